@@ -0,0 +1,181 @@
+package lochness
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+var (
+	// KernelURLConfig is the config key, of the cluster or of a hypervisor, of
+	// the url of the kernel booted. It is a template of the version, e.g.
+	// "http://images/{{.Version}}/vmlinuz".
+	KernelURLConfig = "kernel-url"
+
+	// InitrdURLConfig is the config key, of the cluster or of a hypervisor, of
+	// the url of the initrd booted. It is a template of the version, like
+	// KernelURLConfig.
+	InitrdURLConfig = "initrd-url"
+
+	// KernelArgsConfig is the config key, of the cluster or of a hypervisor, of
+	// the arguments the kernel is booted with, in addition to the uuid
+	KernelArgsConfig = "kernel-args"
+
+	// IPXETemplateConfig is the config key, of the cluster or of a hypervisor,
+	// of the template of the iPXE script booted, which overrides
+	// DefaultIPXETemplate
+	IPXETemplateConfig = "ipxe-template"
+
+	// bootConfigs are the config keys of a Boot. The version booted is the
+	// VersionConfig, which the cluster may also have.
+	bootConfigs = []string{
+		VersionConfig,
+		KernelURLConfig,
+		InitrdURLConfig,
+		KernelArgsConfig,
+		IPXETemplateConfig,
+	}
+)
+
+// DefaultIPXETemplate is the template of the iPXE script a hypervisor boots
+// with, unless overridden. Templates are executed with a Boot and the
+// Hypervisor booting, e.g. {{.KernelURL}} and {{.Hypervisor.ID}}.
+const DefaultIPXETemplate = `#!ipxe
+kernel {{.KernelURL}} uuid={{.Hypervisor.ID}}{{with .KernelArgs}} {{.}}{{end}}
+initrd {{.InitrdURL}}
+boot
+`
+
+// Sources of the template of a Boot
+const (
+	BootSourceDefault    = "default"
+	BootSourceCluster    = "cluster"
+	BootSourceHypervisor = "hypervisor"
+)
+
+// Boot is what a hypervisor network boots: the version of the hypervisor
+// image, where its kernel and initrd are fetched from, the arguments the kernel
+// is booted with, and the template of the iPXE script that boots it.
+type Boot struct {
+	Version    string `json:"version"`
+	KernelURL  string `json:"kernel_url"`
+	InitrdURL  string `json:"initrd_url"`
+	KernelArgs string `json:"kernel_args,omitempty"`
+	Template   string `json:"-"`
+	// TemplateSource is where Template came from, the config of the cluster
+	// or hypervisor, or BootSourceDefault
+	TemplateSource string `json:"template_source"`
+}
+
+// validateBootConfig validates the value of one of the bootConfigs
+func validateBootConfig(key, value string) error {
+	switch key {
+	case KernelURLConfig, InitrdURLConfig, IPXETemplateConfig:
+		if _, err := template.New(key).Parse(value); err != nil {
+			return newValidationError(key, fmt.Sprintf("invalid %s: %s", key, err))
+		}
+	}
+	return nil
+}
+
+// set sets the field of a boot config key, from the config of source
+func (b *Boot) set(key, value, source string) error {
+	if err := validateBootConfig(key, value); err != nil {
+		return err
+	}
+	switch key {
+	case VersionConfig:
+		b.Version = value
+	case KernelURLConfig:
+		b.KernelURL = value
+	case InitrdURLConfig:
+		b.InitrdURL = value
+	case KernelArgsConfig:
+		b.KernelArgs = value
+	case IPXETemplateConfig:
+		b.Template = value
+		b.TemplateSource = source
+	}
+	return nil
+}
+
+// expandURL executes a kernel or initrd url template with the version
+func (b *Boot) expandURL(key, url string) (string, error) {
+	t, err := template.New(key).Parse(url)
+	if err != nil {
+		return "", newValidationError(key, fmt.Sprintf("invalid %s: %s", key, err))
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, struct{ Version string }{b.Version}); err != nil {
+		return "", newValidationError(key, fmt.Sprintf("invalid %s: %s", key, err))
+	}
+	return buf.String(), nil
+}
+
+// Boot returns what the hypervisor network boots, from its config, or the
+// cluster's where it has none, or defaults where neither has a value. The
+// kernel and initrd urls are expanded with the version. Without a template,
+// DefaultIPXETemplate is used.
+func (h *Hypervisor) Boot(defaults Boot) (Boot, error) {
+	b := defaults
+	if b.Template == "" {
+		b.Template = DefaultIPXETemplate
+	}
+	if b.TemplateSource == "" {
+		b.TemplateSource = BootSourceDefault
+	}
+
+	for _, key := range bootConfigs {
+		value, err := h.context.GetConfig(key)
+		if err != nil {
+			if h.context.IsKeyNotFound(err) {
+				continue
+			}
+			return Boot{}, err
+		}
+		if err := b.set(key, value, BootSourceCluster); err != nil {
+			return Boot{}, err
+		}
+	}
+	for _, key := range bootConfigs {
+		if value, ok := h.Config[key]; ok {
+			if err := b.set(key, value, BootSourceHypervisor); err != nil {
+				return Boot{}, err
+			}
+		}
+	}
+
+	if b.Version == "" {
+		return Boot{}, newValidationError(VersionConfig, "missing version")
+	}
+	var err error
+	if b.KernelURL, err = b.expandURL(KernelURLConfig, b.KernelURL); err != nil {
+		return Boot{}, err
+	}
+	if b.InitrdURL, err = b.expandURL(InitrdURLConfig, b.InitrdURL); err != nil {
+		return Boot{}, err
+	}
+	if b.KernelURL == "" || b.InitrdURL == "" {
+		return Boot{}, newValidationError(KernelURLConfig, "missing kernel or initrd url")
+	}
+	return b, nil
+}
+
+// Script executes the template of a Boot for the hypervisor, creating the iPXE
+// script it boots with
+func (b Boot) Script(h *Hypervisor) ([]byte, error) {
+	t, err := template.New(IPXETemplateConfig).Parse(b.Template)
+	if err != nil {
+		return nil, newValidationError(IPXETemplateConfig, fmt.Sprintf("invalid %s: %s", IPXETemplateConfig, err))
+	}
+
+	data := struct {
+		Boot
+		Hypervisor *Hypervisor
+	}{b, h}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return nil, newValidationError(IPXETemplateConfig, fmt.Sprintf("invalid %s: %s", IPXETemplateConfig, err))
+	}
+	return buf.Bytes(), nil
+}
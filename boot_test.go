@@ -0,0 +1,97 @@
+package lochness_test
+
+import (
+	"testing"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestBoot(t *testing.T) {
+	suite.Run(t, new(BootSuite))
+}
+
+type BootSuite struct {
+	common.Suite
+	Defaults lochness.Boot
+}
+
+func (s *BootSuite) SetupTest() {
+	s.Suite.SetupTest()
+	s.Defaults = lochness.Boot{
+		Version:   "0.1.0",
+		KernelURL: "http://images/{{.Version}}/vmlinuz",
+		InitrdURL: "http://images/{{.Version}}/initrd",
+	}
+}
+
+func (s *BootSuite) TestBoot() {
+	hypervisor := s.NewHypervisor()
+
+	boot, err := hypervisor.Boot(s.Defaults)
+	s.Require().NoError(err)
+	s.Equal(lochness.Boot{
+		Version:        "0.1.0",
+		KernelURL:      "http://images/0.1.0/vmlinuz",
+		InitrdURL:      "http://images/0.1.0/initrd",
+		Template:       lochness.DefaultIPXETemplate,
+		TemplateSource: lochness.BootSourceDefault,
+	}, boot)
+
+	s.Require().NoError(s.Context.SetConfig(lochness.VersionConfig, "0.2.0"))
+	s.Require().NoError(s.Context.SetConfig(lochness.KernelArgsConfig, "console=ttyS0"))
+	s.Require().NoError(s.Context.SetConfig(lochness.IPXETemplateConfig, "#!ipxe\nshell\n"))
+	s.Require().NoError(hypervisor.SetConfig(lochness.VersionConfig, "0.3.0"))
+	s.Require().NoError(hypervisor.SetConfig(lochness.InitrdURLConfig, "http://other/{{.Version}}/initrd"))
+
+	boot, err = hypervisor.Boot(s.Defaults)
+	s.Require().NoError(err)
+	s.Equal(lochness.Boot{
+		Version:        "0.3.0",
+		KernelURL:      "http://images/0.3.0/vmlinuz",
+		InitrdURL:      "http://other/0.3.0/initrd",
+		KernelArgs:     "console=ttyS0",
+		Template:       "#!ipxe\nshell\n",
+		TemplateSource: lochness.BootSourceCluster,
+	}, boot, "hypervisor config should override the cluster's, which overrides the defaults")
+
+	s.Require().NoError(hypervisor.SetConfig(lochness.IPXETemplateConfig, "#!ipxe\nexit\n"))
+	boot, err = hypervisor.Boot(s.Defaults)
+	s.Require().NoError(err)
+	s.Equal(lochness.BootSourceHypervisor, boot.TemplateSource)
+
+	_, err = hypervisor.Boot(lochness.Boot{})
+	s.True(lerrors.IsValidation(err), "a version and urls are needed")
+}
+
+func (s *BootSuite) TestValidateConfig() {
+	hypervisor := s.NewHypervisor()
+	s.True(lerrors.IsValidation(s.Context.SetConfig(lochness.KernelURLConfig, "http://images/{{.Version")))
+	s.True(lerrors.IsValidation(hypervisor.SetConfig(lochness.IPXETemplateConfig, "#!ipxe\n{{if}}")))
+	s.True(lerrors.IsValidation(lochness.ValidateHypervisorConfig(lochness.InitrdURLConfig, "{{end}}")))
+}
+
+func (s *BootSuite) TestScript() {
+	hypervisor := s.NewHypervisor()
+	s.Require().NoError(hypervisor.SetConfig(lochness.KernelArgsConfig, "console=ttyS0"))
+	boot, err := hypervisor.Boot(s.Defaults)
+	s.Require().NoError(err)
+
+	script, err := boot.Script(hypervisor)
+	s.NoError(err)
+	s.Equal("#!ipxe\n"+
+		"kernel http://images/0.1.0/vmlinuz uuid="+hypervisor.ID+" console=ttyS0\n"+
+		"initrd http://images/0.1.0/initrd\n"+
+		"boot\n", string(script))
+
+	boot.Template = "#!ipxe\necho {{.Hypervisor.IP}} {{.Version}}\n"
+	script, err = boot.Script(hypervisor)
+	s.NoError(err)
+	s.Equal("#!ipxe\necho 192.168.100.11 0.1.0\n", string(script))
+
+	boot.Template = "{{.Missing}}"
+	_, err = boot.Script(hypervisor)
+	s.True(lerrors.IsValidation(err))
+}
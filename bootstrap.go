@@ -0,0 +1,232 @@
+package lochness
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/pborman/uuid"
+)
+
+var (
+	// DefaultBootstrapTokenTTL is how long bootstrap tokens are valid unless
+	// asked otherwise
+	DefaultBootstrapTokenTTL = 24 * time.Hour
+
+	// ErrInvalidBootstrapToken is returned for bootstrap tokens that are
+	// malformed, not signed with the secret key, expired, or for another node
+	ErrInvalidBootstrapToken = errors.New("invalid bootstrap token")
+
+	// ErrHypervisorMACMismatch is returned when a node registers as a
+	// hypervisor that has a different MAC, or with a token for another MAC
+	ErrHypervisorMACMismatch = lerrors.Conflict(errors.New("the hypervisor has a different mac"))
+)
+
+// bootstrapKeyLabel derives the key bootstrap tokens are signed with from the
+// secret key, so that it is not used for more than one purpose
+const bootstrapKeyLabel = "lochness bootstrap token"
+
+type (
+	// BootstrapToken authorizes freshly netbooted nodes to register
+	// themselves as hypervisors, see RegisterHypervisor. Tokens are signed
+	// with a key derived from the secret key of the Context, see
+	// WithSecretKey, and may be restricted to the node with a MAC.
+	BootstrapToken struct {
+		Expires time.Time `json:"expires"`
+		MAC     string    `json:"mac,omitempty"`
+	}
+
+	// HypervisorRegistration is what a node reports of itself to register as
+	// a hypervisor. The ID is only set by nodes that already know theirs.
+	HypervisorRegistration struct {
+		ID             string    `json:"id,omitempty"`
+		MAC            string    `json:"mac"`
+		IP             net.IP    `json:"ip"`
+		Netmask        net.IP    `json:"netmask"`
+		Gateway        net.IP    `json:"gateway"`
+		TotalResources Resources `json:"total_resources"`
+	}
+)
+
+// bootstrapKey derives the key bootstrap tokens are signed with
+func (c *Context) bootstrapKey() ([]byte, error) {
+	if c.secretKey == nil {
+		return nil, ErrNoSecretKey
+	}
+	mac := hmac.New(sha256.New, c.secretKey)
+	_, _ = mac.Write([]byte(bootstrapKeyLabel))
+	return mac.Sum(nil), nil
+}
+
+// bootstrapSignature signs the encoded claims of a token
+func bootstrapSignature(key []byte, claims string) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(claims))
+	return mac.Sum(nil)
+}
+
+// SignBootstrapToken creates a token for a BootstrapToken, as its encoded
+// claims and signature, "<claims>.<signature>"
+func (c *Context) SignBootstrapToken(t BootstrapToken) (string, error) {
+	if t.MAC != "" {
+		mac, err := net.ParseMAC(t.MAC)
+		if err != nil {
+			return "", newValidationError("mac", "invalid mac")
+		}
+		t.MAC = mac.String()
+	}
+	if t.Expires.IsZero() {
+		return "", newValidationError("expires", "missing expiry")
+	}
+	key, err := c.bootstrapKey()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(t)
+	if err != nil {
+		return "", err
+	}
+	claims := base64.RawURLEncoding.EncodeToString(data)
+	signature := base64.RawURLEncoding.EncodeToString(bootstrapSignature(key, claims))
+	return claims + "." + signature, nil
+}
+
+// VerifyBootstrapToken checks that a token was signed with the secret key, has
+// not expired, and, if it is restricted to a node, that the node has the MAC,
+// returning its BootstrapToken
+func (c *Context) VerifyBootstrapToken(token, mac string) (*BootstrapToken, error) {
+	key, err := c.bootstrapKey()
+	if err != nil {
+		return nil, err
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, ErrInvalidBootstrapToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, bootstrapSignature(key, parts[0])) {
+		return nil, ErrInvalidBootstrapToken
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidBootstrapToken
+	}
+	t := &BootstrapToken{}
+	if err := json.Unmarshal(data, t); err != nil {
+		return nil, ErrInvalidBootstrapToken
+	}
+
+	if time.Now().After(t.Expires) {
+		return nil, ErrInvalidBootstrapToken
+	}
+	if t.MAC != "" {
+		hwaddr, err := net.ParseMAC(mac)
+		if err != nil || hwaddr.String() != t.MAC {
+			return nil, ErrInvalidBootstrapToken
+		}
+	}
+	return t, nil
+}
+
+// Validate ensures a HypervisorRegistration has the MAC, IP, and resources of
+// the node
+func (r *HypervisorRegistration) Validate() error {
+	if r.ID != "" && uuid.Parse(r.ID) == nil {
+		return newValidationError("id", "invalid id")
+	}
+	if _, err := net.ParseMAC(r.MAC); err != nil {
+		return newValidationError("mac", "missing or invalid mac")
+	}
+	if r.IP == nil {
+		return newValidationError("ip", "missing ip")
+	}
+	if r.TotalResources.Memory == 0 || r.TotalResources.Disk == 0 || r.TotalResources.CPU == 0 {
+		return newValidationError("total_resources", "missing resources")
+	}
+	return nil
+}
+
+// RegisterHypervisor creates or updates the hypervisor of a registering node,
+// which is the one with its ID, if it has one, or else its MAC. An existing
+// hypervisor is only updated if it has the node's MAC, and if the node's token
+// is restricted to a MAC, the node must have it, otherwise
+// ErrHypervisorMACMismatch is returned. Its available resources are what
+// remains of the capacity of its total resources once its guests' usage is
+// taken, as with UpdateResources. It returns whether the hypervisor was
+// created.
+func (c *Context) RegisterHypervisor(r *HypervisorRegistration, t *BootstrapToken) (*Hypervisor, bool, error) {
+	if err := r.Validate(); err != nil {
+		return nil, false, err
+	}
+	mac, _ := net.ParseMAC(r.MAC)
+	if t != nil && t.MAC != "" {
+		if tokenMAC, err := net.ParseMAC(t.MAC); err != nil || tokenMAC.String() != mac.String() {
+			return nil, false, ErrHypervisorMACMismatch
+		}
+	}
+
+	var h *Hypervisor
+	var err error
+	id := r.ID
+	if id != "" {
+		if id, err = canonicalizeUUID(id); err != nil {
+			return nil, false, err
+		}
+		h, err = c.Hypervisor(id)
+		if err != nil {
+			if !c.IsKeyNotFound(err) {
+				return nil, false, err
+			}
+			h = nil
+		}
+	} else {
+		h, err = c.FirstHypervisor(func(h *Hypervisor) bool {
+			return h.MAC.String() == mac.String()
+		})
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	created := h == nil
+	if created {
+		h = c.blankHypervisor(id)
+	} else if h.MAC.String() != mac.String() {
+		return nil, false, ErrHypervisorMACMismatch
+	}
+	h.MAC = mac
+	h.IP = r.IP
+	h.Netmask = r.Netmask
+	h.Gateway = r.Gateway
+	if err := h.setTotalResources(r.TotalResources); err != nil {
+		return nil, false, err
+	}
+	if err := h.Save(); err != nil {
+		return nil, false, err
+	}
+	return h, created, nil
+}
+
+// BootstrapConfig is the config of the hypervisor as it is applied, the config
+// of the cluster overlaid with its own
+func (h *Hypervisor) BootstrapConfig() (map[string]string, error) {
+	config := make(map[string]string)
+	if err := h.context.ForEachConfig(func(key, val string) error {
+		config[key] = val
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	for key, val := range h.Config {
+		config[key] = val
+	}
+	return config, nil
+}
@@ -0,0 +1,139 @@
+package lochness_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestBootstrap(t *testing.T) {
+	suite.Run(t, new(BootstrapSuite))
+}
+
+type BootstrapSuite struct {
+	common.Suite
+	SecretContext *lochness.Context
+}
+
+func (s *BootstrapSuite) SetupTest() {
+	s.Suite.SetupTest()
+	var err error
+	s.SecretContext, err = s.Context.WithSecretKey(common.SecretKey)
+	s.Require().NoError(err)
+}
+
+func (s *BootstrapSuite) TestToken() {
+	expires := time.Now().Add(time.Hour)
+	token, err := s.SecretContext.SignBootstrapToken(lochness.BootstrapToken{Expires: expires})
+	s.Require().NoError(err)
+
+	t, err := s.SecretContext.VerifyBootstrapToken(token, "01:23:45:67:89:ab")
+	s.NoError(err)
+	s.True(expires.Equal(t.Expires))
+
+	_, err = s.Context.SignBootstrapToken(lochness.BootstrapToken{Expires: expires})
+	s.Equal(lochness.ErrNoSecretKey, err)
+	_, err = s.Context.VerifyBootstrapToken(token, "")
+	s.Equal(lochness.ErrNoSecretKey, err)
+
+	otherKey := make([]byte, lochness.SecretKeySize)
+	other, err := s.Context.WithSecretKey(otherKey)
+	s.Require().NoError(err)
+
+	expired, err := s.SecretContext.SignBootstrapToken(lochness.BootstrapToken{Expires: time.Now().Add(-time.Second)})
+	s.Require().NoError(err)
+	forMAC, err := s.SecretContext.SignBootstrapToken(lochness.BootstrapToken{Expires: expires, MAC: "01:23:45:67:89:AB"})
+	s.Require().NoError(err)
+
+	tests := []struct {
+		description string
+		ctx         *lochness.Context
+		token       string
+		mac         string
+		expectedErr bool
+	}{
+		{"valid", s.SecretContext, token, "", false},
+		{"other key", other, token, "", true},
+		{"tampered", s.SecretContext, "x" + token, "", true},
+		{"malformed", s.SecretContext, "foobar", "", true},
+		{"expired", s.SecretContext, expired, "", true},
+		{"mac", s.SecretContext, forMAC, "01-23-45-67-89-ab", false},
+		{"other mac", s.SecretContext, forMAC, "01:23:45:67:89:ac", true},
+		{"no mac", s.SecretContext, forMAC, "", true},
+	}
+	for _, test := range tests {
+		_, err := test.ctx.VerifyBootstrapToken(test.token, test.mac)
+		if test.expectedErr {
+			s.Equal(lochness.ErrInvalidBootstrapToken, err, test.description)
+		} else {
+			s.NoError(err, test.description)
+		}
+	}
+}
+
+func (s *BootstrapSuite) TestRegisterHypervisor() {
+	reg := &lochness.HypervisorRegistration{
+		MAC:            "01:23:45:67:89:ab",
+		IP:             net.ParseIP("10.100.101.66"),
+		Netmask:        net.ParseIP("255.255.255.0"),
+		Gateway:        net.ParseIP("10.100.101.1"),
+		TotalResources: lochness.Resources{Memory: 1024, Disk: 2048, CPU: 2},
+	}
+	h, created, err := s.Context.RegisterHypervisor(reg, nil)
+	s.Require().NoError(err)
+	s.True(created)
+	s.Equal(reg.TotalResources, h.TotalResources)
+	s.Equal(reg.TotalResources, h.AvailableResources)
+
+	reg.IP = net.ParseIP("10.100.101.67")
+	again, created, err := s.Context.RegisterHypervisor(reg, nil)
+	s.NoError(err)
+	s.False(created, "nodes should be matched by mac")
+	s.Equal(h.ID, again.ID)
+	s.Equal("10.100.101.67", again.IP.String())
+
+	reg.ID = uuid.New()
+	withID, created, err := s.Context.RegisterHypervisor(reg, nil)
+	s.NoError(err)
+	s.True(created, "nodes should be matched by id if they have one")
+	s.Equal(reg.ID, withID.ID)
+
+	other := *reg
+	other.MAC = "01:23:45:67:89:ac"
+	_, _, err = s.Context.RegisterHypervisor(&other, nil)
+	s.Equal(lochness.ErrHypervisorMACMismatch, err, "a node should not take over a hypervisor with another mac")
+	_, _, err = s.Context.RegisterHypervisor(reg, &lochness.BootstrapToken{MAC: other.MAC})
+	s.Equal(lochness.ErrHypervisorMACMismatch, err, "a node should not register with a token for another mac")
+	again, created, err = s.Context.RegisterHypervisor(reg, &lochness.BootstrapToken{MAC: reg.MAC})
+	s.NoError(err)
+	s.False(created)
+	s.Equal(withID.ID, again.ID)
+
+	invalid := []*lochness.HypervisorRegistration{
+		{ID: "foobar", MAC: reg.MAC, IP: reg.IP, TotalResources: reg.TotalResources},
+		{MAC: "foobar", IP: reg.IP, TotalResources: reg.TotalResources},
+		{MAC: reg.MAC, TotalResources: reg.TotalResources},
+		{MAC: reg.MAC, IP: reg.IP},
+	}
+	for _, r := range invalid {
+		_, _, err := s.Context.RegisterHypervisor(r, nil)
+		s.Error(err)
+	}
+}
+
+func (s *BootstrapSuite) TestBootstrapConfig() {
+	h := s.NewHypervisor()
+	s.Require().NoError(s.Context.SetConfig("foo", "cluster"))
+	s.Require().NoError(s.Context.SetConfig("bar", "cluster"))
+	s.Require().NoError(h.SetConfig("bar", "hypervisor"))
+
+	config, err := h.BootstrapConfig()
+	s.NoError(err)
+	s.Equal("cluster", config["foo"])
+	s.Equal("hypervisor", config["bar"])
+}
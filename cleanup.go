@@ -0,0 +1,101 @@
+package lochness
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/mistifyio/lochness/pkg/deferer"
+)
+
+var (
+	// CleanupPath is the key prefix of the journals of cleanup actions for
+	// changes in progress, see deferer.Journal
+	CleanupPath = "lochness/cleanup/"
+
+	// CleanupTTL is how long a journal outlives a process that stopped
+	// renewing it, after which its changes are rolled back by a janitor
+	CleanupTTL = 30 * time.Second
+)
+
+// cleanup actions journaled by lochness
+const (
+	cleanupReleaseAddress        = "release-address"
+	cleanupRemoveHypervisorGuest = "remove-hypervisor-guest"
+)
+
+// NewJournal creates a journal of cleanup actions for a change in progress
+func (c *Context) NewJournal() (*deferer.Journal, error) {
+	return deferer.NewJournal(c.kv, CleanupPath, c.CleanupHandlers(), CleanupTTL)
+}
+
+// NewJanitor creates a janitor rolling back the changes of the journals
+// abandoned by processes that died
+func (c *Context) NewJanitor() *deferer.Janitor {
+	return deferer.NewJanitor(c.kv, CleanupPath, c.CleanupHandlers())
+}
+
+// CleanupHandlers returns the handlers of the cleanup actions journaled by
+// lochness. A change is only undone if the guest it was made for does not
+// record it, since the process may have died after completing the change
+// but before committing its journal.
+func (c *Context) CleanupHandlers() deferer.Handlers {
+	return deferer.Handlers{
+		cleanupReleaseAddress:        c.cleanupReleaseAddress,
+		cleanupRemoveHypervisorGuest: c.cleanupRemoveHypervisorGuest,
+	}
+}
+
+// journaledGuest returns the guest a journaled change was made for, or nil if
+// it no longer exists
+func (c *Context) journaledGuest(id string) (*Guest, error) {
+	g, err := c.Guest(id)
+	if err != nil {
+		if c.IsKeyNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return g, nil
+}
+
+// cleanupReleaseAddress releases the address reserved in a subnet for a guest
+func (c *Context) cleanupReleaseAddress(params map[string]string) error {
+	g, err := c.journaledGuest(params["guest"])
+	if err != nil {
+		return err
+	}
+	if g != nil && g.SubnetID == params["subnet"] && g.IP.String() == params["ip"] {
+		return nil
+	}
+
+	key := filepath.Join(SubnetPath, params["subnet"], "addresses", params["ip"])
+	value, err := c.kv.Get(key)
+	if err != nil {
+		if c.IsKeyNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	// released and reserved again since
+	if string(value.Data) != params["guest"] {
+		return nil
+	}
+	return c.kv.Remove(key, value.Index)
+}
+
+// cleanupRemoveHypervisorGuest removes a guest from the guests of a hypervisor
+func (c *Context) cleanupRemoveHypervisorGuest(params map[string]string) error {
+	g, err := c.journaledGuest(params["guest"])
+	if err != nil {
+		return err
+	}
+	if g != nil && g.HypervisorID == params["hypervisor"] {
+		return nil
+	}
+
+	err = c.kv.Delete(filepath.Join(HypervisorPath, params["hypervisor"], "guests", params["guest"]), false)
+	if err != nil && !c.IsKeyNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package lochness_test
+
+import (
+	"path"
+	"testing"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestCleanup(t *testing.T) {
+	suite.Run(t, new(CleanupSuite))
+}
+
+type CleanupSuite struct {
+	common.Suite
+	Guest      *lochness.Guest
+	Hypervisor *lochness.Hypervisor
+	Subnet     *lochness.Subnet
+}
+
+func (s *CleanupSuite) SetupTest() {
+	s.Suite.SetupTest()
+
+	s.Guest = s.NewGuest()
+	s.Hypervisor = s.NewHypervisor()
+	s.Subnet = s.NewSubnet()
+	network, err := s.Context.Network(s.Guest.NetworkID)
+	s.Require().NoError(err)
+	s.Require().NoError(network.AddSubnet(s.Subnet))
+	s.Require().NoError(s.Hypervisor.AddSubnet(s.Subnet, "mistify0"))
+}
+
+// placed returns whether the guest has an address reserved and is listed by
+// the hypervisor, as stored in the kv
+func (s *CleanupSuite) placed() (bool, bool) {
+	subnet, err := s.Context.Subnet(s.Subnet.ID)
+	s.Require().NoError(err)
+	hypervisor, err := s.Context.Hypervisor(s.Hypervisor.ID)
+	s.Require().NoError(err)
+	return len(subnet.Addresses()) > 0, len(hypervisor.Guests()) > 0
+}
+
+// journals returns the keys of the cleanup journals left in the kv
+func (s *CleanupSuite) journals() []string {
+	keys, err := s.KV.Keys(lochness.CleanupPath)
+	if err != nil && !s.KV.IsKeyNotFound(err) {
+		s.Require().NoError(err)
+	}
+	return keys
+}
+
+// abandon journals a placement of the guest and leaves it as if the process
+// had died
+func (s *CleanupSuite) abandon(ip string) {
+	journal, err := s.Context.NewJournal()
+	s.Require().NoError(err)
+	s.Require().NoError(journal.Defer("release-address", map[string]string{
+		"subnet": s.Subnet.ID,
+		"ip":     ip,
+		"guest":  s.Guest.ID,
+	}))
+	s.Require().NoError(journal.Defer("remove-hypervisor-guest", map[string]string{
+		"hypervisor": s.Hypervisor.ID,
+		"guest":      s.Guest.ID,
+	}))
+	s.Require().NoError(s.KV.Delete(path.Join(journal.Key(), "alive"), false))
+}
+
+func (s *CleanupSuite) TestAddGuestRollback() {
+	// a concurrent change makes the guest fail to save
+	other, err := s.Context.Guest(s.Guest.ID)
+	s.Require().NoError(err)
+	s.Require().NoError(other.Save())
+
+	s.Error(s.Hypervisor.AddGuest(s.Guest))
+	s.Empty(s.Guest.HypervisorID)
+	s.Nil(s.Guest.IP)
+
+	reserved, listed := s.placed()
+	s.False(reserved, "should release the address")
+	s.False(listed, "should remove the guest from the hypervisor")
+	s.Empty(s.journals())
+}
+
+func (s *CleanupSuite) TestAddGuestCommit() {
+	s.Require().NoError(s.Hypervisor.AddGuest(s.Guest))
+
+	reserved, listed := s.placed()
+	s.True(reserved)
+	s.True(listed)
+	s.Empty(s.journals())
+}
+
+func (s *CleanupSuite) TestJanitor() {
+	// a placement that died half way through
+	ip, err := s.Subnet.ReserveAddress(s.Guest.ID)
+	s.Require().NoError(err)
+	s.Require().NoError(s.KV.Set(path.Join(lochness.HypervisorPath, s.Hypervisor.ID, "guests", s.Guest.ID), s.Guest.ID))
+	s.abandon(ip.String())
+
+	swept, err := s.Context.NewJanitor().Sweep()
+	s.NoError(err)
+	s.Equal(1, swept)
+
+	reserved, listed := s.placed()
+	s.False(reserved, "should release the address")
+	s.False(listed, "should remove the guest from the hypervisor")
+	s.Empty(s.journals())
+}
+
+func (s *CleanupSuite) TestJanitorCompleted() {
+	// a placement that died after completing, before committing
+	s.Require().NoError(s.Hypervisor.AddGuest(s.Guest))
+	s.abandon(s.Guest.IP.String())
+
+	swept, err := s.Context.NewJanitor().Sweep()
+	s.NoError(err)
+	s.Equal(1, swept)
+
+	reserved, listed := s.placed()
+	s.True(reserved, "should keep the address of a placed guest")
+	s.True(listed, "should keep a placed guest on the hypervisor")
+}
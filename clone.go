@@ -0,0 +1,104 @@
+package lochness
+
+import (
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+)
+
+// guestSnapshotKey is the metadata key of the name of a guest's latest
+// snapshot, recorded once the snapshot completes
+const guestSnapshotKey = "snapshot"
+
+// SnapshotName returns the name of a snapshot taken at t. Snapshots are named
+// for when they were taken, so they sort in order.
+func SnapshotName(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// LatestSnapshot returns the name of the guest's latest snapshot, or "" if it
+// has none
+func (g *Guest) LatestSnapshot() string {
+	return g.Metadata[guestSnapshotKey]
+}
+
+// SetLatestSnapshot records the name of the guest's latest snapshot
+func (g *Guest) SetLatestSnapshot(name string) {
+	if g.Metadata == nil {
+		g.Metadata = make(map[string]string)
+	}
+	g.Metadata[guestSnapshotKey] = name
+}
+
+// Clone returns a new, unsaved, guest with the same flavor, image, network,
+// firewall group, vlan group, metadata, and data as g, whose disks are to be
+// cloned from g's, or from g's latest snapshot if fromSnapshot is set. The
+// clone has its own id and MAC, and gets its own address once placed on g's
+// hypervisor.
+func (g *Guest) Clone(fromSnapshot bool) (*Guest, error) {
+	if g.HypervisorID == "" {
+		return nil, lerrors.Conflictf("can not clone guest %s, which is not on a hypervisor", g.ID)
+	}
+	snapshot := ""
+	if fromSnapshot {
+		if snapshot = g.LatestSnapshot(); snapshot == "" {
+			return nil, lerrors.Conflictf("can not clone guest %s from a snapshot, it has none", g.ID)
+		}
+	}
+
+	clone := g.context.NewGuest()
+	for k, v := range g.Metadata {
+		// state and snapshots are the source's own
+		if k == "state" || k == guestSnapshotKey {
+			continue
+		}
+		clone.Metadata[k] = v
+	}
+	clone.Type = g.Type
+	clone.FlavorID = g.FlavorID
+	clone.ImageID = g.ImageID
+	clone.NetworkID = g.NetworkID
+	clone.FWGroupID = g.FWGroupID
+	clone.VLANGroupID = g.VLANGroupID
+	clone.UserData = g.UserData
+	clone.VendorData = g.VendorData
+	clone.DataEncoding = g.DataEncoding
+	clone.CloneOf = g.ID
+	clone.CloneSnapshot = snapshot
+	return clone, nil
+}
+
+// CandidateIsCloneSourceHypervisor returns the Hypervisor of the guest a clone
+// is cloned from, which holds the disks to clone. Guests that are not clones
+// may be placed on any of the Hypervisors.
+func CandidateIsCloneSourceHypervisor(g *Guest, hs Hypervisors) (Hypervisors, error) {
+	if g.CloneOf == "" {
+		return hs, nil
+	}
+
+	logFields := log.Fields{
+		"guestID": g.ID,
+		"func":    "CandidateIsCloneSourceHypervisor",
+	}
+
+	source, err := g.context.Guest(g.CloneOf)
+	if err != nil {
+		return nil, err
+	}
+
+	var hypervisors Hypervisors
+	for _, h := range hs {
+		if h.ID == source.HypervisorID {
+			hypervisors = append(hypervisors, h)
+		}
+	}
+
+	log.WithFields(logFields).WithFields(log.Fields{
+		"in":      len(hs),
+		"out":     len(hypervisors),
+		"removed": len(hs) - len(hypervisors),
+	}).Info("hypervisor candidates filtered")
+
+	return hypervisors, nil
+}
@@ -0,0 +1,96 @@
+package lochness_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestClone(t *testing.T) {
+	suite.Run(t, new(CloneSuite))
+}
+
+type CloneSuite struct {
+	common.Suite
+}
+
+func (s *CloneSuite) TestSnapshotName() {
+	t := time.Date(2016, 3, 7, 9, 12, 44, 5, time.FixedZone("x", 3600))
+	s.Equal("20160307T081244Z", lochness.SnapshotName(t))
+}
+
+func (s *CloneSuite) TestClone() {
+	_, err := s.NewGuest().Clone(false)
+	s.True(errors.Is(err, lerrors.ErrConflict), "guests not on a hypervisor should not be cloned")
+
+	_, source := s.NewHypervisorWithGuest()
+	source.Metadata = map[string]string{"name": "web", "state": lochness.GuestStateRunning}
+	source.UserData = "#cloud-config"
+	s.Require().NoError(source.Save())
+
+	_, err = source.Clone(true)
+	s.True(errors.Is(err, lerrors.ErrConflict), "guests without snapshots should not be cloned from one")
+
+	clone, err := source.Clone(false)
+	s.Require().NoError(err)
+	s.NotEqual(source.ID, clone.ID)
+	s.NotEqual(source.MAC.String(), clone.MAC.String())
+	s.Equal(source.ID, clone.CloneOf)
+	s.Empty(clone.CloneSnapshot)
+	s.Equal(source.FlavorID, clone.FlavorID)
+	s.Equal(source.NetworkID, clone.NetworkID)
+	s.Equal(source.UserData, clone.UserData)
+	s.Equal(map[string]string{"name": "web"}, clone.Metadata)
+	s.Empty(clone.HypervisorID)
+	s.Empty(clone.SubnetID)
+	s.Nil(clone.IP)
+	s.NoError(clone.Save())
+
+	source.SetLatestSnapshot("20160307T081244Z")
+	s.Require().NoError(source.Save())
+	clone, err = source.Clone(true)
+	s.Require().NoError(err)
+	s.Equal("20160307T081244Z", clone.CloneSnapshot)
+	s.NotContains(clone.Metadata, "snapshot")
+}
+
+func (s *CloneSuite) TestValidate() {
+	guest := s.NewGuest()
+	guest.CloneSnapshot = "20160307T081244Z"
+	s.True(lerrors.IsValidation(guest.Validate()))
+
+	guest.CloneOf = "foo"
+	s.True(lerrors.IsValidation(guest.Validate()))
+
+	guest.CloneOf = s.NewGuest().ID
+	s.NoError(guest.Validate())
+}
+
+func (s *CloneSuite) TestCandidateIsCloneSourceHypervisor() {
+	hypervisor, source := s.NewHypervisorWithGuest()
+	hypervisors := lochness.Hypervisors{
+		s.NewHypervisor(),
+		hypervisor,
+	}
+
+	guest := s.NewGuest()
+	candidates, err := lochness.CandidateIsCloneSourceHypervisor(guest, hypervisors)
+	s.NoError(err)
+	s.Len(candidates, 2, "guests that are not clones may go anywhere")
+
+	clone, err := source.Clone(false)
+	s.Require().NoError(err)
+	candidates, err = lochness.CandidateIsCloneSourceHypervisor(clone, hypervisors)
+	s.NoError(err)
+	s.Require().Len(candidates, 1)
+	s.Equal(hypervisor.ID, candidates[0].ID)
+
+	s.Require().NoError(source.Destroy())
+	_, err = lochness.CandidateIsCloneSourceHypervisor(clone, hypervisors)
+	s.Error(err, "clones of deleted guests can not be placed")
+}
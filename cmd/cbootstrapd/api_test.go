@@ -39,6 +39,7 @@ type APISuite struct {
 }
 
 func (s *APISuite) SetupSuite() {
+	s.ExternalKV = true
 	s.Suite.SetupSuite()
 
 	log.SetLevel(log.FatalLevel)
@@ -9,7 +9,9 @@ The following arguments are understood:
     $ cbootstrapd -h
     Usage of cbootstrapd:
     -b, --base="http://ipxe.mistify.local:8888": base address of bits request
+        --config-file="": yaml or toml file of flag settings, overridden by the environment and the command line
     -k, --kv="http://127.0.0.1:4001": address of kv machine
+        --kv-prefix="/lochness": root of the lochness keys in the kv, to share a kv cluster between lochness clusters
     -i, --images="/var/lib/images": directory containing the images
     -o, --options="": additional options to add to boot kernel
     -p, --port=8888: address to listen
@@ -22,7 +22,10 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/justinas/alice"
 	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/config"
+	httpserver "github.com/mistifyio/lochness/internal/server"
 	"github.com/mistifyio/lochness/pkg/kv"
+	_ "github.com/mistifyio/lochness/pkg/kv/bolt"
 	_ "github.com/mistifyio/lochness/pkg/kv/consul"
 	flag "github.com/ogier/pflag"
 )
@@ -36,6 +39,7 @@ type server struct {
 	baseURL        string
 	addOpts        string
 	kvAddr         string
+	kvPrefix       string
 }
 
 const envRegex = "^[_A-Z][_A-Z0-9]*$"
@@ -50,18 +54,29 @@ const configTemplate = `{{range $key, $value := .}}{{ printf "%s=%s\n" $key $val
 func main() {
 	port := flag.UintP("port", "p", 8888, "address to listen")
 	kvAddr := flag.StringP("kv", "k", "http://127.0.0.1:4001", "address of kv machine")
+	kvPrefix := flag.String("kv-prefix", kv.DefaultPrefix, "root of the lochness keys in the kv, to share a kv cluster between lochness clusters")
 	baseURL := flag.StringP("base", "b", "http://ipxe.mistify.local:8888", "base address of bits request")
 	defaultVersion := flag.StringP("version", "v", "0.1.0", "If all else fails, what version to serve")
 	imageDir := flag.StringP("images", "i", "/var/lib/images", "directory containing the images")
 	addOpts := flag.StringP("options", "o", "", "additional options to add to boot kernel")
 	statsd := flag.StringP("statsd", "s", "", "statsd address")
 
+	configFile := flag.String(config.FlagName, "", config.FlagUsage)
 	flag.Parse()
 
+	if err := config.Load(config.Pflag(flag.CommandLine), "cbootstrapd", *configFile); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "config.Load",
+			"file":  *configFile,
+		}).Fatal("failed to load config")
+	}
+
 	KV, err := kv.New(*kvAddr)
 	if err != nil {
 		log.Fatal(err)
 	}
+	KV = kv.WithPrefix(KV, *kvPrefix)
 	c := lochness.NewContext(KV)
 
 	router := mux.NewRouter()
@@ -76,6 +91,7 @@ func main() {
 		baseURL:        *baseURL,
 		addOpts:        *addOpts,
 		kvAddr:         *kvAddr,
+		kvPrefix:       *kvPrefix,
 	}
 
 	chain := alice.New(
@@ -120,12 +136,13 @@ func main() {
 	router.Handle("/ipxe/{ip}", chain.Append(mw.HandlerWrapper("ipxe")).ThenFunc(ipxeHandler))
 	router.Handle("/config/{ip}", chain.Append(mw.HandlerWrapper("config")).ThenFunc(configHandler))
 
-	if err := http.ListenAndServe(fmt.Sprintf(":%d", *port), router); err != nil {
-		log.WithFields(log.Fields{
-			"error": err,
-			"func":  "http.ListenAndServe",
-		}).Fatal("ListenAndServe returned an error")
-	}
+	srv := httpserver.New(fmt.Sprintf(":%d", *port), router, nil)
+	srv.Start()
+
+	// Serve until SIGINT or SIGTERM, then drain in-flight requests
+	sigCtx, cancel := httpserver.SignalContext()
+	defer cancel()
+	httpserver.Wait(sigCtx, srv)
 }
 
 func ipxeHandler(w http.ResponseWriter, r *http.Request) {
@@ -179,6 +196,7 @@ func configHandler(w http.ResponseWriter, r *http.Request) {
 
 	configs := map[string]string{
 		"ETCD_ADDRESS": s.kvAddr,
+		"KV_PREFIX":    s.kvPrefix,
 	}
 	err := s.ctx.ForEachConfig(func(key, val string) error {
 		if s.r.MatchString(key) {
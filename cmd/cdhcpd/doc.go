@@ -1,5 +1,5 @@
 /*
-cdhcpd is a service to monitor a kv for changes to hyperviors and guests and rebuild the DHCP config files as needed.
+cdhcpd is a service to monitor a kv for changes to hyperviors and guests and rebuild the DHCP config files as needed, or serve DHCP itself.
 
 Usage
 
@@ -8,9 +8,94 @@ The following arguments are accepted:
 	$ cdhcpd -h
 	Usage of cdhcpd:
 	  -c, --conf-dir="/etc/dhcp/": dhcpd configuration directory
+	  -f, --config="": optional config file overriding domain and template paths
+	      --config-file="": yaml or toml file of flag settings, overridden by the environment and the command line
+	      --dns-servers="": comma separated dns servers given to the hosts with --serve
 	  -d, --domain="": domain for lochness; required
+	      --guests-template="": path to a template for guests.conf
+	  -p, --http=7545: http port to publish metrics. set to 0 to disable
+	      --hypervisors-template="": path to a template for hypervisors.conf
 	  -k, --kv="http://127.0.0.1:4001": address of kv server
+	      --kv-prefix="/lochness": root of the lochness keys in the kv, to share a kv cluster between lochness clusters
+	      --lease-time=12h0m0s: how long the leases given with --serve last
 	  -l, --log-level="warning": log level: debug/info/warning/error/critical/fatal
+	      --log-max-backups=5: number of rotated log files kept
+	      --log-max-size=100: megabytes a log file grows to before it is rotated, 0 to never rotate it
+	      --log-output="stdout": where to log: stdout, stderr, syslog, journald, or the path of a file
+	      --serve="": address to answer dhcp requests on, e.g. :67, instead of writing dhcpd configs; disabled if empty
+	      --server-ip="": ip the dhcp server identifies itself with; required with --serve unless it is on a specific ip
+	  -z, --zone-dir="": directory to write dns zone files to; disabled if empty
+	      --zone-reload-cmd="": command run with the zone name after a zone file changes, e.g. "rndc reload"
+
+Change Logging
+
+Whenever a config file is replaced, a unified diff against the previous file is
+logged at info level along with the ids of the hosts added, removed and
+modified. Counts of those hosts are also kept as the metrics
+cdhcpd.hosts.<type>.added, cdhcpd.hosts.<type>.removed and
+cdhcpd.hosts.<type>.modified, where type is hypervisors or guests, and served
+as JSON from /metrics on the --http port. The configs written at startup are
+the baseline and are not counted. With --serve, the same changes to the hosts
+of the embedded server are logged and counted, without a diff.
+
+Zone Files
+
+When --zone-dir is set, BIND zone files are written there alongside the dhcpd
+configs. The forward zones nodes.<domain> and guests.<domain> hold an A record
+for each hypervisor and for each guest in guests.conf, and reverse zones are
+written for the IPv4 networks they use, per the netmask of each hypervisor and
+the CIDR of the subnet of each guest. Reverse zones are on octet boundaries: a
+/16 gets one zone, while a /20 gets one per /24 in use, as does a hypervisor
+without a netmask. Each file is named after its zone with a .zone suffix, e.g.
+nodes.example.com.zone and 1.168.192.in-addr.arpa.zone.
+
+Guests with a DNS name, e.g. web1.example.com, also get an A record in the zone
+of its domain, example.com.zone, which is written for each domain the names are
+in, and their reverse records point to the name rather than to guests.<domain>.
+
+The SOA serial of a zone is bumped when cdhcpd starts and afterwards only when
+the zone's records change. Serials are the unix time of the change, or one more
+than the serial already on disk if that is larger. If --zone-reload-cmd is given, it is run with the zone name as
+its last argument after each changed zone is written. Zone files cdhcpd wrote
+for zones that no longer have records, e.g. for a subnet whose guests are all
+gone, are removed, and the reload command run for them as well:
+
+	$ cdhcpd -d example.com -z /var/named/lochness --zone-reload-cmd="rndc reload"
+
+Embedded Server
+
+With --serve, cdhcpd answers DHCP requests itself instead of writing dhcpd
+configs, so that dhcpd is not needed at all. Requests are answered from the
+hypervisors, guests and subnets kept in memory, as soon as a change is applied
+to them, rather than after a config is rewritten and dhcpd restarted, during
+which requests would go unanswered. The hosts and what they are told are those
+of hypervisors.conf and guests.conf: each known MAC is offered its fixed
+address, netmask, gateway and domain name, and hypervisors are network booted
+from BootFile on tftp.services.<domain>, or, once running iPXE, from their iPXE
+script. The --hypervisors-template and --guests-template do not apply. Requests
+of unknown MACs are ignored, so another server may answer them, and requests
+for another address than a host's are refused.
+
+The server identifies itself with the IP it listens on, or --server-ip, which
+is required when listening on all addresses. Leases last --lease-time, and
+--dns-servers are given to every host:
+
+	$ cdhcpd -d example.com --serve :67 --server-ip 192.168.1.2 --dns-servers 192.168.1.2,192.168.1.3
+
+Reloading
+
+The domain and template paths may also be given in a JSON config file, whose
+values take precedence over the command line:
+
+	{
+		"domain": "example.com",
+		"hypervisors_template": "/etc/cdhcpd/hypervisors.conf.tmpl",
+		"guests_template": "/etc/cdhcpd/guests.conf.tmpl"
+	}
+
+Sending cdhcpd a SIGHUP rereads the config file and templates and re-renders the
+dhcpd configs. Any event being processed finishes first. If the new settings
+cannot be loaded, an error is logged and the previous settings stay in use.
 
 Watched
 
@@ -19,5 +104,15 @@ The following prefixes are watched for changes:
 	/lochness/hypervisors
 	/lochness/guests
 	/lochness/subnets
+
+The hypervisors, guests and subnets are fetched once at startup and kept in
+memory. Each change is applied to them, and a config is only re-rendered when
+the change affects a host in it, e.g. a guest's state changing does not touch
+guests.conf. A config file is only replaced, and dhcpd restarted, when the
+rendered output differs from what was last written. Changes carry the whole
+element, so one that follows a missed change, e.g. whose previous value does
+not match what is in memory, is applied all the same, as are deletes of an
+element's whole directory. Everything is only refetched for keys that cannot be
+parsed or values that cannot be unmarshaled.
 */
 package main
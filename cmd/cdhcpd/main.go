@@ -1,288 +1,139 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
-	"crypto/md5"
-	"io"
+	"encoding/json"
+	"fmt"
+	"net/http"
 	"os"
-	"os/exec"
 	"os/signal"
-	"path"
 	"syscall"
 
 	log "github.com/Sirupsen/logrus"
-	"github.com/mistifyio/lochness/pkg/watcher"
-	logx "github.com/mistifyio/mistify-logrus-ext"
+	"github.com/armon/go-metrics"
+	"github.com/bakins/go-metrics-map"
+	"github.com/mistifyio/lochness/internal/config"
+	"github.com/mistifyio/lochness/internal/dhcp"
+	"github.com/mistifyio/lochness/internal/logging"
+	"github.com/mistifyio/lochness/pkg/kv"
+	_ "github.com/mistifyio/lochness/pkg/kv/bolt"
+	_ "github.com/mistifyio/lochness/pkg/kv/consul"
 	flag "github.com/spf13/pflag"
 )
 
-var hypervisorsHash []byte
-var guestsHash []byte
+// setupMetrics creates the metric sink and starts an optional http server
+func setupMetrics(port uint) *metrics.Metrics {
+	ms := mapsink.New()
+	conf := metrics.DefaultConfig("cdhcpd")
+	conf.EnableHostname = false
+	m, _ := metrics.New(conf, ms)
 
-func updateConfigs(f *Fetcher, r *Refresher, hconfPath, gconfPath string) (bool, error) {
-	restart := false
+	// Unless told not to, expose metrics via http
+	if port != 0 {
+		http.Handle("/metrics", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(ms)
+		}))
 
-	// Hypervisors
-	hypervisors, err := f.Hypervisors()
-	if err != nil {
-		log.WithFields(log.Fields{
-			"error": err,
-			"func":  "fetcher.Hypervisors",
-		}).Error("could not fetch hypervisors")
-		return restart, err
-	}
-
-	checksum, err := writeConfig("hypervisors", hconfPath, hypervisorsHash, func(w io.Writer) error {
-		err := r.genHypervisorsConf(w, hypervisors)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"error": err,
-				"func":  "Refresher.genHypervisorsConf",
-				"type":  "hypervisors",
-			}).Error("could not generate configuration")
-		}
-		return err
-	})
-	if err == nil && checksum != nil {
-		hypervisorsHash = checksum
-		restart = true
-	}
-
-	// Guests
-	guests, err := f.Guests()
-	if err != nil {
-		log.WithFields(log.Fields{
-			"error": err,
-			"func":  "fetcher.Guests",
-		}).Error("could not fetch guests")
-		return restart, err
-	}
-	subnets, err := f.Subnets()
-	if err != nil {
-		log.WithFields(log.Fields{
-			"error": err,
-			"func":  "fetcher.Subnets",
-		}).Error("could not fetch subnets")
-		return restart, err
-	}
-
-	checksum, err = writeConfig("guests", gconfPath, guestsHash, func(w io.Writer) error {
-		err := r.genGuestsConf(w, guests, subnets)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"error": err,
-				"func":  "Refresher.genGuestsConf",
-				"type":  "guests",
-			}).Error("could not generate configuration")
-		}
-		return err
-	})
-	if err == nil && checksum != nil {
-		guestsHash = checksum
-		restart = true
+		go func() {
+			log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), nil))
+		}()
 	}
 
-	return restart, nil
-}
-
-func writeConfig(confType, path string, checksum []byte, generator func(io.Writer) error) ([]byte, error) {
-	tmp := path + ".tmp"
-	file, err := os.Create(tmp)
-	if err != nil {
-		log.WithFields(log.Fields{
-			"error": err,
-			"func":  "os.Create",
-			"path":  tmp,
-			"type":  confType,
-		}).Error("Could not create temporary conf file")
-		return nil, err
-	}
-
-	hash := md5.New()
-	buff := bufio.NewWriter(io.MultiWriter(file, hash))
-	if err = generator(buff); err != nil {
-		log.WithFields(log.Fields{
-			"error": err,
-			"path":  tmp,
-			"type":  confType,
-		}).Error("could not generate temporary configuration")
-		return nil, err
-	}
-
-	if err = buff.Flush(); err != nil {
-		log.WithFields(log.Fields{
-			"error": err,
-			"func":  "buff.Flush",
-			"path":  tmp,
-			"type":  confType,
-		}).Error("could not flush buffer to temporary conf file")
-		return nil, err
-	}
-
-	if err = file.Close(); err != nil {
-		log.WithFields(log.Fields{
-			"error": err,
-			"func":  "os.File.Close",
-			"path":  tmp,
-			"type":  confType,
-		}).Error("could not close temporary conf file")
-		return nil, err
-	}
-
-	if bytes.Equal(checksum, hash.Sum(nil)) {
-		log.Debug("no change to conf file")
-		if err := os.Remove(tmp); err != nil {
-			log.WithFields(log.Fields{
-				"error":    err,
-				"filepath": tmp,
-			}).Error("failed to remove temp file")
-		}
-		return nil, nil
-	}
-
-	if err = os.Rename(tmp, path); err != nil {
-		log.WithFields(log.Fields{
-			"error": err,
-			"func":  "os.Rename",
-			"from":  tmp,
-			"to":    path,
-			"type":  confType,
-		}).Error("could not rename temporary conf file")
-		return nil, err
-	}
-
-	log.WithFields(log.Fields{
-		"path": path,
-		"type": confType,
-	}).Info("replaced conf file")
-
-	return hash.Sum(nil), nil
-}
-
-func restartDhcpd() {
-	cmd := exec.Command("systemctl", "restart", "dhcpd.service")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		log.WithFields(log.Fields{
-			"error": err,
-			"func":  "cmd.Run",
-		}).Error("failed to restart dhcpd service")
-	}
+	return m
 }
 
 func main() {
 
 	// Command line options
-	var kvAddress, domain, confPath, logLevel string
-	flag.StringVarP(&domain, "domain", "d", "", "domain for lochness; required")
+	var kvAddress, kvPrefix, logLevel string
+	var port uint
+	var cfg dhcp.Config
+	flag.StringVarP(&cfg.Settings.Domain, "domain", "d", "", "domain for lochness; required")
 	flag.StringVarP(&kvAddress, "kv", "k", "http://127.0.0.1:4001", "address of kv server")
-	flag.StringVarP(&confPath, "conf-dir", "c", "/etc/dhcp/", "dhcpd configuration directory")
+	flag.StringVar(&kvPrefix, "kv-prefix", kv.DefaultPrefix, "root of the lochness keys in the kv, to share a kv cluster between lochness clusters")
+	flag.StringVarP(&cfg.ConfDir, "conf-dir", "c", "/etc/dhcp/", "dhcpd configuration directory")
+	flag.StringVarP(&cfg.SettingsFile, "config", "f", "", "optional config file overriding domain and template paths")
+	flag.StringVarP(&cfg.Settings.HypervisorsTemplate, "hypervisors-template", "", "", "path to a template for hypervisors.conf")
+	flag.StringVarP(&cfg.Settings.GuestsTemplate, "guests-template", "", "", "path to a template for guests.conf")
+	var logCfg logging.Config
 	flag.StringVarP(&logLevel, "log-level", "l", "warning", "log level: debug/info/warning/error/critical/fatal")
+	logging.AddFlags(flag.CommandLine, &logCfg)
+	flag.UintVarP(&port, "http", "p", 7545, "http port to publish metrics. set to 0 to disable")
+	flag.StringVarP(&cfg.ZoneDir, "zone-dir", "z", "", "directory to write dns zone files to; disabled if empty")
+	flag.StringVarP(&cfg.ZoneReloadCmd, "zone-reload-cmd", "", "", "command run with the zone name after a zone file changes, e.g. \"rndc reload\"")
+	flag.StringVarP(&cfg.Listen, "serve", "", "", "address to answer dhcp requests on, e.g. :67, instead of writing dhcpd configs; disabled if empty")
+	flag.StringVarP(&cfg.ServerIP, "server-ip", "", "", "ip the dhcp server identifies itself with; required with --serve unless it is on a specific ip")
+	flag.DurationVarP(&cfg.LeaseTime, "lease-time", "", dhcp.DefaultLeaseTime, "how long the leases given with --serve last")
+	flag.StringVarP(&cfg.DNSServers, "dns-servers", "", "", "comma separated dns servers given to the hosts with --serve")
+	configFile := flag.String(config.FlagName, "", config.FlagUsage)
 	flag.Parse()
 
+	if err := config.Load(config.SPFlag(flag.CommandLine), "cdhcpd", *configFile); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "config.Load",
+			"file":  *configFile,
+		}).Fatal("failed to load config")
+	}
+
 	// Domain is required
-	if domain == "" {
+	if cfg.Settings.Domain == "" && cfg.SettingsFile == "" {
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
 	// Logging
-	if err := logx.DefaultSetup(logLevel); err != nil {
+	if err := logging.Setup(logLevel, "cdhcpd", logCfg); err != nil {
 		log.WithFields(log.Fields{
 			"error": err,
-			"func":  "logx.DefaultSetup",
+			"func":  "logging.Setup",
 		}).Fatal("could not set up logrus")
 	}
 
-	hconfPath := path.Join(confPath, "hypervisors.conf")
-	gconfPath := path.Join(confPath, "guests.conf")
-
-	// Set up fetcher and refresher
-	f := NewFetcher(kvAddress)
-	r := NewRefresher(domain)
-	err := f.FetchAll()
+	KV, err := kv.New(kvAddress)
 	if err != nil {
-		os.Exit(1)
+		log.WithFields(log.Fields{
+			"addr":  kvAddress,
+			"error": err,
+			"func":  "kv.New",
+		}).Fatal("unable to connect to kv")
 	}
+	KV = kv.WithPrefix(KV, kvPrefix)
 
-	// Update at the start of each run
-	restart, err := updateConfigs(f, r, hconfPath, gconfPath)
-	if restart {
-		restartDhcpd()
-	}
-	if err != nil {
-		os.Exit(1)
-	}
+	// Set up metrics
+	m := setupMetrics(port)
 
-	// Create the watcher
-	w, err := watcher.New(f.kv)
+	// Set up the dhcp service
+	d, err := dhcp.New(KV, cfg, m)
 	if err != nil {
 		log.WithFields(log.Fields{
-			"error": err,
-			"func":  "watcher.New",
-		}).Fatal("could not create watcher")
+			"error":      err,
+			"func":       "dhcp.New",
+			"configPath": cfg.SettingsFile,
+		}).Fatal("could not load settings")
 	}
-
-	// Start watching the necessary kv prefixes
-	prefixes := []string{"/lochness/hypervisors", "/lochness/guests", "/lochness/subnets"}
-	for _, prefix := range prefixes {
-		if err := w.Add(prefix); err != nil {
-			log.WithFields(log.Fields{
-				"error":  err,
-				"func":   "watcher.Add",
-				"prefix": prefix,
-			}).Fatal("could not add watch prefix")
-		}
+	if err := d.Start(); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "dhcp.Service.Start",
+		}).Fatal("could not start")
 	}
 
-	// Channel for indicating work in progress
-	// (to coordinate clean exiting between the consumer and the signal handler)
-	ready := make(chan struct{}, 1)
-	ready <- struct{}{}
+	// Handle signals for settings reloads and clean shutdown
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 
-	for w.Next() {
-		// Remove item to indicate processing has begun
-		done := <-ready
-
-		// Integrate the response and update the configs if necessary
-		refresh, err := f.IntegrateResponse(w.Event())
-		if err != nil {
-			log.Info("error on integration; re-fetching")
-			err := f.FetchAll()
-			if err != nil {
-				os.Exit(1)
-			}
-			refresh = true
-		}
-		if refresh {
-			restart, err := updateConfigs(f, r, hconfPath, gconfPath)
-			if restart {
-				restartDhcpd()
-			}
-			if err != nil {
-				log.WithFields(log.Fields{
-					"error": err,
-					"func":  "updateConfigs",
-				}).Warn("could not create watcher")
-			}
+	for s := range sigs {
+		if s != syscall.SIGHUP {
+			log.WithField("signal", s).Info("signal received; waiting for current task to process")
+			break
 		}
 
-		// Return item to indicate processing has completed
-		ready <- done
+		log.WithField("signal", s).Info("signal received; reloading settings")
+		_ = d.Reload()
 	}
-	if err := w.Err(); err != nil {
-		log.WithField("error", err).Fatal("watcher encountered an error")
-	}
-
-	// Handle signals for clean shutdown
-	sigs := make(chan os.Signal)
-	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
 
-	s := <-sigs
-	log.WithField("signal", s).Info("signal received; waiting for current task to process")
-	<-ready // wait until any current processing is finished
-	_ = w.Close()
+	d.Stop()
 	log.Info("exiting")
 }
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	"github.com/mistifyio/lochness/internal/server"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestCEventdAPI(t *testing.T) {
+	suite.Run(t, new(APISuite))
+}
+
+type APISuite struct {
+	common.Suite
+	Port      uint
+	APIServer *server.Server
+	Webhook   *lochness.Webhook
+	APIURL    string
+}
+
+func (s *APISuite) SetupSuite() {
+	s.Suite.SetupSuite()
+
+	log.SetLevel(log.FatalLevel)
+	s.Port = 51133
+	s.APIURL = fmt.Sprintf("http://localhost:%d/webhooks", s.Port)
+
+	s.APIServer = Run(s.Port, s.Context, httpmw.Config{})
+	time.Sleep(100 * time.Millisecond)
+}
+
+func (s *APISuite) SetupTest() {
+	s.Suite.SetupTest()
+	s.Webhook = s.Context.NewWebhook()
+	s.Webhook.URL = "https://hooks.example.com/lochness"
+	s.Webhook.Secret = "s3cret"
+	s.Require().NoError(s.Webhook.Save())
+}
+
+func (s *APISuite) TearDownSuite() {
+	stopChan := s.APIServer.StopChan()
+	s.APIServer.Stop(5 * time.Second)
+	<-stopChan
+
+	s.Suite.TearDownSuite()
+}
+
+func (s *APISuite) TestWebhookList() {
+	s.NewWebhook()
+
+	var webhooks lochness.Webhooks
+	s.DoRequest("GET", s.APIURL, http.StatusOK, nil, &webhooks)
+	s.Require().Len(webhooks, 2)
+	for _, webhook := range webhooks {
+		s.Empty(webhook.Secret, "secrets should not be returned")
+	}
+}
+
+func (s *APISuite) TestWebhookAdd() {
+	tests := []struct {
+		description  string
+		url          string
+		events       []string
+		expectedCode int
+	}{
+		{"valid", "https://hooks.slack.com/services/T0/B0/X", []string{"job.failed", "hypervisor.*"}, http.StatusCreated},
+		{"invalid url", "hooks.slack.com", nil, http.StatusBadRequest},
+		{"invalid pattern", "https://hooks.slack.com/services/T0/B0/X", []string{"["}, http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		webhook := s.Context.NewWebhook()
+		webhook.URL = test.url
+		webhook.Events = test.events
+		webhook.Secret = "s3cret"
+
+		var webhookResp lochness.Webhook
+		s.DoRequest("POST", s.APIURL, test.expectedCode, webhook, &webhookResp)
+		if test.expectedCode != http.StatusCreated {
+			continue
+		}
+		s.Equal(webhook.ID, webhookResp.ID, msg("should return the webhook"))
+		s.Empty(webhookResp.Secret, msg("should not return the secret"))
+
+		// Make sure it actually saved
+		w, err := s.Context.Webhook(webhook.ID)
+		s.NoError(err, msg("should be saved"))
+		s.Equal(test.events, w.Events, msg("should be saved"))
+		s.Equal("s3cret", w.Secret, msg("should be saved"))
+	}
+}
+
+func (s *APISuite) TestWebhookGet() {
+	var webhook lochness.Webhook
+	s.DoRequest("GET", fmt.Sprintf("%s/%s", s.APIURL, s.Webhook.ID), http.StatusOK, nil, &webhook)
+	s.Equal(s.Webhook.ID, webhook.ID)
+	s.Empty(webhook.Secret)
+
+	var errResp map[string]interface{}
+	s.DoRequest("GET", fmt.Sprintf("%s/%s", s.APIURL, uuid.New()), http.StatusNotFound, nil, &errResp)
+	s.Equal("webhook_not_found", errResp["error"])
+	s.DoRequest("GET", fmt.Sprintf("%s/%s", s.APIURL, "asdf"), http.StatusBadRequest, nil, &errResp)
+	s.Equal("invalid_webhook_id", errResp["error"])
+}
+
+func (s *APISuite) TestWebhookUpdate() {
+	update := map[string]interface{}{
+		"id":       uuid.New(),
+		"events":   []string{"guest.*"},
+		"disabled": true,
+	}
+
+	var webhookResp lochness.Webhook
+	s.DoRequest("PATCH", fmt.Sprintf("%s/%s", s.APIURL, s.Webhook.ID), http.StatusOK, update, &webhookResp)
+	s.Equal(s.Webhook.ID, webhookResp.ID, "id should not change")
+
+	// Make sure it actually saved
+	w, err := s.Context.Webhook(s.Webhook.ID)
+	s.NoError(err)
+	s.Equal([]string{"guest.*"}, w.Events)
+	s.True(w.Disabled)
+	s.Equal("s3cret", w.Secret, "secret should be kept")
+}
+
+func (s *APISuite) TestWebhookDestroy() {
+	var webhookResp lochness.Webhook
+	s.DoRequest("DELETE", fmt.Sprintf("%s/%s", s.APIURL, s.Webhook.ID), http.StatusOK, nil, &webhookResp)
+	s.Equal(s.Webhook.ID, webhookResp.ID)
+
+	// Make sure it actually deleted
+	_, err := s.Context.Webhook(s.Webhook.ID)
+	s.Error(err)
+}
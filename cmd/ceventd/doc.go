@@ -0,0 +1,171 @@
+/*
+ceventd publishes changes to lochness entities as events to a NATS or AMQP
+broker, so that external systems, e.g. for billing or a CMDB, can follow them
+without watching the kv. It also posts events to webhooks, managed over an HTTP
+API with JSON formatting.
+
+Usage
+
+The following arguments are understood:
+
+	$ ceventd -h
+	Usage of ceventd:
+	-b, --broker="nats://127.0.0.1:4222": address of the NATS (nats://) or AMQP (amqp://) broker, empty to only deliver webhooks
+	    --config-file="": yaml or toml file of flag settings, overridden by the environment and the command line
+	-k, --kv="http://127.0.0.1:4001": address of kv server
+	    --kv-prefix="/lochness": root of the lochness keys in the kv, to share a kv cluster between lochness clusters
+	    --kv-retries=2: number of times to retry failed kv operations, other than atomic updates
+	    --kv-timeout=5s: timeout of kv operations, after which requests fail with 503, 0 to disable
+	-l, --log-level="warn": log level
+	    --log-max-backups=5: number of rotated log files kept
+	    --log-max-size=100: megabytes a log file grows to before it is rotated, 0 to never rotate it
+	    --log-output="stdout": where to log: stdout, stderr, syslog, journald, or the path of a file
+	    --otlp-endpoint="": OTLP/HTTP collector (host:port) to send request traces to
+	-p, --port=15000: listen port
+	-r, --retries=5: number of times to retry publishing an event before dropping it
+	    --retry-wait=1s: how long to wait between attempts to publish an event
+	    --slow-request=1s: latency above which requests are logged as slow, 0 to disable
+	-t, --topic="lochness": prefix of the NATS subjects, or the AMQP exchange, events are published to
+	    --webhook-retries=5: number of times to retry a webhook delivery, waiting twice as long each time
+	    --webhook-timeout=10s: timeout of a webhook request
+	-w, --webhook-workers=4: number of webhook deliveries to make at the same time
+
+Events
+
+	guest.created, guest.updated, guest.deleted
+	hypervisor.created, hypervisor.updated, hypervisor.deleted
+		A guest or hypervisor was added, changed or removed. Data is the
+		entity after the change.
+	hypervisor.up, hypervisor.down
+		A hypervisor's heartbeat appeared or expired. A hypervisor is
+		reported up the first time ceventd sees its heartbeat.
+	job.done, job.failed
+		A job finished or failed. Data is the job.
+
+With NATS, events are published to the subject of their type under the topic,
+e.g. "lochness.guest.created", so "lochness.guest.*" or "lochness.>" can be
+subscribed to. With AMQP, events are published as persistent messages to a
+durable topic exchange named for the topic, with their type as routing key.
+
+Every event has the same JSON encoding, described by events.Event. The version
+only changes when fields are removed or change meaning.
+
+	{
+		"version": 1,
+		"id": "0b2f6a8e-3c1d-4f5e-9a7b-1c2d3e4f5a6b",
+		"type": "guest.created",
+		"entity": "guest",
+		"subject": "f2011319-ad59-42fb-9bad-92e261f0651c",
+		"time": "2016-03-07T09:12:44.123456Z",
+		"data": {"id":"f2011319-ad59-42fb-9bad-92e261f0651c","metadata":{},"type":"","flavor":"0c9a2e9f-0bd3-4f8b-9fd3-a9c3c5e3d3f5","hypervisor":"","network":"8e7b3d4c-47d6-4d1f-a3c7-0bb0fc7cf4a7","subnet":"","fwgroup":"","vlangroup":"","mac":"02:f2:01:13:19:ad","ip":null,"bridge":""}
+	}
+
+Events are published as changes are seen, at most once. Changes made while
+ceventd is not running, and events that cannot be published after --retries
+attempts, are not published. Run a single instance per cluster, or subscribers
+will see every event once per instance.
+
+Webhooks
+
+Every enabled webhook whose event patterns match an event's type, e.g.
+"job.failed" or "hypervisor.*", is sent the event in a POST request. A webhook
+without patterns is sent every event. The json format posts the event itself,
+and the slack format posts a message for a Slack incoming webhook:
+
+	{"text":"lochness hypervisor e88a75a6-7ae6-487c-9634-6553d3793437: hypervisor.down"}
+
+Requests have the following headers:
+
+	X-Lochness-Event: the event type, e.g. "job.failed"
+	X-Lochness-Delivery: the event id
+	X-Lochness-Signature: "sha256=" followed by the hex HMAC-SHA256 of the
+	body keyed with the webhook's secret, if it has one
+
+Deliveries are made in the background by --webhook-workers workers. A delivery
+that fails, or gets a response other than 2xx, is retried --webhook-retries
+times, waiting --retry-wait and then twice as long each time, before it is
+dropped.
+
+HTTP API endpoints
+
+	/webhooks
+		* GET - Retrieve a list of webhooks
+		* POST - Add a new webhook
+
+	/webhooks/{webhookID}
+		* GET - Retrieve information about a webhook
+		* PATCH - Update a webhook's information
+		* DELETE - Remove a webhook
+
+Secrets are never returned. A PATCH without a secret keeps the current one.
+
+Versioning
+
+The endpoints are served under /v1, e.g. /v1/webhooks, and every response has an
+X-API-Version header listing the versions served. The unversioned paths above
+are deprecated aliases kept for existing tooling, whose responses have a
+Deprecation header, a Warning, and a Link to the versioned path. The cli tools
+use /v1 with servers that have it.
+
+Request Logging
+
+Every request is logged with its method, path, status, latency, response size,
+and request id. Requests slower than --slow-request are logged as warnings and
+tagged "slow". With --otlp-endpoint, a span is also emitted for every request
+through OpenTelemetry, continuing any W3C trace context sent by the client.
+
+Content Types
+
+Responses are JSON unless the Accept header prefers YAML, application/x-yaml,
+or msgpack, application/msgpack, which have the same fields. Request bodies may
+be any of the three, named by their Content-Type header, and are taken as JSON
+without one. Bodies of other types are rejected like malformed ones, with
+"invalid_json".
+
+Errors
+
+Every response has an X-Request-ID header, taken from the request if the client
+sent one and generated otherwise. Error responses carry a machine readable error
+code, e.g. "webhook_not_found" or "invalid_json", and the request id, which is
+also logged with the error. Objects failing validation are rejected with
+"validation_failed" and the names of the invalid fields. Requests whose kv
+operations time out, see --kv-timeout, fail with 503 and "kv_timeout".
+
+	{"message":"webhook not found","error":"webhook_not_found","request_id":"0b2f6a8e-3c1d-4f5e-9a7b-1c2d3e4f5a6b"}
+
+Example Structs
+
+Webhook - lochness.Webhook
+
+	{
+		"id": "3f9b5a2c-6d1e-4c8f-b0a7-2e4d6f8a1c3b",
+		"url": "https://hooks.slack.com/services/T0/B0/X",
+		"events": ["job.failed", "hypervisor.down"],
+		"format": "slack",
+		"disabled": false,
+		"metadata": {}
+	}
+
+Example Requests
+
+GET /webhooks
+
+	$ curl http://localhost:15000/webhooks
+	[{"id":"3f9b5a2c-6d1e-4c8f-b0a7-2e4d6f8a1c3b","url":"https://hooks.slack.com/services/T0/B0/X","events":["job.failed","hypervisor.down"],"format":"slack","disabled":false,"metadata":{}}]
+
+POST /webhooks
+
+	$ curl -X POST http://localhost:15000/webhooks --data-binary '{"url":"https://billing.example.com/lochness","events":["guest.*"],"secret":"s3cret"}'
+	{"id":"7c1e9d3a-2b4f-4e6a-8d0c-5f3a1b7e9c2d","url":"https://billing.example.com/lochness","events":["guest.*"],"format":"json","disabled":false,"metadata":{}}
+
+PATCH /webhooks/{webhookID}
+
+	$ curl -X PATCH http://localhost:15000/webhooks/3f9b5a2c-6d1e-4c8f-b0a7-2e4d6f8a1c3b --data-binary '{"disabled":true}'
+	{"id":"3f9b5a2c-6d1e-4c8f-b0a7-2e4d6f8a1c3b","url":"https://hooks.slack.com/services/T0/B0/X","events":["job.failed","hypervisor.down"],"format":"slack","disabled":true,"metadata":{}}
+
+DELETE /webhooks/{webhookID}
+
+	$ curl -X DELETE http://localhost:15000/webhooks/3f9b5a2c-6d1e-4c8f-b0a7-2e4d6f8a1c3b
+	{"id":"3f9b5a2c-6d1e-4c8f-b0a7-2e4d6f8a1c3b","url":"https://hooks.slack.com/services/T0/B0/X","events":["job.failed","hypervisor.down"],"format":"slack","disabled":true,"metadata":{}}
+*/
+package main
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	"github.com/pborman/uuid"
+)
+
+func getWebhookHelper(hr *httpmw.Response, r *http.Request) (*lochness.Webhook, bool) {
+	ctx := GetContext(r)
+	vars := mux.Vars(r)
+	webhookID, ok := vars["webhookID"]
+	if !ok {
+		hr.JSONErrorMsg(http.StatusBadRequest, "missing_webhook_id", "missing webhook id")
+		return nil, false
+	}
+	if uuid.Parse(webhookID) == nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_webhook_id", "invalid webhook id")
+		return nil, false
+	}
+
+	webhook, err := ctx.Webhook(webhookID)
+	if err != nil {
+		if ctx.IsKeyNotFound(err) {
+			hr.JSONErrorMsg(http.StatusNotFound, "webhook_not_found", "webhook not found")
+		} else {
+			hr.JSONError(http.StatusInternalServerError, err)
+		}
+		return nil, false
+	}
+	return webhook, true
+}
+
+func saveWebhookHelper(hr *httpmw.Response, webhook *lochness.Webhook) bool {
+	if err := webhook.Validate(); err != nil {
+		hr.JSONError(http.StatusBadRequest, err)
+		return false
+	}
+	if err := webhook.Save(); err != nil {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return false
+	}
+	return true
+}
+
+func decodeWebhook(r *http.Request, webhook *lochness.Webhook) (*lochness.Webhook, error) {
+	if webhook == nil {
+		ctx := GetContext(r)
+		webhook = ctx.NewWebhook()
+	}
+
+	if err := httpmw.Decode(r, webhook); err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+// redactWebhook returns a copy of a webhook without its secret, for responses
+func redactWebhook(webhook *lochness.Webhook) *lochness.Webhook {
+	redacted := *webhook
+	redacted.Secret = ""
+	return &redacted
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/config"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	"github.com/mistifyio/lochness/internal/logging"
+	"github.com/mistifyio/lochness/internal/server"
+	"github.com/mistifyio/lochness/pkg/events"
+	"github.com/mistifyio/lochness/pkg/kv"
+	_ "github.com/mistifyio/lochness/pkg/kv/bolt"
+	_ "github.com/mistifyio/lochness/pkg/kv/consul"
+	"github.com/mistifyio/lochness/pkg/watcher"
+	logx "github.com/mistifyio/mistify-logrus-ext"
+	flag "github.com/ogier/pflag"
+)
+
+func main() {
+	var port uint
+	var kvAddr, kvPrefix, broker, topic, logLevel, otlpEndpoint string
+	var retries, webhookWorkers, webhookRetries, kvRetries int
+	var retryWait, webhookTimeout, slowRequest, kvTimeout time.Duration
+
+	flag.UintVarP(&port, "port", "p", 15000, "listen port")
+	flag.StringVarP(&kvAddr, "kv", "k", "http://127.0.0.1:4001", "address of kv server")
+	flag.StringVar(&kvPrefix, "kv-prefix", kv.DefaultPrefix, "root of the lochness keys in the kv, to share a kv cluster between lochness clusters")
+	flag.DurationVar(&kvTimeout, "kv-timeout", 5*time.Second, "timeout of kv operations, after which requests fail with 503, 0 to disable")
+	flag.IntVar(&kvRetries, "kv-retries", 2, "number of times to retry failed kv operations, other than atomic updates")
+	flag.StringVarP(&broker, "broker", "b", "nats://127.0.0.1:4222", "address of the NATS (nats://) or AMQP (amqp://) broker, empty to only deliver webhooks")
+	flag.StringVarP(&topic, "topic", "t", "lochness", "prefix of the NATS subjects, or the AMQP exchange, events are published to")
+	var logCfg logging.Config
+	flag.StringVarP(&logLevel, "log-level", "l", "warn", "log level")
+	logging.AddFlags(flag.CommandLine, &logCfg)
+	flag.IntVarP(&retries, "retries", "r", 5, "number of times to retry publishing an event before dropping it")
+	flag.DurationVar(&retryWait, "retry-wait", time.Second, "how long to wait between attempts to publish an event")
+	flag.IntVarP(&webhookWorkers, "webhook-workers", "w", 4, "number of webhook deliveries to make at the same time")
+	flag.IntVar(&webhookRetries, "webhook-retries", 5, "number of times to retry a webhook delivery, waiting twice as long each time")
+	flag.DurationVar(&webhookTimeout, "webhook-timeout", 10*time.Second, "timeout of a webhook request")
+	flag.DurationVar(&slowRequest, "slow-request", time.Second, "latency above which requests are logged as slow, 0 to disable")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "", "OTLP/HTTP collector (host:port) to send request traces to")
+	configFile := flag.String(config.FlagName, "", config.FlagUsage)
+	flag.Parse()
+
+	if err := config.Load(config.Pflag(flag.CommandLine), "ceventd", *configFile); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "config.Load",
+			"file":  *configFile,
+		}).Fatal("failed to load config")
+	}
+
+	if err := logging.Setup(logLevel, "ceventd", logCfg); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"level": logLevel,
+		}).Fatal("failed to set up logging")
+	}
+
+	KV, err := kv.New(kvAddr)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"addr":  kvAddr,
+			"error": err,
+			"func":  "kv.New",
+		}).Fatal("unable to connect to kv")
+	}
+	KV = kv.WithPrefix(KV, kvPrefix)
+
+	ctx := lochness.NewContext(KV).WithTimeout(kvTimeout).WithRetry(kvRetries, lochness.DefaultKVRetryWait)
+
+	// Webhooks are delivered in the background, so they are published to last
+	webhooks := newDeliverer(ctx, webhookWorkers, webhookRetries, retryWait, webhookTimeout)
+	pubs := []events.Publisher{webhooks}
+	if broker != "" {
+		pub, err := events.NewPublisher(broker, topic)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"addr":  broker,
+				"error": err,
+				"func":  "events.NewPublisher",
+			}).Fatal("unable to connect to broker")
+		}
+		pubs = []events.Publisher{pub, webhooks}
+	}
+
+	w, err := watcher.New(KV)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "watcher.New",
+		}).Fatal("could not create watcher")
+	}
+	for _, prefix := range prefixes {
+		if err := w.Add(prefix); err != nil {
+			log.WithFields(log.Fields{
+				"error":  err,
+				"func":   "watcher.Add",
+				"prefix": prefix,
+			}).Fatal("could not add watch prefix")
+		}
+	}
+
+	// Channel for indicating work in progress
+	// (to coordinate clean exiting between the consumer and the signal handler)
+	ready := make(chan struct{}, 1)
+	ready <- struct{}{}
+
+	m := newMirror(pubs, retries, retryWait)
+	go func() {
+		if err := m.run(w, ready); err != nil {
+			log.WithField("error", err).Fatal("watcher encountered an error")
+		}
+	}()
+
+	reqLog := httpmw.Config{SlowThreshold: slowRequest}
+	if otlpEndpoint != "" {
+		shutdown, err := httpmw.SetupTracing("ceventd", otlpEndpoint)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":    err,
+				"func":     "httpmw.SetupTracing",
+				"endpoint": otlpEndpoint,
+			}).Fatal("failed to set up tracing")
+		}
+		defer logx.LogReturnedErr(shutdown, nil, "failed to shut down tracing")
+		reqLog.Trace = true
+	}
+
+	srv := Run(port, ctx, reqLog)
+
+	// Serve until SIGINT or SIGTERM, then drain in-flight requests
+	sigCtx, cancel := server.SignalContext()
+	defer cancel()
+	server.Wait(sigCtx, srv)
+	log.Info("waiting for current event to be published")
+
+	<-ready // wait until any current publishing is finished
+	_ = w.Close()
+	for _, pub := range pubs {
+		if err := pub.Close(); err != nil {
+			log.WithField("error", err).Error("failed to close publisher")
+		}
+	}
+	log.Info("exiting")
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/pkg/events"
+	"github.com/mistifyio/lochness/pkg/jobqueue"
+	"github.com/mistifyio/lochness/pkg/kv"
+	"github.com/mistifyio/lochness/pkg/watcher"
+)
+
+// prefixes are the kv prefixes whose changes are published
+var prefixes = []string{lochness.GuestPath, lochness.HypervisorPath, jobqueue.JobPath}
+
+// jobActions names the job statuses that are published
+var jobActions = map[string]string{
+	jobqueue.JobStatusDone:  "done",
+	jobqueue.JobStatusError: "failed",
+}
+
+// mirror publishes the changes to lochness entities in the kv as events
+type mirror struct {
+	pubs      []events.Publisher
+	retries   int
+	retryWait time.Duration
+	// alive is whether each hypervisor was last seen alive
+	alive map[string]bool
+	// jobs is the last status seen for each job
+	jobs map[string]string
+}
+
+func newMirror(pubs []events.Publisher, retries int, retryWait time.Duration) *mirror {
+	return &mirror{
+		pubs:      pubs,
+		retries:   retries,
+		retryWait: retryWait,
+		alive:     make(map[string]bool),
+		jobs:      make(map[string]string),
+	}
+}
+
+// run publishes the events received by the watcher until it fails
+func (m *mirror) run(w *watcher.Watcher, ready chan struct{}) error {
+	for w.Next() {
+		// Remove item to indicate processing has begun
+		done := <-ready
+		m.handle(w.Event())
+		// Return item to indicate processing has completed
+		ready <- done
+	}
+	return w.Err()
+}
+
+// handle publishes the event for a kv change, if there is one, with each
+// publisher. Publishing is retried, and the event dropped if it keeps failing.
+func (m *mirror) handle(change kv.Event) {
+	event := m.translate(change)
+	if event == nil {
+		return
+	}
+	for _, pub := range m.pubs {
+		m.publish(pub, event)
+	}
+}
+
+// publish publishes an event with a publisher, retrying on failure
+func (m *mirror) publish(pub events.Publisher, event *events.Event) {
+	fields := log.Fields{
+		"type":    event.Type,
+		"subject": event.Subject,
+		"id":      event.ID,
+	}
+	for attempt := 0; ; attempt++ {
+		err := pub.Publish(event)
+		if err == nil {
+			log.WithFields(fields).Debug("published event")
+			return
+		}
+		if attempt >= m.retries {
+			log.WithFields(fields).WithField("error", err).Error("failed to publish event, dropping it")
+			return
+		}
+		log.WithFields(fields).WithField("error", err).Warn("failed to publish event, retrying")
+		time.Sleep(m.retryWait)
+	}
+}
+
+// translate maps a kv change to the event to publish, or nil if it is not
+// published
+func (m *mirror) translate(change kv.Event) *events.Event {
+	key := strings.TrimPrefix(change.Key, "/")
+	switch {
+	case strings.HasPrefix(key, lochness.GuestPath):
+		return events.FromKV("guest", strings.TrimPrefix(key, lochness.GuestPath), change)
+	case strings.HasPrefix(key, lochness.HypervisorPath):
+		rest := strings.TrimPrefix(key, lochness.HypervisorPath)
+		if id, ok := cutSuffix(rest, "/heartbeat"); ok {
+			return m.heartbeatEvent(id, change)
+		}
+		return events.FromKV("hypervisor", rest, change)
+	case strings.HasPrefix(key, jobqueue.JobPath):
+		return m.jobEvent(strings.TrimPrefix(key, jobqueue.JobPath), change)
+	}
+	return nil
+}
+
+// heartbeatEvent maps changes to a hypervisor's heartbeat to it going up or
+// down. Only changes in state are published.
+func (m *mirror) heartbeatEvent(id string, change kv.Event) *events.Event {
+	alive := change.Type != kv.Delete
+	if wasAlive, seen := m.alive[id]; seen && wasAlive == alive {
+		return nil
+	}
+	m.alive[id] = alive
+
+	if alive {
+		return events.New("hypervisor", "up", id, nil)
+	}
+	return events.New("hypervisor", "down", id, nil)
+}
+
+// jobEvent maps a change to a job to it finishing or failing. A job's status
+// is only published once.
+func (m *mirror) jobEvent(id string, change kv.Event) *events.Event {
+	if strings.Contains(id, "/") || strings.HasSuffix(id, ".lock") {
+		return nil
+	}
+	if change.Type == kv.Delete {
+		delete(m.jobs, id)
+		return nil
+	}
+
+	var job jobqueue.Job
+	if err := json.Unmarshal(change.Data, &job); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"job":   id,
+		}).Warn("invalid job")
+		return nil
+	}
+	if m.jobs[id] == job.Status {
+		return nil
+	}
+	m.jobs[id] = job.Status
+
+	action, ok := jobActions[job.Status]
+	if !ok {
+		return nil
+	}
+	return events.New("job", action, id, change.Data)
+}
+
+// cutSuffix returns s without suffix and whether it had it
+func cutSuffix(s, suffix string) (string, bool) {
+	if !strings.HasSuffix(s, suffix) {
+		return s, false
+	}
+	return strings.TrimSuffix(s, suffix), true
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/mistifyio/lochness/pkg/events"
+	"github.com/mistifyio/lochness/pkg/jobqueue"
+	"github.com/mistifyio/lochness/pkg/kv"
+	"github.com/mistifyio/lochness/pkg/watcher"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestMirror(t *testing.T) {
+	suite.Run(t, new(MirrorSuite))
+}
+
+type MirrorSuite struct {
+	common.Suite
+	Pub    *fakePublisher
+	Mirror *mirror
+}
+
+// fakePublisher records the events published, failing the first fails times
+type fakePublisher struct {
+	mu     sync.Mutex
+	fails  int
+	events []*events.Event
+}
+
+func (f *fakePublisher) Publish(e *events.Event) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.fails > 0 {
+		f.fails--
+		return errors.New("broker unavailable")
+	}
+	f.events = append(f.events, e)
+	return nil
+}
+
+func (f *fakePublisher) Close() error {
+	return nil
+}
+
+func (f *fakePublisher) published() []*events.Event {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]*events.Event(nil), f.events...)
+}
+
+func (s *MirrorSuite) SetupSuite() {
+	s.Suite.SetupSuite()
+	log.SetLevel(log.FatalLevel)
+}
+
+func (s *MirrorSuite) SetupTest() {
+	s.Suite.SetupTest()
+	s.Pub = &fakePublisher{}
+	s.Mirror = newMirror([]events.Publisher{s.Pub}, 2, time.Millisecond)
+}
+
+func (s *MirrorSuite) TestTranslate() {
+	data := []byte(`{"id":"1"}`)
+	tests := []struct {
+		description string
+		key         string
+		eventType   kv.EventType
+		data        []byte
+		expected    string
+	}{
+		{"guest created", "lochness/guests/1/metadata", kv.Create, data, "guest.created"},
+		{"guest updated", "/lochness/guests/1/metadata", kv.Update, data, "guest.updated"},
+		{"guest deleted", "lochness/guests/1/metadata", kv.Delete, nil, "guest.deleted"},
+		{"hypervisor created", "lochness/hypervisors/1/metadata", kv.Create, data, "hypervisor.created"},
+		{"hypervisor config", "lochness/hypervisors/1/config/metadata", kv.Update, data, ""},
+		{"hypervisor guest", "lochness/hypervisors/1/guests/2", kv.Create, nil, ""},
+		{"unknown type", "lochness/guests/1/metadata", kv.None, data, ""},
+		{"other entity", "lochness/subnets/1/metadata", kv.Create, data, ""},
+		{"job lock", "lochness/jobs/1.lock", kv.Create, nil, ""},
+	}
+
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		event := s.Mirror.translate(kv.Event{Key: test.key, Type: test.eventType, Value: kv.Value{Data: test.data}})
+		if test.expected == "" {
+			s.Nil(event, msg("should not be published"))
+			continue
+		}
+		if !s.NotNil(event, msg("should be published")) {
+			continue
+		}
+		s.Equal(test.expected, event.Type, msg("type"))
+		s.Equal("1", event.Subject, msg("subject"))
+		s.Equal(string(test.data), string(event.Data), msg("data"))
+	}
+}
+
+func (s *MirrorSuite) TestHeartbeat() {
+	key := "lochness/hypervisors/1/heartbeat"
+	tests := []struct {
+		description string
+		eventType   kv.EventType
+		expected    string
+	}{
+		{"first beat", kv.Update, "hypervisor.up"},
+		{"next beat", kv.Update, ""},
+		{"expired", kv.Delete, "hypervisor.down"},
+		{"back", kv.Create, "hypervisor.up"},
+	}
+
+	for _, test := range tests {
+		event := s.Mirror.translate(kv.Event{Key: key, Type: test.eventType})
+		if test.expected == "" {
+			s.Nil(event, test.description)
+			continue
+		}
+		if s.NotNil(event, test.description) {
+			s.Equal(test.expected, event.Type, test.description)
+		}
+	}
+}
+
+func (s *MirrorSuite) TestJob() {
+	key := "lochness/jobs/1"
+	tests := []struct {
+		description string
+		status      string
+		expected    string
+	}{
+		{"new", jobqueue.JobStatusNew, ""},
+		{"working", jobqueue.JobStatusWorking, ""},
+		{"failed", jobqueue.JobStatusError, "job.failed"},
+		{"saved again", jobqueue.JobStatusError, ""},
+	}
+
+	for _, test := range tests {
+		data, _ := json.Marshal(jobqueue.Job{ID: "1", Action: "create", Status: test.status})
+		event := s.Mirror.translate(kv.Event{Key: key, Type: kv.Update, Value: kv.Value{Data: data}})
+		if test.expected == "" {
+			s.Nil(event, test.description)
+			continue
+		}
+		if s.NotNil(event, test.description) {
+			s.Equal(test.expected, event.Type, test.description)
+			s.JSONEq(string(data), string(event.Data), test.description)
+		}
+	}
+
+	s.Nil(s.Mirror.translate(kv.Event{Key: key, Type: kv.Delete}))
+	s.Empty(s.Mirror.jobs, "deleted jobs should be forgotten")
+}
+
+func (s *MirrorSuite) TestHandle() {
+	change := kv.Event{Key: "lochness/guests/1/metadata", Type: kv.Delete}
+
+	s.Pub.fails = 2
+	s.Mirror.handle(change)
+	s.Len(s.Pub.published(), 1, "publishing should be retried")
+
+	s.Pub.fails = 3
+	s.Mirror.handle(change)
+	s.Len(s.Pub.published(), 1, "event should be dropped after the retries")
+}
+
+func (s *MirrorSuite) TestRun() {
+	w, err := watcher.New(s.KV)
+	s.Require().NoError(err)
+	defer func() { _ = w.Close() }()
+	for _, prefix := range prefixes {
+		s.Require().NoError(w.Add(prefix))
+	}
+
+	ready := make(chan struct{}, 1)
+	ready <- struct{}{}
+	go func() { _ = s.Mirror.run(w, ready) }()
+
+	guest := s.NewGuest()
+	var event *events.Event
+	for i := 0; i < 100 && event == nil; i++ {
+		for _, e := range s.Pub.published() {
+			if e.Type == "guest.created" && e.Subject == guest.ID {
+				event = e
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.Require().NotNil(event, "guest creation should be published")
+	s.Contains(string(event.Data), guest.ID)
+}
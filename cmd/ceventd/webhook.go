@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/httpmw"
+)
+
+// RegisterWebhookRoutes registers the webhook routes and handlers
+func RegisterWebhookRoutes(prefix string, router *mux.Router) {
+	router.HandleFunc(prefix, ListWebhooks).Methods("GET")
+	router.HandleFunc(prefix, CreateWebhook).Methods("POST")
+
+	sub := router.PathPrefix(prefix).Subrouter()
+	sub.HandleFunc("/{webhookID}", GetWebhook).Methods("GET")
+	sub.HandleFunc("/{webhookID}", UpdateWebhook).Methods("PATCH")
+	sub.HandleFunc("/{webhookID}", DestroyWebhook).Methods("DELETE")
+}
+
+// ListWebhooks gets a list of all webhooks
+func ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	ctx := GetContext(r)
+
+	webhooks := make(lochness.Webhooks, 0)
+	err := ctx.ForEachWebhook(func(webhook *lochness.Webhook) error {
+		webhooks = append(webhooks, redactWebhook(webhook))
+		return nil
+	})
+	if err != nil && !ctx.IsKeyNotFound(err) {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	hr.JSON(http.StatusOK, webhooks)
+}
+
+// GetWebhook gets a particular webhook
+func GetWebhook(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	webhook, ok := getWebhookHelper(hr, r)
+	if !ok {
+		return
+	}
+	hr.JSON(http.StatusOK, redactWebhook(webhook))
+}
+
+// CreateWebhook creates a new webhook
+func CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	webhook, err := decodeWebhook(r, nil)
+	if err != nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_json", err.Error())
+		return
+	}
+
+	if !saveWebhookHelper(hr, webhook) {
+		return
+	}
+	hr.JSON(http.StatusCreated, redactWebhook(webhook))
+}
+
+// UpdateWebhook updates a webhook. The secret is kept unless a new one is
+// given.
+func UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	webhook, ok := getWebhookHelper(hr, r)
+	if !ok {
+		return
+	}
+
+	webhookID := webhook.ID
+	_, err := decodeWebhook(r, webhook)
+	if err != nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_json", err.Error())
+		return
+	}
+
+	// Don't allow ID redefinition
+	webhook.ID = webhookID
+
+	if !saveWebhookHelper(hr, webhook) {
+		return
+	}
+	hr.JSON(http.StatusOK, redactWebhook(webhook))
+}
+
+// DestroyWebhook destroys a webhook
+func DestroyWebhook(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	webhook, ok := getWebhookHelper(hr, r)
+	if !ok {
+		return
+	}
+
+	if err := webhook.Destroy(); err != nil {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+
+	hr.JSON(http.StatusOK, redactWebhook(webhook))
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/pkg/events"
+)
+
+// Webhook request headers
+const (
+	eventHeader     = "X-Lochness-Event"
+	deliveryHeader  = "X-Lochness-Delivery"
+	signatureHeader = "X-Lochness-Signature"
+)
+
+// delivery is an event to post to a webhook
+type delivery struct {
+	webhook *lochness.Webhook
+	event   *events.Event
+}
+
+// deliverer posts events to the webhooks matching them. Deliveries are made
+// in the background by a pool of workers, and retried with an increasing
+// delay until they succeed or run out of attempts.
+type deliverer struct {
+	ctx       *lochness.Context
+	client    *http.Client
+	queue     chan delivery
+	retries   int
+	retryWait time.Duration
+	wg        sync.WaitGroup
+}
+
+func newDeliverer(ctx *lochness.Context, workers, retries int, retryWait, timeout time.Duration) *deliverer {
+	d := &deliverer{
+		ctx:       ctx,
+		client:    &http.Client{Timeout: timeout},
+		queue:     make(chan delivery, 100),
+		retries:   retries,
+		retryWait: retryWait,
+	}
+	for i := 0; i < workers; i++ {
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			for dl := range d.queue {
+				d.deliver(dl)
+			}
+		}()
+	}
+	return d
+}
+
+// Publish queues an event for each enabled webhook it matches
+func (d *deliverer) Publish(event *events.Event) error {
+	err := d.ctx.ForEachWebhook(func(webhook *lochness.Webhook) error {
+		if !webhook.Disabled && webhook.Matches(event.Type) {
+			d.queue <- delivery{webhook: webhook, event: event}
+		}
+		return nil
+	})
+	if err != nil && !d.ctx.IsKeyNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// Close waits for the queued deliveries to be made
+func (d *deliverer) Close() error {
+	close(d.queue)
+	d.wg.Wait()
+	return nil
+}
+
+// deliver posts an event to a webhook, retrying on failure
+func (d *deliverer) deliver(dl delivery) {
+	fields := log.Fields{
+		"webhook": dl.webhook.ID,
+		"type":    dl.event.Type,
+		"id":      dl.event.ID,
+	}
+
+	wait := d.retryWait
+	for attempt := 0; ; attempt++ {
+		err := d.send(dl.webhook, dl.event)
+		if err == nil {
+			log.WithFields(fields).Debug("delivered event")
+			return
+		}
+		if attempt >= d.retries {
+			log.WithFields(fields).WithField("error", err).Error("failed to deliver event, dropping it")
+			return
+		}
+		log.WithFields(fields).WithField("error", err).Warn("failed to deliver event, retrying")
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// send makes a single attempt to post an event to a webhook
+func (d *deliverer) send(webhook *lochness.Webhook, event *events.Event) error {
+	body, err := webhookBody(webhook, event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(eventHeader, event.Type)
+	req.Header.Set(deliveryHeader, event.ID)
+	if webhook.Secret != "" {
+		req.Header.Set(signatureHeader, sign(webhook.Secret, body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+	return nil
+}
+
+// webhookBody encodes an event in the format of a webhook
+func webhookBody(webhook *lochness.Webhook, event *events.Event) ([]byte, error) {
+	if webhook.Format == lochness.WebhookFormatSlack {
+		return json.Marshal(map[string]string{
+			"text": fmt.Sprintf("lochness %s %s: %s", event.Entity, event.Subject, event.Type),
+		})
+	}
+	return json.Marshal(event)
+}
+
+// sign returns the signature header of a body, its HMAC-SHA256 with secret as
+// key, e.g. "sha256=8e0b..."
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/mistifyio/lochness/pkg/events"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestWebhooks(t *testing.T) {
+	suite.Run(t, new(WebhooksSuite))
+}
+
+type WebhooksSuite struct {
+	common.Suite
+	Server   *httptest.Server
+	mu       sync.Mutex
+	fails    int
+	requests []*receivedRequest
+}
+
+// receivedRequest is a request made to the test server
+type receivedRequest struct {
+	header http.Header
+	body   []byte
+}
+
+func (s *WebhooksSuite) SetupSuite() {
+	s.Suite.SetupSuite()
+	log.SetLevel(log.FatalLevel)
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.fails > 0 {
+			s.fails--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		s.requests = append(s.requests, &receivedRequest{header: r.Header, body: body})
+	}))
+}
+
+func (s *WebhooksSuite) TearDownSuite() {
+	s.Server.Close()
+	s.Suite.TearDownSuite()
+}
+
+func (s *WebhooksSuite) SetupTest() {
+	s.Suite.SetupTest()
+	s.mu.Lock()
+	s.fails = 0
+	s.requests = nil
+	s.mu.Unlock()
+}
+
+func (s *WebhooksSuite) received() []*receivedRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]*receivedRequest(nil), s.requests...)
+}
+
+// newWebhook creates and saves a webhook posting to the test server
+func (s *WebhooksSuite) newWebhook(format, secret string, patterns ...string) *lochness.Webhook {
+	webhook := s.Context.NewWebhook()
+	webhook.URL = s.Server.URL + "/hook"
+	webhook.Format = format
+	webhook.Secret = secret
+	webhook.Events = patterns
+	s.Require().NoError(webhook.Save())
+	return webhook
+}
+
+// deliver publishes events and waits for the deliveries to be made
+func (s *WebhooksSuite) deliver(evs ...*events.Event) {
+	d := newDeliverer(s.Context, 2, 2, time.Millisecond, time.Second)
+	for _, e := range evs {
+		s.Require().NoError(d.Publish(e))
+	}
+	s.Require().NoError(d.Close())
+}
+
+func (s *WebhooksSuite) TestDeliver() {
+	s.newWebhook(lochness.WebhookFormatJSON, "s3cret", "job.failed")
+	disabled := s.newWebhook(lochness.WebhookFormatJSON, "")
+	disabled.Disabled = true
+	s.Require().NoError(disabled.Save())
+
+	failed := events.New("job", "failed", "1", []byte(`{"id":"1"}`))
+	s.deliver(events.New("job", "done", "2", nil), failed)
+
+	requests := s.received()
+	s.Require().Len(requests, 1, "only matching enabled webhooks should get events")
+	req := requests[0]
+	s.Equal("job.failed", req.header.Get(eventHeader))
+	s.Equal(failed.ID, req.header.Get(deliveryHeader))
+	s.Equal(sign("s3cret", req.body), req.header.Get(signatureHeader))
+
+	var event events.Event
+	s.Require().NoError(json.Unmarshal(req.body, &event))
+	s.Equal(failed.ID, event.ID)
+	s.Equal("1", event.Subject)
+}
+
+func (s *WebhooksSuite) TestDeliverSlack() {
+	s.newWebhook(lochness.WebhookFormatSlack, "")
+
+	s.deliver(events.New("hypervisor", "down", "e88a75a6-7ae6-487c-9634-6553d3793437", nil))
+
+	requests := s.received()
+	s.Require().Len(requests, 1)
+	s.Empty(requests[0].header.Get(signatureHeader), "unsigned without a secret")
+	var msg map[string]string
+	s.Require().NoError(json.Unmarshal(requests[0].body, &msg))
+	s.Equal("lochness hypervisor e88a75a6-7ae6-487c-9634-6553d3793437: hypervisor.down", msg["text"])
+}
+
+func (s *WebhooksSuite) TestDeliverRetries() {
+	s.newWebhook(lochness.WebhookFormatJSON, "")
+
+	s.mu.Lock()
+	s.fails = 2
+	s.mu.Unlock()
+	s.deliver(events.New("guest", "deleted", "1", nil))
+	s.Len(s.received(), 1, "delivery should be retried")
+
+	s.mu.Lock()
+	s.fails = 3
+	s.mu.Unlock()
+	s.deliver(events.New("guest", "deleted", "1", nil))
+	s.Len(s.received(), 1, "delivery should be dropped after the retries")
+}
+
+func (s *WebhooksSuite) TestSign() {
+	// HMAC-SHA256 test case 2 of RFC 4231
+	s.Equal("sha256=5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843",
+		sign("Jefe", []byte("what do ya want for nothing?")))
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/pkg/bmc"
+	"github.com/mistifyio/lochness/pkg/jobqueue"
+	"github.com/mistifyio/lochness/pkg/lock"
+)
+
+// haKey is the guest metadata key marking guests to fail over
+const haKey = "ha"
+
+// jobAdder adds guest jobs, e.g. a jobqueue.Client
+type jobAdder interface {
+	AddJob(guestID, action string) (*jobqueue.Job, error)
+}
+
+// controller moves the ha guests of dead hypervisors to healthy ones, after
+// trying to recover them by power cycling them
+type controller struct {
+	ctx      *lochness.Context
+	jobs     jobAdder
+	interval time.Duration
+	grace    time.Duration
+	// recoverAfter is how long a hypervisor must be dead before it is power
+	// cycled, 0 to never power cycle
+	recoverAfter time.Duration
+	// power performs a power action, e.g. Hypervisor.Power
+	power func(h *lochness.Hypervisor, action string) error
+	// down is when a hypervisor without heartbeat history was first seen dead
+	down map[string]time.Time
+	// cycled holds the dead hypervisors that were power cycled, so that each
+	// is only cycled once until it comes back
+	cycled map[string]bool
+}
+
+func newController(ctx *lochness.Context, jobs jobAdder, interval, grace, recoverAfter time.Duration) *controller {
+	return &controller{
+		ctx:          ctx,
+		jobs:         jobs,
+		interval:     interval,
+		grace:        grace,
+		recoverAfter: recoverAfter,
+		power:        (*lochness.Hypervisor).Power,
+		down:         make(map[string]time.Time),
+		cycled:       make(map[string]bool),
+	}
+}
+
+// lead watches the hypervisors while holding the leader lock, so that only one
+// cfailoverd acts. It competes for the lock again whenever leadership is lost,
+// and releases it once stop is closed.
+func (c *controller) lead(leader *lock.Lock, stop <-chan struct{}) {
+	for {
+		err := leader.Acquire(c.interval)
+		select {
+		case <-stop:
+			if err == nil {
+				_ = leader.Release()
+			}
+			return
+		default:
+		}
+		if err == lock.ErrTimeout {
+			continue
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"func":  "lock.Acquire",
+			}).Error("failed to acquire leader lock")
+			time.Sleep(c.interval)
+			continue
+		}
+
+		log.WithField("id", leader.ID()).Info("became leader")
+		c.watch(leader, stop)
+		if err := leader.Release(); err != nil && err != lock.ErrNotHeld {
+			log.WithFields(log.Fields{
+				"error": err,
+				"func":  "lock.Release",
+			}).Warn("failed to release leader lock")
+		}
+		select {
+		case <-stop:
+			return
+		default:
+			log.WithField("id", leader.ID()).Warn("lost leadership")
+		}
+	}
+}
+
+// watch checks the hypervisors every interval until leadership is lost or
+// stop is closed
+func (c *controller) watch(leader *lock.Lock, stop <-chan struct{}) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	lost := leader.Lost()
+	for {
+		// confirm leadership before acting on it
+		if err := leader.Renew(); err != nil {
+			return
+		}
+		if err := c.check(time.Now()); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"func":  "controller.check",
+			}).Error("failed to check hypervisors")
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-lost:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check power cycles every hypervisor that has been dead for longer than the
+// recovery delay by now, and fails over the ha guests of every one that has
+// been dead for longer than the grace period
+func (c *controller) check(now time.Time) error {
+	seen := make(map[string]bool)
+	err := c.ctx.ForEachHypervisor(func(h *lochness.Hypervisor) error {
+		seen[h.ID] = true
+
+		since, dead := c.deadSince(h, now)
+		if !dead {
+			return nil
+		}
+		if c.recoverAfter > 0 && now.Sub(since) >= c.recoverAfter {
+			c.recover(h)
+		}
+		if now.Sub(since) < c.grace {
+			return nil
+		}
+
+		for _, guestID := range h.Guests() {
+			if err := c.failover(h, guestID); err != nil {
+				log.WithFields(log.Fields{
+					"error":      err,
+					"hypervisor": h.ID,
+					"guest":      guestID,
+				}).Error("failed to fail over guest")
+			}
+		}
+		return nil
+	})
+
+	// forget hypervisors that were removed
+	for id := range c.down {
+		if !seen[id] {
+			delete(c.down, id)
+		}
+	}
+	for id := range c.cycled {
+		if !seen[id] {
+			delete(c.cycled, id)
+		}
+	}
+	return err
+}
+
+// recover power cycles a dead hypervisor that has a BMC, once, in case it is
+// hung rather than broken
+func (c *controller) recover(h *lochness.Hypervisor) {
+	if h.BMC == nil || c.cycled[h.ID] {
+		return
+	}
+	c.cycled[h.ID] = true
+
+	if err := c.power(h, bmc.PowerCycle); err != nil {
+		log.WithFields(log.Fields{
+			"error":      err,
+			"hypervisor": h.ID,
+		}).Error("failed to power cycle dead hypervisor")
+		return
+	}
+	log.WithField("hypervisor", h.ID).Warn("power cycled dead hypervisor")
+}
+
+// deadSince returns whether a hypervisor is dead and since when. That is its
+// last heartbeat if it has a heartbeat history, and otherwise when it was
+// first seen dead.
+func (c *controller) deadSince(h *lochness.Hypervisor, now time.Time) (time.Time, bool) {
+	if h.IsAlive() {
+		delete(c.down, h.ID)
+		delete(c.cycled, h.ID)
+		return time.Time{}, false
+	}
+
+	if lastBeat := h.Health().LastBeat; !lastBeat.IsZero() {
+		return lastBeat, true
+	}
+
+	since, ok := c.down[h.ID]
+	if !ok {
+		since = now
+		c.down[h.ID] = since
+		log.WithField("hypervisor", h.ID).Warn("hypervisor is dead")
+	}
+	return since, true
+}
+
+// failover removes an ha guest from its dead hypervisor and adds a job to
+// create it again on a hypervisor chosen by the placer
+func (c *controller) failover(h *lochness.Hypervisor, guestID string) error {
+	guest, err := c.ctx.Guest(guestID)
+	if err != nil {
+		return err
+	}
+	if guest.Metadata[haKey] != "true" {
+		return nil
+	}
+
+	if err := h.RemoveGuest(guest); err != nil {
+		return err
+	}
+
+	job, err := c.jobs.AddJob(guest.ID, "select-hypervisor")
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"hypervisor": h.ID,
+		"guest":      guest.ID,
+		"job":        job.ID,
+	}).Info("failing over guest")
+	return nil
+}
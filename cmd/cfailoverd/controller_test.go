@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/mistifyio/lochness/pkg/bmc"
+	"github.com/mistifyio/lochness/pkg/jobqueue"
+	"github.com/mistifyio/lochness/pkg/lock"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestController(t *testing.T) {
+	suite.Run(t, new(ControllerSuite))
+}
+
+type ControllerSuite struct {
+	common.Suite
+	Jobs       *fakeJobs
+	Controller *controller
+}
+
+// fakeJobs records the jobs added instead of queueing them
+type fakeJobs struct {
+	mu   sync.Mutex
+	jobs []jobqueue.Job
+}
+
+func (f *fakeJobs) AddJob(guestID, action string) (*jobqueue.Job, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	job := jobqueue.Job{ID: uuid.New(), Guest: guestID, Action: action}
+	f.jobs = append(f.jobs, job)
+	return &job, nil
+}
+
+func (f *fakeJobs) added() []jobqueue.Job {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]jobqueue.Job(nil), f.jobs...)
+}
+
+func (s *ControllerSuite) SetupSuite() {
+	s.Suite.SetupSuite()
+	log.SetLevel(log.FatalLevel)
+}
+
+func (s *ControllerSuite) SetupTest() {
+	s.Suite.SetupTest()
+	s.Jobs = &fakeJobs{}
+	s.Controller = newController(s.Context, s.Jobs, 10*time.Millisecond, time.Minute, 0)
+}
+
+// newHAGuest creates a hypervisor with a guest, marked ha if asked
+func (s *ControllerSuite) newHAGuest(ha bool) (*lochness.Hypervisor, *lochness.Guest) {
+	hypervisor, guest := s.NewHypervisorWithGuest()
+	if ha {
+		guest.Metadata[haKey] = "true"
+		s.Require().NoError(guest.Save())
+	}
+	return hypervisor, guest
+}
+
+func (s *ControllerSuite) TestCheck() {
+	dead, deadGuest := s.newHAGuest(true)
+	_, otherGuest := s.newHAGuest(false)
+	alive, aliveGuest := s.newHAGuest(true)
+	_, _ = lochness.SetHypervisorID(alive.ID)
+	s.Require().NoError(alive.Heartbeat(time.Hour))
+
+	now := time.Now()
+	s.NoError(s.Controller.check(now))
+	s.Len(s.Jobs.added(), 0, "dead hypervisors should get a grace period")
+
+	s.NoError(s.Controller.check(now.Add(2 * time.Minute)))
+	jobs := s.Jobs.added()
+	s.Require().Len(jobs, 1)
+	s.Equal(deadGuest.ID, jobs[0].Guest)
+	s.Equal("select-hypervisor", jobs[0].Action)
+
+	guest, err := s.Context.Guest(deadGuest.ID)
+	s.Require().NoError(err)
+	s.Empty(guest.HypervisorID, "ha guest should be removed from the dead hypervisor")
+	dead, err = s.Context.Hypervisor(dead.ID)
+	s.Require().NoError(err)
+	s.Len(dead.Guests(), 0)
+
+	for _, id := range []string{otherGuest.ID, aliveGuest.ID} {
+		guest, err := s.Context.Guest(id)
+		s.Require().NoError(err)
+		s.NotEmpty(guest.HypervisorID, "guest should not be moved")
+	}
+
+	s.NoError(s.Controller.check(now.Add(3 * time.Minute)))
+	s.Len(s.Jobs.added(), 1, "a guest should only be failed over once")
+}
+
+func (s *ControllerSuite) TestCheckHeartbeatHistory() {
+	hypervisor, guest := s.newHAGuest(true)
+	history, _ := json.Marshal(map[string]interface{}{
+		"ttl":   time.Minute,
+		"beats": []time.Time{time.Now().Add(-time.Hour)},
+	})
+	s.Require().NoError(s.KV.Set(filepath.Join(lochness.HypervisorPath, hypervisor.ID, "health"), string(history)))
+
+	s.NoError(s.Controller.check(time.Now()))
+	jobs := s.Jobs.added()
+	s.Require().Len(jobs, 1, "hypervisor dead since its last heartbeat should be failed over")
+	s.Equal(guest.ID, jobs[0].Guest)
+}
+
+func (s *ControllerSuite) TestRecover() {
+	dead, _ := s.newHAGuest(true)
+	dead.BMC = &lochness.BMC{Protocol: bmc.ProtocolIPMI, Address: "10.0.0.5"}
+	s.Require().NoError(dead.Save())
+	s.newHAGuest(true) // dead without a bmc
+
+	var cycled []string
+	s.Controller.recoverAfter = 30 * time.Second
+	s.Controller.power = func(h *lochness.Hypervisor, action string) error {
+		s.Equal(bmc.PowerCycle, action)
+		cycled = append(cycled, h.ID)
+		return nil
+	}
+
+	now := time.Now()
+	s.NoError(s.Controller.check(now))
+	s.Empty(cycled, "hypervisors should not be cycled before the recovery delay")
+
+	s.NoError(s.Controller.check(now.Add(30 * time.Second)))
+	s.Equal([]string{dead.ID}, cycled, "dead hypervisors with a bmc should be cycled")
+	s.Empty(s.Jobs.added(), "guests should not be failed over before the grace period")
+
+	s.NoError(s.Controller.check(now.Add(40 * time.Second)))
+	s.Len(cycled, 1, "hypervisors should only be cycled once while dead")
+
+	_, _ = lochness.SetHypervisorID(dead.ID)
+	s.Require().NoError(dead.Heartbeat(time.Hour))
+	s.NoError(s.Controller.check(now.Add(50 * time.Second)))
+	s.False(s.Controller.cycled[dead.ID], "hypervisors that came back should be cycled again when they die")
+
+	s.NoError(s.Controller.check(now.Add(2 * time.Minute)))
+	s.Len(s.Jobs.added(), 1, "guests of hypervisors that were not recovered should be failed over")
+}
+
+func (s *ControllerSuite) TestLead() {
+	s.newHAGuest(true)
+	s.Controller.grace = 0
+
+	leader, err := lock.New(s.KV, leaderKey, time.Second)
+	s.Require().NoError(err)
+	other, err := lock.New(s.KV, leaderKey, time.Second)
+	s.Require().NoError(err)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		s.Controller.lead(leader, stop)
+		close(done)
+	}()
+
+	s.True(waitFor(func() bool { return len(s.Jobs.added()) > 0 }), "leader should fail over guests")
+	s.Equal(lock.ErrLocked, other.TryAcquire(), "leadership should be held")
+
+	close(stop)
+	<-done
+	s.NoError(other.TryAcquire(), "leadership should be released on stop")
+	s.NoError(other.Release())
+}
+
+// waitFor polls f until it returns true or a second passes
+func waitFor(f func() bool) bool {
+	for i := 0; i < 100; i++ {
+		if f() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+/*
+cfailoverd is the guest failover service. When a hypervisor dies, it tries to
+recover it by power cycling it, and moves the guests marked for high
+availability to healthy hypervisors.
+
+Usage
+
+The following arguments are understood:
+
+	$ cfailoverd -h
+	Usage of cfailoverd:
+	-b, --beanstalk="127.0.0.1:11300": address of beanstalkd server
+	    --config-file="": yaml or toml file of flag settings, overridden by the environment and the command line
+	-g, --grace=5m0s: how long a hypervisor must be dead before its guests are failed over
+	-i, --interval=10s: how often to check for dead hypervisors
+	-k, --kv="http://127.0.0.1:4001": address of kv server
+	    --kv-prefix="/lochness": root of the lochness keys in the kv, to share a kv cluster between lochness clusters
+	-l, --log-level="warn": log level
+	    --log-max-backups=5: number of rotated log files kept
+	    --log-max-size=100: megabytes a log file grows to before it is rotated, 0 to never rotate it
+	    --log-output="stdout": where to log: stdout, stderr, syslog, journald, or the path of a file
+	    --recover-after=0: how long a hypervisor with a bmc must be dead before it is power cycled, 0 to disable
+	    --secret-key-file="": file with the key secrets are encrypted with, see csecretd, to decrypt bmc credentials with
+
+Failover
+
+A hypervisor is dead once its heartbeat key, kept by nheartbeatd, expires. It is
+dead since its last recorded heartbeat, or since cfailoverd first saw it dead if
+it has no heartbeat history. When it has been dead for longer than --grace, each
+of its guests with the metadata "ha" set to "true" is removed from it, releasing
+the guest's address, and a select-hypervisor job is added for the guest, as
+cguestd does when a guest is created. cplacerd then picks a live, healthy
+hypervisor, and cworkerd creates the guest there from its image. Other guests
+are left on the dead hypervisor.
+
+Set the metadata with the guest command:
+
+	$ guest modify f2011319-ad59-42fb-9bad-92e261f0651c '{"metadata":{"ha":"true"}}'
+
+The grace period should be long enough for a hypervisor to reboot, since a
+failed over guest is created again rather than moved, and the original may
+still be running if the hypervisor comes back.
+
+Recovery
+
+A hung hypervisor may come back when power cycled. With --recover-after, a
+hypervisor that has a "bmc" and has been dead for that long is power cycled
+through its BMC, once until it comes back, as with hv power cycle. The BMC
+credentials are decrypted with the key in --secret-key-file. --recover-after
+should be shorter than --grace, less the time the hypervisor takes to boot, so
+that guests are only failed over if it does not come back.
+
+Any number of cfailoverd instances may run, but only the leader, elected through
+a lock in the kv, acts on dead hypervisors. If the leader dies, another instance
+takes over once the lock expires.
+*/
+package main
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/config"
+	"github.com/mistifyio/lochness/internal/logging"
+	"github.com/mistifyio/lochness/pkg/jobqueue"
+	"github.com/mistifyio/lochness/pkg/kv"
+	_ "github.com/mistifyio/lochness/pkg/kv/bolt"
+	_ "github.com/mistifyio/lochness/pkg/kv/consul"
+	"github.com/mistifyio/lochness/pkg/lock"
+	flag "github.com/ogier/pflag"
+)
+
+// leaderKey is the lock held by the cfailoverd instance acting on dead
+// hypervisors
+const leaderKey = "lochness/cfailoverd/leader"
+
+// leaderTTL is how long leadership lasts without being renewed
+const leaderTTL = 15 * time.Second
+
+func main() {
+	var kvAddr, kvPrefix, bstalk, logLevel, secretKeyFile string
+	var interval, grace, recoverAfter time.Duration
+
+	flag.StringVarP(&kvAddr, "kv", "k", "http://127.0.0.1:4001", "address of kv server")
+	flag.StringVar(&kvPrefix, "kv-prefix", kv.DefaultPrefix, "root of the lochness keys in the kv, to share a kv cluster between lochness clusters")
+	flag.StringVarP(&bstalk, "beanstalk", "b", "127.0.0.1:11300", "address of beanstalkd server")
+	var logCfg logging.Config
+	flag.StringVarP(&logLevel, "log-level", "l", "warn", "log level")
+	logging.AddFlags(flag.CommandLine, &logCfg)
+	flag.DurationVarP(&interval, "interval", "i", 10*time.Second, "how often to check for dead hypervisors")
+	flag.DurationVarP(&grace, "grace", "g", 5*time.Minute, "how long a hypervisor must be dead before its guests are failed over")
+	flag.DurationVar(&recoverAfter, "recover-after", 0, "how long a hypervisor with a bmc must be dead before it is power cycled, 0 to disable")
+	flag.StringVar(&secretKeyFile, "secret-key-file", "", "file with the key secrets are encrypted with, see csecretd, to decrypt bmc credentials with")
+	configFile := flag.String(config.FlagName, "", config.FlagUsage)
+	flag.Parse()
+
+	if err := config.Load(config.Pflag(flag.CommandLine), "cfailoverd", *configFile); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "config.Load",
+			"file":  *configFile,
+		}).Fatal("failed to load config")
+	}
+
+	if err := logging.Setup(logLevel, "cfailoverd", logCfg); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"level": logLevel,
+		}).Fatal("failed to set up logging")
+	}
+
+	KV, err := kv.New(kvAddr)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"addr":  kvAddr,
+			"error": err,
+			"func":  "kv.New",
+		}).Fatal("unable to connect to kv")
+	}
+	KV = kv.WithPrefix(KV, kvPrefix)
+
+	jobQueue, err := jobqueue.NewClient(bstalk, KV)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":   err,
+			"address": bstalk,
+		}).Fatal("failed to create jobQueue client")
+	}
+
+	leader, err := lock.New(KV, leaderKey, leaderTTL)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "lock.New",
+		}).Fatal("failed to create leader lock")
+	}
+
+	ctx := lochness.NewContext(KV)
+	if secretKeyFile != "" {
+		key, err := lochness.ReadSecretKeyFile(secretKeyFile)
+		if err == nil {
+			ctx, err = ctx.WithSecretKey(key)
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"func":  "lochness.ReadSecretKeyFile",
+				"file":  secretKeyFile,
+			}).Fatal("failed to read secret key")
+		}
+	}
+
+	c := newController(ctx, jobQueue, interval, grace, recoverAfter)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		c.lead(leader, stop)
+		close(done)
+	}()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	s := <-sigs
+	log.WithField("signal", s).Info("signal received, stepping down")
+
+	// Step down so another instance can take over right away
+	close(stop)
+	<-done
+}
@@ -6,25 +6,80 @@ Usage
 
 	$ cguestd -h
 	Usage of cguestd:
+	    --agent-dial-timeout=0s: how long connecting to an agent may take, 0 for the default of 30s
+	    --agent-port=8080: port of the hypervisor agents, for console connections
+	    --agent-proxy="": url of the proxy to connect to agents through, instead of those of $HTTP_PROXY, $HTTPS_PROXY, and $NO_PROXY, or "none" to connect directly
+	-b, --beanstalk="127.0.0.1:11300": address of beanstalkd server
+	    --config-file="": yaml or toml file of flag settings, overridden by the environment and the command line
+	    --idempotency-sweep-interval=10m0s: how often to remove expired idempotency keys, 0 to disable
 	-k, --kv="http://localhost:4001": address of kv machine
+	    --kv-prefix="/lochness": root of the lochness keys in the kv, to share a kv cluster between lochness clusters
+	    --kv-retries=2: number of times to retry failed kv operations, other than atomic updates
+	    --kv-timeout=5s: timeout of kv operations, after which requests fail with 503, 0 to disable
 	-l, --log-level="warn": log level
+	    --log-max-backups=5: number of rotated log files kept
+	    --log-max-size=100: megabytes a log file grows to before it is rotated, 0 to never rotate it
+	    --log-output="stdout": where to log: stdout, stderr, syslog, journald, or the path of a file
+	    --mac-oui="": OUI of the MACs generated for guests created without one, instead of the one configured for the cluster
+	    --otlp-endpoint="": OTLP/HTTP collector (host:port) to send request traces to
 	-p, --port=18000: listen port
+	    --purge-interval=1m0s: how often to purge soft deleted guests whose restore window passed, 0 to disable
+	    --slow-request=1s: latency above which requests are logged as slow, 0 to disable
 	-s, --statsd="": statsd address
+	    --tls-cert="": certificate file (PEM) to serve https and http/2 with, along with --tls-key
+	    --tls-key="": private key file (PEM) of --tls-cert
+	    --tls-reload=0: how often to check the certificate and key files for rotation, 0 to disable
 
 HTTP API Endpoints
 
 	/guests
 		* GET  - Retrieve a list of guests
 		* POST - Create a new guest - Async
+	/guests/batch
+		* POST - Create new guests from an array of specs - Async
+	/guests/constraints
+		* POST - Check a constraints expression against the hypervisors
+	/guests/spread
+		* GET - Report the guests not spread across failure domains
+	/guests/dns
+		* GET - Retrieve the DNS names of the guests, optionally of a domain
 	/guests/{guestID}
 		* GET    - Retrieve information about a guest
 		* PATCH  - Update information for a guest
-		* DELETE - Delete a guest - Async
+		* DELETE - Delete a guest - Async, unless soft deleted
+	/guests/{guestID}/restore
+		* POST - Restore a soft deleted guest
 	/guests/{guestID}/{action}
 		* POST - Perform the action for the guest - Async
 			Actions: shutdown, reboot, restart, poweroff, start, suspend
+	/guests/{guestID}/clone
+		* POST - Create a new guest by cloning a guest - Async
+	/guests/{guestID}/resize
+		* POST - Resize a guest to another flavor - Async
+	/guests/{guestID}/console
+		* POST - Create a one-time token for connecting to a console
+	/console/{token}
+		* GET - Connect to a console, upgrading the connection
 	/jobs/{jobID}
 		* GET - Check job status
+	/jobs/{jobID}/graph
+		* GET - Retrieve the jobs a job depends on and that depend on it
+	/flavors/{flavorID}
+		* GET - Retrieve a flavor, e.g. to check that one exists
+	/usage
+		* GET - Retrieve the usage of the guests per tenant and day
+	/usage/export
+		* GET - Export the usage of the guests per tenant and day as CSV
+	/capacity/forecast
+		* GET - Forecast the placement of guests of a flavor and the headroom left
+	/schemas
+		* GET - Retrieve the names of the entities with JSON Schemas
+	/schemas/{entity}
+		* GET - Retrieve the JSON Schema of an entity, e.g. guest
+	/events
+		* GET - Stream changes to guests and hypervisors as server-sent events
+	/swagger.json
+		* GET - Retrieve a Swagger 2.0 (OpenAPI) description of the API
 
 The endpoints labeled Async run asynchronous actions, such as creating or
 deleting a guest. In such a case, the return status will be `HTTP/1.1 202
@@ -32,6 +87,280 @@ Accepted` and a header `X-Guest-Job-Id` will be included for status lookups.
 Endpoints not labeled as async, such as getting a guest or updating the guest
 information, will occur synchronously before the response is sent.
 
+The description served at /swagger.json is built from the registered routes
+and the json tags of the structs they exchange, and can be used to generate
+clients or validate requests.
+
+The JSON Schemas (draft-07) served at /schemas/{entity} are generated from the
+structs of the entities, for flavor, fwgroup, guest, hypervisor, network, and
+subnet. A schema requires the fields needed to create the entity, marks those
+set by the server readOnly, and does not allow fields the entity does not have,
+so specs can be checked before they are sent, as guest validate does.
+
+Job Dependencies
+
+The async endpoints take depends_on query parameters, the ids of jobs that must
+succeed before the queued job starts, e.g. to restart a guest only once another
+guest was created. The jobs must exist. Workers hold the job back until then,
+and fail it right away if one of them fails. A new guest is placed on a
+hypervisor before its create job waits. /jobs/{jobID}/graph returns the jobs a
+job is connected to by dependencies, each after the jobs it depends on.
+
+Metadata Filters
+
+GET /guests lists only the guests whose metadata has every key=value pair given
+by the metadata query parameter, e.g. /guests?metadata=env=prod. Guest metadata
+is indexed in the kv, so only the guests matching the first filter, by key, are
+loaded.
+
+Guest States
+
+cworkerd records the state a completed action leaves a guest in as its "state"
+metadata: running, shutdown, or suspended. An action that is not allowed from
+the guest's state is refused with `HTTP/1.1 409 Conflict` and the error
+"invalid_guest_state", e.g. rebooting a guest that is shutdown. A guest may
+only be started when shutdown or suspended, shutdown or powered off when
+running or suspended, and rebooted, restarted, or suspended when running.
+Guests whose state is not yet known may have any action.
+
+Soft Delete
+
+With the "soft-delete-window" config key of the cluster set to a duration, e.g.
+"72h", DELETE /guests/{guestID} only marks the guest deleted, with `HTTP/1.1
+200 OK`, recording the time it was and the time it will be purged as its
+"deleted". Its resources and address are kept, and it may be restored until
+then with a POST to /guests/{guestID}/restore. Deleted guests are listed by
+GET /guests?deleted=true instead of GET /guests, may still be gotten, and other
+requests on them are refused with `HTTP/1.1 409 Conflict` and the error
+"guest_deleted". Every --purge-interval, cguestd queues the delete jobs of
+those whose window passed, recording the job as "purge_job" of their "deleted",
+after which they can not be restored. Deleting a deleted guest queues its
+delete job right away.
+
+	$ curl -X POST http://localhost:18000/guests/f2011319-ad59-42fb-9bad-92e261f0651c/restore
+
+MAC Addresses
+
+Guests created without a "mac" are given a random one that no other guest has.
+It starts with the OUI given by the mac_oui query parameter, e.g.
+"POST /guests?mac_oui=52:54:00", or --mac-oui, or the cluster's, set with the
+"mac-oui" config key, or 02:00:00 otherwise.
+
+Default Firewall Groups
+
+With the "default-fwprofile" config key set to a built-in firewall profile,
+e.g. "isolated", guests created without a "fwgroup" are put in the profile's
+fwgroup for their tenant, named by their "tenant" metadata. The profile is
+instantiated for the tenant if it was not yet, see cnetworkd. Guests without a
+tenant are given no fwgroup.
+
+Batch Creation
+
+A POST to /guests/batch, with a body of an array of up to 100 guest specs,
+creates each as POST /guests does. All the guests are checked first, and the
+valid ones saved in a single transaction, then a job is queued for each. The
+response lists, in the order of the specs, each guest and the id of its job,
+or the error and message of a spec that was not created. With the
+all_or_nothing=true query parameter, no guest is created if any is invalid, and
+the request fails with 400 Bad Request naming them, as it does when none is
+valid. As for single guests, addresses are reserved as the guests are placed,
+each in the same transaction as its placement.
+
+Idempotent Creation
+
+A POST to /guests or /guests/batch with an Idempotency-Key header, a key of up
+to 255 characters unique to the request, e.g. a uuid, may be retried with the
+same key, e.g. after a timeout, without creating the guests again. The response
+to the first request is kept in the kv and replayed to retries, with its status
+and X-Guest-Job-ID and the header Idempotent-Replayed: true, for the
+"idempotency-window" config key of the cluster, a duration, or 24h. A retry
+while the first request is in progress is refused with `HTTP/1.1 409 Conflict`
+and the error "idempotency_key_in_use", and a key used for a different request,
+of another route, query, or body, with `HTTP/1.1 422 Unprocessable Entity` and
+the error "idempotency_key_reused". Requests that fail on the server are not
+kept, so that they may be retried. The key of a request in progress is renewed
+as it runs, and expired keys are removed every --idempotency-sweep-interval.
+
+	$ curl -XPOST http://localhost:18000/guests -H 'Idempotency-Key: 6f1d3f4c-0c3e-4c59-8a4e-3f5d2c9c1b7e' --data-binary '{"flavor":"1","network":"1234asdf-1234-asdf-1234-asdf1234asdf1234"}'
+
+Placement Constraints
+
+A guest spec may carry a "constraints" expression restricting the hypervisors
+it may be placed on, e.g.
+
+	{"constraints":"metadata.rack != \"r12\" && hypervisor.metadata.ssd == \"true\""}
+
+It compares attributes of the hypervisor, and of the guest, with quoted strings
+or each other, using ==, !=, and =~ and !~ for regular expressions, and combines
+the comparisons with &&, ||, ! and parentheses. The attributes are
+hypervisor.id, .ip, .mac, .maintenance, .zone, .row, .rack, .metadata.<key>, and
+.config.<key>, which may also be given without the hypervisor. prefix, and
+guest.id, .flavor, .image, .network, and .metadata.<key>. Unset failure domains
+and keys are "". Specs with an invalid expression fail validation, and cplacerd
+only places a guest on a hypervisor meeting its expression. A POST to
+/guests/constraints, with a body of the expression and optionally the guest
+spec it is for, e.g.
+{"constraints":"metadata.rack != \"r12\"","guest":{"metadata":{"tier":"db"}}},
+returns the ids of the hypervisors meeting it, whether or not they are alive or
+have room for the guest, or the validation error of an invalid expression, so
+that an expression can be tried before guests are created with it.
+
+Failure Domains
+
+Hypervisors may have a "failure_domain" of the zone, row, and rack they are in,
+e.g. {"failure_domain":{"zone":"z1","row":"a","rack":"r7"}}, set with chypervisord.
+Guests with the same "anti_affinity" group, e.g. the replicas of a database,
+are spread across the failure domains by cplacerd, so that losing a rack takes
+down as few of them as it can. A GET to /guests/spread reports the domains
+holding more of the guests of a group than an even spread would, at the
+cluster's spread level or the level query parameter, with the guests that are
+there, and the most of the group a domain should hold.
+
+DNS Names
+
+Guests may have a "dns_name", a fully qualified name of at least two lowercase
+labels, e.g. {"dns_name":"web1.example.com"}. Names are unique within their
+domain: creating or updating a guest with the name of another guest fails with
+409 Conflict and the error code dns_name_in_use, as does a batch with one, while
+two guests of a batch given the same name are invalid. The name of a guest is
+freed when it is renamed or destroyed, but kept while it is soft deleted. A GET
+to /guests/dns lists the names, with the id of the guest of each, sorted by
+domain and host, or with the domain query parameter only those of a domain.
+cdhcpd serves the names in its zone files.
+
+Cloning
+
+A POST to /guests/{guestID}/clone creates a new guest with the flavor, image,
+network, firewall group, vlan group, metadata, and user and vendor data of the
+guest, other than its "state" and "snapshot" metadata, and queues a job to
+place it. Metadata in the optional body, e.g. {"metadata":{"name":"web2"}}, is
+set over the copied metadata. The clone gets a new MAC, generated as for
+guests created without one, and a new address when it is placed. It is always
+placed on the guest's hypervisor, where the agent clones the guest's disks for
+it instead of fetching an image. With {"snapshot":true}, the disks are cloned
+from the guest's latest snapshot, recorded as its "snapshot" metadata by
+cworkerd. Guests that are not on a hypervisor, or without a snapshot when one
+is requested, are refused with `HTTP/1.1 409 Conflict` and the error
+"guest_not_cloneable". The clone records the guest it was cloned from as
+"clone_of", and the snapshot as "clone_snapshot".
+
+	$ curl -X POST http://localhost:18000/guests/f2011319-ad59-42fb-9bad-92e261f0651c/clone --data-binary '{"snapshot":true}'
+
+Resizing
+
+A POST to /guests/{guestID}/resize, with a body of {"flavor":"<id>"}, queues a
+job for the agent of the guest's hypervisor to resize the guest to the vcpus,
+memory, and disk of the flavor, and to apply its network bandwidth and disk
+IOPS limits. The guest keeps its flavor until the job is
+done, with the new one recorded as its "resize_flavor", and holds the larger of
+both on its hypervisor meanwhile. Flavors that are missing, the guest's own, or
+with a smaller disk are refused with `HTTP/1.1 400 Bad Request`. Guests are
+only resized on the hypervisor they are on, as lochness can not move their
+disks to another, so those whose hypervisor does not have the available
+resources the flavor adds, those not on a hypervisor, and those already being
+resized are refused with `HTTP/1.1 409 Conflict` and the error
+"guest_not_resizable".
+
+	$ curl -X POST http://localhost:18000/guests/f2011319-ad59-42fb-9bad-92e261f0651c/resize --data-binary '{"flavor":"8c57735c-6217-4cde-9381-6e941967d973"}'
+
+Consoles
+
+A guest's vnc or serial console is reached through cguestd, so clients do not
+need to know which hypervisor the guest is on, or be able to reach it. A POST to
+/guests/{guestID}/console, with an optional body of {"type":"serial"}, returns a
+token for a vnc console by default. The token can be used once, within a
+minute, by a GET to /console/{token} with the headers "Connection: Upgrade" and
+"Upgrade: tcp". The response is "101 Switching Protocols", after which the
+connection carries the raw console stream, relayed to the console endpoint of
+the guest's hypervisor agent on --agent-port. Agents are connected to through
+the proxy of the environment or --agent-proxy, with CONNECT.
+
+	$ curl -X POST http://localhost:18000/guests/f2011319-ad59-42fb-9bad-92e261f0651c/console --data-binary '{"type":"serial"}'
+	{"token":"5b8a3c1e-7d2f-4e9a-b6c0-1f3d5e7a9b2c","guest":"f2011319-ad59-42fb-9bad-92e261f0651c","type":"serial","expires":"2016-03-07T09:13:44.123456Z"}
+
+The guest command's console subcommand does this for every connection to a
+local port.
+
+Events
+
+GET /events streams the changes to guests and hypervisors as server-sent
+events, so UIs and CLIs can follow them without access to the kv. Each event is
+named by its type, e.g. guest.updated, and its data is the same JSON as the
+events ceventd publishes, with the entity after the change. The stream can be
+limited with the query parameters prefix (guests or hypervisors), id, and
+metadata (key=value), each of which may be repeated. A comment is sent every 15
+seconds while nothing changes, to keep proxies from closing the connection.
+
+Every event has an id. A client that reconnects with it in the Last-Event-ID
+header, as EventSource does, or the last_event_id query parameter, gets the
+changes it missed, as long as cguestd still has them: the last 1024 are kept.
+Otherwise the stream starts with a "reset" event, after which the client
+should list the entities again.
+
+	$ curl -N 'http://localhost:18000/events?prefix=guests&metadata=env=prod'
+	id: 3f2c9a1b-12
+	event: guest.updated
+	data: {"version":1,"id":"8d3e...","type":"guest.updated","entity":"guest","subject":"f2011319-ad59-42fb-9bad-92e261f0651c","time":"2016-03-07T09:12:44Z","data":{...}}
+
+TLS
+
+With --tls-cert and --tls-key, cguestd serves https instead of http, with HTTP/2
+for clients that support it. With --tls-reload, the files are checked for
+changes at most that often, as connections are made, so that rotated
+certificates are picked up without a restart. A new certificate is only served
+once both files load, so they can be replaced one at a time.
+
+	$ cguestd --tls-cert /etc/lochness/cguestd.pem --tls-key /etc/lochness/cguestd-key.pem --tls-reload 1m
+	$ curl --cacert /etc/lochness/ca.pem https://localhost:18000/swagger.json
+
+Versioning
+
+The endpoints are served under /v1, e.g. /v1/guests/{guestID}, and every response has an
+X-API-Version header listing the versions served. The unversioned paths above
+are deprecated aliases kept for existing tooling, whose responses have a
+Deprecation header, a Warning, and a Link to the versioned path. The cli tools
+use /v1 with servers that have it.
+
+Request Logging
+
+Every request is logged with its method, path, status, latency, response size,
+and request id. Requests slower than --slow-request are logged as warnings and
+tagged "slow". With --otlp-endpoint, a span is also emitted for every request
+through OpenTelemetry, continuing any W3C trace context sent by the client.
+
+Jobs queued by a request record its request id as their "trace_id", which the
+placer and workers log with every step of the job and send to the agents in the
+X-Trace-ID header, so a failed guest create can be followed from the request to
+the agent. With --otlp-endpoint, the jobs also record the request's span, which
+the spans of the placer, the workers, and the agent calls continue.
+
+Shutdown
+
+On SIGINT or SIGTERM, cguestd stops accepting connections, ends the event
+streams, and waits up to 5 seconds for the requests in flight to finish before
+closing the remaining connections, flushing its logs, and exiting.
+
+Content Types
+
+Responses are JSON unless the Accept header prefers YAML, application/x-yaml,
+or msgpack, application/msgpack, which have the same fields. Request bodies may
+be any of the three, named by their Content-Type header, and are taken as JSON
+without one. Bodies of other types are rejected like malformed ones, with
+"invalid_json".
+
+Errors
+
+Every response has an X-Request-ID header, taken from the request if the client
+sent one and generated otherwise. Error responses carry a machine readable error
+code, e.g. "guest_not_found" or "invalid_json", and the request id, which is also logged
+with the error. Objects failing validation are rejected with
+"validation_failed" and the names of the invalid fields. Requests whose kv
+operations time out, see --kv-timeout, fail with 503 and "kv_timeout".
+
+	{"message":"guest not found","error":"guest_not_found","request_id":"0b2f6a8e-3c1d-4f5e-9a7b-1c2d3e4f5a6b"}
+
+	{"message":"missing or invalid flavor","code":400,"error":"validation_failed","fields":["flavor"],"request_id":"0b2f6a8e-3c1d-4f5e-9a7b-1c2d3e4f5a6b","stack":[...]}
+
 Example Structs
 
 Guest - lochness.Guest
@@ -50,6 +379,19 @@ Guest - lochness.Guest
 		"bridge": "br0"
 	}
 
+A guest may also have "user_data" and "vendor_data", served to it by cmetadatad
+and passed to the agent on creation. With "data_encoding" set to "base64" they
+are base64 encoded, otherwise raw. Each is limited to 16KiB after decoding.
+
+A guest may be created from an image in the catalog managed by cimaged, by its
+id in "image", instead of its flavor's image. Creation fails with
+"validation_failed" if the image does not exist, or needs more disk or memory
+than the flavor has.
+
+Credentials such as the root password are not stored in the guest, but in
+csecretd, and referenced by id in "secrets", keyed by their purpose, e.g.
+{"secrets":{"root-password":"7d0c5b2e-9f1a-4c3d-8e6b-5a4f3e2d1c0b"}}.
+
 Example Requests
 
 GET /guests
@@ -69,6 +411,26 @@ POST /guests
 
 	{"id":"94ea0ba1-5ec2-460e-9c2e-8269593cdad3","metadata":{},"type":"foo","flavor":"1","hypervisor":"","network":"1234asdf-1234-asdf-1234-asdf1234asdf1234","subnet":"1234asdf-1234-asdf-1234-asdf1234asdf1234","fwgroup":"1234asdf-1234-asdf-1234-asdf1234asdf1234","mac":"a4:75:c1:6b:e3:49","ip":"10.100.101.66","bridge":"br0"}
 
+POST /guests/batch
+
+	$ curl -XPOST 'http://localhost:18000/guests/batch' --data-binary '[{"flavor":"1","network":"1234asdf-1234-asdf-1234-asdf1234asdf1234"},{"network":"1234asdf-1234-asdf-1234-asdf1234asdf1234"}]'
+	[{"guest":{"id":"94ea0ba1-5ec2-460e-9c2e-8269593cdad3","flavor":"1",...},"job":"332a128a-ab00-49eb-aef6-8f12e15afe0c"},{"error":"validation_failed","message":"missing or invalid flavor"}]
+
+POST /guests/constraints
+
+	$ curl -XPOST 'http://localhost:18000/guests/constraints' --data-binary '{"constraints":"metadata.rack != \"r12\""}'
+	{"constraints":"metadata.rack != \"r12\"","hypervisors":["e88a75a6-7ae6-487c-9634-6553d3793437"]}
+
+GET /guests/spread
+
+	$ curl 'http://localhost:18000/guests/spread?level=rack'
+	[{"group":"db","domain":"z1/a/r7","guests":["5f5538a9-c712-4dde-83d6-abdeebece444","94ea0ba1-5ec2-460e-9c2e-8269593cdad3"],"max":1}]
+
+GET /guests/dns
+
+	$ curl 'http://localhost:18000/guests/dns?domain=example.com'
+	[{"name":"web1.example.com","host":"web1","domain":"example.com","guest":"94ea0ba1-5ec2-460e-9c2e-8269593cdad3"}]
+
 GET /guests/{guestID}
 
 	$ curl http://localhost:18000/guests/94ea0ba1-5ec2-460e-9c2e-8269593cdad3
@@ -103,9 +465,63 @@ POST /guests/{guestID}/{action}
 
 	{"id":"5f5538a9-c712-4dde-83d6-abdeebece444","metadata":{},"type":"foo","flavor":"1","hypervisor":"","network":"1234asdf-1234-asdf-1234-asdf1234asdf1234","subnet":"1234asdf-1234-asdf-1234-asdf1234asdf1234","fwgroup":"1234asdf-1234-asdf-1234-asdf1234asdf1234","mac":"a4:75:c1:6b:e3:49","ip":"10.100.101.66","bridge":"br0"}
 
+GET /flavors/{flavorID}
+
+	$ curl http://localhost:18000/flavors/33b6afce-c00f-4ad6-9db6-4822a710eb34
+	{"id":"33b6afce-c00f-4ad6-9db6-4822a710eb34","image":"9f02f5b0-069b-4c80-99c2-b0f94958c139","metadata":{},"memory":128,"disk":1024,"cpu":1}
+
+GET /usage
+
+The usage of the guests, per tenant and day in UTC, between from and to, of
+tenant if given. from and to are dates or RFC 3339 times, and default to the
+30 days up to now. The tenant of a guest is its "tenant" metadata. Guests hold
+their resources from their creation until they are destroyed, whatever their
+state, so reservations, in MB hours of memory and disk and hours of vcpus, are
+charged for shut down guests too, while running_hours is only the time they
+ran. Usage is recorded as actions complete, and outlives the guests.
+
+	$ curl 'http://localhost:18000/usage?tenant=acme&from=2016-03-07&to=2016-03-09'
+	[{"tenant":"acme","day":"2016-03-07","guests":2,"running_hours":36,"memory_hours":4608,"disk_hours":36864,"cpu_hours":36},{"tenant":"acme","day":"2016-03-08","guests":1,"running_hours":6,"memory_hours":1536,"disk_hours":12288,"cpu_hours":12}]
+
+GET /usage/export
+
+The same usage as CSV, with a header row, for billing systems to import.
+
+	$ curl 'http://localhost:18000/usage/export?tenant=acme&from=2016-03-07&to=2016-03-09'
+	day,tenant,guests,running_hours,memory_hours,disk_hours,cpu_hours
+	2016-03-07,acme,2,36.0000,4608.0000,36864.0000,36.0000
+	2016-03-08,acme,1,6.0000,1536.0000,12288.0000,12.0000
+
+GET /capacity/forecast
+
+Whether count guests, 1 by default, of the flavor could be placed now, the
+hypervisors they would be placed on, and the headroom each failure domain would
+have left: its available resources and how many more guests of the flavor would
+fit. Guests are spread as the placer spreads them, each on the candidate with
+the most memory available, and candidates are alive, not in maintenance, and
+meet the constraints expression if one is given. Failure domains are those of
+the hypervisors at the domain level, zone, row, or rack, rack by default.
+Subnets are not taken into account, so this is meant for capacity planning and preflight checks
+rather than as a promise. An unknown flavor is 404 Not Found, and a count below
+1 or an invalid expression fails validation.
+
+	$ curl 'http://localhost:18000/capacity/forecast?flavor=33b6afce-c00f-4ad6-9db6-4822a710eb34&count=3'
+	{"flavor":"33b6afce-c00f-4ad6-9db6-4822a710eb34","count":3,"placeable":true,"placed":3,"hypervisors":[{"hypervisor":"e88a75a6-7ae6-487c-9634-6553d3793437","domain":"r1","guests":2},{"hypervisor":"f4f8b1f3-49d5-4e1c-8c3e-5a0b7c2a9d11","domain":"r2","guests":1}],"domains":[{"domain":"r1","hypervisors":1,"placed":2,"available":{"memory":1792,"disk":98304,"cpu":8},"guests":14},{"domain":"r2","hypervisors":1,"placed":1,"available":{"memory":1920,"disk":99328,"cpu":8},"guests":15}]}
+
+GET /schemas/{entity}
+
+	$ curl http://localhost:18000/schemas/flavor
+	{"$schema":"http://json-schema.org/draft-07/schema#","title":"Flavor","type":"object","properties":{"cpu":{"type":"integer","minimum":0},...,"image":{"type":"string","format":"uuid"},...},"required":["image"],"additionalProperties":false}
+
 GET /jobs/{jobID}
 
 	$ curl http://localhost:18000/jobs/011dc937-1b11-4790-903d-1fc6d8e8708e
 	{"id":"011dc937-1b11-4790-903d-1fc6d8e8708e","remote":"","action":"shutdown","guest":"5f5538a9-c712-4dde-83d6-abdeebece444","status":"new","started_at":"0001-01-01T00:00:00Z","finished_at":"0001-01-01T00:00:00Z"}
+
+GET /jobs/{jobID}/graph
+
+	$ curl -X POST 'http://localhost:18000/guests/5f5538a9-c712-4dde-83d6-abdeebece444/start?depends_on=011dc937-1b11-4790-903d-1fc6d8e8708e'
+	$ curl http://localhost:18000/jobs/011dc937-1b11-4790-903d-1fc6d8e8708e/graph
+	{"job":"011dc937-1b11-4790-903d-1fc6d8e8708e","jobs":[{"id":"011dc937-1b11-4790-903d-1fc6d8e8708e","remote":"","action":"shutdown","guest":"5f5538a9-c712-4dde-83d6-abdeebece444","status":"done",...},{"id":"7c1c3a0e-52e1-4a5a-8d8e-3f3b7f3f2d6b","remote":"","action":"start","guest":"5f5538a9-c712-4dde-83d6-abdeebece444","depends_on":["011dc937-1b11-4790-903d-1fc6d8e8708e"],"status":"new",...}]}
 */
 package main
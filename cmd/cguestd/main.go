@@ -1,45 +1,88 @@
 package main
 
 import (
+	"crypto/tls"
+	"time"
+
 	log "github.com/Sirupsen/logrus"
 	"github.com/armon/go-metrics"
 	"github.com/bakins/go-metrics-map"
-	"github.com/bakins/go-metrics-middleware"
 	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/changefeed"
+	"github.com/mistifyio/lochness/internal/config"
+	"github.com/mistifyio/lochness/internal/guestapi"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	"github.com/mistifyio/lochness/internal/logging"
+	"github.com/mistifyio/lochness/internal/server"
+	"github.com/mistifyio/lochness/internal/tlsutil"
+	"github.com/mistifyio/lochness/pkg/agent"
 	"github.com/mistifyio/lochness/pkg/jobqueue"
 	"github.com/mistifyio/lochness/pkg/kv"
+	_ "github.com/mistifyio/lochness/pkg/kv/bolt"
 	_ "github.com/mistifyio/lochness/pkg/kv/consul"
 	logx "github.com/mistifyio/mistify-logrus-ext"
 	flag "github.com/ogier/pflag"
 )
 
-type metricsContext struct {
-	sink    *mapsink.MapSink
-	metrics *metrics.Metrics
-	mmw     *mmw.Middleware
-}
-
 const defaultEtcdAddr = "http://localhost:4001"
 
 func main() {
 	var port uint
-	var kvAddr, bstalk, logLevel, statsd string
+	var agentPort int
+	var kvAddr, kvPrefix, tlsCert, tlsKey, bstalk, logLevel, statsd, otlpEndpoint, macOUI, agentProxy string
+	var slowRequest, tlsReload, kvTimeout, purgeInterval, idempotencySweep, agentDialTimeout time.Duration
+	var kvRetries int
 
 	flag.UintVarP(&port, "port", "p", 18000, "listen port")
 	flag.StringVarP(&kvAddr, "kv", "k", defaultEtcdAddr, "address of kv machine")
+	flag.StringVar(&kvPrefix, "kv-prefix", kv.DefaultPrefix, "root of the lochness keys in the kv, to share a kv cluster between lochness clusters")
+	flag.DurationVar(&kvTimeout, "kv-timeout", 5*time.Second, "timeout of kv operations, after which requests fail with 503, 0 to disable")
+	flag.IntVar(&kvRetries, "kv-retries", 2, "number of times to retry failed kv operations, other than atomic updates")
 	flag.StringVarP(&bstalk, "beanstalk", "b", "127.0.0.1:11300", "address of beanstalkd server")
+	var logCfg logging.Config
 	flag.StringVarP(&logLevel, "log-level", "l", "warn", "log level")
+	logging.AddFlags(flag.CommandLine, &logCfg)
+	flag.StringVar(&macOUI, "mac-oui", "", "OUI of the MACs generated for guests created without one, instead of the one configured for the cluster")
+	flag.IntVar(&agentPort, "agent-port", lochness.AgentPort, "port of the hypervisor agents, for console connections")
+	flag.StringVar(&agentProxy, "agent-proxy", "", `url of the proxy to connect to agents through, instead of those of $HTTP_PROXY, $HTTPS_PROXY, and $NO_PROXY, or "none" to connect directly`)
+	flag.DurationVar(&agentDialTimeout, "agent-dial-timeout", 0, "how long connecting to an agent may take, 0 for the default of 30s")
 	flag.StringVarP(&statsd, "statsd", "s", "", "statsd address")
+	flag.DurationVar(&purgeInterval, "purge-interval", time.Minute, "how often to purge soft deleted guests whose restore window passed, 0 to disable")
+	flag.DurationVar(&idempotencySweep, "idempotency-sweep-interval", 10*time.Minute, "how often to remove expired idempotency keys, 0 to disable")
+	flag.DurationVar(&slowRequest, "slow-request", time.Second, "latency above which requests are logged as slow, 0 to disable")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "", "OTLP/HTTP collector (host:port) to send request traces to")
+	flag.StringVar(&tlsCert, "tls-cert", "", "certificate file (PEM) to serve https and http/2 with, along with --tls-key")
+	flag.StringVar(&tlsKey, "tls-key", "", "private key file (PEM) of --tls-cert")
+	flag.DurationVar(&tlsReload, "tls-reload", 0, "how often to check the certificate and key files for rotation, 0 to disable")
+	configFile := flag.String(config.FlagName, "", config.FlagUsage)
 	flag.Parse()
 
-	if err := logx.DefaultSetup(logLevel); err != nil {
+	if err := config.Load(config.Pflag(flag.CommandLine), "cguestd", *configFile); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "config.Load",
+			"file":  *configFile,
+		}).Fatal("failed to load config")
+	}
+
+	if err := logging.Setup(logLevel, "cguestd", logCfg); err != nil {
 		log.WithFields(log.Fields{
 			"error": err,
-			"func":  "logx.DefaultSetup",
+			"func":  "logging.Setup",
 			"level": logLevel,
 		}).Fatal("unable to set up logrus")
 	}
 
+	if macOUI != "" {
+		if _, err := lochness.ParseOUI(macOUI); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"func":  "lochness.ParseOUI",
+				"oui":   macOUI,
+			}).Fatal("invalid mac oui")
+		}
+	}
+
 	e, err := kv.New(kvAddr)
 	if err != nil {
 		log.WithFields(log.Fields{
@@ -48,8 +91,9 @@ func main() {
 			"func":  "kv.New",
 		}).Fatal("unable to connect to kv")
 	}
+	e = kv.WithPrefix(e, kvPrefix)
 
-	ctx := lochness.NewContext(e)
+	ctx := lochness.NewContext(e).WithTimeout(kvTimeout).WithRetry(kvRetries, lochness.DefaultKVRetryWait)
 
 	log.WithField("address", bstalk).Info("connection to beanstalk")
 	jobQueue, err := jobqueue.NewClient(bstalk, e)
@@ -60,25 +104,90 @@ func main() {
 		}).Fatal("failed to create jobQueue client")
 	}
 
-	// setup metrics
-	sink := mapsink.New()
-	fanout := metrics.FanoutSink{sink}
+	if purgeInterval > 0 {
+		go purgeDeleted(ctx, jobQueue, purgeInterval)
+	}
+	if idempotencySweep > 0 {
+		go guestapi.SweepIdempotencyKeys(ctx, idempotencySweep)
+	}
 
+	// setup metrics
+	var sinks []metrics.MetricSink
 	if statsd != "" {
 		ss, _ := metrics.NewStatsdSink(statsd)
-		fanout = append(fanout, ss)
+		sinks = append(sinks, ss)
 	}
-	conf := metrics.DefaultConfig("cguestd")
-	conf.EnableHostname = false
-	m, _ := metrics.New(conf, fanout)
-
-	mctx := &metricsContext{
-		sink:    sink,
-		metrics: m,
-		mmw:     mmw.New(m),
+	mctx := guestapi.NewMetricsContext(mapsink.New(), sinks...)
+
+	reqLog := httpmw.Config{SlowThreshold: slowRequest}
+	if otlpEndpoint != "" {
+		shutdown, err := httpmw.SetupTracing("cguestd", otlpEndpoint)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":    err,
+				"func":     "httpmw.SetupTracing",
+				"endpoint": otlpEndpoint,
+			}).Fatal("failed to set up tracing")
+		}
+		defer logx.LogReturnedErr(shutdown, nil, "failed to shut down tracing")
+		reqLog.Trace = true
 	}
 
-	server := Run(port, ctx, jobQueue, mctx)
-	// Block until the server is stopped
-	<-server.StopChan()
+	var tlsConfig *tls.Config
+	if tlsCert != "" || tlsKey != "" {
+		tlsConfig, err = tlsutil.ServerConfig(tlsCert, tlsKey, tlsReload)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"func":  "tlsutil.ServerConfig",
+				"cert":  tlsCert,
+				"key":   tlsKey,
+			}).Fatal("failed to load certificate")
+		}
+	}
+
+	feed, err := changefeed.New(e)
+	if err == nil {
+		err = feed.Start()
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "changefeed.Start",
+		}).Fatal("failed to watch for changes")
+	}
+
+	guestAgent := agent.NewMistify(ctx, agentPort)
+	if err := guestAgent.ConfigureTransport(agentProxy, agentDialTimeout); err != nil {
+		log.WithFields(log.Fields{
+			"error":        err,
+			"func":         "agent.ConfigureTransport",
+			"proxy":        agentProxy,
+			"dial-timeout": agentDialTimeout,
+		}).Fatal("invalid agent transport settings")
+	}
+
+	srv := guestapi.Run(port, ctx, jobQueue, guestAgent, macOUI, mctx, feed, reqLog, tlsConfig)
+
+	// Serve until SIGINT or SIGTERM, then drain in-flight requests
+	sigCtx, cancel := server.SignalContext()
+	defer cancel()
+	server.Wait(sigCtx, srv)
+}
+
+// purgeDeleted queues the delete jobs of the soft deleted guests whose restore
+// window passed, every interval, forever
+func purgeDeleted(ctx *lochness.Context, jobQueue *jobqueue.Client, interval time.Duration) {
+	for now := range time.Tick(interval) {
+		purged, err := guestapi.PurgeDeletedGuests(ctx, jobQueue, now)
+		if purged > 0 {
+			log.WithField("guests", purged).Info("queued jobs purging deleted guests")
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"func":  "guestapi.PurgeDeletedGuests",
+			}).Error("failed to purge deleted guests")
+		}
+	}
 }
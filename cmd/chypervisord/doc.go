@@ -8,9 +8,23 @@ The following arguments are understood:
 
 	$ chypervisord -h
 	Usage of chypervisord:
+	    --config-file="": yaml or toml file of flag settings, overridden by the environment and the command line
 	-k, --kv="http://localhost:4001": address of kv machine
+	    --kv-prefix="/lochness": root of the lochness keys in the kv, to share a kv cluster between lochness clusters
+	    --kv-retries=2: number of times to retry failed kv operations, other than atomic updates
+	    --kv-timeout=5s: timeout of kv operations, after which requests fail with 503, 0 to disable
 	-l, --log-level="warn": log level
+	    --log-max-backups=5: number of rotated log files kept
+	    --log-max-size=100: megabytes a log file grows to before it is rotated, 0 to never rotate it
+	    --log-output="stdout": where to log: stdout, stderr, syslog, journald, or the path of a file
+	    --otlp-endpoint="": OTLP/HTTP collector (host:port) to send request traces to
 	-p, --port=17000: listen port
+	    --purge-interval=1m0s: how often to purge soft deleted hypervisors whose restore window passed, 0 to disable
+	    --secret-key-file="": file with the key secrets are encrypted with, see csecretd, to decrypt bmc credentials with for power actions and sign bootstrap tokens with
+	    --slow-request=1s: latency above which requests are logged as slow, 0 to disable
+	    --tls-cert="": certificate file (PEM) to serve https and http/2 with, along with --tls-key
+	    --tls-key="": private key file (PEM) of --tls-cert
+	    --tls-reload=0: how often to check the certificate and key files for rotation, 0 to disable
 
 HTTP API Endpoints
 
@@ -18,15 +32,33 @@ HTTP API Endpoints
 		* GET  - Retrieve a list of hypervisors
 		* POST - Add a new hypervisor
 
+	/hypervisors/register
+		* POST - Register a netbooted node as a hypervisor with a bootstrap
+		         token, and retrieve its config
+
+	/hypervisors/tokens
+		* POST - Create a bootstrap token
+
 	/hypervisors/{hypervisorID}
 		* GET 	 - Retrieve information about a hypervisor
 		* PATCH	 - Update a hypervisor's information
 		* DELETE - Remove a hypervisor
 
+	/hypervisors/{hypervisorID}/restore
+		* POST - Restore a soft deleted hypervisor
+
 	/hypervisors/{hypervisorID}/config
 		* GET   - Retrieve a hypervisor's configuration
 		* PATCH - Update a hypervisor's configuration
 
+	/hypervisors/{hypervisorID}/expected
+		* GET   - Retrieve the facts expected of a hypervisor
+		* PATCH - Update the facts expected of a hypervisor
+
+	/hypervisors/{hypervisorID}/drift
+		* GET - Retrieve how the hypervisor's facts differed from those
+		        expected when nheartbeatd last checked
+
 	/hypervisors/{hypervisorID}/subnets
 		* GET   - Retrieve a list of subnets associated with the hypervisor
 		* PATCH - Update the list of subnets associated with the hypervisor
@@ -37,6 +69,135 @@ HTTP API Endpoints
 	/hypervisors/{hypervisorID}/guests
 		* GET - Retrieve a list of guests running under the hypervisor
 
+	/hypervisors/{hypervisorID}/health
+		* GET - Retrieve the availability of the hypervisor, scored from its
+		        recent heartbeats
+
+	/hypervisors/{hypervisorID}/telemetry
+		* GET  - Retrieve the recent resource telemetry of the hypervisor,
+		         summarized, with the health of its heartbeats
+		* POST - Record a telemetry sample of the hypervisor
+
+	/hypervisors/{hypervisorID}/power
+		* POST - Power the hypervisor on or off, or cycle it, through its BMC
+
+	/hypervisors/{hypervisorID}/desiredstate
+		* GET - Retrieve the desired state of the hypervisor. With
+		        ?generation=N, wait until a newer generation is available or
+		        ?wait=S seconds (default and max 60) elapse
+
+	/hypervisors/{hypervisorID}/desiredstate/ack
+		* GET  - Retrieve the last desired state ack from the hypervisor
+		* POST - Report the result of converging on a desired state generation
+
+	/upgrades
+		* GET  - Retrieve a list of rolling upgrades of hypervisors
+		* POST - Start a rolling upgrade, unless another is in progress
+
+	/upgrades/{upgradeID}
+		* GET    - Retrieve an upgrade, with the progress of its hypervisors
+		* DELETE - Remove a finished upgrade
+
+	/upgrades/{upgradeID}/pause
+	/upgrades/{upgradeID}/resume
+	/upgrades/{upgradeID}/abort
+		* POST - Pause, resume, or abort an upgrade, which cupgraded acts on
+
+	/events
+		* GET - Stream changes to guests and hypervisors as server-sent
+		        events
+
+	/swagger.json
+		* GET - Retrieve a Swagger 2.0 (OpenAPI) description of the API. It
+		        is built from the registered routes and the json tags of the
+		        structs they exchange, and can be used to generate clients or
+		        validate requests
+
+	/schemas
+		* GET - Retrieve the names of the entities with JSON Schemas
+
+	/schemas/{entity}
+		* GET - Retrieve the JSON Schema (draft-07) of an entity, e.g.
+		        hypervisor, generated from its struct, as cguestd serves
+
+Events
+
+GET /events streams the changes to guests and hypervisors as server-sent
+events, so UIs and CLIs can follow them without access to the kv. Each event is
+named by its type, e.g. guest.updated, and its data is the same JSON as the
+events ceventd publishes, with the entity after the change. The stream can be
+limited with the query parameters prefix (guests or hypervisors), id, and
+metadata (key=value), each of which may be repeated. A comment is sent every 15
+seconds while nothing changes, to keep proxies from closing the connection.
+
+Every event has an id. A client that reconnects with it in the Last-Event-ID
+header, as EventSource does, or the last_event_id query parameter, gets the
+changes it missed, as long as chypervisord still has them: the last 1024 are kept.
+Otherwise the stream starts with a "reset" event, after which the client
+should list the entities again.
+
+	$ curl -N 'http://localhost:17000/events?prefix=guests&metadata=env=prod'
+	id: 3f2c9a1b-12
+	event: guest.updated
+	data: {"version":1,"id":"8d3e...","type":"guest.updated","entity":"guest","subject":"f2011319-ad59-42fb-9bad-92e261f0651c","time":"2016-03-07T09:12:44Z","data":{...}}
+
+TLS
+
+With --tls-cert and --tls-key, chypervisord serves https instead of http, with HTTP/2
+for clients that support it. With --tls-reload, the files are checked for
+changes at most that often, as connections are made, so that rotated
+certificates are picked up without a restart. A new certificate is only served
+once both files load, so they can be replaced one at a time.
+
+	$ chypervisord --tls-cert /etc/lochness/chypervisord.pem --tls-key /etc/lochness/chypervisord-key.pem --tls-reload 1m
+	$ curl --cacert /etc/lochness/ca.pem https://localhost:17000/swagger.json
+
+Versioning
+
+The endpoints are served under /v1, e.g. /v1/hypervisors/{hypervisorID}, and every response has an
+X-API-Version header listing the versions served. The unversioned paths above
+are deprecated aliases kept for existing tooling, whose responses have a
+Deprecation header, a Warning, and a Link to the versioned path. The cli tools
+use /v1 with servers that have it.
+
+Request Logging
+
+Every request is logged with its method, path, status, latency, response size,
+and request id. Requests slower than --slow-request are logged as warnings and
+tagged "slow". With --otlp-endpoint, a span is also emitted for every request
+through OpenTelemetry, continuing any W3C trace context sent by the client.
+
+Shutdown
+
+On SIGINT or SIGTERM, chypervisord stops accepting connections, ends the event
+streams, and waits up to 5 seconds for the requests in flight to finish before
+closing the remaining connections, flushing its logs, and exiting.
+
+Content Types
+
+Responses are JSON unless the Accept header prefers YAML, application/x-yaml,
+or msgpack, application/msgpack, which have the same fields. Request bodies may
+be any of the three, named by their Content-Type header, and are taken as JSON
+without one. Bodies of other types are rejected like malformed ones, with
+"invalid_json".
+
+Errors
+
+Every response has an X-Request-ID header, taken from the request if the client
+sent one and generated otherwise. Error responses carry a machine readable error
+code, e.g. "hypervisor_not_found" or "invalid_json", and the request id, which is also logged
+with the error. Objects failing validation are rejected with
+"validation_failed" and the names of the invalid fields. Power actions on a
+hypervisor without a BMC or credentials fail with 409 and "no_bmc" or
+"no_bmc_credentials", without --secret-key-file with 503 and "no_secret_key",
+and those the BMC fails with 502 and "power_failed". Registrations with an
+invalid bootstrap token fail with 401 and "invalid_token". Requests whose kv
+operations time out, see --kv-timeout, fail with 503 and "kv_timeout".
+
+	{"message":"hypervisor not found","error":"hypervisor_not_found","request_id":"0b2f6a8e-3c1d-4f5e-9a7b-1c2d3e4f5a6b"}
+
+	{"message":"invalid id","code":400,"error":"validation_failed","fields":["id"],"request_id":"0b2f6a8e-3c1d-4f5e-9a7b-1c2d3e4f5a6b","stack":[...]}
+
 Example Structs
 
 Hypervisor - lochness.Hypervisor
@@ -57,13 +218,68 @@ Hypervisor - lochness.Hypervisor
 			"memory": 1024,
 			"disk": 1024,
 			"cpu": 1
+		},
+		"secrets": {
+			"ipmi": "7d0c5b2e-9f1a-4c3d-8e6b-5a4f3e2d1c0b"
+		},
+		"bmc": {
+			"protocol": "ipmi",
+			"address": "10.100.102.35"
 		}
 	}
 
-Config - map of string keys and string values
+Credentials such as the agent token and the IPMI credentials are not stored in
+the hypervisor, but in csecretd, and referenced by id in "secrets", keyed by
+their purpose.
+
+The "bmc" of a hypervisor is the baseboard management controller its power is
+controlled through, with POST /hypervisors/{hypervisorID}/power and a body of
+{"action":"on"}, "off", or "cycle". Its "protocol" is "ipmi", driven with
+ipmitool, which must be installed, or "redfish", whose "address" is the url of
+the Redfish service, e.g. https://10.100.102.35. It is logged into with the
+hypervisor's "ipmi" secret, whose value is "user:password". The action is
+accepted, with 202, once the BMC has accepted it.
+
+Bootstrap
+
+Freshly netbooted nodes register themselves as hypervisors, instead of being
+created with hv create, by POSTing what the agent detected of them to
+/hypervisors/register with a bootstrap token. A token is created with POST
+/hypervisors/tokens and a body of {"ttl":"24h"}, the default, and optionally
+the "mac" of the only node it is for, e.g. with hv token, and is typically
+passed to nodes on their kernel command line, see the kernel-args config.
+Tokens are signed with a key derived from --secret-key-file, without which
+registering fails with 503 and "no_secret_key".
+
+	$ curl -X POST http://localhost:17000/hypervisors/register -d '{
+		"token": "eyJleHBpcmVzIjoiMjAyNi0xMC0xN1QxMDowMDowMFoifQ.3q2-7w...",
+		"mac": "01:23:45:67:89:ac",
+		"ip": "10.100.101.35",
+		"netmask": "255.255.255.0",
+		"gateway": "10.100.101.1",
+		"total_resources": {"memory": 68719476736, "disk": 1099511627776, "cpu": 16}
+	}'
+
+The node's hypervisor is the one with its "id", if it sends one, or else its
+"mac", and is created, with 201, if there is none, or updated, with 200. Its
+available resources are what remains of its total resources once its guests'
+usage is taken. The response is the hypervisor, with its config, that of the
+cluster overlaid with its own, and whether it was created:
+
+	{
+		"hypervisor": {"id": "abcd1234-abcd-1234-abcd-1234abcd1234", ...},
+		"config": {"version": "0.5.0", "memory-overcommit": "1.5"},
+		"created": true
+	}
+
+Config - map of string keys and string values. The "cpu-overcommit" and
+"memory-overcommit" keys set the ratios to which the hypervisor's cpus and
+memory may be allocated to guests, overriding the cluster's, and must be
+positive numbers.
 
 	{
-		"foo": "bar"
+		"foo": "bar",
+		"memory-overcommit": "1.5"
 	}
 
 Subnet - map of string subnet ids to string interfaces
@@ -72,6 +288,26 @@ Subnet - map of string subnet ids to string interfaces
 		"c6430cba-648a-41aa-aee4-b59dacfc790d": "br0"
     }
 
+Metadata Filters
+
+GET /hypervisors lists only the hypervisors whose metadata has every key=value
+pair given by the metadata query parameter, e.g.
+/hypervisors?metadata=rack=a1&metadata=zone=east. Hypervisor metadata is
+indexed in the kv, so only the hypervisors matching the first filter, by key,
+are loaded.
+
+Soft Delete
+
+With the "soft-delete-window" config key of the cluster set to a duration, e.g.
+"72h", deleted hypervisors are only marked deleted, with the time they were and
+the time they will be purged as their "deleted", and may be restored until
+then with a POST to /hypervisors/{hypervisorID}/restore. Deleted hypervisors
+are not placed guests on, and are listed by GET /hypervisors?deleted=true
+instead of GET /hypervisors. chypervisord destroys those whose window passed
+every --purge-interval. Deleting a deleted hypervisor destroys it right away.
+Like with hard deletes, hypervisors with guests are refused with
+`HTTP/1.1 409 Conflict` and the error "hypervisor_not_empty".
+
 Example Requests
 
 GET /hypervisors
@@ -108,36 +344,136 @@ GET /hypervisors/{hypervisorID}/config
 
 	$ curl http://localhost:17000/hypervisors/abcd1234-abcd-1234-abcd-1234abcd1234/config
 
-	{"bar":"baz"}
+	{"config":{"bar":"baz"},"modified_index":1043}
 
 PATCH /hypervisors/{hypervisorID}/config
 
+Sets config values, and removes those with empty values. Keys must each be a
+single key of letters, digits, ".", "_", and "-", and the values of the keys
+lochness itself uses, e.g. "cpu-overcommit", must be valid. Nothing is changed
+unless every key and value is, else the request fails with 400 and
+"validation_failed", naming the invalid key. The config is returned in full,
+with the highest modification index of its keys.
+
 	$ curl -XPATCH http://localhost:17000/hypervisors/abcd1234-abcd-1234-abcd-1234abcd1234/config --data-binary '{"bar":"","foobar":"asdf"}'
 
-	{"foobar":"asdf"}
+	{"config":{"foobar":"asdf"},"modified_index":1051}
+
+GET /hypervisors/{hypervisorID}/expected
+
+	$ curl http://localhost:17000/hypervisors/abcd1234-abcd-1234-abcd-1234abcd1234/expected
+
+	{"kernel-args":"console=ttyS0","os-version":"0.4.2"}
+
+PATCH /hypervisors/{hypervisorID}/expected
+
+Sets the facts expected of the hypervisor, such as its kernel args, os version,
+and network interfaces, see lochness.LocalFacts. Empty values are removed.
+
+	$ curl -XPATCH http://localhost:17000/hypervisors/abcd1234-abcd-1234-abcd-1234abcd1234/expected --data-binary '{"kernel-args":"","net.eth0.mtu":"9000"}'
+
+	{"net.eth0.mtu":"9000","os-version":"0.4.2"}
+
+GET /hypervisors/{hypervisorID}/drift
+
+The facts that differed from those expected when nheartbeatd last checked, on
+the hypervisor. A zero checked_at means they have not been checked.
+
+	$ curl http://localhost:17000/hypervisors/abcd1234-abcd-1234-abcd-1234abcd1234/drift
+
+	{"hypervisor":"abcd1234-abcd-1234-abcd-1234abcd1234","checked_at":"2026-10-16T10:02:11Z","differences":[{"key":"net.eth0.mtu","expected":"9000","actual":"1500"}]}
 
 GET /hypervisors/{hypervisorID}/subnets
 
 	$ curl http://localhost:17000/hypervisors/abcd1234-abcd-1234-abcd-1234abcd1234/subnets
 
-	{"c6430cba-648a-41aa-aee4-b59dacfc790d":"br0"}
+	{"subnets":{"c6430cba-648a-41aa-aee4-b59dacfc790d":"br0"},"modified_index":1022}
 
 PATCH /hypervisors/{hypervisorID}/subnets
 
+Adds subnets, mapped to the bridges of the hypervisor to use for them. Bridge
+names must be at most 15 letters, digits, ".", "_", and "-". With the "bridges"
+config key of the hypervisor set, e.g. to "br0,br1", they must also be one of
+those. The subnets of a network in a VLAN group may only be added to
+hypervisors whose "vlan-groups" config key, a comma separated list of VLAN
+group ids, has it. Nothing is changed unless every subnet exists and every
+bridge is valid and can carry its subnet, else the request fails with 404 and
+"subnet_not_found" or 400 and "validation_failed", describing what is missing.
+The subnets are returned in full, as with config.
+
 	$ curl -XPATCH http://localhost:17000/hypervisors/abcd1234-abcd-1234-abcd-1234abcd1234/subnets --data-binary '{"c6430cba-648a-41aa-aee4-b59dacfc790d":"br0"}'
 
-	{"c6430cba-648a-41aa-aee4-b59dacfc790d":"br0"}
+	{"subnets":{"c6430cba-648a-41aa-aee4-b59dacfc790d":"br0"},"modified_index":1060}
 
 DELETE /hypervisors/{hypervisorID}/subnets/{subnetID}
 
 	$ curl -XDELETE http://localhost:17000/hypervisors/abcd1234-abcd-1234-abcd-1234abcd1234/subnets/c6430cba-648a-41aa-aee4-b59dacfc790d
 
-	{}
+	{"subnets":{},"modified_index":0}
 
 GET /hypervisors/{hypervisorID}/guests
 
 	$ curl http://localhost:17000/hypervisors/e88a75a6-7ae6-487c-9634-6553d3793437/guests
 
 	["ad762efc-3c23-402b-8e1f-a248a005efb9","f2011319-ad59-42fb-9bad-92e261f0651c"]
+
+GET /hypervisors/{hypervisorID}/health
+
+Time between heartbeats beyond the heartbeat ttl counts as down time, and each
+such gap as a flap. The score is the availability divided by one more than the
+number of flaps.
+
+	$ curl http://localhost:17000/hypervisors/e88a75a6-7ae6-487c-9634-6553d3793437/health
+
+	{"alive":true,"last_beat":"2016-03-07T09:12:44Z","beats":360,"availability":0.9861,"flaps":2,"score":0.3287}
+
+GET /hypervisors/{hypervisorID}/telemetry
+
+The most recent samples of the load averages, available memory, and available
+guest disk, in MB, of the hypervisor, oldest first, as pushed by nheartbeatd.
+average_load is the mean one minute load over the window, and min_free_memory
+and min_free_disk the least available over it.
+
+	$ curl http://localhost:17000/hypervisors/e88a75a6-7ae6-487c-9634-6553d3793437/telemetry
+
+	{"health":{"alive":true,"last_beat":"2016-03-07T09:12:44Z","beats":360,"availability":1,"flaps":0,"score":1},"samples":[{"time":"2016-03-07T09:11:44Z","load1":0.52,"load5":0.61,"load15":0.58,"free_memory":20480,"free_disk":401233},{"time":"2016-03-07T09:12:44Z","load1":0.71,"load5":0.63,"load15":0.59,"free_memory":19968,"free_disk":401230}],"latest":{"time":"2016-03-07T09:12:44Z","load1":0.71,"load5":0.63,"load15":0.59,"free_memory":19968,"free_disk":401230},"average_load":0.615,"min_free_memory":19968,"min_free_disk":401230}
+
+POST /hypervisors/{hypervisorID}/telemetry
+
+Samples without a time are taken as of when they are received. Negative loads
+fail with 400 and "validation_failed".
+
+	$ curl -XPOST http://localhost:17000/hypervisors/e88a75a6-7ae6-487c-9634-6553d3793437/telemetry --data-binary '{"load1":0.71,"load5":0.63,"load15":0.59,"free_memory":19968,"free_disk":401230}'
+
+GET /hypervisors/{hypervisorID}/desiredstate
+
+	$ curl 'http://localhost:17000/hypervisors/e88a75a6-7ae6-487c-9634-6553d3793437/desiredstate?generation=41&wait=30'
+
+	{"hypervisor":"e88a75a6-7ae6-487c-9634-6553d3793437","generation":52,"guests":[{"id":"ad762efc-3c23-402b-8e1f-a248a005efb9","metadata":{},"type":"","flavor":"0c9a2e9f-0bd3-4f8b-9fd3-a9c3c5e3d3f5","hypervisor":"e88a75a6-7ae6-487c-9634-6553d3793437","network":"8e7b3d4c-47d6-4d1f-a3c7-0bb0fc7cf4a7","subnet":"c6430cba-648a-41aa-aee4-b59dacfc790d","fwgroup":"","vlangroup":"","mac":"02:ad:76:2e:fc:3c","ip":"10.100.101.66","bridge":"br0"}]}
+
+POST /hypervisors/{hypervisorID}/desiredstate/ack
+
+	$ curl -XPOST http://localhost:17000/hypervisors/e88a75a6-7ae6-487c-9634-6553d3793437/desiredstate/ack --data-binary '{"generation":52}'
+
+	{"generation":52,"timestamp":"2015-08-11T14:36:01.120911546Z"}
+
+POST /upgrades
+
+Starts upgrading the hypervisors given, in order, or all of them in order of
+id, batch_size at a time. See cupgraded. Fails with 409 and
+"upgrade_in_progress" while another upgrade is running or paused.
+
+	$ curl -XPOST http://localhost:17000/upgrades --data-binary '{"version":"0.5.0","batch_size":2}'
+
+	{"id":"5d9b7a3c-2f0e-4c8a-9d41-8a7f0e1b2c3d","version":"0.5.0","batch_size":2,"state":"running","created":"2026-10-16T10:02:11Z","finished":"0001-01-01T00:00:00Z","hypervisors":[{"id":"e88a75a6-7ae6-487c-9634-6553d3793437","step":"pending","since":"0001-01-01T00:00:00Z"}]}
+
+POST /upgrades/{upgradeID}/pause
+
+Upgrades can only be paused while running, resumed while paused, and aborted
+while either, else the request fails with 409 and "invalid_upgrade_state".
+
+	$ curl -XPOST http://localhost:17000/upgrades/5d9b7a3c-2f0e-4c8a-9d41-8a7f0e1b2c3d/pause
+
+	{"id":"5d9b7a3c-2f0e-4c8a-9d41-8a7f0e1b2c3d","version":"0.5.0","batch_size":2,"state":"paused",...}
 */
 package main
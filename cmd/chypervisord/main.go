@@ -1,9 +1,20 @@
 package main
 
 import (
+	"crypto/tls"
+	"time"
+
 	log "github.com/Sirupsen/logrus"
 	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/changefeed"
+	"github.com/mistifyio/lochness/internal/config"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	"github.com/mistifyio/lochness/internal/hypervisorapi"
+	"github.com/mistifyio/lochness/internal/logging"
+	"github.com/mistifyio/lochness/internal/server"
+	"github.com/mistifyio/lochness/internal/tlsutil"
 	"github.com/mistifyio/lochness/pkg/kv"
+	_ "github.com/mistifyio/lochness/pkg/kv/bolt"
 	_ "github.com/mistifyio/lochness/pkg/kv/consul"
 	logx "github.com/mistifyio/mistify-logrus-ext"
 	flag "github.com/ogier/pflag"
@@ -13,17 +24,40 @@ const defaultKVAddr = "http://localhost:4001"
 
 func main() {
 	var port uint
-	var kvAddr, logLevel string
+	var kvAddr, kvPrefix, tlsCert, tlsKey, logLevel, otlpEndpoint, secretKeyFile string
+	var slowRequest, tlsReload, kvTimeout, purgeInterval time.Duration
+	var kvRetries int
 
 	flag.UintVarP(&port, "port", "p", 17000, "listen port")
 	flag.StringVarP(&kvAddr, "kv", "k", defaultKVAddr, "address of kv machine")
+	flag.StringVar(&kvPrefix, "kv-prefix", kv.DefaultPrefix, "root of the lochness keys in the kv, to share a kv cluster between lochness clusters")
+	flag.DurationVar(&kvTimeout, "kv-timeout", 5*time.Second, "timeout of kv operations, after which requests fail with 503, 0 to disable")
+	flag.IntVar(&kvRetries, "kv-retries", 2, "number of times to retry failed kv operations, other than atomic updates")
+	var logCfg logging.Config
 	flag.StringVarP(&logLevel, "log-level", "l", "warn", "log level")
+	logging.AddFlags(flag.CommandLine, &logCfg)
+	flag.DurationVar(&slowRequest, "slow-request", time.Second, "latency above which requests are logged as slow, 0 to disable")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "", "OTLP/HTTP collector (host:port) to send request traces to")
+	flag.StringVar(&tlsCert, "tls-cert", "", "certificate file (PEM) to serve https and http/2 with, along with --tls-key")
+	flag.StringVar(&tlsKey, "tls-key", "", "private key file (PEM) of --tls-cert")
+	flag.DurationVar(&tlsReload, "tls-reload", 0, "how often to check the certificate and key files for rotation, 0 to disable")
+	flag.DurationVar(&purgeInterval, "purge-interval", time.Minute, "how often to purge soft deleted hypervisors whose restore window passed, 0 to disable")
+	flag.StringVar(&secretKeyFile, "secret-key-file", "", "file with the key secrets are encrypted with, see csecretd, to decrypt bmc credentials with for power actions and sign bootstrap tokens with")
+	configFile := flag.String(config.FlagName, "", config.FlagUsage)
 	flag.Parse()
 
-	if err := logx.DefaultSetup(logLevel); err != nil {
+	if err := config.Load(config.Pflag(flag.CommandLine), "chypervisord", *configFile); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "config.Load",
+			"file":  *configFile,
+		}).Fatal("failed to load config")
+	}
+
+	if err := logging.Setup(logLevel, "chypervisord", logCfg); err != nil {
 		log.WithFields(log.Fields{
 			"error": err,
-			"func":  "logx.DefaultSetup",
+			"func":  "logging.Setup",
 			"level": logLevel,
 		}).Fatal("failed to set up logging")
 	}
@@ -36,10 +70,86 @@ func main() {
 			"func":  "kv.New",
 		}).Fatal("unable to connect to kv")
 	}
+	KV = kv.WithPrefix(KV, kvPrefix)
+
+	ctx := lochness.NewContext(KV).WithTimeout(kvTimeout).WithRetry(kvRetries, lochness.DefaultKVRetryWait)
+	if secretKeyFile != "" {
+		key, err := lochness.ReadSecretKeyFile(secretKeyFile)
+		if err == nil {
+			ctx, err = ctx.WithSecretKey(key)
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"func":  "lochness.ReadSecretKeyFile",
+				"file":  secretKeyFile,
+			}).Fatal("failed to read secret key")
+		}
+	}
+
+	reqLog := httpmw.Config{SlowThreshold: slowRequest}
+	if otlpEndpoint != "" {
+		shutdown, err := httpmw.SetupTracing("chypervisord", otlpEndpoint)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":    err,
+				"func":     "httpmw.SetupTracing",
+				"endpoint": otlpEndpoint,
+			}).Fatal("failed to set up tracing")
+		}
+		defer logx.LogReturnedErr(shutdown, nil, "failed to shut down tracing")
+		reqLog.Trace = true
+	}
+
+	var tlsConfig *tls.Config
+	if tlsCert != "" || tlsKey != "" {
+		tlsConfig, err = tlsutil.ServerConfig(tlsCert, tlsKey, tlsReload)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"func":  "tlsutil.ServerConfig",
+				"cert":  tlsCert,
+				"key":   tlsKey,
+			}).Fatal("failed to load certificate")
+		}
+	}
 
-	ctx := lochness.NewContext(KV)
+	feed, err := changefeed.New(KV)
+	if err == nil {
+		err = feed.Start()
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "changefeed.Start",
+		}).Fatal("failed to watch for changes")
+	}
 
-	server := Run(port, ctx)
-	// Block until the server is stopped
-	<-server.StopChan()
+	if purgeInterval > 0 {
+		go purgeDeleted(ctx, purgeInterval)
+	}
+
+	srv := hypervisorapi.Run(port, ctx, feed, reqLog, tlsConfig)
+
+	// Serve until SIGINT or SIGTERM, then drain in-flight requests
+	sigCtx, cancel := server.SignalContext()
+	defer cancel()
+	server.Wait(sigCtx, srv)
+}
+
+// purgeDeleted destroys the soft deleted hypervisors whose restore window
+// passed, every interval, forever
+func purgeDeleted(ctx *lochness.Context, interval time.Duration) {
+	for now := range time.Tick(interval) {
+		purged, err := ctx.PurgeHypervisors(now)
+		if purged > 0 {
+			log.WithField("hypervisors", purged).Info("purged deleted hypervisors")
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"func":  "lochness.Context.PurgeHypervisors",
+			}).Error("failed to purge deleted hypervisors")
+		}
+	}
 }
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	"github.com/mistifyio/lochness/internal/server"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestCImagedAPI(t *testing.T) {
+	suite.Run(t, new(APISuite))
+}
+
+type APISuite struct {
+	common.Suite
+	Port      uint
+	APIServer *server.Server
+	Image     *lochness.Image
+	APIURL    string
+}
+
+func (s *APISuite) SetupSuite() {
+	s.Suite.SetupSuite()
+
+	log.SetLevel(log.FatalLevel)
+	s.Port = 51124
+	s.APIURL = fmt.Sprintf("http://localhost:%d/images", s.Port)
+
+	s.APIServer = Run(s.Port, s.Context, httpmw.Config{})
+	time.Sleep(100 * time.Millisecond)
+}
+
+func (s *APISuite) SetupTest() {
+	s.Suite.SetupTest()
+	s.Image = s.NewImage()
+}
+
+func (s *APISuite) TearDownSuite() {
+	stopChan := s.APIServer.StopChan()
+	s.APIServer.Stop(5 * time.Second)
+	<-stopChan
+
+	s.Suite.TearDownSuite()
+}
+
+func (s *APISuite) TestImageList() {
+	var images lochness.Images
+	s.DoRequest("GET", s.APIURL, http.StatusOK, nil, &images)
+
+	s.Len(images, 1)
+	s.Equal(s.Image.ID, images[0].ID)
+}
+
+func (s *APISuite) TestImageAdd() {
+	image := s.Context.NewImage()
+	image.Name = "debian"
+	image.Source = "https://images.example.com/debian.img"
+	image.Checksum = s.Image.Checksum
+
+	var imageResp lochness.Image
+	s.DoRequest("POST", s.APIURL, http.StatusCreated, image, &imageResp)
+
+	s.Equal(image.ID, imageResp.ID)
+
+	// Make sure it actually saved
+	i, err := s.Context.Image(image.ID)
+	s.NoError(err)
+	s.Equal(image.Name, i.Name)
+
+	image = s.Context.NewImage()
+	image.Name = "invalid"
+	var errResp map[string]interface{}
+	s.DoRequest("POST", s.APIURL, http.StatusBadRequest, image, &errResp)
+	s.Equal("validation_failed", errResp["error"])
+}
+
+func (s *APISuite) TestImageGet() {
+	var image lochness.Image
+	s.DoRequest("GET", fmt.Sprintf("%s/%s", s.APIURL, s.Image.ID), http.StatusOK, nil, &image)
+	s.Equal(s.Image.ID, image.ID)
+
+	var errResp map[string]interface{}
+	s.DoRequest("GET", fmt.Sprintf("%s/%s", s.APIURL, uuid.New()), http.StatusNotFound, nil, &errResp)
+	s.Equal("image_not_found", errResp["error"])
+	s.DoRequest("GET", fmt.Sprintf("%s/%s", s.APIURL, "asdf"), http.StatusBadRequest, nil, &errResp)
+	s.Equal("invalid_image_id", errResp["error"])
+}
+
+func (s *APISuite) TestImageUpdate() {
+	s.Image.Name = "foobar"
+	id := s.Image.ID
+	s.Image.ID = uuid.New()
+
+	var imageResp lochness.Image
+	s.DoRequest("PATCH", fmt.Sprintf("%s/%s", s.APIURL, id), http.StatusOK, s.Image, &imageResp)
+
+	s.Equal(id, imageResp.ID, "id should not be redefined")
+
+	// Make sure it actually saved
+	i, err := s.Context.Image(id)
+	s.NoError(err)
+	s.Equal("foobar", i.Name)
+}
+
+func (s *APISuite) TestImageDestroy() {
+	guest := s.NewGuest()
+	guest.ImageID = s.Image.ID
+	s.Require().NoError(guest.Save())
+
+	var errResp map[string]interface{}
+	s.DoRequest("DELETE", fmt.Sprintf("%s/%s", s.APIURL, s.Image.ID), http.StatusConflict, nil, &errResp)
+	s.Equal("image_in_use", errResp["error"])
+
+	s.Require().NoError(guest.Destroy())
+
+	var imageResp lochness.Image
+	s.DoRequest("DELETE", fmt.Sprintf("%s/%s", s.APIURL, s.Image.ID), http.StatusOK, nil, &imageResp)
+	s.Equal(s.Image.ID, imageResp.ID)
+
+	// Make sure it actually destroyed
+	_, err := s.Context.Image(s.Image.ID)
+	s.Error(err)
+}
+
+func (s *APISuite) TestImageFetchStates() {
+	var fetches []*lochness.ImageFetch
+	s.DoRequest("GET", fmt.Sprintf("%s/%s/hypervisors", s.APIURL, s.Image.ID), http.StatusOK, nil, &fetches)
+	s.Len(fetches, 0)
+
+	hypervisorID := uuid.New()
+	s.Require().NoError(s.Image.SetFetchState(hypervisorID, lochness.ImageFetched, nil))
+	s.DoRequest("GET", fmt.Sprintf("%s/%s/hypervisors", s.APIURL, s.Image.ID), http.StatusOK, nil, &fetches)
+	s.Require().Len(fetches, 1)
+	s.Equal(hypervisorID, fetches[0].HypervisorID)
+	s.Equal(lochness.ImageFetched, fetches[0].State)
+	s.Equal(s.Image.Checksum, fetches[0].Checksum)
+}
@@ -0,0 +1,144 @@
+/*
+cimaged is the image catalog service. It exposes functionality over an HTTP API with JSON formatting.
+
+Guests may be created from an image in the catalog, which hypervisors fetch from
+its source and verify against its checksum before creating the guest.
+
+Usage
+
+The following arguments are understood:
+
+	./cimaged -h
+	Usage of ./cimaged:
+	    --config-file="": yaml or toml file of flag settings, overridden by the environment and the command line
+	-k, --kv="http://localhost:4001": address of kv machine
+	    --kv-prefix="/lochness": root of the lochness keys in the kv, to share a kv cluster between lochness clusters
+	    --kv-retries=2: number of times to retry failed kv operations, other than atomic updates
+	    --kv-timeout=5s: timeout of kv operations, after which requests fail with 503, 0 to disable
+	-l, --log-level="warn": log level
+	    --log-max-backups=5: number of rotated log files kept
+	    --log-max-size=100: megabytes a log file grows to before it is rotated, 0 to never rotate it
+	    --log-output="stdout": where to log: stdout, stderr, syslog, journald, or the path of a file
+	    --otlp-endpoint="": OTLP/HTTP collector (host:port) to send request traces to
+	-p, --port=21000: listen port
+	    --slow-request=1s: latency above which requests are logged as slow, 0 to disable
+
+HTTP API endpoints
+
+	/images
+		* GET - Retrieve a list of images
+		* POST - Add a new image
+
+	/images/{imageID}
+		* GET - Retrieve information about an image
+		* PATCH - Update an image's information
+		* DELETE - Remove an image that no guest was created from
+
+	/images/{imageID}/hypervisors
+		* GET - Retrieve the state of the image on each hypervisor that has fetched it
+
+Images
+
+An image's "source" is the http or https url it is fetched from, and its
+"checksum" the sha256 of its contents, as "sha256:<hex>". Guests can only be
+created from an image with flavors of at least its "min_disk" and "min_memory",
+in MB. A hypervisor that has fetched an image does not fetch it again unless
+its checksum changes, so an image whose contents change should be updated with
+the new checksum.
+
+Versioning
+
+The endpoints are served under /v1, e.g. /v1/images/{imageID}, and every response has an
+X-API-Version header listing the versions served. The unversioned paths above
+are deprecated aliases kept for existing tooling, whose responses have a
+Deprecation header, a Warning, and a Link to the versioned path. The cli tools
+use /v1 with servers that have it.
+
+Request Logging
+
+Every request is logged with its method, path, status, latency, response size,
+and request id. Requests slower than --slow-request are logged as warnings and
+tagged "slow". With --otlp-endpoint, a span is also emitted for every request
+through OpenTelemetry, continuing any W3C trace context sent by the client.
+
+Content Types
+
+Responses are JSON unless the Accept header prefers YAML, application/x-yaml,
+or msgpack, application/msgpack, which have the same fields. Request bodies may
+be any of the three, named by their Content-Type header, and are taken as JSON
+without one. Bodies of other types are rejected like malformed ones, with
+"invalid_json".
+
+Errors
+
+Every response has an X-Request-ID header, taken from the request if the client
+sent one and generated otherwise. Error responses carry a machine readable error
+code, e.g. "image_not_found" or "invalid_json", and the request id, which is also logged
+with the error. Objects failing validation are rejected with
+"validation_failed" and the names of the invalid fields. Deleting an image that
+guests were created from fails with 409 and "image_in_use". Requests whose kv
+operations time out, see --kv-timeout, fail with 503 and "kv_timeout".
+
+	{"message":"image not found","error":"image_not_found","request_id":"0b2f6a8e-3c1d-4f5e-9a7b-1c2d3e4f5a6b"}
+
+	{"message":"invalid checksum \"md5:0f\": must be sha256:<hex>","code":400,"error":"validation_failed","fields":["checksum"],"request_id":"0b2f6a8e-3c1d-4f5e-9a7b-1c2d3e4f5a6b","stack":[...]}
+
+Example Structs
+
+Image - lochness.Image
+
+	{
+		"id": "5f2a3b7c-0d4e-4f6a-8b9c-1d2e3f4a5b6c",
+		"name": "ubuntu-14.04",
+		"source": "https://images.example.com/ubuntu-14.04.img",
+		"checksum": "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		"min_disk": 2048,
+		"min_memory": 256,
+		"metadata": {}
+	}
+
+Image fetch state - lochness.ImageFetch
+
+	{
+		"hypervisor": "e88a75a6-7ae6-487c-9634-6553d3793437",
+		"state": "fetched",
+		"checksum": "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		"updated_at": "2016-03-07T09:12:44.123456Z"
+	}
+
+The state is one of "fetching", "fetched", or "failed", with the reason in
+"error".
+
+Example Requests
+
+GET /images
+
+	$ curl http://localhost:21000/images
+	[{"id":"5f2a3b7c-0d4e-4f6a-8b9c-1d2e3f4a5b6c","name":"ubuntu-14.04","source":"https://images.example.com/ubuntu-14.04.img","checksum":"sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08","min_disk":2048,"min_memory":256,"metadata":{}}]
+
+POST /images
+
+	$ curl -X POST http://localhost:21000/images --data-binary '{"name":"debian-8","source":"https://images.example.com/debian-8.img","checksum":"sha256:60303ae22b998861bce3b28f33eec1be758a213c86c93c076dbe9f558c11c752","min_disk":1024}'
+	{"id":"c3e5a1f0-6b2d-4e8f-9a7c-2d4b6f8a0c1e","name":"debian-8","source":"https://images.example.com/debian-8.img","checksum":"sha256:60303ae22b998861bce3b28f33eec1be758a213c86c93c076dbe9f558c11c752","min_disk":1024,"min_memory":0,"metadata":{}}
+
+GET /images/{imageID}
+
+	$ curl http://localhost:21000/images/5f2a3b7c-0d4e-4f6a-8b9c-1d2e3f4a5b6c
+	{"id":"5f2a3b7c-0d4e-4f6a-8b9c-1d2e3f4a5b6c","name":"ubuntu-14.04","source":"https://images.example.com/ubuntu-14.04.img","checksum":"sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08","min_disk":2048,"min_memory":256,"metadata":{}}
+
+PATCH /images/{imageID}
+
+	$ curl -X PATCH http://localhost:21000/images/c3e5a1f0-6b2d-4e8f-9a7c-2d4b6f8a0c1e --data-binary '{"min_memory":128}'
+	{"id":"c3e5a1f0-6b2d-4e8f-9a7c-2d4b6f8a0c1e","name":"debian-8","source":"https://images.example.com/debian-8.img","checksum":"sha256:60303ae22b998861bce3b28f33eec1be758a213c86c93c076dbe9f558c11c752","min_disk":1024,"min_memory":128,"metadata":{}}
+
+DELETE /images/{imageID}
+
+	$ curl -X DELETE http://localhost:21000/images/c3e5a1f0-6b2d-4e8f-9a7c-2d4b6f8a0c1e
+	{"id":"c3e5a1f0-6b2d-4e8f-9a7c-2d4b6f8a0c1e","name":"debian-8","source":"https://images.example.com/debian-8.img","checksum":"sha256:60303ae22b998861bce3b28f33eec1be758a213c86c93c076dbe9f558c11c752","min_disk":1024,"min_memory":128,"metadata":{}}
+
+GET /images/{imageID}/hypervisors
+
+	$ curl http://localhost:21000/images/5f2a3b7c-0d4e-4f6a-8b9c-1d2e3f4a5b6c/hypervisors
+	[{"hypervisor":"e88a75a6-7ae6-487c-9634-6553d3793437","state":"fetched","checksum":"sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08","updated_at":"2016-03-07T09:12:44.123456Z"}]
+*/
+package main
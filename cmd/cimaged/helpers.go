@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	"github.com/pborman/uuid"
+)
+
+func getImageHelper(hr *httpmw.Response, r *http.Request) (*lochness.Image, bool) {
+	ctx := GetContext(r)
+	vars := mux.Vars(r)
+	imageID, ok := vars["imageID"]
+	if !ok {
+		hr.JSONErrorMsg(http.StatusBadRequest, "missing_image_id", "missing image id")
+		return nil, false
+	}
+	if uuid.Parse(imageID) == nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_image_id", "invalid image id")
+		return nil, false
+	}
+
+	image, err := ctx.Image(imageID)
+	if err != nil {
+		if ctx.IsKeyNotFound(err) {
+			hr.JSONErrorMsg(http.StatusNotFound, "image_not_found", "image not found")
+		} else {
+			hr.JSONError(http.StatusInternalServerError, err)
+		}
+		return nil, false
+	}
+	return image, true
+}
+
+func saveImageHelper(hr *httpmw.Response, image *lochness.Image) bool {
+	if err := image.Validate(); err != nil {
+		hr.JSONError(http.StatusBadRequest, err)
+		return false
+	}
+
+	if err := image.Save(); err != nil {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return false
+	}
+	return true
+}
+
+func decodeImage(r *http.Request, image *lochness.Image) (*lochness.Image, error) {
+	if image == nil {
+		ctx := GetContext(r)
+		image = ctx.NewImage()
+	}
+
+	if err := httpmw.Decode(r, image); err != nil {
+		return nil, err
+	}
+	return image, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/bakins/net-http-recover"
+	"github.com/gorilla/context"
+	"github.com/gorilla/handlers"
+	"github.com/gorilla/mux"
+	"github.com/justinas/alice"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	"github.com/mistifyio/lochness/internal/server"
+)
+
+const ctxKey string = "lochnessContext"
+
+// Run starts the server
+func Run(port uint, ctx *lochness.Context, reqLog httpmw.Config) *server.Server {
+	router := mux.NewRouter()
+	router.StrictSlash(true)
+
+	// Common middleware applied to every request
+	reqLog.Name = "cimaged"
+	commonMiddleware := alice.New(
+		httpmw.RequestID,
+		httpmw.Negotiate,
+		httpmw.Logger(reqLog),
+		httpmw.Versioned,
+		handlers.CompressHandler,
+		func(h http.Handler) http.Handler {
+			return recovery.Handler(os.Stderr, h, true)
+		},
+		func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				context.Set(r, ctxKey, ctx)
+				h.ServeHTTP(w, r)
+			})
+		},
+	)
+
+	// NOTE: Due to weirdness with PrefixPath and StrictSlash, can't just pass
+	// a prefixed subrouter to the register functions and have the base path
+	// work cleanly. The register functions need to add a base path handler to
+	// the main router before setting subhandlers on either main or subrouter
+
+	RegisterImageRoutes("/images", router)
+
+	srv := server.New(fmt.Sprintf(":%d", port), commonMiddleware.Then(router), nil)
+	srv.Start()
+	return srv
+}
+
+// SetContext sets a lochness.Context value for a request
+func SetContext(r *http.Request, ctx *lochness.Context) {
+	context.Set(r, ctxKey, ctx)
+}
+
+// GetContext retrieves a lochness.Context value for a request
+func GetContext(r *http.Request) *lochness.Context {
+	if value := context.Get(r, ctxKey); value != nil {
+		return value.(*lochness.Context)
+	}
+	return nil
+}
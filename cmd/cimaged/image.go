@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+)
+
+// RegisterImageRoutes registers the image routes and handlers
+func RegisterImageRoutes(prefix string, router *mux.Router) {
+	router.HandleFunc(prefix, ListImages).Methods("GET")
+	router.HandleFunc(prefix, CreateImage).Methods("POST")
+
+	sub := router.PathPrefix(prefix).Subrouter()
+	sub.HandleFunc("/{imageID}", GetImage).Methods("GET")
+	sub.HandleFunc("/{imageID}", UpdateImage).Methods("PATCH")
+	sub.HandleFunc("/{imageID}", DestroyImage).Methods("DELETE")
+	sub.HandleFunc("/{imageID}/hypervisors", GetImageFetchStates).Methods("GET")
+}
+
+// ListImages gets a list of all images
+func ListImages(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	ctx := GetContext(r)
+	images := make(lochness.Images, 0)
+	err := ctx.ForEachImage(func(image *lochness.Image) error {
+		images = append(images, image)
+		return nil
+	})
+	if err != nil && !ctx.IsKeyNotFound(err) {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	hr.JSON(http.StatusOK, images)
+}
+
+// GetImage gets a particular image
+func GetImage(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	image, ok := getImageHelper(hr, r)
+	if !ok {
+		return
+	}
+	hr.JSON(http.StatusOK, image)
+}
+
+// CreateImage creates a new image
+func CreateImage(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	image, err := decodeImage(r, nil)
+	if err != nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_json", err.Error())
+		return
+	}
+
+	if !saveImageHelper(hr, image) {
+		return
+	}
+	hr.JSON(http.StatusCreated, image)
+}
+
+// UpdateImage updates an image. Hypervisors fetch it again when its checksum
+// changes.
+func UpdateImage(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	image, ok := getImageHelper(hr, r)
+	if !ok {
+		return
+	}
+
+	imageID := image.ID
+
+	if _, err := decodeImage(r, image); err != nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_json", err.Error())
+		return
+	}
+
+	// Don't allow ID redefinition
+	image.ID = imageID
+
+	if !saveImageHelper(hr, image) {
+		return
+	}
+	hr.JSON(http.StatusOK, image)
+}
+
+// DestroyImage destroys an image that no guest was created from
+func DestroyImage(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	image, ok := getImageHelper(hr, r)
+	if !ok {
+		return
+	}
+
+	if err := image.Destroy(); err != nil {
+		if lerrors.IsConflict(err) {
+			hr.JSONErrorMsg(http.StatusConflict, "image_in_use", err.Error())
+		} else {
+			hr.JSONError(http.StatusInternalServerError, err)
+		}
+		return
+	}
+	hr.JSON(http.StatusOK, image)
+}
+
+// GetImageFetchStates gets the fetch state of an image on each hypervisor that
+// has fetched it
+func GetImageFetchStates(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	image, ok := getImageHelper(hr, r)
+	if !ok {
+		return
+	}
+
+	fetches, err := image.FetchStates()
+	if err != nil && !GetContext(r).IsKeyNotFound(err) {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	if fetches == nil {
+		fetches = []*lochness.ImageFetch{}
+	}
+	sort.Slice(fetches, func(i, j int) bool {
+		return fetches[i].HypervisorID < fetches[j].HypervisorID
+	})
+	hr.JSON(http.StatusOK, fetches)
+}
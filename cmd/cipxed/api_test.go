@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	"github.com/mistifyio/lochness/internal/server"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestCIPXEdAPI(t *testing.T) {
+	suite.Run(t, new(APISuite))
+}
+
+type APISuite struct {
+	common.Suite
+	Port       uint
+	APIServer  *server.Server
+	APIURL     string
+	ImageDir   string
+	Hypervisor *lochness.Hypervisor
+}
+
+func (s *APISuite) SetupSuite() {
+	s.Suite.SetupSuite()
+
+	log.SetLevel(log.FatalLevel)
+	s.Port = 51135
+	s.APIURL = fmt.Sprintf("http://localhost:%d", s.Port)
+
+	var err error
+	s.ImageDir, err = ioutil.TempDir("", "cipxed-test")
+	s.Require().NoError(err)
+	s.Require().NoError(os.Mkdir(filepath.Join(s.ImageDir, "0.1.0"), 0777))
+	s.Require().NoError(ioutil.WriteFile(filepath.Join(s.ImageDir, "0.1.0", "vmlinuz"), []byte("0.1.0-vmlinuz"), 0644))
+
+	b := &booter{
+		defaults: lochness.Boot{
+			Version:   "0.1.0",
+			KernelURL: s.APIURL + "/images/{{.Version}}/vmlinuz",
+			InitrdURL: s.APIURL + "/images/{{.Version}}/initrd",
+		},
+		history: newHistory(s.KV, 2),
+	}
+	s.APIServer = Run(s.Port, s.Context, b, s.ImageDir, httpmw.Config{})
+	time.Sleep(100 * time.Millisecond)
+}
+
+func (s *APISuite) SetupTest() {
+	s.Suite.SetupTest()
+	s.Hypervisor = s.NewHypervisor()
+}
+
+func (s *APISuite) TearDownSuite() {
+	stopChan := s.APIServer.StopChan()
+	s.APIServer.Stop(5 * time.Second)
+	<-stopChan
+
+	_ = os.RemoveAll(s.ImageDir)
+	s.Suite.TearDownSuite()
+}
+
+// get fetches a url, returning the status code and body
+func (s *APISuite) get(url string) (int, string) {
+	resp, err := http.Get(url)
+	s.Require().NoError(err)
+	defer func() { _ = resp.Body.Close() }()
+	body, err := ioutil.ReadAll(resp.Body)
+	s.Require().NoError(err)
+	return resp.StatusCode, string(body)
+}
+
+func (s *APISuite) TestIPXE() {
+	code, body := s.get(fmt.Sprintf("%s/ipxe/%s", s.APIURL, s.Hypervisor.IP))
+	s.Equal(http.StatusOK, code)
+	s.Equal(fmt.Sprintf(`#!ipxe
+kernel %[1]s/images/0.1.0/vmlinuz uuid=%[2]s
+initrd %[1]s/images/0.1.0/initrd
+boot
+`, s.APIURL, s.Hypervisor.ID), body)
+
+	s.Require().NoError(s.Context.SetConfig(lochness.KernelArgsConfig, "console=ttyS0"))
+	s.Require().NoError(s.Hypervisor.SetConfig(lochness.VersionConfig, "0.2.0"))
+	_, body = s.get(fmt.Sprintf("%s/ipxe/%s", s.APIURL, s.Hypervisor.IP))
+	s.Contains(body, fmt.Sprintf("kernel %s/images/0.2.0/vmlinuz uuid=%s console=ttyS0\n", s.APIURL, s.Hypervisor.ID))
+
+	s.Require().NoError(s.Hypervisor.SetConfig(lochness.IPXETemplateConfig, "#!ipxe\necho {{.Hypervisor.ID}}\n"))
+	_, body = s.get(fmt.Sprintf("%s/ipxe/%s", s.APIURL, s.Hypervisor.IP))
+	s.Equal(fmt.Sprintf("#!ipxe\necho %s\n", s.Hypervisor.ID), body)
+
+	var errResp map[string]interface{}
+	s.Require().NoError(s.Hypervisor.SetConfig(lochness.IPXETemplateConfig, "{{.Missing}}"))
+	s.DoRequest("GET", fmt.Sprintf("%s/ipxe/%s", s.APIURL, s.Hypervisor.IP), http.StatusInternalServerError, nil, &errResp)
+	s.Equal("invalid_template", errResp["error"])
+	s.DoRequest("GET", fmt.Sprintf("%s/ipxe/%s", s.APIURL, "10.10.10.10"), http.StatusNotFound, nil, &errResp)
+	s.Equal("hypervisor_not_found", errResp["error"])
+	s.DoRequest("GET", fmt.Sprintf("%s/ipxe/%s", s.APIURL, "asdf"), http.StatusBadRequest, nil, &errResp)
+	s.Equal("invalid_ip", errResp["error"])
+}
+
+func (s *APISuite) TestBoots() {
+	var boots []*bootRecord
+	s.DoRequest("GET", fmt.Sprintf("%s/boots/%s", s.APIURL, s.Hypervisor.ID), http.StatusOK, nil, &boots)
+	s.Len(boots, 0)
+
+	for _, version := range []string{"0.1.0", "0.2.0", "0.3.0"} {
+		s.Require().NoError(s.Hypervisor.SetConfig(lochness.VersionConfig, version))
+		code, _ := s.get(fmt.Sprintf("%s/ipxe/%s", s.APIURL, s.Hypervisor.IP))
+		s.Require().Equal(http.StatusOK, code)
+	}
+
+	s.DoRequest("GET", fmt.Sprintf("%s/boots/%s", s.APIURL, s.Hypervisor.ID), http.StatusOK, nil, &boots)
+	s.Require().Len(boots, 2, "only the newest boots should be kept")
+	s.Equal("0.2.0", boots[0].Version)
+	s.Equal("0.3.0", boots[1].Version)
+	s.Equal(s.Hypervisor.ID, boots[1].Hypervisor)
+	s.Equal(s.Hypervisor.IP.String(), boots[1].IP)
+	s.Equal(fmt.Sprintf("%s/images/0.3.0/vmlinuz", s.APIURL), boots[1].KernelURL)
+	s.Equal(lochness.BootSourceDefault, boots[1].TemplateSource)
+	s.NotEmpty(boots[1].RequestID)
+
+	var errResp map[string]interface{}
+	s.DoRequest("GET", fmt.Sprintf("%s/boots/%s", s.APIURL, "asdf"), http.StatusBadRequest, nil, &errResp)
+	s.Equal("invalid_hypervisor_id", errResp["error"])
+	s.DoRequest("GET", fmt.Sprintf("%s/boots/%s", s.APIURL, uuid.New()), http.StatusOK, nil, &boots)
+	s.Len(boots, 0)
+}
+
+func (s *APISuite) TestImages() {
+	code, body := s.get(fmt.Sprintf("%s/images/0.1.0/vmlinuz", s.APIURL))
+	s.Equal(http.StatusOK, code)
+	s.Equal("0.1.0-vmlinuz", body)
+}
@@ -0,0 +1,130 @@
+/*
+cipxed is the network boot service. It serves the iPXE scripts hypervisors boot
+with, generated from their config in the kv, and records which hypervisor
+booted what. It also serves tftp, so that the whole netboot chain is served by
+lochness.
+
+The dhcpd config written by cdhcpd sends hypervisors to
+tftp.services.<domain> for undionly.kpxe, the iPXE build their PXE ROMs
+chainload, and then chains iPXE to
+http://ipxe.services.<domain>:8888/ipxe/${net0/ip}, which cipxed serves. It
+takes over the /ipxe endpoint of cbootstrapd, which should listen on another
+port when both run on the same host.
+
+Usage
+
+The following arguments are understood:
+
+	./cipxed -h
+	Usage of ./cipxed:
+	    --config-file="": yaml or toml file of flag settings, overridden by the environment and the command line
+	-i, --images="/var/lib/images": directory of boot images to serve under /images/, blank not to serve any
+	    --initrd-url="http://ipxe.services.lochness.local:8888/images/{{.Version}}/initrd": url template of the initrd booted by hypervisors without an initrd-url config
+	-o, --kernel-args="": kernel arguments of hypervisors without a kernel-args config
+	    --kernel-url="http://ipxe.services.lochness.local:8888/images/{{.Version}}/vmlinuz": url template of the kernel booted by hypervisors without a kernel-url config
+	-k, --kv="http://localhost:4001": address of kv machine
+	    --kv-prefix="/lochness": root of the lochness keys in the kv, to share a kv cluster between lochness clusters
+	    --kv-retries=2: number of times to retry failed kv operations, other than atomic updates
+	    --kv-timeout=5s: timeout of kv operations, after which requests fail with 503, 0 to disable
+	-l, --log-level="warn": log level
+	    --log-max-backups=5: number of rotated log files kept
+	    --log-max-size=100: megabytes a log file grows to before it is rotated, 0 to never rotate it
+	    --log-output="stdout": where to log: stdout, stderr, syslog, journald, or the path of a file
+	    --otlp-endpoint="": OTLP/HTTP collector (host:port) to send request traces to
+	-p, --port=8888: listen port
+	-r, --retain=20: number of boots of each hypervisor to keep in boot history. 0 disables history
+	    --slow-request=1s: latency above which requests are logged as slow, 0 to disable
+	    --template="": file of the iPXE script template of hypervisors without an ipxe-template config, instead of the built in one
+	    --tftp=":69": udp address to serve tftp on, blank not to
+	    --tftp-root="/var/lib/tftpboot": directory of files to serve over tftp, including undionly.kpxe
+	-v, --version="0.1.0": version booted by hypervisors without a version config
+
+HTTP API endpoints
+
+	/ipxe/{ip}
+		* GET - Retrieve the iPXE script of the hypervisor with the ip, and record its boot
+
+	/boots/{hypervisorID}
+		* GET - Retrieve the recorded boots of a hypervisor, oldest first
+
+	/images/{version}/{file}
+		* GET - Retrieve a boot image, e.g. a kernel or initrd, from --images
+
+Boot Config
+
+What a hypervisor boots is taken from its config, or the cluster's config where
+it has none, or the flags where neither has a value:
+
+	version        the version of the hypervisor image, which upgrades set
+	kernel-url     the url of the kernel, a template of the version, e.g. "http://images/{{.Version}}/vmlinuz"
+	initrd-url     the url of the initrd, a template of the version like kernel-url
+	kernel-args    arguments the kernel is booted with, in addition to uuid=<hypervisor id>
+	ipxe-template  the template of the iPXE script, overriding --template and the built in one
+
+A hypervisor's config is set with hv, e.g.
+
+	$ hv config modify ed5df266-1416-497b-ac96-da42a77c5410 '{"kernel-args":"console=ttyS0"}'
+
+Script templates are go text/templates executed with the version, kernel-url,
+initrd-url, and kernel-args, as {{.Version}}, {{.KernelURL}}, {{.InitrdURL}}, and
+{{.KernelArgs}}, and the hypervisor booting, as {{.Hypervisor}}. The built in
+template is:
+
+	#!ipxe
+	kernel {{.KernelURL}} uuid={{.Hypervisor.ID}}{{with .KernelArgs}} {{.}}{{end}}
+	initrd {{.InitrdURL}}
+	boot
+
+Templates and urls that do not parse are rejected when set. Those that fail to
+execute, e.g. by naming a missing field, fail the request with 500 and
+"invalid_template".
+
+Boot History
+
+Every script served is logged and recorded in the kv under
+/lochness/cipxed/boots/<hypervisor id>/, with the version, urls, and kernel
+arguments booted, where the template came from ("default", "cluster",
+"hypervisor", or the --template file), the address of the client, and the
+request id. Only the newest --retain records are kept for each hypervisor. A
+hypervisor whose boot can not be recorded is still served its script, and the
+failure is logged.
+
+TFTP
+
+Files in --tftp-root, such as undionly.kpxe, are served over tftp to any
+client. A missing undionly.kpxe is warned of at startup, as hypervisors can not
+netboot without it. The files "vmlinuz" and "initrd" are instead the kernel and
+initrd the hypervisor reading them boots, found by the client's address,
+fetched from their urls; other clients are told they do not exist. Transfers
+are logged, with the client's address and the bytes sent.
+
+	$ tftp tftp.services.lochness.local -c get undionly.kpxe
+
+The blksize, tsize, and timeout options are supported, and writes are refused.
+
+Errors
+
+Every response has an X-Request-ID header, taken from the request if the client
+sent one and generated otherwise. Error responses carry a machine readable error
+code, e.g. "hypervisor_not_found" or "invalid_ip", and the request id, which is
+also logged with the error. Requests whose kv operations time out, see
+--kv-timeout, fail with 503 and "kv_timeout".
+
+	{"message":"hypervisor not found","error":"hypervisor_not_found","request_id":"0b2f6a8e-3c1d-4f5e-9a7b-1c2d3e4f5a6b"}
+
+Example Requests
+
+GET /ipxe/{ip}
+
+	$ curl http://ipxe.services.lochness.local:8888/ipxe/192.168.100.200
+	#!ipxe
+	kernel http://ipxe.services.lochness.local:8888/images/0.1.0/vmlinuz uuid=ed5df266-1416-497b-ac96-da42a77c5410 console=ttyS0
+	initrd http://ipxe.services.lochness.local:8888/images/0.1.0/initrd
+	boot
+
+GET /boots/{hypervisorID}
+
+	$ curl http://ipxe.services.lochness.local:8888/boots/ed5df266-1416-497b-ac96-da42a77c5410
+	[{"id":"01457345564000000000","hypervisor":"ed5df266-1416-497b-ac96-da42a77c5410","ip":"192.168.100.200","remote":"192.168.100.200:49152","request_id":"0b2f6a8e-3c1d-4f5e-9a7b-1c2d3e4f5a6b","time":"2016-03-07T09:12:44Z","version":"0.1.0","kernel_url":"http://ipxe.services.lochness.local:8888/images/0.1.0/vmlinuz","initrd_url":"http://ipxe.services.lochness.local:8888/images/0.1.0/initrd","kernel_args":"console=ttyS0","template_source":"default"}]
+*/
+package main
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/pkg/kv"
+)
+
+// bootsPath is the kv prefix under which boot records are kept per hypervisor
+const bootsPath = "lochness/cipxed/boots/"
+
+// bootRecord is the audit record of an iPXE script served to a hypervisor
+type bootRecord struct {
+	ID         string    `json:"id"`
+	Hypervisor string    `json:"hypervisor"`
+	IP         string    `json:"ip"`
+	Remote     string    `json:"remote"`
+	RequestID  string    `json:"request_id"`
+	Time       time.Time `json:"time"`
+	lochness.Boot
+}
+
+// newBootRecord creates a bootRecord for a boot starting now. IDs sort in the
+// order the boots were started.
+func newBootRecord(hypervisor *lochness.Hypervisor, boot lochness.Boot) *bootRecord {
+	now := time.Now()
+	return &bootRecord{
+		ID:         fmt.Sprintf("%020d", now.UnixNano()),
+		Hypervisor: hypervisor.ID,
+		IP:         hypervisor.IP.String(),
+		Time:       now.UTC(),
+		Boot:       boot,
+	}
+}
+
+// history stores bootRecords for each hypervisor in the kv, keeping only the
+// newest retain records of each
+type history struct {
+	mu     sync.Mutex
+	kv     kv.KV
+	retain int
+}
+
+// newHistory creates a history. A retain of 0 disables recording.
+func newHistory(k kv.KV, retain int) *history {
+	return &history{
+		kv:     k,
+		retain: retain,
+	}
+}
+
+// hypervisorBootsPath is a helper for generating the prefix of a hypervisor's
+// records
+func hypervisorBootsPath(hypervisorID string) string {
+	return path.Join(bootsPath, hypervisorID)
+}
+
+// Record saves a boot and drops the oldest records of the hypervisor beyond
+// the retention limit
+func (h *history) Record(r *bootRecord) error {
+	if h == nil || h.retain <= 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	// hypervisors may boot concurrently, keep pruning from racing itself
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.kv.Set(path.Join(hypervisorBootsPath(r.Hypervisor), r.ID), string(data)); err != nil {
+		return err
+	}
+	return h.prune(r.Hypervisor)
+}
+
+// prune deletes the oldest records of a hypervisor beyond the retention limit
+func (h *history) prune(hypervisorID string) error {
+	keys, err := h.kv.Keys(hypervisorBootsPath(hypervisorID))
+	if err != nil {
+		return err
+	}
+	if len(keys) <= h.retain {
+		return nil
+	}
+
+	sort.Strings(keys)
+	for _, key := range keys[:len(keys)-h.retain] {
+		if err := h.kv.Delete(key, false); err != nil && !h.kv.IsKeyNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// List returns the records of a hypervisor, oldest first
+func (h *history) List(hypervisorID string) ([]*bootRecord, error) {
+	values, err := h.kv.GetAll(hypervisorBootsPath(hypervisorID) + "/")
+	if err != nil {
+		if h.kv.IsKeyNotFound(err) {
+			return []*bootRecord{}, nil
+		}
+		return nil, err
+	}
+
+	boots := make([]*bootRecord, 0, len(values))
+	for _, value := range values {
+		r := &bootRecord{}
+		if err := json.Unmarshal(value.Data, r); err != nil {
+			return nil, err
+		}
+		boots = append(boots, r)
+	}
+	sort.Sort(byID(boots))
+	return boots, nil
+}
+
+// byID sorts bootRecords by ID, which is the order they were started
+type byID []*bootRecord
+
+func (b byID) Len() int           { return len(b) }
+func (b byID) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byID) Less(i, j int) bool { return b[i].ID < b[j].ID }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/bakins/net-http-recover"
+	"github.com/gorilla/context"
+	"github.com/gorilla/handlers"
+	"github.com/gorilla/mux"
+	"github.com/justinas/alice"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	"github.com/mistifyio/lochness/internal/server"
+)
+
+const (
+	ctxKey    string = "lochnessContext"
+	booterKey string = "booter"
+)
+
+// Run starts the server. The boot images in images, if set, are served under
+// /images/.
+func Run(port uint, ctx *lochness.Context, b *booter, images string, reqLog httpmw.Config) *server.Server {
+	router := mux.NewRouter()
+	router.StrictSlash(true)
+
+	// Common middleware applied to every request
+	reqLog.Name = "cipxed"
+	commonMiddleware := alice.New(
+		httpmw.RequestID,
+		httpmw.Negotiate,
+		httpmw.Logger(reqLog),
+		handlers.CompressHandler,
+		func(h http.Handler) http.Handler {
+			return recovery.Handler(os.Stderr, h, true)
+		},
+		func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				context.Set(r, ctxKey, ctx)
+				context.Set(r, booterKey, b)
+				h.ServeHTTP(w, r)
+			})
+		},
+	)
+
+	// NOTE: Due to weirdness with PrefixPath and StrictSlash, can't just pass
+	// a prefixed subrouter to the register functions and have the base path
+	// work cleanly. The register functions need to add a base path handler to
+	// the main router before setting subhandlers on either main or subrouter
+
+	RegisterIPXERoutes(router)
+	if images != "" {
+		router.PathPrefix("/images/").Handler(http.StripPrefix("/images/", http.FileServer(http.Dir(images))))
+	}
+
+	srv := server.New(fmt.Sprintf(":%d", port), commonMiddleware.Then(router), nil)
+	srv.Start()
+	return srv
+}
+
+// SetContext sets a lochness.Context value for a request
+func SetContext(r *http.Request, ctx *lochness.Context) {
+	context.Set(r, ctxKey, ctx)
+}
+
+// GetContext retrieves a lochness.Context value for a request
+func GetContext(r *http.Request) *lochness.Context {
+	if value := context.Get(r, ctxKey); value != nil {
+		return value.(*lochness.Context)
+	}
+	return nil
+}
+
+// getBooter retrieves the booter for a request
+func getBooter(r *http.Request) *booter {
+	if value := context.Get(r, booterKey); value != nil {
+		return value.(*booter)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	"github.com/pborman/uuid"
+)
+
+// booter generates the iPXE scripts of hypervisors and records which
+// hypervisor booted what
+type booter struct {
+	// defaults are booted by hypervisors without boot config of their own or
+	// of the cluster
+	defaults lochness.Boot
+	history  *history
+}
+
+// RegisterIPXERoutes registers the iPXE and boot history routes and handlers
+func RegisterIPXERoutes(router *mux.Router) {
+	router.HandleFunc("/ipxe/{ip}", GetIPXE).Methods("GET")
+	router.HandleFunc("/boots/{hypervisorID}", ListBoots).Methods("GET")
+}
+
+// GetIPXE generates the iPXE script of the hypervisor with an ip and records
+// the boot
+func GetIPXE(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	ctx := GetContext(r)
+	b := getBooter(r)
+
+	ip := net.ParseIP(mux.Vars(r)["ip"])
+	if ip == nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_ip", "invalid ip")
+		return
+	}
+
+	hypervisor, err := ctx.FirstHypervisor(func(h *lochness.Hypervisor) bool {
+		return ip.Equal(h.IP)
+	})
+	if err != nil {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	if hypervisor == nil {
+		hr.JSONErrorMsg(http.StatusNotFound, "hypervisor_not_found", "hypervisor not found")
+		return
+	}
+
+	boot, err := hypervisor.Boot(b.defaults)
+	if err != nil {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	script, err := boot.Script(hypervisor)
+	if err != nil {
+		hr.JSONError(http.StatusInternalServerError, httpmw.NewAPIError("invalid_template", err.Error()))
+		return
+	}
+
+	record := newBootRecord(hypervisor, boot)
+	record.Remote = r.RemoteAddr
+	record.RequestID = hr.RequestID()
+	fields := log.Fields{
+		"request_id":      record.RequestID,
+		"hypervisor":      hypervisor.ID,
+		"remote":          record.Remote,
+		"version":         boot.Version,
+		"kernel":          boot.KernelURL,
+		"initrd":          boot.InitrdURL,
+		"template_source": boot.TemplateSource,
+	}
+	// a hypervisor booting is more important than the record of it
+	if err := b.history.Record(record); err != nil {
+		fields["error"] = err
+		log.WithFields(fields).Error("failed to record boot")
+	} else {
+		log.WithFields(fields).Info("hypervisor booting")
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(script)
+}
+
+// ListBoots gets the recorded boots of a hypervisor, oldest first
+func ListBoots(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	b := getBooter(r)
+
+	hypervisorID := mux.Vars(r)["hypervisorID"]
+	if uuid.Parse(hypervisorID) == nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_hypervisor_id", "invalid hypervisor id")
+		return
+	}
+
+	boots, err := b.history.List(hypervisorID)
+	if err != nil {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	hr.JSON(http.StatusOK, boots)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"text/template"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/config"
+	"github.com/mistifyio/lochness/internal/dhcp"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	"github.com/mistifyio/lochness/internal/logging"
+	"github.com/mistifyio/lochness/internal/server"
+	"github.com/mistifyio/lochness/pkg/kv"
+	_ "github.com/mistifyio/lochness/pkg/kv/bolt"
+	_ "github.com/mistifyio/lochness/pkg/kv/consul"
+	"github.com/mistifyio/lochness/pkg/tftp"
+	logx "github.com/mistifyio/mistify-logrus-ext"
+	flag "github.com/ogier/pflag"
+)
+
+const defaultKVAddr = "http://localhost:4001"
+
+func main() {
+	var port uint
+	var kvAddr, kvPrefix, logLevel, otlpEndpoint string
+	var slowRequest, kvTimeout time.Duration
+	var kvRetries, retain int
+	var images, templateFile, tftpAddr, tftpRoot string
+	var defaults lochness.Boot
+
+	flag.UintVarP(&port, "port", "p", 8888, "listen port")
+	flag.StringVarP(&kvAddr, "kv", "k", defaultKVAddr, "address of kv machine")
+	flag.StringVar(&kvPrefix, "kv-prefix", kv.DefaultPrefix, "root of the lochness keys in the kv, to share a kv cluster between lochness clusters")
+	flag.DurationVar(&kvTimeout, "kv-timeout", 5*time.Second, "timeout of kv operations, after which requests fail with 503, 0 to disable")
+	flag.IntVar(&kvRetries, "kv-retries", 2, "number of times to retry failed kv operations, other than atomic updates")
+	var logCfg logging.Config
+	flag.StringVarP(&logLevel, "log-level", "l", "warn", "log level")
+	logging.AddFlags(flag.CommandLine, &logCfg)
+	flag.DurationVar(&slowRequest, "slow-request", time.Second, "latency above which requests are logged as slow, 0 to disable")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "", "OTLP/HTTP collector (host:port) to send request traces to")
+	flag.StringVarP(&defaults.Version, "version", "v", "0.1.0", "version booted by hypervisors without a version config")
+	flag.StringVar(&defaults.KernelURL, "kernel-url", "http://ipxe.services.lochness.local:8888/images/{{.Version}}/vmlinuz", "url template of the kernel booted by hypervisors without a kernel-url config")
+	flag.StringVar(&defaults.InitrdURL, "initrd-url", "http://ipxe.services.lochness.local:8888/images/{{.Version}}/initrd", "url template of the initrd booted by hypervisors without an initrd-url config")
+	flag.StringVarP(&defaults.KernelArgs, "kernel-args", "o", "", "kernel arguments of hypervisors without a kernel-args config")
+	flag.StringVar(&templateFile, "template", "", "file of the iPXE script template of hypervisors without an ipxe-template config, instead of the built in one")
+	flag.StringVarP(&images, "images", "i", "/var/lib/images", "directory of boot images to serve under /images/, blank not to serve any")
+	flag.StringVar(&tftpAddr, "tftp", ":69", "udp address to serve tftp on, blank not to")
+	flag.StringVar(&tftpRoot, "tftp-root", "/var/lib/tftpboot", "directory of files to serve over tftp, including "+dhcp.BootFile)
+	flag.IntVarP(&retain, "retain", "r", 20, "number of boots of each hypervisor to keep in boot history. 0 disables history")
+	configFile := flag.String(config.FlagName, "", config.FlagUsage)
+	flag.Parse()
+
+	if err := config.Load(config.Pflag(flag.CommandLine), "cipxed", *configFile); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "config.Load",
+			"file":  *configFile,
+		}).Fatal("failed to load config")
+	}
+
+	if err := logging.Setup(logLevel, "cipxed", logCfg); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"level": logLevel,
+		}).Fatal("failed to set up logging")
+	}
+
+	KV, err := kv.New(kvAddr)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"addr":  kvAddr,
+			"error": err,
+			"func":  "kv.New",
+		}).Fatal("unable to connect to kv")
+	}
+	KV = kv.WithPrefix(KV, kvPrefix)
+
+	if templateFile != "" {
+		data, err := ioutil.ReadFile(templateFile)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"func":  "ioutil.ReadFile",
+				"file":  templateFile,
+			}).Fatal("failed to read template")
+		}
+		if _, err := template.New("ipxe").Parse(string(data)); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"func":  "template.Parse",
+				"file":  templateFile,
+			}).Fatal("invalid template")
+		}
+		defaults.Template = string(data)
+		defaults.TemplateSource = templateFile
+	}
+
+	ctx := lochness.NewContext(KV).WithTimeout(kvTimeout).WithRetry(kvRetries, lochness.DefaultKVRetryWait)
+
+	reqLog := httpmw.Config{SlowThreshold: slowRequest}
+	if otlpEndpoint != "" {
+		shutdown, err := httpmw.SetupTracing("cipxed", otlpEndpoint)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":    err,
+				"func":     "httpmw.SetupTracing",
+				"endpoint": otlpEndpoint,
+			}).Fatal("failed to set up tracing")
+		}
+		defer logx.LogReturnedErr(shutdown, nil, "failed to shut down tracing")
+		reqLog.Trace = true
+	}
+
+	b := &booter{
+		defaults: defaults,
+		history:  newHistory(KV, retain),
+	}
+	srv := Run(port, ctx, b, images, reqLog)
+
+	if tftpAddr != "" {
+		tftpServer, err := runTFTP(tftpAddr, ctx, b, tftpRoot)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"func":  "runTFTP",
+				"addr":  tftpAddr,
+			}).Fatal("failed to serve tftp")
+		}
+		defer logx.LogReturnedErr(tftpServer.Close, nil, "failed to stop tftp server")
+	}
+
+	// Serve until SIGINT or SIGTERM, then drain in-flight requests
+	sigCtx, cancel := server.SignalContext()
+	defer cancel()
+	server.Wait(sigCtx, srv)
+}
+
+// runTFTP starts serving tftp on addr. The dhcpd config written by cdhcpd
+// sends hypervisors not yet running iPXE for dhcp.BootFile, so its absence
+// from root is warned of.
+func runTFTP(addr string, ctx *lochness.Context, b *booter, root string) (*tftp.Server, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(filepath.Join(root, dhcp.BootFile)); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"root":  root,
+			"file":  dhcp.BootFile,
+		}).Warn("tftp root is missing the boot file")
+	}
+
+	s := tftp.New(newTFTPFiles(ctx, b, root))
+	s.Log = logTransfer
+	go func() {
+		if err := s.Serve(conn); err != nil && err != tftp.ErrServerClosed {
+			log.WithFields(log.Fields{
+				"error": err,
+				"func":  "tftp.Serve",
+			}).Fatal("failed to serve tftp")
+		}
+	}()
+	return s, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness"
+)
+
+// The files served over tftp from the urls of the booting hypervisor's Boot
+const (
+	tftpKernel = "vmlinuz"
+	tftpInitrd = "initrd"
+)
+
+// tftpFiles opens the files read over tftp, from the tftp root, or for the
+// kernel and initrd, from the urls the hypervisor reading them boots
+type tftpFiles struct {
+	ctx    *lochness.Context
+	booter *booter
+	root   string
+	client *http.Client
+}
+
+// newTFTPFiles creates a tftpFiles serving root
+func newTFTPFiles(ctx *lochness.Context, b *booter, root string) *tftpFiles {
+	return &tftpFiles{
+		ctx:    ctx,
+		booter: b,
+		root:   root,
+		client: &http.Client{Timeout: time.Minute},
+	}
+}
+
+// Open opens a file read by the client at remote
+func (f *tftpFiles) Open(filename string, remote *net.UDPAddr) (io.ReadCloser, int64, error) {
+	// cleaning a rooted path keeps names from climbing out of the root
+	name := path.Clean("/" + filename)[1:]
+	switch name {
+	case tftpKernel, tftpInitrd:
+		return f.openBoot(name, remote.IP)
+	}
+
+	file, err := os.Open(filepath.Join(f.root, filepath.FromSlash(name)))
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, 0, err
+	}
+	if info.IsDir() {
+		_ = file.Close()
+		return nil, 0, os.ErrNotExist
+	}
+	return file, info.Size(), nil
+}
+
+// openBoot fetches the kernel or initrd booted by the hypervisor with the ip
+func (f *tftpFiles) openBoot(name string, ip net.IP) (io.ReadCloser, int64, error) {
+	hypervisor, err := f.ctx.FirstHypervisor(func(h *lochness.Hypervisor) bool {
+		return ip.Equal(h.IP)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if hypervisor == nil {
+		return nil, 0, os.ErrNotExist
+	}
+
+	boot, err := hypervisor.Boot(f.booter.defaults)
+	if err != nil {
+		return nil, 0, err
+	}
+	url := boot.KernelURL
+	if name == tftpInitrd {
+		url = boot.InitrdURL
+	}
+
+	resp, err := f.client.Get(url)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, 0, os.ErrNotExist
+		}
+		return nil, 0, fmt.Errorf("failed to fetch %s: %s", url, resp.Status)
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+// logTransfer logs the end of a tftp transfer
+func logTransfer(filename string, remote *net.UDPAddr, size int64, err error) {
+	fields := log.Fields{
+		"file":   filename,
+		"remote": remote.String(),
+		"size":   size,
+	}
+	if err != nil {
+		fields["error"] = err
+		log.WithFields(fields).Warn("tftp transfer failed")
+		return
+	}
+	log.WithFields(fields).Info("tftp transfer")
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/dhcp"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestTFTPFiles(t *testing.T) {
+	suite.Run(t, new(TFTPFilesSuite))
+}
+
+type TFTPFilesSuite struct {
+	common.Suite
+	Root   string
+	Images *httptest.Server
+	Files  *tftpFiles
+}
+
+func (s *TFTPFilesSuite) SetupTest() {
+	s.Suite.SetupTest()
+
+	var err error
+	s.Root, err = ioutil.TempDir("", "cipxed-tftp")
+	s.Require().NoError(err)
+	s.Require().NoError(ioutil.WriteFile(filepath.Join(s.Root, dhcp.BootFile), []byte("ipxe"), 0644))
+	s.Require().NoError(os.Mkdir(filepath.Join(s.Root, "pxelinux.cfg"), 0755))
+
+	s.Images = httptest.NewServer(http.StripPrefix("/images/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "0.1.0/initrd" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, r.URL.Path)
+	})))
+
+	s.Files = newTFTPFiles(s.Context, &booter{
+		defaults: lochness.Boot{
+			Version:   "0.1.0",
+			KernelURL: s.Images.URL + "/images/{{.Version}}/vmlinuz",
+			InitrdURL: s.Images.URL + "/images/{{.Version}}/initrd",
+		},
+	}, s.Root)
+}
+
+func (s *TFTPFilesSuite) TearDownTest() {
+	s.Images.Close()
+	_ = os.RemoveAll(s.Root)
+	s.Suite.TearDownTest()
+}
+
+// read opens a file as the client at ip and reads it
+func (s *TFTPFilesSuite) read(filename, ip string) (string, int64, error) {
+	file, size, err := s.Files.Open(filename, &net.UDPAddr{IP: net.ParseIP(ip), Port: 2000})
+	if err != nil {
+		return "", 0, err
+	}
+	defer func() { _ = file.Close() }()
+	data, err := ioutil.ReadAll(file)
+	s.Require().NoError(err)
+	return string(data), size, nil
+}
+
+func (s *TFTPFilesSuite) TestRoot() {
+	data, size, err := s.read(dhcp.BootFile, "10.0.0.1")
+	s.NoError(err)
+	s.Equal("ipxe", data)
+	s.EqualValues(4, size)
+
+	data, _, err = s.read("/../"+dhcp.BootFile, "10.0.0.1")
+	s.NoError(err, "names should not leave the root")
+	s.Equal("ipxe", data)
+
+	_, _, err = s.read("missing", "10.0.0.1")
+	s.True(os.IsNotExist(err))
+	_, _, err = s.read("pxelinux.cfg", "10.0.0.1")
+	s.True(os.IsNotExist(err), "directories should not be served")
+}
+
+func (s *TFTPFilesSuite) TestBoot() {
+	hypervisor := s.NewHypervisor()
+
+	data, _, err := s.read(tftpKernel, hypervisor.IP.String())
+	s.NoError(err)
+	s.Equal("0.1.0/vmlinuz", data)
+
+	_, _, err = s.read(tftpInitrd, hypervisor.IP.String())
+	s.True(os.IsNotExist(err), "missing images should not be found")
+
+	s.Require().NoError(hypervisor.SetConfig(lochness.VersionConfig, "0.2.0"))
+	data, _, err = s.read(tftpInitrd, hypervisor.IP.String())
+	s.NoError(err)
+	s.Equal("0.2.0/initrd", data)
+
+	_, _, err = s.read(tftpKernel, "10.0.0.1")
+	s.True(os.IsNotExist(err), "unknown hypervisors should not be served a kernel")
+}
@@ -0,0 +1,58 @@
+/*
+cjanitord is the janitor daemon. It periodically sweeps the kv for artifacts
+left behind by guests, hypervisors, and jobs that no longer exist, and removes
+them.
+
+Usage
+
+The following arguments are understood:
+
+	$ cjanitord -h
+	Usage of cjanitord:
+	-c, --clean="addresses,guest-lists,jobs,job-dependents,leases": comma separated kinds of orphans to clean
+	    --config-file="": yaml or toml file of flag settings, overridden by the environment and the command line
+	-n, --dry-run=false: only log the orphans that would be cleaned
+	-g, --grace=10m0s: how long an orphan must be found unchanged before it is cleaned
+	-p, --http=7548: http port to publish metrics. set to 0 to disable
+	-i, --interval=5m0s: how often to sweep for orphans
+	-a, --job-max-age=168h0m0s: how long finished jobs are kept. set to 0 to keep them forever
+	-k, --kv="http://127.0.0.1:4001": address of kv server
+	    --kv-prefix="/lochness": root of the lochness keys in the kv, to share a kv cluster between lochness clusters
+	-l, --log-level="warn": log level
+	    --log-max-backups=5: number of rotated log files kept
+	    --log-max-size=100: megabytes a log file grows to before it is rotated, 0 to never rotate it
+	    --log-output="stdout": where to log: stdout, stderr, syslog, journald, or the path of a file
+	-o, --once=false: sweep once, ignoring the grace period, and exit
+
+Orphans
+
+The kinds of orphans are:
+
+	addresses       subnet addresses reserved for guests that do not exist
+	guest-lists     hypervisor guest list entries of guests that do not exist
+	jobs            done or failed jobs that finished more than --job-max-age ago
+	job-dependents  dependents recorded of or on jobs that do not exist
+	leases          worker leases on jobs that do not exist, or on finished jobs
+	                once the lease has expired
+
+Leases on jobs that are still running are left to the reaper of cworkerd. The
+dependents recorded of or on a job are removed along with it.
+
+Policy
+
+An orphan is only cleaned once it has been found, with the same value, for
+--grace, so that a guest being created or deleted is not mistaken for the
+source of orphans. Orphans are removed only if they have not been changed since
+they were found. Removing a guest list entry bumps the generation of the
+hypervisor, if it exists. With --dry-run, orphans are logged instead of
+cleaned.
+
+lochness-fsck finds these and other inconsistencies on demand.
+
+Metrics
+
+The orphans.<kind>.found, orphans.<kind>.cleaned, and orphans.<kind>.failed
+metrics count the orphans past their grace period, those removed, and those
+that could not be removed.
+*/
+package main
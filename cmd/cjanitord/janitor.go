@@ -0,0 +1,408 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/armon/go-metrics"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/pkg/jobqueue"
+	"github.com/mistifyio/lochness/pkg/kv"
+)
+
+// Kinds of orphans, as reported and named in --clean
+const (
+	kindAddress   = "addresses"
+	kindGuestList = "guest-lists"
+	kindJob       = "jobs"
+	kindDependent = "job-dependents"
+	kindLease     = "leases"
+)
+
+// kinds are all the kinds of orphans, in the order they are cleaned
+var kinds = []string{kindAddress, kindGuestList, kindJob, kindDependent, kindLease}
+
+type (
+	// policy decides which orphans are cleaned
+	policy struct {
+		// Clean is the set of kinds of orphans to clean
+		Clean map[string]bool
+		// Grace is how long an orphan must stay unchanged before it is
+		// cleaned, so that changes in progress are not mistaken for orphans
+		Grace time.Duration
+		// JobMaxAge is how long finished jobs are kept
+		JobMaxAge time.Duration
+		// DryRun only reports the orphans that would be cleaned
+		DryRun bool
+	}
+
+	// orphan is an artifact left behind by something that no longer exists
+	orphan struct {
+		Kind   string
+		Key    string
+		Index  uint64 // of the value found, which is only removed unchanged
+		Reason string
+		// hypervisor whose guests changed if the orphan is removed
+		hypervisor string
+		// job whose dependents are removed along with it
+		job *jobqueue.Job
+	}
+
+	// sighting is when an orphan was first found with its current value
+	sighting struct {
+		index uint64
+		first time.Time
+	}
+
+	// janitor finds and cleans orphans in the kv
+	janitor struct {
+		kv     kv.KV
+		ctx    *lochness.Context
+		policy policy
+		m      *metrics.Metrics
+		seen   map[string]sighting
+	}
+)
+
+// parseKinds parses a comma separated list of kinds of orphans
+func parseKinds(list string) (map[string]bool, error) {
+	clean := make(map[string]bool)
+	for _, kind := range strings.Split(list, ",") {
+		kind = strings.TrimSpace(kind)
+		if kind == "" {
+			continue
+		}
+		known := false
+		for _, k := range kinds {
+			known = known || k == kind
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown kind %q, expected one of %s", kind, strings.Join(kinds, ","))
+		}
+		clean[kind] = true
+	}
+	return clean, nil
+}
+
+// newJanitor creates a janitor cleaning the kv of ctx according to p
+func newJanitor(k kv.KV, ctx *lochness.Context, p policy, m *metrics.Metrics) *janitor {
+	return &janitor{
+		kv:     k,
+		ctx:    ctx,
+		policy: p,
+		m:      m,
+		seen:   make(map[string]sighting),
+	}
+}
+
+// relParts splits a key returned by the kv into its parts below the lochness
+// root
+func relParts(key string) []string {
+	root := strings.Trim(kv.DefaultPrefix, "/")
+	return strings.Split(strings.TrimPrefix(strings.TrimPrefix(key, "/"), root+"/"), "/")
+}
+
+// getAll is kv.GetAll, returning no values for a missing prefix
+func (j *janitor) getAll(prefix string) (map[string]kv.Value, error) {
+	values, err := j.kv.GetAll(prefix)
+	if err != nil {
+		if j.kv.IsKeyNotFound(err) {
+			return map[string]kv.Value{}, nil
+		}
+		return nil, err
+	}
+	return values, nil
+}
+
+// scan returns the orphans of the kinds to clean, sorted by kind and key
+func (j *janitor) scan(now time.Time) ([]*orphan, error) {
+	guestValues, err := j.getAll(lochness.GuestPath)
+	if err != nil {
+		return nil, err
+	}
+	guests := make(map[string]bool)
+	for key := range guestValues {
+		if parts := relParts(key); len(parts) == 3 && parts[2] == "metadata" {
+			guests[parts[1]] = true
+		}
+	}
+
+	orphans := []*orphan{}
+
+	if j.policy.Clean[kindAddress] {
+		values, err := j.getAll(lochness.SubnetPath)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range values {
+			parts := relParts(key)
+			if len(parts) != 4 || parts[2] != "addresses" || guests[string(value.Data)] {
+				continue
+			}
+			orphans = append(orphans, &orphan{
+				Kind:   kindAddress,
+				Key:    key,
+				Index:  value.Index,
+				Reason: fmt.Sprintf("reserved for guest %q, which does not exist", string(value.Data)),
+			})
+		}
+	}
+
+	if j.policy.Clean[kindGuestList] {
+		values, err := j.getAll(lochness.HypervisorPath)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range values {
+			parts := relParts(key)
+			if len(parts) != 4 || parts[2] != "guests" || guests[parts[3]] {
+				continue
+			}
+			orphans = append(orphans, &orphan{
+				Kind:       kindGuestList,
+				Key:        key,
+				Index:      value.Index,
+				Reason:     fmt.Sprintf("lists guest %q, which does not exist", parts[3]),
+				hypervisor: parts[1],
+			})
+		}
+	}
+
+	if j.policy.Clean[kindJob] || j.policy.Clean[kindDependent] || j.policy.Clean[kindLease] {
+		jobOrphans, err := j.scanJobs(now)
+		if err != nil {
+			return nil, err
+		}
+		orphans = append(orphans, jobOrphans...)
+	}
+
+	rank := make(map[string]int, len(kinds))
+	for i, kind := range kinds {
+		rank[kind] = i
+	}
+	sort.Slice(orphans, func(a, b int) bool {
+		if orphans[a].Kind != orphans[b].Kind {
+			return rank[orphans[a].Kind] < rank[orphans[b].Kind]
+		}
+		return orphans[a].Key < orphans[b].Key
+	})
+	return orphans, nil
+}
+
+// scanJobs returns finished jobs older than the max age, dependents of or on
+// jobs that no longer exist, and leases on jobs that no longer exist, or that
+// have finished and whose lease has expired
+func (j *janitor) scanJobs(now time.Time) ([]*orphan, error) {
+	values, err := j.getAll(jobqueue.JobPath)
+	if err != nil {
+		return nil, err
+	}
+
+	orphans := []*orphan{}
+	jobs := make(map[string]*jobqueue.Job)
+	for key, value := range values {
+		parts := relParts(key)
+		// the locks of jobs are stored next to them
+		if len(parts) != 2 || strings.HasSuffix(parts[1], ".lock") {
+			continue
+		}
+		job := &jobqueue.Job{}
+		if err := json.Unmarshal(value.Data, job); err != nil {
+			return nil, fmt.Errorf("%s: %s", key, err)
+		}
+		jobs[parts[1]] = job
+
+		if !j.policy.Clean[kindJob] || j.policy.JobMaxAge == 0 || !finished(job) || job.FinishedAt.IsZero() {
+			continue
+		}
+		if age := now.Sub(job.FinishedAt); age > j.policy.JobMaxAge {
+			orphans = append(orphans, &orphan{
+				Kind:   kindJob,
+				Key:    key,
+				Index:  value.Index,
+				Reason: fmt.Sprintf("finished %s ago", age.Truncate(time.Second)),
+				job:    job,
+			})
+		}
+	}
+
+	if j.policy.Clean[kindDependent] {
+		values, err := j.getAll(jobqueue.JobDependentsPath)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range values {
+			// job-dependents/<dependency>/<dependent>
+			parts := relParts(key)
+			if len(parts) != 3 {
+				continue
+			}
+			reason := ""
+			switch {
+			case jobs[parts[1]] == nil:
+				reason = fmt.Sprintf("of job %q, which does not exist", parts[1])
+			case jobs[parts[2]] == nil:
+				reason = fmt.Sprintf("lists job %q, which does not exist", parts[2])
+			default:
+				continue
+			}
+			orphans = append(orphans, &orphan{
+				Kind:   kindDependent,
+				Key:    key,
+				Index:  value.Index,
+				Reason: reason,
+			})
+		}
+	}
+
+	if !j.policy.Clean[kindLease] {
+		return orphans, nil
+	}
+	values, err = j.getAll(jobqueue.LeasePath)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range values {
+		if len(relParts(key)) != 2 {
+			continue
+		}
+		lease := &jobqueue.Lease{}
+		if err := json.Unmarshal(value.Data, lease); err != nil {
+			return nil, fmt.Errorf("%s: %s", key, err)
+		}
+
+		job, ok := jobs[lease.JobID]
+		reason := fmt.Sprintf("claims job %q, which does not exist", lease.JobID)
+		if ok {
+			if !finished(job) || !now.After(lease.Expires) {
+				continue
+			}
+			reason = fmt.Sprintf("expired on job %q, which has finished", lease.JobID)
+		}
+		orphans = append(orphans, &orphan{
+			Kind:   kindLease,
+			Key:    key,
+			Index:  value.Index,
+			Reason: reason,
+		})
+	}
+	return orphans, nil
+}
+
+// finished returns whether a job is done or failed
+func finished(job *jobqueue.Job) bool {
+	return job.Status == jobqueue.JobStatusDone || job.Status == jobqueue.JobStatusError
+}
+
+// sweep scans the kv and cleans the orphans that have been found unchanged
+// for the grace period. It returns how many orphans were cleaned, or would
+// have been in a dry run.
+func (j *janitor) sweep(now time.Time) (int, error) {
+	orphans, err := j.scan(now)
+	if err != nil {
+		return 0, err
+	}
+
+	seen := make(map[string]sighting, len(orphans))
+	cleaned := 0
+	for _, o := range orphans {
+		s, ok := j.seen[o.Key]
+		if !ok || s.index != o.Index {
+			s = sighting{index: o.Index, first: now}
+		}
+		seen[o.Key] = s
+
+		fields := log.Fields{
+			"kind":   o.Kind,
+			"key":    o.Key,
+			"reason": o.Reason,
+		}
+		if now.Sub(s.first) < j.policy.Grace {
+			log.WithFields(fields).Debug("orphan found, waiting for the grace period")
+			continue
+		}
+		j.incr(o.Kind, "found")
+
+		if j.policy.DryRun {
+			log.WithFields(fields).Info("would clean orphan")
+			cleaned++
+			continue
+		}
+		if err := j.clean(o); err != nil {
+			fields["error"] = err
+			log.WithFields(fields).Error("failed to clean orphan")
+			j.incr(o.Kind, "failed")
+			continue
+		}
+		log.WithFields(fields).Info("cleaned orphan")
+		j.incr(o.Kind, "cleaned")
+		delete(seen, o.Key)
+		cleaned++
+	}
+	// orphans that are gone, or changed, are forgotten
+	j.seen = seen
+	return cleaned, nil
+}
+
+// clean removes an orphan, unless it changed since it was found
+func (j *janitor) clean(o *orphan) error {
+	if err := j.kv.Remove(o.Key, o.Index); err != nil {
+		if j.kv.IsKeyNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if o.job != nil {
+		// rather than waiting for them to be found as orphans
+		return jobqueue.RemoveDependents(j.kv, o.job)
+	}
+	if o.hypervisor == "" {
+		return nil
+	}
+
+	// let the hypervisor know its guests changed, if it still exists
+	h, err := j.ctx.Hypervisor(o.hypervisor)
+	if err != nil {
+		if j.ctx.IsKeyNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	_, err = h.BumpGeneration()
+	return err
+}
+
+// incr counts an orphan of kind
+func (j *janitor) incr(kind, name string) {
+	if j.m != nil {
+		j.m.IncrCounter([]string{"orphans", kind, name}, 1)
+	}
+}
+
+// run sweeps every interval, forever
+func (j *janitor) run(interval time.Duration) {
+	for {
+		j.sweepAndLog()
+		time.Sleep(interval)
+	}
+}
+
+// sweepAndLog sweeps and logs the outcome
+func (j *janitor) sweepAndLog() {
+	cleaned, err := j.sweep(time.Now())
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "janitor.sweep",
+		}).Error("failed to sweep for orphans")
+		return
+	}
+	log.WithFields(log.Fields{
+		"cleaned": cleaned,
+		"dryRun":  j.policy.DryRun,
+	}).Info("swept for orphans")
+}
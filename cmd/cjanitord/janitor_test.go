@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/mistifyio/lochness/pkg/jobqueue"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestJanitor(t *testing.T) {
+	suite.Run(t, new(JanitorSuite))
+}
+
+type JanitorSuite struct {
+	common.Suite
+}
+
+func (s *JanitorSuite) SetupSuite() {
+	log.SetLevel(log.FatalLevel)
+	s.Suite.SetupSuite()
+}
+
+func (s *JanitorSuite) janitor(p policy) *janitor {
+	if p.Clean == nil {
+		p.Clean, _ = parseKinds("addresses,guest-lists,jobs,job-dependents,leases")
+	}
+	return newJanitor(s.KV, s.Context, p, nil)
+}
+
+func (s *JanitorSuite) setJSON(key string, v interface{}) {
+	data, err := json.Marshal(v)
+	s.Require().NoError(err)
+	s.Require().NoError(s.KV.Set(s.PrefixKey(key), string(data)))
+}
+
+func (s *JanitorSuite) exists(key string) bool {
+	_, err := s.KV.Get(s.PrefixKey(key))
+	if err != nil {
+		s.Require().True(s.KV.IsKeyNotFound(err))
+		return false
+	}
+	return true
+}
+
+// orphans sets up one orphan of each kind, along with artifacts in use, and
+// returns the keys of the orphans in the order they are found, and the key of
+// a dependent removed along with the old job
+func (s *JanitorSuite) orphans(now time.Time) ([]string, string) {
+	hypervisor, guest := s.NewHypervisorWithGuest()
+	ghostID := uuid.New()
+
+	address := "subnets/" + guest.SubnetID + "/addresses/192.168.100.250"
+	s.Require().NoError(s.KV.Set(s.PrefixKey(address), ghostID))
+	listed := "hypervisors/" + hypervisor.ID + "/guests/" + ghostID
+	s.Require().NoError(s.KV.Set(s.PrefixKey(listed), ghostID))
+
+	recent := &jobqueue.Job{ID: uuid.New(), Action: "start", Guest: guest.ID, Status: jobqueue.JobStatusError, FinishedAt: now.Add(-time.Hour)}
+	old := &jobqueue.Job{ID: uuid.New(), Action: "start", Guest: guest.ID, Status: jobqueue.JobStatusDone, FinishedAt: now.Add(-48 * time.Hour), DependsOn: []string{recent.ID}}
+	running := &jobqueue.Job{ID: uuid.New(), Action: "start", Guest: guest.ID, Status: jobqueue.JobStatusWorking}
+	for _, job := range []*jobqueue.Job{old, recent, running} {
+		s.setJSON("jobs/"+job.ID, job)
+	}
+	s.Require().NoError(s.KV.Set(s.PrefixKey("jobs/"+running.ID+".lock"), "locked"))
+
+	missing := uuid.New()
+	dependent := "job-dependents/" + recent.ID + "/" + old.ID
+	s.Require().NoError(s.KV.Set(s.PrefixKey(dependent), old.ID))
+	noDependency := "job-dependents/" + missing + "/" + running.ID
+	s.Require().NoError(s.KV.Set(s.PrefixKey(noDependency), running.ID))
+
+	s.setJSON("leases/"+missing, &jobqueue.Lease{JobID: missing, Expires: now.Add(time.Minute)})
+	s.setJSON("leases/"+recent.ID, &jobqueue.Lease{JobID: recent.ID, Expires: now.Add(-time.Minute)})
+	s.setJSON("leases/"+running.ID, &jobqueue.Lease{JobID: running.ID, Expires: now.Add(-time.Minute)})
+
+	leases := []string{"leases/" + missing, "leases/" + recent.ID}
+	sort.Strings(leases)
+	return append([]string{address, listed, "jobs/" + old.ID, noDependency}, leases...), dependent
+}
+
+func (s *JanitorSuite) TestScan() {
+	now := time.Now()
+	expected, _ := s.orphans(now)
+
+	orphans, err := s.janitor(policy{JobMaxAge: 24 * time.Hour}).scan(now)
+	s.Require().NoError(err)
+	s.Require().Len(orphans, len(expected))
+	expectedKinds := []string{kindAddress, kindGuestList, kindJob, kindDependent, kindLease, kindLease}
+	for i, o := range orphans {
+		s.Equal(expectedKinds[i], o.Kind)
+		s.Equal(expected[i], strings.Join(relParts(o.Key), "/"))
+		s.NotEmpty(o.Reason)
+	}
+
+	clean, err := parseKinds("addresses")
+	s.Require().NoError(err)
+	orphans, err = s.janitor(policy{Clean: clean, JobMaxAge: 24 * time.Hour}).scan(now)
+	s.Require().NoError(err)
+	s.Len(orphans, 1)
+
+	orphans, err = s.janitor(policy{JobMaxAge: 0}).scan(now)
+	s.Require().NoError(err)
+	s.Len(orphans, len(expected)-1, "jobs should be kept without a max age")
+}
+
+func (s *JanitorSuite) TestSweep() {
+	now := time.Now()
+	expected, dependent := s.orphans(now)
+	j := s.janitor(policy{Grace: time.Minute, JobMaxAge: 24 * time.Hour})
+
+	cleaned, err := j.sweep(now)
+	s.Require().NoError(err)
+	s.Equal(0, cleaned, "orphans should wait for the grace period")
+
+	// an orphan that changes starts its grace period over
+	s.Require().NoError(s.KV.Set(s.PrefixKey(expected[0]), uuid.New()))
+	cleaned, err = j.sweep(now.Add(time.Minute))
+	s.Require().NoError(err)
+	s.Equal(len(expected)-1, cleaned)
+	s.True(s.exists(expected[0]))
+	for _, key := range expected[1:] {
+		s.False(s.exists(key), key)
+	}
+	s.False(s.exists(dependent), "dependents should be removed with their job")
+
+	cleaned, err = j.sweep(now.Add(2 * time.Minute))
+	s.Require().NoError(err)
+	s.Equal(1, cleaned)
+	s.False(s.exists(expected[0]))
+
+	cleaned, err = j.sweep(now.Add(3 * time.Minute))
+	s.Require().NoError(err)
+	s.Equal(0, cleaned)
+}
+
+func (s *JanitorSuite) TestSweepDryRun() {
+	now := time.Now()
+	expected, _ := s.orphans(now)
+	j := s.janitor(policy{DryRun: true, JobMaxAge: 24 * time.Hour})
+
+	cleaned, err := j.sweep(now)
+	s.Require().NoError(err)
+	s.Equal(len(expected), cleaned)
+	for _, key := range expected {
+		s.True(s.exists(key), key)
+	}
+}
+
+func (s *JanitorSuite) TestParseKinds() {
+	clean, err := parseKinds(" jobs,leases,")
+	s.NoError(err)
+	s.Equal(map[string]bool{"jobs": true, "leases": true}, clean)
+
+	_, err = parseKinds("jobs,guests")
+	s.Error(err)
+}
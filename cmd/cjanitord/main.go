@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	_ "expvar"
+	"fmt"
+	"net/http"
+	_ "net/http/pprof"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/armon/go-metrics"
+	"github.com/bakins/go-metrics-map"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/config"
+	"github.com/mistifyio/lochness/internal/logging"
+	"github.com/mistifyio/lochness/pkg/kv"
+	_ "github.com/mistifyio/lochness/pkg/kv/bolt"
+	_ "github.com/mistifyio/lochness/pkg/kv/consul"
+	flag "github.com/ogier/pflag"
+)
+
+func main() {
+	var port uint
+	var kvAddr, kvPrefix, logLevel, clean string
+	var interval time.Duration
+	var once bool
+	var p policy
+
+	var logCfg logging.Config
+	flag.StringVarP(&logLevel, "log-level", "l", "warn", "log level")
+	logging.AddFlags(flag.CommandLine, &logCfg)
+	flag.StringVarP(&kvAddr, "kv", "k", "http://127.0.0.1:4001", "address of kv server")
+	flag.StringVar(&kvPrefix, "kv-prefix", kv.DefaultPrefix, "root of the lochness keys in the kv, to share a kv cluster between lochness clusters")
+	flag.UintVarP(&port, "http", "p", 7548, "http port to publish metrics. set to 0 to disable")
+	flag.DurationVarP(&interval, "interval", "i", 5*time.Minute, "how often to sweep for orphans")
+	flag.StringVarP(&clean, "clean", "c", strings.Join(kinds, ","), "comma separated kinds of orphans to clean")
+	flag.DurationVarP(&p.Grace, "grace", "g", 10*time.Minute, "how long an orphan must be found unchanged before it is cleaned")
+	flag.DurationVarP(&p.JobMaxAge, "job-max-age", "a", 7*24*time.Hour, "how long finished jobs are kept. set to 0 to keep them forever")
+	flag.BoolVarP(&p.DryRun, "dry-run", "n", false, "only log the orphans that would be cleaned")
+	flag.BoolVarP(&once, "once", "o", false, "sweep once, ignoring the grace period, and exit")
+	configFile := flag.String(config.FlagName, "", config.FlagUsage)
+	flag.Parse()
+
+	if err := config.Load(config.Pflag(flag.CommandLine), "cjanitord", *configFile); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "config.Load",
+			"file":  *configFile,
+		}).Fatal("failed to load config")
+	}
+
+	// Set up logger
+	if err := logging.Setup(logLevel, "cjanitord", logCfg); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"level": logLevel,
+		}).Fatal("failed to set up logging")
+	}
+
+	var err error
+	p.Clean, err = parseKinds(clean)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"clean": clean,
+		}).Fatal("invalid kinds of orphans")
+	}
+	if interval <= 0 || p.Grace < 0 || p.JobMaxAge < 0 {
+		log.WithFields(log.Fields{
+			"interval":  interval,
+			"grace":     p.Grace,
+			"jobMaxAge": p.JobMaxAge,
+		}).Fatal("interval must be positive, and grace and job max age must not be negative")
+	}
+	if once {
+		p.Grace = 0
+	}
+
+	KV, err := kv.New(kvAddr)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"addr":  kvAddr,
+			"error": err,
+			"func":  "kv.New",
+		}).Fatal("unable to connect to kv")
+	}
+	KV = kv.WithPrefix(KV, kvPrefix)
+
+	// setup metrics
+	ms := mapsink.New()
+	conf := metrics.DefaultConfig("cjanitord")
+	conf.EnableHostname = false
+	m, _ := metrics.New(conf, ms)
+
+	if port != 0 && !once {
+		http.Handle("/metrics", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			if err := json.NewEncoder(w).Encode(ms); err != nil {
+				log.WithField("error", err).Error(err)
+			}
+		}))
+
+		go func() {
+			if err := http.ListenAndServe(fmt.Sprintf(":%d", port), nil); err != nil {
+				log.WithFields(log.Fields{
+					"error": err,
+				}).Fatal("error serving")
+			}
+		}()
+	}
+
+	j := newJanitor(KV, lochness.NewContext(KV), p, m)
+	if once {
+		if _, err := j.sweep(time.Now()); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"func":  "janitor.sweep",
+			}).Fatal("failed to sweep for orphans")
+		}
+		return
+	}
+	j.run(interval)
+}
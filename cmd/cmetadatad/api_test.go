@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	httpserver "github.com/mistifyio/lochness/internal/server"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestCMetadatadAPI(t *testing.T) {
+	suite.Run(t, new(APISuite))
+}
+
+type APISuite struct {
+	common.Suite
+	Port      uint
+	APIServer *httpserver.Server
+	APIURL    string
+	ARPTable  string
+	Guest     *lochness.Guest
+}
+
+func (s *APISuite) SetupSuite() {
+	s.Suite.SetupSuite()
+
+	log.SetLevel(log.FatalLevel)
+	s.Port = 51131
+	s.APIURL = fmt.Sprintf("http://127.0.0.1:%d", s.Port)
+
+	f, err := ioutil.TempFile("", "cmetadatad-arp-")
+	s.Require().NoError(err)
+	_ = f.Close()
+	s.ARPTable = f.Name()
+	arpTable = s.ARPTable
+
+	s.APIServer = Run(s.Port, s.Context, "example.com", httpmw.Config{})
+	time.Sleep(100 * time.Millisecond)
+}
+
+func (s *APISuite) SetupTest() {
+	s.Suite.SetupTest()
+	s.writeARP("")
+
+	s.Guest = s.NewGuest()
+	s.Guest.IP = net.ParseIP("127.0.0.1")
+	s.Guest.SubnetID = s.NewSubnet().ID
+	s.Guest.UserData = "#cloud-config\nhostname: foo\n"
+	s.Require().NoError(s.Guest.Save())
+}
+
+func (s *APISuite) TearDownSuite() {
+	stopChan := s.APIServer.StopChan()
+	s.APIServer.Stop(5 * time.Second)
+	<-stopChan
+
+	_ = os.Remove(s.ARPTable)
+	s.Suite.TearDownSuite()
+}
+
+func (s *APISuite) writeARP(entries string) {
+	header := "IP address       HW type     Flags       HW address            Mask     Device\n"
+	s.Require().NoError(ioutil.WriteFile(s.ARPTable, []byte(header+entries), 0644))
+}
+
+func (s *APISuite) get(path string) (int, string) {
+	resp, err := http.Get(s.APIURL + path)
+	s.Require().NoError(err)
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	s.Require().NoError(err)
+	return resp.StatusCode, string(body)
+}
+
+func (s *APISuite) TestMetaData() {
+	tests := []struct {
+		description  string
+		path         string
+		expectedCode int
+		expectedBody string
+	}{
+		{"index", "/latest/meta-data/", http.StatusOK,
+			"hostname\ninstance-id\nlocal-hostname\nlocal-ipv4\nmac\n"},
+		{"instance id", "/latest/meta-data/instance-id", http.StatusOK, s.Guest.ID},
+		{"hostname", "/latest/meta-data/hostname", http.StatusOK, s.Guest.ID + ".guests.example.com"},
+		{"local hostname", "/latest/meta-data/local-hostname", http.StatusOK, s.Guest.ID},
+		{"ip", "/latest/meta-data/local-ipv4", http.StatusOK, "127.0.0.1"},
+		{"mac", "/latest/meta-data/mac", http.StatusOK, s.Guest.MAC.String()},
+		{"unknown item", "/latest/meta-data/foo", http.StatusNotFound, ""},
+		{"user data", "/latest/user-data", http.StatusOK, s.Guest.UserData},
+		{"nocloud meta data", "/nocloud/meta-data", http.StatusOK,
+			fmt.Sprintf("instance-id: %s\nlocal-hostname: %s\n", s.Guest.ID, s.Guest.ID)},
+		{"nocloud user data", "/nocloud/user-data", http.StatusOK, s.Guest.UserData},
+		{"nocloud vendor data", "/nocloud/vendor-data", http.StatusOK, ""},
+	}
+
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		code, body := s.get(test.path)
+		s.Equal(test.expectedCode, code, msg("wrong status"))
+		if test.expectedCode == http.StatusOK {
+			s.Equal(test.expectedBody, body, msg("wrong body"))
+		}
+	}
+}
+
+func (s *APISuite) TestUserDataMissing() {
+	s.Guest.UserData = ""
+	s.Require().NoError(s.Guest.Save())
+
+	code, _ := s.get("/latest/user-data")
+	s.Equal(http.StatusNotFound, code)
+}
+
+func (s *APISuite) TestNetworkConfig() {
+	code, body := s.get("/latest/network-config")
+	s.Require().Equal(http.StatusOK, code)
+
+	var config networkConfig
+	s.Require().NoError(json.Unmarshal([]byte(body), &config))
+	s.Equal(1, config.Version)
+	s.Require().Len(config.Config, 1)
+	device := config.Config[0]
+	s.Equal(s.Guest.MAC.String(), device.MACAddress)
+	s.Require().Len(device.Subnets, 1)
+	s.Equal("static", device.Subnets[0].Type)
+	s.Equal("127.0.0.1", device.Subnets[0].Address)
+	s.Equal("255.255.255.0", device.Subnets[0].Netmask)
+
+	s.Guest.SubnetID = ""
+	s.Require().NoError(s.Guest.Save())
+	_, body = s.get("/nocloud/network-config")
+	s.Require().NoError(json.Unmarshal([]byte(body), &config))
+	s.Equal("dhcp", config.Config[0].Subnets[0].Type, "guest without a subnet should use dhcp")
+}
+
+func (s *APISuite) TestEncodedData() {
+	s.Guest.UserData = "I2Nsb3VkLWNvbmZpZwo="
+	s.Guest.VendorData = "I2Nsb3VkLWNvbmZpZwo="
+	s.Guest.DataEncoding = lochness.DataEncodingBase64
+	s.Require().NoError(s.Guest.Save())
+
+	for _, path := range []string{"/latest/user-data", "/nocloud/user-data", "/nocloud/vendor-data"} {
+		code, body := s.get(path)
+		s.Equal(http.StatusOK, code, path)
+		s.Equal("#cloud-config\n", body, path)
+	}
+}
+
+func (s *APISuite) TestUnknownGuest() {
+	s.Guest.IP = net.ParseIP("192.168.100.50")
+	s.Require().NoError(s.Guest.Save())
+
+	code, _ := s.get("/latest/meta-data/instance-id")
+	s.Equal(http.StatusNotFound, code)
+
+	// incomplete arp entries are ignored
+	s.writeARP("127.0.0.1        0x1         0x0         " + s.Guest.MAC.String() + "     *        br0\n")
+	code, _ = s.get("/latest/meta-data/instance-id")
+	s.Equal(http.StatusNotFound, code)
+}
+
+func (s *APISuite) TestGuestByMAC() {
+	s.Guest.IP = net.ParseIP("192.168.100.50")
+	s.Require().NoError(s.Guest.Save())
+	s.writeARP("127.0.0.1        0x1         0x2         " + s.Guest.MAC.String() + "     *        br0\n")
+
+	code, body := s.get("/latest/meta-data/instance-id")
+	s.Equal(http.StatusOK, code)
+	s.Equal(s.Guest.ID, body)
+}
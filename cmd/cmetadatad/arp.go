@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// arpTable is the kernel's IPv4 neighbour table
+var arpTable = "/proc/net/arp"
+
+// arpFlagComplete marks a resolved entry in the arp table
+const arpFlagComplete = 0x2
+
+// lookupMAC returns the hardware address of ip from the arp table, or nil if
+// it is not known
+func lookupMAC(ip net.IP) (net.HardwareAddr, error) {
+	f, err := os.Open(arpTable)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	// skip the header
+	scanner.Scan()
+	for scanner.Scan() {
+		// IP address, HW type, Flags, HW address, Mask, Device
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || !ip.Equal(net.ParseIP(fields[0])) {
+			continue
+		}
+		flags, err := strconv.ParseInt(fields[2], 0, 64)
+		if err != nil || flags&arpFlagComplete == 0 {
+			continue
+		}
+		return net.ParseMAC(fields[3])
+	}
+	return nil, scanner.Err()
+}
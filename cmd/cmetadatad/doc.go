@@ -0,0 +1,99 @@
+/*
+cmetadatad is the guest metadata service. It serves each guest its own
+metadata and user-data, so cloud-init and similar tools can configure the guest
+on first boot.
+
+Usage
+
+The following arguments are understood:
+
+	./cmetadatad -h
+	Usage of ./cmetadatad:
+	    --config-file="": yaml or toml file of flag settings, overridden by the environment and the command line
+	-d, --domain="": domain for lochness, guest hostnames are <id>.guests.<domain>
+	-k, --kv="http://localhost:4001": address of kv machine
+	    --kv-prefix="/lochness": root of the lochness keys in the kv, to share a kv cluster between lochness clusters
+	-l, --log-level="warn": log level
+	    --log-max-backups=5: number of rotated log files kept
+	    --log-max-size=100: megabytes a log file grows to before it is rotated, 0 to never rotate it
+	    --log-output="stdout": where to log: stdout, stderr, syslog, journald, or the path of a file
+	    --otlp-endpoint="": OTLP/HTTP collector (host:port) to send request traces to
+	-p, --port=8775: listen port
+	    --slow-request=1s: latency above which requests are logged as slow, 0 to disable
+
+Guests
+
+Requests are not authenticated. The guest making a request is found by its
+source address, falling back to the mac address of the source in the arp table
+of the host. Requests from unknown addresses get a 404.
+
+Guests expect the metadata service at 169.254.169.254:80, so cmetadatad is
+meant to run on every hypervisor with that address redirected to it, e.g.
+
+	iptables -t nat -A PREROUTING -d 169.254.169.254/32 -p tcp --dport 80 -j DNAT --to-destination <hypervisor ip>:8775
+
+The user-data and vendor-data of a guest are its user_data and vendor_data
+fields, set through the cguestd API, and decoded if data_encoding is base64.
+
+HTTP API endpoints
+
+	/latest/meta-data/
+		* GET - Retrieve the list of meta-data items
+
+	/latest/meta-data/{item}
+		* GET - Retrieve a meta-data item, one of hostname, instance-id,
+		local-hostname, local-ipv4, or mac
+
+	/latest/user-data
+		* GET - Retrieve the user-data, 404 if the guest has none
+
+	/latest/network-config
+		* GET - Retrieve the cloud-init network config (version 1)
+
+	/nocloud/meta-data
+	/nocloud/user-data
+	/nocloud/vendor-data
+	/nocloud/network-config
+		* GET - Retrieve the cloud-init NoCloud seed
+
+The NoCloud endpoints are used by booting the guest with
+
+	ds=nocloud-net;s=http://169.254.169.254/nocloud/
+
+on the kernel command line or in the SMBIOS serial number.
+
+Request Logging
+
+Every request is logged with its method, path, status, latency, response size,
+and request id. Requests slower than --slow-request are logged as warnings and
+tagged "slow". With --otlp-endpoint, a span is also emitted for every request
+through OpenTelemetry, continuing any W3C trace context sent by the client.
+
+Example Requests
+
+GET /latest/meta-data/
+
+	$ curl http://169.254.169.254/latest/meta-data/
+	hostname
+	instance-id
+	local-hostname
+	local-ipv4
+	mac
+
+GET /latest/meta-data/{item}
+
+	$ curl http://169.254.169.254/latest/meta-data/hostname
+	0e6f3b5c-4c5f-4bfb-9e0a-2f3b1a9c6d7e.guests.example.com
+
+GET /latest/network-config
+
+	$ curl http://169.254.169.254/latest/network-config
+	{"version":1,"config":[{"type":"physical","name":"eth0","mac_address":"01:23:45:67:89:ab","subnets":[{"type":"static","address":"10.10.10.10","netmask":"255.255.255.0","gateway":"10.10.10.1"}]}]}
+
+GET /nocloud/meta-data
+
+	$ curl http://169.254.169.254/nocloud/meta-data
+	instance-id: 0e6f3b5c-4c5f-4bfb-9e0a-2f3b1a9c6d7e
+	local-hostname: 0e6f3b5c-4c5f-4bfb-9e0a-2f3b1a9c6d7e
+*/
+package main
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/bakins/net-http-recover"
+	"github.com/gorilla/mux"
+	"github.com/justinas/alice"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	httpserver "github.com/mistifyio/lochness/internal/server"
+)
+
+// server serves the metadata of the guest making each request
+type server struct {
+	ctx    *lochness.Context
+	domain string
+}
+
+// Run starts the server
+func Run(port uint, ctx *lochness.Context, domain string, reqLog httpmw.Config) *httpserver.Server {
+	s := &server{
+		ctx:    ctx,
+		domain: domain,
+	}
+
+	router := mux.NewRouter()
+	router.StrictSlash(true)
+
+	// Common middleware applied to every request
+	reqLog.Name = "cmetadatad"
+	commonMiddleware := alice.New(
+		httpmw.RequestID,
+		httpmw.Logger(reqLog),
+		func(h http.Handler) http.Handler {
+			return recovery.Handler(os.Stderr, h, true)
+		},
+	)
+
+	// EC2 style
+	router.HandleFunc("/latest/meta-data/", s.guestHandler(s.metaDataIndex)).Methods("GET")
+	router.HandleFunc("/latest/meta-data/{item}", s.guestHandler(s.metaDataItem)).Methods("GET")
+	router.HandleFunc("/latest/user-data", s.guestHandler(s.userData)).Methods("GET")
+	router.HandleFunc("/latest/network-config", s.guestHandler(s.networkConfig)).Methods("GET")
+
+	// cloud-init NoCloud seed, for ds=nocloud-net;s=http://169.254.169.254/nocloud/
+	router.HandleFunc("/nocloud/meta-data", s.guestHandler(s.noCloudMetaData)).Methods("GET")
+	router.HandleFunc("/nocloud/user-data", s.guestHandler(s.userData)).Methods("GET")
+	router.HandleFunc("/nocloud/vendor-data", s.guestHandler(s.vendorData)).Methods("GET")
+	router.HandleFunc("/nocloud/network-config", s.guestHandler(s.networkConfig)).Methods("GET")
+
+	srv := httpserver.New(fmt.Sprintf(":%d", port), commonMiddleware.Then(router), nil)
+	srv.Start()
+	return srv
+}
+
+// guestHandler wraps a handler of guest metadata, looking up the guest
+// making the request
+func (s *server) guestHandler(h func(http.ResponseWriter, *http.Request, *lochness.Guest)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		guest, err := s.requestGuest(r)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":      err,
+				"remote":     r.RemoteAddr,
+				"request_id": w.Header().Get(httpmw.RequestIDHeader),
+			}).Error("failed to look up guest")
+			http.Error(w, "failed to look up guest", http.StatusInternalServerError)
+			return
+		}
+		if guest == nil {
+			http.Error(w, "guest not found", http.StatusNotFound)
+			return
+		}
+		h(w, r, guest)
+	}
+}
+
+// text writes a plain text response
+func text(w http.ResponseWriter, body string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte(body))
+}
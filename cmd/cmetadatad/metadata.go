@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/mistifyio/lochness"
+)
+
+// requestGuest finds the guest making a request by its source address. If no
+// guest has the address, the source mac address is looked up in the arp table
+// and matched against the guests instead. A nil guest is returned if none
+// matches.
+func (s *server) requestGuest(r *http.Request) (*lochness.Guest, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, nil
+	}
+
+	guest, err := s.ctx.FirstGuest(func(g *lochness.Guest) bool {
+		return ip.Equal(g.IP)
+	})
+	if err != nil || guest != nil {
+		return guest, err
+	}
+
+	mac, err := lookupMAC(ip)
+	if err != nil || mac == nil {
+		return nil, err
+	}
+	return s.ctx.FirstGuest(func(g *lochness.Guest) bool {
+		return g.MAC.String() == mac.String()
+	})
+}
+
+// hostname returns the fully qualified hostname of a guest, matching the
+// records cdhcpd serves
+func (s *server) hostname(g *lochness.Guest) string {
+	if s.domain == "" {
+		return g.ID
+	}
+	return g.ID + ".guests." + s.domain
+}
+
+// metaData returns the EC2 style meta-data items of a guest
+func (s *server) metaData(g *lochness.Guest) map[string]string {
+	items := map[string]string{
+		"instance-id":    g.ID,
+		"hostname":       s.hostname(g),
+		"local-hostname": g.ID,
+	}
+	if g.IP != nil {
+		items["local-ipv4"] = g.IP.String()
+	}
+	if g.MAC != nil {
+		items["mac"] = g.MAC.String()
+	}
+	return items
+}
+
+// metaDataIndex lists the available meta-data items
+func (s *server) metaDataIndex(w http.ResponseWriter, r *http.Request, g *lochness.Guest) {
+	items := s.metaData(g)
+	names := make([]string, 0, len(items))
+	for name := range items {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	text(w, strings.Join(names, "\n")+"\n")
+}
+
+func (s *server) metaDataItem(w http.ResponseWriter, r *http.Request, g *lochness.Guest) {
+	value, ok := s.metaData(g)[mux.Vars(r)["item"]]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	text(w, value)
+}
+
+// userData serves the decoded user-data of the guest. Like EC2, a guest
+// without user-data gets a 404.
+func (s *server) userData(w http.ResponseWriter, r *http.Request, g *lochness.Guest) {
+	data, err := g.UserDataBytes()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(data) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	text(w, string(data))
+}
+
+// vendorData serves the decoded vendor-data of the guest. It may be empty,
+// since the NoCloud datasource requires it.
+func (s *server) vendorData(w http.ResponseWriter, r *http.Request, g *lochness.Guest) {
+	data, err := g.VendorDataBytes()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	text(w, string(data))
+}
+
+// noCloudMetaData serves the meta-data of the cloud-init NoCloud datasource,
+// which is YAML
+func (s *server) noCloudMetaData(w http.ResponseWriter, r *http.Request, g *lochness.Guest) {
+	text(w, fmt.Sprintf("instance-id: %s\nlocal-hostname: %s\n", g.ID, g.ID))
+}
+
+type (
+	// networkConfig is a cloud-init network config, version 1
+	networkConfig struct {
+		Version int             `json:"version"`
+		Config  []networkDevice `json:"config"`
+	}
+
+	networkDevice struct {
+		Type       string          `json:"type"`
+		Name       string          `json:"name"`
+		MACAddress string          `json:"mac_address"`
+		Subnets    []networkSubnet `json:"subnets"`
+	}
+
+	networkSubnet struct {
+		Type    string `json:"type"`
+		Address string `json:"address,omitempty"`
+		Netmask string `json:"netmask,omitempty"`
+		Gateway string `json:"gateway,omitempty"`
+	}
+)
+
+// networkConfig serves the cloud-init network config of the guest. JSON is
+// valid YAML, so it is served as is. Guests without an address or subnet fall
+// back to dhcp.
+func (s *server) networkConfig(w http.ResponseWriter, r *http.Request, g *lochness.Guest) {
+	subnet := networkSubnet{Type: "dhcp"}
+	if g.IP != nil && g.SubnetID != "" {
+		sub, err := s.ctx.Subnet(g.SubnetID)
+		if err != nil && !s.ctx.IsKeyNotFound(err) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err == nil {
+			subnet = networkSubnet{
+				Type:    "static",
+				Address: g.IP.String(),
+				Netmask: net.IP(sub.CIDR.Mask).String(),
+			}
+			if sub.Gateway != nil {
+				subnet.Gateway = sub.Gateway.String()
+			}
+		}
+	}
+
+	config := networkConfig{
+		Version: 1,
+		Config: []networkDevice{{
+			Type:       "physical",
+			Name:       "eth0",
+			MACAddress: g.MAC.String(),
+			Subnets:    []networkSubnet{subnet},
+		}},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(config); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -8,9 +8,12 @@ import (
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	"github.com/mistifyio/lochness/internal/server"
 	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/mistifyio/lochness/pkg/jsonschema"
+	"github.com/pborman/uuid"
 	"github.com/stretchr/testify/suite"
-	"github.com/tylerb/graceful"
 )
 
 func TestCNetworkdAPI(t *testing.T) {
@@ -20,7 +23,7 @@ func TestCNetworkdAPI(t *testing.T) {
 type APISuite struct {
 	common.Suite
 	Port      uint
-	APIServer *graceful.Server
+	APIServer *server.Server
 	VLAN      *lochness.VLAN
 	VLANGroup *lochness.VLANGroup
 	APIURL    string
@@ -33,7 +36,7 @@ func (s *APISuite) SetupSuite() {
 	s.Port = 51123
 	s.APIURL = fmt.Sprintf("http://localhost:%d/vlans", s.Port)
 
-	s.APIServer = Run(s.Port, s.Context)
+	s.APIServer = Run(s.Port, s.Context, httpmw.Config{})
 	time.Sleep(100 * time.Millisecond)
 }
 
@@ -200,3 +203,86 @@ func (s *APISuite) TestSetVLANsForGroup() {
 	s.Equal(s.VLAN.Tag, s.VLANGroup.VLANs()[0])
 
 }
+
+func (s *APISuite) TestSubnetAddresses() {
+	_, guest := s.NewHypervisorWithGuest()
+
+	var addresses SubnetAddresses
+	s.DoRequest("GET", fmt.Sprintf("http://localhost:%d/subnets/%s/addresses", s.Port, guest.SubnetID), http.StatusOK, nil, &addresses)
+	s.Equal(guest.SubnetID, addresses.ID)
+	s.Equal(9, addresses.Total)
+	s.Equal(1, addresses.Allocated)
+	s.Equal(8, addresses.Available)
+	s.Equal(map[string]string{guest.IP.String(): guest.ID}, addresses.Addresses)
+
+	var errResp map[string]string
+	s.DoRequest("GET", fmt.Sprintf("http://localhost:%d/subnets/%s/addresses", s.Port, uuid.New()), http.StatusNotFound, nil, &errResp)
+	s.Equal("subnet_not_found", errResp["error"])
+	s.DoRequest("GET", fmt.Sprintf("http://localhost:%d/subnets/foobar/addresses", s.Port), http.StatusBadRequest, nil, &errResp)
+	s.Equal("invalid_subnet_id", errResp["error"])
+}
+
+func (s *APISuite) TestSchemas() {
+	url := fmt.Sprintf("http://localhost:%d/schemas", s.Port)
+	var entities []string
+	s.DoRequest("GET", url, http.StatusOK, nil, &entities)
+	s.Equal(lochness.SchemaEntities(), entities)
+
+	var schema jsonschema.Schema
+	s.DoRequest("GET", url+"/subnet", http.StatusOK, nil, &schema)
+	s.Equal(lochness.EntitySchema("subnet"), &schema)
+
+	var errResp map[string]interface{}
+	s.DoRequest("GET", url+"/foobar", http.StatusNotFound, nil, &errResp)
+	s.Equal("schema_not_found", errResp["error"])
+}
+
+func (s *APISuite) TestNetworkVLANPool() {
+	network := s.NewNetwork()
+	url := fmt.Sprintf("http://localhost:%d/networks/%s/vlans", s.Port, network.ID)
+
+	var pool lochness.VLANPool
+	s.DoRequest("POST", url, http.StatusOK, []lochness.VLANRange{{Start: 100, End: 109}}, &pool)
+	s.Equal([]lochness.VLANRange{{Start: 100, End: 109}}, pool.Ranges)
+	s.Equal(10, pool.Total)
+
+	s.Require().NoError(network.Refresh())
+	subnet := s.NewSubnet()
+	s.Require().NoError(network.AddSubnet(subnet))
+
+	s.DoRequest("GET", url, http.StatusOK, nil, &pool)
+	s.Equal(map[int]string{100: subnet.ID}, pool.Allocated)
+	s.Equal(9, pool.Available)
+
+	var errResp map[string]interface{}
+	s.DoRequest("DELETE", fmt.Sprintf("%s/tags/%d", s.APIURL, subnet.VLAN), http.StatusConflict, nil, &errResp)
+	s.Equal("vlan_allocated", errResp["error"])
+
+	s.DoRequest("POST", url, http.StatusBadRequest, []lochness.VLANRange{{Start: 100, End: 4095}}, &errResp)
+	s.Equal("validation_failed", errResp["error"])
+	s.DoRequest("GET", fmt.Sprintf("http://localhost:%d/networks/%s/vlans", s.Port, uuid.New()), http.StatusNotFound, nil, &errResp)
+	s.Equal("network_not_found", errResp["error"])
+}
+
+func (s *APISuite) TestFWProfiles() {
+	url := fmt.Sprintf("http://localhost:%d/fwprofiles", s.Port)
+	var profiles lochness.FWProfiles
+	s.DoRequest("GET", url, http.StatusOK, nil, &profiles)
+	s.Len(profiles, len(lochness.BuiltinFWProfiles()))
+
+	var fwgroup lochness.FWGroup
+	s.DoRequest("POST", url+"/web/acme", http.StatusCreated, nil, &fwgroup)
+	s.Equal("web", fwgroup.Profile.Name)
+	s.Len(fwgroup.Rules, 2)
+	var saved lochness.FWGroup
+	s.DoRequest("GET", url+"/web/acme", http.StatusOK, nil, &saved)
+	s.Equal(fwgroup.ID, saved.ID)
+
+	var errResp map[string]interface{}
+	s.DoRequest("POST", url+"/web/acme", http.StatusConflict, nil, &errResp)
+	s.Equal("fwprofile_instantiated", errResp["error"])
+	s.DoRequest("POST", url+"/foobar/acme", http.StatusNotFound, nil, &errResp)
+	s.Equal("fwprofile_not_found", errResp["error"])
+	s.DoRequest("GET", url+"/web/initech", http.StatusNotFound, nil, &errResp)
+	s.Equal("fwgroup_not_found", errResp["error"])
+}
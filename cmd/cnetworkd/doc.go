@@ -7,9 +7,18 @@ The following arguments are understood:
 
 	./cnetworkd -h
 	Usage of ./cnetworkd:
+	    --config-file="": yaml or toml file of flag settings, overridden by the environment and the command line
 	-k, --kv="http://localhost:4001": address of kv machine
+	    --kv-prefix="/lochness": root of the lochness keys in the kv, to share a kv cluster between lochness clusters
+	    --kv-retries=2: number of times to retry failed kv operations, other than atomic updates
+	    --kv-timeout=5s: timeout of kv operations, after which requests fail with 503, 0 to disable
 	-l, --log-level="warn": log level
+	    --log-max-backups=5: number of rotated log files kept
+	    --log-max-size=100: megabytes a log file grows to before it is rotated, 0 to never rotate it
+	    --log-output="stdout": where to log: stdout, stderr, syslog, journald, or the path of a file
+	    --otlp-endpoint="": OTLP/HTTP collector (host:port) to send request traces to
 	-p, --port=19000: listen port
+	    --slow-request=1s: latency above which requests are logged as slow, 0 to disable
 
 HTTP API endpoints
 
@@ -39,6 +48,85 @@ HTTP API endpoints
 		* GET - Retrieve a list of VLAN tags the VLAN group contains
 		* POST - Set the list of VLAN tags the VLAN group contains
 
+	/subnets/{subnetID}/addresses
+		* GET - Retrieve the allocated and available address counts of a subnet's range, and the guest of each allocated address
+
+	/networks/{networkID}/vlans
+		* GET - Retrieve the VLAN ranges of a network and the tags of them allocated to its subnets
+		* POST - Set the VLAN ranges of a network
+
+	/fwprofiles
+		* GET - Retrieve the built-in firewall profiles
+
+	/fwprofiles/{profile}/{tenant}
+		* GET - Retrieve the fwgroup instantiated from a firewall profile for a tenant
+		* POST - Instantiate a firewall profile for a tenant, creating its fwgroup
+
+	/schemas
+		* GET - Retrieve the names of the entities with JSON Schemas
+
+	/schemas/{entity}
+		* GET - Retrieve the JSON Schema (draft-07) of an entity, e.g. subnet, generated from its struct
+
+Versioning
+
+The endpoints are served under /v1, e.g. /v1/subnets/{subnetID}, and every response has an
+X-API-Version header listing the versions served. The unversioned paths above
+are deprecated aliases kept for existing tooling, whose responses have a
+Deprecation header, a Warning, and a Link to the versioned path. The cli tools
+use /v1 with servers that have it.
+
+Request Logging
+
+Every request is logged with its method, path, status, latency, response size,
+and request id. Requests slower than --slow-request are logged as warnings and
+tagged "slow". With --otlp-endpoint, a span is also emitted for every request
+through OpenTelemetry, continuing any W3C trace context sent by the client.
+
+Content Types
+
+Responses are JSON unless the Accept header prefers YAML, application/x-yaml,
+or msgpack, application/msgpack, which have the same fields. Request bodies may
+be any of the three, named by their Content-Type header, and are taken as JSON
+without one. Bodies of other types are rejected like malformed ones, with
+"invalid_json".
+
+Errors
+
+Every response has an X-Request-ID header, taken from the request if the client
+sent one and generated otherwise. Error responses carry a machine readable error
+code, e.g. "vlan_not_found" or "invalid_json", and the request id, which is also logged
+with the error. Objects failing validation are rejected with
+"validation_failed" and the names of the invalid fields. Requests whose kv
+operations time out, see --kv-timeout, fail with 503 and "kv_timeout".
+
+	{"message":"tag not found","error":"vlan_not_found","request_id":"0b2f6a8e-3c1d-4f5e-9a7b-1c2d3e4f5a6b"}
+
+	{"message":"invalid tag","code":400,"error":"validation_failed","fields":["tag"],"request_id":"0b2f6a8e-3c1d-4f5e-9a7b-1c2d3e4f5a6b","stack":[...]}
+
+VLAN Pools
+
+Networks may have ranges of VLAN tags, from which a free tag is allocated to
+each subnet added to the network that has none. A tag is free if no VLAN has it,
+so allocated tags never collide with each other or with VLANs added by hand.
+Allocated VLANs name their subnet, and can not be deleted through the API, but
+are released once the subnet leaves the network. Setting the ranges does not
+release tags already allocated outside them. Ranges must be within 1-4094 and
+must not overlap, else the request fails with 400 and "validation_failed". If
+no tag of the ranges is free, adding a subnet fails with a conflict.
+
+Firewall Profiles
+
+The built-in firewall profiles are fwgroups with ready made rules: "isolated"
+allows no incoming traffic, "web" allows http and https from anywhere, and
+"internal-only" allows tcp and udp from the guests in the same fwgroup. A
+profile is instantiated once per tenant, into a fwgroup whose "profile" names
+the profile, the tenant, and when it was created, and whose metadata has the
+tenant. Instantiating a profile again for the tenant fails with 409 and
+"fwprofile_instantiated". With the cluster config "default-fwprofile" set to a
+profile, guests created through cguestd without a fwgroup are put in that
+profile's fwgroup for their tenant, which is instantiated if need be.
+
 Example Structs
 
 VLAN tag - lochness.VLAN
@@ -83,6 +171,8 @@ DELETE /vlans/tags/{vlanTag}
 	$ curl -X DELETE http://localhost:19000/vlans/tags/123
 	{"tag":123,"description":"updated description"}
 
+Deleting a VLAN allocated to a subnet fails with 409 and "vlan_allocated".
+
 GET /vlans/tags/{vlanTag}/groups
 
 	$ curl -X GET http://localhost:19000/vlans/tags/219/groups
@@ -127,5 +217,30 @@ POST /vlans/tags/{vlanGroupID}/tags
 
 	$ curl -X POST http://localhost:19000/vlans/groups/122be0b1-d621-4bf5-8b6b-6d0ce41d7c11/tags --data-binary '[219]'
 	[219]
+
+GET /subnets/{subnetID}/addresses
+
+	$ curl http://localhost:19000/subnets/a3fd8c1e-62a4-4be1-b0d3-8d9a1fd1b5c2/addresses
+	{"id":"a3fd8c1e-62a4-4be1-b0d3-8d9a1fd1b5c2","cidr":"10.10.10.0/24","start":"10.10.10.10","end":"10.10.10.250","total":241,"allocated":2,"available":239,"addresses":{"10.10.10.23":"4d003c76-71d3-44ad-8518-3337273925ff","10.10.10.42":"c1f8ae0c-3d8b-4a52-9f1e-6f0a3c2d9b17"}}
+
+GET /networks/{networkID}/vlans
+
+	$ curl http://localhost:19000/networks/8e7b3d4c-47d6-4d1f-a3c7-0bb0fc7cf4a7/vlans
+	{"network":"8e7b3d4c-47d6-4d1f-a3c7-0bb0fc7cf4a7","vlan_ranges":[{"start":100,"end":199}],"total":100,"available":99,"allocated":{"100":"a3fd8c1e-62a4-4be1-b0d3-8d9a1fd1b5c2"}}
+
+POST /networks/{networkID}/vlans
+
+	$ curl -X POST http://localhost:19000/networks/8e7b3d4c-47d6-4d1f-a3c7-0bb0fc7cf4a7/vlans --data-binary '[{"start":100,"end":199},{"start":300,"end":309}]'
+	{"network":"8e7b3d4c-47d6-4d1f-a3c7-0bb0fc7cf4a7","vlan_ranges":[{"start":100,"end":199},{"start":300,"end":309}],"total":110,"available":109,"allocated":{"100":"a3fd8c1e-62a4-4be1-b0d3-8d9a1fd1b5c2"}}
+
+GET /fwprofiles
+
+	$ curl http://localhost:19000/fwprofiles
+	[{"name":"internal-only","description":"any tcp and udp traffic from the tenant's guests in the group"},{"name":"isolated","description":"no incoming traffic"},{"name":"web","description":"http and https from anywhere"}]
+
+POST /fwprofiles/{profile}/{tenant}
+
+	$ curl -X POST http://localhost:19000/fwprofiles/web/acme
+	{"id":"2c3f4b6e-0f56-4b4e-9a49-4c0f8e4f7a11","metadata":{"tenant":"acme"},"rules":[{"source":"0.0.0.0/0","portStart":80,"portEnd":80,"protocol":"tcp","action":"accept"},{"source":"0.0.0.0/0","portStart":443,"portEnd":443,"protocol":"tcp","action":"accept"}],"profile":{"name":"web","tenant":"acme","created":"2026-10-16T09:12:44Z"}}
 */
 package main
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+)
+
+// RegisterFWProfileRoutes registers the firewall profile routes and handlers
+func RegisterFWProfileRoutes(prefix string, router *mux.Router) {
+	router.HandleFunc(prefix, ListFWProfiles).Methods("GET")
+
+	sub := router.PathPrefix(prefix).Subrouter()
+	sub.HandleFunc("/{profile}/{tenant}", GetFWProfileGroup).Methods("GET")
+	sub.HandleFunc("/{profile}/{tenant}", InstantiateFWProfile).Methods("POST")
+}
+
+// ListFWProfiles gets the built-in firewall profiles
+func ListFWProfiles(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	hr.JSON(http.StatusOK, lochness.BuiltinFWProfiles())
+}
+
+// GetFWProfileGroup gets the fwgroup instantiated from a firewall profile for
+// a tenant
+func GetFWProfileGroup(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	vars := mux.Vars(r)
+	if _, err := lochness.BuiltinFWProfile(vars["profile"]); err != nil {
+		hr.JSONErrorMsg(http.StatusNotFound, "fwprofile_not_found", err.Error())
+		return
+	}
+
+	ctx := GetContext(r)
+	fwgroup, err := ctx.FWProfileGroup(vars["profile"], vars["tenant"])
+	if err != nil {
+		if ctx.IsKeyNotFound(err) {
+			hr.JSONErrorMsg(http.StatusNotFound, "fwgroup_not_found", "profile not instantiated for tenant")
+			return
+		}
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	hr.JSON(http.StatusOK, fwgroup)
+}
+
+// InstantiateFWProfile creates the fwgroup of a firewall profile for a tenant
+func InstantiateFWProfile(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	vars := mux.Vars(r)
+	fwgroup, err := GetContext(r).InstantiateFWProfile(vars["profile"], vars["tenant"])
+	if err != nil {
+		switch {
+		case lerrors.IsNotFound(err):
+			hr.JSONErrorMsg(http.StatusNotFound, "fwprofile_not_found", err.Error())
+		case lerrors.IsValidation(err):
+			hr.JSONError(http.StatusBadRequest, err)
+		case lerrors.IsConflict(err):
+			hr.JSONErrorMsg(http.StatusConflict, "fwprofile_instantiated", err.Error())
+		default:
+			hr.JSONError(http.StatusInternalServerError, err)
+		}
+		return
+	}
+	hr.JSON(http.StatusCreated, fwgroup)
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/json"
 	"net/http"
 	"strconv"
 
@@ -9,26 +8,27 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/httpmw"
 )
 
-func getVLANHelper(hr HTTPResponse, r *http.Request) (*lochness.VLAN, bool) {
+func getVLANHelper(hr *httpmw.Response, r *http.Request) (*lochness.VLAN, bool) {
 	ctx := GetContext(r)
 	vars := mux.Vars(r)
 	vt, ok := vars["vlanTag"]
 	if !ok {
-		hr.JSONMsg(http.StatusBadRequest, "missing vlan tag")
+		hr.JSONErrorMsg(http.StatusBadRequest, "missing_vlan_tag", "missing vlan tag")
 		return nil, false
 	}
 	vlanTag, err := strconv.Atoi(vt)
 	if err != nil {
-		hr.JSONMsg(http.StatusBadRequest, "invalid vlan tag")
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_vlan_tag", "invalid vlan tag")
 		return nil, false
 	}
 
 	vlan, err := ctx.VLAN(vlanTag)
 	if err != nil {
 		if ctx.IsKeyNotFound(err) {
-			hr.JSONMsg(http.StatusNotFound, "tag not found")
+			hr.JSONErrorMsg(http.StatusNotFound, "vlan_not_found", "tag not found")
 		} else {
 			hr.JSONError(http.StatusInternalServerError, err)
 		}
@@ -37,9 +37,9 @@ func getVLANHelper(hr HTTPResponse, r *http.Request) (*lochness.VLAN, bool) {
 	return vlan, true
 }
 
-func saveVLANHelper(hr HTTPResponse, vlan *lochness.VLAN) bool {
+func saveVLANHelper(hr *httpmw.Response, vlan *lochness.VLAN) bool {
 	if err := vlan.Validate(); err != nil {
-		hr.JSONMsg(http.StatusBadRequest, err.Error())
+		hr.JSONError(http.StatusBadRequest, err)
 		return false
 	}
 
@@ -56,29 +56,29 @@ func decodeVLAN(r *http.Request, vlan *lochness.VLAN) (*lochness.VLAN, error) {
 		vlan = ctx.NewVLAN()
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(vlan); err != nil {
+	if err := httpmw.Decode(r, vlan); err != nil {
 		return nil, err
 	}
 	return vlan, nil
 }
 
-func getVLANGroupHelper(hr HTTPResponse, r *http.Request) (*lochness.VLANGroup, bool) {
+func getVLANGroupHelper(hr *httpmw.Response, r *http.Request) (*lochness.VLANGroup, bool) {
 	ctx := GetContext(r)
 	vars := mux.Vars(r)
 	groupID, ok := vars["vlanGroupID"]
 	if !ok {
-		hr.JSONMsg(http.StatusBadRequest, "missing group id")
+		hr.JSONErrorMsg(http.StatusBadRequest, "missing_vlan_group_id", "missing group id")
 		return nil, false
 	}
 	if uuid.Parse(groupID) == nil {
-		hr.JSONMsg(http.StatusBadRequest, "invalid group id")
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_vlan_group_id", "invalid group id")
 		return nil, false
 	}
 
 	vlanGroup, err := ctx.VLANGroup(groupID)
 	if err != nil {
 		if ctx.IsKeyNotFound(err) {
-			hr.JSONMsg(http.StatusNotFound, "group not found")
+			hr.JSONErrorMsg(http.StatusNotFound, "vlan_group_not_found", "group not found")
 		} else {
 			hr.JSONError(http.StatusInternalServerError, err)
 		}
@@ -87,9 +87,9 @@ func getVLANGroupHelper(hr HTTPResponse, r *http.Request) (*lochness.VLANGroup,
 	return vlanGroup, true
 }
 
-func saveVLANGroupHelper(hr HTTPResponse, vlanGroup *lochness.VLANGroup) bool {
+func saveVLANGroupHelper(hr *httpmw.Response, vlanGroup *lochness.VLANGroup) bool {
 	if err := vlanGroup.Validate(); err != nil {
-		hr.JSONMsg(http.StatusBadRequest, err.Error())
+		hr.JSONError(http.StatusBadRequest, err)
 		return false
 	}
 
@@ -106,8 +106,58 @@ func decodeVLANGroup(r *http.Request, vlanGroup *lochness.VLANGroup) (*lochness.
 		vlanGroup = ctx.NewVLANGroup()
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(vlanGroup); err != nil {
+	if err := httpmw.Decode(r, vlanGroup); err != nil {
 		return nil, err
 	}
 	return vlanGroup, nil
 }
+
+func getSubnetHelper(hr *httpmw.Response, r *http.Request) (*lochness.Subnet, bool) {
+	ctx := GetContext(r)
+	vars := mux.Vars(r)
+	subnetID, ok := vars["subnetID"]
+	if !ok {
+		hr.JSONErrorMsg(http.StatusBadRequest, "missing_subnet_id", "missing subnet id")
+		return nil, false
+	}
+	if uuid.Parse(subnetID) == nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_subnet_id", "invalid subnet id")
+		return nil, false
+	}
+
+	subnet, err := ctx.Subnet(subnetID)
+	if err != nil {
+		if ctx.IsKeyNotFound(err) {
+			hr.JSONErrorMsg(http.StatusNotFound, "subnet_not_found", "subnet not found")
+		} else {
+			hr.JSONError(http.StatusInternalServerError, err)
+		}
+		return nil, false
+	}
+	return subnet, true
+}
+
+func getNetworkHelper(hr *httpmw.Response, r *http.Request) (*lochness.Network, bool) {
+	ctx := GetContext(r)
+	vars := mux.Vars(r)
+	networkID, ok := vars["networkID"]
+	if !ok {
+		hr.JSONErrorMsg(http.StatusBadRequest, "missing_network_id", "missing network id")
+		return nil, false
+	}
+	if uuid.Parse(networkID) == nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_network_id", "invalid network id")
+		return nil, false
+	}
+
+	network, err := ctx.Network(networkID)
+	if err != nil {
+		if ctx.IsKeyNotFound(err) {
+			hr.JSONErrorMsg(http.StatusNotFound, "network_not_found", "network not found")
+		} else {
+			hr.JSONError(http.StatusInternalServerError, err)
+		}
+		return nil, false
+	}
+	return network, true
+}
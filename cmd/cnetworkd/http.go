@@ -1,55 +1,34 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
-	"runtime"
-	"strings"
-	"time"
 
-	log "github.com/Sirupsen/logrus"
-	"github.com/bakins/logrus-middleware"
 	"github.com/bakins/net-http-recover"
 	"github.com/gorilla/context"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"github.com/justinas/alice"
 	"github.com/mistifyio/lochness"
-	"github.com/tylerb/graceful"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	"github.com/mistifyio/lochness/internal/server"
 )
 
 const ctxKey string = "lochnessContext"
 
-type (
-	// HTTPResponse is a wrapper for http.ResponseWriter which provides access
-	// to several convenience methods
-	HTTPResponse struct {
-		http.ResponseWriter
-	}
-
-	// HTTPError contains information for http error responses
-	HTTPError struct {
-		Message string   `json:"message"`
-		Code    int      `json:"code"`
-		Stack   []string `json:"stack"`
-	}
-)
-
 // Run starts the server
-func Run(port uint, ctx *lochness.Context) *graceful.Server {
+func Run(port uint, ctx *lochness.Context, reqLog httpmw.Config) *server.Server {
 	router := mux.NewRouter()
 	router.StrictSlash(true)
 
 	// Common middleware applied to every request
-	logrusMiddleware := logrusmiddleware.Middleware{
-		Name: "cnetworkd",
-	}
+	reqLog.Name = "cnetworkd"
 	commonMiddleware := alice.New(
-		func(h http.Handler) http.Handler {
-			return logrusMiddleware.Handler(h, "")
-		},
+		httpmw.RequestID,
+		httpmw.Negotiate,
+		httpmw.Logger(reqLog),
+		httpmw.Versioned,
 		handlers.CompressHandler,
 		func(h http.Handler) http.Handler {
 			return recovery.Handler(os.Stderr, h, true)
@@ -69,65 +48,14 @@ func Run(port uint, ctx *lochness.Context) *graceful.Server {
 
 	RegisterVLANRoutes("/vlans/tags", router)
 	RegisterVLANGroupRoutes("/vlans/groups", router)
-
-	server := &graceful.Server{
-		Timeout: 5 * time.Second,
-		Server: &http.Server{
-			Addr:           fmt.Sprintf(":%d", port),
-			Handler:        commonMiddleware.Then(router),
-			MaxHeaderBytes: 1 << 20,
-		},
-	}
-	go listenAndServe(server)
-	return server
-}
-
-func listenAndServe(server *graceful.Server) {
-	if err := server.ListenAndServe(); err != nil {
-		// Ignore the error from closing the listener, which is involved in the
-		// graceful shutdown
-		if !strings.Contains(err.Error(), "use of closed network connection") {
-			log.WithField("error", err).Fatal("server error")
-		}
-	}
-}
-
-// JSON writes appropriate headers and JSON body to the http response
-func (hr *HTTPResponse) JSON(code int, obj interface{}) {
-	hr.Header().Set("Content-Type", "application/json")
-	hr.WriteHeader(code)
-	encoder := json.NewEncoder(hr)
-	if err := encoder.Encode(obj); err != nil {
-		hr.JSONError(http.StatusInternalServerError, err)
-	}
-}
-
-// JSONError prepares an HTTPError with a stack trace and writes it with
-// HTTPResponse.JSON
-func (hr *HTTPResponse) JSONError(code int, err error) {
-	httpError := &HTTPError{
-		Message: err.Error(),
-		Code:    code,
-		Stack:   make([]string, 0, 4),
-	}
-	for i := 1; ; i++ { //
-		pc, file, line, ok := runtime.Caller(i)
-		if !ok {
-			break
-		}
-		// Print this much at least.  If we can't find the source, it won't show.
-		httpError.Stack = append(httpError.Stack, fmt.Sprintf("%s:%d (0x%x)", file, line, pc))
-	}
-	hr.JSON(code, httpError)
-}
-
-// JSONMsg is a convenience method to write a JSON response with just a message
-// string
-func (hr *HTTPResponse) JSONMsg(code int, msg string) {
-	msgObj := map[string]string{
-		"message": msg,
-	}
-	hr.JSON(code, msgObj)
+	RegisterSubnetRoutes("/subnets", router)
+	RegisterNetworkRoutes("/networks", router)
+	RegisterFWProfileRoutes("/fwprofiles", router)
+	RegisterSchemaRoutes("/schemas", router)
+
+	srv := server.New(fmt.Sprintf(":%d", port), commonMiddleware.Then(router), nil)
+	srv.Start()
+	return srv
 }
 
 // SetContext sets a lochness.Context value for a request
@@ -1,9 +1,16 @@
 package main
 
 import (
+	"time"
+
 	log "github.com/Sirupsen/logrus"
 	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/config"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	"github.com/mistifyio/lochness/internal/logging"
+	"github.com/mistifyio/lochness/internal/server"
 	"github.com/mistifyio/lochness/pkg/kv"
+	_ "github.com/mistifyio/lochness/pkg/kv/bolt"
 	_ "github.com/mistifyio/lochness/pkg/kv/consul"
 	logx "github.com/mistifyio/mistify-logrus-ext"
 	flag "github.com/ogier/pflag"
@@ -13,14 +20,32 @@ const defaultKVAddr = "http://localhost:4001"
 
 func main() {
 	var port uint
-	var kvAddr, logLevel string
+	var kvAddr, kvPrefix, logLevel, otlpEndpoint string
+	var slowRequest, kvTimeout time.Duration
+	var kvRetries int
 
 	flag.UintVarP(&port, "port", "p", 19000, "listen port")
 	flag.StringVarP(&kvAddr, "kv", "k", defaultKVAddr, "address of kv machine")
+	flag.StringVar(&kvPrefix, "kv-prefix", kv.DefaultPrefix, "root of the lochness keys in the kv, to share a kv cluster between lochness clusters")
+	flag.DurationVar(&kvTimeout, "kv-timeout", 5*time.Second, "timeout of kv operations, after which requests fail with 503, 0 to disable")
+	flag.IntVar(&kvRetries, "kv-retries", 2, "number of times to retry failed kv operations, other than atomic updates")
+	var logCfg logging.Config
 	flag.StringVarP(&logLevel, "log-level", "l", "warn", "log level")
+	logging.AddFlags(flag.CommandLine, &logCfg)
+	flag.DurationVar(&slowRequest, "slow-request", time.Second, "latency above which requests are logged as slow, 0 to disable")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "", "OTLP/HTTP collector (host:port) to send request traces to")
+	configFile := flag.String(config.FlagName, "", config.FlagUsage)
 	flag.Parse()
 
-	if err := logx.DefaultSetup(logLevel); err != nil {
+	if err := config.Load(config.Pflag(flag.CommandLine), "cnetworkd", *configFile); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "config.Load",
+			"file":  *configFile,
+		}).Fatal("failed to load config")
+	}
+
+	if err := logging.Setup(logLevel, "cnetworkd", logCfg); err != nil {
 		log.WithFields(log.Fields{
 			"error": err,
 			"level": logLevel,
@@ -35,10 +60,28 @@ func main() {
 			"func":  "kv.New",
 		}).Fatal("unable to connect to kv")
 	}
+	KV = kv.WithPrefix(KV, kvPrefix)
+
+	ctx := lochness.NewContext(KV).WithTimeout(kvTimeout).WithRetry(kvRetries, lochness.DefaultKVRetryWait)
+
+	reqLog := httpmw.Config{SlowThreshold: slowRequest}
+	if otlpEndpoint != "" {
+		shutdown, err := httpmw.SetupTracing("cnetworkd", otlpEndpoint)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":    err,
+				"func":     "httpmw.SetupTracing",
+				"endpoint": otlpEndpoint,
+			}).Fatal("failed to set up tracing")
+		}
+		defer logx.LogReturnedErr(shutdown, nil, "failed to shut down tracing")
+		reqLog.Trace = true
+	}
 
-	ctx := lochness.NewContext(KV)
+	srv := Run(port, ctx, reqLog)
 
-	server := Run(port, ctx)
-	// Block until the server is stopped
-	<-server.StopChan()
+	// Serve until SIGINT or SIGTERM, then drain in-flight requests
+	sigCtx, cancel := server.SignalContext()
+	defer cancel()
+	server.Wait(sigCtx, srv)
 }
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/httpmw"
+)
+
+// RegisterNetworkRoutes registers the network routes and handlers
+func RegisterNetworkRoutes(prefix string, router *mux.Router) {
+	sub := router.PathPrefix(prefix).Subrouter()
+	sub.HandleFunc("/{networkID}/vlans", GetNetworkVLANPool).Methods("GET")
+	sub.HandleFunc("/{networkID}/vlans", UpdateNetworkVLANPool).Methods("POST")
+}
+
+// GetNetworkVLANPool gets the VLAN ranges of a network, and the tags of them
+// allocated to its subnets
+func GetNetworkVLANPool(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	network, ok := getNetworkHelper(hr, r)
+	if !ok {
+		return
+	}
+	sendVLANPool(hr, network)
+}
+
+// UpdateNetworkVLANPool sets the VLAN ranges of a network. Tags already
+// allocated stay allocated, even if they are no longer in the ranges.
+func UpdateNetworkVLANPool(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	network, ok := getNetworkHelper(hr, r)
+	if !ok {
+		return
+	}
+	var ranges []lochness.VLANRange
+	if err := httpmw.Decode(r, &ranges); err != nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_json", err.Error())
+		return
+	}
+
+	network.VLANRanges = ranges
+	if err := network.Validate(); err != nil {
+		hr.JSONError(http.StatusBadRequest, err)
+		return
+	}
+	if err := network.Save(); err != nil {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	sendVLANPool(hr, network)
+}
+
+func sendVLANPool(hr *httpmw.Response, network *lochness.Network) {
+	pool, err := network.VLANPool()
+	if err != nil {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	hr.JSON(http.StatusOK, pool)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/httpmw"
+)
+
+// RegisterSchemaRoutes registers the routes serving the JSON Schemas of the
+// entities
+func RegisterSchemaRoutes(prefix string, router *mux.Router) {
+	router.HandleFunc(prefix, ListSchemas).Methods("GET")
+	sub := router.PathPrefix(prefix).Subrouter()
+	sub.HandleFunc("/{entity}", GetSchema).Methods("GET")
+}
+
+// ListSchemas gets the names of the entities with JSON Schemas
+func ListSchemas(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	hr.JSON(http.StatusOK, lochness.SchemaEntities())
+}
+
+// GetSchema gets the JSON Schema of an entity
+func GetSchema(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	schema := lochness.EntitySchema(mux.Vars(r)["entity"])
+	if schema == nil {
+		hr.JSONErrorMsg(http.StatusNotFound, "schema_not_found", "no schema for entity")
+		return
+	}
+	hr.JSON(http.StatusOK, schema)
+}
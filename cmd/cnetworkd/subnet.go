@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/mistifyio/lochness/internal/httpmw"
+)
+
+// SubnetAddresses is the allocation of a subnet's address range
+type SubnetAddresses struct {
+	ID        string            `json:"id"`
+	CIDR      string            `json:"cidr"`
+	Start     string            `json:"start"`
+	End       string            `json:"end"`
+	Total     int               `json:"total"`
+	Allocated int               `json:"allocated"`
+	Available int               `json:"available"`
+	Addresses map[string]string `json:"addresses"` // address to guest id
+}
+
+// RegisterSubnetRoutes registers the subnet routes and handlers
+func RegisterSubnetRoutes(prefix string, router *mux.Router) {
+	sub := router.PathPrefix(prefix).Subrouter()
+	sub.HandleFunc("/{subnetID}/addresses", GetSubnetAddresses).Methods("GET")
+}
+
+// GetSubnetAddresses gets the allocated and available address counts of a
+// subnet's range, and the guest each allocated address belongs to
+func GetSubnetAddresses(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	subnet, ok := getSubnetHelper(hr, r)
+	if !ok {
+		return
+	}
+
+	addresses := subnet.Addresses()
+	available := len(subnet.AvailableAddresses())
+	hr.JSON(http.StatusOK, &SubnetAddresses{
+		ID:        subnet.ID,
+		CIDR:      subnet.CIDR.String(),
+		Start:     subnet.StartRange.String(),
+		End:       subnet.EndRange.String(),
+		Total:     len(addresses) + available,
+		Allocated: len(addresses),
+		Available: available,
+		Addresses: addresses,
+	})
+}
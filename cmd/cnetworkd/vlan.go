@@ -1,11 +1,11 @@
 package main
 
 import (
-	"encoding/json"
 	"net/http"
 
 	"github.com/gorilla/mux"
 	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/httpmw"
 )
 
 // RegisterVLANRoutes registers the VLAN routes and handlers
@@ -23,7 +23,7 @@ func RegisterVLANRoutes(prefix string, router *mux.Router) {
 
 // ListVLANs gets a list of all VLANs
 func ListVLANs(w http.ResponseWriter, r *http.Request) {
-	hr := HTTPResponse{w}
+	hr := httpmw.NewResponse(w)
 	ctx := GetContext(r)
 	vlans := make(lochness.VLANs, 0)
 	err := ctx.ForEachVLAN(func(vlan *lochness.VLAN) error {
@@ -39,7 +39,7 @@ func ListVLANs(w http.ResponseWriter, r *http.Request) {
 
 // GetVLAN gets a particular VLAN
 func GetVLAN(w http.ResponseWriter, r *http.Request) {
-	hr := HTTPResponse{w}
+	hr := httpmw.NewResponse(w)
 	vlan, ok := getVLANHelper(hr, r)
 	if !ok {
 		return
@@ -49,12 +49,14 @@ func GetVLAN(w http.ResponseWriter, r *http.Request) {
 
 // CreateVLAN creates a new VLAN
 func CreateVLAN(w http.ResponseWriter, r *http.Request) {
-	hr := HTTPResponse{w}
+	hr := httpmw.NewResponse(w)
 	vlan, err := decodeVLAN(r, nil)
 	if err != nil {
-		hr.JSONMsg(http.StatusBadRequest, err.Error())
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_json", err.Error())
 		return
 	}
+	// only networks allocate VLANs to subnets
+	vlan.SubnetID = ""
 
 	if !saveVLANHelper(hr, vlan) {
 		return
@@ -64,22 +66,23 @@ func CreateVLAN(w http.ResponseWriter, r *http.Request) {
 
 // UpdateVLAN updates a VLAN
 func UpdateVLAN(w http.ResponseWriter, r *http.Request) {
-	hr := HTTPResponse{w}
+	hr := httpmw.NewResponse(w)
 	vlan, ok := getVLANHelper(hr, r)
 	if !ok {
 		return
 	}
 
-	vlanTag := vlan.Tag
+	vlanTag, subnetID := vlan.Tag, vlan.SubnetID
 
 	_, err := decodeVLAN(r, vlan)
 	if err != nil {
-		hr.JSONMsg(http.StatusBadRequest, err.Error())
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_json", err.Error())
 		return
 	}
 
-	// Don't allow tag redefinition
+	// Don't allow tag redefinition, or changing the subnet it is allocated to
 	vlan.Tag = vlanTag
+	vlan.SubnetID = subnetID
 
 	if !saveVLANHelper(hr, vlan) {
 		return
@@ -90,11 +93,15 @@ func UpdateVLAN(w http.ResponseWriter, r *http.Request) {
 
 // DestroyVLAN destroys a VLAN
 func DestroyVLAN(w http.ResponseWriter, r *http.Request) {
-	hr := HTTPResponse{w}
+	hr := httpmw.NewResponse(w)
 	vlan, ok := getVLANHelper(hr, r)
 	if !ok {
 		return
 	}
+	if vlan.SubnetID != "" {
+		hr.JSONErrorMsg(http.StatusConflict, "vlan_allocated", "vlan is allocated to subnet "+vlan.SubnetID)
+		return
+	}
 
 	if err := vlan.Destroy(); err != nil {
 		hr.JSONError(http.StatusInternalServerError, err)
@@ -105,7 +112,7 @@ func DestroyVLAN(w http.ResponseWriter, r *http.Request) {
 
 // GetVLANGroupMembership gets a VLAN's group membership
 func GetVLANGroupMembership(w http.ResponseWriter, r *http.Request) {
-	hr := HTTPResponse{w}
+	hr := httpmw.NewResponse(w)
 	vlan, ok := getVLANHelper(hr, r)
 	if !ok {
 		return
@@ -115,14 +122,14 @@ func GetVLANGroupMembership(w http.ResponseWriter, r *http.Request) {
 
 // UpdateVLANGroupMembership updates a VLAN's group membership
 func UpdateVLANGroupMembership(w http.ResponseWriter, r *http.Request) {
-	hr := HTTPResponse{w}
+	hr := httpmw.NewResponse(w)
 	vlan, ok := getVLANHelper(hr, r)
 	if !ok {
 		return
 	}
 	var newGroups []string
-	if err := json.NewDecoder(r.Body).Decode(&newGroups); err != nil {
-		hr.JSONMsg(http.StatusBadRequest, err.Error())
+	if err := httpmw.Decode(r, &newGroups); err != nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_json", err.Error())
 		return
 	}
 
@@ -143,9 +150,9 @@ func UpdateVLANGroupMembership(w http.ResponseWriter, r *http.Request) {
 		vlanGroup, err := ctx.VLANGroup(groupID)
 		if err != nil {
 			if ctx.IsKeyNotFound(err) {
-				hr.JSONMsg(http.StatusBadRequest, "group not found")
+				hr.JSONErrorMsg(http.StatusBadRequest, "vlan_group_not_found", "group not found")
 			} else {
-				hr.JSONMsg(http.StatusInternalServerError, err.Error())
+				hr.JSONError(http.StatusInternalServerError, err)
 			}
 			return
 		}
@@ -1,11 +1,11 @@
 package main
 
 import (
-	"encoding/json"
 	"net/http"
 
 	"github.com/gorilla/mux"
 	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/httpmw"
 )
 
 // RegisterVLANGroupRoutes registers the VLAN routes and handlers
@@ -23,7 +23,7 @@ func RegisterVLANGroupRoutes(prefix string, router *mux.Router) {
 
 // ListVLANGroups gets a list of all VLANGroups
 func ListVLANGroups(w http.ResponseWriter, r *http.Request) {
-	hr := HTTPResponse{w}
+	hr := httpmw.NewResponse(w)
 	ctx := GetContext(r)
 	vlanGroups := make(lochness.VLANGroups, 0)
 	err := ctx.ForEachVLANGroup(func(vlanGroup *lochness.VLANGroup) error {
@@ -39,7 +39,7 @@ func ListVLANGroups(w http.ResponseWriter, r *http.Request) {
 
 // GetVLANGroup gets a particular VLAN
 func GetVLANGroup(w http.ResponseWriter, r *http.Request) {
-	hr := HTTPResponse{w}
+	hr := httpmw.NewResponse(w)
 	vlanGroup, ok := getVLANGroupHelper(hr, r)
 	if !ok {
 		return
@@ -49,10 +49,10 @@ func GetVLANGroup(w http.ResponseWriter, r *http.Request) {
 
 // CreateVLANGroup creates a new VLAN
 func CreateVLANGroup(w http.ResponseWriter, r *http.Request) {
-	hr := HTTPResponse{w}
+	hr := httpmw.NewResponse(w)
 	vlanGroup, err := decodeVLANGroup(r, nil)
 	if err != nil {
-		hr.JSONMsg(http.StatusBadRequest, err.Error())
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_json", err.Error())
 		return
 	}
 
@@ -64,7 +64,7 @@ func CreateVLANGroup(w http.ResponseWriter, r *http.Request) {
 
 // UpdateVLANGroup updates a VLANGroup
 func UpdateVLANGroup(w http.ResponseWriter, r *http.Request) {
-	hr := HTTPResponse{w}
+	hr := httpmw.NewResponse(w)
 	vlanGroup, ok := getVLANGroupHelper(hr, r)
 	if !ok {
 		return
@@ -74,7 +74,7 @@ func UpdateVLANGroup(w http.ResponseWriter, r *http.Request) {
 
 	_, err := decodeVLANGroup(r, vlanGroup)
 	if err != nil {
-		hr.JSONMsg(http.StatusBadRequest, err.Error())
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_json", err.Error())
 		return
 	}
 
@@ -90,7 +90,7 @@ func UpdateVLANGroup(w http.ResponseWriter, r *http.Request) {
 
 // DestroyVLANGroup destroys a VLANGroup
 func DestroyVLANGroup(w http.ResponseWriter, r *http.Request) {
-	hr := HTTPResponse{w}
+	hr := httpmw.NewResponse(w)
 	vlanGroup, ok := getVLANGroupHelper(hr, r)
 	if !ok {
 		return
@@ -105,7 +105,7 @@ func DestroyVLANGroup(w http.ResponseWriter, r *http.Request) {
 
 // GetVLANGroupVLANs gets a VLANGroup's VLANs
 func GetVLANGroupVLANs(w http.ResponseWriter, r *http.Request) {
-	hr := HTTPResponse{w}
+	hr := httpmw.NewResponse(w)
 	vlanGroup, ok := getVLANGroupHelper(hr, r)
 	if !ok {
 		return
@@ -115,14 +115,14 @@ func GetVLANGroupVLANs(w http.ResponseWriter, r *http.Request) {
 
 // UpdateVLANGroupVLANs updates a VLANGroups's VLANs
 func UpdateVLANGroupVLANs(w http.ResponseWriter, r *http.Request) {
-	hr := HTTPResponse{w}
+	hr := httpmw.NewResponse(w)
 	vlanGroup, ok := getVLANGroupHelper(hr, r)
 	if !ok {
 		return
 	}
 	var newTags []int
-	if err := json.NewDecoder(r.Body).Decode(&newTags); err != nil {
-		hr.JSONMsg(http.StatusBadRequest, err.Error())
+	if err := httpmw.Decode(r, &newTags); err != nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_json", err.Error())
 		return
 	}
 
@@ -144,9 +144,9 @@ func UpdateVLANGroupVLANs(w http.ResponseWriter, r *http.Request) {
 		vlan, err := ctx.VLAN(tag)
 		if err != nil {
 			if ctx.IsKeyNotFound(err) {
-				hr.JSONMsg(http.StatusBadRequest, "vlan not found")
+				hr.JSONErrorMsg(http.StatusBadRequest, "vlan_not_found", "vlan not found")
 			} else {
-				hr.JSONMsg(http.StatusInternalServerError, err.Error())
+				hr.JSONError(http.StatusInternalServerError, err)
 			}
 			return
 		}
@@ -11,9 +11,54 @@ The following arguments are understood:
 	$ cplacerd -h
 	Usage of cplacerd:
 	-b, --beanstalk="127.0.0.1:11300": address of beanstalkd server
+	    --config-file="": yaml or toml file of flag settings, overridden by the environment and the command line
 	-k, --kv="http://127.0.0.1:4001": address of kv server
+	    --kv-prefix="/lochness": root of the lochness keys in the kv, to share a kv cluster between lochness clusters
 	-p, --http=7543: address for http interface. set to 0 to disable
 	-l, --log-level="warn": log level
+	    --log-max-backups=5: number of rotated log files kept
+	    --log-max-size=100: megabytes a log file grows to before it is rotated, 0 to never rotate it
+	    --log-output="stdout": where to log: stdout, stderr, syslog, journald, or the path of a file
+	    --otlp-endpoint="": OTLP/HTTP collector (host:port) to send job traces to
+
+Hypervisors that are alive, not in maintenance, have a subnet of the guest's
+network, meet the guest's "constraints" expression, if any, see cguestd, and have
+the resources for the guest are candidates. Candidates are ordered by the health
+score of their recent heartbeats, so hypervisors that have been flapping are
+only used when no steadier one is available.
+
+Guests with an "anti_affinity" group, see cguestd, are spread across failure
+domains: candidates in the domains holding the fewest guests of the group come
+first, ahead of their health. The domains are the racks of the hypervisors'
+"failure_domain", or their rows or zones with the cluster's
+"failure-domain-spread" config, and a hypervisor with none is a domain of its
+own.
+
+	$ etcdctl set /lochness/config/failure-domain-spread row
+
+The job of a placed guest is handed to the workers in the job queue of its
+hypervisor, see cworkerd.
+
+Every log entry of a placement has the "trace_id" of its job, see cworkerd.
+With --otlp-endpoint, each placement is a span continuing the span of the
+request that queued the job.
+
+Clones of a guest, see cguestd, are only placed on the hypervisor of the guest
+they are cloned from, which holds the disks to clone.
+
+The resources a hypervisor has for guests are those nheartbeatd last reported
+available, which take the overcommit ratios of the hypervisor's config, or the
+cluster's, into account. The "cpu-overcommit" ratio lets guests' vcpus add up
+to that multiple of the hypervisor's cpus; without it, cpus are not allocated,
+and only guests with more vcpus than the hypervisor has cpus are rejected. The
+"memory-overcommit" ratio lets guests' memory add up to that multiple of the
+hypervisor's memory, 1 without it. Disk is never overcommitted.
+
+	$ etcdctl set /lochness/config/cpu-overcommit 4
+	$ etcdctl set /lochness/config/memory-overcommit 1.5
+
+When no hypervisor has the resources for a guest, the job fails with the reason
+each was rejected.
 
 Only one instance should be run per cluster, typically ensured by running it via `lock`.
 
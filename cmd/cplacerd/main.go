@@ -1,59 +1,51 @@
 package main
 
-// TODO: multiple beanstalkd servers
-
 import (
 	"encoding/json"
 	_ "expvar"
 	"fmt"
 	"net/http"
 	_ "net/http/pprof"
-	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/armon/go-metrics"
 	"github.com/bakins/go-metrics-map"
-	"github.com/kr/beanstalk"
-	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/config"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	"github.com/mistifyio/lochness/internal/logging"
+	"github.com/mistifyio/lochness/internal/placer"
 	"github.com/mistifyio/lochness/pkg/jobqueue"
 	"github.com/mistifyio/lochness/pkg/kv"
+	_ "github.com/mistifyio/lochness/pkg/kv/bolt"
 	_ "github.com/mistifyio/lochness/pkg/kv/consul"
-	logx "github.com/mistifyio/mistify-logrus-ext"
 	flag "github.com/ogier/pflag"
 )
 
-// TaskFunc is a convenience wrapper for function calls on tasks
-type TaskFunc struct {
-	function func(*jobqueue.Client, *jobqueue.Task) (bool, error)
-	label    string // label for metrics
-}
-
-// XXX: we want to try to keep track of where a job is
-// in this pipeline? would have to persist in the job
-var steps = []TaskFunc{
-	{function: checkJobStatus, label: "checkJobStatus"},
-	{function: checkGuestStatus, label: "checkGuestStatus"},
-	{function: selectHypervisor, label: "selectHypervisor"},
-	{function: changeJobAction, label: "changeJobAction"},
-	{function: addJobToWorker, label: "addJobToWorker"},
-	{function: deleteTask, label: "deleteTask"},
-}
-
-// TODO: restructure this as all the deletes for tube stuff is clunky.
-// as we almost always delete the tube id, wrap in function and delete it?
-
 func main() {
 	var port uint
-	var kvAddr, bstalk, logLevel string
+	var kvAddr, kvPrefix, bstalk, logLevel, otlpEndpoint string
 
 	flag.StringVarP(&bstalk, "beanstalk", "b", "127.0.0.1:11300", "address of beanstalkd server")
+	var logCfg logging.Config
 	flag.StringVarP(&logLevel, "log-level", "l", "warn", "log level")
+	logging.AddFlags(flag.CommandLine, &logCfg)
 	flag.StringVarP(&kvAddr, "kv", "k", "http://127.0.0.1:4001", "address of kv server")
+	flag.StringVar(&kvPrefix, "kv-prefix", kv.DefaultPrefix, "root of the lochness keys in the kv, to share a kv cluster between lochness clusters")
 	flag.UintVarP(&port, "http", "p", 7543, "address for http interface. set to 0 to disable")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "", "OTLP/HTTP collector (host:port) to send job traces to")
+	configFile := flag.String(config.FlagName, "", config.FlagUsage)
 	flag.Parse()
 
+	if err := config.Load(config.Pflag(flag.CommandLine), "cplacerd", *configFile); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "config.Load",
+			"file":  *configFile,
+		}).Fatal("failed to load config")
+	}
+
 	// Set up logger
-	if err := logx.DefaultSetup(logLevel); err != nil {
+	if err := logging.Setup(logLevel, "cplacerd", logCfg); err != nil {
 		log.WithFields(log.Fields{
 			"error": err,
 			"level": logLevel,
@@ -68,6 +60,19 @@ func main() {
 			"func":  "kv.New",
 		}).Fatal("unable to connect to kv")
 	}
+	KV = kv.WithPrefix(KV, kvPrefix)
+
+	// Spans are exported in batches as jobs are worked on, until the daemon
+	// is killed
+	if otlpEndpoint != "" {
+		if _, err := httpmw.SetupTracing("cplacerd", otlpEndpoint); err != nil {
+			log.WithFields(log.Fields{
+				"error":    err,
+				"func":     "httpmw.SetupTracing",
+				"endpoint": otlpEndpoint,
+			}).Fatal("failed to set up tracing")
+		}
+	}
 
 	log.WithField("address", bstalk).Info("connection to beanstalk")
 	jobQueue, err := jobqueue.NewClient(bstalk, KV)
@@ -104,149 +109,5 @@ func main() {
 
 	}
 
-	for {
-		task, err := jobQueue.NextCreateTask()
-		if err != nil {
-			if bCE, ok := err.(beanstalk.ConnError); ok {
-				switch bCE {
-				case beanstalk.ErrTimeout:
-					// Empty queue, continue waiting
-					continue
-				case beanstalk.ErrDeadline:
-					// See docs on beanstalkd deadline
-					// We're just going to sleep to let the deadline'd job expire
-					// and try to get another job
-					m.IncrCounter([]string{"beanstalk", "error", "deadline"}, 1)
-					log.Debug(beanstalk.ErrDeadline)
-					time.Sleep(5 * time.Second)
-					continue
-				default:
-					// You have failed me for the last time
-					log.WithField("error", err).Fatal(err)
-				}
-			}
-			log.WithFields(log.Fields{
-				"task":  task,
-				"error": err,
-			}).Error("invalid task")
-
-			if err := task.Delete(); err != nil {
-				log.WithFields(log.Fields{
-					"task":  task.ID,
-					"error": err,
-				}).Error("unable to delete")
-			}
-		}
-
-		for _, step := range steps {
-
-			fields := log.Fields{
-				"task": task,
-				"step": step.label,
-			}
-
-			log.WithFields(fields).Debug("running")
-
-			start := time.Now()
-			rm, err := step.function(jobQueue, task)
-
-			m.MeasureSince([]string{step.label, "time"}, start)
-			m.IncrCounter([]string{step.label, "count"}, 1)
-
-			duration := int(time.Since(start).Seconds() * 1000)
-			log.WithFields(fields).WithField("duration", duration).Info("done")
-
-			if err != nil {
-
-				m.IncrCounter([]string{step.label, "error"}, 1)
-
-				log.WithFields(fields).WithField("error", err).Error("task error")
-
-				task.Job.Status = jobqueue.JobStatusError
-				task.Job.Error = err.Error()
-				if err := task.Job.Save(24 * time.Hour); err != nil {
-					log.WithFields(log.Fields{
-						"task":  task,
-						"error": err,
-					}).Error("unable to save")
-				}
-			}
-
-			if rm {
-				if _, err = deleteTask(nil, task); err != nil {
-					log.WithFields(log.Fields{
-						"task":  task.ID,
-						"error": err,
-					}).Error("unable to delete")
-				}
-				break
-			}
-		}
-	}
-}
-
-// these funcs return bool if task in beanstalk should be deleted (and loop stopped). loop also stops on error
-
-func checkJobStatus(jobQueue *jobqueue.Client, t *jobqueue.Task) (bool, error) {
-	if t.Job.Status != jobqueue.JobStatusNew {
-		return true, fmt.Errorf("bad job status: %s", t.Job.Status)
-	}
-	if t.Job.Action != "select-hypervisor" {
-		return true, fmt.Errorf("bad action: %s", t.Job.Action)
-	}
-	return false, nil
-}
-
-func checkGuestStatus(jobQueue *jobqueue.Client, t *jobqueue.Task) (bool, error) {
-
-	if t.Guest.HypervisorID != "" {
-		return true, fmt.Errorf("guest already has a hypervisor %s - %s", t.Guest.ID, t.Guest.HypervisorID)
-	}
-	return false, nil
-}
-
-func selectHypervisor(jobQueue *jobqueue.Client, t *jobqueue.Task) (bool, error) {
-	candidates, err := t.Guest.Candidates(lochness.DefaultCandidateFunctions...)
-	if err != nil {
-		return true, fmt.Errorf("unable to select candidate %s - %s", t.Guest.ID, err)
-	}
-
-	if len(candidates) == 0 {
-		return true, fmt.Errorf("no candidates found for %s", t.Guest.ID)
-	}
-
-	h := candidates[0]
-
-	// the API for selecting a candidate and then adding to a hypervisor is clunky
-	if err := h.AddGuest(t.Guest); err != nil {
-		return true, fmt.Errorf("unable to add guest %s to %s - %s", t.Guest.ID, h.ID, err)
-	}
-
-	return false, nil
-}
-
-func changeJobAction(jobQueue *jobqueue.Client, t *jobqueue.Task) (bool, error) {
-	t.Job.Action = "fetch"
-	if err := t.Job.Save(24 * time.Hour); err != nil {
-		return true, fmt.Errorf("unable to change job action - %s", err)
-	}
-	return false, nil
-}
-
-func addJobToWorker(jobQueue *jobqueue.Client, t *jobqueue.Task) (bool, error) {
-	id, err := jobQueue.AddTask(t.Job)
-	if err != nil {
-		return true, fmt.Errorf("unable to put to work queue %s", err)
-	}
-
-	log.WithFields(log.Fields{
-		"task": id,
-		"job":  t.Job.ID,
-	}).Debug("added job to work queue")
-
-	return false, nil
-}
-
-func deleteTask(jobQueue *jobqueue.Client, t *jobqueue.Task) (bool, error) {
-	return false, t.Delete()
+	placer.Run(jobQueue, m)
 }
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	"github.com/mistifyio/lochness/internal/server"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestCSchedAPI(t *testing.T) {
+	suite.Run(t, new(APISuite))
+}
+
+type APISuite struct {
+	common.Suite
+	Port      uint
+	APIServer *server.Server
+	Schedule  *lochness.Schedule
+	APIURL    string
+}
+
+func (s *APISuite) SetupSuite() {
+	s.Suite.SetupSuite()
+
+	log.SetLevel(log.FatalLevel)
+	s.Port = 51132
+	s.APIURL = fmt.Sprintf("http://localhost:%d/schedules", s.Port)
+
+	s.APIServer = Run(s.Port, s.Context, httpmw.Config{})
+	time.Sleep(100 * time.Millisecond)
+}
+
+func (s *APISuite) SetupTest() {
+	s.Suite.SetupTest()
+	s.Schedule = s.NewSchedule()
+}
+
+func (s *APISuite) TearDownSuite() {
+	stopChan := s.APIServer.StopChan()
+	s.APIServer.Stop(5 * time.Second)
+	<-stopChan
+
+	s.Suite.TearDownSuite()
+}
+
+func (s *APISuite) TestScheduleList() {
+	other := s.NewSchedule()
+
+	var schedules lochness.Schedules
+	s.DoRequest("GET", s.APIURL, http.StatusOK, nil, &schedules)
+	s.Len(schedules, 2)
+
+	s.DoRequest("GET", s.APIURL+"?guest="+other.GuestID, http.StatusOK, nil, &schedules)
+	s.Require().Len(schedules, 1)
+	s.Equal(other.ID, schedules[0].ID)
+
+	s.DoRequest("GET", s.APIURL+"?hypervisor="+uuid.New(), http.StatusOK, nil, &schedules)
+	s.Len(schedules, 0)
+}
+
+func (s *APISuite) TestScheduleAdd() {
+	hypervisor := s.NewHypervisor()
+	tests := []struct {
+		description  string
+		guest        string
+		hypervisor   string
+		cron         string
+		expectedCode int
+	}{
+		{"guest", s.Schedule.GuestID, "", "@hourly", http.StatusCreated},
+		{"hypervisor", "", hypervisor.ID, "0 4 * * 6", http.StatusCreated},
+		{"invalid cron", s.Schedule.GuestID, "", "daily", http.StatusBadRequest},
+		{"nonexistent guest", uuid.New(), "", "@hourly", http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		schedule := s.Context.NewSchedule()
+		schedule.GuestID = test.guest
+		schedule.HypervisorID = test.hypervisor
+		schedule.Action = "snapshot"
+		schedule.Cron = test.cron
+
+		var scheduleResp lochness.Schedule
+		s.DoRequest("POST", s.APIURL, test.expectedCode, schedule, &scheduleResp)
+		if test.expectedCode != http.StatusCreated {
+			continue
+		}
+		s.Equal(schedule.ID, scheduleResp.ID, msg("should return the schedule"))
+
+		// Make sure it actually saved
+		sc, err := s.Context.Schedule(schedule.ID)
+		s.NoError(err, msg("should be saved"))
+		s.Equal(test.cron, sc.Cron, msg("should be saved"))
+	}
+}
+
+func (s *APISuite) TestScheduleGet() {
+	var schedule lochness.Schedule
+	s.DoRequest("GET", fmt.Sprintf("%s/%s", s.APIURL, s.Schedule.ID), http.StatusOK, nil, &schedule)
+	s.Equal(s.Schedule.ID, schedule.ID)
+
+	var errResp map[string]interface{}
+	s.DoRequest("GET", fmt.Sprintf("%s/%s", s.APIURL, uuid.New()), http.StatusNotFound, nil, &errResp)
+	s.Equal("schedule_not_found", errResp["error"])
+	s.DoRequest("GET", fmt.Sprintf("%s/%s", s.APIURL, "asdf"), http.StatusBadRequest, nil, &errResp)
+	s.Equal("invalid_schedule_id", errResp["error"])
+}
+
+func (s *APISuite) TestScheduleUpdate() {
+	update := map[string]interface{}{
+		"id":       uuid.New(),
+		"cron":     "15 1 * * *",
+		"disabled": true,
+		"last_run": time.Now(),
+	}
+
+	var scheduleResp lochness.Schedule
+	s.DoRequest("PATCH", fmt.Sprintf("%s/%s", s.APIURL, s.Schedule.ID), http.StatusOK, update, &scheduleResp)
+	s.Equal(s.Schedule.ID, scheduleResp.ID, "id should not change")
+
+	// Make sure it actually saved
+	sc, err := s.Context.Schedule(s.Schedule.ID)
+	s.NoError(err)
+	s.Equal("15 1 * * *", sc.Cron)
+	s.True(sc.Disabled)
+	s.True(sc.LastRun.IsZero(), "last run should not change")
+}
+
+func (s *APISuite) TestScheduleDestroy() {
+	var scheduleResp lochness.Schedule
+	s.DoRequest("DELETE", fmt.Sprintf("%s/%s", s.APIURL, s.Schedule.ID), http.StatusOK, nil, &scheduleResp)
+	s.Equal(s.Schedule.ID, scheduleResp.ID)
+
+	// Make sure it actually deleted
+	_, err := s.Context.Schedule(s.Schedule.ID)
+	s.Error(err)
+}
@@ -0,0 +1,127 @@
+/*
+csched is the scheduling service. It runs guest actions, such as reboots and
+snapshots, at times given by cron expressions, and exposes the schedules over
+an HTTP API with JSON formatting.
+
+Usage
+
+The following arguments are understood:
+
+	./csched -h
+	Usage of ./csched:
+	-b, --beanstalk="127.0.0.1:11300": address of beanstalkd server
+	    --config-file="": yaml or toml file of flag settings, overridden by the environment and the command line
+	-i, --interval=10s: how often to check for due schedules
+	-k, --kv="http://localhost:4001": address of kv machine
+	    --kv-prefix="/lochness": root of the lochness keys in the kv, to share a kv cluster between lochness clusters
+	    --kv-retries=2: number of times to retry failed kv operations, other than atomic updates
+	    --kv-timeout=5s: timeout of kv operations, after which requests fail with 503, 0 to disable
+	-l, --log-level="warn": log level
+	    --log-max-backups=5: number of rotated log files kept
+	    --log-max-size=100: megabytes a log file grows to before it is rotated, 0 to never rotate it
+	    --log-output="stdout": where to log: stdout, stderr, syslog, journald, or the path of a file
+	    --max-late=5m0s: how late a scheduled action may run before it is skipped
+	    --otlp-endpoint="": OTLP/HTTP collector (host:port) to send request traces to
+	-p, --port=16000: listen port
+	    --slow-request=1s: latency above which requests are logged as slow, 0 to disable
+
+Scheduling
+
+A schedule runs its action on a guest, or on every guest of a hypervisor, e.g.
+a weekly reboot window for a hypervisor's guests. Actions are shutdown, reboot,
+restart, poweroff, start, suspend, and snapshot, and are run by adding a job
+for cworkerd, as cguestd does. Snapshots are named for the time they are taken.
+
+Cron expressions have the standard 5 fields (minute, hour, day of month,
+month, day of week) or are one of the descriptors @yearly, @monthly, @weekly,
+@daily, @hourly, and @every <duration>. Times are local to csched.
+
+Any number of csched instances may run. They all serve the API, but only the
+leader, elected through a lock in the kv, adds jobs. If the leader dies,
+another instance takes over once the lock expires. Runs that are more than
+--max-late overdue, e.g. because no instance was running, are skipped.
+
+HTTP API endpoints
+
+	/schedules
+		* GET - Retrieve a list of schedules, filtered by the guest or
+		hypervisor query parameters if given
+		* POST - Add a new schedule
+
+	/schedules/{scheduleID}
+		* GET - Retrieve information about a schedule
+		* PATCH - Update a schedule's information
+		* DELETE - Remove a schedule
+
+Versioning
+
+The endpoints are served under /v1, e.g. /v1/schedules, and every response has an
+X-API-Version header listing the versions served. The unversioned paths above
+are deprecated aliases kept for existing tooling, whose responses have a
+Deprecation header, a Warning, and a Link to the versioned path. The cli tools
+use /v1 with servers that have it.
+
+Request Logging
+
+Every request is logged with its method, path, status, latency, response size,
+and request id. Requests slower than --slow-request are logged as warnings and
+tagged "slow". With --otlp-endpoint, a span is also emitted for every request
+through OpenTelemetry, continuing any W3C trace context sent by the client.
+
+Content Types
+
+Responses are JSON unless the Accept header prefers YAML, application/x-yaml,
+or msgpack, application/msgpack, which have the same fields. Request bodies may
+be any of the three, named by their Content-Type header, and are taken as JSON
+without one. Bodies of other types are rejected like malformed ones, with
+"invalid_json".
+
+Errors
+
+Every response has an X-Request-ID header, taken from the request if the client
+sent one and generated otherwise. Error responses carry a machine readable error
+code, e.g. "schedule_not_found" or "invalid_json", and the request id, which is
+also logged with the error. Objects failing validation are rejected with
+"validation_failed" and the names of the invalid fields. Requests whose kv
+operations time out, see --kv-timeout, fail with 503 and "kv_timeout".
+
+	{"message":"schedule not found","error":"schedule_not_found","request_id":"0b2f6a8e-3c1d-4f5e-9a7b-1c2d3e4f5a6b"}
+
+Example Structs
+
+Schedule - lochness.Schedule
+
+	{
+		"id": "5d3c4e0f-8b8a-4a9e-a5a3-0f7c2d61b1f4",
+		"guest": "f2011319-ad59-42fb-9bad-92e261f0651c",
+		"action": "reboot",
+		"cron": "0 3 * * 0",
+		"disabled": false,
+		"created": "2016-03-01T10:21:07Z",
+		"last_run": "2016-03-06T03:00:00Z",
+		"metadata": {}
+	}
+
+Example Requests
+
+GET /schedules
+
+	$ curl http://localhost:16000/schedules?guest=f2011319-ad59-42fb-9bad-92e261f0651c
+	[{"id":"5d3c4e0f-8b8a-4a9e-a5a3-0f7c2d61b1f4","guest":"f2011319-ad59-42fb-9bad-92e261f0651c","action":"reboot","cron":"0 3 * * 0","disabled":false,"created":"2016-03-01T10:21:07Z","last_run":"2016-03-06T03:00:00Z","metadata":{}}]
+
+POST /schedules
+
+	$ curl -X POST http://localhost:16000/schedules --data-binary '{"hypervisor":"e88a75a6-7ae6-487c-9634-6553d3793437","action":"snapshot","cron":"@daily"}'
+	{"id":"9a4bde3b-5f0e-4a4d-9a3e-0d16c0b1a0e7","hypervisor":"e88a75a6-7ae6-487c-9634-6553d3793437","action":"snapshot","cron":"@daily","disabled":false,"created":"2016-03-07T09:12:44Z","last_run":"0001-01-01T00:00:00Z","metadata":{}}
+
+PATCH /schedules/{scheduleID}
+
+	$ curl -X PATCH http://localhost:16000/schedules/5d3c4e0f-8b8a-4a9e-a5a3-0f7c2d61b1f4 --data-binary '{"disabled":true}'
+	{"id":"5d3c4e0f-8b8a-4a9e-a5a3-0f7c2d61b1f4","guest":"f2011319-ad59-42fb-9bad-92e261f0651c","action":"reboot","cron":"0 3 * * 0","disabled":true,"created":"2016-03-01T10:21:07Z","last_run":"2016-03-06T03:00:00Z","metadata":{}}
+
+DELETE /schedules/{scheduleID}
+
+	$ curl -X DELETE http://localhost:16000/schedules/5d3c4e0f-8b8a-4a9e-a5a3-0f7c2d61b1f4
+	{"id":"5d3c4e0f-8b8a-4a9e-a5a3-0f7c2d61b1f4","guest":"f2011319-ad59-42fb-9bad-92e261f0651c","action":"reboot","cron":"0 3 * * 0","disabled":true,"created":"2016-03-01T10:21:07Z","last_run":"2016-03-06T03:00:00Z","metadata":{}}
+*/
+package main
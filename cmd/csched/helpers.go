@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	"github.com/pborman/uuid"
+)
+
+func getScheduleHelper(hr *httpmw.Response, r *http.Request) (*lochness.Schedule, bool) {
+	ctx := GetContext(r)
+	vars := mux.Vars(r)
+	scheduleID, ok := vars["scheduleID"]
+	if !ok {
+		hr.JSONErrorMsg(http.StatusBadRequest, "missing_schedule_id", "missing schedule id")
+		return nil, false
+	}
+	if uuid.Parse(scheduleID) == nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_schedule_id", "invalid schedule id")
+		return nil, false
+	}
+
+	schedule, err := ctx.Schedule(scheduleID)
+	if err != nil {
+		if ctx.IsKeyNotFound(err) {
+			hr.JSONErrorMsg(http.StatusNotFound, "schedule_not_found", "schedule not found")
+		} else {
+			hr.JSONError(http.StatusInternalServerError, err)
+		}
+		return nil, false
+	}
+	return schedule, true
+}
+
+// saveScheduleHelper validates and saves a schedule, making sure the guest or
+// hypervisor it runs on exists
+func saveScheduleHelper(hr *httpmw.Response, r *http.Request, schedule *lochness.Schedule) bool {
+	if err := schedule.Validate(); err != nil {
+		hr.JSONError(http.StatusBadRequest, err)
+		return false
+	}
+
+	ctx := GetContext(r)
+	var err error
+	if schedule.GuestID != "" {
+		_, err = ctx.Guest(schedule.GuestID)
+		if ctx.IsKeyNotFound(err) {
+			hr.JSONErrorMsg(http.StatusBadRequest, "guest_not_found", "guest not found")
+			return false
+		}
+	} else {
+		_, err = ctx.Hypervisor(schedule.HypervisorID)
+		if ctx.IsKeyNotFound(err) {
+			hr.JSONErrorMsg(http.StatusBadRequest, "hypervisor_not_found", "hypervisor not found")
+			return false
+		}
+	}
+	if err != nil {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return false
+	}
+
+	if err := schedule.Save(); err != nil {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return false
+	}
+	return true
+}
+
+func decodeSchedule(r *http.Request, schedule *lochness.Schedule) (*lochness.Schedule, error) {
+	if schedule == nil {
+		ctx := GetContext(r)
+		schedule = ctx.NewSchedule()
+	}
+
+	if err := httpmw.Decode(r, schedule); err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/config"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	"github.com/mistifyio/lochness/internal/logging"
+	"github.com/mistifyio/lochness/internal/server"
+	"github.com/mistifyio/lochness/pkg/jobqueue"
+	"github.com/mistifyio/lochness/pkg/kv"
+	_ "github.com/mistifyio/lochness/pkg/kv/bolt"
+	_ "github.com/mistifyio/lochness/pkg/kv/consul"
+	"github.com/mistifyio/lochness/pkg/lock"
+	logx "github.com/mistifyio/mistify-logrus-ext"
+	flag "github.com/ogier/pflag"
+)
+
+const defaultKVAddr = "http://localhost:4001"
+
+// leaderKey is the lock held by the csched instance running the schedules
+const leaderKey = "lochness/csched/leader"
+
+// leaderTTL is how long leadership lasts without being renewed
+const leaderTTL = 15 * time.Second
+
+func main() {
+	var port uint
+	var kvAddr, kvPrefix, bstalk, logLevel, otlpEndpoint string
+	var interval, maxLate, slowRequest, kvTimeout time.Duration
+	var kvRetries int
+
+	flag.UintVarP(&port, "port", "p", 16000, "listen port")
+	flag.StringVarP(&kvAddr, "kv", "k", defaultKVAddr, "address of kv machine")
+	flag.StringVar(&kvPrefix, "kv-prefix", kv.DefaultPrefix, "root of the lochness keys in the kv, to share a kv cluster between lochness clusters")
+	flag.DurationVar(&kvTimeout, "kv-timeout", 5*time.Second, "timeout of kv operations, after which requests fail with 503, 0 to disable")
+	flag.IntVar(&kvRetries, "kv-retries", 2, "number of times to retry failed kv operations, other than atomic updates")
+	flag.StringVarP(&bstalk, "beanstalk", "b", "127.0.0.1:11300", "address of beanstalkd server")
+	var logCfg logging.Config
+	flag.StringVarP(&logLevel, "log-level", "l", "warn", "log level")
+	logging.AddFlags(flag.CommandLine, &logCfg)
+	flag.DurationVarP(&interval, "interval", "i", 10*time.Second, "how often to check for due schedules")
+	flag.DurationVar(&maxLate, "max-late", 5*time.Minute, "how late a scheduled action may run before it is skipped")
+	flag.DurationVar(&slowRequest, "slow-request", time.Second, "latency above which requests are logged as slow, 0 to disable")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "", "OTLP/HTTP collector (host:port) to send request traces to")
+	configFile := flag.String(config.FlagName, "", config.FlagUsage)
+	flag.Parse()
+
+	if err := config.Load(config.Pflag(flag.CommandLine), "csched", *configFile); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "config.Load",
+			"file":  *configFile,
+		}).Fatal("failed to load config")
+	}
+
+	if err := logging.Setup(logLevel, "csched", logCfg); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"level": logLevel,
+		}).Fatal("failed to set up logging")
+	}
+
+	KV, err := kv.New(kvAddr)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"addr":  kvAddr,
+			"error": err,
+			"func":  "kv.New",
+		}).Fatal("unable to connect to kv")
+	}
+	KV = kv.WithPrefix(KV, kvPrefix)
+
+	ctx := lochness.NewContext(KV).WithTimeout(kvTimeout).WithRetry(kvRetries, lochness.DefaultKVRetryWait)
+
+	jobQueue, err := jobqueue.NewClient(bstalk, KV)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":   err,
+			"address": bstalk,
+		}).Fatal("failed to create jobQueue client")
+	}
+
+	leader, err := lock.New(KV, leaderKey, leaderTTL)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "lock.New",
+		}).Fatal("failed to create leader lock")
+	}
+
+	reqLog := httpmw.Config{SlowThreshold: slowRequest}
+	if otlpEndpoint != "" {
+		shutdown, err := httpmw.SetupTracing("csched", otlpEndpoint)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":    err,
+				"func":     "httpmw.SetupTracing",
+				"endpoint": otlpEndpoint,
+			}).Fatal("failed to set up tracing")
+		}
+		defer logx.LogReturnedErr(shutdown, nil, "failed to shut down tracing")
+		reqLog.Trace = true
+	}
+
+	sched := &scheduler{
+		ctx:      ctx,
+		jobs:     jobQueue,
+		interval: interval,
+		maxLate:  maxLate,
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		sched.lead(leader, stop)
+		close(done)
+	}()
+
+	srv := Run(port, ctx, reqLog)
+
+	// Serve until SIGINT or SIGTERM, then drain in-flight requests
+	sigCtx, cancel := server.SignalContext()
+	defer cancel()
+	server.Wait(sigCtx, srv)
+
+	// Step down so another instance can take over right away
+	close(stop)
+	<-done
+}
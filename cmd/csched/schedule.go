@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/httpmw"
+)
+
+// RegisterScheduleRoutes registers the schedule routes and handlers
+func RegisterScheduleRoutes(prefix string, router *mux.Router) {
+	router.HandleFunc(prefix, ListSchedules).Methods("GET")
+	router.HandleFunc(prefix, CreateSchedule).Methods("POST")
+
+	sub := router.PathPrefix(prefix).Subrouter()
+	sub.HandleFunc("/{scheduleID}", GetSchedule).Methods("GET")
+	sub.HandleFunc("/{scheduleID}", UpdateSchedule).Methods("PATCH")
+	sub.HandleFunc("/{scheduleID}", DestroySchedule).Methods("DELETE")
+}
+
+// ListSchedules gets a list of all schedules, optionally only those of the
+// guest or hypervisor given in the query
+func ListSchedules(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	ctx := GetContext(r)
+	query := r.URL.Query()
+	guestID, hypervisorID := query.Get("guest"), query.Get("hypervisor")
+
+	schedules := make(lochness.Schedules, 0)
+	err := ctx.ForEachSchedule(func(schedule *lochness.Schedule) error {
+		if guestID != "" && schedule.GuestID != guestID {
+			return nil
+		}
+		if hypervisorID != "" && schedule.HypervisorID != hypervisorID {
+			return nil
+		}
+		schedules = append(schedules, schedule)
+		return nil
+	})
+	if err != nil && !ctx.IsKeyNotFound(err) {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	hr.JSON(http.StatusOK, schedules)
+}
+
+// GetSchedule gets a particular schedule
+func GetSchedule(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	schedule, ok := getScheduleHelper(hr, r)
+	if !ok {
+		return
+	}
+	hr.JSON(http.StatusOK, schedule)
+}
+
+// CreateSchedule creates a new schedule
+func CreateSchedule(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	schedule, err := decodeSchedule(r, nil)
+	if err != nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_json", err.Error())
+		return
+	}
+
+	if !saveScheduleHelper(hr, r, schedule) {
+		return
+	}
+	hr.JSON(http.StatusCreated, schedule)
+}
+
+// UpdateSchedule updates a schedule
+func UpdateSchedule(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	schedule, ok := getScheduleHelper(hr, r)
+	if !ok {
+		return
+	}
+
+	scheduleID := schedule.ID
+	created, lastRun := schedule.Created, schedule.LastRun
+
+	_, err := decodeSchedule(r, schedule)
+	if err != nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_json", err.Error())
+		return
+	}
+
+	// Don't allow ID or run history redefinition
+	schedule.ID = scheduleID
+	schedule.Created, schedule.LastRun = created, lastRun
+
+	if !saveScheduleHelper(hr, r, schedule) {
+		return
+	}
+
+	hr.JSON(http.StatusOK, schedule)
+}
+
+// DestroySchedule destroys a schedule
+func DestroySchedule(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	schedule, ok := getScheduleHelper(hr, r)
+	if !ok {
+		return
+	}
+
+	if err := schedule.Destroy(); err != nil {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+
+	hr.JSON(http.StatusOK, schedule)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/pkg/jobqueue"
+	"github.com/mistifyio/lochness/pkg/lock"
+)
+
+// jobAdder adds guest jobs, e.g. a jobqueue.Client
+type jobAdder interface {
+	AddJob(guestID, action string) (*jobqueue.Job, error)
+}
+
+// scheduler adds jobs for the schedules that are due
+type scheduler struct {
+	ctx      *lochness.Context
+	jobs     jobAdder
+	interval time.Duration
+	maxLate  time.Duration
+}
+
+// lead runs the schedules while holding the leader lock, so that only one
+// csched adds jobs. It competes for the lock again whenever leadership is
+// lost, and releases it once stop is closed.
+func (s *scheduler) lead(leader *lock.Lock, stop <-chan struct{}) {
+	for {
+		err := leader.Acquire(s.interval)
+		select {
+		case <-stop:
+			if err == nil {
+				_ = leader.Release()
+			}
+			return
+		default:
+		}
+		if err == lock.ErrTimeout {
+			continue
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"func":  "lock.Acquire",
+			}).Error("failed to acquire leader lock")
+			time.Sleep(s.interval)
+			continue
+		}
+
+		log.WithField("id", leader.ID()).Info("became leader")
+		s.schedule(leader, stop)
+		if err := leader.Release(); err != nil && err != lock.ErrNotHeld {
+			log.WithFields(log.Fields{
+				"error": err,
+				"func":  "lock.Release",
+			}).Warn("failed to release leader lock")
+		}
+		select {
+		case <-stop:
+			return
+		default:
+			log.WithField("id", leader.ID()).Warn("lost leadership")
+		}
+	}
+}
+
+// schedule runs due schedules every interval until leadership is lost or stop
+// is closed
+func (s *scheduler) schedule(leader *lock.Lock, stop <-chan struct{}) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	lost := leader.Lost()
+	for {
+		// confirm leadership before acting on it
+		if err := leader.Renew(); err != nil {
+			return
+		}
+		if err := s.runDue(time.Now()); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"func":  "scheduler.runDue",
+			}).Error("failed to run schedules")
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-lost:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runDue adds the jobs of every enabled schedule that is due by now. Each run
+// is recorded on its schedule before the jobs are added, so an action is not
+// run twice if the schedule cannot be saved. Runs more than maxLate overdue,
+// e.g. missed while no csched was running, are skipped.
+func (s *scheduler) runDue(now time.Time) error {
+	err := s.ctx.ForEachSchedule(func(sched *lochness.Schedule) error {
+		if sched.Disabled {
+			return nil
+		}
+
+		due, err := sched.Due()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":    err,
+				"schedule": sched.ID,
+				"cron":     sched.Cron,
+			}).Error("invalid schedule")
+			return nil
+		}
+		if due.After(now) {
+			return nil
+		}
+
+		late := now.Sub(due) > s.maxLate
+		sched.LastRun = due
+		if late {
+			sched.LastRun = now
+		}
+		if err := sched.Save(); err != nil {
+			log.WithFields(log.Fields{
+				"error":    err,
+				"schedule": sched.ID,
+			}).Error("failed to record schedule run")
+			return nil
+		}
+
+		if late {
+			log.WithFields(log.Fields{
+				"schedule": sched.ID,
+				"due":      due,
+			}).Warn("skipping late scheduled run")
+			return nil
+		}
+		s.run(sched)
+		return nil
+	})
+	if err != nil && s.ctx.IsKeyNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// run adds the jobs for a schedule's action
+func (s *scheduler) run(sched *lochness.Schedule) {
+	if sched.GuestID != "" {
+		s.addJob(sched, sched.GuestID)
+		return
+	}
+
+	hypervisor, err := s.ctx.Hypervisor(sched.HypervisorID)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":      err,
+			"schedule":   sched.ID,
+			"hypervisor": sched.HypervisorID,
+		}).Error("failed to load hypervisor")
+		return
+	}
+	for _, guestID := range hypervisor.Guests() {
+		s.addJob(sched, guestID)
+	}
+}
+
+// addJob adds a job for the action of a schedule on a guest
+func (s *scheduler) addJob(sched *lochness.Schedule, guestID string) {
+	job, err := s.jobs.AddJob(guestID, sched.Action)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":    err,
+			"schedule": sched.ID,
+			"guest":    guestID,
+			"action":   sched.Action,
+		}).Error("failed to add job")
+		return
+	}
+	log.WithFields(log.Fields{
+		"schedule": sched.ID,
+		"guest":    guestID,
+		"action":   sched.Action,
+		"job":      job.ID,
+	}).Info("added scheduled job")
+}
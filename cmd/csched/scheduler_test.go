@@ -0,0 +1,167 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/mistifyio/lochness/pkg/jobqueue"
+	"github.com/mistifyio/lochness/pkg/lock"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestScheduler(t *testing.T) {
+	suite.Run(t, new(SchedulerSuite))
+}
+
+type SchedulerSuite struct {
+	common.Suite
+	Jobs      *fakeJobs
+	Scheduler *scheduler
+}
+
+// fakeJobs records the jobs added instead of queueing them
+type fakeJobs struct {
+	mu   sync.Mutex
+	jobs []jobqueue.Job
+}
+
+func (f *fakeJobs) AddJob(guestID, action string) (*jobqueue.Job, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	job := jobqueue.Job{ID: uuid.New(), Guest: guestID, Action: action}
+	f.jobs = append(f.jobs, job)
+	return &job, nil
+}
+
+func (f *fakeJobs) added() []jobqueue.Job {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]jobqueue.Job(nil), f.jobs...)
+}
+
+func (s *SchedulerSuite) SetupSuite() {
+	s.Suite.SetupSuite()
+	log.SetLevel(log.FatalLevel)
+}
+
+func (s *SchedulerSuite) SetupTest() {
+	s.Suite.SetupTest()
+	s.Jobs = &fakeJobs{}
+	s.Scheduler = &scheduler{
+		ctx:      s.Context,
+		jobs:     s.Jobs,
+		interval: 10 * time.Millisecond,
+		maxLate:  time.Hour,
+	}
+}
+
+func (s *SchedulerSuite) TestRunDue() {
+	// no schedules at all
+	s.NoError(s.Scheduler.runDue(time.Now()))
+
+	created := time.Date(2016, 1, 1, 12, 0, 0, 0, time.UTC)
+	guestSchedule := s.NewSchedule()
+	guestSchedule.Cron = "0 3 * * *"
+	guestSchedule.Created = created
+	s.Require().NoError(guestSchedule.Save())
+
+	hypervisor, guest := s.NewHypervisorWithGuest()
+	hvSchedule := s.NewSchedule()
+	hvSchedule.GuestID = ""
+	hvSchedule.HypervisorID = hypervisor.ID
+	hvSchedule.Action = "snapshot"
+	hvSchedule.Cron = "0 4 * * *"
+	hvSchedule.Created = created
+	s.Require().NoError(hvSchedule.Save())
+
+	disabled := s.NewSchedule()
+	disabled.Created = created
+	disabled.Disabled = true
+	s.Require().NoError(disabled.Save())
+
+	// nothing is due yet
+	s.NoError(s.Scheduler.runDue(time.Date(2016, 1, 2, 2, 59, 0, 0, time.UTC)))
+	s.Len(s.Jobs.added(), 0)
+
+	// only the guest schedule is due
+	s.NoError(s.Scheduler.runDue(time.Date(2016, 1, 2, 3, 0, 30, 0, time.UTC)))
+	jobs := s.Jobs.added()
+	s.Require().Len(jobs, 1)
+	s.Equal(guestSchedule.GuestID, jobs[0].Guest)
+	s.Equal("reboot", jobs[0].Action)
+
+	// runs are recorded
+	s.Require().NoError(guestSchedule.Refresh())
+	s.Equal(time.Date(2016, 1, 2, 3, 0, 0, 0, time.UTC), guestSchedule.LastRun.UTC())
+	s.NoError(s.Scheduler.runDue(time.Date(2016, 1, 2, 3, 1, 0, 0, time.UTC)))
+	s.Len(s.Jobs.added(), 1, "a run should not be repeated")
+
+	// hypervisor schedules run on each guest of the hypervisor
+	s.NoError(s.Scheduler.runDue(time.Date(2016, 1, 2, 4, 0, 0, 0, time.UTC)))
+	jobs = s.Jobs.added()
+	s.Require().Len(jobs, 2)
+	s.Equal(guest.ID, jobs[1].Guest)
+	s.Equal("snapshot", jobs[1].Action)
+}
+
+func (s *SchedulerSuite) TestRunDueLate() {
+	created := time.Date(2016, 1, 1, 12, 0, 0, 0, time.UTC)
+	schedule := s.NewSchedule()
+	schedule.Cron = "0 3 * * *"
+	schedule.Created = created
+	s.Require().NoError(schedule.Save())
+
+	now := time.Date(2016, 1, 5, 12, 0, 0, 0, time.UTC)
+	s.NoError(s.Scheduler.runDue(now))
+	s.Len(s.Jobs.added(), 0, "late runs should be skipped")
+
+	s.Require().NoError(schedule.Refresh())
+	s.True(now.Equal(schedule.LastRun), "skipped runs should be recorded")
+	due, err := schedule.Due()
+	s.NoError(err)
+	s.Equal(time.Date(2016, 1, 6, 3, 0, 0, 0, time.UTC), due.UTC())
+}
+
+func (s *SchedulerSuite) TestLead() {
+	schedule := s.NewSchedule()
+	schedule.Cron = "* * * * *"
+	schedule.Created = time.Now().Add(-time.Minute)
+	s.Require().NoError(schedule.Save())
+
+	leader, err := lock.New(s.KV, leaderKey, time.Second)
+	s.Require().NoError(err)
+	other, err := lock.New(s.KV, leaderKey, time.Second)
+	s.Require().NoError(err)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		s.Scheduler.lead(leader, stop)
+		close(done)
+	}()
+
+	s.True(waitFor(func() bool { return len(s.Jobs.added()) > 0 }), "leader should run schedules")
+	s.Equal(lock.ErrLocked, other.TryAcquire(), "leadership should be held")
+
+	close(stop)
+	<-done
+	s.NoError(other.TryAcquire(), "leadership should be released on stop")
+	s.NoError(other.Release())
+}
+
+// waitFor polls f until it returns true or a second passes
+func waitFor(f func() bool) bool {
+	for i := 0; i < 100; i++ {
+		if f() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
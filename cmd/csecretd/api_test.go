@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	"github.com/mistifyio/lochness/internal/server"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestCSecretdAPI(t *testing.T) {
+	suite.Run(t, new(APISuite))
+}
+
+type APISuite struct {
+	common.Suite
+	Port      uint
+	APIServer *server.Server
+	AccessLog *bytes.Buffer
+	Secret    *lochness.Secret
+	APIURL    string
+}
+
+func (s *APISuite) SetupSuite() {
+	s.Suite.SetupSuite()
+
+	log.SetLevel(log.FatalLevel)
+	s.Port = 51134
+	s.APIURL = fmt.Sprintf("http://localhost:%d/secrets", s.Port)
+
+	ctx, err := s.Context.WithSecretKey(common.SecretKey)
+	s.Require().NoError(err)
+	s.AccessLog = &bytes.Buffer{}
+	accessLog := log.New()
+	accessLog.Formatter = &log.JSONFormatter{}
+	accessLog.Out = s.AccessLog
+
+	s.APIServer = Run(s.Port, ctx, accessLog, httpmw.Config{})
+	time.Sleep(100 * time.Millisecond)
+}
+
+func (s *APISuite) SetupTest() {
+	s.Suite.SetupTest()
+	s.AccessLog.Reset()
+	s.Secret = s.NewSecret()
+}
+
+func (s *APISuite) TearDownSuite() {
+	stopChan := s.APIServer.StopChan()
+	s.APIServer.Stop(5 * time.Second)
+	<-stopChan
+
+	s.Suite.TearDownSuite()
+}
+
+// accesses returns the entries of the access log
+func (s *APISuite) accesses() []map[string]interface{} {
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(s.AccessLog.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		entry := map[string]interface{}{}
+		s.Require().NoError(json.Unmarshal([]byte(line), &entry))
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func (s *APISuite) TestSecretList() {
+	var secrets []map[string]interface{}
+	s.DoRequest("GET", s.APIURL, http.StatusOK, nil, &secrets)
+
+	s.Require().Len(secrets, 1)
+	s.Equal(s.Secret.ID, secrets[0]["id"])
+	s.NotContains(secrets[0], "value", "secrets should be listed without values")
+	s.NotContains(secrets[0], "ciphertext", "secrets should be listed without values")
+}
+
+func (s *APISuite) TestSecretAdd() {
+	req := secretRequest{Name: "bmc", Kind: lochness.SecretIPMI, Value: "admin:admin"}
+	var secretResp lochness.Secret
+	s.DoRequest("POST", s.APIURL, http.StatusCreated, req, &secretResp)
+	s.Equal(1, secretResp.Version)
+
+	// Make sure it actually saved
+	ctx, _ := s.Context.WithSecretKey(common.SecretKey)
+	secret, err := ctx.Secret(secretResp.ID)
+	s.Require().NoError(err)
+	s.Equal("bmc", secret.Name)
+	value, err := secret.Value()
+	s.NoError(err)
+	s.Equal("admin:admin", string(value))
+
+	var errResp map[string]interface{}
+	s.DoRequest("POST", s.APIURL, http.StatusBadRequest, secretRequest{Name: "bmc", Kind: "foo", Value: "bar"}, &errResp)
+	s.Equal("validation_failed", errResp["error"])
+	s.DoRequest("POST", s.APIURL, http.StatusBadRequest, secretRequest{Name: "bmc", Kind: lochness.SecretIPMI}, &errResp)
+	s.Equal("validation_failed", errResp["error"])
+}
+
+func (s *APISuite) TestSecretGet() {
+	var secret lochness.Secret
+	s.DoRequest("GET", fmt.Sprintf("%s/%s", s.APIURL, s.Secret.ID), http.StatusOK, nil, &secret)
+	s.Equal(s.Secret.ID, secret.ID)
+
+	var errResp map[string]interface{}
+	s.DoRequest("GET", fmt.Sprintf("%s/%s", s.APIURL, uuid.New()), http.StatusNotFound, nil, &errResp)
+	s.Equal("secret_not_found", errResp["error"])
+	s.DoRequest("GET", fmt.Sprintf("%s/%s", s.APIURL, "asdf"), http.StatusBadRequest, nil, &errResp)
+	s.Equal("invalid_secret_id", errResp["error"])
+}
+
+func (s *APISuite) TestSecretValue() {
+	var value secretValue
+	s.DoRequest("GET", fmt.Sprintf("%s/%s/value", s.APIURL, s.Secret.ID), http.StatusOK, nil, &value)
+	s.Equal(secretValue{ID: s.Secret.ID, Version: 1, Value: "hunter2"}, value)
+}
+
+func (s *APISuite) TestSecretRotate() {
+	var secretResp lochness.Secret
+	s.DoRequest("PUT", fmt.Sprintf("%s/%s/value", s.APIURL, s.Secret.ID), http.StatusOK, secretRequest{Value: "correct horse"}, &secretResp)
+	s.Equal(2, secretResp.Version)
+
+	var value secretValue
+	s.DoRequest("GET", fmt.Sprintf("%s/%s/value", s.APIURL, s.Secret.ID), http.StatusOK, nil, &value)
+	s.Equal("correct horse", value.Value)
+
+	var errResp map[string]interface{}
+	s.DoRequest("PUT", fmt.Sprintf("%s/%s/value", s.APIURL, s.Secret.ID), http.StatusBadRequest, secretRequest{}, &errResp)
+	s.Equal("validation_failed", errResp["error"])
+}
+
+func (s *APISuite) TestSecretDestroy() {
+	hypervisor := s.NewHypervisor()
+	hypervisor.Secrets = map[string]string{lochness.SecretAgentToken: s.Secret.ID}
+	s.Require().NoError(hypervisor.Save())
+
+	var errResp map[string]interface{}
+	s.DoRequest("DELETE", fmt.Sprintf("%s/%s", s.APIURL, s.Secret.ID), http.StatusConflict, nil, &errResp)
+	s.Equal("secret_in_use", errResp["error"])
+
+	hypervisor.Secrets = nil
+	s.Require().NoError(hypervisor.Save())
+
+	var secretResp lochness.Secret
+	s.DoRequest("DELETE", fmt.Sprintf("%s/%s", s.APIURL, s.Secret.ID), http.StatusOK, nil, &secretResp)
+	s.Equal(s.Secret.ID, secretResp.ID)
+
+	// Make sure it actually destroyed
+	_, err := s.Context.Secret(s.Secret.ID)
+	s.Error(err)
+}
+
+func (s *APISuite) TestAccessLog() {
+	var value secretValue
+	resp := s.DoRequest("GET", fmt.Sprintf("%s/%s/value", s.APIURL, s.Secret.ID), http.StatusOK, nil, &value)
+	var secretResp lochness.Secret
+	s.DoRequest("PUT", fmt.Sprintf("%s/%s/value", s.APIURL, s.Secret.ID), http.StatusOK, secretRequest{Value: "foo"}, &secretResp)
+
+	entries := s.accesses()
+	s.Require().Len(entries, 2)
+	s.Equal("reveal", entries[0]["action"])
+	s.Equal(s.Secret.ID, entries[0]["secret"])
+	s.Equal(lochness.SecretAgentToken, entries[0]["kind"])
+	s.Equal(float64(1), entries[0]["version"])
+	s.Equal("127.0.0.1", entries[0]["remote"])
+	s.Equal(resp.Header.Get(httpmw.RequestIDHeader), entries[0]["request_id"])
+	s.Equal("rotate", entries[1]["action"])
+	s.Equal(float64(2), entries[1]["version"])
+	s.NotContains(s.AccessLog.String(), "hunter2", "values should not be logged")
+}
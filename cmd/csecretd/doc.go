@@ -0,0 +1,155 @@
+/*
+csecretd is the secrets service. It exposes functionality over an HTTP API with JSON formatting.
+
+Secrets are credentials such as hypervisor agent tokens, IPMI credentials, and
+guest root passwords. They are stored apart from the guests and hypervisors
+that use them, which reference them by id in their "secrets", and their values
+are always encrypted in the kv.
+
+Usage
+
+The following arguments are understood:
+
+	./csecretd -h
+	Usage of ./csecretd:
+	    --access-log="": file to log each access to a secret to, stderr if blank
+	    --config-file="": yaml or toml file of flag settings, overridden by the environment and the command line
+	-k, --kv="http://localhost:4001": address of kv machine
+	    --kv-prefix="/lochness": root of the lochness keys in the kv, to share a kv cluster between lochness clusters
+	    --kv-retries=2: number of times to retry failed kv operations, other than atomic updates
+	    --kv-timeout=5s: timeout of kv operations, after which requests fail with 503, 0 to disable
+	-l, --log-level="warn": log level
+	    --log-max-backups=5: number of rotated log files kept
+	    --log-max-size=100: megabytes a log file grows to before it is rotated, 0 to never rotate it
+	    --log-output="stdout": where to log: stdout, stderr, syslog, journald, or the path of a file
+	    --otlp-endpoint="": OTLP/HTTP collector (host:port) to send request traces to
+	-p, --port=22000: listen port
+	    --secret-key-file="": file with the hex encoded 32 byte key secrets are encrypted with
+	    --slow-request=1s: latency above which requests are logged as slow, 0 to disable
+
+HTTP API endpoints
+
+	/secrets
+		* GET - Retrieve a list of secrets, without their values
+		* POST - Add a new secret
+
+	/secrets/{secretID}
+		* GET - Retrieve information about a secret, without its value
+		* DELETE - Remove a secret that no guest or hypervisor references
+
+	/secrets/{secretID}/value
+		* GET - Retrieve the value of a secret
+		* PUT - Rotate a secret to a new value
+
+Secrets
+
+A secret's "kind" is one of "agent-token", "ipmi", or "root-password". Its
+value is encrypted with AES-256-GCM under the key in --secret-key-file, which
+can be created with:
+
+	$ openssl rand -hex 32 > /etc/lochness/secret.key
+
+The key is not stored in the kv, and secrets can not be read without it, so it
+must be kept and backed up apart from the kv. Rotating a secret replaces its
+value and increments its "version"; guests and hypervisors referencing it get
+the new value without being updated.
+
+Access Log
+
+Every access to a secret, including listing them, is logged to --access-log as
+a json line, whatever the --log-level, with the action, the secret's id, kind,
+and version, the address of the client, and the request id. Values are never
+logged.
+
+	{"action":"reveal","kind":"ipmi","level":"info","msg":"secret accessed","remote":"10.0.0.5","request_id":"0b2f6a8e-3c1d-4f5e-9a7b-1c2d3e4f5a6b","secret":"7d0c5b2e-9f1a-4c3d-8e6b-5a4f3e2d1c0b","time":"2016-03-07T09:12:44Z","version":2}
+
+Versioning
+
+The endpoints are served under /v1, e.g. /v1/secrets/{secretID}, and every response has an
+X-API-Version header listing the versions served. The unversioned paths above
+are deprecated aliases kept for existing tooling, whose responses have a
+Deprecation header, a Warning, and a Link to the versioned path. The cli tools
+use /v1 with servers that have it.
+
+Request Logging
+
+Every request is logged with its method, path, status, latency, response size,
+and request id. Requests slower than --slow-request are logged as warnings and
+tagged "slow". With --otlp-endpoint, a span is also emitted for every request
+through OpenTelemetry, continuing any W3C trace context sent by the client.
+
+Content Types
+
+Responses are JSON unless the Accept header prefers YAML, application/x-yaml,
+or msgpack, application/msgpack, which have the same fields. Request bodies may
+be any of the three, named by their Content-Type header, and are taken as JSON
+without one. Bodies of other types are rejected like malformed ones, with
+"invalid_json".
+
+Errors
+
+Every response has an X-Request-ID header, taken from the request if the client
+sent one and generated otherwise. Error responses carry a machine readable error
+code, e.g. "secret_not_found" or "invalid_json", and the request id, which is also logged
+with the error. Secrets failing validation, including those without a value,
+are rejected with "validation_failed" and the names of the invalid fields.
+Deleting a secret that guests or hypervisors reference fails with 409 and
+"secret_in_use". Requests whose kv operations time out, see --kv-timeout, fail
+with 503 and "kv_timeout".
+
+	{"message":"secret not found","error":"secret_not_found","request_id":"0b2f6a8e-3c1d-4f5e-9a7b-1c2d3e4f5a6b"}
+
+Example Structs
+
+Secret - lochness.Secret
+
+	{
+		"id": "7d0c5b2e-9f1a-4c3d-8e6b-5a4f3e2d1c0b",
+		"name": "hv1-bmc",
+		"kind": "ipmi",
+		"version": 2,
+		"created_at": "2016-03-01T10:00:00Z",
+		"updated_at": "2016-03-07T09:12:44Z"
+	}
+
+Secret value
+
+	{
+		"id": "7d0c5b2e-9f1a-4c3d-8e6b-5a4f3e2d1c0b",
+		"version": 2,
+		"value": "admin:s3cret"
+	}
+
+Example Requests
+
+GET /secrets
+
+	$ curl http://localhost:22000/secrets
+	[{"id":"7d0c5b2e-9f1a-4c3d-8e6b-5a4f3e2d1c0b","name":"hv1-bmc","kind":"ipmi","version":2,"created_at":"2016-03-01T10:00:00Z","updated_at":"2016-03-07T09:12:44Z"}]
+
+POST /secrets
+
+	$ curl -X POST http://localhost:22000/secrets --data-binary '{"name":"hv1-bmc","kind":"ipmi","value":"admin:admin"}'
+	{"id":"7d0c5b2e-9f1a-4c3d-8e6b-5a4f3e2d1c0b","name":"hv1-bmc","kind":"ipmi","version":1,"created_at":"2016-03-01T10:00:00Z","updated_at":"2016-03-01T10:00:00Z"}
+
+GET /secrets/{secretID}
+
+	$ curl http://localhost:22000/secrets/7d0c5b2e-9f1a-4c3d-8e6b-5a4f3e2d1c0b
+	{"id":"7d0c5b2e-9f1a-4c3d-8e6b-5a4f3e2d1c0b","name":"hv1-bmc","kind":"ipmi","version":1,"created_at":"2016-03-01T10:00:00Z","updated_at":"2016-03-01T10:00:00Z"}
+
+PUT /secrets/{secretID}/value
+
+	$ curl -X PUT http://localhost:22000/secrets/7d0c5b2e-9f1a-4c3d-8e6b-5a4f3e2d1c0b/value --data-binary '{"value":"admin:s3cret"}'
+	{"id":"7d0c5b2e-9f1a-4c3d-8e6b-5a4f3e2d1c0b","name":"hv1-bmc","kind":"ipmi","version":2,"created_at":"2016-03-01T10:00:00Z","updated_at":"2016-03-07T09:12:44Z"}
+
+GET /secrets/{secretID}/value
+
+	$ curl http://localhost:22000/secrets/7d0c5b2e-9f1a-4c3d-8e6b-5a4f3e2d1c0b/value
+	{"id":"7d0c5b2e-9f1a-4c3d-8e6b-5a4f3e2d1c0b","version":2,"value":"admin:s3cret"}
+
+DELETE /secrets/{secretID}
+
+	$ curl -X DELETE http://localhost:22000/secrets/7d0c5b2e-9f1a-4c3d-8e6b-5a4f3e2d1c0b
+	{"id":"7d0c5b2e-9f1a-4c3d-8e6b-5a4f3e2d1c0b","name":"hv1-bmc","kind":"ipmi","version":2,"created_at":"2016-03-01T10:00:00Z","updated_at":"2016-03-07T09:12:44Z"}
+*/
+package main
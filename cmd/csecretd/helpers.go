@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	"github.com/pborman/uuid"
+)
+
+func getSecretHelper(hr *httpmw.Response, r *http.Request) (*lochness.Secret, bool) {
+	ctx := GetContext(r)
+	vars := mux.Vars(r)
+	secretID, ok := vars["secretID"]
+	if !ok {
+		hr.JSONErrorMsg(http.StatusBadRequest, "missing_secret_id", "missing secret id")
+		return nil, false
+	}
+	if uuid.Parse(secretID) == nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_secret_id", "invalid secret id")
+		return nil, false
+	}
+
+	secret, err := ctx.Secret(secretID)
+	if err != nil {
+		if ctx.IsKeyNotFound(err) {
+			hr.JSONErrorMsg(http.StatusNotFound, "secret_not_found", "secret not found")
+		} else {
+			hr.JSONError(http.StatusInternalServerError, err)
+		}
+		return nil, false
+	}
+	return secret, true
+}
+
+// setSecretValueHelper encrypts the value of a request for a secret
+func setSecretValueHelper(hr *httpmw.Response, secret *lochness.Secret, value string) bool {
+	if err := secret.SetValue([]byte(value)); err != nil {
+		if _, ok := err.(*lochness.ValidationError); ok {
+			hr.JSONError(http.StatusBadRequest, err)
+		} else {
+			hr.JSONError(http.StatusInternalServerError, err)
+		}
+		return false
+	}
+	return true
+}
+
+func saveSecretHelper(hr *httpmw.Response, secret *lochness.Secret) bool {
+	if err := secret.Validate(); err != nil {
+		hr.JSONError(http.StatusBadRequest, err)
+		return false
+	}
+
+	if err := secret.Save(); err != nil {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return false
+	}
+	return true
+}
+
+// logAccess records an access to a secret in the access log, with who made it
+func logAccess(r *http.Request, hr *httpmw.Response, action string, secret *lochness.Secret) {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	fields := log.Fields{
+		"request_id": hr.RequestID(),
+		"remote":     remote,
+		"action":     action,
+	}
+	if secret != nil {
+		fields["secret"] = secret.ID
+		fields["kind"] = secret.Kind
+		fields["version"] = secret.Version
+	}
+	GetAccessLog(r).WithFields(fields).Info("secret accessed")
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/config"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	"github.com/mistifyio/lochness/internal/logging"
+	"github.com/mistifyio/lochness/internal/server"
+	"github.com/mistifyio/lochness/pkg/kv"
+	_ "github.com/mistifyio/lochness/pkg/kv/bolt"
+	_ "github.com/mistifyio/lochness/pkg/kv/consul"
+	logx "github.com/mistifyio/mistify-logrus-ext"
+	flag "github.com/ogier/pflag"
+)
+
+const defaultKVAddr = "http://localhost:4001"
+
+func main() {
+	var port uint
+	var kvAddr, kvPrefix, logLevel, otlpEndpoint, secretKeyFile, accessLogFile string
+	var slowRequest, kvTimeout time.Duration
+	var kvRetries int
+
+	flag.UintVarP(&port, "port", "p", 22000, "listen port")
+	flag.StringVarP(&kvAddr, "kv", "k", defaultKVAddr, "address of kv machine")
+	flag.StringVar(&kvPrefix, "kv-prefix", kv.DefaultPrefix, "root of the lochness keys in the kv, to share a kv cluster between lochness clusters")
+	flag.DurationVar(&kvTimeout, "kv-timeout", 5*time.Second, "timeout of kv operations, after which requests fail with 503, 0 to disable")
+	flag.IntVar(&kvRetries, "kv-retries", 2, "number of times to retry failed kv operations, other than atomic updates")
+	var logCfg logging.Config
+	flag.StringVarP(&logLevel, "log-level", "l", "warn", "log level")
+	logging.AddFlags(flag.CommandLine, &logCfg)
+	flag.DurationVar(&slowRequest, "slow-request", time.Second, "latency above which requests are logged as slow, 0 to disable")
+	flag.StringVar(&secretKeyFile, "secret-key-file", "", "file with the hex encoded 32 byte key secrets are encrypted with")
+	flag.StringVar(&accessLogFile, "access-log", "", "file to log each access to a secret to, stderr if blank")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "", "OTLP/HTTP collector (host:port) to send request traces to")
+	configFile := flag.String(config.FlagName, "", config.FlagUsage)
+	flag.Parse()
+
+	if err := config.Load(config.Pflag(flag.CommandLine), "csecretd", *configFile); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "config.Load",
+			"file":  *configFile,
+		}).Fatal("failed to load config")
+	}
+
+	if err := logging.Setup(logLevel, "csecretd", logCfg); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"level": logLevel,
+		}).Fatal("failed to set up logging")
+	}
+
+	KV, err := kv.New(kvAddr)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"addr":  kvAddr,
+			"error": err,
+			"func":  "kv.New",
+		}).Fatal("unable to connect to kv")
+	}
+	KV = kv.WithPrefix(KV, kvPrefix)
+
+	key, err := lochness.ReadSecretKeyFile(secretKeyFile)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "lochness.ReadSecretKeyFile",
+			"file":  secretKeyFile,
+		}).Fatal("failed to read secret key")
+	}
+
+	ctx, err := lochness.NewContext(KV).WithTimeout(kvTimeout).WithRetry(kvRetries, lochness.DefaultKVRetryWait).WithSecretKey(key)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "lochness.Context.WithSecretKey",
+		}).Fatal("invalid secret key")
+	}
+
+	// Accesses are logged whatever the log level
+	accessLog := log.New()
+	accessLog.Formatter = &log.JSONFormatter{}
+	accessLog.Level = log.InfoLevel
+	if accessLogFile != "" {
+		f, err := os.OpenFile(accessLogFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"func":  "os.OpenFile",
+				"file":  accessLogFile,
+			}).Fatal("failed to open access log")
+		}
+		defer logx.LogReturnedErr(f.Close, nil, "failed to close access log")
+		accessLog.Out = f
+	}
+
+	reqLog := httpmw.Config{SlowThreshold: slowRequest}
+	if otlpEndpoint != "" {
+		shutdown, err := httpmw.SetupTracing("csecretd", otlpEndpoint)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":    err,
+				"func":     "httpmw.SetupTracing",
+				"endpoint": otlpEndpoint,
+			}).Fatal("failed to set up tracing")
+		}
+		defer logx.LogReturnedErr(shutdown, nil, "failed to shut down tracing")
+		reqLog.Trace = true
+	}
+
+	srv := Run(port, ctx, accessLog, reqLog)
+
+	// Serve until SIGINT or SIGTERM, then drain in-flight requests
+	sigCtx, cancel := server.SignalContext()
+	defer cancel()
+	server.Wait(sigCtx, srv)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+)
+
+type (
+	// secretRequest is the body of requests creating a secret. Only Value is
+	// used when rotating one.
+	secretRequest struct {
+		Name  string `json:"name"`
+		Kind  string `json:"kind"`
+		Value string `json:"value"`
+	}
+
+	// secretValue is the decrypted value of a secret
+	secretValue struct {
+		ID      string `json:"id"`
+		Version int    `json:"version"`
+		Value   string `json:"value"`
+	}
+)
+
+// RegisterSecretRoutes registers the secret routes and handlers
+func RegisterSecretRoutes(prefix string, router *mux.Router) {
+	router.HandleFunc(prefix, ListSecrets).Methods("GET")
+	router.HandleFunc(prefix, CreateSecret).Methods("POST")
+
+	sub := router.PathPrefix(prefix).Subrouter()
+	sub.HandleFunc("/{secretID}", GetSecret).Methods("GET")
+	sub.HandleFunc("/{secretID}", DestroySecret).Methods("DELETE")
+	sub.HandleFunc("/{secretID}/value", GetSecretValue).Methods("GET")
+	sub.HandleFunc("/{secretID}/value", RotateSecret).Methods("PUT")
+}
+
+// ListSecrets gets a list of all secrets, without their values
+func ListSecrets(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	ctx := GetContext(r)
+	secrets := make(lochness.Secrets, 0)
+	err := ctx.ForEachSecret(func(secret *lochness.Secret) error {
+		secrets = append(secrets, secret)
+		return nil
+	})
+	if err != nil && !ctx.IsKeyNotFound(err) {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	sort.Slice(secrets, func(i, j int) bool {
+		return secrets[i].ID < secrets[j].ID
+	})
+	logAccess(r, hr, "list", nil)
+	hr.JSON(http.StatusOK, secrets)
+}
+
+// GetSecret gets a particular secret, without its value
+func GetSecret(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	secret, ok := getSecretHelper(hr, r)
+	if !ok {
+		return
+	}
+	logAccess(r, hr, "get", secret)
+	hr.JSON(http.StatusOK, secret)
+}
+
+// CreateSecret creates a new secret with the value given
+func CreateSecret(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	req := secretRequest{}
+	if err := httpmw.Decode(r, &req); err != nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_json", err.Error())
+		return
+	}
+
+	secret := GetContext(r).NewSecret()
+	secret.Name = req.Name
+	secret.Kind = req.Kind
+	if !setSecretValueHelper(hr, secret, req.Value) {
+		return
+	}
+	if !saveSecretHelper(hr, secret) {
+		return
+	}
+	logAccess(r, hr, "create", secret)
+	hr.JSON(http.StatusCreated, secret)
+}
+
+// GetSecretValue gets the decrypted value of a secret
+func GetSecretValue(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	secret, ok := getSecretHelper(hr, r)
+	if !ok {
+		return
+	}
+
+	value, err := secret.Value()
+	if err != nil {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	logAccess(r, hr, "reveal", secret)
+	hr.JSON(http.StatusOK, secretValue{
+		ID:      secret.ID,
+		Version: secret.Version,
+		Value:   string(value),
+	})
+}
+
+// RotateSecret replaces the value of a secret. Guests and hypervisors that
+// reference it get the new value.
+func RotateSecret(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	secret, ok := getSecretHelper(hr, r)
+	if !ok {
+		return
+	}
+
+	req := secretRequest{}
+	if err := httpmw.Decode(r, &req); err != nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_json", err.Error())
+		return
+	}
+	if !setSecretValueHelper(hr, secret, req.Value) {
+		return
+	}
+	if !saveSecretHelper(hr, secret) {
+		return
+	}
+	logAccess(r, hr, "rotate", secret)
+	hr.JSON(http.StatusOK, secret)
+}
+
+// DestroySecret destroys a secret that no guest or hypervisor references
+func DestroySecret(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	secret, ok := getSecretHelper(hr, r)
+	if !ok {
+		return
+	}
+
+	if err := secret.Destroy(); err != nil {
+		if lerrors.IsConflict(err) {
+			hr.JSONErrorMsg(http.StatusConflict, "secret_in_use", err.Error())
+		} else {
+			hr.JSONError(http.StatusInternalServerError, err)
+		}
+		return
+	}
+	logAccess(r, hr, "delete", secret)
+	hr.JSON(http.StatusOK, secret)
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/pkg/jobqueue"
+	"github.com/mistifyio/lochness/pkg/kv"
+	"github.com/mistifyio/lochness/pkg/lock"
+)
+
+// jobAdder adds guest jobs, e.g. a jobqueue.Client
+type jobAdder interface {
+	AddJob(guestID, action string) (*jobqueue.Job, error)
+}
+
+// controller rolls out the active upgrade, moving each hypervisor of it
+// through its steps
+type controller struct {
+	ctx      *lochness.Context
+	kv       kv.KV
+	jobs     jobAdder
+	interval time.Duration
+	// drainTimeout is how long guests may take to shut down or start
+	drainTimeout time.Duration
+	// verifyTimeout is how long an upgraded hypervisor may take to come back
+	// with the version
+	verifyTimeout time.Duration
+}
+
+func newController(ctx *lochness.Context, KV kv.KV, jobs jobAdder, interval, drainTimeout, verifyTimeout time.Duration) *controller {
+	return &controller{
+		ctx:           ctx,
+		kv:            KV,
+		jobs:          jobs,
+		interval:      interval,
+		drainTimeout:  drainTimeout,
+		verifyTimeout: verifyTimeout,
+	}
+}
+
+// lead rolls out upgrades while holding the leader lock, so that only one
+// cupgraded acts. It competes for the lock again whenever leadership is lost,
+// and releases it once stop is closed.
+func (c *controller) lead(leader *lock.Lock, stop <-chan struct{}) {
+	for {
+		err := leader.Acquire(c.interval)
+		select {
+		case <-stop:
+			if err == nil {
+				_ = leader.Release()
+			}
+			return
+		default:
+		}
+		if err == lock.ErrTimeout {
+			continue
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"func":  "lock.Acquire",
+			}).Error("failed to acquire leader lock")
+			time.Sleep(c.interval)
+			continue
+		}
+
+		log.WithField("id", leader.ID()).Info("became leader")
+		c.watch(leader, stop)
+		if err := leader.Release(); err != nil && err != lock.ErrNotHeld {
+			log.WithFields(log.Fields{
+				"error": err,
+				"func":  "lock.Release",
+			}).Warn("failed to release leader lock")
+		}
+		select {
+		case <-stop:
+			return
+		default:
+			log.WithField("id", leader.ID()).Warn("lost leadership")
+		}
+	}
+}
+
+// watch advances the active upgrade every interval until leadership is lost
+// or stop is closed
+func (c *controller) watch(leader *lock.Lock, stop <-chan struct{}) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	lost := leader.Lost()
+	for {
+		// confirm leadership before acting on it
+		if err := leader.Renew(); err != nil {
+			return
+		}
+		if err := c.check(time.Now()); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"func":  "controller.check",
+			}).Error("failed to advance upgrade")
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-lost:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check advances the hypervisors being upgraded by the active upgrade, and
+// starts the next batch once they are all done. The upgrade fails as soon as
+// a hypervisor does.
+func (c *controller) check(now time.Time) error {
+	u, err := c.ctx.ActiveUpgrade()
+	if err != nil || u == nil {
+		return err
+	}
+	if u.State != lochness.UpgradeRunning && u.State != lochness.UpgradePaused {
+		return nil
+	}
+
+	inProgress := 0
+	for _, uh := range u.Hypervisors {
+		if !uh.InProgress() {
+			continue
+		}
+		if err := c.advance(u, uh, now); err != nil {
+			fail(uh, now, err)
+		}
+		if uh.InProgress() {
+			inProgress++
+		}
+	}
+	if err := u.SaveProgress(); err != nil {
+		return err
+	}
+	if u.State != lochness.UpgradeRunning && u.State != lochness.UpgradePaused {
+		// ended meanwhile
+		return nil
+	}
+
+	for _, uh := range u.Hypervisors {
+		if uh.Step == lochness.UpgradeStepFailed {
+			log.WithFields(log.Fields{
+				"upgrade":    u.ID,
+				"hypervisor": uh.ID,
+				"error":      uh.Error,
+			}).Error("hypervisor upgrade failed")
+			return u.Fail(fmt.Errorf("hypervisor %s failed: %s", uh.ID, uh.Error))
+		}
+	}
+
+	if inProgress > 0 || u.State != lochness.UpgradeRunning {
+		return nil
+	}
+	batch := make([]*lochness.UpgradeHypervisor, 0, u.BatchSize)
+	for _, uh := range u.Hypervisors {
+		if uh.Step == lochness.UpgradeStepPending && len(batch) < u.BatchSize {
+			batch = append(batch, uh)
+		}
+	}
+	if len(batch) == 0 {
+		log.WithFields(log.Fields{
+			"upgrade": u.ID,
+			"version": u.Version,
+		}).Info("upgrade done")
+		return u.Complete()
+	}
+
+	for _, uh := range batch {
+		if err := c.drain(uh, now); err != nil {
+			fail(uh, now, err)
+		}
+	}
+	return u.SaveProgress()
+}
+
+// fail marks a hypervisor's upgrade failed
+func fail(uh *lochness.UpgradeHypervisor, now time.Time, err error) {
+	uh.Error = fmt.Sprintf("%s: %s", uh.Step, err)
+	uh.Step = lochness.UpgradeStepFailed
+	uh.Since = now
+}
+
+// enter moves a hypervisor to a step, waiting for the jobs given
+func enter(uh *lochness.UpgradeHypervisor, step string, jobs []string, now time.Time) {
+	uh.Step = step
+	uh.Since = now
+	uh.Jobs = jobs
+	log.WithFields(log.Fields{
+		"hypervisor": uh.ID,
+		"step":       step,
+	}).Info("hypervisor upgrade step")
+}
+
+// advance moves a hypervisor on to its next step once its current one is done
+func (c *controller) advance(u *lochness.Upgrade, uh *lochness.UpgradeHypervisor, now time.Time) error {
+	switch uh.Step {
+	case lochness.UpgradeStepDraining:
+		done, err := c.jobsDone(uh, now)
+		if err != nil || !done {
+			return err
+		}
+		return c.upgrade(u, uh, now)
+	case lochness.UpgradeStepUpgrading:
+		ok, err := c.verify(uh, now)
+		if err != nil || !ok {
+			return err
+		}
+		return c.restore(uh, now)
+	case lochness.UpgradeStepRestoring:
+		done, err := c.jobsDone(uh, now)
+		if err != nil || !done {
+			return err
+		}
+		return c.finish(uh, now)
+	}
+	return nil
+}
+
+// drain puts a hypervisor in maintenance, so no guests are placed on it, and
+// shuts down its running guests
+func (c *controller) drain(uh *lochness.UpgradeHypervisor, now time.Time) error {
+	h, err := c.ctx.Hypervisor(uh.ID)
+	if err != nil {
+		return err
+	}
+	h.Maintenance = true
+	if err := h.Save(); err != nil {
+		return err
+	}
+
+	var guests, jobs []string
+	for _, guestID := range h.Guests() {
+		guest, err := c.ctx.Guest(guestID)
+		if err != nil {
+			return err
+		}
+		if guest.State() != lochness.GuestStateRunning {
+			continue
+		}
+		job, err := c.jobs.AddJob(guest.ID, "shutdown")
+		if err != nil {
+			return err
+		}
+		guests = append(guests, guest.ID)
+		jobs = append(jobs, job.ID)
+	}
+	uh.Guests = guests
+	enter(uh, lochness.UpgradeStepDraining, jobs, now)
+	return nil
+}
+
+// upgrade sets the version of a drained hypervisor, for nconfigd to install,
+// and expects the hypervisor to report it once upgraded
+func (c *controller) upgrade(u *lochness.Upgrade, uh *lochness.UpgradeHypervisor, now time.Time) error {
+	h, err := c.ctx.Hypervisor(uh.ID)
+	if err != nil {
+		return err
+	}
+	if _, err := h.SetExpectedConfig(map[string]string{lochness.VersionFact: u.Version}); err != nil {
+		return err
+	}
+	if err := h.SetConfig(lochness.VersionConfig, u.Version); err != nil {
+		return err
+	}
+	enter(uh, lochness.UpgradeStepUpgrading, nil, now)
+	return nil
+}
+
+// verify returns whether an upgraded hypervisor is healthy: alive and matching
+// its expected config, including the version, when checked since the upgrade
+// began
+func (c *controller) verify(uh *lochness.UpgradeHypervisor, now time.Time) (bool, error) {
+	h, err := c.ctx.Hypervisor(uh.ID)
+	if err != nil {
+		return false, err
+	}
+	drift, err := h.Drift()
+	if err != nil {
+		return false, err
+	}
+	if h.IsAlive() && drift.CheckedAt.After(uh.Since) && !drift.Drifted() {
+		return true, nil
+	}
+	if now.Sub(uh.Since) > c.verifyTimeout {
+		if !h.IsAlive() {
+			return false, fmt.Errorf("hypervisor not alive after %s", c.verifyTimeout)
+		}
+		return false, fmt.Errorf("hypervisor not at the expected config after %s: %v", c.verifyTimeout, drift.Differences)
+	}
+	return false, nil
+}
+
+// restore starts the guests shut down by drain again
+func (c *controller) restore(uh *lochness.UpgradeHypervisor, now time.Time) error {
+	var jobs []string
+	for _, guestID := range uh.Guests {
+		job, err := c.jobs.AddJob(guestID, "start")
+		if err != nil {
+			return err
+		}
+		jobs = append(jobs, job.ID)
+	}
+	enter(uh, lochness.UpgradeStepRestoring, jobs, now)
+	return nil
+}
+
+// finish takes an upgraded hypervisor out of maintenance
+func (c *controller) finish(uh *lochness.UpgradeHypervisor, now time.Time) error {
+	h, err := c.ctx.Hypervisor(uh.ID)
+	if err != nil {
+		return err
+	}
+	h.Maintenance = false
+	if err := h.Save(); err != nil {
+		return err
+	}
+	enter(uh, lochness.UpgradeStepDone, nil, now)
+	return nil
+}
+
+// jobsDone returns whether the jobs a hypervisor's step waits for are done. A
+// job that failed, or that is still not done after the drain timeout, is an
+// error.
+func (c *controller) jobsDone(uh *lochness.UpgradeHypervisor, now time.Time) (bool, error) {
+	for _, jobID := range uh.Jobs {
+		job, err := c.job(jobID)
+		if err != nil {
+			return false, err
+		}
+		if job == nil {
+			// expired long after finishing
+			continue
+		}
+		switch job.Status {
+		case jobqueue.JobStatusDone:
+			continue
+		case jobqueue.JobStatusError:
+			return false, fmt.Errorf("%s job %s of guest %s failed: %s", job.Action, job.ID, job.Guest, job.Error)
+		}
+		if now.Sub(uh.Since) > c.drainTimeout {
+			return false, fmt.Errorf("%s job %s of guest %s not done after %s", job.Action, job.ID, job.Guest, c.drainTimeout)
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+// job reads a job, or returns nil if it no longer exists. The job is read
+// directly rather than through the jobqueue so its lock is not taken.
+func (c *controller) job(jobID string) (*jobqueue.Job, error) {
+	value, err := c.kv.Get(filepath.Join(jobqueue.JobPath, jobID))
+	if err != nil {
+		if c.kv.IsKeyNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	job := &jobqueue.Job{}
+	if err := json.Unmarshal(value.Data, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
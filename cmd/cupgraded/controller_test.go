@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/mistifyio/lochness/pkg/jobqueue"
+	"github.com/mistifyio/lochness/pkg/kv"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestController(t *testing.T) {
+	suite.Run(t, new(ControllerSuite))
+}
+
+type ControllerSuite struct {
+	common.Suite
+	Jobs       *fakeJobs
+	Controller *controller
+}
+
+// fakeJobs records the jobs added instead of queueing them, saving them in
+// the kv with the status given
+type fakeJobs struct {
+	mu     sync.Mutex
+	kv     kv.KV
+	status string
+	jobs   []jobqueue.Job
+}
+
+func (f *fakeJobs) AddJob(guestID, action string) (*jobqueue.Job, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	job := jobqueue.Job{ID: uuid.New(), Guest: guestID, Action: action, Status: f.status}
+	data, _ := json.Marshal(job)
+	if err := f.kv.Set(filepath.Join(jobqueue.JobPath, job.ID), string(data)); err != nil {
+		return nil, err
+	}
+	f.jobs = append(f.jobs, job)
+	return &job, nil
+}
+
+func (f *fakeJobs) added() []jobqueue.Job {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]jobqueue.Job(nil), f.jobs...)
+}
+
+func (s *ControllerSuite) SetupSuite() {
+	s.Suite.SetupSuite()
+	log.SetLevel(log.FatalLevel)
+}
+
+func (s *ControllerSuite) SetupTest() {
+	s.Suite.SetupTest()
+	s.Jobs = &fakeJobs{kv: s.KV, status: jobqueue.JobStatusDone}
+	s.Controller = newController(s.Context, s.KV, s.Jobs, 10*time.Millisecond, time.Minute, 10*time.Minute)
+}
+
+// newRunningGuest creates a hypervisor with a running guest
+func (s *ControllerSuite) newRunningGuest() (*lochness.Hypervisor, *lochness.Guest) {
+	hypervisor, guest := s.NewHypervisorWithGuest()
+	guest.Metadata["state"] = lochness.GuestStateRunning
+	s.Require().NoError(guest.Save())
+	return hypervisor, guest
+}
+
+// startUpgrade starts an upgrade of the hypervisors given, in order
+func (s *ControllerSuite) startUpgrade(batchSize int, hypervisors ...*lochness.Hypervisor) *lochness.Upgrade {
+	upgrade := s.Context.NewUpgrade()
+	upgrade.Version = "0.5.0"
+	upgrade.BatchSize = batchSize
+	for _, h := range hypervisors {
+		upgrade.AddHypervisor(h.ID)
+	}
+	s.Require().NoError(upgrade.Start())
+	return upgrade
+}
+
+// steps returns the step of each hypervisor of an upgrade, refreshing it
+func (s *ControllerSuite) steps(upgrade *lochness.Upgrade) []string {
+	s.Require().NoError(upgrade.Refresh())
+	steps := make([]string, len(upgrade.Hypervisors))
+	for i, uh := range upgrade.Hypervisors {
+		steps[i] = uh.Step
+	}
+	return steps
+}
+
+// comeBack makes a hypervisor alive and report the version
+func (s *ControllerSuite) comeBack(h *lochness.Hypervisor, version string) {
+	_, _ = lochness.SetHypervisorID(h.ID)
+	s.Require().NoError(h.Heartbeat(time.Hour))
+	_, err := h.CheckDrift(map[string]string{lochness.VersionFact: version})
+	s.Require().NoError(err)
+}
+
+func (s *ControllerSuite) TestRollout() {
+	first, firstGuest := s.newRunningGuest()
+	second, _ := s.NewHypervisorWithGuest()
+	upgrade := s.startUpgrade(1, first, second)
+	now := time.Now().Add(-time.Hour)
+
+	s.NoError(s.Controller.check(now))
+	s.Equal([]string{lochness.UpgradeStepDraining, lochness.UpgradeStepPending}, s.steps(upgrade))
+	hypervisor, err := s.Context.Hypervisor(first.ID)
+	s.Require().NoError(err)
+	s.True(hypervisor.Maintenance, "draining hypervisor should be in maintenance")
+	jobs := s.Jobs.added()
+	s.Require().Len(jobs, 1)
+	s.Equal(firstGuest.ID, jobs[0].Guest)
+	s.Equal("shutdown", jobs[0].Action)
+
+	s.NoError(s.Controller.check(now))
+	s.Equal([]string{lochness.UpgradeStepUpgrading, lochness.UpgradeStepPending}, s.steps(upgrade))
+	hypervisor, err = s.Context.Hypervisor(first.ID)
+	s.Require().NoError(err)
+	s.Equal("0.5.0", hypervisor.Config[lochness.VersionConfig])
+	expected, err := hypervisor.ExpectedConfig()
+	s.Require().NoError(err)
+	s.Equal("0.5.0", expected[lochness.VersionFact])
+
+	s.NoError(s.Controller.check(now.Add(time.Minute)))
+	s.Equal(lochness.UpgradeStepUpgrading, s.steps(upgrade)[0], "hypervisor should not be verified before it comes back")
+
+	s.comeBack(hypervisor, "0.4.0")
+	s.NoError(s.Controller.check(now.Add(time.Minute)))
+	s.Equal(lochness.UpgradeStepUpgrading, s.steps(upgrade)[0], "hypervisor should not be verified at another version")
+
+	s.comeBack(hypervisor, "0.5.0")
+	s.NoError(s.Controller.check(now.Add(time.Minute)))
+	s.Equal([]string{lochness.UpgradeStepRestoring, lochness.UpgradeStepPending}, s.steps(upgrade))
+	jobs = s.Jobs.added()
+	s.Require().Len(jobs, 2)
+	s.Equal(firstGuest.ID, jobs[1].Guest)
+	s.Equal("start", jobs[1].Action)
+
+	s.NoError(s.Controller.check(now.Add(time.Minute)))
+	s.Equal([]string{lochness.UpgradeStepDone, lochness.UpgradeStepDraining}, s.steps(upgrade), "next batch should start once the first is done")
+	hypervisor, err = s.Context.Hypervisor(first.ID)
+	s.Require().NoError(err)
+	s.False(hypervisor.Maintenance)
+	s.Len(s.Jobs.added(), 2, "guests that are not running should not be shut down")
+
+	s.NoError(s.Controller.check(now.Add(time.Minute)))
+	hypervisor, err = s.Context.Hypervisor(second.ID)
+	s.Require().NoError(err)
+	s.comeBack(hypervisor, "0.5.0")
+	s.NoError(s.Controller.check(now.Add(2 * time.Minute)))
+	s.NoError(s.Controller.check(now.Add(2 * time.Minute)))
+	s.Equal([]string{lochness.UpgradeStepDone, lochness.UpgradeStepDone}, s.steps(upgrade))
+
+	s.NoError(s.Controller.check(now.Add(2 * time.Minute)))
+	s.Equal(lochness.UpgradeDone, upgrade.State)
+	active, err := s.Context.ActiveUpgrade()
+	s.NoError(err)
+	s.Nil(active)
+}
+
+func (s *ControllerSuite) TestBatchSize() {
+	hypervisors := []*lochness.Hypervisor{s.NewHypervisor(), s.NewHypervisor(), s.NewHypervisor()}
+	upgrade := s.startUpgrade(2, hypervisors...)
+
+	s.NoError(s.Controller.check(time.Now()))
+	s.Equal([]string{
+		lochness.UpgradeStepDraining,
+		lochness.UpgradeStepDraining,
+		lochness.UpgradeStepPending,
+	}, s.steps(upgrade))
+}
+
+func (s *ControllerSuite) TestPause() {
+	upgrade := s.startUpgrade(1, s.NewHypervisor(), s.NewHypervisor())
+	now := time.Now()
+
+	s.NoError(s.Controller.check(now))
+	s.Require().NoError(upgrade.Pause())
+	s.NoError(s.Controller.check(now))
+	s.Equal([]string{lochness.UpgradeStepUpgrading, lochness.UpgradeStepPending}, s.steps(upgrade), "hypervisors being upgraded should go on while paused")
+	s.Equal(lochness.UpgradePaused, upgrade.State)
+
+	upgrade.Hypervisors[0].Step = lochness.UpgradeStepDone
+	s.Require().NoError(upgrade.SaveProgress())
+	s.NoError(s.Controller.check(now))
+	s.Equal(lochness.UpgradeStepPending, s.steps(upgrade)[1], "paused upgrades should not start the next batch")
+
+	s.Require().NoError(upgrade.Resume())
+	s.NoError(s.Controller.check(now))
+	s.Equal(lochness.UpgradeStepDraining, s.steps(upgrade)[1])
+}
+
+func (s *ControllerSuite) TestAbort() {
+	upgrade := s.startUpgrade(1, s.NewHypervisor())
+	s.Require().NoError(upgrade.Abort())
+
+	s.NoError(s.Controller.check(time.Now()))
+	s.Equal([]string{lochness.UpgradeStepPending}, s.steps(upgrade), "aborted upgrades should not be rolled out")
+}
+
+func (s *ControllerSuite) TestJobFailure() {
+	hypervisor, _ := s.newRunningGuest()
+	upgrade := s.startUpgrade(1, hypervisor)
+	s.Jobs.status = jobqueue.JobStatusError
+	now := time.Now()
+
+	s.NoError(s.Controller.check(now))
+	s.NoError(s.Controller.check(now))
+	s.Equal([]string{lochness.UpgradeStepFailed}, s.steps(upgrade))
+	s.Contains(upgrade.Hypervisors[0].Error, "shutdown job")
+	s.Equal(lochness.UpgradeFailed, upgrade.State)
+	s.Contains(upgrade.Error, hypervisor.ID)
+
+	hypervisor, err := s.Context.Hypervisor(hypervisor.ID)
+	s.Require().NoError(err)
+	s.True(hypervisor.Maintenance, "failed hypervisors should be left in maintenance")
+	active, err := s.Context.ActiveUpgrade()
+	s.NoError(err)
+	s.Nil(active, "failed upgrades should let another be rolled out")
+}
+
+func (s *ControllerSuite) TestTimeouts() {
+	hypervisor, _ := s.newRunningGuest()
+	upgrade := s.startUpgrade(1, hypervisor)
+	s.Jobs.status = jobqueue.JobStatusWorking
+	now := time.Now()
+
+	s.NoError(s.Controller.check(now))
+	s.NoError(s.Controller.check(now.Add(30 * time.Second)))
+	s.Equal([]string{lochness.UpgradeStepDraining}, s.steps(upgrade))
+	s.NoError(s.Controller.check(now.Add(2 * time.Minute)))
+	s.Equal([]string{lochness.UpgradeStepFailed}, s.steps(upgrade), "guests should only get the drain timeout to shut down")
+	s.Contains(upgrade.Hypervisors[0].Error, "not done after")
+
+	upgrade = s.startUpgrade(1, s.NewHypervisor())
+	s.NoError(s.Controller.check(now))
+	s.NoError(s.Controller.check(now))
+	s.Equal([]string{lochness.UpgradeStepUpgrading}, s.steps(upgrade))
+	s.NoError(s.Controller.check(now.Add(time.Hour)))
+	s.Equal([]string{lochness.UpgradeStepFailed}, s.steps(upgrade), "hypervisors should only get the verify timeout to come back")
+	s.Contains(upgrade.Hypervisors[0].Error, "not alive")
+}
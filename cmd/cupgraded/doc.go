@@ -0,0 +1,62 @@
+/*
+cupgraded is the rolling upgrade service. It upgrades the hypervisors of an
+upgrade, started with chypervisord, a batch at a time, shutting their running
+guests down and starting them again.
+
+Usage
+
+The following arguments are understood:
+
+	$ cupgraded -h
+	Usage of cupgraded:
+	-b, --beanstalk="127.0.0.1:11300": address of beanstalkd server
+	    --config-file="": yaml or toml file of flag settings, overridden by the environment and the command line
+	    --drain-timeout=10m0s: how long a hypervisor's guests may take to shut down, or start again, before its upgrade fails
+	-i, --interval=10s: how often to advance the upgrade being rolled out
+	-k, --kv="http://127.0.0.1:4001": address of kv server
+	    --kv-prefix="/lochness": root of the lochness keys in the kv, to share a kv cluster between lochness clusters
+	-l, --log-level="warn": log level
+	    --log-max-backups=5: number of rotated log files kept
+	    --log-max-size=100: megabytes a log file grows to before it is rotated, 0 to never rotate it
+	    --log-output="stdout": where to log: stdout, stderr, syslog, journald, or the path of a file
+	    --verify-timeout=30m0s: how long an upgraded hypervisor may take to come back with the version before its upgrade fails
+
+Upgrades
+
+An upgrade lists the version to upgrade to, the hypervisors to upgrade in order,
+and how many to upgrade at a time. Only one upgrade is rolled out at a time;
+starting another while one is running or paused is a conflict.
+
+	$ hv upgrade start -b 2 0.5.0
+
+Each hypervisor of a batch goes through these steps:
+
+	draining   the hypervisor is put in maintenance, so cplacerd places no new
+	           guests on it, and shutdown jobs are added for its running guests
+	upgrading  once they are shut down, the hypervisor config key "version" is
+	           set to the version, and the fact "os-version" is expected to be it
+	restoring  once nheartbeatd reports the hypervisor alive and without drift
+	           from its expected config, start jobs are added for the guests
+	done       once they are started, the hypervisor is taken out of maintenance
+
+The upgrade itself is run by nconfigd on the hypervisor, by watching the version
+config key, e.g.
+
+	/lochness/hypervisors/<id>/config/version: ["upgrade"]
+
+The next batch starts once every hypervisor of the last is done, and the upgrade
+is done once every hypervisor is. Should a guest job fail, or not be done within
+--drain-timeout, or the hypervisor not come back with the version within
+--verify-timeout, the hypervisor and the upgrade fail. The hypervisor is left in
+maintenance for an operator to look at.
+
+A paused upgrade finishes the hypervisors being upgraded but starts no new
+batch until it is resumed. An aborted upgrade stops at once, leaving the
+hypervisors being upgraded at their step and in maintenance. A failed, aborted,
+or done upgrade lets another be started.
+
+Any number of cupgraded instances may run, but only the leader, elected through
+a lock in the kv, acts on the upgrade. If the leader dies, another instance
+takes over once the lock expires, carrying on from the progress saved.
+*/
+package main
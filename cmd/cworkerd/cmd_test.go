@@ -41,6 +41,7 @@ type CmdSuite struct {
 }
 
 func (s *CmdSuite) SetupSuite() {
+	s.ExternalKV = true
 	s.Suite.SetupSuite()
 	s.Require().NoError(common.Build())
 	s.BinName = "cworkerd"
@@ -9,14 +9,132 @@ The following arguments are understood:
 
 	$ cworkerd -h
 	Usage of cworkerd:
+	    --agent-dial-timeout=0s: how long connecting to an agent may take, 0 for the default of 30s
 	-a, --agent-port=8080: port on which agents listen
+	    --agent-proxy="": url of the proxy to connect to agents through, instead of those of $HTTP_PROXY, $HTTPS_PROXY, and $NO_PROXY, or "none" to connect directly
 	-b, --beanstalk="127.0.0.1:11300": address of beanstalkd server
+	    --config-file="": yaml or toml file of flag settings, overridden by the environment and the command line
+	-d, --desired-state=false: complete guest create and delete jobs from hypervisor desired state acks
 	-k, --kv="http://127.0.0.1:4001": address of kv server
+	    --kv-prefix="/lochness": root of the lochness keys in the kv, to share a kv cluster between lochness clusters
 	-p, --http=7544: http port to publish metrics. set to 0 to disable
 	-l, --log-level="warn": log level
+	    --log-max-backups=5: number of rotated log files kept
+	    --log-max-size=100: megabytes a log file grows to before it is rotated, 0 to never rotate it
+	    --log-output="stdout": where to log: stdout, stderr, syslog, journald, or the path of a file
+	-t, --lease-ttl=1m0s: how long a job is claimed by a worker between reservations
+	-m, --max-reclaims=3: number of times an abandoned job is requeued before it is failed
+	    --otlp-endpoint="": OTLP/HTTP collector (host:port) to send job traces to
+	-q, --queues="default": comma separated job queues to work on, in order of priority. later queues are worked on while earlier ones are empty
+	-r, --reap-interval=1m0s: how often to reclaim abandoned jobs and roll back abandoned changes. set to 0 to disable
+	-w, --workers=1: number of jobs to work on at the same time
 
 Multiple instances may be run at the same time.
 
+Workers
+
+Each of the --workers has its own beanstalk connection and works on one task
+at a time. Work on a guest is serialized across all workers and instances: a
+worker locks the guest in the kv while it handles a task, and a job claims the
+guest from when it starts until its task is removed. Tasks for a guest that is
+locked or claimed by another job are released back to the queue to be tried
+again later.
+
+Agents are reached through the proxies of the HTTP_PROXY, HTTPS_PROXY, and
+NO_PROXY environment variables, or --agent-proxy instead, and connecting to one
+may take up to --agent-dial-timeout.
+
+Job Queues
+
+Work on guests is queued in the job queue of their hypervisor, named by the
+"job-queue" config key of the hypervisor or of the cluster, e.g. one per zone,
+or in the "default" queue. Each worker takes tasks from the --queues, in order:
+it only takes a task from a queue while those before it have none ready, so
+idle workers steal work from queues they do not own rather than wait. A worker
+of zone a that helps zone b when idle runs with --queues=zone-a,zone-b. The
+tasks.stolen metric counts the tasks taken from other than the first queue.
+New guests' work is queued once cplacerd has placed them.
+
+Job Dependencies
+
+A job may depend on other jobs, see cguestd. A worker only starts it once they
+have all succeeded, releasing its task to be tried again until then, and fails
+it without starting it if one of them failed. The jobs.dependency.waiting and
+jobs.dependency.failed metrics count them.
+
+Tracing
+
+Every log entry of the work on a job has the "trace_id" of the job, the id of
+the request that queued it, see cguestd, or else the job's own id, and the calls
+made to the agent for it send the id in the X-Trace-ID header. With
+--otlp-endpoint, each handling of a job's task is a span, continuing the span
+of the request that queued it, and the agent calls are spans within it that
+pass on their W3C trace context.
+
+Abandoned Jobs
+
+Each time a worker reserves a task it records a lease on the job in the kv,
+lasting --lease-ttl. The lease is removed along with the task. A job whose
+lease has expired and whose task is no longer in beanstalk was abandoned by a
+worker that died. Every --reap-interval, such jobs are put back in the queue,
+up to --max-reclaims times, after which they are failed with "job lease
+expired". The jobs.reclaimed.requeued and jobs.reclaimed.failed metrics count
+them.
+
+Abandoned Changes
+
+Placing a guest on a hypervisor, which reserves an address, lists the guest on
+the hypervisor, and updates the guest, is saved in a single kv transaction on
+consul, or one by one under a lock on etcd, see lochness.Context.SaveAll.
+Changes made in several steps otherwise journal how to undo each step in the kv
+under /lochness/cleanup/ before making it, as placements by earlier workers did.
+A process that fails part way through undoes the steps itself, but one that dies
+leaves its journal behind, and it expires after 30 seconds without renewal.
+Every --reap-interval, the steps of expired journals are undone, newest first,
+unless the guest records the completed change. The journals.rolledback metric
+counts them.
+
+Images
+
+Before a guest is created, its image is fetched by the agent on its hypervisor.
+Guests created from a catalog image fetch that image, with its checksum for the
+agent to verify, and the state of the fetch on each hypervisor is recorded
+under the image in the kv. A hypervisor that has already fetched the image, with
+its current checksum, does not fetch it again. Guests without a catalog image
+fetch their flavor's image every time.
+
+Snapshots and Clones
+
+Snapshots are named for when their job started, e.g. 20160307T091244Z, and once
+one completes its name is recorded as the guest's "snapshot" metadata. Guests
+cloned from another, see cguestd, skip the image fetch, and are created by the
+agent of their hypervisor cloning the disks of the guest they are cloned from,
+or of its snapshot.
+
+Resizes
+
+A resize job has the agent resize the guest to the flavor recorded as its
+"resize_flavor", see cguestd. Once the job is done, that flavor becomes the
+guest's; if the job fails, it is dropped and the guest keeps its flavor.
+
+Desired State
+
+With --desired-state, fetch, create, and delete jobs are not sent to the agent.
+Hypervisors are expected to long-poll their desired state, from chypervisord or
+from the kv as nconverged does, and ack each generation they converge on. A
+create job is done once the hypervisor acks the generation that includes the
+guest. A delete job first removes the guest from its hypervisor and is done once
+the resulting generation is acked. A job fails if the hypervisor acks an error
+for its generation; once a later generation is acked, the job's is superseded
+and the job is done. Other guest actions still go through the agent.
+
+Guest States
+
+Once a create, start, shutdown, poweroff, reboot, restart, or suspend job
+completes, the state it leaves the guest in, running, shutdown, or suspended,
+is recorded as the "state" metadata of the guest. cguestd checks guest actions
+against it.
+
 Guest Action Workflow
 https://github.com/mistifyio/lochness/wiki/Guest-Action-%22Workflows%22
 */
@@ -2,7 +2,6 @@ package main
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
 	"net/http"
 	"time"
@@ -10,30 +9,54 @@ import (
 	log "github.com/Sirupsen/logrus"
 	"github.com/armon/go-metrics"
 	"github.com/bakins/go-metrics-map"
-	"github.com/kr/beanstalk"
 	"github.com/mistifyio/lochness"
+	lconfig "github.com/mistifyio/lochness/internal/config"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	"github.com/mistifyio/lochness/internal/logging"
+	"github.com/mistifyio/lochness/internal/worker"
 	"github.com/mistifyio/lochness/pkg/jobqueue"
 	"github.com/mistifyio/lochness/pkg/kv"
+	_ "github.com/mistifyio/lochness/pkg/kv/bolt"
 	_ "github.com/mistifyio/lochness/pkg/kv/consul"
-	"github.com/mistifyio/mistify-agent/config"
-	logx "github.com/mistifyio/mistify-logrus-ext"
 	flag "github.com/ogier/pflag"
 )
 
 func main() {
-	var port, agentPort uint
-	var kvAddr, bstalk, logLevel string
+	var port uint
+	var kvAddr, kvPrefix, logLevel, queues, otlpEndpoint string
+	cfg := worker.Config{}
 
 	// Command line flags
-	flag.StringVarP(&bstalk, "beanstalk", "b", "127.0.0.1:11300", "address of beanstalkd server")
+	flag.StringVarP(&cfg.Beanstalk, "beanstalk", "b", "127.0.0.1:11300", "address of beanstalkd server")
+	var logCfg logging.Config
 	flag.StringVarP(&logLevel, "log-level", "l", "warn", "log level")
+	logging.AddFlags(flag.CommandLine, &logCfg)
 	flag.StringVarP(&kvAddr, "kv", "k", "http://127.0.0.1:4001", "address of kv server")
-	flag.UintVarP(&agentPort, "agent-port", "a", uint(lochness.AgentPort), "port on which agents listen")
+	flag.StringVar(&kvPrefix, "kv-prefix", kv.DefaultPrefix, "root of the lochness keys in the kv, to share a kv cluster between lochness clusters")
+	flag.UintVarP(&cfg.AgentPort, "agent-port", "a", uint(lochness.AgentPort), "port on which agents listen")
+	flag.StringVar(&cfg.AgentProxy, "agent-proxy", "", `url of the proxy to connect to agents through, instead of those of $HTTP_PROXY, $HTTPS_PROXY, and $NO_PROXY, or "none" to connect directly`)
+	flag.DurationVar(&cfg.AgentDialTimeout, "agent-dial-timeout", 0, "how long connecting to an agent may take, 0 for the default of 30s")
 	flag.UintVarP(&port, "http", "p", 7544, "http port to publish metrics. set to 0 to disable")
+	flag.BoolVarP(&cfg.DesiredState, "desired-state", "d", false, "complete guest create and delete jobs from hypervisor desired state acks")
+	flag.UintVarP(&cfg.Workers, "workers", "w", 1, "number of jobs to work on at the same time")
+	flag.DurationVarP(&cfg.LeaseTTL, "lease-ttl", "t", time.Minute, "how long a job is claimed by a worker between reservations")
+	flag.DurationVarP(&cfg.ReapInterval, "reap-interval", "r", time.Minute, "how often to reclaim abandoned jobs and roll back abandoned changes. set to 0 to disable")
+	flag.IntVarP(&cfg.MaxReclaims, "max-reclaims", "m", 3, "number of times an abandoned job is requeued before it is failed")
+	flag.StringVarP(&queues, "queues", "q", jobqueue.DefaultQueue, "comma separated job queues to work on, in order of priority. later queues are worked on while earlier ones are empty")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "", "OTLP/HTTP collector (host:port) to send job traces to")
+	configFile := flag.String(lconfig.FlagName, "", lconfig.FlagUsage)
 	flag.Parse()
 
+	if err := lconfig.Load(lconfig.Pflag(flag.CommandLine), "cworkerd", *configFile); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "config.Load",
+			"file":  *configFile,
+		}).Fatal("failed to load config")
+	}
+
 	// Set up logger
-	if err := logx.DefaultSetup(logLevel); err != nil {
+	if err := logging.Setup(logLevel, "cworkerd", logCfg); err != nil {
 		log.WithFields(log.Fields{
 			"error": err,
 			"level": logLevel,
@@ -48,106 +71,43 @@ func main() {
 			"func":  "kv.New",
 		}).Fatal("unable to connect to kv")
 	}
+	KV = kv.WithPrefix(KV, kvPrefix)
 
-	ctx := lochness.NewContext(KV)
-
-	log.WithField("address", bstalk).Info("connection to beanstalk")
-	jobQueue, err := jobqueue.NewClient(bstalk, KV)
+	cfg.Queues, err = lochness.ParseJobQueues(queues)
 	if err != nil {
 		log.WithFields(log.Fields{
-			"error":   err,
-			"address": bstalk,
-		}).Fatal("failed to create jobQueue client")
-	}
-
-	// Set up metrics
-	m := setupMetrics(port)
-	if m != nil {
+			"error":  err,
+			"queues": queues,
+			"func":   "lochness.ParseJobQueues",
+		}).Fatal("invalid job queues")
 	}
 
-	agent := ctx.NewMistifyAgent(int(agentPort))
-
-	// Start consuming
-	for {
-		consume(jobQueue, agent, m)
-	}
-}
-
-func consume(jobQueue *jobqueue.Client, agent *lochness.MistifyAgent, m *metrics.Metrics) {
-	// Wait for and reserve a job
-	task, err := jobQueue.NextWorkTask()
-	if err != nil {
-		if bCE, ok := err.(beanstalk.ConnError); ok {
-			switch bCE {
-			case beanstalk.ErrTimeout:
-				// Empty queue
-				return
-			case beanstalk.ErrDeadline:
-				// See docs on beanstalkd deadline
-				// We're just going to sleep to let the deadline'd job expire
-				// and try to get another job
-				m.IncrCounter([]string{"beanstalk", "error", "deadline"}, 1)
-				log.Debug(beanstalk.ErrDeadline)
-				time.Sleep(5 * time.Second)
-				return
-			default:
-				// You have failed me for the last time
-				log.WithField("error", err).Fatal(err)
-			}
-		}
-
-		log.WithFields(log.Fields{
-			"task":  task,
-			"error": err,
-		}).Error("invalid task")
-
-		if task.Job != nil {
-			updateJobStatus(task, jobqueue.JobStatusError, err)
-		}
-		if err := task.Delete(); err != nil {
+	// Spans are exported in batches as jobs are worked on, until the daemon
+	// is killed
+	if otlpEndpoint != "" {
+		if _, err := httpmw.SetupTracing("cworkerd", otlpEndpoint); err != nil {
 			log.WithFields(log.Fields{
-				"task":  task.ID,
-				"error": err,
-			}).Error("unable to delete")
+				"error":    err,
+				"func":     "httpmw.SetupTracing",
+				"endpoint": otlpEndpoint,
+			}).Fatal("failed to set up tracing")
 		}
-		return
-	}
-
-	logFields := log.Fields{
-		"task": task,
 	}
 
-	// Handle the task in its current state. Remove task when appropriate.
-	removeTask, err := processTask(task, agent)
-
-	if removeTask {
-		if err != nil {
-			log.WithFields(logFields).WithField("error", err).Error(err)
-			if task.Job != nil {
-				updateJobStatus(task, jobqueue.JobStatusError, err)
-			}
-		} else {
-			updateJobStatus(task, jobqueue.JobStatusDone, nil)
-		}
-		if task.Job != nil {
-			log.WithFields(logFields).WithField("status", task.Job.Status).Info("job status info")
-		}
-
-		updateMetrics(task, m)
+	// Set up metrics
+	m := setupMetrics(port)
 
-		log.WithFields(logFields).Info("removing task")
-		if err := task.Delete(); err != nil {
-			log.WithFields(log.Fields{
-				"task":  task,
-				"error": err,
-			}).Error("unable to delete")
-		}
-	} else {
-		log.WithFields(logFields).Info("releasing task")
-		if err := task.Release(); err != nil {
-			log.WithFields(logFields).WithField("error", err).Fatal(err)
-		}
+	if err := worker.Start(KV, cfg, m); err != nil {
+		log.WithFields(log.Fields{
+			"error":   err,
+			"address": cfg.Beanstalk,
+			"workers": cfg.Workers,
+			"func":    "worker.Start",
+		}).Fatal("failed to start workers")
 	}
+
+	// Workers run until the process is killed
+	select {}
 }
 
 // setupMetrics creates the metric sink and starts an optional http server
@@ -172,130 +132,3 @@ func setupMetrics(port uint) *metrics.Metrics {
 
 	return m
 }
-
-func processTask(task *jobqueue.Task, agent *lochness.MistifyAgent) (bool, error) {
-	logFields := log.Fields{
-		"task": task,
-	}
-	log.WithFields(logFields).Info("reserved task")
-
-	switch task.Job.Status {
-	case jobqueue.JobStatusDone:
-		var err error
-		if task.Job.Action == "delete" {
-			err = postDelete(task)
-		}
-		return true, err
-	case jobqueue.JobStatusError:
-		return true, nil
-	case jobqueue.JobStatusNew:
-		if err := startJob(task, agent); err != nil {
-			return true, err
-		}
-	case jobqueue.JobStatusWorking:
-		if done, err := checkWorkingJob(task, agent); done || err != nil {
-			log.WithFields(log.Fields{
-				"task": task.ID,
-			}).Info("JOB DONE")
-
-			if err == nil && task.Job.Action == "delete" {
-				err = postDelete(task)
-			}
-			return true, err
-		}
-	}
-
-	return false, nil
-}
-
-func startJob(task *jobqueue.Task, agent *lochness.MistifyAgent) error {
-	job := task.Job
-
-	if task.Guest == nil {
-		return errors.New("guest does not exist")
-	}
-
-	var err error
-	var jobID string
-	switch job.Action {
-	case "fetch":
-		jobID, err = agent.FetchImage(task.Guest.ID)
-	case "create":
-		jobID, err = agent.CreateGuest(task.Guest.ID)
-	case "delete":
-		jobID, err = agent.DeleteGuest(task.Guest.ID)
-	default:
-		if _, ok := config.ValidActions[job.Action]; !ok {
-			return errors.New("invalid action")
-		}
-		jobID, err = agent.GuestAction(task.Guest.ID, job.Action)
-	}
-
-	if err != nil {
-		return err
-	}
-	task.Job.RemoteID = jobID
-	updateJobStatus(task, jobqueue.JobStatusWorking, nil)
-	return nil
-}
-
-func checkWorkingJob(task *jobqueue.Task, agent *lochness.MistifyAgent) (bool, error) {
-	done, err := agent.CheckJobStatus(task.Guest.ID, task.Job.RemoteID)
-	if err == nil && done && task.Job.Action == "fetch" {
-		task.Job.Action = "create"
-		task.Job.RemoteID = ""
-		task.Job.Status = jobqueue.JobStatusNew
-
-		done = false
-		// Save Job Status
-		if err := task.Job.Save(24 * time.Hour); err != nil {
-			log.WithFields(log.Fields{
-				"task":  task,
-				"error": err,
-			}).Error("unable to save")
-			return done, err
-		}
-	}
-
-	return done, err
-}
-
-func updateJobStatus(task *jobqueue.Task, status string, e error) {
-	task.Job.Status = status
-	if e != nil {
-		task.Job.Error = e.Error()
-	}
-	if task.Job.StartedAt.Equal(time.Time{}) {
-		task.Job.StartedAt = time.Now()
-	}
-	if status == jobqueue.JobStatusError || status == jobqueue.JobStatusDone {
-		task.Job.FinishedAt = time.Now()
-	}
-
-	// Save Job Status
-	if err := task.Job.Save(24 * time.Hour); err != nil {
-		log.WithFields(log.Fields{
-			"task":  task,
-			"error": err,
-		}).Error("unable to save")
-	}
-}
-
-func postDelete(task *jobqueue.Task) error {
-	log.WithFields(log.Fields{
-		"task": task,
-	}).Info("post delete")
-	return task.Guest.Destroy()
-}
-
-func updateMetrics(task *jobqueue.Task, m *metrics.Metrics) {
-	job := task.Job
-	m.MeasureSince([]string{"action", job.Action, "time"}, job.StartedAt)
-	m.MeasureSince([]string{"action", "time"}, job.StartedAt)
-	m.IncrCounter([]string{"action", job.Action, "count"}, 1)
-	m.IncrCounter([]string{"action", "count"}, 1)
-	if job.Error != "" {
-		m.IncrCounter([]string{"action", job.Action, "error"}, 1)
-		m.IncrCounter([]string{"action", "error"}, 1)
-	}
-}
@@ -12,8 +12,12 @@ The following arguments are understood:
 
 	Available Commands:
 	list        List the guests
+	watch       Print guest changes as they happen
 	create      Create guests asynchronously
+	validate    Check guest specs without creating anything
 	modify      Modify guests
+	clone       Clone guests asynchronously
+	resize      Resize guests asynchronously
 	delete      Delete guests asynchronously
 	shutdown    Shutdown guests asynchronously
 	reboot      Reboot guests asynchronously
@@ -21,13 +25,23 @@ The following arguments are understood:
 	poweroff    Poweroff guests asynchronously
 	start       Start guests asynchronously
 	suspend     Suspend guests asynchronously
+	console     Connect to the console of a guest
+	spread      Report guests not spread across failure domains
+	dns         List the DNS names of guests
 	job         Check status of guest jobs
+	completion  Generate shell completion scripts
 	help        Help about any command
 
 	Flags:
+	    --ca-cert="": file of CA certificates (PEM) to verify an https server with, instead of the system's
+	    --dial-timeout=0s: how long connecting to the server or proxy may take, 0 for the default of 30s
 	-h, --help=false: help for guest
 	-j, --json=false: output in json
+	    --pin="": base64 sha256 of the https server's public key, to trust instead of verifying its certificate chain
+	    --profile="": profile of the config file to use, instead of $LOCHNESS_PROFILE or the default one
+	    --proxy="": url of the proxy to connect through, instead of those of $HTTP_PROXY, $HTTPS_PROXY, and $NO_PROXY, or "none" to connect directly
 	-s, --server="http://localhost:18000/": server address to connect to
+	    --token="": token to send the server as a bearer token
 
 
 	Use "guest help [command]" for more information about a command.
@@ -35,6 +49,153 @@ The following arguments are understood:
 Input is supported via command line or stdin. Async actions queue a job, which
 can be checked on with the job command.
 
+Validate
+
+The validate command checks guest specs without creating anything, so CI
+pipelines can lint guest definitions before they are deployed. Each spec is
+checked against the JSON Schema of guests built into the command, the same as
+cguestd serves at /schemas/guest: it must have a "flavor" and a "network",
+valid ids, ip, mac, and data_encoding, and no fields a guest does not have.
+Nothing is sent to the server, unless --online is given, to also check that the
+flavor exists. The command exits with 4 if any spec is invalid.
+
+	$ guest validate '{"flavor":"33b6afce-c00f-4ad6-9db6-4822a710eb34","network":"c6430cba-648a-41aa-aee4-b59dacfc790d"}' '{"flavour":"small","ip":"10.100.101"}'
+	spec 1: ok
+	spec 2: invalid
+	  flavor: missing
+	  flavour: unknown field
+	  ip: invalid ip
+	  network: missing
+
+Watch
+
+The watch command prints each guest created, updated, or deleted, until
+interrupted, which is handy to follow a rollout or a guest being provisioned.
+It lists the guests every --interval, 2s by default, and the server answers
+with 304 Not Modified while nothing has changed. --metadata limits it to the
+guests that match, as for list.
+
+Batch Create
+
+With --batch, the create command creates the guests of a file, a json array of
+specs, or of stdin with --batch=-, in one request. They are all checked first,
+and the valid ones created together, so a batch does not stop half way through.
+With --all-or-nothing, no guest is created if any is invalid. The job of each
+guest created is printed, in the order of the specs, and each guest not created
+is reported on stderr, failing the command.
+
+	$ guest create --batch guests.json
+	fbd0c7c2-5532-4abc-b6d8-c0cef0e8c1eb
+	52a27964-aeb8-49b5-9267-b3e98571e32d
+
+	$ guest create --batch guests.json --all-or-nothing
+	{"error":"validation_failed","exit_code":4,"message":"failed to create guests","status":400,"fields":{...}}
+
+Clone
+
+The clone command creates a new guest from each guest given, with its flavor,
+network, and metadata, and a new MAC and IP, on the same hypervisor. The new
+guest's disks are cloned from the guest's current disks, or, with --snapshot,
+from its latest snapshot.
+
+	$ guest clone --snapshot f2011319-ad59-42fb-9bad-92e261f0651c
+
+Resize
+
+The resize command resizes each guest given to the vcpus, memory, and disk of
+the flavor given after it. Guests are resized on the hypervisor they are on, as
+their disks can not be moved, so a guest whose hypervisor does not have the
+resources the flavor adds is not resized. Disks can not shrink. The guest keeps
+its flavor, with the new one as its "resize_flavor", until the job is done.
+
+	$ guest resize f2011319-ad59-42fb-9bad-92e261f0651c 8c57735c-6217-4cde-9381-6e941967d973
+
+Console
+
+The console command connects to the vnc or serial console of a guest through
+cguestd, without needing to know which hypervisor the guest is on. It listens
+on a local port, random unless given with --listen, and prints the address.
+Each connection to it gets a one-time console token from cguestd and is
+proxied to the console until either side closes. With --stdio, stdin and
+stdout are connected to a single console session instead.
+
+Spread
+
+The spread command reports the guests of anti-affinity groups that are not
+spread across failure domains: those in a domain holding more of their group
+than an even spread across the domains would, e.g. two of three replicas in
+one rack while another rack has none. The domains are at the cluster's spread
+level, or that of --level. The ids of the guests are printed, or the violations
+with --json, and the command exits with 4 if there are any.
+
+	$ guest spread --level row --json
+	{"group":"db","domain":"z1/a","guests":["5f5538a9-c712-4dde-83d6-abdeebece444","94ea0ba1-5ec2-460e-9c2e-8269593cdad3"],"max":1}
+
+DNS
+
+Guests may be given a fully qualified DNS name, unique within its domain, with
+the dns_name of their spec. The dns command lists the names, and the id of the
+guest of each, of every domain or only of the one given:
+
+	$ guest dns example.com
+	db1.example.com 94ea0ba1-5ec2-460e-9c2e-8269593cdad3
+	web1.example.com 5f5538a9-c712-4dde-83d6-abdeebece444
+
+TLS
+
+An https --server is verified against the system's CA certificates, or those
+in --ca-cert. Alternatively, a server's public key can be pinned with --pin,
+e.g. for a self-signed certificate, in which case its certificate chain is not
+verified. The pin is the base64 sha256 of the public key, as used by curl's
+--pinnedpubkey:
+
+	$ openssl x509 -in cguestd.pem -pubkey -noout | openssl pkey -pubin -outform der | openssl dgst -sha256 -binary | base64
+	$ guest --server https://cguestd.example.com --pin 'sha256//47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=' list
+
+Proxies
+
+Requests go through the proxies of the HTTP_PROXY, HTTPS_PROXY, and NO_PROXY
+environment variables, or through the one given with --proxy instead, e.g.
+from a bastion host. "--proxy none" ignores the environment and connects
+directly. Consoles are tunneled through the proxy with CONNECT, so http and
+https proxies can carry them, but socks5 proxies only carry requests.
+--dial-timeout limits how long connecting to the server or proxy may take.
+
+	$ guest --proxy bastion.example.com:3128 --dial-timeout 5s list
+
+Profiles
+
+Settings can be kept in named profiles of a yaml config file, that of
+$LOCHNESS_CONFIG, or lochness/config in $XDG_CONFIG_HOME, ~/.config by
+default. A profile sets the server, of every cli or of each by name
+under servers, the ca-cert, pin, and token, and the output, json or text.
+--profile selects a profile, else $LOCHNESS_PROFILE, else the default one of
+the file. Flags given on the command line take precedence over the profile.
+The token, of the profile or --token, is sent as a bearer token.
+
+	default: staging
+	profiles:
+	  staging:
+	    server: https://lochnessd.staging.example.com
+	    ca-cert: /etc/lochness/staging-ca.pem
+	  prod:
+	    servers:
+	      guest: https://cguestd.example.com:18000
+	      hv: https://chypervisord.example.com:17000
+	    token: hunter2
+	    output: json
+
+	$ guest --profile prod list
+
+Completion
+
+Shell completion scripts for bash, zsh, and fish are generated by the
+completion command. Guest ids are completed by querying the server given with
+--server, or the default.
+
+	$ source <(guest completion bash)
+	$ guest completion fish > ~/.config/fish/completions/guest.fish
+
 Output
 
 All commands except job support two output formats, a list of ids or a list of JSON
@@ -48,6 +209,30 @@ a lochness.Guest for synchronous actions or the following for async actions:
 
 The job command either returns the job id or a JSON jobqueue.Job.
 
+The list command can also output a table, with --table, of each guest's id,
+name, ip, mac, hypervisor, and state. The name and state are those kept in the
+guest's metadata, under "name" and "state". The table can be sorted by any
+column with --sort, descending if prefixed with "-", and printed without its
+header with --no-header. Missing values are shown as "-".
+
+The list command lists only the guests with every key=value pair of metadata
+given by --metadata, which may be repeated.
+
+Exit Codes
+
+guest exits 0 on success and otherwise with:
+
+	1  error       any other failure
+	2  usage       bad arguments, flags, or ids
+	3  not_found   the server has no such resource
+	4  validation  an invalid spec, or a request the server rejected as invalid
+	5  server      the server could not be reached, failed, or sent a bad response
+
+The last line written to stderr of a failed run is a json object describing
+the failure, with its kind under "error":
+
+	{"error":"not_found","exit_code":3,"message":"failed to get guest","status":404,"fields":{...}}
+
 Examples
 
 List guests
@@ -63,6 +248,14 @@ List guests
 	$ guest list -j 1d1af312-1100-49e2-b3ad-09532ffc4e77
 	{"bridge":"br0","flavor":"1","fwgroup":"1234asdf-1234-asdf-1234-asdf1234asdf1234","hypervisor":"","id":"1d1af312-1100-49e2-b3ad-09532ffc4e77","ip":"10.100.101.34","mac":"e3:80:38:b2:28:a1","metadata":{},"network":"1234asdf-1234-asdf-1234-asdf1234asdf1234","subnet":"1234asdf-1234-asdf-1234-asdf1234asdf1234","type":"foo"}
 
+	$ guest list --metadata env=prod --metadata role=web
+	1d1af312-1100-49e2-b3ad-09532ffc4e77
+
+	$ guest list --table --sort -ip
+	ID                                    NAME  IP             MAC                HYPERVISOR  STATE
+	e41a5a67-b37b-4591-8f74-c1bd997ade84  db    10.100.101.55  7f:e3:d6:59:22:bd  -           -
+	1d1af312-1100-49e2-b3ad-09532ffc4e77  web   10.100.101.34  e3:80:38:b2:28:a1  -           -
+
 Create guests
 
 	$ guest create '{"bridge":"br0", "flavor":"1","fwgroup":"1234asdf-1234-asdf-1234-asdf1234asdf1234", "ip":"10.100.101.66", "mac":"A4-75-C1-6B-E3-49", "network":"1234asdf-1234-asdf-1234-asdf1234asdf1234","subnet":"1234asdf-1234-asdf-1234-asdf1234asdf1234","type":"foo"}' '{"bridge":"br0", "flavor":"1","fwgroup":"1234asdf-1234-asdf-1234-asdf1234asdf1234", "ip":"10.100.101.66", "mac":"A4-75-C1-6B-E3-49", "network":"1234asdf-1234-asdf-1234-asdf1234asdf1234","subnet":"1234asdf-1234-asdf-1234-asdf1234asdf1234","type":"foo"}'
@@ -72,6 +265,11 @@ Create guests
 	$ guest create -j '{"bridge":"br0", "flavor":"1","fwgroup":"1234asdf-1234-asdf-1234-asdf1234asdf1234", "ip":"10.100.101.66", "mac":"A4-75-C1-6B-E3-49", "network":"1234asdf-1234-asdf-1234-asdf1234asdf1234","subnet":"1234asdf-1234-asdf-1234-asdf1234asdf1234","type":"foo"}'
 	{"id":"fbd0c7c2-5532-4abc-b6d8-c0cef0e8c1eb","guest":{"bridge":"br0","flavor":"1","fwgroup":"1234asdf-1234-asdf-1234-asdf1234asdf1234","hypervisor":"","id":"e217e622-b30b-41c1-87ac-a249152b3f32","ip":"10.100.101.66","mac":"a4:75:c1:6b:e3:49","metadata":{},"network":"1234asdf-1234-asdf-1234-asdf1234asdf1234","subnet":"1234asdf-1234-asdf-1234-asdf1234asdf1234","type":"foo"}}
 
+Create guests with generated MACs, starting with an OUI instead of the server's
+
+	$ guest create --mac-oui 52:54:00 '{"flavor":"1","network":"1234asdf-1234-asdf-1234-asdf1234asdf1234"}'
+	fbd0c7c2-5532-4abc-b6d8-c0cef0e8c1eb
+
 Modify guests
 
 	$ guest modify e2aae131-eff7-41ae-8541-73a48eb5295d '{"type":"qwerty"}' 41a7d3ca-685e-4a57-bc61-dce3e33b6b09 '{"type":"zxcv"}'
@@ -81,6 +279,14 @@ Modify guests
 	$ guest modify -j e2aae131-eff7-41ae-8541-73a48eb5295d '{"type":"qwerty"}'
 	{"bridge":"br0","flavor":"1","fwgroup":"1234asdf-1234-asdf-1234-asdf1234asdf1234","hypervisor":"","id":"e2aae131-eff7-41ae-8541-73a48eb5295d","ip":"10.100.101.66","mac":"a4:75:c1:6b:e3:49","metadata":{},"network":"1234asdf-1234-asdf-1234-asdf1234asdf1234","subnet":"1234asdf-1234-asdf-1234-asdf1234asdf1234","type":"qwerty"}
 
+Set user-data and vendor-data from files, which are sent base64 encoded
+
+	$ guest create --user-data cloud-config.yaml '{"flavor":"1","network":"1234asdf-1234-asdf-1234-asdf1234asdf1234"}'
+	fbd0c7c2-5532-4abc-b6d8-c0cef0e8c1eb
+
+	$ guest modify --vendor-data vendor.yaml e2aae131-eff7-41ae-8541-73a48eb5295d '{}'
+	e2aae131-eff7-41ae-8541-73a48eb5295d
+
 Delete guests (also applies to shutdown, reboot, restart, poweroff, start,
 suspend)
 
@@ -90,6 +296,27 @@ suspend)
 	$ guest delete -j e2aae131-eff7-41ae-8541-73a48eb5295d
 	{"id":"14e13848-e449-405a-ae04-b4bbc9016ac5","guest":{"bridge":"br0","flavor":"1","fwgroup":"1234asdf-1234-asdf-1234-asdf1234asdf1234","hypervisor":"","id":"e2aae131-eff7-41ae-8541-73a48eb5295d","ip":"10.100.101.66","mac":"a4:75:c1:6b:e3:49","metadata":{},"network":"1234asdf-1234-asdf-1234-asdf1234asdf1234","subnet":"1234asdf-1234-asdf-1234-asdf1234asdf1234","type":"qwerty"}}
 
+Watch guests
+
+	$ guest watch --metadata env=prod
+	2026-10-16T10:02:11Z created fbd0c7c2-5532-4abc-b6d8-c0cef0e8c1eb
+	2026-10-16T10:02:15Z updated fbd0c7c2-5532-4abc-b6d8-c0cef0e8c1eb
+	2026-10-16T10:05:40Z deleted e2aae131-eff7-41ae-8541-73a48eb5295d
+
+	$ guest watch -j
+	{"guest":{"id":"fbd0c7c2-5532-4abc-b6d8-c0cef0e8c1eb","metadata":{"env":"prod"},...},"id":"fbd0c7c2-5532-4abc-b6d8-c0cef0e8c1eb","time":"2026-10-16T10:02:11Z","type":"created"}
+
+Connect to consoles
+
+	$ guest console e2aae131-eff7-41ae-8541-73a48eb5295d
+	127.0.0.1:41873
+	$ vncviewer 127.0.0.1:41873
+
+	$ guest console --listen 127.0.0.1:5900 e2aae131-eff7-41ae-8541-73a48eb5295d
+	127.0.0.1:5900
+
+	$ guest console --type serial --stdio e2aae131-eff7-41ae-8541-73a48eb5295d
+
 Job status
 
 	$ guest job a18d2ad3-64ed-47cd-9b3b-733542b9b51c
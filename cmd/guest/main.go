@@ -1,15 +1,23 @@
 package main
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
 	"os"
 	"sort"
+	"time"
 	"unicode"
 	"unicode/utf8"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/andrew-d/go-termutil"
 	"github.com/mistifyio/lochness/internal/cli"
+	"github.com/mistifyio/lochness/internal/tunnel"
 	"github.com/spf13/cobra"
 )
 
@@ -17,16 +25,89 @@ var (
 	server  = "http://localhost:18000/"
 	jsonout = false
 	t       = "application/json"
+	caCert  = ""
+	pin     = ""
+
+	transportOpts = cli.TransportOptions{}
+	profileOpts   = cli.ProfileOptions{}
+
+	userDataFile   = ""
+	vendorDataFile = ""
+	macOUI         = ""
+	fromSnapshot   = false
+	batchFile      = ""
+	allOrNothing   = false
+
+	metadataFilters = []string{}
+	online          = false
+	spreadLevel     = ""
+
+	watchInterval = 2 * time.Second
+
+	consoleType   = "vnc"
+	consoleListen = "127.0.0.1:0"
+	consoleStdio  = false
+
+	tableOpts  = cli.TableOptions{}
+	guestTable = cli.Table{
+		{Header: "ID", Key: "id"},
+		{Header: "NAME", Key: "metadata.name"},
+		{Header: "IP", Key: "ip"},
+		{Header: "MAC", Key: "mac"},
+		{Header: "HYPERVISOR", Key: "hypervisor"},
+		{Header: "STATE", Key: "metadata.state"},
+	}
 )
 
+// newClient creates a client for the server, verifying https servers as set by
+// --ca-cert and --pin, and connecting as set by --proxy and --dial-timeout,
+// authenticating with --token
+func newClient() *cli.Client {
+	applyProfile()
+	c := cli.NewClient(server)
+	if err := c.ConfigureTransport(transportOpts); err != nil {
+		cli.Fatal(cli.ExitUsage, log.Fields{
+			"proxy":        transportOpts.Proxy,
+			"dial-timeout": transportOpts.DialTimeout,
+			"error":        err,
+		}, "invalid transport settings")
+	}
+	if err := c.ConfigureTLS(caCert, pin); err != nil {
+		cli.Fatal(cli.ExitUsage, log.Fields{
+			"ca-cert": caCert,
+			"pin":     pin,
+			"error":   err,
+		}, "invalid tls settings")
+	}
+	if err := c.SetToken(profileOpts.Token); err != nil {
+		cli.Fatal(cli.ExitUsage, log.Fields{"error": err}, "invalid token")
+	}
+	return c
+}
+
+// applyProfile sets the flags not given from the profile of the config file
+func applyProfile() {
+	if err := profileOpts.Apply(); err != nil {
+		cli.Fatal(cli.ExitUsage, log.Fields{
+			"config":  cli.ConfigPath(),
+			"profile": profileOpts.Name,
+			"error":   err,
+		}, "invalid profile")
+	}
+}
+
 func help(cmd *cobra.Command, _ []string) {
 	if err := cmd.Help(); err != nil {
-		log.WithField("error", err).Fatal("help")
+		cli.Fatal(cli.ExitError, log.Fields{"error": err}, "help")
 	}
 }
 
 func getGuests(c *cli.Client) []cli.JMap {
-	ret, _ := c.GetMany("guests", "guests")
+	ret, _ := c.GetMany("guests", "guests"+cli.MetadataQuery(metadataFilters))
+	return toJMaps(ret)
+}
+
+func toJMaps(ret []map[string]interface{}) []cli.JMap {
 	guests := make([]cli.JMap, len(ret))
 	for i := range ret {
 		guests[i] = ret[i]
@@ -40,7 +121,57 @@ func getGuest(c *cli.Client, id string) cli.JMap {
 }
 
 func createGuest(c *cli.Client, spec string) cli.JMap {
-	guest, resp := c.Post("guest", "guests", spec)
+	endpoint := "guests"
+	if macOUI != "" {
+		endpoint += "?" + url.Values{"mac_oui": {macOUI}}.Encode()
+	}
+	guest, resp := c.Post("guest", endpoint, spec)
+	j := cli.JMap{
+		"id":    resp.Header.Get("x-guest-job-id"),
+		"guest": guest,
+	}
+	return j
+}
+
+// createGuests creates the guests of specs in one batch, returning the result
+// of each spec, see cguestd
+func createGuests(c *cli.Client, specs []json.RawMessage) []map[string]interface{} {
+	query := url.Values{}
+	if macOUI != "" {
+		query.Set("mac_oui", macOUI)
+	}
+	if allOrNothing {
+		query.Set("all_or_nothing", "true")
+	}
+	endpoint := "guests/batch"
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+	body, err := json.Marshal(specs)
+	if err != nil {
+		cli.Fatal(cli.ExitError, log.Fields{"error": err}, "failed to marshal specs")
+	}
+	results, _ := c.PostMany("guests", endpoint, string(body))
+	return results
+}
+
+func cloneGuest(c *cli.Client, id string) cli.JMap {
+	endpoint := "guests/" + id + "/clone"
+	if macOUI != "" {
+		endpoint += "?" + url.Values{"mac_oui": {macOUI}}.Encode()
+	}
+	spec, _ := json.Marshal(map[string]bool{"snapshot": fromSnapshot})
+	guest, resp := c.Post("guest", endpoint, string(spec))
+	j := cli.JMap{
+		"id":    resp.Header.Get("x-guest-job-id"),
+		"guest": guest,
+	}
+	return j
+}
+
+func resizeGuest(c *cli.Client, id string, flavor string) cli.JMap {
+	spec, _ := json.Marshal(map[string]string{"flavor": flavor})
+	guest, resp := c.Post("guest", "guests/"+id+"/resize", string(spec))
 	j := cli.JMap{
 		"id":    resp.Header.Get("x-guest-job-id"),
 		"guest": guest,
@@ -72,13 +203,109 @@ func guestAction(c *cli.Client, id, action string) cli.JMap {
 	return j
 }
 
+// addData sets the user_data and vendor_data of a spec from the files given
+// with --user-data and --vendor-data. The data in the spec is sent base64
+// encoded, so any other data, in the spec or in the current guest, is
+// re-encoded to match.
+func addData(spec string, current cli.JMap) string {
+	if userDataFile == "" && vendorDataFile == "" {
+		return spec
+	}
+
+	j := cli.JMap{}
+	if err := json.Unmarshal([]byte(spec), &j); err != nil {
+		cli.Fatal(cli.ExitValidation, log.Fields{
+			"spec":  spec,
+			"error": err,
+		}, "invalid spec")
+	}
+
+	specEncoding, _ := j["data_encoding"].(string)
+	currentEncoding, _ := current["data_encoding"].(string)
+	if specEncoding == "" {
+		specEncoding = currentEncoding
+	}
+
+	files := map[string]string{"user_data": userDataFile, "vendor_data": vendorDataFile}
+	for field, file := range files {
+		var data []byte
+		var err error
+		if file != "" {
+			data, err = ioutil.ReadFile(file)
+		} else if value, ok := j[field].(string); ok {
+			data, err = decodeData(value, specEncoding)
+		} else if value, ok := current[field].(string); ok {
+			data, err = decodeData(value, currentEncoding)
+		}
+		if err != nil {
+			cli.Fatal(cli.ExitError, log.Fields{
+				"field": field,
+				"file":  file,
+				"error": err,
+			}, "failed to read data")
+		}
+		if data != nil {
+			j[field] = base64.StdEncoding.EncodeToString(data)
+		}
+	}
+	j["data_encoding"] = "base64"
+
+	return j.String()
+}
+
+// decodeData decodes user-data or vendor-data of a guest
+func decodeData(data, encoding string) ([]byte, error) {
+	if encoding == "base64" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	return []byte(data), nil
+}
+
+// dialConsole gets a one-time console token for a guest and connects to the
+// console with it, through the server
+func dialConsole(c *cli.Client, id string) (net.Conn, error) {
+	token, _ := c.Post("console token", fmt.Sprintf("guests/%s/console", id), cli.JMap{"type": consoleType}.String())
+	t, _ := token["token"].(string)
+	return tunnel.DialTransport(c.Transport(), c.URLString("console/"+t), nil)
+}
+
+// proxyConsole connects a local connection to the console of a guest
+func proxyConsole(c *cli.Client, id string, local io.ReadWriteCloser) {
+	remote, err := dialConsole(c, id)
+	if err != nil {
+		_ = local.Close()
+		log.WithFields(log.Fields{
+			"guest": id,
+			"error": err,
+		}).Error("failed to connect to console")
+		return
+	}
+	tunnel.Join(local, remote)
+}
+
+// stdio is stdin and stdout as a single stream
+type stdio struct {
+	io.Reader
+	io.Writer
+}
+
+// Close closes stdin
+func (s stdio) Close() error {
+	return os.Stdin.Close()
+}
+
 func getJob(c *cli.Client, id string) cli.JMap {
 	job, _ := c.Get("job", "jobs/"+id)
 	return job
 }
 
+// listGuestIDs fetches the guest ids for completion
+func listGuestIDs() ([]string, error) {
+	return newClient().ListIDs("guests")
+}
+
 func list(cmd *cobra.Command, args []string) {
-	c := cli.NewClient(server)
+	c := newClient()
 	guests := []cli.JMap{}
 	if len(args) == 0 {
 		if termutil.Isatty(os.Stdin.Fd()) {
@@ -95,31 +322,199 @@ func list(cmd *cobra.Command, args []string) {
 		}
 	}
 
+	if tableOpts.Table && !jsonout {
+		if err := guestTable.Print(os.Stdout, guests, tableOpts); err != nil {
+			cli.Fatal(cli.ExitError, log.Fields{"error": err}, "failed to print table")
+		}
+		return
+	}
+
 	for _, guest := range guests {
 		guest.Print(jsonout)
 	}
 }
 
+// watch polls the guests every watchInterval, skipping unchanged listings by
+// their ETag, and prints the guests created, updated, and deleted since the
+// last listing
+func watch(cmd *cobra.Command, _ []string) {
+	c := newClient()
+	endpoint := "guests" + cli.MetadataQuery(metadataFilters)
+
+	var guests []cli.JMap
+	etag := ""
+	for first := true; ; first = false {
+		ret, newETag, changed := c.GetManyIfChanged("guests", endpoint, etag)
+		if changed {
+			current := toJMaps(ret)
+			if !first {
+				now := time.Now()
+				for _, change := range cli.Changes(guests, current) {
+					change.Print(jsonout, "guest", now)
+				}
+			}
+			guests, etag = current, newETag
+		}
+		time.Sleep(watchInterval)
+	}
+}
+
 func create(cmd *cobra.Command, specs []string) {
-	c := cli.NewClient(server)
+	c := newClient()
+	if batchFile != "" {
+		if len(specs) > 0 {
+			cli.Fatal(cli.ExitUsage, log.Fields{"num": len(specs)}, "specs may not be given with --batch")
+		}
+		createBatch(c)
+		return
+	}
 	if len(specs) == 0 {
 		specs = cli.Read(os.Stdin)
 	}
 
 	for _, spec := range specs {
 		cli.AssertSpec(spec)
-		j := createGuest(c, spec)
+		j := createGuest(c, addData(spec, nil))
+		j.Print(jsonout)
+	}
+}
+
+// createBatch creates the guests of the specs of the --batch file, a json array,
+// printing the job and guest of each created, and failing if any was not
+func createBatch(c *cli.Client) {
+	var data []byte
+	var err error
+	if batchFile == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(batchFile)
+	}
+	if err != nil {
+		cli.Fatal(cli.ExitError, log.Fields{"file": batchFile, "error": err}, "failed to read batch")
+	}
+	specs := []json.RawMessage{}
+	if err := json.Unmarshal(data, &specs); err != nil {
+		cli.Fatal(cli.ExitValidation, log.Fields{"file": batchFile, "error": err}, "invalid batch: must be a json array of specs")
+	}
+	for i, spec := range specs {
+		cli.AssertSpec(string(spec))
+		specs[i] = json.RawMessage(addData(string(spec), nil))
+	}
+
+	failed := 0
+	for i, result := range createGuests(c, specs) {
+		job, _ := result["job"].(string)
+		if job == "" {
+			failed++
+			log.WithFields(log.Fields{
+				"index":   i,
+				"error":   result["error"],
+				"message": result["message"],
+			}).Error("guest not created")
+			continue
+		}
+		j := cli.JMap{
+			"id":    job,
+			"guest": result["guest"],
+		}
+		j.Print(jsonout)
+	}
+	if failed > 0 {
+		cli.Fatal(cli.ExitValidation, log.Fields{"failed": failed, "total": len(specs)}, "failed to create some guests")
+	}
+}
+
+// validateGuestSpec returns what is wrong with a guest spec, checking it against
+// the guest schema, and that its flavor exists with the client, if given
+func validateGuestSpec(c *cli.Client, spec string) []string {
+	problems := cli.CheckSpec("guest", spec)
+	if c == nil || len(problems) > 0 {
+		return problems
+	}
+	j := cli.JMap{}
+	_ = json.Unmarshal([]byte(spec), &j)
+	flavor, _ := j["flavor"].(string)
+	if !c.Exists("flavor", "flavors/"+flavor) {
+		problems = append(problems, "flavor: not found")
+	}
+	return problems
+}
+
+func validate(cmd *cobra.Command, specs []string) {
+	var c *cli.Client
+	if online {
+		c = newClient()
+	}
+	if len(specs) == 0 {
+		specs = cli.Read(os.Stdin)
+	}
+
+	invalid := 0
+	for i, spec := range specs {
+		problems := validateGuestSpec(c, spec)
+		if len(problems) > 0 {
+			invalid++
+		}
+		if jsonout {
+			cli.JMap{"spec": i + 1, "valid": len(problems) == 0, "errors": problems}.Print(true)
+			continue
+		}
+		if len(problems) == 0 {
+			fmt.Printf("spec %d: ok\n", i+1)
+			continue
+		}
+		fmt.Printf("spec %d: invalid\n", i+1)
+		for _, problem := range problems {
+			fmt.Printf("  %s\n", problem)
+		}
+	}
+	if invalid > 0 {
+		cli.Fatal(cli.ExitValidation, log.Fields{
+			"invalid": invalid,
+			"specs":   len(specs),
+		}, "invalid guest specs")
+	}
+}
+
+func clone(cmd *cobra.Command, ids []string) {
+	c := newClient()
+	if len(ids) == 0 {
+		ids = cli.Read(os.Stdin)
+	}
+
+	for _, id := range ids {
+		cli.AssertID(id)
+		j := cloneGuest(c, id)
+		j.Print(jsonout)
+	}
+}
+
+func resize(cmd *cobra.Command, args []string) {
+	c := newClient()
+	if len(args) == 0 {
+		args = cli.Read(os.Stdin)
+	}
+	if len(args)%2 != 0 {
+		cli.Fatal(cli.ExitUsage, log.Fields{"num": len(args)}, "expected an even number of args")
+	}
+
+	for i := 0; i < len(args); i += 2 {
+		id := args[i]
+		cli.AssertID(id)
+		flavor := args[i+1]
+		cli.AssertID(flavor)
+		j := resizeGuest(c, id, flavor)
 		j.Print(jsonout)
 	}
 }
 
 func modify(cmd *cobra.Command, args []string) {
-	c := cli.NewClient(server)
+	c := newClient()
 	if len(args) == 0 {
 		args = cli.Read(os.Stdin)
 	}
 	if len(args)%2 != 0 {
-		log.WithField("num", len(args)).Fatal("expected an even number of args")
+		cli.Fatal(cli.ExitUsage, log.Fields{"num": len(args)}, "expected an even number of args")
 	}
 
 	for i := 0; i < len(args); i += 2 {
@@ -127,6 +522,9 @@ func modify(cmd *cobra.Command, args []string) {
 		cli.AssertID(id)
 		spec := args[i+1]
 		cli.AssertSpec(spec)
+		if userDataFile != "" || vendorDataFile != "" {
+			spec = addData(spec, getGuest(c, id))
+		}
 
 		guest := modifyGuest(c, id, spec)
 		guest.Print(jsonout)
@@ -134,7 +532,7 @@ func modify(cmd *cobra.Command, args []string) {
 }
 
 func del(cmd *cobra.Command, ids []string) {
-	c := cli.NewClient(server)
+	c := newClient()
 	if len(ids) == 0 {
 		ids = cli.Read(os.Stdin)
 	}
@@ -148,7 +546,7 @@ func del(cmd *cobra.Command, ids []string) {
 
 func generateActionHandler(action string) func(*cobra.Command, []string) {
 	return func(cmd *cobra.Command, ids []string) {
-		c := cli.NewClient(server)
+		c := newClient()
 		if len(ids) == 0 {
 			ids = cli.Read(os.Stdin)
 		}
@@ -162,7 +560,7 @@ func generateActionHandler(action string) func(*cobra.Command, []string) {
 }
 
 func job(cmd *cobra.Command, ids []string) {
-	c := cli.NewClient(server)
+	c := newClient()
 	if len(ids) == 0 {
 		ids = cli.Read(os.Stdin)
 	}
@@ -174,42 +572,191 @@ func job(cmd *cobra.Command, ids []string) {
 	}
 }
 
+// spread prints the guests of anti-affinity groups that are not spread across
+// failure domains, failing if there are any
+func spread(cmd *cobra.Command, _ []string) {
+	c := newClient()
+	endpoint := "guests/spread"
+	if spreadLevel != "" {
+		endpoint += "?" + url.Values{"level": {spreadLevel}}.Encode()
+	}
+	violations, _ := c.GetMany("spread violations", endpoint)
+
+	for _, violation := range toJMaps(violations) {
+		if jsonout {
+			violation.Print(true)
+			continue
+		}
+		guests, _ := violation["guests"].([]interface{})
+		for _, guest := range guests {
+			fmt.Println(guest)
+		}
+	}
+	if len(violations) > 0 {
+		cli.Fatal(cli.ExitValidation, log.Fields{"violations": len(violations)}, "guests not spread across failure domains")
+	}
+}
+
+// dns prints the DNS names of the guests, those in a domain if one is given
+func dns(cmd *cobra.Command, args []string) {
+	c := newClient()
+	endpoint := "guests/dns"
+	if len(args) > 0 {
+		endpoint += "?" + url.Values{"domain": {args[0]}}.Encode()
+	}
+	records, _ := c.GetMany("dns records", endpoint)
+
+	for _, record := range toJMaps(records) {
+		if jsonout {
+			record.Print(true)
+			continue
+		}
+		fmt.Println(record["name"], record["guest"])
+	}
+}
+
+func console(cmd *cobra.Command, ids []string) {
+	c := newClient()
+	id := ids[0]
+	cli.AssertID(id)
+
+	if consoleStdio {
+		remote, err := dialConsole(c, id)
+		if err != nil {
+			cli.Fatal(cli.ExitServer, log.Fields{
+				"guest": id,
+				"error": err,
+			}, "failed to connect to console")
+		}
+		tunnel.Join(stdio{os.Stdin, os.Stdout}, remote)
+		return
+	}
+
+	l, err := net.Listen("tcp", consoleListen)
+	if err != nil {
+		cli.Fatal(cli.ExitError, log.Fields{
+			"address": consoleListen,
+			"error":   err,
+		}, "failed to listen")
+	}
+	if jsonout {
+		cli.JMap{"guest": id, "type": consoleType, "address": l.Addr().String()}.Print(true)
+	} else {
+		fmt.Println(l.Addr())
+	}
+
+	// Every connection gets its own token
+	for {
+		local, err := l.Accept()
+		if err != nil {
+			cli.Fatal(cli.ExitError, log.Fields{"error": err}, "failed to accept connection")
+		}
+		go proxyConsole(c, id, local)
+	}
+}
+
 func main() {
 	root := &cobra.Command{
 		Use:  "guest",
 		Long: "guest is the cli interface to cguestd. All commands support arguments via command line or stdin.",
 		Run:  help,
+
+		PersistentPreRun: func(*cobra.Command, []string) { applyProfile() },
 	}
 	root.PersistentFlags().BoolVarP(&jsonout, "json", "j", jsonout, "output in json")
 	root.PersistentFlags().StringVarP(&server, "server", "s", server, "server address to connect to")
+	root.PersistentFlags().StringVar(&caCert, "ca-cert", caCert, "file of CA certificates (PEM) to verify an https server with, instead of the system's")
+	root.PersistentFlags().StringVar(&pin, "pin", pin, "base64 sha256 of the https server's public key, to trust instead of verifying its certificate chain")
+	transportOpts.AddFlags(root.PersistentFlags())
+	profileOpts.AddFlags(root.PersistentFlags(), "guest")
 
 	cmdList := &cobra.Command{
 		Use:   "list [<id>...]",
 		Short: "List the guests",
 		Run:   list,
+
+		ValidArgsFunction: cli.CompleteIDs(listGuestIDs),
 	}
+	tableOpts.AddFlags(cmdList.Flags())
+	cmdList.Flags().StringArrayVar(&metadataFilters, "metadata", metadataFilters, "only list guests with this key=value metadata. may be repeated")
 	root.AddCommand(cmdList)
 
+	cmdWatch := &cobra.Command{
+		Use:   "watch",
+		Short: "Print guest changes as they happen",
+		Long:  `Watch the guests, printing each guest created, updated, or deleted, until interrupted. Guests that exist when the watch starts are not printed.`,
+		Args:  cobra.NoArgs,
+		Run:   watch,
+	}
+	cmdWatch.Flags().StringArrayVar(&metadataFilters, "metadata", metadataFilters, "only watch guests with this key=value metadata. may be repeated")
+	cmdWatch.Flags().DurationVarP(&watchInterval, "interval", "i", watchInterval, "how often to check for changes")
+	root.AddCommand(cmdWatch)
+
 	cmdCreate := &cobra.Command{
 		Use:   "create <spec>...",
 		Short: "Create guests asynchronously",
-		Long:  `Create new guest(s) using "spec"(s) as the initial values. Where "spec" is a valid json string.`,
+		Long:  `Create new guest(s) using "spec"(s) as the initial values. Where "spec" is a valid json string. Guests without a "mac" are given a generated one that no other guest has.`,
 		Run:   create,
 	}
+	cmdCreate.Flags().StringVar(&macOUI, "mac-oui", macOUI, "OUI of the MACs generated for guests without one, e.g. 52:54:00, instead of the server's")
+	cmdCreate.Flags().StringVar(&batchFile, "batch", batchFile, "file of a json array of specs to create at once, - for stdin, instead of the specs given")
+	cmdCreate.Flags().BoolVar(&allOrNothing, "all-or-nothing", allOrNothing, "with --batch, create no guest if any is invalid")
+	cmdCreate.Flags().StringVarP(&userDataFile, "user-data", "u", userDataFile, "file of user-data to give the guest(s)")
+	cmdCreate.Flags().StringVarP(&vendorDataFile, "vendor-data", "v", vendorDataFile, "file of vendor-data to give the guest(s)")
 	root.AddCommand(cmdCreate)
 
+	cmdValidate := &cobra.Command{
+		Use:   "validate <spec>...",
+		Short: "Check guest specs without creating anything",
+		Long: `Check that each "spec" is one create would accept: valid json with a "flavor"
+and "network", valid ids, ip, and mac, and no unknown fields. Nothing is sent to
+the server, unless --online is given, to check that the flavor exists. Exits with
+4 if any spec is invalid, so specs can be linted before they are deployed.`,
+		Run: validate,
+	}
+	cmdValidate.Flags().BoolVar(&online, "online", online, "also check with the server that the flavor exists")
+	root.AddCommand(cmdValidate)
+
+	cmdClone := &cobra.Command{
+		Use:   "clone <id>...",
+		Short: "Clone guests asynchronously",
+		Long:  `Create a new guest from each given guest, with its flavor, network, and metadata, and a new MAC and IP, on the same hypervisor. The new guest's disks are cloned from the guest's, or from its latest snapshot with --snapshot.`,
+		Run:   clone,
+
+		ValidArgsFunction: cli.CompleteIDs(listGuestIDs),
+	}
+	cmdClone.Flags().BoolVar(&fromSnapshot, "snapshot", fromSnapshot, "clone the latest snapshot of the guest(s) instead of their current disks")
+	cmdClone.Flags().StringVar(&macOUI, "mac-oui", macOUI, "OUI of the MACs generated for the clones, e.g. 52:54:00, instead of the server's")
+	root.AddCommand(cmdClone)
+
+	cmdResize := &cobra.Command{
+		Use:   "resize (<id> <flavor>)...",
+		Short: "Resize guests asynchronously",
+		Long:  `Resize given guest(s) to the vcpus, memory, and disk of "flavor", on the hypervisor they are on. Disks can not shrink, and guests whose hypervisor lacks the resources the flavor adds are not resized.`,
+		Run:   resize,
+
+		ValidArgsFunction: cli.CompleteIDPairs(listGuestIDs),
+	}
+	root.AddCommand(cmdResize)
+
 	cmdModify := &cobra.Command{
 		Use:   "modify (<id> <spec>)...",
 		Short: "Modify guests",
 		Long:  `Modify given guest(s). Where "spec" is a valid json string.`,
 		Run:   modify,
+
+		ValidArgsFunction: cli.CompleteIDPairs(listGuestIDs),
 	}
+	cmdModify.Flags().StringVarP(&userDataFile, "user-data", "u", userDataFile, "file of user-data to give the guest(s)")
+	cmdModify.Flags().StringVarP(&vendorDataFile, "vendor-data", "v", vendorDataFile, "file of vendor-data to give the guest(s)")
 	root.AddCommand(cmdModify)
 
 	cmdDelete := &cobra.Command{
 		Use:   "delete <id>...",
 		Short: "Delete guests asynchronously",
 		Run:   del,
+
+		ValidArgsFunction: cli.CompleteIDs(listGuestIDs),
 	}
 	root.AddCommand(cmdDelete)
 
@@ -219,10 +766,45 @@ func main() {
 			Use:   fmt.Sprintf("%s <id>...", action),
 			Short: fmt.Sprintf("%s guests asynchronously", string(unicode.ToUpper(a))+action[n:]),
 			Run:   generateActionHandler(action),
+
+			ValidArgsFunction: cli.CompleteIDs(listGuestIDs),
 		}
 		root.AddCommand(cmdAction)
 	}
 
+	cmdConsole := &cobra.Command{
+		Use:   "console <id>",
+		Short: "Connect to the console of a guest",
+		Long:  `Connect to the vnc or serial console of a guest through the server. A local port is listened on, and each connection to it is proxied to the console, until interrupted. With --stdio, stdin and stdout are connected to the console instead.`,
+		Args:  cobra.ExactArgs(1),
+		Run:   console,
+
+		ValidArgsFunction: cli.CompleteIDs(listGuestIDs),
+	}
+	cmdConsole.Flags().StringVarP(&consoleType, "type", "t", consoleType, "console type, vnc or serial")
+	cmdConsole.Flags().StringVarP(&consoleListen, "listen", "l", consoleListen, "local address to listen on, a random port by default")
+	cmdConsole.Flags().BoolVar(&consoleStdio, "stdio", consoleStdio, "connect stdin and stdout to the console instead of listening")
+	root.AddCommand(cmdConsole)
+
+	cmdSpread := &cobra.Command{
+		Use:   "spread",
+		Short: "Report guests not spread across failure domains",
+		Long:  `Report the guests of anti-affinity groups in failure domains holding more of their group than an even spread across the domains would. Exits with 4 if there are any, so the spread can be checked periodically.`,
+		Args:  cobra.NoArgs,
+		Run:   spread,
+	}
+	cmdSpread.Flags().StringVar(&spreadLevel, "level", spreadLevel, "level of the failure domains, zone, row, or rack, instead of the cluster's")
+	root.AddCommand(cmdSpread)
+
+	cmdDNS := &cobra.Command{
+		Use:   "dns [domain]",
+		Short: "List the DNS names of guests",
+		Long:  `List the DNS names of the guests, with the id of the guest of each, sorted by domain and host. Given a domain, only the names in it are listed, not those of its subdomains.`,
+		Args:  cobra.MaximumNArgs(1),
+		Run:   dns,
+	}
+	root.AddCommand(cmdDNS)
+
 	cmdJob := &cobra.Command{
 		Use:   "job <id>...",
 		Short: "Check status of guest jobs",
@@ -230,7 +812,9 @@ func main() {
 	}
 	root.AddCommand(cmdJob)
 
+	root.AddCommand(cli.CompletionCmd(root))
+
 	if err := root.Execute(); err != nil {
-		log.WithField("error", err).Fatal("failed to execute root command")
+		cli.Fatal(cli.ExitUsage, log.Fields{"error": err}, "failed to execute root command")
 	}
 }
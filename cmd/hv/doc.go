@@ -24,19 +24,113 @@ The following arguments are understood:
 	create      Create new hypervisors
 	delete      Delete hypervisors
 	modify      Modify hypervisors
-	guests      Operate on hypervisor guests
+	guests      List the guests resident on hypervisors
+	capacity    Show the resource capacity of hypervisors
+	top         Show a live dashboard of the hypervisors
+	power       Control the power of hypervisors through their BMC
+	token       Create a bootstrap token for netbooted nodes to register with
 	config      Operate on hypervisor config
+	expected    Operate on the config expected of hypervisors
+	drift       Show how hypervisors drifted from their expected config
 	subnets     Operate on hypervisor subnets
+	upgrade     Operate on rolling upgrades of hypervisors
+	completion  Generate shell completion scripts
 	help        Help about any command
 
 	Flags:
+	    --ca-cert="": file of CA certificates (PEM) to verify an https server with, instead of the system's
+	    --dial-timeout=0s: how long connecting to the server or proxy may take, 0 for the default of 30s
 	-h, --help=false: help for hv
 	-j, --json=false: output in json
+	    --pin="": base64 sha256 of the https server's public key, to trust instead of verifying its certificate chain
+	    --profile="": profile of the config file to use, instead of $LOCHNESS_PROFILE or the default one
+	    --proxy="": url of the proxy to connect through, instead of those of $HTTP_PROXY, $HTTPS_PROXY, and $NO_PROXY, or "none" to connect directly
 	-s, --server="http://localhost:17000": server address to connect to
+	    --token="": token to send the server as a bearer token
 
 
 	Use "hv help [command]" for more information about a command.
 
+TLS
+
+An https --server is verified against the system's CA certificates, or those
+in --ca-cert. Alternatively, a server's public key can be pinned with --pin,
+e.g. for a self-signed certificate, in which case its certificate chain is not
+verified. The pin is the base64 sha256 of the public key, as used by curl's
+--pinnedpubkey:
+
+	$ openssl x509 -in chypervisord.pem -pubkey -noout | openssl pkey -pubin -outform der | openssl dgst -sha256 -binary | base64
+	$ hv --server https://chypervisord.example.com --pin 'sha256//47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=' list
+
+Profiles
+
+Settings can be kept in named profiles of a yaml config file, that of
+$LOCHNESS_CONFIG, or lochness/config in $XDG_CONFIG_HOME, ~/.config by
+default. A profile sets the server, of every cli or of each by name
+under servers, the ca-cert, pin, and token, and the output, json or text.
+--profile selects a profile, else $LOCHNESS_PROFILE, else the default one of
+the file. Flags given on the command line take precedence over the profile.
+The token, of the profile or --token, is sent as a bearer token.
+
+	default: staging
+	profiles:
+	  staging:
+	    server: https://lochnessd.staging.example.com
+	    ca-cert: /etc/lochness/staging-ca.pem
+	  prod:
+	    servers:
+	      guest: https://cguestd.example.com:18000
+	      hv: https://chypervisord.example.com:17000
+	    token: hunter2
+	    output: json
+
+	$ hv --profile prod list
+
+Top
+
+The top command is a dashboard of the cluster for a terminal. It shows each
+hypervisor's state (up, down, or maintenance), the score of its health, its
+latest one minute load and free memory (MB) as reported by its telemetry, its
+number of guests, and the percentage of its cpu, memory, and disk allocated to
+guests, with a summary of the cluster above. It refreshes every --interval, 5s
+by default, and as soon as chypervisord streams a change to a hypervisor from
+/events.
+
+The table starts sorted by descending load, or by --sort. Keys change the view:
+
+	s       sort by the next column
+	r       reverse the sort
+	/       filter by id, name, or state; enter applies, escape cancels
+	q       quit
+
+--metadata limits it to the hypervisors that match, as for list. When stdout is
+not a terminal, or with --json, the hypervisors are printed once instead, so
+that the summary can be used in scripts.
+
+Completion
+
+Shell completion scripts for bash, zsh, and fish are generated by the
+completion command. Hypervisor ids are completed by querying the server given
+with --server, or the default.
+
+	$ source <(hv completion bash)
+	$ hv completion fish > ~/.config/fish/completions/hv.fish
+
+Exit Codes
+
+hv exits 0 on success and otherwise with:
+
+	1  error       any other failure
+	2  usage       bad arguments, flags, or ids
+	3  not_found   the server has no such resource
+	4  validation  an invalid spec, or a request the server rejected as invalid
+	5  server      the server could not be reached, failed, or sent a bad response
+
+The last line written to stderr of a failed run is a json object describing
+the failure, with its kind under "error":
+
+	{"error":"not_found","exit_code":3,"message":"failed to get hypervisor","status":404,"fields":{...}}
+
 Examples
 
 List hypervisors
@@ -61,7 +155,20 @@ List hypervisors
 	{"available_resources":{"cpu":0,"disk":0,"memory":0},"gateway":"","id":"aa44c6e8-3ee3-4671-86da-31b6b060795c","ip":"10.100.101.34","mac":"01:23:45:67:89:ab","metadata":{},"netmask":"","total_resources":{"cpu":0,"disk":0,"memory":0}}
 	{"available_resources":{"cpu":0,"disk":0,"memory":0},"gateway":"","id":"f403a417-f973-48f1-bea4-0283da8645a2","ip":"10.100.101.34","mac":"01:23:45:67:89:ab","metadata":{},"netmask":"","total_resources":{"cpu":0,"disk":0,"memory":0}}
 
-List guests on hypervisors
+List hypervisors in a table of their available resources, sorted by memory,
+with --sort and --no-header as for guest list
+
+	$ hv list --table --sort -memory
+	ID                                    IP             MAC                MEMORY  DISK     CPU
+	aa44c6e8-3ee3-4671-86da-31b6b060795c  10.100.101.34  01:23:45:67:89:ab  12288   1040384  28
+	f403a417-f973-48f1-bea4-0283da8645a2  10.100.101.35  01:23:45:67:89:ac  4096    1044480  30
+
+List hypervisors with the metadata rack=a1
+
+	$ hv list --metadata rack=a1
+	aa44c6e8-3ee3-4671-86da-31b6b060795c
+
+List guests on hypervisors, as "hv guests" or "hv guests list"
 
 	$ hv guests list
 	aa44c6e8-3ee3-4671-86da-31b6b060795c
@@ -79,6 +186,33 @@ List guests on hypervisors
 	{"guests":["5e32dada-fc99-4ad9-88ec-563e3639a751","12d9f8af-dfa0-4dba-805e-2c528c3f05f9"],"id":"f403a417-f973-48f1-bea4-0283da8645a2"}
 	{"guests":["6d01bcdc-7985-4c0e-9436-2e726932ee13","ede86f63-8008-4a09-a432-71e4c21742e8"],"id":"f718449c-ed60-4e70-ac70-9b7710d2d68d"}
 
+	$ hv guests --table aa44c6e8-3ee3-4671-86da-31b6b060795c
+	ID                                    HYPERVISOR                            NAME  IP             MAC                FLAVOR
+	333434fe-2743-4b35-87cc-13fd62ba13fc  aa44c6e8-3ee3-4671-86da-31b6b060795c  web   10.100.101.66  02:33:34:34:fe:27  1f5acce3-96b4-4ccb-865f-e6c44f68900d
+	9c931fd1-9851-4658-83c3-0cb994266264  aa44c6e8-3ee3-4671-86da-31b6b060795c  -     10.100.101.67  02:9c:93:1f:d1:98  1f5acce3-96b4-4ccb-865f-e6c44f68900d
+
+Show the capacity of hypervisors and the cluster. Used is the total less what
+is free, and free is what may still be allocated to guests, which with
+overcommit may be more than the total, see chypervisord. The hypervisors may be
+sorted with --sort, while the cluster's total stays last.
+
+	$ hv capacity --sort -mem_free
+	ID                                    MEM_TOTAL  MEM_USED  MEM_FREE  DISK_TOTAL  DISK_USED  DISK_FREE  CPU_TOTAL  CPU_USED  CPU_FREE
+	f403a417-f973-48f1-bea4-0283da8645a2  16384      0         20000     1048576     4096       1044480    32         2         30
+	aa44c6e8-3ee3-4671-86da-31b6b060795c  16384      4096      12288     1048576     8192       1040384    32         0         32
+	total                                 32768      4096      32288     2097152     12288      2084864    64         2         62
+
+	$ hv capacity -j aa44c6e8-3ee3-4671-86da-31b6b060795c
+	{"cpu":{"free":32,"total":32,"used":0},"disk":{"free":1040384,"total":1048576,"used":8192},"id":"aa44c6e8-3ee3-4671-86da-31b6b060795c","memory":{"free":12288,"total":16384,"used":4096}}
+	{"cpu":{"free":32,"total":32,"used":0},"disk":{"free":1040384,"total":1048576,"used":8192},"id":"total","memory":{"free":12288,"total":16384,"used":4096}}
+
+Print the dashboard of the hypervisors once, sorted by allocated memory
+
+	$ hv top --sort -mem% | cat
+	ID                                    NAME  STATE        HEALTH  LOAD  GUESTS  CPU%  MEM%  DISK%  FREE_MEM
+	aa44c6e8-3ee3-4671-86da-31b6b060795c  hv1   up           0.99    1.23  2       0     25    1      10240
+	f403a417-f973-48f1-bea4-0283da8645a2  hv2   maintenance  1       0.04  0       6     0     0      15872
+
 Create hypervisors
 
 	$ hv create '{"id":"bbcd1234-abcd-1234-abcd-1234abcd1234","metadata":{},"ip":"10.100.101.35","netmask":"255.255.255.255","gateway":"10.100.101.35","mac":"01:23:45:67:89:ac","total_resources":{"memory":1024,"disk":1024,"cpu":1}, "available_resources": {"memory":1024,"disk":1024,"cpu":1}}'
@@ -119,6 +253,67 @@ Delete hypervisors
 	$ hv delete f403a417-f973-48f1-bea4-0283da8645a2
 	f403a417-f973-48f1-bea4-0283da8645a2
 
+Set the facts expected of a hypervisor, which nheartbeatd checks it against,
+and show how it drifted from them. Empty values are no longer expected. See
+lochness.LocalFacts for the facts that can be expected.
+
+	$ hv expected modify aa44c6e8-3ee3-4671-86da-31b6b060795c '{"kernel-args":"console=ttyS0 intel_iommu=on","os-version":"0.4.2"}'
+	aa44c6e8-3ee3-4671-86da-31b6b060795c
+	├── kernel-args:console=ttyS0 intel_iommu=on
+	└── os-version:0.4.2
+
+	$ hv drift --drifted
+	aa44c6e8-3ee3-4671-86da-31b6b060795c
+	└── os-version: expected "0.4.2", found "0.4.1"
+
+	$ hv drift -j aa44c6e8-3ee3-4671-86da-31b6b060795c
+	{"checked_at":"2026-10-16T10:02:11Z","differences":[{"actual":"0.4.1","expected":"0.4.2","key":"os-version"}],"hypervisor":"aa44c6e8-3ee3-4671-86da-31b6b060795c"}
+
+Roll out an upgrade of hypervisors, two at a time, with cupgraded. The
+hypervisors are upgraded in order of id unless given. Only one upgrade is rolled
+out at a time, and it may be paused, resumed, or aborted. See cupgraded.
+
+	$ hv upgrade start -b 2 0.5.0
+	5d9b7a3c-2f0e-4c8a-9d41-8a7f0e1b2c3d
+
+	$ hv upgrade show 5d9b7a3c-2f0e-4c8a-9d41-8a7f0e1b2c3d
+	5d9b7a3c-2f0e-4c8a-9d41-8a7f0e1b2c3d 0.5.0 running
+	├── aa44c6e8-3ee3-4671-86da-31b6b060795c: done
+	├── f403a417-f973-48f1-bea4-0283da8645a2: upgrading
+	└── f718449c-ed60-4e70-ac70-9b7710d2d68d: pending
+
+	$ hv upgrade pause 5d9b7a3c-2f0e-4c8a-9d41-8a7f0e1b2c3d
+	5d9b7a3c-2f0e-4c8a-9d41-8a7f0e1b2c3d
+
+	$ hv upgrade list --table
+	ID                                    VERSION  STATE   BATCH  CREATED               ERROR
+	5d9b7a3c-2f0e-4c8a-9d41-8a7f0e1b2c3d  0.5.0    paused  2      2026-10-16T10:02:11Z  -
+
+Power cycle a hung hypervisor through its BMC, after giving it the BMC and its
+credentials, stored with the secret command:
+
+	$ secret create hv1-bmc ipmi < bmc-credentials
+	7d0c5b2e-9f1a-4c3d-8e6b-5a4f3e2d1c0b
+
+	$ hv modify aa44c6e8-3ee3-4671-86da-31b6b060795c '{"bmc":{"protocol":"ipmi","address":"10.100.102.35"},"secrets":{"ipmi":"7d0c5b2e-9f1a-4c3d-8e6b-5a4f3e2d1c0b"}}'
+	aa44c6e8-3ee3-4671-86da-31b6b060795c
+
+	$ hv power cycle aa44c6e8-3ee3-4671-86da-31b6b060795c
+	aa44c6e8-3ee3-4671-86da-31b6b060795c
+
+Create a bootstrap token, valid for a week, with which freshly netbooted nodes
+register themselves as hypervisors, instead of being created one by one:
+
+	$ hv token --ttl 168h
+	eyJleHBpcmVzIjoiMjAyNi0xMC0yM1QxMDowMDowMFoifQ.3q2-7wQkR1s...
+
+Hypervisors in maintenance have no new guests placed on them. cupgraded puts
+hypervisors in maintenance while upgrading them, and leaves those that failed
+in it. Take one out of maintenance with modify:
+
+	$ hv modify f403a417-f973-48f1-bea4-0283da8645a2 '{"maintenance":false}'
+	f403a417-f973-48f1-bea4-0283da8645a2
+
 List subnets for hypervisors
 
 	$ hv subnets list
@@ -131,7 +326,15 @@ Delete subnet from hypervisor
 
 	# deleting a subnet requires the hypervisor id also
 	$ hv subnets delete -j aa44c6e8-3ee3-4671-86da-31b6b060795c dae637b7-8abd-41d9-b7a2-9d7c2bdd3ef9 f718449c-ed60-4e70-ac70-9b7710d2d68d f6ac4816-b9c8-4b77-b976-d4be70507754
-	{}
-	{}
+	{"id":"aa44c6e8-3ee3-4671-86da-31b6b060795c"}
+	{"id":"f718449c-ed60-4e70-ac70-9b7710d2d68d"}
+
+Add subnets to a hypervisor. Nothing is changed unless every subnet exists and
+every bridge is a valid name, and the same goes for the keys of config modify,
+which must each be a single key of letters, digits, ".", "_", and "-".
+
+	$ hv subnets modify aa44c6e8-3ee3-4671-86da-31b6b060795c '{"dae637b7-8abd-41d9-b7a2-9d7c2bdd3ef9":"br0"}'
+	aa44c6e8-3ee3-4671-86da-31b6b060795c
+	└── dae637b7-8abd-41d9-b7a2-9d7c2bdd3ef9:br0
 */
 package main
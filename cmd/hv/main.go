@@ -2,8 +2,10 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"os"
 	"sort"
+	"strings"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/andrew-d/go-termutil"
@@ -14,8 +16,104 @@ import (
 var (
 	server  = "http://localhost:17000"
 	jsonout = false
+	caCert  = ""
+	pin     = ""
+
+	transportOpts = cli.TransportOptions{}
+	profileOpts   = cli.ProfileOptions{}
+
+	metadataFilters = []string{}
+	onlyDrifted     = false
+	batchSize       = 1
+	tokenTTL        = "24h"
+	tokenMAC        = ""
+
+	tableOpts = cli.TableOptions{}
+	hvTable   = cli.Table{
+		{Header: "ID", Key: "id"},
+		{Header: "IP", Key: "ip"},
+		{Header: "MAC", Key: "mac"},
+		{Header: "MEMORY", Key: "available_resources.memory"},
+		{Header: "DISK", Key: "available_resources.disk"},
+		{Header: "CPU", Key: "available_resources.cpu"},
+	}
+	guestTable = cli.Table{
+		{Header: "ID", Key: "id"},
+		{Header: "HYPERVISOR", Key: "hypervisor"},
+		{Header: "NAME", Key: "metadata.name"},
+		{Header: "IP", Key: "ip"},
+		{Header: "MAC", Key: "mac"},
+		{Header: "FLAVOR", Key: "flavor"},
+	}
+	upgradeTable = cli.Table{
+		{Header: "ID", Key: "id"},
+		{Header: "VERSION", Key: "version"},
+		{Header: "STATE", Key: "state"},
+		{Header: "BATCH", Key: "batch_size"},
+		{Header: "CREATED", Key: "created"},
+		{Header: "ERROR", Key: "error"},
+	}
+	capacityTable = cli.Table{
+		{Header: "ID", Key: "id"},
+		{Header: "MEM_TOTAL", Key: "memory.total"},
+		{Header: "MEM_USED", Key: "memory.used"},
+		{Header: "MEM_FREE", Key: "memory.free"},
+		{Header: "DISK_TOTAL", Key: "disk.total"},
+		{Header: "DISK_USED", Key: "disk.used"},
+		{Header: "DISK_FREE", Key: "disk.free"},
+		{Header: "CPU_TOTAL", Key: "cpu.total"},
+		{Header: "CPU_USED", Key: "cpu.used"},
+		{Header: "CPU_FREE", Key: "cpu.free"},
+	}
+
+	// capacityResources are the resources capacity is summarized for
+	capacityResources = []string{"memory", "disk", "cpu"}
 )
 
+// newClient creates a client for the server, verifying https servers as set by
+// --ca-cert and --pin, and connecting as set by --proxy and --dial-timeout,
+// authenticating with --token
+func newClient() *cli.Client {
+	applyProfile()
+	c := cli.NewClient(server)
+	if err := c.ConfigureTransport(transportOpts); err != nil {
+		cli.Fatal(cli.ExitUsage, log.Fields{
+			"proxy":        transportOpts.Proxy,
+			"dial-timeout": transportOpts.DialTimeout,
+			"error":        err,
+		}, "invalid transport settings")
+	}
+	if err := c.ConfigureTLS(caCert, pin); err != nil {
+		cli.Fatal(cli.ExitUsage, log.Fields{
+			"ca-cert": caCert,
+			"pin":     pin,
+			"error":   err,
+		}, "invalid tls settings")
+	}
+	if err := c.SetToken(profileOpts.Token); err != nil {
+		cli.Fatal(cli.ExitUsage, log.Fields{"error": err}, "invalid token")
+	}
+	return c
+}
+
+// applyProfile sets the flags not given from the profile of the config file
+func applyProfile() {
+	if err := profileOpts.Apply(); err != nil {
+		cli.Fatal(cli.ExitUsage, log.Fields{
+			"config":  cli.ConfigPath(),
+			"profile": profileOpts.Name,
+			"error":   err,
+		}, "invalid profile")
+	}
+}
+
+// subResource gets the map of a hypervisor sub-resource, e.g. its config, out
+// of the response carrying it along with its modification index
+func subResource(resp cli.JMap, key string) map[string]interface{} {
+	m, _ := resp[key].(map[string]interface{})
+	return m
+}
+
 func printTreeMap(id, key string, m map[string]interface{}) {
 	if jsonout {
 		c := cli.JMap{"id": id}
@@ -71,12 +169,12 @@ func printTreeSlice(id, key string, s []string) {
 
 func help(cmd *cobra.Command, _ []string) {
 	if err := cmd.Help(); err != nil {
-		log.WithField("error", err).Fatal("help")
+		cli.Fatal(cli.ExitError, log.Fields{"error": err}, "help")
 	}
 }
 
 func getHVs(c *cli.Client) []cli.JMap {
-	ret, _ := c.GetMany("hypervisors", "hypervisors")
+	ret, _ := c.GetMany("hypervisors", "hypervisors"+cli.MetadataQuery(metadataFilters))
 	// wasteful you say?
 	hvs := make([]cli.JMap, len(ret))
 	for i := range ret {
@@ -90,6 +188,19 @@ func getGuests(c *cli.Client, id string) []string {
 	return hvs
 }
 
+// getResidentGuests fetches the guests of a hypervisor, from its desired state
+func getResidentGuests(c *cli.Client, id string) []cli.JMap {
+	state, _ := c.Get("desired state", "hypervisors/"+id+"/desiredstate")
+	list, _ := state["guests"].([]interface{})
+	guests := make([]cli.JMap, 0, len(list))
+	for _, guest := range list {
+		if g, ok := guest.(map[string]interface{}); ok {
+			guests = append(guests, g)
+		}
+	}
+	return guests
+}
+
 func getHV(c *cli.Client, id string) cli.JMap {
 	hv, _ := c.Get("hypervisor", "hypervisors/"+id)
 	return hv
@@ -110,6 +221,11 @@ func modifyConfig(c *cli.Client, id string, spec string) cli.JMap {
 	return conf
 }
 
+func modifyExpected(c *cli.Client, id string, spec string) cli.JMap {
+	expected, _ := c.Patch("expected config", "hypervisors/"+id+"/expected", spec)
+	return expected
+}
+
 func modifySubnets(c *cli.Client, id string, spec string) cli.JMap {
 	subnets, _ := c.Patch("subnets", "hypervisors/"+id+"/subnets", spec)
 	return subnets
@@ -120,13 +236,27 @@ func deleteHV(c *cli.Client, id string) cli.JMap {
 	return hv
 }
 
+func getUpgrades(c *cli.Client) []cli.JMap {
+	ret, _ := c.GetMany("upgrades", "upgrades")
+	upgrades := make([]cli.JMap, len(ret))
+	for i := range ret {
+		upgrades[i] = ret[i]
+	}
+	return upgrades
+}
+
+func getUpgrade(c *cli.Client, id string) cli.JMap {
+	upgrade, _ := c.Get("upgrade", "upgrades/"+id)
+	return upgrade
+}
+
 func deleteSubnet(c *cli.Client, hv, subnet string) cli.JMap {
 	sub, _ := c.Delete("subnet", "hypervisors/"+hv+"/subnets/"+subnet)
 	return sub
 }
 
 func list(cmd *cobra.Command, args []string) {
-	c := cli.NewClient(server)
+	c := newClient()
 	hvs := []cli.JMap{}
 	if len(args) == 0 {
 		if termutil.Isatty(os.Stdin.Fd()) {
@@ -143,13 +273,20 @@ func list(cmd *cobra.Command, args []string) {
 		}
 	}
 
+	if tableOpts.Table && !jsonout {
+		if err := hvTable.Print(os.Stdout, hvs, tableOpts); err != nil {
+			cli.Fatal(cli.ExitError, log.Fields{"error": err}, "failed to print table")
+		}
+		return
+	}
+
 	for _, hv := range hvs {
 		hv.Print(jsonout)
 	}
 }
 
 func create(cmd *cobra.Command, specs []string) {
-	c := cli.NewClient(server)
+	c := newClient()
 	if len(specs) == 0 {
 		specs = cli.Read(os.Stdin)
 	}
@@ -162,12 +299,12 @@ func create(cmd *cobra.Command, specs []string) {
 }
 
 func modify(cmd *cobra.Command, args []string) {
-	c := cli.NewClient(server)
+	c := newClient()
 	if len(args) == 0 {
 		args = cli.Read(os.Stdin)
 	}
 	if len(args)%2 != 0 {
-		log.WithField("num", len(args)).Fatal("expected an even amount of args")
+		cli.Fatal(cli.ExitUsage, log.Fields{"num": len(args)}, "expected an even amount of args")
 	}
 
 	for i := 0; i < len(args); i += 2 {
@@ -182,7 +319,7 @@ func modify(cmd *cobra.Command, args []string) {
 }
 
 func del(cmd *cobra.Command, ids []string) {
-	c := cli.NewClient(server)
+	c := newClient()
 	if len(ids) == 0 {
 		ids = cli.Read(os.Stdin)
 	}
@@ -195,7 +332,7 @@ func del(cmd *cobra.Command, ids []string) {
 }
 
 func guests(cmd *cobra.Command, ids []string) {
-	c := cli.NewClient(server)
+	c := newClient()
 	if len(ids) == 0 {
 		if termutil.Isatty(os.Stdin.Fd()) {
 			for _, hv := range getHVs(c) {
@@ -211,14 +348,96 @@ func guests(cmd *cobra.Command, ids []string) {
 		}
 	}
 
+	if tableOpts.Table && !jsonout {
+		rows := []cli.JMap{}
+		for _, id := range ids {
+			rows = append(rows, getResidentGuests(c, id)...)
+		}
+		if err := guestTable.Print(os.Stdout, rows, tableOpts); err != nil {
+			cli.Fatal(cli.ExitError, log.Fields{"error": err}, "failed to print table")
+		}
+		return
+	}
+
 	for _, id := range ids {
 		guests := getGuests(c, id)
 		printTreeSlice(id, "guests", guests)
 	}
 }
 
+// hvCapacity returns the total, used, and free amounts of each resource of a
+// hypervisor, from its total and available resources. With overcommit, more
+// than the total may be free, so used is floored at 0.
+func hvCapacity(hv cli.JMap) cli.JMap {
+	row := cli.JMap{"id": hv.ID()}
+	for _, resource := range capacityResources {
+		total, _ := hv.Lookup("total_resources." + resource).(float64)
+		free, _ := hv.Lookup("available_resources." + resource).(float64)
+		row[resource] = map[string]interface{}{
+			"total": total,
+			"used":  math.Max(total-free, 0),
+			"free":  free,
+		}
+	}
+	return row
+}
+
+// addCapacity adds the resources of a hypervisor's capacity to a sum
+func addCapacity(sum, row cli.JMap) {
+	for _, resource := range capacityResources {
+		s := sum[resource].(map[string]interface{})
+		r := row[resource].(map[string]interface{})
+		for key, value := range r {
+			s[key] = s[key].(float64) + value.(float64)
+		}
+	}
+}
+
+func capacity(cmd *cobra.Command, ids []string) {
+	c := newClient()
+	hvs := []cli.JMap{}
+	if len(ids) == 0 {
+		if termutil.Isatty(os.Stdin.Fd()) {
+			hvs = getHVs(c)
+		} else {
+			ids = cli.Read(os.Stdin)
+		}
+	}
+	for _, id := range ids {
+		cli.AssertID(id)
+		hvs = append(hvs, getHV(c, id))
+	}
+
+	rows := make([]cli.JMap, 0, len(hvs)+1)
+	sum := hvCapacity(cli.JMap{})
+	sum["id"] = "total"
+	for _, hv := range hvs {
+		row := hvCapacity(hv)
+		addCapacity(sum, row)
+		rows = append(rows, row)
+	}
+
+	sort.Sort(cli.JMapSlice(rows))
+	if tableOpts.Sort != "" {
+		if err := capacityTable.Sort(rows, tableOpts.Sort); err != nil {
+			cli.Fatal(cli.ExitUsage, log.Fields{"error": err}, "failed to sort table")
+		}
+	}
+	rows = append(rows, sum)
+
+	if jsonout {
+		for _, row := range rows {
+			row.Print(jsonout)
+		}
+		return
+	}
+	if err := capacityTable.Print(os.Stdout, rows, cli.TableOptions{NoHeader: tableOpts.NoHeader}); err != nil {
+		cli.Fatal(cli.ExitError, log.Fields{"error": err}, "failed to print table")
+	}
+}
+
 func config(cmd *cobra.Command, ids []string) {
-	c := cli.NewClient(server)
+	c := newClient()
 	if len(ids) == 0 {
 		if termutil.Isatty(os.Stdin.Fd()) {
 			for _, hv := range getHVs(c) {
@@ -236,17 +455,17 @@ func config(cmd *cobra.Command, ids []string) {
 
 	for _, id := range ids {
 		config, _ := c.Get("config", "hypervisors/"+id+"/config")
-		printTreeMap(id, "config", config)
+		printTreeMap(id, "config", subResource(config, "config"))
 	}
 }
 
 func configModify(cmd *cobra.Command, args []string) {
-	c := cli.NewClient(server)
+	c := newClient()
 	if len(args) == 0 {
 		args = cli.Read(os.Stdin)
 	}
 	if len(args)%2 != 0 {
-		log.WithField("num", len(args)).Fatal("expected an even amount of args")
+		cli.Fatal(cli.ExitUsage, log.Fields{"num": len(args)}, "expected an even amount of args")
 	}
 
 	for i := 0; i < len(args); i += 2 {
@@ -256,12 +475,98 @@ func configModify(cmd *cobra.Command, args []string) {
 		cli.AssertSpec(spec)
 
 		config := modifyConfig(c, id, spec)
-		printTreeMap(id, "config", config)
+		printTreeMap(id, "config", subResource(config, "config"))
+	}
+}
+
+func expected(cmd *cobra.Command, ids []string) {
+	c := newClient()
+	if len(ids) == 0 {
+		if termutil.Isatty(os.Stdin.Fd()) {
+			for _, hv := range getHVs(c) {
+				ids = append(ids, hv["id"].(string))
+			}
+		} else {
+			ids = cli.Read(os.Stdin)
+			sort.Strings(ids)
+		}
+	} else {
+		for _, id := range ids {
+			cli.AssertID(id)
+		}
+	}
+
+	for _, id := range ids {
+		expected, _ := c.Get("expected config", "hypervisors/"+id+"/expected")
+		printTreeMap(id, "expected", expected)
+	}
+}
+
+func expectedModify(cmd *cobra.Command, args []string) {
+	c := newClient()
+	if len(args) == 0 {
+		args = cli.Read(os.Stdin)
+	}
+	if len(args)%2 != 0 {
+		cli.Fatal(cli.ExitUsage, log.Fields{"num": len(args)}, "expected an even amount of args")
+	}
+
+	for i := 0; i < len(args); i += 2 {
+		id := args[i]
+		cli.AssertID(id)
+		spec := args[i+1]
+		cli.AssertSpec(spec)
+
+		expected := modifyExpected(c, id, spec)
+		printTreeMap(id, "expected", expected)
+	}
+}
+
+func drift(cmd *cobra.Command, ids []string) {
+	c := newClient()
+	if len(ids) == 0 {
+		if termutil.Isatty(os.Stdin.Fd()) {
+			for _, hv := range getHVs(c) {
+				ids = append(ids, hv["id"].(string))
+			}
+		} else {
+			ids = cli.Read(os.Stdin)
+		}
+		sort.Strings(ids)
+	} else {
+		for _, id := range ids {
+			cli.AssertID(id)
+		}
+	}
+
+	for _, id := range ids {
+		drift, _ := c.Get("drift", "hypervisors/"+id+"/drift")
+		differences, _ := drift["differences"].([]interface{})
+		if onlyDrifted && len(differences) == 0 {
+			continue
+		}
+		if jsonout {
+			cli.JMap(drift).Print(jsonout)
+			continue
+		}
+		if checkedAt, _ := drift["checked_at"].(string); strings.HasPrefix(checkedAt, "0001-01-01") {
+			fmt.Println(id, "(not checked)")
+			continue
+		}
+		diffs := make(map[string]interface{}, len(differences))
+		for _, d := range differences {
+			diff, ok := d.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			diffs[fmt.Sprint(diff["key"])] = fmt.Sprintf(" expected %q, found %q", diff["expected"], diff["actual"])
+		}
+		printTreeMap(id, "differences", diffs)
 	}
 }
 
 func subnets(cmd *cobra.Command, ids []string) {
-	c := cli.NewClient(server)
+	c := newClient()
 	if len(ids) == 0 {
 		if termutil.Isatty(os.Stdin.Fd()) {
 			for _, hv := range getHVs(c) {
@@ -279,17 +584,17 @@ func subnets(cmd *cobra.Command, ids []string) {
 
 	for _, id := range ids {
 		subnet, _ := c.Get("subnet", "hypervisors/"+id+"/subnets")
-		printTreeMap(id, "subnet", subnet)
+		printTreeMap(id, "subnet", subResource(subnet, "subnets"))
 	}
 }
 
 func subnetsModify(cmd *cobra.Command, args []string) {
-	c := cli.NewClient(server)
+	c := newClient()
 	if len(args) == 0 {
 		args = cli.Read(os.Stdin)
 	}
 	if len(args)%2 != 0 {
-		log.WithField("num", len(args)).Fatal("expected an even amount of args")
+		cli.Fatal(cli.ExitUsage, log.Fields{"num": len(args)}, "expected an even amount of args")
 	}
 
 	for i := 0; i < len(args); i += 2 {
@@ -299,17 +604,17 @@ func subnetsModify(cmd *cobra.Command, args []string) {
 		cli.AssertSpec(spec)
 
 		subnet := modifySubnets(c, id, spec)
-		printTreeMap(id, "subnet", subnet)
+		printTreeMap(id, "subnet", subResource(subnet, "subnets"))
 	}
 }
 
 func subnetsDel(cmd *cobra.Command, args []string) {
-	c := cli.NewClient(server)
+	c := newClient()
 	if len(args) == 0 {
 		args = cli.Read(os.Stdin)
 	}
 	if len(args)%2 != 0 {
-		log.WithField("num", len(args)).Fatal("expected an even amount of args")
+		cli.Fatal(cli.ExitUsage, log.Fields{"num": len(args)}, "expected an even amount of args")
 	}
 
 	for i := 0; i < len(args); i += 2 {
@@ -318,25 +623,178 @@ func subnetsDel(cmd *cobra.Command, args []string) {
 		subnet := args[i+1]
 		cli.AssertID(subnet)
 
-		deleted := deleteSubnet(c, hv, subnet)
-		deleted.Print(jsonout)
+		remaining := deleteSubnet(c, hv, subnet)
+		printTreeMap(hv, "subnet", subResource(remaining, "subnets"))
+	}
+}
+
+func upgradeStart(cmd *cobra.Command, args []string) {
+	c := newClient()
+	if len(args) == 0 {
+		cli.Fatal(cli.ExitUsage, log.Fields{}, "expected a version")
+	}
+	version, ids := args[0], args[1:]
+	for _, id := range ids {
+		cli.AssertID(id)
+	}
+
+	spec := cli.JMap{"version": version, "batch_size": batchSize}
+	if len(ids) != 0 {
+		spec["hypervisors"] = ids
+	}
+	upgrade, _ := c.Post("upgrade", "upgrades", spec.String())
+	cli.JMap(upgrade).Print(jsonout)
+}
+
+func upgradeList(cmd *cobra.Command, ids []string) {
+	c := newClient()
+	upgrades := []cli.JMap{}
+	if len(ids) == 0 {
+		if termutil.Isatty(os.Stdin.Fd()) {
+			upgrades = getUpgrades(c)
+		} else {
+			ids = cli.Read(os.Stdin)
+		}
+	}
+	for _, id := range ids {
+		cli.AssertID(id)
+		upgrades = append(upgrades, getUpgrade(c, id))
+	}
+	sort.Slice(upgrades, func(i, j int) bool {
+		return fmt.Sprint(upgrades[i]["created"]) < fmt.Sprint(upgrades[j]["created"])
+	})
+
+	if tableOpts.Table && !jsonout {
+		if err := upgradeTable.Print(os.Stdout, upgrades, tableOpts); err != nil {
+			cli.Fatal(cli.ExitError, log.Fields{"error": err}, "failed to print table")
+		}
+		return
+	}
+
+	for _, upgrade := range upgrades {
+		upgrade.Print(jsonout)
+	}
+}
+
+func upgradeShow(cmd *cobra.Command, ids []string) {
+	c := newClient()
+	if len(ids) == 0 {
+		ids = cli.Read(os.Stdin)
+	}
+
+	for _, id := range ids {
+		cli.AssertID(id)
+		upgrade := getUpgrade(c, id)
+		if jsonout {
+			upgrade.Print(jsonout)
+			continue
+		}
+		hvs, _ := upgrade["hypervisors"].([]interface{})
+		steps := make(map[string]interface{}, len(hvs))
+		for _, h := range hvs {
+			hv, ok := h.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			step := fmt.Sprint(" ", hv["step"])
+			if hv["error"] != nil {
+				step += fmt.Sprint(": ", hv["error"])
+			}
+			steps[fmt.Sprint(hv["id"])] = step
+		}
+		printTreeMap(fmt.Sprint(id, " ", upgrade["version"], " ", upgrade["state"]), "hypervisors", steps)
+	}
+}
+
+// upgradeAction creates a command func POSTing the action to upgrades
+func upgradeAction(action string) func(*cobra.Command, []string) {
+	return func(cmd *cobra.Command, ids []string) {
+		c := newClient()
+		if len(ids) == 0 {
+			ids = cli.Read(os.Stdin)
+		}
+
+		for _, id := range ids {
+			cli.AssertID(id)
+			upgrade, _ := c.Post("upgrade", "upgrades/"+id+"/"+action, "")
+			cli.JMap(upgrade).Print(jsonout)
+		}
+	}
+}
+
+func power(cmd *cobra.Command, args []string) {
+	c := newClient()
+	action, ids := args[0], args[1:]
+	if len(ids) == 0 {
+		ids = cli.Read(os.Stdin)
+	}
+
+	spec := fmt.Sprintf(`{"action":%q}`, action)
+	for _, id := range ids {
+		cli.AssertID(id)
+		hv, _ := c.Post("hypervisor", "hypervisors/"+id+"/power", spec)
+		cli.JMap(hv).Print(jsonout)
+	}
+}
+
+func token(cmd *cobra.Command, args []string) {
+	c := newClient()
+	spec := fmt.Sprintf(`{"ttl":%q,"mac":%q}`, tokenTTL, tokenMAC)
+	t, _ := c.Post("token", "hypervisors/tokens", spec)
+	if jsonout {
+		cli.JMap(t).Print(true)
+		return
+	}
+	fmt.Println(t["token"])
+}
+
+func upgradeDel(cmd *cobra.Command, ids []string) {
+	c := newClient()
+	if len(ids) == 0 {
+		ids = cli.Read(os.Stdin)
+	}
+
+	for _, id := range ids {
+		cli.AssertID(id)
+		upgrade, _ := c.Delete("upgrade", "upgrades/"+id)
+		cli.JMap(upgrade).Print(jsonout)
 	}
 }
 
+// listUpgradeIDs fetches the upgrade ids for completion
+func listUpgradeIDs() ([]string, error) {
+	return newClient().ListIDs("upgrades")
+}
+
+// listHVIDs fetches the hypervisor ids for completion
+func listHVIDs() ([]string, error) {
+	return newClient().ListIDs("hypervisors")
+}
+
 func main() {
 	root := &cobra.Command{
 		Use:  "hv",
 		Long: "hv is the cli interface to chypervisord. All commands support arguments via command line or stdin",
 		Run:  help,
+
+		PersistentPreRun: func(*cobra.Command, []string) { applyProfile() },
 	}
 	root.PersistentFlags().BoolVarP(&jsonout, "json", "j", jsonout, "output in json")
 	root.PersistentFlags().StringVarP(&server, "server", "s", server, "server address to connect to")
+	root.PersistentFlags().StringVar(&caCert, "ca-cert", caCert, "file of CA certificates (PEM) to verify an https server with, instead of the system's")
+	root.PersistentFlags().StringVar(&pin, "pin", pin, "base64 sha256 of the https server's public key, to trust instead of verifying its certificate chain")
+	transportOpts.AddFlags(root.PersistentFlags())
+	profileOpts.AddFlags(root.PersistentFlags(), "hv")
 
 	cmdList := &cobra.Command{
 		Use:   "list [<hv>...]",
 		Short: "List the hypervisors",
 		Run:   list,
+
+		ValidArgsFunction: cli.CompleteIDs(listHVIDs),
 	}
+	tableOpts.AddFlags(cmdList.Flags())
+	cmdList.Flags().StringArrayVar(&metadataFilters, "metadata", metadataFilters, "only list hypervisors with this key=value metadata. may be repeated")
 	cmdCreate := &cobra.Command{
 		Use:   "create <spec>...",
 		Short: "Create new hypervisors",
@@ -349,22 +807,77 @@ valid json and contain the required fields, "mac" and "ip".`,
 		Short: "Modify hypervisors",
 		Long:  `Modify given hypervisor. Where "spec" is a valid json string.`,
 		Run:   modify,
+
+		ValidArgsFunction: cli.CompleteIDPairs(listHVIDs),
 	}
 	cmdDel := &cobra.Command{
 		Use:   "delete <hv>...",
 		Short: "Delete hypervisors",
 		Run:   del,
+
+		ValidArgsFunction: cli.CompleteIDs(listHVIDs),
 	}
 	cmdGuestsRoot := &cobra.Command{
-		Use:   "guests",
-		Short: "Operate on hypervisor guests",
-		Run:   help,
+		Use:   "guests [<hv>...]",
+		Short: "List the guests resident on hypervisors",
+		Long: `List the guests resident on given hypervisors, or all of them. With --table,
+the guests are shown with their details, from the hypervisors' desired state.`,
+		Run: guests,
+
+		ValidArgsFunction: cli.CompleteIDs(listHVIDs),
 	}
+	tableOpts.AddFlags(cmdGuestsRoot.PersistentFlags())
 	cmdGuestsList := &cobra.Command{
 		Use:   "list [<hv>...]",
 		Short: "List the guests belonging to hypervisor",
 		Run:   guests,
+
+		ValidArgsFunction: cli.CompleteIDs(listHVIDs),
+	}
+	cmdCapacity := &cobra.Command{
+		Use:   "capacity [<hv>...]",
+		Short: "Show the resource capacity of hypervisors",
+		Long: `Show the total, used, and free memory, disk, and cpu of given hypervisors, or
+all of them, and their sum. Free is what may still be allocated to guests,
+which may be more than the total with overcommit.`,
+		Run: capacity,
+
+		ValidArgsFunction: cli.CompleteIDs(listHVIDs),
+	}
+	tableOpts.AddSortFlags(cmdCapacity.Flags())
+	cmdTop := &cobra.Command{
+		Use:   "top",
+		Short: "Show a live dashboard of the hypervisors",
+		Long: `Show the state, health, load, guests, and allocated resources of the
+hypervisors, updated every --interval and whenever a hypervisor changes. Keys
+sort the table by the next column (s), reverse it (r), filter it by id, name,
+or state (/), and quit (q). Without a terminal, or with --json, the hypervisors
+are printed once.`,
+		Run: top,
 	}
+	tableOpts.AddSortFlags(cmdTop.Flags())
+	cmdTop.Flags().StringArrayVar(&metadataFilters, "metadata", metadataFilters, "only show hypervisors with this key=value metadata. may be repeated")
+	cmdTop.Flags().DurationVarP(&topInterval, "interval", "i", topInterval, "how often to refresh")
+	cmdPower := &cobra.Command{
+		Use:   "power (on|off|cycle) [<hv>...]",
+		Short: "Control the power of hypervisors through their BMC",
+		Long: `Power hypervisors on or off, or cycle them, through their BMC. A hypervisor
+needs a "bmc" and an "ipmi" secret with its credentials, see the secret command.
+Powering off is immediate, without shutting down guests.`,
+		Args: cobra.MinimumNArgs(1),
+		Run:  power,
+	}
+	cmdToken := &cobra.Command{
+		Use:   "token",
+		Short: "Create a bootstrap token for netbooted nodes to register with",
+		Long: `Create a bootstrap token, with which freshly netbooted nodes register themselves
+as hypervisors, or update the hypervisor with their id or mac, at
+/hypervisors/register. chypervisord must have a secret key to sign tokens.`,
+		Args: cobra.NoArgs,
+		Run:  token,
+	}
+	cmdToken.Flags().StringVar(&tokenTTL, "ttl", tokenTTL, "how long the token is valid")
+	cmdToken.Flags().StringVar(&tokenMAC, "mac", tokenMAC, "only let the node with this mac register with the token")
 	cmdConfigRoot := &cobra.Command{
 		Use:   "config",
 		Short: "Operate on hypervisor config",
@@ -374,13 +887,49 @@ valid json and contain the required fields, "mac" and "ip".`,
 		Use:   "list [<hv>...]",
 		Short: "Get hypervisor config",
 		Run:   config,
+
+		ValidArgsFunction: cli.CompleteIDs(listHVIDs),
 	}
 	cmdConfigMod := &cobra.Command{
 		Use:   "modify (<hv> <spec>)...",
 		Short: "Modify hypervisor config",
 		Long:  `Modify the config of given hypervisor. Where "spec" is a valid json string.`,
 		Run:   configModify,
+
+		ValidArgsFunction: cli.CompleteIDPairs(listHVIDs),
+	}
+	cmdExpectedRoot := &cobra.Command{
+		Use:   "expected",
+		Short: "Operate on the config expected of hypervisors",
+		Long: `Operate on the facts expected of hypervisors, such as their kernel args, os
+version, and network interfaces, which nheartbeatd checks them against.`,
+		Run: help,
+	}
+	cmdExpectedList := &cobra.Command{
+		Use:   "list [<hv>...]",
+		Short: "Get hypervisor expected config",
+		Run:   expected,
+
+		ValidArgsFunction: cli.CompleteIDs(listHVIDs),
 	}
+	cmdExpectedMod := &cobra.Command{
+		Use:   "modify (<hv> <spec>)...",
+		Short: "Modify hypervisor expected config",
+		Long:  `Modify the expected config of given hypervisor. Where "spec" is a valid json string. Empty values are removed.`,
+		Run:   expectedModify,
+
+		ValidArgsFunction: cli.CompleteIDPairs(listHVIDs),
+	}
+	cmdDrift := &cobra.Command{
+		Use:   "drift [<hv>...]",
+		Short: "Show how hypervisors drifted from their expected config",
+		Long: `Show the facts of given hypervisors, or all of them, that differed from those
+expected of them when nheartbeatd last checked.`,
+		Run: drift,
+
+		ValidArgsFunction: cli.CompleteIDs(listHVIDs),
+	}
+	cmdDrift.Flags().BoolVar(&onlyDrifted, "drifted", onlyDrifted, "only show hypervisors that drifted")
 	cmdSubnetsRoot := &cobra.Command{
 		Use:   "subnets",
 		Short: "Operate on hypervisor subnets",
@@ -390,17 +939,86 @@ valid json and contain the required fields, "mac" and "ip".`,
 		Use:   "list [<hv>...]",
 		Short: "Get hypervisor subnets",
 		Run:   subnets,
+
+		ValidArgsFunction: cli.CompleteIDs(listHVIDs),
 	}
 	cmdSubnetsMod := &cobra.Command{
 		Use:   "modify (<hv> <spec>)...",
 		Short: "Modify hypervisor subnets",
 		Long:  `Modify the subnets of given hypervisor. Where "spec" is a valid json string.`,
 		Run:   subnetsModify,
+
+		ValidArgsFunction: cli.CompleteIDPairs(listHVIDs),
 	}
 	cmdSubnetsDel := &cobra.Command{
 		Use:   "delete (<hv> <subnet>)...",
 		Short: "Delete hypervisor subnets",
 		Run:   subnetsDel,
+
+		ValidArgsFunction: cli.CompleteIDPairs(listHVIDs),
+	}
+
+	cmdUpgradeRoot := &cobra.Command{
+		Use:   "upgrade",
+		Short: "Operate on rolling upgrades of hypervisors",
+		Long: `Operate on rolling upgrades of hypervisors, done by cupgraded. Each hypervisor
+is put in maintenance, its running guests shut down, the version set for
+nconfigd to install, and once it is back with the version its guests started
+again.`,
+		Run: help,
+	}
+	cmdUpgradeStart := &cobra.Command{
+		Use:   "start <version> [<hv>...]",
+		Short: "Start upgrading hypervisors to a version",
+		Long: `Start upgrading given hypervisors, in order, or all of them, in order of id, to
+a version. Only one upgrade is rolled out at a time.`,
+		Run: upgradeStart,
+	}
+	cmdUpgradeStart.Flags().IntVarP(&batchSize, "batch-size", "b", batchSize, "number of hypervisors to upgrade at a time")
+	cmdUpgradeList := &cobra.Command{
+		Use:   "list [<upgrade>...]",
+		Short: "List the upgrades",
+		Run:   upgradeList,
+
+		ValidArgsFunction: cli.CompleteIDs(listUpgradeIDs),
+	}
+	tableOpts.AddFlags(cmdUpgradeList.Flags())
+	cmdUpgradeShow := &cobra.Command{
+		Use:   "show <upgrade>...",
+		Short: "Show the progress of each hypervisor of upgrades",
+		Run:   upgradeShow,
+
+		ValidArgsFunction: cli.CompleteIDs(listUpgradeIDs),
+	}
+	cmdUpgradePause := &cobra.Command{
+		Use:   "pause <upgrade>...",
+		Short: "Pause upgrades once the hypervisors being upgraded are done",
+		Run:   upgradeAction("pause"),
+
+		ValidArgsFunction: cli.CompleteIDs(listUpgradeIDs),
+	}
+	cmdUpgradeResume := &cobra.Command{
+		Use:   "resume <upgrade>...",
+		Short: "Resume paused upgrades",
+		Run:   upgradeAction("resume"),
+
+		ValidArgsFunction: cli.CompleteIDs(listUpgradeIDs),
+	}
+	cmdUpgradeAbort := &cobra.Command{
+		Use:   "abort <upgrade>...",
+		Short: "Abort upgrades",
+		Long: `Abort upgrades at once. Hypervisors being upgraded are left where they are, in
+maintenance, for an operator to finish.`,
+		Run: upgradeAction("abort"),
+
+		ValidArgsFunction: cli.CompleteIDs(listUpgradeIDs),
+	}
+	cmdUpgradeDel := &cobra.Command{
+		Use:   "delete <upgrade>...",
+		Short: "Delete finished upgrades",
+		Run:   upgradeDel,
+
+		ValidArgsFunction: cli.CompleteIDs(listUpgradeIDs),
 	}
 
 	root.AddCommand(cmdList,
@@ -408,12 +1026,22 @@ valid json and contain the required fields, "mac" and "ip".`,
 		cmdDel,
 		cmdMod,
 		cmdGuestsRoot,
+		cmdCapacity,
+		cmdTop,
+		cmdPower,
+		cmdToken,
 		cmdConfigRoot,
-		cmdSubnetsRoot)
+		cmdExpectedRoot,
+		cmdDrift,
+		cmdSubnetsRoot,
+		cmdUpgradeRoot,
+		cli.CompletionCmd(root))
 	cmdConfigRoot.AddCommand(cmdConfigList, cmdConfigMod)
+	cmdExpectedRoot.AddCommand(cmdExpectedList, cmdExpectedMod)
 	cmdGuestsRoot.AddCommand(cmdGuestsList)
 	cmdSubnetsRoot.AddCommand(cmdSubnetsList, cmdSubnetsMod, cmdSubnetsDel)
+	cmdUpgradeRoot.AddCommand(cmdUpgradeStart, cmdUpgradeList, cmdUpgradeShow, cmdUpgradePause, cmdUpgradeResume, cmdUpgradeAbort, cmdUpgradeDel)
 	if err := root.Execute(); err != nil {
-		log.WithField("error", err).Fatal("failed to execute root command")
+		cli.Fatal(cli.ExitUsage, log.Fields{"error": err}, "failed to execute root command")
 	}
 }
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness/internal/cli"
+	"github.com/spf13/cobra"
+)
+
+// topFetchers is how many hypervisors top fetches the telemetry and guests of
+// at the same time
+const topFetchers = 8
+
+var (
+	topInterval = 5 * time.Second
+
+	topTable = cli.Table{
+		{Header: "ID", Key: "id"},
+		{Header: "NAME", Key: "name"},
+		{Header: "STATE", Key: "state"},
+		{Header: "HEALTH", Key: "health"},
+		{Header: "LOAD", Key: "load"},
+		{Header: "GUESTS", Key: "guests"},
+		{Header: "CPU%", Key: "cpu"},
+		{Header: "MEM%", Key: "memory"},
+		{Header: "DISK%", Key: "disk"},
+		{Header: "FREE_MEM", Key: "free_memory"},
+	}
+)
+
+// topView is what top shows of the rows: how they are sorted and filtered,
+// and the filter being typed, if any
+type topView struct {
+	sort   string
+	desc   bool
+	filter string
+	typing bool
+	input  string
+}
+
+// topResult is a fetch of the rows of top
+type topResult struct {
+	rows []cli.JMap
+	err  error
+	at   time.Time
+}
+
+func top(cmd *cobra.Command, _ []string) {
+	c := newClient()
+	view := &topView{sort: "load", desc: true}
+	if tableOpts.Sort != "" {
+		if err := topTable.Sort(nil, tableOpts.Sort); err != nil {
+			cli.Fatal(cli.ExitUsage, log.Fields{"error": err}, "invalid sort")
+		}
+		view.sort = strings.TrimPrefix(tableOpts.Sort, "-")
+		view.desc = strings.HasPrefix(tableOpts.Sort, "-")
+	}
+
+	rows, err := topRows(c)
+	if err != nil {
+		cli.Fatal(cli.ExitServer, log.Fields{"error": err}, "failed to get hypervisors")
+	}
+	if jsonout || !cli.IsTerminal(os.Stdin, os.Stdout) {
+		rows = view.apply(rows)
+		if jsonout {
+			for _, row := range rows {
+				row.Print(jsonout)
+			}
+			return
+		}
+		if err := topTable.Print(os.Stdout, rows, cli.TableOptions{NoHeader: tableOpts.NoHeader}); err != nil {
+			cli.Fatal(cli.ExitError, log.Fields{"error": err}, "failed to print table")
+		}
+		return
+	}
+
+	screen, err := cli.NewScreen(os.Stdin, os.Stdout)
+	if err != nil {
+		cli.Fatal(cli.ExitError, log.Fields{"error": err}, "failed to set up terminal")
+	}
+	defer func() { _ = screen.Close() }()
+
+	results := make(chan topResult, 1)
+	fetch := func() {
+		go func() {
+			rows, err := topRows(c)
+			results <- topResult{rows: rows, err: err, at: time.Now()}
+		}()
+	}
+	refresh := make(chan struct{}, 1)
+	go streamHypervisorEvents(c, refresh)
+
+	ticker := time.NewTicker(topInterval)
+	defer ticker.Stop()
+	keys := screen.Keys()
+	last := topResult{rows: rows, at: time.Now()}
+	fetching, pending := false, false
+	for {
+		_ = screen.Draw(view.lines(last))
+
+		select {
+		case key, ok := <-keys:
+			if !ok || view.key(key) {
+				return
+			}
+			continue
+		case result := <-results:
+			fetching = false
+			if result.err != nil {
+				last.err = result.err
+			} else {
+				last = result
+			}
+			if !pending {
+				continue
+			}
+			pending = false
+		case <-ticker.C:
+		case <-refresh:
+		}
+
+		if fetching {
+			pending = true
+			continue
+		}
+		fetching = true
+		fetch()
+	}
+}
+
+// streamHypervisorEvents signals refresh whenever a hypervisor changes,
+// reconnecting every topInterval while the stream fails, e.g. against servers
+// without /events
+func streamHypervisorEvents(c *cli.Client, refresh chan<- struct{}) {
+	for {
+		err := c.StreamEvents("events?prefix=hypervisors", func(cli.Event) bool {
+			select {
+			case refresh <- struct{}{}:
+			default:
+			}
+			return true
+		})
+		log.WithField("error", err).Debug("hypervisor event stream ended")
+		time.Sleep(topInterval)
+	}
+}
+
+// topRows fetches the hypervisors, then the telemetry and guests of each, and
+// summarizes them as rows of topTable
+func topRows(c *cli.Client) ([]cli.JMap, error) {
+	hvs := []cli.JMap{}
+	if err := c.GetJSON("hypervisors"+cli.MetadataQuery(metadataFilters), &hvs); err != nil {
+		return nil, err
+	}
+
+	rows := make([]cli.JMap, len(hvs))
+	errs := make([]error, len(hvs))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < topFetchers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				rows[i], errs[i] = topRow(c, hvs[i])
+			}
+		}()
+	}
+	for i := range hvs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rows, nil
+}
+
+// topRow fetches the telemetry and guests of a hypervisor and summarizes it:
+// its state, the score of its health, its latest one minute load and free
+// memory, and the percentage of its resources allocated to guests
+func topRow(c *cli.Client, hv cli.JMap) (cli.JMap, error) {
+	id := hv.ID()
+	telemetry := cli.JMap{}
+	if err := c.GetJSON("hypervisors/"+id+"/telemetry", &telemetry); err != nil {
+		return nil, err
+	}
+	guests := []string{}
+	if err := c.GetJSON("hypervisors/"+id+"/guests", &guests); err != nil {
+		return nil, err
+	}
+
+	state := "down"
+	if maintenance, _ := hv["maintenance"].(bool); maintenance {
+		state = "maintenance"
+	} else if alive, _ := telemetry.Lookup("health.alive").(bool); alive {
+		state = "up"
+	}
+	row := cli.JMap{
+		"id":          id,
+		"name":        hv.Lookup("metadata.name"),
+		"state":       state,
+		"health":      round(telemetry.Lookup("health.score"), 100),
+		"load":        round(telemetry.Lookup("latest.load1"), 100),
+		"free_memory": telemetry.Lookup("latest.free_memory"),
+		"guests":      float64(len(guests)),
+	}
+	capacity := hvCapacity(hv)
+	for _, resource := range capacityResources {
+		r := capacity[resource].(map[string]interface{})
+		if total := r["total"].(float64); total > 0 {
+			row[resource] = math.Round(100 * r["used"].(float64) / total)
+		}
+	}
+	return row, nil
+}
+
+// round rounds a number to a fraction, e.g. hundredths for 100, leaving
+// anything else as it is
+func round(value interface{}, fraction float64) interface{} {
+	if v, ok := value.(float64); ok {
+		return math.Round(v*fraction) / fraction
+	}
+	return value
+}
+
+// apply filters and sorts rows as the view is set to
+func (v *topView) apply(rows []cli.JMap) []cli.JMap {
+	filtered := make([]cli.JMap, 0, len(rows))
+	filter := strings.ToLower(v.filter)
+	for _, row := range rows {
+		if filter == "" || v.matches(row, filter) {
+			filtered = append(filtered, row)
+		}
+	}
+	by := v.sort
+	if v.desc {
+		by = "-" + by
+	}
+	// sorted by id first, so that rows with the same value keep their place
+	_ = topTable.Sort(filtered, "id")
+	_ = topTable.Sort(filtered, by)
+	return filtered
+}
+
+// matches returns whether the id, name, or state of a row contains the
+// lowercase filter
+func (v *topView) matches(row cli.JMap, filter string) bool {
+	for _, key := range []string{"id", "name", "state"} {
+		if s, ok := row[key].(string); ok && strings.Contains(strings.ToLower(s), filter) {
+			return true
+		}
+	}
+	return false
+}
+
+// key handles a key press, returning whether to quit
+func (v *topView) key(key rune) bool {
+	if v.typing {
+		switch key {
+		case cli.KeyEnter:
+			v.filter, v.typing = v.input, false
+		case cli.KeyEscape:
+			v.typing = false
+		case cli.KeyBackspace, '\b':
+			if runes := []rune(v.input); len(runes) > 0 {
+				v.input = string(runes[:len(runes)-1])
+			}
+		case cli.KeyCtrlC:
+			return true
+		default:
+			if key >= ' ' {
+				v.input += string(key)
+			}
+		}
+		return false
+	}
+
+	switch key {
+	case 'q', cli.KeyCtrlC:
+		return true
+	case 's':
+		v.sort = nextColumn(v.sort)
+	case 'r':
+		v.desc = !v.desc
+	case '/':
+		v.typing, v.input = true, v.filter
+	}
+	return false
+}
+
+// nextColumn returns the key of the column of topTable after the one given,
+// by key or header
+func nextColumn(name string) string {
+	for i, col := range topTable {
+		if strings.EqualFold(col.Key, name) || strings.EqualFold(col.Header, name) {
+			return topTable[(i+1)%len(topTable)].Key
+		}
+	}
+	return topTable[0].Key
+}
+
+// lines renders the view of a result: a summary of the cluster, the sort and
+// filter, and the table of the rows
+func (v *topView) lines(result topResult) []string {
+	rows := v.apply(result.rows)
+
+	states := map[string]int{}
+	guests, load, loads := 0.0, 0.0, 0
+	for _, row := range result.rows {
+		states[row["state"].(string)]++
+		guests += row["guests"].(float64)
+		if l, ok := row["load"].(float64); ok {
+			load += l
+			loads++
+		}
+	}
+	summary := fmt.Sprintf("%s  %d hypervisors (%d up, %d down, %d maintenance)  %.0f guests",
+		result.at.Format("15:04:05"), len(result.rows), states["up"], states["down"], states["maintenance"], guests)
+	if loads > 0 {
+		summary += fmt.Sprintf("  load %.2f", load/float64(loads))
+	}
+
+	by := v.sort
+	if v.desc {
+		by = "-" + by
+	}
+	status := "sort: " + by
+	switch {
+	case v.typing:
+		status += "  filter: " + v.input + "_"
+	case v.filter != "":
+		status += "  filter: " + v.filter
+	}
+	status += "  (s sort, r reverse, / filter, q quit)"
+	if result.err != nil {
+		status += "  error: " + result.err.Error()
+	}
+
+	var buf bytes.Buffer
+	_ = topTable.Print(&buf, rows, cli.TableOptions{})
+	lines := []string{summary, status, ""}
+	return append(lines, strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")...)
+}
@@ -0,0 +1,86 @@
+/*
+image is the command line interface to cimaged, the image catalog service.
+image can list/create/modify/delete the images guests are created from, and
+show the state of each image on the hypervisors that have fetched it. It does
+not manage the images stored on hypervisors themselves, see img.
+
+All commands support dual output formats, a tree like output for humans
+(default) or a json output for further processing.
+
+Usage
+
+The following arguments are understood:
+
+	$ image -h
+	image is the cli interface to cimaged. All commands support arguments via command line or stdin
+
+	Usage:
+	  image [flags]
+	  image [command]
+
+	Available Commands:
+	  completion  Generate shell completion scripts
+	  create      Create new images
+	  delete      Delete images
+	  help        Help about any command
+	  hypervisors Show the fetch state of images on hypervisors
+	  list        List the images
+	  modify      Modify images
+
+	Flags:
+	      --dial-timeout duration   how long connecting to the server or proxy may take, 0 for the default of 30s
+	  -h, --help                    help for image
+	  -j, --json                    output in json
+	      --proxy string            url of the proxy to connect through, instead of those of $HTTP_PROXY, $HTTPS_PROXY, and $NO_PROXY, or "none" to connect directly
+	  -s, --server string           server address to connect to (default "http://localhost:21000")
+
+	Use "image [command] --help" for more information about a command.
+
+Exit Codes
+
+image exits 0 on success and otherwise with:
+
+	1  error       any other failure
+	2  usage       bad arguments, flags, or ids
+	3  not_found   the server has no such resource
+	4  validation  an invalid spec, or a request the server rejected as invalid
+	5  server      the server could not be reached, failed, or sent a bad response
+
+The last line written to stderr of a failed run is a json object describing
+the failure, with its kind under "error":
+
+	{"error":"not_found","exit_code":3,"message":"failed to get image","status":404,"fields":{...}}
+
+Examples
+
+Create an image
+
+	$ image create '{"name":"ubuntu-14.04","source":"https://images.example.com/ubuntu-14.04.img","checksum":"sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08","min_disk":2048,"min_memory":256}'
+	5f2a3b7c-0d4e-4f6a-8b9c-1d2e3f4a5b6c
+
+List images
+
+	$ image list -j
+	{"checksum":"sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08","id":"5f2a3b7c-0d4e-4f6a-8b9c-1d2e3f4a5b6c","metadata":{},"min_disk":2048,"min_memory":256,"name":"ubuntu-14.04","source":"https://images.example.com/ubuntu-14.04.img"}
+
+List images in a table
+
+	$ image list --table --sort name
+	ID                                    NAME          MIN_DISK  MIN_MEMORY  SOURCE
+	c3e5a1f0-6b2d-4e8f-9a7c-2d4b6f8a0c1e  debian-8      1024      0           https://images.example.com/debian-8.img
+	5f2a3b7c-0d4e-4f6a-8b9c-1d2e3f4a5b6c  ubuntu-14.04  2048      256         https://images.example.com/ubuntu-14.04.img
+
+Update the checksum of an image whose contents changed, so that hypervisors
+fetch it again
+
+	$ image modify 5f2a3b7c-0d4e-4f6a-8b9c-1d2e3f4a5b6c '{"checksum":"sha256:60303ae22b998861bce3b28f33eec1be758a213c86c93c076dbe9f558c11c752"}'
+	5f2a3b7c-0d4e-4f6a-8b9c-1d2e3f4a5b6c
+
+Show the fetch state of an image
+
+	$ image hypervisors 5f2a3b7c-0d4e-4f6a-8b9c-1d2e3f4a5b6c
+	5f2a3b7c-0d4e-4f6a-8b9c-1d2e3f4a5b6c
+	├── 1f5acce3-96b4-4ccb-865f-e6c44f68900d:failed (checksum mismatch)
+	└── e88a75a6-7ae6-487c-9634-6553d3793437:fetched
+*/
+package main
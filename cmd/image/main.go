@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/andrew-d/go-termutil"
+	"github.com/mistifyio/lochness/internal/cli"
+	"github.com/spf13/cobra"
+)
+
+var (
+	server  = "http://localhost:21000"
+	jsonout = false
+
+	transportOpts = cli.TransportOptions{}
+
+	tableOpts  = cli.TableOptions{}
+	imageTable = cli.Table{
+		{Header: "ID", Key: "id"},
+		{Header: "NAME", Key: "name"},
+		{Header: "MIN_DISK", Key: "min_disk"},
+		{Header: "MIN_MEMORY", Key: "min_memory"},
+		{Header: "SOURCE", Key: "source"},
+	}
+)
+
+// newClient creates a client for the server, connecting as set by --proxy and
+// --dial-timeout
+func newClient() *cli.Client {
+	c := cli.NewClient(server)
+	if err := c.ConfigureTransport(transportOpts); err != nil {
+		cli.Fatal(cli.ExitUsage, log.Fields{
+			"proxy":        transportOpts.Proxy,
+			"dial-timeout": transportOpts.DialTimeout,
+			"error":        err,
+		}, "invalid transport settings")
+	}
+	return c
+}
+
+func help(cmd *cobra.Command, _ []string) {
+	if err := cmd.Help(); err != nil {
+		cli.Fatal(cli.ExitError, log.Fields{"error": err}, "help")
+	}
+}
+
+func getImages(c *cli.Client) []cli.JMap {
+	ret, _ := c.GetMany("images", "images")
+	images := make([]cli.JMap, len(ret))
+	for i := range ret {
+		images[i] = ret[i]
+	}
+	return images
+}
+
+func getImage(c *cli.Client, id string) cli.JMap {
+	image, _ := c.Get("image", "images/"+id)
+	return image
+}
+
+func getFetches(c *cli.Client, id string) []map[string]interface{} {
+	fetches, _ := c.GetMany("hypervisors", "images/"+id+"/hypervisors")
+	return fetches
+}
+
+func createImage(c *cli.Client, spec string) cli.JMap {
+	image, _ := c.Post("image", "images", spec)
+	return image
+}
+
+func modifyImage(c *cli.Client, id string, spec string) cli.JMap {
+	image, _ := c.Patch("image", "images/"+id, spec)
+	return image
+}
+
+func deleteImage(c *cli.Client, id string) cli.JMap {
+	image, _ := c.Delete("image", "images/"+id)
+	return image
+}
+
+// printFetches prints the image id, followed by a tree of the hypervisors that
+// have fetched it and the state of each fetch
+func printFetches(id string, fetches []map[string]interface{}) {
+	if jsonout {
+		for _, fetch := range fetches {
+			cli.JMap(fetch).Print(jsonout)
+		}
+		return
+	}
+
+	fmt.Println(id)
+	if len(fetches) == 0 {
+		return
+	}
+	sort.Slice(fetches, func(i, j int) bool {
+		return fmt.Sprint(fetches[i]["hypervisor"]) < fmt.Sprint(fetches[j]["hypervisor"])
+	})
+
+	for i, fetch := range fetches {
+		branch := "├── "
+		if i == len(fetches)-1 {
+			branch = "└── "
+		}
+		state := fmt.Sprint(fetch["state"])
+		if fetchErr, ok := fetch["error"]; ok {
+			state += " (" + fmt.Sprint(fetchErr) + ")"
+		}
+		fmt.Print(branch, fetch["hypervisor"], ":", state, "\n")
+	}
+}
+
+func list(cmd *cobra.Command, args []string) {
+	c := newClient()
+	images := []cli.JMap{}
+	if len(args) == 0 {
+		if termutil.Isatty(os.Stdin.Fd()) {
+			images = getImages(c)
+			sort.Sort(cli.JMapSlice(images))
+		} else {
+			args = cli.Read(os.Stdin)
+		}
+	}
+	if len(images) == 0 {
+		for _, id := range args {
+			cli.AssertID(id)
+			images = append(images, getImage(c, id))
+		}
+	}
+
+	if tableOpts.Table && !jsonout {
+		if err := imageTable.Print(os.Stdout, images, tableOpts); err != nil {
+			cli.Fatal(cli.ExitError, log.Fields{"error": err}, "failed to print table")
+		}
+		return
+	}
+
+	for _, image := range images {
+		image.Print(jsonout)
+	}
+}
+
+func create(cmd *cobra.Command, specs []string) {
+	c := newClient()
+	if len(specs) == 0 {
+		specs = cli.Read(os.Stdin)
+	}
+
+	for _, spec := range specs {
+		cli.AssertSpec(spec)
+		image := createImage(c, spec)
+		image.Print(jsonout)
+	}
+}
+
+func modify(cmd *cobra.Command, args []string) {
+	c := newClient()
+	if len(args) == 0 {
+		args = cli.Read(os.Stdin)
+	}
+	if len(args)%2 != 0 {
+		cli.Fatal(cli.ExitUsage, log.Fields{"num": len(args)}, "expected an even amount of args")
+	}
+
+	for i := 0; i < len(args); i += 2 {
+		id := args[i]
+		cli.AssertID(id)
+		spec := args[i+1]
+		cli.AssertSpec(spec)
+
+		image := modifyImage(c, id, spec)
+		image.Print(jsonout)
+	}
+}
+
+func del(cmd *cobra.Command, ids []string) {
+	c := newClient()
+	if len(ids) == 0 {
+		ids = cli.Read(os.Stdin)
+	}
+
+	for _, id := range ids {
+		cli.AssertID(id)
+		image := deleteImage(c, id)
+		image.Print(jsonout)
+	}
+}
+
+func hypervisors(cmd *cobra.Command, ids []string) {
+	c := newClient()
+	if len(ids) == 0 {
+		ids = cli.Read(os.Stdin)
+	}
+
+	for _, id := range ids {
+		cli.AssertID(id)
+		printFetches(id, getFetches(c, id))
+	}
+}
+
+// listImageIDs fetches the image ids for completion
+func listImageIDs() ([]string, error) {
+	return newClient().ListIDs("images")
+}
+
+func main() {
+	root := &cobra.Command{
+		Use:  "image",
+		Long: "image is the cli interface to cimaged. All commands support arguments via command line or stdin",
+		Run:  help,
+	}
+	root.PersistentFlags().BoolVarP(&jsonout, "json", "j", jsonout, "output in json")
+	root.PersistentFlags().StringVarP(&server, "server", "s", server, "server address to connect to")
+	transportOpts.AddFlags(root.PersistentFlags())
+
+	cmdList := &cobra.Command{
+		Use:   "list [<image>...]",
+		Short: "List the images",
+		Run:   list,
+
+		ValidArgsFunction: cli.CompleteIDs(listImageIDs),
+	}
+	tableOpts.AddFlags(cmdList.Flags())
+	cmdCreate := &cobra.Command{
+		Use:   "create <spec>...",
+		Short: "Create new images",
+		Long: `Create a new image using "spec" as the initial values. "spec" must be
+valid json and contain the required fields, "name", "source", and "checksum".`,
+		Run: create,
+	}
+	cmdMod := &cobra.Command{
+		Use:   "modify (<image> <spec>)...",
+		Short: "Modify images",
+		Long:  `Modify given image. Where "spec" is a valid json string.`,
+		Run:   modify,
+
+		ValidArgsFunction: cli.CompleteIDPairs(listImageIDs),
+	}
+	cmdDel := &cobra.Command{
+		Use:   "delete <image>...",
+		Short: "Delete images",
+		Run:   del,
+
+		ValidArgsFunction: cli.CompleteIDs(listImageIDs),
+	}
+	cmdHypervisors := &cobra.Command{
+		Use:   "hypervisors <image>...",
+		Short: "Show the fetch state of images on hypervisors",
+		Long: `Show the state of each image on the hypervisors that have fetched it, one
+of "fetching", "fetched", or "failed".`,
+		Run: hypervisors,
+
+		ValidArgsFunction: cli.CompleteIDs(listImageIDs),
+	}
+
+	root.AddCommand(cmdList,
+		cmdCreate,
+		cmdDel,
+		cmdMod,
+		cmdHypervisors,
+		cli.CompletionCmd(root))
+	if err := root.Execute(); err != nil {
+		cli.Fatal(cli.ExitUsage, log.Fields{"error": err}, "failed to execute root command")
+	}
+}
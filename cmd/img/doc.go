@@ -20,8 +20,10 @@ The following arguments are understood
 	help        Help about any command
 
 	Flags:
+	    --dial-timeout=0s: how long connecting to the server or proxy may take, 0 for the default of 30s
 	-h, --help=false: help for img
 	-j, --json=false: output in json
+	    --proxy="": url of the proxy to connect through, instead of those of $HTTP_PROXY, $HTTPS_PROXY, and $NO_PROXY, or "none" to connect directly
 	-s, --server="image.services.lochness.local": server address to connect to
 
 
@@ -34,6 +36,21 @@ Output
 All commands except download support two output formats, a list of image ids or
 a list of JSON objects, line separated. The JSON is a metadata.Image object.
 
+Exit Codes
+
+img exits 0 on success and otherwise with:
+
+	1  error       any other failure
+	2  usage       bad arguments, flags, or ids
+	3  not_found   the server has no such resource
+	4  validation  an invalid spec, or a request the server rejected as invalid
+	5  server      the server could not be reached, failed, or sent a bad response
+
+The last line written to stderr of a failed run is a json object describing
+the failure, with its kind under "error":
+
+	{"error":"not_found","exit_code":3,"message":"failed to get image","status":404,"fields":{...}}
+
 Examples
 
 List images
@@ -25,11 +25,27 @@ var (
 	server      = "image.services.lochness.local"
 	jsonout     = false
 	downloadDir = os.TempDir()
+
+	transportOpts = cli.TransportOptions{}
 )
 
+// newClient creates a client for the server, connecting as set by --proxy and
+// --dial-timeout
+func newClient() *cli.Client {
+	c := cli.NewClient(getServerURL())
+	if err := c.ConfigureTransport(transportOpts); err != nil {
+		cli.Fatal(cli.ExitUsage, log.Fields{
+			"proxy":        transportOpts.Proxy,
+			"dial-timeout": transportOpts.DialTimeout,
+			"error":        err,
+		}, "invalid transport settings")
+	}
+	return c
+}
+
 func help(cmd *cobra.Command, _ []string) {
 	if err := cmd.Help(); err != nil {
-		log.WithField("error", err).Fatal("help")
+		cli.Fatal(cli.ExitError, log.Fields{"error": err}, "help")
 	}
 }
 
@@ -43,7 +59,7 @@ func getImages(c *cli.Client) []cli.JMap {
 }
 
 func list(cmd *cobra.Command, args []string) {
-	c := cli.NewClient(getServerURL())
+	c := newClient()
 	images := []cli.JMap{}
 	if len(args) == 0 {
 		if termutil.Isatty(os.Stdin.Fd()) {
@@ -67,7 +83,7 @@ func list(cmd *cobra.Command, args []string) {
 }
 
 func fetch(cmd *cobra.Command, specs []string) {
-	c := cli.NewClient(getServerURL())
+	c := newClient()
 	if len(specs) == 0 {
 		specs = cli.Read(os.Stdin)
 	}
@@ -87,28 +103,28 @@ func upload(cmd *cobra.Command, specs []string) {
 	for _, spec := range specs {
 		uploadImage := &metadata.Image{}
 		if err := json.Unmarshal([]byte(spec), uploadImage); err != nil {
-			log.WithFields(log.Fields{
+			cli.Fatal(cli.ExitValidation, log.Fields{
 				"error": err,
 				"json":  spec,
 				"func":  "json.Unmarshal",
-			}).Fatal("invalid spec")
+			}, "invalid spec")
 		}
 
 		sourcePath, err := filepath.Abs(uploadImage.Source)
 		if err != nil {
-			log.WithFields(log.Fields{
+			cli.Fatal(cli.ExitError, log.Fields{
 				"error": err,
 				"image": uploadImage,
 				"func":  "filepath.Abs",
-			}).Fatal("failed determine absolute source path")
+			}, "failed determine absolute source path")
 		}
 		file, err := os.Open(sourcePath)
 		if err != nil {
-			log.WithFields(log.Fields{
+			cli.Fatal(cli.ExitError, log.Fields{
 				"error": err,
 				"path":  sourcePath,
 				"func":  "os.Open",
-			}).Fatal("failed to open file")
+			}, "failed to open file")
 		}
 		// File remains open until function exit
 		defer logx.LogReturnedErr(file.Close, log.Fields{
@@ -117,21 +133,21 @@ func upload(cmd *cobra.Command, specs []string) {
 
 		info, err := file.Stat()
 		if err != nil {
-			log.WithFields(log.Fields{
+			cli.Fatal(cli.ExitError, log.Fields{
 				"error": err,
 				"file":  file.Name(),
 				"func":  "file.Stat",
-			}).Fatal("failed to stat file")
+			}, "failed to stat file")
 		}
 
 		req, err := http.NewRequest("PUT", uploadURL, file)
 		if err != nil {
-			log.WithFields(log.Fields{
+			cli.Fatal(cli.ExitError, log.Fields{
 				"error": err,
 				"url":   uploadURL,
 				"file":  file.Name(),
 				"func":  "http.NewRequest",
-			}).Fatal("failed to create request")
+			}, "failed to create request")
 		}
 		req.Header.Add("Content-Length", fmt.Sprintf("%d", info.Size()))
 		req.Header.Add("X-Image-Type", uploadImage.Type)
@@ -139,12 +155,12 @@ func upload(cmd *cobra.Command, specs []string) {
 		req.Header.Add("Content-Type", "application/octet-stream")
 		res, err := http.DefaultClient.Do(req)
 		if err != nil {
-			log.WithFields(log.Fields{
+			cli.Fatal(cli.ExitServer, log.Fields{
 				"error": err,
 				"url":   uploadURL,
 				"file":  file.Name(),
 				"func":  "http.DefaultClient.Do",
-			}).Fatal("request error")
+			}, "request error")
 		}
 		image := &cli.JMap{}
 		cli.ProcessResponse(res, "image", "upload", []int{http.StatusOK}, image)
@@ -161,11 +177,11 @@ func download(cmd *cobra.Command, ids []string) {
 		success := false
 		tempDest, err := ioutil.TempFile(downloadDir, "incompleteImage-")
 		if err != nil {
-			log.WithFields(log.Fields{
+			cli.Fatal(cli.ExitError, log.Fields{
 				"error": err,
 				"dir":   downloadDir,
 				"func":  "ioutil.TempFile",
-			}).Fatal("could not create temporary file")
+			}, "could not create temporary file")
 		}
 		defer func() {
 			if !success {
@@ -250,7 +266,7 @@ func download(cmd *cobra.Command, ids []string) {
 }
 
 func del(cmd *cobra.Command, ids []string) {
-	c := cli.NewClient(getServerURL())
+	c := newClient()
 	if len(ids) == 0 {
 		ids = cli.Read(os.Stdin)
 	}
@@ -265,7 +281,10 @@ func del(cmd *cobra.Command, ids []string) {
 func getServerURL() string {
 	hostport, err := netutil.HostWithPort(server)
 	if err != nil {
-		os.Exit(1)
+		cli.Fatal(cli.ExitUsage, log.Fields{
+			"error":  err,
+			"server": server,
+		}, "invalid server address")
 	}
 
 	serverURL := &url.URL{
@@ -277,11 +296,11 @@ func getServerURL() string {
 
 func main() {
 	if err := logx.DefaultSetup("error"); err != nil {
-		log.WithFields(log.Fields{
+		cli.Fatal(cli.ExitError, log.Fields{
 			"error": err,
 			"func":  "logx.DefaultSetup",
 			"level": "error",
-		}).Fatal("unable to set up logrus")
+		}, "unable to set up logrus")
 	}
 
 	root := &cobra.Command{
@@ -291,6 +310,7 @@ func main() {
 	}
 	root.PersistentFlags().BoolVarP(&jsonout, "json", "j", jsonout, "output in json")
 	root.PersistentFlags().StringVarP(&server, "server", "s", server, "server address to connect to")
+	transportOpts.AddFlags(root.PersistentFlags())
 
 	cmdList := &cobra.Command{
 		Use:   "list [<id>...]",
@@ -331,6 +351,6 @@ func main() {
 	root.AddCommand(cmdDelete)
 
 	if err := root.Execute(); err != nil {
-		log.WithField("error", err).Fatal("failed to execute root command")
+		cli.Fatal(cli.ExitUsage, log.Fields{"error": err}, "failed to execute root command")
 	}
 }
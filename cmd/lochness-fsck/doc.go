@@ -0,0 +1,81 @@
+/*
+lochness-fsck checks the references between guests, hypervisors, and subnets in
+the kv, and the index of their metadata, such as those left behind by a daemon
+that died halfway through a change, and repairs them.
+
+Usage
+
+The following arguments are understood
+
+	$ lochness-fsck -h
+	lochness-fsck checks the references between guests, hypervisors, and subnets in the kv, and repairs them with --fix.
+
+	Usage:
+	  lochness-fsck [flags]
+
+	Flags:
+	      --fix                repair the problems found
+	  -h, --help               help for lochness-fsck
+	  -k, --kv string          address of kv machine (default "http://localhost:4001")
+	      --kv-prefix string   root of the lochness keys in the kv, to share a kv cluster between lochness clusters (default "/lochness")
+
+Checks
+
+Hypervisors and subnets only exist if their metadata does. The problems found,
+and how --fix repairs them, are
+
+	listed_guest_missing      a hypervisor lists a guest that does not exist,
+	                          or is assigned elsewhere: the entry is removed
+	address_orphaned          a subnet address is reserved for a guest that does
+	                          not exist, or has another address: it is released
+	guest_hypervisor_missing  a guest is assigned to a hypervisor that does not
+	                          exist: the guest is unassigned, releasing its
+	                          address, so that it can be scheduled again
+	guest_subnet_missing      a guest has an address in a subnet that does not
+	                          exist: the guest is unassigned
+	guest_not_listed          a guest is assigned to a hypervisor that does not
+	                          list it: it is added to the list
+	guest_address_missing     a guest's address is not reserved for it: it is
+	                          reserved, unless another guest holds it
+	metadata_index_stale      the metadata index lists a guest or hypervisor
+	                          that does not exist, or no longer has the pair:
+	                          the entry is removed
+	metadata_index_missing    a metadata pair of a guest or hypervisor is not
+	                          in the metadata index: it is added
+
+Hypervisors whose guests change are told to converge, as with any other change.
+Problems are fixed in the order above, so that addresses are released before
+they are reserved again. Changes made to the kv while it is checked may be
+reported as problems, so it is best checked while the daemons making placement
+changes are stopped, and checked again after fixing. Guests and hypervisors
+saved before their metadata was indexed are indexed by --fix.
+
+Report
+
+The report is a json object with the number of records checked, and the
+problems found, sorted by check and key. Keys are relative to the lochness root.
+With --fix, each problem records whether it was fixed, or why not.
+
+	$ lochness-fsck --fix
+	{
+		"checked": {
+			"addresses": 12,
+			"guests": 14,
+			"hypervisors": 3,
+			"metadata": 9,
+			"subnets": 2
+		},
+		"problems": [
+			{
+				"check": "address_orphaned",
+				"key": "subnets/a3fd8c1e-62a4-4be1-b0d3-8d9a1fd1b5c2/addresses/10.10.10.23",
+				"message": "address is reserved for guest \"4d003c76-71d3-44ad-8518-3337273925ff\", which does not exist",
+				"fixed": true
+			}
+		]
+	}
+
+The exit status, as with fsck(8), is 0 if no problems were found, 1 if they were
+all fixed, 4 if some remain, and 8 if the kv could not be checked.
+*/
+package main
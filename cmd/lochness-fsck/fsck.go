@@ -0,0 +1,415 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/pkg/kv"
+)
+
+// Checks, as reported
+const (
+	checkListedGuestMissing     = "listed_guest_missing"
+	checkAddressOrphaned        = "address_orphaned"
+	checkGuestHypervisorMissing = "guest_hypervisor_missing"
+	checkGuestSubnetMissing     = "guest_subnet_missing"
+	checkGuestNotListed         = "guest_not_listed"
+	checkGuestAddressMissing    = "guest_address_missing"
+	checkMetadataIndexStale     = "metadata_index_stale"
+	checkMetadataIndexMissing   = "metadata_index_missing"
+)
+
+// checks are the checks made, in the order their problems are fixed, so that
+// addresses are released before they are reserved
+var checks = []string{
+	checkListedGuestMissing,
+	checkAddressOrphaned,
+	checkGuestHypervisorMissing,
+	checkGuestSubnetMissing,
+	checkGuestNotListed,
+	checkGuestAddressMissing,
+	checkMetadataIndexStale,
+	checkMetadataIndexMissing,
+}
+
+type (
+	// problem is an inconsistency found in the kv
+	problem struct {
+		Check   string `json:"check"`
+		Key     string `json:"key"` // relative to the lochness root
+		Message string `json:"message"`
+		Fixed   bool   `json:"fixed"`
+		Error   string `json:"error,omitempty"` // why the fix failed
+		fix     func() error
+	}
+
+	// report is the result of a check
+	report struct {
+		Checked  map[string]int `json:"checked"`
+		Problems []*problem     `json:"problems"`
+	}
+
+	// guestRecord is the part of a guest the checks need
+	guestRecord struct {
+		ID           string            `json:"id"`
+		HypervisorID string            `json:"hypervisor"`
+		SubnetID     string            `json:"subnet"`
+		IP           string            `json:"ip"`
+		Metadata     map[string]string `json:"metadata"`
+	}
+
+	// hypervisorRecord is the part of a hypervisor the checks need
+	hypervisorRecord struct {
+		Metadata map[string]string `json:"metadata"`
+	}
+
+	// cluster holds the records loaded from the kv. Hypervisors and subnets
+	// only exist if their metadata does, so that the guest lists and
+	// addresses left behind by partial deletes are found too.
+	cluster struct {
+		guests      map[string]*guestRecord
+		hypervisors map[string]*hypervisorRecord
+		listed      map[string]map[string]bool // hypervisor id to listed guest ids
+		subnets     map[string]bool
+		reserved    map[string]map[string]string // subnet id to ip to guest id
+		indexed     map[string]bool              // metadata index keys
+	}
+
+	// checker checks the lochness keys of a kv, and fixes them through ctx
+	checker struct {
+		kv  kv.KV
+		ctx *lochness.Context
+	}
+)
+
+// root returns the lochness root in the kv, without slashes
+func root() string {
+	return strings.Trim(kv.DefaultPrefix, "/")
+}
+
+// relKey returns a key relative to the root, under one of the lochness paths
+func relKey(base string, elem ...string) string {
+	return path.Join(append([]string{strings.TrimPrefix(base, root()+"/")}, elem...)...)
+}
+
+// load reads the guests, hypervisors, and subnets from the kv
+func (c *checker) load() (*cluster, error) {
+	values, err := c.kv.GetAll(root() + "/")
+	if err != nil && !c.kv.IsKeyNotFound(err) {
+		return nil, err
+	}
+
+	cl := &cluster{
+		guests:      make(map[string]*guestRecord),
+		hypervisors: make(map[string]*hypervisorRecord),
+		listed:      make(map[string]map[string]bool),
+		subnets:     make(map[string]bool),
+		reserved:    make(map[string]map[string]string),
+		indexed:     make(map[string]bool),
+	}
+	for key, value := range values {
+		parts := strings.Split(strings.TrimPrefix(strings.TrimPrefix(key, "/"), root()+"/"), "/")
+		switch {
+		case len(parts) == 3 && parts[0] == "guests" && parts[2] == "metadata":
+			g := &guestRecord{}
+			if err := json.Unmarshal(value.Data, g); err != nil {
+				return nil, fmt.Errorf("%s: %s", key, err)
+			}
+			g.ID = parts[1]
+			cl.guests[g.ID] = g
+		case len(parts) == 3 && parts[0] == "hypervisors" && parts[2] == "metadata":
+			h := &hypervisorRecord{}
+			if err := json.Unmarshal(value.Data, h); err != nil {
+				return nil, fmt.Errorf("%s: %s", key, err)
+			}
+			cl.hypervisors[parts[1]] = h
+		case len(parts) == 4 && parts[0] == "hypervisors" && parts[2] == "guests":
+			if cl.listed[parts[1]] == nil {
+				cl.listed[parts[1]] = make(map[string]bool)
+			}
+			cl.listed[parts[1]][parts[3]] = true
+		case len(parts) == 3 && parts[0] == "subnets" && parts[2] == "metadata":
+			cl.subnets[parts[1]] = true
+		case len(parts) == 4 && parts[0] == "subnets" && parts[2] == "addresses":
+			if cl.reserved[parts[1]] == nil {
+				cl.reserved[parts[1]] = make(map[string]string)
+			}
+			cl.reserved[parts[1]][parts[3]] = string(value.Data)
+		case len(parts) == 5 && parts[0] == "metadata-index":
+			cl.indexed[strings.Join(parts, "/")] = true
+		}
+	}
+	return cl, nil
+}
+
+// check loads the kv and returns the problems found in it, sorted by check and
+// key
+func (c *checker) check() (*report, error) {
+	cl, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &report{
+		Checked: map[string]int{
+			"guests":      len(cl.guests),
+			"hypervisors": len(cl.hypervisors),
+			"subnets":     len(cl.subnets),
+			"addresses":   0,
+			"metadata":    len(cl.indexed),
+		},
+		Problems: []*problem{},
+	}
+
+	for hid, listed := range cl.listed {
+		for gid := range listed {
+			g, ok := cl.guests[gid]
+			if ok && g.HypervisorID == hid {
+				continue
+			}
+			msg := "listed guest does not exist"
+			if ok {
+				msg = fmt.Sprintf("listed guest is assigned to hypervisor %q", g.HypervisorID)
+			}
+			hid, key := hid, relKey(lochness.HypervisorPath, hid, "guests", gid)
+			r.Problems = append(r.Problems, &problem{
+				Check:   checkListedGuestMissing,
+				Key:     key,
+				Message: msg,
+				fix: func() error {
+					if err := c.delete(key); err != nil {
+						return err
+					}
+					return c.bumpGeneration(hid)
+				},
+			})
+		}
+	}
+
+	for sid, reserved := range cl.reserved {
+		for ip, gid := range reserved {
+			r.Checked["addresses"]++
+			g, ok := cl.guests[gid]
+			if ok && g.SubnetID == sid && sameIP(g.IP, ip) {
+				continue
+			}
+			msg := fmt.Sprintf("address is reserved for guest %q, which does not exist", gid)
+			if ok {
+				msg = fmt.Sprintf("address is reserved for guest %q, which has address %q in subnet %q", gid, g.IP, g.SubnetID)
+			}
+			key := relKey(lochness.SubnetPath, sid, "addresses", ip)
+			r.Problems = append(r.Problems, &problem{
+				Check:   checkAddressOrphaned,
+				Key:     key,
+				Message: msg,
+				fix:     func() error { return c.delete(key) },
+			})
+		}
+	}
+
+	for gid, g := range cl.guests {
+		g := g
+		key := relKey(lochness.GuestPath, gid, "metadata")
+		switch {
+		case g.HypervisorID == "":
+			continue
+		case cl.hypervisors[g.HypervisorID] == nil:
+			r.Problems = append(r.Problems, &problem{
+				Check:   checkGuestHypervisorMissing,
+				Key:     key,
+				Message: fmt.Sprintf("guest is assigned to hypervisor %q, which does not exist", g.HypervisorID),
+				fix:     func() error { return c.unassign(g) },
+			})
+			continue
+		case g.SubnetID != "" && !cl.subnets[g.SubnetID]:
+			r.Problems = append(r.Problems, &problem{
+				Check:   checkGuestSubnetMissing,
+				Key:     key,
+				Message: fmt.Sprintf("guest has an address in subnet %q, which does not exist", g.SubnetID),
+				fix:     func() error { return c.unassign(g) },
+			})
+			continue
+		}
+
+		if !cl.listed[g.HypervisorID][gid] {
+			r.Problems = append(r.Problems, &problem{
+				Check:   checkGuestNotListed,
+				Key:     key,
+				Message: fmt.Sprintf("guest is assigned to hypervisor %q, which does not list it", g.HypervisorID),
+				fix: func() error {
+					listKey := relKey(lochness.HypervisorPath, g.HypervisorID, "guests", g.ID)
+					if err := c.kv.Set(path.Join(root(), listKey), g.ID); err != nil {
+						return err
+					}
+					return c.bumpGeneration(g.HypervisorID)
+				},
+			})
+		}
+
+		if g.SubnetID == "" || g.IP == "" {
+			continue
+		}
+		if holder := cl.reserved[g.SubnetID][g.IP]; holder != gid {
+			msg := "guest address is not reserved in its subnet"
+			if holder != "" {
+				msg = fmt.Sprintf("guest address is reserved for guest %q", holder)
+			}
+			r.Problems = append(r.Problems, &problem{
+				Check:   checkGuestAddressMissing,
+				Key:     key,
+				Message: msg,
+				fix: func() error {
+					// Only a free address is reserved, so an address
+					// held by another guest is never taken from it
+					addressKey := relKey(lochness.SubnetPath, g.SubnetID, "addresses", g.IP)
+					_, err := c.kv.Update(path.Join(root(), addressKey), kv.Value{Data: []byte(g.ID)})
+					return err
+				},
+			})
+		}
+	}
+
+	r.Problems = append(r.Problems, c.checkMetadataIndex(cl)...)
+
+	rank := make(map[string]int, len(checks))
+	for i, check := range checks {
+		rank[check] = i
+	}
+	sort.Slice(r.Problems, func(i, j int) bool {
+		a, b := r.Problems[i], r.Problems[j]
+		if a.Check != b.Check {
+			return rank[a.Check] < rank[b.Check]
+		}
+		return a.Key < b.Key
+	})
+	return r, nil
+}
+
+// checkMetadataIndex returns the problems of the metadata index: keys indexing
+// pairs that guests and hypervisors do not have, and pairs they have that are
+// not indexed
+func (c *checker) checkMetadataIndex(cl *cluster) []*problem {
+	expected := make(map[string]string) // index key to id
+	for id, g := range cl.guests {
+		for k, v := range g.Metadata {
+			expected[relKey(lochness.MetadataIndexKey(lochness.MetadataKindGuests, k, v, id))] = id
+		}
+	}
+	for id, h := range cl.hypervisors {
+		for k, v := range h.Metadata {
+			expected[relKey(lochness.MetadataIndexKey(lochness.MetadataKindHypervisors, k, v, id))] = id
+		}
+	}
+
+	problems := []*problem{}
+	for key := range cl.indexed {
+		if _, ok := expected[key]; ok {
+			continue
+		}
+		key := key
+		problems = append(problems, &problem{
+			Check:   checkMetadataIndexStale,
+			Key:     key,
+			Message: "metadata index entry has no matching guest or hypervisor metadata",
+			fix:     func() error { return c.delete(key) },
+		})
+	}
+	for key, id := range expected {
+		if cl.indexed[key] {
+			continue
+		}
+		key, id := key, id
+		problems = append(problems, &problem{
+			Check:   checkMetadataIndexMissing,
+			Key:     key,
+			Message: "metadata is not indexed",
+			fix:     func() error { return c.kv.Set(path.Join(root(), key), id) },
+		})
+	}
+	return problems
+}
+
+// fix fixes the problems in order, recording which were fixed, and returns
+// whether all of them were
+func (r *report) fix() bool {
+	ok := true
+	for _, p := range r.Problems {
+		if err := p.fix(); err != nil {
+			p.Error = err.Error()
+			ok = false
+			continue
+		}
+		p.Fixed = true
+	}
+	return ok
+}
+
+// unassign removes a guest from its hypervisor and releases its address, so
+// that it can be scheduled again
+func (c *checker) unassign(g *guestRecord) error {
+	if err := c.delete(relKey(lochness.HypervisorPath, g.HypervisorID, "guests", g.ID)); err != nil {
+		return err
+	}
+	if g.SubnetID != "" && g.IP != "" {
+		addressKey := path.Join(root(), relKey(lochness.SubnetPath, g.SubnetID, "addresses", g.IP))
+		value, err := c.kv.Get(addressKey)
+		switch {
+		case err == nil && string(value.Data) == g.ID:
+			if err := c.kv.Delete(addressKey, false); err != nil {
+				return err
+			}
+		case err != nil && !c.kv.IsKeyNotFound(err):
+			return err
+		}
+	}
+
+	guest, err := c.ctx.Guest(g.ID)
+	if err != nil {
+		return err
+	}
+	hypervisorID := guest.HypervisorID
+	guest.HypervisorID = ""
+	guest.SubnetID = ""
+	guest.IP = nil
+	guest.Bridge = ""
+	if err := guest.Save(); err != nil {
+		return err
+	}
+	return c.bumpGeneration(hypervisorID)
+}
+
+// delete deletes a key relative to the root, unless it is already gone
+func (c *checker) delete(key string) error {
+	if err := c.kv.Delete(path.Join(root(), key), false); err != nil && !c.kv.IsKeyNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// bumpGeneration lets a hypervisor know that its guests changed, if it exists
+func (c *checker) bumpGeneration(id string) error {
+	key := path.Join(root(), relKey(lochness.HypervisorPath, id, "metadata"))
+	if _, err := c.kv.Get(key); err != nil {
+		if c.kv.IsKeyNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	h, err := c.ctx.Hypervisor(id)
+	if err != nil {
+		return err
+	}
+	_, err = h.BumpGeneration()
+	return err
+}
+
+// sameIP returns whether two strings are the same ip address
+func sameIP(a, b string) bool {
+	ip := net.ParseIP(a)
+	return a == b || (ip != nil && ip.Equal(net.ParseIP(b)))
+}
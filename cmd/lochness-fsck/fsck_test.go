@@ -0,0 +1,177 @@
+package main
+
+import (
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestFsck(t *testing.T) {
+	suite.Run(t, new(FsckSuite))
+}
+
+type FsckSuite struct {
+	common.Suite
+	Checker *checker
+}
+
+func (s *FsckSuite) SetupSuite() {
+	log.SetLevel(log.FatalLevel)
+	s.Suite.SetupSuite()
+}
+
+func (s *FsckSuite) SetupTest() {
+	s.Suite.SetupTest()
+	s.Checker = &checker{kv: s.KV, ctx: s.Context}
+}
+
+// checks returns the checks and keys of the problems in the report
+func (s *FsckSuite) checks(r *report) [][2]string {
+	found := [][2]string{}
+	for _, p := range r.Problems {
+		found = append(found, [2]string{p.Check, p.Key})
+	}
+	return found
+}
+
+func (s *FsckSuite) TestClean() {
+	hypervisor, _ := s.NewHypervisorWithGuest()
+	_ = s.NewGuest()
+
+	r, err := s.Checker.check()
+	s.Require().NoError(err)
+	s.Empty(r.Problems)
+	s.Equal(2, r.Checked["guests"])
+	s.Equal(1, r.Checked["hypervisors"])
+	s.Equal(1, r.Checked["addresses"])
+
+	generation, err := hypervisor.Generation()
+	s.Require().NoError(err)
+	s.True(r.fix())
+	after, _ := hypervisor.Generation()
+	s.Equal(generation, after, "nothing should change without problems")
+}
+
+func (s *FsckSuite) TestCheckAndFix() {
+	hypervisor, guest := s.NewHypervisorWithGuest()
+	subnetID := guest.SubnetID
+	address := guest.IP.String()
+	ghostID := uuid.New()
+
+	// A guest on a deleted hypervisor, still holding an address
+	stranded := s.NewGuest()
+	stranded.HypervisorID = uuid.New()
+	stranded.SubnetID = subnetID
+	stranded.IP = []byte{192, 168, 100, 250}
+	s.Require().NoError(stranded.Save())
+	s.Require().NoError(s.KV.Set(s.PrefixKey("subnets/"+subnetID+"/addresses/192.168.100.250"), stranded.ID))
+
+	// The hypervisor lists a deleted guest, forgot the real one, and the
+	// subnet lost the real guest's address but kept a deleted guest's
+	s.Require().NoError(s.KV.Set(s.PrefixKey("hypervisors/"+hypervisor.ID+"/guests/"+ghostID), ghostID))
+	s.Require().NoError(s.KV.Delete(s.PrefixKey("hypervisors/"+hypervisor.ID+"/guests/"+guest.ID), false))
+	s.Require().NoError(s.KV.Delete(s.PrefixKey("subnets/"+subnetID+"/addresses/"+address), false))
+	s.Require().NoError(s.KV.Set(s.PrefixKey("subnets/"+subnetID+"/addresses/192.168.100.251"), ghostID))
+
+	r, err := s.Checker.check()
+	s.Require().NoError(err)
+	s.Equal([][2]string{
+		{checkListedGuestMissing, "hypervisors/" + hypervisor.ID + "/guests/" + ghostID},
+		{checkAddressOrphaned, "subnets/" + subnetID + "/addresses/192.168.100.251"},
+		{checkGuestHypervisorMissing, "guests/" + stranded.ID + "/metadata"},
+		{checkGuestNotListed, "guests/" + guest.ID + "/metadata"},
+		{checkGuestAddressMissing, "guests/" + guest.ID + "/metadata"},
+	}, s.checks(r))
+
+	generation, _ := hypervisor.Generation()
+	s.True(r.fix())
+	for _, p := range r.Problems {
+		s.True(p.Fixed, p.Check)
+		s.Empty(p.Error, p.Check)
+	}
+
+	r, err = s.Checker.check()
+	s.Require().NoError(err)
+	s.Empty(r.Problems, "fixed kv should be clean")
+
+	s.Require().NoError(hypervisor.Refresh())
+	s.Equal([]string{guest.ID}, hypervisor.Guests())
+	after, _ := hypervisor.Generation()
+	s.True(after > generation, "fixing the guest list should bump generation")
+
+	subnet, err := s.Context.Subnet(subnetID)
+	s.Require().NoError(err)
+	s.Equal(map[string]string{address: guest.ID}, subnet.Addresses())
+
+	unassigned, err := s.Context.Guest(stranded.ID)
+	s.Require().NoError(err)
+	s.Empty(unassigned.HypervisorID)
+	s.Empty(unassigned.SubnetID)
+	s.Nil(unassigned.IP)
+}
+
+func (s *FsckSuite) TestFixAddressConflict() {
+	_, guest := s.NewHypervisorWithGuest()
+	_, other := s.NewHypervisorWithGuest()
+
+	// Two guests claim the same address, so it can not be reserved for
+	// the one that does not hold it
+	other.IP = guest.IP
+	other.SubnetID = guest.SubnetID
+	s.Require().NoError(other.Save())
+
+	r, err := s.Checker.check()
+	s.Require().NoError(err)
+	s.Require().NotEmpty(r.Problems)
+	s.False(r.fix())
+
+	var conflict *problem
+	for _, p := range r.Problems {
+		if p.Check == checkGuestAddressMissing {
+			conflict = p
+		}
+	}
+	s.Require().NotNil(conflict)
+	s.Equal("guests/"+other.ID+"/metadata", conflict.Key)
+	s.Contains(conflict.Message, guest.ID)
+	s.False(conflict.Fixed)
+	s.NotEmpty(conflict.Error)
+}
+
+func (s *FsckSuite) TestMetadataIndex() {
+	guest := s.NewGuest()
+	guest.Metadata = map[string]string{"env": "prod"}
+	s.Require().NoError(guest.Save())
+	hypervisor := s.NewHypervisor()
+	hypervisor.Metadata = map[string]string{"rack": "a1"}
+	s.Require().NoError(hypervisor.Save())
+
+	// The guest's pair lost its entry, and a deleted guest left one behind
+	ghostID := uuid.New()
+	indexed := lochness.MetadataIndexKey(lochness.MetadataKindGuests, "env", "prod", guest.ID)
+	stale := lochness.MetadataIndexKey(lochness.MetadataKindGuests, "env", "prod", ghostID)
+	s.Require().NoError(s.KV.Delete(indexed, false))
+	s.Require().NoError(s.KV.Set(stale, ghostID))
+
+	r, err := s.Checker.check()
+	s.Require().NoError(err)
+	s.Equal([][2]string{
+		{checkMetadataIndexStale, relKey(stale)},
+		{checkMetadataIndexMissing, relKey(indexed)},
+	}, s.checks(r))
+	s.Equal(2, r.Checked["metadata"])
+
+	s.True(r.fix())
+	r, err = s.Checker.check()
+	s.Require().NoError(err)
+	s.Empty(r.Problems, "fixed kv should be clean")
+
+	guests, err := s.Context.GuestsByMetadata("env", "prod")
+	s.NoError(err)
+	s.Require().Len(guests, 1)
+	s.Equal(guest.ID, guests[0].ID)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/pkg/kv"
+	_ "github.com/mistifyio/lochness/pkg/kv/bolt"
+	_ "github.com/mistifyio/lochness/pkg/kv/consul"
+	logx "github.com/mistifyio/mistify-logrus-ext"
+	"github.com/spf13/cobra"
+)
+
+// Exit statuses, as with fsck(8)
+const (
+	exitClean     = 0
+	exitFixed     = 1
+	exitUnfixed   = 4
+	exitCheckFail = 8
+)
+
+var (
+	kvAddr   = "http://localhost:4001"
+	kvPrefix = kv.DefaultPrefix
+	fix      = false
+)
+
+func run(cmd *cobra.Command, args []string) {
+	k, err := kv.New(kvAddr)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"addr":  kvAddr,
+			"error": err,
+			"func":  "kv.New",
+		}).Fatal("unable to connect to kv")
+	}
+	k = kv.WithPrefix(k, kvPrefix)
+	c := &checker{kv: k, ctx: lochness.NewContext(k)}
+
+	r, err := c.check()
+	if err != nil {
+		log.WithField("error", err).Error("failed to check kv")
+		os.Exit(exitCheckFail)
+	}
+
+	status := exitClean
+	if len(r.Problems) > 0 {
+		status = exitUnfixed
+		if fix && r.fix() {
+			status = exitFixed
+		}
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "\t")
+	if err := encoder.Encode(r); err != nil {
+		log.WithField("error", err).Error("failed to write report")
+		os.Exit(exitCheckFail)
+	}
+	os.Exit(status)
+}
+
+func main() {
+	if err := logx.DefaultSetup("error"); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "logx.DefaultSetup",
+			"level": "error",
+		}).Fatal("unable to set up logrus")
+	}
+
+	root := &cobra.Command{
+		Use:  "lochness-fsck",
+		Long: "lochness-fsck checks the references between guests, hypervisors, and subnets in the kv, and repairs them with --fix.",
+		Run:  run,
+	}
+	root.Flags().StringVarP(&kvAddr, "kv", "k", kvAddr, "address of kv machine")
+	root.Flags().StringVar(&kvPrefix, "kv-prefix", kvPrefix, "root of the lochness keys in the kv, to share a kv cluster between lochness clusters")
+	root.Flags().BoolVar(&fix, "fix", fix, "repair the problems found")
+
+	if err := root.Execute(); err != nil {
+		log.WithField("error", err).Fatal("failed to execute root command")
+	}
+}
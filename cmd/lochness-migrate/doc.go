@@ -0,0 +1,67 @@
+/*
+lochness-migrate upgrades the records in the kv to the schema of this version of
+lochness, by applying the migrations registered with lochness.RegisterMigration
+that have not been applied yet.
+
+Usage
+
+The following arguments are understood
+
+	$ lochness-migrate -h
+	lochness-migrate upgrades the records in the kv to the schema of this version of lochness.
+
+	Usage:
+	  lochness-migrate [flags]
+	  lochness-migrate [command]
+
+	Available Commands:
+	  help        Help about any command
+	  status      Show the schema version and the migrations applied and pending
+	  up          Apply the pending migrations
+
+	Flags:
+	  -h, --help               help for lochness-migrate
+	  -k, --kv string          address of kv machine (default "http://localhost:4001")
+	      --kv-prefix string   root of the lochness keys in the kv, to share a kv cluster between lochness clusters (default "/lochness")
+
+	Use "lochness-migrate [command] --help" for more information about a command.
+
+	$ lochness-migrate up -h
+	Apply the pending migrations in order, holding a lock so that only one
+	migration runs at a time. The schema version is stored after each migration, so
+	an interrupted run continues where it stopped when run again.
+
+	Usage:
+	  lochness-migrate up [flags]
+
+	Flags:
+	  -h, --help                    help for up
+	  -t, --lock-timeout duration   how long to wait for a migration already running, 0 to wait forever (default 1m0s)
+
+Schema Versions
+
+The schema version the records have been migrated to is stored in the
+lochness/schema-version key, and is 0 for records that have never been
+migrated. Each migration upgrades the records from the version before it.
+Records migrated by a newer lochness, whose schema version is past the latest
+migration known, are reported by status and left alone by up.
+
+Examples
+
+Check for pending migrations
+
+	$ lochness-migrate status
+	schema version 1, latest 2
+	   1  applied  move guest bridges into their metadata
+	   2  pending  split hypervisor resources
+
+Apply them
+
+	$ lochness-migrate up
+	migration 2: 1/120 records (0%)
+	migration 2: 13/120 records (10%)
+	...
+	migration 2: 120/120 records (100%)
+	   2  applied  split hypervisor resources
+*/
+package main
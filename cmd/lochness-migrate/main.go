@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/pkg/kv"
+	_ "github.com/mistifyio/lochness/pkg/kv/bolt"
+	_ "github.com/mistifyio/lochness/pkg/kv/consul"
+	logx "github.com/mistifyio/mistify-logrus-ext"
+	"github.com/spf13/cobra"
+)
+
+var (
+	kvAddr      = "http://localhost:4001"
+	kvPrefix    = kv.DefaultPrefix
+	lockTimeout = time.Minute
+)
+
+func help(cmd *cobra.Command, _ []string) {
+	if err := cmd.Help(); err != nil {
+		log.WithField("error", err).Fatal("help")
+	}
+}
+
+// connect creates a context for the kv, under the prefix
+func connect() *lochness.Context {
+	k, err := kv.New(kvAddr)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"addr":  kvAddr,
+			"error": err,
+			"func":  "kv.New",
+		}).Fatal("unable to connect to kv")
+	}
+	return lochness.NewContext(kv.WithPrefix(k, kvPrefix))
+}
+
+func status(cmd *cobra.Command, args []string) {
+	ctx := connect()
+
+	version, err := ctx.SchemaVersion()
+	if err != nil {
+		log.WithField("error", err).Fatal("failed to get schema version")
+	}
+	fmt.Printf("schema version %d, latest %d\n", version, lochness.LatestSchemaVersion())
+
+	for _, m := range lochness.Migrations() {
+		state := "applied"
+		if m.Version > version {
+			state = "pending"
+		}
+		fmt.Printf("%4d  %-8s %s\n", m.Version, state, m.Description)
+	}
+	if version > lochness.LatestSchemaVersion() {
+		log.WithField("version", version).Fatal(lochness.ErrSchemaTooNew.Error())
+	}
+}
+
+func up(cmd *cobra.Command, args []string) {
+	ctx := connect()
+
+	// Report every 10% of a migration, so that large ones show they are
+	// moving without flooding the output
+	last := map[int]int{}
+	progress := func(m lochness.Migration, done, total int) {
+		percent := done * 100 / total
+		if done != total && percent/10 == last[m.Version]/10 {
+			return
+		}
+		last[m.Version] = percent
+		fmt.Fprintf(os.Stderr, "migration %d: %d/%d records (%d%%)\n", m.Version, done, total, percent)
+	}
+
+	applied, err := ctx.Migrate(lockTimeout, progress)
+	for _, m := range applied {
+		fmt.Printf("%4d  applied  %s\n", m.Version, m.Description)
+	}
+	if err != nil {
+		log.WithField("error", err).Fatal("migration failed")
+	}
+	if len(applied) == 0 {
+		fmt.Println("schema is up to date")
+	}
+}
+
+func main() {
+	if err := logx.DefaultSetup("error"); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "logx.DefaultSetup",
+			"level": "error",
+		}).Fatal("unable to set up logrus")
+	}
+
+	root := &cobra.Command{
+		Use:  "lochness-migrate",
+		Long: "lochness-migrate upgrades the records in the kv to the schema of this version of lochness.",
+		Run:  help,
+	}
+	root.PersistentFlags().StringVarP(&kvAddr, "kv", "k", kvAddr, "address of kv machine")
+	root.PersistentFlags().StringVar(&kvPrefix, "kv-prefix", kvPrefix, "root of the lochness keys in the kv, to share a kv cluster between lochness clusters")
+
+	cmdStatus := &cobra.Command{
+		Use:   "status",
+		Short: "Show the schema version and the migrations applied and pending",
+		Run:   status,
+	}
+	root.AddCommand(cmdStatus)
+
+	cmdUp := &cobra.Command{
+		Use:   "up",
+		Short: "Apply the pending migrations",
+		Long: `Apply the pending migrations in order, holding a lock so that only one
+migration runs at a time. The schema version is stored after each migration, so
+an interrupted run continues where it stopped when run again.`,
+		Run: up,
+	}
+	cmdUp.Flags().DurationVarP(&lockTimeout, "lock-timeout", "t", lockTimeout, "how long to wait for a migration already running, 0 to wait forever")
+	root.AddCommand(cmdUp)
+
+	if err := root.Execute(); err != nil {
+		log.WithField("error", err).Fatal("failed to execute root command")
+	}
+}
@@ -0,0 +1,83 @@
+/*
+lochness-snapshot backs up the lochness keys of a kv to a snapshot, and restores
+them into an empty kv, for disaster recovery and cloning environments.
+
+Usage
+
+The following arguments are understood
+
+	$ lochness-snapshot -h
+	lochness-snapshot backs up the lochness keys of a kv to a snapshot, and restores them, for disaster recovery and cloning environments.
+
+	Usage:
+	  lochness-snapshot [flags]
+	  lochness-snapshot [command]
+
+	Available Commands:
+	  export      Export the lochness keys to a snapshot
+	  help        Help about any command
+	  restore     Restore the lochness keys from a snapshot
+
+	Flags:
+	  -x, --exclude strings    keys, relative to the root and possibly with wildcards, to leave out along with the keys under them (default [console-tokens,leases,cworkerd/guests,csched/leader,cfailoverd/leader,...])
+	  -h, --help               help for lochness-snapshot
+	  -k, --kv string          address of kv machine (default "http://localhost:4001")
+	      --kv-prefix string   root of the lochness keys in the kv, to share a kv cluster between lochness clusters (default "/lochness")
+
+	Use "lochness-snapshot [command] --help" for more information about a command.
+
+	$ lochness-snapshot restore -h
+	Restore the lochness keys from a snapshot file, or stdin if it is missing or "-".
+	The kv must be empty unless --force is given, in which case keys missing from the
+	snapshot are deleted so that the kv matches it.
+
+	Usage:
+	  lochness-snapshot restore [<file>] [flags]
+
+	Flags:
+	  -n, --dry-run   print the keys a restore would create (+), overwrite (~), and delete (-), without changing anything
+	  -f, --force     restore into a kv that is not empty
+	  -h, --help      help for restore
+
+Snapshots
+
+A snapshot is a json object with the version of its format, when it was taken,
+and the values of the keys under the lochness root, relative to it. Keys are
+restored under the root given by --kv-prefix, which need not be the one they
+were exported from. Snapshots written by a newer version of lochness-snapshot
+are refused. File names ending with .gz are gzipped.
+
+	{
+		"version": 1,
+		"created": "2016-01-12T18:06:12.453187Z",
+		"keys": {
+			"flavors/33b6afce-c00f-4ad6-9db6-4822a710eb34/metadata": "{\"id\":\"33b6afce-c00f-4ad6-9db6-4822a710eb34\",\"image\":\"9f02f5b0-069b-4c80-99c2-b0f94958c139\",\"metadata\":{},\"memory\":128,\"disk\":1024,\"cpu\":1}",
+			...
+		}
+	}
+
+Keys that only make sense while the cluster runs, such as hypervisor heartbeats,
+leases, locks, and console tokens, are excluded by default. Patterns are matched
+with path.Match, so "*" matches one level of keys. Excluded keys are
+neither exported, nor restored, nor deleted.
+
+Examples
+
+Back up a cluster
+
+	$ lochness-snapshot -k http://etcd:4001 export lochness-20160112.json.gz
+	1284 keys exported to lochness-20160112.json.gz
+
+Check what restoring it would change
+
+	$ lochness-snapshot -k http://etcd:4001 restore --dry-run lochness-20160112.json.gz
+	+ flavors/33b6afce-c00f-4ad6-9db6-4822a710eb34/metadata
+	~ hypervisors/1b593426-cab5-461f-a2bd-f603fa106cd3/metadata
+	- guests/4d003c76-71d3-44ad-8518-3337273925ff/metadata
+
+Clone it into a staging cluster sharing the kv
+
+	$ lochness-snapshot -k http://etcd:4001 --kv-prefix /staging restore lochness-20160112.json.gz
+	1284 keys changed
+*/
+package main
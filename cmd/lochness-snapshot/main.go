@@ -0,0 +1,204 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness/pkg/kv"
+	_ "github.com/mistifyio/lochness/pkg/kv/bolt"
+	_ "github.com/mistifyio/lochness/pkg/kv/consul"
+	logx "github.com/mistifyio/mistify-logrus-ext"
+	"github.com/spf13/cobra"
+)
+
+var (
+	kvAddr   = "http://localhost:4001"
+	kvPrefix = kv.DefaultPrefix
+	exclude  = defaultExcludes
+	dryRun   = false
+	force    = false
+)
+
+func help(cmd *cobra.Command, _ []string) {
+	if err := cmd.Help(); err != nil {
+		log.WithField("error", err).Fatal("help")
+	}
+}
+
+// connect connects to the kv, under the prefix
+func connect() kv.KV {
+	k, err := kv.New(kvAddr)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"addr":  kvAddr,
+			"error": err,
+			"func":  "kv.New",
+		}).Fatal("unable to connect to kv")
+	}
+	return kv.WithPrefix(k, kvPrefix)
+}
+
+// isGzip returns whether a file is gzipped, judging by its name
+func isGzip(file string) bool {
+	return strings.HasSuffix(file, ".gz")
+}
+
+func exportSnapshot(cmd *cobra.Command, args []string) {
+	file := "-"
+	if len(args) > 0 {
+		file = args[0]
+	}
+
+	a, err := export(connect(), exclude)
+	if err != nil {
+		log.WithField("error", err).Fatal("failed to read the kv")
+	}
+
+	var w io.Writer = os.Stdout
+	var closers []io.Closer
+	if file != "-" {
+		f, err := os.Create(file)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"file":  file,
+			}).Fatal("failed to create snapshot")
+		}
+		w = f
+		closers = append(closers, f)
+		if isGzip(file) {
+			gz := gzip.NewWriter(f)
+			w = gz
+			closers = append([]io.Closer{gz}, closers...)
+		}
+	}
+
+	// Closing flushes what gzip buffered and reports write errors of the
+	// file, so it is part of writing the snapshot
+	err = a.write(w)
+	for _, c := range closers {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"file":  file,
+		}).Fatal("failed to write snapshot")
+	}
+	if file != "-" {
+		fmt.Fprintf(os.Stderr, "%d keys exported to %s\n", len(a.Keys), file)
+	}
+}
+
+func restoreSnapshot(cmd *cobra.Command, args []string) {
+	file := "-"
+	if len(args) > 0 {
+		file = args[0]
+	}
+
+	var r io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"file":  file,
+			}).Fatal("failed to open snapshot")
+		}
+		defer logx.LogReturnedErr(f.Close, log.Fields{"file": file}, "failed to close snapshot")
+		r = f
+		if isGzip(file) {
+			gz, err := gzip.NewReader(f)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"error": err,
+					"file":  file,
+				}).Fatal("failed to open snapshot")
+			}
+			r = gz
+		}
+	}
+
+	a, err := readArchive(r)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"file":  file,
+		}).Fatal("invalid snapshot")
+	}
+
+	k := connect()
+	changes, err := diff(k, a, exclude)
+	if err != nil {
+		log.WithField("error", err).Fatal("failed to read the kv")
+	}
+
+	if dryRun {
+		for _, c := range changes {
+			fmt.Println(c.Op, c.Key)
+		}
+		return
+	}
+
+	keys, err := current(k, exclude)
+	if err != nil {
+		log.WithField("error", err).Fatal("failed to read the kv")
+	}
+	if len(keys) > 0 && !force {
+		log.WithField("keys", len(keys)).Fatal("kv is not empty, see --dry-run for what a restore would change and --force to make it")
+	}
+
+	if err := apply(k, a, changes); err != nil {
+		log.WithField("error", err).Fatal("failed to restore snapshot")
+	}
+	fmt.Fprintf(os.Stderr, "%d keys changed\n", len(changes))
+}
+
+func main() {
+	if err := logx.DefaultSetup("error"); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "logx.DefaultSetup",
+			"level": "error",
+		}).Fatal("unable to set up logrus")
+	}
+
+	root := &cobra.Command{
+		Use:  "lochness-snapshot",
+		Long: "lochness-snapshot backs up the lochness keys of a kv to a snapshot, and restores them, for disaster recovery and cloning environments.",
+		Run:  help,
+	}
+	root.PersistentFlags().StringVarP(&kvAddr, "kv", "k", kvAddr, "address of kv machine")
+	root.PersistentFlags().StringVar(&kvPrefix, "kv-prefix", kvPrefix, "root of the lochness keys in the kv, to share a kv cluster between lochness clusters")
+	root.PersistentFlags().StringSliceVarP(&exclude, "exclude", "x", exclude, "keys, relative to the root and possibly with wildcards, to leave out along with the keys under them")
+
+	cmdExport := &cobra.Command{
+		Use:   "export [<file>]",
+		Short: "Export the lochness keys to a snapshot",
+		Long:  `Export the lochness keys to a snapshot file, or stdout if it is missing or "-". Files ending with .gz are gzipped.`,
+		Run:   exportSnapshot,
+	}
+	root.AddCommand(cmdExport)
+
+	cmdRestore := &cobra.Command{
+		Use:   "restore [<file>]",
+		Short: "Restore the lochness keys from a snapshot",
+		Long: `Restore the lochness keys from a snapshot file, or stdin if it is missing or "-".
+The kv must be empty unless --force is given, in which case keys missing from the
+snapshot are deleted so that the kv matches it.`,
+		Run: restoreSnapshot,
+	}
+	cmdRestore.Flags().BoolVarP(&dryRun, "dry-run", "n", dryRun, `print the keys a restore would create (+), overwrite (~), and delete (-), without changing anything`)
+	cmdRestore.Flags().BoolVarP(&force, "force", "f", force, "restore into a kv that is not empty")
+	root.AddCommand(cmdRestore)
+
+	if err := root.Execute(); err != nil {
+		log.WithField("error", err).Fatal("failed to execute root command")
+	}
+}
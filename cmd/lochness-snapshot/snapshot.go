@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mistifyio/lochness/pkg/kv"
+)
+
+// archiveVersion is the version of the archive format written. Archives of
+// newer versions are refused, since they may hold data this version does not
+// know how to restore.
+const archiveVersion = 1
+
+// defaultExcludes are the keys that only make sense while the cluster is
+// running, e.g. heartbeats, locks, and one-time tokens
+var defaultExcludes = []string{
+	"console-tokens",
+	"leases",
+	"cworkerd/guests",
+	"csched/leader",
+	"cfailoverd/leader",
+	"cupgraded/leader",
+	"hypervisors/*/heartbeat",
+	"migrations",
+}
+
+type (
+	// archive is a snapshot of the lochness keys of a kv. Keys are relative
+	// to the lochness root, so that they can be restored under another
+	// --kv-prefix.
+	archive struct {
+		Version int               `json:"version"`
+		Created time.Time         `json:"created"`
+		Keys    map[string]string `json:"keys"`
+	}
+
+	// change is a difference between an archive and a kv
+	change struct {
+		Op  string // "+" to create, "~" to overwrite, "-" to delete
+		Key string
+	}
+)
+
+// root returns the lochness root in the kv, without slashes
+func root() string {
+	return strings.Trim(kv.DefaultPrefix, "/")
+}
+
+// excluded returns whether a key relative to the root, or one of its parent
+// directories, matches one of the patterns
+func excluded(key string, patterns []string) bool {
+	for dir := key; dir != "." && dir != "/" && dir != ""; dir = path.Dir(dir) {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, dir); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// current returns the values of the keys under the lochness root, relative to
+// it, except those excluded
+func current(k kv.KV, exclude []string) (map[string]string, error) {
+	values, err := k.GetAll(root() + "/")
+	if err != nil && !k.IsKeyNotFound(err) {
+		return nil, err
+	}
+
+	keys := make(map[string]string, len(values))
+	for key, value := range values {
+		rel := strings.TrimPrefix(strings.TrimPrefix(key, "/"), root()+"/")
+		if rel == "" || excluded(rel, exclude) {
+			continue
+		}
+		keys[rel] = string(value.Data)
+	}
+	return keys, nil
+}
+
+// export creates an archive of the keys under the lochness root
+func export(k kv.KV, exclude []string) (*archive, error) {
+	keys, err := current(k, exclude)
+	if err != nil {
+		return nil, err
+	}
+	return &archive{
+		Version: archiveVersion,
+		Created: time.Now().UTC(),
+		Keys:    keys,
+	}, nil
+}
+
+// write writes the archive as json
+func (a *archive) write(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "\t")
+	return encoder.Encode(a)
+}
+
+// readArchive reads an archive, checking that its version is supported
+func readArchive(r io.Reader) (*archive, error) {
+	a := &archive{}
+	if err := json.NewDecoder(r).Decode(a); err != nil {
+		return nil, err
+	}
+	if a.Version < 1 {
+		return nil, fmt.Errorf("not a lochness snapshot: missing version")
+	}
+	if a.Version > archiveVersion {
+		return nil, fmt.Errorf("snapshot version %d is newer than the supported version %d", a.Version, archiveVersion)
+	}
+	return a, nil
+}
+
+// diff returns the changes restoring the archive would make to the kv, sorted
+// by key. Excluded keys are neither restored nor deleted.
+func diff(k kv.KV, a *archive, exclude []string) ([]change, error) {
+	keys, err := current(k, exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []change{}
+	for key, value := range a.Keys {
+		if excluded(key, exclude) {
+			continue
+		}
+		existing, ok := keys[key]
+		switch {
+		case !ok:
+			changes = append(changes, change{Op: "+", Key: key})
+		case existing != value:
+			changes = append(changes, change{Op: "~", Key: key})
+		}
+	}
+	for key := range keys {
+		if _, ok := a.Keys[key]; !ok {
+			changes = append(changes, change{Op: "-", Key: key})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+	return changes, nil
+}
+
+// apply makes the changes to the kv, taking values from the archive
+func apply(k kv.KV, a *archive, changes []change) error {
+	for _, c := range changes {
+		key := path.Join(root(), c.Key)
+		var err error
+		if c.Op == "-" {
+			err = k.Delete(key, false)
+		} else {
+			err = k.Set(key, a.Keys[c.Key])
+		}
+		if err != nil {
+			return fmt.Errorf("%s %s: %s", c.Op, c.Key, err)
+		}
+	}
+	return nil
+}
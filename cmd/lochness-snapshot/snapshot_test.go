@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/mistifyio/lochness/pkg/kv"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestSnapshot(t *testing.T) {
+	suite.Run(t, new(SnapshotSuite))
+}
+
+type SnapshotSuite struct {
+	common.Suite
+	Target kv.KV
+}
+
+func (s *SnapshotSuite) SetupSuite() {
+	log.SetLevel(log.FatalLevel)
+	s.Suite.SetupSuite()
+}
+
+func (s *SnapshotSuite) SetupTest() {
+	s.Suite.SetupTest()
+	var err error
+	s.Target, err = kv.New(s.KVURL + "-target")
+	s.Require().NoError(err)
+	s.Require().NoError(s.Target.Delete(s.KVPrefix, true))
+}
+
+func (s *SnapshotSuite) TestRoundTrip() {
+	hypervisor, guest := s.NewHypervisorWithGuest()
+	s.Require().NoError(s.KV.Set(s.PrefixKey("hypervisors/"+hypervisor.ID+"/heartbeat"), "alive"))
+	s.Require().NoError(s.KV.Set(s.PrefixKey("console-tokens/abc"), "{}"))
+	s.Require().NoError(s.KV.Set("other/key", "not lochness"))
+
+	a, err := export(s.KV, defaultExcludes)
+	s.Require().NoError(err)
+	s.Equal(archiveVersion, a.Version)
+	s.Contains(a.Keys, "guests/"+guest.ID+"/metadata")
+	s.Contains(a.Keys, "hypervisors/"+hypervisor.ID+"/metadata")
+	s.NotContains(a.Keys, "hypervisors/"+hypervisor.ID+"/heartbeat", "heartbeats should be excluded")
+	s.NotContains(a.Keys, "console-tokens/abc", "console tokens should be excluded")
+	for key := range a.Keys {
+		s.False(strings.HasPrefix(key, "other"), "keys outside of the root should be left out")
+	}
+
+	buf := &bytes.Buffer{}
+	s.Require().NoError(a.write(buf))
+	restored, err := readArchive(buf)
+	s.Require().NoError(err)
+	s.Equal(a.Keys, restored.Keys)
+
+	changes, err := diff(s.Target, restored, defaultExcludes)
+	s.Require().NoError(err)
+	s.Len(changes, len(a.Keys))
+	s.Require().NoError(apply(s.Target, restored, changes))
+
+	// The restored kv holds the same entities under another prefix
+	target := kv.WithPrefix(s.Target, "/cloned")
+	s.Require().NoError(apply(target, restored, changes))
+	copied, err := export(target, defaultExcludes)
+	s.Require().NoError(err)
+	s.Equal(a.Keys, copied.Keys)
+
+	changes, err = diff(s.Target, restored, defaultExcludes)
+	s.Require().NoError(err)
+	s.Empty(changes, "a restored kv should match the snapshot")
+}
+
+func (s *SnapshotSuite) TestDiff() {
+	a := &archive{
+		Version: archiveVersion,
+		Keys: map[string]string{
+			"flavors/a/metadata": "new",
+			"flavors/b/metadata": "changed",
+			"flavors/c/metadata": "same",
+		},
+	}
+	s.Require().NoError(s.Target.Set("lochness/flavors/b/metadata", "original"))
+	s.Require().NoError(s.Target.Set("lochness/flavors/c/metadata", "same"))
+	s.Require().NoError(s.Target.Set("lochness/flavors/d/metadata", "extra"))
+	s.Require().NoError(s.Target.Set("lochness/leases/x", "excluded"))
+
+	changes, err := diff(s.Target, a, defaultExcludes)
+	s.Require().NoError(err)
+	s.Equal([]change{
+		{"+", "flavors/a/metadata"},
+		{"~", "flavors/b/metadata"},
+		{"-", "flavors/d/metadata"},
+	}, changes)
+
+	s.Require().NoError(apply(s.Target, a, changes))
+	value, err := s.Target.Get("lochness/flavors/b/metadata")
+	s.Require().NoError(err)
+	s.Equal("changed", string(value.Data))
+	_, err = s.Target.Get("lochness/flavors/d/metadata")
+	s.True(s.Target.IsKeyNotFound(err))
+	_, err = s.Target.Get("lochness/leases/x")
+	s.NoError(err, "excluded keys should be left alone")
+}
+
+func (s *SnapshotSuite) TestReadArchive() {
+	tests := []struct {
+		description string
+		input       string
+		expectedErr bool
+	}{
+		{"current version", `{"version":1,"keys":{}}`, false},
+		{"missing version", `{"keys":{}}`, true},
+		{"newer version", `{"version":2,"keys":{}}`, true},
+		{"invalid json", `{`, true},
+	}
+
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		_, err := readArchive(strings.NewReader(test.input))
+		if test.expectedErr {
+			s.Error(err, msg("should fail"))
+		} else {
+			s.NoError(err, msg("should succeed"))
+		}
+	}
+}
+
+func (s *SnapshotSuite) TestExcluded() {
+	tests := []struct {
+		key      string
+		expected bool
+	}{
+		{"leases/abc", true},
+		{"leases", true},
+		{"hypervisors/abc/heartbeat", true},
+		{"hypervisors/abc/metadata", false},
+		{"guests/abc/metadata", false},
+		{"csched/leader", true},
+		{"csched/other", false},
+	}
+
+	for _, test := range tests {
+		s.Equal(test.expected, excluded(test.key, defaultExcludes), test.key)
+	}
+}
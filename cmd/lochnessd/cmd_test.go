@@ -0,0 +1,89 @@
+package main_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestLochnessd(t *testing.T) {
+	suite.Run(t, new(CmdSuite))
+}
+
+type CmdSuite struct {
+	common.Suite
+	BinName string
+	ConfDir string
+}
+
+func (s *CmdSuite) SetupSuite() {
+	s.ExternalKV = true
+	s.Suite.SetupSuite()
+	s.Require().NoError(common.Build())
+	s.BinName = "lochnessd"
+}
+
+func (s *CmdSuite) SetupTest() {
+	s.Suite.SetupTest()
+	s.ConfDir, _ = ioutil.TempDir("", "lochnessd-test")
+}
+
+func (s *CmdSuite) TearDownTest() {
+	s.Suite.TearDownTest()
+	_ = os.RemoveAll(s.ConfDir)
+}
+
+func (s *CmdSuite) TestNoModules() {
+	cmd, err := common.ExecSync("./"+s.BinName, "-k", s.KVURL, "-p", "0")
+	s.Error(err)
+	status, _ := cmd.ExitStatus()
+	s.Equal(1, status)
+}
+
+func (s *CmdSuite) TestModules() {
+	hypervisor, guest := s.NewHypervisorWithGuest()
+
+	apiPort := 17500
+	args := []string{
+		"--hypervisor-api",
+		"--hypervisor-api-port", fmt.Sprintf("%d", apiPort),
+		"--dhcp",
+		"--domain", "lochnessdTest",
+		"--conf-dir", s.ConfDir,
+		"-k", s.KVURL,
+		"-p", "0",
+		"-l", "fatal",
+	}
+	cmd, err := common.Start("./"+s.BinName, args...)
+	s.Require().NoError(err)
+	time.Sleep(1 * time.Second)
+
+	// dhcp refresher
+	hData, err := ioutil.ReadFile(filepath.Join(s.ConfDir, "hypervisors.conf"))
+	s.NoError(err)
+	s.Contains(string(hData), hypervisor.ID)
+	gData, err := ioutil.ReadFile(filepath.Join(s.ConfDir, "guests.conf"))
+	s.NoError(err)
+	s.Contains(string(gData), guest.ID)
+
+	// hypervisor api, on the same kv
+	resp, err := http.Get(fmt.Sprintf("http://localhost:%d/hypervisors/%s", apiPort, hypervisor.ID))
+	if s.NoError(err) {
+		s.Equal(http.StatusOK, resp.StatusCode)
+		_ = resp.Body.Close()
+	}
+
+	// Shut down cleanly
+	s.Require().NoError(cmd.Cmd.Process.Signal(syscall.SIGTERM))
+	s.NoError(cmd.Wait())
+	status, _ := cmd.ExitStatus()
+	s.Equal(0, status)
+}
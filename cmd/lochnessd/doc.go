@@ -0,0 +1,100 @@
+/*
+lochnessd runs the hypervisor api, guest api, worker, placer, and dhcp
+refresher in one process, for small clusters that would rather not run each
+daemon on its own, and can serve a web ui of the cluster alongside them. Each module is enabled by its flag and behaves as the
+daemon it replaces: chypervisord, cguestd, cworkerd, cplacerd, and cdhcpd.
+
+Usage
+
+The following arguments are understood:
+
+	$ lochnessd -h
+	Usage of lochnessd:
+	    --agent-dial-timeout=0s: how long connecting to an agent may take, 0 for the default of 30s
+	-a, --agent-port=8080: port on which agents listen
+	    --agent-proxy="": url of the proxy to connect to agents through, instead of those of $HTTP_PROXY, $HTTPS_PROXY, and $NO_PROXY, or "none" to connect directly
+	-b, --beanstalk="127.0.0.1:11300": address of beanstalkd server
+	    --conf-dir="/etc/dhcp/": dhcpd configuration directory
+	    --config-file="": yaml or toml file of flag settings, overridden by the environment and the command line
+	-d, --desired-state=false: complete guest create and delete jobs from hypervisor desired state acks
+	    --dhcp=false: keep the dhcpd configs up to date, as cdhcpd
+	    --dhcp-config="": optional json file overriding domain and template paths
+	    --dhcp-dns-servers="": comma separated dns servers given to the hosts with --serve-dhcp
+	    --dhcp-lease-time=12h0m0s: how long the leases given with --serve-dhcp last
+	    --dhcp-server-ip="": ip the dhcp server identifies itself with; required with --serve-dhcp unless it is on a specific ip
+	    --domain="": domain for lochness; required with --dhcp
+	    --guest-api=false: serve the guest api, as cguestd
+	    --guest-api-port=18000: listen port of the guest api
+	    --guests-template="": path to a template for guests.conf
+	-p, --http=7546: http port to publish metrics. set to 0 to disable
+	    --hypervisor-api=false: serve the hypervisor api, as chypervisord
+	    --hypervisor-api-port=17000: listen port of the hypervisor api
+	    --hypervisors-template="": path to a template for hypervisors.conf
+	    --idempotency-sweep-interval=10m0s: how often the guest api removes expired idempotency keys, 0 to disable
+	-k, --kv="http://127.0.0.1:4001": address of kv server
+	    --kv-prefix="/lochness": root of the lochness keys in the kv, to share a kv cluster between lochness clusters
+	    --kv-retries=2: number of times the apis retry failed kv operations, other than atomic updates
+	    --kv-timeout=5s: timeout of kv operations of the apis, after which requests fail with 503, 0 to disable
+	-t, --lease-ttl=1m0s: how long a job is claimed by a worker between reservations
+	-l, --log-level="warn": log level
+	    --log-max-backups=5: number of rotated log files kept
+	    --log-max-size=100: megabytes a log file grows to before it is rotated, 0 to never rotate it
+	    --log-output="stdout": where to log: stdout, stderr, syslog, journald, or the path of a file
+	    --mac-oui="": OUI of the MACs generated for guests created without one, instead of the one configured for the cluster
+	-m, --max-reclaims=3: number of times an abandoned job is requeued before it is failed
+	    --otlp-endpoint="": OTLP/HTTP collector (host:port) to send request and job traces to
+	    --placer=false: select hypervisors for new guests, as cplacerd
+	    --queues="default": comma separated job queues to work on, in order of priority. later queues are worked on while earlier ones are empty
+	-r, --reap-interval=1m0s: how often to reclaim abandoned jobs and roll back abandoned changes. set to 0 to disable
+	    --serve-dhcp="": address to answer dhcp requests on, e.g. :67, instead of writing dhcpd configs; disabled if empty
+	    --slow-request=1s: latency above which requests are logged as slow, 0 to disable
+	-s, --statsd="": statsd address for the guest api metrics
+	    --tls-cert="": certificate file (PEM) to serve https and http/2 with, along with --tls-key
+	    --tls-key="": private key file (PEM) of --tls-cert
+	    --tls-reload=0: how often to check the certificate and key files for rotation, 0 to disable
+	    --ui=false: serve a read-only web ui of the hypervisors, guests, subnets, and jobs
+	    --ui-guest-api="": url of the guest api the web ui reads, instead of the one served with --guest-api
+	    --ui-hypervisor-api="": url of the hypervisor api the web ui reads, instead of the one served with --hypervisor-api
+	    --ui-network-api="": url of the network api the web ui reads subnet addresses from, e.g. http://127.0.0.1:19000
+	    --ui-port=17080: listen port of the web ui
+	    --worker=false: work on guest action jobs, as cworkerd
+	-w, --workers=1: number of jobs to work on at the same time
+	    --zone-dir="": directory to write dns zone files to; disabled if empty
+	    --zone-reload-cmd="": command run with the zone name after a zone file changes, e.g. "rndc reload"
+
+At least one module must be enabled. Settings may also come from a config file
+or LOCHNESSD_ environment variables, e.g. LOCHNESSD_GUEST_API=true.
+
+Shared Resources
+
+The modules share one kv connection, logger, and metrics endpoint. The metrics
+of each module are published at /metrics on --http, named after the daemon it
+replaces, e.g. cguestd.* for the guest api; the guest api also serves them at
+its own /metrics. The apis share the tls and request logging settings, and one
+watch of the kv for the changes they stream at /events.
+
+Web UI
+
+With --ui, a minimal web ui of the cluster is served at --ui-port: sortable,
+filterable tables of the hypervisors, guests, and subnets, refreshed every 10
+seconds, with the details of each, and jobs looked up by id. It is read-only:
+the page reads the apis through the ui's server, which passes on GETs of the
+hypervisors, guests, jobs, and subnets and refuses anything else.
+
+The ui reads the hypervisor and guest apis served by the same lochnessd, or
+those at --ui-hypervisor-api and --ui-guest-api, e.g. when they run as
+chypervisord and cguestd elsewhere. Subnets are those the hypervisors carry;
+their address usage is shown if --ui-network-api is set to a cnetworkd. The
+ui is served with the tls and request logging settings of the apis.
+
+	$ lochnessd --hypervisor-api --guest-api --ui --ui-network-api http://127.0.0.1:19000
+
+Shutdown
+
+On SIGINT or SIGTERM the apis stop accepting connections and have 5 seconds to
+finish their requests, and the dhcp refresher finishes the change it is
+writing. Workers and the placer stop with the process: their jobs are
+reclaimed or retried as they would be for cworkerd and cplacerd. SIGHUP
+reloads the dhcp settings, as for cdhcpd.
+*/
+package main
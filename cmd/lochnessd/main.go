@@ -0,0 +1,375 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/armon/go-metrics"
+	"github.com/bakins/go-metrics-map"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/changefeed"
+	"github.com/mistifyio/lochness/internal/config"
+	"github.com/mistifyio/lochness/internal/dhcp"
+	"github.com/mistifyio/lochness/internal/guestapi"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	"github.com/mistifyio/lochness/internal/hypervisorapi"
+	"github.com/mistifyio/lochness/internal/logging"
+	"github.com/mistifyio/lochness/internal/placer"
+	"github.com/mistifyio/lochness/internal/server"
+	"github.com/mistifyio/lochness/internal/tlsutil"
+	"github.com/mistifyio/lochness/internal/webui"
+	"github.com/mistifyio/lochness/internal/worker"
+	"github.com/mistifyio/lochness/pkg/agent"
+	"github.com/mistifyio/lochness/pkg/jobqueue"
+	"github.com/mistifyio/lochness/pkg/kv"
+	_ "github.com/mistifyio/lochness/pkg/kv/bolt"
+	_ "github.com/mistifyio/lochness/pkg/kv/consul"
+	logx "github.com/mistifyio/mistify-logrus-ext"
+	flag "github.com/ogier/pflag"
+)
+
+// newMetrics creates the metrics of a module, kept in the shared sink under
+// the module's daemon name
+func newMetrics(name string, sink metrics.MetricSink) *metrics.Metrics {
+	conf := metrics.DefaultConfig(name)
+	conf.EnableHostname = false
+	m, _ := metrics.New(conf, sink)
+	return m
+}
+
+// serveMetrics publishes the shared metric sink over http
+func serveMetrics(port uint, ms *mapsink.MapSink) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(ms)
+	}))
+
+	go func() {
+		log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), mux))
+	}()
+}
+
+// uiAPI returns the api the web ui reads: a proxy to rawurl, or local if
+// rawurl is empty
+func uiAPI(name, rawurl string, local http.Handler) http.Handler {
+	if rawurl == "" {
+		return local
+	}
+	proxy, err := webui.Proxy(rawurl, nil)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "webui.Proxy",
+			"api":   name,
+			"url":   rawurl,
+		}).Fatal("invalid web ui api url")
+	}
+	return proxy
+}
+
+func main() {
+	var enableHypervisorAPI, enableGuestAPI, enableWorker, enablePlacer, enableDHCP, enableUI bool
+	var port, hypervisorAPIPort, guestAPIPort, uiPort uint
+	var uiHypervisorAPI, uiGuestAPI, uiNetworkAPI string
+	var agentPort, kvRetries int
+	var kvAddr, kvPrefix, bstalk, logLevel, statsd, otlpEndpoint, tlsCert, tlsKey, macOUI, queues string
+	var slowRequest, tlsReload, kvTimeout, idempotencySweep time.Duration
+	workerConfig := worker.Config{}
+	dhcpConfig := dhcp.Config{}
+
+	// Modules
+	flag.BoolVar(&enableHypervisorAPI, "hypervisor-api", false, "serve the hypervisor api, as chypervisord")
+	flag.BoolVar(&enableGuestAPI, "guest-api", false, "serve the guest api, as cguestd")
+	flag.BoolVar(&enableWorker, "worker", false, "work on guest action jobs, as cworkerd")
+	flag.BoolVar(&enablePlacer, "placer", false, "select hypervisors for new guests, as cplacerd")
+	flag.BoolVar(&enableDHCP, "dhcp", false, "keep the dhcpd configs up to date, as cdhcpd")
+	flag.BoolVar(&enableUI, "ui", false, "serve a read-only web ui of the hypervisors, guests, subnets, and jobs")
+
+	// Shared settings
+	flag.StringVarP(&kvAddr, "kv", "k", "http://127.0.0.1:4001", "address of kv server")
+	flag.StringVar(&kvPrefix, "kv-prefix", kv.DefaultPrefix, "root of the lochness keys in the kv, to share a kv cluster between lochness clusters")
+	flag.DurationVar(&kvTimeout, "kv-timeout", 5*time.Second, "timeout of kv operations of the apis, after which requests fail with 503, 0 to disable")
+	flag.IntVar(&kvRetries, "kv-retries", 2, "number of times the apis retry failed kv operations, other than atomic updates")
+	flag.StringVarP(&bstalk, "beanstalk", "b", "127.0.0.1:11300", "address of beanstalkd server")
+	var logCfg logging.Config
+	flag.StringVarP(&logLevel, "log-level", "l", "warn", "log level")
+	logging.AddFlags(flag.CommandLine, &logCfg)
+	flag.UintVarP(&port, "http", "p", 7546, "http port to publish metrics. set to 0 to disable")
+	flag.StringVarP(&statsd, "statsd", "s", "", "statsd address for the guest api metrics")
+	flag.IntVarP(&agentPort, "agent-port", "a", lochness.AgentPort, "port on which agents listen")
+	flag.StringVar(&workerConfig.AgentProxy, "agent-proxy", "", `url of the proxy to connect to agents through, instead of those of $HTTP_PROXY, $HTTPS_PROXY, and $NO_PROXY, or "none" to connect directly`)
+	flag.DurationVar(&workerConfig.AgentDialTimeout, "agent-dial-timeout", 0, "how long connecting to an agent may take, 0 for the default of 30s")
+
+	// API settings
+	flag.UintVarP(&hypervisorAPIPort, "hypervisor-api-port", "", 17000, "listen port of the hypervisor api")
+	flag.UintVarP(&guestAPIPort, "guest-api-port", "", 18000, "listen port of the guest api")
+	flag.DurationVar(&idempotencySweep, "idempotency-sweep-interval", 10*time.Minute, "how often the guest api removes expired idempotency keys, 0 to disable")
+	flag.UintVarP(&uiPort, "ui-port", "", 17080, "listen port of the web ui")
+	flag.StringVar(&uiHypervisorAPI, "ui-hypervisor-api", "", "url of the hypervisor api the web ui reads, instead of the one served with --hypervisor-api")
+	flag.StringVar(&uiGuestAPI, "ui-guest-api", "", "url of the guest api the web ui reads, instead of the one served with --guest-api")
+	flag.StringVar(&uiNetworkAPI, "ui-network-api", "", "url of the network api the web ui reads subnet addresses from, e.g. http://127.0.0.1:19000")
+	flag.StringVar(&macOUI, "mac-oui", "", "OUI of the MACs generated for guests created without one, instead of the one configured for the cluster")
+	flag.DurationVar(&slowRequest, "slow-request", time.Second, "latency above which requests are logged as slow, 0 to disable")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "", "OTLP/HTTP collector (host:port) to send request and job traces to")
+	flag.StringVar(&tlsCert, "tls-cert", "", "certificate file (PEM) to serve https and http/2 with, along with --tls-key")
+	flag.StringVar(&tlsKey, "tls-key", "", "private key file (PEM) of --tls-cert")
+	flag.DurationVar(&tlsReload, "tls-reload", 0, "how often to check the certificate and key files for rotation, 0 to disable")
+
+	// Worker settings
+	flag.BoolVarP(&workerConfig.DesiredState, "desired-state", "d", false, "complete guest create and delete jobs from hypervisor desired state acks")
+	flag.UintVarP(&workerConfig.Workers, "workers", "w", 1, "number of jobs to work on at the same time")
+	flag.DurationVarP(&workerConfig.LeaseTTL, "lease-ttl", "t", time.Minute, "how long a job is claimed by a worker between reservations")
+	flag.DurationVarP(&workerConfig.ReapInterval, "reap-interval", "r", time.Minute, "how often to reclaim abandoned jobs and roll back abandoned changes. set to 0 to disable")
+	flag.IntVarP(&workerConfig.MaxReclaims, "max-reclaims", "m", 3, "number of times an abandoned job is requeued before it is failed")
+	flag.StringVar(&queues, "queues", jobqueue.DefaultQueue, "comma separated job queues to work on, in order of priority. later queues are worked on while earlier ones are empty")
+
+	// DHCP settings
+	flag.StringVar(&dhcpConfig.Settings.Domain, "domain", "", "domain for lochness; required with --dhcp")
+	flag.StringVar(&dhcpConfig.ConfDir, "conf-dir", "/etc/dhcp/", "dhcpd configuration directory")
+	flag.StringVar(&dhcpConfig.SettingsFile, "dhcp-config", "", "optional json file overriding domain and template paths")
+	flag.StringVar(&dhcpConfig.Settings.HypervisorsTemplate, "hypervisors-template", "", "path to a template for hypervisors.conf")
+	flag.StringVar(&dhcpConfig.Settings.GuestsTemplate, "guests-template", "", "path to a template for guests.conf")
+	flag.StringVar(&dhcpConfig.ZoneDir, "zone-dir", "", "directory to write dns zone files to; disabled if empty")
+	flag.StringVar(&dhcpConfig.ZoneReloadCmd, "zone-reload-cmd", "", "command run with the zone name after a zone file changes, e.g. \"rndc reload\"")
+	flag.StringVar(&dhcpConfig.Listen, "serve-dhcp", "", "address to answer dhcp requests on, e.g. :67, instead of writing dhcpd configs; disabled if empty")
+	flag.StringVar(&dhcpConfig.ServerIP, "dhcp-server-ip", "", "ip the dhcp server identifies itself with; required with --serve-dhcp unless it is on a specific ip")
+	flag.DurationVar(&dhcpConfig.LeaseTime, "dhcp-lease-time", dhcp.DefaultLeaseTime, "how long the leases given with --serve-dhcp last")
+	flag.StringVar(&dhcpConfig.DNSServers, "dhcp-dns-servers", "", "comma separated dns servers given to the hosts with --serve-dhcp")
+
+	configFile := flag.String(config.FlagName, "", config.FlagUsage)
+	flag.Parse()
+
+	if err := config.Load(config.Pflag(flag.CommandLine), "lochnessd", *configFile); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "config.Load",
+			"file":  *configFile,
+		}).Fatal("failed to load config")
+	}
+
+	if err := logging.Setup(logLevel, "lochnessd", logCfg); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "logging.Setup",
+			"level": logLevel,
+		}).Fatal("unable to set up logrus")
+	}
+
+	if !(enableHypervisorAPI || enableGuestAPI || enableWorker || enablePlacer || enableDHCP || enableUI) {
+		log.Fatal("no modules enabled")
+	}
+
+	if macOUI != "" {
+		if _, err := lochness.ParseOUI(macOUI); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"func":  "lochness.ParseOUI",
+				"oui":   macOUI,
+			}).Fatal("invalid mac oui")
+		}
+	}
+
+	workerQueues, err := lochness.ParseJobQueues(queues)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":  err,
+			"func":   "lochness.ParseJobQueues",
+			"queues": queues,
+		}).Fatal("invalid job queues")
+	}
+	workerConfig.Queues = workerQueues
+
+	// One kv connection is shared by every module
+	KV, err := kv.New(kvAddr)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"addr":  kvAddr,
+			"error": err,
+			"func":  "kv.New",
+		}).Fatal("unable to connect to kv")
+	}
+	KV = kv.WithPrefix(KV, kvPrefix)
+
+	// The apis fail requests whose kv operations time out, the other modules
+	// wait on the kv
+	apiCtx := lochness.NewContext(KV).WithTimeout(kvTimeout).WithRetry(kvRetries, lochness.DefaultKVRetryWait)
+
+	// Every module records its metrics in one sink, named as its daemon
+	ms := mapsink.New()
+	if port != 0 {
+		serveMetrics(port, ms)
+	}
+
+	// Requests are traced through the jobs they queue to the placer, the
+	// workers, and the agents
+	if otlpEndpoint != "" {
+		shutdown, err := httpmw.SetupTracing("lochnessd", otlpEndpoint)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":    err,
+				"func":     "httpmw.SetupTracing",
+				"endpoint": otlpEndpoint,
+			}).Fatal("failed to set up tracing")
+		}
+		defer logx.LogReturnedErr(shutdown, nil, "failed to shut down tracing")
+	}
+
+	var servers []*server.Server
+
+	if enableHypervisorAPI || enableGuestAPI || enableUI {
+		reqLog := httpmw.Config{SlowThreshold: slowRequest, Trace: otlpEndpoint != ""}
+
+		var tlsConfig *tls.Config
+		if tlsCert != "" || tlsKey != "" {
+			tlsConfig, err = tlsutil.ServerConfig(tlsCert, tlsKey, tlsReload)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"error": err,
+					"func":  "tlsutil.ServerConfig",
+					"cert":  tlsCert,
+					"key":   tlsKey,
+				}).Fatal("failed to load certificate")
+			}
+		}
+
+		// The apis stream the changes of one feed
+		var feed *changefeed.Feed
+		if enableHypervisorAPI || enableGuestAPI {
+			feed, err = changefeed.New(KV)
+			if err == nil {
+				err = feed.Start()
+			}
+			if err != nil {
+				log.WithFields(log.Fields{
+					"error": err,
+					"func":  "changefeed.Start",
+				}).Fatal("failed to watch for changes")
+			}
+		}
+
+		// The web ui reads the apis served here directly
+		var uiConfig webui.Config
+
+		if enableHypervisorAPI {
+			srv := hypervisorapi.Run(hypervisorAPIPort, apiCtx, feed, reqLog, tlsConfig)
+			servers = append(servers, srv)
+			uiConfig.HypervisorAPI = srv.Handler
+		}
+
+		if enableGuestAPI {
+			log.WithField("address", bstalk).Info("connection to beanstalk")
+			jobQueue, err := jobqueue.NewClient(bstalk, KV)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"error":   err,
+					"address": bstalk,
+				}).Fatal("failed to create jobQueue client")
+			}
+
+			var sinks []metrics.MetricSink
+			if statsd != "" {
+				ss, _ := metrics.NewStatsdSink(statsd)
+				sinks = append(sinks, ss)
+			}
+			mctx := guestapi.NewMetricsContext(ms, sinks...)
+			guestAgent := agent.NewMistify(apiCtx, agentPort)
+			if err := guestAgent.ConfigureTransport(workerConfig.AgentProxy, workerConfig.AgentDialTimeout); err != nil {
+				log.WithFields(log.Fields{
+					"error":        err,
+					"func":         "agent.ConfigureTransport",
+					"proxy":        workerConfig.AgentProxy,
+					"dial-timeout": workerConfig.AgentDialTimeout,
+				}).Fatal("invalid agent transport settings")
+			}
+			if idempotencySweep > 0 {
+				go guestapi.SweepIdempotencyKeys(apiCtx, idempotencySweep)
+			}
+			srv := guestapi.Run(guestAPIPort, apiCtx, jobQueue, guestAgent, macOUI, mctx, feed, reqLog, tlsConfig)
+			servers = append(servers, srv)
+			uiConfig.GuestAPI = srv.Handler
+		}
+
+		if enableUI {
+			uiConfig.HypervisorAPI = uiAPI("hypervisor", uiHypervisorAPI, uiConfig.HypervisorAPI)
+			uiConfig.GuestAPI = uiAPI("guest", uiGuestAPI, uiConfig.GuestAPI)
+			uiConfig.NetworkAPI = uiAPI("network", uiNetworkAPI, nil)
+			servers = append(servers, webui.Run(uiPort, uiConfig, reqLog, tlsConfig))
+		}
+	}
+
+	if enableWorker {
+		workerConfig.Beanstalk = bstalk
+		workerConfig.AgentPort = uint(agentPort)
+		if err := worker.Start(KV, workerConfig, newMetrics("cworkerd", ms)); err != nil {
+			log.WithFields(log.Fields{
+				"error":   err,
+				"address": bstalk,
+				"workers": workerConfig.Workers,
+				"func":    "worker.Start",
+			}).Fatal("failed to start workers")
+		}
+	}
+
+	if enablePlacer {
+		log.WithField("address", bstalk).Info("connection to beanstalk")
+		jobQueue, err := jobqueue.NewClient(bstalk, KV)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":   err,
+				"address": bstalk,
+			}).Fatal("failed to create jobQueue client")
+		}
+		go placer.Run(jobQueue, newMetrics("cplacerd", ms))
+	}
+
+	var dhcpService *dhcp.Service
+	if enableDHCP {
+		dhcpService, err = dhcp.New(KV, dhcpConfig, newMetrics("cdhcpd", ms))
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":      err,
+				"func":       "dhcp.New",
+				"configPath": dhcpConfig.SettingsFile,
+			}).Fatal("could not load dhcp settings")
+		}
+		if err := dhcpService.Start(); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"func":  "dhcp.Service.Start",
+			}).Fatal("could not start dhcp")
+		}
+	}
+
+	// Reload the dhcp settings on SIGHUP
+	if dhcpService != nil {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for s := range hup {
+				log.WithField("signal", s).Info("signal received; reloading dhcp settings")
+				_ = dhcpService.Reload()
+			}
+		}()
+	}
+
+	// Run until SIGINT or SIGTERM, then let the apis finish their requests
+	// and the dhcp refresher its current task. Workers and the placer stop
+	// with the process, as their jobs are reclaimed or retried.
+	sigCtx, cancel := server.SignalContext()
+	defer cancel()
+	server.Wait(sigCtx, servers...)
+	if dhcpService != nil {
+		dhcpService.Stop()
+	}
+	log.Info("exiting")
+	server.FlushLogs()
+}
@@ -0,0 +1,88 @@
+/*
+lock inspects the distributed locks kept in the kv by lochness services, such as
+the leader locks of csched and cfailoverd, the guest locks of cworkerd, and the
+stagger slots of nconfigd, so operators can find out who is holding a lock
+without reading the kv by hand.
+
+Usage
+
+The following arguments are understood
+
+	$ lock -h
+	lock inspects the distributed locks kept in the kv, to debug who is holding a lock and who is waiting for it.
+
+	Usage:
+	  lock [flags]
+	  lock [command]
+
+	Available Commands:
+	  help        Help about any command
+	  list        List locks
+	  status      Show the status of a lock
+
+	Flags:
+	  -h, --help               help for lock
+	  -k, --kv string          address of kv machine (default "http://localhost:4001")
+	      --kv-prefix string   root of the lochness keys in the kv, to share a kv cluster between lochness clusters (default "/lochness")
+
+	Use "lock [command] --help" for more information about a command.
+
+	$ lock status -h
+	Show the holder of a lock, when it expires unless renewed, and the clients waiting for it, as json.
+
+	Usage:
+	  lock status [flags]
+
+	Flags:
+	  -h, --help         help for status
+	      --key string   key of the lock, e.g. lochness/cfailoverd/leader
+
+	$ lock list -h
+	List the status of every lock under a prefix that is held, has been held, or is waited for, as a json array.
+
+	Usage:
+	  lock list [flags]
+
+	Flags:
+	  -h, --help            help for list
+	      --prefix string   prefix to list the locks under (default "lochness")
+
+Status
+
+The status of a lock has the client holding it, if any, with its id, hostname,
+pid, and when it acquired and last renewed the lock, along with when the lock
+expires unless renewed and the time left until then. Clients waiting for the
+lock are listed in the order they will get it, and clients holding a read-write
+lock shared are listed as readers. Durations are in nanoseconds.
+
+Examples
+
+	$ lock status --key lochness/cfailoverd/leader
+	{
+	  "key": "lochness/cfailoverd/leader",
+	  "held": true,
+	  "holder": {
+	    "id": "c3a9f1de-5d4b-4f4e-9a5e-1f0f6c1d2b3a",
+	    "hostname": "node1",
+	    "pid": 1234,
+	    "acquired": "2016-03-01T12:00:00Z",
+	    "renewed": "2016-03-01T12:05:00Z",
+	    "ttl": 15000000000
+	  },
+	  "expires": "2016-03-01T12:05:15Z",
+	  "ttl_remaining": 9500000000,
+	  "waiters": [],
+	  "readers": []
+	}
+
+	$ lock list --prefix lochness/migrations
+	[
+	  {
+	    "key": "lochness/migrations/lock",
+	    "held": false,
+	    "waiters": [],
+	    "readers": []
+	  }
+	]
+*/
+package main
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness/pkg/kv"
+	_ "github.com/mistifyio/lochness/pkg/kv/bolt"
+	_ "github.com/mistifyio/lochness/pkg/kv/consul"
+	"github.com/mistifyio/lochness/pkg/lock"
+	logx "github.com/mistifyio/mistify-logrus-ext"
+	"github.com/spf13/cobra"
+)
+
+var (
+	kvAddr   = "http://localhost:4001"
+	kvPrefix = kv.DefaultPrefix
+	key      = ""
+	prefix   = "lochness"
+)
+
+func help(cmd *cobra.Command, _ []string) {
+	if err := cmd.Help(); err != nil {
+		log.WithField("error", err).Fatal("help")
+	}
+}
+
+// connect connects to the kv, under the prefix
+func connect() kv.KV {
+	k, err := kv.New(kvAddr)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"addr":  kvAddr,
+			"error": err,
+			"func":  "kv.New",
+		}).Fatal("unable to connect to kv")
+	}
+	return kv.WithPrefix(k, kvPrefix)
+}
+
+// printJSON prints v as indented json
+func printJSON(v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.WithField("error", err).Fatal("failed to marshal json")
+	}
+	fmt.Println(string(data))
+}
+
+func status(cmd *cobra.Command, args []string) {
+	if key == "" {
+		help(cmd, args)
+		log.Fatal("missing --key")
+	}
+
+	s, err := lock.Inspect(connect(), key)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"key":   key,
+		}).Fatal("failed to inspect lock")
+	}
+	printJSON(s)
+}
+
+func list(cmd *cobra.Command, args []string) {
+	k := connect()
+	keys, err := lock.List(k, prefix)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":  err,
+			"prefix": prefix,
+		}).Fatal("failed to list locks")
+	}
+
+	statuses := make([]*lock.Status, 0, len(keys))
+	for _, key := range keys {
+		s, err := lock.Inspect(k, key)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"key":   key,
+			}).Fatal("failed to inspect lock")
+		}
+		statuses = append(statuses, s)
+	}
+	printJSON(statuses)
+}
+
+func main() {
+	if err := logx.DefaultSetup("error"); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "logx.DefaultSetup",
+			"level": "error",
+		}).Fatal("unable to set up logrus")
+	}
+
+	root := &cobra.Command{
+		Use:  "lock",
+		Long: "lock inspects the distributed locks kept in the kv, to debug who is holding a lock and who is waiting for it.",
+		Run:  help,
+	}
+	root.PersistentFlags().StringVarP(&kvAddr, "kv", "k", kvAddr, "address of kv machine")
+	root.PersistentFlags().StringVar(&kvPrefix, "kv-prefix", kvPrefix, "root of the lochness keys in the kv, to share a kv cluster between lochness clusters")
+
+	cmdStatus := &cobra.Command{
+		Use:   "status",
+		Short: "Show the status of a lock",
+		Long:  "Show the holder of a lock, when it expires unless renewed, and the clients waiting for it, as json.",
+		Run:   status,
+	}
+	cmdStatus.Flags().StringVar(&key, "key", key, "key of the lock, e.g. lochness/cfailoverd/leader")
+	root.AddCommand(cmdStatus)
+
+	cmdList := &cobra.Command{
+		Use:   "list",
+		Short: "List locks",
+		Long:  "List the status of every lock under a prefix that is held, has been held, or is waited for, as a json array.",
+		Run:   list,
+	}
+	cmdList.Flags().StringVar(&prefix, "prefix", prefix, "prefix to list the locks under")
+	root.AddCommand(cmdList)
+
+	if err := root.Execute(); err != nil {
+		log.WithField("error", err).Fatal("failed to execute root command")
+	}
+}
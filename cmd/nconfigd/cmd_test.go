@@ -32,6 +32,7 @@ type CmdSuite struct {
 }
 
 func (s *CmdSuite) SetupSuite() {
+	s.ExternalKV = true
 	s.Suite.SetupSuite()
 
 	log.SetLevel(log.FatalLevel)
@@ -103,6 +104,7 @@ func (s *CmdSuite) TestCmd() {
 		"-a", s.WorkPath,
 		"-c", s.ConfigPath,
 		"-k", s.KVURL,
+		"-p", "0",
 	}
 
 	tests := []testCase{
@@ -144,3 +146,45 @@ func (s *CmdSuite) TestCmd() {
 		}
 	}
 }
+
+func (s *CmdSuite) TestRunHistory() {
+	node := "history-test"
+	var key string
+	for k, roles := range s.Config {
+		if len(roles) > 0 {
+			key = k
+			break
+		}
+	}
+
+	cmd, err := common.Start("./"+s.BinName,
+		"-a", s.WorkPath,
+		"-c", s.ConfigPath,
+		"-k", s.KVURL,
+		"-p", "0",
+		"-n", node,
+		"-r", "1",
+	)
+	s.Require().NoError(err)
+
+	time.Sleep(500 * time.Millisecond)
+	s.NoError(s.KV.Set(key, "true"))
+	time.Sleep(500 * time.Millisecond)
+	s.NoError(cmd.Stop())
+
+	keys, err := s.KV.Keys("lochness/nconfigd/runs/" + node)
+	s.NoError(err)
+	s.Len(keys, 1, "older runs should be pruned")
+
+	runs, err := common.ExecSync("./"+s.BinName, "runs", "-k", s.KVURL, "-n", node)
+	s.Require().NoError(err)
+	lines := strings.Split(strings.TrimSpace(runs.Out.String()), "\n")
+	s.Len(lines, 2, "should list a header and one run")
+	s.Contains(lines[1], key, "should list the triggering key")
+
+	id := strings.Fields(lines[1])[0]
+	run, err := common.ExecSync("./"+s.BinName, "runs", "-k", s.KVURL, "-n", node, id)
+	s.Require().NoError(err)
+	s.Contains(run.Out.String(), `"exit_status": 0`)
+	s.Contains(run.Out.String(), "--kv", "should include ansible output")
+}
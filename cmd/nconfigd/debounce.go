@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultQuietPeriod is how long a prefix must go without changes before
+	// its changes are run, unless configured otherwise
+	defaultQuietPeriod = 100 * time.Millisecond
+	// defaultMaxDelay is the longest a change waits for its run while changes
+	// keep coming, unless configured otherwise
+	defaultMaxDelay = 1 * time.Second
+)
+
+// Debounce is how long the changes to a prefix are batched for before they
+// are run. Zero values are taken from the defaults.
+type Debounce struct {
+	// QuietPeriod is how long the prefix must go without changes
+	QuietPeriod time.Duration
+	// MaxDelay is the longest the first change of a batch waits
+	MaxDelay time.Duration
+}
+
+// orDefaults returns d with its zero values taken from defaults
+func (d Debounce) orDefaults(defaults Debounce) Debounce {
+	if d.QuietPeriod == 0 {
+		d.QuietPeriod = defaults.QuietPeriod
+	}
+	if d.MaxDelay == 0 {
+		d.MaxDelay = defaults.MaxDelay
+	}
+	return d
+}
+
+// Watch is the ansible tags to run for a watched prefix and how its changes
+// are batched. In the config file it is either the array of tags, or an object
+// with the tags and the "quiet_period" and "max_delay" durations, e.g.
+// {"tags": ["packages"], "quiet_period": "30s", "max_delay": "5m"}.
+type Watch struct {
+	Tags Tags
+	Debounce
+}
+
+// watchJSON is the object form of a Watch
+type watchJSON struct {
+	Tags        Tags   `json:"tags"`
+	QuietPeriod string `json:"quiet_period,omitempty"`
+	MaxDelay    string `json:"max_delay,omitempty"`
+}
+
+// UnmarshalJSON reads a Watch from an array of tags or an object
+func (w *Watch) UnmarshalJSON(data []byte) error {
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "[") {
+		*w = Watch{}
+		return json.Unmarshal(data, &w.Tags)
+	}
+
+	var wj watchJSON
+	if err := json.Unmarshal(data, &wj); err != nil {
+		return err
+	}
+	watch := Watch{Tags: wj.Tags}
+	for _, d := range []struct {
+		name  string
+		value string
+		dest  *time.Duration
+	}{
+		{"quiet_period", wj.QuietPeriod, &watch.QuietPeriod},
+		{"max_delay", wj.MaxDelay, &watch.MaxDelay},
+	} {
+		if d.value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(d.value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %s", d.name, err)
+		}
+		if duration < 0 {
+			return fmt.Errorf("invalid %s: must not be negative", d.name)
+		}
+		*d.dest = duration
+	}
+	*w = watch
+	return nil
+}
+
+// MarshalJSON writes a Watch as its tags when it has no debounce settings, and
+// as an object otherwise
+func (w Watch) MarshalJSON() ([]byte, error) {
+	tags := w.Tags
+	if tags == nil {
+		tags = Tags{}
+	}
+	if w.Debounce == (Debounce{}) {
+		return json.Marshal(tags)
+	}
+	wj := watchJSON{Tags: tags}
+	if w.QuietPeriod != 0 {
+		wj.QuietPeriod = w.QuietPeriod.String()
+	}
+	if w.MaxDelay != 0 {
+		wj.MaxDelay = w.MaxDelay.String()
+	}
+	return json.Marshal(wj)
+}
+
+// batch is the changed keys of a prefix waiting to be run
+type batch struct {
+	keys map[string]struct{}
+	// due is when the batch is run: after the quiet period since the last
+	// change, but no later than the max delay since the first
+	due time.Time
+	// deadline is the max delay since the first change
+	deadline time.Time
+}
+
+// batcher collects changed keys into a batch per watched prefix, so that each
+// prefix is debounced on its own
+type batcher struct {
+	batches map[string]*batch
+}
+
+func newBatcher() *batcher {
+	return &batcher{batches: map[string]*batch{}}
+}
+
+// Add adds a key changed at now to the batch of its prefix
+func (b *batcher) Add(prefix, key string, debounce Debounce, now time.Time) {
+	bt, ok := b.batches[prefix]
+	if !ok {
+		bt = &batch{
+			keys:     map[string]struct{}{},
+			deadline: now.Add(debounce.MaxDelay),
+		}
+		b.batches[prefix] = bt
+	}
+	bt.keys[key] = struct{}{}
+	bt.due = now.Add(debounce.QuietPeriod)
+	if bt.due.After(bt.deadline) {
+		bt.due = bt.deadline
+	}
+}
+
+// Next returns when the next batch is due, and false if there are none
+func (b *batcher) Next() (time.Time, bool) {
+	var next time.Time
+	for _, bt := range b.batches {
+		if next.IsZero() || bt.due.Before(next) {
+			next = bt.due
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// Due removes the batches due by now and returns their keys
+func (b *batcher) Due(now time.Time) []string {
+	var keys []string
+	for prefix, bt := range b.batches {
+		if bt.due.After(now) {
+			continue
+		}
+		for key := range bt.keys {
+			keys = append(keys, key)
+		}
+		delete(b.batches, prefix)
+	}
+	return keys
+}
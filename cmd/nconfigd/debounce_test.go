@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestDebounce(t *testing.T) {
+	suite.Run(t, new(DebounceSuite))
+}
+
+type DebounceSuite struct {
+	suite.Suite
+}
+
+func (s *DebounceSuite) TestUnmarshalConfig() {
+	data := []byte(`{
+		"/lochness/config": [],
+		"/lochness/dns": {"tags": ["dns"], "quiet_period": "10ms"},
+		"/lochness/packages": {"tags": ["packages"], "quiet_period": "30s", "max_delay": "5m"}
+	}`)
+	config := Config{}
+	s.Require().NoError(json.Unmarshal(data, &config))
+
+	s.Equal(Watch{Tags: Tags{}}, config["/lochness/config"])
+	s.Equal(Watch{Tags: Tags{"dns"}, Debounce: Debounce{QuietPeriod: 10 * time.Millisecond}}, config["/lochness/dns"])
+	s.Equal(Watch{
+		Tags:     Tags{"packages"},
+		Debounce: Debounce{QuietPeriod: 30 * time.Second, MaxDelay: 5 * time.Minute},
+	}, config["/lochness/packages"])
+
+	out, err := json.Marshal(config)
+	s.Require().NoError(err)
+	roundTrip := Config{}
+	s.Require().NoError(json.Unmarshal(out, &roundTrip))
+	s.Equal(config, roundTrip)
+
+	for _, bad := range []string{
+		`{"/a": {"tags": [], "quiet_period": "soon"}}`,
+		`{"/a": {"tags": [], "max_delay": "-1s"}}`,
+		`{"/a": "dns"}`,
+	} {
+		s.Error(json.Unmarshal([]byte(bad), &Config{}), bad)
+	}
+}
+
+func (s *DebounceSuite) TestOrDefaults() {
+	defaults := Debounce{QuietPeriod: time.Second, MaxDelay: time.Minute}
+	s.Equal(defaults, Debounce{}.orDefaults(defaults))
+	s.Equal(Debounce{QuietPeriod: time.Millisecond, MaxDelay: time.Minute},
+		Debounce{QuietPeriod: time.Millisecond}.orDefaults(defaults))
+}
+
+func (s *DebounceSuite) TestQuietPeriod() {
+	b := newBatcher()
+	now := time.Now()
+	d := Debounce{QuietPeriod: time.Second, MaxDelay: time.Minute}
+
+	_, ok := b.Next()
+	s.False(ok)
+
+	b.Add("/a", "/a/1", d, now)
+	b.Add("/a", "/a/2", d, now.Add(500*time.Millisecond))
+	next, ok := b.Next()
+	s.True(ok)
+	s.Equal(now.Add(1500*time.Millisecond), next, "quiet period should restart on each change")
+
+	s.Empty(b.Due(now.Add(time.Second)))
+	s.ElementsMatch([]string{"/a/1", "/a/2"}, b.Due(next))
+	_, ok = b.Next()
+	s.False(ok)
+}
+
+func (s *DebounceSuite) TestMaxDelay() {
+	b := newBatcher()
+	now := time.Now()
+	d := Debounce{QuietPeriod: time.Second, MaxDelay: 2 * time.Second}
+
+	for i := 0; i < 5; i++ {
+		b.Add("/a", "/a/1", d, now.Add(time.Duration(i)*500*time.Millisecond))
+	}
+	next, _ := b.Next()
+	s.Equal(now.Add(2*time.Second), next, "changes should not wait past the max delay")
+	s.Equal([]string{"/a/1"}, b.Due(next))
+}
+
+func (s *DebounceSuite) TestPerPrefix() {
+	b := newBatcher()
+	now := time.Now()
+
+	b.Add("/packages", "/packages/x", Debounce{QuietPeriod: time.Minute, MaxDelay: time.Hour}, now)
+	b.Add("/dns", "/dns/x", Debounce{QuietPeriod: 10 * time.Millisecond, MaxDelay: time.Second}, now)
+
+	next, _ := b.Next()
+	s.Equal(now.Add(10*time.Millisecond), next)
+	s.Equal([]string{"/dns/x"}, b.Due(next), "a busy prefix should not hold up another")
+
+	next, _ = b.Next()
+	s.Equal(now.Add(time.Minute), next)
+	s.Equal([]string{"/packages/x"}, b.Due(next))
+}
@@ -10,12 +10,29 @@ The following arguments are understood:
 	Usage of nconfigd:
 	-a, --ansible="/root/lochness-ansible": directory containing the ansible run command
 	-c, --config="": path to config file with prefixs
+	    --config-file="": yaml or toml file of flag settings, overridden by the environment and the command line
+	-p, --http=7547: http port to publish metrics and health. set to 0 to disable
 	-k, --kv="http://127.0.0.1:4001": address of kv server
+	    --kv-prefix="/lochness": root of the lochness keys in the kv, to share a kv cluster between lochness clusters
 	-l, --log-level="warn": log level
+	    --log-max-backups=5: number of rotated log files kept
+	    --log-max-size=100: megabytes a log file grows to before it is rotated, 0 to never rotate it
+	    --log-output="stdout": where to log: stdout, stderr, syslog, journald, or the path of a file
+	-m, --max-concurrent=1: maximum concurrent ansible runs. runs sharing a tag never overlap
+	    --max-consecutive-failures=5: ansible runs in a row that may fail, retries included, before exiting. 0 never exits
+	    --max-delay=1s: longest a change waits for ansible while its prefix keeps changing, unless set for the prefix in the config file
+	-n, --node="": name of this node in run history. defaults to hostname
+	    --quiet-period=100ms: how long a watched prefix must go without changes before running ansible, unless set for the prefix in the config file
+	-r, --retain=100: number of ansible runs to keep in run history. 0 disables history
+	    --retries=2: times a failed ansible run is retried
+	    --retry-backoff=10s: wait before the first retry of a failed ansible run, doubled for each retry after
+	-s, --stagger=0: maximum ansible runs at once across all nodes. 0 disables
 
 Config
 
-Config consists of a map of watched kv prefixes to an array of ansible role names
+Config consists of a map of watched kv prefixes to an array of ansible role names,
+or to an object with the role names as "tags" and the "quiet_period" and
+"max_delay" durations to batch the changes to that prefix with
 
 Example config
 
@@ -24,8 +41,81 @@ Example config
 		"/lochness/hypervisors/abcd1234-abcd-1234-abcd-1234abcd1234/config/cbootstrapd": ["cbootstrapd"],
 		"/lochness/hypervisors/abcd1234-abcd-1234-abcd-1234abcd1234/config/dhcpd": ["dhcpd","dhcrelay"],
 		"/lochness/hypervisors/abcd1234-abcd-1234-abcd-1234abcd1234/config/dhcrelay": ["dhcrelay"],
-		"/lochness/hypervisors/abcd1234-abcd-1234-abcd-1234abcd1234/config/dns": ["dns","dhcpd"],
-		"/lochness/hypervisors/abcd1234-abcd-1234-abcd-1234abcd1234/config/tftpd": ["tftpd"]
+		"/lochness/hypervisors/abcd1234-abcd-1234-abcd-1234abcd1234/config/dns": {"tags": ["dns","dhcpd"], "quiet_period": "10ms", "max_delay": "100ms"},
+		"/lochness/hypervisors/abcd1234-abcd-1234-abcd-1234abcd1234/config/packages": {"tags": ["packages"], "quiet_period": "30s", "max_delay": "5m"},
+		"/lochness/hypervisors/abcd1234-abcd-1234-abcd-1234abcd1234/config/tftpd": ["tftpd"],
+		"/lochness/hypervisors/abcd1234-abcd-1234-abcd-1234abcd1234/config/version": ["upgrade"]
 	}
+
+Debouncing
+
+Changes are batched per watched prefix before ansible runs. A batch runs once
+its prefix has gone --quiet-period without changes, or once its first change
+has waited --max-delay, whichever comes first, so a prefix that keeps changing
+still gets runs. Prefixes can set their own "quiet_period" and "max_delay" in
+the config file, e.g. so DNS changes apply quickly while package changes are
+gathered up for minutes. A zero or missing value uses the flag. Batches that
+come due together share a single run.
+
+Concurrency
+
+By default ansible runs happen one at a time. With --max-concurrent greater than
+one, runs for disjoint sets of tags may overlap, so a slow playbook for one role
+does not hold up changes for another. Runs sharing a tag are still serialized,
+and a full playbook run waits for, and blocks, all other runs. A waiting full
+run goes before tagged runs that come due after it. Changes coming due while a
+run for their tags, or a full run, is still waiting are added to that run
+rather than queueing another.
+
+Staggering
+
+A change to a prefix watched by every node would otherwise start ansible on all
+of them at once. With --stagger set, each run first takes one of that many slot
+locks kept in the kv under /lochness/nconfigd/stagger/, waiting for one to free
+up if necessary, so the change is rolled out across the cluster in waves. Every
+node should use the same --stagger value. A slot held by a node that dies is
+freed after 30 seconds.
+
+Failures
+
+A failed ansible run, such as one hitting a transient apt mirror error, is
+retried up to --retries times, waiting --retry-backoff before the first retry
+and twice as long before each one after. A run that fails every attempt counts
+as one failure, and nconfigd only exits once --max-consecutive-failures runs in
+a row have failed, so that its supervisor can restart it. A successful run
+resets the count.
+
+Failures are kept as the metrics nconfigd.runs.failed, nconfigd.runs.retried,
+nconfigd.runs.succeeded and nconfigd.runs.consecutive_failures, served as JSON
+from /metrics on the --http port. /health on the same port returns 200 while
+the latest run succeeded and 503 while it failed, along with the failure counts
+and the last error:
+
+	$ curl http://localhost:7547/health
+	{"healthy":false,"consecutive_failures":1,"total_failures":3,"last_error":"exit status 2","last_failure":"2016-03-01T12:00:00Z"}
+
+Run History
+
+Each ansible run is recorded in the kv under /lochness/nconfigd/runs/<node>/,
+including the keys that triggered it, the tags run, start and end times, the
+exit status and the tail of the output. Only the newest --retain records are
+kept for each node.
+
+The runs subcommand lists the recorded runs of a node, oldest first, or shows a
+single run in full when given its id:
+
+	$ nconfigd runs -h
+	Usage: nconfigd runs [options] [run id]
+	-k, --kv="http://127.0.0.1:4001": address of kv server
+	    --kv-prefix="/lochness": root of the lochness keys in the kv, to share a kv cluster between lochness clusters
+	-n, --node="": node to show runs for. defaults to hostname
+
+Reloading
+
+Sending nconfigd a SIGHUP rereads the config file. Newly listed prefixes are
+watched, prefixes no longer listed are dropped, and tags and debounce settings
+are updated. Any ansible run in progress finishes before the new config takes
+effect. If the new config cannot be loaded, or its prefixes cannot all be
+watched, an error is logged and the previous config and watches stay in use.
 */
 package main
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/armon/go-metrics"
+	"github.com/bakins/go-metrics-map"
+)
+
+// failurePolicy retries failed ansible runs with a doubling backoff and counts
+// the runs that fail every attempt, so the daemon only gives up after
+// MaxConsecutive failed runs in a row rather than on the first one.
+type failurePolicy struct {
+	// Retries is how many times a failed run is retried
+	Retries int
+	// Backoff is the wait before the first retry, doubled for each one after
+	Backoff time.Duration
+	// MaxConsecutive is how many runs in a row may fail before Run reports
+	// that the daemon should exit. 0 never gives up.
+	MaxConsecutive int
+
+	m *metrics.Metrics
+
+	mu          sync.Mutex
+	consecutive int
+	total       int
+	lastError   string
+	lastFailure time.Time
+}
+
+// failureStatus is the failure state reported by the health endpoint
+type failureStatus struct {
+	Healthy             bool       `json:"healthy"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	TotalFailures       int        `json:"total_failures"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastFailure         *time.Time `json:"last_failure,omitempty"`
+}
+
+// Run calls run until it succeeds or the retries are used up. It returns
+// whether the run pushed the consecutive failures to MaxConsecutive, and the
+// error of the last attempt.
+func (p *failurePolicy) Run(keys []string, run func() error) (bool, error) {
+	backoff := p.Backoff
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = run(); err == nil || attempt >= p.Retries {
+			break
+		}
+		log.WithFields(log.Fields{
+			"keys":    keys,
+			"error":   err,
+			"attempt": attempt + 1,
+			"retryIn": backoff,
+		}).Warn("ansible run failed, retrying")
+		p.incr("retried")
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	return p.record(err), err
+}
+
+// record counts the outcome of a run and returns whether the consecutive
+// failures have reached MaxConsecutive
+func (p *failurePolicy) record(err error) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err == nil {
+		p.consecutive = 0
+		p.incr("succeeded")
+		p.gauge()
+		return false
+	}
+
+	p.consecutive++
+	p.total++
+	p.lastError = err.Error()
+	p.lastFailure = time.Now()
+	p.incr("failed")
+	p.gauge()
+	return p.MaxConsecutive > 0 && p.consecutive >= p.MaxConsecutive
+}
+
+func (p *failurePolicy) incr(name string) {
+	if p.m != nil {
+		p.m.IncrCounter([]string{"runs", name}, 1)
+	}
+}
+
+func (p *failurePolicy) gauge() {
+	if p.m != nil {
+		p.m.SetGauge([]string{"runs", "consecutive_failures"}, float32(p.consecutive))
+	}
+}
+
+// Status returns the current failure state. Nconfigd is unhealthy while its
+// latest run has failed.
+func (p *failurePolicy) Status() failureStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	status := failureStatus{
+		Healthy:             p.consecutive == 0,
+		ConsecutiveFailures: p.consecutive,
+		TotalFailures:       p.total,
+		LastError:           p.lastError,
+	}
+	if !p.lastFailure.IsZero() {
+		lastFailure := p.lastFailure
+		status.LastFailure = &lastFailure
+	}
+	return status
+}
+
+// ServeHTTP writes the failure state as JSON, with a 503 status while
+// unhealthy
+func (p *failurePolicy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	status := p.Status()
+	w.Header().Set("Content-Type", "application/json")
+	if status.Healthy {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(status)
+}
+
+// setupMetrics creates the metric sink and, unless port is 0, starts an http
+// server publishing the metrics and the health of the failure policy
+func setupMetrics(port uint, p *failurePolicy) *metrics.Metrics {
+	ms := mapsink.New()
+	conf := metrics.DefaultConfig("nconfigd")
+	conf.EnableHostname = false
+	m, _ := metrics.New(conf, ms)
+
+	if port != 0 {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			_ = json.NewEncoder(w).Encode(ms)
+		}))
+		mux.Handle("/health", p)
+
+		go func() {
+			log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), mux))
+		}()
+	}
+
+	return m
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestFailurePolicy(t *testing.T) {
+	suite.Run(t, new(FailurePolicySuite))
+}
+
+type FailurePolicySuite struct {
+	suite.Suite
+	Policy *failurePolicy
+}
+
+func (s *FailurePolicySuite) SetupSuite() {
+	log.SetLevel(log.FatalLevel)
+}
+
+func (s *FailurePolicySuite) SetupTest() {
+	s.Policy = &failurePolicy{
+		Retries:        2,
+		Backoff:        time.Millisecond,
+		MaxConsecutive: 2,
+	}
+}
+
+// failing returns a run that fails the first n attempts, counting attempts
+func failing(n int, attempts *int) func() error {
+	return func() error {
+		*attempts++
+		if *attempts <= n {
+			return errors.New("apt mirror unreachable")
+		}
+		return nil
+	}
+}
+
+func (s *FailurePolicySuite) TestRetrySucceeds() {
+	attempts := 0
+	exceeded, err := s.Policy.Run(nil, failing(2, &attempts))
+	s.NoError(err)
+	s.False(exceeded)
+	s.Equal(3, attempts)
+	s.True(s.Policy.Status().Healthy)
+}
+
+func (s *FailurePolicySuite) TestMaxConsecutive() {
+	attempts := 0
+	exceeded, err := s.Policy.Run(nil, failing(10, &attempts))
+	s.Error(err)
+	s.False(exceeded, "one failed run should be tolerated")
+	s.Equal(3, attempts, "should stop after the retries")
+
+	status := s.Policy.Status()
+	s.False(status.Healthy)
+	s.Equal(1, status.ConsecutiveFailures)
+	s.Equal("apt mirror unreachable", status.LastError)
+	s.NotNil(status.LastFailure)
+
+	exceeded, _ = s.Policy.Run(nil, failing(10, &attempts))
+	s.True(exceeded)
+
+	s.Policy.MaxConsecutive = 0
+	exceeded, _ = s.Policy.Run(nil, failing(10, &attempts))
+	s.False(exceeded, "0 should never give up")
+}
+
+func (s *FailurePolicySuite) TestSuccessResets() {
+	attempts := 0
+	_, _ = s.Policy.Run(nil, failing(3, &attempts))
+	s.Equal(1, s.Policy.Status().ConsecutiveFailures)
+
+	_, err := s.Policy.Run(nil, failing(0, &attempts))
+	s.NoError(err)
+	status := s.Policy.Status()
+	s.True(status.Healthy)
+	s.Equal(0, status.ConsecutiveFailures)
+	s.Equal(1, status.TotalFailures)
+}
+
+func (s *FailurePolicySuite) TestHealth() {
+	w := httptest.NewRecorder()
+	s.Policy.ServeHTTP(w, nil)
+	s.Equal(http.StatusOK, w.Code)
+
+	attempts := 0
+	_, _ = s.Policy.Run(nil, failing(3, &attempts))
+	w = httptest.NewRecorder()
+	s.Policy.ServeHTTP(w, nil)
+	s.Equal(http.StatusServiceUnavailable, w.Code)
+
+	var status failureStatus
+	s.Require().NoError(json.Unmarshal(w.Body.Bytes(), &status))
+	s.False(status.Healthy)
+	s.Equal(1, status.ConsecutiveFailures)
+}
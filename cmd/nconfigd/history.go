@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mistifyio/lochness/pkg/kv"
+)
+
+// runsPath is the kv prefix under which ansible run records are kept per node
+const runsPath = "lochness/nconfigd/runs/"
+
+// maxRunOutput is the number of trailing bytes of ansible output kept in a record
+const maxRunOutput = 16 * 1024
+
+// runRecord is the history of a single ansible run
+type runRecord struct {
+	ID         string    `json:"id"`
+	Node       string    `json:"node"`
+	Keys       []string  `json:"keys"`
+	Tags       []string  `json:"tags"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	ExitStatus int       `json:"exit_status"`
+	Error      string    `json:"error,omitempty"`
+	Output     string    `json:"output"`
+	Truncated  bool      `json:"truncated,omitempty"`
+}
+
+// newRunRecord creates a runRecord for a run starting now. IDs sort in the
+// order the runs were started.
+func newRunRecord(node string, keys, tags []string) *runRecord {
+	start := time.Now()
+	return &runRecord{
+		ID:    fmt.Sprintf("%020d", start.UnixNano()),
+		Node:  node,
+		Keys:  keys,
+		Tags:  tags,
+		Start: start,
+	}
+}
+
+// history stores runRecords for a node in the kv, keeping only the newest
+// retain records
+type history struct {
+	mu     sync.Mutex
+	kv     kv.KV
+	node   string
+	retain int
+}
+
+// newHistory creates a history for node. A retain of 0 disables recording.
+func newHistory(k kv.KV, node string, retain int) *history {
+	return &history{
+		kv:     k,
+		node:   node,
+		retain: retain,
+	}
+}
+
+// newNodeHistory creates a history for node, falling back to the hostname
+func newNodeHistory(k kv.KV, node string, retain int) (*history, error) {
+	if node == "" {
+		var err error
+		if node, err = os.Hostname(); err != nil {
+			return nil, err
+		}
+	}
+	return newHistory(k, node, retain), nil
+}
+
+// Node returns the name of the node runs are recorded for
+func (h *history) Node() string {
+	if h == nil {
+		return ""
+	}
+	return h.node
+}
+
+// nodeRunsPath is a helper for generating the prefix of a node's records
+func nodeRunsPath(node string) string {
+	return path.Join(runsPath, node)
+}
+
+// Record saves a run and drops the oldest records beyond the retention limit
+func (h *history) Record(r *runRecord) error {
+	if h == nil || h.retain <= 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	// runs may finish concurrently, keep pruning from racing itself
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.kv.Set(path.Join(nodeRunsPath(h.node), r.ID), string(data)); err != nil {
+		return err
+	}
+	return h.prune()
+}
+
+// prune deletes the oldest records beyond the retention limit
+func (h *history) prune() error {
+	keys, err := h.kv.Keys(nodeRunsPath(h.node))
+	if err != nil {
+		return err
+	}
+	if len(keys) <= h.retain {
+		return nil
+	}
+
+	sort.Strings(keys)
+	for _, key := range keys[:len(keys)-h.retain] {
+		if err := h.kv.Delete(key, false); err != nil && !h.kv.IsKeyNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// listRuns returns the records of a node, oldest first
+func listRuns(k kv.KV, node string) ([]*runRecord, error) {
+	values, err := k.GetAll(nodeRunsPath(node) + "/")
+	if err != nil {
+		return nil, err
+	}
+
+	runs := make([]*runRecord, 0, len(values))
+	for _, value := range values {
+		r := &runRecord{}
+		if err := json.Unmarshal(value.Data, r); err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+	sort.Sort(byID(runs))
+	return runs, nil
+}
+
+// getRun returns a single record of a node
+func getRun(k kv.KV, node, id string) (*runRecord, error) {
+	value, err := k.Get(path.Join(nodeRunsPath(node), id))
+	if err != nil {
+		return nil, err
+	}
+
+	r := &runRecord{}
+	if err := json.Unmarshal(value.Data, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// byID sorts runRecords by ID, which is the order they were started
+type byID []*runRecord
+
+func (b byID) Len() int           { return len(b) }
+func (b byID) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byID) Less(i, j int) bool { return b[i].ID < b[j].ID }
+
+// tailBuffer is an io.Writer that keeps only the last max bytes written
+type tailBuffer struct {
+	mu        sync.Mutex
+	max       int
+	buf       []byte
+	truncated bool
+}
+
+// newTailBuffer creates a tailBuffer holding up to max bytes
+func newTailBuffer(max int) *tailBuffer {
+	return &tailBuffer{max: max}
+}
+
+// Write appends p, discarding the oldest bytes beyond max
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.buf = append(t.buf, p...)
+	if over := len(t.buf) - t.max; over > 0 {
+		t.buf = append(t.buf[:0], t.buf[over:]...)
+		t.truncated = true
+	}
+	return len(p), nil
+}
+
+// Tail returns the retained bytes and whether any were discarded
+func (t *tailBuffer) Tail() (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return string(t.buf), t.truncated
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestHistory(t *testing.T) {
+	suite.Run(t, new(HistorySuite))
+}
+
+type HistorySuite struct {
+	suite.Suite
+}
+
+func (s *HistorySuite) TestTailBuffer() {
+	t := newTailBuffer(8)
+
+	_, _ = t.Write([]byte("abcd"))
+	out, truncated := t.Tail()
+	s.Equal("abcd", out)
+	s.False(truncated)
+
+	_, _ = t.Write([]byte("efgh"))
+	out, truncated = t.Tail()
+	s.Equal("abcdefgh", out)
+	s.False(truncated, "exactly full should not be truncated")
+
+	n, err := t.Write([]byte(strings.Repeat("x", 10) + "ijk"))
+	s.NoError(err)
+	s.Equal(13, n, "should report the full write")
+	out, truncated = t.Tail()
+	s.Equal("xxxxxijk", out)
+	s.True(truncated)
+}
+
+func (s *HistorySuite) TestRunRecordOrder() {
+	first := newRunRecord("node", nil, nil)
+	second := newRunRecord("node", nil, nil)
+	s.True(first.ID <= second.ID, "ids should sort in start order")
+	s.Len(first.ID, 20)
+}
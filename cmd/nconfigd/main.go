@@ -3,21 +3,26 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"io"
 	"io/ioutil"
+	"math/rand"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path"
 	"sort"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
+	lconfig "github.com/mistifyio/lochness/internal/config"
+	"github.com/mistifyio/lochness/internal/logging"
 	"github.com/mistifyio/lochness/pkg/kv"
+	_ "github.com/mistifyio/lochness/pkg/kv/bolt"
 	_ "github.com/mistifyio/lochness/pkg/kv/consul"
 	"github.com/mistifyio/lochness/pkg/watcher"
-	logx "github.com/mistifyio/mistify-logrus-ext"
 	flag "github.com/ogier/pflag"
 )
 
@@ -25,17 +30,22 @@ type (
 	// Tags is a list of ansible tags
 	Tags []string
 
-	// Config is a map of kv watched prefixes to ansible tags to run
-	Config map[string]Tags
+	// Config is a map of kv watched prefixes to the ansible tags to run and
+	// how changes are batched
+	Config map[string]Watch
 )
 
 const defaultKVAddr = "http://127.0.0.1:4001"
 
 var ansibleDir = "/var/lib/ansible"
 
+// kvPrefix is the root of the lochness keys in the kv
+var kvPrefix = kv.DefaultPrefix
+
 // loadConfig reads the config file and unmarshals it into a map containing
-// prefixs to watch and ansible tags to run. An empty tag array means a full
-// playbook run. The config file should not be empty
+// prefixs to watch, ansible tags to run, and optionally how changes are
+// batched. An empty tag array means a full playbook run. The config file should
+// not be empty
 func loadConfig(path string) (Config, error) {
 	if path == "" {
 		return Config{}, nil
@@ -58,26 +68,33 @@ func loadConfig(path string) (Config, error) {
 	return config, nil
 }
 
-// getTags returns the ansible tags, if any, associated with a key
-func getTags(config Config, key string) []string {
+// getWatch returns the watched prefix, if any, matching a key and its Watch
+func getWatch(config Config, key string) (string, Watch, bool) {
 	// Check for exact match
-	if tags, ok := config[key]; ok {
-		return tags
+	if watch, ok := config[key]; ok {
+		return key, watch, true
 	}
 
 	// Find prefix
-	for watchPrefix, tags := range config {
+	for watchPrefix, watch := range config {
 		if !strings.HasPrefix(key, watchPrefix) {
 			continue
 		}
-		return tags
+		return watchPrefix, watch, true
 	}
 
-	return nil
+	return "", Watch{}, false
 }
 
-// runAnsible kicks off an ansible run
-func runAnsible(config Config, kvaddr string, keys ...string) {
+// getTags returns the ansible tags, if any, associated with a key
+func getTags(config Config, key string) []string {
+	_, watch, _ := getWatch(config, key)
+	return watch.Tags
+}
+
+// runTags returns the sorted set of ansible tags to run for the changed keys.
+// An empty result means a full playbook run.
+func runTags(config Config, keys ...string) []string {
 	tagSet := map[string]struct{}{}
 	for _, key := range keys {
 		tags := getTags(config, key)
@@ -94,30 +111,95 @@ func runAnsible(config Config, kvaddr string, keys ...string) {
 		keyTags = append(keyTags, tag)
 	}
 	sort.Strings(keyTags)
+	return keyTags
+}
+
+// runAnsible waits for a cluster wide run slot, if staggered, then kicks off an
+// ansible run and records it in hist
+func runAnsible(kvaddr string, keys []string, keyTags []string, hist *history, st *stagger) error {
+	slot, err := st.Acquire()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"keys":  keys,
+			"error": err,
+		}).Error("failed to acquire run slot")
+		return err
+	}
+	if slot != nil {
+		defer func() { _ = slot.Release() }()
+	}
 
-	args := make([]string, 0, 2+len(keyTags)*2)
+	args := make([]string, 0, 4+len(keyTags)*2)
 	args = append(args, "--kv", kvaddr)
+	if kvPrefix != kv.DefaultPrefix {
+		args = append(args, "--kv-prefix", kvPrefix)
+	}
 	for _, tag := range keyTags {
 		args = append(args, "-t", tag)
 	}
+	output := newTailBuffer(maxRunOutput)
 	cmd := exec.Command(path.Join(ansibleDir, "run"), args...)
 	cmd.Dir = ansibleDir
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Stdout = io.MultiWriter(os.Stdout, output)
+	cmd.Stderr = io.MultiWriter(os.Stderr, output)
 
-	if err := cmd.Run(); err != nil {
+	record := newRunRecord(hist.Node(), keys, keyTags)
+	err = cmd.Run()
+	record.End = time.Now()
+	record.Output, record.Truncated = output.Tail()
+	if err != nil {
+		record.Error = err.Error()
+		record.ExitStatus = -1
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+				record.ExitStatus = status.ExitStatus()
+			}
+		}
+	}
+	if rerr := hist.Record(record); rerr != nil {
+		log.WithFields(log.Fields{
+			"error": rerr,
+			"run":   record.ID,
+		}).Error("failed to record ansible run")
+	}
+
+	if err != nil {
 		log.WithFields(log.Fields{
 			"keys":       keys,
 			"ansibleDir": ansibleDir,
 			"args":       args,
 			"error":      err,
 			"errorMsg":   err.Error(),
-		}).Fatal("ansible run failed")
+		}).Error("ansible run failed")
+	}
+	return err
+}
+
+// runWithPolicy runs ansible under the failure policy, exiting once too many
+// runs in a row have failed
+func runWithPolicy(policy *failurePolicy, kvaddr string, keys []string, keyTags []string, hist *history, st *stagger) error {
+	exceeded, err := policy.Run(keys, func() error {
+		return runAnsible(kvaddr, keys, keyTags, hist, st)
+	})
+	if exceeded {
+		log.WithFields(log.Fields{
+			"keys":                   keys,
+			"error":                  err,
+			"maxConsecutiveFailures": policy.MaxConsecutive,
+		}).Fatal("too many consecutive ansible run failures")
 	}
+	return err
 }
 
-// consumeResponses consumes kv respones from a watcher and kicks off ansible
-func consumeResponses(config Config, eaddr string, w *watcher.Watcher, ready chan struct{}) {
+// consumeResponses consumes kv respones from a watcher and kicks off ansible.
+// Changes are batched per watched prefix, a batch running once its prefix has
+// been quiet for the quiet period or its first change has waited the max
+// delay, with defaults filling in for prefixes that do not set their own.
+// Runs are started in the background, with locker serializing runs that share
+// tags, and tracked by runs so they can be waited on before exiting. While a
+// run waits for its tags, later batches for the same tags are folded into it
+// rather than queueing runs of their own.
+func consumeResponses(config Config, defaults Debounce, eaddr string, w *watcher.Watcher, ready chan struct{}, locker *tagLocker, runs *sync.WaitGroup, hist *history, st *stagger, policy *failurePolicy) {
 	key := make(chan string, 1)
 	go func() {
 		for w.Next() {
@@ -131,45 +213,144 @@ func consumeResponses(config Config, eaddr string, w *watcher.Watcher, ready cha
 		}
 	}()
 
-	keys := map[string]struct{}{}
-	timer := time.NewTimer(100 * time.Millisecond)
+	batches := newBatcher()
+	pending := newPendingRuns()
+	timer := time.NewTimer(defaults.QuietPeriod)
 	timer.Stop()
-	max := time.NewTimer(1 * time.Second)
-	max.Stop()
-	maxStopped := true
+	// schedule resets the timer to fire when the next batch is due
+	schedule := func() {
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if next, ok := batches.Next(); ok {
+			timer.Reset(next.Sub(time.Now()))
+		}
+	}
 	for {
 		select {
 		case k := <-key:
-			timer.Reset(100 * time.Millisecond)
-			if maxStopped {
-				max.Reset(1 * time.Second)
-				maxStopped = false
+			// config may be swapped by a reload while the token is out
+			done := <-ready
+			prefix, watch, ok := getWatch(config, k)
+			ready <- done
+			if !ok {
+				prefix = k
 			}
-			keys[k] = struct{}{}
+			batches.Add(prefix, k, watch.Debounce.orDefaults(defaults), time.Now())
+			schedule()
 			continue
-		case <-max.C:
-			if !timer.Stop() {
-				<-timer.C
-			}
 		case <-timer.C:
-			if !max.Stop() {
-				<-max.C
-			}
 		}
-		maxStopped = true
+		aKeys := batches.Due(time.Now())
+		schedule()
+		if len(aKeys) == 0 {
+			continue
+		}
 		// remove item to indicate processing has begun
 		done := <-ready
-		aKeys := make([]string, 0, len(keys))
-		for key := range keys {
-			aKeys = append(aKeys, key)
+		tags := runTags(config, aKeys...)
+		if pending.Add(tags, aKeys) {
+			runs.Add(1)
+			go func() {
+				defer runs.Done()
+				locker.Lock(tags)
+				defer locker.Unlock(tags)
+				_ = runWithPolicy(policy, eaddr, pending.Take(tags), tags, hist, st)
+			}()
 		}
-		runAnsible(config, eaddr, aKeys...)
 		// return item to indicate processing has completed
 		ready <- done
-		keys = map[string]struct{}{}
 	}
 }
 
+// reloadConfig rereads the config file, adjusts the watched prefixes to match
+// and replaces the contents of config in place. The caller must hold the ready
+// token so that the consumer is not reading config while it changes. If the file
+// cannot be loaded, config and the watches are left untouched. If a watch
+// cannot be added or removed, those already changed are rolled back, and
+// config is left untouched; prefixes whose rollback fails too are logged, as
+// they are then watched, or not, contrary to config.
+func reloadConfig(path string, config Config, w *watcher.Watcher) error {
+	newConfig, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+
+	var added, removed []string
+	err = func() error {
+		for prefix := range newConfig {
+			if _, ok := config[prefix]; ok {
+				continue
+			}
+			if err := w.Add(prefix); err != nil {
+				return err
+			}
+			added = append(added, prefix)
+		}
+		for prefix := range config {
+			if _, ok := newConfig[prefix]; ok {
+				continue
+			}
+			if err := w.Remove(prefix); err != nil {
+				return err
+			}
+			removed = append(removed, prefix)
+		}
+		return nil
+	}()
+	if err != nil {
+		rollbackWatches(w, added, removed)
+		return err
+	}
+
+	for prefix := range config {
+		delete(config, prefix)
+	}
+	for prefix, watch := range newConfig {
+		config[prefix] = watch
+	}
+	return nil
+}
+
+// rollbackWatches undoes the watches added and removed by a failed reload,
+// logging those that could not be undone
+func rollbackWatches(w *watcher.Watcher, added, removed []string) {
+	for _, prefix := range added {
+		if err := w.Remove(prefix); err != nil {
+			log.WithFields(log.Fields{
+				"error":  err,
+				"func":   "watcher.Remove",
+				"prefix": prefix,
+			}).Error("failed to roll back added watch, prefix is watched but not configured")
+		}
+	}
+	for _, prefix := range removed {
+		if err := w.Add(prefix); err != nil {
+			log.WithFields(log.Fields{
+				"error":  err,
+				"func":   "watcher.Add",
+				"prefix": prefix,
+			}).Error("failed to roll back removed watch, prefix is configured but not watched")
+		}
+	}
+}
+
+// envKVAddr returns the kv address from the environment, which can only
+// override the default address
+func envKVAddr() string {
+	kvAddr := os.Getenv("NCONFIGD_KV_ADDRESS")
+	if kvAddr == "" {
+		kvAddr = os.Getenv("NCONFIGD_ETCD_ADDRESS")
+		if kvAddr == "" {
+			kvAddr = defaultKVAddr
+		}
+	}
+	return kvAddr
+}
+
 // watchKeys creates a new Watcher and adds all configured keys
 func watchKeys(config Config, kv kv.KV) *watcher.Watcher {
 	w, err := watcher.New(kv)
@@ -192,21 +373,42 @@ func watchKeys(config Config, kv kv.KV) *watcher.Watcher {
 }
 
 func main() {
-	// environment can only override default address
-	kvAddr := os.Getenv("NCONFIGD_KV_ADDRESS")
-	if kvAddr == "" {
-		kvAddr = os.Getenv("NCONFIGD_ETCD_ADDRESS")
-		if kvAddr == "" {
-			kvAddr = defaultKVAddr
-		}
+	if len(os.Args) > 1 && os.Args[1] == "runs" {
+		runsMain(os.Args[2:])
+		return
 	}
 
+	kvAddr := envKVAddr()
+
+	var logCfg logging.Config
 	logLevel := flag.StringP("log-level", "l", "warn", "log level")
+	logging.AddFlags(flag.CommandLine, &logCfg)
 	flag.StringVarP(&ansibleDir, "ansible", "a", ansibleDir, "directory containing the ansible run command")
 	flag.StringP("kv", "k", defaultKVAddr, "address of kv server")
+	flag.StringVar(&kvPrefix, "kv-prefix", kvPrefix, "root of the lochness keys in the kv, to share a kv cluster between lochness clusters")
 	configPath := flag.StringP("config", "c", "", "path to config file with prefixs")
 	once := flag.BoolP("once", "o", false, "run only once and then exit")
+	maxConcurrent := flag.UintP("max-concurrent", "m", 1, "maximum concurrent ansible runs. runs sharing a tag never overlap")
+	node := flag.StringP("node", "n", "", "name of this node in run history. defaults to hostname")
+	retain := flag.UintP("retain", "r", 100, "number of ansible runs to keep in run history. 0 disables history")
+	staggerSlots := flag.UintP("stagger", "s", 0, "maximum ansible runs at once across all nodes. 0 disables")
+	quietPeriod := flag.Duration("quiet-period", defaultQuietPeriod, "how long a watched prefix must go without changes before running ansible, unless set for the prefix in the config file")
+	maxDelay := flag.Duration("max-delay", defaultMaxDelay, "longest a change waits for ansible while its prefix keeps changing, unless set for the prefix in the config file")
+	port := flag.UintP("http", "p", 7547, "http port to publish metrics and health. set to 0 to disable")
+	policy := &failurePolicy{}
+	flag.IntVar(&policy.Retries, "retries", 2, "times a failed ansible run is retried")
+	flag.DurationVar(&policy.Backoff, "retry-backoff", 10*time.Second, "wait before the first retry of a failed ansible run, doubled for each retry after")
+	flag.IntVar(&policy.MaxConsecutive, "max-consecutive-failures", 5, "ansible runs in a row that may fail, retries included, before exiting. 0 never exits")
+	configFile := flag.String(lconfig.FlagName, "", lconfig.FlagUsage)
 	flag.Parse()
+
+	if err := lconfig.Load(lconfig.Pflag(flag.CommandLine), "nconfigd", *configFile); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "config.Load",
+			"file":  *configFile,
+		}).Fatal("failed to load config")
+	}
 	flag.Visit(func(f *flag.Flag) {
 		if f.Name == "kv" {
 			kvAddr = f.Value.String()
@@ -214,14 +416,21 @@ func main() {
 	})
 
 	// Set up logging
-	if err := logx.DefaultSetup(*logLevel); err != nil {
+	if err := logging.Setup(*logLevel, "nconfigd", logCfg); err != nil {
 		log.WithFields(log.Fields{
 			"error": err,
-			"func":  "logx.DefaultSetup",
+			"func":  "logging.Setup",
 			"level": *logLevel,
 		}).Fatal("failed to set up logging")
 	}
 
+	if *quietPeriod < 0 || *maxDelay < 0 {
+		log.WithFields(log.Fields{
+			"quietPeriod": *quietPeriod,
+			"maxDelay":    *maxDelay,
+		}).Fatal("debounce durations must not be negative")
+	}
+
 	// Load config containing prefixs to watch
 	config, err := loadConfig(*configPath)
 	if err != nil {
@@ -242,10 +451,26 @@ func main() {
 			"address": kvAddr,
 		}).Fatal("failed to connect to kv cluster")
 	}
+	e = kv.WithPrefix(e, kvPrefix)
+
+	hist, err := newNodeHistory(e, *node, int(*retain))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"node":  *node,
+		}).Fatal("failed to determine node name")
+	}
+
+	rand.Seed(time.Now().UnixNano())
+	st := newStagger(e, int(*staggerSlots))
+	policy.m = setupMetrics(*port, policy)
 
 	// always run initially
-	runAnsible(config, kvAddr, "")
+	err = runWithPolicy(policy, kvAddr, nil, nil, hist, st)
 	if *once {
+		if err != nil {
+			os.Exit(1)
+		}
 		return
 	}
 
@@ -257,16 +482,38 @@ func main() {
 	ready <- struct{}{}
 
 	// handle events
-	go consumeResponses(config, kvAddr, w, ready)
+	locker := newTagLocker(int(*maxConcurrent))
+	runs := &sync.WaitGroup{}
+	defaults := Debounce{QuietPeriod: *quietPeriod, MaxDelay: *maxDelay}
+	go consumeResponses(config, defaults, kvAddr, w, ready, locker, runs, hist, st, policy)
+
+	// handle signals for config reloads and clean shutdown
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+
+	for s := range sigs {
+		if s != syscall.SIGHUP {
+			log.WithField("signal", s).Info("signal received. waiting for current task to process")
+			break
+		}
 
-	// handle signals for clean shutdown
-	sigs := make(chan os.Signal)
-	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		log.WithField("signal", s).Info("signal received. reloading config")
+		// keep new runs from being started while swapping
+		done := <-ready
+		if err := reloadConfig(*configPath, config, w); err != nil {
+			log.WithFields(log.Fields{
+				"error":      err,
+				"configPath": *configPath,
+			}).Error("failed to reload config, keeping previous config")
+		} else {
+			log.WithField("config", config).Info("config reloaded")
+		}
+		ready <- done
+	}
 
-	s := <-sigs
-	log.WithField("signal", s).Info("signal received. waiting for current task to process")
 	// wait until any current processing is finished
 	<-ready
+	runs.Wait()
 	_ = w.Close()
 	log.Info("exiting")
 }
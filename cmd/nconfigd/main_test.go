@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness/pkg/kv"
+	_ "github.com/mistifyio/lochness/pkg/kv/mem"
+	"github.com/mistifyio/lochness/pkg/watcher"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestReloadConfig(t *testing.T) {
+	suite.Run(t, new(ReloadConfigSuite))
+}
+
+type ReloadConfigSuite struct {
+	suite.Suite
+	Dir     string
+	Watcher *watcher.Watcher
+}
+
+func (s *ReloadConfigSuite) SetupSuite() {
+	log.SetLevel(log.FatalLevel)
+}
+
+func (s *ReloadConfigSuite) SetupTest() {
+	var err error
+	s.Dir, err = ioutil.TempDir("", "nconfigd-reload-test")
+	s.Require().NoError(err)
+
+	store, err := kv.New("mem://")
+	s.Require().NoError(err)
+	s.Watcher, err = watcher.New(store)
+	s.Require().NoError(err)
+}
+
+func (s *ReloadConfigSuite) TearDownTest() {
+	_ = s.Watcher.Close()
+	_ = os.RemoveAll(s.Dir)
+}
+
+// configFile writes a config file
+func (s *ReloadConfigSuite) configFile(data string) string {
+	path := filepath.Join(s.Dir, "config.json")
+	s.Require().NoError(ioutil.WriteFile(path, []byte(data), 0644))
+	return path
+}
+
+func (s *ReloadConfigSuite) TestReload() {
+	config := Config{"/a": {}, "/b": {}}
+	for prefix := range config {
+		s.Require().NoError(s.Watcher.Add(prefix))
+	}
+
+	path := s.configFile(`{"/b": [], "/c": ["c"]}`)
+	s.Require().NoError(reloadConfig(path, config, s.Watcher))
+	s.Equal(Config{"/b": {Tags: Tags{}}, "/c": {Tags: Tags{"c"}}}, config)
+	s.NotNil(s.Watcher.Remove("/a"), "removed prefix should no longer be watched")
+	s.Nil(s.Watcher.Remove("/c"), "added prefix should be watched")
+}
+
+func (s *ReloadConfigSuite) TestRollback() {
+	// /a is not watched, so removing it fails once /c has been added
+	config := Config{"/a": {}, "/b": {}}
+	s.Require().NoError(s.Watcher.Add("/b"))
+
+	path := s.configFile(`{"/b": [], "/c": ["c"]}`)
+	s.Error(reloadConfig(path, config, s.Watcher))
+	s.Equal(Config{"/a": {}, "/b": {}}, config, "config should be left untouched")
+	s.NotNil(s.Watcher.Remove("/c"), "added prefix should be rolled back")
+	s.Nil(s.Watcher.Remove("/b"), "kept prefix should still be watched")
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// pendingRuns tracks the runs waiting for their tags, at most one per set of
+// tags. Keys of batches coming due while a run for their tags is waiting are
+// folded into it instead of queueing another run. A waiting full run takes the
+// keys of every batch, since it runs all tags anyway.
+type pendingRuns struct {
+	mu   sync.Mutex
+	runs map[string]map[string]struct{}
+}
+
+// newPendingRuns creates an empty pendingRuns
+func newPendingRuns() *pendingRuns {
+	return &pendingRuns{
+		runs: map[string]map[string]struct{}{},
+	}
+}
+
+// tagsID identifies a set of sorted tags, the empty string being a full run
+func tagsID(tags []string) string {
+	return strings.Join(tags, ",")
+}
+
+// Add folds keys into the waiting run for tags, or into a waiting full run,
+// returning false. If neither is waiting, a run for tags is added and true is
+// returned, the caller then being responsible for starting it.
+func (p *pendingRuns) Add(tags []string, keys []string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	run, ok := p.runs[tagsID(nil)]
+	if !ok {
+		run, ok = p.runs[tagsID(tags)]
+	}
+	created := !ok
+	if created {
+		run = map[string]struct{}{}
+		p.runs[tagsID(tags)] = run
+	}
+	for _, key := range keys {
+		run[key] = struct{}{}
+	}
+	return created
+}
+
+// Take removes the waiting run for tags, once it is about to start, and
+// returns its sorted keys. Keys added afterwards go to a new run.
+func (p *pendingRuns) Take(tags []string) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	id := tagsID(tags)
+	run := p.runs[id]
+	delete(p.runs, id)
+	keys := make([]string, 0, len(run))
+	for key := range run {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestPendingRuns(t *testing.T) {
+	suite.Run(t, new(PendingRunsSuite))
+}
+
+type PendingRunsSuite struct {
+	suite.Suite
+}
+
+func (s *PendingRunsSuite) TestFold() {
+	p := newPendingRuns()
+	s.True(p.Add([]string{"dns"}, []string{"/a"}), "first run for tags should be added")
+	s.False(p.Add([]string{"dns"}, []string{"/c", "/a"}), "keys should fold into the waiting run")
+	s.True(p.Add([]string{"dns", "network"}, []string{"/b"}), "other tags should get their own run")
+
+	s.Equal([]string{"/a", "/c"}, p.Take([]string{"dns"}))
+	s.True(p.Add([]string{"dns"}, []string{"/d"}), "keys after the run started should get a new run")
+	s.Equal([]string{"/d"}, p.Take([]string{"dns"}))
+	s.Equal([]string{"/b"}, p.Take([]string{"dns", "network"}))
+}
+
+func (s *PendingRunsSuite) TestFoldIntoFull() {
+	p := newPendingRuns()
+	s.True(p.Add(nil, []string{"/a"}))
+	s.False(p.Add([]string{"dns"}, []string{"/b"}), "keys should fold into a waiting full run")
+	s.False(p.Add([]string{}, []string{"/c"}))
+
+	s.Equal([]string{"/a", "/b", "/c"}, p.Take(nil))
+	s.Empty(p.Take([]string{"dns"}))
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness/pkg/kv"
+	flag "github.com/ogier/pflag"
+)
+
+// runsMain implements the runs subcommand, which lists the ansible run
+// history of a node or shows a single run in full
+func runsMain(args []string) {
+	kvAddr := envKVAddr()
+
+	flags := flag.NewFlagSet("nconfigd runs", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: nconfigd runs [options] [run id]")
+		flags.PrintDefaults()
+	}
+	flags.StringVarP(&kvAddr, "kv", "k", kvAddr, "address of kv server")
+	flags.StringVar(&kvPrefix, "kv-prefix", kvPrefix, "root of the lochness keys in the kv, to share a kv cluster between lochness clusters")
+	node := flags.StringP("node", "n", "", "node to show runs for. defaults to hostname")
+	_ = flags.Parse(args)
+
+	if flags.NArg() > 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	e, err := kv.New(kvAddr)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":   err,
+			"address": kvAddr,
+		}).Fatal("failed to connect to kv cluster")
+	}
+	e = kv.WithPrefix(e, kvPrefix)
+
+	hist, err := newNodeHistory(e, *node, 0)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"node":  *node,
+		}).Fatal("failed to determine node name")
+	}
+
+	if flags.NArg() == 1 {
+		showRun(e, hist.Node(), flags.Arg(0))
+		return
+	}
+
+	runs, err := listRuns(e, hist.Node())
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"node":  hist.Node(),
+		}).Fatal("failed to list runs")
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTART\tDURATION\tSTATUS\tTAGS\tKEYS")
+	for _, r := range runs {
+		tags := "all"
+		if len(r.Tags) > 0 {
+			tags = strings.Join(r.Tags, ",")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\n",
+			r.ID,
+			r.Start.Format(time.RFC3339),
+			r.End.Sub(r.Start),
+			r.ExitStatus,
+			tags,
+			strings.Join(r.Keys, ","),
+		)
+	}
+	_ = tw.Flush()
+}
+
+// showRun prints a single run record, including its output
+func showRun(e kv.KV, node, id string) {
+	r, err := getRun(e, node, id)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"node":  node,
+			"id":    id,
+		}).Fatal("failed to get run")
+	}
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"id":    id,
+		}).Fatal("failed to marshal run")
+	}
+	fmt.Println(string(data))
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"time"
+
+	"github.com/mistifyio/lochness/pkg/kv"
+	"github.com/mistifyio/lochness/pkg/lock"
+)
+
+// staggerPath is the kv prefix of the cluster wide run slots
+const staggerPath = "lochness/nconfigd/stagger/"
+
+// staggerTTL is how long a slot outlives a node that stopped renewing it
+const staggerTTL = 30 * time.Second
+
+// stagger limits how many ansible runs happen at once across the cluster.
+// Each run holds one slot of a semaphore in the kv, so a change watched by
+// every node is rolled out in waves.
+type stagger struct {
+	kv    kv.KV
+	slots int
+}
+
+// newStagger creates a stagger with slots concurrent runs. A slots of 0
+// disables staggering.
+func newStagger(k kv.KV, slots int) *stagger {
+	if slots <= 0 {
+		return nil
+	}
+	return &stagger{
+		kv:    k,
+		slots: slots,
+	}
+}
+
+// Acquire blocks until a run slot is free and returns it held. The slot
+// should be released once the run completes.
+func (st *stagger) Acquire() (*lock.Semaphore, error) {
+	if st == nil {
+		return nil, nil
+	}
+
+	sem, err := lock.NewSemaphore(st.kv, staggerPath, st.slots, staggerTTL)
+	if err != nil {
+		return nil, err
+	}
+	if err := sem.Acquire(0); err != nil {
+		return nil, err
+	}
+	return sem, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/mistifyio/lochness/pkg/lock"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestStagger(t *testing.T) {
+	suite.Run(t, new(StaggerSuite))
+}
+
+type StaggerSuite struct {
+	common.Suite
+}
+
+func (s *StaggerSuite) SetupSuite() {
+	s.Suite.SetupSuite()
+	lock.PollInterval = 100 * time.Millisecond
+}
+
+func (s *StaggerSuite) TestDisabled() {
+	st := newStagger(s.KV, 0)
+	s.Nil(st)
+	slot, err := st.Acquire()
+	s.NoError(err)
+	s.Nil(slot)
+}
+
+func (s *StaggerSuite) TestSlots() {
+	st := newStagger(s.KV, 2)
+
+	first, err := st.Acquire()
+	s.Require().NoError(err)
+	second, err := st.Acquire()
+	s.Require().NoError(err)
+	s.NotEqual(first.Slot(), second.Slot(), "should hold different slots")
+
+	acquired := make(chan struct{})
+	go func() {
+		third, err := st.Acquire()
+		if err == nil {
+			_ = third.Release()
+		}
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		s.Fail("third run should wait for a free slot")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	s.NoError(first.Release())
+	select {
+	case <-acquired:
+	case <-time.After(5 * time.Second):
+		s.Fail("third run should get the released slot")
+	}
+	s.NoError(second.Release())
+}
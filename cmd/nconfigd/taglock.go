@@ -0,0 +1,89 @@
+package main
+
+import "sync"
+
+// tagLocker limits concurrent ansible runs and serializes runs that share tags.
+// An empty tag list is a full playbook run and excludes every other run. Full
+// runs waiting to start go first, so that a steady stream of tagged runs cannot
+// starve them.
+type tagLocker struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	max     int
+	running int
+	full    bool
+	// fullWaiting is how many full runs are waiting in Lock
+	fullWaiting int
+	held        map[string]struct{}
+}
+
+// newTagLocker creates a tagLocker allowing up to max concurrent runs
+func newTagLocker(max int) *tagLocker {
+	if max < 1 {
+		max = 1
+	}
+	t := &tagLocker{
+		max:  max,
+		held: map[string]struct{}{},
+	}
+	t.cond = sync.NewCond(&t.mu)
+	return t
+}
+
+// available returns whether a run with tags may start. mu must be held.
+func (t *tagLocker) available(tags []string) bool {
+	if t.running >= t.max || t.full {
+		return false
+	}
+	if len(tags) == 0 {
+		return t.running == 0
+	}
+	if t.fullWaiting > 0 {
+		return false
+	}
+	for _, tag := range tags {
+		if _, ok := t.held[tag]; ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Lock blocks until a run with tags may start
+func (t *tagLocker) Lock(tags []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(tags) == 0 {
+		t.fullWaiting++
+	}
+	for !t.available(tags) {
+		t.cond.Wait()
+	}
+	if len(tags) == 0 {
+		t.fullWaiting--
+	}
+
+	t.running++
+	if len(tags) == 0 {
+		t.full = true
+	}
+	for _, tag := range tags {
+		t.held[tag] = struct{}{}
+	}
+}
+
+// Unlock releases the tags held by a run started with Lock
+func (t *tagLocker) Unlock(tags []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.running--
+	if len(tags) == 0 {
+		t.full = false
+	}
+	for _, tag := range tags {
+		delete(t.held, tag)
+	}
+	t.cond.Broadcast()
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestTagLocker(t *testing.T) {
+	suite.Run(t, new(TagLockerSuite))
+}
+
+type TagLockerSuite struct {
+	suite.Suite
+}
+
+// acquired starts a Lock in the background and reports whether it was
+// obtained within a short wait
+func (s *TagLockerSuite) acquired(t *tagLocker, tags []string) (bool, chan struct{}) {
+	locked := make(chan struct{})
+	go func() {
+		t.Lock(tags)
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		return true, locked
+	case <-time.After(50 * time.Millisecond):
+		return false, locked
+	}
+}
+
+func (s *TagLockerSuite) TestDisjointTags() {
+	t := newTagLocker(2)
+	t.Lock([]string{"dns"})
+	ok, _ := s.acquired(t, []string{"network"})
+	s.True(ok, "disjoint tags should run concurrently")
+}
+
+func (s *TagLockerSuite) TestSharedTags() {
+	t := newTagLocker(2)
+	t.Lock([]string{"dns", "dhcpd"})
+	ok, locked := s.acquired(t, []string{"dhcpd"})
+	s.False(ok, "shared tag should wait")
+
+	t.Unlock([]string{"dns", "dhcpd"})
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		s.Fail("should acquire after unlock")
+	}
+}
+
+func (s *TagLockerSuite) TestMaxConcurrent() {
+	t := newTagLocker(1)
+	t.Lock([]string{"dns"})
+	ok, locked := s.acquired(t, []string{"network"})
+	s.False(ok, "should not exceed max concurrent runs")
+
+	t.Unlock([]string{"dns"})
+	<-locked
+}
+
+func (s *TagLockerSuite) TestFullRun() {
+	t := newTagLocker(3)
+	t.Lock([]string{"dns"})
+	ok, locked := s.acquired(t, nil)
+	s.False(ok, "full run should wait for all runs")
+
+	t.Unlock([]string{"dns"})
+	<-locked
+
+	ok, _ = s.acquired(t, []string{"network"})
+	s.False(ok, "tagged run should wait for full run")
+}
+
+func (s *TagLockerSuite) TestFullRunFirst() {
+	t := newTagLocker(3)
+	t.Lock([]string{"dns"})
+	fullOK, fullLocked := s.acquired(t, nil)
+	s.False(fullOK, "full run should wait for all runs")
+
+	ok, locked := s.acquired(t, []string{"network"})
+	s.False(ok, "tagged run should wait behind a waiting full run")
+
+	t.Unlock([]string{"dns"})
+	<-fullLocked
+	select {
+	case <-locked:
+		s.Fail("tagged run should wait for the full run")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	t.Unlock(nil)
+	<-locked
+}
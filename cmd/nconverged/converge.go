@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/pkg/agent"
+)
+
+// converger converges the guests of a hypervisor's agent on the hypervisor's
+// desired state
+type converger struct {
+	hypervisor *lochness.Hypervisor
+	agent      agent.GuestAgent
+	// jobPoll is how often agent jobs are checked, and jobTimeout how long
+	// they may take
+	jobPoll    time.Duration
+	jobTimeout time.Duration
+	// path is the file the state is persisted to, if any
+	path  string
+	state convergeState
+}
+
+// convergeState is what the converger has done so far, persisted so that a
+// restart neither converges again on a generation already acked nor forgets
+// the guests to delete once they are no longer desired
+type convergeState struct {
+	// Generation is the last generation converged on, and Synced whether
+	// converging on it succeeded
+	Generation uint64 `json:"generation"`
+	Synced     bool   `json:"synced"`
+	// Guests are the guests of the desired state the agent has
+	Guests map[string]bool `json:"guests"`
+}
+
+// newConverger creates a converger, restoring its state from path if set
+func newConverger(hypervisor *lochness.Hypervisor, guestAgent agent.GuestAgent, path string) (*converger, error) {
+	c := &converger{
+		hypervisor: hypervisor,
+		agent:      guestAgent,
+		jobPoll:    time.Second,
+		jobTimeout: 30 * time.Minute,
+		path:       path,
+		state:      convergeState{Guests: map[string]bool{}},
+	}
+	if path == "" {
+		return c, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &c.state); err != nil {
+		return nil, err
+	}
+	if c.state.Guests == nil {
+		c.state.Guests = map[string]bool{}
+	}
+	return c, nil
+}
+
+// sync waits up to wait for a generation of the desired state newer than the
+// last one converged on, converges on it, and acks the result. A generation
+// that failed is converged on again right away. The error of converging is
+// returned after it has been acked.
+func (c *converger) sync(wait time.Duration) error {
+	if !c.state.Synced {
+		wait = 0
+	}
+	desired, err := c.hypervisor.WaitDesiredState(c.state.Generation, wait)
+	if err != nil {
+		return err
+	}
+	if desired.Generation == c.state.Generation && c.state.Synced {
+		return nil
+	}
+
+	result := c.converge(desired)
+	c.state.Generation = desired.Generation
+	c.state.Synced = result == nil
+	if err := c.save(); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "converger.save",
+			"path":  c.path,
+		}).Error("failed to persist the converge state")
+	}
+
+	if err := c.hypervisor.AckDesiredState(desired.Generation, result); err != nil {
+		return err
+	}
+	return result
+}
+
+// converge creates the desired guests the agent does not have and deletes the
+// guests it has that are no longer desired. Soft deleted guests are left as
+// they are, so they can be restored. Guests that fail are logged and retried
+// with the next sync.
+func (c *converger) converge(desired *lochness.DesiredState) error {
+	var failed []string
+	wanted := make(map[string]bool, len(desired.Guests))
+	for _, guest := range desired.Guests {
+		wanted[guest.ID] = true
+		if guest.IsDeleted() {
+			continue
+		}
+		if err := c.ensureGuest(guest); err != nil {
+			log.WithFields(log.Fields{
+				"error":      err,
+				"func":       "converger.ensureGuest",
+				"guest":      guest.ID,
+				"generation": desired.Generation,
+			}).Error("failed to create guest")
+			failed = append(failed, fmt.Sprintf("create %s: %s", guest.ID, err))
+			continue
+		}
+		c.state.Guests[guest.ID] = true
+	}
+
+	unwanted := make([]string, 0, len(c.state.Guests))
+	for guestID := range c.state.Guests {
+		if !wanted[guestID] {
+			unwanted = append(unwanted, guestID)
+		}
+	}
+	sort.Strings(unwanted)
+	for _, guestID := range unwanted {
+		if err := c.deleteGuest(guestID); err != nil {
+			log.WithFields(log.Fields{
+				"error":      err,
+				"func":       "converger.deleteGuest",
+				"guest":      guestID,
+				"generation": desired.Generation,
+			}).Error("failed to delete guest")
+			failed = append(failed, fmt.Sprintf("delete %s: %s", guestID, err))
+			continue
+		}
+		delete(c.state.Guests, guestID)
+	}
+
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// ensureGuest creates a guest unless the agent already has it. Images are
+// fetched first, except for clones, whose disks are cloned from their
+// source's.
+func (c *converger) ensureGuest(guest *lochness.Guest) error {
+	_, err := c.agent.FetchState(guest.ID)
+	if err == nil || !agent.IsGuestNotFound(err) {
+		return err
+	}
+
+	if guest.CloneOf != "" {
+		return c.run(guest.ID, c.agent.Clone)
+	}
+	if err := c.run(guest.ID, c.agent.FetchImage); err != nil {
+		return err
+	}
+	return c.run(guest.ID, c.agent.Create)
+}
+
+// deleteGuest deletes a guest unless the agent no longer has it
+func (c *converger) deleteGuest(guestID string) error {
+	err := c.run(guestID, c.agent.Delete)
+	if agent.IsGuestNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// run starts an agent job for a guest and waits for it to complete
+func (c *converger) run(guestID string, start func(string) (string, error)) error {
+	jobID, err := start(guestID)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(c.jobTimeout)
+	for {
+		done, err := c.agent.JobStatus(guestID, jobID)
+		if done || err != nil {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("agent job %s timed out", jobID)
+		}
+		time.Sleep(c.jobPoll)
+	}
+}
+
+// save persists the state of the converger, if it is persisted. The file is
+// replaced through a temporary file so that it is never left half written.
+func (c *converger) save() error {
+	if c.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(c.state)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(c.path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, filepath.Base(c.path)+".")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path)
+}
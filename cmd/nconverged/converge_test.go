@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/mistifyio/lochness/pkg/agent"
+	"github.com/mistifyio/mistify-agent/client"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestConverger(t *testing.T) {
+	suite.Run(t, new(ConvergerSuite))
+}
+
+type ConvergerSuite struct {
+	common.Suite
+	Dir        string
+	Hypervisor *lochness.Hypervisor
+	Guest      *lochness.Guest
+	Agent      *agent.Mock
+	Converger  *converger
+}
+
+func (s *ConvergerSuite) SetupTest() {
+	s.Suite.SetupTest()
+
+	var err error
+	s.Dir, err = ioutil.TempDir("", "nconverged-test")
+	s.Require().NoError(err)
+
+	s.Hypervisor, s.Guest = s.NewHypervisorWithGuest()
+	s.Agent = agent.NewMock()
+	s.Converger = s.newConverger()
+}
+
+func (s *ConvergerSuite) TearDownTest() {
+	_ = os.RemoveAll(s.Dir)
+	s.Suite.TearDownTest()
+}
+
+// newConverger creates a converger persisted in the test dir
+func (s *ConvergerSuite) newConverger() *converger {
+	c, err := newConverger(s.Hypervisor, s.Agent, filepath.Join(s.Dir, "state.json"))
+	s.Require().NoError(err)
+	c.jobPoll = time.Millisecond
+	return c
+}
+
+// acked returns the generation and error acked by the hypervisor
+func (s *ConvergerSuite) acked() (uint64, string) {
+	ack, err := s.Hypervisor.DesiredStateAck()
+	s.Require().NoError(err)
+	s.Require().NotNil(ack, "desired state should be acked")
+	return ack.Generation, ack.Error
+}
+
+func (s *ConvergerSuite) TestCreate() {
+	s.Require().NoError(s.Converger.sync(0))
+
+	_, err := s.Agent.FetchState(s.Guest.ID)
+	s.NoError(err, "desired guest should be created")
+	s.True(s.Agent.ImageFetched(s.Guest.ID), "image should be fetched before the guest is created")
+
+	generation, ackErr := s.acked()
+	s.Equal(s.Converger.state.Generation, generation)
+	s.Empty(ackErr)
+	s.True(s.Converger.state.Synced)
+}
+
+func (s *ConvergerSuite) TestExisting() {
+	s.Agent.AddGuest(&client.Guest{ID: s.Guest.ID, State: agent.StateRunning})
+	s.Agent.SetError(agent.OpCreate, errors.New("should not be created"))
+
+	s.NoError(s.Converger.sync(0))
+	s.True(s.Converger.state.Guests[s.Guest.ID], "existing guest should be tracked")
+}
+
+func (s *ConvergerSuite) TestDelete() {
+	s.Require().NoError(s.Converger.sync(0))
+	s.Require().NoError(s.Hypervisor.RemoveGuest(s.Guest))
+
+	s.Require().NoError(s.Converger.sync(time.Second))
+	_, err := s.Agent.FetchState(s.Guest.ID)
+	s.True(agent.IsGuestNotFound(err), "guest no longer desired should be deleted")
+	s.NotContains(s.Converger.state.Guests, s.Guest.ID)
+
+	// guests the agent already lost are not an error
+	s.Converger.state.Guests[uuid.New()] = true
+	_, err = s.Hypervisor.BumpGeneration()
+	s.Require().NoError(err)
+	s.NoError(s.Converger.sync(time.Second))
+	s.Empty(s.Converger.state.Guests)
+}
+
+func (s *ConvergerSuite) TestSoftDeleted() {
+	s.Require().NoError(s.Converger.sync(0))
+	s.Require().NoError(s.Guest.SoftDelete(time.Hour))
+
+	s.Require().NoError(s.Converger.sync(time.Second))
+	_, err := s.Agent.FetchState(s.Guest.ID)
+	s.NoError(err, "soft deleted guest should be kept")
+	s.True(s.Converger.state.Guests[s.Guest.ID])
+}
+
+func (s *ConvergerSuite) TestFailure() {
+	s.Agent.SetError(agent.OpCreate, errors.New("no space"))
+
+	s.Error(s.Converger.sync(time.Second))
+	_, ackErr := s.acked()
+	s.Contains(ackErr, "no space", "failure should be acked")
+	s.False(s.Converger.state.Synced)
+
+	// a failed generation is converged on again without waiting
+	s.Agent.SetError(agent.OpCreate, nil)
+	start := time.Now()
+	s.NoError(s.Converger.sync(time.Minute))
+	s.True(time.Since(start) < time.Minute)
+	_, ackErr = s.acked()
+	s.Empty(ackErr)
+}
+
+func (s *ConvergerSuite) TestRestore() {
+	s.Require().NoError(s.Converger.sync(0))
+	s.Agent.SetError(agent.OpFetchState, errors.New("should not be converged again"))
+
+	c := s.newConverger()
+	s.Equal(s.Converger.state, c.state, "state should be restored")
+	s.NoError(c.sync(0), "acked generation should not be converged on again")
+}
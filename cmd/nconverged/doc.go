@@ -0,0 +1,39 @@
+/*
+nconverged converges the guests of the hypervisor node on its desired state.
+It long-polls the desired state of the hypervisor from the kv, has the local
+agent create the guests assigned to the hypervisor that it does not have and
+delete those that are no longer assigned, and acks each generation it
+converges on, with the errors of the guests that failed if any. A generation
+that failed is converged on again after --retry. Run cworkerd with
+--desired-state to have guest create and delete jobs completed by the acks.
+
+Usage
+
+The following arguments are understood:
+
+	$ nconverged -h
+	Usage of nconverged:
+	-a, --agent-port=8080: port on which the agent listens
+	    --config-file="": yaml or toml file of flag settings, overridden by the environment and the command line
+	-d, --id="": hypervisor id
+	-k, --kv="http://localhost:4001": address of kv machine
+	    --kv-prefix="/lochness": root of the lochness keys in the kv, to share a kv cluster between lochness clusters
+	-l, --log-level="info": log level
+	    --log-max-backups=5: number of rotated log files kept
+	    --log-max-size=100: megabytes a log file grows to before it is rotated, 0 to never rotate it
+	    --log-output="stdout": where to log: stdout, stderr, syslog, journald, or the path of a file
+	-r, --retry=30s: how long to wait to converge again after failing to
+	-s, --state-file="/var/lib/nconverged/state.json": file the generation converged on and the guests created are persisted to
+	-w, --wait=5m0s: how long to wait for a new desired state before checking again
+
+Guests
+
+Images are fetched before guests are created, except for clones, whose disks
+are cloned from their source's. Guests the agent already has are left as they
+are. Only guests that have been in the desired state are deleted, so guests
+created otherwise are never touched, and soft deleted guests are kept until
+they are purged. The last generation converged on and the guests of the desired
+state the agent has are persisted to --state-file, so a restart neither
+converges again on a generation already acked nor forgets the guests to delete.
+*/
+package main
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/config"
+	"github.com/mistifyio/lochness/internal/logging"
+	"github.com/mistifyio/lochness/pkg/agent"
+	"github.com/mistifyio/lochness/pkg/kv"
+	_ "github.com/mistifyio/lochness/pkg/kv/bolt"
+	_ "github.com/mistifyio/lochness/pkg/kv/consul"
+	flag "github.com/ogier/pflag"
+)
+
+func main() {
+	kvAddr := flag.StringP("kv", "k", "http://localhost:4001", "address of kv machine")
+	kvPrefix := flag.String("kv-prefix", kv.DefaultPrefix, "root of the lochness keys in the kv, to share a kv cluster between lochness clusters")
+	id := flag.StringP("id", "d", "", "hypervisor id")
+	agentPort := flag.UintP("agent-port", "a", uint(lochness.AgentPort), "port on which the agent listens")
+	wait := flag.DurationP("wait", "w", 5*time.Minute, "how long to wait for a new desired state before checking again")
+	retry := flag.DurationP("retry", "r", 30*time.Second, "how long to wait to converge again after failing to")
+	stateFile := flag.StringP("state-file", "s", "/var/lib/nconverged/state.json", "file the generation converged on and the guests created are persisted to")
+	var logCfg logging.Config
+	logLevel := flag.StringP("log-level", "l", "info", "log level")
+	logging.AddFlags(flag.CommandLine, &logCfg)
+	configFile := flag.String(config.FlagName, "", config.FlagUsage)
+	flag.Parse()
+
+	if err := config.Load(config.Pflag(flag.CommandLine), "nconverged", *configFile); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "config.Load",
+			"file":  *configFile,
+		}).Fatal("failed to load config")
+	}
+
+	if err := logging.Setup(*logLevel, "nconverged", logCfg); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "logging.Setup",
+			"level": logLevel,
+		}).Fatal("failed to set up logging")
+	}
+
+	KV, err := kv.New(*kvAddr)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "kv.New",
+			"id":    id,
+		}).Fatal("failed to connect to kv")
+	}
+	KV = kv.WithPrefix(KV, *kvPrefix)
+
+	c := lochness.NewContext(KV)
+
+	hn, err := lochness.SetHypervisorID(*id)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "lochness.SetHypervisorID",
+			"id":    id,
+		}).Fatal("failed to set hypervisor id")
+	}
+
+	hv, err := c.Hypervisor(hn)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "context.Hypervisor",
+			"id":    hn,
+		}).Fatal("failed to instantiate hypervisor")
+	}
+
+	// guests no longer assigned to the hypervisor are deleted too, so the
+	// agent is not looked up through their assignment
+	guestAgent := agent.NewMistify(c, int(*agentPort)).WithHost(hv.IP.String())
+	conv, err := newConverger(hv, guestAgent, *stateFile)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "newConverger",
+			"path":  *stateFile,
+		}).Fatal("failed to restore the converge state")
+	}
+
+	for {
+		if err := conv.sync(*wait); err != nil {
+			log.WithFields(log.Fields{
+				"error":      err,
+				"func":       "converger.sync",
+				"generation": conv.state.Generation,
+				"retry":      retry.String(),
+			}).Error("failed to converge on the desired state")
+			time.Sleep(*retry)
+		}
+	}
+}
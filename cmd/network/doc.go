@@ -0,0 +1,85 @@
+/*
+network is the command line interface to the networks of cnetworkd, the network
+configuration management service. network can show and set the VLAN ranges of
+networks, from which tags are allocated to their subnets, and instantiate the
+built-in firewall profiles for tenants.
+
+All commands support dual output formats, a tree like output for humans
+(default) or a json output for further processing.
+
+Usage
+
+The following arguments are understood:
+
+	$ network -h
+	network is the cli interface to the networks and firewall profiles of cnetworkd. All commands support arguments via command line or stdin
+
+	Usage:
+	  network [flags]
+	  network [command]
+
+	Available Commands:
+	  completion  Generate shell completion scripts
+	  fwprofiles  List the built-in firewall profiles
+	  help        Help about any command
+	  vlans       Show the VLAN pools of networks
+
+	Flags:
+	      --dial-timeout duration   how long connecting to the server or proxy may take, 0 for the default of 30s
+	  -h, --help                    help for network
+	  -j, --json                    output in json
+	      --proxy string            url of the proxy to connect through, instead of those of $HTTP_PROXY, $HTTPS_PROXY, and $NO_PROXY, or "none" to connect directly
+	  -s, --server string           server address to connect to (default "http://localhost:19000")
+
+	Use "network [command] --help" for more information about a command.
+
+Exit Codes
+
+network exits 0 on success and otherwise with:
+
+	1  error       any other failure
+	2  usage       bad arguments, flags, ids, or ranges
+	3  not_found   the server has no such resource
+	4  validation  a request the server rejected as invalid, e.g. overlapping ranges
+	5  server      the server could not be reached, failed, or sent a bad response
+
+The last line written to stderr of a failed run is a json object describing
+the failure, with its kind under "error":
+
+	{"error":"not_found","exit_code":3,"message":"failed to get vlan pool","status":404,"fields":{...}}
+
+Examples
+
+Show the VLAN pool of a network
+
+	$ network vlans 8e7b3d4c-47d6-4d1f-a3c7-0bb0fc7cf4a7
+	8e7b3d4c-47d6-4d1f-a3c7-0bb0fc7cf4a7 100-199 2/100 allocated, 98 available
+	├── 100:a3fd8c1e-62a4-4be1-b0d3-8d9a1fd1b5c2
+	└── 101:c6430cba-648a-41aa-aee4-b59dacfc790d
+
+Set the VLAN ranges of a network
+
+	$ network vlans set 8e7b3d4c-47d6-4d1f-a3c7-0bb0fc7cf4a7 100-199,300
+	8e7b3d4c-47d6-4d1f-a3c7-0bb0fc7cf4a7 100-199,300-300 2/101 allocated, 99 available
+	├── 100:a3fd8c1e-62a4-4be1-b0d3-8d9a1fd1b5c2
+	└── 101:c6430cba-648a-41aa-aee4-b59dacfc790d
+
+	$ network -j vlans 8e7b3d4c-47d6-4d1f-a3c7-0bb0fc7cf4a7
+	{"allocated":{"100":"a3fd8c1e-62a4-4be1-b0d3-8d9a1fd1b5c2","101":"c6430cba-648a-41aa-aee4-b59dacfc790d"},"available":99,"network":"8e7b3d4c-47d6-4d1f-a3c7-0bb0fc7cf4a7","total":101,"vlan_ranges":[{"end":199,"start":100},{"end":300,"start":300}]}
+
+List the firewall profiles
+
+	$ network fwprofiles
+	internal-only	any tcp and udp traffic from the tenant's guests in the group
+	isolated	no incoming traffic
+	web	http and https from anywhere
+
+Instantiate a firewall profile for a tenant, and get its fwgroup later on
+
+	$ network fwprofiles create web acme
+	2c3f4b6e-0f56-4b4e-9a49-4c0f8e4f7a11
+
+	$ network -j fwprofiles get web acme
+	{"id":"2c3f4b6e-0f56-4b4e-9a49-4c0f8e4f7a11","metadata":{"tenant":"acme"},"profile":{"created":"2026-10-16T09:12:44Z","name":"web","tenant":"acme"},"rules":[{"action":"accept","portEnd":80,"portStart":80,"protocol":"tcp","source":"0.0.0.0/0"},{"action":"accept","portEnd":443,"portStart":443,"protocol":"tcp","source":"0.0.0.0/0"}]}
+*/
+package main
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness/internal/cli"
+	"github.com/spf13/cobra"
+)
+
+var (
+	server  = "http://localhost:19000"
+	jsonout = false
+
+	transportOpts = cli.TransportOptions{}
+)
+
+// vlanRange is a range of VLAN tags as cnetworkd takes them
+type vlanRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// newClient creates a client for the server, connecting as set by --proxy and
+// --dial-timeout
+func newClient() *cli.Client {
+	c := cli.NewClient(server)
+	if err := c.ConfigureTransport(transportOpts); err != nil {
+		cli.Fatal(cli.ExitUsage, log.Fields{
+			"proxy":        transportOpts.Proxy,
+			"dial-timeout": transportOpts.DialTimeout,
+			"error":        err,
+		}, "invalid transport settings")
+	}
+	return c
+}
+
+func help(cmd *cobra.Command, _ []string) {
+	if err := cmd.Help(); err != nil {
+		cli.Fatal(cli.ExitError, log.Fields{"error": err}, "help")
+	}
+}
+
+// parseRanges parses VLAN ranges such as "100-199,300", single tags being
+// ranges of one
+func parseRanges(arg string) ([]vlanRange, error) {
+	ranges := []vlanRange{}
+	for _, part := range strings.Split(arg, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		bounds := strings.SplitN(part, "-", 2)
+		start, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid range %q", part)
+		}
+		end := start
+		if len(bounds) == 2 {
+			if end, err = strconv.Atoi(bounds[1]); err != nil {
+				return nil, fmt.Errorf("invalid range %q", part)
+			}
+		}
+		ranges = append(ranges, vlanRange{Start: start, End: end})
+	}
+	return ranges, nil
+}
+
+func getPool(c *cli.Client, id string) cli.JMap {
+	pool, _ := c.Get("vlan pool", "networks/"+id+"/vlans")
+	return pool
+}
+
+func setPool(c *cli.Client, id string, ranges []vlanRange) cli.JMap {
+	body, err := json.Marshal(ranges)
+	if err != nil {
+		cli.Fatal(cli.ExitError, log.Fields{"error": err}, "failed to marshal ranges")
+	}
+	pool, _ := c.Post("vlan pool", "networks/"+id+"/vlans", string(body))
+	return pool
+}
+
+// printPool prints the ranges and tag counts of the VLAN pool of a network,
+// followed by a tree of the allocated tags and the subnets they belong to
+func printPool(p cli.JMap) {
+	if jsonout {
+		p.Print(jsonout)
+		return
+	}
+
+	ranges := []string{}
+	rs, _ := p["vlan_ranges"].([]interface{})
+	for _, r := range rs {
+		r, _ := r.(map[string]interface{})
+		ranges = append(ranges, fmt.Sprintf("%v-%v", r["start"], r["end"]))
+	}
+	allocated, _ := p["allocated"].(map[string]interface{})
+	fmt.Printf("%s %s %d/%v allocated, %v available\n",
+		p["network"], strings.Join(ranges, ","), len(allocated), p["total"], p["available"])
+
+	if len(allocated) == 0 {
+		return
+	}
+	tags := make([]int, 0, len(allocated))
+	for tag := range allocated {
+		t, _ := strconv.Atoi(tag)
+		tags = append(tags, t)
+	}
+	sort.Ints(tags)
+
+	for _, tag := range tags[:len(tags)-1] {
+		fmt.Print("├── ", tag, ":", allocated[strconv.Itoa(tag)], "\n")
+	}
+	tag := tags[len(tags)-1]
+	fmt.Print("└── ", tag, ":", allocated[strconv.Itoa(tag)], "\n")
+}
+
+func vlans(cmd *cobra.Command, ids []string) {
+	c := newClient()
+	if len(ids) == 0 {
+		ids = cli.Read(os.Stdin)
+	}
+
+	for _, id := range ids {
+		cli.AssertID(id)
+		printPool(getPool(c, id))
+	}
+}
+
+func vlansSet(cmd *cobra.Command, args []string) {
+	c := newClient()
+	if len(args) == 0 {
+		args = cli.Read(os.Stdin)
+	}
+	if len(args)%2 != 0 {
+		cli.Fatal(cli.ExitUsage, log.Fields{"num": len(args)}, "expected an even amount of args")
+	}
+
+	for i := 0; i < len(args); i += 2 {
+		id := args[i]
+		cli.AssertID(id)
+		ranges, err := parseRanges(args[i+1])
+		if err != nil {
+			cli.Fatal(cli.ExitUsage, log.Fields{"ranges": args[i+1], "error": err}, "invalid ranges")
+		}
+
+		printPool(setPool(c, id, ranges))
+	}
+}
+
+func fwprofiles(cmd *cobra.Command, _ []string) {
+	c := newClient()
+	profiles, _ := c.GetMany("firewall profiles", "fwprofiles")
+	for _, p := range profiles {
+		if jsonout {
+			cli.JMap(p).Print(jsonout)
+			continue
+		}
+		fmt.Printf("%s\t%s\n", p["name"], p["description"])
+	}
+}
+
+// fwprofileArgs calls fn with each pair of profile and tenant of the args
+func fwprofileArgs(args []string, fn func(profile, tenant string)) {
+	if len(args) == 0 {
+		args = cli.Read(os.Stdin)
+	}
+	if len(args)%2 != 0 {
+		cli.Fatal(cli.ExitUsage, log.Fields{"num": len(args)}, "expected an even amount of args")
+	}
+
+	for i := 0; i < len(args); i += 2 {
+		profile, tenant := args[i], args[i+1]
+		if strings.Contains(profile, "/") || strings.Contains(tenant, "/") {
+			cli.Fatal(cli.ExitUsage, log.Fields{"profile": profile, "tenant": tenant}, "invalid profile or tenant")
+		}
+		fn(profile, tenant)
+	}
+}
+
+func fwprofilesGet(cmd *cobra.Command, args []string) {
+	c := newClient()
+	fwprofileArgs(args, func(profile, tenant string) {
+		fwgroup, _ := c.Get("fwgroup", "fwprofiles/"+profile+"/"+tenant)
+		cli.JMap(fwgroup).Print(jsonout)
+	})
+}
+
+func fwprofilesCreate(cmd *cobra.Command, args []string) {
+	c := newClient()
+	fwprofileArgs(args, func(profile, tenant string) {
+		fwgroup, _ := c.Post("fwgroup", "fwprofiles/"+profile+"/"+tenant, "")
+		cli.JMap(fwgroup).Print(jsonout)
+	})
+}
+
+func main() {
+	root := &cobra.Command{
+		Use:  "network",
+		Long: "network is the cli interface to the networks and firewall profiles of cnetworkd. All commands support arguments via command line or stdin",
+		Run:  help,
+	}
+	root.PersistentFlags().BoolVarP(&jsonout, "json", "j", jsonout, "output in json")
+	root.PersistentFlags().StringVarP(&server, "server", "s", server, "server address to connect to")
+	transportOpts.AddFlags(root.PersistentFlags())
+
+	cmdVLANs := &cobra.Command{
+		Use:   "vlans <network>...",
+		Short: "Show the VLAN pools of networks",
+		Long: `Show the VLAN ranges of each network, how many of their tags are allocated
+and available, and the subnet each allocated tag belongs to.`,
+		Run: vlans,
+	}
+
+	cmdVLANsSet := &cobra.Command{
+		Use:   "set <network> <ranges>...",
+		Short: "Set the VLAN ranges of networks",
+		Long: `Set the VLAN ranges of each network, given as tags and ranges of tags,
+e.g. "100-199,300". Tags already allocated to subnets stay allocated.`,
+		Run: vlansSet,
+	}
+	cmdVLANs.AddCommand(cmdVLANsSet)
+
+	cmdFWProfiles := &cobra.Command{
+		Use:   "fwprofiles",
+		Short: "List the built-in firewall profiles",
+		Run:   fwprofiles,
+	}
+
+	cmdFWProfilesGet := &cobra.Command{
+		Use:   "get <profile> <tenant>...",
+		Short: "Get the fwgroups of firewall profiles for tenants",
+		Run:   fwprofilesGet,
+	}
+
+	cmdFWProfilesCreate := &cobra.Command{
+		Use:   "create <profile> <tenant>...",
+		Short: "Instantiate firewall profiles for tenants",
+		Long: `Instantiate each firewall profile for its tenant, creating a fwgroup with
+the profile's rules. A profile is instantiated once per tenant.`,
+		Run: fwprofilesCreate,
+	}
+	cmdFWProfiles.AddCommand(cmdFWProfilesGet, cmdFWProfilesCreate)
+
+	root.AddCommand(cmdVLANs, cmdFWProfiles, cli.CompletionCmd(root))
+	if err := root.Execute(); err != nil {
+		cli.Fatal(cli.ExitUsage, log.Fields{"error": err}, "failed to execute root command")
+	}
+}
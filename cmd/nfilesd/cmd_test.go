@@ -0,0 +1,88 @@
+package main_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestNFilesd(t *testing.T) {
+	suite.Run(t, new(CmdSuite))
+}
+
+type CmdSuite struct {
+	common.Suite
+	Dir        string
+	ConfigPath string
+	FilePath   string
+	BinName    string
+	Hypervisor *lochness.Hypervisor
+}
+
+func (s *CmdSuite) SetupSuite() {
+	s.ExternalKV = true
+	s.Suite.SetupSuite()
+	s.Require().NoError(common.Build(), "failed to build nfilesd")
+	s.BinName = "nfilesd"
+}
+
+func (s *CmdSuite) SetupTest() {
+	s.Suite.SetupTest()
+
+	var err error
+	s.Dir, err = ioutil.TempDir("", "nfilesdTest-")
+	s.Require().NoError(err)
+	s.FilePath = filepath.Join(s.Dir, "hypervisor")
+	s.ConfigPath = filepath.Join(s.Dir, "config.json")
+	s.Require().NoError(ioutil.WriteFile(filepath.Join(s.Dir, "hypervisor.tmpl"), []byte("{{.Hypervisor.ID}}"), 0644))
+	s.Require().NoError(ioutil.WriteFile(s.ConfigPath, []byte(`{"files": [{"path": "`+s.FilePath+`", "template": "hypervisor.tmpl"}]}`), 0644))
+
+	s.Hypervisor = s.NewHypervisor()
+}
+
+func (s *CmdSuite) TearDownTest() {
+	_ = os.RemoveAll(s.Dir)
+	s.Suite.TearDownTest()
+}
+
+func (s *CmdSuite) TestCmd() {
+	tests := []struct {
+		description string
+		id          string
+		expectedErr bool
+	}{
+		{"invalid id", "asdf", true},
+		{"no hypervisor for id", uuid.New(), true},
+		{"valid", s.Hypervisor.ID, false},
+	}
+
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		args := []string{
+			"-k", s.KVURL,
+			"-c", s.ConfigPath,
+			"-i", test.id,
+			"--once",
+		}
+		cmd, err := common.Start("./"+s.BinName, args...)
+		if !s.NoError(err, msg("command exec should not error")) {
+			continue
+		}
+
+		if test.expectedErr {
+			s.Error(cmd.Wait(), msg("daemon should have exited with error"))
+			continue
+		}
+		s.NoError(cmd.Wait(), msg("daemon should have exited cleanly"))
+
+		contents, err := ioutil.ReadFile(s.FilePath)
+		s.NoError(err, msg("file should be written"))
+		s.Equal(s.Hypervisor.ID, string(contents), msg("file should be rendered"))
+	}
+}
@@ -0,0 +1,75 @@
+/*
+nfilesd renders the local config files of a hypervisor, such as its network interfaces, bridges, and agent config, from a kv.
+The files are rendered from templates listed in a config file, and rewritten whenever the hypervisor, its subnets, or the cluster config change, with a command run to reload each changed file.
+It is a companion to nheartbeatd, keeping the config of each node in the kv rather than only in ansible.
+
+Usage
+
+The following arguments are understood:
+
+	$ nfilesd -h
+	Usage of nfilesd:
+	-c, --config="/etc/nfilesd/config.json": path to config file of the files to render
+	    --config-file="": yaml or toml file of flag settings, overridden by the environment and the command line
+	-i, --id="": hypervisor id
+	-k, --kv="http://localhost:4001": kv cluster address
+	    --kv-prefix="/lochness": root of the lochness keys in the kv, to share a kv cluster between lochness clusters
+	-l, --log-level="info": log level
+	    --log-max-backups=5: number of rotated log files kept
+	    --log-max-size=100: megabytes a log file grows to before it is rotated, 0 to never rotate it
+	    --log-output="stdout": where to log: stdout, stderr, syslog, journald, or the path of a file
+	-o, --once=false: render the files only once and then exit
+
+Config
+
+The config file lists the files to render, each with the absolute path of the
+file, the template to render it from, relative to the config file unless
+absolute, its octal "mode", 0644 by default, and an optional "reload" command
+run after it changes.
+
+Example config
+
+	{
+		"files": [
+			{"path": "/etc/network/interfaces.d/lochness", "template": "interfaces.tmpl", "reload": "ifreload -a"},
+			{"path": "/etc/mistify/agent.conf", "template": "agent.tmpl", "mode": "0600", "reload": "systemctl restart mistify-agent"}
+		]
+	}
+
+Templates
+
+Templates are go text/templates, executed with:
+
+	.Hypervisor  the hypervisor, e.g. {{.Hypervisor.IP}} or {{.Hypervisor.Metadata.role}}
+	.Config      the cluster config overlaid with the hypervisor's, e.g. {{.Config.dns}}
+	.Bridges     the bridges of its "bridges" config and of its subnets, sorted
+	.Subnets     its subnets, sorted by bridge, each with its .Bridge, .CIDR, .Gateway and .VLAN
+	.AgentPort   the port of the agent
+
+along with the functions join, strings.Join, and netmask, the netmask of a
+CIDR. Config keys that are not set render as empty strings. For example, a
+template of bridge definitions:
+
+	{{range .Subnets}}
+	auto {{.Bridge}}.{{.VLAN}}
+	iface {{.Bridge}}.{{.VLAN}} inet manual
+	{{end}}
+
+Updates
+
+Files are rendered in memory, and only replaced, through a temporary file, if
+their contents changed, so that an update that changes nothing reloads nothing.
+The reload commands of the changed files are then run, each distinct command
+once. A file that fails to render or write is left as it is, while the others
+are still updated. Files are also rendered at startup, and with --once only
+then, exiting with an error if any failed.
+
+Reloading
+
+Sending nfilesd a SIGHUP rereads the config file and its templates, and renders
+the files again. If the new config cannot be loaded, an error is logged and the
+previous config stays in use.
+*/
+package main
+
+//go:generate godocdown -template=../../.godocdown.template -output=README.md
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+
+	log "github.com/Sirupsen/logrus"
+	ln "github.com/mistifyio/lochness"
+)
+
+// defaultMode is the mode of files whose config does not set one
+const defaultMode = "0644"
+
+type (
+	// File is a file of the hypervisor rendered from a template. Template
+	// is the path of the template, relative to the config file unless
+	// absolute. Mode is the octal permissions of the file, defaultMode
+	// unless set. Reload is a command run after the file changes, such as
+	// one restarting the service reading it.
+	File struct {
+		Path     string `json:"path"`
+		Template string `json:"template"`
+		Mode     string `json:"mode"`
+		Reload   string `json:"reload"`
+
+		tmpl *template.Template
+		perm os.FileMode
+	}
+
+	// Config is the config file of the files to render
+	Config struct {
+		Files []*File `json:"files"`
+	}
+
+	// templateData is what the templates are executed with. Config is the
+	// config of the cluster overlaid with the hypervisor's own. Bridges are
+	// those of its BridgesConfig and of its subnets, sorted.
+	templateData struct {
+		Hypervisor *ln.Hypervisor
+		Config     map[string]string
+		Bridges    []string
+		Subnets    []bridgeSubnet
+		AgentPort  int
+	}
+
+	// bridgeSubnet is a subnet of the hypervisor with the bridge it is on
+	bridgeSubnet struct {
+		*ln.Subnet
+		Bridge string
+	}
+
+	// writer writes the files of a config
+	writer struct {
+		mu     sync.Mutex
+		config *Config
+	}
+)
+
+// templateFuncs are the functions templates may use besides the builtins
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+	"netmask": func(n *net.IPNet) string {
+		return net.IP(n.Mask).String()
+	},
+}
+
+// loadConfig reads the config file and parses the templates of its files. The
+// config file should list at least one file, and each file only once.
+func loadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	if len(config.Files) == 0 {
+		return nil, errors.New("no files in config")
+	}
+
+	paths := make(map[string]bool, len(config.Files))
+	for _, f := range config.Files {
+		if err := f.load(filepath.Dir(path)); err != nil {
+			return nil, err
+		}
+		if paths[f.Path] {
+			return nil, fmt.Errorf("file %s is listed more than once", f.Path)
+		}
+		paths[f.Path] = true
+	}
+	return config, nil
+}
+
+// load checks the settings of a file and parses its template, relative to dir
+// unless absolute
+func (f *File) load(dir string) error {
+	if !filepath.IsAbs(f.Path) {
+		return fmt.Errorf("file path %q must be absolute", f.Path)
+	}
+	f.Path = filepath.Clean(f.Path)
+
+	if f.Mode == "" {
+		f.Mode = defaultMode
+	}
+	perm, err := strconv.ParseUint(f.Mode, 8, 32)
+	if err != nil || perm > 0777 {
+		return fmt.Errorf("file %s: invalid mode %q", f.Path, f.Mode)
+	}
+	f.perm = os.FileMode(perm)
+
+	if f.Template == "" {
+		return fmt.Errorf("file %s: missing template", f.Path)
+	}
+	if !filepath.IsAbs(f.Template) {
+		f.Template = filepath.Join(dir, f.Template)
+	}
+	data, err := ioutil.ReadFile(f.Template)
+	if err != nil {
+		return fmt.Errorf("file %s: %s", f.Path, err)
+	}
+	f.tmpl, err = template.New(filepath.Base(f.Template)).Funcs(templateFuncs).Option("missingkey=zero").Parse(string(data))
+	if err != nil {
+		return fmt.Errorf("file %s: %s", f.Path, err)
+	}
+	return nil
+}
+
+// genData gets the hypervisor, its config, and its subnets from the kv for the
+// templates
+func genData(c *ln.Context, hv *ln.Hypervisor) (*templateData, error) {
+	if err := hv.Refresh(); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "Hypervisor.Refresh",
+			"id":    hv.ID,
+		}).Error("could not refresh hypervisor")
+		return nil, err
+	}
+
+	config, err := hv.BootstrapConfig()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "Hypervisor.BootstrapConfig",
+			"id":    hv.ID,
+		}).Error("could not get hypervisor config")
+		return nil, err
+	}
+
+	bridges, err := ln.ParseBridges(config[ln.BridgesConfig])
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "lochness.ParseBridges",
+			"id":    hv.ID,
+		}).Error("invalid bridges config")
+		return nil, err
+	}
+
+	var subnets []bridgeSubnet
+	for id, bridge := range hv.Subnets() {
+		// a missing subnet would leave its bridge unconfigured, so no files
+		// are written until the kv is consistent again
+		subnet, err := c.Subnet(id)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":  err,
+				"func":   "context.Subnet",
+				"subnet": id,
+			}).Error("could not get subnet")
+			return nil, err
+		}
+		subnets = append(subnets, bridgeSubnet{Subnet: subnet, Bridge: bridge})
+		if !hasString(bridges, bridge) {
+			bridges = append(bridges, bridge)
+		}
+	}
+	sort.Strings(bridges)
+	sort.Slice(subnets, func(i, j int) bool {
+		if subnets[i].Bridge != subnets[j].Bridge {
+			return subnets[i].Bridge < subnets[j].Bridge
+		}
+		return subnets[i].ID < subnets[j].ID
+	})
+
+	return &templateData{
+		Hypervisor: hv,
+		Config:     config,
+		Bridges:    bridges,
+		Subnets:    subnets,
+		AgentPort:  ln.AgentPort,
+	}, nil
+}
+
+// newWriter creates a writer of the files of a config
+func newWriter(config *Config) *writer {
+	return &writer{config: config}
+}
+
+// SetConfig replaces the config of the files to write
+func (w *writer) SetConfig(config *Config) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.config = config
+}
+
+// Update renders every file and replaces those that changed, then runs the
+// reload commands of the changed files, each command once. A file that fails
+// to render or write is left as it is while the others are still updated. The
+// first error is returned.
+func (w *writer) Update(data *templateData) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var firstErr error
+	var reloads []string
+	for _, f := range w.config.Files {
+		changed, err := f.write(data)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if changed && f.Reload != "" && !hasString(reloads, f.Reload) {
+			reloads = append(reloads, f.Reload)
+		}
+	}
+
+	for _, command := range reloads {
+		if err := reload(command); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// write renders a file and replaces it, unless it is unchanged. It returns
+// whether the contents of the file changed.
+func (f *File) write(data *templateData) (bool, error) {
+	// render in memory first, so unchanged files are not written at all
+	contents := &bytes.Buffer{}
+	if err := f.tmpl.Execute(contents, data); err != nil {
+		log.WithFields(log.Fields{
+			"error":    err,
+			"path":     f.Path,
+			"template": f.Template,
+		}).Error("could not render file")
+		return false, err
+	}
+
+	previous, err := ioutil.ReadFile(f.Path)
+	if err == nil && bytes.Equal(previous, contents.Bytes()) {
+		log.WithField("path", f.Path).Debug("no change to file")
+		return false, f.chmod()
+	}
+
+	if err := os.MkdirAll(filepath.Dir(f.Path), 0755); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "os.MkdirAll",
+			"path":  f.Path,
+		}).Error("could not create directory of file")
+		return false, err
+	}
+
+	tmp := f.Path + ".tmp"
+	if err := ioutil.WriteFile(tmp, contents.Bytes(), f.perm); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "ioutil.WriteFile",
+			"path":  tmp,
+		}).Error("could not write temporary file")
+		return false, err
+	}
+	// the umask applies to new files
+	if err := os.Chmod(tmp, f.perm); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "os.Chmod",
+			"path":  tmp,
+		}).Error("could not set mode of temporary file")
+		return false, err
+	}
+
+	if err := os.Rename(tmp, f.Path); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "os.Rename",
+			"from":  tmp,
+			"to":    f.Path,
+		}).Error("could not rename temporary file")
+		return false, err
+	}
+
+	log.WithField("path", f.Path).Info("replaced file")
+	return true, nil
+}
+
+// chmod sets the mode of an unchanged file, in case it was changed by hand
+func (f *File) chmod() error {
+	info, err := os.Stat(f.Path)
+	if err != nil || info.Mode().Perm() == f.perm {
+		return err
+	}
+	if err := os.Chmod(f.Path, f.perm); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "os.Chmod",
+			"path":  f.Path,
+		}).Error("could not set mode of file")
+		return err
+	}
+	return nil
+}
+
+// reload runs the reload command of changed files
+func reload(command string) error {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		log.WithFields(log.Fields{
+			"error":   err,
+			"func":    "cmd.Run",
+			"command": command,
+		}).Error("failed to run reload command")
+		return err
+	}
+	return nil
+}
+
+// hasString returns whether s is one of values
+func hasString(values []string, s string) bool {
+	for _, value := range values {
+		if value == s {
+			return true
+		}
+	}
+	return false
+}
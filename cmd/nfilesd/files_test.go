@@ -0,0 +1,143 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	ln "github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestFiles(t *testing.T) {
+	suite.Run(t, new(FilesSuite))
+}
+
+type FilesSuite struct {
+	common.Suite
+	Dir string
+}
+
+const interfacesTemplate = `{{range .Bridges}}auto {{.}}
+{{end}}{{range .Subnets}}{{.Bridge}} {{.CIDR}} {{netmask .CIDR}} vlan {{.VLAN}}
+{{end}}`
+
+func (s *FilesSuite) SetupSuite() {
+	s.Suite.SetupSuite()
+	log.SetLevel(log.FatalLevel)
+}
+
+func (s *FilesSuite) SetupTest() {
+	s.Suite.SetupTest()
+	var err error
+	s.Dir, err = ioutil.TempDir("", "nfilesdTest-")
+	s.Require().NoError(err)
+	s.Require().NoError(ioutil.WriteFile(filepath.Join(s.Dir, "interfaces.tmpl"), []byte(interfacesTemplate), 0644))
+	s.Require().NoError(ioutil.WriteFile(filepath.Join(s.Dir, "agent.tmpl"), []byte("port={{.AgentPort}} ip={{.Hypervisor.IP}} zone={{.Config.zone}}\n"), 0644))
+}
+
+func (s *FilesSuite) TearDownTest() {
+	_ = os.RemoveAll(s.Dir)
+	s.Suite.TearDownTest()
+}
+
+// writeConfig writes a config file and returns its path
+func (s *FilesSuite) writeConfig(config string) string {
+	path := filepath.Join(s.Dir, "config.json")
+	s.Require().NoError(ioutil.WriteFile(path, []byte(config), 0644))
+	return path
+}
+
+func (s *FilesSuite) TestLoadConfig() {
+	config, err := loadConfig(s.writeConfig(`{"files": [
+		{"path": "/etc/network/interfaces.d/lochness", "template": "interfaces.tmpl", "reload": "ifreload -a"},
+		{"path": "/etc/mistify/agent.conf", "template": "` + filepath.Join(s.Dir, "agent.tmpl") + `", "mode": "0600"}
+	]}`))
+	s.Require().NoError(err)
+	s.Len(config.Files, 2)
+	s.Equal(filepath.Join(s.Dir, "interfaces.tmpl"), config.Files[0].Template, "templates should be relative to the config file")
+	s.Equal(os.FileMode(0644), config.Files[0].perm)
+	s.Equal(os.FileMode(0600), config.Files[1].perm)
+
+	tests := []struct {
+		description string
+		config      string
+	}{
+		{"no files", `{"files": []}`},
+		{"relative path", `{"files": [{"path": "agent.conf", "template": "agent.tmpl"}]}`},
+		{"missing template", `{"files": [{"path": "/etc/agent.conf", "template": "missing.tmpl"}]}`},
+		{"invalid mode", `{"files": [{"path": "/etc/agent.conf", "template": "agent.tmpl", "mode": "rw"}]}`},
+		{"duplicate path", `{"files": [{"path": "/etc/agent.conf", "template": "agent.tmpl"}, {"path": "/etc/agent.conf", "template": "interfaces.tmpl"}]}`},
+	}
+	for _, test := range tests {
+		_, err := loadConfig(s.writeConfig(test.config))
+		s.Error(err, test.description)
+	}
+
+	s.Require().NoError(ioutil.WriteFile(filepath.Join(s.Dir, "agent.tmpl"), []byte("{{.AgentPort"), 0644))
+	_, err = loadConfig(s.writeConfig(`{"files": [{"path": "/etc/agent.conf", "template": "agent.tmpl"}]}`))
+	s.Error(err, "templates that do not parse should be errors")
+}
+
+func (s *FilesSuite) TestGenData() {
+	hypervisor, _ := s.NewHypervisorWithGuest()
+	s.Require().NoError(s.Context.SetConfig("zone", "us-east"))
+	s.Require().NoError(hypervisor.SetConfig(ln.BridgesConfig, "mistify1,mistify0"))
+
+	data, err := genData(s.Context, hypervisor)
+	s.Require().NoError(err)
+	s.Equal([]string{"mistify0", "mistify1"}, data.Bridges)
+	s.Require().Len(data.Subnets, 1)
+	s.Equal("mistify0", data.Subnets[0].Bridge)
+	s.Equal("us-east", data.Config["zone"])
+	s.Equal(ln.AgentPort, data.AgentPort)
+}
+
+func (s *FilesSuite) TestUpdate() {
+	hypervisor, _ := s.NewHypervisorWithGuest()
+	interfaces := filepath.Join(s.Dir, "etc", "interfaces")
+	agent := filepath.Join(s.Dir, "etc", "agent.conf")
+	reloaded := filepath.Join(s.Dir, "reloaded")
+	config, err := loadConfig(s.writeConfig(`{"files": [
+		{"path": "` + interfaces + `", "template": "interfaces.tmpl", "reload": "touch ` + reloaded + `"},
+		{"path": "` + agent + `", "template": "agent.tmpl", "mode": "0600"}
+	]}`))
+	s.Require().NoError(err)
+	w := newWriter(config)
+
+	data, err := genData(s.Context, hypervisor)
+	s.Require().NoError(err)
+	s.Require().NoError(w.Update(data))
+
+	contents, err := ioutil.ReadFile(interfaces)
+	s.Require().NoError(err)
+	s.Equal("auto mistify0\nmistify0 192.168.100.0/24 255.255.255.0 vlan 0\n", string(contents))
+	contents, err = ioutil.ReadFile(agent)
+	s.Require().NoError(err)
+	s.Equal("port=8080 ip=192.168.100.11 zone=\n", string(contents))
+	info, err := os.Stat(agent)
+	s.Require().NoError(err)
+	s.Equal(os.FileMode(0600), info.Mode().Perm())
+	_, err = os.Stat(reloaded)
+	s.NoError(err, "changed files should be reloaded")
+
+	// unchanged files are not reloaded
+	s.Require().NoError(os.Remove(reloaded))
+	s.Require().NoError(w.Update(data))
+	_, err = os.Stat(reloaded)
+	s.True(os.IsNotExist(err), "unchanged files should not be reloaded")
+
+	// nor are files without a reload command
+	s.Require().NoError(s.Context.SetConfig("zone", "us-east"))
+	data, err = genData(s.Context, hypervisor)
+	s.Require().NoError(err)
+	s.Require().NoError(w.Update(data))
+	contents, err = ioutil.ReadFile(agent)
+	s.Require().NoError(err)
+	s.Equal("port=8080 ip=192.168.100.11 zone=us-east\n", string(contents))
+	_, err = os.Stat(reloaded)
+	s.True(os.IsNotExist(err))
+}
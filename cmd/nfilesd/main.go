@@ -0,0 +1,169 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+	ln "github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/config"
+	"github.com/mistifyio/lochness/internal/logging"
+	"github.com/mistifyio/lochness/pkg/kv"
+	_ "github.com/mistifyio/lochness/pkg/kv/bolt"
+	_ "github.com/mistifyio/lochness/pkg/kv/consul"
+	"github.com/mistifyio/lochness/pkg/watcher"
+	flag "github.com/ogier/pflag"
+)
+
+func getHV(hn string, c *ln.Context) *ln.Hypervisor {
+	var err error
+	hn, err = ln.SetHypervisorID(hn)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "lochness.SetHypervisorID",
+		}).Fatal("failed")
+	}
+
+	log.WithField("hypervisor_id", hn).Info("using id")
+
+	hv, err := c.Hypervisor(hn)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "context.Hypervisor",
+		}).Fatal("failed to fetch hypervisor info")
+	}
+	return hv
+}
+
+// update renders the files from the current state of the hypervisor
+func update(c *ln.Context, hv *ln.Hypervisor, w *writer) error {
+	data, err := genData(c, hv)
+	if err != nil {
+		return err
+	}
+	return w.Update(data)
+}
+
+func main() {
+	kvAddr := "http://localhost:4001"
+	kvPrefix := kv.DefaultPrefix
+	hn := ""
+	configPath := "/etc/nfilesd/config.json"
+	flag.StringVarP(&kvAddr, "kv", "k", kvAddr, "kv cluster address")
+	flag.StringVar(&kvPrefix, "kv-prefix", kvPrefix, "root of the lochness keys in the kv, to share a kv cluster between lochness clusters")
+	flag.StringVarP(&hn, "id", "i", hn, "hypervisor id")
+	flag.StringVarP(&configPath, "config", "c", configPath, "path to config file of the files to render")
+	once := flag.BoolP("once", "o", false, "render the files only once and then exit")
+	var logCfg logging.Config
+	logLevel := flag.StringP("log-level", "l", "info", "log level")
+	logging.AddFlags(flag.CommandLine, &logCfg)
+	configFile := flag.String(config.FlagName, "", config.FlagUsage)
+	flag.Parse()
+
+	if err := config.Load(config.Pflag(flag.CommandLine), "nfilesd", *configFile); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "config.Load",
+			"file":  *configFile,
+		}).Fatal("failed to load config")
+	}
+
+	if err := logging.Setup(*logLevel, "nfilesd", logCfg); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "logging.Setup",
+			"level": *logLevel,
+		}).Fatal("failed to set up logging")
+	}
+
+	filesConfig, err := loadConfig(configPath)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":      err,
+			"configPath": configPath,
+		}).Fatal("failed to load config")
+	}
+	w := newWriter(filesConfig)
+
+	KV, err := kv.New(kvAddr)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "kv.New",
+		}).Fatal("failed to connect to kv")
+	}
+	KV = kv.WithPrefix(KV, kvPrefix)
+
+	c := ln.NewContext(KV)
+	hv := getHV(hn, c)
+
+	// render at startup
+	if err := update(c, hv, w); err != nil {
+		if *once {
+			log.WithField("error", err).Fatal("could not update files")
+		}
+		log.WithField("error", err).Error("could not update files")
+	}
+	if *once {
+		return
+	}
+
+	fileWatcher, err := watcher.New(KV)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "watcher.New",
+		}).Fatal("failed to start watcher")
+	}
+
+	for _, prefix := range []string{"/lochness/hypervisors/" + hv.ID, "/lochness/subnets", "/lochness/config"} {
+		if err := fileWatcher.Add(prefix); err != nil {
+			log.WithFields(log.Fields{
+				"error":  err,
+				"func":   "watcher.Add",
+				"prefix": prefix,
+			}).Fatal("failed to add prefix to watch list")
+		}
+	}
+
+	go func() {
+		for fileWatcher.Next() {
+			log.WithField("event", fileWatcher.Event()).Debug("event received")
+			_ = update(c, hv, w)
+		}
+		if err := fileWatcher.Err(); err != nil {
+			log.WithField("error", err).Fatal("watcher error")
+		}
+	}()
+
+	// handle signals for config reloads and clean shutdown
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+
+	for s := range sigs {
+		if s != syscall.SIGHUP {
+			log.WithField("signal", s).Info("signal received. waiting for current update to finish")
+			break
+		}
+
+		log.WithField("signal", s).Info("signal received. reloading config")
+		newConfig, err := loadConfig(configPath)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":      err,
+				"configPath": configPath,
+			}).Error("failed to reload config, keeping previous config")
+			continue
+		}
+		w.SetConfig(newConfig)
+		_ = update(c, hv, w)
+	}
+
+	// wait until any current update is finished
+	w.mu.Lock()
+	_ = fileWatcher.Close()
+	log.Info("exiting")
+}
@@ -1,7 +1,7 @@
 /*
 nfirewalld is a simple firewall daemon that monitors a kv for firewall configuration.
 The firewall is implemented using nftables.
-When guests or firewall groups are added, modified, or removed, a new firewall configuration is generated and nftables is reloaded.
+When guests, firewall groups, or flavors are added, modified, or removed, a new firewall configuration is generated and nftables is reloaded.
 
 Usage
 
@@ -9,9 +9,37 @@ The following arguments are understood:
 
 	$ nfirewalld -h
 	Usage of nfirewalld:
+	    --config-file="": yaml or toml file of flag settings, overridden by the environment and the command line
 	-k, --kv="http://localhost:4001": kv cluster address
+	    --kv-prefix="/lochness": root of the lochness keys in the kv, to share a kv cluster between lochness clusters
 	-f, --file="/etc/nftables.conf": nft configuration file
 	-i, --id="": hypervisor id
+
+Group Sources
+
+A rule may have a firewall group as source, matching traffic from the guests of
+that group and of the groups it includes, directly or through other groups.
+Each group is rendered into a chain of its rules and a set of the addresses of
+its members, numbered in the order of the group ids, so the same groups always
+produce the same configuration. Rules that fail to validate are skipped.
+
+	# FWGroupID=2c3f4b6e-0f56-4b4e-9a49-4c0f8e4f7a11
+	chain g1 {
+	    tcp dport 22 ip saddr @s0 accept
+	}
+
+Bandwidth Limits
+
+Traffic to guests whose flavor has a "network_bandwidth" limit, in Mbit/s, is
+dropped once over that rate, ahead of the firewall groups. The limits are
+rendered into a chain of their own, which hooks input before the filter chain,
+so established connections are limited as well. The agent applies the limits,
+and the "disk_iops" ones, to the guest itself when creating or resizing it.
+
+	chain limit {
+	  type filter hook input priority -1;
+	  ip daddr 10.100.101.66 limit rate over 12500 kbytes/second drop
+	}
 */
 package main
 
@@ -8,12 +8,15 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
 	log "github.com/Sirupsen/logrus"
 	ln "github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/config"
 	"github.com/mistifyio/lochness/pkg/kv"
+	_ "github.com/mistifyio/lochness/pkg/kv/bolt"
 	_ "github.com/mistifyio/lochness/pkg/kv/consul"
 	"github.com/mistifyio/lochness/pkg/watcher"
 	flag "github.com/ogier/pflag"
@@ -25,16 +28,18 @@ const (
 )
 
 type groupVal struct {
-	num   int
-	id    string
-	ips   []string
-	rules []string
+	num     int
+	id      string
+	members []string // groups whose guests' ips are in the set
+	ips     []string
+	rules   []string
 }
 
 type templateData struct {
 	ip     string
 	groups groupMap
 	guests guestMap
+	limits limitMap
 }
 
 type groupMap map[string]groupVal
@@ -50,12 +55,50 @@ func (g groupMap) Index(id string) int {
 	return group.num
 }
 
+// number numbers the groups in the order of their ids, so the same groups are
+// always written the same way
+func (g groupMap) number() {
+	ids := make([]string, 0, len(g))
+	for id := range g {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for num, id := range ids {
+		group := g[id]
+		group.num = num
+		group.id = id
+		g[id] = group
+	}
+}
+
+// sorted returns the groups in the order of their numbers
+func (g groupMap) sorted() []groupVal {
+	groups := make([]groupVal, 0, len(g))
+	for _, group := range g {
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].num < groups[j].num })
+	return groups
+}
+
 type guestMap map[string]int
 
+// limitMap maps guest ips to the rate, in kbytes/second, their traffic is
+// limited to
+type limitMap map[string]uint64
+
 // genNFRules iterates through each FWRule and creates the nft rule line
 func genNFRules(groups groupMap, fwrules ln.FWRules) []string {
 	var nftrules []string
 	for _, rule := range fwrules {
+		if err := rule.Validate(); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"rule":  rule,
+			}).Error("skipping invalid rule")
+			continue
+		}
+
 		source := ""
 		if rule.Group != "" {
 			source += "ip saddr @s" + strconv.Itoa(groups.Index(rule.Group))
@@ -73,77 +116,138 @@ func genNFRules(groups groupMap, fwrules ln.FWRules) []string {
 				rule.Protocol,
 				rule.PortEnd,
 				source)
-		} else if rule.PortStart < rule.PortEnd {
+		} else {
 			nftRule = fmt.Sprintf(nftPortRange,
 				rule.Protocol,
 				rule.PortStart,
 				rule.PortEnd,
 				source)
-		} else {
-			log.WithFields(log.Fields{
-				"start": rule.PortStart,
-				"stop":  rule.PortEnd,
-				"error": "invalid port range",
-			}).Error("invalid port range specified")
-			continue
 		}
 		nftrules = append(nftrules, nftRule)
 	}
 	return nftrules
 }
 
+// getGuestsFWGroups gets the FWGroups of the guests of a hypervisor, and
+// those their rules have as source, numbered in the order of their ids
 func getGuestsFWGroups(c *ln.Context, hv *ln.Hypervisor) (groupMap, guestMap) {
-	guests := guestMap{}
-	groups := groupMap{}
-	n := len(groups)
+	fwgroups := map[string]*ln.FWGroup{}
+	guestGroups := map[string]string{}
 
 	_ = hv.ForEachGuest(func(guest *ln.Guest) error {
 		// check if in cache
-		g, ok := groups[guest.FWGroupID]
-		if ok {
-			// link the guest to the FWGroup, via the FWGroup's index
-			guests[guest.IP.String()] = g.num
-			return nil
+		if _, ok := fwgroups[guest.FWGroupID]; !ok {
+			fw, err := c.FWGroup(guest.FWGroupID)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"error": err,
+					"func":  "context.FWGroup",
+					"group": guest.FWGroupID,
+				}).Error("failed to get firewall group")
+				return err
+			}
+			fwgroups[guest.FWGroupID] = fw
 		}
+		guestGroups[guest.IP.String()] = guest.FWGroupID
+		return nil
+	})
 
-		// nope not cached
-		fw, err := c.FWGroup(guest.FWGroupID)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"error": err,
-				"func":  "context.FWGroup",
-				"group": guest.FWGroupID,
-			}).Error("failed to get firewall group")
-			return err
+	groups := groupMap{}
+	for id, fw := range fwgroups {
+		groups.Index(id)
+		for _, rule := range fw.Rules {
+			if rule.Group != "" {
+				groups.Index(rule.Group)
+			}
 		}
+	}
+	groups.number()
+
+	for id, fw := range fwgroups {
+		g := groups[id]
+		g.rules = genNFRules(groups, fw.Rules)
+		groups[id] = g
+	}
+
+	// link the guests to their FWGroups, via the FWGroups' indexes
+	guests := guestMap{}
+	for ip, id := range guestGroups {
+		guests[ip] = groups[id].num
+	}
+	return groups, guests
+}
 
-		g = groupVal{
-			num:   n,
-			id:    fw.ID,
-			rules: genNFRules(groups, fw.Rules),
+// getGuestLimits gets the bandwidth limits of the guests of a hypervisor from
+// their flavors
+func getGuestLimits(c *ln.Context, hv *ln.Hypervisor) limitMap {
+	limits := limitMap{}
+	flavors := map[string]*ln.Flavor{}
+
+	_ = hv.ForEachGuest(func(guest *ln.Guest) error {
+		flavor, ok := flavors[guest.FlavorID]
+		if !ok {
+			var err error
+			flavor, err = c.Flavor(guest.FlavorID)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"error":  err,
+					"func":   "context.Flavor",
+					"flavor": guest.FlavorID,
+				}).Error("failed to get flavor")
+				return err
+			}
+			flavors[guest.FlavorID] = flavor
 		}
-		n++
-		groups[guest.FWGroupID] = g
 
-		// link the guest to the FWGroup, via the FWGroup's index
-		guests[guest.IP.String()] = g.num
+		if flavor.NetworkBandwidth > 0 {
+			// Mbit/s to kbytes/second
+			limits[guest.IP.String()] = flavor.NetworkBandwidth * 125
+		}
 		return nil
 	})
-	return groups, guests
+	return limits
+}
+
+// getGroupMembers sets the groups whose guests are in the set of each group,
+// the group itself and those it includes
+func getGroupMembers(c *ln.Context, groups groupMap) {
+	for id, group := range groups {
+		group.members = []string{id}
+		fw, err := c.FWGroup(id)
+		if err == nil {
+			var members []string
+			if members, err = fw.Members(); err == nil {
+				group.members = members
+			}
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"group": id,
+			}).Error("failed to get firewall group members, using only its own guests")
+		}
+		groups[id] = group
+	}
 }
 
 func populateGroupMembers(c *ln.Context, groups groupMap) {
-	_ = c.ForEachGuest(func(guest *ln.Guest) error {
-		group, ok := groups[guest.FWGroupID]
-		if !ok {
-			// not a FWGroup referenced by any guest's FWGroup
-			return nil
+	getGroupMembers(c, groups)
+
+	// the groups whose sets the guests of each group are in
+	memberOf := map[string][]string{}
+	for id, group := range groups {
+		for _, member := range group.members {
+			memberOf[member] = append(memberOf[member], id)
 		}
+	}
 
-		ips := group.ips
-		ips = append(ips, guest.IP.String())
-		group.ips = ips
-		groups[guest.FWGroupID] = group
+	_ = c.ForEachGuest(func(guest *ln.Guest) error {
+		// none if not a FWGroup referenced by any guest's FWGroup
+		for _, id := range memberOf[guest.FWGroupID] {
+			group := groups[id]
+			group.ips = append(group.ips, guest.IP.String())
+			groups[id] = group
+		}
 		return nil
 	})
 }
@@ -165,6 +269,7 @@ func genRules(hv *ln.Hypervisor, c *ln.Context) (templateData, error) {
 		ip:     hv.IP.String(),
 		groups: groups,
 		guests: guests,
+		limits: getGuestLimits(c, hv),
 	}
 	return td, nil
 }
@@ -181,7 +286,7 @@ func applyRules(filename string, td templateData) error {
 		return err
 	}
 
-	err = nftWrite(temp, td.ip, td.groups, td.guests)
+	err = nftWrite(temp, td.ip, td.groups, td.guests, td.limits)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"error": err,
@@ -295,13 +400,24 @@ func canonicalizeRules(rules string) string {
 
 func main() {
 	kvAddr := "http://localhost:4001"
+	kvPrefix := kv.DefaultPrefix
 	hn := ""
 	rules := "/etc/nftables.conf"
 	flag.StringVarP(&kvAddr, "kv", "k", kvAddr, "kv cluster address")
+	flag.StringVar(&kvPrefix, "kv-prefix", kvPrefix, "root of the lochness keys in the kv, to share a kv cluster between lochness clusters")
 	flag.StringVarP(&hn, "id", "i", hn, "hypervisor id")
 	flag.StringVarP(&rules, "file", "f", rules, "nft configuration file")
+	configFile := flag.String(config.FlagName, "", config.FlagUsage)
 	flag.Parse()
 
+	if err := config.Load(config.Pflag(flag.CommandLine), "nfirewalld", *configFile); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "config.Load",
+			"file":  *configFile,
+		}).Fatal("failed to load config")
+	}
+
 	rules = canonicalizeRules(rules)
 	cleanStaleFiles(rules)
 
@@ -312,6 +428,7 @@ func main() {
 			"func":  "kv.New",
 		}).Fatal("failed to connect to kv")
 	}
+	KV = kv.WithPrefix(KV, kvPrefix)
 
 	c := ln.NewContext(KV)
 	hv := getHV(hn, c)
@@ -340,6 +457,14 @@ func main() {
 		}).Fatal("failed to add prefix to watch list")
 	}
 
+	if err := watcher.Add("/lochness/flavors"); err != nil {
+		log.WithFields(log.Fields{
+			"error":  err,
+			"func":   "watcher.Add",
+			"prefix": "/lochness/flavors",
+		}).Fatal("failed to add prefix to watch list")
+	}
+
 	// load rules at startup
 	td, err := genRules(hv, c)
 	if err != nil {
@@ -6,15 +6,15 @@ import (
 )
 
 //line nftables.ego:1
-func nftWrite(w io.Writer, ip string, groups groupMap, guests guestMap) error {
+func nftWrite(w io.Writer, ip string, groups groupMap, guests guestMap, limits limitMap) error {
 //line nftables.ego:2
 	_, _ = fmt.Fprintf(w, "\nflush ruleset\n\ntable ip filter {\n  ")
 //line nftables.ego:5
-	for id, fwg := range groups {
+	for _, fwg := range groups.sorted() {
 //line nftables.ego:6
 		_, _ = fmt.Fprintf(w, "\n  # FWGroupID=")
 //line nftables.ego:6
-		_, _ = fmt.Fprintf(w, "%v", id)
+		_, _ = fmt.Fprintf(w, "%v", fwg.id)
 //line nftables.ego:7
 		_, _ = fmt.Fprintf(w, "\n  chain g")
 //line nftables.ego:7
@@ -59,35 +59,57 @@ func nftWrite(w io.Writer, ip string, groups groupMap, guests guestMap) error {
 		_, _ = fmt.Fprintf(w, "\n  }\n  ")
 //line nftables.ego:16
 	}
+//line nftables.ego:16
+	if len(limits) > 0 {
 //line nftables.ego:17
+		_, _ = fmt.Fprintf(w, "\n  # limit the bandwidth of guests as specified by their flavors, ahead of\n  # established connections being accepted\n  chain limit {\n    type filter hook input priority -1;")
+//line nftables.ego:20
+		for guestIP, rate := range limits {
+//line nftables.ego:21
+			_, _ = fmt.Fprintf(w, "\n    ip daddr ")
+//line nftables.ego:21
+			_, _ = fmt.Fprintf(w, "%v", guestIP)
+//line nftables.ego:21
+			_, _ = fmt.Fprintf(w, " limit rate over ")
+//line nftables.ego:21
+			_, _ = fmt.Fprintf(w, "%v", rate)
+//line nftables.ego:21
+			_, _ = fmt.Fprintf(w, " kbytes/second drop")
+//line nftables.ego:21
+		}
+//line nftables.ego:22
+		_, _ = fmt.Fprintf(w, "\n  }\n  ")
+//line nftables.ego:23
+	}
+//line nftables.ego:24
 	_, _ = fmt.Fprintf(w, "\n  chain input {\n    type filter hook input priority 0;\n\n    # allow established/related connections\n    ct state {established, related} accept\n\n    # early drop of invalid connections\n    ct state invalid drop\n\n    # allow from loopback\n    iifname lo accept\n\n    # allow icmp\n    ip protocol icmp accept\n\n    # allow lochness hv traffic\n    ip daddr ")
-//line nftables.ego:33
+//line nftables.ego:40
 	_, _ = fmt.Fprintf(w, "%v", ip)
-//line nftables.ego:33
+//line nftables.ego:40
 	_, _ = fmt.Fprintf(w, " accept\n\n  }\n\n  chain forward {\n    type filter hook forward priority 0;\n    drop\n  }\n\n  chain output {\n    type filter hook output priority 0;\n  }\n}\n\n")
-//line nftables.ego:47
+//line nftables.ego:54
 	if len(guests) > 0 {
-//line nftables.ego:48
+//line nftables.ego:55
 		_, _ = fmt.Fprintf(w, "\n# Allow traffic to guests as specified by FWGroups\nadd rule filter input ip daddr vmap { ")
-//line nftables.ego:49
+//line nftables.ego:56
 		for ip, fwgIndex := range guests {
-//line nftables.ego:50
+//line nftables.ego:57
 			_, _ = fmt.Fprintf(w, "\n    ")
-//line nftables.ego:50
+//line nftables.ego:57
 			_, _ = fmt.Fprintf(w, "%v", ip)
-//line nftables.ego:50
+//line nftables.ego:57
 			_, _ = fmt.Fprintf(w, " : jump g")
-//line nftables.ego:50
+//line nftables.ego:57
 			_, _ = fmt.Fprintf(w, "%v", fwgIndex)
-//line nftables.ego:50
+//line nftables.ego:57
 			_, _ = fmt.Fprintf(w, ", ")
-//line nftables.ego:50
+//line nftables.ego:57
 		}
-//line nftables.ego:51
+//line nftables.ego:58
 		_, _ = fmt.Fprintf(w, "\n}\n")
-//line nftables.ego:52
+//line nftables.ego:59
 	}
-//line nftables.ego:53
+//line nftables.ego:60
 	_, _ = fmt.Fprintf(w, "\n\n# reject everything else\nadd rule filter input reject with icmp type port-unreachable\n")
 	return nil
 }
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"strconv"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	ln "github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestNFTables(t *testing.T) {
+	suite.Run(t, new(NFTablesSuite))
+}
+
+type NFTablesSuite struct {
+	common.Suite
+}
+
+func (s *NFTablesSuite) SetupSuite() {
+	s.Suite.SetupSuite()
+	log.SetLevel(log.FatalLevel)
+}
+
+func (s *NFTablesSuite) TestGuestLimits() {
+	hypervisor, guest := s.NewHypervisorWithGuest()
+	s.Empty(getGuestLimits(s.Context, hypervisor), "guests without a bandwidth limit should not be limited")
+
+	flavor, err := s.Context.Flavor(guest.FlavorID)
+	s.Require().NoError(err)
+	flavor.NetworkBandwidth = 100
+	s.Require().NoError(flavor.Save())
+
+	s.Equal(limitMap{guest.IP.String(): 12500}, getGuestLimits(s.Context, hypervisor))
+}
+
+func (s *NFTablesSuite) TestGroupReferences() {
+	hypervisor, guest := s.NewHypervisorWithGuest()
+	admin := s.NewFWGroup()
+	ops := s.NewFWGroup()
+	admin.Includes = []string{ops.ID}
+	s.Require().NoError(admin.Save())
+
+	web := s.NewFWGroup()
+	web.Rules = ln.FWRules{
+		{PortStart: 22, PortEnd: 22, Protocol: "tcp", Group: admin.ID},
+		{PortStart: 80, PortEnd: 90, Protocol: "tcp"},
+	}
+	s.Require().NoError(web.Save())
+	guest.FWGroupID = web.ID
+	s.Require().NoError(guest.Save())
+
+	// a guest of another hypervisor, in a group admin includes
+	other := s.NewGuest()
+	other.FWGroupID = ops.ID
+	other.IP = net.ParseIP("192.168.100.20")
+	s.Require().NoError(other.Save())
+
+	groups, guests := getGuestsFWGroups(s.Context, hypervisor)
+	populateGroupMembers(s.Context, groups)
+	s.Len(groups, 2, "the groups of the guests and of rule sources should be written")
+
+	ids := []string{admin.ID, web.ID}
+	sort.Strings(ids)
+	for num, id := range ids {
+		s.Equal(num, groups[id].num, "groups should be numbered in the order of their ids")
+	}
+	s.Equal(groups[web.ID].num, guests[guest.IP.String()])
+	s.Equal([]string{"tcp dport 22 ip saddr @s" + strconv.Itoa(groups[admin.ID].num), "tcp dport 80 - 90 "}, groups[web.ID].rules)
+	s.Equal([]string{"192.168.100.20"}, groups[admin.ID].ips, "the set should have the guests of included groups")
+	s.Equal([]string{guest.IP.String()}, groups[web.ID].ips)
+}
+
+func (s *NFTablesSuite) TestWriteLimits() {
+	buf := &bytes.Buffer{}
+	s.NoError(nftWrite(buf, "10.0.0.1", groupMap{}, guestMap{}, limitMap{}))
+	s.NotContains(buf.String(), "chain limit", "no limit chain should be written without limits")
+
+	buf.Reset()
+	s.NoError(nftWrite(buf, "10.0.0.1", groupMap{}, guestMap{}, limitMap{"10.0.0.2": 12500}))
+	s.Contains(buf.String(), "chain limit {\n    type filter hook input priority -1;\n    ip daddr 10.0.0.2 limit rate over 12500 kbytes/second drop\n  }")
+	s.Contains(buf.String(), "ip daddr 10.0.0.1 accept", "hypervisor traffic should still be allowed")
+}
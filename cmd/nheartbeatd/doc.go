@@ -1,5 +1,9 @@
 /*
 nheartbeatd periodically confirms that the hypervisor node is alive and updates the resource usage in a kv.
+Recent heartbeats are kept in the kv to score the availability of the hypervisor.
+The available resources are what remains, once the guests' are taken, of the
+capacity given by the overcommit ratios of the hypervisor's config, or the
+cluster's, so changes to the ratios take effect at the next update.
 
 Usage
 
@@ -7,9 +11,33 @@ The following arguments are understood:
 
 	$ nheartbeatd -h
 	Usage of nheartbeatd:
+	    --config-file="": yaml or toml file of flag settings, overridden by the environment and the command line
+	    --drift-interval=10m0s: how often to check the hypervisor's facts against those expected of it. set to 0 to disable
 	-k, --kv="http://localhost:4001": address of kv machine
+	    --kv-prefix="/lochness": root of the lochness keys in the kv, to share a kv cluster between lochness clusters
+	-l, --log-level="info": log level
+	    --log-max-backups=5: number of rotated log files kept
+	    --log-max-size=100: megabytes a log file grows to before it is rotated, 0 to never rotate it
+	    --log-output="stdout": where to log: stdout, stderr, syslog, journald, or the path of a file
 	-d, --id="": hypervisor id
 	-i, --interval=60: update interval in seconds
 	-t, --ttl=0: heartbeat ttl in seconds
+
+Drift
+
+Every --drift-interval, the facts of the hypervisor, such as its kernel args,
+os version, and network interfaces, are compared with those expected of it,
+set with hv expected modify, and the differences are recorded as its drift,
+shown by hv drift. Facts that are not expected are not checked, so hypervisors
+with no expected config never drift. See lochness.LocalFacts for the facts
+gathered.
+
+Telemetry
+
+Every update, the load averages of the hypervisor, its available memory, and
+the disk available in its guestDiskDir are sampled and added to its telemetry
+in the kv. The most recent lochness.TelemetryWindowSize samples are kept, and
+served by chypervisord at /hypervisors/{hypervisorID}/telemetry. A sample that
+cannot be taken or recorded is logged and skipped.
 */
 package main
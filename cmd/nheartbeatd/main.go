@@ -5,9 +5,11 @@ import (
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/config"
+	"github.com/mistifyio/lochness/internal/logging"
 	"github.com/mistifyio/lochness/pkg/kv"
+	_ "github.com/mistifyio/lochness/pkg/kv/bolt"
 	_ "github.com/mistifyio/lochness/pkg/kv/consul"
-	logx "github.com/mistifyio/mistify-logrus-ext"
 	flag "github.com/ogier/pflag"
 )
 
@@ -15,10 +17,23 @@ func main() {
 	interval := flag.DurationP("interval", "i", 0*time.Second, "update interval (default ttl/2)")
 	ttl := flag.DurationP("ttl", "t", 120*time.Second, "heartbeat ttl (min: 10s)")
 	kvAddr := flag.StringP("kv", "k", "http://localhost:4001", "address of kv machine")
+	kvPrefix := flag.String("kv-prefix", kv.DefaultPrefix, "root of the lochness keys in the kv, to share a kv cluster between lochness clusters")
 	id := flag.StringP("id", "d", "", "hypervisor id")
+	driftInterval := flag.Duration("drift-interval", 10*time.Minute, "how often to check the hypervisor's facts against those expected of it. set to 0 to disable")
+	var logCfg logging.Config
 	logLevel := flag.StringP("log-level", "l", "info", "log level")
+	logging.AddFlags(flag.CommandLine, &logCfg)
+	configFile := flag.String(config.FlagName, "", config.FlagUsage)
 	flag.Parse()
 
+	if err := config.Load(config.Pflag(flag.CommandLine), "nheartbeatd", *configFile); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "config.Load",
+			"file":  *configFile,
+		}).Fatal("failed to load config")
+	}
+
 	var intervalSet bool
 	flag.Visit(func(f *flag.Flag) {
 		if f.Name == "interval" {
@@ -26,10 +41,10 @@ func main() {
 		}
 	})
 
-	if err := logx.DefaultSetup(*logLevel); err != nil {
+	if err := logging.Setup(*logLevel, "nheartbeatd", logCfg); err != nil {
 		log.WithFields(log.Fields{
 			"error": err,
-			"func":  "logx.DefaultSetup",
+			"func":  "logging.Setup",
 			"level": logLevel,
 		}).Fatal("failed to set up logging")
 	}
@@ -53,6 +68,7 @@ func main() {
 			"id":    id,
 		}).Fatal("failed to connect to kv")
 	}
+	KV = kv.WithPrefix(KV, *kvPrefix)
 
 	c := lochness.NewContext(KV)
 
@@ -74,13 +90,25 @@ func main() {
 		}).Fatal("failed to instantiate hypervisor")
 	}
 
+	var driftChecked time.Time
 	for {
+		if *driftInterval > 0 && time.Since(driftChecked) >= *driftInterval {
+			checkDrift(hv)
+			driftChecked = time.Now()
+		}
 		if err = hv.UpdateResources(); err != nil {
 			log.WithFields(log.Fields{
 				"error": err,
 				"func":  "hv.UpdateResources",
 			}).Fatal("failed to update hypervisor resources")
 		}
+		if err = hv.CollectTelemetry(); err != nil {
+			// telemetry is informational, so a missed sample is not fatal
+			log.WithFields(log.Fields{
+				"error": err,
+				"func":  "hv.CollectTelemetry",
+			}).Warn("failed to collect hypervisor telemetry")
+		}
 		if err = hv.Heartbeat(*ttl); err != nil {
 			log.WithFields(log.Fields{
 				"error": err,
@@ -91,3 +119,29 @@ func main() {
 		time.Sleep(*interval)
 	}
 }
+
+// checkDrift records how the facts of the hypervisor differ from those expected
+// of it. Failures are logged, as drift is only reported.
+func checkDrift(hv *lochness.Hypervisor) {
+	facts, err := lochness.LocalFacts()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "lochness.LocalFacts",
+		}).Error("failed to gather hypervisor facts")
+		return
+	}
+	drift, err := hv.CheckDrift(facts)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "hv.CheckDrift",
+		}).Error("failed to check hypervisor drift")
+		return
+	}
+	if drift.Drifted() {
+		log.WithFields(log.Fields{
+			"differences": drift.Differences,
+		}).Warn("hypervisor drifted from its expected config")
+	}
+}
@@ -0,0 +1,82 @@
+/*
+secret is the command line interface to csecretd, the secrets service. secret
+can list/create/rotate/reveal/delete the credentials guests and hypervisors
+reference, such as agent tokens, IPMI credentials, and root passwords.
+
+Values are read from stdin, or a file given with --value-file, and never taken
+as arguments, so that they are not left in shell histories or process lists.
+Guests and hypervisors reference a secret by id in their "secrets", e.g.
+
+	$ hv modify e88a75a6-7ae6-487c-9634-6553d3793437 '{"secrets":{"ipmi":"7d0c5b2e-9f1a-4c3d-8e6b-5a4f3e2d1c0b"}}'
+
+All commands support dual output formats, a tree like output for humans
+(default) or a json output for further processing.
+
+Usage
+
+The following arguments are understood:
+
+	$ secret -h
+	secret is the cli interface to csecretd. All commands support arguments via command line or stdin
+
+	Usage:
+	  secret [flags]
+	  secret [command]
+
+	Available Commands:
+	  completion  Generate shell completion scripts
+	  create      Create a new secret
+	  delete      Delete secrets no guest or hypervisor references
+	  help        Help about any command
+	  list        List the secrets, without their values
+	  reveal      Print the values of secrets
+	  rotate      Set a new value for a secret
+
+	Flags:
+	      --dial-timeout duration   how long connecting to the server or proxy may take, 0 for the default of 30s
+	  -h, --help                    help for secret
+	  -j, --json                    output in json
+	      --proxy string            url of the proxy to connect through, instead of those of $HTTP_PROXY, $HTTPS_PROXY, and $NO_PROXY, or "none" to connect directly
+	  -s, --server string           server address to connect to (default "http://localhost:22000")
+
+	Use "secret [command] --help" for more information about a command.
+
+Exit Codes
+
+secret exits 0 on success and otherwise with:
+
+	1  error       any other failure
+	2  usage       bad arguments, flags, or ids, or an empty value
+	3  not_found   the server has no such resource
+	4  validation  an invalid kind or value, or a request the server rejected as invalid
+	5  server      the server could not be reached, failed, or sent a bad response
+
+The last line written to stderr of a failed run is a json object describing
+the failure, with its kind under "error":
+
+	{"error":"not_found","exit_code":3,"message":"failed to get secret","status":404,"fields":{...}}
+
+Examples
+
+Create a secret
+
+	$ secret create hv1-bmc ipmi < bmc-credentials
+	7d0c5b2e-9f1a-4c3d-8e6b-5a4f3e2d1c0b
+
+List secrets in a table
+
+	$ secret list --table
+	ID                                    NAME     KIND   VERSION  UPDATED
+	7d0c5b2e-9f1a-4c3d-8e6b-5a4f3e2d1c0b  hv1-bmc  ipmi   1        2016-03-01T10:00:00Z
+
+Rotate a secret
+
+	$ secret rotate 7d0c5b2e-9f1a-4c3d-8e6b-5a4f3e2d1c0b --value-file new-bmc-credentials
+	7d0c5b2e-9f1a-4c3d-8e6b-5a4f3e2d1c0b
+
+Reveal the value of a secret
+
+	$ secret reveal 7d0c5b2e-9f1a-4c3d-8e6b-5a4f3e2d1c0b
+	admin:s3cret
+*/
+package main
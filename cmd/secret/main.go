@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/andrew-d/go-termutil"
+	"github.com/mistifyio/lochness/internal/cli"
+	"github.com/spf13/cobra"
+)
+
+var (
+	server    = "http://localhost:22000"
+	jsonout   = false
+	valueFile = ""
+
+	transportOpts = cli.TransportOptions{}
+
+	tableOpts   = cli.TableOptions{}
+	secretTable = cli.Table{
+		{Header: "ID", Key: "id"},
+		{Header: "NAME", Key: "name"},
+		{Header: "KIND", Key: "kind"},
+		{Header: "VERSION", Key: "version"},
+		{Header: "UPDATED", Key: "updated_at"},
+	}
+)
+
+// newClient creates a client for the server, connecting as set by --proxy and
+// --dial-timeout
+func newClient() *cli.Client {
+	c := cli.NewClient(server)
+	if err := c.ConfigureTransport(transportOpts); err != nil {
+		cli.Fatal(cli.ExitUsage, log.Fields{
+			"proxy":        transportOpts.Proxy,
+			"dial-timeout": transportOpts.DialTimeout,
+			"error":        err,
+		}, "invalid transport settings")
+	}
+	return c
+}
+
+func help(cmd *cobra.Command, _ []string) {
+	if err := cmd.Help(); err != nil {
+		cli.Fatal(cli.ExitError, log.Fields{"error": err}, "help")
+	}
+}
+
+func getSecrets(c *cli.Client) []cli.JMap {
+	ret, _ := c.GetMany("secrets", "secrets")
+	secrets := make([]cli.JMap, len(ret))
+	for i := range ret {
+		secrets[i] = ret[i]
+	}
+	return secrets
+}
+
+func getSecret(c *cli.Client, id string) cli.JMap {
+	secret, _ := c.Get("secret", "secrets/"+id)
+	return secret
+}
+
+func getValue(c *cli.Client, id string) cli.JMap {
+	value, _ := c.Get("secret value", "secrets/"+id+"/value")
+	return value
+}
+
+func createSecret(c *cli.Client, spec string) cli.JMap {
+	secret, _ := c.Post("secret", "secrets", spec)
+	return secret
+}
+
+func rotateSecret(c *cli.Client, id string, spec string) cli.JMap {
+	secret, _ := c.Put("secret", "secrets/"+id+"/value", spec)
+	return secret
+}
+
+func deleteSecret(c *cli.Client, id string) cli.JMap {
+	secret, _ := c.Delete("secret", "secrets/"+id)
+	return secret
+}
+
+// readValue reads a secret value from --value-file, or stdin if it is not
+// set, without a trailing newline. Values are not taken as arguments so that
+// they are not recorded in shell histories or seen in process lists.
+func readValue() string {
+	var data []byte
+	var err error
+	if valueFile != "" {
+		data, err = ioutil.ReadFile(valueFile)
+	} else {
+		if termutil.Isatty(os.Stdin.Fd()) {
+			fmt.Fprint(os.Stderr, "value: ")
+		}
+		data, err = ioutil.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		cli.Fatal(cli.ExitError, log.Fields{"error": err, "file": valueFile}, "failed to read value")
+	}
+	value := strings.TrimRight(string(data), "\r\n")
+	if value == "" {
+		cli.Fatal(cli.ExitUsage, log.Fields{"file": valueFile}, "empty value")
+	}
+	return value
+}
+
+// valueSpec creates the body of a request setting a secret value
+func valueSpec(fields map[string]string) string {
+	spec, err := json.Marshal(fields)
+	if err != nil {
+		cli.Fatal(cli.ExitError, log.Fields{"error": err}, "failed to encode value")
+	}
+	return string(spec)
+}
+
+func list(cmd *cobra.Command, args []string) {
+	c := newClient()
+	secrets := []cli.JMap{}
+	if len(args) == 0 {
+		if termutil.Isatty(os.Stdin.Fd()) {
+			secrets = getSecrets(c)
+			sort.Sort(cli.JMapSlice(secrets))
+		} else {
+			args = cli.Read(os.Stdin)
+		}
+	}
+	if len(secrets) == 0 {
+		for _, id := range args {
+			cli.AssertID(id)
+			secrets = append(secrets, getSecret(c, id))
+		}
+	}
+
+	if tableOpts.Table && !jsonout {
+		if err := secretTable.Print(os.Stdout, secrets, tableOpts); err != nil {
+			cli.Fatal(cli.ExitError, log.Fields{"error": err}, "failed to print table")
+		}
+		return
+	}
+
+	for _, secret := range secrets {
+		secret.Print(jsonout)
+	}
+}
+
+func create(cmd *cobra.Command, args []string) {
+	c := newClient()
+	name, kind := args[0], args[1]
+	secret := createSecret(c, valueSpec(map[string]string{
+		"name":  name,
+		"kind":  kind,
+		"value": readValue(),
+	}))
+	secret.Print(jsonout)
+}
+
+func rotate(cmd *cobra.Command, args []string) {
+	c := newClient()
+	id := args[0]
+	cli.AssertID(id)
+	secret := rotateSecret(c, id, valueSpec(map[string]string{
+		"value": readValue(),
+	}))
+	secret.Print(jsonout)
+}
+
+func reveal(cmd *cobra.Command, ids []string) {
+	c := newClient()
+	if len(ids) == 0 {
+		ids = cli.Read(os.Stdin)
+	}
+
+	for _, id := range ids {
+		cli.AssertID(id)
+		value := getValue(c, id)
+		if jsonout {
+			value.Print(jsonout)
+			continue
+		}
+		fmt.Println(value["value"])
+	}
+}
+
+func del(cmd *cobra.Command, ids []string) {
+	c := newClient()
+	if len(ids) == 0 {
+		ids = cli.Read(os.Stdin)
+	}
+
+	for _, id := range ids {
+		cli.AssertID(id)
+		secret := deleteSecret(c, id)
+		secret.Print(jsonout)
+	}
+}
+
+// listSecretIDs fetches the secret ids for completion
+func listSecretIDs() ([]string, error) {
+	return newClient().ListIDs("secrets")
+}
+
+func main() {
+	root := &cobra.Command{
+		Use:  "secret",
+		Long: "secret is the cli interface to csecretd. All commands support arguments via command line or stdin",
+		Run:  help,
+	}
+	root.PersistentFlags().BoolVarP(&jsonout, "json", "j", jsonout, "output in json")
+	root.PersistentFlags().StringVarP(&server, "server", "s", server, "server address to connect to")
+	transportOpts.AddFlags(root.PersistentFlags())
+
+	cmdList := &cobra.Command{
+		Use:   "list [<secret>...]",
+		Short: "List the secrets, without their values",
+		Run:   list,
+
+		ValidArgsFunction: cli.CompleteIDs(listSecretIDs),
+	}
+	tableOpts.AddFlags(cmdList.Flags())
+	cmdCreate := &cobra.Command{
+		Use:   "create <name> <kind>",
+		Short: "Create a new secret",
+		Long: `Create a new secret of "kind", one of "agent-token", "ipmi", or
+"root-password". Its value is read from stdin, or the file given with
+--value-file, so that it is not left in shell histories.`,
+		Args: cobra.ExactArgs(2),
+		Run:  create,
+	}
+	cmdCreate.Flags().StringVar(&valueFile, "value-file", valueFile, "file to read the value from instead of stdin")
+	cmdRotate := &cobra.Command{
+		Use:   "rotate <secret>",
+		Short: "Set a new value for a secret",
+		Long: `Replace the value of a secret, read from stdin or the file given with
+--value-file. Guests and hypervisors referencing the secret get the new value.`,
+		Args: cobra.ExactArgs(1),
+		Run:  rotate,
+
+		ValidArgsFunction: cli.CompleteIDs(listSecretIDs),
+	}
+	cmdRotate.Flags().StringVar(&valueFile, "value-file", valueFile, "file to read the value from instead of stdin")
+	cmdReveal := &cobra.Command{
+		Use:   "reveal <secret>...",
+		Short: "Print the values of secrets",
+		Long:  `Print the decrypted values of secrets. Each access is recorded in the access log of csecretd.`,
+		Run:   reveal,
+
+		ValidArgsFunction: cli.CompleteIDs(listSecretIDs),
+	}
+	cmdDel := &cobra.Command{
+		Use:   "delete <secret>...",
+		Short: "Delete secrets no guest or hypervisor references",
+		Run:   del,
+
+		ValidArgsFunction: cli.CompleteIDs(listSecretIDs),
+	}
+
+	root.AddCommand(cmdList,
+		cmdCreate,
+		cmdRotate,
+		cmdReveal,
+		cmdDel,
+		cli.CompletionCmd(root))
+	if err := root.Execute(); err != nil {
+		cli.Fatal(cli.ExitUsage, log.Fields{"error": err}, "failed to execute root command")
+	}
+}
@@ -0,0 +1,61 @@
+/*
+subnet is the command line interface to the subnets of cnetworkd, the network
+configuration management service. subnet can show the address allocation of
+subnets, for capacity planning.
+
+All commands support dual output formats, a tree like output for humans
+(default) or a json output for further processing.
+
+Usage
+
+The following arguments are understood:
+
+	$ subnet -h
+	subnet is the cli interface to the subnets of cnetworkd. All commands support arguments via command line or stdin
+
+	Usage:
+	  subnet [flags]
+	  subnet [command]
+
+	Available Commands:
+	  addresses   Show the address allocation of subnets
+	  completion  Generate shell completion scripts
+	  help        Help about any command
+
+	Flags:
+	      --dial-timeout duration   how long connecting to the server or proxy may take, 0 for the default of 30s
+	  -h, --help                    help for subnet
+	  -j, --json                    output in json
+	      --proxy string            url of the proxy to connect through, instead of those of $HTTP_PROXY, $HTTPS_PROXY, and $NO_PROXY, or "none" to connect directly
+	  -s, --server string           server address to connect to (default "http://localhost:19000")
+
+	Use "subnet [command] --help" for more information about a command.
+
+Exit Codes
+
+subnet exits 0 on success and otherwise with:
+
+	1  error       any other failure
+	2  usage       bad arguments, flags, or ids
+	3  not_found   the server has no such resource
+	4  validation  an invalid spec, or a request the server rejected as invalid
+	5  server      the server could not be reached, failed, or sent a bad response
+
+The last line written to stderr of a failed run is a json object describing
+the failure, with its kind under "error":
+
+	{"error":"not_found","exit_code":3,"message":"failed to get subnet","status":404,"fields":{...}}
+
+Examples
+
+Show the address allocation of a subnet
+
+	$ subnet addresses a3fd8c1e-62a4-4be1-b0d3-8d9a1fd1b5c2
+	a3fd8c1e-62a4-4be1-b0d3-8d9a1fd1b5c2 10.10.10.10-10.10.10.250 2/241 allocated, 239 available
+	├── 10.10.10.23:4d003c76-71d3-44ad-8518-3337273925ff
+	└── 10.10.10.42:c1f8ae0c-3d8b-4a52-9f1e-6f0a3c2d9b17
+
+	$ subnet -j addresses a3fd8c1e-62a4-4be1-b0d3-8d9a1fd1b5c2
+	{"addresses":{"10.10.10.23":"4d003c76-71d3-44ad-8518-3337273925ff","10.10.10.42":"c1f8ae0c-3d8b-4a52-9f1e-6f0a3c2d9b17"},"allocated":2,"available":239,"cidr":"10.10.10.0/24","end":"10.10.10.250","id":"a3fd8c1e-62a4-4be1-b0d3-8d9a1fd1b5c2","start":"10.10.10.10","total":241}
+*/
+package main
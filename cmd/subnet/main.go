@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness/internal/cli"
+	"github.com/spf13/cobra"
+)
+
+var (
+	server  = "http://localhost:19000"
+	jsonout = false
+
+	transportOpts = cli.TransportOptions{}
+)
+
+// newClient creates a client for the server, connecting as set by --proxy and
+// --dial-timeout
+func newClient() *cli.Client {
+	c := cli.NewClient(server)
+	if err := c.ConfigureTransport(transportOpts); err != nil {
+		cli.Fatal(cli.ExitUsage, log.Fields{
+			"proxy":        transportOpts.Proxy,
+			"dial-timeout": transportOpts.DialTimeout,
+			"error":        err,
+		}, "invalid transport settings")
+	}
+	return c
+}
+
+func help(cmd *cobra.Command, _ []string) {
+	if err := cmd.Help(); err != nil {
+		cli.Fatal(cli.ExitError, log.Fields{"error": err}, "help")
+	}
+}
+
+func getAddresses(c *cli.Client, id string) cli.JMap {
+	addresses, _ := c.Get("addresses", "subnets/"+id+"/addresses")
+	return addresses
+}
+
+// printAddresses prints the address counts of a subnet, followed by a tree of
+// the allocated addresses and the guests they belong to
+func printAddresses(a cli.JMap) {
+	if jsonout {
+		a.Print(jsonout)
+		return
+	}
+
+	fmt.Printf("%s %s-%s %v/%v allocated, %v available\n",
+		a["id"], a["start"], a["end"], a["allocated"], a["total"], a["available"])
+
+	allocated, _ := a["addresses"].(map[string]interface{})
+	if len(allocated) == 0 {
+		return
+	}
+	addresses := make([]string, 0, len(allocated))
+	for address := range allocated {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	for _, address := range addresses[:len(addresses)-1] {
+		fmt.Print("├── ", address, ":", allocated[address], "\n")
+	}
+	address := addresses[len(addresses)-1]
+	fmt.Print("└── ", address, ":", allocated[address], "\n")
+}
+
+func addresses(cmd *cobra.Command, ids []string) {
+	c := newClient()
+	if len(ids) == 0 {
+		ids = cli.Read(os.Stdin)
+	}
+
+	for _, id := range ids {
+		cli.AssertID(id)
+		printAddresses(getAddresses(c, id))
+	}
+}
+
+func main() {
+	root := &cobra.Command{
+		Use:  "subnet",
+		Long: "subnet is the cli interface to the subnets of cnetworkd. All commands support arguments via command line or stdin",
+		Run:  help,
+	}
+	root.PersistentFlags().BoolVarP(&jsonout, "json", "j", jsonout, "output in json")
+	root.PersistentFlags().StringVarP(&server, "server", "s", server, "server address to connect to")
+	transportOpts.AddFlags(root.PersistentFlags())
+
+	cmdAddresses := &cobra.Command{
+		Use:   "addresses <subnet>...",
+		Short: "Show the address allocation of subnets",
+		Long: `Show how many addresses of the range of each subnet are allocated and
+available, and the guest each allocated address belongs to.`,
+		Run: addresses,
+	}
+
+	root.AddCommand(cmdAddresses, cli.CompletionCmd(root))
+	if err := root.Execute(); err != nil {
+		cli.Fatal(cli.ExitUsage, log.Fields{"error": err}, "failed to execute root command")
+	}
+}
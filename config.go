@@ -31,10 +31,14 @@ func (c *Context) GetConfig(key string) (string, error) {
 }
 
 // SetConfig sets a single value from the config store. The key can contain slashes ("/")
+// Values of the keys lochness itself uses, e.g. CPUOvercommitConfig, are validated.
 func (c *Context) SetConfig(key, val string) error {
 	if key == "" {
 		return errors.New("empty config key")
 	}
+	if err := validateConfig(key, val); err != nil {
+		return err
+	}
 
 	err := c.kv.Set(filepath.Join(ConfigPath, key), val)
 	return err
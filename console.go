@@ -0,0 +1,155 @@
+package lochness
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"time"
+
+	"github.com/mistifyio/lochness/pkg/kv"
+	"github.com/pborman/uuid"
+)
+
+// Console types
+const (
+	// ConsoleVNC is the graphical console of a guest
+	ConsoleVNC = "vnc"
+	// ConsoleSerial is the serial console of a guest
+	ConsoleSerial = "serial"
+)
+
+var (
+	// ConsoleTokenPath is the path in the config store
+	ConsoleTokenPath = "lochness/console-tokens/"
+
+	// ConsoleTokenTTL is how long a ConsoleToken may be redeemed for
+	ConsoleTokenTTL = time.Minute
+
+	// ConsoleTypes are the consoles a ConsoleToken may be for
+	ConsoleTypes = map[string]bool{
+		ConsoleVNC:    true,
+		ConsoleSerial: true,
+	}
+
+	// ErrConsoleTokenExpired is returned when redeeming an expired
+	// ConsoleToken
+	ErrConsoleTokenExpired = errors.New("console token expired")
+)
+
+// ConsoleToken grants a single connection to the console of a guest. It is
+// removed when redeemed, and can not be redeemed after it expires.
+type ConsoleToken struct {
+	context       *Context
+	modifiedIndex uint64
+	Token         string    `json:"token"`
+	GuestID       string    `json:"guest"`
+	Type          string    `json:"type"`
+	Expires       time.Time `json:"expires"`
+}
+
+// NewConsoleToken creates and saves a ConsoleToken for a console of a guest.
+// Expired tokens that were never redeemed are removed.
+func (c *Context) NewConsoleToken(guestID, consoleType string) (*ConsoleToken, error) {
+	t := &ConsoleToken{
+		context: c,
+		Token:   uuid.New(),
+		GuestID: guestID,
+		Type:    consoleType,
+		Expires: time.Now().Add(ConsoleTokenTTL),
+	}
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := c.removeExpiredConsoleTokens(); err != nil {
+		return nil, err
+	}
+
+	v, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	index, err := c.kv.Update(t.key(), kv.Value{Data: v})
+	if err != nil {
+		return nil, err
+	}
+	t.modifiedIndex = index
+	return t, nil
+}
+
+// RedeemConsoleToken fetches and removes a ConsoleToken, so that it can only
+// be used once. ErrConsoleTokenExpired is returned if it has expired.
+func (c *Context) RedeemConsoleToken(token string) (*ConsoleToken, error) {
+	var err error
+	token, err = canonicalizeUUID(token)
+	if err != nil {
+		return nil, err
+	}
+	t := &ConsoleToken{
+		context: c,
+		Token:   token,
+	}
+
+	resp, err := c.kv.Get(t.key())
+	if err != nil {
+		return nil, err
+	}
+	if err := t.fromResponse(resp); err != nil {
+		return nil, err
+	}
+
+	// Only one redemption can remove it
+	if err := c.kv.Remove(t.key(), t.modifiedIndex); err != nil {
+		return nil, err
+	}
+	if time.Now().After(t.Expires) {
+		return nil, ErrConsoleTokenExpired
+	}
+	return t, nil
+}
+
+// key is a helper to generate the config store key
+func (t *ConsoleToken) key() string {
+	return filepath.Join(ConsoleTokenPath, t.Token)
+}
+
+// fromResponse is a helper to unmarshal a ConsoleToken
+func (t *ConsoleToken) fromResponse(value kv.Value) error {
+	t.modifiedIndex = value.Index
+	return json.Unmarshal(value.Data, &t)
+}
+
+// Validate ensures a ConsoleToken has reasonable data.
+func (t *ConsoleToken) Validate() error {
+	if _, err := canonicalizeUUID(t.Token); err != nil {
+		return newValidationError("token", "missing or invalid token")
+	}
+	if _, err := canonicalizeUUID(t.GuestID); err != nil {
+		return newValidationError("guest", "missing or invalid guest")
+	}
+	if !ConsoleTypes[t.Type] {
+		return newValidationError("type", "missing or invalid console type")
+	}
+	return nil
+}
+
+// removeExpiredConsoleTokens removes the ConsoleTokens that have expired
+func (c *Context) removeExpiredConsoleTokens() error {
+	values, err := c.kv.GetAll(ConsoleTokenPath)
+	if err != nil {
+		if c.kv.IsKeyNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	now := time.Now()
+	for key, value := range values {
+		t := &ConsoleToken{}
+		if err := t.fromResponse(value); err != nil || now.After(t.Expires) {
+			// Best effort, it may have just been redeemed
+			_ = c.kv.Remove(key, value.Index)
+		}
+	}
+	return nil
+}
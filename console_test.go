@@ -0,0 +1,90 @@
+package lochness_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestConsoleToken(t *testing.T) {
+	suite.Run(t, new(ConsoleTokenSuite))
+}
+
+type ConsoleTokenSuite struct {
+	common.Suite
+}
+
+func (s *ConsoleTokenSuite) TearDownTest() {
+	lochness.ConsoleTokenTTL = time.Minute
+	s.Suite.TearDownTest()
+}
+
+func (s *ConsoleTokenSuite) TestNewConsoleToken() {
+	guestID := uuid.New()
+
+	tests := []struct {
+		description string
+		guestID     string
+		consoleType string
+		expectedErr bool
+	}{
+		{"missing guest", "", lochness.ConsoleVNC, true},
+		{"invalid guest", "asdf", lochness.ConsoleVNC, true},
+		{"invalid type", guestID, "rdp", true},
+		{"vnc", guestID, lochness.ConsoleVNC, false},
+		{"serial", guestID, lochness.ConsoleSerial, false},
+	}
+
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		t, err := s.Context.NewConsoleToken(test.guestID, test.consoleType)
+		if test.expectedErr {
+			s.Error(err, msg("should fail"))
+			s.Nil(t, msg("failure shouldn't return a token"))
+			continue
+		}
+		if !s.NoError(err, msg("should succeed")) {
+			continue
+		}
+		s.NotNil(uuid.Parse(t.Token), msg("should have a token"))
+		s.Equal(test.guestID, t.GuestID, msg("should be for the guest"))
+		s.True(t.Expires.After(time.Now()), msg("should not have expired"))
+	}
+}
+
+func (s *ConsoleTokenSuite) TestRedeemConsoleToken() {
+	t, err := s.Context.NewConsoleToken(uuid.New(), lochness.ConsoleSerial)
+	s.Require().NoError(err)
+
+	redeemed, err := s.Context.RedeemConsoleToken(t.Token)
+	s.Require().NoError(err)
+	s.Equal(t.GuestID, redeemed.GuestID)
+	s.Equal(lochness.ConsoleSerial, redeemed.Type)
+
+	_, err = s.Context.RedeemConsoleToken(t.Token)
+	s.Error(err, "should only be redeemed once")
+	s.True(s.Context.IsKeyNotFound(err), "should no longer exist")
+
+	_, err = s.Context.RedeemConsoleToken("asdf")
+	s.Error(err, "invalid token should fail")
+}
+
+func (s *ConsoleTokenSuite) TestRedeemExpiredConsoleToken() {
+	lochness.ConsoleTokenTTL = -time.Second
+	expired, err := s.Context.NewConsoleToken(uuid.New(), lochness.ConsoleVNC)
+	s.Require().NoError(err)
+	_, err = s.Context.RedeemConsoleToken(expired.Token)
+	s.Equal(lochness.ErrConsoleTokenExpired, err)
+
+	old, err := s.Context.NewConsoleToken(uuid.New(), lochness.ConsoleVNC)
+	s.Require().NoError(err)
+	lochness.ConsoleTokenTTL = time.Minute
+	_, err = s.Context.NewConsoleToken(uuid.New(), lochness.ConsoleVNC)
+	s.Require().NoError(err)
+	_, err = s.Context.RedeemConsoleToken(old.Token)
+	s.True(s.Context.IsKeyNotFound(err), "expired tokens should be removed")
+}
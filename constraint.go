@@ -0,0 +1,455 @@
+package lochness
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Constraint is a parsed guest constraints expression, restricting the
+// hypervisors a guest may be placed on. An expression compares attributes of
+// the hypervisor, and of the guest, with each other or with quoted strings,
+// and combines the comparisons with &&, ||, ! and parentheses, e.g.
+//
+//	metadata.rack != "r12" && hypervisor.metadata.ssd == "true"
+//
+// The comparisons are == and !=, and =~ and !~ matching a regular expression.
+// The attributes are hypervisor.id, hypervisor.ip, hypervisor.mac,
+// hypervisor.maintenance, hypervisor.zone, hypervisor.row, hypervisor.rack,
+// hypervisor.metadata.<key>, hypervisor.config.<key>, guest.id, guest.flavor,
+// guest.image, guest.network, and guest.metadata.<key>. Without the
+// hypervisor. prefix, an attribute is the hypervisor's. Unset failure domains,
+// metadata, and config keys are "". Numbers, true, and false may be given
+// without quotes.
+type Constraint struct {
+	expr string
+	root constraintNode
+}
+
+type (
+	// constraintNode is a boolean node of a constraints expression
+	constraintNode interface {
+		match(g *Guest, h *Hypervisor) bool
+	}
+
+	// constraintOperand is a value compared by a constraints expression
+	constraintOperand interface {
+		value(g *Guest, h *Hypervisor) string
+	}
+
+	constraintAnd struct{ left, right constraintNode }
+	constraintOr  struct{ left, right constraintNode }
+	constraintNot struct{ node constraintNode }
+
+	// constraintCompare compares two operands with ==, !=, or, when re is
+	// set, matches the left against it with =~ or !~
+	constraintCompare struct {
+		left, right constraintOperand
+		re          *regexp.Regexp
+		negate      bool
+	}
+
+	// constraintLiteral is a string, number, or boolean value
+	constraintLiteral string
+
+	// constraintAttribute is an attribute of the hypervisor or guest. key is
+	// the metadata or config key.
+	constraintAttribute struct {
+		guest bool
+		field string
+		key   string
+	}
+)
+
+func (n constraintAnd) match(g *Guest, h *Hypervisor) bool {
+	return n.left.match(g, h) && n.right.match(g, h)
+}
+
+func (n constraintOr) match(g *Guest, h *Hypervisor) bool {
+	return n.left.match(g, h) || n.right.match(g, h)
+}
+
+func (n constraintNot) match(g *Guest, h *Hypervisor) bool {
+	return !n.node.match(g, h)
+}
+
+func (n constraintCompare) match(g *Guest, h *Hypervisor) bool {
+	left := n.left.value(g, h)
+	if n.re != nil {
+		return n.re.MatchString(left) != n.negate
+	}
+	return (left == n.right.value(g, h)) != n.negate
+}
+
+func (l constraintLiteral) value(g *Guest, h *Hypervisor) string {
+	return string(l)
+}
+
+func (a constraintAttribute) value(g *Guest, h *Hypervisor) string {
+	if a.guest {
+		switch a.field {
+		case "id":
+			return g.ID
+		case "flavor":
+			return g.FlavorID
+		case "image":
+			return g.ImageID
+		case "network":
+			return g.NetworkID
+		case "metadata":
+			return g.Metadata[a.key]
+		}
+		return ""
+	}
+
+	switch a.field {
+	case "id":
+		return h.ID
+	case "ip":
+		if h.IP == nil {
+			return ""
+		}
+		return h.IP.String()
+	case "mac":
+		return h.MAC.String()
+	case "maintenance":
+		return strconv.FormatBool(h.Maintenance)
+	case "zone", "row", "rack":
+		return h.FailureDomain.name(a.field)
+	case "metadata":
+		return h.Metadata[a.key]
+	case "config":
+		return h.Config[a.key]
+	}
+	return ""
+}
+
+// constraintFields are the attributes of the hypervisor and guest in a
+// constraints expression, and whether they take a key
+var constraintFields = map[bool]map[string]bool{
+	false: {"id": false, "ip": false, "mac": false, "maintenance": false, "zone": false, "row": false, "rack": false, "metadata": true, "config": true},
+	true:  {"id": false, "flavor": false, "image": false, "network": false, "metadata": true},
+}
+
+// ParseConstraint parses a constraints expression, see Constraint. It returns
+// a validation error of the constraints field if the expression is invalid.
+func ParseConstraint(expr string) (*Constraint, error) {
+	tokens, err := lexConstraint(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &constraintParser{expr: expr, tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != constraintEOF {
+		return nil, p.errorf(t, "unexpected %q", t.text)
+	}
+	return &Constraint{expr: expr, root: root}, nil
+}
+
+// String returns the expression of the constraint
+func (c *Constraint) String() string {
+	return c.expr
+}
+
+// Match returns whether the hypervisor meets the constraint for the guest
+func (c *Constraint) Match(g *Guest, h *Hypervisor) bool {
+	return c.root.match(g, h)
+}
+
+// CandidateMatchesConstraints returns Hypervisors meeting the constraints
+// expression of the Guest, see Constraint. If none do, it returns a validation
+// error.
+func CandidateMatchesConstraints(g *Guest, hs Hypervisors) (Hypervisors, error) {
+	if strings.TrimSpace(g.Constraints) == "" {
+		return hs, nil
+	}
+
+	logFields := log.Fields{
+		"guestID": g.ID,
+		"func":    "CandidateMatchesConstraints",
+	}
+
+	c, err := ParseConstraint(g.Constraints)
+	if err != nil {
+		return nil, err
+	}
+
+	var hypervisors Hypervisors
+	for _, h := range hs {
+		if c.Match(g, h) {
+			hypervisors = append(hypervisors, h)
+		} else {
+			log.WithFields(logFields).WithFields(log.Fields{
+				"hypervisorID": h.ID,
+			}).Debug("hypervisor candidate failed")
+		}
+	}
+
+	log.WithFields(logFields).WithFields(log.Fields{
+		"in":      len(hs),
+		"out":     len(hypervisors),
+		"removed": len(hs) - len(hypervisors),
+	}).Info("hypervisor candidates filtered")
+
+	if len(hypervisors) == 0 && len(hs) > 0 {
+		return nil, newValidationError("constraints", fmt.Sprintf("no hypervisor meets the constraints %q", g.Constraints))
+	}
+	return hypervisors, nil
+}
+
+// Kinds of constraints expression tokens
+const (
+	constraintEOF = iota
+	constraintIdent
+	constraintString
+	constraintOp
+	constraintLParen
+	constraintRParen
+)
+
+// constraintToken is a token of a constraints expression and its offset
+type constraintToken struct {
+	kind int
+	text string
+	pos  int
+}
+
+// constraintOps are the operators of a constraints expression
+var constraintOps = []string{"&&", "||", "==", "!=", "=~", "!~", "!"}
+
+// lexConstraint splits a constraints expression into tokens. The text of a
+// string token is its unquoted value.
+func lexConstraint(expr string) ([]constraintToken, error) {
+	var tokens []constraintToken
+	i := 0
+outer:
+	for i < len(expr) {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case c == '(':
+			tokens = append(tokens, constraintToken{constraintLParen, "(", i})
+			i++
+			continue
+		case c == ')':
+			tokens = append(tokens, constraintToken{constraintRParen, ")", i})
+			i++
+			continue
+		case c == '"':
+			end := i + 1
+			for ; end < len(expr); end++ {
+				if expr[end] == '\\' {
+					end++
+					continue
+				}
+				if expr[end] == '"' {
+					break
+				}
+			}
+			if end >= len(expr) {
+				return nil, constraintError(expr, i, "unterminated string")
+			}
+			value, err := strconv.Unquote(expr[i : end+1])
+			if err != nil {
+				return nil, constraintError(expr, i, "invalid string")
+			}
+			tokens = append(tokens, constraintToken{constraintString, value, i})
+			i = end + 1
+			continue
+		case isConstraintIdentChar(c):
+			end := i
+			for end < len(expr) && isConstraintIdentChar(expr[end]) {
+				end++
+			}
+			tokens = append(tokens, constraintToken{constraintIdent, expr[i:end], i})
+			i = end
+			continue
+		}
+		for _, op := range constraintOps {
+			if strings.HasPrefix(expr[i:], op) {
+				tokens = append(tokens, constraintToken{constraintOp, op, i})
+				i += len(op)
+				continue outer
+			}
+		}
+		return nil, constraintError(expr, i, fmt.Sprintf("unexpected %q", c))
+	}
+	return append(tokens, constraintToken{constraintEOF, "end of expression", len(expr)}), nil
+}
+
+// isConstraintIdentChar returns whether c may be part of an attribute name or
+// unquoted literal
+func isConstraintIdentChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '.' || c == '_' || c == '-' || c == '/'
+}
+
+// constraintError creates the validation error of an invalid constraints
+// expression
+func constraintError(expr string, pos int, message string) *ValidationError {
+	return newValidationError("constraints", fmt.Sprintf("invalid constraints %q: %s at offset %d", expr, message, pos))
+}
+
+// constraintParser is a recursive descent parser of constraints expressions:
+//
+//	or      = and { "||" and }
+//	and     = unary { "&&" unary }
+//	unary   = "!" unary | "(" or ")" | operand ( "==" | "!=" | "=~" | "!~" ) operand
+type constraintParser struct {
+	expr   string
+	tokens []constraintToken
+	next   int
+}
+
+func (p *constraintParser) peek() constraintToken {
+	return p.tokens[p.next]
+}
+
+func (p *constraintParser) take() constraintToken {
+	t := p.tokens[p.next]
+	if t.kind != constraintEOF {
+		p.next++
+	}
+	return t
+}
+
+func (p *constraintParser) errorf(t constraintToken, format string, args ...interface{}) error {
+	return constraintError(p.expr, t.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *constraintParser) parseOr() (constraintNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); t.kind == constraintOp && t.text == "||"; t = p.peek() {
+		p.take()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = constraintOr{left, right}
+	}
+	return left, nil
+}
+
+func (p *constraintParser) parseAnd() (constraintNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); t.kind == constraintOp && t.text == "&&"; t = p.peek() {
+		p.take()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = constraintAnd{left, right}
+	}
+	return left, nil
+}
+
+func (p *constraintParser) parseUnary() (constraintNode, error) {
+	t := p.peek()
+	switch {
+	case t.kind == constraintOp && t.text == "!":
+		p.take()
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return constraintNot{node}, nil
+	case t.kind == constraintLParen:
+		p.take()
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.take(); t.kind != constraintRParen {
+			return nil, p.errorf(t, "expected \")\", found %q", t.text)
+		}
+		return node, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *constraintParser) parseCompare() (constraintNode, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	op := p.take()
+	if op.kind != constraintOp || op.text == "&&" || op.text == "||" || op.text == "!" {
+		return nil, p.errorf(op, "expected a comparison, found %q", op.text)
+	}
+	rightToken := p.peek()
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	n := constraintCompare{left: left, right: right, negate: op.text == "!=" || op.text == "!~"}
+	if op.text == "=~" || op.text == "!~" {
+		pattern, ok := right.(constraintLiteral)
+		if !ok {
+			return nil, p.errorf(rightToken, "expected a regular expression, found %q", rightToken.text)
+		}
+		if n.re, err = regexp.Compile(string(pattern)); err != nil {
+			return nil, p.errorf(rightToken, "invalid regular expression: %s", err)
+		}
+	}
+	return n, nil
+}
+
+func (p *constraintParser) parseOperand() (constraintOperand, error) {
+	t := p.take()
+	switch t.kind {
+	case constraintString:
+		return constraintLiteral(t.text), nil
+	case constraintIdent:
+		if t.text == "true" || t.text == "false" || t.text[0] >= '0' && t.text[0] <= '9' {
+			return constraintLiteral(t.text), nil
+		}
+		attr, ok := parseConstraintAttribute(t.text)
+		if !ok {
+			return nil, p.errorf(t, "unknown attribute %q", t.text)
+		}
+		return attr, nil
+	}
+	return nil, p.errorf(t, "expected an attribute or value, found %q", t.text)
+}
+
+// parseConstraintAttribute parses the name of an attribute, e.g.
+// hypervisor.metadata.rack
+func parseConstraintAttribute(name string) (constraintAttribute, bool) {
+	attr := constraintAttribute{}
+	switch {
+	case strings.HasPrefix(name, "guest."):
+		attr.guest = true
+		name = strings.TrimPrefix(name, "guest.")
+	case strings.HasPrefix(name, "hypervisor."):
+		name = strings.TrimPrefix(name, "hypervisor.")
+	}
+
+	parts := strings.SplitN(name, ".", 2)
+	attr.field = parts[0]
+	takesKey, ok := constraintFields[attr.guest][attr.field]
+	if !ok || takesKey != (len(parts) == 2) {
+		return attr, false
+	}
+	if takesKey {
+		attr.key = parts[1]
+		if attr.key == "" {
+			return attr, false
+		}
+	}
+	return attr, true
+}
@@ -0,0 +1,122 @@
+package lochness_test
+
+import (
+	"testing"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestConstraint(t *testing.T) {
+	suite.Run(t, new(ConstraintSuite))
+}
+
+type ConstraintSuite struct {
+	common.Suite
+}
+
+func (s *ConstraintSuite) TestParseConstraint() {
+	tests := []struct {
+		description string
+		expr        string
+		expectedErr bool
+	}{
+		{"equal", `metadata.rack == "r12"`, false},
+		{"and", `metadata.rack != "r12" && hypervisor.metadata.ssd == "true"`, false},
+		{"or not parens", `!(metadata.rack == "r12" || guest.metadata.tier =~ "^db")`, false},
+		{"unquoted literals", `hypervisor.maintenance == false && config.generation != 3`, false},
+		{"attributes", `hypervisor.id != guest.id`, false},
+		{"empty", ``, true},
+		{"no comparison", `metadata.rack`, true},
+		{"failure domain", `hypervisor.zone == "z1" && rack != "r12"`, false},
+		{"unknown attribute", `hypervisor.aisle == "a12"`, true},
+		{"missing key", `metadata == "r12"`, true},
+		{"unexpected key", `guest.flavor.id == "x"`, true},
+		{"unterminated string", `metadata.rack == "r12`, true},
+		{"unbalanced parens", `(metadata.rack == "r12"`, true},
+		{"trailing", `metadata.rack == "r12" "r13"`, true},
+		{"invalid regexp", `metadata.rack =~ "("`, true},
+		{"regexp attribute", `metadata.rack =~ guest.metadata.rack`, true},
+		{"bad operator", `metadata.rack = "r12"`, true},
+	}
+
+	for _, test := range tests {
+		c, err := lochness.ParseConstraint(test.expr)
+		if test.expectedErr {
+			s.Error(err, test.description)
+			s.True(lerrors.IsValidation(err), test.description)
+			s.Nil(c, test.description)
+		} else {
+			s.NoError(err, test.description)
+			s.Equal(test.expr, c.String(), test.description)
+		}
+	}
+}
+
+func (s *ConstraintSuite) TestMatch() {
+	guest := s.NewGuest()
+	guest.Metadata["tier"] = "db-primary"
+	h := s.NewHypervisor()
+	h.Metadata["rack"] = "r7"
+	h.Metadata["ssd"] = "true"
+	h.FailureDomain = &lochness.FailureDomain{Zone: "z1", Rack: "r7"}
+
+	tests := []struct {
+		expr     string
+		expected bool
+	}{
+		{`metadata.rack != "r12" && hypervisor.metadata.ssd == "true"`, true},
+		{`metadata.rack == "r12" || metadata.ssd == "false"`, false},
+		{`!(metadata.rack == "r12")`, true},
+		{`metadata.row == ""`, true},
+		{`metadata.rack =~ "^r[0-9]$" && guest.metadata.tier !~ "^web"`, true},
+		{`hypervisor.maintenance == false`, true},
+		{`hypervisor.id == "` + h.ID + `" && guest.id == "` + guest.ID + `"`, true},
+		{`ip == "192.168.100.11"`, true},
+		{`zone == "z1" && row == "" && hypervisor.rack == metadata.rack`, true},
+		{`metadata.rack == "r7" && metadata.ssd == "false" || guest.metadata.tier == "db-primary"`, true},
+	}
+
+	for _, test := range tests {
+		c, err := lochness.ParseConstraint(test.expr)
+		s.Require().NoError(err, test.expr)
+		s.Equal(test.expected, c.Match(guest, h), test.expr)
+	}
+}
+
+func (s *ConstraintSuite) TestCandidateMatchesConstraints() {
+	guest := s.NewGuest()
+	hypervisors := lochness.Hypervisors{
+		s.NewHypervisor(),
+		s.NewHypervisor(),
+	}
+	hypervisors[0].Metadata["rack"] = "r12"
+	hypervisors[1].Metadata["rack"] = "r13"
+
+	candidates, err := lochness.CandidateMatchesConstraints(guest, hypervisors)
+	s.NoError(err)
+	s.Len(candidates, 2, "no constraints should keep every hypervisor")
+
+	guest.Constraints = `metadata.rack != "r12"`
+	candidates, err = lochness.CandidateMatchesConstraints(guest, hypervisors)
+	s.NoError(err)
+	s.Require().Len(candidates, 1)
+	s.Equal(hypervisors[1].ID, candidates[0].ID)
+
+	guest.Constraints = `metadata.rack == "r14"`
+	_, err = lochness.CandidateMatchesConstraints(guest, hypervisors)
+	s.True(lerrors.IsValidation(err))
+}
+
+func (s *ConstraintSuite) TestGuestValidate() {
+	guest := s.NewGuest()
+	guest.Constraints = `metadata.rack != "r12"`
+	s.NoError(guest.Validate())
+
+	guest.Constraints = `metadata.rack !=`
+	err := guest.Validate()
+	s.Require().Error(err)
+	s.Equal([]string{"constraints"}, err.(*lochness.ValidationError).Fields)
+}
@@ -1,22 +1,64 @@
 package lochness
 
 import (
+	"time"
+
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
 	"github.com/mistifyio/lochness/pkg/kv"
 )
 
 // Context carries around data/structs needed for operations
 type Context struct {
-	kv kv.KV
+	kv        kv.KV // raw with policy applied
+	raw       kv.KV
+	policy    KVPolicy
+	secretKey []byte // encrypts secret values, see WithSecretKey
 }
 
-// NewContext creates a new context
+// NewContext creates a new context. KV operations have no timeout and are not
+// retried; see WithTimeout and WithRetry.
 func NewContext(kv kv.KV) *Context {
 	return &Context{
-		kv: kv,
+		kv:  kv,
+		raw: kv,
 	}
 }
 
-// IsKeyNotFound is a helper to determine if the error is a key not found error
+// IsKeyNotFound is a helper to determine if the error is a key not found
+// error, i.e. an errors.ErrNotFound
 func (c *Context) IsKeyNotFound(err error) bool {
-	return c.kv.IsKeyNotFound(err)
+	return lerrors.IsNotFound(err)
+}
+
+// KVPolicy returns the timeout and retry policy of the context's KV operations
+func (c *Context) KVPolicy() KVPolicy {
+	return c.policy
+}
+
+// WithTimeout returns a copy of the context whose KV operations fail with
+// ErrKVTimeout if they take longer than timeout. Zero disables the timeout.
+func (c *Context) WithTimeout(timeout time.Duration) *Context {
+	policy := c.policy
+	policy.Timeout = timeout
+	return c.withPolicy(policy)
+}
+
+// WithRetry returns a copy of the context whose idempotent KV operations are
+// retried up to retries times when they fail, waiting wait before the first
+// retry and twice as long before each next one.
+func (c *Context) WithRetry(retries int, wait time.Duration) *Context {
+	policy := c.policy
+	policy.Retries = retries
+	policy.RetryWait = wait
+	return c.withPolicy(policy)
+}
+
+// withPolicy returns a copy of the context with the KV policy applied
+func (c *Context) withPolicy(policy KVPolicy) *Context {
+	return &Context{
+		kv:        withKVPolicy(c.raw, policy),
+		raw:       c.raw,
+		policy:    policy,
+		secretKey: c.secretKey,
+	}
 }
@@ -2,9 +2,14 @@ package lochness_test
 
 import (
 	"errors"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/mistifyio/lochness"
 	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/mistifyio/lochness/pkg/kv"
+	"github.com/pborman/uuid"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -29,3 +34,84 @@ func (s *ContextSuite) TestIsKeyNotFound() {
 	err = errors.New("some-random-non-key-not-found-error")
 	s.False(s.KV.IsKeyNotFound(err))
 }
+
+// flakyKV fails the first gets and sets, and delays every get and set
+type flakyKV struct {
+	kv.KV
+	mu    sync.Mutex
+	fails int
+	delay time.Duration
+	calls int
+}
+
+func (f *flakyKV) fail() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.calls <= f.fails {
+		return errors.New("unavailable")
+	}
+	return nil
+}
+
+func (f *flakyKV) Get(key string) (kv.Value, error) {
+	time.Sleep(f.delay)
+	if err := f.fail(); err != nil {
+		return kv.Value{}, err
+	}
+	return f.KV.Get(key)
+}
+
+func (f *flakyKV) Update(key string, value kv.Value) (uint64, error) {
+	time.Sleep(f.delay)
+	if err := f.fail(); err != nil {
+		return 0, err
+	}
+	return f.KV.Update(key, value)
+}
+
+func (s *ContextSuite) TestWithTimeout() {
+	flavor := s.NewFlavor()
+	flaky := &flakyKV{KV: s.KV, delay: 50 * time.Millisecond}
+	ctx := lochness.NewContext(flaky)
+
+	_, err := ctx.Flavor(flavor.ID)
+	s.NoError(err, "no timeout should wait for the kv")
+
+	timeoutCtx := ctx.WithTimeout(10 * time.Millisecond)
+	s.Equal(10*time.Millisecond, timeoutCtx.KVPolicy().Timeout)
+	s.Zero(ctx.KVPolicy().Timeout, "original context should be unchanged")
+
+	_, err = timeoutCtx.Flavor(flavor.ID)
+	s.Equal(lochness.ErrKVTimeout, err)
+
+	f := timeoutCtx.NewFlavor()
+	f.Image = uuid.New()
+	s.Equal(lochness.ErrKVTimeout, f.Save())
+
+	_, err = ctx.WithTimeout(time.Second).Flavor(flavor.ID)
+	s.NoError(err, "a long enough timeout should succeed")
+}
+
+func (s *ContextSuite) TestWithRetry() {
+	flavor := s.NewFlavor()
+	flaky := &flakyKV{KV: s.KV, fails: 2}
+	ctx := lochness.NewContext(flaky)
+
+	_, err := ctx.WithRetry(1, time.Millisecond).Flavor(flavor.ID)
+	s.Error(err, "too few retries should fail")
+
+	flaky.calls = 0
+	_, err = ctx.WithRetry(2, time.Millisecond).Flavor(flavor.ID)
+	s.NoError(err, "enough retries should succeed")
+
+	flaky.calls = 0
+	_, err = ctx.WithRetry(5, time.Millisecond).Flavor(uuid.New())
+	s.True(ctx.IsKeyNotFound(err), "missing keys should not be retried")
+	s.Equal(3, flaky.calls)
+
+	flaky.calls = 0
+	f := ctx.WithRetry(5, time.Millisecond).NewFlavor()
+	f.Image = uuid.New()
+	s.Error(f.Save(), "atomic updates should not be retried")
+}
@@ -0,0 +1,193 @@
+package lochness
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"time"
+
+	"github.com/mistifyio/lochness/pkg/kv"
+)
+
+type (
+	// DesiredState is the set of guests a hypervisor should be running. It is
+	// assembled from the guests assigned to the hypervisor so that a node can
+	// converge on it rather than relying on seeing every individual job.
+	// Generation increases whenever the assignment or an assigned guest changes.
+	DesiredState struct {
+		HypervisorID string `json:"hypervisor"`
+		Generation   uint64 `json:"generation"`
+		Guests       Guests `json:"guests"`
+	}
+
+	// DesiredStateAck is reported by a hypervisor once it has converged, or
+	// failed to converge, on a generation of its DesiredState.
+	DesiredStateAck struct {
+		Generation uint64    `json:"generation"`
+		Error      string    `json:"error,omitempty"`
+		Timestamp  time.Time `json:"timestamp"`
+	}
+)
+
+// desiredStateKey is a helper for generating a key for config store.
+// The modified index of this key is used as the generation.
+func (h *Hypervisor) desiredStateKey() string {
+	return filepath.Join(HypervisorPath, h.ID, "desired", "generation")
+}
+
+// desiredStateAckKey is a helper for generating a key for config store.
+func (h *Hypervisor) desiredStateAckKey() string {
+	return filepath.Join(HypervisorPath, h.ID, "desired", "ack")
+}
+
+// BumpGeneration marks the DesiredState of the Hypervisor as changed and
+// returns the new generation.
+func (h *Hypervisor) BumpGeneration() (uint64, error) {
+	key := h.desiredStateKey()
+	if err := h.context.kv.Set(key, time.Now().String()); err != nil {
+		return 0, err
+	}
+	return h.Generation()
+}
+
+// Generation returns the current generation of the Hypervisor DesiredState.
+// It is 0 if the desired state has never changed.
+func (h *Hypervisor) Generation() (uint64, error) {
+	value, err := h.context.kv.Get(h.desiredStateKey())
+	if err != nil {
+		if h.context.kv.IsKeyNotFound(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return value.Index, nil
+}
+
+// DesiredState assembles the current DesiredState of the Hypervisor.
+func (h *Hypervisor) DesiredState() (*DesiredState, error) {
+	// The generation is read before the guests so the guests are never older
+	// than the generation reported alongside them
+	generation, err := h.Generation()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.Refresh(); err != nil {
+		return nil, err
+	}
+
+	ds := &DesiredState{
+		HypervisorID: h.ID,
+		Generation:   generation,
+		Guests:       make(Guests, 0, len(h.guests)),
+	}
+	err = h.ForEachGuest(func(g *Guest) error {
+		ds.Guests = append(ds.Guests, g)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ds, nil
+}
+
+// WaitDesiredState blocks until the generation of the Hypervisor DesiredState
+// is newer than after or timeout elapses, then returns the DesiredState.
+// Callers should compare the returned generation to after to tell the two apart.
+func (h *Hypervisor) WaitDesiredState(after uint64, timeout time.Duration) (*DesiredState, error) {
+	key := h.desiredStateKey()
+
+	generation, err := h.Generation()
+	if err != nil {
+		return nil, err
+	}
+
+	if generation <= after && timeout > 0 {
+		stop := make(chan struct{})
+		defer close(stop)
+
+		events, errs, err := h.context.kv.Watch(key, generation, stop)
+		if err != nil {
+			return nil, err
+		}
+
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+	LOOP:
+		for {
+			select {
+			case <-events:
+				generation, err = h.Generation()
+				if err != nil {
+					return nil, err
+				}
+				if generation > after {
+					break LOOP
+				}
+			case err := <-errs:
+				return nil, err
+			case <-timer.C:
+				break LOOP
+			}
+		}
+	}
+
+	return h.DesiredState()
+}
+
+// AckDesiredState records the result of converging on a generation.
+// A nil result indicates success.
+func (h *Hypervisor) AckDesiredState(generation uint64, result error) error {
+	ack := DesiredStateAck{
+		Generation: generation,
+		Timestamp:  time.Now(),
+	}
+	if result != nil {
+		ack.Error = result.Error()
+	}
+	return h.saveDesiredStateAck(ack)
+}
+
+// saveDesiredStateAck persists an ack. Acks for generations older than the
+// stored one are ignored so that a delayed report can not roll it back.
+func (h *Hypervisor) saveDesiredStateAck(ack DesiredStateAck) error {
+	key := h.desiredStateAckKey()
+
+	current, index, err := h.desiredStateAck()
+	if err != nil {
+		return err
+	}
+	if current != nil && current.Generation > ack.Generation {
+		return nil
+	}
+
+	v, err := json.Marshal(ack)
+	if err != nil {
+		return err
+	}
+	_, err = h.context.kv.Update(key, kv.Value{Data: v, Index: index})
+	return err
+}
+
+// DesiredStateAck returns the last ack reported by the Hypervisor, or nil if
+// there has been none.
+func (h *Hypervisor) DesiredStateAck() (*DesiredStateAck, error) {
+	ack, _, err := h.desiredStateAck()
+	return ack, err
+}
+
+// desiredStateAck fetches the ack along with its modified index.
+func (h *Hypervisor) desiredStateAck() (*DesiredStateAck, uint64, error) {
+	value, err := h.context.kv.Get(h.desiredStateAckKey())
+	if err != nil {
+		if h.context.kv.IsKeyNotFound(err) {
+			return nil, 0, nil
+		}
+		return nil, 0, err
+	}
+
+	ack := &DesiredStateAck{}
+	if err := json.Unmarshal(value.Data, ack); err != nil {
+		return nil, 0, err
+	}
+	return ack, value.Index, nil
+}
@@ -0,0 +1,142 @@
+package lochness_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestDesiredState(t *testing.T) {
+	suite.Run(t, new(DesiredStateSuite))
+}
+
+type DesiredStateSuite struct {
+	common.Suite
+}
+
+func (s *DesiredStateSuite) TestGeneration() {
+	hypervisor := s.NewHypervisor()
+	generation, err := hypervisor.Generation()
+	s.NoError(err)
+	s.Equal(uint64(0), generation, "untouched hypervisor should have no generation")
+
+	bumped, err := hypervisor.BumpGeneration()
+	s.NoError(err)
+	s.True(bumped > generation, "bump should increase generation")
+
+	generation, err = hypervisor.Generation()
+	s.NoError(err)
+	s.Equal(bumped, generation, "generation should match bump")
+}
+
+func (s *DesiredStateSuite) TestGuestChangesBumpGeneration() {
+	hypervisor, guest := s.NewHypervisorWithGuest()
+	added, err := hypervisor.Generation()
+	s.NoError(err)
+	s.NotEqual(uint64(0), added, "adding a guest should bump generation")
+
+	guest.Metadata["foo"] = "bar"
+	s.NoError(guest.Save())
+	saved, _ := hypervisor.Generation()
+	s.Equal(added, saved, "saving a guest unchanged in the desired state should not bump generation")
+
+	guest.FlavorID = s.NewFlavor().ID
+	s.NoError(guest.Save())
+	resized, _ := hypervisor.Generation()
+	s.True(resized > saved, "changing the flavor of a guest should bump generation")
+
+	s.NoError(guest.SoftDelete(time.Hour))
+	deleted, _ := hypervisor.Generation()
+	s.True(deleted > resized, "deleting a guest should bump generation")
+
+	s.NoError(guest.Restore())
+	restored, _ := hypervisor.Generation()
+	s.True(restored > deleted, "restoring a guest should bump generation")
+
+	s.NoError(hypervisor.RemoveGuest(guest))
+	removed, _ := hypervisor.Generation()
+	s.True(removed > restored, "removing a guest should bump generation")
+}
+
+func (s *DesiredStateSuite) TestGuestMoveBumpsGeneration() {
+	from, guest := s.NewHypervisorWithGuest()
+	to := s.NewHypervisor()
+	fromGeneration, _ := from.Generation()
+
+	guest.HypervisorID = to.ID
+	s.NoError(guest.Save())
+	moved, _ := from.Generation()
+	s.True(moved > fromGeneration, "the hypervisor a guest left should bump generation")
+	toGeneration, _ := to.Generation()
+	s.NotEqual(uint64(0), toGeneration, "the hypervisor a guest joined should bump generation")
+}
+
+func (s *DesiredStateSuite) TestDesiredState() {
+	hypervisor, guest := s.NewHypervisorWithGuest()
+
+	desired, err := hypervisor.DesiredState()
+	s.NoError(err)
+	s.Equal(hypervisor.ID, desired.HypervisorID)
+	generation, _ := hypervisor.Generation()
+	s.Equal(generation, desired.Generation)
+	s.Len(desired.Guests, 1)
+	s.Equal(guest.ID, desired.Guests[0].ID)
+
+	s.NoError(hypervisor.RemoveGuest(guest))
+	desired, err = hypervisor.DesiredState()
+	s.NoError(err)
+	s.Len(desired.Guests, 0)
+}
+
+func (s *DesiredStateSuite) TestWaitDesiredState() {
+	hypervisor, guest := s.NewHypervisorWithGuest()
+	generation, _ := hypervisor.Generation()
+
+	// Already newer
+	desired, err := hypervisor.WaitDesiredState(generation-1, time.Second)
+	s.NoError(err)
+	s.Equal(generation, desired.Generation)
+
+	// Timeout
+	start := time.Now()
+	desired, err = hypervisor.WaitDesiredState(generation, 500*time.Millisecond)
+	s.NoError(err)
+	s.Equal(generation, desired.Generation)
+	s.True(time.Since(start) >= 500*time.Millisecond, "should wait for timeout")
+
+	// Change while waiting
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		guest.FlavorID = s.NewFlavor().ID
+		_ = guest.Save()
+	}()
+	desired, err = hypervisor.WaitDesiredState(generation, 5*time.Second)
+	s.NoError(err)
+	s.True(desired.Generation > generation, "should return newer generation")
+}
+
+func (s *DesiredStateSuite) TestAckDesiredState() {
+	hypervisor := s.NewHypervisor()
+
+	ack, err := hypervisor.DesiredStateAck()
+	s.NoError(err)
+	s.Nil(ack, "should be no ack before one is reported")
+
+	s.NoError(hypervisor.AckDesiredState(5, nil))
+	ack, err = hypervisor.DesiredStateAck()
+	s.NoError(err)
+	s.Equal(uint64(5), ack.Generation)
+	s.Empty(ack.Error)
+
+	s.NoError(hypervisor.AckDesiredState(7, errors.New("failed")))
+	ack, _ = hypervisor.DesiredStateAck()
+	s.Equal(uint64(7), ack.Generation)
+	s.Equal("failed", ack.Error)
+
+	s.NoError(hypervisor.AckDesiredState(6, nil))
+	ack, _ = hypervisor.DesiredStateAck()
+	s.Equal(uint64(7), ack.Generation, "older ack should be ignored")
+}
@@ -0,0 +1,198 @@
+package lochness
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/mistifyio/lochness/pkg/kv"
+)
+
+var (
+	// DNSRecordPath is the path in the config store of the DNS records of
+	// the guests, <domain>/<host> under it, each the id of its guest
+	DNSRecordPath = "lochness/dns/"
+
+	// MaxDNSNameLength is the longest DNS name a guest may have
+	MaxDNSNameLength = 253
+
+	// ErrDNSNameInUse is returned when saving a guest with the DNS name of
+	// another guest
+	ErrDNSNameInUse = lerrors.Conflict(errors.New("the dns name is in use by another guest"))
+)
+
+// dnsLabelRegexp matches valid DNS labels, of lowercase letters, digits, and
+// hyphens, neither starting nor ending with a hyphen
+var dnsLabelRegexp = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// DNSRecord is the DNS name of a guest, its host Name in Domain. Names are
+// unique, so that no two guests have the same host in a domain.
+type DNSRecord struct {
+	modifiedIndex uint64
+	Name          string `json:"name"` // fully qualified, e.g. web1.example.com
+	Host          string `json:"host"`
+	Domain        string `json:"domain"`
+	GuestID       string `json:"guest"`
+}
+
+// ParseDNSName splits a fully qualified DNS name, such as web1.example.com,
+// into its host, web1, and domain, example.com. Names are of at least two
+// lowercase labels, without a trailing dot.
+func ParseDNSName(name string) (string, string, error) {
+	invalid := newValidationError("dns_name", fmt.Sprintf("invalid dns name %q: must be a lowercase fully qualified name, e.g. web1.example.com", name))
+	if len(name) > MaxDNSNameLength {
+		return "", "", invalid
+	}
+	parts := strings.SplitN(name, ".", 2)
+	if len(parts) != 2 || !dnsLabelRegexp.MatchString(parts[0]) || validateDNSDomain(parts[1]) != nil {
+		return "", "", invalid
+	}
+	return parts[0], parts[1], nil
+}
+
+// validateDNSDomain checks that a domain is of lowercase DNS labels
+func validateDNSDomain(domain string) error {
+	for _, label := range strings.Split(domain, ".") {
+		if !dnsLabelRegexp.MatchString(label) {
+			return newValidationError("domain", fmt.Sprintf("invalid domain %q", domain))
+		}
+	}
+	return nil
+}
+
+// dnsRecordKey is a helper to generate the config store key of a DNS name
+func dnsRecordKey(host, domain string) string {
+	return filepath.Join(DNSRecordPath, domain, host)
+}
+
+// DNSRecord returns the record of a DNS name, a not found error if no guest
+// has it
+func (c *Context) DNSRecord(name string) (*DNSRecord, error) {
+	host, domain, err := ParseDNSName(name)
+	if err != nil {
+		return nil, err
+	}
+	value, err := c.kv.Get(dnsRecordKey(host, domain))
+	if err != nil {
+		return nil, err
+	}
+	return &DNSRecord{
+		modifiedIndex: value.Index,
+		Name:          name,
+		Host:          host,
+		Domain:        domain,
+		GuestID:       string(value.Data),
+	}, nil
+}
+
+// DNSRecords returns the records of the guests in a domain, or in every domain
+// if it is blank, sorted by domain and host. The records of subdomains are
+// not those of the domain.
+func (c *Context) DNSRecords(domain string) ([]*DNSRecord, error) {
+	prefix := DNSRecordPath
+	if domain != "" {
+		if err := validateDNSDomain(domain); err != nil {
+			return nil, err
+		}
+		prefix = filepath.Join(DNSRecordPath, domain)
+	}
+
+	records := []*DNSRecord{}
+	values, err := c.kv.GetAll(prefix)
+	if err != nil {
+		if c.IsKeyNotFound(err) {
+			return records, nil
+		}
+		return nil, err
+	}
+	for key, value := range values {
+		rel := strings.TrimPrefix(strings.TrimPrefix(key, "/"), DNSRecordPath)
+		i := strings.LastIndex(rel, "/")
+		if i < 0 || (domain != "" && rel[:i] != domain) {
+			continue
+		}
+		r := &DNSRecord{
+			modifiedIndex: value.Index,
+			Host:          rel[i+1:],
+			Domain:        rel[:i],
+			GuestID:       string(value.Data),
+		}
+		r.Name = r.Host + "." + r.Domain
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Domain != records[j].Domain {
+			return records[i].Domain < records[j].Domain
+		}
+		return records[i].Host < records[j].Host
+	})
+	return records, nil
+}
+
+// dnsOps returns the writes moving the DNS record of the guest from the name
+// it was saved with to its current one. ErrDNSNameInUse is returned if another
+// guest has the name, and the write fails if another guest takes it first.
+func (g *Guest) dnsOps() ([]kv.Op, error) {
+	if g.DNSName == g.savedDNSName {
+		return nil, nil
+	}
+
+	var ops []kv.Op
+	if g.DNSName != "" {
+		host, domain, err := ParseDNSName(g.DNSName)
+		if err != nil {
+			return nil, err
+		}
+		record, err := g.context.DNSRecord(g.DNSName)
+		switch {
+		case err == nil && record.GuestID != g.ID:
+			return nil, ErrDNSNameInUse
+		case err == nil:
+			// already the guest's own
+		case g.context.IsKeyNotFound(err):
+			ops = append(ops, kv.Op{
+				Key:   dnsRecordKey(host, domain),
+				Value: kv.Value{Data: []byte(g.ID)},
+			})
+		default:
+			return nil, err
+		}
+	}
+
+	if g.savedDNSName != "" {
+		record, err := g.context.DNSRecord(g.savedDNSName)
+		switch {
+		case err == nil && record.GuestID == g.ID:
+			ops = append(ops, kv.Op{
+				Key:    dnsRecordKey(record.Host, record.Domain),
+				Value:  kv.Value{Index: record.modifiedIndex},
+				Remove: true,
+			})
+		case err != nil && !g.context.IsKeyNotFound(err):
+			return nil, err
+		}
+	}
+	return ops, nil
+}
+
+// removeDNSRecord removes the DNS record of a destroyed guest, if it has one
+func (g *Guest) removeDNSRecord() error {
+	if g.savedDNSName == "" {
+		return nil
+	}
+	record, err := g.context.DNSRecord(g.savedDNSName)
+	if err != nil {
+		if g.context.IsKeyNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if record.GuestID != g.ID {
+		return nil
+	}
+	return g.context.kv.Remove(dnsRecordKey(record.Host, record.Domain), record.modifiedIndex)
+}
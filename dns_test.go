@@ -0,0 +1,110 @@
+package lochness_test
+
+import (
+	"testing"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestDNS(t *testing.T) {
+	suite.Run(t, new(DNSSuite))
+}
+
+type DNSSuite struct {
+	common.Suite
+}
+
+// newGuest creates a guest with the DNS name
+func (s *DNSSuite) newGuest(name string) *lochness.Guest {
+	g := s.NewGuest()
+	g.DNSName = name
+	s.Require().NoError(g.Save())
+	return g
+}
+
+func (s *DNSSuite) TestParseDNSName() {
+	host, domain, err := lochness.ParseDNSName("web1.dc1.example.com")
+	s.NoError(err)
+	s.Equal("web1", host)
+	s.Equal("dc1.example.com", domain)
+
+	for _, name := range []string{"", "web1", "Web1.example.com", "web1.example.com.", "-web1.example.com", "web_1.example.com", "web1..com"} {
+		_, _, err := lochness.ParseDNSName(name)
+		if s.Error(err, name) {
+			s.Equal([]string{"dns_name"}, err.(*lochness.ValidationError).Fields)
+		}
+	}
+}
+
+func (s *DNSSuite) TestSave() {
+	g := s.newGuest("web1.example.com")
+	record, err := s.Context.DNSRecord("web1.example.com")
+	s.Require().NoError(err)
+	s.Equal(g.ID, record.GuestID)
+	s.Equal("web1", record.Host)
+	s.Equal("example.com", record.Domain)
+
+	// saving again keeps the record
+	s.NoError(g.Save())
+
+	other := s.NewGuest()
+	other.DNSName = "web1.example.com"
+	s.Equal(lochness.ErrDNSNameInUse, other.Save())
+	other.DNSName = "web1.example.org"
+	s.NoError(other.Save(), "names are unique only within their domain")
+
+	// renaming frees the previous name
+	g.DNSName = "web2.example.com"
+	s.Require().NoError(g.Save())
+	_, err = s.Context.DNSRecord("web1.example.com")
+	s.True(s.Context.IsKeyNotFound(err))
+	record, err = s.Context.DNSRecord("web2.example.com")
+	s.Require().NoError(err)
+	s.Equal(g.ID, record.GuestID)
+
+	g.DNSName = ""
+	s.Require().NoError(g.Save())
+	_, err = s.Context.DNSRecord("web2.example.com")
+	s.True(s.Context.IsKeyNotFound(err))
+
+	g.DNSName = "Web2"
+	s.Error(g.Validate())
+}
+
+func (s *DNSSuite) TestDestroy() {
+	g := s.newGuest("web1.example.com")
+	s.Require().NoError(g.Destroy())
+	_, err := s.Context.DNSRecord("web1.example.com")
+	s.True(s.Context.IsKeyNotFound(err))
+
+	s.newGuest("web1.example.com")
+}
+
+func (s *DNSSuite) TestDNSRecords() {
+	records, err := s.Context.DNSRecords("")
+	s.NoError(err)
+	s.Empty(records)
+
+	web := s.newGuest("web1.example.com")
+	db := s.newGuest("db1.example.com")
+	s.newGuest("web1.dc1.example.com")
+	s.newGuest("web1.example.community")
+
+	records, err = s.Context.DNSRecords("example.com")
+	s.Require().NoError(err)
+	s.Require().Len(records, 2, "subdomains should not be included")
+	s.Equal("db1.example.com", records[0].Name)
+	s.Equal(db.ID, records[0].GuestID)
+	s.Equal("web1.example.com", records[1].Name)
+	s.Equal(web.ID, records[1].GuestID)
+
+	records, err = s.Context.DNSRecords("")
+	s.Require().NoError(err)
+	s.Len(records, 4)
+	s.Equal("web1.dc1.example.com", records[0].Name)
+
+	_, err = s.Context.DNSRecords("example..com")
+	s.Error(err)
+}
@@ -18,9 +18,36 @@ A flavor is a virtual resource "Template" for guest creation. A guest has a
 single flavor.
 
 A FW Group is a collection of firewall rules for incoming IP traffic.  A Guest
-has a single fwgroup.
+has a single fwgroup. Rules may have another fwgroup as source, which may
+include further fwgroups, as long as fwgroups do not include each other.
 
 A guest is a virtual machine.  At creation time, a network, fwgroup, and network
 is required.
+
+A DesiredState is the set of guests assigned to a hypervisor, along with a
+generation that increases whenever that set or one of its guests changes.
+Hypervisors may converge on it and ack each generation instead of being sent
+individual jobs.
+
+A Secret is a credential, such as an agent token or a root password, that
+guests and hypervisors reference by id instead of holding it. Its value is
+always encrypted in the config store, with the key given to
+Context.WithSecretKey.
+
+A Boot is what a hypervisor network boots, the version, kernel, initrd, kernel
+arguments, and iPXE script template, taken from the config of the hypervisor or
+the cluster. Once booted, a node registers itself as a hypervisor with
+Context.RegisterHypervisor, given a BootstrapToken signed with the secret key.
+
+The JSON Schemas of the entities, as returned by EntitySchema, are generated
+from their structs, whose schema tags mark the fields required to create them
+and those set by the server, see the jsonschema package.
+
+Schema Migrations
+
+As the entities change, the records already in the config store are upgraded by
+migrations registered with RegisterMigration, and applied in order by
+Context.Migrate, e.g. with the lochness-migrate command. The schema version the
+records have been migrated to is kept in the config store.
 */
 package lochness
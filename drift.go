@@ -0,0 +1,253 @@
+package lochness
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mistifyio/lochness/pkg/kv"
+)
+
+type (
+	// ConfigDifference is a fact of a hypervisor that differs from what is
+	// expected of it. Actual is blank for facts that were not found.
+	ConfigDifference struct {
+		Key      string `json:"key"`
+		Expected string `json:"expected"`
+		Actual   string `json:"actual"`
+	}
+
+	// HypervisorDrift is how a hypervisor's facts differed from its expected
+	// config when they were last checked, see CheckDrift. A zero CheckedAt
+	// means they have not been checked.
+	HypervisorDrift struct {
+		HypervisorID string             `json:"hypervisor"`
+		CheckedAt    time.Time          `json:"checked_at"`
+		Differences  []ConfigDifference `json:"differences"`
+	}
+)
+
+// Drifted returns whether any fact differed from what is expected
+func (d *HypervisorDrift) Drifted() bool {
+	return len(d.Differences) != 0
+}
+
+// expectedConfigKey is a helper for generating a key for config store
+func (h *Hypervisor) expectedConfigKey() string {
+	return filepath.Join(HypervisorPath, h.ID, "expected")
+}
+
+// driftKey is a helper for generating a key for config store
+func (h *Hypervisor) driftKey() string {
+	return filepath.Join(HypervisorPath, h.ID, "drift")
+}
+
+// ExpectedConfig returns the facts expected of the hypervisor, see LocalFacts,
+// by key. It is empty if nothing is expected.
+func (h *Hypervisor) ExpectedConfig() (map[string]string, error) {
+	expected, _, err := h.expectedConfig()
+	return expected, err
+}
+
+// expectedConfig fetches the expected config along with its modified index
+func (h *Hypervisor) expectedConfig() (map[string]string, uint64, error) {
+	expected := map[string]string{}
+	value, err := h.context.kv.Get(h.expectedConfigKey())
+	if err != nil {
+		if h.context.kv.IsKeyNotFound(err) {
+			return expected, 0, nil
+		}
+		return nil, 0, err
+	}
+	if err := json.Unmarshal(value.Data, &expected); err != nil {
+		return nil, 0, err
+	}
+	return expected, value.Index, nil
+}
+
+// SetExpectedConfig sets the facts expected of the hypervisor to the values
+// given, removing those whose value is "", and returns the resulting expected
+// config. Concurrent changes fail with a conflict error.
+func (h *Hypervisor) SetExpectedConfig(changes map[string]string) (map[string]string, error) {
+	expected, index, err := h.expectedConfig()
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range changes {
+		if key == "" {
+			return nil, newValidationError("key", "empty expected config key")
+		}
+		if value == "" {
+			delete(expected, key)
+			continue
+		}
+		expected[key] = value
+	}
+
+	v, err := json.Marshal(expected)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := h.context.kv.Update(h.expectedConfigKey(), kv.Value{Data: v, Index: index}); err != nil {
+		return nil, err
+	}
+	return expected, nil
+}
+
+// CompareConfig returns the differences, sorted by key, between the expected
+// config and the actual facts. Facts that are not expected are ignored.
+func CompareConfig(expected, actual map[string]string) []ConfigDifference {
+	differences := []ConfigDifference{}
+	for key, value := range expected {
+		if actual[key] != value {
+			differences = append(differences, ConfigDifference{
+				Key:      key,
+				Expected: value,
+				Actual:   actual[key],
+			})
+		}
+	}
+	sort.Slice(differences, func(i, j int) bool {
+		return differences[i].Key < differences[j].Key
+	})
+	return differences
+}
+
+// CheckDrift compares the facts of the hypervisor, as gathered on it by
+// LocalFacts, with its expected config and records the result as its drift
+func (h *Hypervisor) CheckDrift(facts map[string]string) (*HypervisorDrift, error) {
+	expected, err := h.ExpectedConfig()
+	if err != nil {
+		return nil, err
+	}
+	drift := &HypervisorDrift{
+		HypervisorID: h.ID,
+		CheckedAt:    time.Now(),
+		Differences:  CompareConfig(expected, facts),
+	}
+
+	v, err := json.Marshal(drift)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.context.kv.Set(h.driftKey(), string(v)); err != nil {
+		return nil, err
+	}
+	return drift, nil
+}
+
+// Drift returns the drift recorded by the last CheckDrift of the hypervisor,
+// with a zero CheckedAt if there has been none
+func (h *Hypervisor) Drift() (*HypervisorDrift, error) {
+	drift := &HypervisorDrift{
+		HypervisorID: h.ID,
+		Differences:  []ConfigDifference{},
+	}
+	value, err := h.context.kv.Get(h.driftKey())
+	if err != nil {
+		if h.context.kv.IsKeyNotFound(err) {
+			return drift, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(value.Data, drift); err != nil {
+		return nil, err
+	}
+	return drift, nil
+}
+
+// LocalFacts gathers the facts of the machine it is run on that can be
+// expected of a hypervisor:
+//
+//	hostname            the hostname
+//	kernel              the kernel release
+//	kernel-args         the kernel command line
+//	os-version          the VERSION_ID of /etc/os-release
+//	net.<iface>.mac     the MAC of each network interface but loopback
+//	net.<iface>.addrs   the comma separated, sorted, addresses of each
+//	                    interface, in CIDR notation
+//	net.<iface>.mtu     the MTU of each interface
+//
+// It should only be run on the hypervisor.
+func LocalFacts() (map[string]string, error) {
+	facts := map[string]string{}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	facts["hostname"] = hostname
+
+	for key, path := range map[string]string{
+		"kernel":      "/proc/sys/kernel/osrelease",
+		"kernel-args": "/proc/cmdline",
+	} {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		facts[key] = strings.TrimSpace(string(data))
+	}
+
+	version, err := osVersion("/etc/os-release")
+	if err != nil {
+		return nil, err
+	}
+	facts["os-version"] = version
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		cidrs := make([]string, len(addrs))
+		for i, addr := range addrs {
+			cidrs[i] = addr.String()
+		}
+		sort.Strings(cidrs)
+
+		prefix := "net." + iface.Name + "."
+		facts[prefix+"mac"] = iface.HardwareAddr.String()
+		facts[prefix+"addrs"] = strings.Join(cidrs, ",")
+		facts[prefix+"mtu"] = fmt.Sprint(iface.MTU)
+	}
+
+	return facts, nil
+}
+
+// osVersion reads the VERSION_ID of an os-release file, which is "" if the
+// file does not exist or has none
+func osVersion(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "VERSION_ID=") {
+			continue
+		}
+		return strings.Trim(strings.TrimPrefix(line, "VERSION_ID="), `"'`), nil
+	}
+	return "", scanner.Err()
+}
@@ -0,0 +1,95 @@
+package lochness_test
+
+import (
+	"testing"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestDrift(t *testing.T) {
+	suite.Run(t, new(DriftSuite))
+}
+
+type DriftSuite struct {
+	common.Suite
+}
+
+func (s *DriftSuite) TestExpectedConfig() {
+	hypervisor := s.NewHypervisor()
+	expected, err := hypervisor.ExpectedConfig()
+	s.NoError(err)
+	s.Empty(expected)
+
+	expected, err = hypervisor.SetExpectedConfig(map[string]string{"kernel": "4.4.0", "os-version": "0.4.2"})
+	s.NoError(err)
+	s.Equal(map[string]string{"kernel": "4.4.0", "os-version": "0.4.2"}, expected)
+
+	expected, err = hypervisor.SetExpectedConfig(map[string]string{"kernel": ""})
+	s.NoError(err)
+	s.Equal(map[string]string{"os-version": "0.4.2"}, expected)
+
+	_, err = hypervisor.SetExpectedConfig(map[string]string{"": "foo"})
+	s.True(lerrors.IsValidation(err))
+
+	expected, err = hypervisor.ExpectedConfig()
+	s.NoError(err)
+	s.Equal(map[string]string{"os-version": "0.4.2"}, expected)
+}
+
+func (s *DriftSuite) TestCompareConfig() {
+	expected := map[string]string{
+		"os-version":     "0.4.2",
+		"kernel-args":    "console=ttyS0",
+		"net.eth0.mtu":   "9000",
+		"net.eth1.addrs": "10.0.0.2/24",
+	}
+	actual := map[string]string{
+		"os-version":   "0.4.2",
+		"kernel-args":  "console=tty0",
+		"net.eth0.mtu": "1500",
+		"hostname":     "node1",
+	}
+	s.Equal([]lochness.ConfigDifference{
+		{Key: "kernel-args", Expected: "console=ttyS0", Actual: "console=tty0"},
+		{Key: "net.eth0.mtu", Expected: "9000", Actual: "1500"},
+		{Key: "net.eth1.addrs", Expected: "10.0.0.2/24", Actual: ""},
+	}, lochness.CompareConfig(expected, actual))
+
+	s.Empty(lochness.CompareConfig(nil, actual))
+}
+
+func (s *DriftSuite) TestCheckDrift() {
+	hypervisor := s.NewHypervisor()
+	drift, err := hypervisor.Drift()
+	s.NoError(err)
+	s.True(drift.CheckedAt.IsZero(), "drift should not be checked yet")
+	s.False(drift.Drifted())
+
+	drift, err = hypervisor.CheckDrift(map[string]string{"os-version": "0.4.1"})
+	s.NoError(err)
+	s.False(drift.Drifted(), "facts that are not expected should not drift")
+
+	_, err = hypervisor.SetExpectedConfig(map[string]string{"os-version": "0.4.2"})
+	s.Require().NoError(err)
+	_, err = hypervisor.CheckDrift(map[string]string{"os-version": "0.4.1"})
+	s.NoError(err)
+
+	drift, err = hypervisor.Drift()
+	s.NoError(err)
+	s.Equal(hypervisor.ID, drift.HypervisorID)
+	s.False(drift.CheckedAt.IsZero())
+	s.True(drift.Drifted())
+	s.Equal([]lochness.ConfigDifference{{Key: "os-version", Expected: "0.4.2", Actual: "0.4.1"}}, drift.Differences)
+}
+
+func (s *DriftSuite) TestLocalFacts() {
+	facts, err := lochness.LocalFacts()
+	s.Require().NoError(err)
+	s.NotEmpty(facts["hostname"])
+	s.NotEmpty(facts["kernel"])
+	s.Contains(facts, "kernel-args")
+	s.Contains(facts, "os-version")
+}
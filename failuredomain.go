@@ -0,0 +1,279 @@
+package lochness
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// FailureDomainLevels are the levels of failure domains, from the widest
+var FailureDomainLevels = []string{"zone", "row", "rack"}
+
+// DefaultSpreadLevel is the failure domain level guests of an anti-affinity
+// group are spread across, unless the cluster's SpreadLevelConfig sets another
+var DefaultSpreadLevel = "rack"
+
+// SpreadLevelConfig is the config key of the cluster of the failure domain
+// level guests of an anti-affinity group are spread across, one of
+// FailureDomainLevels
+var SpreadLevelConfig = "failure-domain-spread"
+
+type (
+	// FailureDomain is where a hypervisor is, so that guests which should not
+	// fail together can be spread across zones, rows, or racks. Each name is
+	// only meaningful within the levels above it, e.g. rack "r1" of row "a".
+	FailureDomain struct {
+		Zone string `json:"zone,omitempty"`
+		Row  string `json:"row,omitempty"`
+		Rack string `json:"rack,omitempty"`
+	}
+
+	// SpreadViolation is a failure domain holding more of the guests of an
+	// anti-affinity group than an even spread across the domains would, so
+	// that losing it takes down more of the group than it needs to. Max is
+	// the most guests of the group a domain should hold.
+	SpreadViolation struct {
+		Group  string   `json:"group"`
+		Domain string   `json:"domain"`
+		Guests []string `json:"guests"`
+		Max    int      `json:"max"`
+	}
+)
+
+// CheckFailureDomainLevel checks that level is one of FailureDomainLevels,
+// returning a validation error of the field if not
+func CheckFailureDomainLevel(field, level string) error {
+	if !hasString(FailureDomainLevels, level) {
+		return newValidationError(field, fmt.Sprintf("invalid failure domain level %q: must be one of %s", level, strings.Join(FailureDomainLevels, ", ")))
+	}
+	return nil
+}
+
+// Validate ensures the names of a FailureDomain can be told apart in its keys
+func (d *FailureDomain) Validate() error {
+	for _, name := range []string{d.Zone, d.Row, d.Rack} {
+		if strings.ContainsAny(name, "/ \t\n") {
+			return newValidationError("failure_domain", fmt.Sprintf("invalid failure domain name %q: may not contain / or spaces", name))
+		}
+	}
+	return nil
+}
+
+// Key returns the failure domain at the level, one of FailureDomainLevels, as
+// the names set down to it joined by "/", e.g. "z1/a/r1" for a rack. It is ""
+// if the name at the level is not set.
+func (d *FailureDomain) Key(level string) string {
+	if d.name(level) == "" {
+		return ""
+	}
+	var names []string
+	for _, l := range FailureDomainLevels {
+		if name := d.name(l); name != "" {
+			names = append(names, name)
+		}
+		if l == level {
+			break
+		}
+	}
+	return strings.Join(names, "/")
+}
+
+// name returns the name of the failure domain at the level, "" if not set
+func (d *FailureDomain) name(level string) string {
+	if d == nil {
+		return ""
+	}
+	switch level {
+	case "zone":
+		return d.Zone
+	case "row":
+		return d.Row
+	case "rack":
+		return d.Rack
+	}
+	return ""
+}
+
+// spreadKey returns the failure domain of the hypervisor at the level that
+// guests are spread across. Hypervisors with none are each their own.
+func (h *Hypervisor) spreadKey(level string) string {
+	if key := h.FailureDomain.Key(level); key != "" {
+		return key
+	}
+	return h.ID
+}
+
+// SpreadLevel returns the failure domain level guests of an anti-affinity
+// group are spread across, from SpreadLevelConfig, DefaultSpreadLevel if unset
+func (c *Context) SpreadLevel() (string, error) {
+	level, err := c.GetConfig(SpreadLevelConfig)
+	if err != nil {
+		if c.IsKeyNotFound(err) {
+			return DefaultSpreadLevel, nil
+		}
+		return "", err
+	}
+	if err := CheckFailureDomainLevel(SpreadLevelConfig, level); err != nil {
+		return "", err
+	}
+	return level, nil
+}
+
+// antiAffinityGroup returns the placed, not soft deleted, guests of the
+// anti-affinity group other than exclude, by the id of their hypervisor
+func (c *Context) antiAffinityGroup(group, exclude string) (map[string][]string, error) {
+	members := make(map[string][]string)
+	err := c.ForEachGuest(func(g *Guest) error {
+		if g.AntiAffinity == group && g.ID != exclude && g.HypervisorID != "" && !g.IsDeleted() {
+			members[g.HypervisorID] = append(members[g.HypervisorID], g.ID)
+		}
+		return nil
+	})
+	return members, err
+}
+
+// CandidateSpreadFailureDomains orders the list of Hypervisors so that those
+// in the failure domains, at the cluster's SpreadLevel, holding the fewest
+// guests of the Guest's anti-affinity group come first, spreading the group
+// across the domains. The order of Hypervisors in equally used domains is
+// kept. Guests in no group are left as they are.
+func CandidateSpreadFailureDomains(g *Guest, hs Hypervisors) (Hypervisors, error) {
+	if g.AntiAffinity == "" {
+		return hs, nil
+	}
+
+	logFields := log.Fields{
+		"guestID": g.ID,
+		"func":    "CandidateSpreadFailureDomains",
+	}
+
+	level, err := g.context.SpreadLevel()
+	if err != nil {
+		return nil, err
+	}
+	members, err := g.context.antiAffinityGroup(g.AntiAffinity, g.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// the members may be on hypervisors that are no longer candidates
+	used := make(map[string]int)
+	if len(members) > 0 {
+		err = g.context.ForEachHypervisor(func(h *Hypervisor) error {
+			used[h.spreadKey(level)] += len(members[h.ID])
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	byUse := hypervisorsByUse{hs: hs, used: make([]int, len(hs))}
+	for i, h := range hs {
+		byUse.used[i] = used[h.spreadKey(level)]
+	}
+	sort.Stable(byUse)
+
+	log.WithFields(logFields).WithFields(log.Fields{
+		"group":   g.AntiAffinity,
+		"level":   level,
+		"members": len(members),
+	}).Info("hypervisor candidates spread")
+
+	return hs, nil
+}
+
+// hypervisorsByUse sorts Hypervisors by ascending use of their failure domain
+type hypervisorsByUse struct {
+	hs   Hypervisors
+	used []int
+}
+
+func (b hypervisorsByUse) Len() int           { return len(b.hs) }
+func (b hypervisorsByUse) Less(i, j int) bool { return b.used[i] < b.used[j] }
+func (b hypervisorsByUse) Swap(i, j int) {
+	b.hs[i], b.hs[j] = b.hs[j], b.hs[i]
+	b.used[i], b.used[j] = b.used[j], b.used[i]
+}
+
+// SpreadViolations returns the failure domains, at the level, holding more of
+// the guests of an anti-affinity group than an even spread across the domains
+// of the hypervisors that are not soft deleted would, sorted by group and
+// domain. Hypervisors with no failure domain at the level are each their own.
+// The level is the cluster's SpreadLevel if blank.
+func (c *Context) SpreadViolations(level string) ([]SpreadViolation, error) {
+	if level == "" {
+		var err error
+		if level, err = c.SpreadLevel(); err != nil {
+			return nil, err
+		}
+	} else if err := CheckFailureDomainLevel("level", level); err != nil {
+		return nil, err
+	}
+
+	domainOf := make(map[string]string)
+	domains := make(map[string]bool)
+	err := c.ForEachHypervisor(func(h *Hypervisor) error {
+		key := h.spreadKey(level)
+		domainOf[h.ID] = key
+		if !h.IsDeleted() {
+			domains[key] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// guests by domain by group
+	groups := make(map[string]map[string][]string)
+	sizes := make(map[string]int)
+	err = c.ForEachGuest(func(g *Guest) error {
+		if g.AntiAffinity == "" || g.HypervisorID == "" || g.IsDeleted() {
+			return nil
+		}
+		domain, ok := domainOf[g.HypervisorID]
+		if !ok {
+			domain = g.HypervisorID
+		}
+		if groups[g.AntiAffinity] == nil {
+			groups[g.AntiAffinity] = make(map[string][]string)
+		}
+		groups[g.AntiAffinity][domain] = append(groups[g.AntiAffinity][domain], g.ID)
+		sizes[g.AntiAffinity]++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	violations := []SpreadViolation{}
+	for group, byDomain := range groups {
+		n := len(domains)
+		if n == 0 {
+			n = 1
+		}
+		max := (sizes[group] + n - 1) / n
+		for domain, guests := range byDomain {
+			if len(guests) <= max {
+				continue
+			}
+			sort.Strings(guests)
+			violations = append(violations, SpreadViolation{
+				Group:  group,
+				Domain: domain,
+				Guests: guests,
+				Max:    max,
+			})
+		}
+	}
+	sort.Slice(violations, func(i, j int) bool {
+		if violations[i].Group != violations[j].Group {
+			return violations[i].Group < violations[j].Group
+		}
+		return violations[i].Domain < violations[j].Domain
+	})
+	return violations, nil
+}
@@ -0,0 +1,119 @@
+package lochness_test
+
+import (
+	"testing"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestFailureDomain(t *testing.T) {
+	suite.Run(t, new(FailureDomainSuite))
+}
+
+type FailureDomainSuite struct {
+	common.Suite
+}
+
+// newHypervisor creates a hypervisor in the rack of row a of zone z1
+func (s *FailureDomainSuite) newHypervisor(rack string) *lochness.Hypervisor {
+	h := s.NewHypervisor()
+	h.FailureDomain = &lochness.FailureDomain{Zone: "z1", Row: "a", Rack: rack}
+	s.Require().NoError(h.Save())
+	return h
+}
+
+// newGuest creates a guest of the anti-affinity group on the hypervisor
+func (s *FailureDomainSuite) newGuest(group string, h *lochness.Hypervisor) *lochness.Guest {
+	g := s.NewGuest()
+	g.AntiAffinity = group
+	g.HypervisorID = h.ID
+	s.Require().NoError(g.Save())
+	return g
+}
+
+func (s *FailureDomainSuite) TestKey() {
+	d := &lochness.FailureDomain{Zone: "z1", Rack: "r7"}
+	s.Equal("z1", d.Key("zone"))
+	s.Equal("", d.Key("row"))
+	s.Equal("z1/r7", d.Key("rack"))
+	s.Equal("", d.Key("aisle"))
+
+	var none *lochness.FailureDomain
+	s.Equal("", none.Key("rack"))
+}
+
+func (s *FailureDomainSuite) TestValidate() {
+	h := s.NewHypervisor()
+	h.FailureDomain = &lochness.FailureDomain{Zone: "z1", Row: "a", Rack: "r7"}
+	s.NoError(h.Validate())
+
+	h.FailureDomain.Rack = "a/r7"
+	err := h.Validate()
+	s.Require().Error(err)
+	s.Equal([]string{"failure_domain"}, err.(*lochness.ValidationError).Fields)
+}
+
+func (s *FailureDomainSuite) TestSpreadLevel() {
+	level, err := s.Context.SpreadLevel()
+	s.NoError(err)
+	s.Equal(lochness.DefaultSpreadLevel, level)
+
+	s.Require().NoError(s.Context.SetConfig(lochness.SpreadLevelConfig, "row"))
+	level, err = s.Context.SpreadLevel()
+	s.NoError(err)
+	s.Equal("row", level)
+
+	s.Require().NoError(s.Context.SetConfig(lochness.SpreadLevelConfig, "aisle"))
+	_, err = s.Context.SpreadLevel()
+	s.True(lerrors.IsValidation(err))
+}
+
+func (s *FailureDomainSuite) TestCandidateSpreadFailureDomains() {
+	r1 := s.newHypervisor("r1")
+	r2 := s.newHypervisor("r2")
+	r1b := s.newHypervisor("r1")
+	s.newGuest("db", r1)
+	s.newGuest("web", r2)
+
+	guest := s.NewGuest()
+	hypervisors := lochness.Hypervisors{r1, r1b, r2}
+	candidates, err := lochness.CandidateSpreadFailureDomains(guest, hypervisors)
+	s.NoError(err)
+	s.Equal(lochness.Hypervisors{r1, r1b, r2}, candidates, "guests in no group should keep the order")
+
+	guest.AntiAffinity = "db"
+	candidates, err = lochness.CandidateSpreadFailureDomains(guest, hypervisors)
+	s.NoError(err)
+	s.Require().Len(candidates, 3)
+	s.Equal(r2.ID, candidates[0].ID, "the rack without the group should come first")
+	s.Equal(r1.ID, candidates[1].ID)
+	s.Equal(r1b.ID, candidates[2].ID)
+}
+
+func (s *FailureDomainSuite) TestSpreadViolations() {
+	r1 := s.newHypervisor("r1")
+	r1b := s.newHypervisor("r1")
+	r2 := s.newHypervisor("r2")
+	db1 := s.newGuest("db", r1)
+	db2 := s.newGuest("db", r1b)
+	s.newGuest("web", r1)
+	s.newGuest("web", r2)
+
+	violations, err := s.Context.SpreadViolations("")
+	s.Require().NoError(err)
+	s.Require().Len(violations, 1)
+	s.Equal("db", violations[0].Group)
+	s.Equal("z1/a/r1", violations[0].Domain)
+	s.Equal(1, violations[0].Max)
+	s.ElementsMatch([]string{db1.ID, db2.ID}, violations[0].Guests)
+
+	violations, err = s.Context.SpreadViolations("row")
+	s.Require().NoError(err)
+	s.Empty(violations, "a single row can not be spread across")
+
+	_, err = s.Context.SpreadViolations("aisle")
+	s.True(lerrors.IsValidation(err))
+}
@@ -2,7 +2,6 @@ package lochness
 
 import (
 	"encoding/json"
-	"errors"
 	"path/filepath"
 
 	"github.com/mistifyio/lochness/pkg/kv"
@@ -19,10 +18,11 @@ type (
 	Flavor struct {
 		context       *Context
 		modifiedIndex uint64
-		ID            string            `json:"id"`
-		Image         string            `json:"image"`
+		ID            string            `json:"id" schema:"uuid"`
+		Image         string            `json:"image" schema:"required,uuid"`
 		Metadata      map[string]string `json:"metadata"`
 		Resources
+		Limits
 	}
 
 	// Flavors is an alias to a slice of *Flavor
@@ -34,6 +34,14 @@ type (
 		Disk   uint64 `json:"disk"`   // disk in MB
 		CPU    uint32 `json:"cpu"`    // virtual cpus
 	}
+
+	// Limits caps the network bandwidth and disk IOPS of a guest, so that it
+	// cannot starve the other guests of its hypervisor. Zero is unlimited.
+	Limits struct {
+		NetworkBandwidth uint64 `json:"network_bandwidth"` // network bandwidth in Mbit/s, each way
+		DiskIOPS         uint64 `json:"disk_iops"`         // disk operations per second
+		DiskIOPSBurst    uint64 `json:"disk_iops_burst"`   // disk operations per second allowed in short bursts
+	}
 )
 
 // NewFlavor creates a blank Flavor
@@ -88,20 +96,34 @@ func (f *Flavor) Refresh() error {
 	return f.fromResponse(resp)
 }
 
-// Validate ensures a Flavor has reasonable data. It currently does nothing.
+// Validate ensures a Flavor has reasonable data.
 func (f *Flavor) Validate() error {
 	if f.ID == "" {
-		return errors.New("flavor ID required")
+		return newValidationError("id", "flavor ID required")
 	}
 	if uuid.Parse(f.ID) == nil {
-		return errors.New("flavor ID must be uuid")
+		return newValidationError("id", "flavor ID must be uuid")
 	}
 
 	if f.Image == "" {
-		return errors.New("flavor image required")
+		return newValidationError("image", "flavor image required")
 	}
 	if uuid.Parse(f.Image) == nil {
-		return errors.New("flavor image must be uuid")
+		return newValidationError("image", "flavor image must be uuid")
+	}
+	return f.Limits.Validate()
+}
+
+// Validate ensures Limits are consistent. A burst requires a disk IOPS limit
+// to burst above.
+func (l Limits) Validate() error {
+	if l.DiskIOPSBurst != 0 {
+		if l.DiskIOPS == 0 {
+			return newValidationError("disk_iops_burst", "disk iops burst requires a disk iops limit")
+		}
+		if l.DiskIOPSBurst < l.DiskIOPS {
+			return newValidationError("disk_iops_burst", "disk iops burst must be at least the disk iops limit")
+		}
 	}
 	return nil
 }
@@ -109,19 +131,5 @@ func (f *Flavor) Validate() error {
 // Save persists a Flavor.
 // It will call Validate.
 func (f *Flavor) Save() error {
-	if err := f.Validate(); err != nil {
-		return err
-	}
-
-	v, err := json.Marshal(f)
-
-	if err != nil {
-		return err
-	}
-
-	index, err := f.context.kv.Update(f.key(), kv.Value{Data: v, Index: f.modifiedIndex})
-	if err == nil {
-		f.modifiedIndex = index
-	}
-	return err
+	return f.context.SaveAll(f)
 }
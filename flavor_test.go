@@ -5,6 +5,7 @@ import (
 
 	"github.com/mistifyio/lochness"
 	"github.com/mistifyio/lochness/internal/tests/common"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
 	"github.com/pborman/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
@@ -77,6 +78,9 @@ func (s *FlavorSuite) TestValidate() {
 		{"missing image", &lochness.Flavor{ID: uuid.New()}, true},
 		{"invalid image", &lochness.Flavor{ID: uuid.New(), Image: "asdf"}, true},
 		{"valid id and image", &lochness.Flavor{ID: uuid.New(), Image: uuid.New()}, false},
+		{"valid limits", &lochness.Flavor{ID: uuid.New(), Image: uuid.New(), Limits: lochness.Limits{NetworkBandwidth: 100, DiskIOPS: 500, DiskIOPSBurst: 1000}}, false},
+		{"burst without iops", &lochness.Flavor{ID: uuid.New(), Image: uuid.New(), Limits: lochness.Limits{DiskIOPSBurst: 1000}}, true},
+		{"burst below iops", &lochness.Flavor{ID: uuid.New(), Image: uuid.New(), Limits: lochness.Limits{DiskIOPS: 500, DiskIOPSBurst: 100}}, true},
 	}
 
 	for _, test := range tests {
@@ -84,6 +88,7 @@ func (s *FlavorSuite) TestValidate() {
 		err := test.flavor.Validate()
 		if test.expectedErr {
 			s.Error(err, msg("should be invalid"))
+			s.True(lerrors.IsValidation(err), msg("should be a validation error"))
 		} else {
 			s.NoError(err, msg("should be valid"))
 		}
@@ -0,0 +1,202 @@
+package lochness
+
+import (
+	"fmt"
+	"sort"
+)
+
+// DefaultForecastDomain is the level, one of FailureDomainLevels, of the
+// failure domains a Forecast reports the headroom of, unless another is given
+var DefaultForecastDomain = "rack"
+
+type (
+	// Forecast is whether Count guests of a flavor could be placed now, the
+	// hypervisors they would be placed on, and the headroom each failure
+	// domain would have left. Hypervisors are candidates as the placer takes
+	// them: alive, not in maintenance nor soft deleted, and meeting the
+	// constraints given. Subnets and the other guests being placed are not
+	// taken into account.
+	Forecast struct {
+		Flavor      string              `json:"flavor"`
+		Count       int                 `json:"count"`
+		Placeable   bool                `json:"placeable"`   // whether all Count guests would be placed
+		Placed      int                 `json:"placed"`      // how many of the guests would be placed
+		Hypervisors []ForecastPlacement `json:"hypervisors"` // the hypervisors they would be placed on
+		Domains     []DomainHeadroom    `json:"domains"`     // headroom once they are placed
+	}
+
+	// ForecastPlacement is the number of guests a Forecast places on a
+	// hypervisor
+	ForecastPlacement struct {
+		Hypervisor string `json:"hypervisor"`
+		Domain     string `json:"domain"`
+		Guests     int    `json:"guests"`
+	}
+
+	// DomainHeadroom is what a failure domain of candidate hypervisors would
+	// have left once the guests of a Forecast are placed: the resources
+	// available, and how many more guests of the flavor would fit. Domain is
+	// "" for hypervisors in none.
+	DomainHeadroom struct {
+		Domain      string    `json:"domain"`
+		Hypervisors int       `json:"hypervisors"`
+		Placed      int       `json:"placed"`
+		Available   Resources `json:"available"`
+		Guests      int       `json:"guests"`
+	}
+
+	// forecastHypervisor is a candidate hypervisor of a Forecast and what it
+	// would have available as guests are placed on it
+	forecastHypervisor struct {
+		hypervisor *Hypervisor
+		domain     string
+		overcommit Overcommit
+		avail      Resources
+		placed     int
+	}
+)
+
+// Forecast forecasts the placement of count guests of the flavor, spreading
+// them over the candidate hypervisors by placing each on the one with the most
+// memory available. Failure domains are those of the hypervisors at the domain
+// level, DefaultForecastDomain if blank. constraints, if not blank, is a
+// constraints expression the hypervisors must meet, see Constraint.
+func (c *Context) Forecast(f *Flavor, count int, domain, constraints string) (*Forecast, error) {
+	if count < 1 {
+		return nil, newValidationError("count", "count must be at least 1")
+	}
+	if f.Memory == 0 && f.Disk == 0 {
+		return nil, newValidationError("flavor", fmt.Sprintf("flavor %s reserves no memory or disk", f.ID))
+	}
+	if domain == "" {
+		domain = DefaultForecastDomain
+	}
+	if err := CheckFailureDomainLevel("domain", domain); err != nil {
+		return nil, err
+	}
+	var constraint *Constraint
+	if constraints != "" {
+		var err error
+		if constraint, err = ParseConstraint(constraints); err != nil {
+			return nil, err
+		}
+	}
+
+	// constraints are evaluated for a guest of the flavor
+	guest := &Guest{FlavorID: f.ID, Metadata: map[string]string{}}
+	var candidates []*forecastHypervisor
+	err := c.ForEachHypervisor(func(h *Hypervisor) error {
+		if !h.IsAlive() || h.Maintenance || h.IsDeleted() {
+			return nil
+		}
+		if constraint != nil && !constraint.Match(guest, h) {
+			return nil
+		}
+		o, err := h.Overcommit()
+		if err != nil {
+			return err
+		}
+		candidates = append(candidates, &forecastHypervisor{
+			hypervisor: h,
+			domain:     h.FailureDomain.Key(domain),
+			overcommit: o,
+			avail:      h.AvailableResources,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].hypervisor.ID < candidates[j].hypervisor.ID
+	})
+
+	forecast := &Forecast{
+		Flavor:      f.ID,
+		Count:       count,
+		Hypervisors: []ForecastPlacement{},
+		Domains:     []DomainHeadroom{},
+	}
+	for ; forecast.Placed < count; forecast.Placed++ {
+		var best *forecastHypervisor
+		for _, fh := range candidates {
+			if fh.fits(f.Resources) && (best == nil || fh.avail.Memory > best.avail.Memory) {
+				best = fh
+			}
+		}
+		if best == nil {
+			break
+		}
+		best.take(f.Resources)
+	}
+	forecast.Placeable = forecast.Placed == count
+
+	domains := make(map[string]*DomainHeadroom)
+	var names []string
+	for _, fh := range candidates {
+		if fh.placed > 0 {
+			forecast.Hypervisors = append(forecast.Hypervisors, ForecastPlacement{
+				Hypervisor: fh.hypervisor.ID,
+				Domain:     fh.domain,
+				Guests:     fh.placed,
+			})
+		}
+		d, ok := domains[fh.domain]
+		if !ok {
+			d = &DomainHeadroom{Domain: fh.domain}
+			domains[fh.domain] = d
+			names = append(names, fh.domain)
+		}
+		d.Hypervisors++
+		d.Placed += fh.placed
+		d.Available.Memory += fh.avail.Memory
+		d.Available.Disk += fh.avail.Disk
+		d.Available.CPU += fh.avail.CPU
+		d.Guests += fh.headroom(f.Resources)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		forecast.Domains = append(forecast.Domains, *domains[name])
+	}
+	return forecast, nil
+}
+
+// fits returns whether a guest needing the resources would fit on the
+// hypervisor
+func (fh *forecastHypervisor) fits(need Resources) bool {
+	return fh.avail.Memory >= need.Memory && fh.avail.Disk >= need.Disk && fh.avail.CPU >= need.CPU
+}
+
+// take places a guest needing the resources on the hypervisor. CPUs are only
+// taken with a CPU overcommit ratio, as the guests' usage is.
+func (fh *forecastHypervisor) take(need Resources) {
+	fh.avail.Memory -= need.Memory
+	fh.avail.Disk -= need.Disk
+	if fh.overcommit.CPU != 0 {
+		fh.avail.CPU -= need.CPU
+	}
+	fh.placed++
+}
+
+// headroom returns how many more guests needing the resources would fit on
+// the hypervisor. need must have memory or disk.
+func (fh *forecastHypervisor) headroom(need Resources) int {
+	if !fh.fits(need) {
+		return 0
+	}
+	n := -1
+	limit := func(avail, need uint64) {
+		if need == 0 {
+			return
+		}
+		if m := int(avail / need); n < 0 || m < n {
+			n = m
+		}
+	}
+	limit(fh.avail.Memory, need.Memory)
+	limit(fh.avail.Disk, need.Disk)
+	if fh.overcommit.CPU != 0 {
+		limit(uint64(fh.avail.CPU), uint64(need.CPU))
+	}
+	return n
+}
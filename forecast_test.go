@@ -0,0 +1,110 @@
+package lochness_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestForecast(t *testing.T) {
+	suite.Run(t, new(ForecastSuite))
+}
+
+type ForecastSuite struct {
+	common.Suite
+	Flavor *lochness.Flavor
+}
+
+func (s *ForecastSuite) SetupTest() {
+	s.Suite.SetupTest()
+	s.Flavor = s.NewFlavor()
+}
+
+// newHypervisor creates an alive hypervisor in the rack with the memory, in
+// MB, available
+func (s *ForecastSuite) newHypervisor(rack string, memory uint64) *lochness.Hypervisor {
+	h := s.NewHypervisor()
+	h.FailureDomain = &lochness.FailureDomain{Rack: rack}
+	h.AvailableResources = lochness.Resources{
+		Memory: memory,
+		Disk:   1024 * 1024,
+		CPU:    32,
+	}
+	s.Require().NoError(h.Save())
+	_, err := lochness.SetHypervisorID(h.ID)
+	s.Require().NoError(err)
+	s.Require().NoError(h.Heartbeat(60 * time.Second))
+	return h
+}
+
+func (s *ForecastSuite) TestForecast() {
+	// room for 3, 1, and 2 guests of the flavor's 128 MB
+	r1a := s.newHypervisor("r1", 400)
+	r1b := s.newHypervisor("r1", 130)
+	r2 := s.newHypervisor("r2", 300)
+	maintenance := s.newHypervisor("r2", 1280)
+	maintenance.Maintenance = true
+	s.Require().NoError(maintenance.Save())
+
+	forecast, err := s.Context.Forecast(s.Flavor, 4, "", "")
+	s.Require().NoError(err)
+	s.True(forecast.Placeable)
+	s.Equal(4, forecast.Placed)
+	placed := make(map[string]int)
+	for _, p := range forecast.Hypervisors {
+		placed[p.Hypervisor] = p.Guests
+	}
+	s.Equal(map[string]int{r1a.ID: 2, r2.ID: 2}, placed, "guests should go where the most memory is available")
+
+	s.Require().Len(forecast.Domains, 2)
+	s.Equal("r1", forecast.Domains[0].Domain)
+	s.Equal(2, forecast.Domains[0].Hypervisors)
+	s.Equal(2, forecast.Domains[0].Placed)
+	s.Equal(2, forecast.Domains[0].Guests)
+	s.Equal(uint64(144+130), forecast.Domains[0].Available.Memory)
+	s.Equal("r2", forecast.Domains[1].Domain)
+	s.Equal(1, forecast.Domains[1].Hypervisors, "hypervisors in maintenance should not be candidates")
+	s.Equal(2, forecast.Domains[1].Placed)
+	s.Equal(0, forecast.Domains[1].Guests)
+	s.Equal(uint64(44), forecast.Domains[1].Available.Memory)
+
+	forecast, err = s.Context.Forecast(s.Flavor, 6, "", "")
+	s.Require().NoError(err)
+	s.True(forecast.Placeable)
+	placed = make(map[string]int)
+	for _, p := range forecast.Hypervisors {
+		placed[p.Hypervisor] = p.Guests
+	}
+	s.Equal(map[string]int{r1a.ID: 3, r1b.ID: 1, r2.ID: 2}, placed)
+
+	forecast, err = s.Context.Forecast(s.Flavor, 7, "", "")
+	s.Require().NoError(err)
+	s.False(forecast.Placeable)
+	s.Equal(6, forecast.Placed)
+
+	forecast, err = s.Context.Forecast(s.Flavor, 3, "", `rack == "r2"`)
+	s.Require().NoError(err)
+	s.False(forecast.Placeable)
+	s.Equal(2, forecast.Placed)
+
+	forecast, err = s.Context.Forecast(s.Flavor, 1, "row", "")
+	s.Require().NoError(err)
+	s.Require().Len(forecast.Domains, 1)
+	s.Equal("", forecast.Domains[0].Domain)
+	s.Equal(3, forecast.Domains[0].Hypervisors)
+}
+
+func (s *ForecastSuite) TestForecastInvalid() {
+	_, err := s.Context.Forecast(s.Flavor, 0, "", "")
+	s.True(lerrors.IsValidation(err))
+	_, err = s.Context.Forecast(s.Flavor, 1, "", "metadata.rack ==")
+	s.True(lerrors.IsValidation(err))
+	_, err = s.Context.Forecast(s.Flavor, 1, "aisle", "")
+	s.True(lerrors.IsValidation(err))
+	_, err = s.Context.Forecast(&lochness.Flavor{ID: "empty"}, 1, "", "")
+	s.True(lerrors.IsValidation(err))
+}
@@ -2,9 +2,11 @@ package lochness
 
 import (
 	"encoding/json"
-	"errors"
+	"fmt"
 	"net"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/mistifyio/lochness/pkg/kv"
 	"github.com/pborman/uuid"
@@ -15,6 +17,12 @@ var (
 	FWGroupPath = "lochness/fwgroups/"
 )
 
+// MaxFWPort is the highest port FWRules may have
+const MaxFWPort = 65535
+
+// fwProtocols are the protocols of FWRules
+var fwProtocols = map[string]bool{"tcp": true, "udp": true}
+
 // XXX: should individual rules be their own keys??
 
 type (
@@ -22,7 +30,7 @@ type (
 	// FWRule represents a single firewall rule
 	FWRule struct {
 		Source    *net.IPNet `json:"source,omitempty"`
-		Group     string     `json:"group"`
+		Group     string     `json:"group" schema:"uuid"`
 		PortStart uint       `json:"portStart"`
 		PortEnd   uint       `json:"portEnd"`
 		Protocol  string     `json:"protocol"`
@@ -32,13 +40,17 @@ type (
 	// FWRules is an alias to a slice of *FWRule
 	FWRules []*FWRule
 
-	// FWGroup represents a group of firewall rules
+	// FWGroup represents a group of firewall rules. A rule with a group as
+	// source matches traffic from the guests of the group, and from those of
+	// the groups it includes.
 	FWGroup struct {
 		context       *Context
 		modifiedIndex uint64
-		ID            string            `json:"id"`
+		ID            string            `json:"id" schema:"uuid"`
 		Metadata      map[string]string `json:"metadata"`
 		Rules         FWRules           `json:"rules"`
+		Includes      []string          `json:"includes,omitempty" schema:"uuid"`
+		Profile       *FWGroupProfile   `json:"profile,omitempty" schema:"readonly"`
 	}
 
 	// FWGroups is an alias to FWGroup slices
@@ -57,6 +69,8 @@ type (
 		ID       string            `json:"id"`
 		Metadata map[string]string `json:"metadata"`
 		Rules    []*fwRuleJSON     `json:"rules"`
+		Includes []string          `json:"includes,omitempty"`
+		Profile  *FWGroupProfile   `json:"profile,omitempty"`
 	}
 )
 
@@ -66,6 +80,8 @@ func (f FWGroup) MarshalJSON() ([]byte, error) {
 		ID:       f.ID,
 		Metadata: f.Metadata,
 		Rules:    make([]*fwRuleJSON, 0, len(f.Rules)),
+		Includes: f.Includes,
+		Profile:  f.Profile,
 	}
 
 	for _, r := range f.Rules {
@@ -96,6 +112,8 @@ func (f *FWGroup) UnmarshalJSON(input []byte) error {
 
 	f.ID = data.ID
 	f.Metadata = data.Metadata
+	f.Includes = data.Includes
+	f.Profile = data.Profile
 	f.Rules = make(FWRules, 0, len(data.Rules))
 
 	for _, r := range data.Rules {
@@ -172,34 +190,112 @@ func (f *FWGroup) Refresh() error {
 	return f.fromResponse(resp)
 }
 
-// Validate ensures a FWGroup has reasonable data.
+// Validate ensures a FWRule has a known protocol, a port range within
+// 1-MaxFWPort, and an IPv4 source network or group id, if any.
+func (r *FWRule) Validate() error {
+	if !fwProtocols[r.Protocol] {
+		return newValidationError("protocol", fmt.Sprintf("invalid protocol %q: must be tcp or udp", r.Protocol))
+	}
+	if r.PortStart < 1 || r.PortEnd > MaxFWPort || r.PortStart > r.PortEnd {
+		return newValidationError("ports", fmt.Sprintf("invalid port range %d-%d: must be within 1-%d", r.PortStart, r.PortEnd, MaxFWPort))
+	}
+	if r.Source != nil && (r.Source.IP.To4() == nil || len(r.Source.Mask) != net.IPv4len) {
+		return newValidationError("source", fmt.Sprintf("invalid source %s: must be an ipv4 network", r.Source))
+	}
+	if r.Group != "" && uuid.Parse(r.Group) == nil {
+		return newValidationError("group", "invalid group")
+	}
+	return nil
+}
+
+// Validate ensures a FWGroup has reasonable data. Whether the groups it
+// references exist is checked on Save.
 func (f *FWGroup) Validate() error {
 	if _, err := canonicalizeUUID(f.ID); err != nil {
-		return errors.New("invalid ID")
+		return newValidationError("id", "invalid ID")
+	}
+	for i, r := range f.Rules {
+		if err := r.Validate(); err != nil {
+			return newValidationError("rules", fmt.Sprintf("invalid rule %d: %s", i, err))
+		}
+	}
+	for _, id := range f.Includes {
+		if uuid.Parse(id) == nil {
+			return newValidationError("includes", fmt.Sprintf("invalid group %q", id))
+		}
+		if id == f.ID {
+			return newValidationError("includes", "a group can not include itself")
+		}
 	}
 	return nil
 }
 
-// Save persists a FWGroup.
-// It will call Validate.
-func (f *FWGroup) Save() error {
-
-	if err := f.Validate(); err != nil {
-		return err
+// checkReferences ensures the groups the rules of a FWGroup have as source,
+// and those it includes, exist, and that groups do not include each other.
+func (f *FWGroup) checkReferences() error {
+	for i, r := range f.Rules {
+		if r.Group == "" || r.Group == f.ID {
+			continue
+		}
+		if _, err := f.context.FWGroup(r.Group); err != nil {
+			if f.context.IsKeyNotFound(err) {
+				return newValidationError("rules", fmt.Sprintf("invalid rule %d: group %s does not exist", i, r.Group))
+			}
+			return err
+		}
 	}
+	_, err := f.Members()
+	return err
+}
 
-	v, err := json.Marshal(f)
-
-	if err != nil {
-		return err
+// Members returns the ids of the groups whose guests a rule with the FWGroup
+// as source matches: the group itself and those it includes, directly or
+// through other groups, sorted. It fails with a validation error if an
+// included group does not exist or groups include each other.
+func (f *FWGroup) Members() ([]string, error) {
+	members := make(map[string]bool)
+	if err := f.walkIncludes(members, nil); err != nil {
+		return nil, err
 	}
+	ids := make([]string, 0, len(members))
+	for id := range members {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
 
-	// if we changed something, don't clobber
-	index, err := f.context.kv.Update(f.key(), kv.Value{Data: v, Index: f.modifiedIndex})
-	if err != nil {
-		return err
+// walkIncludes adds the FWGroup and those it includes to members. path is the
+// groups including it, which it must not include in turn.
+func (f *FWGroup) walkIncludes(members map[string]bool, path []string) error {
+	path = append(path, f.ID)
+	for _, id := range path[:len(path)-1] {
+		if id == f.ID {
+			return newValidationError("includes", fmt.Sprintf("groups include each other: %s", strings.Join(path, " -> ")))
+		}
 	}
+	if members[f.ID] {
+		return nil
+	}
+	members[f.ID] = true
 
-	f.modifiedIndex = index
+	for _, id := range f.Includes {
+		g, err := f.context.FWGroup(id)
+		if err != nil {
+			if f.context.IsKeyNotFound(err) {
+				return newValidationError("includes", fmt.Sprintf("group %s does not exist", id))
+			}
+			return err
+		}
+		if err := g.walkIncludes(members, path); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// Save persists a FWGroup.
+// It will call Validate.
+func (f *FWGroup) Save() error {
+	return f.context.SaveAll(f)
+}
@@ -3,10 +3,12 @@ package lochness_test
 import (
 	"encoding/json"
 	"net"
+	"sort"
 	"testing"
 
 	"github.com/mistifyio/lochness"
 	"github.com/mistifyio/lochness/internal/tests/common"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
 	"github.com/pborman/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
@@ -58,7 +60,7 @@ func (s *FWGroupSuite) TestRefresh() {
 	fwgroup := s.NewFWGroup()
 	fwgroupCopy := &lochness.FWGroup{}
 	*fwgroupCopy = *fwgroup
-	fwgroup.Rules = lochness.FWRules{&lochness.FWRule{}}
+	fwgroup.Rules = lochness.FWRules{&lochness.FWRule{PortStart: 22, PortEnd: 22, Protocol: "tcp"}}
 
 	_ = fwgroup.Save()
 	s.NoError(fwgroupCopy.Refresh(), "refresh existing should succeed")
@@ -93,6 +95,80 @@ func (s *FWGroupSuite) TestValidate() {
 	}
 }
 
+func (s *FWGroupSuite) TestValidateRules() {
+	_, source, _ := net.ParseCIDR("10.0.0.0/8")
+	_, source6, _ := net.ParseCIDR("fd00::/64")
+
+	tests := []struct {
+		description string
+		rule        *lochness.FWRule
+		expectedErr bool
+	}{
+		{"single port", &lochness.FWRule{PortStart: 22, PortEnd: 22, Protocol: "tcp"}, false},
+		{"port range", &lochness.FWRule{PortStart: 1000, PortEnd: 2000, Protocol: "udp", Source: source}, false},
+		{"group source", &lochness.FWRule{PortStart: 80, PortEnd: 80, Protocol: "tcp", Group: uuid.New()}, false},
+		{"missing protocol", &lochness.FWRule{PortStart: 22, PortEnd: 22}, true},
+		{"unknown protocol", &lochness.FWRule{PortStart: 22, PortEnd: 22, Protocol: "sctp"}, true},
+		{"zero port", &lochness.FWRule{Protocol: "tcp"}, true},
+		{"port too high", &lochness.FWRule{PortStart: 22, PortEnd: 70000, Protocol: "tcp"}, true},
+		{"reversed ports", &lochness.FWRule{PortStart: 2000, PortEnd: 1000, Protocol: "tcp"}, true},
+		{"ipv6 source", &lochness.FWRule{PortStart: 22, PortEnd: 22, Protocol: "tcp", Source: source6}, true},
+		{"invalid group", &lochness.FWRule{PortStart: 22, PortEnd: 22, Protocol: "tcp", Group: "asdf"}, true},
+	}
+
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		fg := s.Context.NewFWGroup()
+		fg.Rules = lochness.FWRules{test.rule}
+		err := fg.Validate()
+		if test.expectedErr {
+			s.True(lerrors.IsValidation(err), msg("should be invalid"))
+		} else {
+			s.NoError(err, msg("should be valid"))
+		}
+	}
+}
+
+func (s *FWGroupSuite) TestReferences() {
+	admin := s.NewFWGroup()
+	ops := s.NewFWGroup()
+	web := s.NewFWGroup()
+
+	// rules may have their own group or existing ones as source
+	web.Rules = lochness.FWRules{
+		{PortStart: 80, PortEnd: 80, Protocol: "tcp", Group: web.ID},
+		{PortStart: 22, PortEnd: 22, Protocol: "tcp", Group: admin.ID},
+	}
+	s.NoError(web.Save())
+
+	web.Rules = append(web.Rules, &lochness.FWRule{PortStart: 22, PortEnd: 22, Protocol: "tcp", Group: uuid.New()})
+	s.True(lerrors.IsValidation(web.Save()), "rules should not have missing groups as source")
+	s.Require().NoError(web.Refresh())
+
+	admin.Includes = []string{ops.ID}
+	s.Require().NoError(admin.Save())
+	members, err := admin.Members()
+	s.NoError(err)
+	expected := []string{admin.ID, ops.ID}
+	sort.Strings(expected)
+	s.Equal(expected, members, "members should be the group and those it includes")
+
+	ops.Includes = []string{web.ID}
+	s.Require().NoError(ops.Save())
+	members, err = admin.Members()
+	s.NoError(err)
+	s.Len(members, 3, "includes should be followed through other groups")
+
+	web.Includes = []string{admin.ID}
+	s.True(lerrors.IsValidation(web.Save()), "groups should not include each other")
+
+	web.Includes = []string{web.ID}
+	s.True(lerrors.IsValidation(web.Save()), "a group should not include itself")
+
+	web.Includes = []string{uuid.New()}
+	s.True(lerrors.IsValidation(web.Save()), "a group should not include missing groups")
+}
+
 func (s *FWGroupSuite) TestSave() {
 	goodFWGroup := s.Context.NewFWGroup()
 
@@ -0,0 +1,191 @@
+package lochness
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/mistifyio/lochness/pkg/kv"
+)
+
+var (
+	// FWProfilePath is the path in the config store of the FWGroups
+	// instantiated from FWProfiles, by profile and tenant
+	FWProfilePath = "lochness/fwprofiles/"
+
+	// DefaultFWProfileConfig is the config key of the FWProfile that new
+	// guests given no FWGroup are put in an instance of, that of their tenant
+	DefaultFWProfileConfig = "default-fwprofile"
+)
+
+type (
+	// FWProfile is a built-in FWGroup, instantiated once per tenant, so that
+	// guests get a sane firewall policy without their rules being written by
+	// hand
+	FWProfile struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		// rules returns the rules of an instance, given its id
+		rules func(id string) FWRules
+	}
+
+	// FWProfiles is an alias to FWProfile slices
+	FWProfiles []*FWProfile
+
+	// FWGroupProfile is the provenance of a FWGroup instantiated from a
+	// FWProfile
+	FWGroupProfile struct {
+		Name    string    `json:"name"`
+		Tenant  string    `json:"tenant"`
+		Created time.Time `json:"created"`
+	}
+)
+
+var fwProfiles = map[string]*FWProfile{
+	"isolated": {
+		Name:        "isolated",
+		Description: "no incoming traffic",
+		rules:       func(string) FWRules { return FWRules{} },
+	},
+	"web": {
+		Name:        "web",
+		Description: "http and https from anywhere",
+		rules: func(string) FWRules {
+			_, anywhere, _ := net.ParseCIDR("0.0.0.0/0")
+			return FWRules{
+				{Source: anywhere, PortStart: 80, PortEnd: 80, Protocol: "tcp", Action: "accept"},
+				{Source: anywhere, PortStart: 443, PortEnd: 443, Protocol: "tcp", Action: "accept"},
+			}
+		},
+	},
+	"internal-only": {
+		Name:        "internal-only",
+		Description: "any tcp and udp traffic from the tenant's guests in the group",
+		rules: func(id string) FWRules {
+			return FWRules{
+				{Group: id, PortStart: 1, PortEnd: MaxFWPort, Protocol: "tcp", Action: "accept"},
+				{Group: id, PortStart: 1, PortEnd: MaxFWPort, Protocol: "udp", Action: "accept"},
+			}
+		},
+	},
+}
+
+// BuiltinFWProfiles returns the built-in FWProfiles, sorted by name
+func BuiltinFWProfiles() FWProfiles {
+	profiles := make(FWProfiles, 0, len(fwProfiles))
+	for _, p := range fwProfiles {
+		profiles = append(profiles, p)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+	return profiles
+}
+
+// BuiltinFWProfile returns the built-in FWProfile with the name, or a not found
+// error
+func BuiltinFWProfile(name string) (*FWProfile, error) {
+	p, ok := fwProfiles[name]
+	if !ok {
+		return nil, lerrors.NotFoundf("unknown firewall profile %q", name)
+	}
+	return p, nil
+}
+
+// Rules returns the rules of an instance of the FWProfile with the id, whose
+// rules may have it as source
+func (p *FWProfile) Rules(id string) FWRules {
+	return p.rules(id)
+}
+
+// fwProfileKey is the key of the id of the instance of a profile for a tenant
+func fwProfileKey(name, tenant string) string {
+	return filepath.Join(FWProfilePath, name, tenant)
+}
+
+// fwProfileInstance records the FWGroup instantiated from a profile for a
+// tenant. It fails if the profile was already instantiated for the tenant.
+type fwProfileInstance struct {
+	group *FWGroup
+}
+
+func (i fwProfileInstance) saveOps() ([]kv.Op, error) {
+	return []kv.Op{{
+		Key:   fwProfileKey(i.group.Profile.Name, i.group.Profile.Tenant),
+		Value: kv.Value{Data: []byte(i.group.ID)},
+	}}, nil
+}
+
+func (i fwProfileInstance) saved([]uint64) error {
+	return nil
+}
+
+// InstantiateFWProfile creates and saves the FWGroup of a profile for a
+// tenant, recording the profile and tenant as its provenance. It fails with a
+// conflict if the profile was already instantiated for the tenant.
+func (c *Context) InstantiateFWProfile(name, tenant string) (*FWGroup, error) {
+	p, err := BuiltinFWProfile(name)
+	if err != nil {
+		return nil, err
+	}
+	if tenant == "" || strings.Contains(tenant, "/") {
+		return nil, newValidationError("tenant", fmt.Sprintf("invalid tenant %q", tenant))
+	}
+
+	f := c.NewFWGroup()
+	f.Rules = p.Rules(f.ID)
+	f.Metadata[TenantMetadataKey] = tenant
+	f.Profile = &FWGroupProfile{
+		Name:    p.Name,
+		Tenant:  tenant,
+		Created: time.Now().UTC(),
+	}
+	if err := c.SaveAll(f, fwProfileInstance{f}); err != nil {
+		if lerrors.IsConflict(err) {
+			return nil, lerrors.Conflictf("firewall profile %s is already instantiated for tenant %s", name, tenant)
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// FWProfileGroup fetches the FWGroup instantiated from a profile for a tenant
+func (c *Context) FWProfileGroup(name, tenant string) (*FWGroup, error) {
+	resp, err := c.kv.Get(fwProfileKey(name, tenant))
+	if err != nil {
+		return nil, err
+	}
+	return c.FWGroup(string(resp.Data))
+}
+
+// DefaultFWGroup returns the id of the FWGroup new guests of a tenant are
+// created in when they are given none: the instance of the cluster's default
+// FWProfile for the tenant, instantiated if need be. It returns "" if no
+// default profile is configured or the guest has no tenant.
+func (c *Context) DefaultFWGroup(tenant string) (string, error) {
+	name, err := c.GetConfig(DefaultFWProfileConfig)
+	if err != nil {
+		if c.IsKeyNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	if name == "" || tenant == "" {
+		return "", nil
+	}
+
+	f, err := c.FWProfileGroup(name, tenant)
+	if c.IsKeyNotFound(err) {
+		f, err = c.InstantiateFWProfile(name, tenant)
+		// instantiated concurrently for another guest
+		if lerrors.IsConflict(err) {
+			f, err = c.FWProfileGroup(name, tenant)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	return f.ID, nil
+}
@@ -0,0 +1,83 @@
+package lochness_test
+
+import (
+	"testing"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestFWProfile(t *testing.T) {
+	suite.Run(t, new(FWProfileSuite))
+}
+
+type FWProfileSuite struct {
+	common.Suite
+}
+
+func (s *FWProfileSuite) TestBuiltinFWProfiles() {
+	names := []string{}
+	for _, p := range lochness.BuiltinFWProfiles() {
+		names = append(names, p.Name)
+		f := s.Context.NewFWGroup()
+		f.Rules = p.Rules(f.ID)
+		s.NoError(f.Validate(), p.Name+" rules should be valid")
+	}
+	s.Equal([]string{"internal-only", "isolated", "web"}, names)
+
+	_, err := lochness.BuiltinFWProfile("foobar")
+	s.True(lerrors.IsNotFound(err))
+}
+
+func (s *FWProfileSuite) TestInstantiate() {
+	f, err := s.Context.InstantiateFWProfile("internal-only", "acme")
+	s.Require().NoError(err)
+	s.Equal("internal-only", f.Profile.Name)
+	s.Equal("acme", f.Profile.Tenant)
+	s.False(f.Profile.Created.IsZero())
+	s.Equal("acme", f.Metadata[lochness.TenantMetadataKey])
+	s.Equal(f.ID, f.Rules[0].Group, "rules should have the instance itself as source")
+
+	saved, err := s.Context.FWProfileGroup("internal-only", "acme")
+	s.Require().NoError(err)
+	s.Equal(f.ID, saved.ID)
+	s.Equal(f.Profile.Name, saved.Profile.Name, "the provenance should be saved")
+
+	_, err = s.Context.InstantiateFWProfile("internal-only", "acme")
+	s.True(lerrors.IsConflict(err), "a profile should be instantiated once per tenant")
+	other, err := s.Context.InstantiateFWProfile("internal-only", "initech")
+	s.Require().NoError(err)
+	s.NotEqual(f.ID, other.ID)
+
+	_, err = s.Context.InstantiateFWProfile("foobar", "acme")
+	s.True(lerrors.IsNotFound(err))
+	_, err = s.Context.InstantiateFWProfile("web", "")
+	s.True(lerrors.IsValidation(err))
+	_, err = s.Context.FWProfileGroup("web", "acme")
+	s.True(s.Context.IsKeyNotFound(err))
+}
+
+func (s *FWProfileSuite) TestDefaultFWGroup() {
+	id, err := s.Context.DefaultFWGroup("acme")
+	s.NoError(err)
+	s.Empty(id, "no group should be given without a default profile")
+
+	s.True(lerrors.IsValidation(s.Context.SetConfig(lochness.DefaultFWProfileConfig, "foobar")))
+	s.Require().NoError(s.Context.SetConfig(lochness.DefaultFWProfileConfig, "web"))
+
+	id, err = s.Context.DefaultFWGroup("acme")
+	s.Require().NoError(err)
+	f, err := s.Context.FWProfileGroup("web", "acme")
+	s.Require().NoError(err)
+	s.Equal(f.ID, id, "the default profile should be instantiated for the tenant")
+
+	again, err := s.Context.DefaultFWGroup("acme")
+	s.NoError(err)
+	s.Equal(id, again, "the instance should be reused")
+
+	id, err = s.Context.DefaultFWGroup("")
+	s.NoError(err)
+	s.Empty(id, "guests without a tenant should not be given a group")
+}
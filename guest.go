@@ -2,14 +2,18 @@ package lochness
 
 import (
 	"crypto/md5"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"math/rand"
 	"net"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	log "github.com/Sirupsen/logrus"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
 	"github.com/mistifyio/lochness/pkg/kv"
 	"github.com/pborman/uuid"
 )
@@ -17,25 +21,91 @@ import (
 var (
 	// GuestPath is the path in the config store
 	GuestPath = "lochness/guests/"
+
+	// MaxUserDataSize is the largest user-data or vendor-data a guest may
+	// have, after decoding
+	MaxUserDataSize = 16 * 1024
+)
+
+// Encodings of guest user-data and vendor-data
+const (
+	DataEncodingRaw    = "raw"
+	DataEncodingBase64 = "base64"
+)
+
+// States of a guest, recorded in its "state" metadata once an action completes
+const (
+	GuestStateRunning   = "running"
+	GuestStateShutdown  = "shutdown"
+	GuestStateSuspended = "suspended"
 )
 
+// guestTransition is the states a guest action may start from and the state it
+// leaves the guest in. A nil from allows any state.
+type guestTransition struct {
+	from []string
+	to   string
+}
+
+// guestTransitions are the state transitions of the guest actions
+var guestTransitions = map[string]guestTransition{
+	"create":   {nil, GuestStateRunning},
+	"start":    {[]string{GuestStateShutdown, GuestStateSuspended}, GuestStateRunning},
+	"shutdown": {[]string{GuestStateRunning, GuestStateSuspended}, GuestStateShutdown},
+	"poweroff": {[]string{GuestStateRunning, GuestStateSuspended}, GuestStateShutdown},
+	"reboot":   {[]string{GuestStateRunning}, GuestStateRunning},
+	"restart":  {[]string{GuestStateRunning}, GuestStateRunning},
+	"suspend":  {[]string{GuestStateRunning}, GuestStateSuspended},
+}
+
 type (
 	// Guest is a virtual machine
 	Guest struct {
 		context       *Context
 		modifiedIndex uint64
-		ID            string            `json:"id"`
+		ID            string            `json:"id" schema:"uuid"`
 		Metadata      map[string]string `json:"metadata"`
-		Type          string            `json:"type"`       // type of guest. currently just kvm
-		FlavorID      string            `json:"flavor"`     // resource flavor
-		HypervisorID  string            `json:"hypervisor"` // hypervisor. may be blank if not assigned yet
-		NetworkID     string            `json:"network"`
-		SubnetID      string            `json:"subnet"`
-		FWGroupID     string            `json:"fwgroup"`
-		VLANGroupID   string            `json:"vlangroup"`
+		Type          string            `json:"type"`                              // type of guest. currently just kvm
+		FlavorID      string            `json:"flavor" schema:"required,uuid"`     // resource flavor
+		ImageID       string            `json:"image,omitempty" schema:"uuid"`     // catalog image. the flavor's image if blank
+		HypervisorID  string            `json:"hypervisor" schema:"readonly,uuid"` // hypervisor. may be blank if not assigned yet
+		NetworkID     string            `json:"network" schema:"required,uuid"`
+		SubnetID      string            `json:"subnet" schema:"uuid"`
+		FWGroupID     string            `json:"fwgroup" schema:"uuid"`
+		VLANGroupID   string            `json:"vlangroup" schema:"uuid"`
 		MAC           net.HardwareAddr  `json:"mac"`
 		IP            net.IP            `json:"ip"`
 		Bridge        string            `json:"bridge"`
+		UserData      string            `json:"user_data,omitempty"`                              // served to the guest by cmetadatad, e.g. cloud-init config
+		VendorData    string            `json:"vendor_data,omitempty"`                            // served to the guest by cmetadatad
+		DataEncoding  string            `json:"data_encoding,omitempty" schema:"enum=raw|base64"` // encoding of UserData and VendorData. raw if blank
+		CloneOf       string            `json:"clone_of,omitempty" schema:"readonly,uuid"`        // guest whose disks this guest's are cloned from
+		CloneSnapshot string            `json:"clone_snapshot,omitempty" schema:"readonly"`       // snapshot of CloneOf cloned. its current disks if blank
+		ResizeFlavor  string            `json:"resize_flavor,omitempty" schema:"readonly,uuid"`   // flavor the guest is being resized to, see Resize
+		Secrets       map[string]string `json:"secrets,omitempty" schema:"uuid"`                  // secret ids by purpose, e.g. "root-password"
+		Constraints   string            `json:"constraints,omitempty"`                            // hypervisors the guest may be placed on, see Constraint
+		AntiAffinity  string            `json:"anti_affinity,omitempty"`                          // group of guests spread across failure domains, see FailureDomain
+		DNSName       string            `json:"dns_name,omitempty"`                               // fully qualified name, unique in its domain, see DNSRecord
+		Tombstone     *Tombstone        `json:"deleted,omitempty" schema:"readonly"`              // set once soft deleted, see SoftDelete
+
+		// indexedMetadata is the metadata in the metadata index, so that
+		// Save only updates the pairs that changed
+		indexedMetadata map[string]string
+		// savedDNSName is the DNS name of the guest's DNS record, so that
+		// Save moves it when the name changes
+		savedDNSName string
+		// savedDesired is what of the guest was in the desired state of its
+		// hypervisor when last saved, so that Save only bumps the generation
+		// of the desired state when it changes
+		savedDesired guestDesired
+	}
+
+	// guestDesired is what of a guest its hypervisor's desired state changes
+	// with: the assignment, flavor, and deletion of the guest
+	guestDesired struct {
+		hypervisorID string
+		flavorID     string
+		deleted      bool
 	}
 
 	// Guests is an alias to a slice of *Guest
@@ -43,18 +113,30 @@ type (
 
 	// guestJSON is used to ease json marshal/unmarshal
 	guestJSON struct {
-		ID           string            `json:"id"`
-		Metadata     map[string]string `json:"metadata"`
-		Type         string            `json:"type"`       // type of guest. currently just kvm
-		FlavorID     string            `json:"flavor"`     // resource flavor
-		HypervisorID string            `json:"hypervisor"` // hypervisor. may be blank if not assigned yet
-		NetworkID    string            `json:"network"`
-		SubnetID     string            `json:"subnet"`
-		FWGroupID    string            `json:"fwgroup"`
-		VLANGroupID  string            `json:"vlangroup"`
-		MAC          string            `json:"mac"`
-		IP           net.IP            `json:"ip"`
-		Bridge       string            `json:"bridge"`
+		ID            string            `json:"id"`
+		Metadata      map[string]string `json:"metadata"`
+		Type          string            `json:"type"`            // type of guest. currently just kvm
+		FlavorID      string            `json:"flavor"`          // resource flavor
+		ImageID       string            `json:"image,omitempty"` // catalog image
+		HypervisorID  string            `json:"hypervisor"`      // hypervisor. may be blank if not assigned yet
+		NetworkID     string            `json:"network"`
+		SubnetID      string            `json:"subnet"`
+		FWGroupID     string            `json:"fwgroup"`
+		VLANGroupID   string            `json:"vlangroup"`
+		MAC           string            `json:"mac"`
+		IP            net.IP            `json:"ip"`
+		Bridge        string            `json:"bridge"`
+		UserData      string            `json:"user_data,omitempty"`
+		VendorData    string            `json:"vendor_data,omitempty"`
+		DataEncoding  string            `json:"data_encoding,omitempty"`
+		CloneOf       string            `json:"clone_of,omitempty"`
+		CloneSnapshot string            `json:"clone_snapshot,omitempty"`
+		ResizeFlavor  string            `json:"resize_flavor,omitempty"`
+		Secrets       map[string]string `json:"secrets,omitempty"`
+		Constraints   string            `json:"constraints,omitempty"`
+		AntiAffinity  string            `json:"anti_affinity,omitempty"`
+		DNSName       string            `json:"dns_name,omitempty"`
+		Tombstone     *Tombstone        `json:"deleted,omitempty"`
 	}
 
 	// CandidateFunction is used to select hypervisors that can run the given guest.
@@ -64,18 +146,30 @@ type (
 // MarshalJSON is a helper for marshalling a Guest
 func (g *Guest) MarshalJSON() ([]byte, error) {
 	data := guestJSON{
-		ID:           g.ID,
-		Metadata:     g.Metadata,
-		Type:         g.Type,
-		FlavorID:     g.FlavorID,
-		NetworkID:    g.NetworkID,
-		SubnetID:     g.SubnetID,
-		FWGroupID:    g.FWGroupID,
-		VLANGroupID:  g.VLANGroupID,
-		HypervisorID: g.HypervisorID,
-		IP:           g.IP,
-		MAC:          g.MAC.String(),
-		Bridge:       g.Bridge,
+		ID:            g.ID,
+		Metadata:      g.Metadata,
+		Type:          g.Type,
+		FlavorID:      g.FlavorID,
+		ImageID:       g.ImageID,
+		NetworkID:     g.NetworkID,
+		SubnetID:      g.SubnetID,
+		FWGroupID:     g.FWGroupID,
+		VLANGroupID:   g.VLANGroupID,
+		HypervisorID:  g.HypervisorID,
+		IP:            g.IP,
+		MAC:           g.MAC.String(),
+		Bridge:        g.Bridge,
+		UserData:      g.UserData,
+		VendorData:    g.VendorData,
+		DataEncoding:  g.DataEncoding,
+		CloneOf:       g.CloneOf,
+		CloneSnapshot: g.CloneSnapshot,
+		ResizeFlavor:  g.ResizeFlavor,
+		Secrets:       g.Secrets,
+		Constraints:   g.Constraints,
+		AntiAffinity:  g.AntiAffinity,
+		DNSName:       g.DNSName,
+		Tombstone:     g.Tombstone,
 	}
 
 	return json.Marshal(data)
@@ -101,6 +195,9 @@ func (g *Guest) UnmarshalJSON(input []byte) error {
 	if data.FlavorID != "" {
 		g.FlavorID = data.FlavorID
 	}
+	if data.ImageID != "" {
+		g.ImageID = data.ImageID
+	}
 	if data.NetworkID != "" {
 		g.NetworkID = data.NetworkID
 	}
@@ -122,6 +219,39 @@ func (g *Guest) UnmarshalJSON(input []byte) error {
 	if data.Bridge != "" {
 		g.Bridge = data.Bridge
 	}
+	if data.UserData != "" {
+		g.UserData = data.UserData
+	}
+	if data.VendorData != "" {
+		g.VendorData = data.VendorData
+	}
+	if data.DataEncoding != "" {
+		g.DataEncoding = data.DataEncoding
+	}
+	if data.CloneOf != "" {
+		g.CloneOf = data.CloneOf
+	}
+	if data.CloneSnapshot != "" {
+		g.CloneSnapshot = data.CloneSnapshot
+	}
+	if data.ResizeFlavor != "" {
+		g.ResizeFlavor = data.ResizeFlavor
+	}
+	if data.Secrets != nil {
+		g.Secrets = data.Secrets
+	}
+	if data.Constraints != "" {
+		g.Constraints = data.Constraints
+	}
+	if data.AntiAffinity != "" {
+		g.AntiAffinity = data.AntiAffinity
+	}
+	if data.DNSName != "" {
+		g.DNSName = data.DNSName
+	}
+	if data.Tombstone != nil {
+		g.Tombstone = data.Tombstone
+	}
 
 	if data.MAC != "" {
 		a, err := net.ParseMAC(data.MAC)
@@ -184,7 +314,22 @@ func (g *Guest) key() string {
 // fromResponse is a helper to unmarshal a Guest
 func (g *Guest) fromResponse(value kv.Value) error {
 	g.modifiedIndex = value.Index
-	return json.Unmarshal(value.Data, &g)
+	if err := json.Unmarshal(value.Data, &g); err != nil {
+		return err
+	}
+	g.indexedMetadata = copyMetadata(g.Metadata)
+	g.savedDNSName = g.DNSName
+	g.savedDesired = g.desired()
+	return nil
+}
+
+// desired returns what of the guest is in the desired state of its hypervisor
+func (g *Guest) desired() guestDesired {
+	return guestDesired{
+		hypervisorID: g.HypervisorID,
+		flavorID:     g.FlavorID,
+		deleted:      g.IsDeleted(),
+	}
 }
 
 // Refresh reloads from the data store
@@ -201,47 +346,112 @@ func (g *Guest) Refresh() error {
 // Validate ensures a Guest has reasonable data.
 func (g *Guest) Validate() error {
 	if _, err := canonicalizeUUID(g.ID); err != nil {
-		return errors.New("missing or invalid id")
+		return newValidationError("id", "missing or invalid id")
 	}
 	if _, err := canonicalizeUUID(g.FlavorID); err != nil {
-		return errors.New("missing or invalid flavor")
+		return newValidationError("flavor", "missing or invalid flavor")
 	}
 	if _, err := canonicalizeUUID(g.NetworkID); err != nil {
-		return errors.New("missing or invalid network")
+		return newValidationError("network", "missing or invalid network")
+	}
+	if g.ImageID != "" && uuid.Parse(g.ImageID) == nil {
+		return newValidationError("image", "invalid image")
 	}
 	if g.MAC == nil {
-		return errors.New("missing MAC")
+		return newValidationError("mac", "missing MAC")
+	}
+	if g.CloneOf != "" && uuid.Parse(g.CloneOf) == nil {
+		return newValidationError("clone_of", "invalid clone source")
+	}
+	if g.CloneSnapshot != "" && g.CloneOf == "" {
+		return newValidationError("clone_snapshot", "snapshot without a clone source")
+	}
+	if g.ResizeFlavor != "" && uuid.Parse(g.ResizeFlavor) == nil {
+		return newValidationError("resize_flavor", "invalid resize flavor")
+	}
+	switch g.DataEncoding {
+	case "", DataEncodingRaw, DataEncodingBase64:
+	default:
+		return newValidationError("data_encoding", "invalid data encoding")
+	}
+	if data, err := g.UserDataBytes(); err != nil || len(data) > MaxUserDataSize {
+		return newValidationError("user_data", "invalid or too large user data")
+	}
+	if data, err := g.VendorDataBytes(); err != nil || len(data) > MaxUserDataSize {
+		return newValidationError("vendor_data", "invalid or too large vendor data")
+	}
+	if err := validateSecretRefs(g.Secrets); err != nil {
+		return err
+	}
+	if strings.TrimSpace(g.Constraints) != "" {
+		if _, err := ParseConstraint(g.Constraints); err != nil {
+			return err
+		}
+	}
+	if g.DNSName != "" {
+		if _, _, err := ParseDNSName(g.DNSName); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-// Save persists the Guest to the data store.
-func (g *Guest) Save() error {
+// State returns the state of the guest, or "" if it is not known
+func (g *Guest) State() string {
+	return g.Metadata["state"]
+}
 
-	if err := g.Validate(); err != nil {
-		return err
+// CheckAction returns a conflict error if the action is not allowed from the
+// state of the guest. Guests of unknown state and unknown actions are allowed.
+func (g *Guest) CheckAction(action string) error {
+	state := g.State()
+	t, ok := guestTransitions[action]
+	if state == "" || !ok || t.from == nil {
+		return nil
+	}
+	for _, from := range t.from {
+		if state == from {
+			return nil
+		}
 	}
+	return lerrors.Conflictf("can not %s a guest that is %s", action, state)
+}
 
-	v, err := json.Marshal(g)
+// GuestActionState returns the state a guest is left in by an action, or "" if
+// the action does not change the state
+func GuestActionState(action string) string {
+	return guestTransitions[action].to
+}
 
-	if err != nil {
-		return err
-	}
+// UserDataBytes returns the decoded user-data of the guest
+func (g *Guest) UserDataBytes() ([]byte, error) {
+	return g.decodeData(g.UserData)
+}
 
-	index, err := g.context.kv.Update(g.key(), kv.Value{Data: v, Index: g.modifiedIndex})
-	if err != nil {
-		return err
+// VendorDataBytes returns the decoded vendor-data of the guest
+func (g *Guest) VendorDataBytes() ([]byte, error) {
+	return g.decodeData(g.VendorData)
+}
+
+// decodeData is a helper to decode user-data or vendor-data
+func (g *Guest) decodeData(data string) ([]byte, error) {
+	if g.DataEncoding == DataEncodingBase64 {
+		return base64.StdEncoding.DecodeString(data)
 	}
-	g.modifiedIndex = index
-	return nil
+	return []byte(data), nil
+}
+
+// Save persists the Guest to the data store.
+func (g *Guest) Save() error {
+	return g.context.SaveAll(g)
 }
 
 // Destroy removes a guest
 func (g *Guest) Destroy() error {
 	if g.modifiedIndex == 0 {
 		// it has not been saved?
-		return errors.New("not persisted")
+		return lerrors.NotFound(errors.New("not persisted"))
 	}
 
 	if g.HypervisorID != "" {
@@ -254,10 +464,28 @@ func (g *Guest) Destroy() error {
 		}
 	}
 
-	if err := g.context.kv.Remove(g.key(), g.modifiedIndex); err != nil {
+	ops, err := g.context.metadataOps(MetadataKindGuests, g.ID, g.indexedMetadata, nil)
+	if err != nil {
+		return err
+	}
+	ops = append([]kv.Op{{Key: g.key(), Value: kv.Value{Index: g.modifiedIndex}, Remove: true}}, ops...)
+	if _, err := kv.Txn(g.context.kv, ops); err != nil {
+		return err
+	}
+	if err := g.removeDNSRecord(); err != nil {
+		return err
+	}
+	if err := g.context.kv.Delete(filepath.Join(GuestPath, g.ID), true); err != nil {
 		return err
 	}
-	return g.context.kv.Delete(filepath.Join(GuestPath, g.ID), true)
+	// the guest is gone either way, so a failure only loses its usage
+	if err := g.recordUsageDestroyed(); err != nil {
+		log.WithFields(log.Fields{
+			"guest": g.ID,
+			"error": err,
+		}).Error("unable to record guest usage")
+	}
+	return nil
 }
 
 // Candidates returns a list of Hypervisors that may run this Guest.
@@ -315,8 +543,37 @@ func CandidateIsAlive(g *Guest, hs Hypervisors) (Hypervisors, error) {
 	return hypervisors, nil
 }
 
+// CandidateNotInMaintenance returns Hypervisors that are not in maintenance,
+// nor soft deleted
+func CandidateNotInMaintenance(g *Guest, hs Hypervisors) (Hypervisors, error) {
+	logFields := log.Fields{
+		"guestID": g.ID,
+		"func":    "CandidateNotInMaintenance",
+	}
+
+	var hypervisors Hypervisors
+	for _, h := range hs {
+		if !h.Maintenance && !h.IsDeleted() {
+			hypervisors = append(hypervisors, h)
+		} else {
+			log.WithFields(logFields).WithFields(log.Fields{
+				"hypervisorID": h.ID,
+			}).Debug("hypervisor candidate failed")
+		}
+	}
+
+	log.WithFields(logFields).WithFields(log.Fields{
+		"in":      len(hs),
+		"out":     len(hypervisors),
+		"removed": len(hs) - len(hypervisors),
+	}).Info("hypervisor candidates filtered")
+
+	return hypervisors, nil
+}
+
 // CandidateHasResources returns Hypervisors that have available resources
-// based on the request Flavor of the Guest.
+// based on the request Flavor of the Guest, see Hypervisor.CheckResources. If
+// none do, it returns a validation error with the reason each was rejected.
 func CandidateHasResources(g *Guest, hs Hypervisors) (Hypervisors, error) {
 	logFields := log.Fields{
 		"guestID": g.ID,
@@ -329,26 +586,23 @@ func CandidateHasResources(g *Guest, hs Hypervisors) (Hypervisors, error) {
 	}
 
 	var hypervisors Hypervisors
+	var rejections []string
 	for _, h := range hs {
-		avail := h.AvailableResources
-		if avail.Disk < f.Disk {
-			log.WithFields(logFields).WithFields(log.Fields{
-				"hypervisorID": h.ID,
-				"resource":     "disk",
-			}).Debug("hypervisor candidate failed")
-		} else if avail.Memory < f.Memory {
-			log.WithFields(logFields).WithFields(log.Fields{
-				"hypervisorID": h.ID,
-				"resource":     "memory",
-			}).Debug("hypervisor candidate failed")
-		} else if avail.CPU < f.CPU {
-			log.WithFields(logFields).WithFields(log.Fields{
-				"hypervisorID": h.ID,
-				"resource":     "cpu",
-			}).Debug("hypervisor candidate failed")
-		} else {
+		err := h.CheckResources(f)
+		if err == nil {
 			hypervisors = append(hypervisors, h)
+			continue
 		}
+		verr, ok := err.(*ValidationError)
+		if !ok {
+			return nil, err
+		}
+		log.WithFields(logFields).WithFields(log.Fields{
+			"hypervisorID": h.ID,
+			"resource":     verr.Fields[0],
+			"error":        verr.Message,
+		}).Debug("hypervisor candidate failed")
+		rejections = append(rejections, verr.Message)
 	}
 
 	log.WithFields(logFields).WithFields(log.Fields{
@@ -357,6 +611,9 @@ func CandidateHasResources(g *Guest, hs Hypervisors) (Hypervisors, error) {
 		"removed": len(hs) - len(hypervisors),
 	}).Info("hypervisor candidates filtered")
 
+	if len(hypervisors) == 0 && len(rejections) > 0 {
+		return nil, newValidationError("flavor", fmt.Sprintf("no hypervisor has the resources for flavor %s: %s", f.ID, strings.Join(rejections, "; ")))
+	}
 	return hypervisors, nil
 }
 
@@ -420,6 +677,31 @@ func CandidateRandomize(g *Guest, hs Hypervisors) (Hypervisors, error) {
 	return randomizeHypervisors(hs), nil
 }
 
+// CandidateHealthy orders the list of Hypervisors by their health score, most
+// healthy first, so flapping hypervisors are only used when others are not
+// available. The order of equally healthy Hypervisors is kept.
+func CandidateHealthy(g *Guest, hs Hypervisors) (Hypervisors, error) {
+	byScore := hypervisorsByScore{hs: hs, scores: make([]float64, len(hs))}
+	for i, h := range hs {
+		byScore.scores[i] = h.Health().Score
+	}
+	sort.Stable(byScore)
+	return hs, nil
+}
+
+// hypervisorsByScore sorts Hypervisors by descending health score
+type hypervisorsByScore struct {
+	hs     Hypervisors
+	scores []float64
+}
+
+func (b hypervisorsByScore) Len() int           { return len(b.hs) }
+func (b hypervisorsByScore) Less(i, j int) bool { return b.scores[i] > b.scores[j] }
+func (b hypervisorsByScore) Swap(i, j int) {
+	b.hs[i], b.hs[j] = b.hs[j], b.hs[i]
+	b.scores[i], b.scores[j] = b.scores[j], b.scores[i]
+}
+
 // based on code found on stackoverflow(?)
 func randomizeHypervisors(s Hypervisors) Hypervisors {
 	for i := range s {
@@ -433,9 +715,33 @@ func randomizeHypervisors(s Hypervisors) Hypervisors {
 // DefaultCandidateFunctions is a default list of CandidateFunctions for general use
 var DefaultCandidateFunctions = []CandidateFunction{
 	CandidateIsAlive,
+	CandidateNotInMaintenance,
+	CandidateMatchesConstraints,
+	CandidateIsCloneSourceHypervisor,
 	CandidateHasSubnet,
 	CandidateHasResources,
 	CandidateRandomize,
+	CandidateHealthy,
+	CandidateSpreadFailureDomains,
+}
+
+// FirstGuest will return the first guest for which the function returns true.
+func (c *Context) FirstGuest(f func(*Guest) bool) (*Guest, error) {
+	keys, err := c.kv.Keys(GuestPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		g, err := c.Guest(filepath.Base(k))
+		if err != nil {
+			return nil, err
+		}
+
+		if f(g) {
+			return g, nil
+		}
+	}
+	return nil, nil
 }
 
 // ForEachGuest will run f on each Guest. It will stop iteration if f returns an error.
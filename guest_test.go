@@ -1,15 +1,19 @@
 package lochness_test
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/mistifyio/lochness"
 	"github.com/mistifyio/lochness/internal/tests/common"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
 	"github.com/pborman/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
@@ -25,6 +29,9 @@ type GuestSuite struct {
 
 func (s *GuestSuite) TestJSON() {
 	guest := s.NewGuest()
+	guest.UserData = "I2Nsb3VkLWNvbmZpZwo="
+	guest.VendorData = "I2Nsb3VkLWNvbmZpZwo="
+	guest.DataEncoding = lochness.DataEncodingBase64
 
 	guestBytes, err := json.Marshal(guest)
 	s.NoError(err)
@@ -33,6 +40,9 @@ func (s *GuestSuite) TestJSON() {
 	s.NoError(json.Unmarshal(guestBytes, guestFromJSON))
 	s.Equal(guest.MAC, guestFromJSON.MAC)
 	s.Equal(guest.IP, guestFromJSON.IP)
+	s.Equal(guest.UserData, guestFromJSON.UserData)
+	s.Equal(guest.VendorData, guestFromJSON.VendorData)
+	s.Equal(guest.DataEncoding, guestFromJSON.DataEncoding)
 }
 
 func (s *GuestSuite) TestNewGuest() {
@@ -117,6 +127,82 @@ func (s *GuestSuite) TestValidate() {
 	}
 }
 
+func (s *GuestSuite) TestValidateData() {
+	tooLarge := strings.Repeat("a", lochness.MaxUserDataSize+1)
+	tests := []struct {
+		description string
+		userData    string
+		vendorData  string
+		encoding    string
+		expected    string
+		expectedErr bool
+	}{
+		{"no data", "", "", "", "", false},
+		{"raw", "#cloud-config\n", "", "", "#cloud-config\n", false},
+		{"explicit raw", "#cloud-config\n", "", lochness.DataEncodingRaw, "#cloud-config\n", false},
+		{"base64", "I2Nsb3VkLWNvbmZpZwo=", "", lochness.DataEncodingBase64, "#cloud-config\n", false},
+		{"invalid base64", "#cloud-config", "", lochness.DataEncodingBase64, "", true},
+		{"unknown encoding", "#cloud-config\n", "", "gzip", "", true},
+		{"largest user data", tooLarge[1:], "", "", tooLarge[1:], false},
+		{"user data too large", tooLarge, "", "", "", true},
+		{"encoded user data too large", base64.StdEncoding.EncodeToString([]byte(tooLarge)), "", lochness.DataEncodingBase64, "", true},
+		{"vendor data too large", "", tooLarge, "", "", true},
+	}
+
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		g := s.NewGuest()
+		g.UserData = test.userData
+		g.VendorData = test.vendorData
+		g.DataEncoding = test.encoding
+		err := g.Validate()
+		if test.expectedErr {
+			s.Error(err, msg("should be invalid"))
+			continue
+		}
+		s.NoError(err, msg("should be valid"))
+		data, err := g.UserDataBytes()
+		s.NoError(err, msg("should decode"))
+		s.Equal(test.expected, string(data), msg("wrong user data"))
+	}
+}
+
+func (s *GuestSuite) TestCheckAction() {
+	tests := []struct {
+		description string
+		state       string
+		action      string
+		expectedErr bool
+	}{
+		{"unknown state", "", "reboot", false},
+		{"unknown action", lochness.GuestStateShutdown, "snapshot", false},
+		{"reboot running", lochness.GuestStateRunning, "reboot", false},
+		{"reboot shutdown", lochness.GuestStateShutdown, "reboot", true},
+		{"start shutdown", lochness.GuestStateShutdown, "start", false},
+		{"start suspended", lochness.GuestStateSuspended, "start", false},
+		{"start running", lochness.GuestStateRunning, "start", true},
+		{"shutdown suspended", lochness.GuestStateSuspended, "shutdown", false},
+		{"shutdown shutdown", lochness.GuestStateShutdown, "shutdown", true},
+		{"suspend suspended", lochness.GuestStateSuspended, "suspend", true},
+		{"create any", lochness.GuestStateShutdown, "create", false},
+	}
+
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		g := s.NewGuest()
+		g.Metadata = map[string]string{"state": test.state}
+		err := g.CheckAction(test.action)
+		if test.expectedErr {
+			s.True(lerrors.IsConflict(err), msg("should conflict"))
+			continue
+		}
+		s.NoError(err, msg("should be allowed"))
+	}
+
+	s.Equal(lochness.GuestStateSuspended, lochness.GuestActionState("suspend"))
+	s.Equal("", lochness.GuestActionState("snapshot"))
+}
+
 func (s *GuestSuite) TestSave() {
 	goodGuest := s.Context.NewGuest()
 	flavor := s.NewFlavor()
@@ -233,6 +319,46 @@ func (s *GuestSuite) TestCandidateIsAlive() {
 	s.Equal(hypervisors[1].ID, candidates[0].ID)
 }
 
+func (s *GuestSuite) TestCandidateNotInMaintenance() {
+	guest := s.NewGuest()
+	hypervisors := lochness.Hypervisors{
+		s.NewHypervisor(),
+		s.NewHypervisor(),
+	}
+	hypervisors[0].Maintenance = true
+
+	candidates, err := lochness.CandidateNotInMaintenance(guest, hypervisors)
+	s.NoError(err)
+	s.Len(candidates, 1)
+	s.Equal(hypervisors[1].ID, candidates[0].ID)
+}
+
+func (s *GuestSuite) TestCandidateHealthy() {
+	guest := s.NewGuest()
+	hypervisors := lochness.Hypervisors{
+		s.NewHypervisor(), // Flapping
+		s.NewHypervisor(), // Steady
+		s.NewHypervisor(), // Steady
+	}
+	now := time.Now()
+	for i, h := range hypervisors {
+		beats := []time.Time{now.Add(-2 * time.Minute), now.Add(-time.Minute), now}
+		if i == 0 {
+			beats = []time.Time{now.Add(-10 * time.Minute), now}
+		}
+		history, _ := json.Marshal(map[string]interface{}{"ttl": time.Minute, "beats": beats})
+		s.Require().NoError(s.KV.Set(filepath.Join(lochness.HypervisorPath, h.ID, "health"), string(history)))
+		s.Require().NoError(h.Refresh())
+	}
+
+	candidates, err := lochness.CandidateHealthy(guest, append(lochness.Hypervisors{}, hypervisors...))
+	s.NoError(err)
+	s.Require().Len(candidates, 3)
+	s.Equal(hypervisors[1].ID, candidates[0].ID, "order of equally healthy should be kept")
+	s.Equal(hypervisors[2].ID, candidates[1].ID, "order of equally healthy should be kept")
+	s.Equal(hypervisors[0].ID, candidates[2].ID, "flapping should be last")
+}
+
 func (s *GuestSuite) TestCandidateHasResources() {
 	guest := s.NewGuest()
 	hypervisors := lochness.Hypervisors{
@@ -245,6 +371,13 @@ func (s *GuestSuite) TestCandidateHasResources() {
 	s.NoError(err)
 	s.Len(candidates, 1)
 	s.Equal(hypervisors[1].ID, candidates[0].ID)
+
+	hypervisors[1].AvailableResources = lochness.Resources{}
+	candidates, err = lochness.CandidateHasResources(guest, hypervisors)
+	s.True(lerrors.IsValidation(err), "no candidates should be a validation error")
+	s.Contains(err.Error(), hypervisors[0].ID)
+	s.Contains(err.Error(), hypervisors[1].ID)
+	s.Empty(candidates)
 }
 
 func (s *GuestSuite) TestCandidateHasSubnet() {
@@ -295,6 +428,22 @@ func (s *GuestSuite) TestCandidateRandomize() {
 
 }
 
+func (s *GuestSuite) TestFirstGuest() {
+	_ = s.NewGuest()
+	guest := s.NewGuest()
+	g, err := s.Context.FirstGuest(func(g *lochness.Guest) bool {
+		return g.ID == guest.ID
+	})
+	s.NoError(err)
+	s.Equal(guest.ID, g.ID)
+
+	g, err = s.Context.FirstGuest(func(g *lochness.Guest) bool {
+		return false
+	})
+	s.NoError(err)
+	s.Nil(g)
+}
+
 func (s *GuestSuite) TestForEachGuest() {
 	guest := s.NewGuest()
 	guest2 := s.NewGuest()
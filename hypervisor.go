@@ -8,11 +8,14 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/mistifyio/lochness/pkg/bmc"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
 	"github.com/mistifyio/lochness/pkg/kv"
 	"github.com/pborman/uuid"
 )
@@ -22,6 +25,15 @@ var (
 	HypervisorPath = "lochness/hypervisors/"
 	// id of currently running hypervisor
 	hypervisorID = ""
+	// HeartbeatHistorySize is the number of recent heartbeats kept to score
+	// the health of a hypervisor
+	HeartbeatHistorySize = 360
+	// configKeyRegexp matches valid hypervisor config keys, which are each a
+	// single key in the config store
+	configKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+	// bridgeRegexp matches valid bridge names, which linux limits to 15
+	// characters
+	bridgeRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,14}$`)
 )
 
 type (
@@ -29,21 +41,28 @@ type (
 	Hypervisor struct {
 		context            *Context
 		modifiedIndex      uint64
-		ID                 string            `json:"id"`
+		indexedMetadata    map[string]string // metadata in the metadata index
+		ID                 string            `json:"id" schema:"uuid"`
 		Metadata           map[string]string `json:"metadata"`
-		IP                 net.IP            `json:"ip"`
+		IP                 net.IP            `json:"ip" schema:"required"`
 		Netmask            net.IP            `json:"netmask"`
 		Gateway            net.IP            `json:"gateway"`
-		MAC                net.HardwareAddr  `json:"mac"`
+		MAC                net.HardwareAddr  `json:"mac" schema:"required"`
 		TotalResources     Resources         `json:"total_resources"`
-		AvailableResources Resources         `json:"available_resources"`
+		AvailableResources Resources         `json:"available_resources" schema:"readonly"`
+		Maintenance        bool              `json:"maintenance"`                         // no new guests are placed on hypervisors in maintenance
+		Secrets            map[string]string `json:"secrets" schema:"uuid"`               // secret ids by purpose, e.g. "agent-token"
+		BMC                *BMC              `json:"bmc"`                                 // power is controlled through it, see Power
+		FailureDomain      *FailureDomain    `json:"failure_domain"`                      // zone, row, and rack guests are spread across
+		Tombstone          *Tombstone        `json:"deleted,omitempty" schema:"readonly"` // set once soft deleted, see SoftDelete
 		subnets            map[string]string
 		guests             []string
 		alive              bool
 		heart              kv.EphemeralKey
+		history            *heartbeatHistory
 		// Config is a set of key/values for driving various config options. writes should
 		// only be done using SetConfig
-		Config map[string]string
+		Config map[string]string `json:"-"`
 	}
 
 	// Hypervisors is an alias to a slice of *Hypervisor
@@ -59,6 +78,31 @@ type (
 		MAC                string            `json:"mac"`
 		TotalResources     Resources         `json:"total_resources"`
 		AvailableResources Resources         `json:"available_resources"`
+		Maintenance        *bool             `json:"maintenance,omitempty"`
+		Secrets            map[string]string `json:"secrets,omitempty"`
+		BMC                *BMC              `json:"bmc,omitempty"`
+		FailureDomain      *FailureDomain    `json:"failure_domain,omitempty"`
+		Tombstone          *Tombstone        `json:"deleted,omitempty"`
+	}
+
+	// heartbeatHistory is the rolling record of heartbeats stored under a
+	// hypervisor
+	heartbeatHistory struct {
+		TTL   time.Duration `json:"ttl"`
+		Beats []time.Time   `json:"beats"`
+	}
+
+	// HypervisorHealth summarizes the recent heartbeats of a Hypervisor.
+	// Availability is the fraction of the recorded time the hypervisor was
+	// alive and Flaps the number of times it went down and came back. Score
+	// combines the two, from 0 for unavailable to 1 for always up.
+	HypervisorHealth struct {
+		Alive        bool      `json:"alive"`
+		LastBeat     time.Time `json:"last_beat"`
+		Beats        int       `json:"beats"`
+		Availability float64   `json:"availability"`
+		Flaps        int       `json:"flaps"`
+		Score        float64   `json:"score"`
 	}
 )
 
@@ -73,6 +117,11 @@ func (h *Hypervisor) MarshalJSON() ([]byte, error) {
 		MAC:                h.MAC.String(),
 		TotalResources:     h.TotalResources,
 		AvailableResources: h.AvailableResources,
+		Maintenance:        &h.Maintenance,
+		Secrets:            h.Secrets,
+		BMC:                h.BMC,
+		FailureDomain:      h.FailureDomain,
+		Tombstone:          h.Tombstone,
 	}
 
 	return json.Marshal(data)
@@ -109,6 +158,21 @@ func (h *Hypervisor) UnmarshalJSON(input []byte) error {
 	if &data.AvailableResources != nil {
 		h.AvailableResources = data.AvailableResources
 	}
+	if data.Maintenance != nil {
+		h.Maintenance = *data.Maintenance
+	}
+	if data.Secrets != nil {
+		h.Secrets = data.Secrets
+	}
+	if data.BMC != nil {
+		h.BMC = data.BMC
+	}
+	if data.FailureDomain != nil {
+		h.FailureDomain = data.FailureDomain
+	}
+	if data.Tombstone != nil {
+		h.Tombstone = data.Tombstone
+	}
 
 	if data.MAC != "" {
 		a, err := net.ParseMAC(data.MAC)
@@ -185,13 +249,14 @@ func (h *Hypervisor) Refresh() error {
 	key := filepath.Join(prefix, "metadata")
 	value, ok := nodes[key]
 	if !ok {
-		return errors.New("metadata key is missing")
+		return lerrors.NotFound(errors.New("metadata key is missing"))
 	}
 
 	if err := json.Unmarshal(value.Data, &h); err != nil {
 		return err
 	}
 	h.modifiedIndex = value.Index
+	h.indexedMetadata = copyMetadata(h.Metadata)
 	delete(nodes, key)
 
 	// handle heartbeat
@@ -203,6 +268,17 @@ func (h *Hypervisor) Refresh() error {
 		delete(nodes, key)
 	}
 
+	// handle heartbeat history
+	key = filepath.Join(prefix, "health")
+	if value, ok := nodes[key]; ok {
+		var history heartbeatHistory
+		if err := json.Unmarshal(value.Data, &history); err != nil {
+			return err
+		}
+		h.history = &history
+		delete(nodes, key)
+	}
+
 	config := map[string]string{}
 	guests := []string{}
 	subnets := map[string]string{}
@@ -335,17 +411,19 @@ func (h *Hypervisor) VerifyOnHV() error {
 }
 
 // calcGuestsUsage calculates total resource usage of managed guests.
-// Note that CPU "usage" is intentionally ignored as cores are not directly allocated to guests.
+// CPU "usage" is the vcpus of the guests, though cores are not directly
+// allocated to them.
 func (h *Hypervisor) calcGuestsUsage() (Resources, error) {
 	usage := Resources{}
 	err := h.ForEachGuest(func(guest *Guest) error {
 		// cache?
-		flavor, err := h.context.Flavor(guest.FlavorID)
+		used, err := guest.reservedResources()
 		if err != nil {
 			return err
 		}
-		usage.Memory += flavor.Memory
-		usage.Disk += flavor.Disk
+		usage.Memory += used.Memory
+		usage.Disk += used.Disk
+		usage.CPU += used.CPU
 		return nil
 	})
 	if err != nil {
@@ -355,7 +433,9 @@ func (h *Hypervisor) calcGuestsUsage() (Resources, error) {
 }
 
 // UpdateResources syncs Hypervisor resource usage to the data store.
-// It should only be ran on the actual hypervisor.
+// It should only be ran on the actual hypervisor. The available resources are
+// what remains of the capacity given by the overcommit ratios once the guests'
+// usage is taken. CPU usage is only taken with a CPU overcommit ratio.
 func (h *Hypervisor) UpdateResources() error {
 	if err := h.VerifyOnHV(); err != nil {
 		return err
@@ -365,11 +445,7 @@ func (h *Hypervisor) UpdateResources() error {
 	if err != nil {
 		return err
 	}
-	guestDiskDir, ok := h.Config["guestDiskDir"]
-	if !ok {
-		guestDiskDir = "/mistify/guests"
-	}
-	d, err := disk(guestDiskDir)
+	d, err := disk(h.guestDiskDir())
 	if err != nil {
 		return err
 	}
@@ -378,20 +454,56 @@ func (h *Hypervisor) UpdateResources() error {
 		return err
 	}
 
-	h.TotalResources = Resources{Memory: m, Disk: d, CPU: c}
+	if err := h.setTotalResources(Resources{Memory: m, Disk: d, CPU: c}); err != nil {
+		return err
+	}
+
+	return h.Save()
+}
+
+// guestDiskDir is the directory holding the guests' disks, as configured
+func (h *Hypervisor) guestDiskDir() string {
+	if dir, ok := h.Config["guestDiskDir"]; ok {
+		return dir
+	}
+	return "/mistify/guests"
+}
+
+// setTotalResources sets the total resources of the hypervisor, and its
+// available resources as what remains of their capacity once its guests' usage
+// is taken
+func (h *Hypervisor) setTotalResources(total Resources) error {
+	h.TotalResources = total
 
 	usage, err := h.calcGuestsUsage()
 	if err != nil {
 		return err
 	}
 
+	o, err := h.Overcommit()
+	if err != nil {
+		return err
+	}
+	if o.CPU == 0 {
+		usage.CPU = 0
+	}
+	capacity := o.Capacity(h.TotalResources)
+
 	h.AvailableResources = Resources{
-		Memory: h.TotalResources.Memory - usage.Memory,
-		Disk:   h.TotalResources.Disk - usage.Disk,
-		CPU:    h.TotalResources.CPU - usage.CPU,
+		Memory: remaining(capacity.Memory, usage.Memory),
+		Disk:   remaining(capacity.Disk, usage.Disk),
+		CPU:    uint32(remaining(uint64(capacity.CPU), uint64(usage.CPU))),
 	}
+	return nil
+}
 
-	return h.Save()
+// remaining returns what remains of capacity once usage is taken, which is
+// none if the usage exceeds it
+func remaining(capacity, usage uint64) uint64 {
+	if usage > capacity {
+		return 0
+	}
+	return capacity - usage
 }
 
 // Validate ensures a Hypervisor has reasonable data.
@@ -399,10 +511,26 @@ func (h *Hypervisor) UpdateResources() error {
 func (h *Hypervisor) Validate() error {
 	// TODO: do validation stuff...
 	if h.ID == "" {
-		return errors.New("no id")
+		return newValidationError("id", "no id")
 	}
 	if uuid.Parse(h.ID) == nil {
-		return errors.New("invalid id")
+		return newValidationError("id", "invalid id")
+	}
+	if err := validateSecretRefs(h.Secrets); err != nil {
+		return err
+	}
+	if h.BMC != nil {
+		if !bmc.Protocols[h.BMC.Protocol] {
+			return newValidationError("bmc", "missing or invalid bmc protocol")
+		}
+		if h.BMC.Address == "" {
+			return newValidationError("bmc", "missing bmc address")
+		}
+	}
+	if h.FailureDomain != nil {
+		if err := h.FailureDomain.Validate(); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -410,22 +538,7 @@ func (h *Hypervisor) Validate() error {
 // Save persists a FWGroup.
 // It will call Validate.
 func (h *Hypervisor) Save() error {
-	if err := h.Validate(); err != nil {
-		return err
-	}
-
-	v, err := json.Marshal(h)
-
-	if err != nil {
-		return err
-	}
-
-	index, err := h.context.kv.Update(h.key(), kv.Value{Data: v, Index: h.modifiedIndex})
-	if err != nil {
-		return err
-	}
-	h.modifiedIndex = index
-	return nil
+	return h.context.SaveAll(h)
 }
 
 // the many side of many:one relationships is done with nested keys
@@ -437,8 +550,22 @@ func (h *Hypervisor) subnetKey(s *Subnet) string {
 	return filepath.Join(HypervisorPath, h.ID, "subnets", key)
 }
 
-// AddSubnet adds a subnet to a Hypervisor.
+// ValidateBridge ensures a bridge name is one a subnet can be added to a
+// Hypervisor with
+func ValidateBridge(bridge string) error {
+	if !bridgeRegexp.MatchString(bridge) {
+		return newValidationError("bridge", fmt.Sprintf("invalid bridge name %q", bridge))
+	}
+	return nil
+}
+
+// AddSubnet adds a subnet to a Hypervisor, on a bridge it can carry the subnet
+// on, see CheckSubnetTopology.
 func (h *Hypervisor) AddSubnet(s *Subnet, bridge string) error {
+	if err := ValidateBridge(bridge); err != nil {
+		return err
+	}
+
 	// Make sure the hypervisor exists
 	if h.modifiedIndex == 0 {
 		if err := h.Refresh(); err != nil {
@@ -453,6 +580,10 @@ func (h *Hypervisor) AddSubnet(s *Subnet, bridge string) error {
 		}
 	}
 
+	if err := h.CheckSubnetTopology(s, bridge); err != nil {
+		return err
+	}
+
 	err := h.context.kv.Set(filepath.Join(h.subnetKey(s)), bridge)
 	if err == nil {
 		h.subnets[s.ID] = bridge
@@ -474,6 +605,32 @@ func (h *Hypervisor) Subnets() map[string]string {
 	return h.subnets
 }
 
+// SubnetsIndex returns the highest modification index of the subnet/bridge
+// mappings of a Hypervisor, 0 if there are none
+func (h *Hypervisor) SubnetsIndex() (uint64, error) {
+	return h.maxIndex("subnets")
+}
+
+// maxIndex returns the highest modification index of the keys under a
+// directory of a Hypervisor, 0 if there are none
+func (h *Hypervisor) maxIndex(dir string) (uint64, error) {
+	nodes, err := h.context.kv.GetAll(filepath.Join(HypervisorPath, h.ID, dir))
+	if err != nil {
+		if h.context.kv.IsKeyNotFound(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	var index uint64
+	for _, v := range nodes {
+		if v.Index > index {
+			index = v.Index
+		}
+	}
+	return index, nil
+}
+
 // heartbeatKey is a helper for generating a key for config store.
 func (h *Hypervisor) heartbeatKey() string {
 	return filepath.Join(HypervisorPath, h.ID, "heartbeat")
@@ -495,12 +652,76 @@ func (h *Hypervisor) Heartbeat(ttl time.Duration) error {
 		h.heart = ekey
 	}
 
-	if err := h.heart.Set(time.Now().String()); err != nil {
+	now := time.Now()
+	if err := h.heart.Set(now.String()); err != nil {
 		return err
 	}
 
 	h.alive = true
-	return nil
+	return h.recordHeartbeat(now, ttl)
+}
+
+// healthKey is a helper for generating a key for config store.
+func (h *Hypervisor) healthKey() string {
+	return filepath.Join(HypervisorPath, h.ID, "health")
+}
+
+// recordHeartbeat adds a heartbeat to the history, dropping the oldest beyond
+// HeartbeatHistorySize.
+func (h *Hypervisor) recordHeartbeat(t time.Time, ttl time.Duration) error {
+	if h.history == nil {
+		h.history = &heartbeatHistory{}
+	}
+	h.history.TTL = ttl
+	h.history.Beats = append(h.history.Beats, t)
+	if extra := len(h.history.Beats) - HeartbeatHistorySize; extra > 0 {
+		h.history.Beats = append([]time.Time(nil), h.history.Beats[extra:]...)
+	}
+
+	v, err := json.Marshal(h.history)
+	if err != nil {
+		return err
+	}
+	return h.context.kv.Set(h.healthKey(), string(v))
+}
+
+// Health scores the availability of a Hypervisor from its heartbeat history.
+// Any gap between heartbeats longer than the heartbeat ttl counts as down
+// time and as a flap.
+func (h *Hypervisor) Health() *HypervisorHealth {
+	health := &HypervisorHealth{Alive: h.alive}
+	if h.history == nil || len(h.history.Beats) == 0 {
+		if h.alive {
+			health.Availability, health.Score = 1, 1
+		}
+		return health
+	}
+
+	beats, ttl := h.history.Beats, h.history.TTL
+	health.Beats = len(beats)
+	health.LastBeat = beats[len(beats)-1]
+
+	now := time.Now()
+	var down time.Duration
+	for i := 1; i < len(beats); i++ {
+		if gap := beats[i].Sub(beats[i-1]); gap > ttl {
+			down += gap - ttl
+			health.Flaps++
+		}
+	}
+	if gap := now.Sub(health.LastBeat); gap > ttl {
+		down += gap - ttl
+	}
+
+	health.Availability = 1
+	if span := now.Sub(beats[0]); span > 0 {
+		health.Availability = 1 - down.Seconds()/span.Seconds()
+	}
+	if health.Availability < 0 {
+		health.Availability = 0
+	}
+	health.Score = health.Availability / float64(1+health.Flaps)
+	return health
 }
 
 // IsAlive returns true if the heartbeat is present.
@@ -520,6 +741,8 @@ func (h *Hypervisor) guestKey(g *Guest) string {
 // AddGuest adds a Guest to the Hypervisor.
 // It reserves an IPaddress for the Guest.
 // It also updates the Guest.
+// The address, the hypervisor's guest entry, and the guest are saved together
+// by SaveAll, see it for KVs that are not transactional.
 func (h *Hypervisor) AddGuest(g *Guest) error {
 
 	// make sure we have subnet guest wants.  we should have this figured out
@@ -555,33 +778,35 @@ LOOP:
 		return errors.New("no suitable subnet found")
 	}
 
-	ip, err := s.ReserveAddress(g.ID)
-
-	if err != nil {
-		return err
-	}
-
-	// an instance where transactions would be cool...
-	g.HypervisorID = h.ID
-	g.IP = ip
-	g.SubnetID = s.ID
-	g.Bridge = bridge
-
-	err = h.context.kv.Set(filepath.Join(h.guestKey(g)), g.ID)
-
-	if err != nil {
-		return err
-	}
+	prevHypervisor, prevIP, prevSubnet, prevBridge := g.HypervisorID, g.IP, g.SubnetID, g.Bridge
+	for _, ip := range randomizeAddresses(s.AvailableAddresses()) {
+		g.HypervisorID = h.ID
+		g.IP = ip
+		g.SubnetID = s.ID
+		g.Bridge = bridge
+
+		err := h.context.SaveAll(
+			addressReservation{subnet: s, ip: ip, guestID: g.ID},
+			hypervisorGuest{hypervisor: h, guest: g},
+			g,
+		)
+		if err == nil {
+			return nil
+		}
 
-	err = g.Save()
+		reserved, getErr := h.context.kv.Get(s.addressKey(ip.String()))
+		if getErr == nil && string(reserved.Data) == g.ID {
+			// saved, only updating what is derived from the guest failed
+			return err
+		}
+		g.HypervisorID, g.IP, g.SubnetID, g.Bridge = prevHypervisor, prevIP, prevSubnet, prevBridge
 
-	if err != nil {
-		return err
+		// try another address if this one was taken meanwhile
+		if !lerrors.IsConflict(err) || getErr != nil {
+			return err
+		}
 	}
-
-	h.guests = append(h.guests, g.ID)
-
-	return nil
+	return errors.New("no available addresses")
 }
 
 // RemoveGuest removes a guest from the hypervisor.
@@ -608,6 +833,7 @@ func (h *Hypervisor) RemoveGuest(g *Guest) error {
 	g.SubnetID = ""
 	g.Bridge = ""
 
+	// saving the guest bumps the generation of the desired state
 	if err := g.Save(); err != nil {
 		return err
 	}
@@ -686,14 +912,37 @@ func (c *Context) ForEachHypervisor(f func(*Hypervisor) error) error {
 	return nil
 }
 
+// ValidateHypervisorConfig ensures a Hypervisor Config key is a single, plain
+// key, and its value is valid if it is one of the keys lochness itself uses,
+// e.g. CPUOvercommitConfig. Empty values, which unset the key, are valid.
+func ValidateHypervisorConfig(key, value string) error {
+	if !configKeyRegexp.MatchString(key) {
+		return newValidationError(key, fmt.Sprintf("invalid config key %q", key))
+	}
+	if value == "" {
+		return nil
+	}
+	return validateConfig(key, value)
+}
+
+// ConfigIndex returns the highest modification index of the Config of a
+// Hypervisor, 0 if there is none
+func (h *Hypervisor) ConfigIndex() (uint64, error) {
+	return h.maxIndex("config")
+}
+
 // SetConfig sets a single Hypervisor Config value.
-// Set value to "" to unset.
+// Set value to "" to unset. Values of the keys lochness itself uses, e.g.
+// CPUOvercommitConfig, are validated.
 func (h *Hypervisor) SetConfig(key, value string) error {
 	if key == "" {
 		return errors.New("empty config key")
 	}
 
 	if value != "" {
+		if err := validateConfig(key, value); err != nil {
+			return err
+		}
 		if err := h.context.kv.Set(filepath.Join(HypervisorPath, h.ID, "config", key), value); err != nil {
 			return err
 		}
@@ -713,16 +962,20 @@ func (h *Hypervisor) SetConfig(key, value string) error {
 // The Hypervisor must not have any guests.
 func (h *Hypervisor) Destroy() error {
 	if len(h.guests) != 0 {
-		// XXX: should use an error var?
-		return errors.New("not empty")
+		return lerrors.Conflict(errors.New("not empty"))
 	}
 
 	if h.modifiedIndex == 0 {
 		// it has not been saved?
-		return errors.New("not persisted")
+		return lerrors.NotFound(errors.New("not persisted"))
 	}
 
-	if err := h.context.kv.Remove(h.key(), h.modifiedIndex); err != nil {
+	ops, err := h.context.metadataOps(MetadataKindHypervisors, h.ID, h.indexedMetadata, nil)
+	if err != nil {
+		return err
+	}
+	ops = append([]kv.Op{{Key: h.key(), Value: kv.Value{Index: h.modifiedIndex}, Remove: true}}, ops...)
+	if _, err := kv.Txn(h.context.kv, ops); err != nil {
 		return err
 	}
 
@@ -4,11 +4,13 @@ import (
 	"encoding/json"
 	"errors"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/mistifyio/lochness"
 	"github.com/mistifyio/lochness/internal/tests/common"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
 	"github.com/pborman/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
@@ -251,6 +253,33 @@ func (s *HypervisorSuite) TestAddSubnet() {
 	}
 }
 
+func (s *HypervisorSuite) TestValidateBridge() {
+	for _, bridge := range []string{"mistify0", "br0", "br-int.100", "abcdefghijklmno"} {
+		s.NoError(lochness.ValidateBridge(bridge), bridge)
+	}
+	for _, bridge := range []string{"", "br 0", "br/0", "-br0", "abcdefghijklmnop"} {
+		s.True(lerrors.IsValidation(lochness.ValidateBridge(bridge)), bridge)
+	}
+	s.Error(s.NewHypervisor().AddSubnet(s.NewSubnet(), "br/0"), "invalid bridges should not be added")
+}
+
+func (s *HypervisorSuite) TestSubnetsIndex() {
+	hypervisor := s.NewHypervisor()
+	index, err := hypervisor.SubnetsIndex()
+	s.NoError(err)
+	s.Zero(index, "hypervisors without subnets should have no index")
+
+	s.Require().NoError(hypervisor.AddSubnet(s.NewSubnet(), "mistify0"))
+	first, err := hypervisor.SubnetsIndex()
+	s.NoError(err)
+	s.NotZero(first)
+
+	s.Require().NoError(hypervisor.AddSubnet(s.NewSubnet(), "mistify0"))
+	second, err := hypervisor.SubnetsIndex()
+	s.NoError(err)
+	s.True(second > first, "index should increase with changes")
+}
+
 func (s *HypervisorSuite) TestRemoveSubnet() {
 	subnet := s.NewSubnet()
 	hypervisor := s.NewHypervisor()
@@ -292,6 +321,64 @@ func (s *HypervisorSuite) TestHeartbeatAndIsAlive() {
 	s.True(hypervisor.IsAlive())
 }
 
+func (s *HypervisorSuite) TestHeartbeatHistory() {
+	defer func(size int) { lochness.HeartbeatHistorySize = size }(lochness.HeartbeatHistorySize)
+	lochness.HeartbeatHistorySize = 3
+
+	hypervisor := s.NewHypervisor()
+	_, _ = lochness.SetHypervisorID(hypervisor.ID)
+	for i := 0; i < 5; i++ {
+		s.Require().NoError(hypervisor.Heartbeat(60 * time.Second))
+	}
+	s.Equal(3, hypervisor.Health().Beats)
+
+	// history survives a refresh
+	h, err := s.Context.Hypervisor(hypervisor.ID)
+	s.Require().NoError(err)
+	health := h.Health()
+	s.True(health.Alive)
+	s.Equal(3, health.Beats)
+	s.Equal(0, health.Flaps)
+	s.Equal(1.0, health.Score)
+}
+
+func (s *HypervisorSuite) TestHealth() {
+	now := time.Now()
+	ago := func(minutes int) time.Time {
+		return now.Add(-time.Duration(minutes) * time.Minute)
+	}
+
+	tests := []struct {
+		description  string
+		beats        []time.Time
+		flaps        int
+		availability float64
+	}{
+		{"steady", []time.Time{ago(3), ago(2), ago(1), now}, 0, 1},
+		{"one outage", []time.Time{ago(10), ago(9), ago(4), ago(3), ago(2), ago(1), now}, 1, 0.6},
+		{"flapping", []time.Time{ago(10), ago(8), ago(6), ago(4), ago(2), now}, 5, 0.5},
+		{"dead", []time.Time{ago(12), ago(11)}, 0, 1.0 / 6},
+	}
+
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		hypervisor := s.NewHypervisor()
+		history, _ := json.Marshal(map[string]interface{}{
+			"ttl":   time.Minute,
+			"beats": test.beats,
+		})
+		s.Require().NoError(s.KV.Set(filepath.Join(lochness.HypervisorPath, hypervisor.ID, "health"), string(history)))
+		s.Require().NoError(hypervisor.Refresh())
+
+		health := hypervisor.Health()
+		s.Equal(len(test.beats), health.Beats, msg("beats"))
+		s.True(test.beats[len(test.beats)-1].Equal(health.LastBeat), msg("last beat"))
+		s.Equal(test.flaps, health.Flaps, msg("flaps"))
+		s.InDelta(test.availability, health.Availability, 0.01, msg("availability"))
+		s.InDelta(test.availability/float64(1+test.flaps), health.Score, 0.01, msg("score"))
+	}
+}
+
 func (s *HypervisorSuite) TestAddGuest() {
 	guest := s.NewGuest()
 	hypervisor := s.NewHypervisor()
@@ -436,6 +523,32 @@ func (s *HypervisorSuite) TestSetConfig() {
 	}
 }
 
+func (s *HypervisorSuite) TestValidateHypervisorConfig() {
+	tests := []struct {
+		description string
+		key         string
+		value       string
+		expectedErr bool
+	}{
+		{"empty key", "", "bar", true},
+		{"nested key", "foobar/baz", "bang", true},
+		{"key with spaces", "foo bar", "bang", true},
+		{"empty value", "bar", "", false},
+		{"key and value", "dhcp.interface-name_2", "bar", false},
+		{"invalid lochness value", lochness.CPUOvercommitConfig, "lots", true},
+		{"unset lochness value", lochness.CPUOvercommitConfig, "", false},
+	}
+
+	for _, test := range tests {
+		err := lochness.ValidateHypervisorConfig(test.key, test.value)
+		if test.expectedErr {
+			s.True(lerrors.IsValidation(err), test.description)
+		} else {
+			s.NoError(err, test.description)
+		}
+	}
+}
+
 func (s *HypervisorSuite) TestDestroy() {
 	blank := s.Context.NewHypervisor()
 	blank.ID = ""
@@ -461,4 +574,6 @@ func (s *HypervisorSuite) TestDestroy() {
 			s.NoError(err, msg("should succeed"))
 		}
 	}
+
+	s.True(lerrors.IsConflict(hypervisorWithGuest.Destroy()), "hypervisors with guests should conflict")
 }
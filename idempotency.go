@@ -0,0 +1,276 @@
+package lochness
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/mistifyio/lochness/pkg/kv"
+)
+
+var (
+	// IdempotencyKeyPath is the path in the config store
+	IdempotencyKeyPath = "lochness/idempotency-keys/"
+
+	// IdempotencyWindowConfig is the config key of the cluster of how long
+	// the response to a request with an idempotency key is replayed to
+	// retries of it, a duration such as "24h"
+	IdempotencyWindowConfig = "idempotency-window"
+
+	// DefaultIdempotencyWindow is how long responses are replayed unless
+	// IdempotencyWindowConfig sets otherwise
+	DefaultIdempotencyWindow = 24 * time.Hour
+
+	// IdempotencyClaimTTL is how long a request holds its idempotency key
+	// unless renewed before it is done, so that the key of a request whose
+	// server stopped can be retried
+	IdempotencyClaimTTL = time.Minute
+
+	// MaxIdempotencyKeyLength is the longest idempotency key accepted
+	MaxIdempotencyKeyLength = 255
+
+	// ErrIdempotencyKeyInUse is returned when claiming the idempotency key of
+	// a request still in progress
+	ErrIdempotencyKeyInUse = lerrors.Conflict(errors.New("a request with the idempotency key is in progress"))
+
+	// ErrIdempotencyKeyReused is returned when claiming an idempotency key
+	// that was used for a different request
+	ErrIdempotencyKeyReused = lerrors.Conflict(errors.New("the idempotency key was used for a different request"))
+)
+
+// IdempotentResponse is the response to a request with an idempotency key,
+// which retries of the request get instead of repeating it, until it expires.
+// Request is the fingerprint of the request, see RequestFingerprint. Status
+// is 0 while the request is in progress.
+type IdempotentResponse struct {
+	context       *Context
+	modifiedIndex uint64
+	Key           string            `json:"key"`
+	Request       string            `json:"request"`
+	Status        int               `json:"status,omitempty"`
+	Header        map[string]string `json:"header,omitempty"`
+	Body          []byte            `json:"body,omitempty"`
+	Expires       time.Time         `json:"expires"`
+}
+
+// RequestFingerprint returns a fingerprint of a request, so that an
+// idempotency key reused for a different one can be told apart from a retry
+func RequestFingerprint(method, path, query string, body []byte) string {
+	sum := sha256.New()
+	for _, part := range []string{method, path, query} {
+		_, _ = fmt.Fprintf(sum, "%d:%s\n", len(part), part)
+	}
+	_, _ = sum.Write(body)
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+// ParseIdempotencyWindow parses the window of IdempotencyWindowConfig, which
+// must be a positive duration
+func ParseIdempotencyWindow(value string) (time.Duration, error) {
+	window, err := time.ParseDuration(value)
+	if err != nil || window <= 0 {
+		return 0, newValidationError(IdempotencyWindowConfig, fmt.Sprintf("invalid %s %q: must be a positive duration", IdempotencyWindowConfig, value))
+	}
+	return window, nil
+}
+
+// IdempotencyWindow returns how long responses to requests with an idempotency
+// key are replayed, DefaultIdempotencyWindow unless configured for the cluster
+func (c *Context) IdempotencyWindow() (time.Duration, error) {
+	value, err := c.GetConfig(IdempotencyWindowConfig)
+	if err != nil {
+		if c.IsKeyNotFound(err) {
+			return DefaultIdempotencyWindow, nil
+		}
+		return 0, err
+	}
+	return ParseIdempotencyWindow(value)
+}
+
+// ClaimIdempotencyKey claims an idempotency key for a request, given by its
+// fingerprint. A new claim returns an IdempotentResponse in progress, to
+// Complete or Release once the request is done. A key whose request is done
+// returns its response to replay. ErrIdempotencyKeyInUse is returned if its
+// request is still in progress, and ErrIdempotencyKeyReused if the key is
+// for a different request. An expired key is claimed anew, while the others
+// are left to RemoveExpiredIdempotencyKeys.
+func (c *Context) ClaimIdempotencyKey(key, request string) (*IdempotentResponse, error) {
+	if err := checkIdempotencyKey(key); err != nil {
+		return nil, err
+	}
+	r, err := c.claimIdempotencyKey(key, request)
+	if err != errIdempotencyKeyExpired {
+		return r, err
+	}
+	// Removed as expired, so it can be claimed, unless a retry just did
+	r, err = c.claimIdempotencyKey(key, request)
+	if err == errIdempotencyKeyExpired {
+		return nil, ErrIdempotencyKeyInUse
+	}
+	return r, err
+}
+
+// errIdempotencyKeyExpired is returned by claimIdempotencyKey once it removed
+// the expired IdempotentResponse of the key
+var errIdempotencyKeyExpired = errors.New("idempotency key expired")
+
+// claimIdempotencyKey makes an attempt at ClaimIdempotencyKey
+func (c *Context) claimIdempotencyKey(key, request string) (*IdempotentResponse, error) {
+	r := &IdempotentResponse{
+		context: c,
+		Key:     key,
+		Request: request,
+		Expires: time.Now().Add(IdempotencyClaimTTL),
+	}
+	v, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	index, err := c.kv.Update(r.key(), kv.Value{Data: v})
+	if err == nil {
+		r.modifiedIndex = index
+		return r, nil
+	}
+	if !lerrors.IsConflict(err) {
+		return nil, err
+	}
+
+	// The key was claimed before
+	resp, err := c.kv.Get(r.key())
+	if err != nil {
+		if c.kv.IsKeyNotFound(err) {
+			// Released or expired since, the retry may claim it
+			return nil, ErrIdempotencyKeyInUse
+		}
+		return nil, err
+	}
+	claimed := &IdempotentResponse{context: c}
+	if err := claimed.fromResponse(resp); err != nil {
+		return nil, err
+	}
+	switch {
+	case time.Now().After(claimed.Expires):
+		err := c.kv.Remove(r.key(), claimed.modifiedIndex)
+		if err != nil && !c.kv.IsKeyNotFound(err) && !lerrors.IsConflict(err) {
+			return nil, err
+		}
+		return nil, errIdempotencyKeyExpired
+	case claimed.Request != request:
+		return nil, ErrIdempotencyKeyReused
+	case claimed.Status == 0:
+		return nil, ErrIdempotencyKeyInUse
+	}
+	return claimed, nil
+}
+
+// Done returns whether the request of the IdempotentResponse is done, so that
+// the response is to be replayed
+func (r *IdempotentResponse) Done() bool {
+	return r.Status != 0
+}
+
+// Renew extends the claim of an idempotency key by IdempotencyClaimTTL, for a
+// request in progress that takes longer than that
+func (r *IdempotentResponse) Renew() error {
+	r.Expires = time.Now().Add(IdempotencyClaimTTL)
+	v, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	index, err := r.context.kv.Update(r.key(), kv.Value{Data: v, Index: r.modifiedIndex})
+	if err != nil {
+		return err
+	}
+	r.modifiedIndex = index
+	return nil
+}
+
+// Complete saves the response to the request of a claimed idempotency key, to
+// be replayed to retries until the cluster's IdempotencyWindow passes
+func (r *IdempotentResponse) Complete(status int, header map[string]string, body []byte) error {
+	window, err := r.context.IdempotencyWindow()
+	if err != nil {
+		return err
+	}
+	r.Status = status
+	r.Header = header
+	r.Body = body
+	r.Expires = time.Now().Add(window)
+
+	v, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	index, err := r.context.kv.Update(r.key(), kv.Value{Data: v, Index: r.modifiedIndex})
+	if err != nil {
+		return err
+	}
+	r.modifiedIndex = index
+	return nil
+}
+
+// Release gives up the claim of an idempotency key without a response, so that
+// the request may be retried with it, e.g. after a failure of the server
+func (r *IdempotentResponse) Release() error {
+	err := r.context.kv.Remove(r.key(), r.modifiedIndex)
+	if err != nil && r.context.kv.IsKeyNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// key is a helper to generate the config store key. Keys are hashed, as they
+// are chosen by clients.
+func (r *IdempotentResponse) key() string {
+	sum := sha256.Sum256([]byte(r.Key))
+	return filepath.Join(IdempotencyKeyPath, hex.EncodeToString(sum[:]))
+}
+
+// fromResponse is a helper to unmarshal an IdempotentResponse
+func (r *IdempotentResponse) fromResponse(value kv.Value) error {
+	r.modifiedIndex = value.Index
+	return json.Unmarshal(value.Data, &r)
+}
+
+// checkIdempotencyKey checks that an idempotency key is set and not too long
+func checkIdempotencyKey(key string) error {
+	if key == "" || len(key) > MaxIdempotencyKeyLength {
+		return newValidationError("idempotency_key", fmt.Sprintf("invalid idempotency key: must be 1 to %d characters", MaxIdempotencyKeyLength))
+	}
+	return nil
+}
+
+// RemoveExpiredIdempotencyKeys removes the IdempotentResponses that expired by
+// now, whether done or abandoned, returning how many were removed. Claims that
+// are renewed while their requests are in progress do not expire.
+func (c *Context) RemoveExpiredIdempotencyKeys(now time.Time) (int, error) {
+	values, err := c.kv.GetAll(IdempotencyKeyPath)
+	if err != nil {
+		if c.kv.IsKeyNotFound(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	removed := 0
+	for key, value := range values {
+		r := &IdempotentResponse{}
+		if err := r.fromResponse(value); err == nil && !now.After(r.Expires) {
+			continue
+		}
+		// Left alone if it was just claimed or renewed
+		if err := c.kv.Remove(key, value.Index); err != nil {
+			if c.kv.IsKeyNotFound(err) || lerrors.IsConflict(err) {
+				continue
+			}
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
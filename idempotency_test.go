@@ -0,0 +1,141 @@
+package lochness_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestIdempotency(t *testing.T) {
+	suite.Run(t, new(IdempotencySuite))
+}
+
+type IdempotencySuite struct {
+	common.Suite
+}
+
+func (s *IdempotencySuite) TearDownTest() {
+	lochness.IdempotencyClaimTTL = time.Minute
+	s.Suite.TearDownTest()
+}
+
+func (s *IdempotencySuite) TestRequestFingerprint() {
+	fingerprint := lochness.RequestFingerprint("POST", "/guests", "", []byte("{}"))
+	s.Equal(fingerprint, lochness.RequestFingerprint("POST", "/guests", "", []byte("{}")))
+	s.NotEqual(fingerprint, lochness.RequestFingerprint("POST", "/guests/batch", "", []byte("{}")))
+	s.NotEqual(fingerprint, lochness.RequestFingerprint("POST", "/guests", "mac_oui=52:54:00", []byte("{}")))
+	s.NotEqual(fingerprint, lochness.RequestFingerprint("POST", "/guests", "", []byte("[]")))
+}
+
+func (s *IdempotencySuite) TestIdempotencyWindow() {
+	window, err := s.Context.IdempotencyWindow()
+	s.NoError(err)
+	s.Equal(lochness.DefaultIdempotencyWindow, window)
+
+	s.Require().NoError(s.Context.SetConfig(lochness.IdempotencyWindowConfig, "1h"))
+	window, err = s.Context.IdempotencyWindow()
+	s.NoError(err)
+	s.Equal(time.Hour, window)
+
+	for _, value := range []string{"0", "-1h", "soon"} {
+		err := s.Context.SetConfig(lochness.IdempotencyWindowConfig, value)
+		s.True(lerrors.IsValidation(err), value+" should be invalid")
+	}
+}
+
+func (s *IdempotencySuite) TestClaimIdempotencyKey() {
+	request := lochness.RequestFingerprint("POST", "/guests", "", []byte("{}"))
+
+	claim, err := s.Context.ClaimIdempotencyKey("create-web-1", request)
+	s.Require().NoError(err)
+	s.False(claim.Done(), "a new claim should be in progress")
+
+	_, err = s.Context.ClaimIdempotencyKey("create-web-1", request)
+	s.Equal(lochness.ErrIdempotencyKeyInUse, err)
+
+	header := map[string]string{"X-Guest-Job-ID": "job"}
+	s.Require().NoError(claim.Complete(http.StatusAccepted, header, []byte(`{"id":"guest"}`)))
+
+	replay, err := s.Context.ClaimIdempotencyKey("create-web-1", request)
+	s.Require().NoError(err)
+	s.True(replay.Done())
+	s.Equal(http.StatusAccepted, replay.Status)
+	s.Equal(header, replay.Header)
+	s.Equal(`{"id":"guest"}`, string(replay.Body))
+
+	other := lochness.RequestFingerprint("POST", "/guests", "", []byte(`{"flavor":"small"}`))
+	_, err = s.Context.ClaimIdempotencyKey("create-web-1", other)
+	s.Equal(lochness.ErrIdempotencyKeyReused, err)
+	s.True(lerrors.IsConflict(err))
+
+	for _, key := range []string{"", strings.Repeat("k", lochness.MaxIdempotencyKeyLength+1)} {
+		_, err = s.Context.ClaimIdempotencyKey(key, request)
+		s.True(lerrors.IsValidation(err))
+	}
+}
+
+func (s *IdempotencySuite) TestRelease() {
+	request := lochness.RequestFingerprint("POST", "/guests", "", nil)
+	claim, err := s.Context.ClaimIdempotencyKey("create-web-1", request)
+	s.Require().NoError(err)
+	s.Require().NoError(claim.Release())
+
+	claim, err = s.Context.ClaimIdempotencyKey("create-web-1", request)
+	s.Require().NoError(err, "a released key should be claimed again")
+	s.False(claim.Done())
+}
+
+func (s *IdempotencySuite) TestExpiredClaim() {
+	lochness.IdempotencyClaimTTL = -time.Second
+	request := lochness.RequestFingerprint("POST", "/guests", "", nil)
+	_, err := s.Context.ClaimIdempotencyKey("create-web-1", request)
+	s.Require().NoError(err)
+
+	claim, err := s.Context.ClaimIdempotencyKey("create-web-1", request)
+	s.Require().NoError(err, "an abandoned claim should expire")
+	s.False(claim.Done())
+}
+
+func (s *IdempotencySuite) TestRenew() {
+	lochness.IdempotencyClaimTTL = -time.Second
+	request := lochness.RequestFingerprint("POST", "/guests", "", nil)
+	claim, err := s.Context.ClaimIdempotencyKey("create-web-1", request)
+	s.Require().NoError(err)
+
+	lochness.IdempotencyClaimTTL = time.Minute
+	s.Require().NoError(claim.Renew())
+	_, err = s.Context.ClaimIdempotencyKey("create-web-1", request)
+	s.Equal(lochness.ErrIdempotencyKeyInUse, err, "a renewed claim should not expire")
+	removed, err := s.Context.RemoveExpiredIdempotencyKeys(time.Now())
+	s.NoError(err)
+	s.Equal(0, removed)
+
+	s.NoError(claim.Complete(http.StatusCreated, nil, nil), "a renewed claim should be completed")
+}
+
+func (s *IdempotencySuite) TestRemoveExpiredIdempotencyKeys() {
+	request := lochness.RequestFingerprint("POST", "/guests", "", nil)
+	claim, err := s.Context.ClaimIdempotencyKey("create-web-1", request)
+	s.Require().NoError(err)
+	s.Require().NoError(claim.Complete(http.StatusCreated, nil, nil))
+	_, err = s.Context.ClaimIdempotencyKey("create-web-2", request)
+	s.Require().NoError(err)
+
+	removed, err := s.Context.RemoveExpiredIdempotencyKeys(time.Now())
+	s.NoError(err)
+	s.Equal(0, removed)
+
+	removed, err = s.Context.RemoveExpiredIdempotencyKeys(time.Now().Add(lochness.DefaultIdempotencyWindow + time.Minute))
+	s.NoError(err)
+	s.Equal(2, removed, "done and abandoned keys should expire")
+
+	claim, err = s.Context.ClaimIdempotencyKey("create-web-1", request)
+	s.NoError(err)
+	s.False(claim.Done())
+}
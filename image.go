@@ -0,0 +1,311 @@
+package lochness
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/mistifyio/lochness/pkg/kv"
+	"github.com/pborman/uuid"
+)
+
+var (
+	// ImagePath is the path in the config store for images
+	ImagePath = "lochness/images/"
+)
+
+// Image fetch states
+const (
+	ImageFetching    = "fetching"
+	ImageFetched     = "fetched"
+	ImageFetchFailed = "failed"
+)
+
+type (
+	// Image is a disk image in the catalog, which guests can be created from.
+	// Hypervisors fetch it from its source, and verify it against its
+	// checksum, before creating a guest from it.
+	Image struct {
+		context       *Context
+		modifiedIndex uint64
+		ID            string            `json:"id"`
+		Name          string            `json:"name"`
+		Source        string            `json:"source"`     // http(s) url the image is fetched from
+		Checksum      string            `json:"checksum"`   // sha256:<hex> of the image
+		MinDisk       uint64            `json:"min_disk"`   // disk in MB a guest needs
+		MinMemory     uint64            `json:"min_memory"` // memory in MB a guest needs
+		Metadata      map[string]string `json:"metadata"`
+	}
+
+	// Images is an alias to a slice of *Image
+	Images []*Image
+
+	// ImageFetch is the state of an image on a hypervisor, cached so that it
+	// is only fetched again when its checksum changes
+	ImageFetch struct {
+		HypervisorID string    `json:"hypervisor"`
+		State        string    `json:"state"`
+		Checksum     string    `json:"checksum"` // checksum of the image fetched
+		Error        string    `json:"error,omitempty"`
+		UpdatedAt    time.Time `json:"updated_at"`
+	}
+)
+
+// NewImage creates a blank Image
+func (c *Context) NewImage() *Image {
+	return &Image{
+		context:  c,
+		ID:       uuid.New(),
+		Metadata: make(map[string]string),
+	}
+}
+
+// Image fetches a single Image from the config store
+func (c *Context) Image(id string) (*Image, error) {
+	var err error
+	id, err = canonicalizeUUID(id)
+	if err != nil {
+		return nil, err
+	}
+	i := &Image{
+		context: c,
+		ID:      id,
+	}
+
+	if err := i.Refresh(); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// ForEachImage will run f on each Image. It will stop iteration if f returns
+// an error.
+func (c *Context) ForEachImage(f func(*Image) error) error {
+	keys, err := c.kv.Keys(ImagePath)
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		image, err := c.Image(filepath.Base(k))
+		if err != nil {
+			return err
+		}
+
+		if err := f(image); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// key is a helper to generate the config store key
+func (i *Image) key() string {
+	return filepath.Join(ImagePath, i.ID, "metadata")
+}
+
+// fetchKey is a helper to generate the config store key of the image's fetch
+// state on a hypervisor
+func (i *Image) fetchKey(hypervisorID string) string {
+	return filepath.Join(ImagePath, i.ID, "hypervisors", hypervisorID)
+}
+
+// Refresh reloads from the data store
+func (i *Image) Refresh() error {
+	resp, err := i.context.kv.Get(i.key())
+	if err != nil {
+		return err
+	}
+
+	i.modifiedIndex = resp.Index
+	return json.Unmarshal(resp.Data, &i)
+}
+
+// ParseChecksum parses an image checksum of the form "sha256:<hex>"
+func ParseChecksum(checksum string) ([]byte, error) {
+	parts := strings.SplitN(checksum, ":", 2)
+	if len(parts) != 2 || parts[0] != "sha256" {
+		return nil, newValidationError("checksum", fmt.Sprintf("invalid checksum %q: must be sha256:<hex>", checksum))
+	}
+	sum, err := hex.DecodeString(parts[1])
+	if err != nil || len(sum) != 32 {
+		return nil, newValidationError("checksum", fmt.Sprintf("invalid checksum %q: must be 64 hex digits", checksum))
+	}
+	return sum, nil
+}
+
+// Validate ensures an Image has reasonable data
+func (i *Image) Validate() error {
+	if _, err := canonicalizeUUID(i.ID); err != nil {
+		return newValidationError("id", "missing or invalid id")
+	}
+	if i.Name == "" {
+		return newValidationError("name", "image name required")
+	}
+	source, err := url.Parse(i.Source)
+	if err != nil || (source.Scheme != "http" && source.Scheme != "https") || source.Host == "" {
+		return newValidationError("source", "image source must be an http or https url")
+	}
+	if _, err := ParseChecksum(i.Checksum); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Save persists an Image.
+// It will call Validate.
+func (i *Image) Save() error {
+	if err := i.Validate(); err != nil {
+		return err
+	}
+
+	v, err := json.Marshal(i)
+	if err != nil {
+		return err
+	}
+
+	index, err := i.context.kv.Update(i.key(), kv.Value{Data: v, Index: i.modifiedIndex})
+	if err != nil {
+		return err
+	}
+	i.modifiedIndex = index
+	return nil
+}
+
+// Destroy removes an Image, along with its fetch states. Images that guests
+// were created from can not be removed.
+func (i *Image) Destroy() error {
+	if i.ID == "" {
+		return errors.New("missing id")
+	}
+
+	var guests []string
+	err := i.context.ForEachGuest(func(g *Guest) error {
+		if g.ImageID == i.ID {
+			guests = append(guests, g.ID)
+		}
+		return nil
+	})
+	if err != nil && !i.context.IsKeyNotFound(err) {
+		return err
+	}
+	if len(guests) > 0 {
+		return lerrors.Conflictf("image is used by guests %s", strings.Join(guests, ", "))
+	}
+
+	return i.context.kv.Delete(filepath.Join(ImagePath, i.ID), true)
+}
+
+// CheckFlavor returns a validation error if a guest of the flavor does not
+// have the disk or memory the image needs
+func (i *Image) CheckFlavor(f *Flavor) error {
+	if f.Disk < i.MinDisk {
+		return newValidationError("flavor", fmt.Sprintf("image %s needs %d MB disk, flavor %s has %d", i.ID, i.MinDisk, f.ID, f.Disk))
+	}
+	if f.Memory < i.MinMemory {
+		return newValidationError("flavor", fmt.Sprintf("image %s needs %d MB memory, flavor %s has %d", i.ID, i.MinMemory, f.ID, f.Memory))
+	}
+	return nil
+}
+
+// CheckGuestImage returns a validation error if the guest's catalog image does
+// not exist, or needs more disk or memory than the guest's flavor has
+func (c *Context) CheckGuestImage(g *Guest) error {
+	if g.ImageID == "" {
+		return nil
+	}
+	if _, err := canonicalizeUUID(g.ImageID); err != nil {
+		return newValidationError("image", "invalid image")
+	}
+	if _, err := canonicalizeUUID(g.FlavorID); err != nil {
+		return newValidationError("flavor", "missing or invalid flavor")
+	}
+
+	image, err := c.Image(g.ImageID)
+	if err != nil {
+		if c.IsKeyNotFound(err) {
+			return newValidationError("image", fmt.Sprintf("image %s not found", g.ImageID))
+		}
+		return err
+	}
+	flavor, err := c.Flavor(g.FlavorID)
+	if err != nil {
+		if c.IsKeyNotFound(err) {
+			return newValidationError("flavor", fmt.Sprintf("flavor %s not found", g.FlavorID))
+		}
+		return err
+	}
+	return image.CheckFlavor(flavor)
+}
+
+// FetchState returns the fetch state of the image on a hypervisor
+func (i *Image) FetchState(hypervisorID string) (*ImageFetch, error) {
+	resp, err := i.context.kv.Get(i.fetchKey(hypervisorID))
+	if err != nil {
+		return nil, err
+	}
+
+	fetch := &ImageFetch{}
+	if err := json.Unmarshal(resp.Data, fetch); err != nil {
+		return nil, err
+	}
+	return fetch, nil
+}
+
+// FetchStates returns the fetch states of the image on each hypervisor that
+// has fetched it
+func (i *Image) FetchStates() ([]*ImageFetch, error) {
+	nodes, err := i.context.kv.GetAll(filepath.Join(ImagePath, i.ID, "hypervisors"))
+	if err != nil {
+		return nil, err
+	}
+
+	fetches := make([]*ImageFetch, 0, len(nodes))
+	for _, node := range nodes {
+		fetch := &ImageFetch{}
+		if err := json.Unmarshal(node.Data, fetch); err != nil {
+			return nil, err
+		}
+		fetches = append(fetches, fetch)
+	}
+	return fetches, nil
+}
+
+// SetFetchState records the state of the image on a hypervisor. The image's
+// current checksum is recorded with it.
+func (i *Image) SetFetchState(hypervisorID, state string, fetchErr error) error {
+	fetch := &ImageFetch{
+		HypervisorID: hypervisorID,
+		State:        state,
+		Checksum:     i.Checksum,
+		UpdatedAt:    time.Now(),
+	}
+	if fetchErr != nil {
+		fetch.Error = fetchErr.Error()
+	}
+
+	v, err := json.Marshal(fetch)
+	if err != nil {
+		return err
+	}
+	return i.context.kv.Set(i.fetchKey(hypervisorID), string(v))
+}
+
+// Fetched returns whether the hypervisor has fetched the image as it currently
+// is, so that it need not be fetched again
+func (i *Image) Fetched(hypervisorID string) (bool, error) {
+	fetch, err := i.FetchState(hypervisorID)
+	if err != nil {
+		if i.context.IsKeyNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return fetch.State == ImageFetched && fetch.Checksum == i.Checksum, nil
+}
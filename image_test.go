@@ -0,0 +1,219 @@
+package lochness_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestImage(t *testing.T) {
+	suite.Run(t, new(ImageSuite))
+}
+
+type ImageSuite struct {
+	common.Suite
+}
+
+func (s *ImageSuite) TestNewImage() {
+	image := s.Context.NewImage()
+	s.NotNil(uuid.Parse(image.ID))
+	s.NotNil(image.Metadata)
+}
+
+func (s *ImageSuite) TestImage() {
+	image := s.NewImage()
+
+	tests := []struct {
+		description string
+		ID          string
+		expectedErr bool
+	}{
+		{"missing id", "", true},
+		{"invalid ID", "adf", true},
+		{"nonexistant ID", uuid.New(), true},
+		{"real ID", image.ID, false},
+	}
+
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		i, err := s.Context.Image(test.ID)
+		if test.expectedErr {
+			s.Error(err, msg("lookup should fail"))
+			s.Nil(i, msg("failure shouldn't return an image"))
+		} else {
+			s.NoError(err, msg("lookup should succeed"))
+			s.Equal(image, i, msg("success should return correct data"))
+		}
+	}
+}
+
+func (s *ImageSuite) TestParseChecksum() {
+	tests := []struct {
+		description string
+		checksum    string
+		expectedErr bool
+	}{
+		{"valid", "sha256:" + strings.Repeat("0f", 32), false},
+		{"empty", "", true},
+		{"no algorithm", strings.Repeat("0f", 32), true},
+		{"md5", "md5:" + strings.Repeat("0f", 16), true},
+		{"short", "sha256:0f0f", true},
+		{"not hex", "sha256:" + strings.Repeat("zz", 32), true},
+	}
+
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		sum, err := lochness.ParseChecksum(test.checksum)
+		if test.expectedErr {
+			s.True(lerrors.IsValidation(err), msg("should be a validation error"))
+		} else {
+			s.NoError(err, msg("should parse"))
+			s.Len(sum, 32, msg("should return the digest"))
+		}
+	}
+}
+
+func (s *ImageSuite) TestValidate() {
+	tests := []struct {
+		description string
+		field       string
+		mutate      func(*lochness.Image)
+	}{
+		{"valid", "", func(i *lochness.Image) {}},
+		{"invalid id", "id", func(i *lochness.Image) { i.ID = "asdf" }},
+		{"missing name", "name", func(i *lochness.Image) { i.Name = "" }},
+		{"missing source", "source", func(i *lochness.Image) { i.Source = "" }},
+		{"file source", "source", func(i *lochness.Image) { i.Source = "file:///tmp/ubuntu.img" }},
+		{"missing checksum", "checksum", func(i *lochness.Image) { i.Checksum = "" }},
+	}
+
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		image := s.Context.NewImage()
+		image.Name = "ubuntu"
+		image.Source = "https://images.example.com/ubuntu.img"
+		image.Checksum = "sha256:" + strings.Repeat("ab", 32)
+		test.mutate(image)
+
+		err := image.Validate()
+		if test.field == "" {
+			s.NoError(err, msg("should be valid"))
+			continue
+		}
+		verr, ok := err.(*lochness.ValidationError)
+		s.Require().True(ok, msg("should be a validation error"))
+		s.Equal([]string{test.field}, verr.Fields, msg("should name the invalid field"))
+	}
+}
+
+func (s *ImageSuite) TestSave() {
+	image := s.NewImage()
+	image.Name = "debian"
+	s.NoError(image.Save())
+
+	i, err := s.Context.Image(image.ID)
+	s.NoError(err)
+	s.Equal("debian", i.Name)
+
+	stale, _ := s.Context.Image(image.ID)
+	s.NoError(image.Save())
+	s.Error(stale.Save(), "stale image should not save")
+}
+
+func (s *ImageSuite) TestDestroy() {
+	image := s.NewImage()
+	guest := s.NewGuest()
+	guest.ImageID = image.ID
+	s.Require().NoError(guest.Save())
+
+	err := image.Destroy()
+	s.True(lerrors.IsConflict(err), "image used by a guest should not be destroyed")
+
+	s.Require().NoError(guest.Destroy())
+	s.Require().NoError(image.SetFetchState(uuid.New(), lochness.ImageFetched, nil))
+	s.NoError(image.Destroy())
+
+	_, err = s.Context.Image(image.ID)
+	s.True(s.Context.IsKeyNotFound(err), "image should be gone")
+}
+
+func (s *ImageSuite) TestForEachImage() {
+	image := s.NewImage()
+	image2 := s.NewImage()
+	expectedFound := map[string]bool{
+		image.ID:  true,
+		image2.ID: true,
+	}
+
+	resultFound := make(map[string]bool)
+	err := s.Context.ForEachImage(func(i *lochness.Image) error {
+		resultFound[i.ID] = true
+		return nil
+	})
+	s.NoError(err)
+	s.Equal(expectedFound, resultFound)
+
+	returnErr := errors.New("an error")
+	err = s.Context.ForEachImage(func(i *lochness.Image) error {
+		return returnErr
+	})
+	s.Equal(returnErr, err)
+}
+
+func (s *ImageSuite) TestFetchState() {
+	image := s.NewImage()
+	hypervisorID := uuid.New()
+
+	fetched, err := image.Fetched(hypervisorID)
+	s.NoError(err)
+	s.False(fetched, "should not be fetched before any state is recorded")
+
+	s.Require().NoError(image.SetFetchState(hypervisorID, lochness.ImageFetchFailed, errors.New("checksum mismatch")))
+	fetch, err := image.FetchState(hypervisorID)
+	s.Require().NoError(err)
+	s.Equal(lochness.ImageFetchFailed, fetch.State)
+	s.Equal("checksum mismatch", fetch.Error)
+	fetched, _ = image.Fetched(hypervisorID)
+	s.False(fetched, "failed fetch should not count")
+
+	s.Require().NoError(image.SetFetchState(hypervisorID, lochness.ImageFetched, nil))
+	fetched, _ = image.Fetched(hypervisorID)
+	s.True(fetched, "should be fetched")
+
+	fetches, err := image.FetchStates()
+	s.NoError(err)
+	s.Len(fetches, 1)
+	s.Equal(hypervisorID, fetches[0].HypervisorID)
+
+	image.Checksum = "sha256:" + strings.Repeat("cd", 32)
+	s.Require().NoError(image.Save())
+	fetched, _ = image.Fetched(hypervisorID)
+	s.False(fetched, "changed checksum should be fetched again")
+}
+
+func (s *ImageSuite) TestCheckGuestImage() {
+	image := s.NewImage()
+	guest := s.NewGuest()
+
+	s.NoError(s.Context.CheckGuestImage(guest), "guest without image should pass")
+
+	guest.ImageID = image.ID
+	s.NoError(s.Context.CheckGuestImage(guest))
+
+	guest.ImageID = uuid.New()
+	s.True(lerrors.IsValidation(s.Context.CheckGuestImage(guest)), "missing image should fail")
+
+	image.MinDisk = 1 << 20
+	s.Require().NoError(image.Save())
+	guest.ImageID = image.ID
+	err := s.Context.CheckGuestImage(guest)
+	verr, ok := err.(*lochness.ValidationError)
+	s.Require().True(ok, "too small flavor should fail")
+	s.Equal([]string{"flavor"}, verr.Fields)
+}
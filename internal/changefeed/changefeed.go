@@ -0,0 +1,398 @@
+// Package changefeed streams the changes to guests and hypervisors in the kv
+// to http clients as server-sent events, so they can follow changes without
+// access to the kv. Clients choose the entities they follow with filters,
+// get a comment as a heartbeat while nothing changes, and resume a dropped
+// stream from the id of the last event they got, as EventSource does with the
+// Last-Event-ID header.
+package changefeed
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/mistifyio/lochness/pkg/events"
+	"github.com/mistifyio/lochness/pkg/kv"
+	"github.com/mistifyio/lochness/pkg/watcher"
+	"github.com/pborman/uuid"
+)
+
+const (
+	// DefaultHeartbeat is how often an idle stream gets a heartbeat
+	DefaultHeartbeat = 15 * time.Second
+	// DefaultBacklog is how many changes are kept for streams to resume from
+	DefaultBacklog = 1024
+	// ResetEvent is the event sent when changes may have been missed, e.g.
+	// a stream resumed from an id that is no longer kept. Clients should
+	// list the entities again.
+	ResetEvent = "reset"
+)
+
+// prefixes maps the prefixes streams can be filtered by to their entity and
+// kv path
+var prefixes = map[string]struct {
+	entity string
+	path   string
+}{
+	"guests":      {"guest", lochness.GuestPath},
+	"hypervisors": {"hypervisor", lochness.HypervisorPath},
+}
+
+// subscriberBuffer is how many changes a stream may fall behind by before it
+// is dropped
+const subscriberBuffer = 256
+
+// Change is a change to an entity, numbered in the order the feed saw it
+type Change struct {
+	// ID is the event id streams resume from, the boot of the feed and Seq
+	ID       string
+	Seq      uint64
+	Prefix   string
+	Event    *events.Event
+	metadata map[string]string
+}
+
+// Filter selects the changes a stream gets
+type Filter struct {
+	// Prefixes are the entity prefixes, e.g. "guests"; all if empty
+	Prefixes map[string]bool
+	// IDs are the entity ids; all if empty
+	IDs map[string]bool
+	// Metadata must match the metadata of the entity
+	Metadata lochness.MetadataFilters
+}
+
+// ParseFilter parses a Filter from the prefix, id, and metadata query
+// parameters, each of which may be repeated
+func ParseFilter(query url.Values) (*Filter, error) {
+	f := &Filter{
+		Prefixes: map[string]bool{},
+		IDs:      map[string]bool{},
+	}
+	for _, prefix := range query["prefix"] {
+		prefix = strings.Trim(prefix, "/")
+		if _, ok := prefixes[prefix]; !ok {
+			return nil, lerrors.Validation("prefix", fmt.Sprintf("unknown prefix %q", prefix))
+		}
+		f.Prefixes[prefix] = true
+	}
+	for _, id := range query["id"] {
+		f.IDs[id] = true
+	}
+	metadata, err := lochness.ParseMetadataFilters(query["metadata"])
+	if err != nil {
+		return nil, err
+	}
+	f.Metadata = metadata
+	return f, nil
+}
+
+// Match returns whether a change passes the filter
+func (f *Filter) Match(c *Change) bool {
+	if len(f.Prefixes) > 0 && !f.Prefixes[c.Prefix] {
+		return false
+	}
+	if len(f.IDs) > 0 && !f.IDs[c.Event.Subject] {
+		return false
+	}
+	return f.Metadata.Match(c.metadata)
+}
+
+// subscriber is a stream waiting for changes. ch is closed when it is
+// dropped.
+type subscriber struct {
+	filter *Filter
+	ch     chan *Change
+}
+
+// Feed watches the kv for changes and fans them out to streams
+type Feed struct {
+	// Heartbeat is how often idle streams get a heartbeat
+	Heartbeat time.Duration
+	// Backlog is how many changes are kept to resume streams from
+	Backlog int
+
+	kv      kv.KV
+	watcher *watcher.Watcher
+
+	mu      sync.Mutex
+	boot    string // changes to the ids of an earlier boot may have been missed
+	seq     uint64
+	since   uint64 // streams can resume from any id at or after since
+	backlog []*Change
+	subs    map[*subscriber]struct{}
+	closed  bool
+}
+
+// New creates a Feed of the changes in a kv
+func New(KV kv.KV) (*Feed, error) {
+	if KV == nil {
+		return nil, fmt.Errorf("kv instance must be non-nil")
+	}
+	return &Feed{
+		Heartbeat: DefaultHeartbeat,
+		Backlog:   DefaultBacklog,
+		kv:        KV,
+		boot:      uuid.New()[:8],
+		subs:      map[*subscriber]struct{}{},
+	}, nil
+}
+
+// Start watches the kv in the background. Changes that may be lost to a
+// watch error are reported to streams with a ResetEvent.
+func (f *Feed) Start() error {
+	w, err := watcher.New(f.kv)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.watcher = w
+	f.mu.Unlock()
+	for _, p := range prefixes {
+		if err := w.Add(p.path); err != nil {
+			return err
+		}
+	}
+
+	go func() {
+		for {
+			for w.Next() {
+				f.publish(w.Event())
+			}
+			werr := w.Err()
+			log.WithFields(log.Fields{
+				"error":  werr,
+				"prefix": werr.Prefix,
+			}).Error("change feed watch failed")
+			f.reset()
+			time.Sleep(time.Second)
+			if err := w.Add(werr.Prefix); err != nil {
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Close stops watching and ends every stream. It is called when the servers
+// shut down, as the streams would otherwise hold up the shutdown.
+func (f *Feed) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return
+	}
+	f.closed = true
+	if f.watcher != nil {
+		_ = f.watcher.Close()
+	}
+	for sub := range f.subs {
+		f.dropLocked(sub)
+	}
+}
+
+// change translates a kv event into a Change, or nil if it is not one
+func change(event kv.Event) *Change {
+	key := strings.TrimPrefix(event.Key, "/")
+	for prefix, p := range prefixes {
+		if !strings.HasPrefix(key, p.path) {
+			continue
+		}
+		e := events.FromKV(p.entity, strings.TrimPrefix(key, p.path), event)
+		if e == nil {
+			return nil
+		}
+
+		// deleted entities are matched by their last metadata, if known
+		data := event.Data
+		if event.Type == kv.Delete {
+			data = nil
+			if event.Prev != nil {
+				data = event.Prev.Data
+			}
+		}
+		var entity struct {
+			Metadata map[string]string `json:"metadata"`
+		}
+		_ = json.Unmarshal(data, &entity)
+
+		return &Change{Prefix: prefix, Event: e, metadata: entity.Metadata}
+	}
+	return nil
+}
+
+// publish numbers a change, keeps it in the backlog, and sends it to the
+// streams it passes the filter of
+func (f *Feed) publish(event kv.Event) {
+	c := change(event)
+	if c == nil {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.seq++
+	c.Seq = f.seq
+	c.ID = f.boot + "-" + strconv.FormatUint(c.Seq, 10)
+	f.backlog = append(f.backlog, c)
+	if len(f.backlog) > f.Backlog {
+		f.since = f.backlog[0].Seq
+		f.backlog = f.backlog[1:]
+	}
+
+	for sub := range f.subs {
+		if !sub.filter.Match(c) {
+			continue
+		}
+		select {
+		case sub.ch <- c:
+		default:
+			// Too far behind; it can resume from the backlog
+			f.dropLocked(sub)
+		}
+	}
+}
+
+// reset forgets the backlog and starts a new boot, since changes may have
+// been missed, and drops the streams so they resume and are told to list again
+func (f *Feed) reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.boot = uuid.New()[:8]
+	f.since = f.seq
+	f.backlog = nil
+	for sub := range f.subs {
+		f.dropLocked(sub)
+	}
+}
+
+func (f *Feed) dropLocked(sub *subscriber) {
+	delete(f.subs, sub)
+	close(sub.ch)
+}
+
+// subscribe registers a stream resuming after the event id lastID, returning
+// the subscriber and the changes it missed. ok is false if the changes after
+// lastID are not all known.
+func (f *Feed) subscribe(filter *Filter, lastID string) (sub *subscriber, missed []*Change, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return nil, nil, false
+	}
+
+	ok = true
+	if lastID != "" {
+		ok = false
+		parts := strings.SplitN(lastID, "-", 2)
+		if len(parts) == 2 && parts[0] == f.boot {
+			seq, err := strconv.ParseUint(parts[1], 10, 64)
+			if err == nil && seq >= f.since && seq <= f.seq {
+				ok = true
+				for _, c := range f.backlog {
+					if c.Seq > seq && filter.Match(c) {
+						missed = append(missed, c)
+					}
+				}
+			}
+		}
+	}
+
+	sub = &subscriber{
+		filter: filter,
+		ch:     make(chan *Change, subscriberBuffer),
+	}
+	f.subs[sub] = struct{}{}
+	return sub, missed, ok
+}
+
+func (f *Feed) unsubscribe(sub *subscriber) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.subs[sub]; ok {
+		f.dropLocked(sub)
+	}
+}
+
+// Serve streams the changes that pass filter to w until the client goes away,
+// falls too far behind, or the feed is closed. A stream resumes after the
+// Last-Event-ID header, or the last_event_id query parameter, if either is
+// set.
+func (f *Feed) Serve(w http.ResponseWriter, r *http.Request, filter *Filter) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	sub, missed, resumed := f.subscribe(filter, lastID)
+	if sub == nil {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer f.unsubscribe(sub)
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if !resumed {
+		_, _ = fmt.Fprintf(w, "event: %s\ndata: {}\n\n", ResetEvent)
+	}
+	for _, c := range missed {
+		if err := write(w, c); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(f.Heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case c, ok := <-sub.ch:
+			if !ok {
+				return
+			}
+			if err := write(w, c); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// write writes a change as an event, its data the json of its events.Event
+func write(w http.ResponseWriter, c *Change) error {
+	data, err := json.Marshal(c.Event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", c.ID, c.Event.Type, data)
+	return err
+}
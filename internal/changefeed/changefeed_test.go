@@ -0,0 +1,208 @@
+package changefeed_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness/internal/changefeed"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/mistifyio/lochness/pkg/events"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestChangefeed(t *testing.T) {
+	suite.Run(t, new(ChangefeedSuite))
+}
+
+type ChangefeedSuite struct {
+	common.Suite
+	Feed   *changefeed.Feed
+	Server *httptest.Server
+}
+
+// sse is an event read from a stream
+type sse struct {
+	ID    string
+	Event string
+	Data  string
+}
+
+// stream is a connection to the feed
+type stream struct {
+	resp   *http.Response
+	events chan sse
+}
+
+func (s *ChangefeedSuite) SetupSuite() {
+	s.Suite.SetupSuite()
+	log.SetLevel(log.FatalLevel)
+}
+
+func (s *ChangefeedSuite) SetupTest() {
+	s.Suite.SetupTest()
+
+	var err error
+	s.Feed, err = changefeed.New(s.KV)
+	s.Require().NoError(err)
+	s.Require().NoError(s.Feed.Start())
+	time.Sleep(100 * time.Millisecond)
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filter, err := changefeed.ParseFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.Feed.Serve(w, r, filter)
+	}))
+}
+
+func (s *ChangefeedSuite) TearDownTest() {
+	s.Feed.Close()
+	s.Server.Close()
+	s.Suite.TearDownTest()
+}
+
+// connect opens a stream with the query and Last-Event-ID lastID
+func (s *ChangefeedSuite) connect(query, lastID string) *stream {
+	req, err := http.NewRequest("GET", s.Server.URL+"?"+query, nil)
+	s.Require().NoError(err)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	s.Require().NoError(err)
+	s.Require().Equal(http.StatusOK, resp.StatusCode)
+	s.Equal("text/event-stream", resp.Header.Get("Content-Type"))
+
+	st := &stream{resp: resp, events: make(chan sse, 100)}
+	go func() {
+		defer close(st.events)
+		scanner := bufio.NewScanner(resp.Body)
+		e := sse{}
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				st.events <- e
+				e = sse{}
+			case strings.HasPrefix(line, ":"):
+				e.Event = "comment"
+			case strings.HasPrefix(line, "id: "):
+				e.ID = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				e.Event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				e.Data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}()
+	return st
+}
+
+// next returns the next event of a stream, skipping heartbeats unless
+// comments is set
+func (s *ChangefeedSuite) next(st *stream, comments bool) sse {
+	for {
+		select {
+		case e, ok := <-st.events:
+			if !ok {
+				return sse{Event: "closed"}
+			}
+			if e.Event == "comment" && !comments {
+				continue
+			}
+			return e
+		case <-time.After(5 * time.Second):
+			s.Fail("timed out waiting for an event")
+			return sse{}
+		}
+	}
+}
+
+func (s *ChangefeedSuite) TestStream() {
+	st := s.connect("prefix=guests", "")
+	defer func() { _ = st.resp.Body.Close() }()
+	time.Sleep(100 * time.Millisecond)
+
+	_ = s.NewHypervisor()
+	guest := s.NewGuest()
+
+	e := s.next(st, false)
+	s.Equal("guest.created", e.Event)
+	s.NotEmpty(e.ID)
+	var event events.Event
+	s.Require().NoError(json.Unmarshal([]byte(e.Data), &event))
+	s.Equal(guest.ID, event.Subject)
+	s.Equal("guest", event.Entity)
+}
+
+func (s *ChangefeedSuite) TestFilters() {
+	guest := s.NewGuest()
+	guest.Metadata = map[string]string{"env": "prod"}
+
+	query := url.Values{"metadata": {"env=prod"}}
+	st := s.connect(query.Encode(), "")
+	defer func() { _ = st.resp.Body.Close() }()
+	time.Sleep(100 * time.Millisecond)
+
+	_ = s.NewGuest()
+	s.Require().NoError(guest.Save())
+
+	e := s.next(st, false)
+	s.Equal("guest.updated", e.Event)
+	s.Contains(e.Data, guest.ID)
+
+	resp, err := http.Get(s.Server.URL + "?prefix=flavors")
+	s.Require().NoError(err)
+	_ = resp.Body.Close()
+	s.Equal(http.StatusBadRequest, resp.StatusCode)
+}
+
+func (s *ChangefeedSuite) TestResume() {
+	st := s.connect("prefix=guests", "")
+	time.Sleep(100 * time.Millisecond)
+	first := s.NewGuest()
+	e := s.next(st, false)
+	s.Contains(e.Data, first.ID)
+	_ = st.resp.Body.Close()
+
+	// missed while disconnected
+	second := s.NewGuest()
+	time.Sleep(100 * time.Millisecond)
+
+	st = s.connect("prefix=guests", e.ID)
+	defer func() { _ = st.resp.Body.Close() }()
+	resumed := s.next(st, false)
+	s.Equal("guest.created", resumed.Event)
+	s.Contains(resumed.Data, second.ID)
+
+	// an unknown id can not be resumed from
+	other := s.connect("prefix=guests", "unknown-1")
+	defer func() { _ = other.resp.Body.Close() }()
+	s.Equal(changefeed.ResetEvent, s.next(other, false).Event)
+}
+
+func (s *ChangefeedSuite) TestHeartbeat() {
+	s.Feed.Heartbeat = 50 * time.Millisecond
+	st := s.connect("", "")
+	defer func() { _ = st.resp.Body.Close() }()
+
+	s.Equal("comment", s.next(st, true).Event)
+}
+
+func (s *ChangefeedSuite) TestClose() {
+	st := s.connect("", "")
+	defer func() { _ = st.resp.Body.Close() }()
+	time.Sleep(100 * time.Millisecond)
+
+	s.Feed.Close()
+	s.Equal("closed", s.next(st, false).Event)
+}
@@ -3,6 +3,7 @@ package cli
 import (
 	"bufio"
 	"io"
+	"net/url"
 	"strings"
 )
 
@@ -16,3 +17,12 @@ func Read(r io.Reader) []string {
 	}
 	return args
 }
+
+// MetadataQuery returns the query string selecting the resources whose
+// metadata has each of the key=value filters, or "" if there are none
+func MetadataQuery(filters []string) string {
+	if len(filters) == 0 {
+		return ""
+	}
+	return "?" + url.Values{"metadata": filters}.Encode()
+}
@@ -16,6 +16,11 @@ type CLISuite struct {
 	suite.Suite
 }
 
+func (s *CLISuite) TestMetadataQuery() {
+	s.Equal("", cli.MetadataQuery(nil))
+	s.Equal("?metadata=env%3Dprod&metadata=team%3Da%2Fb", cli.MetadataQuery([]string{"env=prod", "team=a/b"}))
+}
+
 func (s *CLISuite) TestRead() {
 	reader := strings.NewReader("")
 	s.Len(cli.Read(reader), 0)
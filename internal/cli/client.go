@@ -1,23 +1,76 @@
 // Package cli provides a client and utilities for lochness cli applications to
 // interact with agents.
+//
+// Failures exit through Fatal, with one of the Exit codes so scripts can tell
+// usage errors, missing resources, rejected requests, and server failures
+// apart, and with a last line of json on stderr describing the failure.
+//
+// Requests are made under the version of the api that servers serve, see
+// Client.Version, falling back to the unversioned paths of older servers.
 package cli
 
 import (
+	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"path"
 	"strings"
+	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness/internal/tlsutil"
+	"github.com/mistifyio/lochness/internal/transport"
 	logx "github.com/mistifyio/mistify-logrus-ext"
+	"github.com/spf13/pflag"
+)
+
+const (
+	// APIVersion is the version of the apis the client speaks, as served
+	// under /v1 by the api daemons, see httpmw.APIVersion
+	APIVersion = "v1"
+	// APIVersionHeader lists the versions a versioned api serves, see
+	// httpmw.APIVersionHeader
+	APIVersionHeader = "X-API-Version"
 )
 
 // Client interacts with an http api
 type Client struct {
-	c      http.Client
-	t      string //type
-	scheme string
-	addr   string
+	c         http.Client
+	t         string //type
+	scheme    string
+	addr      string
+	transport transport.Config
+	token     string
+	version   string
+	negotiate sync.Once
+}
+
+// bearerTransport sends a token in the Authorization header of every request
+type bearerTransport struct {
+	*http.Transport
+	token string
+}
+
+// RoundTrip sends the request with the token
+func (t bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return t.Transport.RoundTrip(req)
+}
+
+// TransportOptions are how the clis connect to servers
+type TransportOptions struct {
+	Proxy       string
+	DialTimeout time.Duration
+}
+
+// AddFlags adds the --proxy and --dial-timeout flags setting the options
+func (o *TransportOptions) AddFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&o.Proxy, "proxy", o.Proxy, `url of the proxy to connect through, instead of those of $HTTP_PROXY, $HTTPS_PROXY, and $NO_PROXY, or "none" to connect directly`)
+	flags.DurationVar(&o.DialTimeout, "dial-timeout", o.DialTimeout, "how long connecting to the server or proxy may take, 0 for the default of 30s")
 }
 
 // NewClient creates a new Client
@@ -26,27 +79,154 @@ func NewClient(address string) *Client {
 	return &Client{scheme: strings[0], addr: strings[1], t: "application/json"}
 }
 
-// URLString generates the full url given an endpoint path
+// ConfigureTLS sets how https servers are verified, against the CA
+// certificates in caFile, or the system's if it is empty, or by a pin of their
+// certificate's public key. See tlsutil.ClientConfig.
+func (c *Client) ConfigureTLS(caFile, pin string) error {
+	config, err := tlsutil.ClientConfig(caFile, pin)
+	if err != nil {
+		return err
+	}
+	cfg := c.transport
+	cfg.TLS = config
+	return c.configure(cfg)
+}
+
+// ConfigureTransport sets the proxy the client connects through and how long
+// connecting may take. See transport.Config.
+func (c *Client) ConfigureTransport(o TransportOptions) error {
+	cfg := c.transport
+	cfg.Proxy = o.Proxy
+	cfg.DialTimeout = o.DialTimeout
+	return c.configure(cfg)
+}
+
+// SetToken sets the token sent to the server as a bearer token in the
+// Authorization header of every request, none if it is empty
+func (c *Client) SetToken(token string) error {
+	c.token = token
+	return c.configure(c.transport)
+}
+
+// configure makes the client connect as cfg sets
+func (c *Client) configure(cfg transport.Config) error {
+	t, err := cfg.Transport()
+	if err != nil {
+		return err
+	}
+	c.transport = cfg
+	c.c.Transport = t
+	if c.token != "" {
+		c.c.Transport = bearerTransport{Transport: t, token: c.token}
+	}
+	return nil
+}
+
+// TLSConfig returns the tls config set by ConfigureTLS, or nil
+func (c *Client) TLSConfig() *tls.Config {
+	return c.transport.TLS
+}
+
+// Transport returns the transport the client connects with, for connections
+// made outside of it, such as tunnels
+func (c *Client) Transport() *http.Transport {
+	switch t := c.c.Transport.(type) {
+	case *http.Transport:
+		return t
+	case bearerTransport:
+		return t.Transport
+	}
+	// the zero config is valid
+	t, _ := c.transport.Transport()
+	return t
+}
+
+// Version returns the version of the api the client uses with the server,
+// APIVersion, or "" for servers whose routes are not versioned. Unless set
+// with SetVersion, it is negotiated with the server on first use: versioned
+// servers list the versions they serve in the APIVersionHeader of every
+// response, even of routes they do not have.
+func (c *Client) Version() string {
+	c.negotiate.Do(func() {
+		addr := c.scheme + "://" + path.Join(c.addr, APIVersion) + "/"
+		resp, err := c.c.Get(addr)
+		if err != nil {
+			Fatal(ExitServer, log.Fields{
+				"error":   err,
+				"address": addr,
+			}, "failed to negotiate api version")
+		}
+		logx.LogReturnedErr(resp.Body.Close, nil, "failed to close response body")
+		for _, version := range strings.Split(resp.Header.Get(APIVersionHeader), ",") {
+			if strings.TrimSpace(version) == APIVersion {
+				c.version = APIVersion
+			}
+		}
+		log.WithFields(log.Fields{
+			"address": addr,
+			"version": c.version,
+		}).Debug("negotiated api version")
+	})
+	return c.version
+}
+
+// SetVersion sets the version of the api the client uses with the server, ""
+// for unversioned routes, instead of negotiating it
+func (c *Client) SetVersion(version string) {
+	c.negotiate.Do(func() {})
+	c.version = version
+}
+
+// URLString generates the full url given an endpoint path, under the version
+// of the api the server serves
 func (c *Client) URLString(endpoint string) string {
-	return c.scheme + "://" + path.Join(c.addr, endpoint)
+	return c.scheme + "://" + path.Join(c.addr, c.Version(), endpoint)
 }
 
 // GetMany GETs a set of resources
 func (c *Client) GetMany(title, endpoint string) ([]map[string]interface{}, *http.Response) {
 	resp, err := c.c.Get(c.URLString(endpoint))
 	if err != nil {
-		log.WithField("error", err).Fatal("failed to get " + title)
+		Fatal(ExitServer, log.Fields{"error": err}, "failed to get "+title)
 	}
 	ret := []map[string]interface{}{}
 	ProcessResponse(resp, title, "get", []int{http.StatusOK}, &ret)
 	return ret, resp
 }
 
+// GetManyIfChanged GETs a set of resources unless it is unchanged since the
+// response tagged etag, returning the set, its new ETag, and whether it
+// changed. An empty etag always gets the set.
+func (c *Client) GetManyIfChanged(title, endpoint, etag string) ([]map[string]interface{}, string, bool) {
+	addr := c.URLString(endpoint)
+	req, err := http.NewRequest("GET", addr, nil)
+	if err != nil {
+		Fatal(ExitError, log.Fields{
+			"error":   err,
+			"address": addr,
+		}, "unable to form request")
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := c.c.Do(req)
+	if err != nil {
+		Fatal(ExitServer, log.Fields{"error": err}, "failed to get "+title)
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		logx.LogReturnedErr(resp.Body.Close, nil, "failed to close response body")
+		return nil, etag, false
+	}
+	ret := []map[string]interface{}{}
+	ProcessResponse(resp, title, "get", []int{http.StatusOK}, &ret)
+	return ret, resp.Header.Get("ETag"), true
+}
+
 // GetList GETs an array of string (e.g. IDs)
 func (c *Client) GetList(title, endpoint string) ([]string, *http.Response) {
 	resp, err := c.c.Get(c.URLString(endpoint))
 	if err != nil {
-		log.WithField("error", err).Fatal("failed to get " + title)
+		Fatal(ExitServer, log.Fields{"error": err}, "failed to get "+title)
 	}
 	ret := []string{}
 	ProcessResponse(resp, title, "get", []int{http.StatusOK}, &ret)
@@ -57,24 +237,75 @@ func (c *Client) GetList(title, endpoint string) ([]string, *http.Response) {
 func (c *Client) Get(title, endpoint string) (map[string]interface{}, *http.Response) {
 	resp, err := c.c.Get(c.URLString(endpoint))
 	if err != nil {
-		log.WithField("error", err).Fatal("failed to get " + title)
+		Fatal(ExitServer, log.Fields{"error": err}, "failed to get "+title)
 	}
 	ret := map[string]interface{}{}
 	ProcessResponse(resp, title, "get", []int{http.StatusOK}, &ret)
 	return ret, resp
 }
 
-// Post POSTs a body
+// GetJSON GETs a resource into dest, returning failures rather than exiting,
+// for commands that keep running through them
+func (c *Client) GetJSON(endpoint string, dest interface{}) error {
+	resp, err := c.c.Get(c.URLString(endpoint))
+	if err != nil {
+		return err
+	}
+	defer logx.LogReturnedErr(resp.Body.Close, nil, "failed to close response body")
+
+	dec := json.NewDecoder(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		body := JMap{}
+		if err := dec.Decode(&body); err == nil {
+			if msg, _ := body["message"].(string); msg != "" {
+				return fmt.Errorf("%s: %s", resp.Status, msg)
+			}
+		}
+		return errors.New(resp.Status)
+	}
+	return dec.Decode(dest)
+}
+
+// Exists GETs a single resource, returning whether the server has it
+func (c *Client) Exists(title, endpoint string) bool {
+	resp, err := c.c.Get(c.URLString(endpoint))
+	if err != nil {
+		Fatal(ExitServer, log.Fields{"error": err}, "failed to get "+title)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		logx.LogReturnedErr(resp.Body.Close, nil, "failed to close response body")
+		return false
+	}
+	ret := map[string]interface{}{}
+	ProcessResponse(resp, title, "get", []int{http.StatusOK}, &ret)
+	return true
+}
+
+// Post POSTs a body, creating a resource or setting a list of one
 func (c *Client) Post(title, endpoint, body string) (map[string]interface{}, *http.Response) {
 	resp, err := c.c.Post(c.URLString(endpoint), c.t, strings.NewReader(body))
 	if err != nil {
-		log.WithFields(log.Fields{
+		Fatal(ExitServer, log.Fields{
 			"error": err,
 			"body":  body,
-		}).Fatal("unable to create new " + title)
+		}, "unable to create new "+title)
 	}
 	ret := map[string]interface{}{}
-	ProcessResponse(resp, title, "create", []int{http.StatusAccepted, http.StatusCreated}, &ret)
+	ProcessResponse(resp, title, "create", []int{http.StatusOK, http.StatusAccepted, http.StatusCreated}, &ret)
+	return ret, resp
+}
+
+// PostMany POSTs a body, creating a set of resources
+func (c *Client) PostMany(title, endpoint, body string) ([]map[string]interface{}, *http.Response) {
+	resp, err := c.c.Post(c.URLString(endpoint), c.t, strings.NewReader(body))
+	if err != nil {
+		Fatal(ExitServer, log.Fields{
+			"error": err,
+			"body":  body,
+		}, "unable to create new "+title)
+	}
+	ret := []map[string]interface{}{}
+	ProcessResponse(resp, title, "create", []int{http.StatusOK, http.StatusAccepted, http.StatusCreated}, &ret)
 	return ret, resp
 }
 
@@ -83,18 +314,18 @@ func (c *Client) Delete(title, endpoint string) (map[string]interface{}, *http.R
 	addr := c.URLString(endpoint)
 	req, err := http.NewRequest("DELETE", addr, nil)
 	if err != nil {
-		log.WithFields(log.Fields{
+		Fatal(ExitError, log.Fields{
 			"error":   err,
 			"address": addr,
-		}).Fatal("unable to form request")
+		}, "unable to form request")
 	}
 	req.Header.Add("ContentType", c.t)
 	resp, err := c.c.Do(req)
 	if err != nil {
-		log.WithFields(log.Fields{
+		Fatal(ExitServer, log.Fields{
 			"error":   err,
 			"address": addr,
-		}).Fatal("unable to complete request")
+		}, "unable to complete request")
 	}
 
 	ret := map[string]interface{}{}
@@ -107,27 +338,52 @@ func (c *Client) Patch(title, endpoint, body string) (map[string]interface{}, *h
 	addr := c.URLString(endpoint)
 	req, err := http.NewRequest("PATCH", addr, strings.NewReader(body))
 	if err != nil {
-		log.WithFields(log.Fields{
+		Fatal(ExitError, log.Fields{
 			"error":   err,
 			"address": addr,
 			"body":    body,
-		}).Fatal("unable to form request")
+		}, "unable to form request")
 	}
 	req.Header.Add("ContentType", c.t)
 	resp, err := c.c.Do(req)
 	if err != nil {
-		log.WithFields(log.Fields{
+		Fatal(ExitServer, log.Fields{
 			"error":   err,
 			"address": addr,
 			"body":    body,
-		}).Fatal("unable to complete request")
+		}, "unable to complete request")
 	}
 	ret := map[string]interface{}{}
 	ProcessResponse(resp, title, "update", []int{http.StatusOK}, &ret)
 	return ret, resp
 }
 
-func parseError(dec *json.Decoder) (string, []interface{}) {
+// Put PUTs a resource
+func (c *Client) Put(title, endpoint, body string) (map[string]interface{}, *http.Response) {
+	addr := c.URLString(endpoint)
+	req, err := http.NewRequest("PUT", addr, strings.NewReader(body))
+	if err != nil {
+		Fatal(ExitError, log.Fields{
+			"error":   err,
+			"address": addr,
+			"body":    body,
+		}, "unable to form request")
+	}
+	req.Header.Add("ContentType", c.t)
+	resp, err := c.c.Do(req)
+	if err != nil {
+		Fatal(ExitServer, log.Fields{
+			"error":   err,
+			"address": addr,
+			"body":    body,
+		}, "unable to complete request")
+	}
+	ret := map[string]interface{}{}
+	ProcessResponse(resp, title, "update", []int{http.StatusOK}, &ret)
+	return ret, resp
+}
+
+func parseError(dec *json.Decoder) (string, string, []interface{}) {
 	jmap := JMap{}
 	err := dec.Decode(&jmap)
 	if err != nil {
@@ -135,7 +391,7 @@ func parseError(dec *json.Decoder) (string, []interface{}) {
 			"error": err,
 			"func":  "parseError",
 		}).Warn("failed to parse error json")
-		return "", []interface{}{}
+		return "", "", []interface{}{}
 	}
 
 	msg := ""
@@ -147,6 +403,8 @@ func parseError(dec *json.Decoder) (string, []interface{}) {
 		}
 	}
 
+	errCode, _ := jmap["error"].(string)
+
 	stack := []interface{}{}
 	iface, ok = jmap["stack"]
 	if ok {
@@ -154,17 +412,19 @@ func parseError(dec *json.Decoder) (string, []interface{}) {
 			stack = slice
 		}
 	}
-	return msg, stack
+	return msg, errCode, stack
 }
 
-// ProcessResponse processes an http response
+// ProcessResponse processes an http response, exiting with the exit code of
+// an unexpected status, see StatusExitCode. Responses that failed validation
+// exit with ExitValidation whatever their status.
 func ProcessResponse(response *http.Response, title, action string, expectedStatuses []int, dest interface{}) {
 	defer logx.LogReturnedErr(response.Body.Close, nil, "failed to close response body")
 
 	dec := json.NewDecoder(response.Body)
 	if okRespStatus(response.StatusCode, expectedStatuses) {
 		if err := dec.Decode(dest); err != nil {
-			log.WithField("error", err).Fatal("failed to parse json")
+			Fatal(ExitServer, log.Fields{"error": err}, "failed to parse json")
 		}
 		return
 	}
@@ -174,17 +434,24 @@ func ProcessResponse(response *http.Response, title, action string, expectedStat
 		"code":   response.StatusCode,
 	}
 
-	msg, stack := parseError(dec)
+	msg, errCode, stack := parseError(dec)
 	if msg != "" {
 		fields["message"] = msg
 	}
+	if errCode != "" {
+		fields["error"] = errCode
+	}
 	if len(stack) > 0 {
 		if log.GetLevel() >= log.DebugLevel {
 			fields["stack"] = stack
 		}
 	}
 
-	log.WithFields(fields).Fatal("failed to " + action + " " + title)
+	code := StatusExitCode(response.StatusCode)
+	if errCode == "validation_failed" {
+		code = ExitValidation
+	}
+	Fatal(code, fields, "failed to "+action+" "+title)
 }
 
 func okRespStatus(status int, expectedStatuses []int) bool {
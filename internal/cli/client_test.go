@@ -0,0 +1,140 @@
+package cli_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mistifyio/lochness/internal/cli"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestClient(t *testing.T) {
+	suite.Run(t, new(ClientSuite))
+}
+
+type ClientSuite struct {
+	suite.Suite
+	Paths []string
+}
+
+func (s *ClientSuite) SetupTest() {
+	s.Paths = nil
+}
+
+// handler records the paths requested, serving an empty object at all but the
+// versioned ones, which unversioned servers do not have
+func (s *ClientSuite) handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Paths = append(s.Paths, r.URL.Path)
+		if strings.HasPrefix(r.URL.Path, httpmw.APIPrefix+"/") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte("{}"))
+	})
+}
+
+func (s *ClientSuite) TestVersionConstants() {
+	s.Equal(httpmw.APIVersion, cli.APIVersion)
+	s.Equal(httpmw.APIVersionHeader, cli.APIVersionHeader)
+}
+
+func (s *ClientSuite) TestVersioned() {
+	server := httptest.NewServer(httpmw.Versioned(s.handler()))
+	defer server.Close()
+
+	c := cli.NewClient(server.URL)
+	_, resp := c.Get("guest", "guests/foo")
+	s.Equal(cli.APIVersion, c.Version())
+	s.Equal(server.URL+"/v1/guests/foo", c.URLString("guests/foo"))
+	s.Empty(resp.Header.Get("Deprecation"))
+	// the probe and the request, as routed
+	s.Equal([]string{"/", "/guests/foo"}, s.Paths)
+}
+
+func (s *ClientSuite) TestUnversioned() {
+	server := httptest.NewServer(s.handler())
+	defer server.Close()
+
+	c := cli.NewClient(server.URL)
+	c.Get("guest", "guests/foo")
+	s.Equal("", c.Version())
+	s.Equal([]string{"/v1/", "/guests/foo"}, s.Paths)
+}
+
+func (s *ClientSuite) TestSetVersion() {
+	server := httptest.NewServer(httpmw.Versioned(s.handler()))
+	defer server.Close()
+
+	c := cli.NewClient(server.URL)
+	c.SetVersion("")
+	_, resp := c.Get("guest", "guests/foo")
+	s.Equal("true", resp.Header.Get("Deprecation"))
+	s.Equal([]string{"/guests/foo"}, s.Paths, "nothing should be negotiated")
+}
+
+func (s *ClientSuite) TestConfigureTransport() {
+	var hosts []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts = append(hosts, r.Host)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte("{}"))
+	}))
+	defer proxy.Close()
+
+	c := cli.NewClient("http://lochness.invalid")
+	c.SetVersion("")
+	s.Error(c.ConfigureTransport(cli.TransportOptions{Proxy: "ftp://bastion"}))
+	s.Require().NoError(c.ConfigureTransport(cli.TransportOptions{Proxy: proxy.URL, DialTimeout: time.Second}))
+	s.Require().NoError(c.ConfigureTLS("", ""), "tls settings should keep the proxy")
+	c.Get("guest", "guests/foo")
+	s.Equal([]string{"lochness.invalid"}, hosts, "the request should go through the proxy")
+	s.NotNil(c.Transport().Proxy)
+}
+
+func (s *ClientSuite) TestSetToken() {
+	var auths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auths = append(auths, r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	c := cli.NewClient(server.URL)
+	c.SetVersion("")
+	c.Get("guest", "guests/foo")
+	s.Require().NoError(c.SetToken("hunter2"))
+	s.Require().NoError(c.ConfigureTLS("", ""), "tls settings should keep the token")
+	c.Get("guest", "guests/foo")
+	s.Equal([]string{"", "Bearer hunter2"}, auths)
+	s.NotNil(c.Transport(), "the transport should be that under the token")
+}
+
+func (s *ClientSuite) TestGetJSON() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/guests/foo" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message":"not found","error":"not_found"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":"foo"}`))
+	}))
+	defer server.Close()
+
+	c := cli.NewClient(server.URL)
+	c.SetVersion("")
+	guest := cli.JMap{}
+	s.NoError(c.GetJSON("guests/foo", &guest))
+	s.Equal("foo", guest.ID())
+
+	err := c.GetJSON("guests/bar", &guest)
+	s.Require().Error(err, "failures should be returned")
+	s.Contains(err.Error(), "not found")
+}
@@ -0,0 +1,124 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	logx "github.com/mistifyio/mistify-logrus-ext"
+	"github.com/spf13/cobra"
+)
+
+// CompletionShells are the shells CompletionCmd can generate scripts for
+var CompletionShells = []string{"bash", "zsh", "fish"}
+
+// CompletionCmd creates a command that writes a completion script for root
+// to stdout, e.g. `source <(guest completion bash)`
+func CompletionCmd(root *cobra.Command) *cobra.Command {
+	return &cobra.Command{
+		Use:       "completion " + strings.Join(CompletionShells, "|"),
+		Short:     "Generate shell completion scripts",
+		Long:      fmt.Sprintf("Generate a completion script for %s. Completion of ids is done by querying the server.", strings.Join(CompletionShells, ", ")),
+		ValidArgs: CompletionShells,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) != 1 {
+				Fatal(ExitUsage, log.Fields{"num": len(args)}, "expected a shell")
+			}
+			if err := GenCompletion(os.Stdout, root, args[0]); err != nil {
+				Fatal(ExitError, log.Fields{
+					"error": err,
+					"shell": args[0],
+				}, "failed to generate completion")
+			}
+		},
+	}
+}
+
+// GenCompletion writes a completion script for root to w
+func GenCompletion(w io.Writer, root *cobra.Command, shell string) error {
+	switch shell {
+	case "bash":
+		return root.GenBashCompletion(w)
+	case "zsh":
+		return root.GenZshCompletion(w)
+	case "fish":
+		return root.GenFishCompletion(w, true)
+	}
+	return errors.New("unsupported shell")
+}
+
+// CompleteIDs creates a cobra.Command ValidArgsFunction that completes every
+// argument with the ids returned by list. Ids already given are not offered
+// again.
+func CompleteIDs(list func() ([]string, error)) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return completeIDs(list, 1)
+}
+
+// CompleteIDPairs is like CompleteIDs for commands whose arguments are
+// (<id> <value>) pairs. Only the ids are completed, and since an id may appear
+// in more than one pair, all of them are offered.
+func CompleteIDPairs(list func() ([]string, error)) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return completeIDs(list, 2)
+}
+
+func completeIDs(list func() ([]string, error), stride int) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args)%stride != 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		ids, err := list()
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+
+		given := map[string]bool{}
+		if stride == 1 {
+			for _, arg := range args {
+				given[arg] = true
+			}
+		}
+
+		completions := []string{}
+		for _, id := range ids {
+			if !given[id] && strings.HasPrefix(id, toComplete) {
+				completions = append(completions, id)
+			}
+		}
+		sort.Strings(completions)
+		return completions, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// ListIDs GETs a set of resources and returns their ids. Unlike the other
+// Client methods, failures are returned rather than fatal, so that it can be
+// used while completing.
+func (c *Client) ListIDs(endpoint string) ([]string, error) {
+	resp, err := c.c.Get(c.URLString(endpoint))
+	if err != nil {
+		return nil, err
+	}
+	defer logx.LogReturnedErr(resp.Body.Close, nil, "failed to close response body")
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	resources := []JMap{}
+	if err := json.NewDecoder(resp.Body).Decode(&resources); err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(resources))
+	for _, resource := range resources {
+		if id := resource.ID(); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
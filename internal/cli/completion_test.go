@@ -0,0 +1,90 @@
+package cli_test
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mistifyio/lochness/internal/cli"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestCompletion(t *testing.T) {
+	suite.Run(t, new(CompletionSuite))
+}
+
+type CompletionSuite struct {
+	suite.Suite
+}
+
+func listIDs() ([]string, error) {
+	return []string{"bbb", "abc", "abd"}, nil
+}
+
+func (s *CompletionSuite) TestCompleteIDs() {
+	complete := cli.CompleteIDs(listIDs)
+
+	ids, directive := complete(nil, []string{}, "")
+	s.Equal([]string{"abc", "abd", "bbb"}, ids)
+	s.Equal(cobra.ShellCompDirectiveNoFileComp, directive)
+
+	ids, _ = complete(nil, []string{}, "ab")
+	s.Equal([]string{"abc", "abd"}, ids)
+
+	ids, _ = complete(nil, []string{"abc"}, "ab")
+	s.Equal([]string{"abd"}, ids, "given ids should not be offered again")
+
+	_, directive = cli.CompleteIDs(func() ([]string, error) {
+		return nil, errors.New("unreachable")
+	})(nil, []string{}, "")
+	s.Equal(cobra.ShellCompDirectiveError, directive)
+}
+
+func (s *CompletionSuite) TestCompleteIDPairs() {
+	complete := cli.CompleteIDPairs(listIDs)
+
+	ids, _ := complete(nil, []string{}, "a")
+	s.Equal([]string{"abc", "abd"}, ids)
+
+	ids, directive := complete(nil, []string{"abc"}, "")
+	s.Empty(ids, "values should not be completed")
+	s.Equal(cobra.ShellCompDirectiveNoFileComp, directive)
+
+	ids, _ = complete(nil, []string{"abc", "{}"}, "a")
+	s.Equal([]string{"abc", "abd"}, ids, "ids may repeat")
+}
+
+func (s *CompletionSuite) TestListIDs() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/guests" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`[{"id":"foo"},{"id":"bar"},{"name":"noid"}]`))
+	}))
+	defer server.Close()
+
+	c := cli.NewClient(server.URL)
+	ids, err := c.ListIDs("guests")
+	s.NoError(err)
+	s.Equal([]string{"foo", "bar"}, ids)
+
+	_, err = c.ListIDs("missing")
+	s.Error(err)
+}
+
+func (s *CompletionSuite) TestCompletionCmd() {
+	root := &cobra.Command{Use: "test"}
+	cmd := cli.CompletionCmd(root)
+	s.Equal(cli.CompletionShells, cmd.ValidArgs)
+
+	for _, shell := range cli.CompletionShells {
+		buf := &bytes.Buffer{}
+		s.NoError(cli.GenCompletion(buf, root, shell), shell)
+		s.Contains(buf.String(), "test", shell)
+	}
+	s.Error(cli.GenCompletion(&bytes.Buffer{}, root, "tcsh"))
+}
@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	logx "github.com/mistifyio/mistify-logrus-ext"
+)
+
+// Event is a server-sent event, such as a change streamed from /events
+type Event struct {
+	ID   string
+	Type string
+	Data string
+}
+
+// ReadEvents reads the server-sent events of a stream, calling fn with each,
+// until the stream ends or fn returns false. Comments, such as the heartbeats
+// of idle streams, are skipped.
+func ReadEvents(r io.Reader, fn func(Event) bool) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	e := Event{}
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if len(data) > 0 || e.Type != "" {
+				e.Data = strings.Join(data, "\n")
+				if e.Type == "" {
+					e.Type = "message"
+				}
+				if !fn(e) {
+					return nil
+				}
+			}
+			e, data = Event{}, nil
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value := line, ""
+		if i := strings.Index(line, ":"); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "id":
+			e.ID = value
+		case "event":
+			e.Type = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+	return scanner.Err()
+}
+
+// StreamEvents streams the server-sent events of an endpoint to fn, as
+// ReadEvents does. Failures are returned rather than exiting, so that
+// commands can carry on without the stream.
+func (c *Client) StreamEvents(endpoint string, fn func(Event) bool) error {
+	resp, err := c.c.Get(c.URLString(endpoint))
+	if err != nil {
+		return err
+	}
+	defer logx.LogReturnedErr(resp.Body.Close, nil, "failed to close response body")
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to stream events: %s", resp.Status)
+	}
+	return ReadEvents(resp.Body, fn)
+}
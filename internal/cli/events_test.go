@@ -0,0 +1,79 @@
+package cli_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mistifyio/lochness/internal/cli"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestEvents(t *testing.T) {
+	suite.Run(t, new(EventsSuite))
+}
+
+type EventsSuite struct {
+	suite.Suite
+}
+
+const stream = `event: reset
+data: {}
+
+: heartbeat
+
+id: 1
+event: hypervisor
+data: {"action":"update",
+data: "subject":"foo"}
+
+data: plain
+
+`
+
+func (s *EventsSuite) TestReadEvents() {
+	events := []cli.Event{}
+	err := cli.ReadEvents(strings.NewReader(stream), func(e cli.Event) bool {
+		events = append(events, e)
+		return true
+	})
+	s.NoError(err)
+	s.Equal([]cli.Event{
+		{Type: "reset", Data: "{}"},
+		{ID: "1", Type: "hypervisor", Data: "{\"action\":\"update\",\n\"subject\":\"foo\"}"},
+		{Type: "message", Data: "plain"},
+	}, events)
+
+	count := 0
+	err = cli.ReadEvents(strings.NewReader(stream), func(cli.Event) bool {
+		count++
+		return false
+	})
+	s.NoError(err)
+	s.Equal(1, count, "reading should stop once fn returns false")
+}
+
+func (s *EventsSuite) TestStreamEvents() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("prefix") != "hypervisors" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, stream)
+	}))
+	defer server.Close()
+
+	c := cli.NewClient(server.URL)
+	c.SetVersion("")
+	count := 0
+	s.NoError(c.StreamEvents("events?prefix=hypervisors", func(cli.Event) bool {
+		count++
+		return true
+	}))
+	s.Equal(3, count)
+
+	s.Error(c.StreamEvents("events", func(cli.Event) bool { return true }), "failed streams should be returned")
+}
@@ -0,0 +1,103 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Exit codes of the cli tools, so scripts can tell failures apart
+const (
+	// ExitError is any failure not covered by another code
+	ExitError = 1
+	// ExitUsage is a bad command line: unknown flags, wrong number of
+	// arguments, or an invalid id
+	ExitUsage = 2
+	// ExitNotFound is a resource the server does not have
+	ExitNotFound = 3
+	// ExitValidation is a request the server rejected as invalid, or a spec
+	// that is not valid json
+	ExitValidation = 4
+	// ExitServer is a server that could not be reached, failed, or sent a
+	// response that could not be parsed
+	ExitServer = 5
+)
+
+// exitKinds names the exit codes in the error json
+var exitKinds = map[int]string{
+	ExitError:      "error",
+	ExitUsage:      "usage",
+	ExitNotFound:   "not_found",
+	ExitValidation: "validation",
+	ExitServer:     "server",
+}
+
+// Error is the json written to stderr as the last line of output of a failed
+// cli tool
+type Error struct {
+	Error    string                 `json:"error"`
+	ExitCode int                    `json:"exit_code"`
+	Message  string                 `json:"message"`
+	Status   int                    `json:"status,omitempty"`
+	Fields   map[string]interface{} `json:"fields,omitempty"`
+}
+
+// NewError creates the Error of a failure, with any error values in fields
+// turned into their messages
+func NewError(code int, fields log.Fields, msg string) *Error {
+	kind, ok := exitKinds[code]
+	if !ok {
+		kind = exitKinds[ExitError]
+	}
+	e := &Error{
+		Error:    kind,
+		ExitCode: code,
+		Message:  msg,
+	}
+	if len(fields) > 0 {
+		e.Fields = make(map[string]interface{}, len(fields))
+		for k, v := range fields {
+			if err, ok := v.(error); ok {
+				v = err.Error()
+			}
+			e.Fields[k] = v
+		}
+	}
+	if status, ok := fields["code"].(int); ok {
+		e.Status = status
+	}
+	return e
+}
+
+// Write writes the error as a line of json
+func (e *Error) Write(w io.Writer) error {
+	return json.NewEncoder(w).Encode(e)
+}
+
+// Fatal logs a failure, writes it as an Error on stderr, and exits with code
+func Fatal(code int, fields log.Fields, msg string) {
+	log.WithFields(fields).Error(msg)
+	if err := NewError(code, fields, msg).Write(os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, msg)
+	}
+	os.Exit(code)
+}
+
+// StatusExitCode returns the exit code for an http error response: not found,
+// validation for bad requests, and server for server errors
+func StatusExitCode(status int) int {
+	switch {
+	case status == http.StatusNotFound:
+		return ExitNotFound
+	case status == http.StatusBadRequest || status == http.StatusUnprocessableEntity:
+		return ExitValidation
+	case status >= 500:
+		return ExitServer
+	default:
+		return ExitError
+	}
+}
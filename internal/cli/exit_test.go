@@ -0,0 +1,74 @@
+package cli_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness/internal/cli"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestExit(t *testing.T) {
+	suite.Run(t, new(ExitSuite))
+}
+
+type ExitSuite struct {
+	suite.Suite
+}
+
+func (s *ExitSuite) TestNewError() {
+	e := cli.NewError(cli.ExitNotFound, log.Fields{
+		"error": errors.New("not found"),
+		"code":  http.StatusNotFound,
+		"id":    "foo",
+	}, "failed to get guest")
+	s.Equal("not_found", e.Error)
+	s.Equal(cli.ExitNotFound, e.ExitCode)
+	s.Equal("failed to get guest", e.Message)
+	s.Equal(http.StatusNotFound, e.Status)
+	s.Equal("not found", e.Fields["error"])
+	s.Equal("foo", e.Fields["id"])
+
+	e = cli.NewError(42, nil, "oops")
+	s.Equal("error", e.Error)
+	s.Equal(42, e.ExitCode)
+	s.Equal(0, e.Status)
+	s.Nil(e.Fields)
+}
+
+func (s *ExitSuite) TestWrite() {
+	buf := &bytes.Buffer{}
+	e := cli.NewError(cli.ExitUsage, log.Fields{"num": 3}, "expected an even number of args")
+	s.NoError(e.Write(buf))
+
+	line, err := buf.ReadString('\n')
+	s.NoError(err)
+	s.Equal(0, buf.Len(), "should be a single line")
+
+	out := map[string]interface{}{}
+	s.NoError(json.Unmarshal([]byte(line), &out))
+	s.Equal("usage", out["error"])
+	s.EqualValues(cli.ExitUsage, out["exit_code"])
+	s.Equal("expected an even number of args", out["message"])
+	s.NotContains(out, "status")
+	s.Equal(map[string]interface{}{"num": float64(3)}, out["fields"])
+}
+
+func (s *ExitSuite) TestStatusExitCode() {
+	tests := map[int]int{
+		http.StatusNotFound:            cli.ExitNotFound,
+		http.StatusBadRequest:          cli.ExitValidation,
+		http.StatusUnprocessableEntity: cli.ExitValidation,
+		http.StatusInternalServerError: cli.ExitServer,
+		http.StatusServiceUnavailable:  cli.ExitServer,
+		http.StatusConflict:            cli.ExitError,
+		http.StatusOK:                  cli.ExitError,
+	}
+	for status, code := range tests {
+		s.Equal(code, cli.StatusExitCode(status), http.StatusText(status))
+	}
+}
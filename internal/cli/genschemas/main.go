@@ -0,0 +1,41 @@
+// genschemas writes schemas.go, embedding the JSON Schemas of the entities for
+// the cli tools to validate specs with, without importing lochness. It is run
+// by go generate in the cli package.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+
+	"github.com/mistifyio/lochness"
+)
+
+func main() {
+	buf := &bytes.Buffer{}
+	fmt.Fprintln(buf, "// Code generated by genschemas; DO NOT EDIT.")
+	fmt.Fprintln(buf)
+	fmt.Fprintln(buf, "package cli")
+	fmt.Fprintln(buf)
+	fmt.Fprintln(buf, "// schemas are the JSON Schemas of the entities, by name, see lochness.EntitySchema")
+	fmt.Fprintln(buf, "var schemas = map[string]string{")
+	for _, name := range lochness.SchemaEntities() {
+		data, err := json.Marshal(lochness.EntitySchema(name))
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Fprintf(buf, "\t%q: %q,\n", name, data)
+	}
+	fmt.Fprintln(buf, "}")
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile("schemas.go", src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,231 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// ConfigEnv is the environment variable of the path of the config file
+	// of the clis, instead of ConfigPath's default
+	ConfigEnv = "LOCHNESS_CONFIG"
+	// ProfileEnv is the environment variable of the profile the clis use,
+	// unless given with --profile
+	ProfileEnv = "LOCHNESS_PROFILE"
+)
+
+// Outputs are the default output formats a Profile may set
+var Outputs = []string{"json", "text"}
+
+type (
+	// Config is the config file of the clis, a yaml file of named profiles,
+	// e.g.
+	//
+	//	default: staging
+	//	profiles:
+	//	  staging:
+	//	    server: https://lochnessd.staging.example.com
+	//	    ca-cert: /etc/lochness/staging-ca.pem
+	//	  prod:
+	//	    servers:
+	//	      guest: https://cguestd.example.com:18000
+	//	      hv: https://chypervisord.example.com:17000
+	//	    token: hunter2
+	//	    output: json
+	//
+	// Default is the profile used when none is selected.
+	Config struct {
+		Default  string              `yaml:"default"`
+		Profiles map[string]*Profile `yaml:"profiles"`
+	}
+
+	// Profile is a set of settings of the clis for a cluster. Server is the
+	// server of every cli, e.g. a lochnessd serving all the apis, and Servers
+	// that of each cli by its name, taking precedence. Output is the default
+	// output format, one of Outputs.
+	Profile struct {
+		Server  string            `yaml:"server"`
+		Servers map[string]string `yaml:"servers"`
+		Token   string            `yaml:"token"`
+		CACert  string            `yaml:"ca-cert"`
+		Pin     string            `yaml:"pin"`
+		Output  string            `yaml:"output"`
+	}
+
+	// ProfileOptions select the profile a cli uses, and its --token
+	ProfileOptions struct {
+		Name  string
+		Token string
+
+		cli   string
+		flags *pflag.FlagSet
+		once  sync.Once
+		err   error
+	}
+)
+
+// ConfigPath returns the path of the config file of the clis: that of
+// ConfigEnv, or lochness/config in $XDG_CONFIG_HOME, ~/.config by default
+func ConfigPath() string {
+	if path := os.Getenv(ConfigEnv); path != "" {
+		return path
+	}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "lochness", "config")
+}
+
+// LoadConfig reads a config file of the clis. A missing file is an empty
+// config. Unknown keys are errors, so that misspelled settings are not
+// silently ignored.
+func LoadConfig(path string) (*Config, error) {
+	config := &Config{}
+	if path == "" {
+		return config, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return config, nil
+		}
+		return nil, err
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(config); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	for name, profile := range config.Profiles {
+		if profile == nil {
+			config.Profiles[name] = &Profile{}
+			continue
+		}
+		if err := profile.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: profile %s: %s", path, name, err)
+		}
+	}
+	return config, nil
+}
+
+// Profile returns the named profile, or the Default one if name is blank. It
+// returns nil if neither is given, and an error naming the profiles there are
+// if the profile is not in the config.
+func (c *Config) Profile(name string) (*Profile, error) {
+	if name == "" {
+		name = c.Default
+	}
+	if name == "" {
+		return nil, nil
+	}
+	profile, ok := c.Profiles[name]
+	if !ok {
+		names := make([]string, 0, len(c.Profiles))
+		for n := range c.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown profile %q, must be one of: %s", name, strings.Join(names, ", "))
+	}
+	return profile, nil
+}
+
+// Validate checks the output format of a profile
+func (p *Profile) Validate() error {
+	if p.Output != "" && !hasString(Outputs, p.Output) {
+		return fmt.Errorf("invalid output %q, must be one of %s", p.Output, strings.Join(Outputs, ", "))
+	}
+	return nil
+}
+
+// ServerOf returns the server of the cli, "" if the profile has none
+func (p *Profile) ServerOf(cli string) string {
+	if server, ok := p.Servers[cli]; ok {
+		return server
+	}
+	return p.Server
+}
+
+// AddFlags adds the --profile and --token flags setting the options of the
+// cli with the flags, which Apply sets from the profile
+func (o *ProfileOptions) AddFlags(flags *pflag.FlagSet, cli string) {
+	o.cli = cli
+	o.flags = flags
+	flags.StringVar(&o.Name, "profile", o.Name, fmt.Sprintf("profile of the config file to use, instead of $%s or the default one", ProfileEnv))
+	flags.StringVar(&o.Token, "token", o.Token, "token to send the server as a bearer token")
+}
+
+// Apply sets the --server, --ca-cert, --pin, --json, and --token flags that
+// were not given on the command line from the selected profile of the config
+// file at ConfigPath, if there is one. It only applies the profile once, so
+// it may be called before any use of the flags.
+func (o *ProfileOptions) Apply() error {
+	o.once.Do(func() {
+		o.err = o.apply()
+	})
+	return o.err
+}
+
+func (o *ProfileOptions) apply() error {
+	name := o.Name
+	if name == "" {
+		name = os.Getenv(ProfileEnv)
+	}
+	config, err := LoadConfig(ConfigPath())
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		name = config.Default
+	}
+	profile, err := config.Profile(name)
+	if err != nil || profile == nil {
+		return err
+	}
+
+	settings := map[string]string{
+		"server":  profile.ServerOf(o.cli),
+		"ca-cert": profile.CACert,
+		"pin":     profile.Pin,
+		"token":   profile.Token,
+	}
+	if profile.Output != "" {
+		settings["json"] = fmt.Sprint(profile.Output == "json")
+	}
+	for flagName, value := range settings {
+		f := o.flags.Lookup(flagName)
+		if value == "" || f == nil || f.Changed {
+			continue
+		}
+		if err := f.Value.Set(value); err != nil {
+			return fmt.Errorf("profile %s: invalid %s %q: %s", name, flagName, value, err)
+		}
+	}
+	return nil
+}
+
+// hasString returns whether s is one of values
+func hasString(values []string, s string) bool {
+	for _, value := range values {
+		if value == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package cli_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mistifyio/lochness/internal/cli"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestProfile(t *testing.T) {
+	suite.Run(t, new(ProfileSuite))
+}
+
+type ProfileSuite struct {
+	suite.Suite
+	Dir  string
+	Path string
+}
+
+const testConfig = `default: staging
+profiles:
+  staging:
+    server: https://lochnessd.staging.example.com
+    ca-cert: /etc/lochness/staging-ca.pem
+  prod:
+    server: https://lochnessd.example.com
+    servers:
+      hv: https://chypervisord.example.com:17000
+    token: hunter2
+    output: json
+`
+
+func (s *ProfileSuite) SetupTest() {
+	var err error
+	s.Dir, err = ioutil.TempDir("", "lochness-cli-")
+	s.Require().NoError(err)
+	s.Path = filepath.Join(s.Dir, "config")
+	s.Require().NoError(ioutil.WriteFile(s.Path, []byte(testConfig), 0600))
+	s.Require().NoError(os.Setenv(cli.ConfigEnv, s.Path))
+	s.Require().NoError(os.Unsetenv(cli.ProfileEnv))
+}
+
+func (s *ProfileSuite) TearDownTest() {
+	_ = os.Unsetenv(cli.ConfigEnv)
+	_ = os.Unsetenv(cli.ProfileEnv)
+	_ = os.RemoveAll(s.Dir)
+}
+
+// flags returns the flags of a cli with profile options, parsed from args
+func (s *ProfileSuite) flags(cliName string, args ...string) (*pflag.FlagSet, *cli.ProfileOptions) {
+	flags := pflag.NewFlagSet(cliName, pflag.ContinueOnError)
+	flags.StringP("server", "s", "http://localhost:18000/", "")
+	flags.String("ca-cert", "", "")
+	flags.String("pin", "", "")
+	flags.BoolP("json", "j", false, "")
+	opts := &cli.ProfileOptions{}
+	opts.AddFlags(flags, cliName)
+	s.Require().NoError(flags.Parse(args))
+	return flags, opts
+}
+
+func (s *ProfileSuite) TestConfigPath() {
+	s.Equal(s.Path, cli.ConfigPath())
+
+	_ = os.Unsetenv(cli.ConfigEnv)
+	_ = os.Setenv("XDG_CONFIG_HOME", s.Dir)
+	defer func() { _ = os.Unsetenv("XDG_CONFIG_HOME") }()
+	s.Equal(filepath.Join(s.Dir, "lochness", "config"), cli.ConfigPath())
+}
+
+func (s *ProfileSuite) TestLoadConfig() {
+	config, err := cli.LoadConfig(s.Path)
+	s.Require().NoError(err)
+	s.Equal("staging", config.Default)
+	s.Len(config.Profiles, 2)
+
+	profile, err := config.Profile("")
+	s.Require().NoError(err)
+	s.Equal("https://lochnessd.staging.example.com", profile.ServerOf("guest"))
+
+	profile, err = config.Profile("prod")
+	s.Require().NoError(err)
+	s.Equal("https://lochnessd.example.com", profile.ServerOf("guest"))
+	s.Equal("https://chypervisord.example.com:17000", profile.ServerOf("hv"))
+
+	_, err = config.Profile("dev")
+	s.Error(err)
+
+	config, err = cli.LoadConfig(filepath.Join(s.Dir, "missing"))
+	s.NoError(err, "a missing config should be empty")
+	profile, err = config.Profile("")
+	s.NoError(err)
+	s.Nil(profile)
+
+	s.Require().NoError(ioutil.WriteFile(s.Path, []byte("profiles:\n  prod:\n    sever: https://lochnessd.example.com\n"), 0600))
+	_, err = cli.LoadConfig(s.Path)
+	s.Error(err, "unknown keys should be errors")
+
+	s.Require().NoError(ioutil.WriteFile(s.Path, []byte("profiles:\n  prod:\n    output: yaml\n"), 0600))
+	_, err = cli.LoadConfig(s.Path)
+	s.Error(err, "unknown outputs should be errors")
+}
+
+func (s *ProfileSuite) TestApply() {
+	flags, opts := s.flags("guest")
+	s.Require().NoError(opts.Apply())
+	server, _ := flags.GetString("server")
+	caCert, _ := flags.GetString("ca-cert")
+	s.Equal("https://lochnessd.staging.example.com", server, "the default profile should be used")
+	s.Equal("/etc/lochness/staging-ca.pem", caCert)
+
+	_ = os.Setenv(cli.ProfileEnv, "prod")
+	flags, opts = s.flags("hv", "--json=false")
+	s.Require().NoError(opts.Apply())
+	server, _ = flags.GetString("server")
+	jsonout, _ := flags.GetBool("json")
+	s.Equal("https://chypervisord.example.com:17000", server)
+	s.Equal("hunter2", opts.Token)
+	s.False(jsonout, "flags given should take precedence")
+
+	flags, opts = s.flags("guest", "--profile", "staging", "-s", "http://localhost:9")
+	s.Require().NoError(opts.Apply())
+	server, _ = flags.GetString("server")
+	s.Equal("http://localhost:9", server)
+	s.Equal("", opts.Token, "--profile should take precedence over the environment")
+
+	_, opts = s.flags("guest", "--profile", "dev")
+	s.Error(opts.Apply())
+}
@@ -0,0 +1,13 @@
+// Code generated by genschemas; DO NOT EDIT.
+
+package cli
+
+// schemas are the JSON Schemas of the entities, by name, see lochness.EntitySchema
+var schemas = map[string]string{
+	"flavor":     "{\"$schema\":\"http://json-schema.org/draft-07/schema#\",\"title\":\"Flavor\",\"type\":\"object\",\"properties\":{\"cpu\":{\"type\":\"integer\",\"minimum\":0},\"disk\":{\"type\":\"integer\",\"minimum\":0},\"disk_iops\":{\"type\":\"integer\",\"minimum\":0},\"disk_iops_burst\":{\"type\":\"integer\",\"minimum\":0},\"id\":{\"type\":\"string\",\"format\":\"uuid\"},\"image\":{\"type\":\"string\",\"format\":\"uuid\"},\"memory\":{\"type\":\"integer\",\"minimum\":0},\"metadata\":{\"type\":\"object\",\"additionalProperties\":{\"type\":\"string\"}},\"network_bandwidth\":{\"type\":\"integer\",\"minimum\":0}},\"required\":[\"image\"],\"additionalProperties\":false}",
+	"fwgroup":    "{\"$schema\":\"http://json-schema.org/draft-07/schema#\",\"title\":\"FWGroup\",\"type\":\"object\",\"properties\":{\"id\":{\"type\":\"string\",\"format\":\"uuid\"},\"includes\":{\"type\":\"array\",\"items\":{\"type\":\"string\",\"format\":\"uuid\"}},\"metadata\":{\"type\":\"object\",\"additionalProperties\":{\"type\":\"string\"}},\"profile\":{\"type\":\"object\",\"readOnly\":true,\"properties\":{\"created\":{\"type\":\"string\",\"format\":\"date-time\"},\"name\":{\"type\":\"string\"},\"tenant\":{\"type\":\"string\"}},\"additionalProperties\":false},\"rules\":{\"type\":\"array\",\"items\":{\"type\":\"object\",\"properties\":{\"action\":{\"type\":\"string\"},\"group\":{\"type\":\"string\",\"format\":\"uuid\"},\"portEnd\":{\"type\":\"integer\",\"minimum\":0},\"portStart\":{\"type\":\"integer\",\"minimum\":0},\"protocol\":{\"type\":\"string\"},\"source\":{\"type\":\"string\",\"format\":\"cidr\"}},\"additionalProperties\":false}}},\"additionalProperties\":false}",
+	"guest":      "{\"$schema\":\"http://json-schema.org/draft-07/schema#\",\"title\":\"Guest\",\"type\":\"object\",\"properties\":{\"anti_affinity\":{\"type\":\"string\"},\"bridge\":{\"type\":\"string\"},\"clone_of\":{\"type\":\"string\",\"format\":\"uuid\",\"readOnly\":true},\"clone_snapshot\":{\"type\":\"string\",\"readOnly\":true},\"constraints\":{\"type\":\"string\"},\"data_encoding\":{\"type\":\"string\",\"enum\":[\"raw\",\"base64\"]},\"deleted\":{\"type\":\"object\",\"readOnly\":true,\"properties\":{\"deleted\":{\"type\":\"string\",\"format\":\"date-time\"},\"purge\":{\"type\":\"string\",\"format\":\"date-time\"},\"purge_job\":{\"type\":\"string\"}},\"additionalProperties\":false},\"dns_name\":{\"type\":\"string\"},\"flavor\":{\"type\":\"string\",\"format\":\"uuid\"},\"fwgroup\":{\"type\":\"string\",\"format\":\"uuid\"},\"hypervisor\":{\"type\":\"string\",\"format\":\"uuid\",\"readOnly\":true},\"id\":{\"type\":\"string\",\"format\":\"uuid\"},\"image\":{\"type\":\"string\",\"format\":\"uuid\"},\"ip\":{\"type\":\"string\",\"format\":\"ip\"},\"mac\":{\"type\":\"string\",\"format\":\"mac\"},\"metadata\":{\"type\":\"object\",\"additionalProperties\":{\"type\":\"string\"}},\"network\":{\"type\":\"string\",\"format\":\"uuid\"},\"resize_flavor\":{\"type\":\"string\",\"format\":\"uuid\",\"readOnly\":true},\"secrets\":{\"type\":\"object\",\"additionalProperties\":{\"type\":\"string\",\"format\":\"uuid\"}},\"subnet\":{\"type\":\"string\",\"format\":\"uuid\"},\"type\":{\"type\":\"string\"},\"user_data\":{\"type\":\"string\"},\"vendor_data\":{\"type\":\"string\"},\"vlangroup\":{\"type\":\"string\",\"format\":\"uuid\"}},\"required\":[\"flavor\",\"network\"],\"additionalProperties\":false}",
+	"hypervisor": "{\"$schema\":\"http://json-schema.org/draft-07/schema#\",\"title\":\"Hypervisor\",\"type\":\"object\",\"properties\":{\"available_resources\":{\"type\":\"object\",\"readOnly\":true,\"properties\":{\"cpu\":{\"type\":\"integer\",\"minimum\":0},\"disk\":{\"type\":\"integer\",\"minimum\":0},\"memory\":{\"type\":\"integer\",\"minimum\":0}},\"additionalProperties\":false},\"bmc\":{\"type\":\"object\",\"properties\":{\"address\":{\"type\":\"string\"},\"protocol\":{\"type\":\"string\",\"enum\":[\"ipmi\",\"redfish\"]}},\"required\":[\"address\",\"protocol\"],\"additionalProperties\":false},\"deleted\":{\"type\":\"object\",\"readOnly\":true,\"properties\":{\"deleted\":{\"type\":\"string\",\"format\":\"date-time\"},\"purge\":{\"type\":\"string\",\"format\":\"date-time\"},\"purge_job\":{\"type\":\"string\"}},\"additionalProperties\":false},\"failure_domain\":{\"type\":\"object\",\"properties\":{\"rack\":{\"type\":\"string\"},\"row\":{\"type\":\"string\"},\"zone\":{\"type\":\"string\"}},\"additionalProperties\":false},\"gateway\":{\"type\":\"string\",\"format\":\"ip\"},\"id\":{\"type\":\"string\",\"format\":\"uuid\"},\"ip\":{\"type\":\"string\",\"format\":\"ip\"},\"mac\":{\"type\":\"string\",\"format\":\"mac\"},\"maintenance\":{\"type\":\"boolean\"},\"metadata\":{\"type\":\"object\",\"additionalProperties\":{\"type\":\"string\"}},\"netmask\":{\"type\":\"string\",\"format\":\"ip\"},\"secrets\":{\"type\":\"object\",\"additionalProperties\":{\"type\":\"string\",\"format\":\"uuid\"}},\"total_resources\":{\"type\":\"object\",\"properties\":{\"cpu\":{\"type\":\"integer\",\"minimum\":0},\"disk\":{\"type\":\"integer\",\"minimum\":0},\"memory\":{\"type\":\"integer\",\"minimum\":0}},\"additionalProperties\":false}},\"required\":[\"ip\",\"mac\"],\"additionalProperties\":false}",
+	"network":    "{\"$schema\":\"http://json-schema.org/draft-07/schema#\",\"title\":\"Network\",\"type\":\"object\",\"properties\":{\"id\":{\"type\":\"string\",\"format\":\"uuid\"},\"metadata\":{\"type\":\"object\",\"additionalProperties\":{\"type\":\"string\"}},\"vlan_ranges\":{\"type\":\"array\",\"items\":{\"type\":\"object\",\"properties\":{\"end\":{\"type\":\"integer\"},\"start\":{\"type\":\"integer\"}},\"additionalProperties\":false}},\"vlangroup\":{\"type\":\"string\",\"format\":\"uuid\"}},\"additionalProperties\":false}",
+	"subnet":     "{\"$schema\":\"http://json-schema.org/draft-07/schema#\",\"title\":\"Subnet\",\"type\":\"object\",\"properties\":{\"cidr\":{\"type\":\"string\",\"format\":\"cidr\"},\"end\":{\"type\":\"string\",\"format\":\"ip\"},\"gateway\":{\"type\":\"string\",\"format\":\"ip\"},\"id\":{\"type\":\"string\",\"format\":\"uuid\"},\"metadata\":{\"type\":\"object\",\"additionalProperties\":{\"type\":\"string\"}},\"network\":{\"type\":\"string\",\"format\":\"uuid\"},\"start\":{\"type\":\"string\",\"format\":\"ip\"},\"vlan\":{\"type\":\"integer\",\"readOnly\":true}},\"required\":[\"cidr\",\"end\",\"start\"],\"additionalProperties\":false}",
+}
@@ -0,0 +1,101 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// Keys that commands on a Screen commonly handle
+const (
+	KeyCtrlC     = 3
+	KeyEnter     = '\r'
+	KeyEscape    = 27
+	KeyBackspace = 127
+)
+
+// Screen is a terminal taken over by a command that redraws it in place, such
+// as a dashboard, with keys read as they are pressed
+type Screen struct {
+	in    *os.File
+	out   *os.File
+	state *term.State
+}
+
+// IsTerminal returns whether both in and out are terminals, as a Screen needs
+func IsTerminal(in, out *os.File) bool {
+	return term.IsTerminal(int(in.Fd())) && term.IsTerminal(int(out.Fd()))
+}
+
+// NewScreen takes over the terminal of in and out until Close: in is put in
+// raw mode and out switched to its alternate screen, so that what was on it is
+// restored afterwards
+func NewScreen(in, out *os.File) (*Screen, error) {
+	state, err := term.MakeRaw(int(in.Fd()))
+	if err != nil {
+		return nil, err
+	}
+	// alternate screen, hidden cursor
+	fmt.Fprint(out, "\x1b[?1049h\x1b[?25l")
+	return &Screen{in: in, out: out, state: state}, nil
+}
+
+// Close gives the terminal back
+func (s *Screen) Close() error {
+	fmt.Fprint(s.out, "\x1b[?25h\x1b[?1049l")
+	return term.Restore(int(s.in.Fd()), s.state)
+}
+
+// Size returns the width and height of the screen, 80x24 if unknown
+func (s *Screen) Size() (int, int) {
+	width, height, err := term.GetSize(int(s.out.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		return 80, 24
+	}
+	return width, height
+}
+
+// Draw replaces what is on the screen with lines, cut to fit it
+func (s *Screen) Draw(lines []string) error {
+	width, height := s.Size()
+	if len(lines) > height {
+		lines = lines[:height]
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("\x1b[H")
+	for i, line := range lines {
+		if runes := []rune(line); len(runes) > width {
+			line = string(runes[:width])
+		}
+		buf.WriteString(line)
+		buf.WriteString("\x1b[K")
+		if i < len(lines)-1 {
+			buf.WriteString("\r\n")
+		}
+	}
+	buf.WriteString("\x1b[J")
+	_, err := s.out.Write(buf.Bytes())
+	return err
+}
+
+// Keys returns the keys pressed, as they are. The channel is closed once the
+// input ends.
+func (s *Screen) Keys() <-chan rune {
+	keys := make(chan rune)
+	go func() {
+		defer close(keys)
+		r := bufio.NewReader(s.in)
+		for {
+			key, _, err := r.ReadRune()
+			if err != nil {
+				return
+			}
+			keys <- key
+		}
+	}()
+	return keys
+}
@@ -0,0 +1,43 @@
+package cli
+
+//go:generate go run ./genschemas
+
+import (
+	"encoding/json"
+
+	"github.com/mistifyio/lochness/pkg/jsonschema"
+)
+
+// Schema returns the JSON Schema of an entity, e.g. "guest", as embedded when
+// the cli tools were built, or nil if it has none
+func Schema(entity string) *jsonschema.Schema {
+	data, ok := schemas[entity]
+	if !ok {
+		return nil
+	}
+	schema := &jsonschema.Schema{}
+	if err := json.Unmarshal([]byte(data), schema); err != nil {
+		return nil
+	}
+	return schema
+}
+
+// CheckSpec checks a json spec against the JSON Schema of an entity without
+// sending it anywhere, returning what is wrong with it, by field, in order,
+// or nothing if it is valid. Fields the entity does not have are reported, as
+// they are ignored by the servers and most likely misspelled.
+func CheckSpec(entity, spec string) []string {
+	var v interface{}
+	if err := json.Unmarshal([]byte(spec), &v); err != nil {
+		return []string{"invalid json: " + err.Error()}
+	}
+	schema := Schema(entity)
+	if schema == nil {
+		return []string{"no schema for " + entity}
+	}
+	problems := schema.Validate(v)
+	if problems == nil {
+		problems = []string{}
+	}
+	return problems
+}
@@ -0,0 +1,62 @@
+package cli_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/cli"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestSpec(t *testing.T) {
+	suite.Run(t, new(SpecSuite))
+}
+
+type SpecSuite struct {
+	suite.Suite
+}
+
+func (s *SpecSuite) TestSchema() {
+	for _, entity := range lochness.SchemaEntities() {
+		expected, err := json.Marshal(lochness.EntitySchema(entity))
+		s.Require().NoError(err)
+		schema := cli.Schema(entity)
+		if !s.NotNil(schema, entity) {
+			continue
+		}
+		actual, err := json.Marshal(schema)
+		s.Require().NoError(err)
+		s.JSONEq(string(expected), string(actual), entity+" schema is out of date, run go generate")
+	}
+	s.Nil(cli.Schema("foobar"))
+}
+
+func (s *SpecSuite) TestCheckSpec() {
+	id := "8d3e9ee0-4b5c-4a9c-93c4-47b8b2a5d9b1"
+	tests := []struct {
+		description string
+		spec        string
+		expected    []string
+	}{
+		{"minimal", `{"flavor":"` + id + `","network":"` + id + `"}`, []string{}},
+		{"full", `{"id":"` + id + `","flavor":"` + id + `","network":"` + id + `","ip":"10.0.0.1","mac":"01:23:45:67:89:ab","metadata":{"a":"b"},"secrets":{"root":"` + id + `"},"data_encoding":"raw"}`, []string{}},
+		{"not json", `{"flavor":`, []string{"invalid json: unexpected end of JSON input"}},
+		{"missing", `{}`, []string{"flavor: missing", "network: missing"}},
+		{"empty", `{"flavor":"","network":null}`, []string{"flavor: missing", "network: missing"}},
+		{"invalid", `{"flavor":"foo","network":"` + id + `","ip":"10.0.0","mac":"foo","metadata":{"a":1},"secrets":{"root":"foo"},"data_encoding":"hex"}`, []string{
+			"data_encoding: must be one of raw, base64",
+			"flavor: invalid uuid",
+			"ip: invalid ip",
+			"mac: invalid mac",
+			"metadata.a: must be a string",
+			"secrets.root: invalid uuid",
+		}},
+		{"wrong type", `{"flavor":1,"network":"` + id + `","metadata":[]}`, []string{"flavor: must be a string", "metadata: must be an object"}},
+		{"unknown", `{"flavor":"` + id + `","network":"` + id + `","flavour":"x"}`, []string{"flavour: unknown field"}},
+	}
+	for _, test := range tests {
+		s.Equal(test.expected, cli.CheckSpec("guest", test.spec), test.description)
+	}
+	s.Equal([]string{"no schema for foobar"}, cli.CheckSpec("foobar", `{}`))
+}
@@ -0,0 +1,168 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/pflag"
+)
+
+// Column is a column of a table of resources
+type Column struct {
+	Header string
+	// Key is the path of the column's value in a resource, with the keys of
+	// nested objects separated by dots, e.g. "metadata.name"
+	Key string
+}
+
+// TableOptions are the table output options that the clis share
+type TableOptions struct {
+	Table    bool
+	Sort     string
+	NoHeader bool
+}
+
+// AddFlags adds the --table, --sort, and --no-header flags setting the options
+func (o *TableOptions) AddFlags(flags *pflag.FlagSet) {
+	flags.BoolVar(&o.Table, "table", o.Table, "output in a table")
+	o.AddSortFlags(flags)
+}
+
+// AddSortFlags adds the --sort and --no-header flags setting the options, for
+// commands that always output a table
+func (o *TableOptions) AddSortFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&o.Sort, "sort", o.Sort, "column to sort the table by, descending if prefixed with -")
+	flags.BoolVar(&o.NoHeader, "no-header", o.NoHeader, "omit the header row of the table")
+}
+
+// Table renders resources in aligned columns, one row per resource
+type Table []Column
+
+// Print writes the resources as a table, sorted as set by the options.
+// Missing values are written as "-".
+func (t Table) Print(w io.Writer, rows []JMap, o TableOptions) error {
+	if o.Sort != "" {
+		if err := t.Sort(rows, o.Sort); err != nil {
+			return err
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	if !o.NoHeader {
+		headers := make([]string, len(t))
+		for i, col := range t {
+			headers[i] = col.Header
+		}
+		fmt.Fprintln(tw, strings.Join(headers, "\t"))
+	}
+	for _, row := range rows {
+		cells := make([]string, len(t))
+		for i, col := range t {
+			cells[i] = formatCell(row.Lookup(col.Key))
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	return tw.Flush()
+}
+
+// column finds a column by its header or key, ignoring case
+func (t Table) column(name string) (Column, bool) {
+	for _, col := range t {
+		if strings.EqualFold(col.Header, name) || strings.EqualFold(col.Key, name) {
+			return col, true
+		}
+	}
+	return Column{}, false
+}
+
+// Sort sorts the rows by a column, given by its header or key, descending if
+// it is prefixed with -. Rows missing the value sort last.
+func (t Table) Sort(rows []JMap, by string) error {
+	desc := strings.HasPrefix(by, "-")
+	col, ok := t.column(strings.TrimPrefix(by, "-"))
+	if !ok {
+		headers := make([]string, len(t))
+		for i, col := range t {
+			headers[i] = strings.ToLower(col.Header)
+		}
+		return fmt.Errorf("unknown sort column %q, must be one of %s", by, strings.Join(headers, ", "))
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := rows[i].Lookup(col.Key), rows[j].Lookup(col.Key)
+		if missing(a) || missing(b) {
+			return !missing(a)
+		}
+		if desc {
+			return lessCell(b, a)
+		}
+		return lessCell(a, b)
+	})
+	return nil
+}
+
+// Lookup returns the value at a path of keys separated by dots, or nil if
+// there is none
+func (j JMap) Lookup(path string) interface{} {
+	var value interface{} = map[string]interface{}(j)
+	for _, key := range strings.Split(path, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = m[key]
+	}
+	return value
+}
+
+// formatCell formats a value for a table cell
+func formatCell(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "-"
+	case string:
+		if v == "" {
+			return "-"
+		}
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		buf, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(buf)
+	}
+}
+
+// missing returns whether a value is missing, and sorts last
+func missing(value interface{}) bool {
+	return value == nil || value == ""
+}
+
+// lessCell compares two values for sorting. Numbers and ips compare by value,
+// anything else by its formatted text.
+func lessCell(a, b interface{}) bool {
+	if x, ok := a.(float64); ok {
+		if y, ok := b.(float64); ok {
+			return x < y
+		}
+	}
+	if x, ok := a.(string); ok {
+		if y, ok := b.(string); ok {
+			xIP, yIP := net.ParseIP(x), net.ParseIP(y)
+			if xIP != nil && yIP != nil {
+				return bytes.Compare(xIP.To16(), yIP.To16()) < 0
+			}
+		}
+	}
+	return formatCell(a) < formatCell(b)
+}
@@ -0,0 +1,90 @@
+package cli_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mistifyio/lochness/internal/cli"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestTable(t *testing.T) {
+	suite.Run(t, new(TableSuite))
+}
+
+type TableSuite struct {
+	suite.Suite
+}
+
+var table = cli.Table{
+	{Header: "ID", Key: "id"},
+	{Header: "NAME", Key: "metadata.name"},
+	{Header: "IP", Key: "ip"},
+	{Header: "MEMORY", Key: "resources.memory"},
+}
+
+func rows() []cli.JMap {
+	return []cli.JMap{
+		{"id": "a", "metadata": map[string]interface{}{"name": "web"}, "ip": "10.0.0.10", "resources": map[string]interface{}{"memory": float64(1024)}},
+		{"id": "b", "metadata": map[string]interface{}{}, "ip": "10.0.0.9", "resources": map[string]interface{}{"memory": float64(256)}},
+		{"id": "c", "metadata": map[string]interface{}{"name": "db"}, "ip": nil, "resources": map[string]interface{}{"memory": float64(4096)}},
+	}
+}
+
+func (s *TableSuite) TestLookup() {
+	j := rows()[0]
+	s.Equal("web", j.Lookup("metadata.name"))
+	s.Equal(float64(1024), j.Lookup("resources.memory"))
+	s.Nil(j.Lookup("metadata.name.first"))
+	s.Nil(j.Lookup("missing"))
+}
+
+func (s *TableSuite) TestPrint() {
+	var buf bytes.Buffer
+	s.NoError(table.Print(&buf, rows(), cli.TableOptions{}))
+	s.Equal(`ID  NAME  IP         MEMORY
+a   web   10.0.0.10  1024
+b   -     10.0.0.9   256
+c   db    -          4096
+`, buf.String())
+
+	buf.Reset()
+	s.NoError(table.Print(&buf, rows()[:1], cli.TableOptions{NoHeader: true}))
+	s.Equal("a  web  10.0.0.10  1024\n", buf.String())
+}
+
+func (s *TableSuite) TestSort() {
+	tests := []struct {
+		description string
+		sort        string
+		expected    []string
+	}{
+		{"header", "name", []string{"c", "a", "b"}},
+		{"key", "metadata.name", []string{"c", "a", "b"}},
+		{"descending", "-name", []string{"a", "c", "b"}},
+		{"numbers", "memory", []string{"b", "a", "c"}},
+		{"ips", "ip", []string{"b", "a", "c"}},
+	}
+
+	for _, test := range tests {
+		r := rows()
+		var buf bytes.Buffer
+		s.NoError(table.Print(&buf, r, cli.TableOptions{Sort: test.sort}), test.description)
+		ids := make([]string, len(r))
+		for i, row := range r {
+			ids[i] = row.ID()
+		}
+		s.Equal(test.expected, ids, test.description)
+	}
+
+	s.Error(table.Print(&bytes.Buffer{}, rows(), cli.TableOptions{Sort: "color"}), "unknown column should fail")
+}
+
+func (s *TableSuite) TestAddFlags() {
+	var o cli.TableOptions
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	o.AddFlags(flags)
+	s.NoError(flags.Parse([]string{"--table", "--sort", "-ip", "--no-header"}))
+	s.Equal(cli.TableOptions{Table: true, Sort: "-ip", NoHeader: true}, o)
+}
@@ -10,9 +10,9 @@ import (
 // AssertID checks whether a string is a valid id
 func AssertID(id string) {
 	if uuid := uuid.Parse(id); uuid == nil {
-		log.WithFields(log.Fields{
+		Fatal(ExitUsage, log.Fields{
 			"id": id,
-		}).Fatal("invalid id")
+		}, "invalid id")
 	}
 }
 
@@ -20,9 +20,9 @@ func AssertID(id string) {
 func AssertSpec(spec string) {
 	j := JMap{}
 	if err := json.Unmarshal([]byte(spec), &j); err != nil {
-		log.WithFields(log.Fields{
+		Fatal(ExitValidation, log.Fields{
 			"spec":  spec,
 			"error": err,
-		}).Fatal("invalid spec")
+		}, "invalid spec")
 	}
 }
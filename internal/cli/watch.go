@@ -0,0 +1,70 @@
+package cli
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Types of Change
+const (
+	ChangeCreated = "created"
+	ChangeUpdated = "updated"
+	ChangeDeleted = "deleted"
+)
+
+// Change is a resource that was created, updated, or deleted between two
+// listings
+type Change struct {
+	Type     string
+	Resource JMap
+}
+
+// Changes returns the changes from the resources of prev to those of cur,
+// matched by id and sorted by it
+func Changes(prev, cur []JMap) []Change {
+	before := make(map[string]JMap, len(prev))
+	for _, j := range prev {
+		before[j.ID()] = j
+	}
+
+	changes := []Change{}
+	seen := make(map[string]bool, len(cur))
+	for _, j := range cur {
+		id := j.ID()
+		seen[id] = true
+		old, ok := before[id]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Type: ChangeCreated, Resource: j})
+		case !reflect.DeepEqual(old, j):
+			changes = append(changes, Change{Type: ChangeUpdated, Resource: j})
+		}
+	}
+	for _, j := range prev {
+		if !seen[j.ID()] {
+			changes = append(changes, Change{Type: ChangeDeleted, Resource: j})
+		}
+	}
+
+	sort.Slice(changes, func(i, k int) bool {
+		return changes[i].Resource.ID() < changes[k].Resource.ID()
+	})
+	return changes
+}
+
+// Print prints the change as of at, either as json with the resource under
+// title, or as a line of the time, type, and id
+func (c Change) Print(json bool, title string, at time.Time) {
+	if json {
+		JMap{
+			"type": c.Type,
+			"time": at.UTC().Format(time.RFC3339),
+			"id":   c.Resource.ID(),
+			title:  c.Resource,
+		}.Print(true)
+		return
+	}
+	fmt.Println(at.Format(time.RFC3339), c.Type, c.Resource.ID())
+}
@@ -0,0 +1,39 @@
+package cli_test
+
+import (
+	"testing"
+
+	"github.com/mistifyio/lochness/internal/cli"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestWatch(t *testing.T) {
+	suite.Run(t, new(WatchSuite))
+}
+
+type WatchSuite struct {
+	suite.Suite
+}
+
+func (s *WatchSuite) TestChanges() {
+	prev := []cli.JMap{
+		{"id": "a", "state": "running"},
+		{"id": "b", "state": "running"},
+		{"id": "c", "state": "running"},
+	}
+	cur := []cli.JMap{
+		{"id": "d", "state": "new"},
+		{"id": "a", "state": "running"},
+		{"id": "b", "state": "shutdown"},
+	}
+
+	changes := cli.Changes(prev, cur)
+	s.Equal([]cli.Change{
+		{Type: cli.ChangeUpdated, Resource: cur[2]},
+		{Type: cli.ChangeDeleted, Resource: prev[2]},
+		{Type: cli.ChangeCreated, Resource: cur[0]},
+	}, changes)
+
+	s.Empty(cli.Changes(cur, cur))
+	s.Len(cli.Changes(nil, cur), 3)
+}
@@ -0,0 +1,216 @@
+/*
+Package config loads the settings of the lochness daemons from a config file
+and the environment, beneath their command line flags. Settings are named after
+the flags, so that a deployment can keep a config file per daemon and still
+override any setting of it.
+
+A setting comes from, in order of precedence: the command line, the daemon's
+environment variable, e.g. CGUESTD_KV_PREFIX for --kv-prefix of cguestd, the
+--config-file, and the default of the flag. The config file may be yaml
+
+	kv: http://etcd.local:4001
+	kv_prefix: /lochness
+	log-level: info
+
+or flat toml, without tables
+
+	kv = "http://etcd.local:4001"
+	kv_prefix = "/lochness"
+	log-level = "info"
+
+and may also be given by the CGUESTD_CONFIG_FILE environment variable. Lists
+are joined with commas, as slice flags expect.
+*/
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	flag "github.com/ogier/pflag"
+	spflag "github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// FlagName is the name of the flag of the config file
+	FlagName = "config-file"
+
+	// FlagUsage is the usage of the flag of the config file
+	FlagUsage = "yaml or toml file of flag settings, overridden by the environment and the command line"
+)
+
+// FlagSet is the flags of a daemon
+type FlagSet interface {
+	// Flags returns the names of the flags, and whether each was set on the
+	// command line
+	Flags() map[string]bool
+	// Set sets the value of a flag, as if given on the command line
+	Set(name, value string) error
+}
+
+type pflagSet struct {
+	*flag.FlagSet
+}
+
+// Pflag adapts a flag set of github.com/ogier/pflag
+func Pflag(fs *flag.FlagSet) FlagSet {
+	return pflagSet{fs}
+}
+
+func (fs pflagSet) Flags() map[string]bool {
+	names := make(map[string]bool)
+	fs.VisitAll(func(f *flag.Flag) {
+		names[f.Name] = false
+	})
+	fs.Visit(func(f *flag.Flag) {
+		names[f.Name] = true
+	})
+	return names
+}
+
+type spflagSet struct {
+	*spflag.FlagSet
+}
+
+// SPFlag adapts a flag set of github.com/spf13/pflag
+func SPFlag(fs *spflag.FlagSet) FlagSet {
+	return spflagSet{fs}
+}
+
+func (fs spflagSet) Flags() map[string]bool {
+	names := make(map[string]bool)
+	fs.VisitAll(func(f *spflag.Flag) {
+		names[f.Name] = f.Changed
+	})
+	return names
+}
+
+// EnvName returns the environment variable of a daemon's flag, e.g.
+// CGUESTD_KV_PREFIX for the --kv-prefix flag of cguestd
+func EnvName(daemon, flagName string) string {
+	return strings.ToUpper(strings.Replace(daemon+"_"+flagName, "-", "_", -1))
+}
+
+// Load sets the flags of a daemon that were not given on the command line from
+// its environment variables, see EnvName, or else from its config file. The
+// config file may also be given by the environment variable of FlagName. Keys
+// of the config file are flag names, with either dashes or underscores. Unknown
+// keys and invalid values are errors, naming the file or variable.
+func Load(fs FlagSet, daemon, path string) error {
+	if path == "" {
+		path = os.Getenv(EnvName(daemon, FlagName))
+	}
+
+	names := fs.Flags()
+	settings := map[string]string{}
+	if path != "" {
+		var err error
+		if settings, err = readFile(path); err != nil {
+			return err
+		}
+
+		var unknown []string
+		for name := range settings {
+			if _, ok := names[name]; !ok || name == FlagName {
+				unknown = append(unknown, name)
+			}
+		}
+		if len(unknown) > 0 {
+			sort.Strings(unknown)
+			return fmt.Errorf("%s: unknown settings %s", path, strings.Join(unknown, ", "))
+		}
+	}
+
+	for name, set := range names {
+		if set || name == FlagName {
+			continue
+		}
+
+		source := path
+		value, ok := os.LookupEnv(EnvName(daemon, name))
+		if ok {
+			source = EnvName(daemon, name)
+		} else if value, ok = settings[name]; !ok {
+			continue
+		}
+
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("%s: invalid %s %q: %s", source, name, value, err)
+		}
+	}
+	return nil
+}
+
+// readFile reads the flag settings of a yaml or toml config file
+func readFile(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := map[string]interface{}{}
+	switch ext := filepath.Ext(path); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		raw, err = parseTOML(data)
+	default:
+		return nil, fmt.Errorf("%s: unknown config file type %q, must be .yaml, .yml, or .toml", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	settings := make(map[string]string, len(raw))
+	for key, value := range raw {
+		name := strings.Replace(key, "_", "-", -1)
+		s, err := formatValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid %s: %s", path, key, err)
+		}
+		settings[name] = s
+	}
+	return settings, nil
+}
+
+// formatValue formats a setting as a flag value. Lists are comma separated, as
+// for slice flags.
+func formatValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			if _, ok := item.([]interface{}); ok {
+				return "", fmt.Errorf("lists can not be nested")
+			}
+			s, err := formatValue(item)
+			if err != nil {
+				return "", err
+			}
+			items[i] = s
+		}
+		return strings.Join(items, ","), nil
+	case nil:
+		return "", nil
+	default:
+		return "", fmt.Errorf("must be a string, number, boolean, or list, not %T", value)
+	}
+}
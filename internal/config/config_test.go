@@ -0,0 +1,178 @@
+package config_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/mistifyio/lochness/internal/config"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestConfig(t *testing.T) {
+	suite.Run(t, new(ConfigSuite))
+}
+
+type ConfigSuite struct {
+	suite.Suite
+	Dir   string
+	Flags *fakeFlags
+}
+
+// fakeFlags is a flag set that records the values set
+type fakeFlags struct {
+	set    map[string]bool
+	values map[string]string
+}
+
+func (f *fakeFlags) Flags() map[string]bool {
+	return f.set
+}
+
+func (f *fakeFlags) Set(name, value string) error {
+	if name == "port" {
+		if _, err := strconv.Atoi(value); err != nil {
+			return err
+		}
+	}
+	f.values[name] = value
+	return nil
+}
+
+func (s *ConfigSuite) SetupTest() {
+	var err error
+	s.Dir, err = ioutil.TempDir("", "config")
+	s.Require().NoError(err)
+
+	s.Flags = &fakeFlags{
+		set: map[string]bool{
+			"config-file": false,
+			"kv":          false,
+			"kv-prefix":   false,
+			"port":        false,
+			"log-level":   true,
+			"subnets":     false,
+			"debug":       false,
+		},
+		values: map[string]string{},
+	}
+
+	for _, name := range []string{"CONFIG_FILE", "KV", "KV_PREFIX", "PORT", "LOG_LEVEL"} {
+		s.Require().NoError(os.Unsetenv("TESTD_" + name))
+	}
+}
+
+func (s *ConfigSuite) TearDownTest() {
+	_ = os.RemoveAll(s.Dir)
+}
+
+func (s *ConfigSuite) writeFile(name, contents string) string {
+	path := filepath.Join(s.Dir, name)
+	s.Require().NoError(ioutil.WriteFile(path, []byte(contents), 0644))
+	return path
+}
+
+func (s *ConfigSuite) TestEnvName() {
+	s.Equal("CGUESTD_KV_PREFIX", config.EnvName("cguestd", "kv-prefix"))
+	s.Equal("CGUESTD_CONFIG_FILE", config.EnvName("cguestd", config.FlagName))
+}
+
+func (s *ConfigSuite) TestLoadYAML() {
+	path := s.writeFile("testd.yaml", `
+kv: http://kv:4001
+kv_prefix: /test
+port: 8080
+debug: true
+subnets:
+  - 10.0.0.0/8
+  - 192.168.0.0/16
+log-level: debug
+`)
+	s.Require().NoError(config.Load(s.Flags, "testd", path))
+	s.Equal(map[string]string{
+		"kv":        "http://kv:4001",
+		"kv-prefix": "/test",
+		"port":      "8080",
+		"debug":     "true",
+		"subnets":   "10.0.0.0/8,192.168.0.0/16",
+	}, s.Flags.values, "command line flags should not be overridden")
+}
+
+func (s *ConfigSuite) TestLoadTOML() {
+	path := s.writeFile("testd.toml", `
+# settings
+kv = "http://kv:4001"
+kv_prefix = '/test' # comment
+port = 8_080
+debug = false
+subnets = ["10.0.0.0/8", "192.168.0.0/16"]
+`)
+	s.Require().NoError(config.Load(s.Flags, "testd", path))
+	s.Equal(map[string]string{
+		"kv":        "http://kv:4001",
+		"kv-prefix": "/test",
+		"port":      "8080",
+		"debug":     "false",
+		"subnets":   "10.0.0.0/8,192.168.0.0/16",
+	}, s.Flags.values)
+}
+
+func (s *ConfigSuite) TestLoadEnv() {
+	path := s.writeFile("testd.yml", "kv: http://kv:4001\nport: 8080\n")
+	s.Require().NoError(os.Setenv("TESTD_CONFIG_FILE", path))
+	s.Require().NoError(os.Setenv("TESTD_PORT", "9090"))
+	s.Require().NoError(os.Setenv("TESTD_LOG_LEVEL", "info"))
+
+	s.Require().NoError(config.Load(s.Flags, "testd", ""))
+	s.Equal(map[string]string{
+		"kv":   "http://kv:4001",
+		"port": "9090",
+	}, s.Flags.values, "env should override the config file but not the command line")
+
+	s.Require().NoError(os.Setenv("TESTD_PORT", "nine"))
+	err := config.Load(s.Flags, "testd", "")
+	if s.Error(err) {
+		s.Contains(err.Error(), "TESTD_PORT")
+	}
+}
+
+func (s *ConfigSuite) TestLoadNone() {
+	s.NoError(config.Load(s.Flags, "testd", ""))
+	s.Empty(s.Flags.values)
+}
+
+func (s *ConfigSuite) TestLoadErrors() {
+	tests := []struct {
+		description string
+		name        string
+		contents    string
+	}{
+		{"unknown extension", "testd.json", `{"kv": "http://kv:4001"}`},
+		{"unknown setting", "testd.yaml", "kv: http://kv:4001\nfoo: bar\n"},
+		{"config file setting", "testd.yaml", "config-file: other.yaml\n"},
+		{"nested setting", "testd.yaml", "kv:\n  address: http://kv:4001\n"},
+		{"nested list", "testd.yaml", "subnets: [[10.0.0.0/8]]\n"},
+		{"invalid value", "testd.yaml", "port: eighty\n"},
+		{"invalid yaml", "testd.yaml", "kv: [\n"},
+		{"toml table", "testd.toml", "[kv]\naddress = \"http://kv:4001\"\n"},
+		{"toml missing value", "testd.toml", "kv =\n"},
+		{"toml bare string", "testd.toml", "kv = http://kv:4001\n"},
+		{"toml unterminated string", "testd.toml", "kv = \"http://kv:4001\n"},
+		{"toml unterminated array", "testd.toml", "subnets = [\"10.0.0.0/8\"\n"},
+		{"toml duplicate key", "testd.toml", "port = 1\nport = 2\n"},
+	}
+
+	for _, test := range tests {
+		path := s.writeFile(test.name, test.contents)
+		msg := fmt.Sprintf("%s should fail", test.description)
+		err := config.Load(s.Flags, "testd", path)
+		if s.Error(err, msg) {
+			s.Contains(err.Error(), path, msg)
+		}
+	}
+
+	s.Error(config.Load(s.Flags, "testd", filepath.Join(s.Dir, "missing.yaml")))
+}
@@ -0,0 +1,123 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseTOML parses the flat subset of toml that flag settings need: key/value
+// pairs of strings, numbers, booleans, and single line arrays of them. Tables
+// are not supported.
+func parseTOML(data []byte) (map[string]interface{}, error) {
+	settings := map[string]interface{}{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			return nil, fmt.Errorf("line %d: tables are not supported", n)
+		}
+
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: expected key = value", n)
+		}
+		key := strings.Trim(strings.TrimSpace(line[:i]), `"`)
+		if key == "" {
+			return nil, fmt.Errorf("line %d: missing key", n)
+		}
+		if _, ok := settings[key]; ok {
+			return nil, fmt.Errorf("line %d: duplicate key %s", n, key)
+		}
+
+		value, rest, err := parseTOMLValue(strings.TrimSpace(line[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+		if rest = strings.TrimSpace(rest); rest != "" && !strings.HasPrefix(rest, "#") {
+			return nil, fmt.Errorf("line %d: unexpected %q after value", n, rest)
+		}
+		settings[key] = value
+	}
+	return settings, scanner.Err()
+}
+
+// parseTOMLValue parses the value at the start of s, returning the rest of s
+func parseTOMLValue(s string) (interface{}, string, error) {
+	switch {
+	case s == "":
+		return nil, "", fmt.Errorf("missing value")
+	case s[0] == '"':
+		end := 1
+		for ; end < len(s); end++ {
+			if s[end] == '\\' {
+				end++
+			} else if s[end] == '"' {
+				break
+			}
+		}
+		if end >= len(s) {
+			return nil, "", fmt.Errorf("unterminated string")
+		}
+		value, err := strconv.Unquote(s[:end+1])
+		return value, s[end+1:], err
+	case s[0] == '\'':
+		end := strings.Index(s[1:], "'")
+		if end < 0 {
+			return nil, "", fmt.Errorf("unterminated string")
+		}
+		return s[1 : end+1], s[end+2:], nil
+	case s[0] == '[':
+		return parseTOMLArray(s[1:])
+	}
+
+	end := strings.IndexAny(s, ",]# \t")
+	if end < 0 {
+		end = len(s)
+	}
+	word, rest := s[:end], s[end:]
+	switch word {
+	case "true":
+		return true, rest, nil
+	case "false":
+		return false, rest, nil
+	}
+	number := strings.Replace(word, "_", "", -1)
+	if i, err := strconv.ParseInt(number, 0, 64); err == nil {
+		return i, rest, nil
+	}
+	if f, err := strconv.ParseFloat(number, 64); err == nil {
+		return f, rest, nil
+	}
+	return nil, "", fmt.Errorf("invalid value %q", word)
+}
+
+// parseTOMLArray parses the items of an array after its [, returning the rest
+// of s after its ]
+func parseTOMLArray(s string) (interface{}, string, error) {
+	items := []interface{}{}
+	for {
+		s = strings.TrimSpace(s)
+		if strings.HasPrefix(s, "]") {
+			return items, s[1:], nil
+		}
+
+		item, rest, err := parseTOMLValue(s)
+		if err != nil {
+			return nil, "", err
+		}
+		items = append(items, item)
+
+		s = strings.TrimSpace(rest)
+		if strings.HasPrefix(s, ",") {
+			s = s[1:]
+		} else if !strings.HasPrefix(s, "]") {
+			return nil, "", fmt.Errorf("unterminated array")
+		}
+	}
+}
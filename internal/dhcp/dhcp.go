@@ -0,0 +1,697 @@
+// Package dhcp keeps the dhcpd configs of the hypervisors and guests, or the
+// hosts of an embedded DHCP server in place of dhcpd, and optionally dns zone
+// files, up to date with the kv. It is run by cdhcpd and lochnessd.
+package dhcp
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/armon/go-metrics"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/pkg/kv"
+	"github.com/mistifyio/lochness/pkg/watcher"
+)
+
+// Settings are the values that can be reloaded with Reload
+type Settings struct {
+	Domain              string `json:"domain"`
+	HypervisorsTemplate string `json:"hypervisors_template"`
+	GuestsTemplate      string `json:"guests_template"`
+}
+
+// Config is the settings of a Service
+type Config struct {
+	// ConfDir is the dhcpd configuration directory
+	ConfDir string
+	// SettingsFile is an optional json file overriding Settings, read again
+	// on Reload
+	SettingsFile string
+	// Settings are the domain and template paths
+	Settings Settings
+	// ZoneDir is the directory to write dns zone files to, disabled if empty
+	ZoneDir string
+	// ZoneReloadCmd is run with the zone name after a zone file changes
+	ZoneReloadCmd string
+	// Listen is the address to answer DHCP requests on with the embedded
+	// Server, e.g. ":67", instead of writing dhcpd configs. Disabled if empty
+	Listen string
+	// ServerIP is the address the embedded Server identifies itself with,
+	// the IP of Listen if not set
+	ServerIP string
+	// LeaseTime is how long the leases of the embedded Server last,
+	// DefaultLeaseTime if 0
+	LeaseTime time.Duration
+	// DNSServers are the comma separated addresses of the DNS servers given
+	// to the hosts by the embedded Server
+	DNSServers string
+}
+
+// Service rewrites the dhcpd configs as the hypervisors, guests, and subnets in
+// the kv change, restarting dhcpd when they do, or updates the hosts of its
+// embedded Server if it has one
+type Service struct {
+	cfg        Config
+	fetcher    *Fetcher
+	refresher  *Refresher
+	zoneWriter *ZoneWriter
+	m          *metrics.Metrics
+	hconfPath  string
+	gconfPath  string
+
+	// server answers DHCP requests on conn, in place of dhcpd, when
+	// Config.Listen is set
+	server *Server
+	conn   net.PacketConn
+	// stopped is closed once Stop is called, so that conn being closed is
+	// not taken for an error
+	stopped chan struct{}
+
+	// stopWatching stops the watches of the kv
+	stopWatching context.CancelFunc
+
+	// ready holds a token while no event is being processed, to coordinate
+	// reloads and clean exits with the consumer
+	ready chan struct{}
+
+	hypervisorsHash []byte
+	guestsHash      []byte
+
+	// hosts as of the last written configs, for reporting what changed
+	hypervisorHosts map[string]string
+	guestHosts      map[string]string
+}
+
+// New creates a Service for the lochness keys in a kv, recording metrics in m
+func New(e kv.KV, cfg Config, m *metrics.Metrics) (*Service, error) {
+	r, err := newRefresher(cfg.SettingsFile, cfg.Settings)
+	if err != nil {
+		return nil, err
+	}
+	s := &Service{
+		cfg:       cfg,
+		fetcher:   NewFetcher(e),
+		refresher: r,
+		m:         m,
+		hconfPath: path.Join(cfg.ConfDir, "hypervisors.conf"),
+		gconfPath: path.Join(cfg.ConfDir, "guests.conf"),
+		ready:     make(chan struct{}, 1),
+		stopped:   make(chan struct{}),
+	}
+	if cfg.ZoneDir != "" {
+		s.zoneWriter = NewZoneWriter(cfg.ZoneDir, cfg.ZoneReloadCmd)
+	}
+	if cfg.Listen != "" {
+		ip, err := serverIP(cfg)
+		if err != nil {
+			return nil, err
+		}
+		dnsServers, err := parseIPs(cfg.DNSServers)
+		if err != nil {
+			return nil, err
+		}
+		s.server = NewServer(ip, cfg.LeaseTime, dnsServers)
+	}
+	s.ready <- struct{}{}
+	return s, nil
+}
+
+// serverIP is the address the embedded Server identifies itself with, the
+// ServerIP configured or else the IP Listen is on
+func serverIP(cfg Config) (net.IP, error) {
+	host := cfg.ServerIP
+	if host == "" {
+		var err error
+		if host, _, err = net.SplitHostPort(cfg.Listen); err != nil {
+			return nil, err
+		}
+	}
+	ip := net.ParseIP(host)
+	if ip.To4() == nil || ip.IsUnspecified() {
+		return nil, errors.New("an ipv4 server ip is required to serve dhcp on " + cfg.Listen)
+	}
+	return ip, nil
+}
+
+// parseIPs parses a comma separated list of IPv4 addresses
+func parseIPs(list string) ([]net.IP, error) {
+	var ips []net.IP
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		ip := net.ParseIP(field).To4()
+		if ip == nil {
+			return nil, fmt.Errorf("invalid ipv4 address %q", field)
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+// Start writes the configs, or updates the hosts of the embedded Server, and
+// the zone files from the current contents of the kv, then watches the kv to
+// keep them up to date
+func (s *Service) Start() error {
+	if err := s.fetcher.FetchAll(); err != nil {
+		return err
+	}
+
+	// Update at the start of each run
+	if err := s.updateHosts(allChanges); err != nil {
+		return err
+	}
+	if err := s.updateZones(); err != nil {
+		return err
+	}
+
+	if s.server != nil {
+		if err := s.serve(); err != nil {
+			return err
+		}
+	}
+
+	// Watch the hypervisors, guests, and subnets
+	ctx, cancel := context.WithCancel(context.Background())
+	w, err := watch(ctx, s.fetcher.kv)
+	if err != nil {
+		cancel()
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "watch",
+		}).Error("could not watch kv")
+		return err
+	}
+	s.stopWatching = cancel
+
+	go s.consumeEvents(w)
+	return nil
+}
+
+// Reload reloads the settings and rewrites the configs with them between
+// events. The previous settings are kept if the new ones can not be loaded.
+func (s *Service) Reload() error {
+	newR, err := newRefresher(s.cfg.SettingsFile, s.cfg.Settings)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":      err,
+			"func":       "newRefresher",
+			"configPath": s.cfg.SettingsFile,
+		}).Error("could not reload settings; keeping previous settings")
+		return err
+	}
+
+	// Swap in the new settings between events and re-render
+	done := <-s.ready
+	defer func() { s.ready <- done }()
+	*s.refresher = *newR
+	if err = s.updateHosts(allChanges); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "updateHosts",
+		}).Error("could not update configs after reload")
+	}
+	_ = s.updateZones()
+	return err
+}
+
+// Stop waits for the event being processed, if any, and stops watching the kv
+// and serving DHCP requests
+func (s *Service) Stop() {
+	<-s.ready // wait until any current processing is finished
+	if s.stopWatching != nil {
+		s.stopWatching()
+	}
+	close(s.stopped)
+	if s.conn != nil {
+		_ = s.conn.Close()
+	}
+}
+
+// serve listens on Config.Listen and answers DHCP requests with the embedded
+// Server until Stop is called
+func (s *Service) serve() error {
+	conn, err := net.ListenPacket("udp4", s.cfg.Listen)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":  err,
+			"func":   "net.ListenPacket",
+			"listen": s.cfg.Listen,
+		}).Error("could not listen for dhcp requests")
+		return err
+	}
+	s.conn = conn
+	log.WithField("listen", conn.LocalAddr().String()).Info("serving dhcp")
+
+	go func() {
+		err := s.server.Serve(conn)
+		select {
+		case <-s.stopped:
+		default:
+			// only reads fail Serve, failed replies are skipped
+			log.WithField("error", err).Fatal("dhcp server stopped")
+		}
+	}()
+	return nil
+}
+
+// newRefresher creates a Refresher from the settings given on the command line,
+// overridden by any values set in the optional config file
+func newRefresher(configPath string, flags Settings) (*Refresher, error) {
+	s := flags
+	if configPath != "" {
+		data, err := ioutil.ReadFile(configPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &s); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.Domain == "" {
+		return nil, errors.New("missing domain")
+	}
+
+	r := NewRefresher(s.Domain)
+	if err := r.LoadTemplates(s.HypervisorsTemplate, s.GuestsTemplate); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// recordHostChanges logs and counts the hosts added, removed and modified in a
+// newly written config
+func recordHostChanges(m *metrics.Metrics, confType string, old, new map[string]string) {
+	// the first config written is the baseline; the diff of the file shows
+	// anything that changed while cdhcpd was not running
+	if old == nil {
+		return
+	}
+
+	added, removed, modified := hostChanges(old, new)
+	if len(added)+len(removed)+len(modified) == 0 {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"type":     confType,
+		"added":    added,
+		"removed":  removed,
+		"modified": modified,
+	}).Info("hosts changed")
+
+	if m != nil {
+		m.IncrCounter([]string{"hosts", confType, "added"}, float32(len(added)))
+		m.IncrCounter([]string{"hosts", confType, "removed"}, float32(len(removed)))
+		m.IncrCounter([]string{"hosts", confType, "modified"}, float32(len(modified)))
+	}
+}
+
+// hypervisorHostValues keys the hypervisor template values by id
+func hypervisorHostValues(hypervisors map[string]*lochness.Hypervisor) map[string]string {
+	values := map[string]string{}
+	for _, h := range hypervisorHelpers(hypervisors) {
+		values[h.ID] = fmt.Sprintf("%+v", h)
+	}
+	return values
+}
+
+// guestHostValues keys the guest template values by id
+func guestHostValues(guests map[string]*lochness.Guest, subnets map[string]*lochness.Subnet) map[string]string {
+	values := map[string]string{}
+	for _, g := range guestHelpers(guests, subnets) {
+		values[g.ID] = fmt.Sprintf("%+v", g)
+	}
+	return values
+}
+
+// updateHosts brings the dhcpd configs up to date with changes, restarting
+// dhcpd if they changed, or the hosts of the embedded Server if there is one
+func (s *Service) updateHosts(changes Changes) error {
+	if s.server != nil {
+		return s.updateServer()
+	}
+	restart, err := s.updateConfigs(changes)
+	if restart {
+		restartDhcpd()
+	}
+	return err
+}
+
+// updateServer replaces the hosts of the embedded Server with those of the
+// current hypervisors, guests, and subnets
+func (s *Service) updateServer() error {
+	f := s.fetcher
+	hypervisors, err := f.Hypervisors()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "fetcher.Hypervisors",
+		}).Error("could not fetch hypervisors")
+		return err
+	}
+	guests, err := f.Guests()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "fetcher.Guests",
+		}).Error("could not fetch guests")
+		return err
+	}
+	subnets, err := f.Subnets()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "fetcher.Subnets",
+		}).Error("could not fetch subnets")
+		return err
+	}
+
+	s.server.Update(s.refresher.Domain, hypervisors, guests, subnets)
+
+	hosts := hypervisorHostValues(hypervisors)
+	recordHostChanges(s.m, "hypervisors", s.hypervisorHosts, hosts)
+	s.hypervisorHosts = hosts
+	hosts = guestHostValues(guests, subnets)
+	recordHostChanges(s.m, "guests", s.guestHosts, hosts)
+	s.guestHosts = hosts
+	return nil
+}
+
+// updateConfigs rewrites the changed configs, returning whether dhcpd must be
+// restarted because the output of one did change
+func (s *Service) updateConfigs(changes Changes) (bool, error) {
+	f, r, m := s.fetcher, s.refresher, s.m
+	restart := false
+
+	// Hypervisors
+	if changes.Hypervisors {
+		hypervisors, err := f.Hypervisors()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"func":  "fetcher.Hypervisors",
+			}).Error("could not fetch hypervisors")
+			return restart, err
+		}
+
+		checksum, err := writeConfig("hypervisors", s.hconfPath, s.hypervisorsHash, func(w io.Writer) error {
+			err := r.genHypervisorsConf(w, hypervisors)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"error": err,
+					"func":  "Refresher.genHypervisorsConf",
+					"type":  "hypervisors",
+				}).Error("could not generate configuration")
+			}
+			return err
+		})
+		if err == nil && checksum != nil {
+			s.hypervisorsHash = checksum
+			restart = true
+
+			hosts := hypervisorHostValues(hypervisors)
+			recordHostChanges(m, "hypervisors", s.hypervisorHosts, hosts)
+			s.hypervisorHosts = hosts
+		}
+	}
+
+	// Guests
+	if changes.Guests {
+		guests, err := f.Guests()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"func":  "fetcher.Guests",
+			}).Error("could not fetch guests")
+			return restart, err
+		}
+		subnets, err := f.Subnets()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"func":  "fetcher.Subnets",
+			}).Error("could not fetch subnets")
+			return restart, err
+		}
+
+		checksum, err := writeConfig("guests", s.gconfPath, s.guestsHash, func(w io.Writer) error {
+			err := r.genGuestsConf(w, guests, subnets)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"error": err,
+					"func":  "Refresher.genGuestsConf",
+					"type":  "guests",
+				}).Error("could not generate configuration")
+			}
+			return err
+		})
+		if err == nil && checksum != nil {
+			s.guestsHash = checksum
+			restart = true
+
+			hosts := guestHostValues(guests, subnets)
+			recordHostChanges(m, "guests", s.guestHosts, hosts)
+			s.guestHosts = hosts
+		}
+	}
+
+	return restart, nil
+}
+
+// updateZones rewrites any changed dns zone files. It is a no-op if zone files
+// are not enabled.
+func (s *Service) updateZones() error {
+	f, r, zw := s.fetcher, s.refresher, s.zoneWriter
+	if zw == nil {
+		return nil
+	}
+
+	hypervisors, err := f.Hypervisors()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "fetcher.Hypervisors",
+		}).Error("could not fetch hypervisors")
+		return err
+	}
+	guests, err := f.Guests()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "fetcher.Guests",
+		}).Error("could not fetch guests")
+		return err
+	}
+	subnets, err := f.Subnets()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "fetcher.Subnets",
+		}).Error("could not fetch subnets")
+		return err
+	}
+
+	if err := zw.Update(r, hypervisors, guests, subnets); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "ZoneWriter.Update",
+		}).Error("could not update zone files")
+		return err
+	}
+	return nil
+}
+
+// writeConfig generates a config and replaces the file with it, unless its
+// checksum is unchanged. It returns the new checksum, or nil if the file was
+// not replaced.
+func writeConfig(confType, path string, checksum []byte, generator func(io.Writer) error) ([]byte, error) {
+	// generate in memory first, so unchanged configs are not written at all
+	contents := &bytes.Buffer{}
+	if err := generator(contents); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"path":  path,
+			"type":  confType,
+		}).Error("could not generate configuration")
+		return nil, err
+	}
+
+	sum := md5.Sum(contents.Bytes())
+	if bytes.Equal(checksum, sum[:]) {
+		log.WithField("type", confType).Debug("no change to conf file")
+		return nil, nil
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, contents.Bytes(), 0666); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "ioutil.WriteFile",
+			"path":  tmp,
+			"type":  confType,
+		}).Error("could not write temporary conf file")
+		return nil, err
+	}
+
+	// the previous config may legitimately not exist yet
+	previous, _ := ioutil.ReadFile(path)
+
+	if err := os.Rename(tmp, path); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "os.Rename",
+			"from":  tmp,
+			"to":    path,
+			"type":  confType,
+		}).Error("could not rename temporary conf file")
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{
+		"path": path,
+		"type": confType,
+		"diff": unifiedDiff(path, string(previous), contents.String()),
+	}).Info("replaced conf file")
+
+	return sum[:], nil
+}
+
+func restartDhcpd() {
+	cmd := exec.Command("systemctl", "restart", "dhcpd.service")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "cmd.Run",
+		}).Error("failed to restart dhcpd service")
+	}
+}
+
+// watches are the changes watched of the hypervisors, guests, and subnets, and
+// the errors of the watches
+type watches struct {
+	hypervisors <-chan watcher.HypervisorEvent
+	guests      <-chan watcher.GuestEvent
+	subnets     <-chan watcher.SubnetEvent
+	errs        <-chan *watcher.EventError
+}
+
+// watch watches the hypervisors, guests, and subnets of a kv until ctx is done
+func watch(ctx context.Context, e kv.KV) (*watches, error) {
+	w := &watches{}
+	var hypervisorErrs, guestErrs, subnetErrs <-chan *watcher.EventError
+	var err error
+	if w.hypervisors, hypervisorErrs, err = watcher.WatchHypervisors(ctx, e); err != nil {
+		return nil, err
+	}
+	if w.guests, guestErrs, err = watcher.WatchGuests(ctx, e); err != nil {
+		return nil, err
+	}
+	if w.subnets, subnetErrs, err = watcher.WatchSubnets(ctx, e); err != nil {
+		return nil, err
+	}
+	w.errs = mergeErrors(hypervisorErrs, guestErrs, subnetErrs)
+	return w, nil
+}
+
+// mergeErrors merges the error channels of the watches, closing the channel
+// returned once they all are
+func mergeErrors(chans ...<-chan *watcher.EventError) <-chan *watcher.EventError {
+	merged := make(chan *watcher.EventError)
+	var wg sync.WaitGroup
+	for _, ch := range chans {
+		wg.Add(1)
+		go func(ch <-chan *watcher.EventError) {
+			defer wg.Done()
+			for err := range ch {
+				merged <- err
+			}
+		}(ch)
+	}
+	go func() {
+		wg.Wait()
+		close(merged)
+	}()
+	return merged
+}
+
+// consumeEvents integrates the changes watched and rewrites the configs as
+// needed, until a watch ends
+func (s *Service) consumeEvents(w *watches) {
+	f := s.fetcher
+	for {
+		var integrate func() (Changes, error)
+		select {
+		case e, ok := <-w.hypervisors:
+			if !ok {
+				return
+			}
+			integrate = func() (Changes, error) { return f.IntegrateHypervisorEvent(e) }
+		case e, ok := <-w.guests:
+			if !ok {
+				return
+			}
+			integrate = func() (Changes, error) { return f.IntegrateGuestEvent(e) }
+		case e, ok := <-w.subnets:
+			if !ok {
+				return
+			}
+			integrate = func() (Changes, error) { return f.IntegrateSubnetEvent(e) }
+		case err, ok := <-w.errs:
+			if !ok {
+				return
+			}
+			if err.Event == nil {
+				log.WithField("error", err).Fatal("watcher encountered an error")
+			}
+			log.WithFields(log.Fields{
+				"error": err.Err,
+				"key":   err.Event.Key,
+			}).Error("could not decode kv response")
+			integrate = func() (Changes, error) { return Changes{}, err }
+		}
+
+		// Remove item to indicate processing has begun
+		done := <-s.ready
+
+		// Integrate the change and update the configs if necessary
+		changes, err := integrate()
+		if err != nil {
+			log.Info("error on integration; re-fetching")
+			if err := f.FetchAll(); err != nil {
+				os.Exit(1)
+			}
+			changes = allChanges
+		}
+		if changes.Any() {
+			if err := s.updateHosts(changes); err != nil {
+				log.WithFields(log.Fields{
+					"error": err,
+					"func":  "updateHosts",
+				}).Warn("could not update configs")
+			}
+			_ = s.updateZones()
+		}
+
+		// Return item to indicate processing has completed
+		s.ready <- done
+	}
+}
@@ -0,0 +1,59 @@
+package dhcp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestDiff(t *testing.T) {
+	suite.Run(t, new(DiffSuite))
+}
+
+type DiffSuite struct {
+	suite.Suite
+}
+
+func (s *DiffSuite) TestUnifiedDiff() {
+	tests := []struct {
+		description string
+		old         string
+		new         string
+		expected    string
+	}{
+		{"same", "a\nb\nc\n", "a\nb\nc\n", ""},
+		{"empty", "", "", ""},
+		{"from nothing", "", "a\nb\n",
+			"--- f\n+++ f\n@@ -0,0 +1,2 @@\n+a\n+b\n"},
+		{"to nothing", "a\n", "",
+			"--- f\n+++ f\n@@ -1 +0,0 @@\n-a\n"},
+		{"modified line", "a\nb\nc\n", "a\nB\nc\n",
+			"--- f\n+++ f\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n"},
+		{"context trimmed", "1\n2\n3\n4\n5\n6\n7\n8\n9\n", "1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			"--- f\n+++ f\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n"},
+		{"separate hunks", "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n", "one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ntwelve\n",
+			"--- f\n+++ f\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+twelve\n"},
+		{"added in middle", "a\nc\n", "a\nb\nc\n",
+			"--- f\n+++ f\n@@ -1,2 +1,3 @@\n a\n+b\n c\n"},
+	}
+
+	for _, test := range tests {
+		msg := func(m string) string { return test.description + " : " + m }
+		s.Equal(test.expected, unifiedDiff("f", test.old, test.new), msg("wrong diff"))
+	}
+}
+
+func (s *DiffSuite) TestHostChanges() {
+	old := map[string]string{"a": "1", "b": "2", "c": "3"}
+	new := map[string]string{"a": "1", "b": "two", "d": "4"}
+
+	added, removed, modified := hostChanges(old, new)
+	s.Equal([]string{"d"}, added)
+	s.Equal([]string{"c"}, removed)
+	s.Equal([]string{"b"}, modified)
+
+	added, removed, modified = hostChanges(old, old)
+	s.Empty(added)
+	s.Empty(removed)
+	s.Empty(modified)
+}
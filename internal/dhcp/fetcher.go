@@ -0,0 +1,445 @@
+package dhcp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/hashicorp/go-multierror"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/pkg/kv"
+	"github.com/mistifyio/lochness/pkg/watcher"
+)
+
+type (
+	// Fetcher grabs keys from a kv and maintains lists of hypervisors, guests, and subnets
+	Fetcher struct {
+		context     *lochness.Context
+		kv          kv.KV
+		hypervisors map[string]*lochness.Hypervisor
+		guests      map[string]*lochness.Guest
+		subnets     map[string]*lochness.Subnet
+	}
+
+	// ilogFields defines what needs to be passed to logIntegrationMessage()
+	ilogFields struct {
+		r kv.Event
+		m string
+		i string
+		v string
+		e error
+		f string
+	}
+)
+
+// NewFetcher creates a new fetcher of the lochness keys in a kv
+func NewFetcher(e kv.KV) *Fetcher {
+	c := lochness.NewContext(e)
+	return &Fetcher{
+		context: c,
+		kv:      e,
+	}
+}
+
+// FetchAll pulls the hypervisors, guests, and subnets from a kv
+func (f *Fetcher) FetchAll() error {
+	var errs *multierror.Error
+	if err := f.fetchHypervisors(); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if err := f.fetchSubnets(); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if err := f.fetchGuests(); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	return errs.ErrorOrNil()
+}
+
+// fetchHypervisors pulls the hypervisors from a kv
+func (f *Fetcher) fetchHypervisors() error {
+	f.hypervisors = make(map[string]*lochness.Hypervisor)
+	err := f.context.ForEachHypervisor(func(hv *lochness.Hypervisor) error {
+		f.hypervisors[hv.ID] = hv
+		return nil
+	})
+	if err != nil {
+		if f.kv.IsKeyNotFound(err) {
+			log.WithFields(log.Fields{
+				"error": err,
+				"func":  "context.ForEachHypervisor",
+			}).Warning("no hypervisors are stored in kv")
+			return nil
+		}
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "context.ForEachHypervisor",
+		}).Error("could not retrieve hypervisors from kv")
+		return err
+	}
+	log.WithFields(log.Fields{
+		"hypervisorCount": len(f.hypervisors),
+	}).Info("fetched hypervisors metadata")
+	return nil
+}
+
+// fetchGuests pulls the guests from a kv
+func (f *Fetcher) fetchGuests() error {
+	f.guests = make(map[string]*lochness.Guest)
+	err := f.context.ForEachGuest(func(g *lochness.Guest) error {
+		f.guests[g.ID] = g
+		return nil
+	})
+	if err != nil {
+		if f.kv.IsKeyNotFound(err) {
+			log.WithFields(log.Fields{
+				"error": err,
+				"func":  "context.ForEachGuest",
+			}).Warning("no guests are stored in kv")
+			return nil
+		}
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "context.ForEachGuest",
+		}).Error("could not retrieve guests from kv")
+		return err
+	}
+	log.WithFields(log.Fields{
+		"guestCount": len(f.guests),
+	}).Info("fetched guests metadata")
+	return nil
+}
+
+// fetchSubnets pulls the subnets from a kv
+func (f *Fetcher) fetchSubnets() error {
+	f.subnets = make(map[string]*lochness.Subnet)
+	err := f.context.ForEachSubnet(func(s *lochness.Subnet) error {
+		f.subnets[s.ID] = s
+		return nil
+	})
+	if err != nil {
+		if f.kv.IsKeyNotFound(err) {
+			// key missing; log warning but return no error
+			log.WithFields(log.Fields{
+				"error": err,
+				"func":  "Get",
+			}).Warning("no subnets are stored in kv")
+			return nil
+		}
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "Get",
+		}).Error("could not retrieve subnets from kv")
+		return err
+	}
+	log.WithFields(log.Fields{
+		"subnetCount": len(f.subnets),
+	}).Info("fetched subnets metadata")
+	return nil
+}
+
+// Hypervisors retrieves the stored hypervisors, or fetches them if they
+// aren't stored yet
+func (f *Fetcher) Hypervisors() (map[string]*lochness.Hypervisor, error) {
+	if f.hypervisors == nil {
+		if err := f.fetchHypervisors(); err != nil {
+			return nil, err
+		}
+	}
+	return f.hypervisors, nil
+}
+
+// Guests retrieves the stored guests, or fetches them if they aren't stored
+// yet
+func (f *Fetcher) Guests() (map[string]*lochness.Guest, error) {
+	if f.guests == nil {
+		if err := f.fetchGuests(); err != nil {
+			return nil, err
+		}
+	}
+	return f.guests, nil
+}
+
+// Subnets retrieves the stored subnets, or fetches them if they aren't
+// stored yet
+func (f *Fetcher) Subnets() (map[string]*lochness.Subnet, error) {
+	if f.subnets == nil {
+		if err := f.fetchSubnets(); err != nil {
+			return nil, err
+		}
+	}
+	return f.subnets, nil
+}
+
+// Changes are the configs whose output is changed by an integrated kv event
+type Changes struct {
+	Hypervisors bool
+	Guests      bool
+}
+
+// allChanges rewrites every config, e.g. after a fetch
+var allChanges = Changes{Hypervisors: true, Guests: true}
+
+// Any returns whether any config changed
+func (c Changes) Any() bool {
+	return c.Hypervisors || c.Guests
+}
+
+// IntegrateResponse decodes a kv event, see watcher.DecodeGuestEvent, and
+// integrates it with IntegrateHypervisorEvent, IntegrateGuestEvent, or
+// IntegrateSubnetEvent. Other keys nested under an element, such as a subnet's
+// addresses or a hypervisor's config, are not written to the configs and are
+// ignored. An error is returned for keys that cannot be parsed and values that
+// cannot be unmarshaled, as well as by the integration, so that everything can
+// be refetched.
+func (f *Fetcher) IntegrateResponse(event kv.Event) (Changes, error) {
+	element, id, vtype, ok := watcher.ParseKey(event.Key)
+	if !ok {
+		msg := "caught response from kv that did not match"
+		log.WithFields(log.Fields{
+			"key":    event.Key,
+			"action": event.Type,
+		}).Warning(msg)
+		return Changes{}, errors.New(msg)
+	}
+	ilf := ilogFields{r: event, m: element, i: id, v: vtype}
+	f.logIntegrationMessage("debug", "response received", ilf)
+
+	var changes Changes
+	var err error
+	switch element {
+	case "hypervisors":
+		var e watcher.HypervisorEvent
+		if e, ok, err = watcher.DecodeHypervisorEvent(f.context, event); ok {
+			changes, err = f.IntegrateHypervisorEvent(e)
+		}
+	case "guests":
+		var e watcher.GuestEvent
+		if e, ok, err = watcher.DecodeGuestEvent(f.context, event); ok {
+			changes, err = f.IntegrateGuestEvent(e)
+		}
+	case "subnets":
+		var e watcher.SubnetEvent
+		if e, ok, err = watcher.DecodeSubnetEvent(f.context, event); ok {
+			changes, err = f.IntegrateSubnetEvent(e)
+		}
+	}
+	if err != nil {
+		if !ok {
+			ilf.e = err
+			ilf.f = "watcher.Decode"
+			f.logIntegrationMessage("error", "could not decode kv response", ilf)
+		}
+		return Changes{}, err
+	}
+	if !ok {
+		f.logIntegrationMessage("debug", "action on something other than the main element; ignoring", ilf)
+	}
+	return changes, nil
+}
+
+// IntegrateHypervisorEvent updates our list of hypervisors with a change of
+// one, then returns which configs it changes. Only changes to the values
+// written to the configs count. As each event carries the whole element, one
+// that creates an element we have, updates one we don't, or whose previous
+// value does not match ours, makes up for the changes we missed of it. An error
+// is only returned before the first fetch, so that everything can be fetched.
+func (f *Fetcher) IntegrateHypervisorEvent(e watcher.HypervisorEvent) (Changes, error) {
+	ilf := newILogFields(e.EntityEvent)
+	if f.hypervisors == nil {
+		return Changes{}, f.notFetched(ilf)
+	}
+
+	old, ok := f.hypervisors[e.ID]
+	if !f.checkExists(e.EntityEvent, ilf, ok) {
+		return Changes{}, nil
+	}
+	if e.Old != nil {
+		f.checkPrev(ilf, hypervisorHost(old), hypervisorHost(e.Old))
+	}
+
+	var changes Changes
+	if e.Type == kv.Delete {
+		delete(f.hypervisors, e.ID)
+		f.logIntegrationMessage("info", "deleted hypervisor", ilf)
+		changes.Hypervisors = true
+	} else {
+		f.hypervisors[e.ID] = e.New
+		f.logIntegrationMessage("info", "integrated hypervisor", ilf)
+		changes.Hypervisors = hypervisorHost(old) != hypervisorHost(e.New)
+	}
+	f.logNoChanges(changes, ilf)
+	return changes, nil
+}
+
+// IntegrateGuestEvent updates our list of guests with a change of one, see
+// IntegrateHypervisorEvent
+func (f *Fetcher) IntegrateGuestEvent(e watcher.GuestEvent) (Changes, error) {
+	ilf := newILogFields(e.EntityEvent)
+	if f.guests == nil {
+		return Changes{}, f.notFetched(ilf)
+	}
+
+	old, ok := f.guests[e.ID]
+	if !f.checkExists(e.EntityEvent, ilf, ok) {
+		return Changes{}, nil
+	}
+	if e.Old != nil {
+		f.checkPrev(ilf, guestHost(old, f.subnets), guestHost(e.Old, f.subnets))
+	}
+
+	var changes Changes
+	if e.Type == kv.Delete {
+		delete(f.guests, e.ID)
+		f.logIntegrationMessage("info", "deleted guest", ilf)
+		changes.Guests = guestHost(old, f.subnets) != ""
+	} else {
+		f.guests[e.ID] = e.New
+		f.logIntegrationMessage("info", "integrated guest", ilf)
+		changes.Guests = guestHost(old, f.subnets) != guestHost(e.New, f.subnets)
+	}
+	f.logNoChanges(changes, ilf)
+	return changes, nil
+}
+
+// IntegrateSubnetEvent updates our list of subnets with a change of one, see
+// IntegrateHypervisorEvent. Subnets change guests.conf through their guests.
+func (f *Fetcher) IntegrateSubnetEvent(e watcher.SubnetEvent) (Changes, error) {
+	ilf := newILogFields(e.EntityEvent)
+	if f.subnets == nil {
+		return Changes{}, f.notFetched(ilf)
+	}
+
+	old, ok := f.subnets[e.ID]
+	if !f.checkExists(e.EntityEvent, ilf, ok) {
+		return Changes{}, nil
+	}
+	// the subnet's own values are compared, as what its guests contribute
+	// depends on the rest of the subnets
+	if e.Old != nil {
+		f.checkPrev(ilf, subnetHost(old), subnetHost(e.Old))
+	}
+
+	before := subnetHosts(e.ID, f.guests, f.subnets)
+	if e.Type == kv.Delete {
+		delete(f.subnets, e.ID)
+		f.logIntegrationMessage("info", "deleted subnet", ilf)
+	} else {
+		f.subnets[e.ID] = e.New
+		f.logIntegrationMessage("info", "integrated subnet", ilf)
+	}
+	changes := Changes{Guests: before != subnetHosts(e.ID, f.guests, f.subnets)}
+	f.logNoChanges(changes, ilf)
+	return changes, nil
+}
+
+// newILogFields returns the log fields of an entity event
+func newILogFields(e watcher.EntityEvent) ilogFields {
+	element, id, vtype, _ := watcher.ParseKey(e.Event.Key)
+	return ilogFields{r: e.Event, m: element, i: id, v: vtype}
+}
+
+// notFetched logs and returns the error of events integrated before the first
+// fetch
+func (f *Fetcher) notFetched(ilf ilogFields) error {
+	msg := "cannot integrate elements when no initial fetch has occurred"
+	f.logIntegrationMessage("error", msg, ilf)
+	return errors.New(msg)
+}
+
+// logNoChanges logs integrated events that change no config
+func (f *Fetcher) logNoChanges(changes Changes, ilf ilogFields) {
+	if !changes.Any() {
+		f.logIntegrationMessage("debug", "no change to the configs", ilf)
+	}
+}
+
+// logIntegrationMessage logs a uniform message during integration
+func (f *Fetcher) logIntegrationMessage(level string, message string, fields ilogFields) {
+	logfields := log.Fields{
+		"action":  fields.r.Type,
+		"element": fields.m,
+		"id":      fields.i,
+		"vtype":   fields.v,
+		"key":     fields.r.Key,
+	}
+	if fields.e != nil {
+		logfields["error"] = fields.e
+		logfields["func"] = fields.f
+	}
+	if level == "debug" {
+		log.WithFields(logfields).Debug(message)
+	} else if level == "info" {
+		log.WithFields(logfields).Info(message)
+	} else if level == "warning" {
+		log.WithFields(logfields).Warning(message)
+	} else if level == "error" {
+		log.WithFields(logfields).Error(message)
+	}
+}
+
+// checkPrev compares what an element contributes to the configs with what its
+// previous value, from an update or delete event if the kv sends one, does. A
+// mismatch means an earlier event was missed, which the event makes up for, so
+// it is only logged.
+func (f *Fetcher) checkPrev(ilf ilogFields, current, prev string) {
+	if prev != current {
+		f.logIntegrationMessage("warning", "previous value from kv does not match the fetched element; integrating anyway", ilf)
+	}
+}
+
+// checkExists logs events that create an element we already have, or operate
+// on one we don't, which the event makes up for unless it is a delete. It
+// returns whether there is anything to integrate.
+func (f *Fetcher) checkExists(e watcher.EntityEvent, ilf ilogFields, exists bool) bool {
+	switch {
+	case exists && e.Type == kv.Create:
+		f.logIntegrationMessage("warning", "caught response creating an element that already exists; updating it", ilf)
+	case !exists && e.Type == kv.Update:
+		f.logIntegrationMessage("warning", "caught response updating an element that doesn't exist; adding it", ilf)
+	case !exists && e.Type == kv.Delete:
+		f.logIntegrationMessage("debug", "caught response deleting an element that doesn't exist; ignoring", ilf)
+		return false
+	}
+	return true
+}
+
+// hypervisorHost returns what a hypervisor contributes to hypervisors.conf
+func hypervisorHost(hv *lochness.Hypervisor) string {
+	if hv == nil {
+		return ""
+	}
+	return fmt.Sprintf("%+v", newHypervisorHelper(hv))
+}
+
+// guestHost returns what a guest contributes to guests.conf
+func guestHost(g *lochness.Guest, subnets map[string]*lochness.Subnet) string {
+	if g == nil {
+		return ""
+	}
+	h, ok := newGuestHelper(g, subnets)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%+v", h)
+}
+
+// subnetHosts returns what the guests of a subnet contribute to guests.conf
+func subnetHosts(id string, guests map[string]*lochness.Guest, subnets map[string]*lochness.Subnet) string {
+	members := map[string]*lochness.Guest{}
+	for gid, g := range guests {
+		if g.SubnetID == id {
+			members[gid] = g
+		}
+	}
+	return fmt.Sprintf("%+v", guestHelpers(members, subnets))
+}
+
+// subnetHost returns the values of a subnet written to guests.conf
+func subnetHost(s *lochness.Subnet) string {
+	if s == nil || s.CIDR == nil {
+		return ""
+	}
+	return s.Gateway.String() + " " + net.IP(s.CIDR.Mask).String()
+}
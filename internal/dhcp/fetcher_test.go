@@ -1,12 +1,13 @@
-package main_test
+package dhcp_test
 
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"testing"
 
 	log "github.com/Sirupsen/logrus"
-	"github.com/mistifyio/lochness/cmd/cdhcpd"
+	"github.com/mistifyio/lochness/internal/dhcp"
 	"github.com/mistifyio/lochness/internal/tests/common"
 	"github.com/mistifyio/lochness/pkg/kv"
 	"github.com/stretchr/testify/suite"
@@ -18,7 +19,7 @@ func TestFetcher(t *testing.T) {
 
 type FetcherSuite struct {
 	common.Suite
-	Fetcher *main.Fetcher
+	Fetcher *dhcp.Fetcher
 }
 
 func (s *FetcherSuite) SetupSuite() {
@@ -28,7 +29,7 @@ func (s *FetcherSuite) SetupSuite() {
 
 func (s *FetcherSuite) SetupTest() {
 	s.Suite.SetupTest()
-	s.Fetcher = main.NewFetcher(s.KVURL)
+	s.Fetcher = dhcp.NewFetcher(s.KV)
 
 	log.SetLevel(log.FatalLevel)
 }
@@ -99,85 +100,181 @@ func (s *FetcherSuite) TestIntegrateResponse() {
 	gJSON, _ := json.Marshal(guest)
 	sJSON, _ := json.Marshal(subnet)
 
+	modified := *hypervisor
+	modified.IP = net.ParseIP("10.10.10.10")
+	modifiedJSON, _ := json.Marshal(&modified)
+
 	hPath := s.KVPrefix + "/hypervisors/%s/metadata"
 	sPath := s.KVPrefix + "/subnets/%s/metadata"
 	gPath := s.KVPrefix + "/guests/%s/metadata"
 
 	// Should fail before first fetch
-	refresh, err := s.Fetcher.IntegrateResponse(kv.Event{
+	changes, err := s.Fetcher.IntegrateResponse(kv.Event{
 		Type: kv.Create,
 		Key:  fmt.Sprintf(hPath, hypervisor.ID),
 	})
 	s.Error(err)
-	s.False(refresh)
+	s.False(changes.Any())
 
 	_ = s.Fetcher.FetchAll()
 
 	tests := []struct {
 		description string
 		resp        kv.Event
-		refresh     bool
+		changes     dhcp.Changes
 		expectedErr bool
 	}{
 		{"create wrong key",
 			kv.Event{
 				Type: kv.Create,
 				Key:  "foobar/baz",
-			}, false, true,
+			}, dhcp.Changes{}, true,
+		},
+		{"set hypervisor unchanged",
+			kv.Event{
+				Type:  kv.Update,
+				Key:   fmt.Sprintf(hPath, hypervisor.ID),
+				Value: kv.Value{Data: hJSON},
+			}, dhcp.Changes{}, false,
 		},
 		{"set hypervisor",
+			kv.Event{
+				Type:  kv.Update,
+				Key:   fmt.Sprintf(hPath, hypervisor.ID),
+				Value: kv.Value{Data: modifiedJSON},
+				Prev:  &kv.Value{Data: hJSON},
+			}, dhcp.Changes{Hypervisors: true}, false,
+		},
+		{"set hypervisor missed change",
 			kv.Event{
 				Type:  kv.Update,
 				Key:   fmt.Sprintf(hPath, hypervisor.ID),
 				Value: kv.Value{Data: hJSON},
-			}, true, false,
+				Prev:  &kv.Value{Data: hJSON},
+			}, dhcp.Changes{Hypervisors: true}, false,
 		},
-		{"set guest",
+		{"set guest unchanged",
 			kv.Event{
 				Type:  kv.Update,
 				Key:   fmt.Sprintf(gPath, guest.ID),
 				Value: kv.Value{Data: gJSON},
-			}, true, false,
+			}, dhcp.Changes{}, false,
 		},
-		{"set subnet",
+		{"set subnet unchanged",
 			kv.Event{
 				Type:  kv.Update,
 				Key:   fmt.Sprintf(sPath, subnet.ID),
 				Value: kv.Value{Data: sJSON},
-			}, true, false,
+			}, dhcp.Changes{}, false,
 		},
 		{"delete guest",
 			kv.Event{
 				Type: kv.Delete,
 				Key:  fmt.Sprintf(gPath, guest.ID),
-			}, true, false,
+				Prev: &kv.Value{Data: gJSON},
+			}, dhcp.Changes{Guests: true}, false,
 		},
-		{"delete subnet",
+		{"delete subnet without guests",
 			kv.Event{
 				Type: kv.Delete,
 				Key:  fmt.Sprintf(sPath, subnet.ID),
-			}, true, false,
+			}, dhcp.Changes{}, false,
 		},
 		{"delete hypervisor",
 			kv.Event{
 				Type: kv.Delete,
 				Key:  fmt.Sprintf(hPath, hypervisor.ID),
-			}, true, false,
+			}, dhcp.Changes{Hypervisors: true}, false,
 		},
 		{"create hypervisor",
 			kv.Event{
 				Type:  kv.Create,
 				Key:   fmt.Sprintf(hPath, hypervisor.ID),
 				Value: kv.Value{Data: hJSON},
-			}, true, false,
+			}, dhcp.Changes{Hypervisors: true}, false,
+		},
+		{"set hypervisor leading slash",
+			kv.Event{
+				Type:  kv.Update,
+				Key:   "/" + fmt.Sprintf(hPath, hypervisor.ID),
+				Value: kv.Value{Data: modifiedJSON},
+			}, dhcp.Changes{Hypervisors: true}, false,
+		},
+		{"create existing hypervisor",
+			kv.Event{
+				Type:  kv.Create,
+				Key:   fmt.Sprintf(hPath, hypervisor.ID),
+				Value: kv.Value{Data: modifiedJSON},
+			}, dhcp.Changes{}, false,
+		},
+		{"set hypervisor config",
+			kv.Event{
+				Type:  kv.Update,
+				Key:   fmt.Sprintf(s.KVPrefix+"/hypervisors/%s/config/foo", hypervisor.ID),
+				Value: kv.Value{Data: []byte("bar")},
+			}, dhcp.Changes{}, false,
+		},
+		{"create subnet",
+			kv.Event{
+				Type:  kv.Create,
+				Key:   fmt.Sprintf(sPath, subnet.ID),
+				Value: kv.Value{Data: sJSON},
+			}, dhcp.Changes{}, false,
+		},
+		{"create subnet address",
+			kv.Event{
+				Type:  kv.Create,
+				Key:   fmt.Sprintf(s.KVPrefix+"/subnets/%s/addresses/%s", subnet.ID, guest.IP),
+				Value: kv.Value{Data: []byte(guest.ID)},
+			}, dhcp.Changes{}, false,
+		},
+		{"set missing guest",
+			kv.Event{
+				Type:  kv.Update,
+				Key:   fmt.Sprintf(gPath, guest.ID),
+				Value: kv.Value{Data: gJSON},
+			}, dhcp.Changes{Guests: true}, false,
+		},
+		{"create guest directory",
+			kv.Event{
+				Type: kv.Create,
+				Key:  fmt.Sprintf(s.KVPrefix+"/guests/%s", guest.ID),
+			}, dhcp.Changes{}, false,
+		},
+		{"delete guest directory",
+			kv.Event{
+				Type: kv.Delete,
+				Key:  fmt.Sprintf(s.KVPrefix+"/guests/%s", guest.ID),
+			}, dhcp.Changes{Guests: true}, false,
+		},
+		{"delete missing guest",
+			kv.Event{
+				Type: kv.Delete,
+				Key:  fmt.Sprintf(gPath, guest.ID),
+				Prev: &kv.Value{Data: gJSON},
+			}, dhcp.Changes{}, false,
+		},
+		{"create guest invalid json",
+			kv.Event{
+				Type:  kv.Create,
+				Key:   fmt.Sprintf(gPath, guest.ID),
+				Value: kv.Value{Data: []byte("foobar")},
+			}, dhcp.Changes{}, true,
+		},
+		{"create guest invalid id",
+			kv.Event{
+				Type:  kv.Create,
+				Key:   fmt.Sprintf(gPath, "FOOBAR"),
+				Value: kv.Value{Data: gJSON},
+			}, dhcp.Changes{}, true,
 		},
 	}
 
 	for _, test := range tests {
 		msg := s.Messager(test.description)
-		refresh, err := s.Fetcher.IntegrateResponse(test.resp)
+		changes, err := s.Fetcher.IntegrateResponse(test.resp)
 
-		s.Equal(test.refresh, refresh, msg("wrong refresh conclusion"))
+		s.Equal(test.changes, changes, msg("wrong changes"))
 		if test.expectedErr {
 			s.Error(err, msg("should have errored"))
 		} else {
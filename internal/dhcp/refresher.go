@@ -0,0 +1,263 @@
+package dhcp
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"text/template"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness"
+)
+
+type (
+	// Refresher writes out the dhcp configuration files hypervisors.conf and
+	// guests.conf, given a fetcher
+	Refresher struct {
+		Domain              string
+		HypervisorsTemplate string
+		GuestsTemplate      string
+	}
+
+	// templateHelper is used for inserting values into the templates
+	templateHelper struct {
+		Domain      string
+		BootFile    string
+		Hypervisors []hypervisorHelper
+		Guests      []guestHelper
+	}
+
+	// hypervisorHelper is used for inserting a hypervisor's values into the template
+	hypervisorHelper struct {
+		ID      string
+		MAC     string
+		IP      string
+		Gateway string
+		Netmask string
+	}
+
+	// guestHelper is used for inserting a guest's values into the template
+	guestHelper struct {
+		ID      string
+		MAC     string
+		IP      string
+		Gateway string
+		CIDR    string
+	}
+)
+
+// BootFile is the iPXE build hypervisors not yet running iPXE are told to fetch
+// from tftp.services.<domain> and chainload
+const BootFile = "undionly.kpxe"
+
+var hypervisorsTemplate = `
+# Auto generated by cdhcpd, do not edit
+
+group hypervisors {
+    option domain-name "nodes.{{.Domain}}";
+    if exists user-class and option user-class = "iPXE" {
+        filename "http://ipxe.services.{{.Domain}}:8888/ipxe/${net0/ip}";
+    } else {
+        next-server tftp.services.{{.Domain}};
+        filename "{{.BootFile}}";
+    }
+{{range $h := .Hypervisors}}
+    host {{$h.ID}} {
+        hardware ethernet  {{$h.MAC}};
+        fixed-address      {{$h.IP}};
+        option routers     {{$h.Gateway}};
+        option subnet-mask {{$h.Netmask}};
+    }
+{{end}}
+}
+`
+
+var guestsTemplate = `
+# Auto generated by cdhcpd, do not edit
+
+group guests {
+    option domain-name "guests.{{.Domain}}";
+{{range $g := .Guests}}
+    host {{$g.ID}} {
+        hardware ethernet  {{$g.MAC}};
+        fixed-address      {{$g.IP}};
+        option routers     {{$g.Gateway}};
+        option subnet-mask {{$g.CIDR}};
+    }
+{{end}}
+}
+`
+
+// NewRefresher creates a new refresher
+func NewRefresher(domain string) *Refresher {
+	return &Refresher{
+		Domain:              domain,
+		HypervisorsTemplate: hypervisorsTemplate,
+		GuestsTemplate:      guestsTemplate,
+	}
+}
+
+// LoadTemplates replaces the built in templates with the contents of the
+// given files. An empty path keeps the built in template for that config.
+func (r *Refresher) LoadTemplates(hypervisorsPath, guestsPath string) error {
+	if hypervisorsPath != "" {
+		t, err := readTemplate("hypervisors.conf", hypervisorsPath)
+		if err != nil {
+			return err
+		}
+		r.HypervisorsTemplate = t
+	}
+	if guestsPath != "" {
+		t, err := readTemplate("guests.conf", guestsPath)
+		if err != nil {
+			return err
+		}
+		r.GuestsTemplate = t
+	}
+	return nil
+}
+
+// readTemplate reads a template file and makes sure it parses
+func readTemplate(name, path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "ioutil.ReadFile",
+			"path":  path,
+		}).Error("could not read " + name + " template")
+		return "", err
+	}
+	if _, err := template.New(name).Parse(string(data)); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "template.Parse",
+			"path":  path,
+		}).Error("could not parse " + name + " template")
+		return "", err
+	}
+	return string(data), nil
+}
+
+// hypervisorHelpers builds the template values for the hypervisors, sorted by id
+func hypervisorHelpers(hypervisors map[string]*lochness.Hypervisor) []hypervisorHelper {
+	// Sort keys
+	hkeys := make([]string, len(hypervisors))
+	i := 0
+	for id := range hypervisors {
+		hkeys[i] = id
+		i++
+	}
+	sort.Strings(hkeys)
+
+	helpers := make([]hypervisorHelper, 0, len(hkeys))
+	for _, id := range hkeys {
+		helpers = append(helpers, newHypervisorHelper(hypervisors[id]))
+	}
+	return helpers
+}
+
+// newHypervisorHelper builds the template values for a hypervisor
+func newHypervisorHelper(hv *lochness.Hypervisor) hypervisorHelper {
+	return hypervisorHelper{
+		ID:      hv.ID,
+		MAC:     strings.ToUpper(hv.MAC.String()),
+		IP:      hv.IP.String(),
+		Gateway: hv.Gateway.String(),
+		Netmask: hv.Netmask.String(),
+	}
+}
+
+// genHypervisorsConf writes the hypervisors config
+func (r *Refresher) genHypervisorsConf(w io.Writer, hypervisors map[string]*lochness.Hypervisor) error {
+	vals := new(templateHelper)
+	vals.Domain = r.Domain
+	vals.BootFile = BootFile
+	vals.Hypervisors = hypervisorHelpers(hypervisors)
+
+	// Execute template
+	t, err := template.New("hypervisors.conf").Parse(r.HypervisorsTemplate)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "template.Parse",
+		}).Error("could not parse hypervisors.conf template")
+		return err
+	}
+	if err = t.Execute(w, vals); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "template.Execute",
+		}).Error("could not execute hypervisors.conf template")
+		return err
+	}
+	return nil
+}
+
+// guestHelpers builds the template values for the guests that are assigned to
+// a hypervisor and a known subnet, sorted by id
+func guestHelpers(guests map[string]*lochness.Guest, subnets map[string]*lochness.Subnet) []guestHelper {
+	// Sort guest keys
+	gkeys := make([]string, len(guests))
+	i := 0
+	for id := range guests {
+		gkeys[i] = id
+		i++
+	}
+	sort.Strings(gkeys)
+
+	helpers := make([]guestHelper, 0, len(gkeys))
+	for _, id := range gkeys {
+		if h, ok := newGuestHelper(guests[id], subnets); ok {
+			helpers = append(helpers, h)
+		}
+	}
+	return helpers
+}
+
+// newGuestHelper builds the template values for a guest, or returns false if
+// it is not assigned to a hypervisor and a known subnet
+func newGuestHelper(g *lochness.Guest, subnets map[string]*lochness.Subnet) (guestHelper, bool) {
+	if g.HypervisorID == "" || g.SubnetID == "" {
+		return guestHelper{}, false
+	}
+	s, ok := subnets[g.SubnetID]
+	if !ok {
+		return guestHelper{}, false
+	}
+	mask := s.CIDR.Mask
+	return guestHelper{
+		ID:      g.ID,
+		MAC:     strings.ToUpper(g.MAC.String()),
+		IP:      g.IP.String(),
+		Gateway: s.Gateway.String(),
+		CIDR:    fmt.Sprintf("%d.%d.%d.%d", mask[0], mask[1], mask[2], mask[3]),
+	}, true
+}
+
+// genGuestsConf writes the guests config
+func (r *Refresher) genGuestsConf(w io.Writer, guests map[string]*lochness.Guest, subnets map[string]*lochness.Subnet) error {
+	vals := new(templateHelper)
+	vals.Domain = r.Domain
+	vals.Guests = guestHelpers(guests, subnets)
+
+	// Execute template
+	t, err := template.New("guests.conf").Parse(r.GuestsTemplate)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "template.Parse",
+		}).Error("could not parse guests.conf template")
+		return err
+	}
+	if err = t.Execute(w, vals); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "template.Execute",
+		}).Error("could not execute guests.conf template")
+		return err
+	}
+	return nil
+}
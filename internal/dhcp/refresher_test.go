@@ -0,0 +1,79 @@
+package dhcp_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness/internal/dhcp"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestRefresher(t *testing.T) {
+	suite.Run(t, new(RefresherSuite))
+}
+
+type RefresherSuite struct {
+	suite.Suite
+	Dir string
+}
+
+func (s *RefresherSuite) SetupSuite() {
+	log.SetLevel(log.FatalLevel)
+}
+
+func (s *RefresherSuite) SetupTest() {
+	var err error
+	s.Dir, err = ioutil.TempDir("", "cdhcpd-refresher-test")
+	s.Require().NoError(err)
+}
+
+func (s *RefresherSuite) TearDownTest() {
+	_ = os.RemoveAll(s.Dir)
+}
+
+func (s *RefresherSuite) writeTemplate(name, contents string) string {
+	path := filepath.Join(s.Dir, name)
+	s.Require().NoError(ioutil.WriteFile(path, []byte(contents), 0644))
+	return path
+}
+
+func (s *RefresherSuite) TestLoadTemplates() {
+	defaults := dhcp.NewRefresher("example.com")
+	hypervisors := s.writeTemplate("hypervisors.tmpl", "hypervisors {{.Domain}}")
+	guests := s.writeTemplate("guests.tmpl", "guests {{.Domain}}")
+	invalid := s.writeTemplate("invalid.tmpl", "{{.Domain")
+
+	tests := []struct {
+		description         string
+		hypervisorsPath     string
+		guestsPath          string
+		expectedHypervisors string
+		expectedGuests      string
+		expectedErr         bool
+	}{
+		{"no paths", "", "", defaults.HypervisorsTemplate, defaults.GuestsTemplate, false},
+		{"hypervisors only", hypervisors, "", "hypervisors {{.Domain}}", defaults.GuestsTemplate, false},
+		{"guests only", "", guests, defaults.HypervisorsTemplate, "guests {{.Domain}}", false},
+		{"both", hypervisors, guests, "hypervisors {{.Domain}}", "guests {{.Domain}}", false},
+		{"missing file", filepath.Join(s.Dir, "missing"), "", "", "", true},
+		{"invalid template", "", invalid, "", "", true},
+	}
+
+	for _, test := range tests {
+		msg := func(m string) string { return test.description + " : " + m }
+		r := dhcp.NewRefresher("example.com")
+		err := r.LoadTemplates(test.hypervisorsPath, test.guestsPath)
+		if test.expectedErr {
+			s.Error(err, msg("should error"))
+			continue
+		}
+		if !s.NoError(err, msg("should not error")) {
+			continue
+		}
+		s.Equal(test.expectedHypervisors, r.HypervisorsTemplate, msg("wrong hypervisors template"))
+		s.Equal(test.expectedGuests, r.GuestsTemplate, msg("wrong guests template"))
+	}
+}
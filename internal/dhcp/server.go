@@ -0,0 +1,251 @@
+package dhcp
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/krolaw/dhcp4"
+	"github.com/mistifyio/lochness"
+)
+
+type (
+	// Server answers DHCPv4 requests of the hypervisors and guests itself,
+	// from the hosts it is updated with, in place of dhcpd. Only known hosts
+	// are answered, each always with its fixed address.
+	Server struct {
+		ip         net.IP
+		leaseTime  time.Duration
+		dnsServers []net.IP
+
+		mu     sync.RWMutex
+		domain string
+		hosts  map[string]*dhcpHost // by mac
+	}
+
+	// dhcpHost is what a hypervisor or guest is told, as in its host
+	// declaration of hypervisors.conf or guests.conf
+	dhcpHost struct {
+		ID         string
+		IP         net.IP
+		Netmask    net.IPMask
+		Gateway    net.IP
+		DomainName string
+		// Hypervisor is set for hypervisors, which are network booted
+		Hypervisor bool
+	}
+)
+
+// DefaultLeaseTime is how long the leases of the embedded server last, unless
+// configured otherwise
+const DefaultLeaseTime = 12 * time.Hour
+
+// NewServer creates a Server identifying itself with ip. A leaseTime of 0 is
+// DefaultLeaseTime. dnsServers, if any, are given to every host.
+func NewServer(ip net.IP, leaseTime time.Duration, dnsServers []net.IP) *Server {
+	if leaseTime == 0 {
+		leaseTime = DefaultLeaseTime
+	}
+	return &Server{
+		ip:         ip.To4(),
+		leaseTime:  leaseTime,
+		dnsServers: dnsServers,
+		hosts:      map[string]*dhcpHost{},
+	}
+}
+
+// Update replaces the hosts answered with the hypervisors and the guests in
+// guests.conf. Hosts without a MAC or an IPv4 address are left out, as are
+// hosts with the MAC of another, after the first by id.
+func (s *Server) Update(domain string, hypervisors map[string]*lochness.Hypervisor, guests map[string]*lochness.Guest, subnets map[string]*lochness.Subnet) {
+	hosts := map[string]*dhcpHost{}
+	add := func(mac net.HardwareAddr, h *dhcpHost) {
+		if len(mac) == 0 || h.IP.To4() == nil {
+			return
+		}
+		if other, ok := hosts[mac.String()]; ok {
+			log.WithFields(log.Fields{
+				"mac":   mac.String(),
+				"host":  h.ID,
+				"other": other.ID,
+			}).Warn("skipping host with the mac of another")
+			return
+		}
+		hosts[mac.String()] = h
+	}
+
+	hkeys := make([]string, 0, len(hypervisors))
+	for id := range hypervisors {
+		hkeys = append(hkeys, id)
+	}
+	sort.Strings(hkeys)
+	for _, id := range hkeys {
+		hv := hypervisors[id]
+		add(hv.MAC, &dhcpHost{
+			ID:         hv.ID,
+			IP:         hv.IP.To4(),
+			Netmask:    net.IPMask(hv.Netmask.To4()),
+			Gateway:    hv.Gateway.To4(),
+			DomainName: "nodes." + domain,
+			Hypervisor: true,
+		})
+	}
+
+	gkeys := make([]string, 0, len(guests))
+	for id := range guests {
+		gkeys = append(gkeys, id)
+	}
+	sort.Strings(gkeys)
+	for _, id := range gkeys {
+		g := guests[id]
+		if g.HypervisorID == "" || g.SubnetID == "" {
+			continue
+		}
+		subnet, ok := subnets[g.SubnetID]
+		if !ok {
+			continue
+		}
+		add(g.MAC, &dhcpHost{
+			ID:         g.ID,
+			IP:         g.IP.To4(),
+			Netmask:    subnet.CIDR.Mask,
+			Gateway:    subnet.Gateway.To4(),
+			DomainName: "guests." + domain,
+		})
+	}
+
+	s.mu.Lock()
+	s.domain = domain
+	s.hosts = hosts
+	s.mu.Unlock()
+}
+
+// Serve answers the requests read from conn until reading fails, e.g. once
+// conn is closed. Replies that cannot be sent are logged and skipped.
+func (s *Server) Serve(conn net.PacketConn) error {
+	return dhcp4.Serve(replyConn{conn}, s)
+}
+
+// replyConn is a dhcp4.ServeConn whose failed writes are logged instead of
+// returned, since dhcp4.Serve gives up on the first one, and a reply to one
+// client failing, e.g. for lack of a route, is no reason to stop serving
+type replyConn struct {
+	net.PacketConn
+}
+
+// WriteTo writes a reply, logging and swallowing any error
+func (c replyConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	n, err := c.PacketConn.WriteTo(b, addr)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "net.PacketConn.WriteTo",
+			"addr":  addr.String(),
+		}).Error("failed to send dhcp reply")
+		return len(b), nil
+	}
+	return n, nil
+}
+
+// ServeDHCP answers a request, see dhcp4.Handler. Discovers and requests of
+// unknown hosts are ignored, so that another server may answer them, while
+// requests for an address other than a known host's are refused.
+func (s *Server) ServeDHCP(req dhcp4.Packet, msgType dhcp4.MessageType, options dhcp4.Options) dhcp4.Packet {
+	s.mu.RLock()
+	host, ok := s.hosts[req.CHAddr().String()]
+	domain := s.domain
+	s.mu.RUnlock()
+
+	fields := log.Fields{
+		"mac":  req.CHAddr().String(),
+		"type": msgType.String(),
+	}
+	if !ok {
+		log.WithFields(fields).Debug("ignoring request of unknown host")
+		return nil
+	}
+	fields["host"] = host.ID
+
+	switch msgType {
+	case dhcp4.Discover:
+		log.WithFields(fields).Debug("offering")
+		return s.reply(req, dhcp4.Offer, host, domain, options)
+
+	case dhcp4.Request:
+		// a request for another server's offer
+		if id, ok := options[dhcp4.OptionServerIdentifier]; ok && !net.IP(id).Equal(s.ip) {
+			return nil
+		}
+		requested := net.IP(options[dhcp4.OptionRequestedIPAddress])
+		if requested == nil {
+			requested = req.CIAddr()
+		}
+		if !requested.Equal(host.IP) {
+			log.WithFields(fields).WithField("requested", requested.String()).Info("refusing request for another address")
+			return dhcp4.ReplyPacket(req, dhcp4.NAK, s.ip, nil, 0, nil)
+		}
+		log.WithFields(fields).Debug("acknowledging")
+		return s.reply(req, dhcp4.ACK, host, domain, options)
+
+	case dhcp4.Inform:
+		reply := s.reply(req, dhcp4.ACK, host, domain, options)
+		reply.SetYIAddr(net.IPv4zero)
+		return reply
+	}
+
+	// releases and declines need no answer, the address stays the host's
+	return nil
+}
+
+// reply builds the offer or ack of a host, with the options it asked for
+func (s *Server) reply(req dhcp4.Packet, msgType dhcp4.MessageType, host *dhcpHost, domain string, options dhcp4.Options) dhcp4.Packet {
+	hostOptions := dhcp4.Options{
+		dhcp4.OptionSubnetMask: []byte(host.Netmask),
+		dhcp4.OptionDomainName: []byte(host.DomainName),
+	}
+	if host.Gateway != nil {
+		hostOptions[dhcp4.OptionRouter] = []byte(host.Gateway)
+	}
+	if len(s.dnsServers) > 0 {
+		hostOptions[dhcp4.OptionDomainNameServer] = dhcp4.JoinIPs(s.dnsServers)
+	}
+
+	reply := dhcp4.ReplyPacket(req, msgType, s.ip, host.IP, s.leaseTime,
+		hostOptions.SelectOrderOrAll(options[dhcp4.OptionParameterRequestList]))
+
+	if host.Hypervisor {
+		s.setBoot(reply, host, domain, options)
+	}
+	return reply
+}
+
+// setBoot tells a hypervisor what to network boot, as hypervisors.conf does:
+// the iPXE script of the hypervisor if it is running iPXE already, otherwise
+// BootFile from tftp.services.<domain>
+func (s *Server) setBoot(reply dhcp4.Packet, host *dhcpHost, domain string, options dhcp4.Options) {
+	if string(options[dhcp4.OptionUserClass]) == "iPXE" {
+		reply.SetFile([]byte("http://ipxe.services." + domain + ":8888/ipxe/" + host.IP.String()))
+		return
+	}
+
+	tftp := "tftp.services." + domain
+	reply.SetSName([]byte(tftp))
+	reply.SetFile([]byte(BootFile))
+	ips, err := net.LookupIP(tftp)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "net.LookupIP",
+			"host":  tftp,
+		}).Error("could not resolve the tftp server")
+		return
+	}
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			reply.SetSIAddr(ip)
+			return
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package dhcp_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/krolaw/dhcp4"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/dhcp"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestServer(t *testing.T) {
+	suite.Run(t, new(ServerSuite))
+}
+
+type ServerSuite struct {
+	suite.Suite
+	Server        *dhcp.Server
+	ServerIP      net.IP
+	HypervisorMAC net.HardwareAddr
+	GuestMAC      net.HardwareAddr
+}
+
+func (s *ServerSuite) SetupSuite() {
+	log.SetLevel(log.FatalLevel)
+}
+
+func (s *ServerSuite) SetupTest() {
+	s.ServerIP = net.ParseIP("127.0.0.1")
+	s.HypervisorMAC, _ = net.ParseMAC("4c:3f:b1:7e:54:01")
+	s.GuestMAC, _ = net.ParseMAC("4c:3f:b1:7e:54:02")
+	_, cidr, _ := net.ParseCIDR("10.0.0.0/24")
+
+	hypervisors := map[string]*lochness.Hypervisor{
+		"hv1": {
+			ID:      "hv1",
+			MAC:     s.HypervisorMAC,
+			IP:      net.ParseIP("192.168.1.10"),
+			Netmask: net.ParseIP("255.255.255.0"),
+			Gateway: net.ParseIP("192.168.1.1"),
+		},
+	}
+	guests := map[string]*lochness.Guest{
+		"g1": {ID: "g1", HypervisorID: "hv1", SubnetID: "s1", MAC: s.GuestMAC, IP: net.ParseIP("10.0.0.5")},
+	}
+	subnets := map[string]*lochness.Subnet{
+		"s1": {ID: "s1", CIDR: cidr, Gateway: net.ParseIP("10.0.0.1")},
+	}
+
+	s.Server = dhcp.NewServer(s.ServerIP, time.Hour, []net.IP{net.ParseIP("10.0.0.2")})
+	s.Server.Update("example.com", hypervisors, guests, subnets)
+}
+
+// serve has the server answer a request, as dhcp4.Serve does
+func (s *ServerSuite) serve(mt dhcp4.MessageType, mac net.HardwareAddr, options ...dhcp4.Option) (dhcp4.Packet, dhcp4.Options) {
+	req := dhcp4.RequestPacket(mt, mac, nil, []byte{1, 2, 3, 4}, false, options)
+	reply := s.Server.ServeDHCP(req, mt, req.ParseOptions())
+	if reply == nil {
+		return nil, nil
+	}
+	return reply, reply.ParseOptions()
+}
+
+func (s *ServerSuite) TestUnknownHost() {
+	mac, _ := net.ParseMAC("4c:3f:b1:7e:54:ff")
+	reply, _ := s.serve(dhcp4.Discover, mac)
+	s.Nil(reply, "unknown hosts should be ignored")
+}
+
+func (s *ServerSuite) TestGuest() {
+	reply, options := s.serve(dhcp4.Discover, s.GuestMAC)
+	s.Require().NotNil(reply)
+	s.Equal([]byte{byte(dhcp4.Offer)}, options[dhcp4.OptionDHCPMessageType])
+	s.Equal("10.0.0.5", reply.YIAddr().String())
+	s.Equal("255.255.255.0", net.IP(options[dhcp4.OptionSubnetMask]).String())
+	s.Equal("10.0.0.1", net.IP(options[dhcp4.OptionRouter]).String())
+	s.Equal("10.0.0.2", net.IP(options[dhcp4.OptionDomainNameServer]).String())
+	s.Equal("guests.example.com", string(options[dhcp4.OptionDomainName]))
+	s.True(s.ServerIP.Equal(net.IP(options[dhcp4.OptionServerIdentifier])))
+	s.Equal(dhcp4.OptionsLeaseTime(time.Hour), options[dhcp4.OptionIPAddressLeaseTime])
+
+	reply, options = s.serve(dhcp4.Request, s.GuestMAC,
+		dhcp4.Option{Code: dhcp4.OptionRequestedIPAddress, Value: net.ParseIP("10.0.0.5").To4()},
+		dhcp4.Option{Code: dhcp4.OptionServerIdentifier, Value: s.ServerIP.To4()})
+	s.Require().NotNil(reply)
+	s.Equal([]byte{byte(dhcp4.ACK)}, options[dhcp4.OptionDHCPMessageType])
+	s.Equal("10.0.0.5", reply.YIAddr().String())
+
+	reply, options = s.serve(dhcp4.Request, s.GuestMAC,
+		dhcp4.Option{Code: dhcp4.OptionRequestedIPAddress, Value: net.ParseIP("10.0.0.9").To4()})
+	s.Require().NotNil(reply)
+	s.Equal([]byte{byte(dhcp4.NAK)}, options[dhcp4.OptionDHCPMessageType], "requests for another address should be refused")
+
+	reply, _ = s.serve(dhcp4.Request, s.GuestMAC,
+		dhcp4.Option{Code: dhcp4.OptionRequestedIPAddress, Value: net.ParseIP("10.0.0.5").To4()},
+		dhcp4.Option{Code: dhcp4.OptionServerIdentifier, Value: net.ParseIP("10.0.0.3").To4()})
+	s.Nil(reply, "requests for another server should be ignored")
+
+	reply, _ = s.serve(dhcp4.Release, s.GuestMAC)
+	s.Nil(reply)
+}
+
+func (s *ServerSuite) TestHypervisor() {
+	reply, options := s.serve(dhcp4.Discover, s.HypervisorMAC)
+	s.Require().NotNil(reply)
+	s.Equal("192.168.1.10", reply.YIAddr().String())
+	s.Equal("nodes.example.com", string(options[dhcp4.OptionDomainName]))
+	s.Equal(dhcp.BootFile, string(reply.File()))
+	s.Equal("tftp.services.example.com", string(reply.SName()))
+
+	reply, _ = s.serve(dhcp4.Discover, s.HypervisorMAC, dhcp4.Option{Code: dhcp4.OptionUserClass, Value: []byte("iPXE")})
+	s.Require().NotNil(reply)
+	s.Equal("http://ipxe.services.example.com:8888/ipxe/192.168.1.10", string(reply.File()))
+}
+
+func (s *ServerSuite) TestUpdate() {
+	s.Server.Update("example.com", map[string]*lochness.Hypervisor{}, map[string]*lochness.Guest{}, map[string]*lochness.Subnet{})
+	reply, _ := s.serve(dhcp4.Discover, s.GuestMAC)
+	s.Nil(reply, "removed hosts should no longer be answered")
+}
+
+func (s *ServerSuite) TestServe() {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	s.Require().NoError(err)
+	go func() { _ = s.Server.Serve(conn) }()
+	defer func() { _ = conn.Close() }()
+
+	client, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	s.Require().NoError(err)
+	defer func() { _ = client.Close() }()
+
+	req := dhcp4.RequestPacket(dhcp4.Discover, s.GuestMAC, nil, []byte{1, 2, 3, 4}, false, nil)
+	_, err = client.WriteTo(req, conn.LocalAddr())
+	s.Require().NoError(err)
+
+	s.Require().NoError(client.SetReadDeadline(time.Now().Add(5 * time.Second)))
+	buf := make([]byte, 1500)
+	n, _, err := client.ReadFrom(buf)
+	s.Require().NoError(err)
+	reply := dhcp4.Packet(buf[:n])
+	s.Equal("10.0.0.5", reply.YIAddr().String())
+}
+
+// failingConn reads the given packets, then fails, and fails every write
+type failingConn struct {
+	net.PacketConn
+	packets [][]byte
+	writes  int
+}
+
+func (c *failingConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	if len(c.packets) == 0 {
+		return 0, nil, errors.New("closed")
+	}
+	n := copy(b, c.packets[0])
+	c.packets = c.packets[1:]
+	return n, &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 68}, nil
+}
+
+func (c *failingConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.writes++
+	return 0, errors.New("no route to host")
+}
+
+func (s *ServerSuite) TestServeWriteErrors() {
+	req := dhcp4.RequestPacket(dhcp4.Discover, s.GuestMAC, nil, []byte{1, 2, 3, 4}, false, nil)
+	conn := &failingConn{packets: [][]byte{req, req}}
+
+	err := s.Server.Serve(conn)
+	s.EqualError(err, "closed", "only reads should stop serving")
+	s.Equal(2, conn.writes, "failed replies should be skipped")
+}
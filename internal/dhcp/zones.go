@@ -0,0 +1,371 @@
+package dhcp
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness"
+)
+
+type (
+	// zone is a DNS zone to be written out as a BIND zone file
+	zone struct {
+		Name    string
+		Domain  string
+		Serial  uint32
+		Records []zoneRecord
+	}
+
+	// zoneRecord is a single resource record in a zone
+	zoneRecord struct {
+		Name  string
+		Type  string
+		Value string
+	}
+
+	// ZoneWriter writes BIND zone files for the hypervisors and guests into a
+	// directory, bumping the serial of each zone whose records change and
+	// optionally running a reload command for it
+	ZoneWriter struct {
+		Dir       string
+		ReloadCmd string
+		hashes    map[string][]byte
+	}
+)
+
+// zoneHeader starts every zone file written, telling them from the others
+const zoneHeader = "; Auto generated by cdhcpd, do not edit"
+
+var zoneTemplate = zoneHeader + `
+$ORIGIN {{.Name}}.
+$TTL 300
+@ IN SOA ns.services.{{.Domain}}. hostmaster.{{.Domain}}. (
+    {{.Serial}} ; serial
+    3600 ; refresh
+    600 ; retry
+    604800 ; expire
+    300 ; minimum
+)
+@ IN NS ns.services.{{.Domain}}.
+{{range $r := .Records}}{{$r.Name}} IN {{$r.Type}} {{$r.Value}}
+{{end}}`
+
+// matchSerial finds the serial in a zone file written from zoneTemplate
+var matchSerial = regexp.MustCompile(`(?m)^\s*(\d+) ; serial$`)
+
+// NewZoneWriter creates a new ZoneWriter
+func NewZoneWriter(dir, reloadCmd string) *ZoneWriter {
+	return &ZoneWriter{
+		Dir:       dir,
+		ReloadCmd: reloadCmd,
+		hashes:    map[string][]byte{},
+	}
+}
+
+// reverseZone returns the IPv4 reverse zone of an address in a network with
+// the given prefix length, and the name of its record in it. Reverse zones are
+// delegated on octet boundaries, so a network whose prefix is not gets the
+// zones of the longer prefix within it, e.g. a /20 those of its /24s. Networks
+// smaller than a /24 share the zone of theirs.
+func reverseZone(ip4 net.IP, ones int) (string, string) {
+	octets := (ones + 7) / 8
+	if octets < 1 {
+		octets = 1
+	}
+	if octets > 3 {
+		octets = 3
+	}
+	zone := make([]string, 0, octets)
+	for i := octets - 1; i >= 0; i-- {
+		zone = append(zone, strconv.Itoa(int(ip4[i])))
+	}
+	name := make([]string, 0, 4-octets)
+	for i := 3; i >= octets; i-- {
+		name = append(name, strconv.Itoa(int(ip4[i])))
+	}
+	return strings.Join(zone, ".") + ".in-addr.arpa", strings.Join(name, ".")
+}
+
+// prefixLength returns the length of the prefix of an IPv4 network mask, or 24
+// for a missing or non-canonical one
+func prefixLength(mask net.IPMask) int {
+	if len(mask) == net.IPv6len {
+		mask = mask[12:]
+	}
+	ones, bits := mask.Size()
+	if bits != 8*net.IPv4len {
+		return 24
+	}
+	return ones
+}
+
+// genZones builds the forward zones nodes.<domain> and guests.<domain>, and
+// one for each domain of the DNS names of the guests, along with the IPv4
+// reverse zones for their addresses, derived from the netmasks of the
+// hypervisors and the CIDRs of the subnets of the guests. The reverse record
+// of a guest with a DNS name points to that name.
+func (r *Refresher) genZones(hypervisors map[string]*lochness.Hypervisor, guests map[string]*lochness.Guest, subnets map[string]*lochness.Subnet) []*zone {
+	nodes := &zone{Name: "nodes." + r.Domain, Domain: r.Domain}
+	guestZone := &zone{Name: "guests." + r.Domain, Domain: r.Domain}
+	named := map[string]*zone{nodes.Name: nodes, guestZone.Name: guestZone}
+	reverse := map[string]*zone{}
+	// DNS names taken, normally unique already, see lochness.DNSRecord
+	taken := map[string]string{}
+
+	addPTR := func(ip net.IP, mask net.IPMask, target string) {
+		ip4 := ip.To4()
+		if ip4 == nil {
+			return
+		}
+		name, record := reverseZone(ip4, prefixLength(mask))
+		z, ok := reverse[name]
+		if !ok {
+			z = &zone{Name: name, Domain: r.Domain}
+			reverse[name] = z
+		}
+		z.Records = append(z.Records, zoneRecord{
+			Name:  record,
+			Type:  "PTR",
+			Value: target + ".",
+		})
+	}
+
+	hkeys := make([]string, 0, len(hypervisors))
+	for id := range hypervisors {
+		hkeys = append(hkeys, id)
+	}
+	sort.Strings(hkeys)
+	for _, id := range hkeys {
+		hv := hypervisors[id]
+		if hv.IP == nil {
+			continue
+		}
+		nodes.Records = append(nodes.Records, zoneRecord{Name: hv.ID, Type: "A", Value: hv.IP.String()})
+		addPTR(hv.IP, net.IPMask(hv.Netmask), hv.ID+"."+nodes.Name)
+	}
+
+	gkeys := make([]string, 0, len(guests))
+	for id := range guests {
+		gkeys = append(gkeys, id)
+	}
+	sort.Strings(gkeys)
+	for _, id := range gkeys {
+		g := guests[id]
+		// same guests as guests.conf
+		if g.HypervisorID == "" || g.SubnetID == "" || g.IP == nil {
+			continue
+		}
+		subnet, ok := subnets[g.SubnetID]
+		if !ok {
+			continue
+		}
+		guestZone.Records = append(guestZone.Records, zoneRecord{Name: g.ID, Type: "A", Value: g.IP.String()})
+		target := g.ID + "." + guestZone.Name
+
+		if g.DNSName != "" {
+			host, domain, err := lochness.ParseDNSName(g.DNSName)
+			switch {
+			case err != nil:
+				log.WithFields(log.Fields{
+					"error": err,
+					"guest": g.ID,
+				}).Warn("skipping invalid dns name")
+			case taken[g.DNSName] != "":
+				log.WithFields(log.Fields{
+					"name":  g.DNSName,
+					"guest": g.ID,
+					"owner": taken[g.DNSName],
+				}).Warn("skipping dns name of another guest")
+			default:
+				taken[g.DNSName] = g.ID
+				z, ok := named[domain]
+				if !ok {
+					z = &zone{Name: domain, Domain: r.Domain}
+					named[domain] = z
+				}
+				z.Records = append(z.Records, zoneRecord{Name: host, Type: "A", Value: g.IP.String()})
+				target = g.DNSName
+			}
+		}
+		var mask net.IPMask
+		if subnet.CIDR != nil {
+			mask = subnet.CIDR.Mask
+		}
+		addPTR(g.IP, mask, target)
+	}
+
+	zones := []*zone{nodes, guestZone}
+	domains := make([]string, 0, len(named))
+	for name := range named {
+		if name != nodes.Name && name != guestZone.Name {
+			domains = append(domains, name)
+		}
+	}
+	sort.Strings(domains)
+	for _, name := range domains {
+		zones = append(zones, named[name])
+	}
+	names := make([]string, 0, len(reverse))
+	for name := range reverse {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		zones = append(zones, reverse[name])
+	}
+	return zones
+}
+
+// genZone writes a zone file
+func genZone(w io.Writer, z *zone) error {
+	t, err := template.New("zone").Parse(zoneTemplate)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "template.Parse",
+		}).Error("could not parse zone template")
+		return err
+	}
+	if err = t.Execute(w, z); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "template.Execute",
+			"zone":  z.Name,
+		}).Error("could not execute zone template")
+		return err
+	}
+	return nil
+}
+
+// Update writes out any zones whose records have changed since they were last
+// written and reloads them. Zone files written before, by this or an earlier
+// ZoneWriter, of zones that no longer have records are removed, and the
+// removed zones reloaded as well.
+func (zw *ZoneWriter) Update(r *Refresher, hypervisors map[string]*lochness.Hypervisor, guests map[string]*lochness.Guest, subnets map[string]*lochness.Subnet) error {
+	zones := r.genZones(hypervisors, guests, subnets)
+	current := make(map[string]bool, len(zones))
+	for _, z := range zones {
+		current[z.Name] = true
+
+		// hash the zone without a serial so only record changes are noticed
+		hash := md5.New()
+		buff := bufio.NewWriter(hash)
+		if err := genZone(buff, z); err != nil {
+			return err
+		}
+		if err := buff.Flush(); err != nil {
+			return err
+		}
+		checksum := hash.Sum(nil)
+		if bytes.Equal(zw.hashes[z.Name], checksum) {
+			continue
+		}
+
+		path := filepath.Join(zw.Dir, z.Name+".zone")
+		z.Serial = nextSerial(readSerial(path))
+		if _, err := writeConfig("zone", path, nil, func(w io.Writer) error {
+			return genZone(w, z)
+		}); err != nil {
+			return err
+		}
+		zw.hashes[z.Name] = checksum
+
+		zw.reload(z.Name)
+	}
+	return zw.removeStale(current)
+}
+
+// removeStale removes the zone files written from zoneTemplate, and left
+// alone otherwise, of zones that are not current
+func (zw *ZoneWriter) removeStale(current map[string]bool) error {
+	paths, err := filepath.Glob(filepath.Join(zw.Dir, "*.zone"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".zone")
+		if current[name] {
+			continue
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if !bytes.HasPrefix(data, []byte(zoneHeader)) {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"func":  "os.Remove",
+				"zone":  name,
+			}).Error("failed to remove stale zone")
+			return err
+		}
+		delete(zw.hashes, name)
+		log.WithField("zone", name).Info("removed stale zone")
+
+		zw.reload(name)
+	}
+	return nil
+}
+
+// reload runs the reload command, if any, for a zone
+func (zw *ZoneWriter) reload(name string) {
+	args := strings.Fields(zw.ReloadCmd)
+	if len(args) == 0 {
+		return
+	}
+
+	cmd := exec.Command(args[0], append(args[1:], name)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "cmd.Run",
+			"zone":  name,
+		}).Error("failed to reload zone")
+	}
+}
+
+// readSerial returns the serial of an existing zone file, or 0
+func readSerial(path string) uint32 {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	match := matchSerial.FindSubmatch(data)
+	if match == nil {
+		return 0
+	}
+	serial, err := strconv.ParseUint(string(match[1]), 10, 32)
+	if err != nil {
+		return 0
+	}
+	return uint32(serial)
+}
+
+// nextSerial returns a serial greater than previous. Serials are the current
+// unix time where possible so they stay meaningful across restarts.
+func nextSerial(previous uint32) uint32 {
+	serial := uint32(time.Now().Unix())
+	if serial <= previous {
+		serial = previous + 1
+	}
+	return serial
+}
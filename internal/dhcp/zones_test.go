@@ -0,0 +1,171 @@
+package dhcp_test
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/dhcp"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestZoneWriter(t *testing.T) {
+	suite.Run(t, new(ZoneWriterSuite))
+}
+
+type ZoneWriterSuite struct {
+	suite.Suite
+	Dir         string
+	Refresher   *dhcp.Refresher
+	Hypervisors map[string]*lochness.Hypervisor
+	Guests      map[string]*lochness.Guest
+	Subnets     map[string]*lochness.Subnet
+}
+
+func (s *ZoneWriterSuite) SetupSuite() {
+	log.SetLevel(log.FatalLevel)
+}
+
+func (s *ZoneWriterSuite) SetupTest() {
+	var err error
+	s.Dir, err = ioutil.TempDir("", "cdhcpd-zones-test")
+	s.Require().NoError(err)
+
+	s.Refresher = dhcp.NewRefresher("example.com")
+	s.Hypervisors = map[string]*lochness.Hypervisor{
+		"hv1": {ID: "hv1", IP: net.ParseIP("192.168.1.10")},
+	}
+	s.Guests = map[string]*lochness.Guest{
+		"g1": {ID: "g1", HypervisorID: "hv1", SubnetID: "s1", IP: net.ParseIP("10.0.0.5")},
+		"g2": {ID: "g2", SubnetID: "s1", IP: net.ParseIP("10.0.0.6")},
+	}
+	s.Subnets = map[string]*lochness.Subnet{
+		"s1": {ID: "s1"},
+	}
+}
+
+func (s *ZoneWriterSuite) TearDownTest() {
+	_ = os.RemoveAll(s.Dir)
+}
+
+func (s *ZoneWriterSuite) readZone(name string) string {
+	data, err := ioutil.ReadFile(filepath.Join(s.Dir, name+".zone"))
+	s.Require().NoError(err)
+	return string(data)
+}
+
+func (s *ZoneWriterSuite) serial(name string) string {
+	match := regexp.MustCompile(`(\d+) ; serial`).FindStringSubmatch(s.readZone(name))
+	s.Require().NotNil(match)
+	return match[1]
+}
+
+func (s *ZoneWriterSuite) TestUpdate() {
+	zw := dhcp.NewZoneWriter(s.Dir, "")
+	s.Require().NoError(zw.Update(s.Refresher, s.Hypervisors, s.Guests, s.Subnets))
+
+	nodes := s.readZone("nodes.example.com")
+	s.Contains(nodes, "$ORIGIN nodes.example.com.")
+	s.Contains(nodes, "hv1 IN A 192.168.1.10")
+
+	guests := s.readZone("guests.example.com")
+	s.Contains(guests, "g1 IN A 10.0.0.5")
+	s.NotContains(guests, "g2", "unassigned guest should not be included")
+
+	s.Contains(s.readZone("1.168.192.in-addr.arpa"), "10 IN PTR hv1.nodes.example.com.")
+	s.Contains(s.readZone("0.0.10.in-addr.arpa"), "5 IN PTR g1.guests.example.com.")
+}
+
+func (s *ZoneWriterSuite) TestSerial() {
+	zw := dhcp.NewZoneWriter(s.Dir, "")
+	s.Require().NoError(zw.Update(s.Refresher, s.Hypervisors, s.Guests, s.Subnets))
+	nodesSerial := s.serial("nodes.example.com")
+	guestsSerial := s.serial("guests.example.com")
+
+	s.Guests["g3"] = &lochness.Guest{ID: "g3", HypervisorID: "hv1", SubnetID: "s1", IP: net.ParseIP("10.0.0.7")}
+	s.Require().NoError(zw.Update(s.Refresher, s.Hypervisors, s.Guests, s.Subnets))
+	s.Equal(nodesSerial, s.serial("nodes.example.com"), "unchanged zone should keep its serial")
+	s.True(s.serial("guests.example.com") > guestsSerial, "changed zone should bump its serial")
+
+	// a new writer picks up from the serial on disk
+	guestsSerial = s.serial("guests.example.com")
+	zw = dhcp.NewZoneWriter(s.Dir, "")
+	s.Require().NoError(zw.Update(s.Refresher, s.Hypervisors, s.Guests, s.Subnets))
+	s.True(s.serial("guests.example.com") > guestsSerial, "rewritten zone should bump its serial")
+}
+
+func (s *ZoneWriterSuite) TestReloadCmd() {
+	out := filepath.Join(s.Dir, "reloaded")
+	script := filepath.Join(s.Dir, "reload.sh")
+	s.Require().NoError(ioutil.WriteFile(script, []byte("#!/bin/sh\necho $1 >> "+out+"\n"), 0755))
+
+	zw := dhcp.NewZoneWriter(s.Dir, script)
+	s.Require().NoError(zw.Update(s.Refresher, s.Hypervisors, s.Guests, s.Subnets))
+	data, err := ioutil.ReadFile(out)
+	s.Require().NoError(err)
+	s.Contains(string(data), "nodes.example.com\n")
+	s.Contains(string(data), "guests.example.com\n")
+}
+
+func (s *ZoneWriterSuite) TestDNSNames() {
+	s.Guests["g1"].DNSName = "web1.apps.test"
+	s.Guests["g3"] = &lochness.Guest{ID: "g3", HypervisorID: "hv1", SubnetID: "s1", IP: net.ParseIP("10.0.0.7"), DNSName: "web1.apps.test"}
+	s.Guests["g4"] = &lochness.Guest{ID: "g4", HypervisorID: "hv1", SubnetID: "s1", IP: net.ParseIP("10.0.0.8"), DNSName: "db1.guests.example.com"}
+
+	zw := dhcp.NewZoneWriter(s.Dir, "")
+	s.Require().NoError(zw.Update(s.Refresher, s.Hypervisors, s.Guests, s.Subnets))
+
+	apps := s.readZone("apps.test")
+	s.Contains(apps, "$ORIGIN apps.test.")
+	s.Contains(apps, "web1 IN A 10.0.0.5")
+	s.NotContains(apps, "10.0.0.7", "a name should only be served for one guest")
+
+	guests := s.readZone("guests.example.com")
+	s.Contains(guests, "g1 IN A 10.0.0.5", "guests should keep their id records")
+	s.Contains(guests, "db1 IN A 10.0.0.8", "names in an existing zone should be added to it")
+
+	reverse := s.readZone("0.0.10.in-addr.arpa")
+	s.Contains(reverse, "5 IN PTR web1.apps.test.")
+	s.Contains(reverse, "7 IN PTR g3.guests.example.com.")
+}
+
+func (s *ZoneWriterSuite) TestReverseZonesFromCIDR() {
+	_, s.Subnets["s1"].CIDR, _ = net.ParseCIDR("10.0.0.0/16")
+	s.Subnets["s2"] = &lochness.Subnet{ID: "s2"}
+	_, s.Subnets["s2"].CIDR, _ = net.ParseCIDR("172.16.0.0/20")
+	s.Guests["g3"] = &lochness.Guest{ID: "g3", HypervisorID: "hv1", SubnetID: "s2", IP: net.ParseIP("172.16.3.4")}
+	s.Hypervisors["hv1"].Netmask = net.ParseIP("255.255.0.0")
+
+	zw := dhcp.NewZoneWriter(s.Dir, "")
+	s.Require().NoError(zw.Update(s.Refresher, s.Hypervisors, s.Guests, s.Subnets))
+
+	s.Contains(s.readZone("168.192.in-addr.arpa"), "10.1 IN PTR hv1.nodes.example.com.")
+	s.Contains(s.readZone("0.10.in-addr.arpa"), "5.0 IN PTR g1.guests.example.com.")
+	s.Contains(s.readZone("3.16.172.in-addr.arpa"), "4 IN PTR g3.guests.example.com.", "prefixes should be rounded to the octet within")
+	_, err := os.Stat(filepath.Join(s.Dir, "0.0.10.in-addr.arpa.zone"))
+	s.True(os.IsNotExist(err), "a /24 zone should not be written for a /16")
+}
+
+func (s *ZoneWriterSuite) TestStaleZones() {
+	other := filepath.Join(s.Dir, "other.test.zone")
+	s.Require().NoError(ioutil.WriteFile(other, []byte("; written by hand\n"), 0644))
+
+	zw := dhcp.NewZoneWriter(s.Dir, "")
+	s.Require().NoError(zw.Update(s.Refresher, s.Hypervisors, s.Guests, s.Subnets))
+	s.readZone("0.0.10.in-addr.arpa")
+
+	// a new writer removes the zones left behind as well
+	_, s.Subnets["s1"].CIDR, _ = net.ParseCIDR("10.0.0.0/16")
+	zw = dhcp.NewZoneWriter(s.Dir, "")
+	s.Require().NoError(zw.Update(s.Refresher, s.Hypervisors, s.Guests, s.Subnets))
+	s.readZone("0.10.in-addr.arpa")
+	_, err := os.Stat(filepath.Join(s.Dir, "0.0.10.in-addr.arpa.zone"))
+	s.True(os.IsNotExist(err), "stale zones should be removed")
+	_, err = os.Stat(other)
+	s.NoError(err, "zones not written by the writer should be kept")
+}
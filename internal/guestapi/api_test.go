@@ -0,0 +1,757 @@
+package guestapi
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/armon/go-metrics"
+	"github.com/bakins/go-metrics-map"
+	"github.com/bakins/go-metrics-middleware"
+	"github.com/kr/beanstalk"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/changefeed"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	"github.com/mistifyio/lochness/internal/server"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/mistifyio/lochness/internal/tunnel"
+	"github.com/mistifyio/lochness/pkg/jobqueue"
+	"github.com/mistifyio/lochness/pkg/jsonschema"
+	"github.com/mistifyio/lochness/pkg/swagger"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestCGuestdAPI(t *testing.T) {
+	suite.Run(t, new(APISuite))
+}
+
+type APISuite struct {
+	common.Suite
+	Port           uint
+	BeanstalkdCmd  *exec.Cmd
+	BeanstalkdPath string
+	JobQueue       *jobqueue.Client
+	MetricsContext *MetricsContext
+	APIServer      *server.Server
+	Guest          *lochness.Guest
+	APIURL         string
+}
+
+// fakeConsoles connects to consoles that send their type and guest id
+type fakeConsoles struct{}
+
+func (fakeConsoles) DialConsole(guestID, consoleType string) (net.Conn, error) {
+	console, agent := net.Pipe()
+	go func() {
+		_, _ = fmt.Fprintf(agent, "%s %s\n", consoleType, guestID)
+		_ = agent.Close()
+	}()
+	return console, nil
+}
+
+func (s *APISuite) SetupSuite() {
+	s.Suite.SetupSuite()
+
+	log.SetLevel(log.FatalLevel)
+	s.Port = 51124
+	s.APIURL = fmt.Sprintf("http://localhost:%d/guests", s.Port)
+
+	// Metrics context
+	sink := mapsink.New()
+	fanout := metrics.FanoutSink{sink}
+	conf := metrics.DefaultConfig("cguestd-test")
+	conf.EnableHostname = false
+	m, _ := metrics.New(conf, fanout)
+	s.MetricsContext = &MetricsContext{
+		sink:    sink,
+		metrics: m,
+		mmw:     mmw.New(m),
+	}
+
+	// Beanstalkd
+	port := "59872"
+	s.BeanstalkdPath = fmt.Sprintf("127.0.0.1:%s", port)
+	s.BeanstalkdCmd = exec.Command("beanstalkd", "-p", port)
+	s.Require().NoError(s.BeanstalkdCmd.Start())
+
+	beanstalkdReady := false
+	for i := 0; i < 10; i++ {
+		if _, err := beanstalk.Dial("tcp", s.BeanstalkdPath); err == nil {
+			beanstalkdReady = true
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	s.Require().True(beanstalkdReady)
+
+	// Jobqueue
+	s.JobQueue, _ = jobqueue.NewClient(s.BeanstalkdPath, s.KV)
+
+	// Run the server
+	// Change feed
+	feed, err := changefeed.New(s.KV)
+	s.Require().NoError(err)
+	s.Require().NoError(feed.Start())
+
+	s.APIServer = Run(s.Port, s.Context, s.JobQueue, fakeConsoles{}, "", s.MetricsContext, feed, httpmw.Config{}, nil)
+	time.Sleep(100 * time.Millisecond)
+
+}
+
+func (s *APISuite) SetupTest() {
+	s.Suite.SetupTest()
+	s.Guest = s.NewGuest()
+}
+
+func (s *APISuite) TearDownSuite() {
+	stopChan := s.APIServer.StopChan()
+	s.APIServer.Stop(5 * time.Second)
+	<-stopChan
+
+	_ = s.BeanstalkdCmd.Process.Kill()
+	_ = s.BeanstalkdCmd.Wait()
+
+	s.Suite.TearDownSuite()
+}
+
+func (s *APISuite) TestGuestsList() {
+	var guests lochness.Guests
+	s.DoRequest("GET", s.APIURL, http.StatusOK, nil, &guests)
+
+	s.Len(guests, 1)
+	s.Equal(s.Guest.ID, guests[0].ID)
+}
+
+func (s *APISuite) TestGuestsListMetadata() {
+	s.Guest.Metadata = map[string]string{"env": "prod"}
+	s.Require().NoError(s.Guest.Save())
+	_ = s.NewGuest()
+
+	var guests lochness.Guests
+	s.DoRequest("GET", s.APIURL+"?metadata=env=prod", http.StatusOK, nil, &guests)
+	s.Require().Len(guests, 1)
+	s.Equal(s.Guest.ID, guests[0].ID)
+
+	var errResp httpmw.HTTPError
+	s.DoRequest("GET", s.APIURL+"?metadata=env", http.StatusBadRequest, nil, &errResp)
+	s.Equal(httpmw.ErrCodeValidationFailed, errResp.ErrorCode)
+}
+
+func (s *APISuite) TestGuestsListETag() {
+	resp, err := http.Get(s.APIURL)
+	s.Require().NoError(err)
+	_ = resp.Body.Close()
+	s.Equal(http.StatusOK, resp.StatusCode)
+	etag := resp.Header.Get("ETag")
+	s.NotEmpty(etag)
+
+	get := func(etag string) *http.Response {
+		req, err := http.NewRequest("GET", s.APIURL, nil)
+		s.Require().NoError(err)
+		req.Header.Set("If-None-Match", etag)
+		resp, err := http.DefaultClient.Do(req)
+		s.Require().NoError(err)
+		_ = resp.Body.Close()
+		return resp
+	}
+
+	resp = get(etag)
+	s.Equal(http.StatusNotModified, resp.StatusCode)
+	s.Equal(etag, resp.Header.Get("ETag"))
+
+	// a change to a guest changes the tag
+	s.Guest.Metadata = map[string]string{"env": "prod"}
+	s.Require().NoError(s.Guest.Save())
+	resp = get(etag)
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.NotEqual(etag, resp.Header.Get("ETag"))
+}
+
+func (s *APISuite) TestGuestAdd() {
+	s.Guest.ID = uuid.New()
+
+	var guestResp lochness.Guest
+	resp := s.DoRequest("POST", s.APIURL, http.StatusAccepted, s.Guest, &guestResp)
+	s.NotEmpty(resp.Header.Get("X-Guest-Job-ID"))
+
+	s.Equal(s.Guest.ID, guestResp.ID)
+}
+
+func (s *APISuite) TestGuestAddGenerateMAC() {
+	spec := map[string]interface{}{
+		"flavor":  s.Guest.FlavorID,
+		"network": s.Guest.NetworkID,
+	}
+
+	var guestResp lochness.Guest
+	s.DoRequest("POST", s.APIURL+"?mac_oui=52:54:00", http.StatusAccepted, spec, &guestResp)
+	s.Equal("52:54:00", guestResp.MAC[:3].String(), "should generate a mac with the oui")
+	s.NotEqual(s.Guest.MAC, guestResp.MAC)
+
+	s.DoRequest("POST", s.APIURL, http.StatusAccepted, spec, &guestResp)
+	s.Equal(lochness.DefaultMACOUI, guestResp.MAC[:3].String(), "should generate a mac with the cluster oui")
+
+	var errResp httpmw.HTTPError
+	s.DoRequest("POST", s.APIURL+"?mac_oui=01:00:5e", http.StatusBadRequest, spec, &errResp)
+	s.Equal(httpmw.ErrCodeValidationFailed, errResp.ErrorCode)
+}
+
+func (s *APISuite) TestGuestAddBatch() {
+	valid := map[string]interface{}{
+		"flavor":  s.Guest.FlavorID,
+		"network": s.Guest.NetworkID,
+	}
+	invalid := map[string]interface{}{
+		"network": s.Guest.NetworkID,
+	}
+	url := s.APIURL + "/batch"
+
+	var results []batchResult
+	s.DoRequest("POST", url, http.StatusAccepted, []interface{}{valid, invalid, valid}, &results)
+	s.Require().Len(results, 3)
+	for _, i := range []int{0, 2} {
+		s.Require().NotNil(results[i].Guest, "valid guests should be created")
+		s.NotEmpty(results[i].Job, "valid guests should have a job")
+		_, err := s.Context.Guest(results[i].Guest.ID)
+		s.NoError(err)
+	}
+	s.Nil(results[1].Guest, "invalid guests should not be created")
+	s.Equal(httpmw.ErrCodeValidationFailed, results[1].ErrorCode)
+	s.NotEqual(results[0].Guest.MAC, results[2].Guest.MAC)
+
+	var errResp httpmw.HTTPError
+	s.DoRequest("POST", url+"?all_or_nothing=true", http.StatusBadRequest, []interface{}{valid, invalid}, &errResp)
+	s.Equal(httpmw.ErrCodeValidationFailed, errResp.ErrorCode)
+	s.Contains(errResp.Message, "1: ")
+	s.DoRequest("POST", url, http.StatusBadRequest, []interface{}{invalid}, &errResp)
+	s.Equal(httpmw.ErrCodeValidationFailed, errResp.ErrorCode)
+	s.DoRequest("POST", url, http.StatusBadRequest, []interface{}{}, &errResp)
+	s.Equal("empty_batch", errResp.ErrorCode)
+	s.DoRequest("POST", url, http.StatusBadRequest, valid, &errResp)
+	s.Equal("invalid_json", errResp.ErrorCode)
+
+	s.DoRequest("POST", url+"?all_or_nothing=true", http.StatusAccepted, []interface{}{valid, valid}, &results)
+	s.Len(results, 2)
+}
+
+func (s *APISuite) TestGuestAddIdempotent() {
+	spec := fmt.Sprintf(`{"flavor":%q,"network":%q}`, s.Guest.FlavorID, s.Guest.NetworkID)
+	post := func(url, key, body string) (*http.Response, []byte) {
+		req, err := http.NewRequest("POST", url, strings.NewReader(body))
+		s.Require().NoError(err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(IdempotencyKeyHeader, key)
+		resp, err := http.DefaultClient.Do(req)
+		s.Require().NoError(err)
+		defer func() { _ = resp.Body.Close() }()
+		data, err := ioutil.ReadAll(resp.Body)
+		s.Require().NoError(err)
+		return resp, data
+	}
+
+	key := uuid.New()
+	resp, first := post(s.APIURL, key, spec)
+	s.Require().Equal(http.StatusAccepted, resp.StatusCode)
+	job := resp.Header.Get("X-Guest-Job-ID")
+	s.NotEmpty(job)
+	s.Empty(resp.Header.Get(IdempotentReplayHeader))
+
+	resp, retry := post(s.APIURL, key, spec)
+	s.Equal(http.StatusAccepted, resp.StatusCode)
+	s.Equal("true", resp.Header.Get(IdempotentReplayHeader))
+	s.Equal(job, resp.Header.Get("X-Guest-Job-ID"))
+	s.Equal(first, retry, "retries should get the first response")
+
+	var guests lochness.Guests
+	s.DoRequest("GET", s.APIURL, http.StatusOK, nil, &guests)
+	s.Len(guests, 2, "retries should not create guests")
+
+	resp, _ = post(s.APIURL, key, `{"flavor":"`+s.Guest.FlavorID+`"}`)
+	s.Equal(http.StatusUnprocessableEntity, resp.StatusCode, "a key may not be reused for another request")
+	resp, _ = post(s.APIURL+"/batch", key, "["+spec+"]")
+	s.Equal(http.StatusUnprocessableEntity, resp.StatusCode, "a key may not be reused for another route")
+
+	key = uuid.New()
+	resp, _ = post(s.APIURL+"/batch", key, "["+spec+","+spec+"]")
+	s.Equal(http.StatusAccepted, resp.StatusCode)
+	resp, _ = post(s.APIURL+"/batch", key, "["+spec+","+spec+"]")
+	s.Equal(http.StatusAccepted, resp.StatusCode)
+	s.Equal("true", resp.Header.Get(IdempotentReplayHeader))
+	s.DoRequest("GET", s.APIURL, http.StatusOK, nil, &guests)
+	s.Len(guests, 4)
+
+	resp, _ = post(s.APIURL, strings.Repeat("k", lochness.MaxIdempotencyKeyLength+1), spec)
+	s.Equal(http.StatusBadRequest, resp.StatusCode)
+}
+
+func (s *APISuite) TestCheckConstraints() {
+	h := s.NewHypervisor()
+	h.Metadata["rack"] = "r7"
+	s.Require().NoError(h.Save())
+	other := s.NewHypervisor()
+	other.Metadata["rack"] = "r12"
+	s.Require().NoError(other.Save())
+	url := s.APIURL + "/constraints"
+
+	var result constraintsResult
+	test := constraintsTest{Constraints: `metadata.rack != "r12"`}
+	s.DoRequest("POST", url, http.StatusOK, test, &result)
+	s.Equal(test.Constraints, result.Constraints)
+	s.Equal([]string{h.ID}, result.Hypervisors)
+
+	spec := map[string]interface{}{
+		"constraints": `guest.metadata.tier == "db" && metadata.rack == "r12"`,
+		"guest":       map[string]interface{}{"metadata": map[string]string{"tier": "db"}},
+	}
+	s.DoRequest("POST", url, http.StatusOK, spec, &result)
+	s.Equal([]string{other.ID}, result.Hypervisors)
+
+	var errResp httpmw.HTTPError
+	s.DoRequest("POST", url, http.StatusBadRequest, constraintsTest{Constraints: `metadata.rack !=`}, &errResp)
+	s.Equal(httpmw.ErrCodeValidationFailed, errResp.ErrorCode)
+	s.Equal([]string{"constraints"}, errResp.Fields)
+}
+
+func (s *APISuite) TestCapacityForecast() {
+	h := s.NewHypervisor()
+	_, _ = lochness.SetHypervisorID(h.ID)
+	s.Require().NoError(h.Heartbeat(60 * time.Second))
+	url := fmt.Sprintf("http://localhost:%d/capacity/forecast?flavor=%s", s.Port, s.Guest.FlavorID)
+
+	var forecast lochness.Forecast
+	s.DoRequest("GET", url+"&count=2", http.StatusOK, nil, &forecast)
+	s.True(forecast.Placeable)
+	s.Equal(2, forecast.Placed)
+	s.Require().Len(forecast.Hypervisors, 1)
+	s.Equal(h.ID, forecast.Hypervisors[0].Hypervisor)
+	s.Len(forecast.Domains, 1)
+
+	var errResp httpmw.HTTPError
+	s.DoRequest("GET", url+"&count=0", http.StatusBadRequest, nil, &errResp)
+	s.Equal(httpmw.ErrCodeValidationFailed, errResp.ErrorCode)
+	s.DoRequest("GET", url+"&count=two", http.StatusBadRequest, nil, &errResp)
+	s.Equal("invalid_count", errResp.ErrorCode)
+	s.DoRequest("GET", url[:len(url)-len(s.Guest.FlavorID)]+uuid.New(), http.StatusNotFound, nil, &errResp)
+	s.Equal("flavor_not_found", errResp.ErrorCode)
+}
+
+func (s *APISuite) TestSpreadViolations() {
+	h := s.NewHypervisor()
+	h.FailureDomain = &lochness.FailureDomain{Rack: "r1"}
+	s.Require().NoError(h.Save())
+	for i := 0; i < 2; i++ {
+		g := s.NewGuest()
+		g.AntiAffinity = "db"
+		g.HypervisorID = h.ID
+		s.Require().NoError(g.Save())
+	}
+	url := fmt.Sprintf("http://localhost:%d/guests/spread", s.Port)
+
+	var violations []lochness.SpreadViolation
+	s.DoRequest("GET", url, http.StatusOK, nil, &violations)
+	s.Empty(violations, "a single rack can not be spread across")
+
+	// a hypervisor without a failure domain is a domain of its own
+	_ = s.NewHypervisor()
+	s.DoRequest("GET", url+"?level=rack", http.StatusOK, nil, &violations)
+	s.Require().Len(violations, 1)
+	s.Equal("db", violations[0].Group)
+	s.Equal("r1", violations[0].Domain)
+
+	var errResp httpmw.HTTPError
+	s.DoRequest("GET", url+"?level=aisle", http.StatusBadRequest, nil, &errResp)
+	s.Equal(httpmw.ErrCodeValidationFailed, errResp.ErrorCode)
+}
+
+func (s *APISuite) TestGuestDNSNames() {
+	spec := map[string]interface{}{
+		"flavor":   s.Guest.FlavorID,
+		"network":  s.Guest.NetworkID,
+		"dns_name": "web1.example.com",
+	}
+
+	var guestResp lochness.Guest
+	s.DoRequest("POST", s.APIURL, http.StatusAccepted, spec, &guestResp)
+	s.Equal("web1.example.com", guestResp.DNSName)
+
+	var errResp httpmw.HTTPError
+	s.DoRequest("POST", s.APIURL, http.StatusConflict, spec, &errResp)
+	s.Equal("dns_name_in_use", errResp.ErrorCode)
+	s.DoRequest("POST", s.APIURL+"/batch", http.StatusConflict, []interface{}{spec}, &errResp)
+	s.Equal("dns_name_in_use", errResp.ErrorCode)
+
+	spec["dns_name"] = "db1.example.com"
+	var results []batchResult
+	s.DoRequest("POST", s.APIURL+"/batch", http.StatusAccepted, []interface{}{spec, spec}, &results)
+	s.Require().Len(results, 2)
+	s.NotNil(results[0].Guest)
+	s.Equal(httpmw.ErrCodeValidationFailed, results[1].ErrorCode, "names should be unique within a batch")
+
+	spec["dns_name"] = "Web1"
+	s.DoRequest("POST", s.APIURL, http.StatusBadRequest, spec, &errResp)
+	s.Equal(httpmw.ErrCodeValidationFailed, errResp.ErrorCode)
+
+	var records []lochness.DNSRecord
+	s.DoRequest("GET", s.APIURL+"/dns?domain=example.com", http.StatusOK, nil, &records)
+	s.Require().Len(records, 2)
+	s.Equal("db1.example.com", records[0].Name)
+	s.Equal(results[0].Guest.ID, records[0].GuestID)
+	s.Equal("web1.example.com", records[1].Name)
+	s.Equal(guestResp.ID, records[1].GuestID)
+	s.DoRequest("GET", s.APIURL+"/dns?domain=other.com", http.StatusOK, nil, &records)
+	s.Empty(records)
+	s.DoRequest("GET", s.APIURL+"/dns?domain=-bad", http.StatusBadRequest, nil, &errResp)
+	s.Equal(httpmw.ErrCodeValidationFailed, errResp.ErrorCode)
+}
+
+func (s *APISuite) TestGuestAddDefaultFWGroup() {
+	spec := map[string]interface{}{
+		"flavor":   s.Guest.FlavorID,
+		"network":  s.Guest.NetworkID,
+		"metadata": map[string]string{lochness.TenantMetadataKey: "acme"},
+	}
+
+	var guestResp lochness.Guest
+	s.DoRequest("POST", s.APIURL, http.StatusAccepted, spec, &guestResp)
+	s.Empty(guestResp.FWGroupID, "no fwgroup should be given without a default profile")
+
+	s.Require().NoError(s.Context.SetConfig(lochness.DefaultFWProfileConfig, "isolated"))
+	s.DoRequest("POST", s.APIURL, http.StatusAccepted, spec, &guestResp)
+	fwgroup, err := s.Context.FWProfileGroup("isolated", "acme")
+	s.Require().NoError(err)
+	s.Equal(fwgroup.ID, guestResp.FWGroupID, "the tenant's instance of the default profile should be given")
+}
+
+func (s *APISuite) TestGuestAddImage() {
+	image := s.NewImage()
+	spec := map[string]interface{}{
+		"flavor":  s.Guest.FlavorID,
+		"network": s.Guest.NetworkID,
+		"image":   image.ID,
+	}
+
+	var guestResp lochness.Guest
+	s.DoRequest("POST", s.APIURL, http.StatusAccepted, spec, &guestResp)
+	s.Equal(image.ID, guestResp.ImageID)
+
+	var errResp httpmw.HTTPError
+	spec["image"] = uuid.New()
+	s.DoRequest("POST", s.APIURL, http.StatusBadRequest, spec, &errResp)
+	s.Equal(httpmw.ErrCodeValidationFailed, errResp.ErrorCode)
+
+	image.MinMemory = 1 << 20
+	s.Require().NoError(image.Save())
+	spec["image"] = image.ID
+	s.DoRequest("POST", s.APIURL, http.StatusBadRequest, spec, &errResp)
+	s.Equal(httpmw.ErrCodeValidationFailed, errResp.ErrorCode)
+}
+
+func (s *APISuite) TestGuestGet() {
+	var guest lochness.Guest
+	s.DoRequest("GET", fmt.Sprintf("%s/%s", s.APIURL, s.Guest.ID), http.StatusOK, nil, &guest)
+
+	s.Equal(s.Guest.ID, guest.ID)
+}
+
+func (s *APISuite) TestGuestUpdate() {
+	s.Guest.MAC, _ = net.ParseMAC("01:23:45:67:89:ab")
+
+	var guestResp lochness.Guest
+	s.DoRequest("PATCH", fmt.Sprintf("%s/%s", s.APIURL, s.Guest.ID), http.StatusOK, s.Guest, &guestResp)
+
+	s.Equal(s.Guest.ID, guestResp.ID)
+
+	// Make sure it actually saved
+	g, err := s.Context.Guest(s.Guest.ID)
+	s.NoError(err)
+	s.Equal(s.Guest.MAC, g.MAC)
+}
+
+func (s *APISuite) TestGuestDestroy() {
+	var guestResp lochness.Guest
+	resp := s.DoRequest("DELETE", fmt.Sprintf("%s/%s", s.APIURL, s.Guest.ID), http.StatusAccepted, nil, &guestResp)
+	s.NotEmpty(resp.Header.Get("X-Guest-Job-ID"))
+
+	s.Equal(s.Guest.ID, guestResp.ID)
+}
+
+func (s *APISuite) TestGuestSoftDelete() {
+	s.Require().NoError(s.Context.SetConfig(lochness.SoftDeleteConfig, "24h"))
+	guestURL := fmt.Sprintf("%s/%s", s.APIURL, s.Guest.ID)
+
+	var guestResp lochness.Guest
+	s.DoRequest("DELETE", guestURL, http.StatusOK, nil, &guestResp)
+	s.True(guestResp.IsDeleted())
+
+	var guests lochness.Guests
+	s.DoRequest("GET", s.APIURL, http.StatusOK, nil, &guests)
+	s.Len(guests, 0)
+	s.DoRequest("GET", s.APIURL+"?deleted=true", http.StatusOK, nil, &guests)
+	if s.Len(guests, 1) {
+		s.Equal(s.Guest.ID, guests[0].ID)
+	}
+
+	var errResp httpmw.HTTPError
+	s.DoRequest("POST", guestURL+"/reboot", http.StatusConflict, nil, &errResp)
+	s.Equal("guest_deleted", errResp.ErrorCode)
+	s.DoRequest("GET", s.APIURL+"?deleted=maybe", http.StatusBadRequest, nil, &errResp)
+	s.Equal("invalid_deleted", errResp.ErrorCode)
+
+	guestResp = lochness.Guest{}
+	s.DoRequest("POST", guestURL+"/restore", http.StatusOK, nil, &guestResp)
+	s.False(guestResp.IsDeleted())
+	s.DoRequest("POST", guestURL+"/restore", http.StatusConflict, nil, &errResp)
+	s.Equal("guest_not_restorable", errResp.ErrorCode)
+
+	// deleting a soft deleted guest purges it
+	s.DoRequest("DELETE", guestURL, http.StatusOK, nil, &guestResp)
+	resp := s.DoRequest("DELETE", guestURL, http.StatusAccepted, nil, &guestResp)
+	s.NotEmpty(resp.Header.Get("X-Guest-Job-ID"))
+	g, err := s.Context.Guest(s.Guest.ID)
+	s.Require().NoError(err)
+	s.Equal(resp.Header.Get("X-Guest-Job-ID"), g.Tombstone.PurgeJob)
+	_, err = s.JobQueue.ReadJob(g.Tombstone.PurgeJob)
+	s.NoError(err, "the purge job should be queued")
+	s.DoRequest("DELETE", guestURL, http.StatusConflict, nil, &errResp)
+}
+
+func (s *APISuite) TestGuestAction() {
+	var guestResp lochness.Guest
+	resp := s.DoRequest("POST", fmt.Sprintf("%s/%s/%s", s.APIURL, s.Guest.ID, "reboot"), http.StatusAccepted, nil, &guestResp)
+	s.NotEmpty(resp.Header.Get("X-Guest-Job-ID"))
+
+	s.Equal(s.Guest.ID, guestResp.ID)
+}
+
+func (s *APISuite) TestGuestActionState() {
+	s.Guest.Metadata = map[string]string{"state": lochness.GuestStateShutdown}
+	s.Require().NoError(s.Guest.Save())
+
+	var errResp httpmw.HTTPError
+	s.DoRequest("POST", fmt.Sprintf("%s/%s/%s", s.APIURL, s.Guest.ID, "reboot"), http.StatusConflict, nil, &errResp)
+	s.Equal("invalid_guest_state", errResp.ErrorCode)
+
+	var guestResp lochness.Guest
+	resp := s.DoRequest("POST", fmt.Sprintf("%s/%s/%s", s.APIURL, s.Guest.ID, "start"), http.StatusAccepted, nil, &guestResp)
+	s.NotEmpty(resp.Header.Get("X-Guest-Job-ID"))
+}
+
+func (s *APISuite) TestGuestJob() {
+	var guestResp lochness.Guest
+	resp := s.DoRequest("POST", fmt.Sprintf("%s/%s/%s", s.APIURL, s.Guest.ID, "reboot"), http.StatusAccepted, nil, &guestResp)
+	jobID := resp.Header.Get("X-Guest-Job-ID")
+
+	var job jobqueue.Job
+	s.DoRequest("GET", fmt.Sprintf("http://localhost:%d/jobs/%s", s.Port, jobID), http.StatusOK, nil, &job)
+
+	s.Equal(jobID, job.ID)
+}
+
+func (s *APISuite) TestGuestJobGraph() {
+	url := fmt.Sprintf("%s/%s/%s", s.APIURL, s.Guest.ID, "reboot")
+	var guestResp lochness.Guest
+	resp := s.DoRequest("POST", url, http.StatusAccepted, nil, &guestResp)
+	first := resp.Header.Get("X-Guest-Job-ID")
+	resp = s.DoRequest("POST", url+"?depends_on="+first, http.StatusAccepted, nil, &guestResp)
+	second := resp.Header.Get("X-Guest-Job-ID")
+
+	var errResp httpmw.HTTPError
+	s.DoRequest("POST", url+"?depends_on=foo", http.StatusBadRequest, nil, &errResp)
+	s.Equal("invalid_job_id", errResp.ErrorCode)
+	s.DoRequest("POST", url+"?depends_on="+uuid.New(), http.StatusBadRequest, nil, &errResp)
+	s.Equal("job_not_found", errResp.ErrorCode)
+
+	var graph jobqueue.JobGraph
+	s.DoRequest("GET", fmt.Sprintf("http://localhost:%d/jobs/%s/graph", s.Port, first), http.StatusOK, nil, &graph)
+	s.Equal(first, graph.JobID)
+	s.Require().Len(graph.Jobs, 2)
+	s.Equal(first, graph.Jobs[0].ID)
+	s.Equal(second, graph.Jobs[1].ID)
+	s.Equal([]string{first}, graph.Jobs[1].DependsOn)
+
+	s.DoRequest("GET", fmt.Sprintf("http://localhost:%d/jobs/%s/graph", s.Port, uuid.New()), http.StatusNotFound, nil, &errResp)
+	s.Equal("job_not_found", errResp.ErrorCode)
+}
+
+func (s *APISuite) TestGuestClone() {
+	url := fmt.Sprintf("%s/%s/clone", s.APIURL, s.Guest.ID)
+	var errResp map[string]interface{}
+	s.DoRequest("POST", url, http.StatusConflict, nil, &errResp)
+	s.Equal("guest_not_cloneable", errResp["error"])
+
+	_, source := s.NewHypervisorWithGuest()
+	source.Metadata = map[string]string{"name": "web", "state": lochness.GuestStateRunning}
+	s.Require().NoError(source.Save())
+	url = fmt.Sprintf("%s/%s/clone", s.APIURL, source.ID)
+	s.DoRequest("POST", url, http.StatusConflict, map[string]bool{"snapshot": true}, &errResp)
+	s.Equal("guest_not_cloneable", errResp["error"])
+
+	var guestResp lochness.Guest
+	resp := s.DoRequest("POST", url, http.StatusAccepted, map[string]interface{}{"metadata": map[string]string{"role": "copy"}}, &guestResp)
+	s.NotEmpty(resp.Header.Get("X-Guest-Job-ID"))
+	s.NotEqual(source.ID, guestResp.ID)
+	s.NotEqual(source.MAC.String(), guestResp.MAC.String())
+	s.Equal(source.ID, guestResp.CloneOf)
+	s.Empty(guestResp.CloneSnapshot)
+	s.Empty(guestResp.HypervisorID)
+	s.Empty(guestResp.IP)
+	s.Equal(source.FlavorID, guestResp.FlavorID)
+	s.Equal(source.NetworkID, guestResp.NetworkID)
+	s.Equal(map[string]string{"name": "web", "role": "copy"}, guestResp.Metadata)
+}
+
+func (s *APISuite) TestGuestResize() {
+	flavor := s.NewFlavor()
+	flavor.Resources.Disk *= 2
+	s.Require().NoError(flavor.Save())
+
+	url := fmt.Sprintf("%s/%s/resize", s.APIURL, s.Guest.ID)
+	var errResp map[string]interface{}
+	s.DoRequest("POST", url, http.StatusConflict, map[string]string{"flavor": flavor.ID}, &errResp)
+	s.Equal("guest_not_resizable", errResp["error"])
+
+	hypervisor, guest := s.NewHypervisorWithGuest()
+	url = fmt.Sprintf("%s/%s/resize", s.APIURL, guest.ID)
+	s.DoRequest("POST", url, http.StatusBadRequest, map[string]string{"flavor": "foo"}, &errResp)
+	s.Equal("validation_failed", errResp["error"])
+
+	hypervisor.AvailableResources = lochness.Resources{}
+	s.Require().NoError(hypervisor.Save())
+	s.DoRequest("POST", url, http.StatusConflict, map[string]string{"flavor": flavor.ID}, &errResp)
+	s.Equal("guest_not_resizable", errResp["error"])
+
+	hypervisor.AvailableResources = hypervisor.TotalResources
+	s.Require().NoError(hypervisor.Save())
+	var guestResp lochness.Guest
+	resp := s.DoRequest("POST", url, http.StatusAccepted, map[string]string{"flavor": flavor.ID}, &guestResp)
+	s.NotEmpty(resp.Header.Get("X-Guest-Job-ID"))
+	s.Equal(guest.FlavorID, guestResp.FlavorID)
+	s.Equal(flavor.ID, guestResp.ResizeFlavor)
+
+	s.DoRequest("POST", url, http.StatusConflict, map[string]string{"flavor": flavor.ID}, &errResp)
+	s.Equal("guest_not_resizable", errResp["error"])
+}
+
+func (s *APISuite) TestFlavorGet() {
+	url := fmt.Sprintf("http://localhost:%d/flavors", s.Port)
+	var flavor lochness.Flavor
+	s.DoRequest("GET", fmt.Sprintf("%s/%s", url, s.Guest.FlavorID), http.StatusOK, nil, &flavor)
+	s.Equal(s.Guest.FlavorID, flavor.ID)
+
+	var errResp map[string]interface{}
+	s.DoRequest("GET", fmt.Sprintf("%s/%s", url, uuid.New()), http.StatusNotFound, nil, &errResp)
+	s.Equal("flavor_not_found", errResp["error"])
+	s.DoRequest("GET", fmt.Sprintf("%s/%s", url, "foobar"), http.StatusBadRequest, nil, &errResp)
+	s.Equal("invalid_flavor_id", errResp["error"])
+}
+
+func (s *APISuite) TestSwagger() {
+	var spec swagger.Spec
+	s.DoRequest("GET", fmt.Sprintf("http://localhost:%d/swagger.json", s.Port), http.StatusOK, nil, &spec)
+
+	s.Equal("2.0", spec.Swagger)
+	s.NoError(spec.Validate())
+	s.Contains(spec.Paths["/guests"], "post")
+	s.Contains(spec.Paths["/guests/batch"], "post")
+	s.Equal(IdempotencyKeyHeader, spec.Paths["/guests"]["post"].Parameters[2].Name)
+	s.Contains(spec.Paths["/guests/constraints"], "post")
+	s.Contains(spec.Paths["/capacity/forecast"], "get")
+	s.Contains(spec.Paths["/guests/spread"], "get")
+	s.Contains(spec.Paths["/guests/dns"], "get")
+	s.Contains(spec.Paths["/guests/{guestID}/reboot"], "post")
+	s.Contains(spec.Paths["/jobs/{jobID}"], "get")
+	s.Contains(spec.Paths["/flavors/{flavorID}"], "get")
+	s.Contains(spec.Paths["/schemas/{entity}"], "get")
+	s.Contains(spec.Paths["/guests/{guestID}/console"], "post")
+	s.Contains(spec.Paths["/guests/{guestID}/clone"], "post")
+	s.Contains(spec.Paths["/guests/{guestID}/resize"], "post")
+	s.Contains(spec.Definitions, "Guest")
+}
+
+func (s *APISuite) TestSchemas() {
+	url := fmt.Sprintf("http://localhost:%d/schemas", s.Port)
+	var entities []string
+	s.DoRequest("GET", url, http.StatusOK, nil, &entities)
+	s.Equal(lochness.SchemaEntities(), entities)
+
+	var schema jsonschema.Schema
+	s.DoRequest("GET", url+"/guest", http.StatusOK, nil, &schema)
+	s.Equal(lochness.EntitySchema("guest"), &schema)
+
+	var errResp map[string]interface{}
+	s.DoRequest("GET", url+"/foobar", http.StatusNotFound, nil, &errResp)
+	s.Equal("schema_not_found", errResp["error"])
+}
+
+func (s *APISuite) TestUsage() {
+	s.Guest.Metadata[lochness.TenantMetadataKey] = "acme"
+	s.Guest.Metadata["state"] = lochness.GuestStateRunning
+	s.Require().NoError(s.Guest.Save())
+	s.Require().NoError(s.Guest.RecordUsage())
+
+	url := fmt.Sprintf("http://localhost:%d/usage", s.Port)
+	var usage lochness.Usages
+	s.DoRequest("GET", url+"?tenant=acme", http.StatusOK, nil, &usage)
+	if s.Len(usage, 1) {
+		s.Equal("acme", usage[0].Tenant)
+		s.Equal(1, usage[0].Guests)
+	}
+	s.DoRequest("GET", url+"?tenant=nobody", http.StatusOK, nil, &usage)
+	s.Len(usage, 0)
+
+	var errResp map[string]interface{}
+	s.DoRequest("GET", url+"?from=yesterday", http.StatusBadRequest, nil, &errResp)
+	s.Equal("invalid_from", errResp["error"])
+	s.DoRequest("GET", url+"?from=2016-03-08&to=2016-03-07", http.StatusBadRequest, nil, &errResp)
+	s.Equal("validation_failed", errResp["error"])
+
+	resp, err := http.Get(url + "/export?tenant=acme")
+	s.Require().NoError(err)
+	defer func() { _ = resp.Body.Close() }()
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.Equal("text/csv", resp.Header.Get("Content-Type"))
+	body, err := ioutil.ReadAll(resp.Body)
+	s.NoError(err)
+	s.Contains(string(body), "day,tenant,guests,running_hours,memory_hours,disk_hours,cpu_hours\n")
+	s.Contains(string(body), ",acme,1,")
+}
+
+func (s *APISuite) TestGuestConsole() {
+	url := fmt.Sprintf("%s/%s/console", s.APIURL, s.Guest.ID)
+	var errResp map[string]interface{}
+	s.DoRequest("POST", url, http.StatusConflict, nil, &errResp)
+	s.Equal("guest_not_placed", errResp["error"])
+
+	_, guest := s.NewHypervisorWithGuest()
+	url = fmt.Sprintf("%s/%s/console", s.APIURL, guest.ID)
+	s.DoRequest("POST", url, http.StatusBadRequest, map[string]string{"type": "rdp"}, &errResp)
+	s.Equal("validation_failed", errResp["error"])
+
+	var token lochness.ConsoleToken
+	s.DoRequest("POST", url, http.StatusCreated, map[string]string{"type": lochness.ConsoleSerial}, &token)
+	s.Equal(guest.ID, token.GuestID)
+	s.Equal(lochness.ConsoleSerial, token.Type)
+
+	consoleURL := fmt.Sprintf("http://localhost:%d/console/%s", s.Port, token.Token)
+	s.DoRequest("GET", consoleURL, http.StatusUpgradeRequired, nil, &errResp)
+	s.Equal("upgrade_required", errResp["error"])
+
+	c, err := tunnel.Dial(consoleURL, nil, nil)
+	s.Require().NoError(err)
+	output, err := ioutil.ReadAll(c)
+	s.NoError(err)
+	s.Equal(fmt.Sprintf("serial %s\n", guest.ID), string(output))
+	_ = c.Close()
+
+	_, err = tunnel.Dial(consoleURL, nil, nil)
+	if s.Error(err, "token should only be used once") {
+		s.Equal(http.StatusNotFound, err.(*tunnel.StatusError).Code)
+	}
+}
@@ -0,0 +1,150 @@
+package guestapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+)
+
+// maxBatchGuests is the most guests a batch may create
+const maxBatchGuests = 100
+
+// batchResult is the outcome of creating one of the guests of a batch: the
+// guest and the id of its job, or why it was not created
+type batchResult struct {
+	Guest     *lochness.Guest `json:"guest,omitempty"`
+	Job       string          `json:"job,omitempty"`
+	ErrorCode string          `json:"error,omitempty"`
+	Message   string          `json:"message,omitempty"`
+}
+
+// CreateGuestBatch creates guests from an array of guest specs, as CreateGuest
+// does each. All the guests are checked first, and the valid ones saved in a
+// single transaction before a job is queued for each. With the all_or_nothing
+// query parameter, no guest is created if any is invalid. The results are in
+// the order of the specs.
+func CreateGuestBatch(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	ctx := GetContext(r)
+
+	allOrNothing := false
+	if v := r.URL.Query().Get("all_or_nothing"); v != "" {
+		var err error
+		if allOrNothing, err = strconv.ParseBool(v); err != nil {
+			hr.JSONErrorMsg(http.StatusBadRequest, "invalid_all_or_nothing", "invalid all_or_nothing: must be true or false")
+			return
+		}
+	}
+
+	var specs []json.RawMessage
+	if err := httpmw.Decode(r, &specs); err != nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_json", err.Error())
+		return
+	}
+	if len(specs) == 0 {
+		hr.JSONErrorMsg(http.StatusBadRequest, "empty_batch", "no guests given")
+		return
+	}
+	if len(specs) > maxBatchGuests {
+		hr.JSONErrorMsg(http.StatusBadRequest, "batch_too_large", fmt.Sprintf("at most %d guests may be created at once", maxBatchGuests))
+		return
+	}
+
+	dependsOn, ok := dependsOnHelper(hr, r)
+	if !ok {
+		return
+	}
+
+	results := make([]batchResult, len(specs))
+	guests := make([]lochness.Saver, 0, len(specs))
+	var invalid []string
+	// index of the spec given each dns name, so no two guests share one
+	dnsNames := map[string]int{}
+	for i, spec := range specs {
+		guest := ctx.NewGuest()
+		// Left unset, so that guests created without a MAC can be told apart
+		// and given a generated one
+		guest.MAC = nil
+		if err := json.Unmarshal(spec, guest); err != nil {
+			results[i] = batchResult{ErrorCode: "invalid_json", Message: err.Error()}
+			invalid = append(invalid, fmt.Sprintf("%d: %s", i, err))
+			continue
+		}
+		if err := prepareBatchGuest(r, guest); err != nil {
+			if _, ok := err.(*lochness.ValidationError); !ok {
+				hr.JSONError(http.StatusInternalServerError, err)
+				return
+			}
+			results[i] = batchResult{ErrorCode: httpmw.ErrCodeValidationFailed, Message: err.Error()}
+			invalid = append(invalid, fmt.Sprintf("%d: %s", i, err))
+			continue
+		}
+		if guest.DNSName != "" {
+			if j, ok := dnsNames[guest.DNSName]; ok {
+				msg := fmt.Sprintf("dns name %q is also given guest %d", guest.DNSName, j)
+				results[i] = batchResult{ErrorCode: httpmw.ErrCodeValidationFailed, Message: msg}
+				invalid = append(invalid, fmt.Sprintf("%d: %s", i, msg))
+				continue
+			}
+			dnsNames[guest.DNSName] = i
+		}
+		results[i].Guest = guest
+		guests = append(guests, guest)
+	}
+
+	if len(guests) == 0 || (allOrNothing && len(invalid) > 0) {
+		hr.JSONErrorMsg(http.StatusBadRequest, httpmw.ErrCodeValidationFailed, "invalid guests, by index: "+strings.Join(invalid, "; "))
+		return
+	}
+
+	if err := ctx.SaveAll(guests...); err != nil {
+		if err == lochness.ErrDNSNameInUse {
+			hr.JSONErrorMsg(http.StatusConflict, "dns_name_in_use", err.Error())
+			return
+		}
+		// guests given the id of another
+		if lerrors.IsConflict(err) {
+			hr.JSONErrorMsg(http.StatusConflict, "guest_exists", err.Error())
+			return
+		}
+		hr.JSONError(newGuestErrorCode(err), err)
+		return
+	}
+
+	jobQueue := GetJobQueue(r)
+	for i := range results {
+		if results[i].Guest == nil {
+			continue
+		}
+		job, err := jobQueue.AddJobContext(r.Context(), results[i].Guest.ID, "select-hypervisor", dependsOn)
+		if err != nil {
+			results[i].ErrorCode = httpmw.StatusErrorCode(http.StatusInternalServerError)
+			results[i].Message = err.Error()
+			continue
+		}
+		results[i].Job = job.ID
+	}
+	hr.JSON(http.StatusAccepted, results)
+}
+
+// prepareBatchGuest readies one of the guests of a batch to be created, as
+// CreateGuest does, and validates it
+func prepareBatchGuest(r *http.Request, guest *lochness.Guest) error {
+	resetNewGuest(guest)
+	if err := generateMAC(r, guest); err != nil {
+		return err
+	}
+	if err := defaultFWGroup(r, guest); err != nil {
+		return err
+	}
+	if err := GetContext(r).CheckGuestImage(guest); err != nil {
+		return err
+	}
+	return guest.Validate()
+}
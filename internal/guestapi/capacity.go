@@ -0,0 +1,64 @@
+package guestapi
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/pborman/uuid"
+)
+
+// RegisterCapacityRoutes registers the routes reporting the capacity of the
+// cluster, for capacity planning and preflight checks
+func RegisterCapacityRoutes(prefix string, router *mux.Router, m *MetricsContext) {
+	sub := router.PathPrefix(prefix).Subrouter()
+	sub.Handle("/forecast", m.mmw.HandlerFunc(GetCapacityForecast, "capacity_forecast")).Methods("GET")
+}
+
+// GetCapacityForecast forecasts the placement of the number of guests of the
+// count query parameter, 1 by default, of the flavor of the flavor parameter,
+// see lochness.Context.Forecast. The domain parameter is the level of the
+// failure domains headroom is reported for, and the constraints parameter a
+// constraints expression the hypervisors must meet.
+func GetCapacityForecast(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	ctx := GetContext(r)
+	query := r.URL.Query()
+
+	flavorID := query.Get("flavor")
+	if uuid.Parse(flavorID) == nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_flavor_id", "missing or invalid flavor id")
+		return
+	}
+	count := 1
+	if v := query.Get("count"); v != "" {
+		var err error
+		if count, err = strconv.Atoi(v); err != nil {
+			hr.JSONErrorMsg(http.StatusBadRequest, "invalid_count", "invalid count: must be a number")
+			return
+		}
+	}
+
+	flavor, err := ctx.Flavor(flavorID)
+	if err != nil {
+		if ctx.IsKeyNotFound(err) {
+			hr.JSONErrorMsg(http.StatusNotFound, "flavor_not_found", "flavor not found")
+			return
+		}
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+
+	forecast, err := ctx.Forecast(flavor, count, query.Get("domain"), query.Get("constraints"))
+	if err != nil {
+		if lerrors.IsValidation(err) {
+			hr.JSONError(http.StatusBadRequest, err)
+			return
+		}
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	hr.JSON(http.StatusOK, forecast)
+}
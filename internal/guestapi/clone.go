@@ -0,0 +1,63 @@
+package guestapi
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/mistifyio/lochness/internal/httpmw"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+)
+
+// cloneRequest is the optional request body of CloneGuest
+type cloneRequest struct {
+	// Snapshot clones the guest's latest snapshot instead of its current
+	// disks
+	Snapshot bool `json:"snapshot"`
+	// Metadata is set on the clone, over the metadata copied from the guest
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// CloneGuest creates a new guest from an existing one, with its flavor,
+// network, and metadata, and queues a job to place it on the guest's
+// hypervisor and clone the guest's disks for it
+func CloneGuest(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	source := GetRequestGuest(r)
+
+	dependsOn, ok := dependsOnHelper(hr, r)
+	if !ok {
+		return
+	}
+
+	req := cloneRequest{}
+	if err := httpmw.Decode(r, &req); err != nil && err != io.EOF {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_json", err.Error())
+		return
+	}
+
+	guest, err := source.Clone(req.Snapshot)
+	if err != nil {
+		if errors.Is(err, lerrors.ErrConflict) {
+			hr.JSONErrorMsg(http.StatusConflict, "guest_not_cloneable", err.Error())
+			return
+		}
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	for k, v := range req.Metadata {
+		guest.Metadata[k] = v
+	}
+
+	// The clone gets a MAC of its own
+	guest.MAC = nil
+	if !generateMACHelper(hr, r, guest) {
+		return
+	}
+
+	if !saveGuestHelper(hr, guest) {
+		return
+	}
+
+	guestNewJobHelper(hr, r, guest, "select-hypervisor", dependsOn)
+}
@@ -0,0 +1,116 @@
+package guestapi
+
+import (
+	"io"
+	"net"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	"github.com/mistifyio/lochness/internal/tunnel"
+)
+
+type (
+	// ConsoleDialer connects to the consoles of guests, e.g.
+	// lochness.MistifyAgent
+	ConsoleDialer interface {
+		DialConsole(guestID, consoleType string) (net.Conn, error)
+	}
+
+	// consoleRequest is the body of a request for a console token
+	consoleRequest struct {
+		Type string `json:"type"`
+	}
+)
+
+// RegisterConsoleRoutes registers the console routes and handlers. Console
+// connections take over the request's connection, so they are not wrapped in
+// metrics.
+func RegisterConsoleRoutes(prefix string, router *mux.Router) {
+	sub := router.PathPrefix(prefix).Subrouter()
+
+	sub.HandleFunc("/{token}", ConnectConsole).Methods("GET")
+}
+
+// CreateConsoleToken creates a one-time token for connecting to a console of a
+// guest, vnc unless another type is requested
+func CreateConsoleToken(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	ctx := GetContext(r)
+	guest := GetRequestGuest(r)
+
+	req := consoleRequest{}
+	if err := httpmw.Decode(r, &req); err != nil && err != io.EOF {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_json", err.Error())
+		return
+	}
+	if req.Type == "" {
+		req.Type = lochness.ConsoleVNC
+	}
+
+	if guest.HypervisorID == "" {
+		hr.JSONErrorMsg(http.StatusConflict, "guest_not_placed", "guest has no hypervisor")
+		return
+	}
+
+	token, err := ctx.NewConsoleToken(guest.ID, req.Type)
+	if err != nil {
+		if _, ok := err.(*lochness.ValidationError); ok {
+			hr.JSONError(http.StatusBadRequest, err)
+			return
+		}
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	hr.JSON(http.StatusCreated, token)
+}
+
+// ConnectConsole redeems a console token and connects the request, upgraded
+// to a tunnel, to the console through the guest's hypervisor agent
+func ConnectConsole(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	ctx := GetContext(r)
+
+	if !tunnel.IsUpgrade(r) {
+		w.Header().Set("Upgrade", tunnel.Protocol)
+		hr.JSONErrorMsg(http.StatusUpgradeRequired, "upgrade_required", "console connections must upgrade to "+tunnel.Protocol)
+		return
+	}
+
+	token, err := ctx.RedeemConsoleToken(mux.Vars(r)["token"])
+	if err != nil {
+		switch {
+		case err == lochness.ErrConsoleTokenExpired:
+			hr.JSONErrorMsg(http.StatusGone, "console_token_expired", err.Error())
+		case ctx.IsKeyNotFound(err):
+			hr.JSONErrorMsg(http.StatusNotFound, "console_token_not_found", "console token not found")
+		default:
+			hr.JSONErrorMsg(http.StatusBadRequest, "invalid_console_token", err.Error())
+		}
+		return
+	}
+
+	console, err := GetConsoleDialer(r).DialConsole(token.GuestID, token.Type)
+	if err != nil {
+		hr.JSONErrorMsg(http.StatusBadGateway, "console_unavailable", err.Error())
+		return
+	}
+
+	client, err := tunnel.Upgrade(w, r)
+	if err != nil {
+		_ = console.Close()
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+
+	fields := log.Fields{
+		"guest":      token.GuestID,
+		"type":       token.Type,
+		"request_id": hr.RequestID(),
+	}
+	log.WithFields(fields).Info("console connected")
+	tunnel.Join(client, console)
+	log.WithFields(fields).Info("console disconnected")
+}
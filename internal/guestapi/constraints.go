@@ -0,0 +1,66 @@
+package guestapi
+
+import (
+	"net/http"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/httpmw"
+)
+
+type (
+	// constraintsTest is a constraints expression to be tested, with the
+	// guest spec it would be evaluated for, see lochness.Constraint
+	constraintsTest struct {
+		Constraints string          `json:"constraints"`
+		Guest       *lochness.Guest `json:"guest,omitempty"`
+	}
+
+	// constraintsResult is the hypervisors meeting a tested constraints
+	// expression
+	constraintsResult struct {
+		Constraints string   `json:"constraints"`
+		Hypervisors []string `json:"hypervisors"`
+	}
+)
+
+// CheckConstraints parses a guest constraints expression and reports the ids of
+// the hypervisors meeting it, so that an expression can be tried before
+// guests are created with it. Attributes of the guest are taken from the
+// optional guest spec. Whether the hypervisors are alive or have the resources
+// for the guest is not considered. An invalid expression is a validation
+// error.
+func CheckConstraints(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	ctx := GetContext(r)
+
+	var test constraintsTest
+	if err := httpmw.Decode(r, &test); err != nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_json", err.Error())
+		return
+	}
+	constraint, err := lochness.ParseConstraint(test.Constraints)
+	if err != nil {
+		hr.JSONError(http.StatusBadRequest, err)
+		return
+	}
+	guest := test.Guest
+	if guest == nil {
+		guest = &lochness.Guest{}
+	}
+
+	result := constraintsResult{
+		Constraints: test.Constraints,
+		Hypervisors: []string{},
+	}
+	err = ctx.ForEachHypervisor(func(h *lochness.Hypervisor) error {
+		if !h.IsDeleted() && constraint.Match(guest, h) {
+			result.Hypervisors = append(result.Hypervisors, h.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	hr.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,27 @@
+package guestapi
+
+import (
+	"net/http"
+
+	"github.com/mistifyio/lochness/internal/httpmw"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+)
+
+// ListDNSRecords lists the DNS names of the guests, see
+// lochness.Context.DNSRecords. The domain query parameter limits them to the
+// names in a domain.
+func ListDNSRecords(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	ctx := GetContext(r)
+
+	records, err := ctx.DNSRecords(r.URL.Query().Get("domain"))
+	if err != nil {
+		if lerrors.IsValidation(err) {
+			hr.JSONError(http.StatusBadRequest, err)
+			return
+		}
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	hr.JSON(http.StatusOK, records)
+}
@@ -0,0 +1,38 @@
+package guestapi
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	"github.com/pborman/uuid"
+)
+
+// RegisterFlavorRoutes registers the flavor routes and handlers. Flavors are
+// read only, so that guest specs can be checked against them.
+func RegisterFlavorRoutes(prefix string, router *mux.Router, m *MetricsContext) {
+	sub := router.PathPrefix(prefix).Subrouter()
+
+	sub.Handle("/{flavorID}", m.mmw.HandlerFunc(GetFlavor, "flavor")).Methods("GET")
+}
+
+// GetFlavor gets a flavor
+func GetFlavor(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	ctx := GetContext(r)
+	flavorID := mux.Vars(r)["flavorID"]
+	if uuid.Parse(flavorID) == nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_flavor_id", "invalid flavor id")
+		return
+	}
+	flavor, err := ctx.Flavor(flavorID)
+	if err != nil {
+		if ctx.IsKeyNotFound(err) {
+			hr.JSONErrorMsg(http.StatusNotFound, "flavor_not_found", "flavor not found")
+			return
+		}
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	hr.JSON(http.StatusOK, flavor)
+}
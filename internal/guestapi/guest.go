@@ -0,0 +1,246 @@
+package guestapi
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/justinas/alice"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+)
+
+// guestActions are the actions that can be taken on a guest through the api
+var guestActions = []string{"shutdown", "reboot", "restart", "poweroff", "start", "suspend"}
+
+// RegisterGuestRoutes registers the guest routes and handlers
+func RegisterGuestRoutes(prefix string, router *mux.Router, m *MetricsContext) {
+	guestMiddleware := alice.New(
+		loadGuest,
+	)
+	// soft deleted guests may only be gotten, deleted for good, or restored
+	liveGuestMiddleware := guestMiddleware.Append(rejectDeletedGuest)
+
+	router.Handle(prefix, m.mmw.HandlerFunc(ListGuests, "list")).Methods("GET")
+	router.Handle(prefix, m.mmw.HandlerFunc(idempotent(CreateGuest), "create")).Methods("POST")
+	router.Handle(prefix+"/batch", m.mmw.HandlerFunc(idempotent(CreateGuestBatch), "create_batch")).Methods("POST")
+	router.Handle(prefix+"/constraints", m.mmw.HandlerFunc(CheckConstraints, "check_constraints")).Methods("POST")
+	router.Handle(prefix+"/spread", m.mmw.HandlerFunc(GetSpreadViolations, "spread")).Methods("GET")
+	router.Handle(prefix+"/dns", m.mmw.HandlerFunc(ListDNSRecords, "dns")).Methods("GET")
+
+	// TODO: Figure out a cleaner way to do middleware on the subrouter
+	sub := router.PathPrefix(prefix).Subrouter()
+
+	// XXX: could do a simple struct that had the info and range over it to set this up
+	sub.Handle("/{guestID}", guestMiddleware.Append(m.mmw.HandlerWrapper("get")).ThenFunc(GetGuest)).Methods("GET")
+	sub.Handle("/{guestID}", liveGuestMiddleware.Append(m.mmw.HandlerWrapper("update")).ThenFunc(UpdateGuest)).Methods("PATCH")
+	sub.Handle("/{guestID}", guestMiddleware.Append(m.mmw.HandlerWrapper("destroy")).ThenFunc(DestroyGuest)).Methods("DELETE")
+	sub.Handle("/{guestID}/restore", guestMiddleware.Append(m.mmw.HandlerWrapper("restore")).ThenFunc(RestoreGuest)).Methods("POST")
+	sub.Handle("/{guestID}/console", liveGuestMiddleware.Append(m.mmw.HandlerWrapper("console")).ThenFunc(CreateConsoleToken)).Methods("POST")
+	sub.Handle("/{guestID}/clone", liveGuestMiddleware.Append(m.mmw.HandlerWrapper("clone")).ThenFunc(CloneGuest)).Methods("POST")
+	sub.Handle("/{guestID}/resize", liveGuestMiddleware.Append(m.mmw.HandlerWrapper("resize")).ThenFunc(ResizeGuest)).Methods("POST")
+	// Limit actions and have specific action metrics while sharing a handler
+	for _, action := range guestActions {
+		sub.Handle(fmt.Sprintf("/{guestID}/{action:%s}", action),
+			liveGuestMiddleware.
+				Append(m.mmw.HandlerWrapper(action)).
+				ThenFunc(GuestAction),
+		).Methods("POST")
+	}
+}
+
+// ListGuests gets a list of all guests, or those whose metadata matches the
+// metadata query parameters, each a key=value pair. Soft deleted guests are
+// only listed, instead of the others, with the deleted=true query parameter.
+// The list is tagged with an ETag, so it can be polled with If-None-Match.
+func ListGuests(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	ctx := GetContext(r)
+	filters, err := lochness.ParseMetadataFilters(r.URL.Query()["metadata"])
+	if err != nil {
+		hr.JSONError(http.StatusBadRequest, err)
+		return
+	}
+	deleted, ok := deletedQueryHelper(hr, r)
+	if !ok {
+		return
+	}
+	guests, err := ctx.GuestsMatching(filters)
+	if err != nil {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+
+	listed := make(lochness.Guests, 0, len(guests))
+	for _, guest := range guests {
+		if guest.IsDeleted() == deleted {
+			listed = append(listed, guest)
+		}
+	}
+	hr.JSONETag(r, listed)
+}
+
+// CreateGuest creates a new guest
+func CreateGuest(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+
+	guest, err := decodeGuest(r, nil)
+	if err != nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_json", err.Error())
+		return
+	}
+
+	resetNewGuest(guest)
+
+	dependsOn, ok := dependsOnHelper(hr, r)
+	if !ok {
+		return
+	}
+
+	if !generateMACHelper(hr, r, guest) {
+		return
+	}
+
+	if !defaultFWGroupHelper(hr, r, guest) {
+		return
+	}
+
+	if !checkImageHelper(hr, r, guest) {
+		return
+	}
+
+	if !saveGuestHelper(hr, guest) {
+		return
+	}
+
+	guestNewJobHelper(hr, r, guest, "select-hypervisor", dependsOn)
+}
+
+// resetNewGuest clears the fields of a guest to be created that are only set
+// by the server
+func resetNewGuest(guest *lochness.Guest) {
+	// Hypervisor will be selected automatically
+	guest.HypervisorID = ""
+	// Clones are created through CloneGuest
+	guest.CloneOf = ""
+	guest.CloneSnapshot = ""
+	// Flavors are changed through ResizeGuest once the guest is placed
+	guest.ResizeFlavor = ""
+	// Guests are deleted through DestroyGuest
+	guest.Tombstone = nil
+}
+
+// GetGuest gets a particular guest
+func GetGuest(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	hr.JSON(http.StatusOK, GetRequestGuest(r))
+}
+
+// UpdateGuest updates an existing guest
+func UpdateGuest(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	guest := GetRequestGuest(r)
+	resizeFlavor := guest.ResizeFlavor
+
+	_, err := decodeGuest(r, guest)
+	if err != nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_json", err.Error())
+		return
+	}
+	// Resizes are started and finished by jobs
+	guest.ResizeFlavor = resizeFlavor
+	// Only live guests are updated
+	guest.Tombstone = nil
+
+	if !saveGuestHelper(hr, guest) {
+		return
+	}
+	hr.JSON(http.StatusOK, guest)
+}
+
+// DestroyGuest removes a guest and frees its IP. With a soft delete window
+// configured for the cluster, the guest is only marked deleted instead, so that
+// it may be restored until it is purged. Deleting a soft deleted guest purges
+// it right away. Deleting a guest changed meanwhile, or already being purged,
+// is a conflict.
+func DestroyGuest(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	ctx := GetContext(r)
+	guest := GetRequestGuest(r)
+
+	dependsOn, ok := dependsOnHelper(hr, r)
+	if !ok {
+		return
+	}
+
+	if guest.IsDeleted() {
+		job, err := purgeGuest(GetJobQueue(r), guest)
+		if err != nil {
+			if lerrors.IsConflict(err) {
+				hr.JSONError(http.StatusConflict, err)
+				return
+			}
+			hr.JSONError(http.StatusInternalServerError, err)
+			return
+		}
+		hr.Header().Set("X-Guest-Job-ID", job.ID)
+		hr.JSON(http.StatusAccepted, guest)
+		return
+	}
+
+	window, err := ctx.SoftDeleteWindow()
+	if err != nil {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	if window == 0 {
+		guestNewJobHelper(hr, r, guest, "delete", dependsOn)
+		return
+	}
+
+	if err := guest.SoftDelete(window); err != nil {
+		if lerrors.IsConflict(err) {
+			hr.JSONError(http.StatusConflict, err)
+			return
+		}
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	hr.JSON(http.StatusOK, guest)
+}
+
+// RestoreGuest restores a soft deleted guest, unless it is being purged
+func RestoreGuest(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	guest := GetRequestGuest(r)
+
+	if err := guest.Restore(); err != nil {
+		if lerrors.IsConflict(err) {
+			hr.JSONErrorMsg(http.StatusConflict, "guest_not_restorable", err.Error())
+			return
+		}
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	hr.JSON(http.StatusOK, guest)
+}
+
+// GuestAction handles all of the generic guest actions
+func GuestAction(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	guest := GetRequestGuest(r)
+
+	action := mux.Vars(r)["action"]
+	if err := guest.CheckAction(action); err != nil {
+		hr.JSONErrorMsg(http.StatusConflict, "invalid_guest_state", err.Error())
+		return
+	}
+
+	dependsOn, ok := dependsOnHelper(hr, r)
+	if !ok {
+		return
+	}
+
+	guestNewJobHelper(hr, r, guest, action, dependsOn)
+}
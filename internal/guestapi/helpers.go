@@ -0,0 +1,237 @@
+package guestapi
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	"github.com/pborman/uuid"
+)
+
+const guestKey = "guest"
+
+// loadGuest is a middleware to load a guest into the request context and
+// handles sending a response in case of error
+func loadGuest(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hr := httpmw.NewResponse(w)
+		ctx := GetContext(r)
+		vars := mux.Vars(r)
+		guestID, ok := vars["guestID"]
+		if !ok {
+			hr.JSONErrorMsg(http.StatusBadRequest, "missing_guest_id", "missing guest id")
+			return
+		}
+		if uuid.Parse(guestID) == nil {
+			hr.JSONErrorMsg(http.StatusBadRequest, "invalid_guest_id", "invalid guest id")
+			return
+		}
+		guest, err := ctx.Guest(guestID)
+		if err != nil {
+			if ctx.IsKeyNotFound(err) {
+				hr.JSONErrorMsg(http.StatusNotFound, "guest_not_found", "guest not found")
+				return
+			}
+			hr.JSONError(http.StatusInternalServerError, err)
+			return
+		}
+		SetRequestGuest(r, guest)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// rejectDeletedGuest is a middleware, used after loadGuest, refusing requests
+// on soft deleted guests
+func rejectDeletedGuest(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if GetRequestGuest(r).IsDeleted() {
+			hr := httpmw.NewResponse(w)
+			hr.JSONErrorMsg(http.StatusConflict, "guest_deleted", "guest is deleted, restore it first")
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// deletedQueryHelper parses the deleted query parameter, false if not given,
+// and handles sending a response in case of error
+func deletedQueryHelper(hr *httpmw.Response, r *http.Request) (bool, bool) {
+	v := r.URL.Query().Get("deleted")
+	if v == "" {
+		return false, true
+	}
+	deleted, err := strconv.ParseBool(v)
+	if err != nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_deleted", "invalid deleted: must be true or false")
+		return false, false
+	}
+	return deleted, true
+}
+
+// saveGuestHelper saves the guest object and handles sending a response in case
+// of error
+func saveGuestHelper(hr *httpmw.Response, guest *lochness.Guest) bool {
+	if err := guest.Validate(); err != nil {
+		hr.JSONError(http.StatusBadRequest, err)
+		return false
+	}
+	// Save
+	if err := guest.Save(); err != nil {
+		if err == lochness.ErrDNSNameInUse {
+			hr.JSONErrorMsg(http.StatusConflict, "dns_name_in_use", err.Error())
+			return false
+		}
+		hr.JSONError(http.StatusInternalServerError, err)
+		return false
+	}
+	return true
+}
+
+// decodeGuest decodes the request body, see httpmw.Decode, into a guest object
+func decodeGuest(r *http.Request, guest *lochness.Guest) (*lochness.Guest, error) {
+	if guest == nil {
+		ctx := GetContext(r)
+		guest = ctx.NewGuest()
+		// Left unset, so that guests created without a MAC can be told
+		// apart and given a generated one
+		guest.MAC = nil
+	}
+
+	if err := httpmw.Decode(r, guest); err != nil {
+		return nil, err
+	}
+	return guest, nil
+
+}
+
+// generateMAC gives a new guest without a MAC a generated one, with the OUI
+// requested, the one cguestd was started with, or the one configured for the
+// cluster
+func generateMAC(r *http.Request, guest *lochness.Guest) error {
+	if guest.MAC != nil {
+		return nil
+	}
+
+	oui := r.URL.Query().Get("mac_oui")
+	if oui == "" {
+		oui = GetMACOUI(r)
+	}
+	mac, err := GetContext(r).GenerateMAC(oui)
+	if err != nil {
+		return err
+	}
+	guest.MAC = mac
+	return nil
+}
+
+// generateMACHelper gives a new guest without a MAC a generated one, see
+// generateMAC, and handles sending a response in case of error
+func generateMACHelper(hr *httpmw.Response, r *http.Request, guest *lochness.Guest) bool {
+	if err := generateMAC(r, guest); err != nil {
+		hr.JSONError(newGuestErrorCode(err), err)
+		return false
+	}
+	return true
+}
+
+// newGuestErrorCode is the status of an error preparing a new guest, 400 if
+// the guest is invalid
+func newGuestErrorCode(err error) int {
+	if _, ok := err.(*lochness.ValidationError); ok {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// defaultFWGroup puts a guest given no fwgroup in the instance of the
+// cluster's default firewall profile for its tenant, if one is configured
+func defaultFWGroup(r *http.Request, guest *lochness.Guest) error {
+	if guest.FWGroupID != "" {
+		return nil
+	}
+
+	id, err := GetContext(r).DefaultFWGroup(guest.Tenant())
+	if err != nil {
+		return err
+	}
+	guest.FWGroupID = id
+	return nil
+}
+
+// defaultFWGroupHelper puts a guest given no fwgroup in the instance of the
+// default firewall profile, see defaultFWGroup, and handles sending a response
+// in case of error
+func defaultFWGroupHelper(hr *httpmw.Response, r *http.Request, guest *lochness.Guest) bool {
+	if err := defaultFWGroup(r, guest); err != nil {
+		hr.JSONError(newGuestErrorCode(err), err)
+		return false
+	}
+	return true
+}
+
+// checkImageHelper checks that the guest's catalog image exists and fits its
+// flavor, and handles sending a response in case of error
+func checkImageHelper(hr *httpmw.Response, r *http.Request, guest *lochness.Guest) bool {
+	if err := GetContext(r).CheckGuestImage(guest); err != nil {
+		hr.JSONError(newGuestErrorCode(err), err)
+		return false
+	}
+	return true
+}
+
+// dependsOnHelper gets the ids of the jobs a new job depends on from the
+// depends_on query, which may be repeated or comma separated, checking they
+// exist, and handles sending a response in case of error
+func dependsOnHelper(hr *httpmw.Response, r *http.Request) ([]string, bool) {
+	jobQueue := GetJobQueue(r)
+	var dependsOn []string
+	for _, value := range r.URL.Query()["depends_on"] {
+		for _, id := range strings.Split(value, ",") {
+			if id = strings.TrimSpace(id); id == "" {
+				continue
+			}
+			if uuid.Parse(id) == nil {
+				hr.JSONErrorMsg(http.StatusBadRequest, "invalid_job_id", fmt.Sprintf("invalid dependency %q", id))
+				return nil, false
+			}
+			if _, err := jobQueue.ReadJob(id); err != nil {
+				if GetContext(r).IsKeyNotFound(err) {
+					hr.JSONErrorMsg(http.StatusBadRequest, "job_not_found", fmt.Sprintf("dependency %s not found", id))
+					return nil, false
+				}
+				hr.JSONError(http.StatusInternalServerError, err)
+				return nil, false
+			}
+			dependsOn = append(dependsOn, id)
+		}
+	}
+	return dependsOn, true
+}
+
+// guestNewJobHelper creates a new job for a guest action, after the jobs it
+// depends on, and handles sending a response
+func guestNewJobHelper(hr *httpmw.Response, r *http.Request, guest *lochness.Guest, action string, dependsOn []string) {
+	jobQueue := GetJobQueue(r)
+	job, err := jobQueue.AddJobContext(r.Context(), guest.ID, action, dependsOn)
+	if err != nil {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	hr.Header().Set("X-Guest-Job-ID", job.ID)
+	hr.JSON(http.StatusAccepted, guest)
+}
+
+// SetRequestGuest saves the guest to the request context
+func SetRequestGuest(r *http.Request, g *lochness.Guest) {
+	context.Set(r, guestKey, g)
+}
+
+// GetRequestGuest retrieves the guest from the request context
+func GetRequestGuest(r *http.Request) *lochness.Guest {
+	return context.Get(r, guestKey).(*lochness.Guest)
+}
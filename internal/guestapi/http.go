@@ -0,0 +1,147 @@
+// Package guestapi is the http api of guests and their jobs and consoles,
+// served by cguestd and lochnessd.
+package guestapi
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/armon/go-metrics"
+	"github.com/bakins/go-metrics-map"
+	"github.com/bakins/go-metrics-middleware"
+	"github.com/bakins/net-http-recover"
+	"github.com/gorilla/context"
+	"github.com/gorilla/handlers"
+	"github.com/gorilla/mux"
+	"github.com/justinas/alice"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/changefeed"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	"github.com/mistifyio/lochness/internal/server"
+	"github.com/mistifyio/lochness/pkg/jobqueue"
+)
+
+// MetricsContext holds the metrics of the api, served at /metrics
+type MetricsContext struct {
+	sink    *mapsink.MapSink
+	metrics *metrics.Metrics
+	mmw     *mmw.Middleware
+}
+
+// NewMetricsContext creates the metrics of the api, kept in sink for /metrics
+// and also sent to any other sinks, e.g. statsd
+func NewMetricsContext(sink *mapsink.MapSink, sinks ...metrics.MetricSink) *MetricsContext {
+	fanout := append(metrics.FanoutSink{sink}, sinks...)
+	conf := metrics.DefaultConfig("cguestd")
+	conf.EnableHostname = false
+	m, _ := metrics.New(conf, fanout)
+
+	return &MetricsContext{
+		sink:    sink,
+		metrics: m,
+		mmw:     mmw.New(m),
+	}
+}
+
+const (
+	ctxKey     string = "lochnessContext"
+	jQKey      string = "lochnessJobQueue"
+	consoleKey string = "lochnessConsoles"
+	macOUIKey  string = "lochnessMACOUI"
+)
+
+// Run starts the server. The changes of feed are streamed at /events.
+func Run(port uint, ctx *lochness.Context, jobQueue *jobqueue.Client, consoles ConsoleDialer, macOUI string, m *MetricsContext, feed *changefeed.Feed, reqLog httpmw.Config, tlsConfig *tls.Config) *server.Server {
+	router := mux.NewRouter()
+	router.StrictSlash(true)
+
+	// Common middleware applied to every request
+	reqLog.Name = "cguestd"
+	commonMiddleware := alice.New(
+		httpmw.RequestID,
+		httpmw.Negotiate,
+		httpmw.Logger(reqLog),
+		httpmw.Versioned,
+		handlers.CompressHandler,
+		func(h http.Handler) http.Handler {
+			return recovery.Handler(os.Stderr, h, true)
+		},
+		func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				context.Set(r, ctxKey, ctx)
+				context.Set(r, jQKey, jobQueue)
+				context.Set(r, consoleKey, consoles)
+				context.Set(r, macOUIKey, macOUI)
+				h.ServeHTTP(w, r)
+			})
+		},
+	)
+
+	// NOTE: Due to weirdness with PrefixPath and StrictSlash, can't just pass
+	// a prefixed subrouter to the register functions and have the base path
+	// work cleanly. The register functions need to add a base path handler to
+	// the main router before setting subhandlers on either main or subrouter
+
+	RegisterGuestRoutes("/guests", router, m)
+	RegisterJobRoutes("/jobs", router, m)
+	RegisterFlavorRoutes("/flavors", router, m)
+	RegisterUsageRoutes("/usage", router, m)
+	RegisterCapacityRoutes("/capacity", router, m)
+	RegisterSchemaRoutes("/schemas", router)
+	RegisterConsoleRoutes("/console", router)
+	RegisterEventRoutes("/events", router, feed)
+
+	router.HandleFunc("/metrics",
+		func(w http.ResponseWriter, r *http.Request) {
+			hr := httpmw.NewResponse(w)
+			hr.JSON(http.StatusOK, m.sink)
+		})
+
+	RegisterSwaggerRoute(router)
+
+	srv := server.New(fmt.Sprintf(":%d", port), commonMiddleware.Then(router), tlsConfig)
+	// Streams only end with the client, so end them to shut down
+	srv.RegisterOnShutdown(feed.Close)
+	srv.Start()
+	return srv
+}
+
+// SetContext sets a lochness.Context value for a request
+func SetContext(r *http.Request, ctx *lochness.Context) {
+	context.Set(r, ctxKey, ctx)
+}
+
+// GetContext retrieves a lochness.Context value for a request
+func GetContext(r *http.Request) *lochness.Context {
+	if value := context.Get(r, ctxKey); value != nil {
+		return value.(*lochness.Context)
+	}
+	return nil
+}
+
+// GetJobQueue retrieves a lochness.Context value for a request
+func GetJobQueue(r *http.Request) *jobqueue.Client {
+	if value := context.Get(r, jQKey); value != nil {
+		return value.(*jobqueue.Client)
+	}
+	return nil
+}
+
+// GetConsoleDialer retrieves the ConsoleDialer for a request
+func GetConsoleDialer(r *http.Request) ConsoleDialer {
+	if value := context.Get(r, consoleKey); value != nil {
+		return value.(ConsoleDialer)
+	}
+	return nil
+}
+
+// GetMACOUI retrieves the OUI of MACs generated for new guests, empty for the
+// one configured for the cluster
+func GetMACOUI(r *http.Request) string {
+	if value := context.Get(r, macOUIKey); value != nil {
+		return value.(string)
+	}
+	return ""
+}
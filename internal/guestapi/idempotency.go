@@ -0,0 +1,180 @@
+package guestapi
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+)
+
+// IdempotencyKeyHeader is the header of a request creating guests that
+// retries send again, so that they get its response instead of creating the
+// guests again
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotentReplayHeader is set on responses replayed to a retry
+const IdempotentReplayHeader = "Idempotent-Replayed"
+
+// idempotentHeaders are the headers of a response that are replayed
+var idempotentHeaders = []string{"Content-Type", "X-Guest-Job-ID"}
+
+// idempotent is a middleware making requests with an IdempotencyKeyHeader safe
+// to retry, e.g. after a timeout. The response to the first request with a key
+// is kept for the cluster's idempotency window and replayed to retries, which
+// must be the same request. Responses to requests that failed on the server
+// are not kept, so that they may be retried. The key is claimed for as long as
+// the request is in progress.
+func idempotent(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			h(w, r)
+			return
+		}
+		hr := httpmw.NewResponse(w)
+		ctx := GetContext(r)
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			hr.JSONErrorMsg(http.StatusBadRequest, "invalid_body", err.Error())
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		fingerprint := lochness.RequestFingerprint(r.Method, r.URL.Path, r.URL.RawQuery, body)
+		claim, err := ctx.ClaimIdempotencyKey(key, fingerprint)
+		switch {
+		case err == lochness.ErrIdempotencyKeyInUse:
+			hr.JSONErrorMsg(http.StatusConflict, "idempotency_key_in_use", err.Error())
+			return
+		case err == lochness.ErrIdempotencyKeyReused:
+			hr.JSONErrorMsg(http.StatusUnprocessableEntity, "idempotency_key_reused", err.Error())
+			return
+		case lerrors.IsValidation(err):
+			hr.JSONError(http.StatusBadRequest, err)
+			return
+		case err != nil:
+			hr.JSONError(http.StatusInternalServerError, err)
+			return
+		}
+
+		if claim.Done() {
+			for name, value := range claim.Header {
+				w.Header().Set(name, value)
+			}
+			w.Header().Set(IdempotentReplayHeader, "true")
+			w.WriteHeader(claim.Status)
+			_, _ = w.Write(claim.Body)
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w}
+		stopRenewing := renewClaim(claim, hr.RequestID())
+		h(rec, r)
+		stopRenewing()
+
+		logFields := log.Fields{
+			"request_id": hr.RequestID(),
+			"status":     rec.status,
+		}
+		if rec.status == 0 || rec.status >= http.StatusInternalServerError {
+			if err := claim.Release(); err != nil {
+				log.WithFields(logFields).WithField("error", err).Error("failed to release idempotency key")
+			}
+			return
+		}
+		header := make(map[string]string)
+		for _, name := range idempotentHeaders {
+			if value := rec.Header().Get(name); value != "" {
+				header[name] = value
+			}
+		}
+		if err := claim.Complete(rec.status, header, rec.body.Bytes()); err != nil {
+			log.WithFields(logFields).WithField("error", err).Error("failed to keep idempotent response")
+			// Retries would otherwise wait for the claim to expire
+			_ = claim.Release()
+		}
+	}
+}
+
+// renewClaim renews the claim of an idempotency key in the background, so that
+// it does not expire while its request is in progress, until the returned func
+// is called
+func renewClaim(claim *lochness.IdempotentResponse, requestID string) func() {
+	interval := lochness.IdempotencyClaimTTL / 3
+	if interval <= 0 {
+		return func() {}
+	}
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			if err := claim.Renew(); err != nil {
+				log.WithFields(log.Fields{
+					"request_id": requestID,
+					"error":      err,
+					"func":       "lochness.IdempotentResponse.Renew",
+				}).Error("failed to renew idempotency key")
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+// responseRecorder records the status and body of a response as it is written
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+// WriteHeader records the status and writes the header
+func (rr *responseRecorder) WriteHeader(status int) {
+	if rr.status == 0 {
+		rr.status = status
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the body and writes it
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	rr.body.Write(b)
+	return rr.ResponseWriter.Write(b)
+}
+
+// SweepIdempotencyKeys removes the expired idempotency keys every interval,
+// forever
+func SweepIdempotencyKeys(ctx *lochness.Context, interval time.Duration) {
+	for now := range time.Tick(interval) {
+		removed, err := ctx.RemoveExpiredIdempotencyKeys(now)
+		if removed > 0 {
+			log.WithField("keys", removed).Info("removed expired idempotency keys")
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"func":  "lochness.Context.RemoveExpiredIdempotencyKeys",
+			}).Error("failed to remove expired idempotency keys")
+		}
+	}
+}
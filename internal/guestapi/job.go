@@ -0,0 +1,52 @@
+package guestapi
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	"github.com/pborman/uuid"
+)
+
+// RegisterJobRoutes registers the guest routes and handlers
+func RegisterJobRoutes(prefix string, router *mux.Router, m *MetricsContext) {
+	// TODO: Figure out a cleaner way to do middleware on the subrouter
+	sub := router.PathPrefix(prefix).Subrouter()
+
+	sub.Handle("/{jobID}", m.mmw.HandlerFunc(GetJob, "job")).Methods("GET")
+	sub.Handle("/{jobID}/graph", m.mmw.HandlerFunc(GetJobGraph, "job_graph")).Methods("GET")
+}
+
+// GetJob gets a job status
+func GetJob(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	vars := mux.Vars(r)
+	jobQueue := GetJobQueue(r)
+	job, err := jobQueue.Job(vars["jobID"])
+	if err != nil {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	hr.JSON(http.StatusOK, job)
+}
+
+// GetJobGraph gets the jobs a job depends on and those that depend on it,
+// transitively, each after the jobs it depends on
+func GetJobGraph(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	jobID := mux.Vars(r)["jobID"]
+	if uuid.Parse(jobID) == nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_job_id", "invalid job id")
+		return
+	}
+	graph, err := GetJobQueue(r).JobGraph(jobID)
+	if err != nil {
+		if GetContext(r).IsKeyNotFound(err) {
+			hr.JSONErrorMsg(http.StatusNotFound, "job_not_found", "job not found")
+			return
+		}
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	hr.JSON(http.StatusOK, graph)
+}
@@ -0,0 +1,79 @@
+package guestapi
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/mistifyio/lochness/pkg/jobqueue"
+	"github.com/pborman/uuid"
+)
+
+// PurgeDeletedGuests queues the delete jobs of the soft deleted guests whose
+// restore window passed by now, unless already queued, returning how many were
+// and the first error of those that could not be
+func PurgeDeletedGuests(ctx *lochness.Context, jobQueue *jobqueue.Client, now time.Time) (int, error) {
+	var expired lochness.Guests
+	err := ctx.ForEachGuest(func(g *lochness.Guest) error {
+		if g.IsDeleted() && g.Tombstone.PurgeJob == "" && g.Tombstone.Expired(now) {
+			expired = append(expired, g)
+		}
+		return nil
+	})
+	if err != nil {
+		if ctx.IsKeyNotFound(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	// one guest failing to be purged does not hold back the others
+	purged := 0
+	for _, g := range expired {
+		if _, e := purgeGuest(jobQueue, g); e != nil {
+			if err == nil {
+				err = e
+			}
+			continue
+		}
+		purged++
+	}
+	return purged, err
+}
+
+// purgeGuest records the job deleting a soft deleted guest for good in the
+// guest's tombstone, so that it is not restored nor queued again, and then
+// queues it. The tombstone is saved first, failing with a conflict if the guest
+// was restored or purged meanwhile, so that it is never purged twice nor after
+// being restored. A guest already being purged is a conflict as well.
+func purgeGuest(jobQueue *jobqueue.Client, g *lochness.Guest) (*jobqueue.Job, error) {
+	if g.Tombstone.PurgeJob != "" {
+		return nil, lerrors.Conflict(errors.New("guest is already being purged"))
+	}
+
+	id := uuid.New()
+	g.Tombstone.PurgeJob = id
+	if err := g.Save(); err != nil {
+		g.Tombstone.PurgeJob = ""
+		return nil, err
+	}
+
+	job, err := jobQueue.AddJobWithID(context.Background(), id, g.ID, "delete", nil)
+	if err != nil {
+		// let the guest be restored, or purged again
+		g.Tombstone.PurgeJob = ""
+		if e := g.Save(); e != nil {
+			log.WithFields(log.Fields{
+				"error": e,
+				"func":  "lochness.Guest.Save",
+				"guest": g.ID,
+				"job":   id,
+			}).Error("failed to clear purge job of guest that could not be purged")
+		}
+		return nil, err
+	}
+	return job, nil
+}
@@ -0,0 +1,52 @@
+package guestapi
+
+import (
+	"net/http"
+
+	"github.com/mistifyio/lochness/internal/httpmw"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+)
+
+// resizeRequest is the request body of ResizeGuest
+type resizeRequest struct {
+	// Flavor is the id of the flavor to resize the guest to
+	Flavor string `json:"flavor"`
+}
+
+// ResizeGuest queues a job to resize a guest to another flavor, once the
+// guest's hypervisor is found to have the resources the flavor adds
+func ResizeGuest(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	guest := GetRequestGuest(r)
+
+	dependsOn, ok := dependsOnHelper(hr, r)
+	if !ok {
+		return
+	}
+
+	req := resizeRequest{}
+	if err := httpmw.Decode(r, &req); err != nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_json", err.Error())
+		return
+	}
+
+	if _, err := guest.Resize(req.Flavor); err != nil {
+		switch {
+		// checked first, as the conflicts of hypervisors without the
+		// resources wrap the validation errors saying which they lack
+		case lerrors.IsConflict(err):
+			hr.JSONErrorMsg(http.StatusConflict, "guest_not_resizable", err.Error())
+		case lerrors.IsValidation(err):
+			hr.JSONError(http.StatusBadRequest, err)
+		default:
+			hr.JSONError(http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	if !saveGuestHelper(hr, guest) {
+		return
+	}
+
+	guestNewJobHelper(hr, r, guest, "resize", dependsOn)
+}
@@ -0,0 +1,28 @@
+package guestapi
+
+import (
+	"net/http"
+
+	"github.com/mistifyio/lochness/internal/httpmw"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+)
+
+// GetSpreadViolations reports the failure domains holding more of the guests
+// of an anti-affinity group than an even spread would, see
+// lochness.Context.SpreadViolations. The level query parameter is the level of
+// the failure domains, the cluster's spread level by default.
+func GetSpreadViolations(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	ctx := GetContext(r)
+
+	violations, err := ctx.SpreadViolations(r.URL.Query().Get("level"))
+	if err != nil {
+		if lerrors.IsValidation(err) {
+			hr.JSONError(http.StatusBadRequest, err)
+			return
+		}
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	hr.JSON(http.StatusOK, violations)
+}
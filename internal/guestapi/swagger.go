@@ -0,0 +1,258 @@
+package guestapi
+
+import (
+	"fmt"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	"github.com/mistifyio/lochness/pkg/events"
+	"github.com/mistifyio/lochness/pkg/jobqueue"
+	"github.com/mistifyio/lochness/pkg/swagger"
+)
+
+// apiVersion is the version of the API described by /swagger.json
+const apiVersion = "1.0"
+
+// jobHeader documents the header returned by routes that queue a job
+var jobHeader = map[string]string{
+	"X-Guest-Job-ID": "id of the job queued for the guest",
+}
+
+// dependsOnParam documents the query parameter of the routes that queue a job
+// naming the jobs it depends on
+var dependsOnParam = swagger.Parameter{
+	Name: "depends_on", Type: "string", Description: "id of a job that must succeed before the queued job starts. may be repeated or comma separated",
+}
+
+// idempotencyHeader documents the request header of the routes creating guests
+// that makes them safe to retry
+var idempotencyHeader = map[string]string{
+	IdempotencyKeyHeader: "key unique to the request, so that retries sending it again get its response, with Idempotent-Replayed: true, instead of creating guests again",
+}
+
+// usageQuery documents the query parameters of the usage routes
+var usageQuery = []swagger.Parameter{
+	{Name: "tenant", Type: "string", Description: "tenant to report the usage of. all if blank"},
+	{Name: "from", Type: "string", Description: "start of the period, a date or RFC 3339 time. defaults to 30 days before to"},
+	{Name: "to", Type: "string", Description: "end of the period, a date or RFC 3339 time. defaults to now"},
+}
+
+// routeDocs documents the request and response bodies of the api routes
+func routeDocs() swagger.Routes {
+	docs := swagger.Routes{
+		"GET /guests": {
+			Summary: "List the guests",
+			Tags:    []string{"guests"},
+			Query: []swagger.Parameter{
+				{Name: "metadata", Type: "string", Description: "key=value pair the metadata must have. may be repeated"},
+				{Name: "deleted", Type: "boolean", Description: "list the soft deleted guests instead of the others"},
+			},
+			Response: lochness.Guests{},
+			Headers: map[string]string{
+				"ETag": "tag of the listing; requests with it in If-None-Match get 304 Not Modified until the listing changes",
+			},
+		},
+		"POST /guests": {
+			Summary: "Create a guest and queue a job to place it on a hypervisor",
+			Tags:    []string{"guests"},
+			Query: []swagger.Parameter{
+				{Name: "mac_oui", Type: "string", Description: "OUI of the MAC generated when the guest has none, e.g. 52:54:00"},
+				dependsOnParam,
+			},
+			Request:  &lochness.Guest{},
+			Response: &lochness.Guest{},
+			Status:   http.StatusAccepted,
+			Headers:  jobHeader,
+
+			RequestHeaders: idempotencyHeader,
+		},
+		"POST /guests/batch": {
+			Summary: "Create guests from an array of guest specs and queue a job to place each, returning the guest and job id, or error, of each spec",
+			Tags:    []string{"guests"},
+			Query: []swagger.Parameter{
+				{Name: "all_or_nothing", Type: "boolean", Description: "create no guest if any is invalid"},
+				{Name: "mac_oui", Type: "string", Description: "OUI of the MACs generated for guests without one, e.g. 52:54:00"},
+				dependsOnParam,
+			},
+			Request:  lochness.Guests{},
+			Response: []batchResult{},
+			Status:   http.StatusAccepted,
+
+			RequestHeaders: idempotencyHeader,
+		},
+		"POST /guests/constraints": {
+			Summary:  "Check a guest constraints expression, returning the ids of the hypervisors meeting it for the optional guest spec",
+			Tags:     []string{"guests"},
+			Request:  constraintsTest{},
+			Response: constraintsResult{},
+		},
+		"GET /guests/spread": {
+			Summary: "Report the failure domains holding more of the guests of an anti-affinity group than an even spread would",
+			Tags:    []string{"guests"},
+			Query: []swagger.Parameter{
+				{Name: "level", Type: "string", Description: "level of the failure domains, zone, row, or rack. defaults to the cluster's"},
+			},
+			Response: []lochness.SpreadViolation{},
+		},
+		"GET /guests/dns": {
+			Summary: "List the DNS names of the guests, sorted by domain and host",
+			Tags:    []string{"guests"},
+			Query: []swagger.Parameter{
+				{Name: "domain", Type: "string", Description: "only the names in this domain, not its subdomains"},
+			},
+			Response: []lochness.DNSRecord{},
+		},
+		"GET /guests/{guestID}": {
+			Summary:  "Get a guest",
+			Tags:     []string{"guests"},
+			Response: &lochness.Guest{},
+		},
+		"PATCH /guests/{guestID}": {
+			Summary:  "Update a guest",
+			Tags:     []string{"guests"},
+			Request:  &lochness.Guest{},
+			Response: &lochness.Guest{},
+		},
+		"DELETE /guests/{guestID}": {
+			Summary:  "Queue a job to delete a guest. With a soft delete window configured, the guest is only marked deleted, with 200 OK, unless it already is. Deleting a guest already being purged is a conflict.",
+			Tags:     []string{"guests"},
+			Query:    []swagger.Parameter{dependsOnParam},
+			Response: &lochness.Guest{},
+			Status:   http.StatusAccepted,
+			Headers:  jobHeader,
+		},
+		"POST /guests/{guestID}/restore": {
+			Summary:  "Restore a soft deleted guest that is not being purged",
+			Tags:     []string{"guests"},
+			Request:  map[string]string{},
+			Response: &lochness.Guest{},
+		},
+		"POST /guests/{guestID}/console": {
+			Summary:  "Create a one-time token for connecting to a console of a guest",
+			Tags:     []string{"console"},
+			Request:  map[string]string{},
+			Response: &lochness.ConsoleToken{},
+			Status:   http.StatusCreated,
+		},
+		"POST /guests/{guestID}/clone": {
+			Summary: "Create a guest from an existing one and queue a job to clone its disks on its hypervisor",
+			Tags:    []string{"guests"},
+			Query: []swagger.Parameter{
+				{Name: "mac_oui", Type: "string", Description: "OUI of the MAC generated for the clone, e.g. 52:54:00"},
+				dependsOnParam,
+			},
+			Request:  &cloneRequest{},
+			Response: &lochness.Guest{},
+			Status:   http.StatusAccepted,
+			Headers:  jobHeader,
+		},
+		"POST /guests/{guestID}/resize": {
+			Summary:  "Queue a job to resize a guest to another flavor on its hypervisor",
+			Tags:     []string{"guests"},
+			Query:    []swagger.Parameter{dependsOnParam},
+			Request:  &resizeRequest{},
+			Response: &lochness.Guest{},
+			Status:   http.StatusAccepted,
+			Headers:  jobHeader,
+		},
+		"GET /console/{token}": {
+			Summary: "Connect to a console, upgrading the connection to a raw tcp tunnel",
+			Tags:    []string{"console"},
+			Status:  http.StatusSwitchingProtocols,
+		},
+		"GET /jobs/{jobID}": {
+			Summary:  "Get a job",
+			Tags:     []string{"jobs"},
+			Response: &jobqueue.Job{},
+		},
+		"GET /jobs/{jobID}/graph": {
+			Summary:  "Get the graph of the jobs a job depends on and that depend on it, each after those it depends on",
+			Tags:     []string{"jobs"},
+			Response: &jobqueue.JobGraph{},
+		},
+		"GET /flavors/{flavorID}": {
+			Summary:  "Get a flavor",
+			Tags:     []string{"flavors"},
+			Response: &lochness.Flavor{},
+		},
+		"GET /usage": {
+			Summary:  "Get the usage of the guests per tenant and day, in UTC, for chargeback",
+			Tags:     []string{"usage"},
+			Query:    usageQuery,
+			Response: lochness.Usages{},
+		},
+		"GET /usage/export": {
+			Summary:  "Export the usage of the guests per tenant and day, in UTC, as CSV",
+			Tags:     []string{"usage"},
+			Query:    usageQuery,
+			Produces: []string{"text/csv"},
+		},
+		"GET /capacity/forecast": {
+			Summary: "Forecast whether guests of a flavor could be placed, on which hypervisors, and the headroom left per failure domain",
+			Tags:    []string{"capacity"},
+			Query: []swagger.Parameter{
+				{Name: "flavor", Type: "string", Description: "id of the flavor of the guests"},
+				{Name: "count", Type: "integer", Description: "number of guests. defaults to 1"},
+				{Name: "domain", Type: "string", Description: "level of the failure domains, zone, row, or rack. defaults to rack"},
+				{Name: "constraints", Type: "string", Description: "constraints expression the hypervisors must meet"},
+			},
+			Response: &lochness.Forecast{},
+		},
+		"GET /events": {
+			Summary: "Stream the changes to guests and hypervisors as server-sent events",
+			Tags:    []string{"events"},
+			Query: []swagger.Parameter{
+				{Name: "prefix", Type: "string", Description: "guests or hypervisors, the entities to stream changes of. may be repeated"},
+				{Name: "id", Type: "string", Description: "id of an entity to stream changes of. may be repeated"},
+				{Name: "metadata", Type: "string", Description: "key=value pair the metadata of the entity must have. may be repeated"},
+				{Name: "last_event_id", Type: "string", Description: "id of the last event received, to resume after, as the Last-Event-ID header"},
+			},
+			Response: &events.Event{},
+			Produces: []string{"text/event-stream"},
+		},
+		"GET /schemas": {
+			Summary:  "List the entities with JSON Schemas",
+			Tags:     []string{"schemas"},
+			Response: []string{},
+		},
+		"GET /schemas/{entity}": {
+			Summary:  "Get the JSON Schema (draft-07) of an entity, e.g. guest",
+			Tags:     []string{"schemas"},
+			Response: map[string]interface{}{},
+		},
+		"GET /swagger.json": {
+			Summary: "Get this description of the api",
+		},
+	}
+	for _, action := range guestActions {
+		docs[fmt.Sprintf("POST /guests/{guestID}/{action:%s}", action)] = swagger.Route{
+			Summary:  fmt.Sprintf("Queue a job to %s a guest", action),
+			Tags:     []string{"actions"},
+			Query:    []swagger.Parameter{dependsOnParam},
+			Response: &lochness.Guest{},
+			Status:   http.StatusAccepted,
+			Headers:  jobHeader,
+		}
+	}
+	return docs
+}
+
+// RegisterSwaggerRoute registers a route serving a swagger description of all
+// routes on the router. It must be called after all other routes are
+// registered.
+func RegisterSwaggerRoute(router *mux.Router) *swagger.Spec {
+	spec := swagger.New("cguestd", apiVersion)
+	spec.SetError(&httpmw.HTTPError{})
+	spec.Consumes = httpmw.MediaTypes
+	spec.Produces = httpmw.MediaTypes
+	spec.BasePath = httpmw.APIPrefix
+
+	router.Handle("/swagger.json", spec.Handler()).Methods("GET")
+	if err := spec.AddRouter(router, routeDocs()); err != nil {
+		log.WithField("error", err).Fatal("failed to describe api")
+	}
+	return spec
+}
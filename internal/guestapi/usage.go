@@ -0,0 +1,124 @@
+package guestapi
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+)
+
+// defaultUsagePeriod is the period usage is reported for when the query does
+// not start it
+const defaultUsagePeriod = 30 * 24 * time.Hour
+
+// usageColumns are the columns of the usage export, as the fields of
+// lochness.Usage are named in JSON
+var usageColumns = []string{"day", "tenant", "guests", "running_hours", "memory_hours", "disk_hours", "cpu_hours"}
+
+// RegisterUsageRoutes registers the routes reporting the usage of the guests,
+// for chargeback and billing
+func RegisterUsageRoutes(prefix string, router *mux.Router, m *MetricsContext) {
+	router.Handle(prefix, m.mmw.HandlerFunc(GetUsage, "usage")).Methods("GET")
+	sub := router.PathPrefix(prefix).Subrouter()
+	sub.Handle("/export", m.mmw.HandlerFunc(ExportUsage, "usage_export")).Methods("GET")
+}
+
+// GetUsage gets the usage of the guests per tenant and day, see usageHelper
+func GetUsage(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	usage, ok := usageHelper(hr, r)
+	if !ok {
+		return
+	}
+	hr.JSON(http.StatusOK, usage)
+}
+
+// ExportUsage gets the usage of the guests per tenant and day as CSV, with a
+// header row, see usageHelper
+func ExportUsage(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	usage, ok := usageHelper(hr, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="usage.csv"`)
+	w.WriteHeader(http.StatusOK)
+
+	cw := csv.NewWriter(w)
+	_ = cw.Write(usageColumns)
+	for _, u := range usage {
+		_ = cw.Write([]string{
+			u.Day,
+			u.Tenant,
+			strconv.Itoa(u.Guests),
+			formatHours(u.RunningHours),
+			formatHours(u.MemoryHours),
+			formatHours(u.DiskHours),
+			formatHours(u.CPUHours),
+		})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		log.WithField("error", err).Error("failed to write usage export")
+	}
+}
+
+// formatHours formats resource hours for the usage export
+func formatHours(hours float64) string {
+	return strconv.FormatFloat(hours, 'f', 4, 64)
+}
+
+// usageHelper gets the usage of the guests between the from and to query
+// parameters, of the tenant parameter if given. from defaults to
+// defaultUsagePeriod before to, which defaults to now. Both are dates, taken
+// as midnight UTC, or RFC 3339 times. It sends an error response if it fails.
+func usageHelper(hr *httpmw.Response, r *http.Request) (lochness.Usages, bool) {
+	ctx := GetContext(r)
+	query := r.URL.Query()
+
+	to := time.Now()
+	if v := query.Get("to"); v != "" {
+		t, err := parseUsageTime(v)
+		if err != nil {
+			hr.JSONErrorMsg(http.StatusBadRequest, "invalid_to", "invalid to: "+err.Error())
+			return nil, false
+		}
+		to = t
+	}
+	from := to.Add(-defaultUsagePeriod)
+	if v := query.Get("from"); v != "" {
+		t, err := parseUsageTime(v)
+		if err != nil {
+			hr.JSONErrorMsg(http.StatusBadRequest, "invalid_from", "invalid from: "+err.Error())
+			return nil, false
+		}
+		from = t
+	}
+
+	usage, err := ctx.Usage(query.Get("tenant"), from, to)
+	if err != nil {
+		if lerrors.IsValidation(err) {
+			hr.JSONError(http.StatusBadRequest, err)
+			return nil, false
+		}
+		hr.JSONError(http.StatusInternalServerError, err)
+		return nil, false
+	}
+	return usage, true
+}
+
+// parseUsageTime parses a date or RFC 3339 time
+func parseUsageTime(v string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", v); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
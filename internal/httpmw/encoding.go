@@ -0,0 +1,199 @@
+package httpmw
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Media types that responses are encoded as and request bodies decoded from
+const (
+	MediaTypeJSON    = "application/json"
+	MediaTypeYAML    = "application/x-yaml"
+	MediaTypeMsgpack = "application/msgpack"
+)
+
+// MediaTypes are the media types supported, JSON first as the default
+var MediaTypes = []string{MediaTypeJSON, MediaTypeYAML, MediaTypeMsgpack}
+
+// mediaTypeAliases maps the media types accepted for each supported one,
+// including unofficial variants clients send, to the one responses use
+var mediaTypeAliases = map[string]string{
+	MediaTypeJSON:           MediaTypeJSON,
+	MediaTypeYAML:           MediaTypeYAML,
+	"application/yaml":      MediaTypeYAML,
+	"text/yaml":             MediaTypeYAML,
+	"text/x-yaml":           MediaTypeYAML,
+	MediaTypeMsgpack:        MediaTypeMsgpack,
+	"application/x-msgpack": MediaTypeMsgpack,
+	"*/*":                   MediaTypeJSON,
+	"application/*":         MediaTypeJSON,
+}
+
+// Negotiate picks the media type of the response from the Accept header of the
+// request, JSON if the client prefers none of the supported ones, and sets it
+// as the Content-Type of the response for ResponseType. The response is marked
+// as varying with the Accept header so that caches keep each encoding.
+func Negotiate(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", accepted(r.Header.Get("Accept")))
+		w.Header().Add("Vary", "Accept")
+		h.ServeHTTP(w, r)
+	})
+}
+
+// accepted returns the supported media type an Accept header prefers. Types of
+// equal quality are preferred in the order listed.
+func accepted(header string) string {
+	best, bestQ := MediaTypeJSON, 0.0
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		supported, ok := mediaTypeAliases[mediaType]
+		if !ok {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > bestQ {
+			best, bestQ = supported, q
+		}
+	}
+	return best
+}
+
+// ResponseType returns the media type negotiated for a response by Negotiate,
+// or JSON if it was not negotiated
+func ResponseType(header http.Header) string {
+	if mediaType, ok := mediaTypeAliases[header.Get("Content-Type")]; ok {
+		return mediaType
+	}
+	return MediaTypeJSON
+}
+
+// Encode writes obj to w as the media type given. YAML and msgpack have the
+// same structure as the JSON encoding, so field names and formats follow the
+// json tags and marshalers of obj.
+func Encode(w io.Writer, mediaType string, obj interface{}) error {
+	switch mediaType {
+	case MediaTypeYAML, MediaTypeMsgpack:
+	default:
+		return json.NewEncoder(w).Encode(obj)
+	}
+
+	v, err := generic(obj)
+	if err != nil {
+		return err
+	}
+	if mediaType == MediaTypeMsgpack {
+		return encodeMsgpack(w, v)
+	}
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(v); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// Decode reads the body of r into obj as its Content-Type, JSON if unset. An
+// empty body is io.EOF whatever the type, as with json.Decoder.
+func Decode(r *http.Request, obj interface{}) error {
+	mediaType := MediaTypeJSON
+	if header := r.Header.Get("Content-Type"); header != "" {
+		parsed, _, err := mime.ParseMediaType(header)
+		if err != nil {
+			return fmt.Errorf("invalid content type: %s", err)
+		}
+		var ok bool
+		if mediaType, ok = mediaTypeAliases[parsed]; !ok || strings.Contains(parsed, "*") {
+			return fmt.Errorf("unsupported content type %q", parsed)
+		}
+	}
+	if mediaType == MediaTypeJSON {
+		return json.NewDecoder(r.Body).Decode(obj)
+	}
+
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return io.EOF
+	}
+
+	var v interface{}
+	if mediaType == MediaTypeMsgpack {
+		v, err = decodeMsgpack(data)
+	} else {
+		err = yaml.Unmarshal(data, &v)
+	}
+	if err != nil {
+		return err
+	}
+	// decode through json so obj is filled as from a JSON body
+	data, err = json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("unsupported body: %s", err)
+	}
+	return json.Unmarshal(data, obj)
+}
+
+// generic converts obj to the maps, slices, and scalars of its JSON encoding,
+// keeping integers as integers
+func generic(obj interface{}) (interface{}, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+	return numbers(v)
+}
+
+// numbers replaces the json.Numbers of a decoded value with integers where
+// they fit, and floats otherwise
+func numbers(v interface{}) (interface{}, error) {
+	var err error
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		if u, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			return u, nil
+		}
+		return v.Float64()
+	case map[string]interface{}:
+		for k, e := range v {
+			if v[k], err = numbers(e); err != nil {
+				return nil, err
+			}
+		}
+	case []interface{}:
+		for i, e := range v {
+			if v[i], err = numbers(e); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,175 @@
+package httpmw_test
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mistifyio/lochness/internal/httpmw"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestEncoding(t *testing.T) {
+	suite.Run(t, new(EncodingSuite))
+}
+
+type EncodingSuite struct {
+	suite.Suite
+}
+
+// encodingTest is a value encoded with its json tags and marshalers
+type encodingTest struct {
+	Name    string            `json:"name"`
+	Count   int64             `json:"count"`
+	Size    uint64            `json:"size"`
+	Ratio   float64           `json:"ratio"`
+	Enabled bool              `json:"enabled"`
+	Tags    []string          `json:"tags"`
+	Meta    map[string]string `json:"meta"`
+	Parent  *encodingTest     `json:"parent"`
+	Created time.Time         `json:"created"`
+}
+
+func newEncodingTest() encodingTest {
+	return encodingTest{
+		Name:    strings.Repeat("a", 300),
+		Count:   -70000,
+		Size:    math.MaxUint64,
+		Ratio:   0.5,
+		Enabled: true,
+		Tags:    []string{"foo", "bar"},
+		Meta:    map[string]string{"foo": "bar"},
+		Created: time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+}
+
+// request creates a request with a body of the content type given
+func (s *EncodingSuite) request(contentType string, body []byte) *http.Request {
+	r, err := http.NewRequest("POST", "/foo", bytes.NewReader(body))
+	s.Require().NoError(err)
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	return r
+}
+
+func (s *EncodingSuite) TestNegotiate() {
+	tests := []struct {
+		accept   string
+		expected string
+	}{
+		{"", httpmw.MediaTypeJSON},
+		{"*/*", httpmw.MediaTypeJSON},
+		{"text/html", httpmw.MediaTypeJSON},
+		{"application/x-yaml", httpmw.MediaTypeYAML},
+		{"application/yaml", httpmw.MediaTypeYAML},
+		{"application/msgpack", httpmw.MediaTypeMsgpack},
+		{"application/x-msgpack", httpmw.MediaTypeMsgpack},
+		{"application/json, application/msgpack", httpmw.MediaTypeJSON},
+		{"application/json;q=0.5, application/msgpack", httpmw.MediaTypeMsgpack},
+		{"text/html, application/x-yaml;q=0.9, */*;q=0.8", httpmw.MediaTypeYAML},
+		{"application/x-yaml;q=foo", httpmw.MediaTypeJSON},
+	}
+
+	for _, test := range tests {
+		var negotiated string
+		h := httpmw.Negotiate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			negotiated = httpmw.ResponseType(w.Header())
+		}))
+		r, _ := http.NewRequest("GET", "/foo", nil)
+		r.Header.Set("Accept", test.accept)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		s.Equal(test.expected, negotiated, test.accept)
+		s.Equal(test.expected, w.Header().Get("Content-Type"), test.accept)
+		s.Equal("Accept", w.Header().Get("Vary"), test.accept)
+	}
+
+	s.Equal(httpmw.MediaTypeJSON, httpmw.ResponseType(http.Header{}), "responses not negotiated should be json")
+}
+
+func (s *EncodingSuite) TestEncodeDecode() {
+	value := newEncodingTest()
+	value.Parent = &encodingTest{Name: "parent"}
+
+	for _, mediaType := range httpmw.MediaTypes {
+		buf := &bytes.Buffer{}
+		s.Require().NoError(httpmw.Encode(buf, mediaType, value), mediaType)
+
+		decoded := encodingTest{}
+		s.NoError(httpmw.Decode(s.request(mediaType, buf.Bytes()), &decoded), mediaType)
+		s.Equal(value, decoded, mediaType)
+	}
+}
+
+func (s *EncodingSuite) TestEncodeYAML() {
+	buf := &bytes.Buffer{}
+	s.NoError(httpmw.Encode(buf, httpmw.MediaTypeYAML, map[string]interface{}{
+		"size":  uint64(math.MaxUint64),
+		"list":  []int{1, 2},
+		"empty": nil,
+	}))
+	s.Equal("empty: null\nlist:\n  - 1\n  - 2\nsize: 18446744073709551615\n", buf.String())
+}
+
+func (s *EncodingSuite) TestEncodeMsgpack() {
+	buf := &bytes.Buffer{}
+	s.NoError(httpmw.Encode(buf, httpmw.MediaTypeMsgpack, map[string]interface{}{
+		"a": []interface{}{1, -1, 200, -200, true, nil, "x"},
+		"b": 0.5,
+	}))
+	s.Equal([]byte{
+		0x82,
+		0xa1, 'a', 0x97, 0x01, 0xff, 0xcc, 0xc8, 0xd1, 0xff, 0x38, 0xc3, 0xc0, 0xa1, 'x',
+		0xa1, 'b', 0xcb, 0x3f, 0xe0, 0, 0, 0, 0, 0, 0,
+	}, buf.Bytes())
+}
+
+func (s *EncodingSuite) TestDecode() {
+	tests := []struct {
+		description string
+		contentType string
+		body        string
+		expected    encodingTest
+		err         bool
+	}{
+		{"no content type", "", `{"name":"foo"}`, encodingTest{Name: "foo"}, false},
+		{"json with charset", "application/json; charset=utf-8", `{"name":"foo"}`, encodingTest{Name: "foo"}, false},
+		{"yaml", "application/x-yaml", "name: foo\ncount: 2\ntags: [a]\n", encodingTest{Name: "foo", Count: 2, Tags: []string{"a"}}, false},
+		{"yaml alias", "text/yaml", "name: foo\n", encodingTest{Name: "foo"}, false},
+		{"msgpack", "application/msgpack", "\x81\xa4name\xa3foo", encodingTest{Name: "foo"}, false},
+		{"msgpack binary", "application/x-msgpack", "\x81\xa4name\xc4\x03foo", encodingTest{Name: "foo"}, false},
+		{"unsupported type", "text/plain", `{"name":"foo"}`, encodingTest{}, true},
+		{"wildcard type", "*/*", `{"name":"foo"}`, encodingTest{}, true},
+		{"invalid yaml", "application/x-yaml", "name: [foo\n", encodingTest{}, true},
+		{"yaml wrong type", "application/x-yaml", "name: [foo]\n", encodingTest{}, true},
+		{"truncated msgpack", "application/msgpack", "\x81\xa4name\xa3fo", encodingTest{}, true},
+		{"trailing msgpack", "application/msgpack", "\x81\xa4name\xa3foo\xc0", encodingTest{}, true},
+		{"msgpack map key", "application/msgpack", "\x81\x01\xa3foo", encodingTest{}, true},
+		{"msgpack extension", "application/msgpack", "\xd4\x01\x00", encodingTest{}, true},
+	}
+
+	for _, test := range tests {
+		decoded := encodingTest{}
+		err := httpmw.Decode(s.request(test.contentType, []byte(test.body)), &decoded)
+		if test.err {
+			s.Error(err, test.description)
+			continue
+		}
+		s.NoError(err, test.description)
+		s.Equal(test.expected, decoded, test.description)
+	}
+}
+
+func (s *EncodingSuite) TestDecodeEmpty() {
+	for _, mediaType := range httpmw.MediaTypes {
+		decoded := encodingTest{}
+		s.Equal(io.EOF, httpmw.Decode(s.request(mediaType, nil), &decoded), mediaType)
+	}
+}
@@ -0,0 +1,150 @@
+// Package httpmw provides http middleware shared by the lochness api daemons:
+// request ids, content negotiation between JSON, YAML, and msgpack, request
+// logging with slow request tagging, optional tracing, and versioned routes,
+// along with the JSON responses and errors of their handlers.
+package httpmw
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness/internal/tracing"
+	"github.com/pborman/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader is the header carrying the id of a request. It is set on
+// every response, and is taken from the request if the client provides one.
+const RequestIDHeader = "X-Request-ID"
+
+// Config configures the request logging middleware
+type Config struct {
+	// Name of the daemon, added to every log entry and used as the tracer name
+	Name string
+	// SlowThreshold is the latency above which requests are logged as slow.
+	// Zero disables slow request tagging.
+	SlowThreshold time.Duration
+	// Trace enables a span per request, emitted through the global
+	// OpenTelemetry tracer provider. See SetupTracing.
+	Trace bool
+}
+
+// RequestID makes sure every request has an id, which is echoed in the
+// response headers. The id is also the id of the request's trace, carried by
+// its context, see tracing.ID.
+func RequestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if requestID == "" {
+			requestID = uuid.New()
+			r.Header.Set(RequestIDHeader, requestID)
+		}
+		w.Header().Set(RequestIDHeader, requestID)
+		h.ServeHTTP(w, r.WithContext(tracing.WithID(r.Context(), requestID)))
+	})
+}
+
+// Logger creates a middleware that logs the method, path, status, latency,
+// and response size of every request. It should come after RequestID so that
+// the request id is logged.
+func Logger(config Config) func(http.Handler) http.Handler {
+	var tracer trace.Tracer
+	if config.Trace {
+		tracer = otel.Tracer(config.Name)
+	}
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rw := &responseWriter{ResponseWriter: w}
+
+			var span trace.Span
+			if tracer != nil {
+				r, span = startSpan(tracer, r)
+			}
+
+			h.ServeHTTP(rw, r)
+
+			latency := time.Since(start)
+			slow := config.SlowThreshold > 0 && latency > config.SlowThreshold
+			if span != nil {
+				endSpan(span, rw, slow)
+			}
+
+			entry := log.WithFields(log.Fields{
+				"name":       config.Name,
+				"method":     r.Method,
+				"path":       r.URL.Path,
+				"status":     rw.Status(),
+				"latency":    latency,
+				"size":       rw.size,
+				"remote":     r.RemoteAddr,
+				"request_id": r.Header.Get(RequestIDHeader),
+			})
+			if slow {
+				entry.WithField("slow", true).Warn("slow request")
+				return
+			}
+			entry.Info("request")
+		})
+	}
+}
+
+// responseWriter records the status and size of a response
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+// WriteHeader records the status and writes the header
+func (rw *responseWriter) WriteHeader(status int) {
+	if rw.status == 0 {
+		rw.status = status
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the size of the body and writes it
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	n, err := rw.ResponseWriter.Write(b)
+	rw.size += n
+	return n, err
+}
+
+// Flush flushes the underlying ResponseWriter if it supports it
+func (rw *responseWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack takes over the connection from the underlying ResponseWriter if it
+// supports it, recording a protocol switch
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	if rw.status == 0 {
+		rw.status = http.StatusSwitchingProtocols
+	}
+	return h.Hijack()
+}
+
+// Status returns the status of the response. Nothing written means an
+// implicit 200.
+func (rw *responseWriter) Status() int {
+	if rw.status == 0 {
+		return http.StatusOK
+	}
+	return rw.status
+}
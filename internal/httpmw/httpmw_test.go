@@ -0,0 +1,173 @@
+package httpmw_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/mistifyio/lochness/internal/tracing"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestHTTPMW(t *testing.T) {
+	suite.Run(t, new(HTTPMWSuite))
+}
+
+type HTTPMWSuite struct {
+	common.Suite
+	Hook *entryHook
+}
+
+// entryHook records the entries logged
+type entryHook struct {
+	sync.Mutex
+	entries []*log.Entry
+}
+
+func (h *entryHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *entryHook) Fire(e *log.Entry) error {
+	h.Lock()
+	defer h.Unlock()
+	h.entries = append(h.entries, e)
+	return nil
+}
+
+func (s *HTTPMWSuite) SetupSuite() {
+	s.Hook = &entryHook{}
+	log.AddHook(s.Hook)
+	log.SetLevel(log.InfoLevel)
+}
+
+func (s *HTTPMWSuite) TearDownSuite() {
+	log.SetLevel(log.FatalLevel)
+}
+
+func (s *HTTPMWSuite) SetupTest() {
+	s.Hook.entries = nil
+}
+
+func (s *HTTPMWSuite) TearDownTest() {
+}
+
+func (s *HTTPMWSuite) serve(h http.Handler, requestID string) *httptest.ResponseRecorder {
+	r, _ := http.NewRequest("GET", "/foo", nil)
+	if requestID != "" {
+		r.Header.Set(httpmw.RequestIDHeader, requestID)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func (s *HTTPMWSuite) TestRequestID() {
+	var seen, traceID string
+	h := httpmw.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Get(httpmw.RequestIDHeader)
+		traceID = tracing.ID(r.Context())
+	}))
+
+	w := s.serve(h, "")
+	s.NotEmpty(seen)
+	s.Equal(seen, w.Header().Get(httpmw.RequestIDHeader))
+	s.Equal(seen, traceID)
+
+	w = s.serve(h, "foobar")
+	s.Equal("foobar", seen)
+	s.Equal("foobar", w.Header().Get(httpmw.RequestIDHeader))
+	s.Equal("foobar", traceID)
+}
+
+func (s *HTTPMWSuite) TestLogger() {
+	tests := []struct {
+		description string
+		delay       time.Duration
+		status      int
+		body        string
+		level       log.Level
+		slow        bool
+	}{
+		{"implicit ok", 0, 0, "hello", log.InfoLevel, false},
+		{"explicit status", 0, http.StatusNotFound, "", log.InfoLevel, false},
+		{"slow", 20 * time.Millisecond, http.StatusCreated, "hi", log.WarnLevel, true},
+	}
+
+	config := httpmw.Config{Name: "test", SlowThreshold: 10 * time.Millisecond}
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		s.Hook.entries = nil
+
+		h := httpmw.Logger(config)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(test.delay)
+			if test.status != 0 {
+				w.WriteHeader(test.status)
+			}
+			_, _ = w.Write([]byte(test.body))
+		}))
+		_ = s.serve(h, "foobar")
+
+		status := test.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		if !s.Len(s.Hook.entries, 1, msg("wrong number of entries")) {
+			continue
+		}
+		entry := s.Hook.entries[0]
+		s.Equal(test.level, entry.Level, msg("wrong level"))
+		s.Equal("test", entry.Data["name"], msg("wrong name"))
+		s.Equal("GET", entry.Data["method"], msg("wrong method"))
+		s.Equal("/foo", entry.Data["path"], msg("wrong path"))
+		s.Equal(status, entry.Data["status"], msg("wrong status"))
+		s.Equal(len(test.body), entry.Data["size"], msg("wrong size"))
+		s.Equal("foobar", entry.Data["request_id"], msg("wrong request id"))
+		s.True(entry.Data["latency"].(time.Duration) >= test.delay, msg("latency too low"))
+		if test.slow {
+			s.Equal(true, entry.Data["slow"], msg("not tagged slow"))
+		} else {
+			s.NotContains(entry.Data, "slow", msg("tagged slow"))
+		}
+	}
+}
+
+func (s *HTTPMWSuite) TestVersioned() {
+	var seen string
+	h := httpmw.Versioned(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.URL.Path
+	}))
+
+	tests := []struct {
+		path       string
+		seen       string
+		deprecated bool
+	}{
+		{"/v1/guests/foo", "/guests/foo", false},
+		{"/v1", "/", false},
+		{"/v1/", "/", false},
+		{"/guests/foo", "/guests/foo", true},
+		{"/v1foo", "/v1foo", true},
+	}
+	for _, test := range tests {
+		r, _ := http.NewRequest("GET", test.path, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		s.Equal(test.seen, seen, test.path)
+		s.Equal(test.path, r.URL.Path, "the request should be left as made")
+		s.Equal(httpmw.APIVersion, w.Header().Get(httpmw.APIVersionHeader), test.path)
+		if test.deprecated {
+			s.Equal("true", w.Header().Get("Deprecation"), test.path)
+			s.Equal("</v1"+test.path+">; rel=\"successor-version\"", w.Header().Get("Link"), test.path)
+			s.Contains(w.Header().Get("Warning"), "/v1"+test.path, test.path)
+		} else {
+			s.Empty(w.Header().Get("Deprecation"), test.path)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package httpmw
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// The msgpack codec only handles the values of JSON documents: nil, bools,
+// integers, floats, strings, arrays, and maps with string keys. Binary data
+// is decoded as strings, and extension types are not supported.
+
+// errMsgpackShort is returned for msgpack data that ends within a value
+var errMsgpackShort = errors.New("msgpack: unexpected end of data")
+
+// encodeMsgpack writes a value made of the types generic returns as msgpack
+func encodeMsgpack(w io.Writer, v interface{}) error {
+	bw := bufio.NewWriter(w)
+	if err := writeMsgpack(bw, v); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// writeMsgpack writes a value in its most compact msgpack format
+func writeMsgpack(w *bufio.Writer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		return w.WriteByte(0xc0)
+	case bool:
+		if v {
+			return w.WriteByte(0xc3)
+		}
+		return w.WriteByte(0xc2)
+	case int64:
+		if v >= 0 {
+			return writeMsgpackUint(w, uint64(v))
+		}
+		return writeMsgpackInt(w, v)
+	case uint64:
+		return writeMsgpackUint(w, v)
+	case float64:
+		_ = w.WriteByte(0xcb)
+		return writeBigEndian(w, 8, math.Float64bits(v))
+	case string:
+		writeMsgpackLength(w, len(v), 0xa0, 31, 0xd9, 0xda)
+		_, err := w.WriteString(v)
+		return err
+	case []interface{}:
+		writeMsgpackLength(w, len(v), 0x90, 15, 0, 0xdc)
+		for _, e := range v {
+			if err := writeMsgpack(w, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]interface{}:
+		// sorted, like JSON, so equal values encode equally
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		writeMsgpackLength(w, len(v), 0x80, 15, 0, 0xde)
+		for _, k := range keys {
+			if err := writeMsgpack(w, k); err != nil {
+				return err
+			}
+			if err := writeMsgpack(w, v[k]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("msgpack: unsupported type %T", v)
+}
+
+// writeMsgpackUint writes a non-negative integer
+func writeMsgpackUint(w *bufio.Writer, v uint64) error {
+	switch {
+	case v <= 0x7f:
+		return w.WriteByte(byte(v))
+	case v <= math.MaxUint8:
+		_ = w.WriteByte(0xcc)
+		return writeBigEndian(w, 1, v)
+	case v <= math.MaxUint16:
+		_ = w.WriteByte(0xcd)
+		return writeBigEndian(w, 2, v)
+	case v <= math.MaxUint32:
+		_ = w.WriteByte(0xce)
+		return writeBigEndian(w, 4, v)
+	}
+	_ = w.WriteByte(0xcf)
+	return writeBigEndian(w, 8, v)
+}
+
+// writeMsgpackInt writes a negative integer
+func writeMsgpackInt(w *bufio.Writer, v int64) error {
+	switch {
+	case v >= -32:
+		return w.WriteByte(byte(v))
+	case v >= math.MinInt8:
+		_ = w.WriteByte(0xd0)
+		return writeBigEndian(w, 1, uint64(v))
+	case v >= math.MinInt16:
+		_ = w.WriteByte(0xd1)
+		return writeBigEndian(w, 2, uint64(v))
+	case v >= math.MinInt32:
+		_ = w.WriteByte(0xd2)
+		return writeBigEndian(w, 4, uint64(v))
+	}
+	_ = w.WriteByte(0xd3)
+	return writeBigEndian(w, 8, uint64(v))
+}
+
+// writeMsgpackLength writes the header of a string, array, or map of length n:
+// the fix format up to fixMax, then the 8 bit format if there is one, then the
+// 16 bit format and the 32 bit one following it
+func writeMsgpackLength(w *bufio.Writer, n int, fix byte, fixMax int, len8, len16 byte) {
+	switch {
+	case n <= fixMax:
+		_ = w.WriteByte(fix | byte(n))
+	case len8 != 0 && n <= math.MaxUint8:
+		_ = w.WriteByte(len8)
+		_ = writeBigEndian(w, 1, uint64(n))
+	case n <= math.MaxUint16:
+		_ = w.WriteByte(len16)
+		_ = writeBigEndian(w, 2, uint64(n))
+	default:
+		_ = w.WriteByte(len16 + 1)
+		_ = writeBigEndian(w, 4, uint64(n))
+	}
+}
+
+// writeBigEndian writes the low size bytes of v, most significant first
+func writeBigEndian(w *bufio.Writer, size int, v uint64) error {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	_, err := w.Write(buf[8-size:])
+	return err
+}
+
+// msgpackReader decodes msgpack values from data
+type msgpackReader struct {
+	data []byte
+	pos  int
+}
+
+// decodeMsgpack decodes a single msgpack value into nil, bools, int64s,
+// uint64s, float64s, strings, []interface{}s, and map[string]interface{}s
+func decodeMsgpack(data []byte) (interface{}, error) {
+	r := &msgpackReader{data: data}
+	v, err := r.value()
+	if err != nil {
+		return nil, err
+	}
+	if r.pos != len(r.data) {
+		return nil, errors.New("msgpack: unexpected data after value")
+	}
+	return v, nil
+}
+
+// next returns the next n bytes
+func (r *msgpackReader) next(n int) ([]byte, error) {
+	if n < 0 || len(r.data)-r.pos < n {
+		return nil, errMsgpackShort
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+// uint reads a big endian unsigned integer of size bytes
+func (r *msgpackReader) uint(size int) (uint64, error) {
+	b, err := r.next(size)
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+// int reads a big endian signed integer of size bytes
+func (r *msgpackReader) int(size int) (int64, error) {
+	v, err := r.uint(size)
+	if err != nil {
+		return 0, err
+	}
+	shift := uint(64 - 8*size)
+	return int64(v<<shift) >> shift, nil
+}
+
+// length reads the length of a string, array, or map of size bytes
+func (r *msgpackReader) length(size int) (int, error) {
+	n, err := r.uint(size)
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(len(r.data)) {
+		// every element takes at least a byte
+		return 0, errMsgpackShort
+	}
+	return int(n), nil
+}
+
+func (r *msgpackReader) value() (interface{}, error) {
+	b, err := r.next(1)
+	if err != nil {
+		return nil, err
+	}
+	c := b[0]
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xe0 == 0xa0:
+		return r.str(int(c & 0x1f))
+	case c&0xf0 == 0x90:
+		return r.array(int(c & 0x0f))
+	case c&0xf0 == 0x80:
+		return r.mapping(int(c & 0x0f))
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		v, err := r.uint(1 << (c - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		if v <= math.MaxInt64 {
+			return int64(v), nil
+		}
+		return v, nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		return r.int(1 << (c - 0xd0))
+	case 0xca:
+		v, err := r.uint(4)
+		return float64(math.Float32frombits(uint32(v))), err
+	case 0xcb:
+		v, err := r.uint(8)
+		return math.Float64frombits(v), err
+	case 0xd9, 0xda, 0xdb:
+		n, err := r.length(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return r.str(n)
+	case 0xc4, 0xc5, 0xc6:
+		// binary data
+		n, err := r.length(1 << (c - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return r.str(n)
+	case 0xdc, 0xdd:
+		n, err := r.length(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return r.array(n)
+	case 0xde, 0xdf:
+		n, err := r.length(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return r.mapping(n)
+	}
+	return nil, fmt.Errorf("msgpack: unsupported format 0x%02x", c)
+}
+
+func (r *msgpackReader) str(n int) (interface{}, error) {
+	b, err := r.next(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (r *msgpackReader) array(n int) (interface{}, error) {
+	a := make([]interface{}, n)
+	for i := range a {
+		v, err := r.value()
+		if err != nil {
+			return nil, err
+		}
+		a[i] = v
+	}
+	return a, nil
+}
+
+func (r *msgpackReader) mapping(n int) (interface{}, error) {
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		k, err := r.value()
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("msgpack: unsupported map key type %T", k)
+		}
+		if m[key], err = r.value(); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,215 @@
+package httpmw
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness"
+)
+
+type (
+	// Response is a wrapper for http.ResponseWriter which provides access to
+	// several convenience methods for the responses of the api daemons
+	Response struct {
+		http.ResponseWriter
+	}
+
+	// HTTPError contains information for http error responses
+	HTTPError struct {
+		Message   string   `json:"message"`
+		Code      int      `json:"code"`
+		ErrorCode string   `json:"error"`
+		Fields    []string `json:"fields,omitempty"`
+		RequestID string   `json:"request_id,omitempty"`
+		Stack     []string `json:"stack"`
+	}
+
+	// APIError is an error with a machine readable code, e.g.
+	// "schedule_not_found", for error responses
+	APIError struct {
+		Code    string
+		Message string
+	}
+)
+
+// ErrCodeValidationFailed is the error code of responses for objects that fail
+// validation
+const ErrCodeValidationFailed = "validation_failed"
+
+// errCodeKVTimeout is the error code of responses to requests whose kv
+// operations timed out
+const errCodeKVTimeout = "kv_timeout"
+
+// NewAPIError creates an APIError
+func NewAPIError(code, message string) *APIError {
+	return &APIError{
+		Code:    code,
+		Message: message,
+	}
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// NewResponse wraps w in a Response
+func NewResponse(w http.ResponseWriter) *Response {
+	return &Response{w}
+}
+
+// JSON writes appropriate headers and the body to the http response, encoded
+// as JSON or the media type negotiated by Negotiate
+func (hr *Response) JSON(code int, obj interface{}) {
+	mediaType := ResponseType(hr.Header())
+	hr.Header().Set("Content-Type", mediaType)
+	hr.WriteHeader(code)
+	if err := Encode(hr, mediaType, obj); err != nil {
+		hr.JSONError(http.StatusInternalServerError, err)
+	}
+}
+
+// JSONETag writes obj as a 200 response, encoded as Response.JSON does,
+// tagged with a weak ETag of its content, or only a 304 Not Modified if the
+// ETag is one of the If-None-Match header of r, so clients polling for changes
+// skip unchanged bodies
+func (hr *Response) JSONETag(r *http.Request, obj interface{}) {
+	mediaType := ResponseType(hr.Header())
+	buf := &bytes.Buffer{}
+	if err := Encode(buf, mediaType, obj); err != nil {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	sum := sha1.Sum(buf.Bytes())
+	etag := `W/"` + hex.EncodeToString(sum[:]) + `"`
+
+	hr.Header().Set("ETag", etag)
+	if etagMatch(r.Header.Get("If-None-Match"), etag) {
+		hr.WriteHeader(http.StatusNotModified)
+		return
+	}
+	hr.Header().Set("Content-Type", mediaType)
+	hr.WriteHeader(http.StatusOK)
+	_, _ = hr.Write(buf.Bytes())
+}
+
+// etagMatch returns whether etag is in an If-None-Match header, comparing
+// weakly as the header requires
+func etagMatch(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// JSONError prepares an HTTPError with a stack trace and writes it with
+// Response.JSON. The error code is taken from an *APIError or
+// *lochness.ValidationError and otherwise derived from the status code. KV
+// timeouts, wrapped or not, are reported as 503 Service Unavailable, whatever
+// the code given.
+func (hr *Response) JSONError(code int, err error) {
+	if errors.Is(err, lochness.ErrKVTimeout) {
+		code = http.StatusServiceUnavailable
+	}
+	httpError := &HTTPError{
+		Message:   err.Error(),
+		Code:      code,
+		ErrorCode: StatusErrorCode(code),
+		RequestID: hr.RequestID(),
+		Stack:     stack(2),
+	}
+	switch e := err.(type) {
+	case *APIError:
+		httpError.ErrorCode = e.Code
+	case *lochness.ValidationError:
+		httpError.ErrorCode = ErrCodeValidationFailed
+		httpError.Fields = e.Fields
+	}
+	if errors.Is(err, lochness.ErrKVTimeout) {
+		httpError.ErrorCode = errCodeKVTimeout
+	}
+	hr.logError(code, httpError.ErrorCode, httpError.Message)
+	hr.JSON(code, httpError)
+}
+
+// JSONMsg is a convenience method to write a JSON response with just a message
+// string. Error responses also get an error code derived from the status code
+// and the request id.
+func (hr *Response) JSONMsg(code int, msg string) {
+	if code >= http.StatusBadRequest {
+		hr.JSONErrorMsg(code, StatusErrorCode(code), msg)
+		return
+	}
+	msgObj := map[string]string{
+		"message": msg,
+	}
+	hr.JSON(code, msgObj)
+}
+
+// JSONErrorMsg writes a JSON error response with a message, a machine readable
+// error code, and the request id, without the stack trace of JSONError
+func (hr *Response) JSONErrorMsg(code int, errCode, msg string) {
+	msgObj := map[string]string{
+		"message":    msg,
+		"error":      errCode,
+		"request_id": hr.RequestID(),
+	}
+	hr.logError(code, errCode, msg)
+	hr.JSON(code, msgObj)
+}
+
+// RequestID returns the id of the request being responded to
+func (hr *Response) RequestID() string {
+	return hr.Header().Get(RequestIDHeader)
+}
+
+// logError logs an error response with the request id so that it can be
+// matched with what the client saw
+func (hr *Response) logError(code int, errCode, msg string) {
+	entry := log.WithFields(log.Fields{
+		"request_id": hr.RequestID(),
+		"status":     code,
+		"error":      errCode,
+		"message":    msg,
+	})
+	if code >= http.StatusInternalServerError {
+		entry.Error("request failed")
+		return
+	}
+	entry.Info("request rejected")
+}
+
+// StatusErrorCode derives an error code from an http status code, e.g.
+// "not_found"
+func StatusErrorCode(code int) string {
+	text := http.StatusText(code)
+	if text == "" {
+		return "error"
+	}
+	return strings.ToLower(strings.Replace(text, " ", "_", -1))
+}
+
+// stack returns the stack trace starting skip frames up from stack, 1 being
+// its caller
+func stack(skip int) []string {
+	trace := make([]string, 0, 4)
+	for i := skip; ; i++ {
+		pc, file, line, ok := runtime.Caller(i)
+		if !ok {
+			break
+		}
+		// Print this much at least.  If we can't find the source, it won't show.
+		trace = append(trace, fmt.Sprintf("%s:%d (0x%x)", file, line, pc))
+	}
+	return trace
+}
@@ -0,0 +1,87 @@
+package httpmw_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestResponse(t *testing.T) {
+	suite.Run(t, new(ResponseSuite))
+}
+
+type ResponseSuite struct {
+	suite.Suite
+}
+
+func (s *ResponseSuite) SetupSuite() {
+	log.SetLevel(log.FatalLevel)
+}
+
+// respond records the response written by f, with a request id
+func (s *ResponseSuite) respond(f func(hr *httpmw.Response)) (*httptest.ResponseRecorder, map[string]interface{}) {
+	w := httptest.NewRecorder()
+	w.Header().Set(httpmw.RequestIDHeader, "request-1234")
+	f(httpmw.NewResponse(w))
+
+	body := map[string]interface{}{}
+	s.Require().NoError(json.Unmarshal(w.Body.Bytes(), &body))
+	return w, body
+}
+
+func (s *ResponseSuite) TestJSONError() {
+	tests := []struct {
+		description  string
+		code         int
+		err          error
+		expectedCode int
+		expectedErr  string
+		fields       []interface{}
+	}{
+		{"plain error", http.StatusNotFound, errors.New("missing"), http.StatusNotFound, "not_found", nil},
+		{"api error", http.StatusNotFound, httpmw.NewAPIError("schedule_not_found", "missing"), http.StatusNotFound, "schedule_not_found", nil},
+		{"validation error", http.StatusBadRequest, lerrors.Validation("name", "is required"), http.StatusBadRequest, "validation_failed", []interface{}{"name"}},
+		{"kv timeout", http.StatusInternalServerError, lochness.ErrKVTimeout, http.StatusServiceUnavailable, "kv_timeout", nil},
+		{"wrapped kv timeout", http.StatusInternalServerError, fmt.Errorf("get guest: %w", lochness.ErrKVTimeout), http.StatusServiceUnavailable, "kv_timeout", nil},
+	}
+
+	for _, test := range tests {
+		w, body := s.respond(func(hr *httpmw.Response) {
+			hr.JSONError(test.code, test.err)
+		})
+		s.Equal(test.expectedCode, w.Code, test.description)
+		s.Equal(test.expectedErr, body["error"], test.description)
+		s.Equal(test.err.Error(), body["message"], test.description)
+		if test.fields != nil {
+			s.Equal(test.fields, body["fields"], test.description)
+		} else {
+			s.NotContains(body, "fields", test.description)
+		}
+		s.Equal("request-1234", body["request_id"], test.description)
+		s.NotEmpty(body["stack"], test.description)
+	}
+}
+
+func (s *ResponseSuite) TestJSONMsg() {
+	w, body := s.respond(func(hr *httpmw.Response) {
+		hr.JSONMsg(http.StatusOK, "done")
+	})
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal(map[string]interface{}{"message": "done"}, body)
+
+	w, body = s.respond(func(hr *httpmw.Response) {
+		hr.JSONMsg(http.StatusConflict, "busy")
+	})
+	s.Equal(http.StatusConflict, w.Code)
+	s.Equal("conflict", body["error"])
+	s.Equal("request-1234", body["request_id"])
+}
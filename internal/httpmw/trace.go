@@ -0,0 +1,70 @@
+package httpmw
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	sdkresource "go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SetupTracing sets the global OpenTelemetry tracer provider to one exporting
+// spans over OTLP/HTTP to endpoint (host:port), and the global propagator to
+// W3C trace context. The returned function flushes and stops the exporter.
+func SetupTracing(name, endpoint string) (func() error, error) {
+	exporter, err := otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpoint(endpoint),
+		otlptracehttp.WithInsecure(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(sdkresource.NewSchemaless(
+			attribute.String("service.name", name),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return func() error {
+		return provider.Shutdown(context.Background())
+	}, nil
+}
+
+// startSpan starts a server span for a request, continuing any trace
+// propagated by the client
+func startSpan(tracer trace.Tracer, r *http.Request) (*http.Request, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer.Start(ctx, r.Method+" "+r.URL.Path,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.method", r.Method),
+			attribute.String("http.target", r.URL.Path),
+			attribute.String("request_id", r.Header.Get(RequestIDHeader)),
+		),
+	)
+	return r.WithContext(ctx), span
+}
+
+// endSpan records the result of a request on its span and ends it
+func endSpan(span trace.Span, rw *responseWriter, slow bool) {
+	status := rw.Status()
+	span.SetAttributes(
+		attribute.Int("http.status_code", status),
+		attribute.Int("http.response_content_length", rw.size),
+		attribute.Bool("slow", slow),
+	)
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	span.End()
+}
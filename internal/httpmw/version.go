@@ -0,0 +1,65 @@
+package httpmw
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	// APIVersion is the version of the apis, whose routes are served under
+	// APIPrefix
+	APIVersion = "v1"
+	// APIPrefix prefixes the versioned routes of the apis, e.g.
+	// /v1/guests/{guestID}
+	APIPrefix = "/" + APIVersion
+	// APIVersionHeader lists the versions a versioned api serves, comma
+	// separated, on every response, including those of routes it does not
+	// have, so that clients can tell it from an api that is not versioned
+	APIVersionHeader = "X-API-Version"
+)
+
+// Versioned serves the routes of an api, as registered at their unversioned
+// paths, under APIPrefix as well. The unversioned paths are deprecated aliases
+// of the versioned ones, and their responses say so with a Deprecation header,
+// a Warning, and a Link to the versioned path, so that existing tooling keeps
+// working while it moves over. It should come after Logger so that requests
+// are logged with the path they were made with.
+func Versioned(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(APIVersionHeader, APIVersion)
+
+		path, ok := trimAPIPrefix(r.URL.Path)
+		if !ok {
+			successor := APIPrefix + r.URL.Path
+			w.Header().Set("Deprecation", "true")
+			w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", successor))
+			w.Header().Add("Warning", fmt.Sprintf("299 - \"unversioned api paths are deprecated, use %s\"", successor))
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		// route the request by its unversioned path, leaving the request as
+		// made to the middleware before
+		versioned := new(http.Request)
+		*versioned = *r
+		u := *r.URL
+		u.Path = path
+		if u.RawPath != "" {
+			u.RawPath, _ = trimAPIPrefix(u.RawPath)
+		}
+		versioned.URL = &u
+		h.ServeHTTP(w, versioned)
+	})
+}
+
+// trimAPIPrefix returns a path without APIPrefix, and whether it had it
+func trimAPIPrefix(path string) (string, bool) {
+	if path == APIPrefix {
+		return "/", true
+	}
+	if strings.HasPrefix(path, APIPrefix+"/") {
+		return strings.TrimPrefix(path, APIPrefix), true
+	}
+	return path, false
+}
@@ -0,0 +1,703 @@
+package hypervisorapi
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/changefeed"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	"github.com/mistifyio/lochness/internal/server"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/mistifyio/lochness/pkg/bmc"
+	"github.com/mistifyio/lochness/pkg/jsonschema"
+	"github.com/mistifyio/lochness/pkg/swagger"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestCHypervisordAPI(t *testing.T) {
+	suite.Run(t, new(APISuite))
+}
+
+type APISuite struct {
+	common.Suite
+	Port       uint
+	APIServer  *server.Server
+	Feed       *changefeed.Feed
+	Hypervisor *lochness.Hypervisor
+	APIURL     string
+}
+
+func (s *APISuite) SetupSuite() {
+	s.Suite.SetupSuite()
+
+	log.SetLevel(log.FatalLevel)
+	s.Port = 51123
+	s.APIURL = fmt.Sprintf("http://localhost:%d/hypervisors", s.Port)
+
+	var err error
+	s.Feed, err = changefeed.New(s.KV)
+	s.Require().NoError(err)
+	s.Require().NoError(s.Feed.Start())
+
+	ctx, err := s.Context.WithSecretKey(common.SecretKey)
+	s.Require().NoError(err)
+	s.APIServer = Run(s.Port, ctx, s.Feed, httpmw.Config{}, nil)
+	time.Sleep(100 * time.Millisecond)
+}
+
+func (s *APISuite) SetupTest() {
+	s.Suite.SetupTest()
+	s.Hypervisor = s.NewHypervisor()
+	_ = s.Hypervisor.SetConfig("foo", "bar")
+}
+
+func (s *APISuite) TearDownSuite() {
+	stopChan := s.APIServer.StopChan()
+	s.APIServer.Stop(5 * time.Second)
+	<-stopChan
+
+	s.Suite.TearDownSuite()
+}
+
+func (s *APISuite) TestHypervisorsList() {
+	var hypervisors lochness.Hypervisors
+	s.DoRequest("GET", s.APIURL, http.StatusOK, nil, &hypervisors)
+
+	s.Len(hypervisors, 1)
+	s.Equal(s.Hypervisor.ID, hypervisors[0].ID)
+}
+
+func (s *APISuite) TestHypervisorsListMetadata() {
+	s.Hypervisor.Metadata = map[string]string{"rack": "a1", "zone": "east"}
+	s.Require().NoError(s.Hypervisor.Save())
+	_ = s.NewHypervisor()
+
+	var hypervisors lochness.Hypervisors
+	s.DoRequest("GET", s.APIURL+"?metadata=rack=a1&metadata=zone=east", http.StatusOK, nil, &hypervisors)
+	s.Require().Len(hypervisors, 1)
+	s.Equal(s.Hypervisor.ID, hypervisors[0].ID)
+
+	s.DoRequest("GET", s.APIURL+"?metadata=rack=a1&metadata=zone=west", http.StatusOK, nil, &hypervisors)
+	s.Empty(hypervisors)
+
+	var errResp map[string]interface{}
+	s.DoRequest("GET", s.APIURL+"?metadata=rack", http.StatusBadRequest, nil, &errResp)
+	s.Equal("validation_failed", errResp["error"])
+}
+
+func (s *APISuite) TestHypervisorAdd() {
+	hypervisor := s.Context.NewHypervisor()
+	hypervisor.IP = net.ParseIP("192.168.100.12")
+	hypervisor.Netmask = net.ParseIP("225.225.225.225")
+	hypervisor.Gateway = net.ParseIP("192.168.100.1")
+	hypervisor.MAC, _ = net.ParseMAC("96:E0:51:F9:31:C2")
+	hypervisor.TotalResources = lochness.Resources{
+		Memory: 16 * 1024,
+		Disk:   1024 * 1024,
+		CPU:    32,
+	}
+	hypervisor.AvailableResources = hypervisor.TotalResources
+
+	var hypervisorResp lochness.Hypervisor
+	s.DoRequest("POST", s.APIURL, http.StatusCreated, hypervisor, &hypervisorResp)
+
+	s.Equal(hypervisor.ID, hypervisorResp.ID)
+
+	// Make sure it actually saved
+	h, err := s.Context.Hypervisor(hypervisor.ID)
+	s.NoError(err)
+	s.Equal(hypervisor.ID, h.ID)
+}
+
+func (s *APISuite) TestHypervisorGet() {
+	var hypervisor lochness.Hypervisor
+	s.DoRequest("GET", fmt.Sprintf("%s/%s", s.APIURL, s.Hypervisor.ID), http.StatusOK, nil, &hypervisor)
+
+	s.Equal(s.Hypervisor.ID, hypervisor.ID)
+}
+
+func (s *APISuite) TestHypervisorGetVersioned() {
+	var hypervisor lochness.Hypervisor
+	url := fmt.Sprintf("http://localhost:%d/v1/hypervisors/%s", s.Port, s.Hypervisor.ID)
+	resp := s.DoRequest("GET", url, http.StatusOK, nil, &hypervisor)
+	s.Equal(s.Hypervisor.ID, hypervisor.ID)
+	s.Equal(httpmw.APIVersion, resp.Header.Get(httpmw.APIVersionHeader))
+	s.Empty(resp.Header.Get("Deprecation"))
+
+	resp = s.DoRequest("GET", fmt.Sprintf("%s/%s", s.APIURL, s.Hypervisor.ID), http.StatusOK, nil, &hypervisor)
+	s.Equal("true", resp.Header.Get("Deprecation"))
+	s.Contains(resp.Header.Get("Link"), "/v1/hypervisors/"+s.Hypervisor.ID)
+}
+
+func (s *APISuite) TestHypervisorUpdate() {
+	s.Hypervisor.IP = net.ParseIP("192.168.100.13")
+
+	var hypervisorResp lochness.Hypervisor
+	s.DoRequest("PATCH", fmt.Sprintf("%s/%s", s.APIURL, s.Hypervisor.ID), http.StatusOK, s.Hypervisor, &hypervisorResp)
+
+	s.Equal(s.Hypervisor.ID, hypervisorResp.ID)
+
+	// Make sure it actually saved
+	h, err := s.Context.Hypervisor(s.Hypervisor.ID)
+	s.NoError(err)
+	s.Equal(s.Hypervisor.IP, h.IP)
+}
+
+func (s *APISuite) TestHypervisorDestroy() {
+	var hypervisorResp lochness.Hypervisor
+	s.DoRequest("DELETE", fmt.Sprintf("%s/%s", s.APIURL, s.Hypervisor.ID), http.StatusOK, nil, &hypervisorResp)
+
+	s.Equal(s.Hypervisor.ID, hypervisorResp.ID)
+
+	// Make sure it actually saved
+	_, err := s.Context.Hypervisor(s.Hypervisor.ID)
+	s.Error(err)
+}
+
+func (s *APISuite) TestHypervisorSoftDelete() {
+	s.Require().NoError(s.Context.SetConfig(lochness.SoftDeleteConfig, "24h"))
+	hypervisorURL := fmt.Sprintf("%s/%s", s.APIURL, s.Hypervisor.ID)
+
+	var hypervisorResp lochness.Hypervisor
+	s.DoRequest("DELETE", hypervisorURL, http.StatusOK, nil, &hypervisorResp)
+	s.True(hypervisorResp.IsDeleted())
+
+	var hypervisors lochness.Hypervisors
+	s.DoRequest("GET", s.APIURL, http.StatusOK, nil, &hypervisors)
+	s.Len(hypervisors, 0)
+	s.DoRequest("GET", s.APIURL+"?deleted=true", http.StatusOK, nil, &hypervisors)
+	s.Len(hypervisors, 1)
+
+	hypervisorResp = lochness.Hypervisor{}
+	s.DoRequest("POST", hypervisorURL+"/restore", http.StatusOK, nil, &hypervisorResp)
+	s.False(hypervisorResp.IsDeleted())
+	var errResp httpmw.HTTPError
+	s.DoRequest("POST", hypervisorURL+"/restore", http.StatusConflict, nil, &errResp)
+	s.Equal("hypervisor_not_deleted", errResp.ErrorCode)
+
+	// deleting a soft deleted hypervisor purges it
+	s.DoRequest("DELETE", hypervisorURL, http.StatusOK, nil, &hypervisorResp)
+	s.DoRequest("DELETE", hypervisorURL, http.StatusOK, nil, &hypervisorResp)
+	_, err := s.Context.Hypervisor(s.Hypervisor.ID)
+	s.True(s.Context.IsKeyNotFound(err))
+}
+
+func (s *APISuite) TestHypervisorGetConfig() {
+	var config hypervisorConfig
+	s.DoRequest("GET", fmt.Sprintf("%s/%s/config", s.APIURL, s.Hypervisor.ID), http.StatusOK, nil, &config)
+
+	s.Equal(s.Hypervisor.Config, config.Config)
+	s.NotZero(config.ModifiedIndex)
+}
+
+func (s *APISuite) TestHypervisorUpdateConfig() {
+	index, err := s.Hypervisor.ConfigIndex()
+	s.Require().NoError(err)
+
+	configChanges := map[string]string{"asdf": "qwer", "foo": ""}
+	var config hypervisorConfig
+	s.DoRequest("PATCH", fmt.Sprintf("%s/%s/config", s.APIURL, s.Hypervisor.ID), http.StatusOK, configChanges, &config)
+
+	s.Equal(map[string]string{"asdf": "qwer"}, config.Config, "the full resulting config should be returned")
+	s.True(config.ModifiedIndex > index, "modified index should increase")
+
+	// Make sure it actually saved
+	hypervisor, err := s.Context.Hypervisor(s.Hypervisor.ID)
+	s.NoError(err)
+	s.Equal(configChanges["asdf"], hypervisor.Config["asdf"])
+	index, err = hypervisor.ConfigIndex()
+	s.NoError(err)
+	s.Equal(index, config.ModifiedIndex)
+}
+
+func (s *APISuite) TestHypervisorUpdateConfigInvalid() {
+	tests := []struct {
+		description string
+		changes     map[string]string
+		field       string
+	}{
+		{"invalid value", map[string]string{"asdf": "qwer", lochness.MemoryOvercommitConfig: "lots"}, lochness.MemoryOvercommitConfig},
+		{"nested key", map[string]string{"asdf": "qwer", "foo/bar": "baz"}, "foo/bar"},
+		{"empty key", map[string]string{"asdf": "qwer", "": "baz"}, ""},
+	}
+
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		var httpErr httpmw.HTTPError
+		s.DoRequest("PATCH", fmt.Sprintf("%s/%s/config", s.APIURL, s.Hypervisor.ID), http.StatusBadRequest, test.changes, &httpErr)
+		s.Equal("validation_failed", httpErr.ErrorCode, msg("should fail validation"))
+		s.Equal([]string{test.field}, httpErr.Fields, msg("should name the invalid key"))
+
+		hypervisor, err := s.Context.Hypervisor(s.Hypervisor.ID)
+		s.NoError(err)
+		s.NotContains(hypervisor.Config, "asdf", msg("valid keys should not be set either"))
+	}
+
+	var httpErr httpmw.HTTPError
+	s.DoRequest("PATCH", fmt.Sprintf("%s/%s/config", s.APIURL, s.Hypervisor.ID), http.StatusBadRequest, map[string]int{"asdf": 1}, &httpErr)
+	s.Equal("invalid_json", httpErr.ErrorCode, "values should be strings")
+}
+
+func (s *APISuite) TestHypervisorExpectedConfig() {
+	url := fmt.Sprintf("%s/%s/expected", s.APIURL, s.Hypervisor.ID)
+	var expected map[string]string
+	s.DoRequest("GET", url, http.StatusOK, nil, &expected)
+	s.Empty(expected)
+
+	s.DoRequest("PATCH", url, http.StatusOK, map[string]string{"kernel": "4.4.0", "os-version": "0.4.2"}, &expected)
+	s.Equal(map[string]string{"kernel": "4.4.0", "os-version": "0.4.2"}, expected)
+
+	expected = nil
+	s.DoRequest("PATCH", url, http.StatusOK, map[string]string{"kernel": ""}, &expected)
+	s.Equal(map[string]string{"os-version": "0.4.2"}, expected)
+
+	var httpErr httpmw.HTTPError
+	s.DoRequest("PATCH", url, http.StatusBadRequest, map[string]string{"": "foo"}, &httpErr)
+	s.Equal("validation_failed", httpErr.ErrorCode)
+
+	expected = nil
+	s.DoRequest("GET", url, http.StatusOK, nil, &expected)
+	s.Equal(map[string]string{"os-version": "0.4.2"}, expected)
+}
+
+func (s *APISuite) TestHypervisorDrift() {
+	url := fmt.Sprintf("%s/%s/drift", s.APIURL, s.Hypervisor.ID)
+	var drift lochness.HypervisorDrift
+	s.DoRequest("GET", url, http.StatusOK, nil, &drift)
+	s.Equal(s.Hypervisor.ID, drift.HypervisorID)
+	s.True(drift.CheckedAt.IsZero())
+	s.False(drift.Drifted())
+
+	_, err := s.Hypervisor.SetExpectedConfig(map[string]string{"os-version": "0.4.2"})
+	s.Require().NoError(err)
+	_, err = s.Hypervisor.CheckDrift(map[string]string{"os-version": "0.4.1"})
+	s.Require().NoError(err)
+	s.DoRequest("GET", url, http.StatusOK, nil, &drift)
+	s.False(drift.CheckedAt.IsZero())
+	s.Equal([]lochness.ConfigDifference{{Key: "os-version", Expected: "0.4.2", Actual: "0.4.1"}}, drift.Differences)
+}
+
+func (s *APISuite) TestUpgrades() {
+	url := strings.TrimSuffix(s.APIURL, "hypervisors") + "upgrades"
+
+	var httpErr httpmw.HTTPError
+	s.DoRequest("POST", url, http.StatusBadRequest, map[string]interface{}{"batch_size": 2}, &httpErr)
+	s.Equal("validation_failed", httpErr.ErrorCode)
+
+	var upgrade lochness.Upgrade
+	s.DoRequest("POST", url, http.StatusCreated, map[string]interface{}{"version": "0.5.0"}, &upgrade)
+	s.Equal("0.5.0", upgrade.Version)
+	s.Equal(1, upgrade.BatchSize)
+	s.Equal(lochness.UpgradeRunning, upgrade.State)
+	s.Require().Len(upgrade.Hypervisors, 1)
+	s.Equal(s.Hypervisor.ID, upgrade.Hypervisors[0].ID)
+	s.Equal(lochness.UpgradeStepPending, upgrade.Hypervisors[0].Step)
+
+	s.DoRequest("POST", url, http.StatusConflict, map[string]interface{}{"version": "0.5.1"}, &httpErr)
+	s.Equal("upgrade_in_progress", httpErr.ErrorCode)
+
+	var upgrades lochness.Upgrades
+	s.DoRequest("GET", url, http.StatusOK, nil, &upgrades)
+	s.Require().Len(upgrades, 1)
+	s.Equal(upgrade.ID, upgrades[0].ID)
+
+	upgradeURL := url + "/" + upgrade.ID
+	s.DoRequest("DELETE", upgradeURL, http.StatusConflict, nil, &httpErr)
+	s.Equal("upgrade_in_progress", httpErr.ErrorCode)
+
+	s.DoRequest("POST", upgradeURL+"/pause", http.StatusAccepted, nil, &upgrade)
+	s.Equal(lochness.UpgradePaused, upgrade.State)
+	s.DoRequest("POST", upgradeURL+"/pause", http.StatusConflict, nil, &httpErr)
+	s.Equal("invalid_upgrade_state", httpErr.ErrorCode)
+	s.DoRequest("POST", upgradeURL+"/resume", http.StatusAccepted, nil, &upgrade)
+	s.Equal(lochness.UpgradeRunning, upgrade.State)
+	s.DoRequest("POST", upgradeURL+"/abort", http.StatusAccepted, nil, &upgrade)
+	s.Equal(lochness.UpgradeAborted, upgrade.State)
+
+	s.DoRequest("GET", upgradeURL, http.StatusOK, nil, &upgrade)
+	s.Equal(lochness.UpgradeAborted, upgrade.State)
+	s.DoRequest("DELETE", upgradeURL, http.StatusOK, nil, &upgrade)
+	s.DoRequest("GET", upgradeURL, http.StatusNotFound, nil, &httpErr)
+}
+
+func (s *APISuite) TestHypervisorSubnetList() {
+	hypervisor, _ := s.NewHypervisorWithGuest()
+	var subnets hypervisorSubnets
+	s.DoRequest("GET", fmt.Sprintf("%s/%s/subnets", s.APIURL, hypervisor.ID), http.StatusOK, nil, &subnets)
+
+	s.Len(subnets.Subnets, 1)
+	s.Equal(hypervisor.Subnets(), subnets.Subnets)
+	s.NotZero(subnets.ModifiedIndex)
+}
+
+func (s *APISuite) TestHypervisorSubnetUpdate() {
+	subnet := s.NewSubnet()
+	_ = s.Hypervisor.AddSubnet(subnet, "foobar")
+	other := s.NewSubnet()
+
+	var subnets hypervisorSubnets
+	s.DoRequest("PATCH", fmt.Sprintf("%s/%s/subnets", s.APIURL, s.Hypervisor.ID), http.StatusOK, map[string]string{other.ID: "br0"}, &subnets)
+	s.Equal(map[string]string{subnet.ID: "foobar", other.ID: "br0"}, subnets.Subnets, "the full resulting subnets should be returned")
+
+	hypervisor, err := s.Context.Hypervisor(s.Hypervisor.ID)
+	s.Require().NoError(err)
+	s.Equal(hypervisor.Subnets(), subnets.Subnets)
+	index, err := hypervisor.SubnetsIndex()
+	s.NoError(err)
+	s.Equal(index, subnets.ModifiedIndex)
+
+	tests := []struct {
+		description string
+		changes     map[string]string
+		code        int
+		errCode     string
+	}{
+		{"invalid bridge", map[string]string{s.NewSubnet().ID: "br0", other.ID: "br 1"}, http.StatusBadRequest, "validation_failed"},
+		{"long bridge", map[string]string{other.ID: "abcdefghijklmnop"}, http.StatusBadRequest, "validation_failed"},
+		{"invalid subnet id", map[string]string{"asdf": "br0"}, http.StatusBadRequest, "validation_failed"},
+		{"missing subnet", map[string]string{other.ID: "br1", uuid.New(): "br0"}, http.StatusNotFound, "subnet_not_found"},
+		{"unknown bridge", map[string]string{other.ID: "br1", s.NewSubnet().ID: "br2"}, http.StatusBadRequest, "validation_failed"},
+	}
+	s.Require().NoError(s.Hypervisor.SetConfig(lochness.BridgesConfig, "br0,br1"))
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		var httpErr httpmw.HTTPError
+		s.DoRequest("PATCH", fmt.Sprintf("%s/%s/subnets", s.APIURL, s.Hypervisor.ID), test.code, test.changes, &httpErr)
+		s.Equal(test.errCode, httpErr.ErrorCode, msg("should fail"))
+
+		hypervisor, err := s.Context.Hypervisor(s.Hypervisor.ID)
+		s.Require().NoError(err)
+		s.Equal(subnets.Subnets, hypervisor.Subnets(), msg("no subnet should be changed"))
+	}
+}
+
+func (s *APISuite) TestHypervisorSubnetRemove() {
+	hypervisor, guest := s.NewHypervisorWithGuest()
+	var subnets hypervisorSubnets
+	s.DoRequest("DELETE", fmt.Sprintf("%s/%s/subnets/%s", s.APIURL, hypervisor.ID, guest.SubnetID), http.StatusOK, nil, &subnets)
+
+	s.Len(subnets.Subnets, 0)
+
+	// Make sure it actually saved
+	h, _ := s.Context.Hypervisor(hypervisor.ID)
+	s.Len(h.Subnets(), 0)
+}
+
+func (s *APISuite) TestHypervisorGuestList() {
+	hypervisor, guest := s.NewHypervisorWithGuest()
+	var guests []string
+	s.DoRequest("GET", fmt.Sprintf("%s/%s/guests", s.APIURL, hypervisor.ID), http.StatusOK, nil, &guests)
+
+	s.Len(guests, 1)
+	s.Equal(guest.ID, guests[0])
+}
+
+func (s *APISuite) TestHypervisorHealth() {
+	var health lochness.HypervisorHealth
+	s.DoRequest("GET", fmt.Sprintf("%s/%s/health", s.APIURL, s.Hypervisor.ID), http.StatusOK, nil, &health)
+	s.False(health.Alive)
+	s.Equal(0.0, health.Score)
+
+	_, _ = lochness.SetHypervisorID(s.Hypervisor.ID)
+	s.Require().NoError(s.Hypervisor.Heartbeat(60 * time.Second))
+	s.DoRequest("GET", fmt.Sprintf("%s/%s/health", s.APIURL, s.Hypervisor.ID), http.StatusOK, nil, &health)
+	s.True(health.Alive)
+	s.Equal(1, health.Beats)
+	s.Equal(1.0, health.Score)
+}
+
+func (s *APISuite) TestHypervisorTelemetry() {
+	url := fmt.Sprintf("%s/%s/telemetry", s.APIURL, s.Hypervisor.ID)
+	var telemetry lochness.HypervisorTelemetry
+	s.DoRequest("GET", url, http.StatusOK, nil, &telemetry)
+	s.Len(telemetry.Samples, 0)
+	s.Nil(telemetry.Latest)
+
+	samples := []lochness.TelemetrySample{
+		{Load1: 1, FreeMemory: 512, FreeDisk: 2048},
+		{Load1: 3, FreeMemory: 1024, FreeDisk: 1024},
+	}
+	for _, sample := range samples {
+		s.DoRequest("POST", url, http.StatusOK, sample, &telemetry)
+	}
+	s.Len(telemetry.Samples, 2)
+	if s.NotNil(telemetry.Latest) {
+		s.Equal(3.0, telemetry.Latest.Load1)
+	}
+	s.Equal(2.0, telemetry.AverageLoad)
+	s.Equal(uint64(512), telemetry.MinFreeMemory)
+	s.Equal(uint64(1024), telemetry.MinFreeDisk)
+
+	s.DoRequest("GET", url, http.StatusOK, nil, &telemetry)
+	s.Len(telemetry.Samples, 2)
+
+	var httpErr httpmw.HTTPError
+	s.DoRequest("POST", url, http.StatusBadRequest, lochness.TelemetrySample{Load1: -1}, &httpErr)
+	s.Equal("validation_failed", httpErr.ErrorCode)
+	s.DoRequest("POST", url, http.StatusBadRequest, map[string]string{"load1": "high"}, &httpErr)
+	s.Equal("invalid_json", httpErr.ErrorCode)
+}
+
+// fakeBMC records the power actions performed through it
+type fakeBMC struct {
+	actions *[]string
+}
+
+func (f fakeBMC) Power(action string) error {
+	if action == bmc.PowerOff {
+		return errors.New("bmc unreachable")
+	}
+	*f.actions = append(*f.actions, action)
+	return nil
+}
+
+func (s *APISuite) TestHypervisorPower() {
+	var actions []string
+	lochness.NewBMCController = func(protocol, address string, creds bmc.Credentials) (bmc.Controller, error) {
+		return fakeBMC{&actions}, nil
+	}
+	defer func() { lochness.NewBMCController = bmc.New }()
+	url := fmt.Sprintf("%s/%s/power", s.APIURL, s.Hypervisor.ID)
+
+	var errResp map[string]interface{}
+	s.DoRequest("POST", url, http.StatusConflict, powerRequest{Action: bmc.PowerCycle}, &errResp)
+	s.Equal("no_bmc", errResp["error"])
+
+	s.Hypervisor.BMC = &lochness.BMC{Protocol: bmc.ProtocolIPMI, Address: "10.0.0.5"}
+	s.Require().NoError(s.Hypervisor.Save())
+	s.DoRequest("POST", url, http.StatusConflict, powerRequest{Action: bmc.PowerCycle}, &errResp)
+	s.Equal("no_bmc_credentials", errResp["error"])
+
+	secret := s.NewSecret()
+	s.Require().NoError(secret.Rotate([]byte("admin:secret")))
+	s.Hypervisor.Secrets = map[string]string{lochness.SecretIPMI: secret.ID}
+	s.Require().NoError(s.Hypervisor.Save())
+
+	var hypervisor lochness.Hypervisor
+	s.DoRequest("POST", url, http.StatusAccepted, powerRequest{Action: bmc.PowerCycle}, &hypervisor)
+	s.Equal(s.Hypervisor.ID, hypervisor.ID)
+	s.Equal([]string{bmc.PowerCycle}, actions)
+
+	s.DoRequest("POST", url, http.StatusBadRequest, powerRequest{Action: "reboot"}, &errResp)
+	s.Equal("validation_failed", errResp["error"])
+	s.DoRequest("POST", url, http.StatusBadGateway, powerRequest{Action: bmc.PowerOff}, &errResp)
+	s.Equal("power_failed", errResp["error"])
+}
+
+func (s *APISuite) TestHypervisorRegister() {
+	var token bootstrapToken
+	s.DoRequest("POST", s.APIURL+"/tokens", http.StatusCreated, tokenRequest{TTL: "1h"}, &token)
+	s.NotEmpty(token.Token)
+	s.WithinDuration(time.Now().Add(time.Hour), token.Expires, time.Minute)
+
+	var errResp map[string]interface{}
+	s.DoRequest("POST", s.APIURL+"/tokens", http.StatusBadRequest, tokenRequest{TTL: "-1h"}, &errResp)
+	s.Equal("invalid_ttl", errResp["error"])
+
+	node := map[string]interface{}{
+		"token":           token.Token,
+		"mac":             "01:23:45:67:89:ab",
+		"ip":              "10.100.101.66",
+		"netmask":         "255.255.255.0",
+		"gateway":         "10.100.101.1",
+		"total_resources": lochness.Resources{Memory: 1024, Disk: 2048, CPU: 2},
+	}
+	var reg registration
+	s.DoRequest("POST", s.APIURL+"/register", http.StatusCreated, node, &reg)
+	s.True(reg.Created)
+	s.Equal("01:23:45:67:89:ab", reg.Hypervisor.MAC.String())
+	s.Equal(uint32(2), reg.Hypervisor.AvailableResources.CPU)
+	s.Require().NoError(s.Context.SetConfig("cluster", "yes"))
+
+	// the same node registering again updates its hypervisor
+	node["ip"] = "10.100.101.67"
+	var again registration
+	s.DoRequest("POST", s.APIURL+"/register", http.StatusOK, node, &again)
+	s.False(again.Created)
+	s.Equal(reg.Hypervisor.ID, again.Hypervisor.ID)
+	s.Equal("10.100.101.67", again.Hypervisor.IP.String())
+	s.Equal("yes", again.Config["cluster"])
+
+	node["token"] = token.Token + "x"
+	s.DoRequest("POST", s.APIURL+"/register", http.StatusUnauthorized, node, &errResp)
+	s.Equal("invalid_token", errResp["error"])
+
+	// tokens for a mac only register that node
+	s.DoRequest("POST", s.APIURL+"/tokens", http.StatusCreated, tokenRequest{MAC: "01:23:45:67:89:AC"}, &token)
+	s.Equal("01:23:45:67:89:ac", token.MAC)
+	node["token"] = token.Token
+	s.DoRequest("POST", s.APIURL+"/register", http.StatusUnauthorized, node, &errResp)
+	node["mac"] = "01:23:45:67:89:ac"
+	delete(node, "total_resources")
+	s.DoRequest("POST", s.APIURL+"/register", http.StatusBadRequest, node, &errResp)
+	s.Equal("validation_failed", errResp["error"])
+
+	// a node can not take over the hypervisor of another mac by its id
+	node["total_resources"] = lochness.Resources{Memory: 1024, Disk: 2048, CPU: 2}
+	node["id"] = reg.Hypervisor.ID
+	s.DoRequest("POST", s.APIURL+"/register", http.StatusConflict, node, &errResp)
+	s.Equal("mac_mismatch", errResp["error"])
+}
+
+func (s *APISuite) TestSwagger() {
+	var spec swagger.Spec
+	s.DoRequest("GET", fmt.Sprintf("http://localhost:%d/swagger.json", s.Port), http.StatusOK, nil, &spec)
+
+	s.Equal("2.0", spec.Swagger)
+	s.NoError(spec.Validate())
+	s.Contains(spec.Paths["/hypervisors/{hypervisorID}"], "patch")
+	s.Contains(spec.Paths["/hypervisors/{hypervisorID}/desiredstate/ack"], "post")
+	s.Contains(spec.Paths["/hypervisors/{hypervisorID}/drift"], "get")
+	s.Contains(spec.Paths["/hypervisors/register"], "post")
+	s.Contains(spec.Paths["/schemas/{entity}"], "get")
+	s.Contains(spec.Paths["/upgrades/{upgradeID}/abort"], "post")
+	s.Contains(spec.Definitions, "Hypervisor")
+}
+
+func (s *APISuite) TestSchemas() {
+	url := fmt.Sprintf("http://localhost:%d/schemas", s.Port)
+	var entities []string
+	s.DoRequest("GET", url, http.StatusOK, nil, &entities)
+	s.Equal(lochness.SchemaEntities(), entities)
+
+	var schema jsonschema.Schema
+	s.DoRequest("GET", url+"/hypervisor", http.StatusOK, nil, &schema)
+	s.Equal(lochness.EntitySchema("hypervisor"), &schema)
+
+	var errResp map[string]interface{}
+	s.DoRequest("GET", url+"/foobar", http.StatusNotFound, nil, &errResp)
+	s.Equal("schema_not_found", errResp["error"])
+}
+
+func (s *APISuite) TestHypervisorValidationError() {
+	hypervisor := s.Context.NewHypervisor()
+	hypervisor.ID = "foobar"
+
+	var httpErr httpmw.HTTPError
+	resp := s.DoRequest("POST", s.APIURL, http.StatusBadRequest, hypervisor, &httpErr)
+	s.Equal("validation_failed", httpErr.ErrorCode)
+	s.Equal([]string{"id"}, httpErr.Fields)
+	s.NotEmpty(httpErr.RequestID)
+	s.Equal(resp.Header.Get(httpmw.RequestIDHeader), httpErr.RequestID)
+}
+
+func (s *APISuite) TestHypervisorInvalidID() {
+	var errResp map[string]string
+	resp := s.DoRequest("GET", fmt.Sprintf("%s/%s", s.APIURL, "foobar"), http.StatusBadRequest, nil, &errResp)
+	s.Equal("invalid_hypervisor_id", errResp["error"])
+	s.Equal(resp.Header.Get(httpmw.RequestIDHeader), errResp["request_id"])
+}
+
+// doEncoded makes a request with a body and response of the media type given,
+// decoding the response into respBody
+func (s *APISuite) doEncoded(method, url, mediaType string, expectedRespCode int, body interface{}, respBody interface{}) *http.Response {
+	buf := &bytes.Buffer{}
+	if body != nil {
+		s.Require().NoError(httpmw.Encode(buf, mediaType, body))
+	}
+	req, err := http.NewRequest(method, url, buf)
+	s.Require().NoError(err)
+	req.Header.Set("Accept", mediaType)
+	if body != nil {
+		req.Header.Set("Content-Type", mediaType)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	s.Require().NoError(err)
+	defer func() { _ = resp.Body.Close() }()
+	s.Equal(expectedRespCode, resp.StatusCode)
+	s.Equal(mediaType, resp.Header.Get("Content-Type"))
+
+	// decode the response as a request body of its content type
+	decodeReq, _ := http.NewRequest("POST", url, resp.Body)
+	decodeReq.Header.Set("Content-Type", resp.Header.Get("Content-Type"))
+	s.NoError(httpmw.Decode(decodeReq, respBody))
+	return resp
+}
+
+func (s *APISuite) TestContentNegotiation() {
+	for _, mediaType := range []string{httpmw.MediaTypeYAML, httpmw.MediaTypeMsgpack} {
+		var hypervisor lochness.Hypervisor
+		s.doEncoded("GET", fmt.Sprintf("%s/%s", s.APIURL, s.Hypervisor.ID), mediaType, http.StatusOK, nil, &hypervisor)
+		s.Equal(s.Hypervisor.ID, hypervisor.ID, mediaType)
+		s.Equal(s.Hypervisor.TotalResources, hypervisor.TotalResources, mediaType)
+
+		added := s.Context.NewHypervisor()
+		added.IP = net.ParseIP("192.168.100.12")
+		added.Netmask = net.ParseIP("255.255.255.0")
+		added.Gateway = net.ParseIP("192.168.100.1")
+		added.MAC, _ = net.ParseMAC("96:E0:51:F9:31:C2")
+		var resp lochness.Hypervisor
+		s.doEncoded("POST", s.APIURL, mediaType, http.StatusCreated, added, &resp)
+		s.Equal(added.ID, resp.ID, mediaType)
+		s.Equal(added.MAC, resp.MAC, mediaType)
+
+		var httpErr httpmw.HTTPError
+		s.doEncoded("POST", s.APIURL, mediaType, http.StatusBadRequest, map[string]string{"id": "foobar"}, &httpErr)
+		s.Equal("validation_failed", httpErr.ErrorCode, mediaType)
+	}
+
+	var errResp map[string]string
+	s.DoRequest("GET", fmt.Sprintf("%s/%s", s.APIURL, "foobar"), http.StatusBadRequest, nil, &errResp)
+	s.Equal("invalid_hypervisor_id", errResp["error"], "json should be the default")
+
+	req, _ := http.NewRequest("POST", s.APIURL, strings.NewReader("id: foo"))
+	req.Header.Set("Content-Type", "text/plain")
+	resp, err := http.DefaultClient.Do(req)
+	s.Require().NoError(err)
+	_ = resp.Body.Close()
+	s.Equal(http.StatusBadRequest, resp.StatusCode, "unsupported content types should be rejected")
+}
+
+func (s *APISuite) TestEvents() {
+	url := fmt.Sprintf("http://localhost:%d/events?prefix=hypervisors&id=%s", s.Port, s.Hypervisor.ID)
+	resp, err := http.Get(url)
+	s.Require().NoError(err)
+	defer func() { _ = resp.Body.Close() }()
+	s.Require().Equal(http.StatusOK, resp.StatusCode)
+	s.Equal("text/event-stream", resp.Header.Get("Content-Type"))
+	time.Sleep(100 * time.Millisecond)
+
+	_ = s.NewHypervisor()
+	s.Hypervisor.Metadata = map[string]string{"rack": "a1"}
+	s.Require().NoError(s.Hypervisor.Save())
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	var event, data string
+	for event == "" || data == "" {
+		select {
+		case line := <-lines:
+			if strings.HasPrefix(line, "event: ") {
+				event = strings.TrimPrefix(line, "event: ")
+			}
+			if strings.HasPrefix(line, "data: ") {
+				data = strings.TrimPrefix(line, "data: ")
+			}
+		case <-time.After(5 * time.Second):
+			s.FailNow("timed out waiting for an event")
+		}
+	}
+	s.Equal("hypervisor.updated", event)
+	s.Contains(data, s.Hypervisor.ID)
+
+	var errResp map[string]interface{}
+	s.DoRequest("GET", fmt.Sprintf("http://localhost:%d/events?prefix=flavors", s.Port), http.StatusBadRequest, nil, &errResp)
+	s.Equal("validation_failed", errResp["error"])
+}
@@ -0,0 +1,70 @@
+package hypervisorapi
+
+import (
+	"net/http"
+
+	"github.com/mistifyio/lochness/internal/httpmw"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+)
+
+// GetHypervisorExpectedConfig gets the facts expected of a hypervisor
+func GetHypervisorExpectedConfig(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	hypervisor, ok := getHypervisorHelper(hr, r)
+	if !ok {
+		return
+	}
+
+	expected, err := hypervisor.ExpectedConfig()
+	if err != nil {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	hr.JSON(http.StatusOK, expected)
+}
+
+// UpdateHypervisorExpectedConfig sets the facts expected of a hypervisor.
+// Empty values are removed.
+func UpdateHypervisorExpectedConfig(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	hypervisor, ok := getHypervisorHelper(hr, r)
+	if !ok {
+		return
+	}
+	var changes map[string]string
+	if err := httpmw.Decode(r, &changes); err != nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_json", err.Error())
+		return
+	}
+
+	expected, err := hypervisor.SetExpectedConfig(changes)
+	if err != nil {
+		switch {
+		case lerrors.IsValidation(err):
+			hr.JSONError(http.StatusBadRequest, err)
+		case lerrors.IsConflict(err):
+			hr.JSONErrorMsg(http.StatusConflict, "expected_config_changed", "expected config changed concurrently, try again")
+		default:
+			hr.JSONError(http.StatusInternalServerError, err)
+		}
+		return
+	}
+	hr.JSON(http.StatusOK, expected)
+}
+
+// GetHypervisorDrift gets how the facts of a hypervisor differed from those
+// expected of it when last checked on the hypervisor
+func GetHypervisorDrift(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	hypervisor, ok := getHypervisorHelper(hr, r)
+	if !ok {
+		return
+	}
+
+	drift, err := hypervisor.Drift()
+	if err != nil {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	hr.JSON(http.StatusOK, drift)
+}
@@ -0,0 +1,30 @@
+package hypervisorapi
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/mistifyio/lochness/internal/changefeed"
+	"github.com/mistifyio/lochness/internal/httpmw"
+)
+
+// RegisterEventRoutes registers the route streaming the changes of feed as
+// server-sent events. Streams are long lived, so they are not wrapped in
+// metrics.
+func RegisterEventRoutes(prefix string, router *mux.Router, feed *changefeed.Feed) {
+	router.HandleFunc(prefix, StreamEvents(feed)).Methods("GET")
+}
+
+// StreamEvents returns a handler streaming the changes of feed that pass the
+// filter of the prefix, id, and metadata query parameters
+func StreamEvents(feed *changefeed.Feed) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := changefeed.ParseFilter(r.URL.Query())
+		if err != nil {
+			hr := httpmw.NewResponse(w)
+			hr.JSONError(http.StatusBadRequest, err)
+			return
+		}
+		feed.Serve(w, r, filter)
+	}
+}
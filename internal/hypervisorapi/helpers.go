@@ -1,30 +1,34 @@
-package main
+package hypervisorapi
 
 import (
-	"encoding/json"
 	"net/http"
 
 	"github.com/gorilla/mux"
 	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/httpmw"
 	"github.com/pborman/uuid"
 )
 
 // getHypervisorHelper gets the hypervisor object and handles sending a response
 // in case of error
-func getHypervisorHelper(hr HTTPResponse, r *http.Request) (*lochness.Hypervisor, bool) {
+func getHypervisorHelper(hr *httpmw.Response, r *http.Request) (*lochness.Hypervisor, bool) {
 	ctx := GetContext(r)
 	vars := mux.Vars(r)
 	hypervisorID, ok := vars["hypervisorID"]
 	if !ok {
-		hr.JSONMsg(http.StatusBadRequest, "missing hypervisor id")
+		hr.JSONErrorMsg(http.StatusBadRequest, "missing_hypervisor_id", "missing hypervisor id")
 		return nil, false
 	}
 	if uuid.Parse(hypervisorID) == nil {
-		hr.JSONMsg(http.StatusBadRequest, "invalid hypervisor id")
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_hypervisor_id", "invalid hypervisor id")
 		return nil, false
 	}
 	hypervisor, err := ctx.Hypervisor(hypervisorID)
 	if err != nil {
+		if ctx.IsKeyNotFound(err) {
+			hr.JSONErrorMsg(http.StatusNotFound, "hypervisor_not_found", "hypervisor not found")
+			return nil, false
+		}
 		hr.JSONError(http.StatusInternalServerError, err)
 		return nil, false
 	}
@@ -33,9 +37,9 @@ func getHypervisorHelper(hr HTTPResponse, r *http.Request) (*lochness.Hypervisor
 
 // saveHypervisorHelper saves the hypervisor object and handles sending a
 // response in case of error
-func saveHypervisorHelper(hr HTTPResponse, hypervisor *lochness.Hypervisor) bool {
+func saveHypervisorHelper(hr *httpmw.Response, hypervisor *lochness.Hypervisor) bool {
 	if err := hypervisor.Validate(); err != nil {
-		hr.JSONMsg(http.StatusBadRequest, err.Error())
+		hr.JSONError(http.StatusBadRequest, err)
 		return false
 	}
 	// Save
@@ -46,14 +50,14 @@ func saveHypervisorHelper(hr HTTPResponse, hypervisor *lochness.Hypervisor) bool
 	return true
 }
 
-// decodeHypervisor decodes request body JSON into a hypervisor object
+// decodeHypervisor decodes the request body, see httpmw.Decode, into a hypervisor object
 func decodeHypervisor(r *http.Request, hypervisor *lochness.Hypervisor) (*lochness.Hypervisor, error) {
 	if hypervisor == nil {
 		ctx := GetContext(r)
 		hypervisor = ctx.NewHypervisor()
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(hypervisor); err != nil {
+	if err := httpmw.Decode(r, hypervisor); err != nil {
 		return nil, err
 	}
 	return hypervisor, nil
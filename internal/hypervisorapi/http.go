@@ -0,0 +1,77 @@
+// Package hypervisorapi is the http api of hypervisors and their desired state,
+// served by chypervisord and lochnessd.
+package hypervisorapi
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/bakins/net-http-recover"
+	"github.com/gorilla/context"
+	"github.com/gorilla/handlers"
+	"github.com/gorilla/mux"
+	"github.com/justinas/alice"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/changefeed"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	"github.com/mistifyio/lochness/internal/server"
+)
+
+const ctxKey string = "lochnessContext"
+
+// Run starts the server. The changes of feed are streamed at /events.
+func Run(port uint, ctx *lochness.Context, feed *changefeed.Feed, reqLog httpmw.Config, tlsConfig *tls.Config) *server.Server {
+	router := mux.NewRouter()
+	router.StrictSlash(true)
+
+	// Common middleware applied to every request
+	reqLog.Name = "chypervisord"
+	commonMiddleware := alice.New(
+		httpmw.RequestID,
+		httpmw.Negotiate,
+		httpmw.Logger(reqLog),
+		httpmw.Versioned,
+		handlers.CompressHandler,
+		func(h http.Handler) http.Handler {
+			return recovery.Handler(os.Stderr, h, true)
+		},
+		func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				context.Set(r, ctxKey, ctx)
+				h.ServeHTTP(w, r)
+			})
+		},
+	)
+
+	// NOTE: Due to weirdness with PrefixPath and StrictSlash, can't just pass
+	// a prefixed subrouter to the register functions and have the base path
+	// work cleanly. The register functions need to add a base path handler to
+	// the main router before setting subhandlers on either main or subrouter
+
+	RegisterHypervisorRoutes("/hypervisors", router)
+	RegisterUpgradeRoutes("/upgrades", router)
+	RegisterEventRoutes("/events", router, feed)
+	RegisterSchemaRoutes("/schemas", router)
+	RegisterSwaggerRoute(router)
+
+	srv := server.New(fmt.Sprintf(":%d", port), commonMiddleware.Then(router), tlsConfig)
+	// Streams only end with the client, so end them to shut down
+	srv.RegisterOnShutdown(feed.Close)
+	srv.Start()
+	return srv
+}
+
+// SetContext sets a lochness.Context value for a request
+func SetContext(r *http.Request, ctx *lochness.Context) {
+	context.Set(r, ctxKey, ctx)
+}
+
+// GetContext retrieves a lochness.Context value for a request
+func GetContext(r *http.Request) *lochness.Context {
+	if value := context.Get(r, ctxKey); value != nil {
+		return value.(*lochness.Context)
+	}
+	return nil
+}
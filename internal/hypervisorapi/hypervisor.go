@@ -0,0 +1,637 @@
+package hypervisorapi
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/pborman/uuid"
+)
+
+// RegisterHypervisorRoutes registers the hypervisor routes and handlers
+func RegisterHypervisorRoutes(prefix string, router *mux.Router) {
+	router.HandleFunc(prefix, ListHypervisors).Methods("GET")
+	router.HandleFunc(prefix, CreateHypervisor).Methods("POST")
+	router.HandleFunc(prefix+"/register", RegisterHypervisor).Methods("POST")
+	router.HandleFunc(prefix+"/tokens", CreateBootstrapToken).Methods("POST")
+	sub := router.PathPrefix(prefix).Subrouter()
+	sub.HandleFunc("/{hypervisorID}", GetHypervisor).Methods("GET")
+	sub.HandleFunc("/{hypervisorID}", UpdateHypervisor).Methods("PATCH")
+	sub.HandleFunc("/{hypervisorID}", DestroyHypervisor).Methods("DELETE")
+	sub.HandleFunc("/{hypervisorID}/restore", RestoreHypervisor).Methods("POST")
+	sub.HandleFunc("/{hypervisorID}/config", GetHypervisorConfig).Methods("GET")
+	sub.HandleFunc("/{hypervisorID}/config", UpdateHypervisorConfig).Methods("PATCH")
+	sub.HandleFunc("/{hypervisorID}/expected", GetHypervisorExpectedConfig).Methods("GET")
+	sub.HandleFunc("/{hypervisorID}/expected", UpdateHypervisorExpectedConfig).Methods("PATCH")
+	sub.HandleFunc("/{hypervisorID}/drift", GetHypervisorDrift).Methods("GET")
+	sub.HandleFunc("/{hypervisorID}/subnets", ListHypervisorSubnets).Methods("GET")
+	sub.HandleFunc("/{hypervisorID}/subnets", AddHypervisorSubnets).Methods("PATCH")
+	sub.HandleFunc("/{hypervisorID}/subnets/{subnetID}", RemoveHypervisorSubnet).Methods("DELETE")
+	sub.HandleFunc("/{hypervisorID}/guests", ListHypervisorGuests).Methods("GET")
+	sub.HandleFunc("/{hypervisorID}/health", GetHypervisorHealth).Methods("GET")
+	sub.HandleFunc("/{hypervisorID}/telemetry", GetHypervisorTelemetry).Methods("GET")
+	sub.HandleFunc("/{hypervisorID}/telemetry", RecordHypervisorTelemetry).Methods("POST")
+	sub.HandleFunc("/{hypervisorID}/power", PowerHypervisor).Methods("POST")
+	sub.HandleFunc("/{hypervisorID}/desiredstate", GetHypervisorDesiredState).Methods("GET")
+	sub.HandleFunc("/{hypervisorID}/desiredstate/ack", GetHypervisorDesiredStateAck).Methods("GET")
+	sub.HandleFunc("/{hypervisorID}/desiredstate/ack", AckHypervisorDesiredState).Methods("POST")
+}
+
+// maxDesiredStateWait caps how long a desired state request may be held open
+const maxDesiredStateWait = 60 * time.Second
+
+type (
+	// hypervisorConfig is the config of a hypervisor, as returned by the
+	// config routes. ModifiedIndex is the highest modification index of its
+	// keys.
+	hypervisorConfig struct {
+		Config        map[string]string `json:"config"`
+		ModifiedIndex uint64            `json:"modified_index"`
+	}
+
+	// hypervisorSubnets are the subnets of a hypervisor mapped to their
+	// bridges, as returned by the subnets routes. ModifiedIndex is the highest
+	// modification index of its keys.
+	hypervisorSubnets struct {
+		Subnets       map[string]string `json:"subnets"`
+		ModifiedIndex uint64            `json:"modified_index"`
+	}
+
+	// configPatch is the request body of UpdateHypervisorConfig, the config
+	// values to set, or to unset if empty
+	configPatch map[string]string
+
+	// subnetsPatch is the request body of AddHypervisorSubnets, the ids of
+	// the subnets to add mapped to their bridges
+	subnetsPatch map[string]string
+
+	// powerRequest is the request body of PowerHypervisor
+	powerRequest struct {
+		Action string `json:"action"` // on, off, or cycle
+	}
+)
+
+// sendHypervisorConfig sends the config of a hypervisor with its modification
+// index
+func sendHypervisorConfig(hr *httpmw.Response, h *lochness.Hypervisor) {
+	index, err := h.ConfigIndex()
+	if err != nil {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	hr.JSON(http.StatusOK, &hypervisorConfig{Config: h.Config, ModifiedIndex: index})
+}
+
+// sendHypervisorSubnets sends the subnets of a hypervisor with their
+// modification index
+func sendHypervisorSubnets(hr *httpmw.Response, h *lochness.Hypervisor) {
+	index, err := h.SubnetsIndex()
+	if err != nil {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	hr.JSON(http.StatusOK, &hypervisorSubnets{Subnets: h.Subnets(), ModifiedIndex: index})
+}
+
+// sortedKeys returns the keys of a map in order, so patches are validated and
+// applied in a predictable order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Validate ensures every key and value of the patch is valid, so that none is
+// set unless all can be
+func (p configPatch) Validate() error {
+	for _, key := range sortedKeys(p) {
+		if err := lochness.ValidateHypervisorConfig(key, p[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate ensures every subnet id and bridge of the patch is valid, so that
+// none is added unless all can be
+func (p subnetsPatch) Validate() error {
+	for _, subnetID := range sortedKeys(p) {
+		if uuid.Parse(subnetID) == nil {
+			return lerrors.Validation(subnetID, "invalid subnet id")
+		}
+		if err := lochness.ValidateBridge(p[subnetID]); err != nil {
+			return lerrors.Validation(subnetID, err.Error())
+		}
+	}
+	return nil
+}
+
+// ListHypervisors gets a list of all hypervisors, or those whose metadata
+// matches the metadata query parameters, each a key=value pair. Soft deleted
+// hypervisors are only listed, instead of the others, with the deleted=true
+// query parameter.
+func ListHypervisors(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	ctx := GetContext(r)
+	filters, err := lochness.ParseMetadataFilters(r.URL.Query()["metadata"])
+	if err != nil {
+		hr.JSONError(http.StatusBadRequest, err)
+		return
+	}
+	deleted := false
+	if v := r.URL.Query().Get("deleted"); v != "" {
+		if deleted, err = strconv.ParseBool(v); err != nil {
+			hr.JSONErrorMsg(http.StatusBadRequest, "invalid_deleted", "invalid deleted: must be true or false")
+			return
+		}
+	}
+	hypervisors, err := ctx.HypervisorsMatching(filters)
+	if err != nil {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+
+	listed := make(lochness.Hypervisors, 0, len(hypervisors))
+	for _, hypervisor := range hypervisors {
+		if hypervisor.IsDeleted() == deleted {
+			listed = append(listed, hypervisor)
+		}
+	}
+	hr.JSON(http.StatusOK, listed)
+}
+
+// GetHypervisor gets a particular hypervisor
+func GetHypervisor(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	hypervisor, ok := getHypervisorHelper(hr, r)
+	if !ok {
+		return
+	}
+	hr.JSON(http.StatusOK, hypervisor)
+}
+
+// CreateHypervisor creates a new hypervisor
+func CreateHypervisor(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+
+	hypervisor, err := decodeHypervisor(r, nil)
+	if err != nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_json", err.Error())
+		return
+	}
+	// Hypervisors are deleted through DestroyHypervisor
+	hypervisor.Tombstone = nil
+
+	if !saveHypervisorHelper(hr, hypervisor) {
+		return
+	}
+	hr.JSON(http.StatusCreated, hypervisor)
+}
+
+// UpdateHypervisor updates an existing hypervisor
+func UpdateHypervisor(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	hypervisor, ok := getHypervisorHelper(hr, r)
+	if !ok {
+		return // Specific response handled by getHypervisorHelper
+	}
+
+	tombstone := hypervisor.Tombstone
+
+	// Parse Request
+	_, err := decodeHypervisor(r, hypervisor)
+	if err != nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_json", err.Error())
+		return
+	}
+	// Hypervisors are deleted and restored through their own routes
+	hypervisor.Tombstone = tombstone
+
+	if !saveHypervisorHelper(hr, hypervisor) {
+		return
+	}
+	hr.JSON(http.StatusOK, hypervisor)
+}
+
+// DestroyHypervisor deletes an existing hypervisor. With a soft delete window
+// configured for the cluster, the hypervisor is only marked deleted instead, so
+// that it may be restored until it is purged. Deleting a soft deleted
+// hypervisor purges it right away.
+func DestroyHypervisor(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	ctx := GetContext(r)
+	hypervisor, ok := getHypervisorHelper(hr, r)
+	if !ok {
+		return
+	}
+
+	window := time.Duration(0)
+	if !hypervisor.IsDeleted() {
+		var err error
+		if window, err = ctx.SoftDeleteWindow(); err != nil {
+			hr.JSONError(http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	destroy := hypervisor.Destroy
+	if window > 0 {
+		destroy = func() error { return hypervisor.SoftDelete(window) }
+	}
+	if err := destroy(); err != nil {
+		if lerrors.IsConflict(err) {
+			hr.JSONErrorMsg(http.StatusConflict, "hypervisor_not_empty", err.Error())
+			return
+		}
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	hr.JSON(http.StatusOK, hypervisor)
+}
+
+// RestoreHypervisor restores a soft deleted hypervisor
+func RestoreHypervisor(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	hypervisor, ok := getHypervisorHelper(hr, r)
+	if !ok {
+		return
+	}
+
+	if err := hypervisor.Restore(); err != nil {
+		if lerrors.IsConflict(err) {
+			hr.JSONErrorMsg(http.StatusConflict, "hypervisor_not_deleted", err.Error())
+			return
+		}
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	hr.JSON(http.StatusOK, hypervisor)
+}
+
+// GetHypervisorConfig gets the set of key/value config options
+func GetHypervisorConfig(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	hypervisor, ok := getHypervisorHelper(hr, r)
+	if !ok {
+		return
+	}
+
+	sendHypervisorConfig(hr, hypervisor)
+}
+
+// GetHypervisorHealth gets the health of a hypervisor, scored from its recent
+// heartbeats
+func GetHypervisorHealth(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	hypervisor, ok := getHypervisorHelper(hr, r)
+	if !ok {
+		return
+	}
+
+	hr.JSON(http.StatusOK, hypervisor.Health())
+}
+
+// GetHypervisorTelemetry gets the recent resource telemetry of a hypervisor,
+// summarized, with the health of its heartbeats
+func GetHypervisorTelemetry(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	hypervisor, ok := getHypervisorHelper(hr, r)
+	if !ok {
+		return
+	}
+
+	telemetry, err := hypervisor.Telemetry()
+	if err != nil {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	hr.JSON(http.StatusOK, telemetry)
+}
+
+// RecordHypervisorTelemetry adds a telemetry sample pushed by a hypervisor to
+// its window
+func RecordHypervisorTelemetry(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	hypervisor, ok := getHypervisorHelper(hr, r)
+	if !ok {
+		return
+	}
+
+	var sample lochness.TelemetrySample
+	if err := httpmw.Decode(r, &sample); err != nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_json", err.Error())
+		return
+	}
+	if err := hypervisor.RecordTelemetry(sample); err != nil {
+		if lerrors.IsValidation(err) {
+			hr.JSONError(http.StatusBadRequest, err)
+			return
+		}
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+
+	telemetry, err := hypervisor.Telemetry()
+	if err != nil {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	hr.JSON(http.StatusOK, telemetry)
+}
+
+// PowerHypervisor powers a hypervisor on, off, or cycles it through its BMC.
+// The BMC performs the action once it has accepted it.
+func PowerHypervisor(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	hypervisor, ok := getHypervisorHelper(hr, r)
+	if !ok {
+		return
+	}
+	var req powerRequest
+	if err := httpmw.Decode(r, &req); err != nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_json", err.Error())
+		return
+	}
+
+	err := hypervisor.Power(req.Action)
+	switch {
+	case err == nil:
+	case lerrors.IsValidation(err):
+		hr.JSONError(http.StatusBadRequest, err)
+		return
+	case err == lochness.ErrNoBMC:
+		hr.JSONErrorMsg(http.StatusConflict, "no_bmc", err.Error())
+		return
+	case err == lochness.ErrNoBMCCredentials:
+		hr.JSONErrorMsg(http.StatusConflict, "no_bmc_credentials", err.Error())
+		return
+	case err == lochness.ErrNoSecretKey:
+		hr.JSONErrorMsg(http.StatusServiceUnavailable, "no_secret_key", "no secret key to decrypt bmc credentials with")
+		return
+	case errors.Is(err, lochness.ErrKVTimeout) || GetContext(r).IsKeyNotFound(err):
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	default:
+		hr.JSONErrorMsg(http.StatusBadGateway, "power_failed", err.Error())
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"request_id": hr.RequestID(),
+		"hypervisor": hypervisor.ID,
+		"action":     req.Action,
+	}).Info("hypervisor power action")
+	hr.JSON(http.StatusAccepted, hypervisor)
+}
+
+// UpdateHypervisorConfig sets key/value config options, and unsets those with
+// empty values. Nothing is changed unless every key and value is valid.
+func UpdateHypervisorConfig(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	hypervisor, ok := getHypervisorHelper(hr, r)
+	if !ok {
+		return
+	}
+	var patch configPatch
+	if err := httpmw.Decode(r, &patch); err != nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_json", err.Error())
+		return
+	}
+	if err := patch.Validate(); err != nil {
+		hr.JSONError(http.StatusBadRequest, err)
+		return
+	}
+
+	for _, key := range sortedKeys(patch) {
+		if err := hypervisor.SetConfig(key, patch[key]); err != nil {
+			hr.JSONError(http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	if err := hypervisor.Refresh(); err != nil {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	sendHypervisorConfig(hr, hypervisor)
+}
+
+// ListHypervisorSubnets lists the subnets associated with a hypervisor
+func ListHypervisorSubnets(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	hypervisor, ok := getHypervisorHelper(hr, r)
+	if !ok {
+		return
+	}
+
+	sendHypervisorSubnets(hr, hypervisor)
+}
+
+// AddHypervisorSubnets associates subnets with a hypervisor. Nothing is
+// changed unless every subnet exists and every bridge is valid and can carry
+// its subnet, see Hypervisor.CheckSubnetTopology.
+func AddHypervisorSubnets(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	ctx := GetContext(r)
+	hypervisor, ok := getHypervisorHelper(hr, r)
+	if !ok {
+		return
+	}
+
+	var patch subnetsPatch
+	if err := httpmw.Decode(r, &patch); err != nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_json", err.Error())
+		return
+	}
+	if err := patch.Validate(); err != nil {
+		hr.JSONError(http.StatusBadRequest, err)
+		return
+	}
+
+	subnetIDs := sortedKeys(patch)
+	subnets := make([]*lochness.Subnet, len(subnetIDs))
+	for i, subnetID := range subnetIDs {
+		subnet, err := ctx.Subnet(subnetID)
+		if err != nil {
+			if ctx.IsKeyNotFound(err) {
+				hr.JSONError(http.StatusNotFound, httpmw.NewAPIError("subnet_not_found", err.Error()))
+				return
+			}
+			hr.JSONError(http.StatusInternalServerError, err)
+			return
+		}
+		subnets[i] = subnet
+	}
+
+	for _, subnet := range subnets {
+		if err := hypervisor.CheckSubnetTopology(subnet, patch[subnet.ID]); err != nil {
+			if lerrors.IsValidation(err) {
+				hr.JSONError(http.StatusBadRequest, err)
+				return
+			}
+			hr.JSONError(http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	for _, subnet := range subnets {
+		if err := hypervisor.AddSubnet(subnet, patch[subnet.ID]); err != nil {
+			hr.JSONError(http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	if err := hypervisor.Refresh(); err != nil {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	sendHypervisorSubnets(hr, hypervisor)
+}
+
+// RemoveHypervisorSubnet removes a subnet from a Hypervisor
+func RemoveHypervisorSubnet(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	ctx := GetContext(r)
+	hypervisor, ok := getHypervisorHelper(hr, r)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+	subnet, err := ctx.Subnet(vars["subnetID"])
+	if err != nil {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := hypervisor.RemoveSubnet(subnet); err != nil {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := hypervisor.Refresh(); err != nil {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	sendHypervisorSubnets(hr, hypervisor)
+}
+
+// ListHypervisorGuests returns a list of guests of the Hypervisor
+func ListHypervisorGuests(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	hypervisor, ok := getHypervisorHelper(hr, r)
+	if !ok {
+		return
+	}
+
+	hr.JSON(http.StatusOK, hypervisor.Guests())
+}
+
+// GetHypervisorDesiredState returns the desired state of the Hypervisor. If the
+// generation query parameter is given, the request is held open until a newer
+// generation is available or the wait query parameter (in seconds) elapses.
+func GetHypervisorDesiredState(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	hypervisor, ok := getHypervisorHelper(hr, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	var generation uint64
+	var wait time.Duration
+	if g := query.Get("generation"); g != "" {
+		var err error
+		generation, err = strconv.ParseUint(g, 10, 64)
+		if err != nil {
+			hr.JSONErrorMsg(http.StatusBadRequest, "invalid_generation", "invalid generation")
+			return
+		}
+		wait = maxDesiredStateWait
+	}
+	if ws := query.Get("wait"); ws != "" {
+		seconds, err := strconv.ParseUint(ws, 10, 32)
+		if err != nil {
+			hr.JSONErrorMsg(http.StatusBadRequest, "invalid_wait", "invalid wait")
+			return
+		}
+		wait = time.Duration(seconds) * time.Second
+		if wait > maxDesiredStateWait {
+			wait = maxDesiredStateWait
+		}
+	}
+
+	desired, err := hypervisor.WaitDesiredState(generation, wait)
+	if err != nil {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	hr.JSON(http.StatusOK, desired)
+}
+
+// GetHypervisorDesiredStateAck returns the last desired state ack reported by
+// the Hypervisor
+func GetHypervisorDesiredStateAck(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	hypervisor, ok := getHypervisorHelper(hr, r)
+	if !ok {
+		return
+	}
+
+	ack, err := hypervisor.DesiredStateAck()
+	if err != nil {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	if ack == nil {
+		hr.JSONErrorMsg(http.StatusNotFound, "ack_not_found", "no ack reported")
+		return
+	}
+	hr.JSON(http.StatusOK, ack)
+}
+
+// AckHypervisorDesiredState records the result of the Hypervisor converging on
+// a desired state generation
+func AckHypervisorDesiredState(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	hypervisor, ok := getHypervisorHelper(hr, r)
+	if !ok {
+		return
+	}
+
+	var ack lochness.DesiredStateAck
+	if err := httpmw.Decode(r, &ack); err != nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_json", err.Error())
+		return
+	}
+	if ack.Generation == 0 {
+		hr.JSONErrorMsg(http.StatusBadRequest, "missing_generation", "missing generation")
+		return
+	}
+
+	var result error
+	if ack.Error != "" {
+		result = errors.New(ack.Error)
+	}
+	if err := hypervisor.AckDesiredState(ack.Generation, result); err != nil {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+
+	current, err := hypervisor.DesiredStateAck()
+	if err != nil {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	hr.JSON(http.StatusOK, current)
+}
@@ -0,0 +1,165 @@
+package hypervisorapi
+
+import (
+	"net/http"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+)
+
+type (
+	// registerRequest is the request body of RegisterHypervisor, a bootstrap
+	// token and what the node reports of itself
+	registerRequest struct {
+		Token string `json:"token"`
+		lochness.HypervisorRegistration
+	}
+
+	// registration is the response of RegisterHypervisor, the registered
+	// hypervisor and the config it is to apply
+	registration struct {
+		Hypervisor *lochness.Hypervisor `json:"hypervisor"`
+		Config     map[string]string    `json:"config"`
+		Created    bool                 `json:"created"`
+	}
+
+	// tokenRequest is the request body of CreateBootstrapToken
+	tokenRequest struct {
+		TTL string `json:"ttl,omitempty"` // e.g. 24h, DefaultBootstrapTokenTTL if empty
+		MAC string `json:"mac,omitempty"`
+	}
+
+	// bootstrapToken is the response of CreateBootstrapToken
+	bootstrapToken struct {
+		Token string `json:"token"`
+		lochness.BootstrapToken
+	}
+)
+
+// RegisterHypervisor registers a freshly netbooted node as a hypervisor,
+// creating it or updating the one with its id or mac, if it has a valid
+// bootstrap token. A hypervisor with the id but another mac is a conflict. The
+// hypervisor is returned with its config.
+func RegisterHypervisor(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	ctx := GetContext(r)
+
+	var req registerRequest
+	if err := httpmw.Decode(r, &req); err != nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_json", err.Error())
+		return
+	}
+
+	token, err := ctx.VerifyBootstrapToken(req.Token, req.MAC)
+	switch {
+	case err == nil:
+	case err == lochness.ErrNoSecretKey:
+		hr.JSONErrorMsg(http.StatusServiceUnavailable, "no_secret_key", "no secret key to verify bootstrap tokens with")
+		return
+	case err == lochness.ErrInvalidBootstrapToken:
+		hr.JSONErrorMsg(http.StatusUnauthorized, "invalid_token", err.Error())
+		return
+	default:
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+
+	hypervisor, created, err := ctx.RegisterHypervisor(&req.HypervisorRegistration, token)
+	switch {
+	case err == nil:
+	case lerrors.IsValidation(err):
+		hr.JSONError(http.StatusBadRequest, err)
+		return
+	case err == lochness.ErrHypervisorMACMismatch:
+		hr.JSONErrorMsg(http.StatusConflict, "mac_mismatch", err.Error())
+		return
+	case lerrors.IsConflict(err):
+		hr.JSONError(http.StatusConflict, err)
+		return
+	default:
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+
+	config, err := hypervisor.BootstrapConfig()
+	if err != nil {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"request_id": hr.RequestID(),
+		"hypervisor": hypervisor.ID,
+		"mac":        hypervisor.MAC.String(),
+		"ip":         hypervisor.IP.String(),
+		"created":    created,
+	}).Info("hypervisor registered")
+
+	code := http.StatusOK
+	if created {
+		code = http.StatusCreated
+	}
+	hr.JSON(code, &registration{
+		Hypervisor: hypervisor,
+		Config:     config,
+		Created:    created,
+	})
+}
+
+// CreateBootstrapToken creates a bootstrap token with which nodes may register
+// themselves, valid for the ttl and, if a mac is given, only for that node
+func CreateBootstrapToken(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	ctx := GetContext(r)
+
+	var req tokenRequest
+	if err := httpmw.Decode(r, &req); err != nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_json", err.Error())
+		return
+	}
+
+	ttl := lochness.DefaultBootstrapTokenTTL
+	if req.TTL != "" {
+		var err error
+		ttl, err = time.ParseDuration(req.TTL)
+		if err != nil || ttl <= 0 {
+			hr.JSONErrorMsg(http.StatusBadRequest, "invalid_ttl", "ttl must be a positive duration, e.g. 24h")
+			return
+		}
+	}
+
+	t := lochness.BootstrapToken{
+		Expires: time.Now().Add(ttl).UTC().Truncate(time.Second),
+		MAC:     req.MAC,
+	}
+	token, err := ctx.SignBootstrapToken(t)
+	switch {
+	case err == nil:
+	case err == lochness.ErrNoSecretKey:
+		hr.JSONErrorMsg(http.StatusServiceUnavailable, "no_secret_key", "no secret key to sign bootstrap tokens with")
+		return
+	case lerrors.IsValidation(err):
+		hr.JSONError(http.StatusBadRequest, err)
+		return
+	default:
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+
+	// the token has the mac as signed
+	signed, err := ctx.VerifyBootstrapToken(token, req.MAC)
+	if err != nil {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"request_id": hr.RequestID(),
+		"expires":    signed.Expires,
+		"mac":        signed.MAC,
+	}).Info("bootstrap token created")
+	hr.JSON(http.StatusCreated, &bootstrapToken{Token: token, BootstrapToken: *signed})
+}
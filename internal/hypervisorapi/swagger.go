@@ -0,0 +1,243 @@
+package hypervisorapi
+
+import (
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	"github.com/mistifyio/lochness/pkg/events"
+	"github.com/mistifyio/lochness/pkg/swagger"
+)
+
+// apiVersion is the version of the API described by /swagger.json
+const apiVersion = "1.0"
+
+// routeDocs documents the request and response bodies of the api routes
+var routeDocs = swagger.Routes{
+	"GET /hypervisors": {
+		Summary: "List the hypervisors",
+		Tags:    []string{"hypervisors"},
+		Query: []swagger.Parameter{
+			{Name: "metadata", Type: "string", Description: "key=value pair the metadata must have. may be repeated"},
+			{Name: "deleted", Type: "boolean", Description: "list the soft deleted hypervisors instead of the others"},
+		},
+		Response: lochness.Hypervisors{},
+	},
+	"POST /hypervisors": {
+		Summary:  "Create a hypervisor",
+		Tags:     []string{"hypervisors"},
+		Request:  &lochness.Hypervisor{},
+		Response: &lochness.Hypervisor{},
+		Status:   http.StatusCreated,
+	},
+	"POST /hypervisors/register": {
+		Summary:  "Register a node as a hypervisor with a bootstrap token, creating it or updating the one with its id or mac, and get its config. Created hypervisors are returned with 201.",
+		Tags:     []string{"bootstrap"},
+		Request:  &registerRequest{},
+		Response: &registration{},
+	},
+	"POST /hypervisors/tokens": {
+		Summary:  "Create a bootstrap token, valid for the ttl and, if a mac is given, only for that node",
+		Tags:     []string{"bootstrap"},
+		Request:  &tokenRequest{},
+		Response: &bootstrapToken{},
+		Status:   http.StatusCreated,
+	},
+	"GET /hypervisors/{hypervisorID}": {
+		Summary:  "Get a hypervisor",
+		Tags:     []string{"hypervisors"},
+		Response: &lochness.Hypervisor{},
+	},
+	"PATCH /hypervisors/{hypervisorID}": {
+		Summary:  "Update a hypervisor",
+		Tags:     []string{"hypervisors"},
+		Request:  &lochness.Hypervisor{},
+		Response: &lochness.Hypervisor{},
+	},
+	"DELETE /hypervisors/{hypervisorID}": {
+		Summary:  "Delete a hypervisor without guests. With a soft delete window configured, the hypervisor is only marked deleted, unless it already is.",
+		Tags:     []string{"hypervisors"},
+		Response: &lochness.Hypervisor{},
+	},
+	"POST /hypervisors/{hypervisorID}/restore": {
+		Summary:  "Restore a soft deleted hypervisor",
+		Tags:     []string{"hypervisors"},
+		Request:  map[string]string{},
+		Response: &lochness.Hypervisor{},
+	},
+	"GET /hypervisors/{hypervisorID}/config": {
+		Summary:  "Get the config of a hypervisor",
+		Tags:     []string{"config"},
+		Response: &hypervisorConfig{},
+	},
+	"PATCH /hypervisors/{hypervisorID}/config": {
+		Summary:  "Set config values of a hypervisor. Empty values are removed. Nothing is changed unless every key and value is valid.",
+		Tags:     []string{"config"},
+		Request:  configPatch{},
+		Response: &hypervisorConfig{},
+	},
+	"GET /hypervisors/{hypervisorID}/expected": {
+		Summary:  "Get the facts expected of a hypervisor, such as its kernel args, checked for drift",
+		Tags:     []string{"drift"},
+		Response: map[string]string{},
+	},
+	"PATCH /hypervisors/{hypervisorID}/expected": {
+		Summary:  "Set facts expected of a hypervisor. Empty values are removed.",
+		Tags:     []string{"drift"},
+		Request:  map[string]string{},
+		Response: map[string]string{},
+	},
+	"GET /hypervisors/{hypervisorID}/drift": {
+		Summary:  "Get how the facts of a hypervisor differed from those expected when last checked",
+		Tags:     []string{"drift"},
+		Response: &lochness.HypervisorDrift{},
+	},
+	"GET /hypervisors/{hypervisorID}/subnets": {
+		Summary:  "List the subnets of a hypervisor, mapped to their bridges",
+		Tags:     []string{"subnets"},
+		Response: &hypervisorSubnets{},
+	},
+	"PATCH /hypervisors/{hypervisorID}/subnets": {
+		Summary:  "Add subnets, mapped to their bridges, to a hypervisor. Nothing is changed unless every subnet exists and every bridge is valid.",
+		Tags:     []string{"subnets"},
+		Request:  subnetsPatch{},
+		Response: &hypervisorSubnets{},
+	},
+	"DELETE /hypervisors/{hypervisorID}/subnets/{subnetID}": {
+		Summary:  "Remove a subnet from a hypervisor",
+		Tags:     []string{"subnets"},
+		Response: &hypervisorSubnets{},
+	},
+	"GET /hypervisors/{hypervisorID}/guests": {
+		Summary:  "List the ids of the guests on a hypervisor",
+		Tags:     []string{"guests"},
+		Response: []string{},
+	},
+	"GET /hypervisors/{hypervisorID}/health": {
+		Summary:  "Get the availability of a hypervisor, scored from its recent heartbeats",
+		Tags:     []string{"hypervisors"},
+		Response: &lochness.HypervisorHealth{},
+	},
+	"GET /hypervisors/{hypervisorID}/telemetry": {
+		Summary:  "Get the recent resource telemetry of a hypervisor, with the health of its heartbeats",
+		Tags:     []string{"hypervisors"},
+		Response: &lochness.HypervisorTelemetry{},
+	},
+	"POST /hypervisors/{hypervisorID}/telemetry": {
+		Summary:  "Record a resource telemetry sample of a hypervisor",
+		Tags:     []string{"hypervisors"},
+		Request:  &lochness.TelemetrySample{},
+		Response: &lochness.HypervisorTelemetry{},
+	},
+	"POST /hypervisors/{hypervisorID}/power": {
+		Summary:  "Power a hypervisor on or off, or cycle it, through its BMC",
+		Tags:     []string{"hypervisors"},
+		Request:  powerRequest{},
+		Response: &lochness.Hypervisor{},
+		Status:   http.StatusAccepted,
+	},
+	"GET /hypervisors/{hypervisorID}/desiredstate": {
+		Summary: "Get the desired state of a hypervisor",
+		Tags:    []string{"desiredstate"},
+		Query: []swagger.Parameter{
+			{Name: "generation", Type: "integer", Description: "wait for a generation newer than this"},
+			{Name: "wait", Type: "integer", Description: "seconds to wait for a newer generation"},
+		},
+		Response: &lochness.DesiredState{},
+	},
+	"GET /hypervisors/{hypervisorID}/desiredstate/ack": {
+		Summary:  "Get the last desired state ack of a hypervisor",
+		Tags:     []string{"desiredstate"},
+		Response: &lochness.DesiredStateAck{},
+	},
+	"POST /hypervisors/{hypervisorID}/desiredstate/ack": {
+		Summary:  "Ack a desired state generation",
+		Tags:     []string{"desiredstate"},
+		Request:  &lochness.DesiredStateAck{},
+		Response: &lochness.DesiredStateAck{},
+	},
+	"GET /upgrades": {
+		Summary:  "List the rolling upgrades of hypervisors",
+		Tags:     []string{"upgrades"},
+		Response: lochness.Upgrades{},
+	},
+	"POST /upgrades": {
+		Summary:  "Start rolling out an upgrade of hypervisors, unless another is in progress",
+		Tags:     []string{"upgrades"},
+		Request:  &upgradeRequest{},
+		Response: &lochness.Upgrade{},
+		Status:   http.StatusCreated,
+	},
+	"GET /upgrades/{upgradeID}": {
+		Summary:  "Get an upgrade, with the progress of its hypervisors",
+		Tags:     []string{"upgrades"},
+		Response: &lochness.Upgrade{},
+	},
+	"DELETE /upgrades/{upgradeID}": {
+		Summary:  "Delete a finished upgrade",
+		Tags:     []string{"upgrades"},
+		Response: &lochness.Upgrade{},
+	},
+	"POST /upgrades/{upgradeID}/pause": {
+		Summary:  "Pause an upgrade once the hypervisors being upgraded are done",
+		Tags:     []string{"upgrades"},
+		Response: &lochness.Upgrade{},
+		Status:   http.StatusAccepted,
+	},
+	"POST /upgrades/{upgradeID}/resume": {
+		Summary:  "Resume a paused upgrade",
+		Tags:     []string{"upgrades"},
+		Response: &lochness.Upgrade{},
+		Status:   http.StatusAccepted,
+	},
+	"POST /upgrades/{upgradeID}/abort": {
+		Summary:  "Abort an upgrade, leaving the hypervisors being upgraded in maintenance",
+		Tags:     []string{"upgrades"},
+		Response: &lochness.Upgrade{},
+		Status:   http.StatusAccepted,
+	},
+	"GET /events": {
+		Summary: "Stream the changes to guests and hypervisors as server-sent events",
+		Tags:    []string{"events"},
+		Query: []swagger.Parameter{
+			{Name: "prefix", Type: "string", Description: "guests or hypervisors, the entities to stream changes of. may be repeated"},
+			{Name: "id", Type: "string", Description: "id of an entity to stream changes of. may be repeated"},
+			{Name: "metadata", Type: "string", Description: "key=value pair the metadata of the entity must have. may be repeated"},
+			{Name: "last_event_id", Type: "string", Description: "id of the last event received, to resume after, as the Last-Event-ID header"},
+		},
+		Response: &events.Event{},
+		Produces: []string{"text/event-stream"},
+	},
+	"GET /schemas": {
+		Summary:  "List the entities with JSON Schemas",
+		Tags:     []string{"schemas"},
+		Response: []string{},
+	},
+	"GET /schemas/{entity}": {
+		Summary:  "Get the JSON Schema (draft-07) of an entity, e.g. guest",
+		Tags:     []string{"schemas"},
+		Response: map[string]interface{}{},
+	},
+	"GET /swagger.json": {
+		Summary: "Get this description of the api",
+	},
+}
+
+// RegisterSwaggerRoute registers a route serving a swagger description of all
+// routes on the router. It must be called after all other routes are
+// registered.
+func RegisterSwaggerRoute(router *mux.Router) *swagger.Spec {
+	spec := swagger.New("chypervisord", apiVersion)
+	spec.SetError(&httpmw.HTTPError{})
+	spec.Consumes = httpmw.MediaTypes
+	spec.Produces = httpmw.MediaTypes
+	spec.BasePath = httpmw.APIPrefix
+
+	router.Handle("/swagger.json", spec.Handler()).Methods("GET")
+	if err := spec.AddRouter(router, routeDocs); err != nil {
+		log.WithField("error", err).Fatal("failed to describe api")
+	}
+	return spec
+}
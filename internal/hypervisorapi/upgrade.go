@@ -0,0 +1,158 @@
+package hypervisorapi
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/pborman/uuid"
+)
+
+// RegisterUpgradeRoutes registers the upgrade routes and handlers
+func RegisterUpgradeRoutes(prefix string, router *mux.Router) {
+	router.HandleFunc(prefix, ListUpgrades).Methods("GET")
+	router.HandleFunc(prefix, StartUpgrade).Methods("POST")
+	sub := router.PathPrefix(prefix).Subrouter()
+	sub.HandleFunc("/{upgradeID}", GetUpgrade).Methods("GET")
+	sub.HandleFunc("/{upgradeID}", DestroyUpgrade).Methods("DELETE")
+	sub.HandleFunc("/{upgradeID}/pause", upgradeActionHandler((*lochness.Upgrade).Pause)).Methods("POST")
+	sub.HandleFunc("/{upgradeID}/resume", upgradeActionHandler((*lochness.Upgrade).Resume)).Methods("POST")
+	sub.HandleFunc("/{upgradeID}/abort", upgradeActionHandler((*lochness.Upgrade).Abort)).Methods("POST")
+}
+
+// upgradeRequest is the request body of StartUpgrade
+type upgradeRequest struct {
+	// Version is the version to upgrade the hypervisors to
+	Version string `json:"version"`
+	// BatchSize is how many hypervisors are upgraded at a time, 1 if unset
+	BatchSize int `json:"batch_size"`
+	// Hypervisors are the ids of the hypervisors to upgrade, in order. All
+	// are, in order of id, if unset.
+	Hypervisors []string `json:"hypervisors"`
+}
+
+// ListUpgrades gets a list of all upgrades
+func ListUpgrades(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	ctx := GetContext(r)
+
+	upgrades := make(lochness.Upgrades, 0)
+	err := ctx.ForEachUpgrade(func(u *lochness.Upgrade) error {
+		upgrades = append(upgrades, u)
+		return nil
+	})
+	if err != nil && !ctx.IsKeyNotFound(err) {
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	hr.JSON(http.StatusOK, upgrades)
+}
+
+// StartUpgrade starts rolling out an upgrade of hypervisors, unless another is
+// in progress
+func StartUpgrade(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	ctx := GetContext(r)
+
+	req := upgradeRequest{}
+	if err := httpmw.Decode(r, &req); err != nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_json", err.Error())
+		return
+	}
+
+	upgrade := ctx.NewUpgrade()
+	upgrade.Version = req.Version
+	if req.BatchSize != 0 {
+		upgrade.BatchSize = req.BatchSize
+	}
+	for _, id := range req.Hypervisors {
+		upgrade.AddHypervisor(id)
+	}
+
+	if err := upgrade.Start(); err != nil {
+		switch {
+		case lerrors.IsValidation(err):
+			hr.JSONError(http.StatusBadRequest, err)
+		case lerrors.IsConflict(err):
+			hr.JSONErrorMsg(http.StatusConflict, "upgrade_in_progress", err.Error())
+		default:
+			hr.JSONError(http.StatusInternalServerError, err)
+		}
+		return
+	}
+	hr.JSON(http.StatusCreated, upgrade)
+}
+
+// GetUpgrade gets a particular upgrade, with the progress of its hypervisors
+func GetUpgrade(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	upgrade, ok := getUpgradeHelper(hr, r)
+	if !ok {
+		return
+	}
+	hr.JSON(http.StatusOK, upgrade)
+}
+
+// DestroyUpgrade deletes a finished upgrade
+func DestroyUpgrade(w http.ResponseWriter, r *http.Request) {
+	hr := httpmw.NewResponse(w)
+	upgrade, ok := getUpgradeHelper(hr, r)
+	if !ok {
+		return
+	}
+
+	if err := upgrade.Destroy(); err != nil {
+		if lerrors.IsConflict(err) {
+			hr.JSONErrorMsg(http.StatusConflict, "upgrade_in_progress", err.Error())
+			return
+		}
+		hr.JSONError(http.StatusInternalServerError, err)
+		return
+	}
+	hr.JSON(http.StatusOK, upgrade)
+}
+
+// upgradeActionHandler creates a handler changing the state of an upgrade,
+// e.g. pausing it. cupgraded acts on the change on its next check.
+func upgradeActionHandler(action func(*lochness.Upgrade) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hr := httpmw.NewResponse(w)
+		upgrade, ok := getUpgradeHelper(hr, r)
+		if !ok {
+			return
+		}
+
+		if err := action(upgrade); err != nil {
+			if lerrors.IsConflict(err) {
+				hr.JSONErrorMsg(http.StatusConflict, "invalid_upgrade_state", err.Error())
+				return
+			}
+			hr.JSONError(http.StatusInternalServerError, err)
+			return
+		}
+		hr.JSON(http.StatusAccepted, upgrade)
+	}
+}
+
+// getUpgradeHelper gets the upgrade object and handles sending a response in
+// case of error
+func getUpgradeHelper(hr *httpmw.Response, r *http.Request) (*lochness.Upgrade, bool) {
+	ctx := GetContext(r)
+	upgradeID := mux.Vars(r)["upgradeID"]
+	if uuid.Parse(upgradeID) == nil {
+		hr.JSONErrorMsg(http.StatusBadRequest, "invalid_upgrade_id", "invalid upgrade id")
+		return nil, false
+	}
+	upgrade, err := ctx.Upgrade(upgradeID)
+	if err != nil {
+		if ctx.IsKeyNotFound(err) {
+			hr.JSONErrorMsg(http.StatusNotFound, "upgrade_not_found", "upgrade not found")
+			return nil, false
+		}
+		hr.JSONError(http.StatusInternalServerError, err)
+		return nil, false
+	}
+	return upgrade, true
+}
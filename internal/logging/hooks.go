@@ -0,0 +1,116 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log/syslog"
+	"net"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// journalSocket is the native protocol socket of journald
+var journalSocket = "/run/systemd/journal/socket"
+
+// syslogHook sends entries to the local syslog daemon
+type syslogHook struct {
+	writer *syslog.Writer
+}
+
+func newSyslogHook(name string) (*syslogHook, error) {
+	writer, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, name)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogHook{writer: writer}, nil
+}
+
+func (h *syslogHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *syslogHook) Fire(entry *log.Entry) error {
+	line, err := format(entry)
+	if err != nil {
+		return err
+	}
+	msg := string(line)
+
+	switch entry.Level {
+	case log.PanicLevel, log.FatalLevel:
+		return h.writer.Crit(msg)
+	case log.ErrorLevel:
+		return h.writer.Err(msg)
+	case log.WarnLevel:
+		return h.writer.Warning(msg)
+	case log.InfoLevel:
+		return h.writer.Info(msg)
+	default:
+		return h.writer.Debug(msg)
+	}
+}
+
+// journalHook sends entries to journald over its native protocol
+type journalHook struct {
+	conn       net.Conn
+	identifier string
+}
+
+func newJournalHook(name string) (*journalHook, error) {
+	conn, err := net.Dial("unixgram", journalSocket)
+	if err != nil {
+		return nil, err
+	}
+	return &journalHook{conn: conn, identifier: name}, nil
+}
+
+func (h *journalHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *journalHook) Fire(entry *log.Entry) error {
+	line, err := format(entry)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	writeJournalField(&buf, "PRIORITY", []byte(fmt.Sprint(priority(entry.Level))))
+	writeJournalField(&buf, "SYSLOG_IDENTIFIER", []byte(h.identifier))
+	writeJournalField(&buf, "MESSAGE", line)
+	_, err = h.conn.Write(buf.Bytes())
+	return err
+}
+
+// priority returns the syslog priority of a level
+func priority(level log.Level) syslog.Priority {
+	switch level {
+	case log.PanicLevel, log.FatalLevel:
+		return syslog.LOG_CRIT
+	case log.ErrorLevel:
+		return syslog.LOG_ERR
+	case log.WarnLevel:
+		return syslog.LOG_WARNING
+	case log.InfoLevel:
+		return syslog.LOG_INFO
+	default:
+		return syslog.LOG_DEBUG
+	}
+}
+
+// writeJournalField writes a field of the journald native protocol. Values
+// with newlines are written with their length, as the protocol requires.
+func writeJournalField(buf *bytes.Buffer, name string, value []byte) {
+	buf.WriteString(name)
+	if bytes.IndexByte(value, '\n') < 0 {
+		buf.WriteByte('=')
+		buf.Write(value)
+		buf.WriteByte('\n')
+		return
+	}
+	buf.WriteByte('\n')
+	_ = binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.Write(value)
+	buf.WriteByte('\n')
+}
@@ -0,0 +1,127 @@
+// Package logging sets up where the lochness daemons log to, so that their
+// structured json logs can go to a rotated file, the local syslog, or journald
+// without wrapper scripts, as well as to stdout.
+//
+// The json of each entry is the same wherever it goes. Entries sent to syslog
+// and journald are given the priority of their level, and the daemon's name as
+// their identifier.
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	logx "github.com/mistifyio/mistify-logrus-ext"
+)
+
+const (
+	// Stdout logs to stdout, the default
+	Stdout = "stdout"
+	// Stderr logs to stderr
+	Stderr = "stderr"
+	// Syslog logs to the local syslog daemon
+	Syslog = "syslog"
+	// Journald logs to the systemd journal
+	Journald = "journald"
+
+	// DefaultMaxSize is the size in megabytes a log file grows to before it
+	// is rotated, unless set otherwise
+	DefaultMaxSize = 100
+
+	// DefaultMaxBackups is the number of rotated log files kept unless set
+	// otherwise
+	DefaultMaxBackups = 5
+)
+
+// Config is where a daemon logs to
+type Config struct {
+	// Output is Stdout, Stderr, Syslog, Journald, or the path of a file.
+	// Empty is Stdout.
+	Output string
+	// MaxSize is the size in megabytes a log file grows to before it is
+	// rotated, 0 to never rotate it
+	MaxSize int
+	// MaxBackups is the number of rotated log files kept, as path.1 through
+	// path.N, newest first
+	MaxBackups int
+}
+
+// FlagSet is the flags of a daemon, either of github.com/ogier/pflag or
+// github.com/spf13/pflag
+type FlagSet interface {
+	StringVar(p *string, name, value, usage string)
+	IntVar(p *int, name string, value int, usage string)
+}
+
+// AddFlags adds the --log-output, --log-max-size, and --log-max-backups flags
+// setting cfg to a daemon's flags
+func AddFlags(fs FlagSet, cfg *Config) {
+	fs.StringVar(&cfg.Output, "log-output", Stdout, "where to log: stdout, stderr, syslog, journald, or the path of a file")
+	fs.IntVar(&cfg.MaxSize, "log-max-size", DefaultMaxSize, "megabytes a log file grows to before it is rotated, 0 to never rotate it")
+	fs.IntVar(&cfg.MaxBackups, "log-max-backups", DefaultMaxBackups, "number of rotated log files kept")
+}
+
+// Validate checks the sizes of a log file output
+func (c Config) Validate() error {
+	if c.MaxSize < 0 {
+		return errors.New("log max size must not be negative")
+	}
+	if c.MaxBackups < 0 {
+		return errors.New("log max backups must not be negative")
+	}
+	return nil
+}
+
+// Setup sets the level and json formatter of the standard logger, as
+// logx.DefaultSetup does, and sends its entries to the output of cfg. name
+// identifies the daemon in syslog and journald.
+func Setup(level, name string, cfg Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if err := logx.DefaultSetup(level); err != nil {
+		return err
+	}
+
+	switch output := strings.TrimSpace(cfg.Output); output {
+	case "", Stdout:
+		log.SetOutput(os.Stdout)
+	case Stderr:
+		log.SetOutput(os.Stderr)
+	case Syslog:
+		hook, err := newSyslogHook(name)
+		if err != nil {
+			return fmt.Errorf("failed to connect to syslog: %s", err)
+		}
+		log.AddHook(hook)
+		log.SetOutput(ioutil.Discard)
+	case Journald:
+		hook, err := newJournalHook(name)
+		if err != nil {
+			return fmt.Errorf("failed to connect to journald: %s", err)
+		}
+		log.AddHook(hook)
+		log.SetOutput(ioutil.Discard)
+	default:
+		file, err := OpenFile(output, cfg.MaxSize, cfg.MaxBackups)
+		if err != nil {
+			return err
+		}
+		log.SetOutput(file)
+	}
+	return nil
+}
+
+// format formats an entry with its logger's formatter, without the trailing
+// newline
+func format(entry *log.Entry) ([]byte, error) {
+	line, err := entry.Logger.Formatter.Format(entry)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(strings.TrimRight(string(line), "\n")), nil
+}
@@ -0,0 +1,129 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestLogging(t *testing.T) {
+	suite.Run(t, new(LoggingSuite))
+}
+
+type LoggingSuite struct {
+	suite.Suite
+	Dir string
+}
+
+func (s *LoggingSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "logging-test-")
+	s.Require().NoError(err)
+	s.Dir = dir
+}
+
+func (s *LoggingSuite) TearDownTest() {
+	_ = os.RemoveAll(s.Dir)
+}
+
+func (s *LoggingSuite) TestValidate() {
+	s.NoError(Config{}.Validate())
+	s.NoError(Config{Output: "/var/log/cguestd.log", MaxSize: 10, MaxBackups: 3}.Validate())
+	s.Error(Config{MaxSize: -1}.Validate())
+	s.Error(Config{MaxBackups: -1}.Validate())
+}
+
+func (s *LoggingSuite) TestFileRotation() {
+	path := filepath.Join(s.Dir, "daemon.log")
+	f, err := OpenFile(path, 1, 2)
+	s.Require().NoError(err)
+	defer func() { _ = f.Close() }()
+
+	chunk := []byte(strings.Repeat("a", 600*1024-1) + "\n")
+	for i := 0; i < 4; i++ {
+		chunk[0] = byte('0' + i)
+		n, err := f.Write(chunk)
+		s.NoError(err)
+		s.Equal(len(chunk), n)
+	}
+
+	// Each file holds a single chunk, and the oldest chunk has been removed
+	firsts := map[string]byte{
+		path:                '3',
+		backupName(path, 1): '2',
+		backupName(path, 2): '1',
+	}
+	for name, first := range firsts {
+		data, err := ioutil.ReadFile(name)
+		s.Require().NoError(err, name)
+		s.Len(data, len(chunk), name)
+		s.Equal(first, data[0], name)
+	}
+	_, err = os.Stat(backupName(path, 3))
+	s.True(os.IsNotExist(err))
+}
+
+func (s *LoggingSuite) TestFileAppends() {
+	path := filepath.Join(s.Dir, "daemon.log")
+	s.Require().NoError(ioutil.WriteFile(path, []byte("old\n"), 0644))
+
+	f, err := OpenFile(path, 0, 0)
+	s.Require().NoError(err)
+	_, err = f.Write([]byte("new\n"))
+	s.NoError(err)
+	s.NoError(f.Close())
+
+	data, _ := ioutil.ReadFile(path)
+	s.Equal("old\nnew\n", string(data))
+}
+
+func (s *LoggingSuite) TestJournalHook() {
+	socket := filepath.Join(s.Dir, "journal.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	s.Require().NoError(err)
+	defer func() { _ = conn.Close() }()
+
+	defer func(orig string) { journalSocket = orig }(journalSocket)
+	journalSocket = socket
+
+	hook, err := newJournalHook("cguestd")
+	s.Require().NoError(err)
+
+	logger := log.New()
+	logger.Formatter = &log.JSONFormatter{}
+	entry := log.NewEntry(logger).WithField("guest", "1234")
+	entry.Level = log.WarnLevel
+	entry.Message = "slow"
+	s.Require().NoError(hook.Fire(entry))
+
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	s.Require().NoError(err)
+	msg := string(buf[:n])
+	s.Contains(msg, "PRIORITY=4\n")
+	s.Contains(msg, "SYSLOG_IDENTIFIER=cguestd\n")
+	s.Contains(msg, "MESSAGE={")
+	s.Contains(msg, `"guest":"1234"`)
+	s.True(strings.HasSuffix(msg, "}\n"))
+}
+
+func (s *LoggingSuite) TestWriteJournalField() {
+	var buf bytes.Buffer
+	writeJournalField(&buf, "MESSAGE", []byte("one line"))
+	s.Equal("MESSAGE=one line\n", buf.String())
+
+	buf.Reset()
+	writeJournalField(&buf, "MESSAGE", []byte("two\nlines"))
+	expected := &bytes.Buffer{}
+	expected.WriteString("MESSAGE\n")
+	_ = binary.Write(expected, binary.LittleEndian, uint64(9))
+	expected.WriteString("two\nlines\n")
+	s.Equal(expected.Bytes(), buf.Bytes())
+}
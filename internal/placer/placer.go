@@ -0,0 +1,210 @@
+// Package placer selects hypervisors for the guests of the create jobs queued
+// in beanstalk and hands the jobs to the workers. It is run by cplacerd and
+// lochnessd.
+package placer
+
+// TODO: multiple beanstalkd servers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/armon/go-metrics"
+	"github.com/kr/beanstalk"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tracing"
+	"github.com/mistifyio/lochness/pkg/jobqueue"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TaskFunc is a convenience wrapper for function calls on tasks
+type TaskFunc struct {
+	function func(*jobqueue.Client, *jobqueue.Task) (bool, error)
+	label    string // label for metrics
+}
+
+// XXX: we want to try to keep track of where a job is
+// in this pipeline? would have to persist in the job
+var steps = []TaskFunc{
+	{function: checkJobStatus, label: "checkJobStatus"},
+	{function: checkGuestStatus, label: "checkGuestStatus"},
+	{function: selectHypervisor, label: "selectHypervisor"},
+	{function: changeJobAction, label: "changeJobAction"},
+	{function: addJobToWorker, label: "addJobToWorker"},
+	{function: deleteTask, label: "deleteTask"},
+}
+
+// TODO: restructure this as all the deletes for tube stuff is clunky.
+// as we almost always delete the tube id, wrap in function and delete it?
+
+// Run places the guests of create jobs as they are queued, recording metrics
+// in m. It does not return.
+func Run(jobQueue *jobqueue.Client, m *metrics.Metrics) {
+	for {
+		task, err := jobQueue.NextCreateTask()
+		if err != nil {
+			if bCE, ok := err.(beanstalk.ConnError); ok {
+				switch bCE {
+				case beanstalk.ErrTimeout:
+					// Empty queue, continue waiting
+					continue
+				case beanstalk.ErrDeadline:
+					// See docs on beanstalkd deadline
+					// We're just going to sleep to let the deadline'd job expire
+					// and try to get another job
+					m.IncrCounter([]string{"beanstalk", "error", "deadline"}, 1)
+					log.Debug(beanstalk.ErrDeadline)
+					time.Sleep(5 * time.Second)
+					continue
+				default:
+					// You have failed me for the last time
+					log.WithField("error", err).Fatal(err)
+				}
+			}
+			log.WithFields(log.Fields{
+				"task":  task,
+				"error": err,
+			}).Error("invalid task")
+
+			if err := task.Delete(); err != nil {
+				log.WithFields(log.Fields{
+					"task":  task.ID,
+					"error": err,
+				}).Error("unable to delete")
+			}
+		}
+
+		// Placing the guest is a span of the trace of the request that
+		// queued its job
+		_, span := tracing.Start(task.Job.Context(context.Background()), "place guest", trace.SpanKindConsumer)
+		span.SetAttributes(
+			attribute.String("job.id", task.Job.ID),
+			attribute.String("guest", task.Job.Guest),
+		)
+
+		for _, step := range steps {
+
+			fields := log.Fields{
+				"task":        task,
+				"step":        step.label,
+				tracing.Field: task.Job.TraceID,
+			}
+
+			log.WithFields(fields).Debug("running")
+
+			start := time.Now()
+			rm, err := step.function(jobQueue, task)
+
+			m.MeasureSince([]string{step.label, "time"}, start)
+			m.IncrCounter([]string{step.label, "count"}, 1)
+
+			duration := int(time.Since(start).Seconds() * 1000)
+			log.WithFields(fields).WithField("duration", duration).Info("done")
+
+			if err != nil {
+
+				m.IncrCounter([]string{step.label, "error"}, 1)
+
+				log.WithFields(fields).WithField("error", err).Error("task error")
+				span.SetStatus(codes.Error, err.Error())
+
+				task.Job.Status = jobqueue.JobStatusError
+				task.Job.Error = err.Error()
+				if err := task.Job.Save(24 * time.Hour); err != nil {
+					log.WithFields(log.Fields{
+						"task":  task,
+						"error": err,
+					}).Error("unable to save")
+				}
+			}
+
+			if rm {
+				if _, err = deleteTask(nil, task); err != nil {
+					log.WithFields(log.Fields{
+						"task":  task.ID,
+						"error": err,
+					}).Error("unable to delete")
+				}
+				break
+			}
+		}
+		span.End()
+	}
+}
+
+// these funcs return bool if task in beanstalk should be deleted (and loop stopped). loop also stops on error
+
+func checkJobStatus(jobQueue *jobqueue.Client, t *jobqueue.Task) (bool, error) {
+	if t.Job.Status != jobqueue.JobStatusNew {
+		return true, fmt.Errorf("bad job status: %s", t.Job.Status)
+	}
+	if t.Job.Action != "select-hypervisor" {
+		return true, fmt.Errorf("bad action: %s", t.Job.Action)
+	}
+	return false, nil
+}
+
+func checkGuestStatus(jobQueue *jobqueue.Client, t *jobqueue.Task) (bool, error) {
+
+	if t.Guest.HypervisorID != "" {
+		return true, fmt.Errorf("guest already has a hypervisor %s - %s", t.Guest.ID, t.Guest.HypervisorID)
+	}
+	return false, nil
+}
+
+func selectHypervisor(jobQueue *jobqueue.Client, t *jobqueue.Task) (bool, error) {
+	candidates, err := t.Guest.Candidates(lochness.DefaultCandidateFunctions...)
+	if err != nil {
+		return true, fmt.Errorf("unable to select candidate %s - %s", t.Guest.ID, err)
+	}
+
+	if len(candidates) == 0 {
+		return true, fmt.Errorf("no candidates found for %s", t.Guest.ID)
+	}
+
+	h := candidates[0]
+
+	// the API for selecting a candidate and then adding to a hypervisor is clunky
+	if err := h.AddGuest(t.Guest); err != nil {
+		return true, fmt.Errorf("unable to add guest %s to %s - %s", t.Guest.ID, h.ID, err)
+	}
+
+	// the guest's work is queued in the job queue of its hypervisor
+	queue, err := h.JobQueue()
+	if err != nil {
+		return true, fmt.Errorf("unable to get job queue of %s - %s", h.ID, err)
+	}
+	t.Job.Queue = queue
+
+	return false, nil
+}
+
+func changeJobAction(jobQueue *jobqueue.Client, t *jobqueue.Task) (bool, error) {
+	t.Job.Action = "fetch"
+	if err := t.Job.Save(24 * time.Hour); err != nil {
+		return true, fmt.Errorf("unable to change job action - %s", err)
+	}
+	return false, nil
+}
+
+func addJobToWorker(jobQueue *jobqueue.Client, t *jobqueue.Task) (bool, error) {
+	id, err := jobQueue.AddTask(t.Job)
+	if err != nil {
+		return true, fmt.Errorf("unable to put to work queue %s", err)
+	}
+
+	log.WithFields(log.Fields{
+		"task": id,
+		"job":  t.Job.ID,
+	}).Debug("added job to work queue")
+
+	return false, nil
+}
+
+func deleteTask(jobQueue *jobqueue.Client, t *jobqueue.Task) (bool, error) {
+	return false, t.Delete()
+}
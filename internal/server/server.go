@@ -0,0 +1,139 @@
+// Package server runs the http servers of the lochness daemons and shuts them
+// down gracefully: on SIGINT or SIGTERM a server stops accepting connections,
+// waits for its in-flight requests up to a timeout, closes what is left, and
+// the logs are flushed before the daemon exits.
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// DefaultTimeout is how long a server waits for in-flight requests when it is
+// shut down
+const DefaultTimeout = 5 * time.Second
+
+// Server is an http server that can be stopped gracefully
+type Server struct {
+	*http.Server
+
+	// Timeout is how long Wait gives in-flight requests to finish
+	Timeout time.Duration
+
+	stopOnce sync.Once
+	stopChan chan struct{}
+}
+
+// New creates a Server for a handler, serving https if tlsConfig is not nil
+func New(addr string, handler http.Handler, tlsConfig *tls.Config) *Server {
+	return &Server{
+		Server: &http.Server{
+			Addr:           addr,
+			Handler:        handler,
+			MaxHeaderBytes: 1 << 20,
+			TLSConfig:      tlsConfig,
+		},
+		Timeout:  DefaultTimeout,
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Start serves in the background. Errors other than the server being shut
+// down are fatal.
+func (s *Server) Start() {
+	go func() {
+		serve := s.ListenAndServe
+		if s.TLSConfig != nil {
+			serve = func() error {
+				// the certificate comes from the tls config
+				return s.ListenAndServeTLS("", "")
+			}
+		}
+		if err := serve(); err != nil && err != http.ErrServerClosed {
+			log.WithFields(log.Fields{
+				"error": err,
+				"addr":  s.Addr,
+			}).Fatal("server error")
+		}
+	}()
+}
+
+// Shutdown stops accepting connections and waits for in-flight requests until
+// ctx is done, after which the remaining connections are closed. StopChan is
+// closed once the server has stopped.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.Server.Shutdown(ctx)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"addr":  s.Addr,
+		}).Warn("requests still in flight at shutdown; closing connections")
+		_ = s.Server.Close()
+	}
+	s.stopOnce.Do(func() { close(s.stopChan) })
+	return err
+}
+
+// Stop shuts the server down, giving in-flight requests up to timeout to
+// finish
+func (s *Server) Stop(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	_ = s.Shutdown(ctx)
+}
+
+// StopChan returns a channel that is closed once the server has stopped
+func (s *Server) StopChan() <-chan struct{} {
+	return s.stopChan
+}
+
+// SignalContext returns a context that is canceled on SIGINT or SIGTERM
+func SignalContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// Wait blocks until ctx is done or a server stops on its own, then stops the
+// servers, each within its Timeout, and flushes the logs
+func Wait(ctx context.Context, servers ...*Server) {
+	stopped := make(chan struct{}, len(servers))
+	for _, s := range servers {
+		go func(s *Server) {
+			<-s.StopChan()
+			stopped <- struct{}{}
+		}(s)
+	}
+
+	select {
+	case <-ctx.Done():
+		log.Info("shutting down")
+	case <-stopped:
+	}
+
+	var wg sync.WaitGroup
+	for _, s := range servers {
+		wg.Add(1)
+		go func(s *Server) {
+			defer wg.Done()
+			s.Stop(s.Timeout)
+		}(s)
+	}
+	wg.Wait()
+
+	FlushLogs()
+}
+
+// FlushLogs syncs the log output, if it is a file, so nothing logged is lost
+// when the daemon exits
+func FlushLogs() {
+	if f, ok := log.StandardLogger().Out.(*os.File); ok {
+		_ = f.Sync()
+	}
+}
@@ -0,0 +1,124 @@
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness/internal/server"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestServer(t *testing.T) {
+	suite.Run(t, new(ServerSuite))
+}
+
+type ServerSuite struct {
+	suite.Suite
+	Addr    string
+	Server  *server.Server
+	Release chan struct{}
+	Started chan struct{}
+}
+
+func (s *ServerSuite) SetupSuite() {
+	log.SetLevel(log.FatalLevel)
+}
+
+func (s *ServerSuite) SetupTest() {
+	s.Addr = fmt.Sprintf("127.0.0.1:%d", 20000+rand.Intn(10000))
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	s.Release, s.Started = release, started
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		_, _ = w.Write([]byte("done"))
+	})
+	s.Server = server.New(s.Addr, handler, nil)
+	s.Server.Start()
+	time.Sleep(100 * time.Millisecond)
+}
+
+// get makes a request in the background, sending its body or error
+func (s *ServerSuite) get() chan interface{} {
+	result := make(chan interface{}, 1)
+	go func() {
+		resp, err := http.Get("http://" + s.Addr + "/")
+		if err != nil {
+			result <- err
+			return
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			result <- err
+			return
+		}
+		result <- string(body)
+	}()
+	<-s.Started
+	return result
+}
+
+func (s *ServerSuite) TestStopDrains() {
+	result := s.get()
+
+	stopped := make(chan struct{})
+	go func() {
+		s.Server.Stop(5 * time.Second)
+		close(stopped)
+	}()
+
+	// the in-flight request holds up the shutdown, but no new ones are taken
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case <-stopped:
+		s.Fail("stopped with a request in flight")
+	default:
+	}
+	_, err := http.Get("http://" + s.Addr + "/")
+	s.Error(err)
+
+	close(s.Release)
+	s.Equal("done", <-result)
+	<-stopped
+	<-s.Server.StopChan()
+}
+
+func (s *ServerSuite) TestStopTimeout() {
+	result := s.get()
+
+	start := time.Now()
+	s.Server.Stop(100 * time.Millisecond)
+	s.WithinDuration(start.Add(100*time.Millisecond), time.Now(), time.Second)
+	<-s.Server.StopChan()
+
+	_, ok := (<-result).(error)
+	s.True(ok, "expected the request to be cut off")
+	close(s.Release)
+}
+
+func (s *ServerSuite) TestWait() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		server.Wait(ctx, s.Server)
+		close(done)
+	}()
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		s.Fail("wait did not return")
+	}
+	<-s.Server.StopChan()
+	close(s.Release)
+}
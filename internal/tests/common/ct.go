@@ -14,12 +14,14 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/mistifyio/lochness"
 	"github.com/mistifyio/lochness/pkg/kv"
 	_ "github.com/mistifyio/lochness/pkg/kv/consul"
+	_ "github.com/mistifyio/lochness/pkg/kv/mem"
 	"github.com/pborman/uuid"
 	"github.com/stretchr/testify/suite"
 )
@@ -28,6 +30,9 @@ func init() {
 	rand.Seed(time.Now().UnixNano())
 }
 
+// SecretKey is the secret key test secrets are encrypted with
+var SecretKey = bytes.Repeat([]byte{0x42}, lochness.SecretKeySize)
+
 // ConsulMaker will create an exec.Cmd to run consul with the given paramaters
 func ConsulMaker(port uint16, dir, prefix string) *exec.Cmd {
 	b, err := json.Marshal(map[string]interface{}{
@@ -89,6 +94,10 @@ type Suite struct {
 	KVCmdMaker func(uint16, string, string) *exec.Cmd
 	TestPrefix string
 	Context    *lochness.Context
+	// ExternalKV runs a real kv process instead of the in-memory kv, for
+	// suites that share the kv with other processes. It is implied by
+	// KVCmdMaker or by setting the KV environment variable.
+	ExternalKV bool
 }
 
 // SetupSuite runs a new kv instance.
@@ -96,6 +105,12 @@ func (s *Suite) SetupSuite() {
 	if s.TestPrefix == "" {
 		s.TestPrefix = "lochness-test"
 	}
+	s.KVPrefix = "lochness"
+
+	if !s.ExternalKV && s.KVCmdMaker == nil && os.Getenv("KV") == "" {
+		s.setupMemKV()
+		return
+	}
 
 	s.KVDir, _ = ioutil.TempDir("", s.TestPrefix+"-"+uuid.New())
 
@@ -128,10 +143,18 @@ func (s *Suite) SetupSuite() {
 	}
 
 	s.Context = lochness.NewContext(s.KV)
-	s.KVPrefix = "lochness"
 	s.KVURL = "http://127.0.0.1:" + strconv.Itoa(int(s.KVPort))
 }
 
+// setupMemKV uses a private in-memory kv, which needs no process.
+func (s *Suite) setupMemKV() {
+	s.KVURL = "mem://" + s.TestPrefix + "-" + uuid.New()
+	var err error
+	s.KV, err = kv.New(s.KVURL)
+	s.Require().NoError(err)
+	s.Context = lochness.NewContext(s.KV)
+}
+
 // SetupTest prepares anything needed per test.
 func (s *Suite) SetupTest() {
 }
@@ -143,6 +166,10 @@ func (s *Suite) TearDownTest() {
 
 // TearDownSuite stops the kv instance and removes all data.
 func (s *Suite) TearDownSuite() {
+	if s.KVCmd == nil {
+		return
+	}
+
 	// Stop the test kv process
 	s.Require().NoError(s.KVCmd.Process.Kill())
 	s.Require().Error(s.KVCmd.Wait())
@@ -240,6 +267,48 @@ func (s *Suite) NewGuest() *lochness.Guest {
 	return guest
 }
 
+// NewSchedule creates and saves a new daily reboot Schedule for a new Guest.
+func (s *Suite) NewSchedule() *lochness.Schedule {
+	sched := s.Context.NewSchedule()
+	sched.GuestID = s.NewGuest().ID
+	sched.Action = "reboot"
+	sched.Cron = "@daily"
+	s.NoError(sched.Save())
+	return sched
+}
+
+// NewWebhook creates and saves a new Webhook for all events.
+func (s *Suite) NewWebhook() *lochness.Webhook {
+	webhook := s.Context.NewWebhook()
+	webhook.URL = "http://localhost:9/hook"
+	s.NoError(webhook.Save())
+	return webhook
+}
+
+// NewImage creates and saves a new Image.
+func (s *Suite) NewImage() *lochness.Image {
+	image := s.Context.NewImage()
+	image.Name = "ubuntu"
+	image.Source = "http://localhost:9/ubuntu.img"
+	image.Checksum = "sha256:" + strings.Repeat("ab", 32)
+	image.MinDisk = 512
+	image.MinMemory = 64
+	s.NoError(image.Save())
+	return image
+}
+
+// NewSecret creates and saves a new Secret, encrypted with SecretKey.
+func (s *Suite) NewSecret() *lochness.Secret {
+	ctx, err := s.Context.WithSecretKey(SecretKey)
+	s.Require().NoError(err)
+	secret := ctx.NewSecret()
+	secret.Name = "agent"
+	secret.Kind = lochness.SecretAgentToken
+	s.NoError(secret.SetValue([]byte("hunter2")))
+	s.NoError(secret.Save())
+	return secret
+}
+
 // NewHypervisorWithGuest creates and saves a new Hypervisor and Guest, with the Guest added to the Hypervisor.
 func (s *Suite) NewHypervisorWithGuest() (*lochness.Hypervisor, *lochness.Guest) {
 	guest := s.NewGuest()
@@ -0,0 +1,175 @@
+// Package tlsutil provides the TLS setup shared by the lochness api daemons and
+// their clients: serving HTTPS and HTTP/2 with certificates that may be rotated
+// on disk, and verifying or pinning server certificates.
+package tlsutil
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// pinPrefix is the optional prefix of a pin, as used by curl's --pinnedpubkey
+const pinPrefix = "sha256//"
+
+// ServerConfig creates a tls.Config serving the certificate and key in the
+// files given, with HTTP/2 enabled. With a reload interval, the files are
+// checked for changes at most that often, during handshakes, and a rotated
+// certificate is served once both files load. Zero disables reloading.
+func ServerConfig(certFile, keyFile string, reload time.Duration) (*tls.Config, error) {
+	l := &certLoader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		interval: reload,
+	}
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+	l.checked = time.Now()
+
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     []string{"h2", "http/1.1"},
+		GetCertificate: l.getCertificate,
+	}, nil
+}
+
+// ClientConfig creates a tls.Config verifying servers against the CA
+// certificates in caFile, or the system's if it is empty. With a pin, the
+// server certificate's public key must match it instead, so that self-signed
+// certificates can be used. See PublicKeyPin.
+func ClientConfig(caFile, pin string) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if pin != "" {
+		pin = strings.TrimPrefix(pin, pinPrefix)
+		if _, err := base64.StdEncoding.DecodeString(pin); err != nil {
+			return nil, fmt.Errorf("invalid pin: %s", err)
+		}
+		// The pin replaces chain verification, and is checked instead
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("no server certificate")
+			}
+			cert, err := x509.ParseCertificate(rawCerts[0])
+			if err != nil {
+				return err
+			}
+			if PublicKeyPin(cert) != pin {
+				return errors.New("server certificate does not match pin")
+			}
+			return nil
+		}
+	}
+
+	return config, nil
+}
+
+// PublicKeyPin returns the pin of a certificate, the base64 encoded sha256 of
+// its public key. It is what is printed by:
+//
+//	openssl x509 -in cert.pem -pubkey -noout | openssl pkey -pubin -outform der | openssl dgst -sha256 -binary | base64
+func PublicKeyPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// certLoader loads a certificate and key, reloading them when they change
+type certLoader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time // latest modification of the files loaded
+	checked time.Time // last check for changes
+}
+
+// load loads the certificate and key
+func (l *certLoader) load() error {
+	modTime, err := l.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(l.certFile, l.keyFile)
+	if err != nil {
+		return err
+	}
+	l.cert = &cert
+	l.modTime = modTime
+	return nil
+}
+
+// latestModTime returns the time the certificate or key was last modified
+func (l *certLoader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{l.certFile, l.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// getCertificate returns the certificate to serve, reloading it first if it is
+// time to check and the files have changed. A certificate that fails to load,
+// e.g. because only one of the files has been replaced yet, is retried at the
+// next check, and the current one is served meanwhile.
+func (l *certLoader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.interval <= 0 || time.Since(l.checked) < l.interval {
+		return l.cert, nil
+	}
+	l.checked = time.Now()
+
+	fields := log.Fields{
+		"cert": l.certFile,
+		"key":  l.keyFile,
+	}
+	modTime, err := l.latestModTime()
+	if err != nil {
+		log.WithFields(fields).WithField("error", err).Warn("failed to check certificate for changes")
+		return l.cert, nil
+	}
+	if !modTime.After(l.modTime) {
+		return l.cert, nil
+	}
+	if err := l.load(); err != nil {
+		log.WithFields(fields).WithField("error", err).Warn("failed to reload certificate, keeping the current one")
+		return l.cert, nil
+	}
+	log.WithFields(fields).Info("reloaded certificate")
+	return l.cert, nil
+}
@@ -0,0 +1,183 @@
+package tlsutil_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness/internal/tlsutil"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestTLSUtil(t *testing.T) {
+	suite.Run(t, new(TLSUtilSuite))
+}
+
+type TLSUtilSuite struct {
+	suite.Suite
+	Dir      string
+	CertFile string
+	KeyFile  string
+	Cert     *x509.Certificate
+}
+
+func (s *TLSUtilSuite) SetupSuite() {
+	log.SetLevel(log.FatalLevel)
+}
+
+func (s *TLSUtilSuite) SetupTest() {
+	var err error
+	s.Dir, err = ioutil.TempDir("", "tlsutil-")
+	s.Require().NoError(err)
+	s.CertFile = filepath.Join(s.Dir, "cert.pem")
+	s.KeyFile = filepath.Join(s.Dir, "key.pem")
+	s.Cert = s.writeCert("localhost")
+}
+
+func (s *TLSUtilSuite) TearDownTest() {
+	_ = os.RemoveAll(s.Dir)
+}
+
+// writeCert writes a new self-signed certificate for 127.0.0.1 and its key
+func (s *TLSUtilSuite) writeCert(name string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	s.Require().NoError(err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	s.Require().NoError(err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	s.Require().NoError(err)
+
+	s.Require().NoError(ioutil.WriteFile(s.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	s.Require().NoError(ioutil.WriteFile(s.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+
+	cert, err := x509.ParseCertificate(der)
+	s.Require().NoError(err)
+	return cert
+}
+
+// serve serves https with the config, returning the address
+func (s *TLSUtilSuite) serve(config *tls.Config) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	server := &http.Server{
+		Handler:   http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		TLSConfig: config,
+	}
+	go func() { _ = server.Serve(tls.NewListener(l, config)) }()
+	return "https://" + l.Addr().String()
+}
+
+// get makes a request with the client config, returning the response
+func (s *TLSUtilSuite) get(url string, config *tls.Config) (*http.Response, error) {
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:   config,
+			ForceAttemptHTTP2: true,
+		},
+	}
+	resp, err := client.Get(url)
+	if err == nil {
+		_ = resp.Body.Close()
+	}
+	return resp, err
+}
+
+func (s *TLSUtilSuite) TestServerConfig() {
+	_, err := tlsutil.ServerConfig(filepath.Join(s.Dir, "missing.pem"), s.KeyFile, 0)
+	s.Error(err, "missing certificate should fail")
+
+	config, err := tlsutil.ServerConfig(s.CertFile, s.KeyFile, 0)
+	s.Require().NoError(err)
+	url := s.serve(config)
+
+	client, err := tlsutil.ClientConfig(s.CertFile, "")
+	s.Require().NoError(err)
+	resp, err := s.get(url, client)
+	s.Require().NoError(err)
+	s.Equal(2, resp.ProtoMajor, "should use http/2")
+
+	client, err = tlsutil.ClientConfig("", "")
+	s.Require().NoError(err)
+	_, err = s.get(url, client)
+	s.Error(err, "unknown CA should fail verification")
+}
+
+func (s *TLSUtilSuite) TestServerConfigReload() {
+	config, err := tlsutil.ServerConfig(s.CertFile, s.KeyFile, time.Millisecond)
+	s.Require().NoError(err)
+	url := s.serve(config)
+
+	client, err := tlsutil.ClientConfig("", tlsutil.PublicKeyPin(s.Cert))
+	s.Require().NoError(err)
+	_, err = s.get(url, client)
+	s.Require().NoError(err)
+
+	rotated := s.writeCert("rotated")
+	future := time.Now().Add(time.Minute)
+	s.Require().NoError(os.Chtimes(s.CertFile, future, future))
+	time.Sleep(10 * time.Millisecond)
+
+	_, err = s.get(url, client)
+	s.Error(err, "old certificate should no longer be served")
+	client, err = tlsutil.ClientConfig("", tlsutil.PublicKeyPin(rotated))
+	s.Require().NoError(err)
+	_, err = s.get(url, client)
+	s.NoError(err, "rotated certificate should be served")
+}
+
+func (s *TLSUtilSuite) TestClientConfigPin() {
+	config, err := tlsutil.ServerConfig(s.CertFile, s.KeyFile, 0)
+	s.Require().NoError(err)
+	url := s.serve(config)
+
+	tests := []struct {
+		description string
+		pin         string
+		expectedErr bool
+	}{
+		{"pin", tlsutil.PublicKeyPin(s.Cert), false},
+		{"curl style pin", "sha256//" + tlsutil.PublicKeyPin(s.Cert), false},
+		{"wrong pin", "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=", true},
+	}
+
+	for _, test := range tests {
+		client, err := tlsutil.ClientConfig("", test.pin)
+		s.Require().NoError(err, test.description)
+		_, err = s.get(url, client)
+		if test.expectedErr {
+			s.Error(err, test.description)
+		} else {
+			s.NoError(err, test.description)
+		}
+	}
+
+	_, err = tlsutil.ClientConfig("", "not base64!")
+	s.Error(err, "invalid pin should fail")
+	_, err = tlsutil.ClientConfig(filepath.Join(s.Dir, "missing.pem"), "")
+	s.Error(err, "missing CA file should fail")
+}
@@ -0,0 +1,91 @@
+// Package tracing carries the trace of an api request through the jobs it
+// queues, the placer and workers handling them, and the calls made to the
+// hypervisor agents, so that a failed guest create can be followed from end to
+// end.
+//
+// A trace has an id, the request id of the api request it started with, which
+// is logged by every step, and, when OpenTelemetry tracing is set up, see
+// httpmw.SetupTracing, the W3C trace context of the span it continues.
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// Header is the header carrying the id of a trace on the calls to the
+	// agents
+	Header = "X-Trace-ID"
+
+	// Field is the log field of the id of a trace
+	Field = "trace_id"
+
+	// tracerName names the tracer of the spans started by Start
+	tracerName = "github.com/mistifyio/lochness"
+)
+
+type key int
+
+const idKey key = 0
+
+// propagator carries the W3C trace context whether or not the global
+// propagator has been set
+var propagator = propagation.TraceContext{}
+
+// WithID returns a copy of ctx carrying the id of a trace. An empty id leaves
+// ctx as it is.
+func WithID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, idKey, id)
+}
+
+// ID returns the id of the trace ctx carries, "" if none
+func ID(ctx context.Context) string {
+	id, _ := ctx.Value(idKey).(string)
+	return id
+}
+
+// Parent returns the W3C traceparent of the span ctx carries, "" if none, for
+// the span to be continued elsewhere with Continue
+func Parent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// Continue returns a copy of ctx carrying the trace of id and, if not empty,
+// the span of traceparent, as returned by Parent
+func Continue(ctx context.Context, id, traceparent string) context.Context {
+	ctx = WithID(ctx, id)
+	if traceparent == "" {
+		return ctx
+	}
+	return propagator.Extract(ctx, propagation.MapCarrier{"traceparent": traceparent})
+}
+
+// Start starts a span of the trace ctx carries, through the global
+// OpenTelemetry tracer provider, tagged with the id of the trace. The span
+// does nothing unless tracing has been set up.
+func Start(ctx context.Context, name string, kind trace.SpanKind) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name,
+		trace.WithSpanKind(kind),
+		trace.WithAttributes(attribute.String(Field, ID(ctx))),
+	)
+}
+
+// SetHeaders sets the id and W3C trace context of the trace ctx carries on the
+// headers of an outgoing request
+func SetHeaders(ctx context.Context, header http.Header) {
+	if id := ID(ctx); id != "" {
+		header.Set(Header, id)
+	}
+	propagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
@@ -0,0 +1,63 @@
+package tracing_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/mistifyio/lochness/internal/tracing"
+	"github.com/stretchr/testify/suite"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracing(t *testing.T) {
+	suite.Run(t, new(TracingSuite))
+}
+
+type TracingSuite struct {
+	suite.Suite
+}
+
+func (s *TracingSuite) TestID() {
+	ctx := context.Background()
+	s.Empty(tracing.ID(ctx))
+	s.Equal(ctx, tracing.WithID(ctx, ""), "an empty id should leave the context as it is")
+	s.Equal("request-1234", tracing.ID(tracing.WithID(ctx, "request-1234")))
+}
+
+func (s *TracingSuite) TestParentContinue() {
+	s.Empty(tracing.Parent(context.Background()), "no span should have no traceparent")
+
+	provider := sdktrace.NewTracerProvider()
+	defer func() { _ = provider.Shutdown(context.Background()) }()
+	ctx, span := provider.Tracer("test").Start(context.Background(), "request")
+	defer span.End()
+
+	parent := tracing.Parent(ctx)
+	s.NotEmpty(parent)
+
+	continued := tracing.Continue(context.Background(), "request-1234", parent)
+	s.Equal("request-1234", tracing.ID(continued))
+	remote := trace.SpanContextFromContext(continued)
+	s.True(remote.IsRemote())
+	s.Equal(span.SpanContext().TraceID(), remote.TraceID())
+	s.Equal(span.SpanContext().SpanID(), remote.SpanID())
+
+	s.Equal("request-1234", tracing.ID(tracing.Continue(context.Background(), "request-1234", "")))
+}
+
+func (s *TracingSuite) TestSetHeaders() {
+	header := http.Header{}
+	tracing.SetHeaders(context.Background(), header)
+	s.Empty(header)
+
+	provider := sdktrace.NewTracerProvider()
+	defer func() { _ = provider.Shutdown(context.Background()) }()
+	ctx, span := provider.Tracer("test").Start(tracing.WithID(context.Background(), "request-1234"), "job")
+	defer span.End()
+
+	tracing.SetHeaders(ctx, header)
+	s.Equal("request-1234", header.Get(tracing.Header))
+	s.Equal(tracing.Parent(ctx), header.Get("traceparent"))
+}
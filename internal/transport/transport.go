@@ -0,0 +1,118 @@
+// Package transport builds the http transports of the lochness clients, the
+// clis' and the hypervisor agent client of the daemons, so that servers can be
+// reached through proxies and with dial timeouts of their own.
+//
+// Unless a proxy is given, the HTTP_PROXY, HTTPS_PROXY, and NO_PROXY
+// environment variables apply, as for any go program.
+package transport
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultDialTimeout is how long connecting to a server or proxy may take
+	// unless set otherwise
+	DefaultDialTimeout = 30 * time.Second
+
+	// NoProxy, given as the proxy, connects to servers directly, ignoring the
+	// proxy environment variables
+	NoProxy = "none"
+)
+
+// Config is how a client connects to servers
+type Config struct {
+	// Proxy is the url of the proxy to connect through, http, https, or
+	// socks5, with http assumed if it has no scheme. If empty, the proxy
+	// environment variables apply. See NoProxy.
+	Proxy string
+	// DialTimeout is how long connecting may take, DefaultDialTimeout if zero
+	DialTimeout time.Duration
+	// TLS verifies https servers, with the defaults if nil
+	TLS *tls.Config
+}
+
+// ParseProxy parses the url of a proxy, assuming http if it has no scheme, e.g.
+// "bastion:3128"
+func ParseProxy(proxy string) (*url.URL, error) {
+	if !strings.Contains(proxy, "://") {
+		proxy = "http://" + proxy
+	}
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("proxy has no host")
+	}
+	return u, nil
+}
+
+// Validate checks the proxy and dial timeout
+func (c Config) Validate() error {
+	if _, err := c.proxyFunc(); err != nil {
+		return err
+	}
+	if c.DialTimeout < 0 {
+		return errors.New("dial timeout must not be negative")
+	}
+	return nil
+}
+
+// proxyFunc returns the function choosing the proxy of each request, nil to
+// connect directly
+func (c Config) proxyFunc() (func(*http.Request) (*url.URL, error), error) {
+	switch c.Proxy {
+	case "":
+		return http.ProxyFromEnvironment, nil
+	case NoProxy:
+		return nil, nil
+	}
+	u, err := ParseProxy(c.Proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy: %s", err)
+	}
+	return http.ProxyURL(u), nil
+}
+
+// Transport creates an http.Transport connecting as configured, with the
+// settings of http.DefaultTransport otherwise
+func (c Config) Transport() (*http.Transport, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	// validated
+	proxy, _ := c.proxyFunc()
+
+	timeout := c.DialTimeout
+	if timeout == 0 {
+		timeout = DefaultDialTimeout
+	}
+	dialer := &net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: 30 * time.Second,
+	}
+
+	return &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       c.TLS,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}, nil
+}
@@ -0,0 +1,263 @@
+// Package tunnel carries raw byte streams, such as guest consoles, over http
+// connections upgraded to the "tcp" protocol, so that they go through the same
+// ports, proxies, and middleware as the apis.
+package tunnel
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Protocol is the protocol connections are upgraded to
+const Protocol = "tcp"
+
+// StatusError is returned by Dial when the server refuses the upgrade
+type StatusError struct {
+	Code int
+	Body []byte
+}
+
+// Error returns a string error message
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("upgrade refused: %s: %s", http.StatusText(e.Code), strings.TrimSpace(string(e.Body)))
+}
+
+// conn is a net.Conn that first reads what was buffered while the upgrade was
+// made
+type conn struct {
+	net.Conn
+	r io.Reader
+}
+
+// Read reads from the buffer, then the connection
+func (c *conn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// IsUpgrade returns whether the request asks for a tunnel
+func IsUpgrade(r *http.Request) bool {
+	return headerContains(r.Header, "Connection", "upgrade") &&
+		strings.EqualFold(r.Header.Get("Upgrade"), Protocol)
+}
+
+// Dial makes a request for a tunnel to rawurl, with the headers given, and
+// returns the connection once the server has upgraded it. https servers are
+// verified with config, or the defaults if it is nil.
+func Dial(rawurl string, header http.Header, config *tls.Config) (net.Conn, error) {
+	return DialTransport(&http.Transport{TLSClientConfig: config}, rawurl, header)
+}
+
+// DialTransport is Dial connecting as the transport does: with its dialer, its
+// tls config, and through its proxy, if any, which is asked to CONNECT to the
+// server
+func DialTransport(t *http.Transport, rawurl string, header http.Header) (net.Conn, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", Protocol)
+
+	c, err := connect(t, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme == "https" {
+		var config *tls.Config
+		if t.TLSClientConfig == nil {
+			config = &tls.Config{}
+		} else {
+			config = t.TLSClientConfig.Clone()
+		}
+		if config.ServerName == "" {
+			config.ServerName = u.Hostname()
+		}
+		// Upgrades are not supported by http/2
+		config.NextProtos = []string{"http/1.1"}
+		tc := tls.Client(c, config)
+		if err := tc.Handshake(); err != nil {
+			_ = c.Close()
+			return nil, err
+		}
+		c = tc
+	}
+
+	if err := req.Write(c); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(c)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		_ = c.Close()
+		return nil, &StatusError{Code: resp.StatusCode, Body: body}
+	}
+
+	return &conn{Conn: c, r: br}, nil
+}
+
+// connect connects to the server of a request, directly or through the proxy
+// of the transport
+func connect(t *http.Transport, req *http.Request) (net.Conn, error) {
+	dial := t.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	ctx := req.Context()
+	host := hostPort(req.URL)
+
+	var proxy *url.URL
+	if t.Proxy != nil {
+		var err error
+		if proxy, err = t.Proxy(req); err != nil {
+			return nil, err
+		}
+	}
+	if proxy == nil {
+		return dial(ctx, "tcp", host)
+	}
+	if proxy.Scheme != "http" && proxy.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported proxy scheme %q", proxy.Scheme)
+	}
+
+	c, err := dial(ctx, "tcp", hostPort(proxy))
+	if err != nil {
+		return nil, err
+	}
+	if proxy.Scheme == "https" {
+		tc := tls.Client(c, &tls.Config{ServerName: proxy.Hostname()})
+		if err := tc.Handshake(); err != nil {
+			_ = c.Close()
+			return nil, err
+		}
+		c = tc
+	}
+
+	connectReq := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: host},
+		Host:   host,
+		Header: make(http.Header),
+	}
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + password))
+		connectReq.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := connectReq.Write(c); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(c)
+	resp, err := http.ReadResponse(br, connectReq)
+	if err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = c.Close()
+		return nil, fmt.Errorf("proxy refused to connect: %s", resp.Status)
+	}
+	return &conn{Conn: c, r: br}, nil
+}
+
+// hostPort returns the host and port of a url, with the port defaulting to
+// that of its scheme
+func hostPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	port := "80"
+	if u.Scheme == "https" {
+		port = "443"
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// Upgrade accepts a request for a tunnel, taking over its connection. Nothing
+// should be written to w before or after.
+func Upgrade(w http.ResponseWriter, r *http.Request) (net.Conn, error) {
+	if !IsUpgrade(r) {
+		return nil, fmt.Errorf("not a request to upgrade to %s", Protocol)
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, fmt.Errorf("connection can not be taken over")
+	}
+
+	c, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: " + Protocol + "\r\n\r\n"); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	if err := rw.Flush(); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+
+	return &conn{Conn: c, r: rw.Reader}, nil
+}
+
+// Join copies between a and b until either side is done, then closes both
+func Join(a, b io.ReadWriteCloser) {
+	var wg sync.WaitGroup
+	var once sync.Once
+	closeBoth := func() {
+		_ = a.Close()
+		_ = b.Close()
+	}
+
+	copy := func(dst io.Writer, src io.Reader) {
+		defer wg.Done()
+		_, _ = io.Copy(dst, src)
+		once.Do(closeBoth)
+	}
+
+	wg.Add(2)
+	go copy(a, b)
+	go copy(b, a)
+	wg.Wait()
+}
+
+// headerContains returns whether a comma separated header contains token
+func headerContains(header http.Header, key, token string) bool {
+	for _, value := range header[http.CanonicalHeaderKey(key)] {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,163 @@
+package tunnel_test
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	"github.com/mistifyio/lochness/internal/tunnel"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestTunnel(t *testing.T) {
+	suite.Run(t, new(TunnelSuite))
+}
+
+type TunnelSuite struct {
+	suite.Suite
+	Server *httptest.Server
+}
+
+func (s *TunnelSuite) SetupSuite() {
+	log.SetLevel(log.FatalLevel)
+
+	// Echo everything sent through the tunnel, behind the api middleware
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Refuse") != "" {
+			http.Error(w, "refused", http.StatusForbidden)
+			return
+		}
+		c, err := tunnel.Upgrade(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer func() { _ = c.Close() }()
+		_, _ = io.Copy(c, c)
+	})
+	s.Server = httptest.NewServer(httpmw.RequestID(httpmw.Logger(httpmw.Config{})(echo)))
+}
+
+func (s *TunnelSuite) TearDownSuite() {
+	s.Server.Close()
+}
+
+func (s *TunnelSuite) TestDial() {
+	c, err := tunnel.Dial(s.Server.URL+"/echo", nil, nil)
+	s.Require().NoError(err)
+	defer func() { _ = c.Close() }()
+
+	r := bufio.NewReader(c)
+	for _, line := range []string{"hello\n", "world\n"} {
+		_, err := c.Write([]byte(line))
+		s.Require().NoError(err)
+		got, err := r.ReadString('\n')
+		s.NoError(err)
+		s.Equal(line, got)
+	}
+}
+
+func (s *TunnelSuite) TestDialTransport() {
+	// A proxy that only CONNECTs
+	var connected []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "CONNECT" {
+			http.Error(w, "not a CONNECT", http.StatusMethodNotAllowed)
+			return
+		}
+		connected = append(connected, r.Host)
+		server, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		c, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			_ = server.Close()
+			return
+		}
+		_, _ = c.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		tunnel.Join(c, server)
+	}))
+	defer proxy.Close()
+
+	proxyURL, _ := url.Parse(proxy.URL)
+	t := &http.Transport{Proxy: http.ProxyURL(proxyURL)}
+	c, err := tunnel.DialTransport(t, s.Server.URL+"/echo", nil)
+	s.Require().NoError(err)
+	defer func() { _ = c.Close() }()
+
+	_, err = c.Write([]byte("hello\n"))
+	s.Require().NoError(err)
+	got, err := bufio.NewReader(c).ReadString('\n')
+	s.NoError(err)
+	s.Equal("hello\n", got)
+	s.Equal([]string{strings.TrimPrefix(s.Server.URL, "http://")}, connected, "the tunnel should go through the proxy")
+
+	t = &http.Transport{Proxy: http.ProxyURL(&url.URL{Scheme: "socks5", Host: proxyURL.Host})}
+	_, err = tunnel.DialTransport(t, s.Server.URL+"/echo", nil)
+	s.Error(err, "socks5 proxies should not be supported")
+}
+
+func (s *TunnelSuite) TestDialRefused() {
+	_, err := tunnel.Dial(s.Server.URL+"/echo", http.Header{"X-Refuse": {"1"}}, nil)
+	s.Require().Error(err)
+	statusErr, ok := err.(*tunnel.StatusError)
+	s.Require().True(ok, "should be a StatusError")
+	s.Equal(http.StatusForbidden, statusErr.Code)
+	s.Contains(string(statusErr.Body), "refused")
+
+	resp, err := http.Get(s.Server.URL + "/echo")
+	s.Require().NoError(err)
+	_ = resp.Body.Close()
+	s.Equal(http.StatusBadRequest, resp.StatusCode, "requests without an upgrade should be rejected")
+}
+
+func (s *TunnelSuite) TestIsUpgrade() {
+	tests := []struct {
+		description string
+		connection  string
+		upgrade     string
+		expected    bool
+	}{
+		{"upgrade", "Upgrade", "tcp", true},
+		{"listed", "keep-alive, upgrade", "TCP", true},
+		{"websocket", "Upgrade", "websocket", false},
+		{"no connection", "", "tcp", false},
+	}
+
+	for _, test := range tests {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Set("Connection", test.connection)
+		r.Header.Set("Upgrade", test.upgrade)
+		s.Equal(test.expected, tunnel.IsUpgrade(r), test.description)
+	}
+}
+
+func (s *TunnelSuite) TestJoin() {
+	local, a := net.Pipe()
+	b, remote := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		tunnel.Join(a, b)
+		close(done)
+	}()
+
+	go func() { _, _ = local.Write([]byte("ping")) }()
+	buf := make([]byte, 4)
+	_, err := io.ReadFull(remote, buf)
+	s.NoError(err)
+	s.Equal("ping", string(buf))
+
+	_ = remote.Close()
+	<-done
+	_, err = local.Read(buf)
+	s.Error(err, "both sides should be closed once either is done")
+}
@@ -0,0 +1,408 @@
+// The lochness web ui: read-only views of the cluster, got from the apis
+// through /api of the server the page is served by.
+(function () {
+	"use strict";
+
+	var refreshInterval = 10000;
+	var view = document.getElementById("view");
+	var filterInput = document.getElementById("filter");
+	var updated = document.getElementById("updated");
+
+	var available = {};
+	var sorts = {};
+	var timer = null;
+	var current = null;
+
+	// get fetches json from the api, failing with the message of error
+	// responses. The empty path lists which collections the apis serve.
+	function get(path) {
+		return fetch(path ? "api/" + path : "api", {headers: {Accept: "application/json"}}).then(function (resp) {
+			return resp.json().catch(function () {
+				return {};
+			}).then(function (body) {
+				if (!resp.ok) {
+					throw new Error(resp.status + " " + (body.message || resp.statusText));
+				}
+				return body;
+			});
+		});
+	}
+
+	// getEach gets a path for each id, with failures as nulls so one missing
+	// entity does not hide the rest
+	function getEach(ids, path) {
+		return Promise.all(ids.map(function (id) {
+			return get(path(id)).catch(function () {
+				return null;
+			});
+		}));
+	}
+
+	function el(tag, attrs, children) {
+		var e = document.createElement(tag);
+		Object.keys(attrs || {}).forEach(function (k) {
+			if (k === "onclick") {
+				e.onclick = attrs[k];
+			} else {
+				e.setAttribute(k, attrs[k]);
+			}
+		});
+		(children || []).forEach(function (c) {
+			e.appendChild(typeof c === "string" ? document.createTextNode(c) : c);
+		});
+		return e;
+	}
+
+	function text(value) {
+		if (value === undefined || value === null || value === "") {
+			return "";
+		}
+		return String(value);
+	}
+
+	function name(entity) {
+		return (entity.metadata && entity.metadata.name) || "";
+	}
+
+	function percent(used, total) {
+		return total > 0 ? Math.round(100 * used / total) : "";
+	}
+
+	function showError(err) {
+		view.replaceChildren(el("div", {"class": "error"}, [err.message || String(err)]));
+	}
+
+	function matches(row, filter) {
+		if (!filter) {
+			return true;
+		}
+		return Object.keys(row).some(function (k) {
+			return text(row[k]).toLowerCase().indexOf(filter) >= 0;
+		});
+	}
+
+	// table renders rows under columns, sortable by clicking their headers
+	// and filtered by the filter box. Rows with an href link to it.
+	function table(key, columns, rows) {
+		var sort = sorts[key] || {column: columns[0].key, desc: false};
+		var filter = filterInput.value.trim().toLowerCase();
+		rows = rows.filter(function (row) {
+			return matches(row, filter);
+		});
+		rows.sort(function (a, b) {
+			var x = a[sort.column], y = b[sort.column];
+			var cmp = typeof x === "number" && typeof y === "number" ? x - y : text(x).localeCompare(text(y));
+			return sort.desc ? -cmp : cmp;
+		});
+
+		var head = el("tr", {}, columns.map(function (col) {
+			var label = col.header + (col.key === sort.column ? (sort.desc ? " ▼" : " ▲") : "");
+			return el("th", {onclick: function () {
+				sorts[key] = {column: col.key, desc: col.key === sort.column && !sort.desc};
+				render();
+			}}, [label]);
+		}));
+		var body = rows.map(function (row) {
+			var attrs = row.href ? {"class": "link", onclick: function () {
+				location.hash = row.href;
+			}} : {};
+			return el("tr", attrs, columns.map(function (col) {
+				var attrs = col.key === "id" ? {"class": "id"} : {};
+				if (col.key === "state") {
+					attrs["class"] = "state-" + row.state;
+				}
+				return el("td", attrs, [text(row[col.key])]);
+			}));
+		});
+		if (rows.length === 0) {
+			body = [el("tr", {}, [el("td", {colspan: columns.length, "class": "muted"}, ["nothing to show"])])];
+		}
+		return el("table", {}, [el("thead", {}, [head]), el("tbody", {}, body)]);
+	}
+
+	function section(title, children) {
+		return el("section", {}, [el("h2", {}, [title])].concat(children));
+	}
+
+	function unavailable(what) {
+		return el("p", {"class": "muted"}, ["No api serving " + what + " is configured."]);
+	}
+
+	function hypervisorState(hv, health) {
+		if (hv.maintenance) {
+			return "maintenance";
+		}
+		return health && health.alive ? "up" : "down";
+	}
+
+	var views = {
+		hypervisors: function () {
+			if (!available.hypervisors) {
+				return Promise.resolve([unavailable("hypervisors")]);
+			}
+			return get("hypervisors").then(function (hvs) {
+				var ids = hvs.map(function (hv) {
+					return hv.id;
+				});
+				return Promise.all([
+					getEach(ids, function (id) {
+						return "hypervisors/" + id + "/health";
+					}),
+					getEach(ids, function (id) {
+						return "hypervisors/" + id + "/guests";
+					})
+				]).then(function (results) {
+					var rows = hvs.map(function (hv, i) {
+						var total = hv.total_resources || {}, avail = hv.available_resources || {};
+						return {
+							href: "hypervisors/" + hv.id,
+							id: hv.id,
+							name: name(hv),
+							ip: hv.ip,
+							state: hypervisorState(hv, results[0][i]),
+							guests: results[1][i] ? results[1][i].length : "",
+							cpu: percent(total.cpu - avail.cpu, total.cpu),
+							memory: percent(total.memory - avail.memory, total.memory),
+							disk: percent(total.disk - avail.disk, total.disk)
+						};
+					});
+					return [table("hypervisors", [
+						{header: "ID", key: "id"},
+						{header: "Name", key: "name"},
+						{header: "IP", key: "ip"},
+						{header: "State", key: "state"},
+						{header: "Guests", key: "guests"},
+						{header: "CPU %", key: "cpu"},
+						{header: "Memory %", key: "memory"},
+						{header: "Disk %", key: "disk"}
+					], rows)];
+				});
+			});
+		},
+
+		hypervisor: function (id) {
+			return Promise.all([
+				get("hypervisors/" + id),
+				get("hypervisors/" + id + "/health").catch(function () {
+					return null;
+				}),
+				get("hypervisors/" + id + "/guests").catch(function () {
+					return [];
+				}),
+				get("hypervisors/" + id + "/subnets").catch(function () {
+					return {subnets: {}};
+				})
+			]).then(function (results) {
+				var hv = results[0];
+				var guests = results[2].map(function (guest) {
+					return {href: "guests/" + guest, id: guest};
+				});
+				var subnets = Object.keys(results[3].subnets || {}).map(function (subnet) {
+					return {href: "subnets/" + subnet, id: subnet, bridge: results[3].subnets[subnet]};
+				});
+				return [
+					section("Hypervisor " + id + (name(hv) ? " (" + name(hv) + ")" : ""), [el("pre", {}, [JSON.stringify(hv, null, 2)])]),
+					section("Health", [el("pre", {}, [JSON.stringify(results[1], null, 2)])]),
+					section("Guests", [table("hypervisor-guests", [{header: "ID", key: "id"}], guests)]),
+					section("Subnets", [table("hypervisor-subnets", [
+						{header: "ID", key: "id"},
+						{header: "Bridge", key: "bridge"}
+					], subnets)])
+				];
+			});
+		},
+
+		guests: function () {
+			if (!available.guests) {
+				return Promise.resolve([unavailable("guests")]);
+			}
+			return get("guests").then(function (guests) {
+				var rows = guests.map(function (guest) {
+					return {
+						href: "guests/" + guest.id,
+						id: guest.id,
+						name: name(guest),
+						hypervisor: guest.hypervisor,
+						ip: guest.ip,
+						mac: guest.mac,
+						flavor: guest.flavor,
+						subnet: guest.subnet
+					};
+				});
+				return [table("guests", [
+					{header: "ID", key: "id"},
+					{header: "Name", key: "name"},
+					{header: "Hypervisor", key: "hypervisor"},
+					{header: "IP", key: "ip"},
+					{header: "MAC", key: "mac"},
+					{header: "Flavor", key: "flavor"},
+					{header: "Subnet", key: "subnet"}
+				], rows)];
+			});
+		},
+
+		guest: function (id) {
+			return get("guests/" + id).then(function (guest) {
+				return [section("Guest " + id + (name(guest) ? " (" + name(guest) + ")" : ""), [el("pre", {}, [JSON.stringify(guest, null, 2)])])];
+			});
+		},
+
+		// subnets are those the hypervisors carry, with their address
+		// usage if the network api is configured
+		subnets: function () {
+			if (!available.hypervisors) {
+				return Promise.resolve([unavailable("hypervisors, whose subnets are shown")]);
+			}
+			return get("hypervisors").then(function (hvs) {
+				return getEach(hvs.map(function (hv) {
+					return hv.id;
+				}), function (id) {
+					return "hypervisors/" + id + "/subnets";
+				}).then(function (results) {
+					var bySubnet = {};
+					results.forEach(function (result, i) {
+						Object.keys((result && result.subnets) || {}).forEach(function (subnet) {
+							(bySubnet[subnet] = bySubnet[subnet] || []).push(name(hvs[i]) || hvs[i].id);
+						});
+					});
+					var ids = Object.keys(bySubnet);
+					var addresses = available.subnets ? getEach(ids, function (id) {
+						return "subnets/" + id + "/addresses";
+					}) : Promise.resolve([]);
+					return addresses.then(function (addresses) {
+						var rows = ids.map(function (id, i) {
+							var a = addresses[i] || {};
+							return {
+								href: "subnets/" + id,
+								id: id,
+								cidr: a.cidr,
+								range: a.start ? a.start + "-" + a.end : "",
+								allocated: a.allocated,
+								available: a.available,
+								hypervisors: bySubnet[id].length
+							};
+						});
+						var children = [table("subnets", [
+							{header: "ID", key: "id"},
+							{header: "CIDR", key: "cidr"},
+							{header: "Range", key: "range"},
+							{header: "Allocated", key: "allocated"},
+							{header: "Available", key: "available"},
+							{header: "Hypervisors", key: "hypervisors"}
+						], rows)];
+						if (!available.subnets) {
+							children.push(unavailable("subnet addresses"));
+						}
+						return children;
+					});
+				});
+			});
+		},
+
+		subnet: function (id) {
+			if (!available.subnets) {
+				return Promise.resolve([unavailable("subnet addresses")]);
+			}
+			return get("subnets/" + id + "/addresses").then(function (a) {
+				var rows = Object.keys(a.addresses || {}).map(function (address) {
+					return {href: "guests/" + a.addresses[address], address: address, guest: a.addresses[address]};
+				});
+				return [
+					section("Subnet " + id, [el("p", {}, [
+						text(a.cidr) + " " + text(a.start) + "-" + text(a.end) + ": " +
+						text(a.allocated) + "/" + text(a.total) + " allocated, " + text(a.available) + " available"
+					])]),
+					section("Addresses", [table("subnet-addresses", [
+						{header: "Address", key: "address"},
+						{header: "Guest", key: "guest"}
+					], rows)])
+				];
+			});
+		},
+
+		// jobs have no listing, so they are looked up by id, such as those
+		// returned when guests are created or acted on
+		jobs: function (id) {
+			if (!available.jobs) {
+				return Promise.resolve([unavailable("jobs")]);
+			}
+			var input = el("input", {type: "text", placeholder: "job id", size: 40, value: id || ""});
+			var form = el("form", {}, [input, el("button", {type: "submit"}, ["Show"])]);
+			form.onsubmit = function (e) {
+				e.preventDefault();
+				location.hash = "jobs/" + input.value.trim();
+			};
+			if (!id) {
+				return Promise.resolve([form]);
+			}
+			return Promise.all([
+				get("jobs/" + id),
+				get("jobs/" + id + "/graph").catch(function () {
+					return null;
+				})
+			]).then(function (results) {
+				var children = [form, section("Job " + id, [el("pre", {}, [JSON.stringify(results[0], null, 2)])])];
+				if (results[1]) {
+					children.push(section("Graph", [el("pre", {}, [JSON.stringify(results[1], null, 2)])]));
+				}
+				return children;
+			});
+		}
+	};
+
+	// route returns the view of the location: a collection, or one entity of
+	// it as collection/id
+	function route() {
+		var parts = location.hash.replace(/^#/, "").split("/");
+		var collection = parts[0] || "hypervisors";
+		var id = parts.slice(1).join("/");
+		var single = {hypervisors: "hypervisor", guests: "guest", subnets: "subnet"};
+		if (!views[collection]) {
+			collection = "hypervisors";
+		}
+		return {
+			collection: collection,
+			render: id && single[collection] ? views[single[collection]].bind(null, id) : views[collection].bind(null, id),
+			refresh: !id && collection !== "jobs"
+		};
+	}
+
+	function render() {
+		var r = route();
+		document.querySelectorAll("nav a").forEach(function (a) {
+			a.classList.toggle("active", a.dataset.view === r.collection);
+		});
+		clearTimeout(timer);
+		var token = current = {};
+		r.render().then(function (children) {
+			if (token !== current) {
+				return;
+			}
+			view.replaceChildren.apply(view, children);
+			updated.textContent = "updated " + new Date().toLocaleTimeString();
+		}).catch(function (err) {
+			if (token === current) {
+				showError(err);
+			}
+		}).then(function () {
+			if (token === current && r.refresh) {
+				timer = setTimeout(render, refreshInterval);
+			}
+		});
+	}
+
+	window.addEventListener("hashchange", function () {
+		filterInput.value = "";
+		render();
+	});
+	var typing = null;
+	filterInput.addEventListener("input", function () {
+		clearTimeout(typing);
+		typing = setTimeout(render, 300);
+	});
+
+	get("").then(function (apis) {
+		available = apis;
+		render();
+	}).catch(showError);
+})();
@@ -0,0 +1,159 @@
+// Package webui serves a minimal web ui of a lochness cluster: read-only views
+// of its hypervisors, guests, subnets, and jobs, for teams that would rather
+// not build a console of their own.
+//
+// The ui is a single page embedded in the binary, which gets what it shows
+// from the apis through the server it is served by: GETs of /api/hypervisors,
+// /api/guests, /api/jobs, and /api/subnets are passed on to the versioned
+// routes of the api that serves them, and anything else is refused, so the
+// ui can change nothing and needs no access to the apis of its own.
+package webui
+
+import (
+	"crypto/tls"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/justinas/alice"
+	"github.com/mistifyio/lochness/internal/httpmw"
+	"github.com/mistifyio/lochness/internal/server"
+)
+
+//go:embed static
+var static embed.FS
+
+// Config is where the ui gets what it shows. Each api is a handler of its
+// routes, such as the router of an api served in the same process or a Proxy
+// to one, and may be nil if the cluster has none, leaving the views that need
+// it empty.
+type Config struct {
+	// HypervisorAPI serves the hypervisors and the subnets they carry, as
+	// chypervisord does
+	HypervisorAPI http.Handler
+	// GuestAPI serves the guests and their jobs, as cguestd does
+	GuestAPI http.Handler
+	// NetworkAPI serves the addresses of subnets, as cnetworkd does
+	NetworkAPI http.Handler
+}
+
+// apis returns the api serving each collection the ui reads
+func (c Config) apis() map[string]http.Handler {
+	return map[string]http.Handler{
+		"hypervisors": c.HypervisorAPI,
+		"guests":      c.GuestAPI,
+		"jobs":        c.GuestAPI,
+		"subnets":     c.NetworkAPI,
+	}
+}
+
+// Proxy creates a handler passing requests on to the api at rawurl, e.g.
+// http://127.0.0.1:19000, through transport, or http.DefaultTransport if it
+// is nil
+func Proxy(rawurl string, transport http.RoundTripper) (http.Handler, error) {
+	target, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, fmt.Errorf("api url must be http or https with a host: %q", rawurl)
+	}
+	return &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(target)
+		},
+		Transport: transport,
+	}, nil
+}
+
+// Handler serves the ui, with its requests of the apis under /api
+func Handler(config Config) http.Handler {
+	files, _ := fs.Sub(static, "static")
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServer(http.FS(files)))
+	mux.Handle("/api", apiIndex(config))
+	mux.Handle("/api/", apiHandler(config))
+	return mux
+}
+
+// apiIndex lists which collections the ui can get, so that it only offers
+// the views it has the apis of
+func apiIndex(config Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		available := map[string]bool{}
+		for collection, api := range config.apis() {
+			available[collection] = api != nil
+		}
+		writeJSON(w, http.StatusOK, available)
+	})
+}
+
+// apiHandler passes the reads of the collections of the ui on to the versioned
+// routes of their apis, as json
+func apiHandler(config Config) http.Handler {
+	apis := config.apis()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "the ui is read-only")
+			return
+		}
+
+		path := strings.TrimPrefix(r.URL.Path, "/api")
+		collection := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0]
+		api, ok := apis[collection]
+		if !ok {
+			writeError(w, http.StatusNotFound, "not_found", "unknown collection: "+collection)
+			return
+		}
+		if api == nil {
+			writeError(w, http.StatusNotFound, "api_not_configured", "no api serves "+collection)
+			return
+		}
+
+		req := r.Clone(r.Context())
+		req.URL.Path = httpmw.APIPrefix + path
+		req.URL.RawPath = ""
+		req.RequestURI = req.URL.RequestURI()
+		req.Header.Set("Accept", "application/json")
+		req.Header.Del("Cookie")
+		api.ServeHTTP(w, req)
+	})
+}
+
+// writeJSON writes a response of obj as json
+func writeJSON(w http.ResponseWriter, code int, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(obj); err != nil {
+		log.WithField("error", err).Error("failed to encode json")
+	}
+}
+
+// writeError writes an error response shaped as those of the apis
+func writeError(w http.ResponseWriter, code int, errCode, msg string) {
+	writeJSON(w, code, map[string]interface{}{
+		"message": msg,
+		"code":    code,
+		"error":   errCode,
+	})
+}
+
+// Run starts a server of the ui on port, serving https if tlsConfig is not nil
+func Run(port uint, config Config, reqLog httpmw.Config, tlsConfig *tls.Config) *server.Server {
+	reqLog.Name = "webui"
+	handler := alice.New(
+		httpmw.RequestID,
+		httpmw.Logger(reqLog),
+	).Then(Handler(config))
+
+	srv := server.New(fmt.Sprintf(":%d", port), handler, tlsConfig)
+	srv.Start()
+	return srv
+}
@@ -0,0 +1,144 @@
+package webui_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mistifyio/lochness/internal/webui"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestWebUI(t *testing.T) {
+	suite.Run(t, new(WebUISuite))
+}
+
+type WebUISuite struct {
+	suite.Suite
+	Requests []*http.Request
+	Server   *httptest.Server
+}
+
+func (s *WebUISuite) SetupTest() {
+	s.Requests = nil
+	api := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Requests = append(s.Requests, r)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `[]`)
+	})
+	s.Server = httptest.NewServer(webui.Handler(webui.Config{
+		HypervisorAPI: api,
+		GuestAPI:      api,
+	}))
+}
+
+func (s *WebUISuite) TearDownTest() {
+	s.Server.Close()
+}
+
+func (s *WebUISuite) do(method, path string) (*http.Response, string) {
+	req, err := http.NewRequest(method, s.Server.URL+path, nil)
+	s.Require().NoError(err)
+	resp, err := http.DefaultClient.Do(req)
+	s.Require().NoError(err)
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(resp.Body)
+	s.Require().NoError(err)
+	return resp, string(body)
+}
+
+func (s *WebUISuite) TestStatic() {
+	tests := []struct {
+		path        string
+		contentType string
+	}{
+		{"/", "text/html"},
+		{"/app.js", "javascript"},
+		{"/style.css", "text/css"},
+	}
+	for _, test := range tests {
+		resp, body := s.do("GET", test.path)
+		s.Equal(http.StatusOK, resp.StatusCode, test.path)
+		s.Contains(resp.Header.Get("Content-Type"), test.contentType, test.path)
+		s.NotEmpty(body, test.path)
+	}
+}
+
+func (s *WebUISuite) TestAPIIndex() {
+	resp, body := s.do("GET", "/api")
+	s.Equal(http.StatusOK, resp.StatusCode)
+	available := map[string]bool{}
+	s.Require().NoError(json.Unmarshal([]byte(body), &available))
+	s.Equal(map[string]bool{
+		"hypervisors": true,
+		"guests":      true,
+		"jobs":        true,
+		"subnets":     false,
+	}, available)
+}
+
+func (s *WebUISuite) TestAPI() {
+	tests := []struct {
+		description  string
+		method       string
+		path         string
+		expectedCode int
+		expectedPath string
+	}{
+		{"hypervisors", "GET", "/api/hypervisors?metadata=a=b", http.StatusOK, "/v1/hypervisors"},
+		{"hypervisor health", "GET", "/api/hypervisors/abc/health", http.StatusOK, "/v1/hypervisors/abc/health"},
+		{"job graph", "GET", "/api/jobs/abc/graph", http.StatusOK, "/v1/jobs/abc/graph"},
+		{"head", "HEAD", "/api/guests", http.StatusOK, "/v1/guests"},
+		{"post", "POST", "/api/guests", http.StatusMethodNotAllowed, ""},
+		{"delete", "DELETE", "/api/hypervisors/abc", http.StatusMethodNotAllowed, ""},
+		{"unknown collection", "GET", "/api/secrets", http.StatusNotFound, ""},
+		{"unconfigured api", "GET", "/api/subnets/abc/addresses", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		s.Requests = nil
+		resp, _ := s.do(test.method, test.path)
+		s.Equal(test.expectedCode, resp.StatusCode, test.description)
+		if test.expectedPath == "" {
+			s.Empty(s.Requests, test.description)
+			continue
+		}
+		s.Require().Len(s.Requests, 1, test.description)
+		r := s.Requests[0]
+		s.Equal(test.expectedPath, r.URL.Path, test.description)
+		s.Equal("application/json", r.Header.Get("Accept"), test.description)
+		if i := strings.Index(test.path, "?"); i >= 0 {
+			s.Equal(test.path[i+1:], r.URL.RawQuery, test.description)
+		}
+	}
+}
+
+func (s *WebUISuite) TestProxy() {
+	var got *http.Request
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		_, _ = io.WriteString(w, `{"id":"abc"}`)
+	}))
+	defer api.Close()
+
+	proxy, err := webui.Proxy(api.URL, nil)
+	s.Require().NoError(err)
+	ui := httptest.NewServer(webui.Handler(webui.Config{NetworkAPI: proxy}))
+	defer ui.Close()
+
+	resp, err := http.Get(ui.URL + "/api/subnets/abc/addresses")
+	s.Require().NoError(err)
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.JSONEq(`{"id":"abc"}`, string(body))
+	s.Require().NotNil(got)
+	s.Equal("/v1/subnets/abc/addresses", got.URL.Path)
+
+	for _, rawurl := range []string{"ftp://api", "http://", "://"} {
+		_, err := webui.Proxy(rawurl, nil)
+		s.Error(err, rawurl)
+	}
+}
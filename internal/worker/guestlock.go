@@ -0,0 +1,125 @@
+package worker
+
+import (
+	"encoding/json"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/mistifyio/lochness/pkg/jobqueue"
+	"github.com/mistifyio/lochness/pkg/kv"
+	"github.com/mistifyio/lochness/pkg/lock"
+)
+
+const (
+	// guestLockPath is where per-guest locks and active job markers are kept
+	guestLockPath = "lochness/cworkerd/guests/"
+	// guestLockTTL is how long a guest lock outlives a worker that dies
+	// while holding it
+	guestLockTTL = 30 * time.Second
+)
+
+// guestLocks serializes work on guests across workers. A worker holds the
+// guest lock while it handles a single task, and a guest is claimed by one job
+// from when it starts until its task is removed, so two jobs for the same
+// guest never run at the same time.
+type guestLocks struct {
+	kv kv.KV
+}
+
+func newGuestLocks(k kv.KV) *guestLocks {
+	return &guestLocks{kv: k}
+}
+
+// guestKey is a helper for generating the lock key of a guest
+func guestKey(guestID string) string {
+	return filepath.Join(guestLockPath, guestID)
+}
+
+// activeKey is a helper for generating the key of a guest's active job marker
+func activeKey(guestID string) string {
+	return path.Join(guestKey(guestID), "job")
+}
+
+// acquire locks the task's guest and claims it for the task's job. If the
+// guest is locked by another worker or claimed by another unfinished job, a
+// nil lock is returned and the task should be tried again later.
+func (g *guestLocks) acquire(task *jobqueue.Task) (*lock.Lock, error) {
+	guestID := task.Job.Guest
+	l, err := lock.New(g.kv, guestKey(guestID), guestLockTTL)
+	if err != nil {
+		return nil, err
+	}
+	if err := l.TryAcquire(); err != nil {
+		if err == lock.ErrLocked {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	claimed, err := g.claim(guestID, task.Job.ID)
+	if err != nil || !claimed {
+		_ = l.Release()
+		return nil, err
+	}
+	return l, nil
+}
+
+// claim marks the guest as being worked on by a job. It fails if another job
+// that has not finished already has the guest. The guest lock must be held.
+func (g *guestLocks) claim(guestID, jobID string) (bool, error) {
+	key := activeKey(guestID)
+	value, err := g.kv.Get(key)
+	if err != nil && !g.kv.IsKeyNotFound(err) {
+		return false, err
+	}
+	if err == nil {
+		current := string(value.Data)
+		if current == jobID {
+			return true, nil
+		}
+		active, err := g.jobActive(current)
+		if err != nil || active {
+			return false, err
+		}
+	}
+	return true, g.kv.Set(key, jobID)
+}
+
+// jobActive returns whether a job still exists and has not finished. The job
+// is read directly rather than through the jobqueue so its lock is not taken.
+func (g *guestLocks) jobActive(jobID string) (bool, error) {
+	value, err := g.kv.Get(filepath.Join(jobqueue.JobPath, jobID))
+	if err != nil {
+		if g.kv.IsKeyNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	job := &jobqueue.Job{}
+	if err := json.Unmarshal(value.Data, job); err != nil {
+		return false, err
+	}
+	return job.Status == jobqueue.JobStatusNew || job.Status == jobqueue.JobStatusWorking, nil
+}
+
+// release gives up the guest lock, first releasing the guest's claim if the
+// task's job is finished with it
+func (g *guestLocks) release(l *lock.Lock, task *jobqueue.Task, removed bool) error {
+	if removed {
+		key := activeKey(task.Job.Guest)
+		value, err := g.kv.Get(key)
+		switch {
+		case err == nil && string(value.Data) == task.Job.ID:
+			if err := g.kv.Delete(key, false); err != nil {
+				_ = l.Release()
+				return err
+			}
+		case err != nil && !g.kv.IsKeyNotFound(err):
+			_ = l.Release()
+			return err
+		}
+	}
+	return l.Release()
+}
@@ -0,0 +1,108 @@
+package worker
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/mistifyio/lochness/pkg/jobqueue"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestGuestLocks(t *testing.T) {
+	suite.Run(t, new(GuestLocksSuite))
+}
+
+type GuestLocksSuite struct {
+	common.Suite
+	Locks *guestLocks
+}
+
+func (s *GuestLocksSuite) SetupTest() {
+	s.Suite.SetupTest()
+	s.Locks = newGuestLocks(s.KV)
+}
+
+// newTask creates a task for a job on a guest, saving the job with status
+func (s *GuestLocksSuite) newTask(guestID, status string) *jobqueue.Task {
+	job := &jobqueue.Job{
+		ID:     uuid.New(),
+		Action: "reboot",
+		Guest:  guestID,
+		Status: status,
+	}
+	data, err := json.Marshal(job)
+	s.Require().NoError(err)
+	s.Require().NoError(s.KV.Set(filepath.Join(jobqueue.JobPath, job.ID), string(data)))
+	return &jobqueue.Task{JobID: job.ID, Job: job}
+}
+
+func (s *GuestLocksSuite) TestWorkerExclusion() {
+	guestID := uuid.New()
+	task := s.newTask(guestID, jobqueue.JobStatusNew)
+
+	l, err := s.Locks.acquire(task)
+	s.Require().NoError(err)
+	s.Require().NotNil(l)
+
+	other, err := s.Locks.acquire(task)
+	s.NoError(err)
+	s.Nil(other, "locked guest should be busy")
+
+	s.NoError(s.Locks.release(l, task, false))
+	l, err = s.Locks.acquire(task)
+	s.NoError(err)
+	s.NotNil(l, "same job should get its guest back")
+	s.NoError(s.Locks.release(l, task, true))
+}
+
+func (s *GuestLocksSuite) TestJobExclusion() {
+	guestID := uuid.New()
+	first := s.newTask(guestID, jobqueue.JobStatusNew)
+	second := s.newTask(guestID, jobqueue.JobStatusNew)
+	otherGuest := s.newTask(uuid.New(), jobqueue.JobStatusNew)
+
+	l, err := s.Locks.acquire(first)
+	s.Require().NoError(err)
+	s.Require().NotNil(l)
+	s.NoError(s.Locks.release(l, first, false))
+
+	l, err = s.Locks.acquire(second)
+	s.NoError(err)
+	s.Nil(l, "guest claimed by an unfinished job should be busy")
+
+	l, err = s.Locks.acquire(otherGuest)
+	s.NoError(err)
+	s.Require().NotNil(l, "other guests should not be affected")
+	s.NoError(s.Locks.release(l, otherGuest, true))
+
+	l, err = s.Locks.acquire(first)
+	s.Require().NoError(err)
+	s.Require().NotNil(l)
+	s.NoError(s.Locks.release(l, first, true))
+
+	l, err = s.Locks.acquire(second)
+	s.NoError(err)
+	s.Require().NotNil(l, "guest should be free once the first job is removed")
+	s.NoError(s.Locks.release(l, second, true))
+}
+
+func (s *GuestLocksSuite) TestStaleClaim() {
+	guestID := uuid.New()
+	finished := s.newTask(guestID, jobqueue.JobStatusDone)
+	next := s.newTask(guestID, jobqueue.JobStatusNew)
+
+	s.Require().NoError(s.KV.Set(activeKey(guestID), finished.Job.ID))
+	l, err := s.Locks.acquire(next)
+	s.NoError(err)
+	s.Require().NotNil(l, "finished job should not keep the guest")
+	s.NoError(s.Locks.release(l, next, true))
+
+	s.Require().NoError(s.KV.Set(activeKey(guestID), uuid.New()))
+	l, err = s.Locks.acquire(next)
+	s.NoError(err)
+	s.Require().NotNil(l, "missing job should not keep the guest")
+	s.NoError(s.Locks.release(l, next, true))
+}
@@ -0,0 +1,62 @@
+package worker
+
+import (
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/pkg/jobqueue"
+)
+
+// imageFetched returns whether the hypervisor of the guest has already fetched
+// its catalog image as it currently is, so that fetching it can be skipped.
+// Guests created from their flavor's image are always fetched.
+func imageFetched(ctx *lochness.Context, guest *lochness.Guest) (bool, error) {
+	if guest.ImageID == "" {
+		return false, nil
+	}
+	image, err := ctx.Image(guest.ImageID)
+	if err != nil {
+		return false, err
+	}
+	return image.Fetched(guest.HypervisorID)
+}
+
+// recordImageFetch records the state of the guest's catalog image on its
+// hypervisor. Failing to is only logged, since the state is only a cache.
+func recordImageFetch(ctx *lochness.Context, guest *lochness.Guest, state string, fetchErr error) {
+	if guest.ImageID == "" {
+		return
+	}
+	logFields := log.Fields{
+		"guest":      guest.ID,
+		"image":      guest.ImageID,
+		"hypervisor": guest.HypervisorID,
+		"state":      state,
+	}
+
+	image, err := ctx.Image(guest.ImageID)
+	if err == nil {
+		err = image.SetFetchState(guest.HypervisorID, state, fetchErr)
+	}
+	if err != nil {
+		log.WithFields(logFields).WithField("error", err).Error("unable to record image fetch state")
+	}
+}
+
+// fetchDone moves a fetch job on to creating the guest
+func fetchDone(task *jobqueue.Task) error {
+	task.Job.Action = "create"
+	task.Job.RemoteID = ""
+	task.Job.Status = jobqueue.JobStatusNew
+
+	// Save Job Status
+	if err := task.Job.Save(24 * time.Hour); err != nil {
+		log.WithFields(log.Fields{
+			"task":  task,
+			"error": err,
+		}).Error("unable to save")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package worker
+
+import (
+	"errors"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/armon/go-metrics"
+	"github.com/mistifyio/lochness/pkg/deferer"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/mistifyio/lochness/pkg/jobqueue"
+)
+
+// errLeaseExpired is recorded on jobs that are failed by the reaper
+var errLeaseExpired = errors.New("job lease expired")
+
+// reaper reclaims jobs abandoned by workers that died while working on them.
+// A job is abandoned once its lease has expired and its task is gone from
+// beanstalk. Abandoned jobs are put back in the queue up to maxReclaims times
+// and then failed. The reaper also rolls back the changes of cleanup journals
+// abandoned by processes that died, if it has a janitor.
+type reaper struct {
+	jobQueue    *jobqueue.Client
+	m           *metrics.Metrics
+	leaseTTL    time.Duration
+	maxReclaims int
+	janitor     *deferer.Janitor
+}
+
+// run reaps every interval, forever
+func (r *reaper) run(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := r.reap(); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"func":  "reaper.reap",
+			}).Error("failed to reap jobs")
+		}
+		r.sweep()
+	}
+}
+
+// sweep rolls back abandoned cleanup journals
+func (r *reaper) sweep() {
+	if r.janitor == nil {
+		return
+	}
+	swept, err := r.janitor.Sweep()
+	if swept > 0 {
+		r.m.IncrCounter([]string{"journals", "rolledback"}, float32(swept))
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"func":  "deferer.Janitor.Sweep",
+		}).Error("failed to roll back abandoned cleanup journals")
+	}
+}
+
+// reap reclaims all currently abandoned jobs
+func (r *reaper) reap() error {
+	leases, err := r.jobQueue.Leases()
+	if err != nil {
+		return err
+	}
+
+	for _, lease := range leases {
+		if !lease.Expired() {
+			continue
+		}
+		if err := r.reclaim(lease.JobID); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"job":   lease.JobID,
+				"func":  "reaper.reclaim",
+			}).Error("failed to reclaim job")
+		}
+	}
+	return nil
+}
+
+// reclaim requeues or fails a job if it has been abandoned
+func (r *reaper) reclaim(jobID string) error {
+	lease, err := r.jobQueue.Lease(jobID)
+	if err != nil || lease == nil || !lease.Expired() {
+		return err
+	}
+	// a task still in beanstalk is handed out again once its worker's
+	// reservation times out
+	exists, err := r.jobQueue.TaskExists(lease.TaskID)
+	if err != nil || exists {
+		return err
+	}
+
+	job, err := r.jobQueue.Job(jobID)
+	if err != nil {
+		if lerrors.IsNotFound(err) {
+			// the job was removed, e.g. by the janitor, leaving only its
+			// lease, which would otherwise be retried on every reap
+			return r.jobQueue.DeleteLease(jobID)
+		}
+		// a worker or another reaper has the job locked
+		log.WithFields(log.Fields{
+			"error": err,
+			"job":   jobID,
+		}).Debug("unable to get job")
+		return nil
+	}
+	// released last, once the job is requeued or failed
+	defer func() {
+		if err := job.Release(); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"job":   jobID,
+				"func":  "jobqueue.Job.Release",
+			}).Error("failed to release job")
+		}
+	}()
+
+	// recheck now that the job is locked, in case it was just reclaimed
+	lease, err = r.jobQueue.Lease(jobID)
+	if err != nil || lease == nil || !lease.Expired() {
+		return err
+	}
+
+	logFields := log.Fields{
+		"job":      job.ID,
+		"guest":    job.Guest,
+		"action":   job.Action,
+		"status":   job.Status,
+		"worker":   lease.Worker,
+		"reclaims": lease.Reclaims,
+	}
+
+	switch {
+	case job.Status == jobqueue.JobStatusDone || job.Status == jobqueue.JobStatusError:
+		// the worker finished the job but died before cleaning up
+		return r.jobQueue.DeleteLease(job.ID)
+	case lease.Reclaims < r.maxReclaims:
+		// the task is only handed out after a delay, by when the new lease
+		// is saved and the job unlocked for a worker to pick it up
+		taskID, err := r.jobQueue.AddDelayedTask(job)
+		if err != nil {
+			return err
+		}
+		lease.TaskID = taskID
+		lease.Reclaims++
+		lease.Expires = time.Now().Add(r.leaseTTL)
+		if err := r.jobQueue.SaveLease(lease); err != nil {
+			// the next reap adds another task, as the lease is unchanged
+			if dErr := r.jobQueue.DeleteTask(taskID); dErr != nil {
+				log.WithFields(log.Fields{
+					"error": dErr,
+					"job":   job.ID,
+					"task":  taskID,
+					"func":  "jobqueue.Client.DeleteTask",
+				}).Error("failed to delete requeued task")
+			}
+			return err
+		}
+		r.m.IncrCounter([]string{"jobs", "reclaimed", "requeued"}, 1)
+		log.WithFields(logFields).Warn("requeued abandoned job")
+	default:
+		job.Status = jobqueue.JobStatusError
+		job.Error = errLeaseExpired.Error()
+		job.FinishedAt = time.Now()
+		if err := job.Save(24 * time.Hour); err != nil {
+			return err
+		}
+		if err := r.jobQueue.DeleteLease(job.ID); err != nil {
+			return err
+		}
+		r.m.IncrCounter([]string{"jobs", "reclaimed", "failed"}, 1)
+		log.WithFields(logFields).Warn("failed abandoned job")
+	}
+	return nil
+}
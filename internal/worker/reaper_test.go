@@ -0,0 +1,147 @@
+package worker
+
+import (
+	"fmt"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/bakins/go-metrics-map"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/mistifyio/lochness/pkg/jobqueue"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestReaper(t *testing.T) {
+	suite.Run(t, new(ReaperSuite))
+}
+
+type ReaperSuite struct {
+	common.Suite
+	BStalkCmd *exec.Cmd
+	JobQueue  *jobqueue.Client
+	Reaper    *reaper
+}
+
+func (s *ReaperSuite) SetupTest() {
+	s.Suite.SetupTest()
+
+	bPort := "59874"
+	s.BStalkCmd = exec.Command("beanstalkd", "-p", bPort)
+	s.Require().NoError(s.BStalkCmd.Start())
+	time.Sleep(500 * time.Millisecond)
+
+	jobQueue, err := jobqueue.NewClient(fmt.Sprintf("127.0.0.1:%s", bPort), s.KV)
+	s.Require().NoError(err)
+	s.JobQueue = jobQueue
+
+	m, _ := metrics.New(metrics.DefaultConfig("cworkerd-test"), mapsink.New())
+	s.Reaper = &reaper{
+		jobQueue:    jobQueue,
+		m:           m,
+		leaseTTL:    time.Minute,
+		maxReclaims: 1,
+	}
+}
+
+func (s *ReaperSuite) TearDownTest() {
+	s.Require().NoError(s.BStalkCmd.Process.Kill())
+	s.Require().Error(s.BStalkCmd.Wait())
+
+	s.Suite.TearDownTest()
+}
+
+// abandonedJob creates a job whose worker died after deleting its task
+func (s *ReaperSuite) abandonedJob(status string) *jobqueue.Job {
+	job := s.JobQueue.NewJob()
+	job.Guest = uuid.New()
+	job.Action = "reboot"
+	job.Status = status
+	s.Require().NoError(job.Save(time.Minute))
+	s.Require().NoError(job.Release())
+
+	_, err := s.JobQueue.AddTask(job)
+	s.Require().NoError(err)
+	task, err := s.JobQueue.NextWorkTask()
+	s.Require().NoError(err)
+	s.Require().NoError(task.Lease("dead", -time.Second))
+	s.Require().NoError(task.Delete())
+	return job
+}
+
+func (s *ReaperSuite) TestLiveLease() {
+	job := s.JobQueue.NewJob()
+	job.Guest = uuid.New()
+	job.Action = "reboot"
+	s.Require().NoError(job.Save(time.Minute))
+	s.Require().NoError(job.Release())
+	_, err := s.JobQueue.AddTask(job)
+	s.Require().NoError(err)
+	task, err := s.JobQueue.NextWorkTask()
+	s.Require().NoError(err)
+
+	// expired, but the task is still reserved
+	s.Require().NoError(task.Lease("slow", -time.Second))
+	s.NoError(s.Reaper.reap())
+	lease, err := s.JobQueue.Lease(job.ID)
+	s.NoError(err)
+	s.Equal(0, lease.Reclaims, "job with a task should not be reclaimed")
+	s.NoError(task.Delete())
+}
+
+func (s *ReaperSuite) TestRequeueThenFail() {
+	job := s.abandonedJob(jobqueue.JobStatusWorking)
+
+	s.NoError(s.Reaper.reap())
+	lease, err := s.JobQueue.Lease(job.ID)
+	s.Require().NoError(err)
+	s.Equal(1, lease.Reclaims)
+	s.False(lease.Expired())
+
+	task, err := s.JobQueue.NextWorkTask()
+	s.Require().NoError(err)
+	s.Equal(job.ID, task.JobID, "job should be requeued")
+	s.Equal(lease.TaskID, task.ID, "lease should have the new task")
+	s.Equal(jobqueue.JobStatusWorking, task.Job.Status)
+
+	// the new worker dies too
+	s.Require().NoError(task.Lease("dead", -time.Second))
+	s.Require().NoError(task.Delete())
+
+	s.NoError(s.Reaper.reap())
+	lease, err = s.JobQueue.Lease(job.ID)
+	s.NoError(err)
+	s.Nil(lease, "failed job should have no lease")
+
+	job, err = s.JobQueue.Job(job.ID)
+	s.Require().NoError(err)
+	s.Equal(jobqueue.JobStatusError, job.Status)
+	s.Equal(errLeaseExpired.Error(), job.Error)
+	s.NoError(job.Release())
+}
+
+func (s *ReaperSuite) TestFinishedJob() {
+	job := s.abandonedJob(jobqueue.JobStatusDone)
+
+	s.NoError(s.Reaper.reap())
+	lease, err := s.JobQueue.Lease(job.ID)
+	s.NoError(err)
+	s.Nil(lease, "finished job should have its lease cleaned up")
+
+	job, err = s.JobQueue.Job(job.ID)
+	s.Require().NoError(err)
+	s.Equal(jobqueue.JobStatusDone, job.Status)
+	s.NoError(job.Release())
+}
+
+func (s *ReaperSuite) TestRemovedJob() {
+	job := s.abandonedJob(jobqueue.JobStatusWorking)
+	s.Require().NoError(s.KV.Delete(s.PrefixKey("jobs/"+job.ID), false))
+
+	s.NoError(s.Reaper.reap())
+	lease, err := s.JobQueue.Lease(job.ID)
+	s.NoError(err)
+	s.Nil(lease, "lease of a removed job should be deleted")
+}
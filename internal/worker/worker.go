@@ -0,0 +1,669 @@
+// Package worker works on the guest action jobs queued in beanstalk, through
+// the agents of the hypervisors. It is run by cworkerd and lochnessd.
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/armon/go-metrics"
+	"github.com/kr/beanstalk"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tracing"
+	"github.com/mistifyio/lochness/pkg/agent"
+	"github.com/mistifyio/lochness/pkg/jobqueue"
+	"github.com/mistifyio/lochness/pkg/kv"
+	"github.com/mistifyio/mistify-agent/config"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// desiredStateCtx is set when hypervisors converge on their desired state
+// themselves. Jobs that only change what a hypervisor should be running are
+// then completed by waiting for the hypervisor's ack instead of calling its agent.
+var desiredStateCtx *lochness.Context
+
+// desiredStateActions are the job actions handled through the desired state
+var desiredStateActions = map[string]bool{
+	"fetch":  true,
+	"create": true,
+	"delete": true,
+}
+
+// leaseTTL is how long a worker's claim on a job lasts without the worker
+// reserving its task again
+var leaseTTL = time.Minute
+
+// Config is the settings of the workers
+type Config struct {
+	// Beanstalk is the address of the beanstalkd server
+	Beanstalk string
+	// AgentPort is the port on which agents listen
+	AgentPort uint
+	// AgentProxy is the proxy agents are connected to through, see
+	// MistifyAgent.ConfigureTransport
+	AgentProxy string
+	// AgentDialTimeout is how long connecting to an agent may take, the
+	// default if zero
+	AgentDialTimeout time.Duration
+	// Agent is what guests are operated on through, a mistify-agent client
+	// configured with the agent settings above if nil
+	Agent agent.GuestAgent
+	// Workers is the number of jobs to work on at the same time
+	Workers uint
+	// DesiredState completes guest create and delete jobs from hypervisor
+	// desired state acks
+	DesiredState bool
+	// LeaseTTL is how long a job is claimed by a worker between
+	// reservations
+	LeaseTTL time.Duration
+	// ReapInterval is how often to reclaim abandoned jobs and roll back
+	// abandoned cleanup journals, 0 to disable
+	ReapInterval time.Duration
+	// MaxReclaims is the number of times an abandoned job is requeued before
+	// it is failed
+	MaxReclaims int
+	// Queues are the job queues tasks are taken from, in order of priority.
+	// Tasks are only taken from a queue while those before it have none
+	// ready. Without queues, tasks are taken from the default queue.
+	Queues []string
+}
+
+// Start starts the workers and the reaper of abandoned jobs, recording metrics
+// in m. Workers run until the process exits, so it should only be called
+// once per process.
+func Start(KV kv.KV, cfg Config, m *metrics.Metrics) error {
+	if cfg.Workers == 0 {
+		return errors.New("at least one worker is required")
+	}
+	if cfg.LeaseTTL > 0 {
+		leaseTTL = cfg.LeaseTTL
+	}
+
+	ctx := lochness.NewContext(KV)
+	if cfg.DesiredState {
+		desiredStateCtx = ctx
+	}
+
+	guestAgent := cfg.Agent
+	if guestAgent == nil {
+		mistify := agent.NewMistify(ctx, int(cfg.AgentPort))
+		if err := mistify.ConfigureTransport(cfg.AgentProxy, cfg.AgentDialTimeout); err != nil {
+			return err
+		}
+		guestAgent = mistify
+	}
+	locks := newGuestLocks(KV)
+
+	hostname, _ := os.Hostname()
+
+	// Reclaim jobs abandoned by workers that died
+	if cfg.ReapInterval > 0 {
+		reaperQueue, err := jobqueue.NewClient(cfg.Beanstalk, KV)
+		if err != nil {
+			return err
+		}
+		r := &reaper{
+			jobQueue:    reaperQueue,
+			m:           m,
+			leaseTTL:    leaseTTL,
+			maxReclaims: cfg.MaxReclaims,
+			janitor:     ctx.NewJanitor(),
+		}
+		go r.run(cfg.ReapInterval)
+	}
+
+	// Each worker has its own beanstalk connection, since they are not safe
+	// for concurrent use
+	for i := uint(0); i < cfg.Workers; i++ {
+		log.WithFields(log.Fields{
+			"address": cfg.Beanstalk,
+			"worker":  i,
+		}).Info("connection to beanstalk")
+		jobQueue, err := jobqueue.NewClient(cfg.Beanstalk, KV)
+		if err != nil {
+			return err
+		}
+		jobQueue.SetWorkQueues(cfg.Queues)
+
+		worker := fmt.Sprintf("%s/%d/%d", hostname, os.Getpid(), i)
+		go func() {
+			// Start consuming
+			for {
+				consume(jobQueue, ctx, guestAgent, m, locks, worker, cfg.Queues)
+			}
+		}()
+	}
+	return nil
+}
+
+func consume(jobQueue *jobqueue.Client, ctx *lochness.Context, guestAgent agent.GuestAgent, m *metrics.Metrics, locks *guestLocks, worker string, queues []string) {
+	// Wait for and reserve a job
+	task, err := jobQueue.NextWorkTask()
+	if err != nil {
+		if bCE, ok := err.(beanstalk.ConnError); ok {
+			switch bCE {
+			case beanstalk.ErrTimeout:
+				// Empty queue
+				return
+			case beanstalk.ErrDeadline:
+				// See docs on beanstalkd deadline
+				// We're just going to sleep to let the deadline'd job expire
+				// and try to get another job
+				m.IncrCounter([]string{"beanstalk", "error", "deadline"}, 1)
+				log.Debug(beanstalk.ErrDeadline)
+				time.Sleep(5 * time.Second)
+				return
+			default:
+				// You have failed me for the last time
+				log.WithField("error", err).Fatal(err)
+			}
+		}
+
+		log.WithFields(log.Fields{
+			"task":  task,
+			"error": err,
+		}).Error("invalid task")
+
+		if task.Job != nil {
+			updateJobStatus(task, jobqueue.JobStatusError, err)
+		}
+		if err := task.Delete(); err != nil {
+			log.WithFields(log.Fields{
+				"task":  task.ID,
+				"error": err,
+			}).Error("unable to delete")
+		}
+		return
+	}
+
+	logFields := log.Fields{
+		"task":        task,
+		"queue":       task.Job.Queue,
+		tracing.Field: task.Job.TraceID,
+	}
+
+	// Tasks of the queues after the first are stolen while it is empty
+	if stolen(queues, task.Job.Queue) {
+		m.IncrCounter([]string{"tasks", "stolen"}, 1)
+	}
+
+	// Jobs are only started once the jobs they depend on have succeeded
+	if task.Job.Status == jobqueue.JobStatusNew && len(task.Job.DependsOn) > 0 {
+		if !checkDependencies(jobQueue, task, m, logFields) {
+			return
+		}
+	}
+
+	// Only one job may work on a guest at a time
+	guestLock, err := locks.acquire(task)
+	if err != nil || guestLock == nil {
+		if err != nil {
+			log.WithFields(logFields).WithField("error", err).Error("unable to lock guest")
+		} else {
+			m.IncrCounter([]string{"guest", "busy"}, 1)
+			log.WithFields(logFields).Debug("guest busy")
+		}
+		log.WithFields(logFields).Info("releasing task")
+		if err := task.Release(); err != nil {
+			log.WithFields(logFields).WithField("error", err).Fatal(err)
+		}
+		return
+	}
+
+	// Claim the job so it can be reclaimed if this worker dies
+	if err := task.Lease(worker, leaseTTL); err != nil {
+		log.WithFields(logFields).WithField("error", err).Error("unable to lease job")
+	}
+
+	// The work on the job is a span of the trace of the request that queued
+	// it, continued in the calls to the agent
+	jobCtx, span := tracing.Start(task.Job.Context(context.Background()), "job "+task.Job.Action, trace.SpanKindConsumer)
+	span.SetAttributes(
+		attribute.String("job.id", task.Job.ID),
+		attribute.String("job.status", task.Job.Status),
+		attribute.String("guest", task.Job.Guest),
+	)
+
+	// Handle the task in its current state. Remove task when appropriate.
+	removeTask, err := processTask(task, ctx, guestAgent.WithContext(jobCtx))
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+
+	if removeTask {
+		if err != nil {
+			log.WithFields(logFields).WithField("error", err).Error(err)
+			if task.Job != nil {
+				updateJobStatus(task, jobqueue.JobStatusError, err)
+			}
+		} else {
+			updateJobStatus(task, jobqueue.JobStatusDone, nil)
+		}
+		if task.Job != nil {
+			log.WithFields(logFields).WithField("status", task.Job.Status).Info("job status info")
+		}
+
+		updateMetrics(task, m)
+
+		log.WithFields(logFields).Info("removing task")
+		if err := task.Delete(); err != nil {
+			log.WithFields(logFields).WithField("error", err).Error("unable to delete")
+		}
+		if err := jobQueue.DeleteLease(task.Job.ID); err != nil {
+			log.WithFields(logFields).WithField("error", err).Error("unable to delete lease")
+		}
+	} else {
+		log.WithFields(logFields).Info("releasing task")
+		if err := task.Release(); err != nil {
+			log.WithFields(logFields).WithField("error", err).Fatal(err)
+		}
+	}
+
+	if err := locks.release(guestLock, task, removeTask); err != nil {
+		log.WithFields(logFields).WithField("error", err).Error("unable to unlock guest")
+	}
+}
+
+func processTask(task *jobqueue.Task, ctx *lochness.Context, guestAgent agent.GuestAgent) (bool, error) {
+	logFields := log.Fields{
+		"task":        task,
+		tracing.Field: task.Job.TraceID,
+	}
+	log.WithFields(logFields).Info("reserved task")
+
+	switch task.Job.Status {
+	case jobqueue.JobStatusDone:
+		var err error
+		if task.Job.Action == "delete" {
+			err = postDelete(task)
+		}
+		return true, err
+	case jobqueue.JobStatusError:
+		return true, nil
+	case jobqueue.JobStatusNew:
+		if err := startJob(task, ctx, guestAgent); err != nil {
+			if task.Job.Action == "resize" {
+				cancelResize(task)
+			}
+			return true, err
+		}
+	case jobqueue.JobStatusWorking:
+		if done, err := checkWorkingJob(task, ctx, guestAgent); done || err != nil {
+			log.WithFields(log.Fields{
+				"task":        task.ID,
+				tracing.Field: task.Job.TraceID,
+			}).Info("JOB DONE")
+
+			if err == nil {
+				switch task.Job.Action {
+				case "delete":
+					err = postDelete(task)
+				case "snapshot":
+					recordSnapshot(task)
+				case "resize":
+					err = completeResize(task)
+				default:
+					recordGuestState(task)
+				}
+			} else if task.Job.Action == "resize" {
+				cancelResize(task)
+			}
+			return true, err
+		}
+	}
+
+	return false, nil
+}
+
+func startJob(task *jobqueue.Task, ctx *lochness.Context, guestAgent agent.GuestAgent) error {
+	job := task.Job
+
+	if task.Guest == nil {
+		return errors.New("guest does not exist")
+	}
+
+	if desiredStateCtx != nil && desiredStateActions[job.Action] {
+		return startDesiredStateJob(task)
+	}
+
+	var err error
+	var jobID string
+	switch job.Action {
+	case "fetch":
+		// clones' disks are cloned from their source's instead
+		if task.Guest.CloneOf != "" {
+			return fetchDone(task)
+		}
+		var fetched bool
+		if fetched, err = imageFetched(ctx, task.Guest); err != nil {
+			return err
+		}
+		if fetched {
+			log.WithField("task", task).Info("image already fetched")
+			return fetchDone(task)
+		}
+		if jobID, err = guestAgent.FetchImage(task.Guest.ID); err == nil {
+			recordImageFetch(ctx, task.Guest, lochness.ImageFetching, nil)
+		}
+	case "create":
+		if task.Guest.CloneOf != "" {
+			jobID, err = guestAgent.Clone(task.Guest.ID)
+		} else {
+			jobID, err = guestAgent.Create(task.Guest.ID)
+		}
+	case "delete":
+		jobID, err = guestAgent.Delete(task.Guest.ID)
+	case "snapshot":
+		// snapshots are named for when the job started, so the name can be
+		// recorded once the snapshot completes
+		task.Job.StartedAt = time.Now()
+		jobID, err = guestAgent.Snapshot(task.Guest.ID, lochness.SnapshotName(task.Job.StartedAt))
+	case "resize":
+		jobID, err = guestAgent.Resize(task.Guest.ID)
+	default:
+		if _, ok := config.ValidActions[job.Action]; !ok {
+			return errors.New("invalid action")
+		}
+		jobID, err = guestAgent.Action(task.Guest.ID, job.Action)
+	}
+
+	if err != nil {
+		return err
+	}
+	task.Job.RemoteID = jobID
+	updateJobStatus(task, jobqueue.JobStatusWorking, nil)
+	return nil
+}
+
+func checkWorkingJob(task *jobqueue.Task, ctx *lochness.Context, guestAgent agent.GuestAgent) (bool, error) {
+	if desiredStateCtx != nil && desiredStateActions[task.Job.Action] {
+		return checkDesiredStateJob(task)
+	}
+
+	done, err := guestAgent.JobStatus(task.Guest.ID, task.Job.RemoteID)
+	if task.Job.Action == "fetch" && (done || err != nil) {
+		if err != nil {
+			recordImageFetch(ctx, task.Guest, lochness.ImageFetchFailed, err)
+			return done, err
+		}
+		recordImageFetch(ctx, task.Guest, lochness.ImageFetched, nil)
+		return false, fetchDone(task)
+	}
+
+	return done, err
+}
+
+// startDesiredStateJob records the desired state generation a job is waiting
+// on. For deletes, the guest is first removed from its hypervisor so that it is
+// dropped from the desired state.
+func startDesiredStateJob(task *jobqueue.Task) error {
+	if task.Guest.HypervisorID == "" {
+		return errors.New("guest is not assigned to a hypervisor")
+	}
+
+	hypervisor, err := desiredStateCtx.Hypervisor(task.Guest.HypervisorID)
+	if err != nil {
+		return err
+	}
+
+	if task.Job.Action == "delete" {
+		if err := hypervisor.RemoveGuest(task.Guest); err != nil {
+			return err
+		}
+	}
+
+	generation, err := hypervisor.Generation()
+	if err != nil {
+		return err
+	}
+
+	task.Job.RemoteID = fmt.Sprintf("%s/%d", hypervisor.ID, generation)
+	updateJobStatus(task, jobqueue.JobStatusWorking, nil)
+	return nil
+}
+
+// checkDesiredStateJob checks whether the hypervisor has acked the generation
+// recorded by startDesiredStateJob. The job fails only with an error acked for
+// that very generation, a later ack superseding it.
+func checkDesiredStateJob(task *jobqueue.Task) (bool, error) {
+	parts := strings.SplitN(task.Job.RemoteID, "/", 2)
+	if len(parts) != 2 {
+		return true, errors.New("invalid desired state job id")
+	}
+	generation, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return true, err
+	}
+
+	hypervisor, err := desiredStateCtx.Hypervisor(parts[0])
+	if err != nil {
+		return true, err
+	}
+	ack, err := hypervisor.DesiredStateAck()
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case ack == nil || ack.Generation < generation:
+		return false, nil
+	case ack.Generation > generation:
+		// the hypervisor converged on a later desired state, which
+		// supersedes the job's, so the outcome of that one is not the job's
+		log.WithFields(log.Fields{
+			"job":        task.Job.ID,
+			"hypervisor": hypervisor.ID,
+			"generation": generation,
+			"acked":      ack.Generation,
+		}).Info("desired state superseded")
+		return true, nil
+	case ack.Error != "":
+		return true, errors.New(ack.Error)
+	}
+	return true, nil
+}
+
+// checkDependencies returns whether the task's job may start, the jobs it
+// depends on having succeeded. The task is released to wait for them
+// otherwise, or deleted along with failing its job if one of them failed.
+func checkDependencies(jobQueue *jobqueue.Client, task *jobqueue.Task, m *metrics.Metrics, logFields log.Fields) bool {
+	done, err := jobQueue.DependenciesDone(task.Job)
+	if done && err == nil {
+		return true
+	}
+
+	if depErr, ok := err.(*jobqueue.DependencyError); ok {
+		m.IncrCounter([]string{"jobs", "dependency", "failed"}, 1)
+		log.WithFields(logFields).WithField("dependency", depErr.Dependency).Warn("dependency failed")
+		updateJobStatus(task, jobqueue.JobStatusError, err)
+		log.WithFields(logFields).Info("removing task")
+		if err := task.Delete(); err != nil {
+			log.WithFields(logFields).WithField("error", err).Error("unable to delete")
+		}
+		return false
+	}
+
+	if err != nil {
+		log.WithFields(logFields).WithField("error", err).Error("unable to check dependencies")
+	} else {
+		m.IncrCounter([]string{"jobs", "dependency", "waiting"}, 1)
+		log.WithFields(logFields).Debug("waiting on dependencies")
+	}
+	log.WithFields(logFields).Info("releasing task")
+	if err := task.Release(); err != nil {
+		log.WithFields(logFields).WithField("error", err).Fatal(err)
+	}
+	return false
+}
+
+// stolen returns whether a task of the job queue was taken from one of the
+// lower priority queues, the worker's first queue having none ready
+func stolen(queues []string, queue string) bool {
+	if len(queues) < 2 {
+		return false
+	}
+	first := queues[0]
+	if first == "" {
+		first = jobqueue.DefaultQueue
+	}
+	if queue == "" {
+		queue = jobqueue.DefaultQueue
+	}
+	return queue != first
+}
+
+func updateJobStatus(task *jobqueue.Task, status string, e error) {
+	task.Job.Status = status
+	if e != nil {
+		task.Job.Error = e.Error()
+	}
+	if task.Job.StartedAt.Equal(time.Time{}) {
+		task.Job.StartedAt = time.Now()
+	}
+	if status == jobqueue.JobStatusError || status == jobqueue.JobStatusDone {
+		task.Job.FinishedAt = time.Now()
+	}
+
+	// Save Job Status
+	if err := task.Job.Save(24 * time.Hour); err != nil {
+		log.WithFields(log.Fields{
+			"task":        task,
+			tracing.Field: task.Job.TraceID,
+			"error":       err,
+		}).Error("unable to save")
+	}
+}
+
+func postDelete(task *jobqueue.Task) error {
+	log.WithFields(log.Fields{
+		"task":        task,
+		tracing.Field: task.Job.TraceID,
+	}).Info("post delete")
+	return task.Guest.Destroy()
+}
+
+// recordGuestState records the state a completed action leaves the guest in,
+// for cguestd to check the guest's next action against
+func recordGuestState(task *jobqueue.Task) {
+	state := lochness.GuestActionState(task.Job.Action)
+	if state == "" {
+		return
+	}
+
+	guest := task.Guest
+	if err := guest.Refresh(); err != nil {
+		log.WithFields(log.Fields{
+			"task":        task,
+			tracing.Field: task.Job.TraceID,
+			"error":       err,
+		}).Error("unable to refresh guest")
+		return
+	}
+	if guest.Metadata == nil {
+		guest.Metadata = make(map[string]string)
+	}
+	guest.Metadata["state"] = state
+	if err := guest.Save(); err != nil {
+		log.WithFields(log.Fields{
+			"task":        task,
+			tracing.Field: task.Job.TraceID,
+			"state":       state,
+			"error":       err,
+		}).Error("unable to record guest state")
+		return
+	}
+	recordUsage(task)
+}
+
+// recordUsage records the usage of the guest once a completed action changed
+// its state or reservation. Usage is only reported, so failures are logged.
+func recordUsage(task *jobqueue.Task) {
+	if err := task.Guest.RecordUsage(); err != nil {
+		log.WithFields(log.Fields{
+			"task":        task,
+			tracing.Field: task.Job.TraceID,
+			"error":       err,
+		}).Error("unable to record guest usage")
+	}
+}
+
+// recordSnapshot records the name of the snapshot taken by a completed job as
+// the guest's latest, for it to be cloned from
+func recordSnapshot(task *jobqueue.Task) {
+	guest := task.Guest
+	if err := guest.Refresh(); err != nil {
+		log.WithFields(log.Fields{
+			"task":        task,
+			tracing.Field: task.Job.TraceID,
+			"error":       err,
+		}).Error("unable to refresh guest")
+		return
+	}
+	name := lochness.SnapshotName(task.Job.StartedAt)
+	guest.SetLatestSnapshot(name)
+	if err := guest.Save(); err != nil {
+		log.WithFields(log.Fields{
+			"task":     task,
+			"snapshot": name,
+			"error":    err,
+		}).Error("unable to record guest snapshot")
+	}
+}
+
+// completeResize makes the flavor a completed job resized the guest to its
+// flavor
+func completeResize(task *jobqueue.Task) error {
+	guest := task.Guest
+	if err := guest.Refresh(); err != nil {
+		return err
+	}
+	if err := guest.CompleteResize(); err != nil {
+		return err
+	}
+	recordUsage(task)
+	return nil
+}
+
+// cancelResize drops the flavor a failed job was resizing the guest to, so
+// the guest may be resized again
+func cancelResize(task *jobqueue.Task) {
+	guest := task.Guest
+	if guest == nil {
+		return
+	}
+	if err := guest.Refresh(); err != nil {
+		log.WithFields(log.Fields{
+			"task":        task,
+			tracing.Field: task.Job.TraceID,
+			"error":       err,
+		}).Error("unable to refresh guest")
+		return
+	}
+	if err := guest.CancelResize(); err != nil {
+		log.WithFields(log.Fields{
+			"task":        task,
+			tracing.Field: task.Job.TraceID,
+			"error":       err,
+		}).Error("unable to cancel guest resize")
+	}
+}
+
+func updateMetrics(task *jobqueue.Task, m *metrics.Metrics) {
+	job := task.Job
+	m.MeasureSince([]string{"action", job.Action, "time"}, job.StartedAt)
+	m.MeasureSince([]string{"action", "time"}, job.StartedAt)
+	m.IncrCounter([]string{"action", job.Action, "count"}, 1)
+	m.IncrCounter([]string{"action", "count"}, 1)
+	if job.Error != "" {
+		m.IncrCounter([]string{"action", job.Action, "error"}, 1)
+		m.IncrCounter([]string{"action", "error"}, 1)
+	}
+}
@@ -0,0 +1,57 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/mistifyio/lochness/pkg/jobqueue"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestDesiredStateJob(t *testing.T) {
+	suite.Run(t, new(DesiredStateJobSuite))
+}
+
+type DesiredStateJobSuite struct {
+	common.Suite
+}
+
+func (s *DesiredStateJobSuite) SetupTest() {
+	s.Suite.SetupTest()
+	desiredStateCtx = s.Context
+}
+
+func (s *DesiredStateJobSuite) TearDownTest() {
+	desiredStateCtx = nil
+	s.Suite.TearDownTest()
+}
+
+func (s *DesiredStateJobSuite) TestCheck() {
+	hypervisor := s.NewHypervisor()
+	task := &jobqueue.Task{Job: &jobqueue.Job{
+		ID:       uuid.New(),
+		RemoteID: fmt.Sprintf("%s/%d", hypervisor.ID, 5),
+	}}
+
+	done, err := checkDesiredStateJob(task)
+	s.NoError(err)
+	s.False(done, "job should wait for an ack")
+
+	s.Require().NoError(hypervisor.AckDesiredState(4, errors.New("earlier")))
+	done, err = checkDesiredStateJob(task)
+	s.NoError(err)
+	s.False(done, "an earlier ack should not complete the job")
+
+	s.Require().NoError(hypervisor.AckDesiredState(5, errors.New("failed")))
+	done, err = checkDesiredStateJob(task)
+	s.True(done)
+	s.EqualError(err, "failed", "an error acked for the job's generation should fail it")
+
+	s.Require().NoError(hypervisor.AckDesiredState(6, errors.New("later")))
+	done, err = checkDesiredStateJob(task)
+	s.True(done)
+	s.NoError(err, "a later ack should supersede the job's generation, not fail it")
+}
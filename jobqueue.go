@@ -0,0 +1,54 @@
+package lochness
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// JobQueueConfig is the config key, of the cluster or of a hypervisor, of the
+// named job queue the work on its guests is queued in, e.g. one per zone. The
+// work on guests whose hypervisor has none is queued in the default queue.
+var JobQueueConfig = "job-queue"
+
+// jobQueueRegexp matches job queue names, which name beanstalk tubes
+var jobQueueRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+
+// ParseJobQueues parses a comma separated list of job queue names, which must
+// be lowercase letters, digits, and dashes
+func ParseJobQueues(value string) ([]string, error) {
+	queues := splitList(value)
+	for _, queue := range queues {
+		if !jobQueueRegexp.MatchString(queue) {
+			return nil, newValidationError(JobQueueConfig, fmt.Sprintf("invalid job queue %q: must be lowercase letters, digits, and dashes", queue))
+		}
+	}
+	return queues, nil
+}
+
+// parseJobQueue parses the single job queue name of JobQueueConfig
+func parseJobQueue(value string) (string, error) {
+	queues, err := ParseJobQueues(value)
+	if err != nil {
+		return "", err
+	}
+	if len(queues) != 1 {
+		return "", newValidationError(JobQueueConfig, fmt.Sprintf("invalid %s %q: must be a single job queue", JobQueueConfig, value))
+	}
+	return queues[0], nil
+}
+
+// JobQueue returns the job queue the work on the hypervisor's guests is
+// queued in, from its config or the cluster's, or "" for the default queue
+func (h *Hypervisor) JobQueue() (string, error) {
+	value, ok := h.Config[JobQueueConfig]
+	if !ok {
+		var err error
+		if value, err = h.context.GetConfig(JobQueueConfig); err != nil {
+			if h.context.IsKeyNotFound(err) {
+				return "", nil
+			}
+			return "", err
+		}
+	}
+	return parseJobQueue(value)
+}
@@ -0,0 +1,68 @@
+package lochness_test
+
+import (
+	"testing"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestJobQueue(t *testing.T) {
+	suite.Run(t, new(JobQueueSuite))
+}
+
+type JobQueueSuite struct {
+	common.Suite
+}
+
+func (s *JobQueueSuite) TestParseJobQueues() {
+	tests := []struct {
+		description string
+		value       string
+		expected    []string
+		expectedErr bool
+	}{
+		{"empty", "", nil, false},
+		{"one", "zone-a", []string{"zone-a"}, false},
+		{"ordered", "zone-a, zone-b,default", []string{"zone-a", "zone-b", "default"}, false},
+		{"uppercase", "Zone-A", nil, true},
+		{"leading dash", "-zone", nil, true},
+		{"invalid character", "zone_a", nil, true},
+	}
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		queues, err := lochness.ParseJobQueues(test.value)
+		if test.expectedErr {
+			s.True(lerrors.IsValidation(err), msg("should be invalid"))
+		} else {
+			s.NoError(err, msg("should be valid"))
+			s.Equal(test.expected, queues, msg("should be parsed"))
+		}
+	}
+}
+
+func (s *JobQueueSuite) TestHypervisorJobQueue() {
+	hypervisor := s.NewHypervisor()
+
+	queue, err := hypervisor.JobQueue()
+	s.NoError(err)
+	s.Empty(queue, "hypervisors should default to the default queue")
+
+	s.Require().NoError(s.Context.SetConfig(lochness.JobQueueConfig, "zone-a"))
+	queue, err = hypervisor.JobQueue()
+	s.NoError(err)
+	s.Equal("zone-a", queue, "the cluster config should apply")
+
+	s.Require().NoError(hypervisor.SetConfig(lochness.JobQueueConfig, "zone-b"))
+	queue, err = hypervisor.JobQueue()
+	s.NoError(err)
+	s.Equal("zone-b", queue, "the hypervisor config should override the cluster's")
+}
+
+func (s *JobQueueSuite) TestValidateConfig() {
+	hypervisor := s.NewHypervisor()
+	s.True(lerrors.IsValidation(s.Context.SetConfig(lochness.JobQueueConfig, "zone-a,zone-b")))
+	s.True(lerrors.IsValidation(hypervisor.SetConfig(lochness.JobQueueConfig, "Zone A")))
+}
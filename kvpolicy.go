@@ -0,0 +1,172 @@
+package lochness
+
+import (
+	"errors"
+	"time"
+
+	"github.com/mistifyio/lochness/pkg/kv"
+)
+
+// ErrKVTimeout is returned when a KV operation takes longer than the timeout
+// of the Context, see Context.WithTimeout
+var ErrKVTimeout = errors.New("kv operation timed out")
+
+// DefaultKVRetryWait is a reasonable wait before the first retry of a failed
+// KV operation, see Context.WithRetry
+const DefaultKVRetryWait = 100 * time.Millisecond
+
+// KVPolicy is the timeout and retry policy applied to the KV operations of a
+// Context
+type KVPolicy struct {
+	Timeout   time.Duration
+	Retries   int
+	RetryWait time.Duration
+}
+
+// policyKV applies a KVPolicy to the operations on entities. Watches, locks,
+// and ephemeral keys are long lived and are passed through untouched.
+type policyKV struct {
+	kv.KV
+	policy KVPolicy
+}
+
+// withKVPolicy wraps a KV to apply the policy. A zero policy returns k
+// unchanged.
+func withKVPolicy(k kv.KV, policy KVPolicy) kv.KV {
+	if policy == (KVPolicy{}) {
+		return k
+	}
+	return &policyKV{
+		KV:     k,
+		policy: policy,
+	}
+}
+
+// result is the outcome of a KV operation
+type result struct {
+	value interface{}
+	err   error
+}
+
+// timeout runs fn, returning ErrKVTimeout if it takes too long. There is no
+// way to cancel a KV operation, so one that times out is left to finish in the
+// background and its result is discarded.
+func (p *policyKV) timeout(fn func() (interface{}, error)) (interface{}, error) {
+	if p.policy.Timeout <= 0 {
+		return fn()
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		value, err := fn()
+		done <- result{value, err}
+	}()
+
+	timer := time.NewTimer(p.policy.Timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-timer.C:
+		return nil, ErrKVTimeout
+	}
+}
+
+// retry runs fn with the timeout, retrying it as long as it fails with
+// anything but a key not found error, which retrying will not change
+func (p *policyKV) retry(fn func() (interface{}, error)) (interface{}, error) {
+	wait := p.policy.RetryWait
+	value, err := p.timeout(fn)
+	for i := 0; i < p.policy.Retries && err != nil && !p.KV.IsKeyNotFound(err); i++ {
+		time.Sleep(wait)
+		wait *= 2
+		value, err = p.timeout(fn)
+	}
+	return value, err
+}
+
+func (p *policyKV) Delete(key string, recurse bool) error {
+	_, err := p.retry(func() (interface{}, error) {
+		return nil, p.KV.Delete(key, recurse)
+	})
+	return err
+}
+
+func (p *policyKV) Get(key string) (kv.Value, error) {
+	value, err := p.retry(func() (interface{}, error) {
+		return p.KV.Get(key)
+	})
+	if err != nil {
+		return kv.Value{}, err
+	}
+	return value.(kv.Value), nil
+}
+
+func (p *policyKV) GetAll(prefix string) (map[string]kv.Value, error) {
+	values, err := p.retry(func() (interface{}, error) {
+		return p.KV.GetAll(prefix)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values.(map[string]kv.Value), nil
+}
+
+func (p *policyKV) Keys(key string) ([]string, error) {
+	keys, err := p.retry(func() (interface{}, error) {
+		return p.KV.Keys(key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys.([]string), nil
+}
+
+func (p *policyKV) Set(key, value string) error {
+	_, err := p.retry(func() (interface{}, error) {
+		return nil, p.KV.Set(key, value)
+	})
+	return err
+}
+
+// Update is not retried, since an update that timed out may still have been
+// made, and retrying it would then fail as a conflict
+func (p *policyKV) Update(key string, value kv.Value) (uint64, error) {
+	index, err := p.timeout(func() (interface{}, error) {
+		return p.KV.Update(key, value)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return index.(uint64), nil
+}
+
+// Remove is not retried, for the same reason as Update
+func (p *policyKV) Remove(key string, index uint64) error {
+	_, err := p.timeout(func() (interface{}, error) {
+		return nil, p.KV.Remove(key, index)
+	})
+	return err
+}
+
+// Txn is not retried, for the same reason as Update
+func (p *policyKV) Txn(ops []kv.Op) ([]uint64, error) {
+	indexes, err := p.timeout(func() (interface{}, error) {
+		return kv.Txn(p.KV, ops)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return indexes.([]uint64), nil
+}
+
+func (p *policyKV) Ping() error {
+	_, err := p.retry(func() (interface{}, error) {
+		return nil, p.KV.Ping()
+	})
+	return err
+}
+
+func (p *policyKV) WatchesPrev() bool {
+	return kv.WatchesPrev(p.KV)
+}
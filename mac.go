@@ -0,0 +1,96 @@
+package lochness
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+)
+
+var (
+	// MACOUIConfig is the config key of the OUI that generated guest MACs
+	// start with
+	MACOUIConfig = "mac-oui"
+
+	// DefaultMACOUI is the OUI of generated MACs when none is configured. It
+	// is locally administered, so it does not clash with real hardware.
+	DefaultMACOUI = "02:00:00"
+
+	// MACAttempts is how many random MACs are tried before giving up on
+	// finding one that no guest has
+	MACAttempts = 16
+)
+
+// ParseOUI parses an OUI, the first three bytes of a MAC, e.g. "52:54:00" or
+// "52-54-00". It must be unicast, since guests can not use multicast MACs.
+func ParseOUI(oui string) (net.HardwareAddr, error) {
+	parts := strings.FieldsFunc(oui, func(r rune) bool { return r == ':' || r == '-' })
+	if len(parts) != 3 {
+		return nil, newValidationError("mac_oui", fmt.Sprintf("invalid oui %q: must be three bytes", oui))
+	}
+	prefix := make(net.HardwareAddr, 3)
+	for i, part := range parts {
+		b, err := strconv.ParseUint(part, 16, 8)
+		if err != nil || len(part) != 2 {
+			return nil, newValidationError("mac_oui", fmt.Sprintf("invalid oui %q: must be hex bytes", oui))
+		}
+		prefix[i] = byte(b)
+	}
+	if prefix[0]&1 != 0 {
+		return nil, newValidationError("mac_oui", fmt.Sprintf("invalid oui %q: must be unicast", oui))
+	}
+	return prefix, nil
+}
+
+// MACOUI returns the OUI of generated MACs configured for the cluster, or
+// DefaultMACOUI
+func (c *Context) MACOUI() (string, error) {
+	oui, err := c.GetConfig(MACOUIConfig)
+	if err != nil {
+		if c.IsKeyNotFound(err) {
+			return DefaultMACOUI, nil
+		}
+		return "", err
+	}
+	return oui, nil
+}
+
+// GenerateMAC returns a random MAC starting with the OUI given, or the one
+// configured for the cluster if it is empty, that no guest has. Guests saved
+// concurrently may still be given the same MAC, though it is unlikely.
+func (c *Context) GenerateMAC(oui string) (net.HardwareAddr, error) {
+	if oui == "" {
+		var err error
+		if oui, err = c.MACOUI(); err != nil {
+			return nil, err
+		}
+	}
+	prefix, err := ParseOUI(oui)
+	if err != nil {
+		return nil, err
+	}
+
+	used := make(map[string]bool)
+	err = c.ForEachGuest(func(g *Guest) error {
+		used[g.MAC.String()] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	mac := make(net.HardwareAddr, 6)
+	copy(mac, prefix)
+	for i := 0; i < MACAttempts; i++ {
+		if _, err := rand.Read(mac[3:]); err != nil {
+			return nil, err
+		}
+		if !used[mac.String()] {
+			return mac, nil
+		}
+	}
+	return nil, lerrors.Conflictf("no unused mac found with oui %s", prefix)
+}
@@ -0,0 +1,76 @@
+package lochness_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestMAC(t *testing.T) {
+	suite.Run(t, new(MACSuite))
+}
+
+type MACSuite struct {
+	common.Suite
+}
+
+func (s *MACSuite) TestParseOUI() {
+	tests := []struct {
+		description string
+		oui         string
+		expected    net.HardwareAddr
+		expectedErr bool
+	}{
+		{"colons", "52:54:00", net.HardwareAddr{0x52, 0x54, 0x00}, false},
+		{"dashes", "52-54-0A", net.HardwareAddr{0x52, 0x54, 0x0a}, false},
+		{"empty", "", nil, true},
+		{"too short", "52:54", nil, true},
+		{"too long", "52:54:00:01", nil, true},
+		{"not hex", "52:54:zz", nil, true},
+		{"short byte", "52:54:0", nil, true},
+		{"multicast", "01:00:5e", nil, true},
+	}
+
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		prefix, err := lochness.ParseOUI(test.oui)
+		if test.expectedErr {
+			s.True(lerrors.IsValidation(err), msg("should be a validation error"))
+		} else {
+			s.NoError(err, msg("should parse"))
+			s.Equal(test.expected, prefix, msg("should return the bytes"))
+		}
+	}
+}
+
+func (s *MACSuite) TestGenerateMAC() {
+	mac, err := s.Context.GenerateMAC("52:54:00")
+	s.Require().NoError(err)
+	s.Equal(net.HardwareAddr{0x52, 0x54, 0x00}, mac[:3], "should start with the oui")
+	s.Len(mac, 6)
+
+	mac, err = s.Context.GenerateMAC("")
+	s.Require().NoError(err)
+	s.Equal(lochness.DefaultMACOUI, mac[:3].String(), "should default to DefaultMACOUI")
+
+	s.Require().NoError(s.Context.SetConfig(lochness.MACOUIConfig, "a2:00:01"))
+	mac, err = s.Context.GenerateMAC("")
+	s.Require().NoError(err)
+	s.Equal("a2:00:01", mac[:3].String(), "should use the configured oui")
+
+	_, err = s.Context.GenerateMAC("01:00:5e")
+	s.True(lerrors.IsValidation(err), "invalid oui should fail")
+}
+
+func (s *MACSuite) TestGenerateMACExhausted() {
+	attempts := lochness.MACAttempts
+	defer func() { lochness.MACAttempts = attempts }()
+
+	lochness.MACAttempts = 0
+	_, err := s.Context.GenerateMAC("52:54:00")
+	s.True(lerrors.IsConflict(err), "running out of attempts should conflict")
+}
@@ -0,0 +1,264 @@
+package lochness
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mistifyio/lochness/pkg/kv"
+)
+
+var (
+	// MetadataIndexPath is the path in the config store of the metadata
+	// index, see MetadataIndexKey
+	MetadataIndexPath = "lochness/metadata-index/"
+)
+
+// Kinds of entity in the metadata index
+const (
+	MetadataKindGuests      = "guests"
+	MetadataKindHypervisors = "hypervisors"
+)
+
+// metadataIndexPrefix is the key under which the ids of the entities of a kind
+// with a metadata pair are indexed
+func metadataIndexPrefix(kind, key, value string) string {
+	return filepath.Join(MetadataIndexPath, kind, escapeMetadata(key), escapeMetadata(value))
+}
+
+// MetadataIndexKey returns the key in the config store indexing a metadata
+// pair of a guest or hypervisor, <kind>/<key>/<value>/<id> under
+// MetadataIndexPath
+func MetadataIndexKey(kind, key, value, id string) string {
+	return filepath.Join(metadataIndexPrefix(kind, key, value), id)
+}
+
+// escapeMetadata escapes a metadata key or value as a single path element.
+// Empty ones are escaped as %00, since path elements can not be empty, and "."
+// and ".." are escaped too, since they would otherwise be cleaned out of the
+// path.
+func escapeMetadata(s string) string {
+	switch s {
+	case "":
+		return "%00"
+	case ".":
+		return "%2E"
+	case "..":
+		return "%2E%2E"
+	}
+	return url.PathEscape(s)
+}
+
+// copyMetadata copies metadata, so that the copy is not changed along with it
+func copyMetadata(metadata map[string]string) map[string]string {
+	c := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		c[k] = v
+	}
+	return c
+}
+
+// metadataOps returns the writes updating the metadata index of an entity from
+// the metadata it was indexed with to its current metadata, to be applied in
+// the same transaction as the entity. Pairs that did not change are left alone,
+// as are index keys that are already as they should be.
+func (c *Context) metadataOps(kind, id string, indexed, metadata map[string]string) ([]kv.Op, error) {
+	var ops []kv.Op
+	for k, v := range indexed {
+		if current, ok := metadata[k]; ok && current == v {
+			continue
+		}
+		key := MetadataIndexKey(kind, k, v, id)
+		value, err := c.kv.Get(key)
+		if err != nil {
+			if c.kv.IsKeyNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		ops = append(ops, kv.Op{
+			Key:    key,
+			Value:  kv.Value{Index: value.Index},
+			Remove: true,
+		})
+	}
+	for k, v := range metadata {
+		if old, ok := indexed[k]; ok && old == v {
+			continue
+		}
+		key := MetadataIndexKey(kind, k, v, id)
+		var index uint64
+		value, err := c.kv.Get(key)
+		switch {
+		case err == nil && string(value.Data) == id:
+			// already indexed
+			continue
+		case err == nil:
+			index = value.Index
+		case !c.kv.IsKeyNotFound(err):
+			return nil, err
+		}
+		ops = append(ops, kv.Op{
+			Key:   key,
+			Value: kv.Value{Data: []byte(id), Index: index},
+		})
+	}
+	return ops, nil
+}
+
+// metadataIDs returns the ids of the entities of a kind with a metadata pair
+func (c *Context) metadataIDs(kind, key, value string) ([]string, error) {
+	keys, err := c.kv.Keys(metadataIndexPrefix(kind, key, value))
+	if err != nil {
+		if c.kv.IsKeyNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	ids := make([]string, len(keys))
+	for i, k := range keys {
+		ids[i] = filepath.Base(k)
+	}
+	return ids, nil
+}
+
+// GuestsByMetadata returns the guests whose metadata has key set to value,
+// looked up in the metadata index rather than by loading every guest
+func (c *Context) GuestsByMetadata(key, value string) (Guests, error) {
+	ids, err := c.metadataIDs(MetadataKindGuests, key, value)
+	if err != nil {
+		return nil, err
+	}
+	guests := make(Guests, 0, len(ids))
+	for _, id := range ids {
+		g, err := c.Guest(id)
+		if err != nil {
+			// the guest was destroyed since the index was read
+			if c.kv.IsKeyNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		if g.Metadata[key] == value {
+			guests = append(guests, g)
+		}
+	}
+	return guests, nil
+}
+
+// HypervisorsByMetadata returns the hypervisors whose metadata has key set to
+// value, looked up in the metadata index rather than by loading every
+// hypervisor
+func (c *Context) HypervisorsByMetadata(key, value string) (Hypervisors, error) {
+	ids, err := c.metadataIDs(MetadataKindHypervisors, key, value)
+	if err != nil {
+		return nil, err
+	}
+	hypervisors := make(Hypervisors, 0, len(ids))
+	for _, id := range ids {
+		h, err := c.Hypervisor(id)
+		if err != nil {
+			// the hypervisor was destroyed since the index was read
+			if c.kv.IsKeyNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		if h.Metadata[key] == value {
+			hypervisors = append(hypervisors, h)
+		}
+	}
+	return hypervisors, nil
+}
+
+// MetadataFilters are metadata pairs that selected guests or hypervisors must
+// all have
+type MetadataFilters map[string]string
+
+// ParseMetadataFilters parses metadata filters of the form key=value
+func ParseMetadataFilters(filters []string) (MetadataFilters, error) {
+	f := make(MetadataFilters, len(filters))
+	for _, filter := range filters {
+		parts := strings.SplitN(filter, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, newValidationError("metadata", fmt.Sprintf("invalid metadata filter %q: must be key=value", filter))
+		}
+		f[parts[0]] = parts[1]
+	}
+	return f, nil
+}
+
+// Match returns whether metadata has all of the pairs of the filters
+func (f MetadataFilters) Match(metadata map[string]string) bool {
+	for k, v := range f {
+		if value, ok := metadata[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+// indexed returns the pair of the filters to look up in the metadata index,
+// the first by key so that lookups are repeatable
+func (f MetadataFilters) indexed() (string, string) {
+	keys := make([]string, 0, len(f))
+	for k := range f {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys[0], f[keys[0]]
+}
+
+// GuestsMatching returns the guests whose metadata matches the filters, all of
+// the guests if there are none. Only the guests indexed with one of the pairs
+// are loaded.
+func (c *Context) GuestsMatching(filters MetadataFilters) (Guests, error) {
+	if len(filters) == 0 {
+		guests := make(Guests, 0)
+		err := c.ForEachGuest(func(g *Guest) error {
+			guests = append(guests, g)
+			return nil
+		})
+		return guests, err
+	}
+
+	candidates, err := c.GuestsByMetadata(filters.indexed())
+	if err != nil {
+		return nil, err
+	}
+	guests := make(Guests, 0, len(candidates))
+	for _, g := range candidates {
+		if filters.Match(g.Metadata) {
+			guests = append(guests, g)
+		}
+	}
+	return guests, nil
+}
+
+// HypervisorsMatching returns the hypervisors whose metadata matches the
+// filters, all of the hypervisors if there are none. Only the hypervisors
+// indexed with one of the pairs are loaded.
+func (c *Context) HypervisorsMatching(filters MetadataFilters) (Hypervisors, error) {
+	if len(filters) == 0 {
+		hypervisors := make(Hypervisors, 0)
+		err := c.ForEachHypervisor(func(h *Hypervisor) error {
+			hypervisors = append(hypervisors, h)
+			return nil
+		})
+		return hypervisors, err
+	}
+
+	candidates, err := c.HypervisorsByMetadata(filters.indexed())
+	if err != nil {
+		return nil, err
+	}
+	hypervisors := make(Hypervisors, 0, len(candidates))
+	for _, h := range candidates {
+		if filters.Match(h.Metadata) {
+			hypervisors = append(hypervisors, h)
+		}
+	}
+	return hypervisors, nil
+}
@@ -0,0 +1,160 @@
+package lochness_test
+
+import (
+	"testing"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestMetadataIndex(t *testing.T) {
+	suite.Run(t, new(MetadataIndexSuite))
+}
+
+type MetadataIndexSuite struct {
+	common.Suite
+}
+
+// guestIDs returns the ids of guests
+func guestIDs(guests lochness.Guests) []string {
+	ids := make([]string, len(guests))
+	for i, g := range guests {
+		ids[i] = g.ID
+	}
+	return ids
+}
+
+func (s *MetadataIndexSuite) TestGuestsByMetadata() {
+	prod := s.NewGuest()
+	prod.Metadata = map[string]string{"env": "prod", "team": "a/b"}
+	s.Require().NoError(prod.Save())
+	dev := s.NewGuest()
+	dev.Metadata = map[string]string{"env": "dev", "note": ""}
+	s.Require().NoError(dev.Save())
+
+	guests, err := s.Context.GuestsByMetadata("env", "prod")
+	s.NoError(err)
+	s.Equal([]string{prod.ID}, guestIDs(guests))
+
+	guests, err = s.Context.GuestsByMetadata("team", "a/b")
+	s.NoError(err)
+	s.Equal([]string{prod.ID}, guestIDs(guests), "should escape slashes")
+
+	guests, err = s.Context.GuestsByMetadata("note", "")
+	s.NoError(err)
+	s.Equal([]string{dev.ID}, guestIDs(guests), "should index empty values")
+
+	guests, err = s.Context.GuestsByMetadata("env", "staging")
+	s.NoError(err)
+	s.Empty(guests)
+
+	dots := s.NewGuest()
+	dots.Metadata = map[string]string{".": "..", "..": "."}
+	s.Require().NoError(dots.Save())
+	guests, err = s.Context.GuestsByMetadata(".", "..")
+	s.NoError(err)
+	s.Equal([]string{dots.ID}, guestIDs(guests), "should escape dots")
+	guests, err = s.Context.GuestsByMetadata("..", ".")
+	s.NoError(err)
+	s.Equal([]string{dots.ID}, guestIDs(guests), "should escape dots")
+	s.Require().NoError(dots.Destroy())
+
+	// Changing a value moves the guest in the index
+	dev.Metadata["env"] = "prod"
+	s.Require().NoError(dev.Save())
+	guests, err = s.Context.GuestsByMetadata("env", "dev")
+	s.NoError(err)
+	s.Empty(guests)
+	guests, err = s.Context.GuestsByMetadata("env", "prod")
+	s.NoError(err)
+	s.ElementsMatch([]string{prod.ID, dev.ID}, guestIDs(guests))
+
+	// A guest loaded from the kv updates the pairs it was saved with
+	loaded, err := s.Context.Guest(prod.ID)
+	s.Require().NoError(err)
+	delete(loaded.Metadata, "team")
+	s.Require().NoError(loaded.Save())
+	guests, err = s.Context.GuestsByMetadata("team", "a/b")
+	s.NoError(err)
+	s.Empty(guests)
+
+	s.Require().NoError(dev.Destroy())
+	guests, err = s.Context.GuestsByMetadata("env", "prod")
+	s.NoError(err)
+	s.Equal([]string{prod.ID}, guestIDs(guests), "destroyed guests should be removed")
+	keys, _ := s.KV.Keys(lochness.MetadataIndexPath + "guests/note")
+	s.Empty(keys)
+}
+
+func (s *MetadataIndexSuite) TestFailedSave() {
+	guest := s.NewGuest()
+	guest.Metadata = map[string]string{"env": "prod"}
+	s.Require().NoError(guest.Save())
+
+	stale, err := s.Context.Guest(guest.ID)
+	s.Require().NoError(err)
+	guest.Metadata["team"] = "a"
+	s.Require().NoError(guest.Save())
+
+	// the index is written in the transaction of the guest, so a conflict
+	// leaves it as it was
+	stale.Metadata["env"] = "dev"
+	s.Error(stale.Save())
+	guests, err := s.Context.GuestsByMetadata("env", "dev")
+	s.NoError(err)
+	s.Empty(guests)
+	guests, err = s.Context.GuestsByMetadata("env", "prod")
+	s.NoError(err)
+	s.Equal([]string{guest.ID}, guestIDs(guests))
+}
+
+func (s *MetadataIndexSuite) TestHypervisorsByMetadata() {
+	hypervisor := s.NewHypervisor()
+	hypervisor.Metadata = map[string]string{"rack": "a1"}
+	s.Require().NoError(hypervisor.Save())
+	_ = s.NewHypervisor()
+
+	hypervisors, err := s.Context.HypervisorsByMetadata("rack", "a1")
+	s.NoError(err)
+	s.Require().Len(hypervisors, 1)
+	s.Equal(hypervisor.ID, hypervisors[0].ID)
+
+	s.Require().NoError(hypervisor.Destroy())
+	hypervisors, err = s.Context.HypervisorsByMetadata("rack", "a1")
+	s.NoError(err)
+	s.Empty(hypervisors)
+}
+
+func (s *MetadataIndexSuite) TestParseMetadataFilters() {
+	filters, err := lochness.ParseMetadataFilters([]string{"env=prod", "note=", "expr=a=b"})
+	s.NoError(err)
+	s.Equal(lochness.MetadataFilters{"env": "prod", "note": "", "expr": "a=b"}, filters)
+
+	for _, filter := range []string{"env", "=prod", ""} {
+		_, err := lochness.ParseMetadataFilters([]string{filter})
+		s.Error(err, filter)
+	}
+}
+
+func (s *MetadataIndexSuite) TestGuestsMatching() {
+	web := s.NewGuest()
+	web.Metadata = map[string]string{"env": "prod", "role": "web"}
+	s.Require().NoError(web.Save())
+	db := s.NewGuest()
+	db.Metadata = map[string]string{"env": "prod", "role": "db"}
+	s.Require().NoError(db.Save())
+	other := s.NewGuest()
+
+	guests, err := s.Context.GuestsMatching(nil)
+	s.NoError(err)
+	s.ElementsMatch([]string{web.ID, db.ID, other.ID}, guestIDs(guests))
+
+	guests, err = s.Context.GuestsMatching(lochness.MetadataFilters{"env": "prod"})
+	s.NoError(err)
+	s.ElementsMatch([]string{web.ID, db.ID}, guestIDs(guests))
+
+	guests, err = s.Context.GuestsMatching(lochness.MetadataFilters{"env": "prod", "role": "db"})
+	s.NoError(err)
+	s.Equal([]string{db.ID}, guestIDs(guests))
+}
@@ -0,0 +1,243 @@
+package lochness
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mistifyio/lochness/pkg/kv"
+	"github.com/mistifyio/lochness/pkg/lock"
+)
+
+var (
+	// SchemaVersionKey is the key in the config store holding the schema
+	// version the records have been migrated to
+	SchemaVersionKey = "lochness/schema-version"
+
+	// MigrationLockKey is the key of the lock held while migrating, so that
+	// only one migration runs at a time
+	MigrationLockKey = "lochness/migrations/lock"
+
+	// MigrationLockTTL is how long the migration lock outlives a migration
+	// that stopped renewing it
+	MigrationLockTTL = 30 * time.Second
+
+	// ErrSchemaTooNew is returned when the records have been migrated to a
+	// schema version newer than the latest migration known
+	ErrSchemaTooNew = errors.New("schema version is newer than the latest migration known")
+
+	// ErrMigrationLockLost is returned when the migration lock could not be
+	// renewed during a migration, and another may have started
+	ErrMigrationLockLost = errors.New("migration lock lost")
+)
+
+type (
+	// MigrationFunc upgrades the records in the config store from the
+	// previous schema version. It should report its progress through
+	// progress, e.g. with MigrateRecords. A migration that fails part way
+	// is run again from the start, so it must be safe to rerun.
+	MigrationFunc func(c *Context, progress func(done, total int)) error
+
+	// Migration is a registered MigrationFunc
+	Migration struct {
+		Version     int
+		Description string
+		Func        MigrationFunc
+	}
+
+	// MigrationProgress is called as a migration goes through the records,
+	// with the number of records done out of total
+	MigrationProgress func(m Migration, done, total int)
+)
+
+var migrations = struct {
+	sync.RWMutex
+	m map[int]Migration
+}{
+	m: map[int]Migration{},
+}
+
+// RegisterMigration registers the migration of the records to version, from
+// the version before it. Versions start at 1 and must not be skipped.
+func RegisterMigration(version int, description string, fn MigrationFunc) {
+	migrations.Lock()
+	defer migrations.Unlock()
+
+	if version < 1 {
+		panic("lochness: RegisterMigration called with version " + strconv.Itoa(version))
+	}
+	if _, dup := migrations.m[version]; dup {
+		panic("lochness: RegisterMigration called twice for version " + strconv.Itoa(version))
+	}
+	migrations.m[version] = Migration{
+		Version:     version,
+		Description: description,
+		Func:        fn,
+	}
+}
+
+// Migrations returns the registered migrations, ordered by version
+func Migrations() []Migration {
+	migrations.RLock()
+	defer migrations.RUnlock()
+
+	ms := make([]Migration, 0, len(migrations.m))
+	for _, m := range migrations.m {
+		ms = append(ms, m)
+	}
+	sort.Slice(ms, func(i, j int) bool {
+		return ms[i].Version < ms[j].Version
+	})
+	return ms
+}
+
+// LatestSchemaVersion returns the version of the latest registered migration,
+// or 0 if there are none
+func LatestSchemaVersion() int {
+	ms := Migrations()
+	if len(ms) == 0 {
+		return 0
+	}
+	return ms[len(ms)-1].Version
+}
+
+// SchemaVersion returns the schema version the records have been migrated to,
+// 0 if they have never been
+func (c *Context) SchemaVersion() (int, error) {
+	value, err := c.kv.Get(SchemaVersionKey)
+	if err != nil {
+		if c.IsKeyNotFound(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	var version int
+	if err := json.Unmarshal(value.Data, &version); err != nil {
+		return 0, fmt.Errorf("invalid schema version: %s", err)
+	}
+	return version, nil
+}
+
+// setSchemaVersion stores the schema version the records have been migrated
+// to
+func (c *Context) setSchemaVersion(version int) error {
+	return c.kv.Set(SchemaVersionKey, strconv.Itoa(version))
+}
+
+// PendingMigrations returns the migrations that have not been applied yet, in
+// order. ErrSchemaTooNew is returned if the records have been migrated past
+// the latest migration known, e.g. by a newer lochness.
+func (c *Context) PendingMigrations() ([]Migration, error) {
+	version, err := c.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	all := Migrations()
+	if version > LatestSchemaVersion() {
+		return nil, ErrSchemaTooNew
+	}
+
+	pending := []Migration{}
+	for _, m := range all {
+		if m.Version > version {
+			pending = append(pending, m)
+		}
+	}
+	for i, m := range pending {
+		if m.Version != version+i+1 {
+			return nil, fmt.Errorf("missing migration to schema version %d", version+i+1)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies the pending migrations in order, holding the migration
+// lock, and returns those that were applied. It waits up to timeout for the
+// lock, forever if it is 0. The schema version is stored after each
+// migration, so an interrupted Migrate continues where it stopped.
+func (c *Context) Migrate(timeout time.Duration, progress MigrationProgress) ([]Migration, error) {
+	l, err := lock.New(c.kv, MigrationLockKey, MigrationLockTTL)
+	if err != nil {
+		return nil, err
+	}
+	if err := l.Acquire(timeout); err != nil {
+		return nil, err
+	}
+	defer func() { _ = l.Release() }()
+
+	// Pending migrations are only known for sure once the lock is held
+	pending, err := c.PendingMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	applied := []Migration{}
+	for _, m := range pending {
+		select {
+		case <-l.Lost():
+			return applied, ErrMigrationLockLost
+		default:
+		}
+
+		report := func(done, total int) {
+			if progress != nil {
+				progress(m, done, total)
+			}
+		}
+		if err := m.Func(c, report); err != nil {
+			return applied, fmt.Errorf("migration to schema version %d: %s", m.Version, err)
+		}
+
+		if err := l.Renew(); err != nil {
+			return applied, ErrMigrationLockLost
+		}
+		if err := c.setSchemaVersion(m.Version); err != nil {
+			return applied, err
+		}
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
+
+// MigrateRecords passes every record under prefix to fn, for use by
+// MigrationFuncs, and saves what it returns in place of the record. A nil
+// return leaves the record unchanged. Records are saved atomically, so the
+// migration fails rather than clobber a record changed in the meantime.
+func (c *Context) MigrateRecords(prefix string, fn func(key string, data []byte) ([]byte, error), progress func(done, total int)) error {
+	values, err := c.kv.GetAll(prefix)
+	if err != nil {
+		if c.IsKeyNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for i, key := range keys {
+		value := values[key]
+		data, err := fn(key, value.Data)
+		if err != nil {
+			return fmt.Errorf("%s: %s", key, err)
+		}
+		if data != nil {
+			if _, err := c.kv.Update(key, kv.Value{Data: data, Index: value.Index}); err != nil {
+				return fmt.Errorf("%s: %s", key, err)
+			}
+		}
+		if progress != nil {
+			progress(i+1, len(keys))
+		}
+	}
+	return nil
+}
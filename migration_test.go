@@ -0,0 +1,142 @@
+package lochness_test
+
+import (
+	"encoding/json"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/mistifyio/lochness/pkg/lock"
+	"github.com/stretchr/testify/suite"
+)
+
+var (
+	registerMigrations sync.Once
+	secondMigrations   int
+)
+
+func TestMigration(t *testing.T) {
+	registerMigrations.Do(func() {
+		// Tag every flavor, to check records are rewritten
+		lochness.RegisterMigration(1, "tag flavors", func(c *lochness.Context, progress func(done, total int)) error {
+			return c.MigrateRecords(lochness.FlavorPath, func(key string, data []byte) ([]byte, error) {
+				if path.Base(key) != "metadata" {
+					return nil, nil
+				}
+				flavor := map[string]interface{}{}
+				if err := json.Unmarshal(data, &flavor); err != nil {
+					return nil, err
+				}
+				flavor["metadata"] = map[string]string{"migrated": "true"}
+				return json.Marshal(flavor)
+			}, progress)
+		})
+		lochness.RegisterMigration(2, "count", func(c *lochness.Context, progress func(done, total int)) error {
+			secondMigrations++
+			return nil
+		})
+	})
+	suite.Run(t, new(MigrationSuite))
+}
+
+type MigrationSuite struct {
+	common.Suite
+}
+
+func (s *MigrationSuite) SetupTest() {
+	s.Suite.SetupTest()
+	secondMigrations = 0
+}
+
+func (s *MigrationSuite) TestRegisterMigration() {
+	s.Panics(func() {
+		lochness.RegisterMigration(1, "duplicate", nil)
+	}, "duplicate versions should panic")
+	s.Panics(func() {
+		lochness.RegisterMigration(0, "invalid", nil)
+	}, "versions below 1 should panic")
+
+	ms := lochness.Migrations()
+	s.Require().Len(ms, 2)
+	s.Equal(1, ms[0].Version)
+	s.Equal(2, ms[1].Version)
+	s.Equal(2, lochness.LatestSchemaVersion())
+}
+
+func (s *MigrationSuite) TestMigrate() {
+	flavors := []*lochness.Flavor{s.NewFlavor(), s.NewFlavor()}
+
+	version, err := s.Context.SchemaVersion()
+	s.Require().NoError(err)
+	s.Equal(0, version, "unmigrated records should be version 0")
+
+	pending, err := s.Context.PendingMigrations()
+	s.Require().NoError(err)
+	s.Len(pending, 2)
+
+	reports := []string{}
+	applied, err := s.Context.Migrate(time.Second, func(m lochness.Migration, done, total int) {
+		reports = append(reports, m.Description)
+		s.True(done <= total)
+	})
+	s.Require().NoError(err)
+	s.Len(applied, 2)
+	s.Len(reports, len(flavors), "each record should be reported")
+	s.Equal(1, secondMigrations)
+
+	for _, f := range flavors {
+		s.Require().NoError(f.Refresh())
+		s.Equal("true", f.Metadata["migrated"])
+	}
+
+	version, err = s.Context.SchemaVersion()
+	s.Require().NoError(err)
+	s.Equal(2, version)
+
+	applied, err = s.Context.Migrate(time.Second, nil)
+	s.Require().NoError(err)
+	s.Empty(applied, "migrated records should not be migrated again")
+	s.Equal(1, secondMigrations)
+}
+
+func (s *MigrationSuite) TestMigrateResume() {
+	s.Require().NoError(s.KV.Set(lochness.SchemaVersionKey, "1"))
+
+	applied, err := s.Context.Migrate(time.Second, nil)
+	s.Require().NoError(err)
+	s.Require().Len(applied, 1)
+	s.Equal(2, applied[0].Version)
+}
+
+func (s *MigrationSuite) TestMigrateTooNew() {
+	s.Require().NoError(s.KV.Set(lochness.SchemaVersionKey, "3"))
+
+	_, err := s.Context.PendingMigrations()
+	s.Equal(lochness.ErrSchemaTooNew, err)
+	_, err = s.Context.Migrate(time.Second, nil)
+	s.Equal(lochness.ErrSchemaTooNew, err)
+	s.Equal(0, secondMigrations)
+}
+
+func (s *MigrationSuite) TestMigrateLocked() {
+	l, err := lock.New(s.KV, lochness.MigrationLockKey, time.Second)
+	s.Require().NoError(err)
+	s.Require().NoError(l.TryAcquire())
+	defer func() { _ = l.Release() }()
+
+	_, err = s.Context.Migrate(10*time.Millisecond, nil)
+	s.Equal(lock.ErrTimeout, err)
+	s.Equal(0, secondMigrations, "nothing should be migrated without the lock")
+}
+
+func (s *MigrationSuite) TestSchemaVersionInvalid() {
+	s.Require().NoError(s.KV.Set(lochness.SchemaVersionKey, "two"))
+
+	_, err := s.Context.SchemaVersion()
+	s.Require().Error(err)
+	s.True(strings.Contains(err.Error(), "invalid schema version"))
+}
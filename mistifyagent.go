@@ -2,18 +2,28 @@ package lochness
 
 import (
 	"bytes"
+	gocontext "context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"path"
 	"time"
 
+	"github.com/mistifyio/lochness/internal/tracing"
+	"github.com/mistifyio/lochness/internal/transport"
+	"github.com/mistifyio/lochness/internal/tunnel"
 	magent "github.com/mistifyio/mistify-agent"
 	"github.com/mistifyio/mistify-agent/client"
 	"github.com/mistifyio/mistify-agent/rpc"
 	logx "github.com/mistifyio/mistify-logrus-ext"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // AgentPort is the default port on which to attempt contacting an agent
@@ -23,8 +33,32 @@ type (
 	// MistifyAgent is an Agent that communicates with a hypervisor agent to perform
 	// actions relating to guests
 	MistifyAgent struct {
-		context *Context
-		port    int
+		context   *Context
+		port      int
+		transport *http.Transport
+		ctx       gocontext.Context
+		// host is the agent all requests go to, see WithHost
+		host string
+	}
+
+	// imageRequest asks an agent to fetch an image. Agents that verify
+	// images check them against the checksum.
+	imageRequest struct {
+		rpc.ImageRequest
+		Checksum string `json:"checksum,omitempty"`
+	}
+
+	// limitedGuest is a client.Guest along with the limits of its flavor, for
+	// the agent to apply to its nics and disks
+	limitedGuest struct {
+		*client.Guest
+		Limits Limits `json:"limits"`
+	}
+
+	// resizeRequest is the request body of an agent guest resize
+	resizeRequest struct {
+		Resources
+		Limits Limits `json:"limits"`
 	}
 
 	// ErrorHTTPCode should be used for errors resulting from an http response
@@ -45,15 +79,51 @@ func (context *Context) NewMistifyAgent(port int) *MistifyAgent {
 	if port <= 0 {
 		port = AgentPort
 	}
+	// the zero config is valid
+	t, _ := transport.Config{}.Transport()
 	return &MistifyAgent{
-		context: context,
-		port:    port,
+		context:   context,
+		port:      port,
+		transport: t,
+		ctx:       gocontext.Background(),
+	}
+}
+
+// WithContext returns a copy of the agent whose requests carry the trace of
+// ctx, see tracing.WithID, and are canceled with it
+func (agent *MistifyAgent) WithContext(ctx gocontext.Context) *MistifyAgent {
+	a := *agent
+	a.ctx = ctx
+	return &a
+}
+
+// WithHost returns a copy of the agent whose requests all go to the agent at
+// host, whatever hypervisor the guests are assigned to, so that a hypervisor
+// can operate on its own guests, including those no longer assigned to it
+func (agent *MistifyAgent) WithHost(host string) *MistifyAgent {
+	a := *agent
+	a.host = host
+	return &a
+}
+
+// ConfigureTransport sets the proxy agents are connected to through, a url or
+// "none" to connect directly, and how long connecting may take, the default
+// if zero. Unless a proxy is set, the HTTP_PROXY, HTTPS_PROXY, and NO_PROXY
+// environment variables apply.
+func (agent *MistifyAgent) ConfigureTransport(proxy string, dialTimeout time.Duration) error {
+	t, err := transport.Config{Proxy: proxy, DialTimeout: dialTimeout}.Transport()
+	if err != nil {
+		return err
 	}
+	agent.transport = t
+	return nil
 }
 
 // generateClientGuest creates a client.Guest object based on the stored guest
-// properties. Used during guest creation
-func (agent *MistifyAgent) generateClientGuest(g *Guest) (*client.Guest, error) {
+// properties, along with the limits of its flavor. Used during guest creation.
+// The guest's user-data and vendor-data are passed along base64 encoded in the
+// "user_data" and "vendor_data" metadata.
+func (agent *MistifyAgent) generateClientGuest(g *Guest) (*limitedGuest, error) {
 	if err := g.Validate(); err != nil {
 		return nil, err
 	}
@@ -93,21 +163,48 @@ func (agent *MistifyAgent) generateClientGuest(g *Guest) (*client.Guest, error)
 		VLANs:   vlans,
 	}
 
+	image := flavor.Image
+	if g.ImageID != "" {
+		image = g.ImageID
+	}
+
 	disk := client.Disk{
 		Size:   flavor.Disk,
-		Image:  flavor.Image,
-		Source: flavor.Image,
-	}
-
-	return &client.Guest{
-		ID:       g.ID,
-		Type:     g.Type,
-		Image:    flavor.Image,
-		Nics:     []client.Nic{nic},
-		Disks:    []client.Disk{disk},
-		Memory:   uint(flavor.Memory),
-		CPU:      uint(flavor.CPU),
-		Metadata: g.Metadata,
+		Image:  image,
+		Source: image,
+	}
+
+	metadata := make(map[string]string, len(g.Metadata)+2)
+	for key, value := range g.Metadata {
+		metadata[key] = value
+	}
+	userData, err := g.UserDataBytes()
+	if err != nil {
+		return nil, err
+	}
+	if len(userData) > 0 {
+		metadata["user_data"] = base64.StdEncoding.EncodeToString(userData)
+	}
+	vendorData, err := g.VendorDataBytes()
+	if err != nil {
+		return nil, err
+	}
+	if len(vendorData) > 0 {
+		metadata["vendor_data"] = base64.StdEncoding.EncodeToString(vendorData)
+	}
+
+	return &limitedGuest{
+		Guest: &client.Guest{
+			ID:       g.ID,
+			Type:     g.Type,
+			Image:    image,
+			Nics:     []client.Nic{nic},
+			Disks:    []client.Disk{disk},
+			Memory:   uint(flavor.Memory),
+			CPU:      uint(flavor.CPU),
+			Metadata: metadata,
+		},
+		Limits: flavor.Limits,
 	}, nil
 }
 
@@ -137,54 +234,85 @@ func (agent *MistifyAgent) jobURL(host, jobID string) string {
 // Generally don't use directly; other, more convenient methods will wrap this
 func (agent *MistifyAgent) request(url, httpMethod string, expectedCode int, dataObj interface{}) ([]byte, string, error) {
 	httpClient := &http.Client{
-		Timeout: 15 * time.Second,
+		Transport: agent.transport,
+		Timeout:   15 * time.Second,
 	}
 
 	// Make the request. POST sends JSON data, GET doesn't
-	var resp *http.Response
-	var reqErr error
+	var body io.Reader
 	if httpMethod == "POST" {
 		dataJSON, err := json.Marshal(dataObj)
 		if err != nil {
 			return nil, "", err
 		}
-		resp, reqErr = httpClient.Post(url, "application/json", bytes.NewReader(dataJSON))
-	} else {
-		resp, reqErr = httpClient.Get(url)
+		body = bytes.NewReader(dataJSON)
+	}
+	req, err := http.NewRequest(httpMethod, url, body)
+	if err != nil {
+		return nil, "", err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
 	}
-	if reqErr != nil {
-		return nil, "", reqErr
+
+	// The request is a span of the trace of the agent's context, whose id
+	// and trace context are passed on to the agent
+	ctx, span := tracing.Start(agent.ctx, "agent "+httpMethod+" "+req.URL.Path, trace.SpanKindClient)
+	defer span.End()
+	span.SetAttributes(attribute.String("http.url", url))
+	tracing.SetHeaders(ctx, req.Header)
+
+	resp, err := httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, "", err
 	}
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
 	defer logx.LogReturnedErr(resp.Body.Close, nil, "failed to close response body")
 
 	if resp.StatusCode != expectedCode {
-		return nil, "", ErrorHTTPCode{expectedCode, resp.StatusCode}
+		err := ErrorHTTPCode{expectedCode, resp.StatusCode}
+		span.SetStatus(codes.Error, err.Error())
+		return nil, "", err
 	}
 
-	body, err := ioutil.ReadAll(resp.Body)
-	return body, resp.Header.Get("X-Guest-Job-ID"), err
+	respBody, err := ioutil.ReadAll(resp.Body)
+	return respBody, resp.Header.Get("X-Guest-Job-ID"), err
 }
 
-// getHypervisor loads the hypervisor based on guest id
-func (agent *MistifyAgent) getHypervisor(guestID string) (*Hypervisor, error) {
+// guestHost returns the address of the agent of the hypervisor of a guest, or
+// the host of the agent, see WithHost
+func (agent *MistifyAgent) guestHost(guestID string) (string, error) {
+	if agent.host != "" {
+		return agent.host, nil
+	}
 	guest, err := agent.context.Guest(guestID)
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	return agent.hypervisorHost(guest.HypervisorID)
+}
+
+// hypervisorHost returns the address of the agent of a hypervisor, or the host
+// of the agent, see WithHost
+func (agent *MistifyAgent) hypervisorHost(hypervisorID string) (string, error) {
+	if agent.host != "" {
+		return agent.host, nil
 	}
-	hypervisor, err := agent.context.Hypervisor(guest.HypervisorID)
+	hypervisor, err := agent.context.Hypervisor(hypervisorID)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	return hypervisor, nil
+	return hypervisor.IP.String(), nil
 }
 
 // requestGuestAction makes requests for a guest to a hypervisor agent.
 func (agent *MistifyAgent) requestGuestAction(guestID, actionName string) (string, error) {
-	hypervisor, err := agent.getHypervisor(guestID)
+	host, err := agent.guestHost(guestID)
 	if err != nil {
 		return "", err
 	}
-	url := agent.guestActionURL(hypervisor.IP.String(), guestID, actionName)
+	url := agent.guestActionURL(host, guestID, actionName)
 
 	// Make the request
 	_, jobID, err := agent.request(url, "POST", http.StatusAccepted, nil)
@@ -200,12 +328,12 @@ func (agent *MistifyAgent) CheckJobStatus(guestID, jobID string) (bool, error) {
 	if jobID == "" {
 		return false, errors.New("missing job id")
 	}
-	hypervisor, err := agent.getHypervisor(guestID)
+	host, err := agent.guestHost(guestID)
 	if err != nil {
 		return false, err
 	}
 
-	url := agent.jobURL(hypervisor.IP.String(), jobID)
+	url := agent.jobURL(host, jobID)
 	body, _, err := agent.request(url, "GET", http.StatusOK, nil)
 	if err != nil {
 		return false, err
@@ -228,11 +356,11 @@ func (agent *MistifyAgent) CheckJobStatus(guestID, jobID string) (bool, error) {
 
 // GetGuest retrieves information on a guest from an agent
 func (agent *MistifyAgent) GetGuest(guestID string) (*client.Guest, error) {
-	hypervisor, err := agent.getHypervisor(guestID)
+	host, err := agent.guestHost(guestID)
 	if err != nil {
 		return nil, err
 	}
-	url := agent.guestActionURL(hypervisor.IP.String(), guestID, "get")
+	url := agent.guestActionURL(host, guestID, "get")
 	body, _, err := agent.request(url, "GET", http.StatusOK, nil)
 	if err != nil {
 		return nil, err
@@ -253,7 +381,7 @@ func (agent *MistifyAgent) CreateGuest(guestID string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	hypervisor, err := agent.context.Hypervisor(guest.HypervisorID)
+	host, err := agent.hypervisorHost(guest.HypervisorID)
 	if err != nil {
 		return "", err
 	}
@@ -263,7 +391,7 @@ func (agent *MistifyAgent) CreateGuest(guestID string) (string, error) {
 		return "", err
 	}
 
-	url := agent.guestActionURL(hypervisor.IP.String(), guestID, "create")
+	url := agent.guestActionURL(host, guestID, "create")
 	_, jobID, err := agent.request(url, "POST", http.StatusAccepted, g)
 	return jobID, err
 }
@@ -281,28 +409,134 @@ func (agent *MistifyAgent) GuestAction(guestID, actionName string) (string, erro
 	return jobID, err
 }
 
-// FetchImage fetches a disk image that can be used for guest creation
-func (agent *MistifyAgent) FetchImage(guestID string) (string, error) {
+// SnapshotGuest takes a named snapshot of a guest's disks
+func (agent *MistifyAgent) SnapshotGuest(guestID, name string) (string, error) {
+	if name == "" {
+		return "", errors.New("missing snapshot name")
+	}
+	host, err := agent.guestHost(guestID)
+	if err != nil {
+		return "", err
+	}
+
+	url := agent.guestActionURL(host, guestID, "snapshots")
+	req := map[string]string{"dest": name}
+	_, jobID, err := agent.request(url, "POST", http.StatusAccepted, req)
+	return jobID, err
+}
+
+// ResizeGuest resizes the vcpus, memory, and disk of a guest, and changes its
+// limits, to those of the flavor it is being resized to, see Guest.Resize
+func (agent *MistifyAgent) ResizeGuest(guestID string) (string, error) {
 	guest, err := agent.context.Guest(guestID)
 	if err != nil {
 		return "", err
 	}
+	if guest.ResizeFlavor == "" {
+		return "", errors.New("guest is not being resized")
+	}
+	flavor, err := agent.context.Flavor(guest.ResizeFlavor)
+	if err != nil {
+		return "", err
+	}
+	host, err := agent.hypervisorHost(guest.HypervisorID)
+	if err != nil {
+		return "", err
+	}
+
+	url := agent.guestActionURL(host, guestID, "resize")
+	req := &resizeRequest{Resources: flavor.Resources, Limits: flavor.Limits}
+	_, jobID, err := agent.request(url, "POST", http.StatusAccepted, req)
+	return jobID, err
+}
+
+// cloneRequest is the request body of an agent guest clone
+type cloneRequest struct {
+	Dest     *limitedGuest `json:"dest"`               // the clone
+	Snapshot string        `json:"snapshot,omitempty"` // the source's current disks if blank
+}
+
+// CloneGuest creates a guest whose disks are cloned from those of the guest it
+// is a clone of, see Guest.Clone, on the hypervisor of both
+func (agent *MistifyAgent) CloneGuest(guestID string) (string, error) {
+	guest, err := agent.context.Guest(guestID)
+	if err != nil {
+		return "", err
+	}
+	if guest.CloneOf == "" {
+		return "", errors.New("guest is not a clone")
+	}
+	source, err := agent.context.Guest(guest.CloneOf)
+	if err != nil {
+		return "", err
+	}
+	if source.HypervisorID != guest.HypervisorID {
+		return "", errors.New("guest is not on the hypervisor of its clone source")
+	}
+	host, err := agent.hypervisorHost(guest.HypervisorID)
+	if err != nil {
+		return "", err
+	}
 
-	flavor, err := agent.context.Flavor(guest.FlavorID)
+	g, err := agent.generateClientGuest(guest)
 	if err != nil {
 		return "", err
 	}
 
-	hypervisor, err := agent.context.Hypervisor(guest.HypervisorID)
+	url := agent.guestActionURL(host, source.ID, "clone")
+	req := &cloneRequest{Dest: g, Snapshot: guest.CloneSnapshot}
+	_, jobID, err := agent.request(url, "POST", http.StatusAccepted, req)
+	return jobID, err
+}
+
+// DialConsole connects to a console of a guest, vnc or serial, through its
+// hypervisor agent. The connection is made by upgrading a request to the
+// agent's console endpoint.
+func (agent *MistifyAgent) DialConsole(guestID, consoleType string) (net.Conn, error) {
+	if !ConsoleTypes[consoleType] {
+		return nil, fmt.Errorf("invalid console type %q", consoleType)
+	}
+	host, err := agent.guestHost(guestID)
+	if err != nil {
+		return nil, err
+	}
+
+	url := agent.guestActionURL(host, guestID, path.Join("console", consoleType))
+	return tunnel.DialTransport(agent.transport, url, nil)
+}
+
+// FetchImage fetches a disk image that can be used for guest creation. A
+// guest's catalog image is fetched from its source and verified against its
+// checksum, otherwise the flavor's image is fetched.
+func (agent *MistifyAgent) FetchImage(guestID string) (string, error) {
+	guest, err := agent.context.Guest(guestID)
+	if err != nil {
+		return "", err
+	}
+
+	host, err := agent.hypervisorHost(guest.HypervisorID)
 	if err != nil {
 		return "", err
 	}
 
-	host := hypervisor.IP.String()
-	req := &rpc.ImageRequest{
-		ID:   flavor.Image,
-		Type: guest.Type,
+	req := &imageRequest{}
+	req.Type = guest.Type
+	if guest.ImageID != "" {
+		image, err := agent.context.Image(guest.ImageID)
+		if err != nil {
+			return "", err
+		}
+		req.ID = image.ID
+		req.Source = image.Source
+		req.Checksum = image.Checksum
+	} else {
+		flavor, err := agent.context.Flavor(guest.FlavorID)
+		if err != nil {
+			return "", err
+		}
+		req.ID = flavor.Image
 	}
+
 	url := fmt.Sprintf("http://%s:%d/images", host, agent.port)
 	_, jobID, err := agent.request(url, "POST", http.StatusAccepted, req)
 	return jobID, err
@@ -1,8 +1,10 @@
 package lochness_test
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httptest"
@@ -13,6 +15,8 @@ import (
 
 	"github.com/mistifyio/lochness"
 	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/mistifyio/lochness/internal/tracing"
+	"github.com/mistifyio/lochness/internal/tunnel"
 	magent "github.com/mistifyio/mistify-agent"
 	mnet "github.com/mistifyio/util/net"
 	"github.com/pborman/uuid"
@@ -29,6 +33,7 @@ type MistifyAgentSuite struct {
 	api        *httptest.Server
 	guest      *lochness.Guest
 	hypervisor *lochness.Hypervisor
+	traceID    string
 }
 
 func (s *MistifyAgentSuite) SetupSuite() {
@@ -36,9 +41,18 @@ func (s *MistifyAgentSuite) SetupSuite() {
 
 	s.api = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		path := r.URL.String()
+		s.traceID = r.Header.Get(tracing.Header)
 		actionRegexp := regexp.MustCompile(fmt.Sprintf("/guests/%s/\\w+", s.guest.ID))
 		jobRegexp := regexp.MustCompile("/jobs/\\w+")
 		switch {
+		case path == fmt.Sprintf("/guests/%s/console/vnc", s.guest.ID):
+			c, err := tunnel.Upgrade(w, r)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = c.Write([]byte("RFB 003.008\n"))
+			_ = c.Close()
 		case path == fmt.Sprintf("/guests/%s", s.guest.ID):
 			guestBytes, _ := json.Marshal(s.guest)
 			_, _ = w.Write(guestBytes)
@@ -75,6 +89,29 @@ func (s *MistifyAgentSuite) TestNewMistifyAgent() {
 	s.NotNil(s.agent)
 }
 
+func (s *MistifyAgentSuite) TestWithContext() {
+	_, err := s.agent.GuestAction(s.guest.ID, "restart")
+	s.NoError(err)
+	s.Empty(s.traceID, "requests without a trace should not send one")
+
+	agent := s.agent.WithContext(tracing.WithID(context.Background(), "request-1234"))
+	_, err = agent.GuestAction(s.guest.ID, "restart")
+	s.NoError(err)
+	s.Equal("request-1234", s.traceID)
+}
+
+func (s *MistifyAgentSuite) TestWithHost() {
+	host, _, _ := mnet.SplitHostPort(s.api.Listener.Addr().String())
+	s.Require().NoError(s.hypervisor.RemoveGuest(s.guest))
+
+	_, err := s.agent.DeleteGuest(s.guest.ID)
+	s.Error(err, "guest no longer assigned to a hypervisor should have no agent")
+
+	jobID, err := s.agent.WithHost(host).DeleteGuest(s.guest.ID)
+	s.NoError(err)
+	s.NotNil(uuid.Parse(jobID), "guest should be deleted through the agent at host")
+}
+
 func (s *MistifyAgentSuite) TestGetGuest() {
 	tests := []struct {
 		description string
@@ -175,6 +212,33 @@ func (s *MistifyAgentSuite) TestGuestAction() {
 	}
 }
 
+func (s *MistifyAgentSuite) TestSnapshotGuest() {
+	tests := []struct {
+		description string
+		id          string
+		name        string
+		expectedErr bool
+	}{
+		{"missing id", "", "snap", true},
+		{"nonuuid id", "asdf", "snap", true},
+		{"nonexistent id", uuid.New(), "snap", true},
+		{"missing name", s.guest.ID, "", true},
+		{"existing id", s.guest.ID, "snap", false},
+	}
+
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		jobID, err := s.agent.SnapshotGuest(test.id, test.name)
+		if test.expectedErr {
+			s.Error(err, msg("should fail"))
+			s.Nil(uuid.Parse(jobID), msg("fail should not return jobID"))
+		} else {
+			s.NoError(err, msg("should succeed"))
+			s.NotNil(uuid.Parse(jobID), msg("should return jobID"))
+		}
+	}
+}
+
 func (s *MistifyAgentSuite) TestCheckJobStatus() {
 	tests := []struct {
 		description string
@@ -226,3 +290,34 @@ func (s *MistifyAgentSuite) TestFetchImage() {
 		}
 	}
 }
+
+func (s *MistifyAgentSuite) TestDialConsole() {
+	tests := []struct {
+		description string
+		id          string
+		consoleType string
+		expectedErr bool
+	}{
+		{"missing id", "", lochness.ConsoleVNC, true},
+		{"nonexistent id", uuid.New(), lochness.ConsoleVNC, true},
+		{"invalid type", s.guest.ID, "rdp", true},
+		{"existing id", s.guest.ID, lochness.ConsoleVNC, false},
+	}
+
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		c, err := s.agent.DialConsole(test.id, test.consoleType)
+		if test.expectedErr {
+			s.Error(err, msg("should fail"))
+			s.Nil(c, msg("fail should not return a connection"))
+			continue
+		}
+		if !s.NoError(err, msg("should succeed")) {
+			continue
+		}
+		banner, err := ioutil.ReadAll(c)
+		s.NoError(err, msg("should read the console"))
+		s.Equal("RFB 003.008\n", string(banner), msg("should read the console"))
+		_ = c.Close()
+	}
+}
@@ -6,7 +6,7 @@ import (
 	"path/filepath"
 	"strings"
 
-	"github.com/mistifyio/lochness/pkg/kv"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
 	"github.com/pborman/uuid"
 )
 
@@ -20,8 +20,10 @@ type (
 	Network struct {
 		context       *Context
 		modifiedIndex uint64
-		ID            string            `json:"id"`
+		ID            string            `json:"id" schema:"uuid"`
 		Metadata      map[string]string `json:"metadata"`
+		VLANGroupID   string            `json:"vlangroup,omitempty" schema:"uuid"` // subnets are only reachable from hypervisors carrying it, see VLANGroupsConfig
+		VLANRanges    []VLANRange       `json:"vlan_ranges,omitempty"`             // tags allocated to its subnets, see AddSubnet
 		subnets       []string
 	}
 
@@ -83,7 +85,7 @@ func (n *Network) Refresh() error {
 	key := filepath.Join(prefix, "metadata")
 	value, ok := nodes[key]
 	if !ok {
-		return errors.New("metadata key is missing")
+		return lerrors.NotFound(errors.New("metadata key is missing"))
 	}
 
 	if err := json.Unmarshal(value.Data, &n); err != nil {
@@ -110,34 +112,23 @@ func (n *Network) Refresh() error {
 
 }
 
-// Validate ensures a Network has reasonable data. It currently does nothing.
+// Validate ensures a Network has reasonable data.
 func (n *Network) Validate() error {
 	if _, err := canonicalizeUUID(n.ID); err != nil {
-		return errors.New("invalid ID")
+		return newValidationError("id", "invalid ID")
 	}
-	return nil
+	if n.VLANGroupID != "" {
+		if _, err := canonicalizeUUID(n.VLANGroupID); err != nil {
+			return newValidationError("vlangroup", "invalid VLAN group ID")
+		}
+	}
+	return validateVLANRanges(n.VLANRanges)
 }
 
 // Save persists a Network.
 // It will call Validate.
 func (n *Network) Save() error {
-
-	if err := n.Validate(); err != nil {
-		return err
-	}
-
-	v, err := json.Marshal(n)
-
-	if err != nil {
-		return err
-	}
-
-	index, err := n.context.kv.Update(n.key(), kv.Value{Data: v, Index: n.modifiedIndex})
-	if err != nil {
-		return err
-	}
-	n.modifiedIndex = index
-	return nil
+	return n.context.SaveAll(n)
 }
 
 func (n *Network) subnetKey(s *Subnet) string {
@@ -150,7 +141,9 @@ func (n *Network) subnetKey(s *Subnet) string {
 
 // when we load one, should we make sure the networkid actually matches us?
 
-// AddSubnet adds a Subnet to the Network.
+// AddSubnet adds a Subnet to the Network. If the Network has VLAN ranges and
+// the subnet no VLAN, a free tag of them is allocated to it, failing with a
+// conflict if there is none.
 func (n *Network) AddSubnet(s *Subnet) error {
 	// Make sure the Network exists
 	if n.modifiedIndex == 0 {
@@ -166,21 +159,36 @@ func (n *Network) AddSubnet(s *Subnet) error {
 		}
 	}
 
-	if err := n.context.kv.Set(n.subnetKey(s), ""); err != nil {
-		return err
-	}
-	n.subnets = append(n.subnets, s.ID)
-
 	// an instance where transactions would be cool...
+	// The subnet is saved first, so that one overlapping another subnet of
+	// the network is rejected before the network lists it
+	networkID, vlan := s.NetworkID, s.VLAN
+	if len(n.VLANRanges) > 0 && s.VLAN == 0 {
+		tag, err := n.allocateVLAN(s)
+		if err != nil {
+			return err
+		}
+		s.VLAN = tag
+	}
 	s.NetworkID = n.ID
 	if err := s.Save(); err != nil {
+		if s.VLAN != vlan {
+			_ = n.releaseVLAN(s)
+		}
+		s.NetworkID, s.VLAN = networkID, vlan
 		return err
 	}
 
+	if err := n.context.kv.Set(n.subnetKey(s), ""); err != nil {
+		return err
+	}
+	n.subnets = append(n.subnets, s.ID)
+
 	return nil
 }
 
-// RemoveSubnet removes a subnet from the network
+// RemoveSubnet removes a subnet from the network, releasing the VLAN allocated
+// to it
 func (n *Network) RemoveSubnet(s *Subnet) error {
 	if err := n.context.kv.Delete(n.subnetKey(s), false); err != nil {
 		return err
@@ -194,7 +202,12 @@ func (n *Network) RemoveSubnet(s *Subnet) error {
 	}
 	n.subnets = newSubnets
 
+	if err := n.releaseVLAN(s); err != nil {
+		return err
+	}
+
 	s.NetworkID = ""
+	s.VLAN = 0
 	if err := s.Save(); err != nil {
 		return err
 	}
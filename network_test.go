@@ -5,6 +5,7 @@ import (
 
 	"github.com/mistifyio/lochness"
 	"github.com/mistifyio/lochness/internal/tests/common"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
 	"github.com/pborman/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
@@ -142,6 +143,19 @@ func (s *NetworkSuite) TestAddSubnet() {
 	}
 }
 
+func (s *NetworkSuite) TestAddSubnetOverlap() {
+	network := s.NewNetwork()
+	s.Require().NoError(network.AddSubnet(s.NewSubnet()))
+
+	overlapping := s.NewSubnet()
+	err := network.AddSubnet(overlapping)
+	s.True(lerrors.IsConflict(err), "overlapping subnet should conflict")
+	s.Len(network.Subnets(), 1, "fail should not add subnet to network")
+	s.Empty(overlapping.NetworkID, "fail should not add network to subnet")
+	s.NoError(network.Refresh())
+	s.Len(network.Subnets(), 1, "fail should not store subnet in network")
+}
+
 func (s *NetworkSuite) TestSubnets() {
 	network := s.NewNetwork()
 	_ = network.AddSubnet(s.NewSubnet())
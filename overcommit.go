@@ -0,0 +1,203 @@
+package lochness
+
+import (
+	"fmt"
+	"strconv"
+)
+
+var (
+	// CPUOvercommitConfig is the config key, of the cluster or of a
+	// hypervisor, of the ratio of guest vcpus to hypervisor cpus
+	CPUOvercommitConfig = "cpu-overcommit"
+
+	// MemoryOvercommitConfig is the config key, of the cluster or of a
+	// hypervisor, of the ratio of guest memory to hypervisor memory
+	MemoryOvercommitConfig = "memory-overcommit"
+)
+
+// Overcommit is how far the resources of a hypervisor may be allocated to
+// guests, as ratios of allocated to total resources. Ratios below 1 hold back
+// resources for the hypervisor itself. Without a CPU ratio, cpus are not
+// allocated, and any guest with no more vcpus than the hypervisor has cpus
+// fits. Without a Memory ratio, memory is allocated up to the total.
+type Overcommit struct {
+	CPU    float64 `json:"cpu,omitempty"`
+	Memory float64 `json:"memory,omitempty"`
+}
+
+// ParseOvercommit parses the overcommit ratio of a config key, which must be a
+// positive number
+func ParseOvercommit(key, value string) (float64, error) {
+	ratio, err := strconv.ParseFloat(value, 64)
+	if err != nil || ratio <= 0 {
+		return 0, newValidationError(key, fmt.Sprintf("invalid %s %q: must be a positive number", key, value))
+	}
+	return ratio, nil
+}
+
+// validateConfig validates the config values lochness itself uses
+func validateConfig(key, value string) error {
+	switch key {
+	case CPUOvercommitConfig, MemoryOvercommitConfig:
+		_, err := ParseOvercommit(key, value)
+		return err
+	case MACOUIConfig:
+		_, err := ParseOUI(value)
+		return err
+	case KernelURLConfig, InitrdURLConfig, IPXETemplateConfig:
+		return validateBootConfig(key, value)
+	case SoftDeleteConfig:
+		_, err := ParseSoftDeleteWindow(value)
+		return err
+	case IdempotencyWindowConfig:
+		_, err := ParseIdempotencyWindow(value)
+		return err
+	case BridgesConfig:
+		_, err := ParseBridges(value)
+		return err
+	case VLANGroupsConfig:
+		_, err := ParseVLANGroups(value)
+		return err
+	case JobQueueConfig:
+		_, err := parseJobQueue(value)
+		return err
+	case DefaultFWProfileConfig:
+		if _, err := BuiltinFWProfile(value); err != nil {
+			return newValidationError(key, err.Error())
+		}
+	}
+	return nil
+}
+
+// set sets the ratio of an overcommit config key
+func (o *Overcommit) set(key, value string) error {
+	ratio, err := ParseOvercommit(key, value)
+	if err != nil {
+		return err
+	}
+	switch key {
+	case CPUOvercommitConfig:
+		o.CPU = ratio
+	case MemoryOvercommitConfig:
+		o.Memory = ratio
+	}
+	return nil
+}
+
+// Overcommit returns the overcommit ratios configured for the cluster
+func (c *Context) Overcommit() (Overcommit, error) {
+	var o Overcommit
+	for _, key := range []string{CPUOvercommitConfig, MemoryOvercommitConfig} {
+		value, err := c.GetConfig(key)
+		if err != nil {
+			if c.IsKeyNotFound(err) {
+				continue
+			}
+			return Overcommit{}, err
+		}
+		if err := o.set(key, value); err != nil {
+			return Overcommit{}, err
+		}
+	}
+	return o, nil
+}
+
+// Overcommit returns the overcommit ratios of the hypervisor, from its config,
+// or the cluster's where it has none
+func (h *Hypervisor) Overcommit() (Overcommit, error) {
+	o, err := h.context.Overcommit()
+	if err != nil {
+		return Overcommit{}, err
+	}
+	for _, key := range []string{CPUOvercommitConfig, MemoryOvercommitConfig} {
+		if value, ok := h.Config[key]; ok {
+			if err := o.set(key, value); err != nil {
+				return Overcommit{}, err
+			}
+		}
+	}
+	return o, nil
+}
+
+// Capacity returns the resources of a hypervisor with the total resources
+// given that may be allocated to guests. Disk is never overcommitted.
+func (o Overcommit) Capacity(total Resources) Resources {
+	capacity := total
+	if o.CPU != 0 {
+		capacity.CPU = uint32(float64(total.CPU) * o.CPU)
+	}
+	if o.Memory != 0 {
+		capacity.Memory = uint64(float64(total.Memory) * o.Memory)
+	}
+	return capacity
+}
+
+// CheckResources returns a validation error explaining why the hypervisor does
+// not have the available resources for a guest of the flavor, or nil if it
+// does
+func (h *Hypervisor) CheckResources(f *Flavor) error {
+	o, err := h.Overcommit()
+	if err != nil {
+		return err
+	}
+	return h.checkResources(o, f.Resources, fmt.Sprintf("flavor %s needs", f.ID))
+}
+
+// CheckResize returns a validation error explaining why the hypervisor does not
+// have the available resources to resize a guest on it from one flavor to
+// another, or nil if it does. Only what the new flavor adds to the old needs to
+// be available, except for cpus when they are not allocated.
+func (h *Hypervisor) CheckResize(from, to *Flavor) error {
+	o, err := h.Overcommit()
+	if err != nil {
+		return err
+	}
+	var growth Resources
+	if to.Disk > from.Disk {
+		growth.Disk = to.Disk - from.Disk
+	}
+	if to.Memory > from.Memory {
+		growth.Memory = to.Memory - from.Memory
+	}
+	if o.CPU == 0 {
+		growth.CPU = to.CPU
+	} else if to.CPU > from.CPU {
+		growth.CPU = to.CPU - from.CPU
+	}
+	return h.checkResources(o, growth, fmt.Sprintf("resizing from flavor %s to %s needs", from.ID, to.ID))
+}
+
+// checkResources returns a validation error explaining why the hypervisor,
+// with the overcommit ratios given, does not have the available resources
+// needed, which are described by needs, or nil if it does
+func (h *Hypervisor) checkResources(o Overcommit, need Resources, needs string) error {
+	total := h.TotalResources
+	capacity := o.Capacity(total)
+	avail := h.AvailableResources
+
+	if avail.Disk < need.Disk {
+		return newValidationError("disk", fmt.Sprintf("hypervisor %s has %d of %d MB disk available, %s %d",
+			h.ID, avail.Disk, capacity.Disk, needs, need.Disk))
+	}
+	if avail.Memory < need.Memory {
+		return newValidationError("memory", fmt.Sprintf("hypervisor %s has %d of %d MB memory (%d MB at %gx overcommit) available, %s %d",
+			h.ID, avail.Memory, capacity.Memory, total.Memory, overcommitRatio(o.Memory), needs, need.Memory))
+	}
+	if avail.CPU < need.CPU {
+		if o.CPU == 0 {
+			return newValidationError("cpu", fmt.Sprintf("hypervisor %s has %d cpus, %s %d",
+				h.ID, avail.CPU, needs, need.CPU))
+		}
+		return newValidationError("cpu", fmt.Sprintf("hypervisor %s has %d of %d vcpus (%d cpus at %gx overcommit) available, %s %d",
+			h.ID, avail.CPU, capacity.CPU, total.CPU, o.CPU, needs, need.CPU))
+	}
+	return nil
+}
+
+// overcommitRatio returns the ratio, with the zero ratio as the 1 it stands for
+func overcommitRatio(ratio float64) float64 {
+	if ratio == 0 {
+		return 1
+	}
+	return ratio
+}
@@ -0,0 +1,128 @@
+package lochness_test
+
+import (
+	"testing"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestOvercommit(t *testing.T) {
+	suite.Run(t, new(OvercommitSuite))
+}
+
+type OvercommitSuite struct {
+	common.Suite
+}
+
+func (s *OvercommitSuite) TestParseOvercommit() {
+	tests := []struct {
+		description string
+		value       string
+		expected    float64
+		expectedErr bool
+	}{
+		{"integer", "2", 2, false},
+		{"fraction", "1.5", 1.5, false},
+		{"below one", "0.9", 0.9, false},
+		{"empty", "", 0, true},
+		{"zero", "0", 0, true},
+		{"negative", "-1", 0, true},
+		{"not a number", "lots", 0, true},
+	}
+
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		ratio, err := lochness.ParseOvercommit(lochness.CPUOvercommitConfig, test.value)
+		if test.expectedErr {
+			s.True(lerrors.IsValidation(err), msg("should be a validation error"))
+		} else {
+			s.NoError(err, msg("should parse"))
+			s.Equal(test.expected, ratio, msg("should return the ratio"))
+		}
+	}
+}
+
+func (s *OvercommitSuite) TestOvercommit() {
+	hypervisor := s.NewHypervisor()
+
+	o, err := hypervisor.Overcommit()
+	s.NoError(err)
+	s.Equal(lochness.Overcommit{}, o, "should have no ratios by default")
+
+	s.Require().NoError(s.Context.SetConfig(lochness.CPUOvercommitConfig, "4"))
+	s.Require().NoError(s.Context.SetConfig(lochness.MemoryOvercommitConfig, "1.5"))
+	o, err = hypervisor.Overcommit()
+	s.NoError(err)
+	s.Equal(lochness.Overcommit{CPU: 4, Memory: 1.5}, o, "should use the cluster's ratios")
+
+	s.Require().NoError(hypervisor.SetConfig(lochness.MemoryOvercommitConfig, "1.2"))
+	o, err = hypervisor.Overcommit()
+	s.NoError(err)
+	s.Equal(lochness.Overcommit{CPU: 4, Memory: 1.2}, o, "should prefer the hypervisor's ratios")
+
+	s.True(lerrors.IsValidation(s.Context.SetConfig(lochness.CPUOvercommitConfig, "none")), "invalid cluster ratio should not be set")
+	s.True(lerrors.IsValidation(hypervisor.SetConfig(lochness.CPUOvercommitConfig, "-2")), "invalid hypervisor ratio should not be set")
+}
+
+func (s *OvercommitSuite) TestCapacity() {
+	total := lochness.Resources{Memory: 1000, Disk: 500, CPU: 8}
+
+	s.Equal(total, lochness.Overcommit{}.Capacity(total))
+	s.Equal(lochness.Resources{Memory: 1500, Disk: 500, CPU: 32},
+		lochness.Overcommit{CPU: 4, Memory: 1.5}.Capacity(total))
+	s.Equal(lochness.Resources{Memory: 900, Disk: 500, CPU: 8},
+		lochness.Overcommit{Memory: 0.9}.Capacity(total))
+}
+
+func (s *OvercommitSuite) TestCheckResources() {
+	hypervisor := s.NewHypervisor()
+	flavor := s.NewFlavor()
+	s.NoError(hypervisor.CheckResources(flavor))
+
+	tests := []struct {
+		description string
+		available   lochness.Resources
+		field       string
+	}{
+		{"disk", lochness.Resources{Memory: flavor.Memory, CPU: flavor.CPU}, "disk"},
+		{"memory", lochness.Resources{Disk: flavor.Disk, CPU: flavor.CPU}, "memory"},
+		{"cpu", lochness.Resources{Memory: flavor.Memory, Disk: flavor.Disk}, "cpu"},
+	}
+
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		hypervisor.AvailableResources = test.available
+		err := hypervisor.CheckResources(flavor)
+		s.True(lerrors.IsValidation(err), msg("should be a validation error"))
+		verr, _ := err.(*lochness.ValidationError)
+		if s.NotNil(verr, msg("should be a ValidationError")) {
+			s.Equal([]string{test.field}, verr.Fields, msg("should name the resource"))
+			s.Contains(verr.Message, hypervisor.ID, msg("should name the hypervisor"))
+		}
+	}
+}
+
+func (s *OvercommitSuite) TestUpdateResources() {
+	hypervisor, guest := s.NewHypervisorWithGuest()
+	_ = hypervisor.SetConfig("guestDiskDir", "/")
+	flavor, _ := s.Context.Flavor(guest.FlavorID)
+	_, _ = lochness.SetHypervisorID(hypervisor.ID)
+
+	s.Require().NoError(hypervisor.SetConfig(lochness.CPUOvercommitConfig, "2"))
+	s.Require().NoError(hypervisor.SetConfig(lochness.MemoryOvercommitConfig, "1.5"))
+	s.Require().NoError(hypervisor.UpdateResources())
+
+	tr := hypervisor.TotalResources
+	ar := hypervisor.AvailableResources
+	s.Equal(uint64(float64(tr.Memory)*1.5)-flavor.Memory, ar.Memory)
+	s.Equal(tr.Disk-flavor.Disk, ar.Disk)
+	// The guest's vcpus may exceed the capacity of a small machine
+	cpu := uint32(0)
+	if tr.CPU*2 > flavor.CPU {
+		cpu = tr.CPU*2 - flavor.CPU
+	}
+	s.Equal(cpu, ar.CPU)
+}
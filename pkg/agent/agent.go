@@ -0,0 +1,144 @@
+// Package agent provides a typed interface for operating on guests through the
+// agent of the hypervisor they are assigned to, with an implementation backed
+// by mistify-agent and an in-memory mock for tests.
+package agent
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/mistify-agent/client"
+)
+
+// GuestAgent performs operations on guests. Operations other than FetchState,
+// DialConsole and WithContext are asynchronous and return the id of an agent
+// job whose completion can be checked with JobStatus.
+type GuestAgent interface {
+	Create(guestID string) (string, error)
+	Clone(guestID string) (string, error)
+	Delete(guestID string) (string, error)
+	Reboot(guestID string) (string, error)
+	Shutdown(guestID string) (string, error)
+	Action(guestID, action string) (string, error)
+	Snapshot(guestID, name string) (string, error)
+	Resize(guestID string) (string, error)
+	FetchImage(guestID string) (string, error)
+	FetchState(guestID string) (*client.Guest, error)
+	JobStatus(guestID, jobID string) (bool, error)
+	DialConsole(guestID, consoleType string) (net.Conn, error)
+	// WithContext returns a GuestAgent whose requests carry ctx, e.g. the
+	// trace of the job they are made for
+	WithContext(ctx context.Context) GuestAgent
+}
+
+// Mistify is a GuestAgent that talks to mistify-agent over HTTP
+type Mistify struct {
+	agent *lochness.MistifyAgent
+}
+
+var _ GuestAgent = &Mistify{}
+
+// NewMistify creates a new Mistify using context to look up guests and their
+// hypervisors. A port of 0 uses the default agent port.
+func NewMistify(context *lochness.Context, port int) *Mistify {
+	return &Mistify{
+		agent: context.NewMistifyAgent(port),
+	}
+}
+
+// WithHost returns a copy of m whose requests all go to the agent at host,
+// whatever hypervisor the guests are assigned to, see
+// MistifyAgent.WithHost
+func (m *Mistify) WithHost(host string) *Mistify {
+	return &Mistify{agent: m.agent.WithHost(host)}
+}
+
+// IsGuestNotFound returns whether err is an agent not knowing about a guest,
+// from either the agent of a hypervisor or a Mock
+func IsGuestNotFound(err error) bool {
+	if err == ErrGuestNotFound {
+		return true
+	}
+	var codeErr lochness.ErrorHTTPCode
+	return errors.As(err, &codeErr) && codeErr.Code == http.StatusNotFound
+}
+
+// ConfigureTransport sets the proxy agents are connected to through and how
+// long connecting may take, see MistifyAgent.ConfigureTransport
+func (m *Mistify) ConfigureTransport(proxy string, dialTimeout time.Duration) error {
+	return m.agent.ConfigureTransport(proxy, dialTimeout)
+}
+
+// WithContext returns a copy of m whose requests carry ctx
+func (m *Mistify) WithContext(ctx context.Context) GuestAgent {
+	return &Mistify{agent: m.agent.WithContext(ctx)}
+}
+
+// Create creates a guest on its hypervisor
+func (m *Mistify) Create(guestID string) (string, error) {
+	return m.agent.CreateGuest(guestID)
+}
+
+// Clone creates a guest whose disks are cloned from those of the guest it is a
+// clone of, see lochness.Guest.Clone
+func (m *Mistify) Clone(guestID string) (string, error) {
+	return m.agent.CloneGuest(guestID)
+}
+
+// Delete deletes a guest from its hypervisor
+func (m *Mistify) Delete(guestID string) (string, error) {
+	return m.agent.DeleteGuest(guestID)
+}
+
+// Reboot reboots a guest
+func (m *Mistify) Reboot(guestID string) (string, error) {
+	return m.agent.GuestAction(guestID, "reboot")
+}
+
+// Shutdown cleanly shuts down a guest
+func (m *Mistify) Shutdown(guestID string) (string, error) {
+	return m.agent.GuestAction(guestID, "shutdown")
+}
+
+// Action runs any action of the agent on a guest, e.g. "start" or "poweroff"
+func (m *Mistify) Action(guestID, action string) (string, error) {
+	return m.agent.GuestAction(guestID, action)
+}
+
+// Snapshot takes a named snapshot of a guest
+func (m *Mistify) Snapshot(guestID, name string) (string, error) {
+	return m.agent.SnapshotGuest(guestID, name)
+}
+
+// Resize resizes a guest to the flavor it is being resized to, see
+// lochness.Guest.Resize
+func (m *Mistify) Resize(guestID string) (string, error) {
+	return m.agent.ResizeGuest(guestID)
+}
+
+// FetchImage fetches the disk image a guest is created from onto its
+// hypervisor
+func (m *Mistify) FetchImage(guestID string) (string, error) {
+	return m.agent.FetchImage(guestID)
+}
+
+// FetchState retrieves the guest as known to its hypervisor, including its
+// current state
+func (m *Mistify) FetchState(guestID string) (*client.Guest, error) {
+	return m.agent.GetGuest(guestID)
+}
+
+// JobStatus returns whether an agent job has completed. A completed job that
+// failed returns its error.
+func (m *Mistify) JobStatus(guestID, jobID string) (bool, error) {
+	return m.agent.CheckJobStatus(guestID, jobID)
+}
+
+// DialConsole connects to a console of a guest, vnc or serial
+func (m *Mistify) DialConsole(guestID, consoleType string) (net.Conn, error) {
+	return m.agent.DialConsole(guestID, consoleType)
+}
@@ -0,0 +1,130 @@
+package agent_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/mistifyio/lochness/pkg/agent"
+	magent "github.com/mistifyio/mistify-agent"
+	mnet "github.com/mistifyio/util/net"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestMistify(t *testing.T) {
+	suite.Run(t, new(MistifySuite))
+}
+
+type MistifySuite struct {
+	common.Suite
+	Agent    *agent.Mistify
+	API      *httptest.Server
+	Guest    *lochness.Guest
+	Requests []string
+}
+
+func (s *MistifySuite) SetupSuite() {
+	s.Suite.SetupSuite()
+
+	s.API = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Requests = append(s.Requests, r.Method+" "+r.URL.Path)
+		if r.Method == "POST" {
+			w.Header().Set("X-Guest-Job-ID", uuid.New())
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		var body interface{} = &magent.Job{Status: magent.Complete}
+		switch {
+		case r.URL.Path == "/guests/"+s.Guest.ID:
+			body = s.Guest
+		case strings.HasPrefix(r.URL.Path, "/guests/"):
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		data, _ := json.Marshal(body)
+		_, _ = w.Write(data)
+	}))
+}
+
+func (s *MistifySuite) SetupTest() {
+	s.Suite.SetupTest()
+	u, _ := url.Parse(s.API.URL)
+	host, sPort, _ := mnet.SplitHostPort(u.Host)
+	port, _ := strconv.Atoi(sPort)
+	s.Agent = agent.NewMistify(s.Context, port)
+
+	var hypervisor *lochness.Hypervisor
+	hypervisor, s.Guest = s.NewHypervisorWithGuest()
+	hypervisor.IP = net.ParseIP(host)
+	s.Require().NoError(hypervisor.Save())
+	s.Requests = nil
+}
+
+func (s *MistifySuite) TearDownSuite() {
+	s.API.Close()
+	s.Suite.TearDownSuite()
+}
+
+func (s *MistifySuite) TestOperations() {
+	id := s.Guest.ID
+	tests := []struct {
+		description string
+		op          func() (string, error)
+		request     string
+	}{
+		{"create", func() (string, error) { return s.Agent.Create(id) }, "POST /guests"},
+		{"delete", func() (string, error) { return s.Agent.Delete(id) }, fmt.Sprintf("POST /guests/%s/delete", id)},
+		{"reboot", func() (string, error) { return s.Agent.Reboot(id) }, fmt.Sprintf("POST /guests/%s/reboot", id)},
+		{"shutdown", func() (string, error) { return s.Agent.Shutdown(id) }, fmt.Sprintf("POST /guests/%s/shutdown", id)},
+		{"action", func() (string, error) { return s.Agent.Action(id, "start") }, fmt.Sprintf("POST /guests/%s/start", id)},
+		{"snapshot", func() (string, error) { return s.Agent.Snapshot(id, "snap") }, fmt.Sprintf("POST /guests/%s/snapshots", id)},
+		{"fetch image", func() (string, error) { return s.Agent.FetchImage(id) }, "POST /images"},
+	}
+
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		s.Requests = nil
+		jobID, err := test.op()
+		s.NoError(err, msg("should succeed"))
+		s.NotNil(uuid.Parse(jobID), msg("should return jobID"))
+		s.Equal([]string{test.request}, s.Requests, msg("wrong request"))
+
+		done, err := s.Agent.JobStatus(id, jobID)
+		s.NoError(err, msg("job status should succeed"))
+		s.True(done, msg("job should be done"))
+	}
+}
+
+func (s *MistifySuite) TestFetchState() {
+	guest, err := s.Agent.FetchState(s.Guest.ID)
+	s.NoError(err)
+	s.Equal(s.Guest.ID, guest.ID)
+
+	_, err = s.Agent.FetchState(uuid.New())
+	s.Error(err, "nonexistent guest should fail")
+}
+
+func (s *MistifySuite) TestWithHost() {
+	host, _, _ := mnet.SplitHostPort(s.API.Listener.Addr().String())
+	id := uuid.New()
+
+	_, err := s.Agent.Delete(id)
+	s.Error(err, "guest unknown to lochness should have no agent")
+
+	jobID, err := s.Agent.WithHost(host).Delete(id)
+	s.NoError(err)
+	s.NotNil(uuid.Parse(jobID))
+	s.Equal([]string{fmt.Sprintf("POST /guests/%s/delete", id)}, s.Requests)
+
+	_, err = s.Agent.WithHost(host).FetchState(id)
+	s.True(agent.IsGuestNotFound(err), "guest unknown to the agent should not be found")
+}
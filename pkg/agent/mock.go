@@ -0,0 +1,302 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/mistify-agent/client"
+	"github.com/pborman/uuid"
+)
+
+// Operation names used with Mock.SetError
+const (
+	OpCreate      = "create"
+	OpClone       = "clone"
+	OpDelete      = "delete"
+	OpReboot      = "reboot"
+	OpShutdown    = "shutdown"
+	OpAction      = "action"
+	OpSnapshot    = "snapshot"
+	OpResize      = "resize"
+	OpFetchImage  = "fetchimage"
+	OpFetchState  = "fetchstate"
+	OpJobStatus   = "jobstatus"
+	OpDialConsole = "dialconsole"
+)
+
+// Guest states reported by Mock
+const (
+	StateRunning  = "running"
+	StateShutdown = "shutdown"
+)
+
+// ErrGuestNotFound is returned by Mock for guests it does not know about
+var ErrGuestNotFound = errors.New("guest not found")
+
+// ErrJobNotFound is returned by Mock for jobs it did not start
+var ErrJobNotFound = errors.New("job not found")
+
+// actionStates are the states guests are left in by the actions of Mock.Action,
+// other actions leave the state as it is
+var actionStates = map[string]string{
+	"start":    StateRunning,
+	"reboot":   StateRunning,
+	"restart":  StateRunning,
+	"shutdown": StateShutdown,
+	"poweroff": StateShutdown,
+}
+
+// Mock is an in-memory GuestAgent for tests. Operations take effect, and
+// their jobs complete, immediately. Errors can be injected per operation.
+type Mock struct {
+	mu        sync.Mutex
+	guests    map[string]*client.Guest
+	snapshots map[string][]string
+	resizes   map[string]int
+	images    map[string]bool
+	jobs      map[string]string
+	errs      map[string]error
+}
+
+var _ GuestAgent = &Mock{}
+
+// NewMock creates a new Mock with no guests
+func NewMock() *Mock {
+	return &Mock{
+		guests:    map[string]*client.Guest{},
+		snapshots: map[string][]string{},
+		resizes:   map[string]int{},
+		images:    map[string]bool{},
+		jobs:      map[string]string{},
+		errs:      map[string]error{},
+	}
+}
+
+// SetError makes every call of the operation op fail with err. A nil err
+// clears it.
+func (m *Mock) SetError(op string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err == nil {
+		delete(m.errs, op)
+		return
+	}
+	m.errs[op] = err
+}
+
+// AddGuest adds a guest as if it had already been created
+func (m *Mock) AddGuest(guest *client.Guest) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	g := *guest
+	if g.State == "" {
+		g.State = StateRunning
+	}
+	m.guests[g.ID] = &g
+}
+
+// Snapshots returns the names of the snapshots taken of a guest
+func (m *Mock) Snapshots(guestID string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]string(nil), m.snapshots[guestID]...)
+}
+
+// Resizes returns the number of times a guest has been resized
+func (m *Mock) Resizes(guestID string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.resizes[guestID]
+}
+
+// ImageFetched returns whether the image of a guest has been fetched
+func (m *Mock) ImageFetched(guestID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.images[guestID]
+}
+
+// WithContext returns m, requests are not made
+func (m *Mock) WithContext(ctx context.Context) GuestAgent {
+	return m
+}
+
+// job records a completed job for a guest and returns its id. mu must be held.
+func (m *Mock) job(guestID string) string {
+	id := uuid.New()
+	m.jobs[id] = guestID
+	return id
+}
+
+// guestOp runs f on an existing guest unless op has an injected error
+func (m *Mock) guestOp(op, guestID string, f func(*client.Guest)) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.errs[op]; err != nil {
+		return "", err
+	}
+	g, ok := m.guests[guestID]
+	if !ok {
+		return "", ErrGuestNotFound
+	}
+	f(g)
+	return m.job(guestID), nil
+}
+
+// Create creates a running guest
+func (m *Mock) Create(guestID string) (string, error) {
+	return m.create(OpCreate, guestID)
+}
+
+// Clone creates a running guest, as Create does
+func (m *Mock) Clone(guestID string) (string, error) {
+	return m.create(OpClone, guestID)
+}
+
+// create creates a running guest unless op has an injected error
+func (m *Mock) create(op, guestID string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.errs[op]; err != nil {
+		return "", err
+	}
+	if guestID == "" {
+		return "", errors.New("missing guest id")
+	}
+	m.guests[guestID] = &client.Guest{ID: guestID, State: StateRunning}
+	return m.job(guestID), nil
+}
+
+// Delete removes a guest
+func (m *Mock) Delete(guestID string) (string, error) {
+	return m.guestOp(OpDelete, guestID, func(g *client.Guest) {
+		delete(m.guests, g.ID)
+		delete(m.snapshots, g.ID)
+	})
+}
+
+// Reboot leaves a guest running
+func (m *Mock) Reboot(guestID string) (string, error) {
+	return m.guestOp(OpReboot, guestID, func(g *client.Guest) {
+		g.State = StateRunning
+	})
+}
+
+// Shutdown shuts down a guest
+func (m *Mock) Shutdown(guestID string) (string, error) {
+	return m.guestOp(OpShutdown, guestID, func(g *client.Guest) {
+		g.State = StateShutdown
+	})
+}
+
+// Action leaves a guest running after a start, reboot or restart, and shut
+// down after a shutdown or poweroff
+func (m *Mock) Action(guestID, action string) (string, error) {
+	if action == "" {
+		return "", errors.New("missing action")
+	}
+	return m.guestOp(OpAction, guestID, func(g *client.Guest) {
+		if state, ok := actionStates[action]; ok {
+			g.State = state
+		}
+	})
+}
+
+// Snapshot records a named snapshot of a guest
+func (m *Mock) Snapshot(guestID, name string) (string, error) {
+	if name == "" {
+		return "", errors.New("missing snapshot name")
+	}
+	return m.guestOp(OpSnapshot, guestID, func(g *client.Guest) {
+		m.snapshots[g.ID] = append(m.snapshots[g.ID], name)
+	})
+}
+
+// Resize records a resize of a guest
+func (m *Mock) Resize(guestID string) (string, error) {
+	return m.guestOp(OpResize, guestID, func(g *client.Guest) {
+		m.resizes[g.ID]++
+	})
+}
+
+// FetchImage records the image of a guest as fetched. The guest need not
+// exist yet, images are fetched before guests are created.
+func (m *Mock) FetchImage(guestID string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.errs[OpFetchImage]; err != nil {
+		return "", err
+	}
+	if guestID == "" {
+		return "", errors.New("missing guest id")
+	}
+	m.images[guestID] = true
+	return m.job(guestID), nil
+}
+
+// FetchState returns a copy of a guest
+func (m *Mock) FetchState(guestID string) (*client.Guest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.errs[OpFetchState]; err != nil {
+		return nil, err
+	}
+	g, ok := m.guests[guestID]
+	if !ok {
+		return nil, ErrGuestNotFound
+	}
+	guest := *g
+	return &guest, nil
+}
+
+// JobStatus reports jobs started by the Mock as complete
+func (m *Mock) JobStatus(guestID, jobID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.errs[OpJobStatus]; err != nil {
+		return true, err
+	}
+	if id, ok := m.jobs[jobID]; !ok || id != guestID {
+		return false, ErrJobNotFound
+	}
+	return true, nil
+}
+
+// DialConsole returns a connection to a console of a guest that echoes back
+// whatever is written to it, until it is closed
+func (m *Mock) DialConsole(guestID, consoleType string) (net.Conn, error) {
+	if !lochness.ConsoleTypes[consoleType] {
+		return nil, fmt.Errorf("invalid console type %q", consoleType)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.errs[OpDialConsole]; err != nil {
+		return nil, err
+	}
+	if _, ok := m.guests[guestID]; !ok {
+		return nil, ErrGuestNotFound
+	}
+	conn, console := net.Pipe()
+	go func() {
+		_, _ = io.Copy(console, console)
+		_ = console.Close()
+	}()
+	return conn, nil
+}
@@ -0,0 +1,147 @@
+package agent_test
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/pkg/agent"
+	"github.com/mistifyio/mistify-agent/client"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestMock(t *testing.T) {
+	suite.Run(t, new(MockSuite))
+}
+
+type MockSuite struct {
+	suite.Suite
+	Mock *agent.Mock
+}
+
+func (s *MockSuite) SetupTest() {
+	s.Mock = agent.NewMock()
+}
+
+func (s *MockSuite) TestLifecycle() {
+	jobID, err := s.Mock.Create("foo")
+	s.NoError(err)
+	done, err := s.Mock.JobStatus("foo", jobID)
+	s.True(done)
+	s.NoError(err)
+
+	guest, err := s.Mock.FetchState("foo")
+	s.NoError(err)
+	s.Equal(agent.StateRunning, guest.State)
+
+	_, err = s.Mock.Shutdown("foo")
+	s.NoError(err)
+	guest, _ = s.Mock.FetchState("foo")
+	s.Equal(agent.StateShutdown, guest.State)
+
+	_, err = s.Mock.Reboot("foo")
+	s.NoError(err)
+	guest, _ = s.Mock.FetchState("foo")
+	s.Equal(agent.StateRunning, guest.State)
+
+	_, err = s.Mock.Action("foo", "poweroff")
+	s.NoError(err)
+	guest, _ = s.Mock.FetchState("foo")
+	s.Equal(agent.StateShutdown, guest.State)
+
+	_, err = s.Mock.Action("foo", "start")
+	s.NoError(err)
+	guest, _ = s.Mock.FetchState("foo")
+	s.Equal(agent.StateRunning, guest.State)
+
+	_, err = s.Mock.Resize("foo")
+	s.NoError(err)
+	s.Equal(1, s.Mock.Resizes("foo"))
+
+	_, err = s.Mock.Snapshot("foo", "snap1")
+	s.NoError(err)
+	_, err = s.Mock.Snapshot("foo", "")
+	s.Error(err, "missing snapshot name should error")
+	s.Equal([]string{"snap1"}, s.Mock.Snapshots("foo"))
+
+	_, err = s.Mock.Delete("foo")
+	s.NoError(err)
+	_, err = s.Mock.FetchState("foo")
+	s.Equal(agent.ErrGuestNotFound, err)
+	s.True(agent.IsGuestNotFound(err))
+}
+
+func (s *MockSuite) TestUnknownGuest() {
+	for _, op := range []func(string) (string, error){s.Mock.Delete, s.Mock.Reboot, s.Mock.Shutdown, s.Mock.Resize} {
+		jobID, err := op("missing")
+		s.Equal(agent.ErrGuestNotFound, err)
+		s.Empty(jobID)
+	}
+
+	done, err := s.Mock.JobStatus("missing", "missing")
+	s.False(done)
+	s.Equal(agent.ErrJobNotFound, err)
+}
+
+func (s *MockSuite) TestAddGuest() {
+	s.Mock.AddGuest(&client.Guest{ID: "foo"})
+	guest, err := s.Mock.FetchState("foo")
+	s.NoError(err)
+	s.Equal(agent.StateRunning, guest.State, "added guest should default to running")
+
+	guest.State = "changed"
+	guest, _ = s.Mock.FetchState("foo")
+	s.Equal(agent.StateRunning, guest.State, "fetched guest should be a copy")
+}
+
+func (s *MockSuite) TestSetError() {
+	failure := errors.New("failure")
+	s.Mock.SetError(agent.OpCreate, failure)
+	_, err := s.Mock.Create("foo")
+	s.Equal(failure, err)
+
+	s.Mock.SetError(agent.OpCreate, nil)
+	jobID, err := s.Mock.Create("foo")
+	s.NoError(err)
+
+	s.Mock.SetError(agent.OpJobStatus, failure)
+	done, err := s.Mock.JobStatus("foo", jobID)
+	s.True(done, "failed job should be done")
+	s.Equal(failure, err)
+}
+
+func (s *MockSuite) TestClone() {
+	_, err := s.Mock.FetchImage("foo")
+	s.NoError(err)
+	s.True(s.Mock.ImageFetched("foo"), "image should be fetched before the guest exists")
+
+	jobID, err := s.Mock.Clone("foo")
+	s.NoError(err)
+	done, err := s.Mock.JobStatus("foo", jobID)
+	s.True(done)
+	s.NoError(err)
+
+	guest, err := s.Mock.FetchState("foo")
+	s.NoError(err)
+	s.Equal(agent.StateRunning, guest.State)
+}
+
+func (s *MockSuite) TestDialConsole() {
+	_, err := s.Mock.DialConsole("missing", lochness.ConsoleSerial)
+	s.Equal(agent.ErrGuestNotFound, err)
+
+	s.Mock.AddGuest(&client.Guest{ID: "foo"})
+	_, err = s.Mock.DialConsole("foo", "bogus")
+	s.Error(err, "invalid console type should fail")
+
+	conn, err := s.Mock.DialConsole("foo", lochness.ConsoleSerial)
+	s.Require().NoError(err)
+	defer func() { _ = conn.Close() }()
+
+	go func() { _, _ = conn.Write([]byte("hello")) }()
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	s.NoError(err)
+	s.Equal("hello", string(buf), "console should echo")
+}
@@ -0,0 +1,83 @@
+// Package bmc controls the power of machines through their baseboard
+// management controllers, over IPMI with ipmitool or over Redfish.
+package bmc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Protocols a BMC may be driven with
+const (
+	ProtocolIPMI    = "ipmi"
+	ProtocolRedfish = "redfish"
+)
+
+// Power actions
+const (
+	// PowerOn powers a machine on
+	PowerOn = "on"
+	// PowerOff powers a machine off immediately, without a clean shutdown
+	PowerOff = "off"
+	// PowerCycle powers a machine off and on again
+	PowerCycle = "cycle"
+)
+
+var (
+	// Protocols are the protocols a BMC may be driven with
+	Protocols = map[string]bool{
+		ProtocolIPMI:    true,
+		ProtocolRedfish: true,
+	}
+
+	// Actions are the power actions a Controller performs
+	Actions = map[string]bool{
+		PowerOn:    true,
+		PowerOff:   true,
+		PowerCycle: true,
+	}
+
+	// Timeout is how long a power action may take
+	Timeout = 30 * time.Second
+
+	// ErrInvalidAction is returned for power actions other than Actions
+	ErrInvalidAction = errors.New("invalid power action")
+)
+
+// Controller performs power actions on a machine through its BMC
+type Controller interface {
+	Power(action string) error
+}
+
+// Credentials are the user and password a BMC is logged into with
+type Credentials struct {
+	User     string
+	Password string
+}
+
+// ParseCredentials parses credentials of the form "user:password", as they
+// are stored in a secret
+func ParseCredentials(s string) (Credentials, error) {
+	parts := strings.SplitN(strings.TrimSpace(s), ":", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return Credentials{}, errors.New("bmc credentials must be user:password")
+	}
+	return Credentials{User: parts[0], Password: parts[1]}, nil
+}
+
+// New creates a Controller for a BMC. The address of an IPMI BMC is its host,
+// and that of a Redfish BMC the url of its service, e.g. https://10.0.0.5.
+func New(protocol, address string, creds Credentials) (Controller, error) {
+	if address == "" {
+		return nil, errors.New("missing bmc address")
+	}
+	switch protocol {
+	case ProtocolIPMI:
+		return &IPMI{Address: address, Credentials: creds}, nil
+	case ProtocolRedfish:
+		return NewRedfish(address, creds)
+	}
+	return nil, fmt.Errorf("unsupported bmc protocol %q", protocol)
+}
@@ -0,0 +1,143 @@
+package bmc_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mistifyio/lochness/pkg/bmc"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestBMC(t *testing.T) {
+	suite.Run(t, new(BMCSuite))
+}
+
+type BMCSuite struct {
+	suite.Suite
+}
+
+// fakeRedfish is a Redfish service with a single system, recording the resets
+// requested
+type fakeRedfish struct {
+	mu     sync.Mutex
+	resets []string
+	auth   string
+}
+
+func (f *fakeRedfish) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, password, _ := r.BasicAuth()
+	if user+":"+password != f.auth {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/redfish/v1/Systems":
+		fmt.Fprint(w, `{"Members":[{"@odata.id":"/redfish/v1/Systems/1"}]}`)
+	case r.Method == "GET" && r.URL.Path == "/redfish/v1/Systems/1":
+		fmt.Fprint(w, `{"Actions":{"#ComputerSystem.Reset":{"target":"/redfish/v1/Systems/1/Actions/Reset"}}}`)
+	case r.Method == "POST" && r.URL.Path == "/redfish/v1/Systems/1/Actions/Reset":
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		f.mu.Lock()
+		f.resets = append(f.resets, body["ResetType"])
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *BMCSuite) TestParseCredentials() {
+	creds, err := bmc.ParseCredentials("admin:pass:word\n")
+	s.NoError(err)
+	s.Equal(bmc.Credentials{User: "admin", Password: "pass:word"}, creds)
+
+	_, err = bmc.ParseCredentials("admin")
+	s.Error(err)
+	_, err = bmc.ParseCredentials(":password")
+	s.Error(err)
+}
+
+func (s *BMCSuite) TestNew() {
+	creds := bmc.Credentials{User: "admin", Password: "admin"}
+	tests := []struct {
+		description string
+		protocol    string
+		address     string
+		expectedErr bool
+	}{
+		{"ipmi", bmc.ProtocolIPMI, "10.0.0.5", false},
+		{"redfish", bmc.ProtocolRedfish, "https://10.0.0.5", false},
+		{"redfish without scheme", bmc.ProtocolRedfish, "10.0.0.5", true},
+		{"missing address", bmc.ProtocolIPMI, "", true},
+		{"unknown protocol", "amt", "10.0.0.5", true},
+	}
+
+	for _, test := range tests {
+		_, err := bmc.New(test.protocol, test.address, creds)
+		if test.expectedErr {
+			s.Error(err, test.description)
+		} else {
+			s.NoError(err, test.description)
+		}
+	}
+}
+
+func (s *BMCSuite) TestRedfish() {
+	fake := &fakeRedfish{auth: "admin:secret"}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	controller, err := bmc.New(bmc.ProtocolRedfish, server.URL, bmc.Credentials{User: "admin", Password: "secret"})
+	s.Require().NoError(err)
+	for _, action := range []string{bmc.PowerOn, bmc.PowerOff, bmc.PowerCycle} {
+		s.NoError(controller.Power(action), action)
+	}
+	s.Equal([]string{"On", "ForceOff", "PowerCycle"}, fake.resets)
+	s.Equal(bmc.ErrInvalidAction, controller.Power("reboot"))
+
+	controller, err = bmc.New(bmc.ProtocolRedfish, server.URL, bmc.Credentials{User: "admin", Password: "wrong"})
+	s.Require().NoError(err)
+	err = controller.Power(bmc.PowerOn)
+	s.Error(err)
+	s.Contains(err.Error(), "401")
+}
+
+func (s *BMCSuite) TestIPMI() {
+	dir, err := ioutil.TempDir("", "bmc-test")
+	s.Require().NoError(err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	// the fake ipmitool records its arguments and the password it was given
+	out := filepath.Join(dir, "out")
+	script := filepath.Join(dir, "ipmitool")
+	s.Require().NoError(ioutil.WriteFile(script, []byte(fmt.Sprintf(`#!/bin/sh
+echo "$@ $IPMI_PASSWORD" > %s
+[ "$6" != "fail" ] || { echo "unable to establish session" >&2; exit 1; }
+`, out)), 0755))
+
+	ipmi := &bmc.IPMI{
+		Address:     "10.0.0.5",
+		Credentials: bmc.Credentials{User: "admin", Password: "secret"},
+		Command:     script,
+	}
+	s.NoError(ipmi.Power(bmc.PowerCycle))
+	data, err := ioutil.ReadFile(out)
+	s.Require().NoError(err)
+	s.Equal("-I lanplus -H 10.0.0.5 -U admin -E chassis power cycle secret", strings.TrimSpace(string(data)))
+
+	s.Equal(bmc.ErrInvalidAction, ipmi.Power("reboot"))
+
+	ipmi.Credentials.User = "fail"
+	err = ipmi.Power(bmc.PowerOn)
+	s.Error(err)
+	s.Contains(err.Error(), "unable to establish session")
+}
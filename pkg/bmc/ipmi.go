@@ -0,0 +1,54 @@
+package bmc
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// IPMI drives a BMC over IPMI v2.0 (lanplus) with ipmitool
+type IPMI struct {
+	Address     string
+	Credentials Credentials
+	// Command is the ipmitool to run, "ipmitool" if blank
+	Command string
+}
+
+// ipmiActions are the ipmitool chassis power commands of the power actions
+var ipmiActions = map[string]string{
+	PowerOn:    "on",
+	PowerOff:   "off",
+	PowerCycle: "cycle",
+}
+
+// Power performs a power action. The password is passed to ipmitool through
+// its environment rather than its arguments, so that it can not be seen in
+// the process list.
+func (i *IPMI) Power(action string) error {
+	command, ok := ipmiActions[action]
+	if !ok {
+		return ErrInvalidAction
+	}
+	name := i.Command
+	if name == "" {
+		name = "ipmitool"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, name,
+		"-I", "lanplus",
+		"-H", i.Address,
+		"-U", i.Credentials.User,
+		"-E",
+		"chassis", "power", command,
+	)
+	cmd.Env = append(os.Environ(), "IPMI_PASSWORD="+i.Credentials.Password)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ipmitool chassis power %s: %s: %s", command, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
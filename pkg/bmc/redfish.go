@@ -0,0 +1,128 @@
+package bmc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Redfish drives a BMC through its Redfish service, resetting the first
+// system the service manages
+type Redfish struct {
+	URL         *url.URL
+	Credentials Credentials
+	Client      *http.Client
+}
+
+// redfishResetTypes are the ComputerSystem.Reset types of the power actions
+var redfishResetTypes = map[string]string{
+	PowerOn:    "On",
+	PowerOff:   "ForceOff",
+	PowerCycle: "PowerCycle",
+}
+
+// NewRedfish creates a Redfish for the service at address
+func NewRedfish(address string, creds Credentials) (*Redfish, error) {
+	u, err := url.Parse(address)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("redfish address must be an http or https url: %q", address)
+	}
+	return &Redfish{
+		URL:         u,
+		Credentials: creds,
+		Client:      &http.Client{Timeout: Timeout},
+	}, nil
+}
+
+// Power performs a power action
+func (r *Redfish) Power(action string) error {
+	resetType, ok := redfishResetTypes[action]
+	if !ok {
+		return ErrInvalidAction
+	}
+
+	system, err := r.system()
+	if err != nil {
+		return err
+	}
+	var info struct {
+		Actions struct {
+			Reset struct {
+				Target string `json:"target"`
+			} `json:"#ComputerSystem.Reset"`
+		} `json:"Actions"`
+	}
+	if err := r.do("GET", system, nil, &info); err != nil {
+		return err
+	}
+	target := info.Actions.Reset.Target
+	if target == "" {
+		target = strings.TrimSuffix(system, "/") + "/Actions/ComputerSystem.Reset"
+	}
+	return r.do("POST", target, map[string]string{"ResetType": resetType}, nil)
+}
+
+// system returns the path of the first system of the service
+func (r *Redfish) system() (string, error) {
+	var systems struct {
+		Members []struct {
+			ID string `json:"@odata.id"`
+		} `json:"Members"`
+	}
+	if err := r.do("GET", "/redfish/v1/Systems", nil, &systems); err != nil {
+		return "", err
+	}
+	if len(systems.Members) == 0 {
+		return "", errors.New("redfish service has no systems")
+	}
+	return systems.Members[0].ID, nil
+}
+
+// do makes a request of the service and decodes the response into out, if
+// not nil
+func (r *Redfish) do(method, path string, body interface{}, out interface{}) error {
+	ref, err := url.Parse(path)
+	if err != nil {
+		return err
+	}
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, r.URL.ResolveReference(ref).String(), reader)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(r.Credentials.User, r.Credentials.Password)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("redfish %s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(data)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
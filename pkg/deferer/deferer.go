@@ -2,6 +2,10 @@
 // log.Fatal() is effecively the same as calling fmt.Println() followed by
 // os.Exit(1). The normal defer methods are not run when os.Exit() is called
 // but sometimes it is necessary (e.g. release a lock).
+//
+// A Journal goes further, recording named cleanup actions in a kv so that a
+// Janitor in another process can perform them if the process dies before
+// completing its changes.
 package deferer
 
 import (
@@ -0,0 +1,323 @@
+package deferer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/mistifyio/lochness/pkg/kv"
+	"github.com/pborman/uuid"
+)
+
+// Handlers maps the names of cleanup actions to the functions performing
+// them. Handlers may be called again for an action they already performed, by
+// a Janitor after a crash, so they should be idempotent.
+type Handlers map[string]func(params map[string]string) error
+
+// Action is a cleanup action recorded in a Journal
+type Action struct {
+	Name    string            `json:"name"`
+	Params  map[string]string `json:"params"`
+	Created time.Time         `json:"created"`
+}
+
+// Owner describes the process a Journal belongs to
+type Owner struct {
+	Hostname string    `json:"hostname"`
+	PID      int       `json:"pid"`
+	Started  time.Time `json:"started"`
+}
+
+// journal key helpers
+func aliveKey(key string) string   { return path.Join(key, "alive") }
+func actionsKey(key string) string { return path.Join(key, "actions") }
+
+// Journal is a Deferer whose cleanup actions are recorded in a kv before the
+// changes they undo are made. Unlike a Deferer, actions are named and looked
+// up in Handlers, so that if the process dies before calling Commit or Run, a
+// Janitor in another process can find the journal and roll it back. A journal
+// is owned by its process for as long as the process renews it, which is done
+// in the background.
+type Journal struct {
+	kv       kv.KV
+	key      string
+	handlers Handlers
+	ttl      time.Duration
+
+	mu      sync.Mutex // mu protects the following vars
+	actions []Action
+	keys    []string
+	alive   kv.EphemeralKey
+	stop    chan struct{}
+	closed  bool
+}
+
+// NewJournal creates a Journal under prefix whose actions are performed by
+// handlers. The journal is abandoned, and may be rolled back by a Janitor, if
+// its process stops renewing it for ttl.
+func NewJournal(k kv.KV, prefix string, handlers Handlers, ttl time.Duration) (*Journal, error) {
+	if k == nil {
+		return nil, errors.New("kv must not be nil")
+	}
+	if prefix == "" {
+		return nil, errors.New("missing prefix")
+	}
+	if ttl < time.Second {
+		return nil, errors.New("ttl must be at least 1s")
+	}
+
+	j := &Journal{
+		kv:       k,
+		key:      path.Join(prefix, uuid.New()),
+		handlers: handlers,
+		ttl:      ttl,
+		stop:     make(chan struct{}),
+	}
+
+	alive, err := k.EphemeralKey(aliveKey(j.key), ttl)
+	if err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	data, err := json.Marshal(Owner{
+		Hostname: hostname,
+		PID:      os.Getpid(),
+		Started:  time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := alive.Set(string(data)); err != nil {
+		_ = alive.Destroy()
+		return nil, err
+	}
+	j.alive = alive
+
+	go j.keepAlive()
+	return j, nil
+}
+
+// Key returns the key of the journal
+func (j *Journal) Key() string {
+	return j.key
+}
+
+// keepAlive renews the journal every third of its ttl until it is closed
+func (j *Journal) keepAlive() {
+	ticker := time.NewTicker(j.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-j.stop:
+			return
+		case <-ticker.C:
+			if err := j.alive.Renew(); err != nil {
+				log.WithFields(log.Fields{
+					"error":   err,
+					"journal": j.key,
+				}).Error("failed to renew cleanup journal")
+			}
+		}
+	}
+}
+
+// Defer records a cleanup action, to be performed by the handler of name with
+// params if the journal is run or abandoned. It should be called before the
+// change it undoes is made.
+func (j *Journal) Defer(name string, params map[string]string) error {
+	if _, ok := j.handlers[name]; !ok {
+		return fmt.Errorf("unknown cleanup action %q", name)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.closed {
+		return errors.New("journal is closed")
+	}
+
+	action := Action{
+		Name:    name,
+		Params:  params,
+		Created: time.Now(),
+	}
+	data, err := json.Marshal(action)
+	if err != nil {
+		return err
+	}
+	// zero padded so that actions sort in order
+	key := path.Join(actionsKey(j.key), fmt.Sprintf("%020d", len(j.actions)))
+	if err := j.kv.Set(key, string(data)); err != nil {
+		return err
+	}
+	j.actions = append(j.actions, action)
+	j.keys = append(j.keys, key)
+	return nil
+}
+
+// Run performs the recorded actions in reverse order, as a rollback of the
+// changes made since they were recorded, and removes the journal. Actions
+// that fail are left for a Janitor to retry, and the first error is returned.
+func (j *Journal) Run() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.closed {
+		return nil
+	}
+	j.close()
+
+	err := rollback(j.kv, j.key, j.handlers, j.actions, j.keys)
+	if err != nil {
+		// give the remaining actions up to the janitor
+		_ = j.alive.Destroy()
+		_ = j.kv.Delete(aliveKey(j.key), false)
+		return err
+	}
+	return j.kv.Delete(j.key, true)
+}
+
+// Commit removes the journal without performing its actions, once the
+// changes they would undo are complete
+func (j *Journal) Commit() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.closed {
+		return nil
+	}
+	j.close()
+
+	_ = j.alive.Destroy()
+	return j.kv.Delete(j.key, true)
+}
+
+// close stops renewing the journal. mu must be held.
+func (j *Journal) close() {
+	j.closed = true
+	close(j.stop)
+}
+
+// rollback performs actions in reverse order, deleting the key of each that
+// succeeds, and returns the first error
+func rollback(k kv.KV, journal string, handlers Handlers, actions []Action, keys []string) error {
+	var first error
+	for i := len(actions) - 1; i >= 0; i-- {
+		action := actions[i]
+		handler, ok := handlers[action.Name]
+		err := fmt.Errorf("unknown cleanup action %q", action.Name)
+		if ok {
+			err = handler(action.Params)
+		}
+		if err == nil {
+			err = k.Delete(keys[i], false)
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":   err,
+				"journal": journal,
+				"action":  action.Name,
+				"params":  action.Params,
+			}).Error("cleanup action failed")
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// Janitor rolls back the journals under a prefix that were abandoned by
+// processes that died before committing or running them
+type Janitor struct {
+	kv       kv.KV
+	prefix   string
+	handlers Handlers
+}
+
+// NewJanitor creates a Janitor of the journals under prefix, performing their
+// actions with handlers
+func NewJanitor(k kv.KV, prefix string, handlers Handlers) *Janitor {
+	return &Janitor{
+		kv:       k,
+		prefix:   prefix,
+		handlers: handlers,
+	}
+}
+
+// Sweep rolls back every abandoned journal and returns how many were rolled
+// back completely. Journals with failed actions are kept to be retried by the
+// next sweep.
+func (j *Janitor) Sweep() (int, error) {
+	keys, err := j.kv.Keys(j.prefix)
+	if err != nil {
+		if j.kv.IsKeyNotFound(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	var first error
+	swept := 0
+	for _, key := range keys {
+		key = strings.TrimSuffix(key, "/")
+		err := j.sweep(key)
+		if err == errAlive {
+			continue
+		}
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		swept++
+	}
+	return swept, first
+}
+
+// errAlive is returned when sweeping a journal that is still owned
+var errAlive = errors.New("journal is alive")
+
+// sweep rolls back the journal at key if it has been abandoned
+func (j *Janitor) sweep(key string) error {
+	if _, err := j.kv.Get(aliveKey(key)); err == nil {
+		return errAlive
+	} else if !j.kv.IsKeyNotFound(err) {
+		return err
+	}
+
+	values, err := j.kv.GetAll(actionsKey(key))
+	if err != nil && !j.kv.IsKeyNotFound(err) {
+		return err
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	actions := make([]Action, len(keys))
+	for i, k := range keys {
+		if err := json.Unmarshal(values[k].Data, &actions[i]); err != nil {
+			return err
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"journal": key,
+		"actions": len(actions),
+	}).Warn("rolling back abandoned cleanup journal")
+	if err := rollback(j.kv, key, j.handlers, actions, keys); err != nil {
+		return err
+	}
+	return j.kv.Delete(key, true)
+}
@@ -0,0 +1,133 @@
+package deferer_test
+
+import (
+	"errors"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/mistifyio/lochness/pkg/deferer"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestJournal(t *testing.T) {
+	suite.Run(t, new(JournalSuite))
+}
+
+type JournalSuite struct {
+	common.Suite
+	Prefix   string
+	Undone   []string
+	Handlers deferer.Handlers
+}
+
+func (s *JournalSuite) SetupSuite() {
+	s.TestPrefix = "journal-test"
+	s.Suite.SetupSuite()
+}
+
+func (s *JournalSuite) SetupTest() {
+	s.Suite.SetupTest()
+	s.Prefix = filepath.Join(s.KVPrefix, "cleanup")
+	s.Undone = nil
+	s.Handlers = deferer.Handlers{
+		"undo": func(params map[string]string) error {
+			s.Undone = append(s.Undone, params["step"])
+			return nil
+		},
+		"fail": func(params map[string]string) error {
+			return errors.New("cleanup failed")
+		},
+	}
+}
+
+func (s *JournalSuite) newJournal() *deferer.Journal {
+	j, err := deferer.NewJournal(s.KV, s.Prefix, s.Handlers, time.Second)
+	s.Require().NoError(err)
+	return j
+}
+
+// journals returns the keys of the journals left in the kv
+func (s *JournalSuite) journals() []string {
+	keys, err := s.KV.Keys(s.Prefix)
+	if err != nil && !s.KV.IsKeyNotFound(err) {
+		s.Require().NoError(err)
+	}
+	return keys
+}
+
+func (s *JournalSuite) TestNewJournal() {
+	_, err := deferer.NewJournal(nil, s.Prefix, s.Handlers, time.Second)
+	s.Error(err, "nil kv should error")
+	_, err = deferer.NewJournal(s.KV, "", s.Handlers, time.Second)
+	s.Error(err, "missing prefix should error")
+	_, err = deferer.NewJournal(s.KV, s.Prefix, s.Handlers, time.Millisecond)
+	s.Error(err, "short ttl should error")
+
+	j := s.newJournal()
+	s.Contains(j.Key(), s.Prefix)
+	s.Error(j.Defer("unknown", nil), "unknown actions should error")
+	s.NoError(j.Commit())
+}
+
+func (s *JournalSuite) TestCommit() {
+	j := s.newJournal()
+	s.NoError(j.Defer("undo", map[string]string{"step": "1"}))
+	s.NoError(j.Commit())
+	s.Empty(s.Undone, "committed actions should not run")
+	s.Empty(s.journals())
+	s.Error(j.Defer("undo", nil), "closed journals should not record")
+}
+
+func (s *JournalSuite) TestRun() {
+	j := s.newJournal()
+	s.NoError(j.Defer("undo", map[string]string{"step": "1"}))
+	s.NoError(j.Defer("undo", map[string]string{"step": "2"}))
+	s.NoError(j.Run())
+	s.Equal([]string{"2", "1"}, s.Undone, "should run in reverse order")
+	s.Empty(s.journals())
+	s.NoError(j.Run(), "running again should do nothing")
+	s.Len(s.Undone, 2)
+}
+
+func (s *JournalSuite) TestRunFailure() {
+	j := s.newJournal()
+	s.NoError(j.Defer("undo", map[string]string{"step": "1"}))
+	s.NoError(j.Defer("fail", nil))
+	s.Error(j.Run())
+	s.Equal([]string{"1"}, s.Undone, "should run the other actions")
+	s.Len(s.journals(), 1, "should leave the failed action for the janitor")
+
+	// the janitor retries what is left
+	s.Handlers["fail"] = func(map[string]string) error { return nil }
+	swept, err := deferer.NewJanitor(s.KV, s.Prefix, s.Handlers).Sweep()
+	s.NoError(err)
+	s.Equal(1, swept)
+	s.Equal([]string{"1"}, s.Undone, "should not rerun completed actions")
+	s.Empty(s.journals())
+}
+
+func (s *JournalSuite) TestJanitor() {
+	janitor := deferer.NewJanitor(s.KV, s.Prefix, s.Handlers)
+	swept, err := janitor.Sweep()
+	s.NoError(err)
+	s.Equal(0, swept)
+
+	live := s.newJournal()
+	s.NoError(live.Defer("undo", map[string]string{"step": "live"}))
+	dead := s.newJournal()
+	s.NoError(dead.Defer("undo", map[string]string{"step": "1"}))
+	s.NoError(dead.Defer("undo", map[string]string{"step": "2"}))
+	// as if its process died
+	s.NoError(s.KV.Delete(path.Join(dead.Key(), "alive"), false))
+
+	swept, err = janitor.Sweep()
+	s.NoError(err)
+	s.Equal(1, swept)
+	s.Equal([]string{"2", "1"}, s.Undone, "should roll back abandoned journals only")
+	s.Len(s.journals(), 1)
+
+	s.NoError(live.Commit())
+}
@@ -0,0 +1,115 @@
+// Package errors defines the kinds of errors returned by the kv drivers and
+// the lochness entities, so that callers can tell them apart with errors.Is
+// and errors.As whatever the backend, instead of matching error messages.
+package errors
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNotFound is the kind of errors for keys or entities that do not
+	// exist
+	ErrNotFound = errors.New("not found")
+
+	// ErrConflict is the kind of errors for atomic operations that failed
+	// because the key was modified or created concurrently
+	ErrConflict = errors.New("conflict")
+
+	// ErrValidation is the kind of errors for entities that are not valid,
+	// see ValidationError
+	ErrValidation = errors.New("validation failed")
+)
+
+// Error is an error of one of the kinds above, wrapping the error that caused
+// it, e.g. one returned by a kv backend
+type Error struct {
+	Kind error
+	Err  error
+}
+
+// Error returns the message of the wrapped error
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Is reports whether the error is of kind target
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// Unwrap returns the wrapped error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// NotFound wraps err as an ErrNotFound. A nil err returns nil.
+func NotFound(err error) error {
+	return wrap(ErrNotFound, err)
+}
+
+// NotFoundf creates an ErrNotFound with a formatted message
+func NotFoundf(format string, a ...interface{}) error {
+	return NotFound(fmt.Errorf(format, a...))
+}
+
+// Conflict wraps err as an ErrConflict. A nil err returns nil.
+func Conflict(err error) error {
+	return wrap(ErrConflict, err)
+}
+
+// Conflictf creates an ErrConflict with a formatted message
+func Conflictf(format string, a ...interface{}) error {
+	return Conflict(fmt.Errorf(format, a...))
+}
+
+// wrap wraps err as the kind given
+func wrap(kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{
+		Kind: kind,
+		Err:  err,
+	}
+}
+
+// ValidationError is returned by Validate methods. Fields lists the json names
+// of the fields that failed validation.
+type ValidationError struct {
+	Fields  []string
+	Message string
+}
+
+// Validation creates a ValidationError for a single field
+func Validation(field, message string) *ValidationError {
+	return &ValidationError{
+		Fields:  []string{field},
+		Message: message,
+	}
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// Is reports whether target is ErrValidation
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// IsNotFound returns whether err is an ErrNotFound
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
+
+// IsConflict returns whether err is an ErrConflict
+func IsConflict(err error) bool {
+	return errors.Is(err, ErrConflict)
+}
+
+// IsValidation returns whether err is an ErrValidation
+func IsValidation(err error) bool {
+	return errors.Is(err, ErrValidation)
+}
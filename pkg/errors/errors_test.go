@@ -0,0 +1,58 @@
+package errors_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestErrors(t *testing.T) {
+	suite.Run(t, new(ErrorsSuite))
+}
+
+type ErrorsSuite struct {
+	suite.Suite
+}
+
+func (s *ErrorsSuite) TestKinds() {
+	cause := errors.New("key not found")
+	notFound := lerrors.NotFound(cause)
+
+	tests := []struct {
+		description string
+		err         error
+		notFound    bool
+		conflict    bool
+		validation  bool
+	}{
+		{"plain", cause, false, false, false},
+		{"not found", notFound, true, false, false},
+		{"wrapped not found", fmt.Errorf("fetching: %w", notFound), true, false, false},
+		{"conflictf", lerrors.Conflictf("index %d is stale", 3), false, true, false},
+		{"validation", lerrors.Validation("id", "invalid ID"), false, false, true},
+		{"nil", nil, false, false, false},
+	}
+
+	for _, test := range tests {
+		s.Equal(test.notFound, lerrors.IsNotFound(test.err), test.description)
+		s.Equal(test.conflict, lerrors.IsConflict(test.err), test.description)
+		s.Equal(test.validation, lerrors.IsValidation(test.err), test.description)
+	}
+
+	s.Equal("key not found", notFound.Error(), "message should be the cause's")
+	s.True(errors.Is(notFound, cause), "cause should be unwrapped")
+	s.Nil(lerrors.NotFound(nil))
+	s.Nil(lerrors.Conflict(nil))
+}
+
+func (s *ErrorsSuite) TestValidationError() {
+	err := fmt.Errorf("saving: %w", lerrors.Validation("cidr", "CIDR cannot be nil"))
+
+	var verr *lerrors.ValidationError
+	s.Require().True(errors.As(err, &verr))
+	s.Equal([]string{"cidr"}, verr.Fields)
+	s.Equal("CIDR cannot be nil", verr.Error())
+}
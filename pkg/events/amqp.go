@@ -0,0 +1,91 @@
+package events
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/streadway/amqp"
+)
+
+// amqpPublisher publishes events to a durable topic exchange named for the
+// prefix, with the event type as routing key. Subscribers bind their queues
+// with patterns such as "guest.*" or "#".
+type amqpPublisher struct {
+	mu       sync.Mutex
+	addr     string
+	exchange string
+	conn     *amqp.Connection
+	ch       *amqp.Channel
+}
+
+func newAMQPPublisher(addr, exchange string) (*amqpPublisher, error) {
+	p := &amqpPublisher{addr: addr, exchange: exchange}
+	if err := p.connect(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// connect dials the broker and declares the exchange
+func (p *amqpPublisher) connect() error {
+	conn, err := amqp.Dial(p.addr)
+	if err != nil {
+		return err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	if err := ch.ExchangeDeclare(p.exchange, "topic", true, false, false, false, nil); err != nil {
+		_ = conn.Close()
+		return err
+	}
+	p.conn, p.ch = conn, ch
+	return nil
+}
+
+// Publish sends an event as a persistent message. A failed publish drops the
+// connection, and the next one reconnects.
+func (p *amqpPublisher) Publish(e *Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conn == nil {
+		if err := p.connect(); err != nil {
+			return err
+		}
+	}
+
+	err = p.ch.Publish(p.exchange, e.Type, false, false, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		MessageId:    e.ID,
+		Timestamp:    e.Time,
+		Type:         e.Type,
+		Body:         body,
+	})
+	if err != nil {
+		_ = p.conn.Close()
+		p.conn, p.ch = nil, nil
+	}
+	return err
+}
+
+// Close disconnects from the broker
+func (p *amqpPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close()
+	p.conn, p.ch = nil, nil
+	return err
+}
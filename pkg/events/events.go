@@ -0,0 +1,84 @@
+// Package events publishes notifications of changes to entities, such as a
+// guest being created or a job failing, to a message broker. Events have a
+// stable JSON encoding so that subscribers written in any language can consume
+// them. NATS (nats://) and AMQP 0-9-1 (amqp://, amqps://) brokers are supported.
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/pborman/uuid"
+)
+
+// Version is the version of the event encoding. It only changes when fields
+// are removed or change meaning.
+const Version = 1
+
+// Event is a change to an entity
+type Event struct {
+	Version int       `json:"version"`
+	ID      string    `json:"id"`
+	Type    string    `json:"type"`
+	Entity  string    `json:"entity"`
+	Subject string    `json:"subject"`
+	Time    time.Time `json:"time"`
+	// Data is the entity after the change, if there is one
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// Publisher sends events to a broker
+type Publisher interface {
+	// Publish sends an event to the topic of its type
+	Publish(*Event) error
+	// Close disconnects from the broker
+	Close() error
+}
+
+// New creates an event of type action on an entity, e.g. "created" on a
+// "guest" with the id subject.
+func New(entity, action, subject string, data []byte) *Event {
+	return &Event{
+		Version: Version,
+		ID:      uuid.New(),
+		Type:    entity + "." + action,
+		Entity:  entity,
+		Subject: subject,
+		Time:    time.Now().UTC(),
+		Data:    json.RawMessage(data),
+	}
+}
+
+// Topic returns the topic an event is published to, its type under prefix,
+// e.g. "lochness.guest.created".
+func (e *Event) Topic(prefix string) string {
+	if prefix == "" {
+		return e.Type
+	}
+	return prefix + "." + e.Type
+}
+
+// NewPublisher connects to the broker at addr, chosen by its scheme. Events
+// are published under the topic prefix, which is the exchange for AMQP.
+func NewPublisher(addr, prefix string) (Publisher, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var p Publisher
+	switch u.Scheme {
+	case "nats", "tls":
+		p, err = newNATSPublisher(addr, prefix)
+	case "amqp", "amqps":
+		p, err = newAMQPPublisher(addr, prefix)
+	default:
+		err = fmt.Errorf("unsupported broker scheme: %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
@@ -0,0 +1,87 @@
+package events_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mistifyio/lochness/pkg/events"
+	"github.com/mistifyio/lochness/pkg/kv"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestEvents(t *testing.T) {
+	suite.Run(t, new(EventsSuite))
+}
+
+type EventsSuite struct {
+	suite.Suite
+}
+
+func (s *EventsSuite) TestNew() {
+	e := events.New("guest", "created", "f2011319-ad59-42fb-9bad-92e261f0651c", []byte(`{"id":"f2011319-ad59-42fb-9bad-92e261f0651c"}`))
+	s.Equal(events.Version, e.Version)
+	s.NotEmpty(e.ID)
+	s.Equal("guest.created", e.Type)
+	s.Equal("guest", e.Entity)
+	s.False(e.Time.IsZero())
+
+	body, err := json.Marshal(e)
+	s.Require().NoError(err)
+	var decoded map[string]interface{}
+	s.Require().NoError(json.Unmarshal(body, &decoded))
+	for _, field := range []string{"version", "id", "type", "entity", "subject", "time", "data"} {
+		s.Contains(decoded, field)
+	}
+	s.Equal(map[string]interface{}{"id": e.Subject}, decoded["data"])
+
+	body, err = json.Marshal(events.New("guest", "deleted", e.Subject, nil))
+	s.Require().NoError(err)
+	s.NotContains(string(body), `"data"`, "data should be omitted without an entity")
+}
+
+func (s *EventsSuite) TestTopic() {
+	e := events.New("job", "failed", "1", nil)
+	s.Equal("job.failed", e.Topic(""))
+	s.Equal("lochness.job.failed", e.Topic("lochness"))
+}
+
+func (s *EventsSuite) TestNewPublisher() {
+	tests := []struct {
+		description string
+		addr        string
+	}{
+		{"unsupported scheme", "http://localhost:4222"},
+		{"no scheme", "localhost"},
+		{"invalid url", "nats://%zz"},
+	}
+
+	for _, test := range tests {
+		p, err := events.NewPublisher(test.addr, "lochness")
+		s.Error(err, test.description)
+		s.Nil(p, test.description)
+	}
+}
+
+func (s *EventsSuite) TestFromKV() {
+	id := "f2011319-ad59-42fb-9bad-92e261f0651c"
+	data := []byte(`{"id":"` + id + `"}`)
+
+	e := events.FromKV("guest", id+"/metadata", kv.Event{Type: kv.Create, Value: kv.Value{Data: data}})
+	s.Require().NotNil(e)
+	s.Equal("guest.created", e.Type)
+	s.Equal(id, e.Subject)
+	s.JSONEq(string(data), string(e.Data))
+
+	e = events.FromKV("hypervisor", id+"/metadata", kv.Event{Type: kv.Update, Value: kv.Value{Data: data}})
+	s.Require().NotNil(e)
+	s.Equal("hypervisor.updated", e.Type)
+
+	e = events.FromKV("guest", id+"/metadata", kv.Event{Type: kv.Delete, Value: kv.Value{Data: data}})
+	s.Require().NotNil(e)
+	s.Equal("guest.deleted", e.Type)
+	s.Empty(e.Data, "deleted entities have no data")
+
+	for _, rest := range []string{id, id + "/heartbeat", "/metadata", id + "/subnets/metadata"} {
+		s.Nil(events.FromKV("guest", rest, kv.Event{Type: kv.Create}), rest)
+	}
+}
@@ -0,0 +1,37 @@
+package events
+
+import (
+	"strings"
+
+	"github.com/mistifyio/lochness/pkg/kv"
+)
+
+// kvActions names the change to an entity for each kv event type
+var kvActions = map[kv.EventType]string{
+	kv.Create: "created",
+	kv.Update: "updated",
+	kv.Delete: "deleted",
+}
+
+// FromKV returns the event of a change to the metadata key of an entity, rest
+// being the key under the entity's path, "<id>/metadata". Changes to other
+// keys have no event and return nil.
+func FromKV(entity, rest string, change kv.Event) *Event {
+	if !strings.HasSuffix(rest, "/metadata") {
+		return nil
+	}
+	id := strings.TrimSuffix(rest, "/metadata")
+	if id == "" || strings.Contains(id, "/") {
+		return nil
+	}
+	action, ok := kvActions[change.Type]
+	if !ok {
+		return nil
+	}
+
+	var data []byte
+	if change.Type != kv.Delete {
+		data = change.Data
+	}
+	return New(entity, action, id, data)
+}
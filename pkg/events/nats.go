@@ -0,0 +1,40 @@
+package events
+
+import (
+	"encoding/json"
+
+	"github.com/nats-io/nats"
+)
+
+// natsPublisher publishes events to a NATS subject per event type. The client
+// reconnects by itself if the connection is lost.
+type natsPublisher struct {
+	conn   *nats.Conn
+	prefix string
+}
+
+func newNATSPublisher(addr, prefix string) (*natsPublisher, error) {
+	conn, err := nats.Connect(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &natsPublisher{conn: conn, prefix: prefix}, nil
+}
+
+// Publish sends an event and waits for the server to have processed it
+func (p *natsPublisher) Publish(e *Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := p.conn.Publish(e.Topic(p.prefix), body); err != nil {
+		return err
+	}
+	return p.conn.Flush()
+}
+
+// Close disconnects from the server
+func (p *natsPublisher) Close() error {
+	p.conn.Close()
+	return nil
+}
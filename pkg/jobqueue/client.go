@@ -1,12 +1,16 @@
 package jobqueue
 
 import (
+	"context"
 	"errors"
 	"strconv"
 	"time"
 
 	"github.com/kr/beanstalk"
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tracing"
 	"github.com/mistifyio/lochness/pkg/kv"
+	"github.com/pborman/uuid"
 )
 
 // Default parameters
@@ -24,6 +28,9 @@ type Client struct {
 	beanConn *beanstalk.Conn
 	kv       kv.KV
 	tubes    *tubes
+	// queues are the job queues work tasks are taken from, in order of
+	// priority
+	queues []string
 }
 
 // NewClient creates a new Client and initializes the beanstalk connection + tubes
@@ -41,21 +48,46 @@ func NewClient(bstalk string, kv kv.KV) (*Client, error) {
 		beanConn: conn,
 		kv:       kv,
 		tubes:    newTubes(conn),
+		queues:   []string{""},
 	}
 	return client, nil
 }
 
+// SetWorkQueues sets the job queues NextWorkTask takes tasks from, in order of
+// priority, "" or DefaultQueue being the default queue. Tasks are only taken
+// from a queue while those before it have none ready, so idle workers steal
+// tasks from the later queues. Without queues, tasks are taken from the default
+// queue.
+func (c *Client) SetWorkQueues(queues []string) {
+	if len(queues) == 0 {
+		queues = []string{""}
+	}
+	c.queues = queues
+}
+
 // AddTask creates a new task in the appropriate beanstalk queue
 func (c *Client) AddTask(j *Job) (uint64, error) {
+	return c.addTask(j, 0)
+}
+
+// AddDelayedTask creates a new task like AddTask, but one that is only handed
+// out after the same delay as a released task, so that the caller can finish
+// with the job, e.g. while still holding its lock
+func (c *Client) AddDelayedTask(j *Job) (uint64, error) {
+	return c.addTask(j, delay)
+}
+
+// addTask creates a new task ready once wait is up
+func (c *Client) addTask(j *Job, wait time.Duration) (uint64, error) {
 	if j == nil {
 		return 0, errors.New("missing job")
 	}
 
-	ts := c.tubes.work
+	ts := c.tubes.workQueue(j.Queue)
 	if j.Action == "select-hypervisor" {
 		ts = c.tubes.create
 	}
-	id, err := ts.Put(j.ID)
+	id, err := ts.Put(j.ID, wait)
 	return id, err
 }
 
@@ -70,9 +102,22 @@ func (c *Client) NextCreateTask() (*Task, error) {
 	return task, err
 }
 
-// NextWorkTask returns the next task from the work tube
+// NextWorkTask returns the next task from the work queues, taken from the
+// first of them that has one ready. If none has, it waits for a task from any
+// of them. See SetWorkQueues.
 func (c *Client) NextWorkTask() (*Task, error) {
-	task, err := c.nextTask(c.tubes.work)
+	if len(c.queues) > 1 {
+		for _, queue := range c.queues {
+			id, body, ok, err := c.tubes.workQueue(queue).TryReserve()
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				return c.loadTask(id, body)
+			}
+		}
+	}
+	task, err := c.nextTask(c.tubes.workQueues(c.queues))
 	return task, err
 }
 
@@ -82,7 +127,11 @@ func (c *Client) nextTask(ts *tubeSet) (*Task, error) {
 	if err != nil {
 		return nil, err
 	}
+	return c.loadTask(id, body)
+}
 
+// loadTask builds a reserved task and loads the Job and Guest
+func (c *Client) loadTask(id uint64, body string) (*Task, error) {
 	// Build the Task object
 	task := &Task{
 		ID:     id,
@@ -98,14 +147,79 @@ func (c *Client) nextTask(ts *tubeSet) (*Task, error) {
 		return task, err
 	}
 
-	return task, err
+	return task, nil
+}
+
+// guestQueue returns the job queue of the hypervisor of a guest, "" for the
+// default queue if the guest has none or does not exist
+func (c *Client) guestQueue(guestID string) (string, error) {
+	ctx := lochness.NewContext(c.kv)
+	guest, err := ctx.Guest(guestID)
+	if err != nil {
+		if ctx.IsKeyNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	if guest.HypervisorID == "" {
+		return "", nil
+	}
+	hv, err := ctx.Hypervisor(guest.HypervisorID)
+	if err != nil {
+		if ctx.IsKeyNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return hv.JobQueue()
 }
 
-// AddJob creates a new job for a guest and adds a task for it
+// AddJob creates a new job for a guest and adds a task for it, in the job
+// queue of the guest's hypervisor
 func (c *Client) AddJob(guestID, action string) (*Job, error) {
+	return c.AddJobAfter(guestID, action, nil)
+}
+
+// AddJobAfter creates a new job for a guest that depends on other jobs, and
+// adds a task for it. Workers only start the job once its dependencies have
+// succeeded, and fail it if one of them fails. See DependenciesDone.
+func (c *Client) AddJobAfter(guestID, action string, dependsOn []string) (*Job, error) {
+	return c.AddJobContext(context.Background(), guestID, action, dependsOn)
+}
+
+// AddJobContext is AddJobAfter recording the trace ctx carries in the job, see
+// tracing.WithID, so that the work on it is traced along with the request
+// that queued it. Jobs queued without a trace are traced by their own ID.
+func (c *Client) AddJobContext(ctx context.Context, guestID, action string, dependsOn []string) (*Job, error) {
+	return c.AddJobWithID(ctx, uuid.New(), guestID, action, dependsOn)
+}
+
+// AddJobWithID is AddJobContext with the ID of the job chosen by the caller,
+// e.g. so that it can be recorded before the job is queued. The ID must be a
+// new uuid.
+func (c *Client) AddJobWithID(ctx context.Context, id, guestID, action string, dependsOn []string) (*Job, error) {
+	queue, err := c.guestQueue(guestID)
+	if err != nil {
+		return nil, err
+	}
+
 	job := c.NewJob()
+	job.ID = id
 	job.Guest = guestID
 	job.Action = action
+	job.Queue = queue
+	job.DependsOn = dependsOn
+	job.TraceID = tracing.ID(ctx)
+	if job.TraceID == "" {
+		job.TraceID = job.ID
+	}
+	job.TraceParent = tracing.Parent(ctx)
+	if err := job.Validate(); err != nil {
+		return nil, err
+	}
+	if err := c.addDependents(job); err != nil {
+		return nil, err
+	}
 	if err := job.Save(jobTTL); err != nil {
 		return nil, err
 	}
@@ -116,7 +230,7 @@ func (c *Client) AddJob(guestID, action string) (*Job, error) {
 		return nil, err
 	}
 
-	_, err := c.AddTask(job)
+	_, err = c.AddTask(job)
 	return job, err
 }
 
@@ -138,7 +252,13 @@ func (c *Client) StatsCreate() (map[string]string, error) {
 	return tubeStats(c.tubes.create)
 }
 
-// StatsWork returns the stats for the work queue
+// StatsWork returns the stats for the default work queue
 func (c *Client) StatsWork() (map[string]string, error) {
-	return tubeStats(c.tubes.work)
+	return tubeStats(c.tubes.workQueue(""))
+}
+
+// StatsQueue returns the stats for a job queue, "" or DefaultQueue being the
+// default queue
+func (c *Client) StatsQueue(queue string) (map[string]string, error) {
+	return tubeStats(c.tubes.workQueue(queue))
 }
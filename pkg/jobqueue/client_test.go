@@ -1,10 +1,12 @@
 package jobqueue_test
 
 import (
+	"context"
 	"strconv"
 	"testing"
 
 	"github.com/kr/beanstalk"
+	"github.com/mistifyio/lochness/internal/tracing"
 	"github.com/mistifyio/lochness/pkg/jobqueue"
 	"github.com/mistifyio/lochness/pkg/kv"
 	_ "github.com/mistifyio/lochness/pkg/kv/consul"
@@ -90,6 +92,24 @@ func (s *ClientSuite) TestNextWorkTask() {
 	s.Equal(job.ID, task.JobID)
 }
 
+func (s *ClientSuite) TestNextWorkTaskQueues() {
+	stolen := s.newJob("restart")
+	stolen.Queue = "zone-b"
+	stolenID, _ := s.Client.AddTask(stolen)
+	own := s.newJob("restart")
+	own.Queue = "zone-a"
+	ownID, _ := s.Client.AddTask(own)
+
+	s.Client.SetWorkQueues([]string{"zone-a", "zone-b"})
+	task, err := s.Client.NextWorkTask()
+	s.NoError(err)
+	s.Equal(ownID, task.ID, "tasks of the first queue should be taken first")
+	task, err = s.Client.NextWorkTask()
+	s.NoError(err)
+	s.Equal(stolenID, task.ID, "tasks of later queues should be stolen")
+	s.Equal("zone-b", task.Job.Queue)
+}
+
 func (s *ClientSuite) TestNextCreateTask() {
 	job := s.newJob("select-hypervisor")
 	taskID, _ := s.Client.AddTask(job)
@@ -125,6 +145,37 @@ func (s *ClientSuite) TestAddJob() {
 	}
 }
 
+func (s *ClientSuite) TestAddJobContext() {
+	ctx := tracing.WithID(context.Background(), "request-1234")
+	job, err := s.Client.AddJobContext(ctx, uuid.New(), "restart", nil)
+	s.Require().NoError(err)
+	s.Equal("request-1234", job.TraceID)
+
+	saved, err := s.Client.ReadJob(job.ID)
+	s.Require().NoError(err)
+	s.Equal("request-1234", saved.TraceID)
+	s.Equal("request-1234", tracing.ID(saved.Context(context.Background())))
+
+	job, err = s.Client.AddJob(uuid.New(), "restart")
+	s.Require().NoError(err)
+	s.Equal(job.ID, job.TraceID, "jobs queued without a trace should be traced by their id")
+}
+
+func (s *ClientSuite) TestAddJobWithID() {
+	id := uuid.New()
+	job, err := s.Client.AddJobWithID(context.Background(), id, uuid.New(), "restart", nil)
+	s.Require().NoError(err)
+	s.Equal(id, job.ID)
+
+	saved, err := s.Client.ReadJob(id)
+	s.Require().NoError(err)
+	s.Equal(job.Guest, saved.Guest)
+
+	job, err = s.Client.AddJobWithID(context.Background(), "", uuid.New(), "restart", nil)
+	s.Error(err, "a job without an id should fail")
+	s.Nil(job)
+}
+
 func (s *ClientSuite) TestStats() {
 	stats, err := s.Client.StatsCreate()
 	if connErr, ok := err.(beanstalk.ConnError); ok {
@@ -0,0 +1,197 @@
+package jobqueue
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/mistifyio/lochness/pkg/kv"
+)
+
+var (
+	// JobDependentsPath is the path in the config store of the jobs that
+	// depend on each job
+	JobDependentsPath = "lochness/job-dependents/"
+)
+
+// DependencyError is the error of a job one of whose dependencies failed
+type DependencyError struct {
+	Dependency string
+	Reason     string
+}
+
+func (e *DependencyError) Error() string {
+	return fmt.Sprintf("dependency %s failed: %s", e.Dependency, e.Reason)
+}
+
+// JobGraph is the graph of the jobs a job is connected to by dependencies, be
+// it those it depends on or those that depend on it, transitively
+type JobGraph struct {
+	JobID string `json:"job"`
+	// Jobs are sorted so that each comes after the jobs it depends on
+	Jobs []*Job `json:"jobs"`
+}
+
+// dependentKey is the key recording that a job depends on another
+func dependentKey(dependency, jobID string) string {
+	return filepath.Join(JobDependentsPath, dependency, jobID)
+}
+
+// addDependents records the job as a dependent of each of its dependencies,
+// which must exist. Since dependencies must exist before their dependents, the
+// jobs and their dependencies form a directed acyclic graph.
+func (c *Client) addDependents(j *Job) error {
+	for _, id := range j.DependsOn {
+		if _, err := c.ReadJob(id); err != nil {
+			if c.kv.IsKeyNotFound(err) {
+				return fmt.Errorf("dependency %s does not exist", id)
+			}
+			return err
+		}
+	}
+	for _, id := range j.DependsOn {
+		if err := c.kv.Set(dependentKey(id, j.ID), j.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveDependents removes the keys recording the dependencies of a job that
+// has been removed: those listing it as a dependent of its dependencies, and
+// those listing its own dependents. Keys already gone are ignored.
+func RemoveDependents(k kv.KV, j *Job) error {
+	for _, id := range j.DependsOn {
+		if err := k.Delete(dependentKey(id, j.ID), false); err != nil && !k.IsKeyNotFound(err) {
+			return err
+		}
+	}
+	if err := k.Delete(filepath.Join(JobDependentsPath, j.ID), true); err != nil && !k.IsKeyNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// Dependents returns the IDs of the jobs that depend on a job, sorted
+func (c *Client) Dependents(jobID string) ([]string, error) {
+	keys, err := c.kv.Keys(filepath.Join(JobDependentsPath, jobID))
+	if err != nil {
+		if c.kv.IsKeyNotFound(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+
+	ids := make([]string, len(keys))
+	for i, key := range keys {
+		ids[i] = filepath.Base(key)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// DependenciesDone returns whether all the jobs a job depends on have
+// succeeded. If one of them failed or no longer exists, a *DependencyError is
+// returned, and the job should be failed as well.
+func (c *Client) DependenciesDone(j *Job) (bool, error) {
+	done := true
+	for _, id := range j.DependsOn {
+		dep, err := c.ReadJob(id)
+		if err != nil {
+			if c.kv.IsKeyNotFound(err) {
+				return false, &DependencyError{Dependency: id, Reason: "job does not exist"}
+			}
+			return false, err
+		}
+		switch dep.Status {
+		case JobStatusDone:
+		case JobStatusError:
+			return false, &DependencyError{Dependency: id, Reason: dep.Error}
+		default:
+			done = false
+		}
+	}
+	return done, nil
+}
+
+// JobGraph returns the graph of the jobs connected to a job by dependencies.
+// Jobs that no longer exist are left out.
+func (c *Client) JobGraph(jobID string) (*JobGraph, error) {
+	root, err := c.ReadJob(jobID)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := map[string]*Job{root.ID: root}
+	next := []*Job{root}
+	for len(next) > 0 {
+		j := next[0]
+		next = next[1:]
+
+		dependents, err := c.Dependents(j.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range append(dependents, j.DependsOn...) {
+			if _, ok := jobs[id]; ok {
+				continue
+			}
+			job, err := c.ReadJob(id)
+			if err != nil {
+				if c.kv.IsKeyNotFound(err) {
+					continue
+				}
+				return nil, err
+			}
+			jobs[id] = job
+			next = append(next, job)
+		}
+	}
+
+	return &JobGraph{JobID: root.ID, Jobs: sortJobs(jobs)}, nil
+}
+
+// sortJobs sorts jobs so that each comes after the jobs it depends on, and
+// otherwise by ID
+func sortJobs(jobs map[string]*Job) []*Job {
+	ids := make([]string, 0, len(jobs))
+	for id := range jobs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	sorted := make([]*Job, 0, len(jobs))
+	added := make(map[string]bool, len(jobs))
+	for len(sorted) < len(jobs) {
+		progress := false
+		for _, id := range ids {
+			if added[id] || !dependenciesAdded(jobs, added, jobs[id]) {
+				continue
+			}
+			added[id] = true
+			sorted = append(sorted, jobs[id])
+			progress = true
+		}
+		// jobs edited by hand into a cycle are appended as they are
+		if !progress {
+			for _, id := range ids {
+				if !added[id] {
+					sorted = append(sorted, jobs[id])
+				}
+			}
+			break
+		}
+	}
+	return sorted
+}
+
+// dependenciesAdded returns whether the dependencies of a job that are in the
+// graph were added
+func dependenciesAdded(jobs map[string]*Job, added map[string]bool, j *Job) bool {
+	for _, id := range j.DependsOn {
+		if _, ok := jobs[id]; ok && !added[id] {
+			return false
+		}
+	}
+	return true
+}
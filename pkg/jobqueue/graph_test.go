@@ -0,0 +1,126 @@
+package jobqueue_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mistifyio/lochness/pkg/jobqueue"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestJobGraph(t *testing.T) {
+	suite.Run(t, new(GraphSuite))
+}
+
+type GraphSuite struct {
+	JobQCommonSuite
+}
+
+// finish saves a job with a final status
+func (s *GraphSuite) finish(j *jobqueue.Job, status string) {
+	s.Require().NoError(j.Refresh())
+	j.Status = status
+	if status == jobqueue.JobStatusError {
+		j.Error = "failed"
+	}
+	s.Require().NoError(j.Save(60 * time.Second))
+	s.Require().NoError(j.Release())
+}
+
+func (s *GraphSuite) TestAddJobAfter() {
+	first := s.newJob("restart")
+
+	tests := []struct {
+		description string
+		dependsOn   []string
+		expectedErr bool
+	}{
+		{"invalid dependency", []string{"foo"}, true},
+		{"missing dependency", []string{uuid.New()}, true},
+		{"existing dependency", []string{first.ID}, false},
+	}
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		job, err := s.Client.AddJobAfter(first.Guest, "restart", test.dependsOn)
+		if test.expectedErr {
+			s.Error(err, msg("should fail"))
+			s.Nil(job, msg("should not return job"))
+		} else {
+			s.NoError(err, msg("should succeed"))
+			s.Equal(test.dependsOn, job.DependsOn, msg("should depend on the jobs"))
+		}
+	}
+
+	dependents, err := s.Client.Dependents(first.ID)
+	s.NoError(err)
+	s.Len(dependents, 1)
+}
+
+func (s *GraphSuite) TestDependenciesDone() {
+	first := s.newJob("restart")
+	second := s.newJob("restart")
+	job, err := s.Client.AddJobAfter(first.Guest, "restart", []string{first.ID, second.ID})
+	s.Require().NoError(err)
+
+	done, err := s.Client.DependenciesDone(job)
+	s.NoError(err)
+	s.False(done, "new dependencies should be waited on")
+
+	s.finish(first, jobqueue.JobStatusDone)
+	done, err = s.Client.DependenciesDone(job)
+	s.NoError(err)
+	s.False(done, "every dependency should be waited on")
+
+	s.finish(second, jobqueue.JobStatusError)
+	done, err = s.Client.DependenciesDone(job)
+	s.False(done)
+	depErr, ok := err.(*jobqueue.DependencyError)
+	s.Require().True(ok, "a failed dependency should fail the job")
+	s.Equal(second.ID, depErr.Dependency)
+}
+
+func (s *GraphSuite) TestJobGraph() {
+	first := s.newJob("restart")
+	second, err := s.Client.AddJobAfter(first.Guest, "restart", []string{first.ID})
+	s.Require().NoError(err)
+	third, err := s.Client.AddJobAfter(first.Guest, "restart", []string{second.ID, first.ID})
+	s.Require().NoError(err)
+	unrelated := s.newJob("restart")
+
+	for _, id := range []string{first.ID, second.ID, third.ID} {
+		graph, err := s.Client.JobGraph(id)
+		s.Require().NoError(err)
+		s.Equal(id, graph.JobID)
+		ids := make([]string, len(graph.Jobs))
+		for i, j := range graph.Jobs {
+			ids[i] = j.ID
+		}
+		s.Equal([]string{first.ID, second.ID, third.ID}, ids, "jobs should come after their dependencies")
+	}
+
+	graph, err := s.Client.JobGraph(unrelated.ID)
+	s.Require().NoError(err)
+	s.Len(graph.Jobs, 1)
+
+	_, err = s.Client.JobGraph(uuid.New())
+	s.Error(err)
+}
+
+func (s *GraphSuite) TestRemoveDependents() {
+	first := s.newJob("restart")
+	second, err := s.Client.AddJobAfter(first.Guest, "restart", []string{first.ID})
+	s.Require().NoError(err)
+	third, err := s.Client.AddJobAfter(first.Guest, "restart", []string{second.ID, first.ID})
+	s.Require().NoError(err)
+
+	s.NoError(jobqueue.RemoveDependents(s.KV, second))
+	dependents, err := s.Client.Dependents(first.ID)
+	s.NoError(err)
+	s.Equal([]string{third.ID}, dependents, "the job should no longer be a dependent")
+	dependents, err = s.Client.Dependents(second.ID)
+	s.NoError(err)
+	s.Empty(dependents, "the dependents of the job should be removed")
+
+	s.NoError(jobqueue.RemoveDependents(s.KV, second), "removing again should succeed")
+}
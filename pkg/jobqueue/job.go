@@ -1,11 +1,14 @@
 package jobqueue
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"path/filepath"
 	"time"
 
+	"github.com/mistifyio/lochness/internal/tracing"
 	"github.com/mistifyio/lochness/pkg/kv"
 	"github.com/pborman/uuid"
 )
@@ -26,16 +29,20 @@ const (
 type (
 	// Job is a single job for a guest such as create, delete, etc.
 	Job struct {
-		ID         string    `json:"id"`
-		RemoteID   string    `json:"remote"` // ID of remote hypervisor/guest job
-		Action     string    `json:"action"`
-		Guest      string    `json:"guest"`
-		Error      string    `json:"error,omitempty"`
-		Status     string    `json:"status,omitempty"`
-		StartedAt  time.Time `json:"started_at,omitempty"`
-		FinishedAt time.Time `json:"finished_at,omitempty"`
-		client     *Client
-		lock       kv.Lock
+		ID          string    `json:"id"`
+		RemoteID    string    `json:"remote"` // ID of remote hypervisor/guest job
+		Action      string    `json:"action"`
+		Guest       string    `json:"guest"`
+		Queue       string    `json:"queue,omitempty"`       // job queue of the work task, "" for the default
+		DependsOn   []string  `json:"depends_on,omitempty"`  // IDs of jobs that must succeed first
+		TraceID     string    `json:"trace_id,omitempty"`    // ID of the trace of the request that queued the job
+		TraceParent string    `json:"traceparent,omitempty"` // W3C trace context of the span that queued the job
+		Error       string    `json:"error,omitempty"`
+		Status      string    `json:"status,omitempty"`
+		StartedAt   time.Time `json:"started_at,omitempty"`
+		FinishedAt  time.Time `json:"finished_at,omitempty"`
+		client      *Client
+		lock        kv.Lock
 	}
 )
 
@@ -68,9 +75,24 @@ func (j *Job) Validate() error {
 		return errors.New("Status is required")
 	}
 
+	for _, id := range j.DependsOn {
+		if uuid.Parse(id) == nil {
+			return fmt.Errorf("invalid dependency %q", id)
+		}
+		if id == j.ID {
+			return errors.New("Job may not depend on itself")
+		}
+	}
+
 	return nil
 }
 
+// Context returns a copy of parent carrying the trace of the job, for the
+// work on it to be traced along with the request that queued it
+func (j *Job) Context(parent context.Context) context.Context {
+	return tracing.Continue(parent, j.TraceID, j.TraceParent)
+}
+
 // key is a helper to generate the config store key.
 func (j *Job) key() string {
 	return filepath.Join(JobPath, j.ID)
@@ -135,6 +157,21 @@ func (j *Job) Refresh() error {
 	return json.Unmarshal(v.Data, &j)
 }
 
+// ReadJob retrieves a single job from the data store without locking it, to
+// inspect jobs another component may be working on. The job may not be saved.
+func (c *Client) ReadJob(id string) (*Job, error) {
+	v, err := c.kv.Get(filepath.Join(JobPath, id))
+	if err != nil {
+		return nil, err
+	}
+
+	j := &Job{}
+	if err := json.Unmarshal(v.Data, j); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
 // Job retrieves a single job from the data store.
 func (c *Client) Job(id string) (*Job, error) {
 	j := &Job{
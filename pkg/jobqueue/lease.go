@@ -0,0 +1,135 @@
+package jobqueue
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"time"
+
+	"github.com/kr/beanstalk"
+)
+
+var (
+	// LeasePath is the path in the config store for job leases
+	LeasePath = "lochness/leases/"
+)
+
+// Lease records the worker that last claimed a job and when that claim runs
+// out. A job whose lease has expired and whose task is no longer in beanstalk
+// has been abandoned.
+type Lease struct {
+	JobID    string    `json:"job"`
+	TaskID   uint64    `json:"task"`
+	Worker   string    `json:"worker"`
+	Claimed  time.Time `json:"claimed"`
+	Expires  time.Time `json:"expires"`
+	Reclaims int       `json:"reclaims,omitempty"`
+}
+
+// leaseKey is a helper to generate the config store key of a job's lease
+func leaseKey(jobID string) string {
+	return filepath.Join(LeasePath, jobID)
+}
+
+// Expired returns whether the lease has run out
+func (l *Lease) Expired() bool {
+	return time.Now().After(l.Expires)
+}
+
+// Lease claims the task's job for a worker until ttl elapses. Workers renew
+// the lease each time they reserve the task.
+func (t *Task) Lease(worker string, ttl time.Duration) error {
+	if t.Job == nil {
+		return errors.New("trying to lease a nil job")
+	}
+
+	lease, err := t.client.Lease(t.Job.ID)
+	if err != nil {
+		return err
+	}
+	if lease == nil {
+		lease = &Lease{JobID: t.Job.ID}
+	}
+	lease.TaskID = t.ID
+	lease.Worker = worker
+	lease.Claimed = time.Now()
+	lease.Expires = lease.Claimed.Add(ttl)
+	return t.client.SaveLease(lease)
+}
+
+// Lease retrieves the lease of a job. A nil lease is returned if the job has
+// none.
+func (c *Client) Lease(jobID string) (*Lease, error) {
+	value, err := c.kv.Get(leaseKey(jobID))
+	if err != nil {
+		if c.kv.IsKeyNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	lease := &Lease{}
+	if err := json.Unmarshal(value.Data, lease); err != nil {
+		return nil, err
+	}
+	return lease, nil
+}
+
+// Leases retrieves all job leases
+func (c *Client) Leases() ([]*Lease, error) {
+	keys, err := c.kv.Keys(LeasePath)
+	if err != nil {
+		if c.kv.IsKeyNotFound(err) {
+			return []*Lease{}, nil
+		}
+		return nil, err
+	}
+
+	leases := make([]*Lease, 0, len(keys))
+	for _, key := range keys {
+		lease, err := c.Lease(filepath.Base(key))
+		if err != nil {
+			return nil, err
+		}
+		// deleted since listing
+		if lease == nil {
+			continue
+		}
+		leases = append(leases, lease)
+	}
+	return leases, nil
+}
+
+// SaveLease persists a lease
+func (c *Client) SaveLease(lease *Lease) error {
+	if lease.JobID == "" {
+		return errors.New("JobID is required")
+	}
+
+	v, err := json.Marshal(lease)
+	if err != nil {
+		return err
+	}
+	return c.kv.Set(leaseKey(lease.JobID), string(v))
+}
+
+// DeleteLease removes the lease of a job
+func (c *Client) DeleteLease(jobID string) error {
+	err := c.kv.Delete(leaseKey(jobID), false)
+	if err != nil && c.kv.IsKeyNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// TaskExists returns whether a task is still in beanstalk
+func (c *Client) TaskExists(id uint64) (bool, error) {
+	_, err := c.beanConn.StatsJob(id)
+	if err == nil {
+		return true, nil
+	}
+	if cErr, ok := err.(beanstalk.ConnError); ok && cErr.Err == beanstalk.ErrNotFound {
+		return false, nil
+	}
+	return false, err
+}
@@ -0,0 +1,90 @@
+package jobqueue_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mistifyio/lochness/pkg/jobqueue"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestLease(t *testing.T) {
+	suite.Run(t, new(LeaseSuite))
+}
+
+type LeaseSuite struct {
+	JobQCommonSuite
+}
+
+func (s *LeaseSuite) TestTaskLease() {
+	job := s.newJob("")
+	_, _ = s.Client.AddTask(job)
+	task, err := s.Client.NextWorkTask()
+	s.Require().NoError(err)
+
+	lease, err := s.Client.Lease(job.ID)
+	s.NoError(err)
+	s.Nil(lease, "unclaimed job should have no lease")
+
+	s.NoError(task.Lease("worker1", time.Minute))
+	lease, err = s.Client.Lease(job.ID)
+	s.Require().NoError(err)
+	s.Equal(job.ID, lease.JobID)
+	s.Equal(task.ID, lease.TaskID)
+	s.Equal("worker1", lease.Worker)
+	s.False(lease.Expired())
+
+	// reclaims carry over renewals
+	lease.Reclaims = 2
+	s.Require().NoError(s.Client.SaveLease(lease))
+	s.NoError(task.Lease("worker2", -time.Second))
+	lease, err = s.Client.Lease(job.ID)
+	s.Require().NoError(err)
+	s.Equal("worker2", lease.Worker)
+	s.Equal(2, lease.Reclaims)
+	s.True(lease.Expired())
+
+	s.NoError(task.Delete())
+}
+
+func (s *LeaseSuite) TestLeases() {
+	leases, err := s.Client.Leases()
+	s.NoError(err)
+	s.Len(leases, 0)
+
+	job1 := s.newJob("")
+	job2 := s.newJob("")
+	for _, job := range []*jobqueue.Job{job1, job2} {
+		s.Require().NoError(s.Client.SaveLease(&jobqueue.Lease{
+			JobID:   job.ID,
+			Worker:  "worker",
+			Expires: time.Now().Add(time.Minute),
+		}))
+	}
+
+	leases, err = s.Client.Leases()
+	s.NoError(err)
+	s.Len(leases, 2)
+
+	s.NoError(s.Client.DeleteLease(job1.ID))
+	s.NoError(s.Client.DeleteLease(job1.ID), "deleting a missing lease should not error")
+	leases, err = s.Client.Leases()
+	s.NoError(err)
+	s.Len(leases, 1)
+	s.Equal(job2.ID, leases[0].JobID)
+}
+
+func (s *LeaseSuite) TestTaskExists() {
+	job := s.newJob("")
+	id, err := s.Client.AddTask(job)
+	s.Require().NoError(err)
+
+	exists, err := s.Client.TaskExists(id)
+	s.NoError(err)
+	s.True(exists)
+
+	s.Require().NoError(s.Client.DeleteTask(id))
+	exists, err = s.Client.TaskExists(id)
+	s.NoError(err)
+	s.False(exists)
+}
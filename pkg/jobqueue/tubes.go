@@ -22,13 +22,27 @@ type (
 		consume *beanstalk.TubeSet
 	}
 
-	// tubes holds the create and work tubeSets
+	// tubes holds the create tubeSet and the work tubeSets, by job queue
 	tubes struct {
+		conn   *beanstalk.Conn
 		create *tubeSet
-		work   *tubeSet
+		work   map[string]*tubeSet
 	}
 )
 
+// DefaultQueue is the name of the default job queue, that of the work tube.
+// Jobs are queued in it unless they are given another.
+const DefaultQueue = "default"
+
+// workTubeName returns the name of the beanstalk tube of a job queue, the
+// default queue, "" or DefaultQueue, being the work tube
+func workTubeName(queue string) string {
+	if queue == "" || queue == DefaultQueue {
+		return workTube
+	}
+	return workTube + "-" + queue
+}
+
 // newTubeSet creates a new tubeSet for a tube name
 func newTubeSet(conn *beanstalk.Conn, name string) *tubeSet {
 	return &tubeSet{
@@ -40,11 +54,11 @@ func newTubeSet(conn *beanstalk.Conn, name string) *tubeSet {
 	}
 }
 
-// Put puts a job into the publish tube.
+// Put puts a job into the publish tube, ready once delay is up.
 // See http://godoc.org/github.com/kr/beanstalk#Tube.Put
-func (ts *tubeSet) Put(jobID string) (uint64, error) {
+func (ts *tubeSet) Put(jobID string, delay time.Duration) (uint64, error) {
 	body := []byte(jobID)
-	id, err := ts.publish.Put(body, priority, 0, ttr)
+	id, err := ts.publish.Put(body, priority, delay, ttr)
 	return id, err
 }
 
@@ -68,10 +82,43 @@ func (ts *tubeSet) Reserve() (uint64, string, error) {
 	}
 }
 
+// TryReserve reserves an item from the consume tubeset if one is ready,
+// returning false if none is
+func (ts *tubeSet) TryReserve() (uint64, string, bool, error) {
+	id, body, err := ts.consume.Reserve(0)
+	if err != nil {
+		if connErr, ok := err.(beanstalk.ConnError); ok && (connErr.Err == beanstalk.ErrTimeout || connErr.Err == beanstalk.ErrDeadline) {
+			return 0, "", false, nil
+		}
+		return 0, "", false, err
+	}
+	return id, string(body), true, nil
+}
+
 // newTubes creates a new tubes
 func newTubes(conn *beanstalk.Conn) *tubes {
 	return &tubes{
+		conn:   conn,
 		create: newTubeSet(conn, createTube),
-		work:   newTubeSet(conn, workTube),
+		work:   map[string]*tubeSet{"": newTubeSet(conn, workTube)},
+	}
+}
+
+// workQueue returns the work tubeSet of a job queue
+func (t *tubes) workQueue(queue string) *tubeSet {
+	ts, ok := t.work[queue]
+	if !ok {
+		ts = newTubeSet(t.conn, workTubeName(queue))
+		t.work[queue] = ts
+	}
+	return ts
+}
+
+// workQueues returns a tubeSet consuming any of the job queues
+func (t *tubes) workQueues(queues []string) *tubeSet {
+	names := make([]string, len(queues))
+	for i, queue := range queues {
+		names[i] = workTubeName(queue)
 	}
+	return &tubeSet{consume: beanstalk.NewTubeSet(t.conn, names...)}
 }
@@ -0,0 +1,376 @@
+// Package jsonschema generates JSON Schemas (draft-07) from the json encoding of
+// structs, and validates decoded json against them. Beyond what the types say,
+// fields may be annotated with a schema struct tag of comma separated options:
+//
+//	required      the field must be set
+//	readonly      the field is set by the server, and ignored if sent
+//	uuid          the field is a uuid, or for maps and slices, their values are
+//	enum=a|b      the field is one of the values given
+//
+// e.g. `json:"flavor" schema:"required,uuid"`. Generated schemas are
+// self-contained: struct types are inlined rather than referenced, and objects
+// of structs may not have fields their struct does not.
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pborman/uuid"
+)
+
+// Draft is the JSON Schema version of generated schemas
+const Draft = "http://json-schema.org/draft-07/schema#"
+
+var (
+	timeType  = reflect.TypeOf(time.Time{})
+	ipType    = reflect.TypeOf(net.IP{})
+	ipNetType = reflect.TypeOf(net.IPNet{})
+	macType   = reflect.TypeOf(net.HardwareAddr{})
+	bytesType = reflect.TypeOf([]byte{})
+)
+
+// Schema is a JSON Schema, of the subset of keywords generated
+type Schema struct {
+	Schema     string             `json:"$schema,omitempty"`
+	Title      string             `json:"title,omitempty"`
+	Type       string             `json:"type,omitempty"`
+	Format     string             `json:"format,omitempty"`
+	Enum       []string           `json:"enum,omitempty"`
+	Minimum    *float64           `json:"minimum,omitempty"`
+	ReadOnly   bool               `json:"readOnly,omitempty"`
+	Items      *Schema            `json:"items,omitempty"`
+	Properties map[string]*Schema `json:"properties,omitempty"`
+	Required   []string           `json:"required,omitempty"`
+	// AdditionalProperties is the schema of the values of objects without
+	// Properties, e.g. of maps
+	AdditionalProperties *Schema `json:"-"`
+	// Closed objects may only have their Properties, i.e.
+	// "additionalProperties": false
+	Closed bool `json:"-"`
+}
+
+// schemaJSON is the json encoding of a Schema, whose additionalProperties is
+// either a schema or false
+type schemaJSON Schema
+
+// MarshalJSON encodes a Schema
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	var additional interface{}
+	switch {
+	case s.Closed:
+		additional = false
+	case s.AdditionalProperties != nil:
+		additional = s.AdditionalProperties
+	}
+	return json.Marshal(&struct {
+		*schemaJSON
+		AdditionalProperties interface{} `json:"additionalProperties,omitempty"`
+	}{(*schemaJSON)(s), additional})
+}
+
+// UnmarshalJSON decodes a Schema
+func (s *Schema) UnmarshalJSON(data []byte) error {
+	aux := struct {
+		*schemaJSON
+		AdditionalProperties json.RawMessage `json:"additionalProperties"`
+	}{schemaJSON: (*schemaJSON)(s)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	switch string(aux.AdditionalProperties) {
+	case "", "true", "null":
+	case "false":
+		s.Closed = true
+	default:
+		s.AdditionalProperties = &Schema{}
+		return json.Unmarshal(aux.AdditionalProperties, s.AdditionalProperties)
+	}
+	return nil
+}
+
+// Generate returns the schema of the json encoding of the type of v, titled
+func Generate(title string, v interface{}) *Schema {
+	g := &generator{seen: map[reflect.Type]bool{}}
+	schema := g.schemaOf(reflect.TypeOf(v))
+	schema.Schema = Draft
+	schema.Title = title
+	return schema
+}
+
+// generator generates schemas, keeping track of the struct types being
+// generated so that recursive types end
+type generator struct {
+	seen map[reflect.Type]bool
+}
+
+func (g *generator) schemaOf(t reflect.Type) *Schema {
+	// types with their own json encodings
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case ipType:
+		return &Schema{Type: "string", Format: "ip"}
+	case ipNetType, reflect.PtrTo(ipNetType):
+		return &Schema{Type: "string", Format: "cidr"}
+	case macType:
+		return &Schema{Type: "string", Format: "mac"}
+	case bytesType:
+		return &Schema{Type: "string", Format: "byte"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return g.schemaOf(t.Elem())
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Schema{Type: "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		zero := 0.0
+		return &Schema{Type: "integer", Minimum: &zero}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: g.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if g.seen[t] {
+			return &Schema{Type: "object"}
+		}
+		g.seen[t] = true
+		defer delete(g.seen, t)
+		schema := &Schema{Type: "object", Properties: map[string]*Schema{}, Closed: true}
+		g.addFields(schema, t)
+		sort.Strings(schema.Required)
+		return schema
+	}
+	// interfaces and anything else may hold any value
+	return &Schema{}
+}
+
+// addFields adds the json encoded fields of a struct to an object schema,
+// including those of embedded structs
+func (g *generator) addFields(schema *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if idx := strings.Index(tag, ","); idx != -1 {
+			name, opts = tag[:idx], tag[idx+1:]
+		}
+
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.addFields(schema, ft)
+				continue
+			}
+		}
+		if field.PkgPath != "" {
+			// unexported
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		var fs *Schema
+		if strings.Contains(opts, "string") {
+			fs = &Schema{Type: "string"}
+		} else {
+			fs = g.schemaOf(field.Type)
+		}
+		if annotate(fs, field.Tag.Get("schema")) {
+			schema.Required = append(schema.Required, name)
+		}
+		schema.Properties[name] = fs
+	}
+}
+
+// annotate applies the options of a schema tag to the schema of a field,
+// returning whether the field is required
+func annotate(schema *Schema, tag string) bool {
+	// formats and values apply to the values of maps and slices
+	values := schema
+	for values.Items != nil || values.AdditionalProperties != nil {
+		if values.Items != nil {
+			values = values.Items
+		} else {
+			values = values.AdditionalProperties
+		}
+	}
+
+	required := false
+	for _, opt := range strings.Split(tag, ",") {
+		switch {
+		case opt == "required":
+			required = true
+		case opt == "readonly":
+			schema.ReadOnly = true
+		case opt == "uuid":
+			values.Format = "uuid"
+		case strings.HasPrefix(opt, "enum="):
+			values.Enum = strings.Split(strings.TrimPrefix(opt, "enum="), "|")
+		}
+	}
+	return required
+}
+
+// Validate checks a decoded json value, as decoded into an interface{}, against
+// the schema, returning what is wrong with it, each prefixed by the path of
+// the value, in order, or nothing if it is valid. As with the servers, null
+// values and empty strings are taken as unset.
+func (s *Schema) Validate(v interface{}) []string {
+	problems := s.validate("", v)
+	sort.Strings(problems)
+	return problems
+}
+
+// validate checks a value at a path
+func (s *Schema) validate(path string, v interface{}) []string {
+	prefix := ""
+	if path != "" {
+		prefix = path + ": "
+	}
+
+	switch s.Type {
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return []string{prefix + "must be an object"}
+		}
+		return s.validateObject(path, obj)
+	case "array":
+		items, ok := v.([]interface{})
+		if !ok {
+			return []string{prefix + "must be an array"}
+		}
+		var problems []string
+		if s.Items != nil {
+			for i, item := range items {
+				if item != nil {
+					problems = append(problems, s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item)...)
+				}
+			}
+		}
+		return problems
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return []string{prefix + "must be a boolean"}
+		}
+	case "number", "integer":
+		n, ok := v.(float64)
+		if !ok {
+			return []string{prefix + "must be a number"}
+		}
+		if s.Type == "integer" && n != math.Trunc(n) {
+			return []string{prefix + "must be an integer"}
+		}
+		if s.Minimum != nil && n < *s.Minimum {
+			return []string{prefix + fmt.Sprintf("must be at least %v", *s.Minimum)}
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return []string{prefix + "must be a string"}
+		}
+		if str == "" {
+			return nil
+		}
+		if problem := checkFormat(s.Format, str); problem != "" {
+			return []string{prefix + problem}
+		}
+		if len(s.Enum) > 0 && !contains(s.Enum, str) {
+			return []string{prefix + "must be one of " + strings.Join(s.Enum, ", ")}
+		}
+	}
+	return nil
+}
+
+// validateObject checks the properties of an object at a path
+func (s *Schema) validateObject(path string, obj map[string]interface{}) []string {
+	join := func(key string) string {
+		if path == "" {
+			return key
+		}
+		return path + "." + key
+	}
+
+	var problems []string
+	for _, name := range s.Required {
+		if value, ok := obj[name]; !ok || value == nil || value == "" {
+			problems = append(problems, join(name)+": missing")
+		}
+	}
+	for key, value := range obj {
+		if value == nil {
+			continue
+		}
+		ps, ok := s.Properties[key]
+		switch {
+		case ok:
+		case s.AdditionalProperties != nil:
+			ps = s.AdditionalProperties
+		case s.Closed:
+			problems = append(problems, join(key)+": unknown field")
+			continue
+		default:
+			continue
+		}
+		problems = append(problems, ps.validate(join(key), value)...)
+	}
+	return problems
+}
+
+// checkFormat returns what is wrong with a string of a format, if anything
+func checkFormat(format, str string) string {
+	switch format {
+	case "uuid":
+		if uuid.Parse(str) == nil {
+			return "invalid uuid"
+		}
+	case "ip":
+		if net.ParseIP(str) == nil {
+			return "invalid ip"
+		}
+	case "cidr":
+		if _, _, err := net.ParseCIDR(str); err != nil {
+			return "invalid cidr"
+		}
+	case "mac":
+		if _, err := net.ParseMAC(str); err != nil {
+			return "invalid mac"
+		}
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, str); err != nil {
+			return "invalid date-time"
+		}
+	}
+	return ""
+}
+
+// contains returns whether a value is one of values
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package jsonschema_test
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mistifyio/lochness/pkg/jsonschema"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestJSONSchema(t *testing.T) {
+	suite.Run(t, new(JSONSchemaSuite))
+}
+
+type JSONSchemaSuite struct {
+	suite.Suite
+}
+
+type (
+	Base struct {
+		ID string `json:"id" schema:"uuid"`
+	}
+
+	Thing struct {
+		Base
+		Name     string            `json:"name" schema:"required"`
+		Count    uint64            `json:"count,omitempty"`
+		Ratio    float64           `json:"ratio"`
+		Enabled  bool              `json:"enabled"`
+		Kind     string            `json:"kind" schema:"enum=a|b"`
+		IP       net.IP            `json:"ip"`
+		MAC      net.HardwareAddr  `json:"mac"`
+		Created  time.Time         `json:"created" schema:"readonly"`
+		Tags     []string          `json:"tags"`
+		Metadata map[string]string `json:"metadata"`
+		Refs     map[string]string `json:"refs" schema:"uuid"`
+		Parent   *Thing            `json:"parent,omitempty"`
+		Big      int64             `json:"big,string"`
+		Ignored  string            `json:"-"`
+		private  string
+	}
+)
+
+func (s *JSONSchemaSuite) TestGenerate() {
+	schema := jsonschema.Generate("Thing", Thing{})
+	s.Equal(jsonschema.Draft, schema.Schema)
+	s.Equal("Thing", schema.Title)
+	s.Equal("object", schema.Type)
+	s.True(schema.Closed)
+	s.Equal([]string{"name"}, schema.Required)
+
+	props := schema.Properties
+	s.Len(props, 14)
+	s.Equal("uuid", props["id"].Format)
+	s.Equal("integer", props["count"].Type)
+	s.Equal(0.0, *props["count"].Minimum)
+	s.Equal("number", props["ratio"].Type)
+	s.Equal("boolean", props["enabled"].Type)
+	s.Equal([]string{"a", "b"}, props["kind"].Enum)
+	s.Equal("ip", props["ip"].Format)
+	s.Equal("mac", props["mac"].Format)
+	s.Equal("date-time", props["created"].Format)
+	s.True(props["created"].ReadOnly)
+	s.Equal("string", props["tags"].Items.Type)
+	s.Equal("string", props["metadata"].AdditionalProperties.Type)
+	s.Equal("uuid", props["refs"].AdditionalProperties.Format)
+	s.Equal("object", props["parent"].Type)
+	s.Nil(props["parent"].Properties, "recursive types should end")
+	s.Equal("string", props["big"].Type)
+}
+
+func (s *JSONSchemaSuite) TestJSON() {
+	schema := jsonschema.Generate("Thing", Thing{})
+	data, err := json.Marshal(schema)
+	s.Require().NoError(err)
+
+	var raw map[string]interface{}
+	s.Require().NoError(json.Unmarshal(data, &raw))
+	s.Equal(false, raw["additionalProperties"])
+	metadata := raw["properties"].(map[string]interface{})["metadata"].(map[string]interface{})
+	s.Equal(map[string]interface{}{"type": "string"}, metadata["additionalProperties"])
+
+	decoded := &jsonschema.Schema{}
+	s.Require().NoError(json.Unmarshal(data, decoded))
+	s.Equal(schema, decoded)
+}
+
+func (s *JSONSchemaSuite) TestValidate() {
+	schema := jsonschema.Generate("Thing", Thing{})
+	id := "8d3e9ee0-4b5c-4a9c-93c4-47b8b2a5d9b1"
+
+	tests := []struct {
+		description string
+		json        string
+		expected    []string
+	}{
+		{"minimal", `{"name":"foo"}`, nil},
+		{"full", `{"id":"` + id + `","name":"foo","count":1,"ratio":0.5,"enabled":true,"kind":"a","ip":"10.0.0.1","mac":"01:23:45:67:89:ab","created":"2016-01-02T15:04:05Z","tags":["a"],"metadata":{"a":"b"},"refs":{"a":"` + id + `"},"parent":{"name":"bar"},"big":"1"}`, nil},
+		{"missing", `{}`, []string{"name: missing"}},
+		{"unset", `{"name":"","id":null,"kind":""}`, []string{"name: missing"}},
+		{"not an object", `[]`, []string{"must be an object"}},
+		{"invalid", `{"id":"foo","name":"foo","count":-1,"ratio":"1","enabled":1,"kind":"c","ip":"10.0.0","mac":"foo","created":"yesterday","tags":"a","metadata":{"a":1},"refs":{"a":"foo"},"parent":{"id":"foo"},"big":1}`, []string{
+			"big: must be a string",
+			"count: must be at least 0",
+			"created: invalid date-time",
+			"enabled: must be a boolean",
+			"id: invalid uuid",
+			"ip: invalid ip",
+			"kind: must be one of a, b",
+			"mac: invalid mac",
+			"metadata.a: must be a string",
+			"ratio: must be a number",
+			"refs.a: invalid uuid",
+			"tags: must be an array",
+		}},
+		{"items", `{"name":"foo","tags":["a",1,null]}`, []string{"tags[1]: must be a string"}},
+		{"integer", `{"name":"foo","count":1.5}`, []string{"count: must be an integer"}},
+		{"unknown", `{"name":"foo","nmae":"foo"}`, []string{"nmae: unknown field"}},
+	}
+	for _, test := range tests {
+		var v interface{}
+		s.Require().NoError(json.Unmarshal([]byte(test.json), &v), test.description)
+		s.Equal(test.expected, schema.Validate(v), test.description)
+	}
+}
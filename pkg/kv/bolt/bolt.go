@@ -0,0 +1,502 @@
+// Package bolt is a kv.KV implementation storing its data in a local bbolt
+// file, for single node deployments without a kv cluster. Its semantics follow
+// the consul implementation. Watches, locks and ephemeral keys are handled in
+// process, so a file is only usable by one process at a time; locks and
+// ephemeral keys left by a previous process are released when the file is
+// opened.
+package bolt
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/mistifyio/lochness/pkg/kv"
+	"go.etcd.io/bbolt"
+)
+
+var (
+	errKeyNotFound = lerrors.NotFound(errors.New("key not found"))
+	errCAS         = lerrors.Conflict(errors.New("CAS failed"))
+	errLocked      = errors.New("lock held by another client")
+	errNotHeld     = errors.New("lock not held")
+)
+
+var (
+	dataBucket = []byte("kv")
+	metaBucket = []byte("meta")
+	indexKey   = []byte("index")
+)
+
+// OpenTimeout is how long New waits for another process to close the file
+var OpenTimeout = time.Second
+
+// stores holds the open stores by path, since a file can only be opened once
+var stores = struct {
+	sync.Mutex
+	m map[string]*store
+}{
+	m: map[string]*store{},
+}
+
+func init() {
+	kv.Register("bolt", New)
+}
+
+// New opens, or creates, the bbolt file at the path of addr, e.g.
+// "bolt:///var/lib/lochness/kv.db". Every kv for the same path in a process
+// shares the open file.
+func New(addr string) (kv.KV, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "bolt" {
+		return nil, errors.New("not a bolt address: " + addr)
+	}
+	path := u.Host + u.Path
+	if path == "" {
+		return nil, errors.New("missing path in bolt address: " + addr)
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	stores.Lock()
+	defer stores.Unlock()
+	if s, ok := stores.m[path]; ok {
+		return s, nil
+	}
+	s, err := open(path)
+	if err != nil {
+		return nil, err
+	}
+	stores.m[path] = s
+	return s, nil
+}
+
+type entry struct {
+	Data  []byte `json:"data"`
+	Index uint64 `json:"index"`
+	// Session is the id of the lock or ephemeral key holding the entry
+	Session   string `json:"session,omitempty"`
+	Ephemeral bool   `json:"ephemeral,omitempty"`
+}
+
+type store struct {
+	db *bbolt.DB
+
+	// mu serializes writes so that watches see events in order, and
+	// protects the following two vars
+	mu       sync.Mutex
+	sessions map[string]*session
+	watches  map[*watch]struct{}
+}
+
+func open(path string) (*store, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: OpenTimeout})
+	if err != nil {
+		return nil, err
+	}
+	s := &store{
+		db:       db,
+		sessions: map[string]*session{},
+		watches:  map[*watch]struct{}{},
+	}
+	if err := s.update(releaseAll); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// normalize strips the leading slash of a key
+func normalize(key string) string {
+	return strings.TrimPrefix(key, "/")
+}
+
+// txn is a write transaction, recording the events of its changes
+type txn struct {
+	data   *bbolt.Bucket
+	meta   *bbolt.Bucket
+	events []kv.Event
+}
+
+// update runs fn in a write transaction and notifies watches of its events
+// once committed. The store must be locked.
+func (s *store) update(fn func(*txn) error) error {
+	t := &txn{}
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		var err error
+		if t.data, err = tx.CreateBucketIfNotExists(dataBucket); err != nil {
+			return err
+		}
+		if t.meta, err = tx.CreateBucketIfNotExists(metaBucket); err != nil {
+			return err
+		}
+		return fn(t)
+	})
+	if err != nil {
+		return err
+	}
+	for _, event := range t.events {
+		s.notify(event)
+	}
+	return nil
+}
+
+// view runs fn in a read transaction. fn is not called if nothing has been
+// written yet.
+func (s *store) view(fn func(*bbolt.Bucket) error) error {
+	return s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(dataBucket)
+		if data == nil {
+			return nil
+		}
+		return fn(data)
+	})
+}
+
+func decode(b []byte) (*entry, error) {
+	e := &entry{}
+	if err := json.Unmarshal(b, e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// get returns the entry of key, or nil if it does not exist
+func (t *txn) get(key string) (*entry, error) {
+	b := t.data.Get([]byte(key))
+	if b == nil {
+		return nil, nil
+	}
+	return decode(b)
+}
+
+// nextIndex increments and returns the store index
+func (t *txn) nextIndex() (uint64, error) {
+	var index uint64
+	if b := t.meta.Get(indexKey); b != nil {
+		index = binary.BigEndian.Uint64(b)
+	}
+	index++
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, index)
+	return index, t.meta.Put(indexKey, b)
+}
+
+// write stores e without changing its index
+func (t *txn) write(key string, e *entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return t.data.Put([]byte(key), b)
+}
+
+// set stores data in key, keeping the session holding it unless sessionID is
+// given
+func (t *txn) set(key string, data []byte, sessionID string, ephemeral bool) (uint64, error) {
+	e, err := t.get(key)
+	if err != nil {
+		return 0, err
+	}
+	eType := kv.Update
+	var prev *kv.Value
+	if e == nil {
+		eType = kv.Create
+		e = &entry{}
+	} else {
+		prev = &kv.Value{Data: e.Data, Index: e.Index}
+	}
+
+	if e.Index, err = t.nextIndex(); err != nil {
+		return 0, err
+	}
+	e.Data = data
+	if sessionID != "" {
+		e.Session = sessionID
+		e.Ephemeral = ephemeral
+	}
+	if err := t.write(key, e); err != nil {
+		return 0, err
+	}
+
+	t.events = append(t.events, kv.Event{
+		Key:   key,
+		Type:  eType,
+		Value: kv.Value{Data: data, Index: e.Index},
+		Prev:  prev,
+	})
+	return e.Index, nil
+}
+
+// delete removes key, e is its current entry
+func (t *txn) delete(key string, e *entry) error {
+	if _, err := t.nextIndex(); err != nil {
+		return err
+	}
+	if err := t.data.Delete([]byte(key)); err != nil {
+		return err
+	}
+	t.events = append(t.events, kv.Event{
+		Key:   key,
+		Type:  kv.Delete,
+		Value: kv.Value{Index: e.Index},
+		Prev:  &kv.Value{Data: e.Data, Index: e.Index},
+	})
+	return nil
+}
+
+// releaseAll ends every session, which can only have been left by a previous
+// process
+func releaseAll(t *txn) error {
+	held := map[string]*entry{}
+	c := t.data.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		e, err := decode(v)
+		if err != nil {
+			return err
+		}
+		if e.Session != "" {
+			held[string(k)] = e
+		}
+	}
+
+	for key, e := range held {
+		if e.Ephemeral {
+			if err := t.delete(key, e); err != nil {
+				return err
+			}
+			continue
+		}
+		e.Session = ""
+		if err := t.write(key, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scan calls fn for every entry with a key under prefix
+func scan(data *bbolt.Bucket, prefix string, fn func(key string, e *entry) error) error {
+	c := data.Cursor()
+	for k, v := c.Seek([]byte(prefix)); k != nil && strings.HasPrefix(string(k), prefix); k, v = c.Next() {
+		e, err := decode(v)
+		if err != nil {
+			return err
+		}
+		if err := fn(string(k), e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *store) Delete(key string, recurse bool) error {
+	key = normalize(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.update(func(t *txn) error {
+		if !recurse {
+			e, err := t.get(key)
+			if err != nil || e == nil {
+				return err
+			}
+			return t.delete(key, e)
+		}
+
+		if !strings.HasSuffix(key, "/") {
+			key += "/"
+		}
+		matched := map[string]*entry{}
+		err := scan(t.data, key, func(k string, e *entry) error {
+			matched[k] = e
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for k, e := range matched {
+			if err := t.delete(k, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *store) Get(key string) (kv.Value, error) {
+	key = normalize(key)
+	var value kv.Value
+	err := s.view(func(data *bbolt.Bucket) error {
+		b := data.Get([]byte(key))
+		if b == nil {
+			return nil
+		}
+		e, err := decode(b)
+		if err != nil {
+			return err
+		}
+		value = kv.Value{Data: e.Data, Index: e.Index}
+		return nil
+	})
+	if err != nil {
+		return kv.Value{}, err
+	}
+	if value.Data == nil {
+		return kv.Value{}, errKeyNotFound
+	}
+	return value, nil
+}
+
+func (s *store) GetAll(prefix string) (map[string]kv.Value, error) {
+	many := map[string]kv.Value{}
+	err := s.view(func(data *bbolt.Bucket) error {
+		return scan(data, normalize(prefix), func(k string, e *entry) error {
+			many[k] = kv.Value{Data: e.Data, Index: e.Index}
+			return nil
+		})
+	})
+	return many, err
+}
+
+// Keys returns the keys directly under key. Deeper keys are returned as their
+// directory with a trailing slash.
+func (s *store) Keys(key string) ([]string, error) {
+	key = normalize(key)
+	if !strings.HasSuffix(key, "/") {
+		key += "/"
+	}
+
+	keys := []string{}
+	err := s.view(func(data *bbolt.Bucket) error {
+		return scan(data, key, func(k string, e *entry) error {
+			rest := k[len(key):]
+			if i := strings.Index(rest, "/"); i != -1 {
+				k = key + rest[:i+1]
+			}
+			// keys are scanned in order, so a directory is repeated
+			// consecutively
+			if len(keys) == 0 || keys[len(keys)-1] != k {
+				keys = append(keys, k)
+			}
+			return nil
+		})
+	})
+	sort.Strings(keys)
+	return keys, err
+}
+
+func (s *store) Set(key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.update(func(t *txn) error {
+		_, err := t.set(normalize(key), []byte(value), "", false)
+		return err
+	})
+}
+
+// Update sets key only if it was not modified since value.Index. An index of
+// 0 means the key must not exist.
+func (s *store) Update(key string, value kv.Value) (uint64, error) {
+	key = normalize(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var index uint64
+	err := s.update(func(t *txn) error {
+		e, err := t.get(key)
+		if err != nil {
+			return err
+		}
+		if (e != nil && e.Index != value.Index) || (e == nil && value.Index != 0) {
+			return errCAS
+		}
+		index, err = t.set(key, value.Data, "", false)
+		return err
+	})
+	return index, err
+}
+
+// Remove deletes key only if it was not modified since index. Removing a key
+// that does not exist succeeds.
+func (s *store) Remove(key string, index uint64) error {
+	key = normalize(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.update(func(t *txn) error {
+		e, err := t.get(key)
+		if err != nil || e == nil {
+			return err
+		}
+		if e.Index != index {
+			return lerrors.Conflict(errors.New("failed to delete atomically"))
+		}
+		return t.delete(key, e)
+	})
+}
+
+// Txn applies the ops in a single write transaction, which is rolled back if
+// any of them fails
+func (s *store) Txn(ops []kv.Op) ([]uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	indexes := make([]uint64, len(ops))
+	err := s.update(func(t *txn) error {
+		for i, op := range ops {
+			key := normalize(op.Key)
+			e, err := t.get(key)
+			if err != nil {
+				return err
+			}
+			if op.Remove {
+				if e == nil {
+					continue
+				}
+				if e.Index != op.Value.Index {
+					return lerrors.Conflict(errors.New("failed to delete atomically"))
+				}
+				if err := t.delete(key, e); err != nil {
+					return err
+				}
+				continue
+			}
+			if (e != nil && e.Index != op.Value.Index) || (e == nil && op.Value.Index != 0) {
+				return errCAS
+			}
+			if indexes[i], err = t.set(key, op.Value.Data, "", false); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return indexes, nil
+}
+
+func (s *store) IsKeyNotFound(err error) bool {
+	return lerrors.IsNotFound(err)
+}
+
+// Ping checks that the file is still readable
+func (s *store) Ping() error {
+	return s.db.View(func(tx *bbolt.Tx) error {
+		return nil
+	})
+}
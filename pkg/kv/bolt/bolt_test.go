@@ -0,0 +1,260 @@
+package bolt_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mistifyio/lochness/internal/tests/common"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/mistifyio/lochness/pkg/kv"
+	"github.com/mistifyio/lochness/pkg/kv/bolt"
+	"github.com/mistifyio/lochness/pkg/kv/kvtest"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestBolt(t *testing.T) {
+	suite.Run(t, new(BoltSuite))
+}
+
+func TestBoltConformance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bolt-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	suite.Run(t, &kvtest.Suite{
+		New: func() (kv.KV, error) {
+			return kv.New("bolt://" + filepath.Join(dir, uuid.New()+".db"))
+		},
+	})
+}
+
+type BoltSuite struct {
+	common.Suite
+	Dir string
+}
+
+func (s *BoltSuite) SetupSuite() {
+	var err error
+	s.Dir, err = ioutil.TempDir("", "bolt-test-")
+	s.Require().NoError(err)
+}
+
+func (s *BoltSuite) TearDownSuite() {
+	_ = os.RemoveAll(s.Dir)
+}
+
+func (s *BoltSuite) SetupTest() {
+	var err error
+	s.KV, err = kv.New("bolt://" + filepath.Join(s.Dir, uuid.New()+".db"))
+	s.Require().NoError(err)
+}
+
+func (s *BoltSuite) TearDownTest() {
+}
+
+func (s *BoltSuite) TestNew() {
+	path := filepath.Join(s.Dir, "shared.db")
+	a, err := kv.New("bolt://" + path)
+	s.Require().NoError(err)
+	b, err := kv.New("bolt://" + path)
+	s.Require().NoError(err)
+
+	s.NoError(a.Set("foo", "bar"))
+	value, err := b.Get("foo")
+	s.NoError(err)
+	s.Equal("bar", string(value.Data))
+
+	_, err = s.KV.Get("foo")
+	s.True(s.KV.IsKeyNotFound(err))
+
+	tests := []struct {
+		description string
+		addr        string
+	}{
+		{"generic", "http://" + path},
+		{"missing path", "bolt://"},
+		{"directory", "bolt://" + s.Dir},
+	}
+	for _, test := range tests {
+		_, err := bolt.New(test.addr)
+		s.Error(err, test.description)
+	}
+}
+
+func (s *BoltSuite) TestGetSet() {
+	_, err := s.KV.Get("foo/bar")
+	s.True(s.KV.IsKeyNotFound(err))
+
+	s.NoError(s.KV.Set("/foo/bar", "baz"))
+	value, err := s.KV.Get("foo/bar")
+	s.NoError(err)
+	s.Equal("baz", string(value.Data))
+	s.NotZero(value.Index)
+
+	s.NoError(s.KV.Set("foo/bar", "qux"))
+	updated, err := s.KV.Get("foo/bar")
+	s.NoError(err)
+	s.Equal("qux", string(updated.Data))
+	s.True(updated.Index > value.Index)
+}
+
+func (s *BoltSuite) TestKeys() {
+	for _, key := range []string{"foo/a", "foo/b", "foo/dir/c", "foo/dir/d", "food"} {
+		s.NoError(s.KV.Set(key, key))
+	}
+
+	keys, err := s.KV.Keys("foo")
+	s.NoError(err)
+	s.Equal([]string{"foo/a", "foo/b", "foo/dir/"}, keys)
+
+	values, err := s.KV.GetAll("foo/dir")
+	s.NoError(err)
+	s.Len(values, 2)
+	s.Equal("foo/dir/c", string(values["foo/dir/c"].Data))
+}
+
+func (s *BoltSuite) TestUpdate() {
+	tests := []struct {
+		description string
+		index       uint64
+		expectedErr bool
+	}{
+		{"create", 0, false},
+		{"create existing", 0, true},
+		{"stale index", 1000, true},
+	}
+
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		_, err := s.KV.Update("foo", kv.Value{Data: []byte("bar"), Index: test.index})
+		if test.expectedErr {
+			s.Error(err, msg("should fail"))
+			s.True(lerrors.IsConflict(err), msg("should be a conflict"))
+		} else {
+			s.NoError(err, msg("should succeed"))
+		}
+	}
+
+	value, err := s.KV.Get("foo")
+	s.Require().NoError(err)
+	index, err := s.KV.Update("foo", kv.Value{Data: []byte("baz"), Index: value.Index})
+	s.NoError(err)
+	s.True(index > value.Index)
+}
+
+func (s *BoltSuite) TestRemove() {
+	s.NoError(s.KV.Remove("foo", 1))
+	s.NoError(s.KV.Set("foo", "bar"))
+	value, _ := s.KV.Get("foo")
+
+	err := s.KV.Remove("foo", value.Index+1)
+	s.Error(err)
+	s.True(lerrors.IsConflict(err))
+	s.NoError(s.KV.Remove("foo", value.Index))
+	_, err = s.KV.Get("foo")
+	s.True(s.KV.IsKeyNotFound(err))
+}
+
+func (s *BoltSuite) TestDelete() {
+	for _, key := range []string{"foo", "foo/a", "foo/b/c", "food"} {
+		s.NoError(s.KV.Set(key, key))
+	}
+
+	s.NoError(s.KV.Delete("foo", true))
+	values, err := s.KV.GetAll("")
+	s.NoError(err)
+	s.Len(values, 2)
+	s.Contains(values, "foo")
+	s.Contains(values, "food")
+
+	s.NoError(s.KV.Delete("foo", false))
+	_, err = s.KV.Get("foo")
+	s.True(s.KV.IsKeyNotFound(err))
+}
+
+func (s *BoltSuite) TestWatch() {
+	s.NoError(s.KV.Set("foo/old", "old"))
+	old, _ := s.KV.Get("foo/old")
+	s.NoError(s.KV.Set("foo/existing", "existing"))
+
+	stop := make(chan struct{})
+	defer close(stop)
+	events, _, err := s.KV.Watch("foo", old.Index, stop)
+	s.Require().NoError(err)
+
+	s.NoError(s.KV.Set("bar", "ignored"))
+	s.NoError(s.KV.Set("foo/new", "new"))
+	s.NoError(s.KV.Set("foo/new", "newer"))
+	created, _ := s.KV.Get("foo/new")
+	s.NoError(s.KV.Delete("foo/new", false))
+
+	s.True(kv.WatchesPrev(s.KV))
+	expected := []kv.Event{
+		{Key: "foo/existing", Type: kv.Create},
+		{Key: "foo/new", Type: kv.Create},
+		{Key: "foo/new", Type: kv.Update, Prev: &kv.Value{Data: []byte("new")}},
+		{Key: "foo/new", Type: kv.Delete, Value: kv.Value{Index: created.Index}, Prev: &kv.Value{Data: []byte("newer"), Index: created.Index}},
+	}
+	for _, e := range expected {
+		msg := s.Messager(e.Key + " " + e.Type.String())
+		select {
+		case event := <-events:
+			s.Equal(e.Key, event.Key, msg("wrong key"))
+			s.Equal(e.Type, event.Type, msg("wrong type"))
+			if e.Type == kv.Delete {
+				s.Equal(e.Value.Index, event.Value.Index, msg("wrong index"))
+			}
+			if e.Prev == nil {
+				s.Nil(event.Prev, msg("should not have a previous value"))
+			} else if s.NotNil(event.Prev, msg("missing previous value")) {
+				s.Equal(string(e.Prev.Data), string(event.Prev.Data), msg("wrong previous value"))
+				if e.Prev.Index != 0 {
+					s.Equal(e.Prev.Index, event.Prev.Index, msg("wrong previous index"))
+				}
+			}
+		case <-time.After(time.Second):
+			s.Fail(msg("timed out waiting for event"))
+			return
+		}
+	}
+}
+
+func (s *BoltSuite) TestLock() {
+	lock, err := s.KV.Lock("lock", 50*time.Millisecond)
+	s.Require().NoError(err)
+
+	_, err = s.KV.Lock("lock", time.Second)
+	s.Error(err, "lock should be held")
+
+	s.NoError(lock.Renew())
+	s.NoError(lock.Unlock())
+	s.Error(lock.Unlock(), "lock should be released")
+
+	_, err = s.KV.Lock("lock", 50*time.Millisecond)
+	s.Require().NoError(err)
+	time.Sleep(100 * time.Millisecond)
+	other, err := s.KV.Lock("lock", time.Second)
+	s.NoError(err, "lock should have expired")
+	s.NoError(other.Unlock())
+}
+
+func (s *BoltSuite) TestEphemeralKey() {
+	key, err := s.KV.EphemeralKey("ephemeral", 50*time.Millisecond)
+	s.Require().NoError(err)
+	s.NoError(key.Set("foo"))
+
+	value, err := s.KV.Get("ephemeral")
+	s.NoError(err)
+	s.Equal("foo", string(value.Data))
+
+	time.Sleep(100 * time.Millisecond)
+	_, err = s.KV.Get("ephemeral")
+	s.True(s.KV.IsKeyNotFound(err), "key should have expired")
+	s.Error(key.Set("bar"))
+}
@@ -0,0 +1,145 @@
+package bolt
+
+import (
+	"time"
+
+	"github.com/mistifyio/lochness/pkg/kv"
+	"github.com/pborman/uuid"
+)
+
+// session holds a key until it is released or its ttl expires. The key is
+// unlocked on expiry, or deleted if the session is ephemeral.
+type session struct {
+	s         *store
+	id        string
+	key       string
+	ttl       time.Duration
+	ephemeral bool
+	timer     *time.Timer
+}
+
+// acquire creates a session holding key. The store must be locked.
+func (s *store) acquire(key string, ttl time.Duration, ephemeral bool) (*session, error) {
+	sess := &session{
+		s:         s,
+		id:        uuid.New(),
+		key:       normalize(key),
+		ttl:       ttl,
+		ephemeral: ephemeral,
+	}
+
+	err := s.update(func(t *txn) error {
+		e, err := t.get(sess.key)
+		if err != nil {
+			return err
+		}
+		var data []byte
+		if e != nil {
+			if e.Session != "" {
+				return errLocked
+			}
+			data = e.Data
+		}
+		_, err = t.set(sess.key, data, sess.id, ephemeral)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.sessions[sess.id] = sess
+	sess.timer = time.AfterFunc(ttl, sess.expire)
+	return sess, nil
+}
+
+func (s *store) Lock(key string, ttl time.Duration) (kv.Lock, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.acquire(key, ttl, false)
+}
+
+func (s *store) EphemeralKey(key string, ttl time.Duration) (kv.EphemeralKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.acquire(key, ttl, true)
+}
+
+// held reports whether the session is still alive. The store must be locked.
+func (sess *session) held() bool {
+	_, ok := sess.s.sessions[sess.id]
+	return ok
+}
+
+// end ends the session, releasing or deleting its key. The store must be
+// locked.
+func (sess *session) end() error {
+	sess.timer.Stop()
+	delete(sess.s.sessions, sess.id)
+
+	return sess.s.update(func(t *txn) error {
+		e, err := t.get(sess.key)
+		if err != nil || e == nil || e.Session != sess.id {
+			return err
+		}
+		if sess.ephemeral {
+			return t.delete(sess.key, e)
+		}
+		e.Session = ""
+		return t.write(sess.key, e)
+	})
+}
+
+func (sess *session) expire() {
+	sess.s.mu.Lock()
+	defer sess.s.mu.Unlock()
+
+	if sess.held() {
+		// a key left behind is released when the file is next opened
+		_ = sess.end()
+	}
+}
+
+// Renew resets the ttl of the session
+func (sess *session) Renew() error {
+	sess.s.mu.Lock()
+	defer sess.s.mu.Unlock()
+
+	if !sess.held() {
+		return errNotHeld
+	}
+	sess.timer.Reset(sess.ttl)
+	return nil
+}
+
+// Unlock releases the lock
+func (sess *session) Unlock() error {
+	sess.s.mu.Lock()
+	defer sess.s.mu.Unlock()
+
+	if !sess.held() {
+		return errNotHeld
+	}
+	return sess.end()
+}
+
+// Set renews the ephemeral key and sets its value
+func (sess *session) Set(value string) error {
+	sess.s.mu.Lock()
+	defer sess.s.mu.Unlock()
+
+	if !sess.held() {
+		return errNotHeld
+	}
+	sess.timer.Reset(sess.ttl)
+	return sess.s.update(func(t *txn) error {
+		_, err := t.set(sess.key, []byte(value), sess.id, sess.ephemeral)
+		return err
+	})
+}
+
+// Destroy deletes the ephemeral key without waiting for its ttl to expire
+func (sess *session) Destroy() error {
+	return sess.Unlock()
+}
@@ -0,0 +1,101 @@
+package bolt
+
+import (
+	"strings"
+
+	"github.com/mistifyio/lochness/pkg/kv"
+	"go.etcd.io/bbolt"
+)
+
+// watch delivers the events of a prefix. Events are queued so that writers
+// never block on slow watchers.
+type watch struct {
+	prefix string
+	queue  []kv.Event
+	wake   chan struct{}
+}
+
+// notify queues an event for the watches of its key. The store must be
+// locked.
+func (s *store) notify(event kv.Event) {
+	for w := range s.watches {
+		if !strings.HasPrefix(event.Key, w.prefix) {
+			continue
+		}
+		w.queue = append(w.queue, event)
+		select {
+		case w.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// WatchesPrev returns true, events of updated and deleted keys carry their
+// previous values
+func (s *store) WatchesPrev() bool {
+	return true
+}
+
+// Watch sends events for keys under prefix modified after lastIndex. Like the
+// consul implementation, existing keys modified after lastIndex are sent as
+// creates first.
+func (s *store) Watch(prefix string, lastIndex uint64, stop chan struct{}) (chan kv.Event, chan error, error) {
+	w := &watch{
+		prefix: normalize(prefix),
+		wake:   make(chan struct{}, 1),
+	}
+	events := make(chan kv.Event)
+	errs := make(chan error)
+
+	// writes are blocked while the existing keys are read, so none are
+	// missed before the watch is registered
+	s.mu.Lock()
+	err := s.view(func(data *bbolt.Bucket) error {
+		return scan(data, w.prefix, func(k string, e *entry) error {
+			if e.Index > lastIndex {
+				w.queue = append(w.queue, kv.Event{
+					Key:   k,
+					Type:  kv.Create,
+					Value: kv.Value{Data: e.Data, Index: e.Index},
+				})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		s.mu.Unlock()
+		return nil, nil, err
+	}
+	s.watches[w] = struct{}{}
+	s.mu.Unlock()
+
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			delete(s.watches, w)
+			s.mu.Unlock()
+		}()
+		for {
+			s.mu.Lock()
+			queue := w.queue
+			w.queue = nil
+			s.mu.Unlock()
+
+			for _, event := range queue {
+				select {
+				case events <- event:
+				case <-stop:
+					return
+				}
+			}
+
+			select {
+			case <-w.wake:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return events, errs, nil
+}
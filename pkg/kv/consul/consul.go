@@ -2,16 +2,17 @@ package consul
 
 import (
 	"errors"
+	"fmt"
 	"net/url"
 	"strings"
 	"time"
 
 	consul "github.com/hashicorp/consul/api"
-	"github.com/hashicorp/consul/watch"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
 	"github.com/mistifyio/lochness/pkg/kv"
 )
 
-var err404 = errors.New("key not found")
+var err404 = lerrors.NotFound(errors.New("key not found"))
 
 func init() {
 	kv.Register("consul", New)
@@ -20,15 +21,19 @@ func init() {
 type ckv struct {
 	c      *consul.KV
 	client *consul.Client
-	config *consul.Config
+	// indexDir is the directory the states of watches are persisted in, if
+	// any
+	indexDir string
 }
 
 // New instantiates a consul kv implementation.
 // The parameter addr may be the empty string or a valid URL.
 // If addr is not empty it must be a valid URL with schemes http, https or consul; consul is synonymous with http.
 // If addr is the empty string the consul client will connect to the default address, which may be influenced by the environment.
+// The watch_index_dir query parameter of addr, e.g. consul://127.0.0.1:8500?watch_index_dir=/var/lib/lochness/watches, is a directory to persist the index of each watched prefix in, so that a watch of the prefix after a restart resumes where the last one left off rather than sending every key again.
 func New(addr string) (kv.KV, error) {
 	config := consul.DefaultConfig()
+	var indexDir string
 	if addr == "" {
 		addr = config.Scheme + "://" + config.Address
 	} else {
@@ -41,6 +46,7 @@ func New(addr string) (kv.KV, error) {
 			config.Scheme = u.Scheme
 		}
 		config.Address = u.Host
+		indexDir = u.Query().Get("watch_index_dir")
 	}
 
 	client, err := consul.NewClient(config)
@@ -48,7 +54,7 @@ func New(addr string) (kv.KV, error) {
 		return nil, err
 	}
 
-	return &ckv{c: client.KV(), client: client, config: config}, nil
+	return &ckv{c: client.KV(), client: client, indexDir: indexDir}, nil
 }
 
 func (c *ckv) Delete(key string, recurse bool) error {
@@ -113,8 +119,7 @@ func (c *ckv) cas(key string, value kv.Value) error {
 	}
 
 	if !valid {
-		// TODO(mm) better error
-		return errors.New("CAS failed")
+		return lerrors.Conflict(errors.New("CAS failed"))
 	}
 
 	return nil
@@ -141,85 +146,76 @@ func (c *ckv) Remove(key string, index uint64) error {
 	}
 
 	if !ok {
-		err = errors.New("failed to delete atomically")
+		err = lerrors.Conflict(errors.New("failed to delete atomically"))
 	}
 
 	return err
 }
 
-func (c *ckv) IsKeyNotFound(err error) bool {
-	return err == err404
-}
-
-func (c *ckv) Watch(prefix string, lastIndex uint64, stop chan struct{}) (chan kv.Event, chan error, error) {
-	wp, err := watch.Parse(map[string]interface{}{
-		"type":   "keyprefix",
-		"prefix": prefix,
-	})
-	if err != nil {
-		return nil, nil, err
-	}
-
-	events := make(chan kv.Event)
-	errs := make(chan error)
-
-	lastState := map[string]uint64{}
-	wp.Handler = func(newIndex uint64, data interface{}) {
-		newState := map[string]uint64{}
-		for _, kvp := range data.(consul.KVPairs) {
-			newState[kvp.Key] = kvp.ModifyIndex
-
-			// from before time we care about, so not an Event
-			if kvp.ModifyIndex <= lastIndex {
-				delete(lastState, kvp.Key)
+// MaxTxnOps is the most writes consul applies in one transaction
+const MaxTxnOps = 64
+
+// Txn applies the ops in a single consul transaction. Removes of keys that do
+// not exist are left out of it, since consul fails them, while the other
+// implementations succeed.
+func (c *ckv) Txn(ops []kv.Op) ([]uint64, error) {
+	txn := make(consul.KVTxnOps, 0, len(ops))
+	// the ops of the transaction, by index in ops
+	txnOps := make([]int, 0, len(ops))
+	for i, op := range ops {
+		if op.Remove {
+			_, err := c.Get(op.Key)
+			if c.IsKeyNotFound(err) {
 				continue
 			}
-
-			event := kv.Event{
-				Key:  kvp.Key,
-				Type: kv.Update,
-				Value: kv.Value{
-					Data:  kvp.Value,
-					Index: kvp.ModifyIndex,
-				},
-			}
-
-			if _, ok := lastState[kvp.Key]; !ok {
-				event.Type = kv.Create
-			} else {
-				delete(lastState, kvp.Key)
+			if err != nil {
+				return nil, err
 			}
-			events <- event
+			txn = append(txn, &consul.KVTxnOp{Verb: consul.KVDeleteCAS, Key: op.Key, Index: op.Value.Index})
+		} else {
+			// an index of 0 only sets keys that do not exist
+			txn = append(txn, &consul.KVTxnOp{Verb: consul.KVCAS, Key: op.Key, Value: op.Value.Data, Index: op.Value.Index})
 		}
+		txnOps = append(txnOps, i)
+	}
+	if len(txn) > MaxTxnOps {
+		return nil, fmt.Errorf("transaction of %d writes exceeds the consul limit of %d", len(txn), MaxTxnOps)
+	}
 
-		// anything left over in lastState has not been found in
-		// newState so it must have been deleted
-		for key, index := range lastState {
-			events <- kv.Event{
-				Key:  key,
-				Type: kv.Delete,
-				Value: kv.Value{
-					Index: index,
-				},
+	indexes := make([]uint64, len(ops))
+	if len(txn) == 0 {
+		return indexes, nil
+	}
+	ok, resp, _, err := c.c.Txn(txn, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		for _, txnErr := range resp.Errors {
+			if txnErr.OpIndex < len(txnOps) && ops[txnOps[txnErr.OpIndex]].Remove {
+				return nil, lerrors.Conflict(errors.New("failed to delete atomically"))
 			}
 		}
-
-		lastState = newState
-		lastIndex = newIndex
+		return nil, lerrors.Conflict(errors.New("CAS failed"))
 	}
 
-	go func() {
-		<-stop
-		wp.Stop()
-	}()
-	go func() {
-		err = wp.Run(c.config.Address)
-		if err != nil {
-			errs <- err
+	// only the sets have results, in order
+	results := resp.Results
+	for _, i := range txnOps {
+		if ops[i].Remove {
+			continue
+		}
+		if len(results) == 0 {
+			return nil, errors.New("missing result of transaction write")
 		}
-	}()
+		indexes[i] = results[0].ModifyIndex
+		results = results[1:]
+	}
+	return indexes, nil
+}
 
-	return events, errs, nil
+func (c *ckv) IsKeyNotFound(err error) bool {
+	return lerrors.IsNotFound(err)
 }
 
 type lock struct {
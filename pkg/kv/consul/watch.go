@@ -0,0 +1,277 @@
+package consul
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	consul "github.com/hashicorp/consul/api"
+	"github.com/mistifyio/lochness/pkg/kv"
+)
+
+var (
+	// WaitTime is how long the blocking query of a watch waits for a change
+	// before it is made again, which also bounds how long a stopped watch
+	// lingers
+	WaitTime = time.Minute
+
+	// MaxRetryWait is the longest a watch waits to retry a failed query, the
+	// wait doubling from a second with each consecutive failure
+	MaxRetryWait = 30 * time.Second
+)
+
+// watch is the state of a watched prefix: the values of its keys as of the
+// last query, sent as the previous values of updated and deleted keys
+type watch struct {
+	prefix string
+	// since is the index events have been sent up to, keys modified at or
+	// before it are not sent
+	since uint64
+	last  map[string]kv.Value
+	// restored is set while last holds only the indexes of the keys, as
+	// persisted by a previous watch, and not their values
+	restored bool
+	// path is the file the state is persisted to, if any
+	path string
+}
+
+// watchState is what is persisted of a watch, the index its events have been
+// sent up to and the modify indexes of its keys then
+type watchState struct {
+	Index uint64            `json:"index"`
+	Keys  map[string]uint64 `json:"keys"`
+}
+
+// WatchesPrev returns true, the values of watched keys are kept to send the
+// previous values of updated and deleted keys
+func (c *ckv) WatchesPrev() bool {
+	return true
+}
+
+// Watch sends events for the keys under prefix modified after lastIndex,
+// existing keys first as creates. If the kv persists watch indexes, the watch
+// resumes from where the last watch of prefix left off instead, unless
+// lastIndex is more recent.
+func (c *ckv) Watch(prefix string, lastIndex uint64, stop chan struct{}) (chan kv.Event, chan error, error) {
+	w := &watch{
+		prefix: prefix,
+		since:  lastIndex,
+		last:   map[string]kv.Value{},
+	}
+	if c.indexDir != "" {
+		w.path = filepath.Join(c.indexDir, url.PathEscape(prefix)+".json")
+		state, err := loadWatchState(w.path)
+		if err != nil {
+			return nil, nil, err
+		}
+		if state != nil && state.Index >= lastIndex {
+			w.since = state.Index
+			for key, index := range state.Keys {
+				w.last[key] = kv.Value{Index: index}
+			}
+			w.restored = true
+		}
+	}
+
+	events := make(chan kv.Event)
+	errs := make(chan error)
+	go c.watch(w, events, stop)
+	return events, errs, nil
+}
+
+// watch makes the blocking queries of a watch until stop is closed. Failed
+// queries are retried, so that a consul leader election does not end the
+// watch.
+func (c *ckv) watch(w *watch, events chan kv.Event, stop chan struct{}) {
+	var index uint64
+	var retry time.Duration
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		pairs, meta, err := c.c.List(w.prefix, &consul.QueryOptions{
+			WaitIndex: index,
+			WaitTime:  WaitTime,
+		})
+		if err != nil {
+			retry *= 2
+			if retry == 0 {
+				retry = time.Second
+			} else if retry > MaxRetryWait {
+				retry = MaxRetryWait
+			}
+			log.WithFields(log.Fields{
+				"error":  err,
+				"func":   "consul.KV.List",
+				"prefix": w.prefix,
+				"retry":  retry.String(),
+			}).Warn("watch query failed")
+			select {
+			case <-time.After(retry):
+			case <-stop:
+				return
+			}
+			continue
+		}
+		retry = 0
+
+		switch {
+		case meta.LastIndex == index:
+			// the wait timed out without a change
+			continue
+		case meta.LastIndex < index:
+			// the index went backwards, e.g. the cluster was restored
+			// from a snapshot, so the prefix is listed afresh and every
+			// key in it sent again, whatever its index
+			index = 0
+			w.since = 0
+			w.last = map[string]kv.Value{}
+			w.restored = false
+		default:
+			index = meta.LastIndex
+		}
+
+		for _, event := range w.diff(pairs) {
+			select {
+			case events <- event:
+			case <-stop:
+				return
+			}
+		}
+		w.since = meta.LastIndex
+
+		if err := w.save(); err != nil {
+			log.WithFields(log.Fields{
+				"error":  err,
+				"func":   "watch.save",
+				"prefix": w.prefix,
+				"path":   w.path,
+			}).Error("failed to persist the watch index")
+		}
+	}
+}
+
+// diff returns the events of pairs against the last state of the watch, and
+// makes pairs the last state. Keys whose modify index is unchanged, or at or
+// before the index events were sent up to, have no event, nor have deleted
+// keys that were not known.
+func (w *watch) diff(pairs consul.KVPairs) []kv.Event {
+	var events []kv.Event
+	state := make(map[string]kv.Value, len(pairs))
+	for _, kvp := range pairs {
+		value := kv.Value{
+			Data:  kvp.Value,
+			Index: kvp.ModifyIndex,
+		}
+		state[kvp.Key] = value
+
+		prev, ok := w.last[kvp.Key]
+		delete(w.last, kvp.Key)
+		if kvp.ModifyIndex <= w.since || (ok && prev.Index == kvp.ModifyIndex) {
+			continue
+		}
+
+		event := kv.Event{
+			Key:   kvp.Key,
+			Type:  kv.Create,
+			Value: value,
+		}
+		if ok {
+			event.Type = kv.Update
+			if !w.restored {
+				event.Prev = &prev
+			}
+		}
+		events = append(events, event)
+	}
+
+	// anything left over in the last state has not been found in the new
+	// one so it must have been deleted
+	deleted := make([]string, 0, len(w.last))
+	for key := range w.last {
+		deleted = append(deleted, key)
+	}
+	sort.Strings(deleted)
+	for _, key := range deleted {
+		prev := w.last[key]
+		event := kv.Event{
+			Key:  key,
+			Type: kv.Delete,
+			Value: kv.Value{
+				Index: prev.Index,
+			},
+		}
+		if !w.restored {
+			event.Prev = &prev
+		}
+		events = append(events, event)
+	}
+
+	w.last = state
+	w.restored = false
+	return events
+}
+
+// save persists the index and keys of the watch, if it is persisted. The
+// file is replaced through a temporary file so that it is never left half
+// written.
+func (w *watch) save() error {
+	if w.path == "" {
+		return nil
+	}
+
+	state := watchState{
+		Index: w.since,
+		Keys:  make(map[string]uint64, len(w.last)),
+	}
+	for key, value := range w.last {
+		state.Keys[key] = value.Index
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(w.path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, filepath.Base(w.path)+".")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), w.path)
+}
+
+// loadWatchState reads the persisted state of a watch, nil if there is none
+func loadWatchState(path string) (*watchState, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	state := &watchState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
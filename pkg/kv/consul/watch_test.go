@@ -0,0 +1,175 @@
+package consul
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"github.com/mistifyio/lochness/pkg/kv"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestWatch(t *testing.T) {
+	suite.Run(t, new(WatchSuite))
+}
+
+type WatchSuite struct {
+	suite.Suite
+	Dir string
+}
+
+func (s *WatchSuite) SetupTest() {
+	var err error
+	s.Dir, err = ioutil.TempDir("", "consul-watch-test-")
+	s.Require().NoError(err)
+}
+
+func (s *WatchSuite) TearDownTest() {
+	_ = os.RemoveAll(s.Dir)
+}
+
+func (s *WatchSuite) TestDiff() {
+	w := &watch{since: 1, last: map[string]kv.Value{}}
+
+	events := w.diff(consul.KVPairs{
+		{Key: "a", Value: []byte("a1"), ModifyIndex: 1},
+		{Key: "b", Value: []byte("b2"), ModifyIndex: 2},
+	})
+	s.Require().Len(events, 1, "keys modified at or before since should have no event")
+	s.Equal(kv.Event{Key: "b", Type: kv.Create, Value: kv.Value{Data: []byte("b2"), Index: 2}}, events[0])
+
+	events = w.diff(consul.KVPairs{
+		{Key: "a", Value: []byte("a1"), ModifyIndex: 1},
+		{Key: "b", Value: []byte("b2"), ModifyIndex: 2},
+	})
+	s.Empty(events, "unchanged keys should have no event")
+
+	events = w.diff(consul.KVPairs{
+		{Key: "b", Value: []byte("b3"), ModifyIndex: 3},
+	})
+	s.Require().Len(events, 2)
+	s.Equal(kv.Event{
+		Key:   "b",
+		Type:  kv.Update,
+		Value: kv.Value{Data: []byte("b3"), Index: 3},
+		Prev:  &kv.Value{Data: []byte("b2"), Index: 2},
+	}, events[0])
+	s.Equal(kv.Event{
+		Key:   "a",
+		Type:  kv.Delete,
+		Value: kv.Value{Index: 1},
+		Prev:  &kv.Value{Data: []byte("a1"), Index: 1},
+	}, events[1])
+}
+
+func (s *WatchSuite) TestPersist() {
+	path := filepath.Join(s.Dir, "watches", "prefix.json")
+	state, err := loadWatchState(path)
+	s.NoError(err)
+	s.Nil(state, "a missing state should not be an error")
+
+	w := &watch{last: map[string]kv.Value{}, path: path}
+	_ = w.diff(consul.KVPairs{
+		{Key: "a", Value: []byte("a1"), ModifyIndex: 1},
+		{Key: "b", Value: []byte("b2"), ModifyIndex: 2},
+	})
+	w.since = 5
+	s.Require().NoError(w.save())
+
+	state, err = loadWatchState(path)
+	s.Require().NoError(err)
+	s.Equal(&watchState{Index: 5, Keys: map[string]uint64{"a": 1, "b": 2}}, state)
+
+	// a restored watch only sends what changed since, without previous values
+	w = &watch{since: state.Index, last: map[string]kv.Value{}, restored: true}
+	for key, index := range state.Keys {
+		w.last[key] = kv.Value{Index: index}
+	}
+	events := w.diff(consul.KVPairs{
+		{Key: "b", Value: []byte("b6"), ModifyIndex: 6},
+		{Key: "c", Value: []byte("c7"), ModifyIndex: 7},
+	})
+	s.Equal([]kv.Event{
+		{Key: "b", Type: kv.Update, Value: kv.Value{Data: []byte("b6"), Index: 6}},
+		{Key: "c", Type: kv.Create, Value: kv.Value{Data: []byte("c7"), Index: 7}},
+		{Key: "a", Type: kv.Delete, Value: kv.Value{Index: 1}},
+	}, events)
+	s.False(w.restored)
+}
+
+// listServer serves the responses to the list queries of a watch in turn,
+// repeating the last one
+type listServer struct {
+	mu        sync.Mutex
+	responses []listResponse
+}
+
+// listResponse is the index and keys of a list query
+type listResponse struct {
+	index uint64
+	pairs consul.KVPairs
+}
+
+func (l *listServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l.mu.Lock()
+	resp := l.responses[0]
+	if len(l.responses) > 1 {
+		l.responses = l.responses[1:]
+	} else {
+		// nothing changes any more, so do not have the watch spin
+		time.Sleep(10 * time.Millisecond)
+	}
+	l.mu.Unlock()
+
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(resp.index, 10))
+	_ = json.NewEncoder(w).Encode(resp.pairs)
+}
+
+func (s *WatchSuite) TestIndexBackwards() {
+	srv := httptest.NewServer(&listServer{responses: []listResponse{
+		{index: 10, pairs: consul.KVPairs{
+			{Key: "lochness/a", Value: []byte("a9"), ModifyIndex: 9},
+			{Key: "lochness/b", Value: []byte("b10"), ModifyIndex: 10},
+		}},
+		// the cluster was restored from an older snapshot
+		{index: 3, pairs: consul.KVPairs{
+			{Key: "lochness/a", Value: []byte("a2"), ModifyIndex: 2},
+			{Key: "lochness/c", Value: []byte("c3"), ModifyIndex: 3},
+		}},
+	}})
+	defer srv.Close()
+
+	client, err := consul.NewClient(&consul.Config{Address: srv.Listener.Addr().String()})
+	s.Require().NoError(err)
+	c := &ckv{c: client.KV(), client: client}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	events, _, err := c.Watch("lochness/", 0, stop)
+	s.Require().NoError(err)
+
+	var got []kv.Event
+	timeout := time.After(5 * time.Second)
+	for len(got) < 4 {
+		select {
+		case event := <-events:
+			got = append(got, event)
+		case <-timeout:
+			s.FailNow("timed out waiting for events", "got %v", got)
+		}
+	}
+	s.Equal([]kv.Event{
+		{Key: "lochness/a", Type: kv.Create, Value: kv.Value{Data: []byte("a9"), Index: 9}},
+		{Key: "lochness/b", Type: kv.Create, Value: kv.Value{Data: []byte("b10"), Index: 10}},
+		{Key: "lochness/a", Type: kv.Create, Value: kv.Value{Data: []byte("a2"), Index: 2}},
+		{Key: "lochness/c", Type: kv.Create, Value: kv.Value{Data: []byte("c3"), Index: 3}},
+	}, got, "keys at lower indexes should be sent again once the index goes backwards")
+}
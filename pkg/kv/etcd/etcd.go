@@ -7,6 +7,7 @@ import (
 
 	etcdErr "github.com/coreos/etcd/error"
 	"github.com/coreos/go-etcd/etcd"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
 	"github.com/mistifyio/lochness/pkg/kv"
 )
 
@@ -43,7 +44,7 @@ func New(addr string) (kv.KV, error) {
 
 func (e *ekv) Delete(key string, recurse bool) error {
 	_, err := e.e.Delete(key, recurse)
-	if err != nil && e.IsKeyNotFound(err) {
+	if err != nil && isErrorCode(err, etcdErr.EcodeKeyNotFound) {
 		err = nil
 	}
 	return err
@@ -52,7 +53,7 @@ func (e *ekv) Delete(key string, recurse bool) error {
 func (e ekv) Get(key string) (kv.Value, error) {
 	resp, err := e.e.Get(key, false, false)
 	if err != nil {
-		return kv.Value{}, err
+		return kv.Value{}, kindOf(err)
 	}
 
 	if resp.Node.Dir {
@@ -65,7 +66,7 @@ func (e ekv) Get(key string) (kv.Value, error) {
 func (e ekv) GetAll(prefix string) (map[string]kv.Value, error) {
 	resp, err := e.e.Get(prefix, false, true)
 	if err != nil {
-		return nil, err
+		return nil, kindOf(err)
 	}
 
 	if !resp.Node.Dir {
@@ -93,7 +94,7 @@ func (e ekv) GetAll(prefix string) (map[string]kv.Value, error) {
 func (e *ekv) Keys(key string) ([]string, error) {
 	resp, err := e.e.Get(key, true, false)
 	if err != nil {
-		return nil, err
+		return nil, kindOf(err)
 	}
 
 	if !resp.Node.Dir {
@@ -123,31 +124,50 @@ func (e ekv) Update(key string, value kv.Value) (uint64, error) {
 		resp, err = e.e.CompareAndSwap(key, string(value.Data), 0, "", value.Index)
 	}
 	if err != nil {
-		return 0, err
+		return 0, kindOf(err)
 	}
 	return resp.Node.ModifiedIndex, nil
 }
 
 func (e *ekv) Remove(key string, index uint64) error {
 	_, err := e.e.CompareAndDelete(key, "", index)
-	return err
+	return kindOf(err)
 }
 
 func (e *ekv) IsKeyNotFound(err error) bool {
-	eErr, ok := err.(*etcd.EtcdError)
-	return ok && eErr.ErrorCode == etcdErr.EcodeKeyNotFound
+	return lerrors.IsNotFound(err)
 }
 
-func (e *ekv) isKeyExists(err error) bool {
+// isErrorCode returns whether err is an etcd error with the code given
+func isErrorCode(err error, code int) bool {
 	eErr, ok := err.(*etcd.EtcdError)
-	return ok && eErr.ErrorCode == etcdErr.EcodeNodeExist
+	return ok && eErr.ErrorCode == code
+}
+
+// kindOf wraps etcd errors as the lochness errors they correspond to, if any
+func kindOf(err error) error {
+	switch {
+	case isErrorCode(err, etcdErr.EcodeKeyNotFound):
+		return lerrors.NotFound(err)
+	case isErrorCode(err, etcdErr.EcodeNodeExist), isErrorCode(err, etcdErr.EcodeTestFailed):
+		return lerrors.Conflict(err)
+	}
+	return err
 }
 
 var typeE2KV = map[string]kv.EventType{
-	"compareAndSwap": kv.Update,
-	"create":         kv.Create,
-	"delete":         kv.Delete,
-	"set":            kv.Update,
+	"compareAndDelete": kv.Delete,
+	"compareAndSwap":   kv.Update,
+	"create":           kv.Create,
+	"delete":           kv.Delete,
+	"expire":           kv.Delete,
+	"set":              kv.Update,
+}
+
+// WatchesPrev returns true, etcd sends the previous node of updated and
+// deleted keys
+func (e *ekv) WatchesPrev() bool {
+	return true
 }
 
 func (e *ekv) Watch(prefix string, index uint64, stop chan struct{}) (chan kv.Event, chan error, error) {
@@ -161,7 +181,7 @@ func (e *ekv) Watch(prefix string, index uint64, stop chan struct{}) (chan kv.Ev
 	events := make(chan kv.Event)
 	go func() {
 		for resp := range responses {
-			events <- kv.Event{
+			event := kv.Event{
 				Type: typeE2KV[resp.Action],
 				Key:  resp.Node.Key,
 				Value: kv.Value{
@@ -169,6 +189,13 @@ func (e *ekv) Watch(prefix string, index uint64, stop chan struct{}) (chan kv.Ev
 					Index: resp.Node.ModifiedIndex,
 				},
 			}
+			if resp.PrevNode != nil {
+				event.Prev = &kv.Value{
+					Data:  []byte(resp.PrevNode.Value),
+					Index: resp.PrevNode.ModifiedIndex,
+				}
+			}
+			events <- event
 		}
 	}()
 
@@ -213,7 +240,7 @@ func (e *ekv) Lock(key string, ttl time.Duration) (kv.Lock, error) {
 	if err == nil {
 		lock.index = resp.Node.ModifiedIndex
 		return lock, nil
-	} else if !e.isKeyExists(err) {
+	} else if !isErrorCode(err, etcdErr.EcodeNodeExist) {
 		return nil, err
 	}
 
@@ -224,7 +251,7 @@ func (e *ekv) Lock(key string, ttl time.Duration) (kv.Lock, error) {
 	}
 
 	value := string(v.Data)
-	if value != "locked=true" || value != "locked=false" {
+	if value != "locked=true" && value != "locked=false" {
 		return nil, errors.New("key does not contain a valid Lock value")
 	}
 
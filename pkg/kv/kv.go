@@ -1,7 +1,8 @@
 // Package kv abstracts a distributed/clusted kv store for use with lochness
 // kv does not aim to be a full-featured generic kv abstraction, but can be useful anyway.
 // Only implementors imported by users will be available at runtime.
-// See documentation of KV for handled operations.
+// See documentation of KV for handled operations. Implementations should pass
+// the conformance suite of the kvtest package.
 package kv
 
 import (
@@ -45,6 +46,10 @@ type Event struct {
 	Key  string
 	Type EventType
 	Value
+	// Prev is the value of the key before an Update or Delete, if the KV
+	// watches previous values, see WatchesPrev. It is nil for a Create, and
+	// may be nil for keys modified before the watch started.
+	Prev *Value
 }
 
 var register = struct {
@@ -99,12 +104,13 @@ func New(addr string) (KV, error) {
 	}
 
 	for _, constructor := range register.kvs {
+		// scheme was http(s) so an error just means we tried to connect
+		// to an incompatible cluster, or an implementation that is not
+		// reachable over http
 		kv, err := constructor(addr)
 		if err != nil {
-			return nil, err
+			continue
 		}
-		// scheme was http(s) so an error just means we tried to connect
-		// to an incompatible cluster
 		if err := kv.Ping(); err == nil {
 			return kv, nil
 		}
@@ -130,6 +136,21 @@ type EphemeralKey interface {
 	Destroy() error
 }
 
+// PrevWatcher is implemented by KVs that can send the previous values of keys
+// in watch events
+type PrevWatcher interface {
+	KV
+	// WatchesPrev returns whether watch events carry previous values
+	WatchesPrev() bool
+}
+
+// WatchesPrev returns whether the watch events of k carry the previous values
+// of updated and deleted keys in Event.Prev
+func WatchesPrev(k KV) bool {
+	pw, ok := k.(PrevWatcher)
+	return ok && pw.WatchesPrev()
+}
+
 // KV is the interface for distributed key value store interaction
 type KV interface {
 	Delete(string, bool) error
@@ -13,6 +13,7 @@ import (
 	"github.com/mistifyio/lochness/pkg/kv"
 	consul "github.com/mistifyio/lochness/pkg/kv/consul"
 	etcd "github.com/mistifyio/lochness/pkg/kv/etcd"
+	"github.com/mistifyio/lochness/pkg/kv/kvtest"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -33,6 +34,7 @@ func (s *KVSuite) SetupSuite() {
 	default:
 		panic("unknown KV specified in environment")
 	}
+	s.ExternalKV = true
 	s.Suite.SetupSuite()
 	s.keys = []string{s.KVPrefix + "/fee", s.KVPrefix + "/fi", s.KVPrefix + "/fo", s.KVPrefix + "/fum"}
 }
@@ -45,6 +47,18 @@ func (s *KVSuite) SetupTest() {
 	}
 }
 
+func (s *KVSuite) TestConformance() {
+	prefix := s.KVPrefix + "/conformance/"
+	suite.Run(s.T(), &kvtest.Suite{
+		New: func() (kv.KV, error) {
+			return s.KV, s.KV.Delete(prefix, true)
+		},
+		Prefix: prefix,
+		// sessions can not be shorter
+		TTL: 10 * time.Second,
+	})
+}
+
 func (s *KVSuite) TestRegister() {
 	s.Require().Panics(func() {
 		kv.Register("consul", func(string) (kv.KV, error) { return nil, nil })
@@ -0,0 +1,394 @@
+// Package kvtest provides a conformance suite for kv.KV implementations. Each
+// backend runs it in its tests to show it behaves as the others do: CRUD of
+// keys, compare-and-swap, transactions, watch ordering, and expiry of ephemeral keys and
+// locks.
+//
+// Prefixes are directories given with a trailing slash, as backends differ on
+// whether keys merely starting with a prefix, e.g. "food" for "foo", are under
+// it.
+//
+//	func TestConformance(t *testing.T) {
+//		suite.Run(t, &kvtest.Suite{
+//			New: func() (kv.KV, error) { return kv.New("mem://") },
+//		})
+//	}
+package kvtest
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/mistifyio/lochness/pkg/kv"
+	"github.com/stretchr/testify/suite"
+)
+
+// DefaultTTL is the ttl of the ephemeral keys and locks tested if Suite.TTL is
+// unset
+const DefaultTTL = 100 * time.Millisecond
+
+// Suite is the conformance suite of kv.KV implementations
+type Suite struct {
+	suite.Suite
+	// New creates the KV of a test. It must hold no keys under Prefix, e.g.
+	// a new in-memory store, or a shared one with the prefix deleted.
+	New func() (kv.KV, error)
+	// Prefix is prepended to every key tested, e.g. "lochness/" for keys to
+	// be mapped by kv.WithPrefix
+	Prefix string
+	// TTL is the ttl of the ephemeral keys and locks tested, for backends
+	// with a minimum. They are expected to expire within twice of it.
+	TTL time.Duration
+	// EventTimeout is how long a watch event may take to arrive, a second if
+	// unset
+	EventTimeout time.Duration
+	// KV is the KV of the current test
+	KV kv.KV
+}
+
+// SetupTest creates the KV of the test
+func (s *Suite) SetupTest() {
+	s.Require().NotNil(s.New, "kvtest.Suite.New must be set")
+	var err error
+	s.KV, err = s.New()
+	s.Require().NoError(err)
+	if s.TTL == 0 {
+		s.TTL = DefaultTTL
+	}
+	if s.EventTimeout == 0 {
+		s.EventTimeout = time.Second
+	}
+}
+
+// set sets keys to their own names
+func (s *Suite) set(keys ...string) {
+	for _, key := range keys {
+		s.Require().NoError(s.KV.Set(s.key(key), key))
+	}
+}
+
+// key returns the key tested under the prefix
+func (s *Suite) key(key string) string {
+	return s.Prefix + key
+}
+
+// trimmed returns keys as tested, trimmed as trimKeys does
+func (s *Suite) trimmed(keys ...string) []string {
+	for i, key := range keys {
+		keys[i] = s.key(key)
+	}
+	return trimKeys(keys)
+}
+
+// trimKeys strips the slashes backends may put around keys and directories
+func trimKeys(keys []string) []string {
+	trimmed := make([]string, len(keys))
+	for i, key := range keys {
+		trimmed[i] = strings.Trim(key, "/")
+	}
+	return trimmed
+}
+
+// TestGetSet checks values are stored, and their indexes increase
+func (s *Suite) TestGetSet() {
+	_, err := s.KV.Get(s.key("foo/bar"))
+	s.Require().Error(err, "missing keys should not be found")
+	s.True(s.KV.IsKeyNotFound(err), "missing keys should be not found errors")
+
+	s.NoError(s.KV.Set(s.key("foo/bar"), "baz"))
+	value, err := s.KV.Get(s.key("foo/bar"))
+	s.Require().NoError(err)
+	s.Equal("baz", string(value.Data))
+	s.NotZero(value.Index, "values should have an index")
+
+	s.NoError(s.KV.Set(s.key("foo/bar"), "qux"))
+	updated, err := s.KV.Get(s.key("foo/bar"))
+	s.Require().NoError(err)
+	s.Equal("qux", string(updated.Data))
+	s.True(updated.Index > value.Index, "setting a key should increase its index")
+
+	s.NoError(s.KV.Set(s.key("foo/empty"), ""))
+	value, err = s.KV.Get(s.key("foo/empty"))
+	s.NoError(err, "empty values should be stored")
+	s.Empty(value.Data)
+}
+
+// TestGetAll checks all the keys under a directory are returned with their
+// values
+func (s *Suite) TestGetAll() {
+	values, err := s.KV.GetAll(s.key("foo/"))
+	s.NoError(err, "missing prefixes should not be an error")
+	s.Empty(values)
+
+	s.set("foo/a", "foo/dir/b", "foo/dir/sub/c", "food")
+	values, err = s.KV.GetAll(s.key("foo/"))
+	s.Require().NoError(err)
+	s.Len(values, 3, "all keys under the directory, and only those, should be returned")
+	for _, key := range []string{"foo/a", "foo/dir/b", "foo/dir/sub/c"} {
+		value, err := s.KV.Get(s.key(key))
+		s.Require().NoError(err)
+		s.Equal(value, values[s.key(key)], key)
+	}
+}
+
+// TestKeys checks only the children of a prefix are listed
+func (s *Suite) TestKeys() {
+	keys, err := s.KV.Keys(s.key("foo"))
+	s.NoError(err, "missing prefixes should not be an error")
+	s.Empty(keys)
+
+	s.set("foo/a", "foo/b", "foo/dir/c", "foo/dir/d", "food")
+	keys, err = s.KV.Keys(s.key("foo"))
+	s.Require().NoError(err)
+	s.ElementsMatch(s.trimmed("foo/a", "foo/b", "foo/dir"), trimKeys(keys), "only the children of the prefix should be returned")
+
+	keys, err = s.KV.Keys(s.key("foo/dir/"))
+	s.Require().NoError(err)
+	s.ElementsMatch(s.trimmed("foo/dir/c", "foo/dir/d"), trimKeys(keys), "a trailing slash should not matter")
+}
+
+// TestDelete checks single and recursive deletes
+func (s *Suite) TestDelete() {
+	s.set("foo/a", "foo/b/c", "foo/d", "food")
+
+	s.NoError(s.KV.Delete(s.key("foo/a"), false))
+	_, err := s.KV.Get(s.key("foo/a"))
+	s.True(s.KV.IsKeyNotFound(err), "deleted keys should not be found")
+
+	s.NoError(s.KV.Delete(s.key("foo/"), true))
+	values, err := s.KV.GetAll(s.key("foo/"))
+	s.NoError(err)
+	s.Empty(values, "deleting recursively should delete the keys under the directory")
+	_, err = s.KV.Get(s.key("food"))
+	s.NoError(err, "keys outside the directory should be kept")
+}
+
+// TestUpdate checks compare-and-swap updates and their conflicts
+func (s *Suite) TestUpdate() {
+	index, err := s.KV.Update(s.key("foo"), kv.Value{Data: []byte("bar")})
+	s.Require().NoError(err, "an index of 0 should create the key")
+	s.NotZero(index)
+
+	value, err := s.KV.Get(s.key("foo"))
+	s.Require().NoError(err)
+	s.Equal(index, value.Index, "the index returned should be the key's")
+
+	tests := []struct {
+		description string
+		index       uint64
+	}{
+		{"create existing", 0},
+		{"stale index", index - 1},
+		{"future index", index + 1000},
+	}
+	for _, test := range tests {
+		_, err := s.KV.Update(s.key("foo"), kv.Value{Data: []byte("baz"), Index: test.index})
+		s.True(lerrors.IsConflict(err), test.description+" should be a conflict")
+	}
+	value, err = s.KV.Get(s.key("foo"))
+	s.Require().NoError(err)
+	s.Equal("bar", string(value.Data), "failed updates should not change the value")
+
+	updated, err := s.KV.Update(s.key("foo"), kv.Value{Data: []byte("baz"), Index: index})
+	s.Require().NoError(err, "the current index should update the key")
+	s.True(updated > index, "updating a key should increase its index")
+	value, err = s.KV.Get(s.key("foo"))
+	s.Require().NoError(err)
+	s.Equal("baz", string(value.Data))
+	s.Equal(updated, value.Index)
+}
+
+// TestRemove checks compare-and-swap removes and their conflicts
+func (s *Suite) TestRemove() {
+	index, err := s.KV.Update(s.key("foo"), kv.Value{Data: []byte("bar")})
+	s.Require().NoError(err)
+
+	for _, stale := range []uint64{index - 1, index + 1} {
+		err := s.KV.Remove(s.key("foo"), stale)
+		s.True(lerrors.IsConflict(err), fmt.Sprintf("removing at index %d should be a conflict", stale))
+		value, err := s.KV.Get(s.key("foo"))
+		s.Require().NoError(err, "failed removes should keep the key")
+		s.Equal(index, value.Index)
+	}
+
+	s.NoError(s.KV.Remove(s.key("foo"), index))
+	_, err = s.KV.Get(s.key("foo"))
+	s.True(s.KV.IsKeyNotFound(err), "removed keys should not be found")
+}
+
+// TestTxn checks that transactions apply all of their writes, or none of them
+// once one fails
+func (s *Suite) TestTxn() {
+	index, err := s.KV.Update(s.key("foo"), kv.Value{Data: []byte("bar")})
+	s.Require().NoError(err)
+	removed, err := s.KV.Update(s.key("gone"), kv.Value{Data: []byte("bar")})
+	s.Require().NoError(err)
+
+	_, err = kv.Txn(s.KV, []kv.Op{
+		{Key: s.key("new"), Value: kv.Value{Data: []byte("new")}},
+		{Key: s.key("gone"), Value: kv.Value{Index: removed}, Remove: true},
+		{Key: s.key("foo"), Value: kv.Value{Data: []byte("baz"), Index: index + 1000}},
+	})
+	s.True(lerrors.IsConflict(err), "a stale index should be a conflict")
+	_, err = s.KV.Get(s.key("new"))
+	s.True(s.KV.IsKeyNotFound(err), "a failed transaction should not create keys")
+	value, err := s.KV.Get(s.key("gone"))
+	s.Require().NoError(err, "a failed transaction should not remove keys")
+	s.Equal("bar", string(value.Data))
+
+	indexes, err := kv.Txn(s.KV, []kv.Op{
+		{Key: s.key("new"), Value: kv.Value{Data: []byte("new")}},
+		{Key: s.key("gone"), Value: kv.Value{Index: value.Index}, Remove: true},
+		{Key: s.key("foo"), Value: kv.Value{Data: []byte("baz"), Index: index}},
+	})
+	s.Require().NoError(err)
+	s.Require().Len(indexes, 3)
+	s.Zero(indexes[1], "removes should have no index")
+	for i, key := range map[int]string{0: "new", 2: "foo"} {
+		value, err := s.KV.Get(s.key(key))
+		s.Require().NoError(err)
+		s.Equal(indexes[i], value.Index, "the index returned should be the key's")
+	}
+	value, err = s.KV.Get(s.key("foo"))
+	s.Require().NoError(err)
+	s.Equal("baz", string(value.Data))
+	_, err = s.KV.Get(s.key("gone"))
+	s.True(s.KV.IsKeyNotFound(err), "removed keys should not be found")
+}
+
+// nextEvent receives an event, failing the test if none arrives in time
+func (s *Suite) nextEvent(events chan kv.Event, errs chan error) kv.Event {
+	select {
+	case event := <-events:
+		return event
+	case err := <-errs:
+		s.Require().FailNow(fmt.Sprintf("unexpected watch error: %v", err))
+	case <-time.After(s.EventTimeout):
+		s.Require().FailNow("timed out waiting for an event")
+	}
+	return kv.Event{}
+}
+
+// TestWatch checks changes after the index given are watched in order, and
+// only those under the directory
+func (s *Suite) TestWatch() {
+	s.NoError(s.KV.Set(s.key("foo/old"), "old"))
+	old, err := s.KV.Get(s.key("foo/old"))
+	s.Require().NoError(err)
+	s.NoError(s.KV.Set(s.key("foo/existing"), "existing"))
+
+	stop := make(chan struct{})
+	events, errs, err := s.KV.Watch(s.key("foo/"), old.Index, stop)
+	s.Require().NoError(err)
+
+	s.NoError(s.KV.Set(s.key("bar"), "ignored"))
+	s.NoError(s.KV.Set(s.key("food"), "ignored"))
+	for i := 0; i < 3; i++ {
+		s.NoError(s.KV.Set(s.key(fmt.Sprintf("foo/%d", i)), "new"))
+	}
+	s.NoError(s.KV.Set(s.key("foo/1"), "newer"))
+	s.NoError(s.KV.Delete(s.key("foo/0"), false))
+	s.NoError(s.KV.Delete(s.key("foo/1"), false))
+
+	expected := []struct {
+		key       string
+		eventType kv.EventType
+		data      string
+		prev      string
+	}{
+		{"foo/existing", kv.Create, "existing", ""},
+		{"foo/0", kv.Create, "new", ""},
+		{"foo/1", kv.Create, "new", ""},
+		{"foo/2", kv.Create, "new", ""},
+		{"foo/1", kv.Update, "newer", "new"},
+		{"foo/0", kv.Delete, "", "new"},
+		{"foo/1", kv.Delete, "", "newer"},
+	}
+	var index uint64
+	indexes := map[string]uint64{}
+	for i, e := range expected {
+		msg := fmt.Sprintf("event %d: %s %s", i, e.key, e.eventType)
+		event := s.nextEvent(events, errs)
+		s.Equal(strings.Trim(s.key(e.key), "/"), strings.Trim(event.Key, "/"), msg+": wrong key, events should be in order")
+		s.Equal(e.eventType, event.Type, msg+": wrong type")
+		if e.eventType == kv.Delete {
+			// deletes carry the last index of the key
+			s.Equal(indexes[e.key], event.Index, msg+": wrong index")
+		} else {
+			s.Equal(e.data, string(event.Data), msg+": wrong data")
+			s.True(event.Index > index, msg+": indexes should increase")
+			index = event.Index
+			indexes[e.key] = event.Index
+		}
+		if !kv.WatchesPrev(s.KV) {
+			continue
+		}
+		if e.prev == "" {
+			s.Nil(event.Prev, msg+": creates should not have a previous value")
+		} else if s.NotNil(event.Prev, msg+": missing previous value") {
+			s.Equal(e.prev, string(event.Prev.Data), msg+": wrong previous value")
+		}
+	}
+
+	close(stop)
+	time.Sleep(s.EventTimeout / 10)
+	s.NoError(s.KV.Set(s.key("foo/stopped"), "stopped"))
+	select {
+	case event, ok := <-events:
+		s.False(ok, fmt.Sprintf("unexpected event after stop: %v", event))
+	case <-time.After(s.EventTimeout / 10):
+	}
+}
+
+// TestEphemeralKey checks ephemeral keys live while renewed and expire after their
+// ttl
+func (s *Suite) TestEphemeralKey() {
+	key, err := s.KV.EphemeralKey(s.key("ephemeral"), s.TTL)
+	s.Require().NoError(err)
+	s.Require().NoError(key.Set("foo"))
+
+	value, err := s.KV.Get(s.key("ephemeral"))
+	s.Require().NoError(err)
+	s.Equal("foo", string(value.Data))
+
+	for i := 0; i < 3; i++ {
+		time.Sleep(s.TTL / 2)
+		s.Require().NoError(key.Renew())
+	}
+	_, err = s.KV.Get(s.key("ephemeral"))
+	s.NoError(err, "renewed keys should outlive their ttl")
+
+	time.Sleep(2 * s.TTL)
+	_, err = s.KV.Get(s.key("ephemeral"))
+	s.True(s.KV.IsKeyNotFound(err), "keys should expire after their ttl")
+	s.Error(key.Set("bar"), "expired keys should not be set")
+
+	key, err = s.KV.EphemeralKey(s.key("destroyed"), s.TTL)
+	s.Require().NoError(err)
+	s.Require().NoError(key.Set("foo"))
+	s.NoError(key.Destroy())
+	_, err = s.KV.Get(s.key("destroyed"))
+	s.True(s.KV.IsKeyNotFound(err), "destroyed keys should be deleted at once")
+}
+
+// TestLock checks locks are exclusive until released or expired
+func (s *Suite) TestLock() {
+	lock, err := s.KV.Lock(s.key("lock"), s.TTL)
+	s.Require().NoError(err)
+
+	_, err = s.KV.Lock(s.key("lock"), s.TTL)
+	s.Error(err, "held locks should not be acquired")
+
+	s.NoError(lock.Renew())
+	s.NoError(lock.Unlock())
+	s.Error(lock.Unlock(), "released locks should not be unlocked")
+
+	lock, err = s.KV.Lock(s.key("lock"), s.TTL)
+	s.Require().NoError(err, "released locks should be acquired")
+	time.Sleep(2 * s.TTL)
+	s.Error(lock.Renew(), "expired locks should not be renewed")
+	other, err := s.KV.Lock(s.key("lock"), s.TTL)
+	s.Require().NoError(err, "expired locks should be acquired")
+	s.NoError(other.Unlock())
+}
@@ -0,0 +1,375 @@
+// Package mem is an in-memory kv.KV implementation, for tests and demos that
+// should not need a running kv cluster. Its semantics follow the consul
+// implementation. Data lives only as long as the process.
+package mem
+
+import (
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/mistifyio/lochness/pkg/kv"
+)
+
+var (
+	errKeyNotFound = lerrors.NotFound(errors.New("key not found"))
+	errCAS         = lerrors.Conflict(errors.New("CAS failed"))
+	errLocked      = errors.New("lock held by another client")
+	errNotHeld     = errors.New("lock not held")
+)
+
+// stores holds the named stores so that every New with the same name shares
+// one store
+var stores = struct {
+	sync.Mutex
+	m map[string]*store
+}{
+	m: map[string]*store{},
+}
+
+func init() {
+	kv.Register("mem", New)
+}
+
+// New returns an in-memory kv. Stores are named by the host of addr, e.g.
+// "mem://demo", and every kv with the same name shares the same data. An addr
+// without a name ("mem://") always returns a new, private store.
+func New(addr string) (kv.KV, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "mem" {
+		return nil, errors.New("not a mem address: " + addr)
+	}
+	if u.Host == "" {
+		return newStore(), nil
+	}
+
+	stores.Lock()
+	defer stores.Unlock()
+	s, ok := stores.m[u.Host]
+	if !ok {
+		s = newStore()
+		stores.m[u.Host] = s
+	}
+	return s, nil
+}
+
+type entry struct {
+	data  []byte
+	index uint64
+	// session is the id of the lock or ephemeral key holding the entry
+	session string
+}
+
+type store struct {
+	mu       sync.Mutex
+	index    uint64
+	entries  map[string]*entry
+	sessions map[string]*session
+	watches  map[*watch]struct{}
+}
+
+func newStore() *store {
+	return &store{
+		entries:  map[string]*entry{},
+		sessions: map[string]*session{},
+		watches:  map[*watch]struct{}{},
+	}
+}
+
+// normalize strips the leading slash of a key
+func normalize(key string) string {
+	return strings.TrimPrefix(key, "/")
+}
+
+// set stores data in key and notifies watches. The store must be locked.
+func (s *store) set(key string, data []byte, sessionID string) uint64 {
+	s.index++
+	e, existed := s.entries[key]
+	var prev *kv.Value
+	if existed {
+		prev = &kv.Value{Data: e.data, Index: e.index}
+	} else {
+		e = &entry{}
+		s.entries[key] = e
+	}
+	e.data = data
+	e.index = s.index
+	if sessionID != "" {
+		e.session = sessionID
+	}
+
+	eType := kv.Update
+	if !existed {
+		eType = kv.Create
+	}
+	s.notify(kv.Event{
+		Key:   key,
+		Type:  eType,
+		Value: kv.Value{Data: data, Index: e.index},
+		Prev:  prev,
+	})
+	return e.index
+}
+
+// delete removes a key and notifies watches. The store must be locked.
+func (s *store) delete(key string) {
+	e, ok := s.entries[key]
+	if !ok {
+		return
+	}
+	delete(s.entries, key)
+	s.index++
+	s.notify(kv.Event{
+		Key:   key,
+		Type:  kv.Delete,
+		Value: kv.Value{Index: e.index},
+		Prev:  &kv.Value{Data: e.data, Index: e.index},
+	})
+}
+
+func (s *store) Delete(key string, recurse bool) error {
+	key = normalize(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !recurse {
+		s.delete(key)
+		return nil
+	}
+
+	if !strings.HasSuffix(key, "/") {
+		key += "/"
+	}
+	for k := range s.entries {
+		if strings.HasPrefix(k, key) {
+			s.delete(k)
+		}
+	}
+	return nil
+}
+
+func (s *store) Get(key string) (kv.Value, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[normalize(key)]
+	if !ok || e.data == nil {
+		return kv.Value{}, errKeyNotFound
+	}
+	return kv.Value{Data: e.data, Index: e.index}, nil
+}
+
+func (s *store) GetAll(prefix string) (map[string]kv.Value, error) {
+	prefix = normalize(prefix)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	many := map[string]kv.Value{}
+	for k, e := range s.entries {
+		if strings.HasPrefix(k, prefix) {
+			many[k] = kv.Value{Data: e.data, Index: e.index}
+		}
+	}
+	return many, nil
+}
+
+// Keys returns the keys directly under key. Deeper keys are returned as their
+// directory with a trailing slash.
+func (s *store) Keys(key string) ([]string, error) {
+	key = normalize(key)
+	if !strings.HasSuffix(key, "/") {
+		key += "/"
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seen := map[string]bool{}
+	keys := []string{}
+	for k := range s.entries {
+		if !strings.HasPrefix(k, key) {
+			continue
+		}
+		rest := k[len(key):]
+		if i := strings.Index(rest, "/"); i != -1 {
+			k = key + rest[:i+1]
+		}
+		if !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *store) Set(key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.set(normalize(key), []byte(value), "")
+	return nil
+}
+
+// Update sets key only if it was not modified since value.Index. An index of
+// 0 means the key must not exist.
+func (s *store) Update(key string, value kv.Value) (uint64, error) {
+	key = normalize(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if (ok && e.index != value.Index) || (!ok && value.Index != 0) {
+		return 0, errCAS
+	}
+	return s.set(key, value.Data, ""), nil
+}
+
+// Remove deletes key only if it was not modified since index. Removing a key
+// that does not exist succeeds.
+func (s *store) Remove(key string, index uint64) error {
+	key = normalize(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return nil
+	}
+	if e.index != index {
+		return lerrors.Conflict(errors.New("failed to delete atomically"))
+	}
+	s.delete(key)
+	return nil
+}
+
+// Txn applies the ops under the store lock, once each has been checked
+func (s *store) Txn(ops []kv.Op) ([]uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, op := range ops {
+		e, ok := s.entries[normalize(op.Key)]
+		switch {
+		case op.Remove && ok && e.index != op.Value.Index:
+			return nil, lerrors.Conflict(errors.New("failed to delete atomically"))
+		case op.Remove:
+		case (ok && e.index != op.Value.Index) || (!ok && op.Value.Index != 0):
+			return nil, errCAS
+		}
+	}
+
+	indexes := make([]uint64, len(ops))
+	for i, op := range ops {
+		if op.Remove {
+			s.delete(normalize(op.Key))
+			continue
+		}
+		indexes[i] = s.set(normalize(op.Key), op.Value.Data, "")
+	}
+	return indexes, nil
+}
+
+func (s *store) IsKeyNotFound(err error) bool {
+	return lerrors.IsNotFound(err)
+}
+
+// Ping always succeeds
+func (s *store) Ping() error {
+	return nil
+}
+
+// watch delivers the events of a prefix. Events are queued so that writers
+// never block on slow watchers.
+type watch struct {
+	prefix string
+	queue  []kv.Event
+	wake   chan struct{}
+}
+
+// notify queues an event for the watches of its key. The store must be
+// locked.
+func (s *store) notify(event kv.Event) {
+	for w := range s.watches {
+		if !strings.HasPrefix(event.Key, w.prefix) {
+			continue
+		}
+		w.queue = append(w.queue, event)
+		select {
+		case w.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// WatchesPrev returns true, events of updated and deleted keys carry their
+// previous values
+func (s *store) WatchesPrev() bool {
+	return true
+}
+
+// Watch sends events for keys under prefix modified after lastIndex. Like the
+// consul implementation, existing keys modified after lastIndex are sent as
+// creates first.
+func (s *store) Watch(prefix string, lastIndex uint64, stop chan struct{}) (chan kv.Event, chan error, error) {
+	w := &watch{
+		prefix: normalize(prefix),
+		wake:   make(chan struct{}, 1),
+	}
+	events := make(chan kv.Event)
+	errs := make(chan error)
+
+	s.mu.Lock()
+	keys := make([]string, 0, len(s.entries))
+	for k := range s.entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		e := s.entries[k]
+		if strings.HasPrefix(k, w.prefix) && e.index > lastIndex {
+			w.queue = append(w.queue, kv.Event{
+				Key:   k,
+				Type:  kv.Create,
+				Value: kv.Value{Data: e.data, Index: e.index},
+			})
+		}
+	}
+	s.watches[w] = struct{}{}
+	s.mu.Unlock()
+
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			delete(s.watches, w)
+			s.mu.Unlock()
+		}()
+		for {
+			s.mu.Lock()
+			queue := w.queue
+			w.queue = nil
+			s.mu.Unlock()
+
+			for _, event := range queue {
+				select {
+				case events <- event:
+				case <-stop:
+					return
+				}
+			}
+
+			select {
+			case <-w.wake:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return events, errs, nil
+}
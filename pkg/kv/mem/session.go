@@ -0,0 +1,131 @@
+package mem
+
+import (
+	"time"
+
+	"github.com/mistifyio/lochness/pkg/kv"
+	"github.com/pborman/uuid"
+)
+
+// session holds a key until it is released or its ttl expires. The key is
+// unlocked on expiry, or deleted if the session is ephemeral.
+type session struct {
+	s         *store
+	id        string
+	key       string
+	ttl       time.Duration
+	ephemeral bool
+	timer     *time.Timer
+}
+
+// acquire creates a session holding key. The store must be locked.
+func (s *store) acquire(key string, ttl time.Duration, ephemeral bool) (*session, error) {
+	key = normalize(key)
+	if e, ok := s.entries[key]; ok && e.session != "" {
+		return nil, errLocked
+	}
+
+	sess := &session{
+		s:         s,
+		id:        uuid.New(),
+		key:       key,
+		ttl:       ttl,
+		ephemeral: ephemeral,
+	}
+	var data []byte
+	if e, ok := s.entries[key]; ok {
+		data = e.data
+	}
+	s.set(key, data, sess.id)
+	s.sessions[sess.id] = sess
+	sess.timer = time.AfterFunc(ttl, sess.expire)
+	return sess, nil
+}
+
+func (s *store) Lock(key string, ttl time.Duration) (kv.Lock, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.acquire(key, ttl, false)
+}
+
+func (s *store) EphemeralKey(key string, ttl time.Duration) (kv.EphemeralKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.acquire(key, ttl, true)
+}
+
+// held reports whether the session is still alive. The store must be locked.
+func (sess *session) held() bool {
+	_, ok := sess.s.sessions[sess.id]
+	return ok
+}
+
+// end ends the session, releasing or deleting its key. The store must be
+// locked.
+func (sess *session) end() {
+	sess.timer.Stop()
+	delete(sess.s.sessions, sess.id)
+
+	e, ok := sess.s.entries[sess.key]
+	if !ok || e.session != sess.id {
+		return
+	}
+	if sess.ephemeral {
+		sess.s.delete(sess.key)
+		return
+	}
+	e.session = ""
+}
+
+func (sess *session) expire() {
+	sess.s.mu.Lock()
+	defer sess.s.mu.Unlock()
+
+	if sess.held() {
+		sess.end()
+	}
+}
+
+// Renew resets the ttl of the session
+func (sess *session) Renew() error {
+	sess.s.mu.Lock()
+	defer sess.s.mu.Unlock()
+
+	if !sess.held() {
+		return errNotHeld
+	}
+	sess.timer.Reset(sess.ttl)
+	return nil
+}
+
+// Unlock releases the lock
+func (sess *session) Unlock() error {
+	sess.s.mu.Lock()
+	defer sess.s.mu.Unlock()
+
+	if !sess.held() {
+		return errNotHeld
+	}
+	sess.end()
+	return nil
+}
+
+// Set renews the ephemeral key and sets its value
+func (sess *session) Set(value string) error {
+	sess.s.mu.Lock()
+	defer sess.s.mu.Unlock()
+
+	if !sess.held() {
+		return errNotHeld
+	}
+	sess.timer.Reset(sess.ttl)
+	sess.s.set(sess.key, []byte(value), sess.id)
+	return nil
+}
+
+// Destroy deletes the ephemeral key without waiting for its ttl to expire
+func (sess *session) Destroy() error {
+	return sess.Unlock()
+}
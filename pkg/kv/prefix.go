@@ -0,0 +1,146 @@
+package kv
+
+import (
+	"strings"
+	"time"
+)
+
+// DefaultPrefix is the root under which lochness keys are stored
+const DefaultPrefix = "/lochness"
+
+// prefixKV stores keys under the default root in another root instead
+type prefixKV struct {
+	KV
+	root   string
+	prefix string
+}
+
+// WithPrefix wraps a KV so that keys under DefaultPrefix are stored under
+// prefix instead, allowing independent lochness clusters to share one kv
+// cluster. Keys returned by the KV, including those of watch events, are
+// mapped back, so users of the returned KV keep using the default root. An
+// empty prefix or DefaultPrefix returns k unchanged.
+func WithPrefix(k KV, prefix string) KV {
+	root := strings.Trim(DefaultPrefix, "/")
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" || prefix == root {
+		return k
+	}
+	return &prefixKV{
+		KV:     k,
+		root:   root,
+		prefix: prefix,
+	}
+}
+
+// replaceRoot replaces the from root of key with to, keeping any leading slash.
+// Keys outside of from are returned unchanged.
+func replaceRoot(key, from, to string) string {
+	slash := ""
+	if strings.HasPrefix(key, "/") {
+		slash = "/"
+		key = key[1:]
+	}
+	if key == from {
+		return slash + to
+	}
+	if strings.HasPrefix(key, from+"/") {
+		return slash + to + key[len(from):]
+	}
+	return slash + key
+}
+
+func (p *prefixKV) in(key string) string {
+	return replaceRoot(key, p.root, p.prefix)
+}
+
+func (p *prefixKV) out(key string) string {
+	return replaceRoot(key, p.prefix, p.root)
+}
+
+func (p *prefixKV) Delete(key string, recurse bool) error {
+	return p.KV.Delete(p.in(key), recurse)
+}
+
+func (p *prefixKV) Get(key string) (Value, error) {
+	return p.KV.Get(p.in(key))
+}
+
+func (p *prefixKV) GetAll(prefix string) (map[string]Value, error) {
+	values, err := p.KV.GetAll(p.in(prefix))
+	if err != nil {
+		return nil, err
+	}
+	mapped := make(map[string]Value, len(values))
+	for key, value := range values {
+		mapped[p.out(key)] = value
+	}
+	return mapped, nil
+}
+
+func (p *prefixKV) Keys(key string) ([]string, error) {
+	keys, err := p.KV.Keys(p.in(key))
+	if err != nil {
+		return nil, err
+	}
+	for i, k := range keys {
+		keys[i] = p.out(k)
+	}
+	return keys, nil
+}
+
+func (p *prefixKV) Set(key, value string) error {
+	return p.KV.Set(p.in(key), value)
+}
+
+func (p *prefixKV) Update(key string, value Value) (uint64, error) {
+	return p.KV.Update(p.in(key), value)
+}
+
+func (p *prefixKV) Remove(key string, index uint64) error {
+	return p.KV.Remove(p.in(key), index)
+}
+
+func (p *prefixKV) Txn(ops []Op) ([]uint64, error) {
+	mapped := make([]Op, len(ops))
+	for i, op := range ops {
+		op.Key = p.in(op.Key)
+		mapped[i] = op
+	}
+	return Txn(p.KV, mapped)
+}
+
+func (p *prefixKV) Watch(prefix string, index uint64, stop chan struct{}) (chan Event, chan error, error) {
+	events, errs, err := p.KV.Watch(p.in(prefix), index, stop)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// like the underlying channel, mapped is only closed if events is, since
+	// callers may close stop themselves when it is
+	mapped := make(chan Event)
+	go func() {
+		for event := range events {
+			event.Key = p.out(event.Key)
+			select {
+			case mapped <- event:
+			case <-stop:
+				return
+			}
+		}
+		close(mapped)
+	}()
+	return mapped, errs, nil
+}
+
+func (p *prefixKV) WatchesPrev() bool {
+	return WatchesPrev(p.KV)
+}
+
+func (p *prefixKV) EphemeralKey(key string, ttl time.Duration) (EphemeralKey, error) {
+	return p.KV.EphemeralKey(p.in(key), ttl)
+}
+
+func (p *prefixKV) Lock(key string, ttl time.Duration) (Lock, error) {
+	return p.KV.Lock(p.in(key), ttl)
+}
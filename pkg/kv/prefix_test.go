@@ -0,0 +1,157 @@
+package kv_test
+
+import (
+	"testing"
+
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/mistifyio/lochness/pkg/kv"
+	"github.com/mistifyio/lochness/pkg/kv/kvtest"
+	"github.com/mistifyio/lochness/pkg/kv/mem"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestPrefix(t *testing.T) {
+	suite.Run(t, new(PrefixSuite))
+}
+
+func TestPrefixConformance(t *testing.T) {
+	suite.Run(t, &kvtest.Suite{
+		New: func() (kv.KV, error) {
+			store, err := kv.New("mem://")
+			if err != nil {
+				return nil, err
+			}
+			// keys of other clusters should go unseen
+			if err := store.Set("lochness/foo/a", "other"); err != nil {
+				return nil, err
+			}
+			return kv.WithPrefix(store, "cluster"), nil
+		},
+		Prefix: "lochness/",
+	})
+}
+
+type PrefixSuite struct {
+	common.Suite
+	Store *mapKV
+	KV    kv.KV
+}
+
+// mapKV is a minimal in-memory kv.KV that records the keys it is given
+type mapKV struct {
+	kv.KV
+	values map[string]string
+	events chan kv.Event
+}
+
+func (m *mapKV) Set(key, value string) error {
+	m.values[key] = value
+	return nil
+}
+
+func (m *mapKV) Get(key string) (kv.Value, error) {
+	return kv.Value{Data: []byte(m.values[key])}, nil
+}
+
+func (m *mapKV) GetAll(prefix string) (map[string]kv.Value, error) {
+	values := map[string]kv.Value{}
+	for key, value := range m.values {
+		values[key] = kv.Value{Data: []byte(value)}
+	}
+	return values, nil
+}
+
+func (m *mapKV) Keys(prefix string) ([]string, error) {
+	keys := []string{}
+	for key := range m.values {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (m *mapKV) Watch(prefix string, index uint64, stop chan struct{}) (chan kv.Event, chan error, error) {
+	return m.events, make(chan error), nil
+}
+
+func (s *PrefixSuite) SetupSuite() {
+}
+
+func (s *PrefixSuite) TearDownSuite() {
+}
+
+func (s *PrefixSuite) SetupTest() {
+	s.Store = &mapKV{
+		values: map[string]string{},
+		events: make(chan kv.Event, 1),
+	}
+	s.KV = kv.WithPrefix(s.Store, "/staging")
+}
+
+func (s *PrefixSuite) TearDownTest() {
+}
+
+func (s *PrefixSuite) TestWithPrefixDefault() {
+	s.Equal(s.Store, kv.WithPrefix(s.Store, ""))
+	s.Equal(s.Store, kv.WithPrefix(s.Store, kv.DefaultPrefix))
+	s.Equal(s.Store, kv.WithPrefix(s.Store, "lochness/"))
+}
+
+func (s *PrefixSuite) TestSet() {
+	tests := []struct {
+		description string
+		key         string
+		stored      string
+	}{
+		{"relative", "lochness/guests/foo", "staging/guests/foo"},
+		{"absolute", "/lochness/guests/foo", "/staging/guests/foo"},
+		{"root", "lochness", "staging"},
+		{"similar root", "lochnessfoo/bar", "lochnessfoo/bar"},
+		{"outside root", "foo/lochness", "foo/lochness"},
+	}
+
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		s.Store.values = map[string]string{}
+		s.NoError(s.KV.Set(test.key, "bar"), msg("set failed"))
+		s.Contains(s.Store.values, test.stored, msg("wrong stored key"))
+
+		value, err := s.KV.Get(test.key)
+		s.NoError(err, msg("get failed"))
+		s.Equal("bar", string(value.Data), msg("wrong value"))
+	}
+}
+
+func (s *PrefixSuite) TestKeys() {
+	s.Store.values["staging/guests/foo"] = "foo"
+	s.Store.values["/staging/guests/bar"] = "bar"
+
+	keys, err := s.KV.Keys("lochness/guests")
+	s.NoError(err)
+	s.ElementsMatch([]string{"lochness/guests/foo", "/lochness/guests/bar"}, keys)
+
+	values, err := s.KV.GetAll("lochness/guests")
+	s.NoError(err)
+	s.Contains(values, "lochness/guests/foo")
+	s.Contains(values, "/lochness/guests/bar")
+}
+
+func (s *PrefixSuite) TestWatch() {
+	stop := make(chan struct{})
+	defer close(stop)
+
+	events, _, err := s.KV.Watch("/lochness/guests", 0, stop)
+	s.Require().NoError(err)
+
+	s.Store.events <- kv.Event{Key: "/staging/guests/foo", Type: kv.Create}
+	event := <-events
+	s.Equal("/lochness/guests/foo", event.Key)
+	s.Equal(kv.Create, event.Type)
+}
+
+func (s *PrefixSuite) TestWatchesPrev() {
+	s.False(kv.WatchesPrev(s.KV), "should not watch previous values if the kv does not")
+
+	store, err := mem.New("mem://")
+	s.Require().NoError(err)
+	s.True(kv.WatchesPrev(kv.WithPrefix(store, "/staging")))
+}
@@ -0,0 +1,158 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// TxnLockKey is the key of the lock that serializes the transactions of
+	// KVs that are not Transactors, shared by every client of the kv
+	TxnLockKey = "lochness/kv/txn-lock"
+
+	// TxnLockTTL is how long the transaction lock is held if its holder dies
+	TxnLockTTL = 30 * time.Second
+
+	// TxnLockTimeout is how long Txn waits for the transaction lock
+	TxnLockTimeout = 10 * time.Second
+)
+
+// Op is a write of a transaction. Like Update, it sets Key to Value.Data only
+// if the key was not modified since Value.Index, an index of 0 meaning the key
+// must not exist. With Remove, like Remove, it deletes the key instead.
+type Op struct {
+	Key    string
+	Value  Value
+	Remove bool
+}
+
+// Transactor is implemented by KVs that can apply several writes atomically
+type Transactor interface {
+	KV
+	// Txn applies the ops all at once, or none of them if any fails, and
+	// returns the modified index of each key written, 0 for those removed.
+	Txn([]Op) ([]uint64, error)
+}
+
+// UndoError is returned by Txn when the writes applied before one failed could
+// not all be undone, so that the transaction is left partly applied
+type UndoError struct {
+	// Err is the error of the op that failed
+	Err error
+	// Keys are the keys whose writes could not be undone
+	Keys []string
+}
+
+func (e *UndoError) Error() string {
+	return fmt.Sprintf("%v, and the writes of %s could not be undone", e.Err, strings.Join(e.Keys, ", "))
+}
+
+// Txn applies ops with k. KVs that are Transactors, such as bolt, consul and
+// mem, apply them atomically, all of them or none. Others, such as etcd, are
+// not transactional: the ops are applied in order, holding the lock of
+// TxnLockKey so that transactions do not interleave, and those applied are
+// undone once one fails. Other clients may then see the ops partly applied,
+// and an undo fails if its key was modified in the meantime, which is
+// returned as an UndoError. Otherwise the error is that of the op that failed.
+// A single op is applied as is, without the lock, since it is atomic anyway.
+func Txn(k KV, ops []Op) ([]uint64, error) {
+	if t, ok := k.(Transactor); ok {
+		return t.Txn(ops)
+	}
+	if len(ops) == 1 {
+		index, _, err := applyOp(k, ops[0])
+		if err != nil {
+			return nil, err
+		}
+		return []uint64{index}, nil
+	}
+
+	lock, err := lockTxn(k)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = lock.Unlock() }()
+
+	indexes := make([]uint64, len(ops))
+	var undo []Op
+	for i, op := range ops {
+		index, revert, err := applyOp(k, op)
+		if err != nil {
+			var failed []string
+			for j := len(undo) - 1; j >= 0; j-- {
+				if _, _, undoErr := applyOp(k, undo[j]); undoErr != nil {
+					failed = append(failed, undo[j].Key)
+				}
+			}
+			if len(failed) > 0 {
+				return nil, &UndoError{Err: err, Keys: failed}
+			}
+			return nil, err
+		}
+		indexes[i] = index
+		if revert != nil {
+			undo = append(undo, *revert)
+		}
+	}
+	return indexes, nil
+}
+
+// lockTxn takes the transaction lock, retrying while another client holds it
+// for up to TxnLockTimeout
+func lockTxn(k KV) (Lock, error) {
+	deadline := time.Now().Add(TxnLockTimeout)
+	wait := 10 * time.Millisecond
+	for {
+		lock, err := k.Lock(TxnLockKey, TxnLockTTL)
+		if err == nil {
+			return lock, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.New("timed out waiting for the transaction lock: " + err.Error())
+		}
+		time.Sleep(wait)
+		if wait < time.Second {
+			wait *= 2
+		}
+	}
+}
+
+// applyOp applies an op, returning the modified index of the key and the op
+// undoing it, if any
+func applyOp(k KV, op Op) (uint64, *Op, error) {
+	// the previous value is only needed to undo changes to an existing key
+	var prev Value
+	if op.Value.Index != 0 {
+		var err error
+		prev, err = k.Get(op.Key)
+		switch {
+		case err == nil:
+		case !k.IsKeyNotFound(err):
+			return 0, nil, err
+		case op.Remove:
+			// removing a key that does not exist succeeds
+			return 0, nil, nil
+		}
+	}
+
+	if op.Remove {
+		if err := k.Remove(op.Key, op.Value.Index); err != nil {
+			return 0, nil, err
+		}
+		if op.Value.Index == 0 {
+			return 0, nil, nil
+		}
+		return 0, &Op{Key: op.Key, Value: Value{Data: prev.Data}}, nil
+	}
+
+	index, err := k.Update(op.Key, op.Value)
+	if err != nil {
+		return 0, nil, err
+	}
+	if op.Value.Index == 0 {
+		return index, &Op{Key: op.Key, Value: Value{Index: index}, Remove: true}, nil
+	}
+	return index, &Op{Key: op.Key, Value: Value{Data: prev.Data, Index: index}}, nil
+}
@@ -0,0 +1,92 @@
+package kv_test
+
+import (
+	"testing"
+	"time"
+
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/mistifyio/lochness/pkg/kv"
+	"github.com/mistifyio/lochness/pkg/kv/kvtest"
+	_ "github.com/mistifyio/lochness/pkg/kv/mem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// TestTxnUndoConformance checks the transactions of KVs that are not
+// Transactors, which undo their writes once one fails
+func TestTxnUndoConformance(t *testing.T) {
+	suite.Run(t, &kvtest.Suite{
+		New: func() (kv.KV, error) {
+			store, err := kv.New("mem://")
+			if err != nil {
+				return nil, err
+			}
+			// hide the Txn of the store
+			return struct{ kv.KV }{store}, nil
+		},
+	})
+}
+
+// meddler modifies the key "a" whenever the key "b" is updated, so that the
+// undo of a write of "a" fails
+type meddler struct {
+	kv.KV
+}
+
+func (m meddler) Update(key string, value kv.Value) (uint64, error) {
+	if key == "b" {
+		_ = m.KV.Set("a", "meddled")
+	}
+	return m.KV.Update(key, value)
+}
+
+func TestTxnUndoError(t *testing.T) {
+	store, err := kv.New("mem://")
+	require.NoError(t, err)
+
+	_, err = kv.Txn(meddler{store}, []kv.Op{
+		{Key: "a", Value: kv.Value{Data: []byte("a")}},
+		{Key: "b", Value: kv.Value{Data: []byte("b"), Index: 1000}},
+	})
+	undoErr, ok := err.(*kv.UndoError)
+	require.True(t, ok, "a failed undo should be an UndoError")
+	assert.Equal(t, []string{"a"}, undoErr.Keys)
+	assert.True(t, lerrors.IsConflict(undoErr.Err), "the error of the failed op should be kept")
+}
+
+func TestTxnLock(t *testing.T) {
+	store, err := kv.New("mem://")
+	require.NoError(t, err)
+	k := struct{ kv.KV }{store}
+
+	timeout := kv.TxnLockTimeout
+	kv.TxnLockTimeout = 50 * time.Millisecond
+	defer func() { kv.TxnLockTimeout = timeout }()
+
+	lock, err := k.Lock(kv.TxnLockKey, time.Minute)
+	require.NoError(t, err)
+	ops := []kv.Op{
+		{Key: "a", Value: kv.Value{Data: []byte("a")}},
+		{Key: "b", Value: kv.Value{Data: []byte("b")}},
+	}
+	_, err = kv.Txn(k, ops)
+	assert.Error(t, err, "a transaction should wait for the lock")
+	_, err = k.Get("a")
+	assert.True(t, k.IsKeyNotFound(err), "a transaction without the lock should not write")
+
+	indexes, err := kv.Txn(k, []kv.Op{{Key: "c", Value: kv.Value{Data: []byte("c")}}})
+	assert.NoError(t, err, "a single op should not wait for the lock")
+	assert.Len(t, indexes, 1)
+	_, err = kv.Txn(k, []kv.Op{{Key: "c", Value: kv.Value{Data: []byte("c")}}})
+	assert.True(t, lerrors.IsConflict(err), "a single op should keep its index check")
+
+	require.NoError(t, lock.Unlock())
+	_, err = kv.Txn(k, ops)
+	assert.NoError(t, err)
+	_, err = kv.Txn(k, []kv.Op{
+		{Key: "d", Value: kv.Value{Data: []byte("d")}},
+		{Key: "e", Value: kv.Value{Data: []byte("e")}},
+	})
+	assert.NoError(t, err, "the lock should be released after a transaction")
+}
@@ -0,0 +1,20 @@
+/*
+Package lochnesstest provides test doubles for code built on the lochness
+library. Store is a testify mock satisfying lochness.Store, so logic that looks
+up guests, hypervisors, and the other entities can be unit tested without a
+running kv.
+
+Example
+
+	store := &lochnesstest.Store{}
+	store.On("Guest", guestID).Return(guest, nil)
+	store.On("Hypervisor", "bad").Return(nil, errors.New("invalid UUID: bad"))
+
+	doSomething(store)
+
+	store.AssertExpectations(t)
+
+Store is generated with mockery from the interfaces in the lochness package;
+run go generate in the repository root after changing them.
+*/
+package lochnesstest
@@ -0,0 +1,64 @@
+package lochnesstest_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/pkg/lochnesstest"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestStore(t *testing.T) {
+	suite.Run(t, new(StoreSuite))
+}
+
+type StoreSuite struct {
+	suite.Suite
+	Store *lochnesstest.Store
+}
+
+func (s *StoreSuite) SetupTest() {
+	s.Store = &lochnesstest.Store{}
+}
+
+func (s *StoreSuite) TestImplementsStore() {
+	var store lochness.Store = s.Store
+	s.NotNil(store)
+}
+
+func (s *StoreSuite) TestReturn() {
+	guest := &lochness.Guest{ID: "foo"}
+	s.Store.On("Guest", "foo").Return(guest, nil)
+	s.Store.On("Guest", "bar").Return(nil, errors.New("not found"))
+
+	g, err := s.Store.Guest("foo")
+	s.NoError(err)
+	s.Equal(guest, g)
+
+	g, err = s.Store.Guest("bar")
+	s.Error(err)
+	s.Nil(g)
+
+	s.Store.AssertExpectations(s.T())
+}
+
+func (s *StoreSuite) TestReturnFunc() {
+	hypervisors := []*lochness.Hypervisor{{ID: "foo"}, {ID: "bar"}}
+	s.Store.On("ForEachHypervisor", mock.Anything).Return(func(f func(*lochness.Hypervisor) error) error {
+		for _, h := range hypervisors {
+			if err := f(h); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	var seen []string
+	s.NoError(s.Store.ForEachHypervisor(func(h *lochness.Hypervisor) error {
+		seen = append(seen, h.ID)
+		return nil
+	}))
+	s.Equal([]string{"foo", "bar"}, seen)
+}
@@ -0,0 +1,413 @@
+// Package lock provides distributed exclusive and read-write locks, and
+// semaphores limiting a resource to N holders, on top of a kv.KV.
+// A held lock is kept alive in the background until it is released, and
+// records who holds it so that contention can be debugged. Clients blocked in
+// Acquire wait in a queue and obtain the lock in the order they asked for it.
+package lock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mistifyio/lochness/pkg/kv"
+	"github.com/pborman/uuid"
+)
+
+var (
+	// ErrLocked is returned when a lock is held by someone else
+	ErrLocked = errors.New("lock is held by another client")
+	// ErrTimeout is returned when a lock could not be acquired in time
+	ErrTimeout = errors.New("timed out acquiring lock")
+	// ErrNotHeld is returned when operating on a lock that is not held
+	ErrNotHeld = errors.New("lock is not held")
+)
+
+// PollInterval is how often a blocking Acquire retries a held lock
+var PollInterval = 500 * time.Millisecond
+
+// Holder describes the client holding a lock
+type Holder struct {
+	ID       string        `json:"id"`
+	Hostname string        `json:"hostname"`
+	PID      int           `json:"pid"`
+	Acquired time.Time     `json:"acquired"`
+	Renewed  time.Time     `json:"renewed"`
+	TTL      time.Duration `json:"ttl"`
+}
+
+// Lock is an exclusive lock on a kv key
+type Lock struct {
+	kv  kv.KV
+	key string
+	ttl time.Duration
+	id  string
+
+	mu       sync.Mutex // mu protects the following vars
+	lock     kv.Lock
+	holder   kv.EphemeralKey
+	info     Holder
+	stop     chan struct{}
+	lost     chan struct{}
+	position int
+}
+
+// New creates a new, unheld, Lock on key. A held lock expires if it is not
+// renewed within ttl.
+func New(k kv.KV, key string, ttl time.Duration) (*Lock, error) {
+	if k == nil {
+		return nil, errors.New("kv must not be nil")
+	}
+	if key == "" {
+		return nil, errors.New("missing key")
+	}
+	if ttl < time.Second {
+		return nil, errors.New("ttl must be at least 1s")
+	}
+
+	return &Lock{
+		kv:       k,
+		key:      key,
+		ttl:      ttl,
+		id:       uuid.New(),
+		position: -1,
+	}, nil
+}
+
+// lockKey is a helper for generating the key of the underlying kv lock
+func lockKey(key string) string {
+	return path.Join(key, "lock")
+}
+
+// holderKey is a helper for generating the key holding the Holder info
+func holderKey(key string) string {
+	return path.Join(key, "holder")
+}
+
+// queueKey is a helper for generating the key of the directory of waiters
+func queueKey(key string) string {
+	return path.Join(key, "queue")
+}
+
+// ticketKey is a helper for generating the key of the last ticket handed out
+// to a waiter
+func ticketKey(key string) string {
+	return path.Join(key, "ticket")
+}
+
+// Key returns the key of the lock
+func (l *Lock) Key() string {
+	return l.key
+}
+
+// ID returns the unique id used for this lock in Holder info
+func (l *Lock) ID() string {
+	return l.id
+}
+
+// TryAcquire attempts to acquire the lock without blocking. ErrLocked is
+// returned if the lock is held by someone else, or if others are waiting for
+// it in Acquire.
+func (l *Lock) TryAcquire() error {
+	return l.tryAcquire("")
+}
+
+// tryAcquire attempts to acquire the lock if ticket is first in the queue of
+// waiters, or if nobody is waiting when ticket is empty
+func (l *Lock) tryAcquire(ticket string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.lock != nil {
+		return nil
+	}
+
+	tickets, err := queued(l.kv, l.key)
+	if err != nil {
+		return err
+	}
+	position := len(tickets)
+	if ticket != "" {
+		position = sort.SearchStrings(tickets, ticket)
+		l.position = position
+	}
+	if position != 0 {
+		return ErrLocked
+	}
+
+	lock, err := l.kv.Lock(lockKey(l.key), l.ttl)
+	if err != nil {
+		// the kv drivers do not distinguish contention from other errors, so
+		// if the kv is reachable assume the lock is held
+		if l.kv.Ping() == nil {
+			return ErrLocked
+		}
+		return err
+	}
+
+	holder, err := l.kv.EphemeralKey(holderKey(l.key), l.ttl)
+	if err != nil {
+		_ = lock.Unlock()
+		return err
+	}
+
+	l.info = newHolder(l.id, l.ttl)
+	l.info.Acquired = time.Now()
+	l.info.Renewed = l.info.Acquired
+	if err := setHolder(holder, l.info); err != nil {
+		_ = holder.Destroy()
+		_ = lock.Unlock()
+		return err
+	}
+
+	l.lock = lock
+	l.holder = holder
+	l.position = -1
+	l.stop = make(chan struct{})
+	l.lost = make(chan struct{})
+	go keepAlive(&l.mu, l.ttl, l.renew, l.stop, l.lost)
+	return nil
+}
+
+// Acquire blocks until the lock is acquired or timeout elapses. A timeout of
+// 0 waits forever. A contended lock is obtained in the order Acquire was
+// called, see Position.
+func (l *Lock) Acquire(timeout time.Duration) error {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	if err := l.TryAcquire(); err != ErrLocked {
+		return err
+	}
+
+	ticket, waiter, err := l.enqueue()
+	if err != nil {
+		return err
+	}
+	defer l.dequeue(ticket, waiter)
+
+	for {
+		err := l.tryAcquire(ticket)
+		if err != ErrLocked {
+			return err
+		}
+
+		select {
+		case <-deadline:
+			return ErrTimeout
+		case <-time.After(PollInterval):
+		}
+
+		// keep our place in the queue
+		if err := waiter.Renew(); err != nil {
+			return err
+		}
+	}
+}
+
+// Position returns the number of clients queued ahead of l while it waits in
+// Acquire, as of its last attempt, or -1 if it is not waiting
+func (l *Lock) Position() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.position
+}
+
+// enqueue takes a ticket and adds l to the queue of waiters. The queue entry
+// is ephemeral, so waiters that die leave the queue once their ttl expires.
+func (l *Lock) enqueue() (string, kv.EphemeralKey, error) {
+	n, err := nextTicket(l.kv, l.key)
+	if err != nil {
+		return "", nil, err
+	}
+	// zero padded so that tickets sort in order
+	ticket := fmt.Sprintf("%020d", n)
+
+	waiter, err := l.kv.EphemeralKey(path.Join(queueKey(l.key), ticket), l.ttl)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := setHolder(waiter, newHolder(l.id, l.ttl)); err != nil {
+		_ = waiter.Destroy()
+		return "", nil, err
+	}
+	return ticket, waiter, nil
+}
+
+// dequeue removes l from the queue of waiters
+func (l *Lock) dequeue(ticket string, waiter kv.EphemeralKey) {
+	_ = waiter.Destroy()
+	_ = l.kv.Delete(path.Join(queueKey(l.key), ticket), false)
+
+	l.mu.Lock()
+	l.position = -1
+	l.mu.Unlock()
+}
+
+// nextTicket atomically increments and returns the ticket counter of key
+func nextTicket(k kv.KV, key string) (uint64, error) {
+	for {
+		value, err := k.Get(ticketKey(key))
+		if err != nil && !k.IsKeyNotFound(err) {
+			return 0, err
+		}
+
+		var n uint64
+		if err == nil {
+			n, err = strconv.ParseUint(string(value.Data), 10, 64)
+			if err != nil {
+				return 0, err
+			}
+		}
+		n++
+
+		value.Data = []byte(strconv.FormatUint(n, 10))
+		if _, err := k.Update(ticketKey(key), value); err != nil {
+			// as with locks, a reachable kv means someone else took the
+			// ticket first
+			if k.Ping() == nil {
+				continue
+			}
+			return 0, err
+		}
+		return n, nil
+	}
+}
+
+// queued returns the sorted tickets of the clients waiting for the lock on key
+func queued(k kv.KV, key string) ([]string, error) {
+	keys, err := k.Keys(queueKey(key))
+	if err != nil {
+		if k.IsKeyNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	tickets := make([]string, 0, len(keys))
+	for _, key := range keys {
+		tickets = append(tickets, path.Base(key))
+	}
+	sort.Strings(tickets)
+	return tickets, nil
+}
+
+// Renew refreshes the ttl of a held lock. Held locks are renewed in the
+// background, but callers may renew before acting on the protected resource
+// to confirm the lock is still held.
+func (l *Lock) Renew() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.renew()
+}
+
+// renew refreshes the ttl of the lock and holder. mu must be held.
+func (l *Lock) renew() error {
+	if l.lock == nil {
+		return ErrNotHeld
+	}
+
+	if err := l.lock.Renew(); err != nil {
+		return err
+	}
+	l.info.Renewed = time.Now()
+	return setHolder(l.holder, l.info)
+}
+
+// Release releases a held lock
+func (l *Lock) Release() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.lock == nil {
+		return ErrNotHeld
+	}
+
+	close(l.stop)
+	// not every driver deletes a destroyed ephemeral key, so remove the
+	// holder info explicitly before anyone else can acquire the lock
+	_ = l.holder.Destroy()
+	_ = l.kv.Delete(holderKey(l.key), false)
+	err := l.lock.Unlock()
+	l.lock = nil
+	l.holder = nil
+	return err
+}
+
+// Lost returns a channel that is closed if a held lock could not be renewed
+// and may have been taken by someone else. It is nil if the lock is not held.
+func (l *Lock) Lost() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.lock == nil {
+		return nil
+	}
+	return l.lost
+}
+
+// keepAlive calls renew, with mu held, every third of ttl until stop is
+// closed, closing lost if a renewal fails
+func keepAlive(mu *sync.Mutex, ttl time.Duration, renew func() error, stop, lost chan struct{}) {
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			mu.Lock()
+			select {
+			case <-stop:
+				mu.Unlock()
+				return
+			default:
+			}
+			err := renew()
+			mu.Unlock()
+			if err != nil {
+				close(lost)
+				return
+			}
+		}
+	}
+}
+
+// newHolder describes this process as a client of a lock
+func newHolder(id string, ttl time.Duration) Holder {
+	hostname, _ := os.Hostname()
+	return Holder{
+		ID:       id,
+		Hostname: hostname,
+		PID:      os.Getpid(),
+		TTL:      ttl,
+	}
+}
+
+// setHolder stores the holder info in the ephemeral holder key
+func setHolder(holder kv.EphemeralKey, info Holder) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return holder.Set(string(data))
+}
+
+// GetHolder returns the current holder of the lock on key, or nil if the lock
+// is not held
+func GetHolder(k kv.KV, key string) (*Holder, error) {
+	return getHolder(k, holderKey(key))
+}
@@ -0,0 +1,187 @@
+package lock_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mistifyio/lochness/internal/tests/common"
+	_ "github.com/mistifyio/lochness/pkg/kv/consul"
+	"github.com/mistifyio/lochness/pkg/lock"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestLock(t *testing.T) {
+	suite.Run(t, new(LockSuite))
+}
+
+type LockSuite struct {
+	common.Suite
+	Key string
+}
+
+func (s *LockSuite) SetupSuite() {
+	s.KVPort = 54555
+	s.TestPrefix = "lock-test"
+	s.Suite.SetupSuite()
+	lock.PollInterval = 100 * time.Millisecond
+}
+
+func (s *LockSuite) SetupTest() {
+	s.Suite.SetupTest()
+	s.Key = filepath.Join(s.KVPrefix, "locks", "test")
+}
+
+func (s *LockSuite) newLock() *lock.Lock {
+	l, err := lock.New(s.KV, s.Key, time.Second)
+	s.Require().NoError(err)
+	return l
+}
+
+func (s *LockSuite) TestNew() {
+	tests := []struct {
+		description string
+		key         string
+		ttl         time.Duration
+		expectedErr bool
+	}{
+		{"missing key", "", time.Second, true},
+		{"short ttl", s.Key, time.Millisecond, true},
+		{"valid", s.Key, time.Second, false},
+	}
+
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		l, err := lock.New(s.KV, test.key, test.ttl)
+		if test.expectedErr {
+			s.Error(err, msg("should error"))
+			s.Nil(l, msg("should not return a lock"))
+		} else {
+			s.NoError(err, msg("should not error"))
+			s.Equal(test.key, l.Key(), msg("should have the key"))
+		}
+	}
+
+	_, err := lock.New(nil, s.Key, time.Second)
+	s.Error(err, "nil kv should error")
+}
+
+func (s *LockSuite) TestTryAcquire() {
+	l1 := s.newLock()
+	l2 := s.newLock()
+
+	s.NoError(l1.TryAcquire())
+	s.NoError(l1.TryAcquire(), "reacquiring a held lock should not error")
+	s.Equal(lock.ErrLocked, l2.TryAcquire())
+
+	s.NoError(l1.Release())
+	s.NoError(l2.TryAcquire())
+	s.NoError(l2.Release())
+}
+
+func (s *LockSuite) TestAcquire() {
+	l1 := s.newLock()
+	l2 := s.newLock()
+
+	s.NoError(l1.Acquire(0))
+	s.Equal(lock.ErrTimeout, l2.Acquire(300*time.Millisecond))
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		_ = l1.Release()
+	}()
+	s.NoError(l2.Acquire(5 * time.Second))
+	s.NoError(l2.Release())
+}
+
+func (s *LockSuite) TestAcquireOrder() {
+	l1 := s.newLock()
+	l2 := s.newLock()
+	l3 := s.newLock()
+	s.Equal(-1, l2.Position(), "should not be waiting")
+
+	s.NoError(l1.Acquire(0))
+
+	acquired := make(chan *lock.Lock, 2)
+	wait := func(l *lock.Lock, position int) {
+		go func() {
+			if l.Acquire(5*time.Second) == nil {
+				acquired <- l
+			}
+		}()
+		s.Require().True(s.waitFor(func() bool { return l.Position() == position }),
+			"should be queued at %d", position)
+	}
+	wait(l2, 0)
+	wait(l3, 1)
+	s.Equal(lock.ErrLocked, s.newLock().TryAcquire(), "should not jump the queue")
+
+	s.NoError(l1.Release())
+	s.Equal(l2, <-acquired, "first waiter should acquire first")
+	s.Equal(-1, l2.Position())
+	s.True(s.waitFor(func() bool { return l3.Position() == 0 }), "should move up the queue")
+
+	s.NoError(l2.Release())
+	s.Equal(l3, <-acquired)
+	s.NoError(l3.Release())
+}
+
+// waitFor polls cond until it is true or a few poll intervals have passed
+func (s *LockSuite) waitFor(cond func() bool) bool {
+	for i := 0; i < 50; i++ {
+		if cond() {
+			return true
+		}
+		time.Sleep(lock.PollInterval / 10)
+	}
+	return false
+}
+
+func (s *LockSuite) TestKeepAlive() {
+	l1 := s.newLock()
+	l2 := s.newLock()
+
+	s.NoError(l1.TryAcquire())
+	// outlive several ttls
+	time.Sleep(3 * time.Second)
+	s.Equal(lock.ErrLocked, l2.TryAcquire(), "held lock should be kept alive")
+
+	select {
+	case <-l1.Lost():
+		s.Fail("lock should not be lost")
+	default:
+	}
+	s.NoError(l1.Renew())
+	s.NoError(l1.Release())
+}
+
+func (s *LockSuite) TestRelease() {
+	l := s.newLock()
+	s.Equal(lock.ErrNotHeld, l.Release())
+	s.Equal(lock.ErrNotHeld, l.Renew())
+	s.Nil(l.Lost())
+
+	s.NoError(l.TryAcquire())
+	s.NoError(l.Release())
+	s.Equal(lock.ErrNotHeld, l.Release())
+}
+
+func (s *LockSuite) TestGetHolder() {
+	holder, err := lock.GetHolder(s.KV, s.Key)
+	s.NoError(err)
+	s.Nil(holder, "unheld lock should have no holder")
+
+	l := s.newLock()
+	s.NoError(l.TryAcquire())
+	holder, err = lock.GetHolder(s.KV, s.Key)
+	s.NoError(err)
+	s.Equal(l.ID(), holder.ID)
+	s.Equal(os.Getpid(), holder.PID)
+	s.Equal(time.Second, holder.TTL)
+
+	s.NoError(l.Release())
+	holder, err = lock.GetHolder(s.KV, s.Key)
+	s.NoError(err)
+	s.Nil(holder, "released lock should have no holder")
+}
@@ -0,0 +1,233 @@
+package lock
+
+import (
+	"errors"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/mistifyio/lochness/pkg/kv"
+	"github.com/pborman/uuid"
+)
+
+var errHeld = errors.New("lock is already held")
+
+// RWLock is a lock on a kv key that is either held shared by any number of
+// readers or exclusively by one writer. Writers are preferred: new readers wait
+// while a writer holds or is waiting for the lock. The exclusive side is a Lock
+// on the same key, so writers are queued in order and exclude plain Locks too.
+type RWLock struct {
+	kv    kv.KV
+	key   string
+	ttl   time.Duration
+	id    string
+	write *Lock
+
+	mu     sync.Mutex // mu protects the following vars
+	reader kv.EphemeralKey
+	info   Holder
+	stop   chan struct{}
+	lost   chan struct{}
+}
+
+// NewRW creates a new, unheld, RWLock on key. A held lock expires if it is not
+// renewed within ttl.
+func NewRW(k kv.KV, key string, ttl time.Duration) (*RWLock, error) {
+	write, err := New(k, key, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RWLock{
+		kv:    k,
+		key:   key,
+		ttl:   ttl,
+		id:    uuid.New(),
+		write: write,
+	}, nil
+}
+
+// readersKey is a helper for generating the key of the directory of readers
+func readersKey(key string) string {
+	return path.Join(key, "readers")
+}
+
+// Key returns the key of the lock
+func (rw *RWLock) Key() string {
+	return rw.key
+}
+
+// ID returns the unique id used for this lock in Holder info
+func (rw *RWLock) ID() string {
+	return rw.id
+}
+
+// TryAcquireShared attempts to acquire the lock shared without blocking.
+// ErrLocked is returned if a writer holds or is waiting for the lock.
+func (rw *RWLock) TryAcquireShared() error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if rw.reader != nil {
+		return nil
+	}
+	if rw.write.Lost() != nil {
+		return errHeld
+	}
+
+	key := path.Join(readersKey(rw.key), rw.id)
+	reader, err := rw.kv.EphemeralKey(key, rw.ttl)
+	if err != nil {
+		return err
+	}
+	info := newHolder(rw.id, rw.ttl)
+	info.Acquired = time.Now()
+	info.Renewed = info.Acquired
+	if err := setHolder(reader, info); err != nil {
+		_ = reader.Destroy()
+		return err
+	}
+
+	// the reader is registered before looking for writers, and writers look
+	// for readers only once they are the holder, so one always sees the other
+	busy, err := writerActive(rw.kv, rw.key)
+	if err != nil || busy {
+		_ = reader.Destroy()
+		_ = rw.kv.Delete(key, false)
+		if err != nil {
+			return err
+		}
+		return ErrLocked
+	}
+
+	rw.reader = reader
+	rw.info = info
+	rw.stop = make(chan struct{})
+	rw.lost = make(chan struct{})
+	go keepAlive(&rw.mu, rw.ttl, rw.renewShared, rw.stop, rw.lost)
+	return nil
+}
+
+// AcquireShared blocks until the lock is acquired shared or timeout elapses. A
+// timeout of 0 waits forever.
+func (rw *RWLock) AcquireShared(timeout time.Duration) error {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	for {
+		err := rw.TryAcquireShared()
+		if err != ErrLocked {
+			return err
+		}
+
+		select {
+		case <-deadline:
+			return ErrTimeout
+		case <-time.After(PollInterval):
+		}
+	}
+}
+
+// AcquireExclusive blocks until the lock is acquired exclusively or timeout
+// elapses. A timeout of 0 waits forever. Once the writer holds the lock, new
+// readers are turned away while it waits for current readers to release it.
+func (rw *RWLock) AcquireExclusive(timeout time.Duration) error {
+	rw.mu.Lock()
+	shared := rw.reader != nil
+	rw.mu.Unlock()
+	if shared {
+		return errHeld
+	}
+
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	if err := rw.write.Acquire(timeout); err != nil {
+		return err
+	}
+
+	for {
+		readers, err := rw.kv.Keys(readersKey(rw.key))
+		if err != nil && !rw.kv.IsKeyNotFound(err) {
+			_ = rw.write.Release()
+			return err
+		}
+		if len(readers) == 0 {
+			return nil
+		}
+
+		select {
+		case <-deadline:
+			_ = rw.write.Release()
+			return ErrTimeout
+		case <-time.After(PollInterval):
+		}
+	}
+}
+
+// Renew refreshes the ttl of the lock in whichever mode it is held. Held locks
+// are renewed in the background.
+func (rw *RWLock) Renew() error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if rw.reader != nil {
+		return rw.renewShared()
+	}
+	return rw.write.Renew()
+}
+
+// renewShared refreshes the ttl of the reader. mu must be held.
+func (rw *RWLock) renewShared() error {
+	if rw.reader == nil {
+		return ErrNotHeld
+	}
+	rw.info.Renewed = time.Now()
+	return setHolder(rw.reader, rw.info)
+}
+
+// Release releases the lock in whichever mode it is held
+func (rw *RWLock) Release() error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if rw.reader == nil {
+		return rw.write.Release()
+	}
+
+	close(rw.stop)
+	err := rw.reader.Destroy()
+	_ = rw.kv.Delete(path.Join(readersKey(rw.key), rw.id), false)
+	rw.reader = nil
+	return err
+}
+
+// Lost returns a channel that is closed if a held lock could not be renewed
+// and may have been taken by someone else. It is nil if the lock is not held.
+func (rw *RWLock) Lost() <-chan struct{} {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if rw.reader != nil {
+		return rw.lost
+	}
+	return rw.write.Lost()
+}
+
+// writerActive reports whether a writer holds or is queued for the lock on key
+func writerActive(k kv.KV, key string) (bool, error) {
+	holder, err := GetHolder(k, key)
+	if err != nil || holder != nil {
+		return holder != nil, err
+	}
+	tickets, err := queued(k, key)
+	return len(tickets) > 0, err
+}
@@ -0,0 +1,139 @@
+package lock_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/mistifyio/lochness/pkg/lock"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestRWLock(t *testing.T) {
+	suite.Run(t, new(RWLockSuite))
+}
+
+type RWLockSuite struct {
+	common.Suite
+	Key string
+}
+
+func (s *RWLockSuite) SetupSuite() {
+	s.TestPrefix = "rwlock-test"
+	s.Suite.SetupSuite()
+	lock.PollInterval = 100 * time.Millisecond
+}
+
+func (s *RWLockSuite) SetupTest() {
+	s.Suite.SetupTest()
+	s.Key = filepath.Join(s.KVPrefix, "locks", "rwtest")
+}
+
+func (s *RWLockSuite) newRWLock() *lock.RWLock {
+	rw, err := lock.NewRW(s.KV, s.Key, time.Second)
+	s.Require().NoError(err)
+	return rw
+}
+
+func (s *RWLockSuite) TestNewRW() {
+	_, err := lock.NewRW(s.KV, "", time.Second)
+	s.Error(err, "missing key should error")
+	_, err = lock.NewRW(nil, s.Key, time.Second)
+	s.Error(err, "nil kv should error")
+
+	rw := s.newRWLock()
+	s.Equal(s.Key, rw.Key())
+	s.NotEmpty(rw.ID())
+}
+
+func (s *RWLockSuite) TestShared() {
+	r1 := s.newRWLock()
+	r2 := s.newRWLock()
+	w := s.newRWLock()
+
+	s.NoError(r1.AcquireShared(0))
+	s.NoError(r2.TryAcquireShared(), "readers should share the lock")
+	s.NoError(r2.TryAcquireShared(), "reacquiring a held lock should not error")
+	s.Equal(lock.ErrTimeout, w.AcquireExclusive(300*time.Millisecond), "readers should exclude writers")
+
+	holder, err := lock.GetHolder(s.KV, s.Key)
+	s.NoError(err)
+	s.Nil(holder, "timed out writer should not hold the lock")
+
+	s.NoError(r1.Release())
+	s.NoError(r2.Release())
+	s.Equal(lock.ErrNotHeld, r2.Release())
+
+	s.NoError(w.AcquireExclusive(time.Second))
+	s.NoError(w.Release())
+}
+
+func (s *RWLockSuite) TestExclusive() {
+	w := s.newRWLock()
+	r := s.newRWLock()
+	l, err := lock.New(s.KV, s.Key, time.Second)
+	s.Require().NoError(err)
+
+	s.NoError(w.AcquireExclusive(0))
+	s.Equal(lock.ErrLocked, r.TryAcquireShared(), "writer should exclude readers")
+	s.Equal(lock.ErrLocked, l.TryAcquire(), "writer should exclude plain locks")
+	s.Error(w.TryAcquireShared(), "writer should not also read")
+
+	s.NoError(w.Release())
+	s.NoError(r.TryAcquireShared())
+	s.Error(r.AcquireExclusive(time.Second), "reader should not upgrade")
+	s.NoError(r.Release())
+}
+
+func (s *RWLockSuite) TestWriterPreference() {
+	r1 := s.newRWLock()
+	r2 := s.newRWLock()
+	w := s.newRWLock()
+
+	s.NoError(r1.AcquireShared(0))
+
+	acquired := make(chan error, 1)
+	go func() {
+		acquired <- w.AcquireExclusive(5 * time.Second)
+	}()
+
+	// wait for the writer to hold the lock and wait on the reader
+	for i := 0; i < 50; i++ {
+		if holder, _ := lock.GetHolder(s.KV, s.Key); holder != nil {
+			break
+		}
+		time.Sleep(lock.PollInterval / 10)
+	}
+	s.Equal(lock.ErrLocked, r2.TryAcquireShared(), "waiting writer should turn away new readers")
+
+	s.NoError(r1.Release())
+	s.NoError(<-acquired, "writer should acquire once readers are gone")
+	s.NoError(w.Release())
+	s.NoError(r2.TryAcquireShared())
+	s.NoError(r2.Release())
+}
+
+func (s *RWLockSuite) TestKeepAlive() {
+	r := s.newRWLock()
+	w := s.newRWLock()
+
+	s.NoError(r.TryAcquireShared())
+	// outlive several ttls
+	time.Sleep(3 * time.Second)
+	s.Equal(lock.ErrTimeout, w.AcquireExclusive(200*time.Millisecond), "shared lock should be kept alive")
+	select {
+	case <-r.Lost():
+		s.Fail("shared lock should not be lost")
+	default:
+	}
+	s.NoError(r.Renew())
+	s.NoError(r.Release())
+
+	s.NoError(w.AcquireExclusive(0))
+	s.NoError(w.Renew())
+	s.NotNil(w.Lost())
+	s.NoError(w.Release())
+	s.Equal(lock.ErrNotHeld, w.Renew())
+	s.Nil(w.Lost())
+}
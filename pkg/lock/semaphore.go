@@ -0,0 +1,215 @@
+package lock
+
+import (
+	"errors"
+	"math/rand"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mistifyio/lochness/pkg/kv"
+	"github.com/pborman/uuid"
+)
+
+// Semaphore is a lock on a kv key that up to size clients may hold at once.
+// Each holder holds one of size slots, each a Lock on a key under the
+// semaphore's key, so held slots are kept alive and record their holder as
+// Locks do. Unlike Lock, waiting clients are not queued; whichever finds a free
+// slot first gets it.
+type Semaphore struct {
+	kv   kv.KV
+	key  string
+	size int
+	ttl  time.Duration
+	id   string
+
+	mu   sync.Mutex // mu protects the following vars
+	slot *Lock
+	n    int
+}
+
+// NewSemaphore creates a new, unheld, Semaphore on key with size slots. A
+// held slot expires if it is not renewed within ttl. Every client of a
+// semaphore should use the same size.
+func NewSemaphore(k kv.KV, key string, size int, ttl time.Duration) (*Semaphore, error) {
+	if k == nil {
+		return nil, errors.New("kv must not be nil")
+	}
+	if key == "" {
+		return nil, errors.New("missing key")
+	}
+	if size < 1 {
+		return nil, errors.New("size must be at least 1")
+	}
+	if ttl < time.Second {
+		return nil, errors.New("ttl must be at least 1s")
+	}
+
+	return &Semaphore{
+		kv:   k,
+		key:  key,
+		size: size,
+		ttl:  ttl,
+		id:   uuid.New(),
+		n:    -1,
+	}, nil
+}
+
+// slotKey is a helper for generating the key of the lock on slot n
+func slotKey(key string, n int) string {
+	return path.Join(key, strconv.Itoa(n))
+}
+
+// Key returns the key of the semaphore
+func (s *Semaphore) Key() string {
+	return s.key
+}
+
+// ID returns the unique id used for this semaphore in Holder info
+func (s *Semaphore) ID() string {
+	return s.id
+}
+
+// Size returns the number of slots of the semaphore
+func (s *Semaphore) Size() int {
+	return s.size
+}
+
+// Slot returns the number of the held slot, or -1 if none is held
+func (s *Semaphore) Slot() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.n
+}
+
+// TryAcquire attempts to acquire a slot without blocking. ErrLocked is
+// returned if every slot is held.
+func (s *Semaphore) TryAcquire() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.slot != nil {
+		return nil
+	}
+
+	// start at a random slot so waiting clients don't all contend for the first
+	offset := rand.Intn(s.size)
+	for i := 0; i < s.size; i++ {
+		n := (offset + i) % s.size
+		l, err := New(s.kv, slotKey(s.key, n), s.ttl)
+		if err != nil {
+			return err
+		}
+		l.id = s.id
+		err = l.TryAcquire()
+		if err == nil {
+			s.slot = l
+			s.n = n
+			return nil
+		}
+		if err != ErrLocked {
+			return err
+		}
+	}
+	return ErrLocked
+}
+
+// Acquire blocks until a slot is acquired or timeout elapses. A timeout of 0
+// waits forever.
+func (s *Semaphore) Acquire(timeout time.Duration) error {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	for {
+		err := s.TryAcquire()
+		if err != ErrLocked {
+			return err
+		}
+
+		select {
+		case <-deadline:
+			return ErrTimeout
+		case <-time.After(PollInterval):
+		}
+	}
+}
+
+// Renew refreshes the ttl of the held slot. Held slots are renewed in the
+// background.
+func (s *Semaphore) Renew() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.slot == nil {
+		return ErrNotHeld
+	}
+	return s.slot.Renew()
+}
+
+// Release releases the held slot
+func (s *Semaphore) Release() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.slot == nil {
+		return ErrNotHeld
+	}
+	err := s.slot.Release()
+	s.slot = nil
+	s.n = -1
+	return err
+}
+
+// Lost returns a channel that is closed if the held slot could not be renewed
+// and may have been taken by someone else. It is nil if no slot is held.
+func (s *Semaphore) Lost() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.slot == nil {
+		return nil
+	}
+	return s.slot.Lost()
+}
+
+// GetHolders returns the current holders of the slots of the semaphore on key,
+// ordered by slot
+func GetHolders(k kv.KV, key string) ([]Holder, error) {
+	keys, err := k.Keys(key)
+	if err != nil {
+		if k.IsKeyNotFound(err) {
+			return []Holder{}, nil
+		}
+		return nil, err
+	}
+
+	slots := make([]int, 0, len(keys))
+	for _, slot := range keys {
+		n, err := strconv.Atoi(path.Base(strings.TrimSuffix(slot, "/")))
+		if err != nil {
+			continue
+		}
+		slots = append(slots, n)
+	}
+	sort.Ints(slots)
+
+	holders := make([]Holder, 0, len(slots))
+	for _, n := range slots {
+		holder, err := GetHolder(k, slotKey(key, n))
+		if err != nil {
+			return nil, err
+		}
+		if holder != nil {
+			holders = append(holders, *holder)
+		}
+	}
+	return holders, nil
+}
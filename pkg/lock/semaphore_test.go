@@ -0,0 +1,133 @@
+package lock_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/mistifyio/lochness/pkg/lock"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestSemaphore(t *testing.T) {
+	suite.Run(t, new(SemaphoreSuite))
+}
+
+type SemaphoreSuite struct {
+	common.Suite
+	Key string
+}
+
+func (s *SemaphoreSuite) SetupSuite() {
+	s.TestPrefix = "semaphore-test"
+	s.Suite.SetupSuite()
+	lock.PollInterval = 100 * time.Millisecond
+}
+
+func (s *SemaphoreSuite) SetupTest() {
+	s.Suite.SetupTest()
+	s.Key = filepath.Join(s.KVPrefix, "semaphores", "test")
+}
+
+func (s *SemaphoreSuite) newSemaphore() *lock.Semaphore {
+	sem, err := lock.NewSemaphore(s.KV, s.Key, 2, time.Second)
+	s.Require().NoError(err)
+	return sem
+}
+
+func (s *SemaphoreSuite) TestNewSemaphore() {
+	tests := []struct {
+		description string
+		key         string
+		size        int
+		ttl         time.Duration
+		expectedErr bool
+	}{
+		{"missing key", "", 2, time.Second, true},
+		{"zero size", s.Key, 0, time.Second, true},
+		{"short ttl", s.Key, 2, time.Millisecond, true},
+		{"valid", s.Key, 2, time.Second, false},
+	}
+
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		sem, err := lock.NewSemaphore(s.KV, test.key, test.size, test.ttl)
+		if test.expectedErr {
+			s.Error(err, msg("should error"))
+			s.Nil(sem, msg("should not return a semaphore"))
+		} else {
+			s.NoError(err, msg("should not error"))
+			s.Equal(test.key, sem.Key(), msg("should have the key"))
+			s.Equal(test.size, sem.Size(), msg("should have the size"))
+			s.Equal(-1, sem.Slot(), msg("should not hold a slot"))
+		}
+	}
+}
+
+func (s *SemaphoreSuite) TestSlots() {
+	first := s.newSemaphore()
+	second := s.newSemaphore()
+	third := s.newSemaphore()
+
+	s.NoError(first.TryAcquire())
+	s.NoError(first.TryAcquire(), "reacquiring a held slot should not error")
+	s.NoError(second.Acquire(0))
+	s.NotEqual(first.Slot(), second.Slot(), "should hold different slots")
+	s.NotNil(first.Lost())
+
+	s.Equal(lock.ErrLocked, third.TryAcquire(), "should not exceed the size")
+	s.Equal(lock.ErrTimeout, third.Acquire(300*time.Millisecond))
+
+	acquired := make(chan error, 1)
+	go func() { acquired <- third.Acquire(5 * time.Second) }()
+	time.Sleep(200 * time.Millisecond)
+	s.NoError(first.Release())
+	s.NoError(<-acquired, "should get the released slot")
+	s.Equal(lock.ErrNotHeld, first.Release())
+
+	s.NoError(second.Release())
+	s.NoError(third.Release())
+}
+
+func (s *SemaphoreSuite) TestRenew() {
+	sem := s.newSemaphore()
+	s.Equal(lock.ErrNotHeld, sem.Renew())
+	s.Nil(sem.Lost())
+
+	s.Require().NoError(sem.TryAcquire())
+	defer func() { _ = sem.Release() }()
+	s.NoError(sem.Renew())
+
+	// held slots are kept alive past the ttl
+	time.Sleep(1500 * time.Millisecond)
+	fill := s.newSemaphore()
+	s.Require().NoError(fill.TryAcquire())
+	defer func() { _ = fill.Release() }()
+	s.Equal(lock.ErrLocked, s.newSemaphore().TryAcquire(), "should still hold a slot")
+}
+
+func (s *SemaphoreSuite) TestGetHolders() {
+	holders, err := lock.GetHolders(s.KV, s.Key)
+	s.NoError(err)
+	s.Empty(holders)
+
+	first := s.newSemaphore()
+	second := s.newSemaphore()
+	s.Require().NoError(first.TryAcquire())
+	s.Require().NoError(second.TryAcquire())
+
+	holders, err = lock.GetHolders(s.KV, s.Key)
+	s.NoError(err)
+	s.Len(holders, 2)
+	ids := []string{holders[0].ID, holders[1].ID}
+	s.Contains(ids, first.ID())
+	s.Contains(ids, second.ID())
+
+	s.NoError(first.Release())
+	holders, err = lock.GetHolders(s.KV, s.Key)
+	s.NoError(err)
+	s.Require().Len(holders, 1)
+	s.Equal(second.ID(), holders[0].ID)
+	s.NoError(second.Release())
+}
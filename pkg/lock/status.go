@@ -0,0 +1,149 @@
+package lock
+
+import (
+	"encoding/json"
+	"errors"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mistifyio/lochness/pkg/kv"
+)
+
+// Status describes the state of the lock on a key, for debugging contention
+type Status struct {
+	Key  string `json:"key"`
+	Held bool   `json:"held"`
+	// Holder is the client holding the lock exclusively, if held
+	Holder *Holder `json:"holder,omitempty"`
+	// Expires is when the lock expires unless its holder renews it
+	Expires *time.Time `json:"expires,omitempty"`
+	// TTLRemaining is the time left until Expires
+	TTLRemaining time.Duration `json:"ttl_remaining,omitempty"`
+	// Waiters are the clients queued in Acquire, in the order they will get
+	// the lock
+	Waiters []Holder `json:"waiters"`
+	// Readers are the clients holding a RWLock shared
+	Readers []Holder `json:"readers"`
+}
+
+// Inspect returns the status of the lock on key. A lock that is not held has
+// no Holder.
+func Inspect(k kv.KV, key string) (*Status, error) {
+	if k == nil {
+		return nil, errors.New("kv must not be nil")
+	}
+	if key == "" {
+		return nil, errors.New("missing key")
+	}
+
+	status := &Status{
+		Key:     key,
+		Waiters: []Holder{},
+		Readers: []Holder{},
+	}
+
+	holder, err := GetHolder(k, key)
+	if err != nil {
+		return nil, err
+	}
+	if holder != nil {
+		status.Held = true
+		status.Holder = holder
+		expires := holder.Renewed.Add(holder.TTL)
+		status.Expires = &expires
+		if remaining := expires.Sub(time.Now()); remaining > 0 {
+			status.TTLRemaining = remaining
+		}
+	}
+
+	tickets, err := queued(k, key)
+	if err != nil {
+		return nil, err
+	}
+	for _, ticket := range tickets {
+		waiter, err := getHolder(k, path.Join(queueKey(key), ticket))
+		if err != nil {
+			return nil, err
+		}
+		if waiter != nil {
+			status.Waiters = append(status.Waiters, *waiter)
+		}
+	}
+
+	readers, err := k.Keys(readersKey(key))
+	if err != nil && !k.IsKeyNotFound(err) {
+		return nil, err
+	}
+	sort.Strings(readers)
+	for _, reader := range readers {
+		r, err := getHolder(k, reader)
+		if err != nil {
+			return nil, err
+		}
+		if r != nil {
+			status.Readers = append(status.Readers, *r)
+		}
+	}
+
+	return status, nil
+}
+
+// List returns the sorted keys of the locks under prefix that are held, have
+// been held, or are waited for
+func List(k kv.KV, prefix string) ([]string, error) {
+	if k == nil {
+		return nil, errors.New("kv must not be nil")
+	}
+
+	values, err := k.GetAll(prefix)
+	if err != nil {
+		if k.IsKeyNotFound(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+
+	locks := map[string]struct{}{}
+	for key := range values {
+		key = strings.Trim(key, "/")
+		dir, base := path.Split(key)
+		dir = strings.TrimSuffix(dir, "/")
+		switch {
+		case base == "lock" || base == "holder" || base == "ticket":
+			locks[dir] = struct{}{}
+		case path.Base(dir) == "queue" || path.Base(dir) == "readers":
+			locks[path.Dir(dir)] = struct{}{}
+		}
+	}
+
+	keys := make([]string, 0, len(locks))
+	for key := range locks {
+		if key != "" && key != "." {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// getHolder reads the Holder info stored in key, or nil if there is none
+func getHolder(k kv.KV, key string) (*Holder, error) {
+	value, err := k.Get(key)
+	if err != nil {
+		if k.IsKeyNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if len(value.Data) == 0 {
+		return nil, nil
+	}
+
+	holder := &Holder{}
+	if err := json.Unmarshal(value.Data, holder); err != nil {
+		return nil, err
+	}
+	return holder, nil
+}
@@ -0,0 +1,95 @@
+package lock_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/mistifyio/lochness/pkg/lock"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestStatus(t *testing.T) {
+	suite.Run(t, new(StatusSuite))
+}
+
+type StatusSuite struct {
+	common.Suite
+	Key string
+}
+
+func (s *StatusSuite) SetupSuite() {
+	s.TestPrefix = "status-test"
+	s.Suite.SetupSuite()
+	lock.PollInterval = 100 * time.Millisecond
+}
+
+func (s *StatusSuite) SetupTest() {
+	s.Suite.SetupTest()
+	s.Key = filepath.Join(s.KVPrefix, "locks", "status")
+}
+
+func (s *StatusSuite) TestInspect() {
+	_, err := lock.Inspect(s.KV, "")
+	s.Error(err, "missing key should error")
+
+	status, err := lock.Inspect(s.KV, s.Key)
+	s.Require().NoError(err)
+	s.False(status.Held)
+	s.Nil(status.Holder)
+	s.Empty(status.Waiters)
+
+	l, err := lock.New(s.KV, s.Key, 2*time.Second)
+	s.Require().NoError(err)
+	s.Require().NoError(l.TryAcquire())
+	defer func() { _ = l.Release() }()
+
+	waiter, err := lock.New(s.KV, s.Key, 2*time.Second)
+	s.Require().NoError(err)
+	go func() { _ = waiter.Acquire(500 * time.Millisecond) }()
+	time.Sleep(200 * time.Millisecond)
+
+	status, err = lock.Inspect(s.KV, s.Key)
+	s.Require().NoError(err)
+	s.True(status.Held)
+	s.Require().NotNil(status.Holder)
+	s.Equal(l.ID(), status.Holder.ID)
+	s.NotNil(status.Expires)
+	s.True(status.TTLRemaining > 0 && status.TTLRemaining <= 2*time.Second)
+	s.Require().Len(status.Waiters, 1)
+	s.Equal(waiter.ID(), status.Waiters[0].ID)
+}
+
+func (s *StatusSuite) TestInspectReaders() {
+	rw, err := lock.NewRW(s.KV, s.Key, time.Second)
+	s.Require().NoError(err)
+	s.Require().NoError(rw.TryAcquireShared())
+	defer func() { _ = rw.Release() }()
+
+	status, err := lock.Inspect(s.KV, s.Key)
+	s.Require().NoError(err)
+	s.False(status.Held)
+	s.Require().Len(status.Readers, 1)
+	s.Equal(rw.ID(), status.Readers[0].ID)
+}
+
+func (s *StatusSuite) TestList() {
+	keys, err := lock.List(s.KV, s.KVPrefix)
+	s.NoError(err)
+	s.Empty(keys)
+
+	first := filepath.Join(s.KVPrefix, "locks", "a")
+	second := filepath.Join(s.KVPrefix, "locks", "b")
+	for _, key := range []string{first, second} {
+		l, err := lock.New(s.KV, key, time.Second)
+		s.Require().NoError(err)
+		s.Require().NoError(l.TryAcquire())
+		defer func() { _ = l.Release() }()
+	}
+	s.Require().NoError(s.KV.Set(filepath.Join(s.KVPrefix, "other"), "x"))
+
+	keys, err = lock.List(s.KV, s.KVPrefix)
+	s.NoError(err)
+	s.Equal([]string{first, second}, keys)
+}
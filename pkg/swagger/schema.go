@@ -0,0 +1,161 @@
+package swagger
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// definitionsPrefix prefixes references to definitions
+const definitionsPrefix = "#/definitions/"
+
+// Schema is a json schema as used by swagger
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+var (
+	timeType  = reflect.TypeOf(time.Time{})
+	ipType    = reflect.TypeOf(net.IP{})
+	ipNetType = reflect.TypeOf(net.IPNet{})
+	macType   = reflect.TypeOf(net.HardwareAddr{})
+	bytesType = reflect.TypeOf([]byte{})
+)
+
+// typeNames assigns unique definition names to struct types
+type typeNames struct {
+	names map[reflect.Type]string
+	taken map[string]bool
+}
+
+func newTypeNames() *typeNames {
+	return &typeNames{
+		names: map[reflect.Type]string{},
+		taken: map[string]bool{},
+	}
+}
+
+// name returns the definition name of a type, and whether it is new. Types
+// with the same name from different packages are qualified by package.
+func (tn *typeNames) name(t reflect.Type) (string, bool) {
+	if name, ok := tn.names[t]; ok {
+		return name, false
+	}
+	name := t.Name()
+	if tn.taken[name] {
+		pkg := t.PkgPath()
+		name = pkg[strings.LastIndex(pkg, "/")+1:] + "." + name
+	}
+	tn.names[t] = name
+	tn.taken[name] = true
+	return name, true
+}
+
+// Schema returns the schema of the type of v, adding definitions for any
+// named struct types it uses
+func (s *Spec) Schema(v interface{}) *Schema {
+	return s.schemaOf(reflect.TypeOf(v))
+}
+
+func (s *Spec) schemaOf(t reflect.Type) *Schema {
+	// types with their own json encodings
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case ipType:
+		return &Schema{Type: "string", Format: "ip"}
+	case ipNetType, reflect.PtrTo(ipNetType):
+		return &Schema{Type: "string", Format: "cidr"}
+	case macType:
+		return &Schema{Type: "string", Format: "mac"}
+	case bytesType:
+		return &Schema{Type: "string", Format: "byte"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return s.schemaOf(t.Elem())
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: s.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: s.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.structSchema(t)
+		}
+		name, isNew := s.types.name(t)
+		if isNew {
+			// reserve the name first in case the type refers to itself
+			s.Definitions[name] = &Schema{}
+			*s.Definitions[name] = *s.structSchema(t)
+		}
+		return &Schema{Ref: definitionsPrefix + name}
+	}
+	// interfaces and anything else may hold any value
+	return &Schema{}
+}
+
+// structSchema builds an object schema from the json encoding of a struct
+func (s *Spec) structSchema(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	s.addFields(schema, t)
+	return schema
+}
+
+// addFields adds the json encoded fields of a struct to an object schema,
+// including those of embedded structs
+func (s *Spec) addFields(schema *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if idx := strings.Index(tag, ","); idx != -1 {
+			name, opts = tag[:idx], tag[idx+1:]
+		}
+
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				s.addFields(schema, ft)
+				continue
+			}
+		}
+		if field.PkgPath != "" {
+			// unexported
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		if strings.Contains(opts, "string") {
+			schema.Properties[name] = &Schema{Type: "string"}
+			continue
+		}
+		schema.Properties[name] = s.schemaOf(field.Type)
+	}
+}
@@ -0,0 +1,338 @@
+// Package swagger builds Swagger 2.0 (OpenAPI) descriptions of http APIs.
+// Paths and methods come from the routes registered on a gorilla/mux router and
+// body schemas from the json struct tags of the types exchanged, so the
+// description stays in sync with the code.
+package swagger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+type (
+	// Spec is a Swagger 2.0 document
+	Spec struct {
+		Swagger     string              `json:"swagger"`
+		Info        Info                `json:"info"`
+		BasePath    string              `json:"basePath,omitempty"`
+		Consumes    []string            `json:"consumes,omitempty"`
+		Produces    []string            `json:"produces,omitempty"`
+		Paths       map[string]PathItem `json:"paths"`
+		Definitions map[string]*Schema  `json:"definitions,omitempty"`
+		types       *typeNames
+		errSchema   *Schema
+	}
+
+	// Info describes the API
+	Info struct {
+		Title       string `json:"title"`
+		Description string `json:"description,omitempty"`
+		Version     string `json:"version"`
+	}
+
+	// PathItem holds the operations of a path, keyed by lowercase method
+	PathItem map[string]*Operation
+
+	// Operation describes a single method on a path
+	Operation struct {
+		Summary     string              `json:"summary,omitempty"`
+		OperationID string              `json:"operationId,omitempty"`
+		Tags        []string            `json:"tags,omitempty"`
+		Produces    []string            `json:"produces,omitempty"`
+		Parameters  []Parameter         `json:"parameters,omitempty"`
+		Responses   map[string]Response `json:"responses"`
+	}
+
+	// Parameter describes a path, query, or body parameter
+	Parameter struct {
+		Name        string   `json:"name"`
+		In          string   `json:"in"`
+		Description string   `json:"description,omitempty"`
+		Required    bool     `json:"required,omitempty"`
+		Type        string   `json:"type,omitempty"`
+		Format      string   `json:"format,omitempty"`
+		Pattern     string   `json:"pattern,omitempty"`
+		Schema      *Schema  `json:"schema,omitempty"`
+		Enum        []string `json:"enum,omitempty"`
+	}
+
+	// Response describes a response
+	Response struct {
+		Description string            `json:"description"`
+		Schema      *Schema           `json:"schema,omitempty"`
+		Headers     map[string]Header `json:"headers,omitempty"`
+	}
+
+	// Header describes a response header
+	Header struct {
+		Description string `json:"description,omitempty"`
+		Type        string `json:"type"`
+	}
+
+	// Route documents what a router does not know about a route: its summary,
+	// query parameters, and the types of its request and response bodies.
+	// Request and Response are example values of the body types, or nil for
+	// none.
+	Route struct {
+		Summary  string
+		Tags     []string
+		Query    []Parameter
+		Request  interface{}
+		Response interface{}
+		// Status is the status of a successful response. Defaults to 200.
+		Status int
+		// Headers are response headers, keyed by name, with descriptions
+		Headers map[string]string
+		// RequestHeaders are optional request headers, keyed by name, with
+		// descriptions
+		RequestHeaders map[string]string
+		// Produces are the content types of the response, if not the
+		// spec's, e.g. text/event-stream
+		Produces []string
+	}
+
+	// Routes documents routes, keyed by method and path template as registered,
+	// e.g. "GET /guests/{guestID}"
+	Routes map[string]Route
+)
+
+// pathVar matches a variable in a mux path template
+var pathVar = regexp.MustCompile(`\{([^{}:]+)(?::([^{}]*))?\}`)
+
+// literal matches a path variable pattern that only matches itself
+var literal = regexp.MustCompile(`^[A-Za-z0-9_\-.]+$`)
+
+// New creates an empty Spec for an API
+func New(title, version string) *Spec {
+	return &Spec{
+		Swagger:     "2.0",
+		Info:        Info{Title: title, Version: version},
+		Consumes:    []string{"application/json"},
+		Produces:    []string{"application/json"},
+		Paths:       map[string]PathItem{},
+		Definitions: map[string]*Schema{},
+		types:       newTypeNames(),
+	}
+}
+
+// SetError sets the type of the body of error responses, which is added as the
+// default response of every operation
+func (s *Spec) SetError(v interface{}) {
+	s.errSchema = s.Schema(v)
+}
+
+// AddRouter adds an operation for every route registered on router that
+// matches a path and method. Routes not documented in routes get a generic
+// description. An error is returned if routes documents a route that is not
+// registered.
+func (s *Spec) AddRouter(router *mux.Router, routes Routes) error {
+	seen := map[string]bool{}
+	err := router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		tmpl, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			// no methods, e.g. a subrouter prefix
+			return nil
+		}
+
+		for _, method := range methods {
+			key := method + " " + tmpl
+			seen[key] = true
+			if err := s.addOperation(method, tmpl, routes[key]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	missing := []string{}
+	for key := range routes {
+		if !seen[key] {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("documented routes not registered: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// addOperation adds the operation for a route
+func (s *Spec) addOperation(method, tmpl string, route Route) error {
+	path, params := parsePath(tmpl)
+	item, ok := s.Paths[path]
+	if !ok {
+		item = PathItem{}
+		s.Paths[path] = item
+	}
+	m := strings.ToLower(method)
+	if _, ok := item[m]; ok {
+		return fmt.Errorf("duplicate route: %s %s", method, path)
+	}
+
+	op := &Operation{
+		Summary:     route.Summary,
+		OperationID: operationID(m, path),
+		Tags:        route.Tags,
+		Produces:    route.Produces,
+		Parameters:  append(params, route.Query...),
+		Responses:   map[string]Response{},
+	}
+	for i := range route.Query {
+		op.Parameters[len(params)+i].In = "query"
+	}
+	headers := make([]string, 0, len(route.RequestHeaders))
+	for name := range route.RequestHeaders {
+		headers = append(headers, name)
+	}
+	sort.Strings(headers)
+	for _, name := range headers {
+		op.Parameters = append(op.Parameters, Parameter{
+			Name:        name,
+			In:          "header",
+			Description: route.RequestHeaders[name],
+			Type:        "string",
+		})
+	}
+	if route.Request != nil {
+		op.Parameters = append(op.Parameters, Parameter{
+			Name:     "body",
+			In:       "body",
+			Required: true,
+			Schema:   s.Schema(route.Request),
+		})
+	}
+
+	status := route.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	resp := Response{Description: http.StatusText(status)}
+	if route.Response != nil {
+		resp.Schema = s.Schema(route.Response)
+	}
+	if len(route.Headers) > 0 {
+		resp.Headers = map[string]Header{}
+		for name, description := range route.Headers {
+			resp.Headers[name] = Header{Description: description, Type: "string"}
+		}
+	}
+	op.Responses[strconv.Itoa(status)] = resp
+	if s.errSchema != nil {
+		op.Responses["default"] = Response{Description: "error", Schema: s.errSchema}
+	}
+
+	item[m] = op
+	return nil
+}
+
+// parsePath converts a mux path template into a swagger path and its path
+// parameters. Variables whose pattern only matches a literal are replaced by
+// the literal.
+func parsePath(tmpl string) (string, []Parameter) {
+	params := []Parameter{}
+	path := pathVar.ReplaceAllStringFunc(tmpl, func(v string) string {
+		match := pathVar.FindStringSubmatch(v)
+		name, pattern := match[1], match[2]
+		if pattern != "" && literal.MatchString(pattern) {
+			return pattern
+		}
+		params = append(params, Parameter{
+			Name:     name,
+			In:       "path",
+			Required: true,
+			Type:     "string",
+			Pattern:  pattern,
+		})
+		return "{" + name + "}"
+	})
+	return path, params
+}
+
+// operationID generates an operation id from a method and path, e.g.
+// getGuestsGuestID for GET /guests/{guestID}
+func operationID(method, path string) string {
+	id := method
+	for _, part := range strings.FieldsFunc(path, func(r rune) bool {
+		return r == '/' || r == '{' || r == '}' || r == '-' || r == '_' || r == '.'
+	}) {
+		id += strings.ToUpper(part[:1]) + part[1:]
+	}
+	return id
+}
+
+// Handler serves the spec as json
+func (s *Spec) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(s)
+	})
+}
+
+// Validate checks that every schema reference resolves to a definition
+func (s *Spec) Validate() error {
+	if s.Swagger != "2.0" {
+		return errors.New("unsupported swagger version")
+	}
+	for name, def := range s.Definitions {
+		if err := s.checkRefs(def); err != nil {
+			return fmt.Errorf("definition %s: %s", name, err)
+		}
+	}
+	for path, item := range s.Paths {
+		for method, op := range item {
+			for _, param := range op.Parameters {
+				if err := s.checkRefs(param.Schema); err != nil {
+					return fmt.Errorf("%s %s: %s", method, path, err)
+				}
+			}
+			for _, resp := range op.Responses {
+				if err := s.checkRefs(resp.Schema); err != nil {
+					return fmt.Errorf("%s %s: %s", method, path, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkRefs checks that the references in a schema resolve
+func (s *Spec) checkRefs(schema *Schema) error {
+	if schema == nil {
+		return nil
+	}
+	if schema.Ref != "" {
+		name := strings.TrimPrefix(schema.Ref, definitionsPrefix)
+		if _, ok := s.Definitions[name]; !ok {
+			return fmt.Errorf("unresolved reference %s", schema.Ref)
+		}
+	}
+	if err := s.checkRefs(schema.Items); err != nil {
+		return err
+	}
+	if err := s.checkRefs(schema.AdditionalProperties); err != nil {
+		return err
+	}
+	for _, prop := range schema.Properties {
+		if err := s.checkRefs(prop); err != nil {
+			return err
+		}
+	}
+	return nil
+}
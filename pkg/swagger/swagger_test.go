@@ -0,0 +1,176 @@
+package swagger_test
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mistifyio/lochness/pkg/swagger"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestSwagger(t *testing.T) {
+	suite.Run(t, new(SwaggerSuite))
+}
+
+type SwaggerSuite struct {
+	suite.Suite
+	Router *mux.Router
+}
+
+type (
+	Base struct {
+		ID string `json:"id"`
+	}
+
+	Thing struct {
+		Base
+		Name     string            `json:"name"`
+		Count    uint64            `json:"count,omitempty"`
+		Ratio    float64           `json:"ratio"`
+		IP       net.IP            `json:"ip"`
+		MAC      net.HardwareAddr  `json:"mac"`
+		Created  time.Time         `json:"created"`
+		Tags     []string          `json:"tags"`
+		Metadata map[string]string `json:"metadata"`
+		Parent   *Thing            `json:"parent,omitempty"`
+		Big      int64             `json:"big,string"`
+		Ignored  string            `json:"-"`
+		NoTag    bool
+		private  string
+	}
+
+	Error struct {
+		Message string `json:"message"`
+	}
+)
+
+func noop(w http.ResponseWriter, r *http.Request) {}
+
+func (s *SwaggerSuite) SetupTest() {
+	s.Router = mux.NewRouter()
+	s.Router.HandleFunc("/things", noop).Methods("GET")
+	s.Router.HandleFunc("/things", noop).Methods("POST")
+	sub := s.Router.PathPrefix("/things").Subrouter()
+	sub.HandleFunc("/{thingID}", noop).Methods("GET", "DELETE")
+	sub.HandleFunc("/{thingID}/{action:start}", noop).Methods("POST")
+	sub.HandleFunc("/{thingID}/parts/{part:[0-9]+}", noop).Methods("GET")
+	s.Router.HandleFunc("/metrics", noop)
+}
+
+func (s *SwaggerSuite) TestAddRouter() {
+	spec := swagger.New("test", "1.0")
+	spec.SetError(&Error{})
+	s.Require().NoError(spec.AddRouter(s.Router, swagger.Routes{
+		"GET /things": {
+			Summary:  "List things",
+			Response: []*Thing{},
+		},
+		"POST /things": {
+			Request:  &Thing{},
+			Response: &Thing{},
+			Status:   http.StatusCreated,
+			Headers:  map[string]string{"X-Job-ID": "job"},
+
+			RequestHeaders: map[string]string{"Idempotency-Key": "key"},
+		},
+		"GET /things/{thingID}/parts/{part:[0-9]+}": {
+			Query:    []swagger.Parameter{{Name: "wait", Type: "integer"}},
+			Produces: []string{"text/event-stream"},
+		},
+	}))
+	s.NoError(spec.Validate())
+
+	s.Len(spec.Paths, 4, "routes without methods should be skipped")
+
+	list := spec.Paths["/things"]["get"]
+	s.Require().NotNil(list)
+	s.Equal("List things", list.Summary)
+	s.Equal("getThings", list.OperationID)
+	s.Equal("array", list.Responses["200"].Schema.Type)
+	s.Equal("#/definitions/Thing", list.Responses["200"].Schema.Items.Ref)
+	s.Equal("#/definitions/Error", list.Responses["default"].Schema.Ref)
+
+	create := spec.Paths["/things"]["post"]
+	s.Require().NotNil(create)
+	s.Require().Len(create.Parameters, 2)
+	s.Equal("Idempotency-Key", create.Parameters[0].Name)
+	s.Equal("header", create.Parameters[0].In)
+	s.Equal("body", create.Parameters[1].In)
+	s.Contains(create.Responses, "201")
+	s.Contains(create.Responses["201"].Headers, "X-Job-ID")
+
+	s.Contains(spec.Paths["/things/{thingID}"], "get")
+	s.Contains(spec.Paths["/things/{thingID}"], "delete")
+	s.Contains(spec.Paths, "/things/{thingID}/start", "literal patterns should be inlined")
+
+	part := spec.Paths["/things/{thingID}/parts/{part}"]["get"]
+	s.Require().NotNil(part)
+	s.Require().Len(part.Parameters, 3)
+	s.Equal("thingID", part.Parameters[0].Name)
+	s.Equal("path", part.Parameters[0].In)
+	s.Equal("[0-9]+", part.Parameters[1].Pattern)
+	s.Equal("query", part.Parameters[2].In)
+	s.Equal("getThingsThingIDPartsPart", part.OperationID)
+	s.Equal([]string{"text/event-stream"}, part.Produces)
+	s.Empty(list.Produces, "operations should default to the spec's content types")
+}
+
+func (s *SwaggerSuite) TestUndocumentedRoute() {
+	spec := swagger.New("test", "1.0")
+	err := spec.AddRouter(s.Router, swagger.Routes{
+		"GET /missing": {},
+	})
+	s.Error(err)
+	s.Contains(err.Error(), "GET /missing")
+}
+
+func (s *SwaggerSuite) TestSchema() {
+	spec := swagger.New("test", "1.0")
+	schema := spec.Schema(Thing{})
+	s.Equal("#/definitions/Thing", schema.Ref)
+
+	thing := spec.Definitions["Thing"]
+	s.Require().NotNil(thing)
+	props := thing.Properties
+	expected := map[string][2]string{
+		"id":       {"string", ""},
+		"name":     {"string", ""},
+		"count":    {"integer", "int64"},
+		"ratio":    {"number", "double"},
+		"ip":       {"string", "ip"},
+		"mac":      {"string", "mac"},
+		"created":  {"string", "date-time"},
+		"tags":     {"array", ""},
+		"metadata": {"object", ""},
+		"big":      {"string", ""},
+		"NoTag":    {"boolean", ""},
+	}
+	for name, typeFormat := range expected {
+		s.Require().Contains(props, name)
+		s.Equal(typeFormat[0], props[name].Type, name)
+		s.Equal(typeFormat[1], props[name].Format, name)
+	}
+	s.Equal("#/definitions/Thing", props["parent"].Ref)
+	s.Len(props, len(expected)+1)
+	s.NoError(spec.Validate())
+}
+
+func (s *SwaggerSuite) TestHandler() {
+	spec := swagger.New("test", "1.0")
+	s.Require().NoError(spec.AddRouter(s.Router, nil))
+
+	rec := httptest.NewRecorder()
+	spec.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/swagger.json", nil))
+	s.Equal(http.StatusOK, rec.Code)
+	s.Equal("application/json", rec.Header().Get("Content-Type"))
+
+	doc := map[string]interface{}{}
+	s.NoError(json.Unmarshal(rec.Body.Bytes(), &doc))
+	s.Equal("2.0", doc["swagger"])
+	s.Contains(doc["paths"], "/things")
+}
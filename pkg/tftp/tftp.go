@@ -0,0 +1,399 @@
+// Package tftp is a read only TFTP server (RFC 1350), for network booting
+// machines whose firmware can only fetch files over TFTP, such as a PXE ROM
+// chainloading iPXE. It supports the blksize, tsize, and timeout options (RFC
+// 2347, 2348, 2349). Files are always sent as is, whatever the transfer mode.
+package tftp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Opcodes of TFTP packets
+const (
+	opRRQ   uint16 = 1
+	opWRQ   uint16 = 2
+	opDATA  uint16 = 3
+	opACK   uint16 = 4
+	opERROR uint16 = 5
+	opOACK  uint16 = 6
+)
+
+// Error codes of TFTP error packets
+const (
+	errUndefined uint16 = 0
+	errNotFound  uint16 = 1
+	errAccess    uint16 = 2
+	errIllegal   uint16 = 4
+	errBadOption uint16 = 8
+)
+
+const (
+	// defaultBlockSize is the size of data packets without the blksize
+	// option, and minBlockSize and maxBlockSize the bounds of the option
+	defaultBlockSize = 512
+	minBlockSize     = 8
+	maxBlockSize     = 65464
+	maxPacketSize    = maxBlockSize + 4
+
+	defaultTimeout = 2 * time.Second
+	defaultRetries = 5
+
+	// minOptionTimeout and maxOptionTimeout are the bounds, in seconds, of
+	// the timeout option
+	minOptionTimeout = 1
+	maxOptionTimeout = 255
+)
+
+var (
+	// ErrTimeout is returned when a client does not acknowledge a packet
+	// after all retries
+	ErrTimeout = errors.New("timed out waiting for acknowledgement")
+
+	// ErrServerClosed is returned by Serve after Close
+	ErrServerClosed = errors.New("tftp: server closed")
+)
+
+// Handler opens the files clients read
+type Handler interface {
+	// Open opens a file read by the client at remote, returning it and its
+	// size, or -1 if it is not known. Errors satisfying os.IsNotExist are
+	// reported to the client as file not found.
+	Open(filename string, remote *net.UDPAddr) (io.ReadCloser, int64, error)
+}
+
+// HandlerFunc is a function serving as a Handler
+type HandlerFunc func(filename string, remote *net.UDPAddr) (io.ReadCloser, int64, error)
+
+// Open calls f
+func (f HandlerFunc) Open(filename string, remote *net.UDPAddr) (io.ReadCloser, int64, error) {
+	return f(filename, remote)
+}
+
+// Server serves read requests with a Handler
+type Server struct {
+	Handler Handler
+	// Timeout is how long to wait for each acknowledgement, unless the
+	// client asks for another with the timeout option
+	Timeout time.Duration
+	// Retries is how many times a packet is resent before a transfer is
+	// abandoned
+	Retries int
+	// Log, if set, is called when a transfer ends, with the error that
+	// ended it early, if any
+	Log func(filename string, remote *net.UDPAddr, size int64, err error)
+
+	mu        sync.Mutex
+	conn      *net.UDPConn
+	closed    bool
+	transfers sync.WaitGroup
+}
+
+// New creates a Server for a Handler
+func New(handler Handler) *Server {
+	return &Server{
+		Handler: handler,
+		Timeout: defaultTimeout,
+		Retries: defaultRetries,
+	}
+}
+
+// ListenAndServe listens on the udp address, e.g. ":69", and serves requests
+// until Close
+func (s *Server) ListenAndServe(addr string) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(conn)
+}
+
+// Serve serves requests received on conn until Close. Each transfer is made
+// from a port of its own, as the protocol requires.
+func (s *Server) Serve(conn *net.UDPConn) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		_ = conn.Close()
+		return ErrServerClosed
+	}
+	s.conn = conn
+	s.mu.Unlock()
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, remote, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return err
+		}
+
+		packet := make([]byte, n)
+		copy(packet, buf[:n])
+		s.transfers.Add(1)
+		go func() {
+			defer s.transfers.Done()
+			s.handle(packet, remote)
+		}()
+	}
+}
+
+// Addr returns the address the server is listening on, nil before Serve
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.LocalAddr()
+}
+
+// Close stops the server and waits for the transfers in progress, which stop
+// when their clients stop acknowledging or they are done
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	conn := s.conn
+	s.mu.Unlock()
+
+	var err error
+	if conn != nil {
+		err = conn.Close()
+	}
+	s.transfers.Wait()
+	return err
+}
+
+// request is a parsed read or write request
+type request struct {
+	op       uint16
+	filename string
+	mode     string
+	options  map[string]string
+}
+
+// parseRequest parses a RRQ or WRQ packet
+func parseRequest(packet []byte) (*request, error) {
+	if len(packet) < 2 {
+		return nil, errors.New("short packet")
+	}
+	r := &request{
+		op:      binary.BigEndian.Uint16(packet),
+		options: make(map[string]string),
+	}
+	if r.op != opRRQ && r.op != opWRQ {
+		return r, nil
+	}
+
+	fields := bytes.Split(packet[2:], []byte{0})
+	// a terminated packet ends with an empty field
+	if len(fields) < 3 || len(fields[len(fields)-1]) != 0 {
+		return nil, errors.New("malformed request")
+	}
+	fields = fields[:len(fields)-1]
+	if len(fields)%2 != 0 {
+		return nil, errors.New("malformed options")
+	}
+	r.filename = string(fields[0])
+	r.mode = strings.ToLower(string(fields[1]))
+	for i := 2; i < len(fields); i += 2 {
+		r.options[strings.ToLower(string(fields[i]))] = string(fields[i+1])
+	}
+	return r, nil
+}
+
+// handle answers a request from remote on a port of its own
+func (s *Server) handle(packet []byte, remote *net.UDPAddr) {
+	conn, err := net.DialUDP("udp", nil, remote)
+	if err != nil {
+		s.log("", remote, 0, err)
+		return
+	}
+	defer func() { _ = conn.Close() }()
+
+	req, err := parseRequest(packet)
+	if err != nil {
+		_ = sendError(conn, errIllegal, err.Error())
+		return
+	}
+	switch req.op {
+	case opRRQ:
+	case opWRQ:
+		_ = sendError(conn, errAccess, "read only server")
+		return
+	default:
+		// stray packets for finished transfers are ignored
+		return
+	}
+
+	size, err := s.read(conn, req, remote)
+	s.log(req.filename, remote, size, err)
+}
+
+// log reports the end of a transfer
+func (s *Server) log(filename string, remote *net.UDPAddr, size int64, err error) {
+	if s.Log != nil {
+		s.Log(filename, remote, size, err)
+	}
+}
+
+// read sends the file of a read request, returning the number of bytes sent
+func (s *Server) read(conn *net.UDPConn, req *request, remote *net.UDPAddr) (int64, error) {
+	blockSize := defaultBlockSize
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	oack := map[string]string{}
+
+	if value, ok := req.options["blksize"]; ok {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < minBlockSize {
+			_ = sendError(conn, errBadOption, "unsupported option value")
+			return 0, fmt.Errorf("invalid blksize %q", value)
+		}
+		if n > maxBlockSize {
+			n = maxBlockSize
+		}
+		blockSize = n
+		oack["blksize"] = strconv.Itoa(n)
+	}
+	if value, ok := req.options["timeout"]; ok {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < minOptionTimeout || n > maxOptionTimeout {
+			_ = sendError(conn, errBadOption, "unsupported option value")
+			return 0, fmt.Errorf("invalid timeout %q", value)
+		}
+		timeout = time.Duration(n) * time.Second
+		oack["timeout"] = value
+	}
+
+	file, size, err := s.Handler.Open(req.filename, remote)
+	if err != nil {
+		if os.IsNotExist(err) {
+			_ = sendError(conn, errNotFound, "file not found")
+		} else {
+			_ = sendError(conn, errUndefined, err.Error())
+		}
+		return 0, err
+	}
+	defer func() { _ = file.Close() }()
+
+	if _, ok := req.options["tsize"]; ok && size >= 0 {
+		oack["tsize"] = strconv.FormatInt(size, 10)
+	}
+
+	if len(oack) > 0 {
+		if err := s.send(conn, oackPacket(oack), 0, timeout); err != nil {
+			return 0, err
+		}
+	}
+
+	var sent int64
+	buf := make([]byte, blockSize)
+	for block := uint16(1); ; block++ {
+		n, err := io.ReadFull(file, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			_ = sendError(conn, errUndefined, err.Error())
+			return sent, err
+		}
+		if err := s.send(conn, dataPacket(block, buf[:n]), block, timeout); err != nil {
+			return sent, err
+		}
+		sent += int64(n)
+		if n < blockSize {
+			return sent, nil
+		}
+	}
+}
+
+// send sends a packet until the client acknowledges block, it sends an error,
+// or the retries run out
+func (s *Server) send(conn *net.UDPConn, packet []byte, block uint16, timeout time.Duration) error {
+	buf := make([]byte, maxPacketSize)
+	for attempt := 0; attempt <= s.Retries; attempt++ {
+		if _, err := conn.Write(packet); err != nil {
+			return err
+		}
+		deadline := time.Now().Add(timeout)
+		for {
+			if err := conn.SetReadDeadline(deadline); err != nil {
+				return err
+			}
+			n, err := conn.Read(buf)
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					break
+				}
+				return err
+			}
+			if n < 4 {
+				continue
+			}
+			switch binary.BigEndian.Uint16(buf) {
+			case opACK:
+				// duplicate acks of earlier blocks are ignored
+				if binary.BigEndian.Uint16(buf[2:]) == block {
+					return nil
+				}
+			case opERROR:
+				return fmt.Errorf("client error %d: %s", binary.BigEndian.Uint16(buf[2:]), strings.TrimRight(string(buf[4:n]), "\x00"))
+			}
+		}
+	}
+	return ErrTimeout
+}
+
+// dataPacket creates a DATA packet
+func dataPacket(block uint16, data []byte) []byte {
+	packet := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint16(packet, opDATA)
+	binary.BigEndian.PutUint16(packet[2:], block)
+	copy(packet[4:], data)
+	return packet
+}
+
+// oackPacket creates an OACK packet of the options accepted
+func oackPacket(options map[string]string) []byte {
+	packet := make([]byte, 2, 64)
+	binary.BigEndian.PutUint16(packet, opOACK)
+	for name, value := range options {
+		packet = append(packet, name...)
+		packet = append(packet, 0)
+		packet = append(packet, value...)
+		packet = append(packet, 0)
+	}
+	return packet
+}
+
+// sendError sends an ERROR packet, which ends a transfer
+func sendError(conn *net.UDPConn, code uint16, msg string) error {
+	packet := make([]byte, 4, 5+len(msg))
+	binary.BigEndian.PutUint16(packet, opERROR)
+	binary.BigEndian.PutUint16(packet[2:], code)
+	packet = append(packet, msg...)
+	packet = append(packet, 0)
+	_, err := conn.Write(packet)
+	return err
+}
@@ -0,0 +1,181 @@
+package tftp_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mistifyio/lochness/pkg/tftp"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestTFTP(t *testing.T) {
+	suite.Run(t, new(TFTPSuite))
+}
+
+type TFTPSuite struct {
+	suite.Suite
+	Server *tftp.Server
+	Files  map[string][]byte
+	Done   chan error
+	mu     sync.Mutex
+	Logged []string
+}
+
+func (s *TFTPSuite) SetupTest() {
+	s.Files = map[string][]byte{
+		"undionly.kpxe": bytes.Repeat([]byte("0123456789abcdef"), 100),
+		"empty":         {},
+	}
+	s.Logged = nil
+	s.Server = tftp.New(tftp.HandlerFunc(func(filename string, remote *net.UDPAddr) (io.ReadCloser, int64, error) {
+		data, ok := s.Files[filename]
+		if !ok {
+			return nil, 0, os.ErrNotExist
+		}
+		return ioutil.NopCloser(bytes.NewReader(data)), int64(len(data)), nil
+	}))
+	s.Server.Timeout = 200 * time.Millisecond
+	s.Server.Retries = 1
+	s.Server.Log = func(filename string, remote *net.UDPAddr, size int64, err error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.Logged = append(s.Logged, filename)
+	}
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	s.Require().NoError(err)
+	s.Done = make(chan error, 1)
+	go func() { s.Done <- s.Server.Serve(conn) }()
+	for s.Server.Addr() == nil {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (s *TFTPSuite) TearDownTest() {
+	s.NoError(s.Server.Close())
+	s.Equal(tftp.ErrServerClosed, <-s.Done)
+}
+
+// request sends a request packet to the server, returning the client conn
+func (s *TFTPSuite) request(op uint16, fields ...string) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	s.Require().NoError(err)
+	packet := make([]byte, 2)
+	binary.BigEndian.PutUint16(packet, op)
+	for _, field := range fields {
+		packet = append(packet, field...)
+		packet = append(packet, 0)
+	}
+	_, err = conn.WriteToUDP(packet, s.Server.Addr().(*net.UDPAddr))
+	s.Require().NoError(err)
+	return conn
+}
+
+// receive reads a packet, returning its opcode, the rest of it, and the
+// address of the transfer
+func (s *TFTPSuite) receive(conn *net.UDPConn) (uint16, []byte, *net.UDPAddr) {
+	buf := make([]byte, 65536)
+	s.Require().NoError(conn.SetReadDeadline(time.Now().Add(2 * time.Second)))
+	n, remote, err := conn.ReadFromUDP(buf)
+	s.Require().NoError(err)
+	s.Require().True(n >= 4)
+	return binary.BigEndian.Uint16(buf), buf[2:n], remote
+}
+
+// ack acknowledges a block
+func (s *TFTPSuite) ack(conn *net.UDPConn, remote *net.UDPAddr, block uint16) {
+	packet := make([]byte, 4)
+	binary.BigEndian.PutUint16(packet, 4)
+	binary.BigEndian.PutUint16(packet[2:], block)
+	_, err := conn.WriteToUDP(packet, remote)
+	s.Require().NoError(err)
+}
+
+// download reads a file until the last block, acknowledging each
+func (s *TFTPSuite) download(conn *net.UDPConn, blockSize int) []byte {
+	var data []byte
+	for want := uint16(1); ; want++ {
+		op, packet, remote := s.receive(conn)
+		s.Require().Equal(uint16(3), op, "expected data")
+		block, body := binary.BigEndian.Uint16(packet), packet[2:]
+		s.Require().Equal(want, block)
+		data = append(data, body...)
+		s.ack(conn, remote, block)
+		if len(body) < blockSize {
+			return data
+		}
+	}
+}
+
+func (s *TFTPSuite) TestRead() {
+	conn := s.request(1, "undionly.kpxe", "octet")
+	defer func() { _ = conn.Close() }()
+	s.Equal(s.Files["undionly.kpxe"], s.download(conn, 512))
+
+	conn = s.request(1, "empty", "octet")
+	defer func() { _ = conn.Close() }()
+	s.Empty(s.download(conn, 512))
+}
+
+func (s *TFTPSuite) TestOptions() {
+	conn := s.request(1, "undionly.kpxe", "octet", "blksize", "1000", "tsize", "0")
+	defer func() { _ = conn.Close() }()
+
+	op, packet, remote := s.receive(conn)
+	s.Require().Equal(uint16(6), op, "expected oack")
+	options := strings.Split(strings.TrimRight(string(packet), "\x00"), "\x00")
+	s.ElementsMatch([]string{"blksize", "1000", "tsize", "1600"}, options)
+
+	s.ack(conn, remote, 0)
+	s.Equal(s.Files["undionly.kpxe"], s.download(conn, 1000))
+}
+
+func (s *TFTPSuite) TestErrors() {
+	conn := s.request(1, "missing", "octet")
+	defer func() { _ = conn.Close() }()
+	op, packet, _ := s.receive(conn)
+	s.Equal(uint16(5), op)
+	s.Equal("\x00\x01file not found\x00", string(packet))
+
+	conn = s.request(2, "undionly.kpxe", "octet")
+	defer func() { _ = conn.Close() }()
+	op, packet, _ = s.receive(conn)
+	s.Equal(uint16(5), op)
+	s.Equal(uint16(2), binary.BigEndian.Uint16(packet), "writes should be refused")
+
+	conn = s.request(1, "undionly.kpxe", "octet", "blksize", "4")
+	defer func() { _ = conn.Close() }()
+	op, packet, _ = s.receive(conn)
+	s.Equal(uint16(5), op)
+	s.Equal(uint16(8), binary.BigEndian.Uint16(packet), "bad options should be refused")
+}
+
+func (s *TFTPSuite) TestRetransmit() {
+	conn := s.request(1, "undionly.kpxe", "octet")
+	defer func() { _ = conn.Close() }()
+
+	_, first, _ := s.receive(conn)
+	_, resent, _ := s.receive(conn)
+	s.Equal(first, resent, "unacknowledged blocks should be resent")
+
+	// the transfer is abandoned once the retries run out
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		s.mu.Lock()
+		logged := len(s.Logged)
+		s.mu.Unlock()
+		if logged > 0 {
+			break
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Equal([]string{"undionly.kpxe"}, s.Logged)
+}
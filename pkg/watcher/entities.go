@@ -0,0 +1,307 @@
+package watcher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/pkg/kv"
+)
+
+// entityKeys parses the keys of hypervisors, subnets, and guests, and of what
+// is nested under them, with or without the leading slash some kvs have
+var entityKeys = regexp.MustCompile(`^/?lochness/(hypervisors|subnets|guests)/([0-9a-f\-]+)(/(.*))?$`)
+
+// ErrUnknownKey is the error of events whose key is not that of an entity, or
+// of something nested under one
+var ErrUnknownKey = errors.New("key is not of a known entity")
+
+type (
+	// EntityEvent is what the change events of all entities have. Type is
+	// Create, Update, or Delete, and Event the kv event it was decoded from.
+	// Deletes are of the entity's value or of its whole directory.
+	EntityEvent struct {
+		Type  kv.EventType
+		ID    string
+		Event kv.Event
+	}
+
+	// HypervisorEvent is a change of a hypervisor. Old is the hypervisor
+	// before an update or delete, if the kv watches previous values, see
+	// Watcher.WatchesPrev, and New after a create or update.
+	HypervisorEvent struct {
+		EntityEvent
+		Old *lochness.Hypervisor
+		New *lochness.Hypervisor
+	}
+
+	// GuestEvent is a change of a guest, see HypervisorEvent
+	GuestEvent struct {
+		EntityEvent
+		Old *lochness.Guest
+		New *lochness.Guest
+	}
+
+	// SubnetEvent is a change of a subnet, see HypervisorEvent
+	SubnetEvent struct {
+		EntityEvent
+		Old *lochness.Subnet
+		New *lochness.Subnet
+	}
+
+	// EventError is the error of a kv event that could not be decoded, or,
+	// without one, of the watch
+	EventError struct {
+		Event *kv.Event
+		Err   error
+	}
+)
+
+func (e *EventError) Error() string {
+	if e.Event == nil {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %s", e.Event.Key, e.Err)
+}
+
+// ParseKey parses the key of an entity, or of something nested under it, into
+// the path of its kind, e.g. "guests", its id, and the rest of the key, e.g.
+// "metadata". ok is false for other keys.
+func ParseKey(key string) (kind, id, rest string, ok bool) {
+	matches := entityKeys.FindStringSubmatch(key)
+	if matches == nil {
+		return "", "", "", false
+	}
+	return matches[1], matches[2], matches[4], true
+}
+
+// entityEvent decodes what all entity events have, ok being false for events
+// that do not change the value of an entity of the kind, e.g. of the keys
+// nested under it
+func entityEvent(kind string, e kv.Event) (EntityEvent, bool, error) {
+	k, id, rest, ok := ParseKey(e.Key)
+	if !ok {
+		return EntityEvent{}, false, ErrUnknownKey
+	}
+	if k != kind {
+		return EntityEvent{}, false, nil
+	}
+	switch {
+	case rest == "metadata" && e.Type != kv.None:
+	case rest == "" && e.Type == kv.Delete:
+		// the entity's directory, deleted with everything under it
+	default:
+		return EntityEvent{}, false, nil
+	}
+	return EntityEvent{Type: e.Type, ID: id, Event: e}, true, nil
+}
+
+// unmarshalValues unmarshals the previous and current values of an event into
+// old and new, returning whether each was. Creates have no previous value and
+// deletes no current one.
+func unmarshalValues(e kv.Event, old, new json.Unmarshaler) (bool, bool, error) {
+	hasOld := e.Prev != nil && e.Type != kv.Create
+	if hasOld {
+		if err := old.UnmarshalJSON(e.Prev.Data); err != nil {
+			return false, false, err
+		}
+	}
+	hasNew := e.Type != kv.Delete
+	if hasNew {
+		if err := new.UnmarshalJSON(e.Data); err != nil {
+			return false, false, err
+		}
+	}
+	return hasOld, hasNew, nil
+}
+
+// DecodeHypervisorEvent decodes a kv event of a hypervisor, bound to c. ok is
+// false for events that do not change one, and an error is returned for keys
+// that can not be parsed and values that can not be unmarshaled.
+func DecodeHypervisorEvent(c *lochness.Context, e kv.Event) (HypervisorEvent, bool, error) {
+	ee, ok, err := entityEvent("hypervisors", e)
+	if !ok {
+		return HypervisorEvent{}, false, err
+	}
+	event := HypervisorEvent{EntityEvent: ee}
+	old, new := c.NewHypervisor(), c.NewHypervisor()
+	hasOld, hasNew, err := unmarshalValues(e, old, new)
+	if err != nil {
+		return HypervisorEvent{}, false, err
+	}
+	if hasOld {
+		event.Old = old
+	}
+	if hasNew {
+		event.New = new
+	}
+	return event, true, nil
+}
+
+// DecodeGuestEvent decodes a kv event of a guest, see DecodeHypervisorEvent
+func DecodeGuestEvent(c *lochness.Context, e kv.Event) (GuestEvent, bool, error) {
+	ee, ok, err := entityEvent("guests", e)
+	if !ok {
+		return GuestEvent{}, false, err
+	}
+	event := GuestEvent{EntityEvent: ee}
+	old, new := c.NewGuest(), c.NewGuest()
+	hasOld, hasNew, err := unmarshalValues(e, old, new)
+	if err != nil {
+		return GuestEvent{}, false, err
+	}
+	if hasOld {
+		event.Old = old
+	}
+	if hasNew {
+		event.New = new
+	}
+	return event, true, nil
+}
+
+// DecodeSubnetEvent decodes a kv event of a subnet, see DecodeHypervisorEvent
+func DecodeSubnetEvent(c *lochness.Context, e kv.Event) (SubnetEvent, bool, error) {
+	ee, ok, err := entityEvent("subnets", e)
+	if !ok {
+		return SubnetEvent{}, false, err
+	}
+	event := SubnetEvent{EntityEvent: ee}
+	old, new := c.NewSubnet(), c.NewSubnet()
+	hasOld, hasNew, err := unmarshalValues(e, old, new)
+	if err != nil {
+		return SubnetEvent{}, false, err
+	}
+	if hasOld {
+		event.Old = old
+	}
+	if hasNew {
+		event.New = new
+	}
+	return event, true, nil
+}
+
+// WatchHypervisors watches the hypervisors of a kv until ctx is done, sending
+// their changes on the first channel. The errors of events that can not be
+// decoded are sent on the second channel, which gets the error of the watch
+// before both are closed if it fails. Like Watcher.Add, changes made shortly
+// before it returns may be missed.
+func WatchHypervisors(ctx context.Context, KV kv.KV) (<-chan HypervisorEvent, <-chan *EventError, error) {
+	events := make(chan HypervisorEvent)
+	c := lochness.NewContext(KV)
+	errs, err := watchEntities(ctx, KV, path.Join("/", lochness.HypervisorPath), func(e kv.Event) (bool, error) {
+		event, ok, err := DecodeHypervisorEvent(c, e)
+		if !ok || err != nil {
+			return true, err
+		}
+		select {
+		case events <- event:
+			return true, nil
+		case <-ctx.Done():
+			return false, nil
+		}
+	}, func() { close(events) })
+	if err != nil {
+		return nil, nil, err
+	}
+	return events, errs, nil
+}
+
+// WatchGuests watches the guests of a kv, see WatchHypervisors
+func WatchGuests(ctx context.Context, KV kv.KV) (<-chan GuestEvent, <-chan *EventError, error) {
+	events := make(chan GuestEvent)
+	c := lochness.NewContext(KV)
+	errs, err := watchEntities(ctx, KV, path.Join("/", lochness.GuestPath), func(e kv.Event) (bool, error) {
+		event, ok, err := DecodeGuestEvent(c, e)
+		if !ok || err != nil {
+			return true, err
+		}
+		select {
+		case events <- event:
+			return true, nil
+		case <-ctx.Done():
+			return false, nil
+		}
+	}, func() { close(events) })
+	if err != nil {
+		return nil, nil, err
+	}
+	return events, errs, nil
+}
+
+// WatchSubnets watches the subnets of a kv, see WatchHypervisors
+func WatchSubnets(ctx context.Context, KV kv.KV) (<-chan SubnetEvent, <-chan *EventError, error) {
+	events := make(chan SubnetEvent)
+	c := lochness.NewContext(KV)
+	errs, err := watchEntities(ctx, KV, path.Join("/", lochness.SubnetPath), func(e kv.Event) (bool, error) {
+		event, ok, err := DecodeSubnetEvent(c, e)
+		if !ok || err != nil {
+			return true, err
+		}
+		select {
+		case events <- event:
+			return true, nil
+		case <-ctx.Done():
+			return false, nil
+		}
+	}, func() { close(events) })
+	if err != nil {
+		return nil, nil, err
+	}
+	return events, errs, nil
+}
+
+// watchEntities watches a prefix of a kv until ctx is done, passing each
+// event to send, which returns whether to go on and the error of an event it
+// could not decode. The errors are sent on the channel returned, which is
+// closed, after calling done, once the watch ends.
+func watchEntities(ctx context.Context, KV kv.KV, prefix string, send func(kv.Event) (bool, error), done func()) (<-chan *EventError, error) {
+	stop := make(chan struct{})
+	waitIndex := getLatestIndex(KV, prefix)
+	events, watchErrs, err := KV.Watch(prefix, waitIndex, stop)
+	if err != nil {
+		return nil, err
+	}
+
+	errs := make(chan *EventError)
+	sendErr := func(err *EventError) bool {
+		select {
+		case errs <- err:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	go func() {
+		defer close(errs)
+		defer done()
+		defer close(stop)
+		for {
+			select {
+			case e, ok := <-events:
+				if !ok {
+					return
+				}
+				goOn, err := send(e)
+				if err != nil {
+					goOn = sendErr(&EventError{Event: &e, Err: err})
+				}
+				if !goOn {
+					return
+				}
+			case err, ok := <-watchErrs:
+				if ok {
+					sendErr(&EventError{Err: err})
+				}
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return errs, nil
+}
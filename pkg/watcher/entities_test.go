@@ -0,0 +1,127 @@
+package watcher_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/mistifyio/lochness/pkg/kv"
+	"github.com/mistifyio/lochness/pkg/watcher"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestEntities(t *testing.T) {
+	suite.Run(t, new(EntitiesSuite))
+}
+
+type EntitiesSuite struct {
+	common.Suite
+}
+
+func (s *EntitiesSuite) TestParseKey() {
+	tests := []struct {
+		key, kind, id, rest string
+		ok                  bool
+	}{
+		{"lochness/guests/0a1b/metadata", "guests", "0a1b", "metadata", true},
+		{"/lochness/subnets/0a1b/addresses/10.0.0.1", "subnets", "0a1b", "addresses/10.0.0.1", true},
+		{"lochness/hypervisors/0a1b", "hypervisors", "0a1b", "", true},
+		{"lochness/guests/FOOBAR/metadata", "", "", "", false},
+		{"lochness/flavors/0a1b/metadata", "", "", "", false},
+	}
+	for _, test := range tests {
+		kind, id, rest, ok := watcher.ParseKey(test.key)
+		s.Equal(test.ok, ok, test.key)
+		s.Equal(test.kind, kind, test.key)
+		s.Equal(test.id, id, test.key)
+		s.Equal(test.rest, rest, test.key)
+	}
+}
+
+func (s *EntitiesSuite) TestDecodeGuestEvent() {
+	guest := s.NewGuest()
+	data, _ := json.Marshal(guest)
+	key := fmt.Sprintf("lochness/guests/%s/metadata", guest.ID)
+
+	e, ok, err := watcher.DecodeGuestEvent(s.Context, kv.Event{
+		Type:  kv.Update,
+		Key:   key,
+		Value: kv.Value{Data: data},
+		Prev:  &kv.Value{Data: data},
+	})
+	s.NoError(err)
+	if s.True(ok) {
+		s.Equal(kv.Update, e.Type)
+		s.Equal(guest.ID, e.ID)
+		s.Equal(guest.MAC, e.Old.MAC)
+		s.Equal(guest.MAC, e.New.MAC)
+	}
+
+	e, ok, err = watcher.DecodeGuestEvent(s.Context, kv.Event{
+		Type: kv.Delete,
+		Key:  fmt.Sprintf("lochness/guests/%s", guest.ID),
+	})
+	s.NoError(err)
+	if s.True(ok) {
+		s.Nil(e.Old)
+		s.Nil(e.New)
+	}
+
+	_, ok, err = watcher.DecodeGuestEvent(s.Context, kv.Event{
+		Type:  kv.Create,
+		Key:   fmt.Sprintf("lochness/hypervisors/%s/metadata", guest.ID),
+		Value: kv.Value{Data: data},
+	})
+	s.NoError(err)
+	s.False(ok, "other entities are not guests")
+
+	_, ok, err = watcher.DecodeGuestEvent(s.Context, kv.Event{
+		Type:  kv.Create,
+		Key:   key,
+		Value: kv.Value{Data: []byte("foobar")},
+	})
+	s.Error(err)
+	s.False(ok)
+
+	_, _, err = watcher.DecodeGuestEvent(s.Context, kv.Event{Type: kv.Create, Key: "foobar/baz"})
+	s.Equal(watcher.ErrUnknownKey, err)
+}
+
+func (s *EntitiesSuite) TestWatchGuests() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, errs, err := watcher.WatchGuests(ctx, s.KV)
+	s.Require().NoError(err)
+
+	guest := s.NewGuest()
+	// the hypervisor is not a guest
+	_ = s.NewHypervisor()
+	s.Require().NoError(s.KV.Set(fmt.Sprintf("lochness/guests/%s/metadata", "0a1b"), "foobar"))
+
+	select {
+	case e := <-events:
+		s.Equal(kv.Create, e.Type)
+		s.Equal(guest.ID, e.ID)
+		if s.NotNil(e.New) {
+			s.Equal(guest.MAC, e.New.MAC)
+		}
+	case <-time.After(5 * time.Second):
+		s.Fail("guest not watched")
+	}
+
+	select {
+	case err := <-errs:
+		if s.NotNil(err.Event) {
+			s.Contains(err.Event.Key, "0a1b")
+		}
+	case <-time.After(5 * time.Second):
+		s.Fail("invalid guest not reported")
+	}
+
+	cancel()
+	for range events {
+	}
+}
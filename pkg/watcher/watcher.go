@@ -1,4 +1,5 @@
-// Package watcher provides kv prefix watching capabilities.
+// Package watcher provides kv prefix watching capabilities, and watches of the
+// hypervisors, guests, and subnets that decode their changes.
 package watcher
 
 import (
@@ -87,10 +88,19 @@ func (w *Watcher) Next() bool {
 }
 
 // Event returns the event received that caused Next to return.
+// Events of updated and deleted keys carry the previous value of the key if
+// WatchesPrev returns true.
 func (w *Watcher) Event() kv.Event {
 	return w.event
 }
 
+// WatchesPrev returns whether the kv sends the previous values of updated and
+// deleted keys in events, see kv.WatchesPrev. Consumers that need to know what
+// changed must otherwise keep the values themselves.
+func (w *Watcher) WatchesPrev() bool {
+	return kv.WatchesPrev(w.kv)
+}
+
 // Err returns the last error received
 func (w *Watcher) Err() *Error {
 	return w.err
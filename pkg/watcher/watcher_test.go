@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/mistifyio/lochness/pkg/kv"
 	_ "github.com/mistifyio/lochness/pkg/kv/consul"
 	"github.com/mistifyio/lochness/pkg/watcher"
 	"github.com/pborman/uuid"
@@ -85,6 +87,8 @@ func (s *WatcherSuite) TestNextResponse() {
 	}
 
 	go func() {
+		// Events right after Add may be missed, see Watcher.Add
+		time.Sleep(10 * time.Millisecond)
 		for i := 0; i < len(prefixes); i++ {
 			for j := 0; j < len(prefixes); j++ {
 				_ = s.KV.Set(prefixes[j]+"/subkey", fmt.Sprint(i+j))
@@ -104,6 +108,33 @@ func (s *WatcherSuite) TestNextResponse() {
 
 }
 
+func (s *WatcherSuite) TestEventPrev() {
+	s.Require().True(s.Watcher.WatchesPrev())
+
+	prefix := uuid.New()
+	s.Require().NoError(s.KV.Set(prefix+"/foo", "foo"))
+	s.Require().NoError(s.Watcher.Add(prefix))
+
+	go func() {
+		// Events right after Add may be missed, see Watcher.Add
+		time.Sleep(10 * time.Millisecond)
+		_ = s.KV.Set(prefix+"/foo", "bar")
+	}()
+
+	// the existing key may be sent as a create first
+	s.Require().True(s.Watcher.Next())
+	event := s.Watcher.Event()
+	if event.Type == kv.Create {
+		s.Require().True(s.Watcher.Next())
+		event = s.Watcher.Event()
+	}
+	s.Equal(kv.Update, event.Type)
+	s.Equal("bar", string(event.Data))
+	if s.NotNil(event.Prev, "should have the previous value") {
+		s.Equal("foo", string(event.Prev.Data))
+	}
+}
+
 func (s *WatcherSuite) TestRemove() {
 	prefix := uuid.New()
 	s.Error(s.Watcher.Remove(prefix), "not watched prefix should fail")
@@ -0,0 +1,65 @@
+package lochness
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mistifyio/lochness/pkg/bmc"
+)
+
+var (
+	// ErrNoBMC is returned when controlling the power of a hypervisor without
+	// a BMC
+	ErrNoBMC = errors.New("hypervisor has no bmc")
+
+	// ErrNoBMCCredentials is returned when controlling the power of a
+	// hypervisor without a SecretIPMI secret
+	ErrNoBMCCredentials = errors.New("hypervisor has no bmc credentials")
+
+	// NewBMCController creates the controller Power drives a BMC with. It may
+	// be replaced, e.g. by tests.
+	NewBMCController = bmc.New
+)
+
+// BMC is the baseboard management controller of a hypervisor, which its power
+// is controlled through. It is logged into with the hypervisor's SecretIPMI
+// secret, whose value is "user:password".
+type BMC struct {
+	Protocol string `json:"protocol" schema:"required,enum=ipmi|redfish"` // ipmi or redfish
+	Address  string `json:"address" schema:"required"`                    // host for ipmi, service url for redfish
+}
+
+// Power performs a power action, one of bmc.PowerOn, bmc.PowerOff, or
+// bmc.PowerCycle, on a hypervisor through its BMC. The context of the
+// hypervisor must have the secret key to decrypt the BMC credentials.
+func (h *Hypervisor) Power(action string) error {
+	if !bmc.Actions[action] {
+		return newValidationError("action", "missing or invalid power action")
+	}
+	if h.BMC == nil {
+		return ErrNoBMC
+	}
+	secretID, ok := h.Secrets[SecretIPMI]
+	if !ok {
+		return ErrNoBMCCredentials
+	}
+
+	secret, err := h.context.Secret(secretID)
+	if err != nil {
+		return err
+	}
+	value, err := secret.Value()
+	if err != nil {
+		return err
+	}
+	creds, err := bmc.ParseCredentials(string(value))
+	if err != nil {
+		return fmt.Errorf("secret %s: %s", secret.ID, err)
+	}
+
+	controller, err := NewBMCController(h.BMC.Protocol, h.BMC.Address, creds)
+	if err != nil {
+		return err
+	}
+	return controller.Power(action)
+}
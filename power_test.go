@@ -0,0 +1,113 @@
+package lochness_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/mistifyio/lochness/pkg/bmc"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestPower(t *testing.T) {
+	suite.Run(t, new(PowerSuite))
+}
+
+type PowerSuite struct {
+	common.Suite
+	Actions []string
+	Creds   bmc.Credentials
+	Address string
+}
+
+// fakeBMC records the actions it performs on the suite
+type fakeBMC struct {
+	s *PowerSuite
+}
+
+func (f fakeBMC) Power(action string) error {
+	if action == bmc.PowerOff {
+		return errors.New("unreachable")
+	}
+	f.s.Actions = append(f.s.Actions, action)
+	return nil
+}
+
+func (s *PowerSuite) SetupTest() {
+	s.Suite.SetupTest()
+	s.Actions = nil
+	lochness.NewBMCController = func(protocol, address string, creds bmc.Credentials) (bmc.Controller, error) {
+		s.Address = address
+		s.Creds = creds
+		return fakeBMC{s}, nil
+	}
+}
+
+func (s *PowerSuite) TearDownTest() {
+	lochness.NewBMCController = bmc.New
+	s.Suite.TearDownTest()
+}
+
+// newHypervisor creates a hypervisor with a BMC and credentials, from a context
+// with the secret key
+func (s *PowerSuite) newHypervisor() *lochness.Hypervisor {
+	ctx, err := s.Context.WithSecretKey(common.SecretKey)
+	s.Require().NoError(err)
+	secret := ctx.NewSecret()
+	secret.Name = "bmc"
+	secret.Kind = lochness.SecretIPMI
+	s.Require().NoError(secret.SetValue([]byte("admin:secret")))
+	s.Require().NoError(secret.Save())
+
+	hypervisor := s.NewHypervisor()
+	hypervisor.BMC = &lochness.BMC{Protocol: bmc.ProtocolIPMI, Address: "10.0.0.5"}
+	hypervisor.Secrets = map[string]string{lochness.SecretIPMI: secret.ID}
+	s.Require().NoError(hypervisor.Save())
+
+	hypervisor, err = ctx.Hypervisor(hypervisor.ID)
+	s.Require().NoError(err)
+	return hypervisor
+}
+
+func (s *PowerSuite) TestPower() {
+	hypervisor := s.newHypervisor()
+
+	s.NoError(hypervisor.Power(bmc.PowerCycle))
+	s.Equal([]string{bmc.PowerCycle}, s.Actions)
+	s.Equal("10.0.0.5", s.Address)
+	s.Equal(bmc.Credentials{User: "admin", Password: "secret"}, s.Creds)
+
+	s.Error(hypervisor.Power(bmc.PowerOff), "bmc failures should be returned")
+	s.True(lerrors.IsValidation(hypervisor.Power("reboot")), "unknown actions should be invalid")
+}
+
+func (s *PowerSuite) TestPowerNotConfigured() {
+	hypervisor := s.newHypervisor()
+
+	keyless, err := s.Context.Hypervisor(hypervisor.ID)
+	s.Require().NoError(err)
+	s.Equal(lochness.ErrNoSecretKey, keyless.Power(bmc.PowerOn), "credentials should not be decrypted without the key")
+
+	hypervisor.Secrets = nil
+	s.Equal(lochness.ErrNoBMCCredentials, hypervisor.Power(bmc.PowerOn))
+
+	hypervisor.BMC = nil
+	s.Equal(lochness.ErrNoBMC, hypervisor.Power(bmc.PowerOn))
+	s.Empty(s.Actions)
+}
+
+func (s *PowerSuite) TestValidateBMC() {
+	hypervisor := s.NewHypervisor()
+	hypervisor.BMC = &lochness.BMC{Protocol: "amt", Address: "10.0.0.5"}
+	s.True(lerrors.IsValidation(hypervisor.Validate()), "unknown protocols should be invalid")
+	hypervisor.BMC = &lochness.BMC{Protocol: bmc.ProtocolRedfish}
+	s.True(lerrors.IsValidation(hypervisor.Validate()), "bmcs need an address")
+	hypervisor.BMC = &lochness.BMC{Protocol: bmc.ProtocolRedfish, Address: "https://10.0.0.5"}
+	s.NoError(hypervisor.Save())
+
+	found, err := s.Context.Hypervisor(hypervisor.ID)
+	s.Require().NoError(err)
+	s.Equal(hypervisor.BMC, found.BMC, "bmc should be persisted")
+}
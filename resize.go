@@ -0,0 +1,95 @@
+package lochness
+
+import (
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/pborman/uuid"
+)
+
+// max returns the larger of each of the resources of r and o
+func (r Resources) max(o Resources) Resources {
+	if o.Memory > r.Memory {
+		r.Memory = o.Memory
+	}
+	if o.Disk > r.Disk {
+		r.Disk = o.Disk
+	}
+	if o.CPU > r.CPU {
+		r.CPU = o.CPU
+	}
+	return r
+}
+
+// Resize checks that the guest may be resized to the flavor and records the
+// flavor as the one the guest is being resized to, without saving the guest.
+// The guest keeps its flavor until the resize is done, see CompleteResize, and
+// holds the larger of both flavors' resources on its hypervisor meanwhile.
+//
+// Guests must be on a hypervisor with the available resources for what the
+// flavor adds, as their disks can not be moved to another. Disks can not
+// shrink. Conflict errors are returned for guests that can not be resized
+// where they are, and validation errors for flavors they can not be resized
+// to.
+func (g *Guest) Resize(flavorID string) (*Flavor, error) {
+	if g.HypervisorID == "" {
+		return nil, lerrors.Conflictf("can not resize guest %s, which is not on a hypervisor", g.ID)
+	}
+	if g.ResizeFlavor != "" {
+		return nil, lerrors.Conflictf("guest %s is already being resized to flavor %s", g.ID, g.ResizeFlavor)
+	}
+
+	if uuid.Parse(flavorID) == nil {
+		return nil, newValidationError("flavor", "missing or invalid flavor")
+	}
+	to, err := g.context.Flavor(flavorID)
+	if err != nil {
+		if g.context.IsKeyNotFound(err) {
+			return nil, newValidationError("flavor", "flavor does not exist")
+		}
+		return nil, err
+	}
+	if to.ID == g.FlavorID {
+		return nil, newValidationError("flavor", "guest is already of the flavor")
+	}
+	from, err := g.context.Flavor(g.FlavorID)
+	if err != nil {
+		return nil, err
+	}
+	if to.Disk < from.Disk {
+		return nil, newValidationError("flavor", "disks can not shrink")
+	}
+
+	hypervisor, err := g.context.Hypervisor(g.HypervisorID)
+	if err != nil {
+		return nil, err
+	}
+	if err := hypervisor.CheckResize(from, to); err != nil {
+		if lerrors.IsValidation(err) {
+			return nil, lerrors.Conflict(err)
+		}
+		return nil, err
+	}
+
+	g.ResizeFlavor = to.ID
+	return to, nil
+}
+
+// CompleteResize makes the flavor the guest was being resized to its flavor
+// and saves the guest
+func (g *Guest) CompleteResize() error {
+	if g.ResizeFlavor == "" {
+		return nil
+	}
+	g.FlavorID = g.ResizeFlavor
+	g.ResizeFlavor = ""
+	return g.Save()
+}
+
+// CancelResize drops the flavor the guest was being resized to and saves the
+// guest, which keeps its flavor
+func (g *Guest) CancelResize() error {
+	if g.ResizeFlavor == "" {
+		return nil
+	}
+	g.ResizeFlavor = ""
+	return g.Save()
+}
@@ -0,0 +1,153 @@
+package lochness_test
+
+import (
+	"testing"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestResize(t *testing.T) {
+	suite.Run(t, new(ResizeSuite))
+}
+
+type ResizeSuite struct {
+	common.Suite
+}
+
+// newFlavor creates and saves a flavor of the resources given
+func (s *ResizeSuite) newFlavor(r lochness.Resources) *lochness.Flavor {
+	flavor := s.NewFlavor()
+	flavor.Resources = r
+	s.Require().NoError(flavor.Save())
+	return flavor
+}
+
+func (s *ResizeSuite) TestCheckResize() {
+	hypervisor := s.NewHypervisor()
+	from := s.newFlavor(lochness.Resources{Memory: 1024, Disk: 4096, CPU: 2})
+	to := s.newFlavor(lochness.Resources{Memory: 2048, Disk: 8192, CPU: 4})
+
+	s.Require().NoError(hypervisor.SetConfig(lochness.CPUOvercommitConfig, "2"))
+	hypervisor.AvailableResources = lochness.Resources{Memory: 1024, Disk: 4096, CPU: 2}
+	s.NoError(hypervisor.CheckResize(from, to), "only the growth should be needed")
+
+	hypervisor.AvailableResources.Memory = 1023
+	err := hypervisor.CheckResize(from, to)
+	s.True(lerrors.IsValidation(err))
+	verr, _ := err.(*lochness.ValidationError)
+	if s.NotNil(verr) {
+		s.Equal([]string{"memory"}, verr.Fields)
+	}
+
+	// cpus are not allocated without a cpu overcommit ratio, so all of the
+	// new flavor's are needed
+	s.Require().NoError(hypervisor.SetConfig(lochness.CPUOvercommitConfig, ""))
+	hypervisor.AvailableResources = lochness.Resources{Memory: 1024, Disk: 4096, CPU: 3}
+	s.Error(hypervisor.CheckResize(from, to))
+	hypervisor.AvailableResources.CPU = 4
+	s.NoError(hypervisor.CheckResize(from, to))
+}
+
+func (s *ResizeSuite) TestResize() {
+	to := s.newFlavor(lochness.Resources{Memory: 256, Disk: 2048, CPU: 2})
+
+	_, err := s.NewGuest().Resize(to.ID)
+	s.True(lerrors.IsConflict(err), "guests not on a hypervisor should not be resized")
+
+	hypervisor, guest := s.NewHypervisorWithGuest()
+
+	tests := []struct {
+		description string
+		flavorID    string
+	}{
+		{"invalid flavor", "foo"},
+		{"missing flavor", uuid.New()},
+		{"same flavor", guest.FlavorID},
+		{"smaller disk", s.newFlavor(lochness.Resources{Memory: 256, Disk: 512, CPU: 2}).ID},
+	}
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		_, err := guest.Resize(test.flavorID)
+		s.True(lerrors.IsValidation(err), msg("should be a validation error"))
+		s.False(lerrors.IsConflict(err), msg("should not be a conflict"))
+	}
+
+	hypervisor.AvailableResources = lochness.Resources{}
+	s.Require().NoError(hypervisor.Save())
+	_, err = guest.Resize(to.ID)
+	s.True(lerrors.IsConflict(err), "guests should not be resized past their hypervisor's resources")
+	s.Contains(err.Error(), hypervisor.ID)
+
+	hypervisor.AvailableResources = hypervisor.TotalResources
+	s.Require().NoError(hypervisor.Save())
+	flavor, err := guest.Resize(to.ID)
+	s.Require().NoError(err)
+	s.Equal(to.ID, flavor.ID)
+	s.Equal(to.ID, guest.ResizeFlavor)
+	s.NotEqual(to.ID, guest.FlavorID)
+	s.Require().NoError(guest.Save())
+
+	_, err = guest.Resize(to.ID)
+	s.True(lerrors.IsConflict(err), "guests should only be resized once at a time")
+}
+
+func (s *ResizeSuite) TestCompleteResize() {
+	_, guest := s.NewHypervisorWithGuest()
+	to := s.newFlavor(lochness.Resources{Memory: 256, Disk: 2048, CPU: 2})
+	_, err := guest.Resize(to.ID)
+	s.Require().NoError(err)
+	s.Require().NoError(guest.Save())
+
+	s.Require().NoError(guest.CompleteResize())
+	saved, err := s.Context.Guest(guest.ID)
+	s.Require().NoError(err)
+	s.Equal(to.ID, saved.FlavorID)
+	s.Empty(saved.ResizeFlavor)
+
+	s.NoError(saved.CompleteResize(), "guests not being resized are left as they are")
+}
+
+func (s *ResizeSuite) TestCancelResize() {
+	_, guest := s.NewHypervisorWithGuest()
+	from := guest.FlavorID
+	to := s.newFlavor(lochness.Resources{Memory: 256, Disk: 2048, CPU: 2})
+	_, err := guest.Resize(to.ID)
+	s.Require().NoError(err)
+	s.Require().NoError(guest.Save())
+
+	s.Require().NoError(guest.CancelResize())
+	saved, err := s.Context.Guest(guest.ID)
+	s.Require().NoError(err)
+	s.Equal(from, saved.FlavorID)
+	s.Empty(saved.ResizeFlavor)
+}
+
+func (s *ResizeSuite) TestUpdateResources() {
+	hypervisor, guest := s.NewHypervisorWithGuest()
+	_ = hypervisor.SetConfig("guestDiskDir", "/")
+	_, _ = lochness.SetHypervisorID(hypervisor.ID)
+	s.Require().NoError(hypervisor.SetConfig(lochness.CPUOvercommitConfig, "64"))
+
+	from, err := s.Context.Flavor(guest.FlavorID)
+	s.Require().NoError(err)
+	to := s.newFlavor(lochness.Resources{Memory: from.Memory / 2, Disk: from.Disk * 2, CPU: from.CPU * 2})
+	_, err = guest.Resize(to.ID)
+	s.Require().NoError(err)
+	s.Require().NoError(guest.Save())
+
+	s.Require().NoError(hypervisor.UpdateResources())
+	tr := hypervisor.TotalResources
+	ar := hypervisor.AvailableResources
+	s.Equal(tr.Memory-from.Memory, ar.Memory, "the larger memory should be held")
+	s.Equal(tr.Disk-to.Disk, ar.Disk, "the larger disk should be held")
+	// The guest's vcpus may exceed the capacity of a small machine
+	cpu := uint32(0)
+	if tr.CPU*64 > to.CPU {
+		cpu = tr.CPU*64 - to.CPU
+	}
+	s.Equal(cpu, ar.CPU, "the larger cpus should be held")
+}
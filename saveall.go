@@ -0,0 +1,226 @@
+package lochness
+
+import (
+	"encoding/json"
+	"net"
+
+	"github.com/mistifyio/lochness/pkg/kv"
+)
+
+// Saver is an entity, or a change to one, that can be saved along with others
+// by SaveAll. It is implemented by Flavor, FWGroup, Guest, Hypervisor, Network,
+// and Subnet, whose Save saves them alone.
+type Saver interface {
+	// saveOps validates the change and returns its index-checked writes
+	saveOps() ([]kv.Op, error)
+	// saved is given the modified indexes of the writes once applied, and
+	// updates what is derived from them, e.g. the hypervisor generation
+	saved(indexes []uint64) error
+}
+
+// SaveAll saves the entities together with kv.Txn, none of them if any fails
+// to validate. On transactional KVs, such as consul, they are all saved or, if
+// any was modified since it was loaded, none of them is. On others, such as
+// etcd, the writes are made one by one under the kv.Txn lock and undone once
+// one fails, so that other clients may briefly see some of them saved, and a
+// failed undo is returned as a kv.UndoError. The metadata index is written
+// along with the entities, while what else is derived from them, e.g. the
+// generation of a guest's hypervisor, is updated once they are saved.
+func (c *Context) SaveAll(entities ...Saver) error {
+	var ops []kv.Op
+	counts := make([]int, len(entities))
+	for i, e := range entities {
+		eOps, err := e.saveOps()
+		if err != nil {
+			return err
+		}
+		ops = append(ops, eOps...)
+		counts[i] = len(eOps)
+	}
+
+	indexes, err := kv.Txn(c.kv, ops)
+	if err != nil {
+		return err
+	}
+
+	var savedErr error
+	for i, e := range entities {
+		if err := e.saved(indexes[:counts[i]]); err != nil && savedErr == nil {
+			savedErr = err
+		}
+		indexes = indexes[counts[i]:]
+	}
+	return savedErr
+}
+
+// entityOp is the write saving an entity at key, checked against the index it
+// was loaded at
+func entityOp(key string, entity interface{}, index uint64) ([]kv.Op, error) {
+	v, err := json.Marshal(entity)
+	if err != nil {
+		return nil, err
+	}
+	return []kv.Op{{Key: key, Value: kv.Value{Data: v, Index: index}}}, nil
+}
+
+func (f *Flavor) saveOps() ([]kv.Op, error) {
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	return entityOp(f.key(), f, f.modifiedIndex)
+}
+
+func (f *Flavor) saved(indexes []uint64) error {
+	f.modifiedIndex = indexes[0]
+	return nil
+}
+
+func (f *FWGroup) saveOps() ([]kv.Op, error) {
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	if err := f.checkReferences(); err != nil {
+		return nil, err
+	}
+	// if we changed something, don't clobber
+	return entityOp(f.key(), f, f.modifiedIndex)
+}
+
+func (f *FWGroup) saved(indexes []uint64) error {
+	f.modifiedIndex = indexes[0]
+	return nil
+}
+
+func (g *Guest) saveOps() ([]kv.Op, error) {
+	if err := g.Validate(); err != nil {
+		return nil, err
+	}
+	ops, err := entityOp(g.key(), g, g.modifiedIndex)
+	if err != nil {
+		return nil, err
+	}
+	dnsOps, err := g.dnsOps()
+	if err != nil {
+		return nil, err
+	}
+	metadataOps, err := g.context.metadataOps(MetadataKindGuests, g.ID, g.indexedMetadata, g.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	ops = append(ops, dnsOps...)
+	return append(ops, metadataOps...), nil
+}
+
+func (g *Guest) saved(indexes []uint64) error {
+	g.modifiedIndex = indexes[0]
+	g.savedDNSName = g.DNSName
+	g.indexedMetadata = copyMetadata(g.Metadata)
+
+	// the desired state of the hypervisors the guest left or joined, or of
+	// the one it stayed on if it changed otherwise
+	prev := g.savedDesired
+	g.savedDesired = g.desired()
+	if prev == g.savedDesired {
+		return nil
+	}
+	hypervisorIDs := []string{prev.hypervisorID}
+	if g.HypervisorID != prev.hypervisorID {
+		hypervisorIDs = append(hypervisorIDs, g.HypervisorID)
+	}
+	for _, id := range hypervisorIDs {
+		if id == "" {
+			continue
+		}
+		if _, err := g.context.blankHypervisor(id).BumpGeneration(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *Hypervisor) saveOps() ([]kv.Op, error) {
+	if err := h.Validate(); err != nil {
+		return nil, err
+	}
+	ops, err := entityOp(h.key(), h, h.modifiedIndex)
+	if err != nil {
+		return nil, err
+	}
+	metadataOps, err := h.context.metadataOps(MetadataKindHypervisors, h.ID, h.indexedMetadata, h.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	return append(ops, metadataOps...), nil
+}
+
+func (h *Hypervisor) saved(indexes []uint64) error {
+	h.modifiedIndex = indexes[0]
+	h.indexedMetadata = copyMetadata(h.Metadata)
+	return nil
+}
+
+func (n *Network) saveOps() ([]kv.Op, error) {
+	if err := n.Validate(); err != nil {
+		return nil, err
+	}
+	return entityOp(n.key(), n, n.modifiedIndex)
+}
+
+func (n *Network) saved(indexes []uint64) error {
+	n.modifiedIndex = indexes[0]
+	return nil
+}
+
+func (s *Subnet) saveOps() ([]kv.Op, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	if err := s.context.CheckSubnetOverlap(s); err != nil {
+		return nil, err
+	}
+	return entityOp(s.key(), s, s.modifiedIndex)
+}
+
+func (s *Subnet) saved(indexes []uint64) error {
+	s.modifiedIndex = indexes[0]
+	return nil
+}
+
+// addressReservation reserves an address of a subnet for a guest. It fails if
+// the address is already reserved.
+type addressReservation struct {
+	subnet  *Subnet
+	ip      net.IP
+	guestID string
+}
+
+func (r addressReservation) saveOps() ([]kv.Op, error) {
+	return []kv.Op{{
+		Key:   r.subnet.addressKey(r.ip.String()),
+		Value: kv.Value{Data: []byte(r.guestID)},
+	}}, nil
+}
+
+func (r addressReservation) saved([]uint64) error {
+	r.subnet.addresses[ipToI32(r.ip)] = r.guestID
+	return nil
+}
+
+// hypervisorGuest adds a guest to a hypervisor's guests. It fails if the guest
+// is already one of them.
+type hypervisorGuest struct {
+	hypervisor *Hypervisor
+	guest      *Guest
+}
+
+func (hg hypervisorGuest) saveOps() ([]kv.Op, error) {
+	return []kv.Op{{
+		Key:   hg.hypervisor.guestKey(hg.guest),
+		Value: kv.Value{Data: []byte(hg.guest.ID)},
+	}}, nil
+}
+
+func (hg hypervisorGuest) saved([]uint64) error {
+	hg.hypervisor.guests = append(hg.hypervisor.guests, hg.guest.ID)
+	return nil
+}
@@ -0,0 +1,52 @@
+package lochness_test
+
+import (
+	"testing"
+
+	"github.com/mistifyio/lochness/internal/tests/common"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestSaveAll(t *testing.T) {
+	suite.Run(t, new(SaveAllSuite))
+}
+
+type SaveAllSuite struct {
+	common.Suite
+}
+
+func (s *SaveAllSuite) TestSaveAll() {
+	hypervisor := s.NewHypervisor()
+	guest := s.NewGuest()
+
+	// a concurrent change makes the guest stale
+	other, err := s.Context.Guest(guest.ID)
+	s.Require().NoError(err)
+	s.Require().NoError(other.Save())
+
+	hypervisor.Metadata["rack"] = "a1"
+	guest.Metadata["env"] = "prod"
+	s.True(lerrors.IsConflict(s.Context.SaveAll(hypervisor, guest)))
+	loaded, err := s.Context.Hypervisor(hypervisor.ID)
+	s.Require().NoError(err)
+	s.Empty(loaded.Metadata["rack"], "nothing should be saved")
+
+	// invalid entities are not saved either
+	guest = other
+	guest.Metadata["env"] = "prod"
+	invalid := s.Context.NewGuest()
+	s.True(lerrors.IsValidation(s.Context.SaveAll(hypervisor, guest, invalid)))
+
+	s.Require().NoError(s.Context.SaveAll(hypervisor, guest))
+	loaded, err = s.Context.Hypervisor(hypervisor.ID)
+	s.Require().NoError(err)
+	s.Equal("a1", loaded.Metadata["rack"])
+	loadedGuest, err := s.Context.Guest(guest.ID)
+	s.Require().NoError(err)
+	s.Equal("prod", loadedGuest.Metadata["env"])
+
+	// the indexes of the saved entities are kept
+	s.NoError(hypervisor.Save())
+	s.NoError(guest.Save())
+}
@@ -0,0 +1,202 @@
+package lochness
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"time"
+
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/mistifyio/lochness/pkg/kv"
+	"github.com/pborman/uuid"
+	"github.com/robfig/cron"
+)
+
+var (
+	// SchedulePath is the path in the config store
+	SchedulePath = "lochness/schedules/"
+
+	// ScheduleActions are the guest job actions a Schedule may run
+	ScheduleActions = map[string]bool{
+		"shutdown": true,
+		"reboot":   true,
+		"restart":  true,
+		"poweroff": true,
+		"start":    true,
+		"suspend":  true,
+		"snapshot": true,
+	}
+)
+
+type (
+	// Schedule runs an action at the times given by a cron expression. The
+	// action is run on a guest, or on every guest of a hypervisor.
+	Schedule struct {
+		context       *Context
+		modifiedIndex uint64
+		ID            string            `json:"id"`
+		GuestID       string            `json:"guest,omitempty"`
+		HypervisorID  string            `json:"hypervisor,omitempty"`
+		Action        string            `json:"action"`
+		Cron          string            `json:"cron"` // standard 5 field cron expression, or a descriptor like @daily
+		Disabled      bool              `json:"disabled"`
+		Created       time.Time         `json:"created"`
+		LastRun       time.Time         `json:"last_run"` // runs due up to this time have been handled
+		Metadata      map[string]string `json:"metadata"`
+	}
+
+	// Schedules is an alias to a slice of *Schedule
+	Schedules []*Schedule
+)
+
+// NewSchedule creates a blank Schedule
+func (c *Context) NewSchedule() *Schedule {
+	return &Schedule{
+		context:  c,
+		ID:       uuid.New(),
+		Created:  time.Now(),
+		Metadata: make(map[string]string),
+	}
+}
+
+// Schedule fetches a Schedule from the config store
+func (c *Context) Schedule(id string) (*Schedule, error) {
+	var err error
+	id, err = canonicalizeUUID(id)
+	if err != nil {
+		return nil, err
+	}
+	s := &Schedule{
+		context: c,
+		ID:      id,
+	}
+
+	if err := s.Refresh(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// key is a helper to generate the config store key
+func (s *Schedule) key() string {
+	return filepath.Join(SchedulePath, s.ID, "metadata")
+}
+
+// fromResponse is a helper to unmarshal a Schedule
+func (s *Schedule) fromResponse(value kv.Value) error {
+	s.modifiedIndex = value.Index
+	return json.Unmarshal(value.Data, &s)
+}
+
+// Refresh reloads from the data store
+func (s *Schedule) Refresh() error {
+	resp, err := s.context.kv.Get(s.key())
+	if err != nil {
+		return err
+	}
+
+	return s.fromResponse(resp)
+}
+
+// Validate ensures a Schedule has reasonable data.
+func (s *Schedule) Validate() error {
+	if _, err := canonicalizeUUID(s.ID); err != nil {
+		return newValidationError("id", "missing or invalid id")
+	}
+	if (s.GuestID == "") == (s.HypervisorID == "") {
+		return &ValidationError{
+			Fields:  []string{"guest", "hypervisor"},
+			Message: "exactly one of guest or hypervisor is required",
+		}
+	}
+	if s.GuestID != "" {
+		if _, err := canonicalizeUUID(s.GuestID); err != nil {
+			return newValidationError("guest", "invalid guest")
+		}
+	}
+	if s.HypervisorID != "" {
+		if _, err := canonicalizeUUID(s.HypervisorID); err != nil {
+			return newValidationError("hypervisor", "invalid hypervisor")
+		}
+	}
+	if !ScheduleActions[s.Action] {
+		return newValidationError("action", "missing or invalid action")
+	}
+	if _, err := cron.ParseStandard(s.Cron); err != nil {
+		return newValidationError("cron", "missing or invalid cron expression")
+	}
+
+	return nil
+}
+
+// Next returns the first time after t at which the action is due
+func (s *Schedule) Next(t time.Time) (time.Time, error) {
+	sched, err := cron.ParseStandard(s.Cron)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return sched.Next(t), nil
+}
+
+// Due returns the time the action is next due, counting from the last run, or
+// from the creation of the schedule if it has not run yet. A due time in the
+// past is a run that is still pending.
+func (s *Schedule) Due() (time.Time, error) {
+	from := s.LastRun
+	if from.IsZero() {
+		from = s.Created
+	}
+	return s.Next(from)
+}
+
+// Save persists the Schedule to the data store.
+func (s *Schedule) Save() error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+
+	v, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	index, err := s.context.kv.Update(s.key(), kv.Value{Data: v, Index: s.modifiedIndex})
+	if err != nil {
+		return err
+	}
+	s.modifiedIndex = index
+	return nil
+}
+
+// Destroy removes a Schedule
+func (s *Schedule) Destroy() error {
+	if s.modifiedIndex == 0 {
+		// it has not been saved?
+		return lerrors.NotFound(errors.New("not persisted"))
+	}
+
+	if err := s.context.kv.Remove(s.key(), s.modifiedIndex); err != nil {
+		return err
+	}
+	return s.context.kv.Delete(filepath.Join(SchedulePath, s.ID), true)
+}
+
+// ForEachSchedule will run f on each Schedule. It will stop iteration if f
+// returns an error.
+func (c *Context) ForEachSchedule(f func(*Schedule) error) error {
+	keys, err := c.kv.Keys(SchedulePath)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		s, err := c.Schedule(filepath.Base(k))
+		if err != nil {
+			return err
+		}
+
+		if err := f(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
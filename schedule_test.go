@@ -0,0 +1,197 @@
+package lochness_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestSchedule(t *testing.T) {
+	suite.Run(t, new(ScheduleSuite))
+}
+
+type ScheduleSuite struct {
+	common.Suite
+}
+
+func (s *ScheduleSuite) TestNewSchedule() {
+	sched := s.Context.NewSchedule()
+	s.NotNil(uuid.Parse(sched.ID))
+	s.False(sched.Created.IsZero())
+}
+
+func (s *ScheduleSuite) TestSchedule() {
+	sched := s.NewSchedule()
+
+	tests := []struct {
+		description string
+		ID          string
+		expectedErr bool
+	}{
+		{"missing id", "", true},
+		{"invalid ID", "adf", true},
+		{"nonexistant ID", uuid.New(), true},
+		{"real ID", sched.ID, false},
+	}
+
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		sc, err := s.Context.Schedule(test.ID)
+		if test.expectedErr {
+			s.Error(err, msg("lookup should fail"))
+			s.Nil(sc, msg("failure shouldn't return a schedule"))
+		} else {
+			s.NoError(err, msg("lookup should succeed"))
+			s.Equal(sched.GuestID, sc.GuestID, msg("success should return correct data"))
+			s.Equal(sched.Cron, sc.Cron, msg("success should return correct data"))
+			s.True(sched.Created.Equal(sc.Created), msg("success should return correct data"))
+		}
+	}
+}
+
+func (s *ScheduleSuite) TestRefresh() {
+	sched := s.NewSchedule()
+	schedCopy, err := s.Context.Schedule(sched.ID)
+	s.Require().NoError(err)
+
+	sched.Cron = "0 3 * * *"
+	s.Require().NoError(sched.Save())
+	s.NoError(schedCopy.Refresh(), "refresh existing should succeed")
+	s.Equal(sched.Cron, schedCopy.Cron, "refresh should pull new data")
+
+	newSched := s.Context.NewSchedule()
+	s.Error(newSched.Refresh(), "unsaved schedule refresh should fail")
+}
+
+func (s *ScheduleSuite) TestValidate() {
+	id := uuid.New()
+	tests := []struct {
+		description string
+		ID          string
+		guest       string
+		hypervisor  string
+		action      string
+		cron        string
+		expectedErr bool
+	}{
+		{"missing ID", "", id, "", "reboot", "@daily", true},
+		{"non uuid ID", "asdf", id, "", "reboot", "@daily", true},
+		{"missing target", id, "", "", "reboot", "@daily", true},
+		{"both targets", id, id, id, "reboot", "@daily", true},
+		{"non uuid guest", id, "asdf", "", "reboot", "@daily", true},
+		{"non uuid hypervisor", id, "", "asdf", "reboot", "@daily", true},
+		{"missing action", id, id, "", "", "@daily", true},
+		{"invalid action", id, id, "", "delete", "@daily", true},
+		{"missing cron", id, id, "", "reboot", "", true},
+		{"invalid cron", id, id, "", "reboot", "* * *", true},
+		{"seconds cron", id, id, "", "reboot", "0 0 3 * * *", true},
+		{"guest", id, id, "", "reboot", "@daily", false},
+		{"hypervisor", id, "", id, "snapshot", "30 2 * * 0", false},
+	}
+
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		sched := &lochness.Schedule{
+			ID:           test.ID,
+			GuestID:      test.guest,
+			HypervisorID: test.hypervisor,
+			Action:       test.action,
+			Cron:         test.cron,
+		}
+		err := sched.Validate()
+		if test.expectedErr {
+			s.Error(err, msg("should be invalid"))
+		} else {
+			s.NoError(err, msg("should be valid"))
+		}
+	}
+}
+
+func (s *ScheduleSuite) TestDue() {
+	sched := s.Context.NewSchedule()
+	sched.Cron = "0 3 * * *"
+	sched.Created = time.Date(2016, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	due, err := sched.Due()
+	s.NoError(err)
+	s.Equal(time.Date(2016, 1, 2, 3, 0, 0, 0, time.UTC), due, "should count from creation")
+
+	sched.LastRun = due
+	due, err = sched.Due()
+	s.NoError(err)
+	s.Equal(time.Date(2016, 1, 3, 3, 0, 0, 0, time.UTC), due, "should count from the last run")
+
+	sched.Cron = "asdf"
+	_, err = sched.Due()
+	s.Error(err, "invalid cron should fail")
+}
+
+func (s *ScheduleSuite) TestSave() {
+	goodSchedule := s.Context.NewSchedule()
+	goodSchedule.GuestID = uuid.New()
+	goodSchedule.Action = "reboot"
+	goodSchedule.Cron = "@weekly"
+
+	clobberSchedule := *goodSchedule
+
+	tests := []struct {
+		description string
+		schedule    *lochness.Schedule
+		expectedErr bool
+	}{
+		{"invalid schedule", &lochness.Schedule{}, true},
+		{"valid schedule", goodSchedule, false},
+		{"existing schedule", goodSchedule, false},
+		{"existing schedule clobber", &clobberSchedule, true},
+	}
+
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		err := test.schedule.Save()
+		if test.expectedErr {
+			s.Error(err, msg("should fail"))
+		} else {
+			s.NoError(err, msg("should succeed"))
+		}
+	}
+}
+
+func (s *ScheduleSuite) TestDestroy() {
+	sched := s.NewSchedule()
+	newSched := s.Context.NewSchedule()
+
+	s.Error(newSched.Destroy(), "unsaved schedule destroy should fail")
+	s.NoError(sched.Destroy(), "existing schedule destroy should succeed")
+	_, err := s.Context.Schedule(sched.ID)
+	s.Error(err, "destroyed schedule should be gone")
+}
+
+func (s *ScheduleSuite) TestForEachSchedule() {
+	sched := s.NewSchedule()
+	sched2 := s.NewSchedule()
+	expectedFound := map[string]bool{
+		sched.ID:  true,
+		sched2.ID: true,
+	}
+
+	resultFound := make(map[string]bool)
+
+	err := s.Context.ForEachSchedule(func(sc *lochness.Schedule) error {
+		resultFound[sc.ID] = true
+		return nil
+	})
+	s.NoError(err)
+	s.Equal(expectedFound, resultFound)
+
+	returnErr := errors.New("an error")
+	err = s.Context.ForEachSchedule(func(sc *lochness.Schedule) error {
+		return returnErr
+	})
+	s.Error(err)
+	s.Equal(returnErr, err)
+}
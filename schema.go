@@ -0,0 +1,42 @@
+package lochness
+
+import (
+	"sort"
+
+	"github.com/mistifyio/lochness/pkg/jsonschema"
+)
+
+// schemaEntities are the entities with JSON Schemas, by name
+var schemaEntities = map[string]struct {
+	title string
+	value interface{}
+}{
+	"flavor":     {"Flavor", Flavor{}},
+	"fwgroup":    {"FWGroup", FWGroup{}},
+	"guest":      {"Guest", Guest{}},
+	"hypervisor": {"Hypervisor", Hypervisor{}},
+	"network":    {"Network", Network{}},
+	"subnet":     {"Subnet", Subnet{}},
+}
+
+// SchemaEntities returns the names of the entities with JSON Schemas, e.g.
+// "guest", in order
+func SchemaEntities() []string {
+	names := make([]string, 0, len(schemaEntities))
+	for name := range schemaEntities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// EntitySchema returns the JSON Schema of an entity, generated from its struct
+// and schema tags, or nil if it has none. Fields required by the schema are
+// those required to create the entity, which the server does not fill in.
+func EntitySchema(name string) *jsonschema.Schema {
+	entity, ok := schemaEntities[name]
+	if !ok {
+		return nil
+	}
+	return jsonschema.Generate(entity.title, entity.value)
+}
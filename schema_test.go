@@ -0,0 +1,45 @@
+package lochness_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestSchema(t *testing.T) {
+	suite.Run(t, new(SchemaSuite))
+}
+
+type SchemaSuite struct {
+	common.Suite
+}
+
+func (s *SchemaSuite) TestEntitySchema() {
+	s.Nil(lochness.EntitySchema("foobar"))
+
+	guest := lochness.EntitySchema("guest")
+	s.Equal("Guest", guest.Title)
+	s.Equal([]string{"flavor", "network"}, guest.Required)
+	s.True(guest.Properties["hypervisor"].ReadOnly)
+
+	// saved entities are valid by their schemas
+	entities := map[string]interface{}{
+		"flavor":     s.NewFlavor(),
+		"fwgroup":    s.NewFWGroup(),
+		"guest":      s.NewGuest(),
+		"hypervisor": s.NewHypervisor(),
+		"network":    s.NewNetwork(),
+		"subnet":     s.NewSubnet(),
+	}
+	s.Len(entities, len(lochness.SchemaEntities()))
+	for _, name := range lochness.SchemaEntities() {
+		data, err := json.Marshal(entities[name])
+		s.Require().NoError(err, name)
+		var v interface{}
+		s.Require().NoError(json.Unmarshal(data, &v), name)
+		s.Empty(lochness.EntitySchema(name).Validate(v), name)
+	}
+}
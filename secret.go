@@ -0,0 +1,372 @@
+package lochness
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/mistifyio/lochness/pkg/kv"
+	"github.com/pborman/uuid"
+)
+
+// Secret kinds
+const (
+	// SecretAgentToken is a token a hypervisor's agent authenticates with
+	SecretAgentToken = "agent-token"
+	// SecretIPMI is the credentials of a hypervisor's IPMI interface
+	SecretIPMI = "ipmi"
+	// SecretRootPassword is the root password of a guest
+	SecretRootPassword = "root-password"
+)
+
+var (
+	// SecretPath is the path in the config store for secrets
+	SecretPath = "lochness/secrets/"
+
+	// SecretKinds are the kinds of credentials a Secret may hold
+	SecretKinds = map[string]bool{
+		SecretAgentToken:   true,
+		SecretIPMI:         true,
+		SecretRootPassword: true,
+	}
+
+	// MaxSecretSize is the largest value a Secret may hold
+	MaxSecretSize = 4096
+
+	// ErrNoSecretKey is returned when encrypting or decrypting a Secret with
+	// a Context that has no secret key
+	ErrNoSecretKey = errors.New("no secret key")
+)
+
+// SecretKeySize is the size in bytes of the key secrets are encrypted with
+const SecretKeySize = 32
+
+type (
+	// Secret is a credential, such as an agent token or a root password,
+	// stored apart from the guests and hypervisors that reference it. Its
+	// value is always encrypted in the config store, with AES-256-GCM under
+	// the secret key of the Context, and is only returned by Value.
+	Secret struct {
+		context       *Context
+		modifiedIndex uint64
+		nonce         []byte
+		ciphertext    []byte
+		ID            string    `json:"id"`
+		Name          string    `json:"name"`
+		Kind          string    `json:"kind"`
+		Version       int       `json:"version"` // incremented by each new value
+		CreatedAt     time.Time `json:"created_at"`
+		UpdatedAt     time.Time `json:"updated_at"` // when the current value was set
+	}
+
+	// Secrets is an alias to a slice of *Secret
+	Secrets []*Secret
+
+	// secretJSON is how a Secret is persisted, with its encrypted value
+	secretJSON struct {
+		Secret
+		Nonce      []byte `json:"nonce"`
+		Ciphertext []byte `json:"ciphertext"`
+	}
+)
+
+// ParseSecretKey parses a secret key from its hex encoding, ignoring
+// surrounding whitespace
+func ParseSecretKey(data []byte) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != SecretKeySize {
+		return nil, fmt.Errorf("secret key must be %d hex encoded bytes", SecretKeySize)
+	}
+	return key, nil
+}
+
+// ReadSecretKeyFile reads a hex encoded secret key from a file, e.g. one
+// created with `openssl rand -hex 32`
+func ReadSecretKeyFile(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSecretKey(data)
+}
+
+// WithSecretKey returns a copy of the context that encrypts and decrypts
+// secret values with key, which must be SecretKeySize bytes
+func (c *Context) WithSecretKey(key []byte) (*Context, error) {
+	if len(key) != SecretKeySize {
+		return nil, fmt.Errorf("secret key must be %d bytes", SecretKeySize)
+	}
+	ctx := c.withPolicy(c.policy)
+	ctx.secretKey = append([]byte(nil), key...)
+	return ctx, nil
+}
+
+// NewSecret creates a blank Secret
+func (c *Context) NewSecret() *Secret {
+	return &Secret{
+		context: c,
+		ID:      uuid.New(),
+	}
+}
+
+// Secret fetches a single Secret from the config store
+func (c *Context) Secret(id string) (*Secret, error) {
+	var err error
+	id, err = canonicalizeUUID(id)
+	if err != nil {
+		return nil, err
+	}
+	s := &Secret{
+		context: c,
+		ID:      id,
+	}
+
+	if err := s.Refresh(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ForEachSecret will run f on each Secret. It will stop iteration if f returns
+// an error.
+func (c *Context) ForEachSecret(f func(*Secret) error) error {
+	keys, err := c.kv.Keys(SecretPath)
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		secret, err := c.Secret(filepath.Base(k))
+		if err != nil {
+			return err
+		}
+
+		if err := f(secret); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// key is a helper to generate the config store key
+func (s *Secret) key() string {
+	return filepath.Join(SecretPath, s.ID)
+}
+
+// Refresh reloads from the data store
+func (s *Secret) Refresh() error {
+	resp, err := s.context.kv.Get(s.key())
+	if err != nil {
+		return err
+	}
+
+	data := secretJSON{}
+	if err := json.Unmarshal(resp.Data, &data); err != nil {
+		return err
+	}
+	context := s.context
+	*s = data.Secret
+	s.context = context
+	s.modifiedIndex = resp.Index
+	s.nonce = data.Nonce
+	s.ciphertext = data.Ciphertext
+	return nil
+}
+
+// aead creates the cipher secret values are encrypted with
+func (s *Secret) aead() (cipher.AEAD, error) {
+	if s.context.secretKey == nil {
+		return nil, ErrNoSecretKey
+	}
+	block, err := aes.NewCipher(s.context.secretKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// additionalData binds a ciphertext to the secret and version it was
+// encrypted for, so that it can not be swapped for another
+func (s *Secret) additionalData() []byte {
+	return []byte(fmt.Sprintf("%s/%d", s.ID, s.Version))
+}
+
+// SetValue encrypts a new value for the Secret, incrementing its version. It
+// is not persisted until Save.
+func (s *Secret) SetValue(value []byte) error {
+	if len(value) == 0 {
+		return newValidationError("value", "missing value")
+	}
+	if len(value) > MaxSecretSize {
+		return newValidationError("value", fmt.Sprintf("value larger than %d bytes", MaxSecretSize))
+	}
+	aead, err := s.aead()
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	s.Version++
+	s.nonce = nonce
+	s.ciphertext = aead.Seal(nil, nonce, value, s.additionalData())
+	s.UpdatedAt = time.Now()
+	return nil
+}
+
+// Value decrypts the value of the Secret
+func (s *Secret) Value() ([]byte, error) {
+	if s.ciphertext == nil {
+		return nil, errors.New("secret has no value")
+	}
+	aead, err := s.aead()
+	if err != nil {
+		return nil, err
+	}
+	value, err := aead.Open(nil, s.nonce, s.ciphertext, s.additionalData())
+	if err != nil {
+		return nil, errors.New("failed to decrypt secret: wrong key or corrupt value")
+	}
+	return value, nil
+}
+
+// Validate ensures a Secret has reasonable data
+func (s *Secret) Validate() error {
+	if _, err := canonicalizeUUID(s.ID); err != nil {
+		return newValidationError("id", "missing or invalid id")
+	}
+	if s.Name == "" {
+		return newValidationError("name", "secret name required")
+	}
+	if !SecretKinds[s.Kind] {
+		return newValidationError("kind", "missing or invalid secret kind")
+	}
+	if s.ciphertext == nil {
+		return newValidationError("value", "missing value")
+	}
+	return nil
+}
+
+// Save persists a Secret.
+// It will call Validate.
+func (s *Secret) Save() error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	if s.CreatedAt.IsZero() {
+		s.CreatedAt = s.UpdatedAt
+	}
+
+	v, err := json.Marshal(secretJSON{
+		Secret:     *s,
+		Nonce:      s.nonce,
+		Ciphertext: s.ciphertext,
+	})
+	if err != nil {
+		return err
+	}
+
+	index, err := s.context.kv.Update(s.key(), kv.Value{Data: v, Index: s.modifiedIndex})
+	if err != nil {
+		return err
+	}
+	s.modifiedIndex = index
+	return nil
+}
+
+// Rotate replaces the value of a Secret and saves it. References to the Secret
+// get the new value without changing.
+func (s *Secret) Rotate(value []byte) error {
+	if err := s.SetValue(value); err != nil {
+		return err
+	}
+	return s.Save()
+}
+
+// References returns the ids of the guests and hypervisors that reference the
+// Secret, sorted
+func (s *Secret) References() (guests, hypervisors []string, err error) {
+	err = s.context.ForEachGuest(func(g *Guest) error {
+		if referencesSecret(g.Secrets, s.ID) {
+			guests = append(guests, g.ID)
+		}
+		return nil
+	})
+	if err != nil && !s.context.IsKeyNotFound(err) {
+		return nil, nil, err
+	}
+	err = s.context.ForEachHypervisor(func(h *Hypervisor) error {
+		if referencesSecret(h.Secrets, s.ID) {
+			hypervisors = append(hypervisors, h.ID)
+		}
+		return nil
+	})
+	if err != nil && !s.context.IsKeyNotFound(err) {
+		return nil, nil, err
+	}
+	sort.Strings(guests)
+	sort.Strings(hypervisors)
+	return guests, hypervisors, nil
+}
+
+// Destroy removes a Secret. Secrets that guests or hypervisors reference can
+// not be removed.
+func (s *Secret) Destroy() error {
+	if s.ID == "" {
+		return errors.New("missing id")
+	}
+
+	guests, hypervisors, err := s.References()
+	if err != nil {
+		return err
+	}
+	var users []string
+	if len(guests) > 0 {
+		users = append(users, "guests "+strings.Join(guests, ", "))
+	}
+	if len(hypervisors) > 0 {
+		users = append(users, "hypervisors "+strings.Join(hypervisors, ", "))
+	}
+	if len(users) > 0 {
+		return lerrors.Conflictf("secret is used by %s", strings.Join(users, " and "))
+	}
+
+	return s.context.kv.Delete(s.key(), false)
+}
+
+// referencesSecret returns whether the secret references of a guest or
+// hypervisor include id
+func referencesSecret(refs map[string]string, id string) bool {
+	for _, ref := range refs {
+		if ref == id {
+			return true
+		}
+	}
+	return false
+}
+
+// validateSecretRefs ensures the secret references of a guest or hypervisor
+// map purposes to secret ids
+func validateSecretRefs(refs map[string]string) error {
+	for purpose, id := range refs {
+		if purpose == "" {
+			return newValidationError("secrets", "missing secret purpose")
+		}
+		if uuid.Parse(id) == nil {
+			return newValidationError("secrets", fmt.Sprintf("invalid secret for %q", purpose))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package lochness_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestSecret(t *testing.T) {
+	suite.Run(t, new(SecretSuite))
+}
+
+type SecretSuite struct {
+	common.Suite
+	SecretContext *lochness.Context
+}
+
+func (s *SecretSuite) SetupTest() {
+	s.Suite.SetupTest()
+	var err error
+	s.SecretContext, err = s.Context.WithSecretKey(common.SecretKey)
+	s.Require().NoError(err)
+}
+
+func (s *SecretSuite) TestParseSecretKey() {
+	key := strings.Repeat("0f", lochness.SecretKeySize)
+	tests := []struct {
+		description string
+		data        string
+		expectedErr bool
+	}{
+		{"valid", key, false},
+		{"trailing newline", key + "\n", false},
+		{"empty", "", true},
+		{"short", "0f0f", true},
+		{"not hex", strings.Repeat("zz", lochness.SecretKeySize), true},
+	}
+
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		parsed, err := lochness.ParseSecretKey([]byte(test.data))
+		if test.expectedErr {
+			s.Error(err, msg("should fail"))
+		} else {
+			s.NoError(err, msg("should parse"))
+			s.Equal(bytes.Repeat([]byte{0x0f}, lochness.SecretKeySize), parsed, msg("should return the key"))
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "lochness-secret-key")
+	s.Require().NoError(err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	path := filepath.Join(dir, "key")
+	s.Require().NoError(ioutil.WriteFile(path, []byte(key+"\n"), 0600))
+	parsed, err := lochness.ReadSecretKeyFile(path)
+	s.NoError(err)
+	s.Len(parsed, lochness.SecretKeySize)
+	_, err = lochness.ReadSecretKeyFile(filepath.Join(dir, "missing"))
+	s.Error(err)
+}
+
+func (s *SecretSuite) TestWithSecretKey() {
+	_, err := s.Context.WithSecretKey([]byte("short"))
+	s.Error(err)
+
+	secret := s.Context.NewSecret()
+	s.Equal(lochness.ErrNoSecretKey, secret.SetValue([]byte("foo")), "contexts without a key should not encrypt")
+}
+
+func (s *SecretSuite) TestSecret() {
+	secret := s.NewSecret()
+
+	tests := []struct {
+		description string
+		ID          string
+		expectedErr bool
+	}{
+		{"missing id", "", true},
+		{"invalid ID", "adf", true},
+		{"nonexistant ID", uuid.New(), true},
+		{"real ID", secret.ID, false},
+	}
+
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		found, err := s.SecretContext.Secret(test.ID)
+		if test.expectedErr {
+			s.Error(err, msg("lookup should fail"))
+			s.Nil(found, msg("failure shouldn't return a secret"))
+		} else {
+			s.NoError(err, msg("lookup should succeed"))
+			s.Equal(secret.Name, found.Name, msg("success should return correct data"))
+			s.Equal(1, found.Version, msg("success should return correct data"))
+			value, err := found.Value()
+			s.NoError(err, msg("value should decrypt"))
+			s.Equal("hunter2", string(value), msg("value should decrypt"))
+		}
+	}
+}
+
+func (s *SecretSuite) TestEncrypted() {
+	secret := s.NewSecret()
+
+	resp, err := s.KV.Get(filepath.Join(lochness.SecretPath, secret.ID))
+	s.Require().NoError(err)
+	s.NotContains(string(resp.Data), "hunter2", "value should be encrypted")
+	s.NotContains(string(resp.Data), "aHVudGVyMg", "value should be encrypted")
+
+	data, err := json.Marshal(secret)
+	s.Require().NoError(err)
+	s.NotContains(string(data), "ciphertext", "json should not include the encrypted value")
+
+	found, err := s.Context.Secret(secret.ID)
+	s.Require().NoError(err)
+	_, err = found.Value()
+	s.Equal(lochness.ErrNoSecretKey, err, "contexts without a key should not decrypt")
+
+	other, err := s.Context.WithSecretKey(bytes.Repeat([]byte{1}, lochness.SecretKeySize))
+	s.Require().NoError(err)
+	found, err = other.Secret(secret.ID)
+	s.Require().NoError(err)
+	_, err = found.Value()
+	s.Error(err, "other keys should not decrypt")
+}
+
+func (s *SecretSuite) TestValidate() {
+	tests := []struct {
+		description string
+		id          string
+		name        string
+		kind        string
+		value       string
+		expectedErr bool
+	}{
+		{"missing id", "", "foo", lochness.SecretIPMI, "bar", true},
+		{"invalid id", "foo", "foo", lochness.SecretIPMI, "bar", true},
+		{"missing name", uuid.New(), "", lochness.SecretIPMI, "bar", true},
+		{"invalid kind", uuid.New(), "foo", "foo", "bar", true},
+		{"missing value", uuid.New(), "foo", lochness.SecretIPMI, "", true},
+		{"valid", uuid.New(), "foo", lochness.SecretIPMI, "bar", false},
+	}
+
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		secret := s.SecretContext.NewSecret()
+		secret.ID = test.id
+		secret.Name = test.name
+		secret.Kind = test.kind
+		if test.value != "" {
+			s.Require().NoError(secret.SetValue([]byte(test.value)), msg("value should be set"))
+		}
+		err := secret.Validate()
+		if test.expectedErr {
+			s.True(lerrors.IsValidation(err), msg("should be a validation error"))
+		} else {
+			s.NoError(err, msg("should be valid"))
+		}
+	}
+
+	secret := s.SecretContext.NewSecret()
+	s.True(lerrors.IsValidation(secret.SetValue(nil)), "empty values should be invalid")
+	s.True(lerrors.IsValidation(secret.SetValue(make([]byte, lochness.MaxSecretSize+1))), "large values should be invalid")
+}
+
+func (s *SecretSuite) TestRotate() {
+	secret := s.NewSecret()
+	found, err := s.SecretContext.Secret(secret.ID)
+	s.Require().NoError(err)
+
+	s.NoError(found.Rotate([]byte("correct horse")))
+	s.Equal(2, found.Version)
+	s.True(found.UpdatedAt.After(found.CreatedAt))
+
+	found, err = s.SecretContext.Secret(secret.ID)
+	s.Require().NoError(err)
+	value, err := found.Value()
+	s.NoError(err)
+	s.Equal("correct horse", string(value))
+	s.Equal(secret.CreatedAt.Unix(), found.CreatedAt.Unix(), "rotation should keep the creation time")
+
+	s.Error(secret.Rotate([]byte("stale")), "rotating a stale secret should fail")
+}
+
+func (s *SecretSuite) TestDestroy() {
+	secret := s.NewSecret()
+	hypervisor, guest := s.NewHypervisorWithGuest()
+	guest.Secrets = map[string]string{lochness.SecretRootPassword: secret.ID}
+	s.Require().NoError(guest.Save())
+	hypervisor.Secrets = map[string]string{lochness.SecretAgentToken: secret.ID}
+	s.Require().NoError(hypervisor.Save())
+
+	guests, hypervisors, err := secret.References()
+	s.NoError(err)
+	s.Equal([]string{guest.ID}, guests)
+	s.Equal([]string{hypervisor.ID}, hypervisors)
+
+	err = secret.Destroy()
+	s.True(lerrors.IsConflict(err), "referenced secrets should not be destroyed")
+	s.Contains(err.Error(), guest.ID)
+	s.Contains(err.Error(), hypervisor.ID)
+
+	guest.Secrets = nil
+	s.Require().NoError(guest.Save())
+	hypervisor.Secrets = map[string]string{}
+	s.Require().NoError(hypervisor.Save())
+	s.NoError(secret.Destroy())
+	_, err = s.Context.Secret(secret.ID)
+	s.True(s.Context.IsKeyNotFound(err))
+}
+
+func (s *SecretSuite) TestForEachSecret() {
+	secret1 := s.NewSecret()
+	secret2 := s.NewSecret()
+	expectedFound := map[string]bool{
+		secret1.ID: true,
+		secret2.ID: true,
+	}
+
+	resultFound := make(map[string]bool)
+	s.NoError(s.Context.ForEachSecret(func(secret *lochness.Secret) error {
+		resultFound[secret.ID] = true
+		return nil
+	}))
+	s.Equal(expectedFound, resultFound)
+}
+
+func (s *SecretSuite) TestSecretRefs() {
+	guest := s.NewGuest()
+	guest.Secrets = map[string]string{lochness.SecretRootPassword: "foo"}
+	s.True(lerrors.IsValidation(guest.Validate()), "guest secret references should be ids")
+	guest.Secrets = map[string]string{"": uuid.New()}
+	s.True(lerrors.IsValidation(guest.Validate()), "guest secret references should have a purpose")
+
+	hypervisor := s.NewHypervisor()
+	hypervisor.Secrets = map[string]string{lochness.SecretIPMI: "foo"}
+	s.True(lerrors.IsValidation(hypervisor.Validate()), "hypervisor secret references should be ids")
+
+	secret := s.NewSecret()
+	hypervisor.Secrets = map[string]string{lochness.SecretIPMI: secret.ID}
+	s.Require().NoError(hypervisor.Save())
+	found, err := s.Context.Hypervisor(hypervisor.ID)
+	s.Require().NoError(err)
+	s.Equal(hypervisor.Secrets, found.Secrets, "secret references should be persisted")
+}
@@ -0,0 +1,169 @@
+package lochness
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+)
+
+// SoftDeleteConfig is the config key of the cluster of how long deleted guests
+// and hypervisors may be restored, a duration such as "72h". Until then they
+// are only marked deleted, and they are purged once it passes. Unset or "0",
+// they are deleted right away.
+var SoftDeleteConfig = "soft-delete-window"
+
+// Tombstone marks a guest or hypervisor deleted. It is kept, and may be
+// restored, until Purge.
+type Tombstone struct {
+	Deleted  time.Time `json:"deleted"`
+	Purge    time.Time `json:"purge"`
+	PurgeJob string    `json:"purge_job,omitempty"` // job purging a guest, once queued
+}
+
+// ParseSoftDeleteWindow parses the restore window of SoftDeleteConfig, which
+// must be a duration that is not negative
+func ParseSoftDeleteWindow(value string) (time.Duration, error) {
+	if value == "0" {
+		return 0, nil
+	}
+	window, err := time.ParseDuration(value)
+	if err != nil || window < 0 {
+		return 0, newValidationError(SoftDeleteConfig, fmt.Sprintf("invalid %s %q: must be a duration that is not negative", SoftDeleteConfig, value))
+	}
+	return window, nil
+}
+
+// SoftDeleteWindow returns the restore window configured for the cluster, or 0
+// if deletes are not soft
+func (c *Context) SoftDeleteWindow() (time.Duration, error) {
+	value, err := c.GetConfig(SoftDeleteConfig)
+	if err != nil {
+		if c.IsKeyNotFound(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return ParseSoftDeleteWindow(value)
+}
+
+// newTombstone returns a tombstone of an entity deleted now, to be purged
+// after window
+func newTombstone(window time.Duration) *Tombstone {
+	now := time.Now()
+	return &Tombstone{Deleted: now, Purge: now.Add(window)}
+}
+
+// Expired returns whether the restore window of the tombstone passed by now
+func (t *Tombstone) Expired(now time.Time) bool {
+	return !now.Before(t.Purge)
+}
+
+// IsDeleted returns whether the guest is soft deleted
+func (g *Guest) IsDeleted() bool {
+	return g.Tombstone != nil
+}
+
+// SoftDelete marks the guest deleted, so that it may be restored until the
+// window passes. It fails with a conflict if it is already deleted.
+func (g *Guest) SoftDelete(window time.Duration) error {
+	if g.IsDeleted() {
+		return lerrors.Conflict(errors.New("guest is already deleted"))
+	}
+	g.Tombstone = newTombstone(window)
+	if err := g.Save(); err != nil {
+		g.Tombstone = nil
+		return err
+	}
+	return nil
+}
+
+// Restore unmarks a soft deleted guest. It fails with a conflict if the guest
+// is not deleted or is already being purged.
+func (g *Guest) Restore() error {
+	if !g.IsDeleted() {
+		return lerrors.Conflict(errors.New("guest is not deleted"))
+	}
+	if g.Tombstone.PurgeJob != "" {
+		return lerrors.Conflict(errors.New("guest is being purged"))
+	}
+	tombstone := g.Tombstone
+	g.Tombstone = nil
+	if err := g.Save(); err != nil {
+		g.Tombstone = tombstone
+		return err
+	}
+	return nil
+}
+
+// IsDeleted returns whether the hypervisor is soft deleted
+func (h *Hypervisor) IsDeleted() bool {
+	return h.Tombstone != nil
+}
+
+// SoftDelete marks the hypervisor deleted, so that it may be restored until
+// the window passes. Deleted hypervisors are not placed guests on. Like
+// Destroy, it fails with a conflict if the hypervisor has guests, or if it is
+// already deleted.
+func (h *Hypervisor) SoftDelete(window time.Duration) error {
+	if len(h.guests) != 0 {
+		return lerrors.Conflict(errors.New("not empty"))
+	}
+	if h.IsDeleted() {
+		return lerrors.Conflict(errors.New("hypervisor is already deleted"))
+	}
+	h.Tombstone = newTombstone(window)
+	if err := h.Save(); err != nil {
+		h.Tombstone = nil
+		return err
+	}
+	return nil
+}
+
+// Restore unmarks a soft deleted hypervisor. It fails with a conflict if the
+// hypervisor is not deleted.
+func (h *Hypervisor) Restore() error {
+	if !h.IsDeleted() {
+		return lerrors.Conflict(errors.New("hypervisor is not deleted"))
+	}
+	tombstone := h.Tombstone
+	h.Tombstone = nil
+	if err := h.Save(); err != nil {
+		h.Tombstone = tombstone
+		return err
+	}
+	return nil
+}
+
+// PurgeHypervisors destroys the soft deleted hypervisors whose restore window
+// passed by now, returning how many were and the first error of those that
+// could not be
+func (c *Context) PurgeHypervisors(now time.Time) (int, error) {
+	var expired Hypervisors
+	err := c.ForEachHypervisor(func(h *Hypervisor) error {
+		if h.IsDeleted() && h.Tombstone.Expired(now) {
+			expired = append(expired, h)
+		}
+		return nil
+	})
+	if err != nil {
+		if c.IsKeyNotFound(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	// one hypervisor failing to be purged does not hold back the others
+	purged := 0
+	for _, h := range expired {
+		if e := h.Destroy(); e != nil {
+			if err == nil {
+				err = e
+			}
+			continue
+		}
+		purged++
+	}
+	return purged, err
+}
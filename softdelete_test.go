@@ -0,0 +1,102 @@
+package lochness_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestSoftDelete(t *testing.T) {
+	suite.Run(t, new(SoftDeleteSuite))
+}
+
+type SoftDeleteSuite struct {
+	common.Suite
+}
+
+func (s *SoftDeleteSuite) TestSoftDeleteWindow() {
+	window, err := s.Context.SoftDeleteWindow()
+	s.NoError(err)
+	s.Zero(window)
+
+	s.NoError(s.Context.SetConfig(lochness.SoftDeleteConfig, "72h"))
+	window, err = s.Context.SoftDeleteWindow()
+	s.NoError(err)
+	s.Equal(72*time.Hour, window)
+
+	s.NoError(s.Context.SetConfig(lochness.SoftDeleteConfig, "0"))
+	for _, value := range []string{"-1h", "soon", ""} {
+		s.True(lerrors.IsValidation(s.Context.SetConfig(lochness.SoftDeleteConfig, value)), value)
+	}
+}
+
+func (s *SoftDeleteSuite) TestGuest() {
+	guest := s.NewGuest()
+
+	s.True(lerrors.IsConflict(guest.Restore()))
+	s.NoError(guest.SoftDelete(time.Hour))
+	s.True(lerrors.IsConflict(guest.SoftDelete(time.Hour)))
+
+	loaded, err := s.Context.Guest(guest.ID)
+	s.Require().NoError(err)
+	if s.True(loaded.IsDeleted()) {
+		s.WithinDuration(loaded.Tombstone.Deleted.Add(time.Hour), loaded.Tombstone.Purge, time.Second)
+		s.False(loaded.Tombstone.Expired(time.Now()))
+		s.True(loaded.Tombstone.Expired(time.Now().Add(time.Hour)))
+	}
+
+	s.NoError(loaded.Restore())
+	loaded, err = s.Context.Guest(guest.ID)
+	s.Require().NoError(err)
+	s.False(loaded.IsDeleted())
+}
+
+func (s *SoftDeleteSuite) TestGuestPurging() {
+	guest := s.NewGuest()
+	s.Require().NoError(guest.SoftDelete(0))
+	guest.Tombstone.PurgeJob = "job"
+	s.Require().NoError(guest.Save())
+
+	s.True(lerrors.IsConflict(guest.Restore()))
+}
+
+func (s *SoftDeleteSuite) TestHypervisor() {
+	hypervisor, _ := s.NewHypervisorWithGuest()
+	s.True(lerrors.IsConflict(hypervisor.SoftDelete(time.Hour)))
+
+	hypervisor = s.NewHypervisor()
+	s.True(lerrors.IsConflict(hypervisor.Restore()))
+	s.NoError(hypervisor.SoftDelete(time.Hour))
+
+	candidates, err := lochness.CandidateNotInMaintenance(s.NewGuest(), lochness.Hypervisors{hypervisor})
+	s.NoError(err)
+	s.Len(candidates, 0)
+
+	s.NoError(hypervisor.Restore())
+	loaded, err := s.Context.Hypervisor(hypervisor.ID)
+	s.Require().NoError(err)
+	s.False(loaded.IsDeleted())
+}
+
+func (s *SoftDeleteSuite) TestPurgeHypervisors() {
+	expired := s.NewHypervisor()
+	s.Require().NoError(expired.SoftDelete(time.Minute))
+	kept := s.NewHypervisor()
+	s.Require().NoError(kept.SoftDelete(time.Hour))
+	live := s.NewHypervisor()
+
+	purged, err := s.Context.PurgeHypervisors(time.Now().Add(30 * time.Minute))
+	s.NoError(err)
+	s.Equal(1, purged)
+
+	_, err = s.Context.Hypervisor(expired.ID)
+	s.True(s.Context.IsKeyNotFound(err))
+	for _, h := range []*lochness.Hypervisor{kept, live} {
+		_, err = s.Context.Hypervisor(h.ID)
+		s.NoError(err)
+	}
+}
@@ -0,0 +1,93 @@
+package lochness
+
+//go:generate mockery -name=Store -output=pkg/lochnesstest -outpkg=lochnesstest
+
+type (
+	// ConfigStore is the set of operations on global config.
+	ConfigStore interface {
+		GetConfig(key string) (string, error)
+		SetConfig(key, val string) error
+		ForEachConfig(f func(key, val string) error) error
+	}
+
+	// FlavorStore is the set of lookup operations on Flavors.
+	FlavorStore interface {
+		NewFlavor() *Flavor
+		Flavor(id string) (*Flavor, error)
+	}
+
+	// FWGroupStore is the set of lookup operations on FWGroups.
+	FWGroupStore interface {
+		NewFWGroup() *FWGroup
+		FWGroup(id string) (*FWGroup, error)
+	}
+
+	// GuestStore is the set of lookup operations on Guests.
+	GuestStore interface {
+		NewGuest() *Guest
+		Guest(id string) (*Guest, error)
+		ForEachGuest(f func(*Guest) error) error
+	}
+
+	// HypervisorStore is the set of lookup operations on Hypervisors.
+	HypervisorStore interface {
+		NewHypervisor() *Hypervisor
+		Hypervisor(id string) (*Hypervisor, error)
+		FirstHypervisor(f func(*Hypervisor) bool) (*Hypervisor, error)
+		ForEachHypervisor(f func(*Hypervisor) error) error
+	}
+
+	// ImageStore is the set of lookup operations on Images.
+	ImageStore interface {
+		NewImage() *Image
+		Image(id string) (*Image, error)
+		ForEachImage(f func(*Image) error) error
+	}
+
+	// NetworkStore is the set of lookup operations on Networks.
+	NetworkStore interface {
+		NewNetwork() *Network
+		Network(id string) (*Network, error)
+	}
+
+	// SubnetStore is the set of lookup operations on Subnets.
+	SubnetStore interface {
+		NewSubnet() *Subnet
+		Subnet(id string) (*Subnet, error)
+		ForEachSubnet(f func(*Subnet) error) error
+	}
+
+	// VLANStore is the set of lookup operations on VLANs.
+	VLANStore interface {
+		NewVLAN() *VLAN
+		VLAN(tag int) (*VLAN, error)
+		ForEachVLAN(f func(*VLAN) error) error
+	}
+
+	// VLANGroupStore is the set of lookup operations on VLANGroups.
+	VLANGroupStore interface {
+		NewVLANGroup() *VLANGroup
+		VLANGroup(id string) (*VLANGroup, error)
+		ForEachVLANGroup(f func(*VLANGroup) error) error
+	}
+
+	// Store is the full set of operations provided by a Context. Code that
+	// only needs to look up entities should accept a Store, or one of the
+	// narrower interfaces, so it can be tested with the doubles in
+	// pkg/lochnesstest instead of a running kv.
+	Store interface {
+		ConfigStore
+		FlavorStore
+		FWGroupStore
+		GuestStore
+		HypervisorStore
+		ImageStore
+		NetworkStore
+		SubnetStore
+		VLANStore
+		VLANGroupStore
+		IsKeyNotFound(err error) bool
+	}
+)
+
+var _ Store = &Context{}
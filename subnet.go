@@ -8,8 +8,10 @@ import (
 	"math/rand"
 	"net"
 	"path/filepath"
+	"sort"
 	"strings"
 
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
 	"github.com/mistifyio/lochness/pkg/kv"
 	"github.com/pborman/uuid"
 )
@@ -24,13 +26,14 @@ type (
 	Subnet struct {
 		context       *Context
 		modifiedIndex uint64
-		ID            string            `json:"id"`
+		ID            string            `json:"id" schema:"uuid"`
 		Metadata      map[string]string `json:"metadata"`
-		NetworkID     string            `json:"network"`
+		NetworkID     string            `json:"network" schema:"uuid"`
 		Gateway       net.IP            `json:"gateway"`
-		CIDR          *net.IPNet        `json:"cidr"`
-		StartRange    net.IP            `json:"start"` // first usable IP in range
-		EndRange      net.IP            `json:"end"`   // last usable IP in range
+		CIDR          *net.IPNet        `json:"cidr" schema:"required"`
+		StartRange    net.IP            `json:"start" schema:"required"`          // first usable IP in range
+		EndRange      net.IP            `json:"end" schema:"required"`            // last usable IP in range
+		VLAN          int               `json:"vlan,omitempty" schema:"readonly"` // tag allocated from its network's VLAN ranges
 		addresses     map[uint32]string //all allocated addresses. use int as its quickest to go back and forth
 	}
 
@@ -46,6 +49,7 @@ type (
 		CIDR       string            `json:"cidr"`
 		StartRange net.IP            `json:"start"`
 		EndRange   net.IP            `json:"end"`
+		VLAN       int               `json:"vlan,omitempty"`
 	}
 )
 
@@ -61,6 +65,7 @@ func (s *Subnet) MarshalJSON() ([]byte, error) {
 		CIDR:       s.CIDR.String(),
 		StartRange: s.StartRange,
 		EndRange:   s.EndRange,
+		VLAN:       s.VLAN,
 	}
 
 	return json.Marshal(data)
@@ -80,6 +85,7 @@ func (s *Subnet) UnmarshalJSON(input []byte) error {
 	s.Gateway = data.Gateway
 	s.StartRange = data.StartRange
 	s.EndRange = data.EndRange
+	s.VLAN = data.VLAN
 
 	_, n, err := net.ParseCIDR(data.CIDR)
 	if err != nil {
@@ -144,7 +150,7 @@ func (s *Subnet) Refresh() error {
 	key := filepath.Join(prefix, "metadata")
 	value, ok := nodes[key]
 	if !ok {
-		return errors.New("metadata key is missing")
+		return lerrors.NotFound(errors.New("metadata key is missing"))
 	}
 
 	if err := json.Unmarshal(value.Data, &s); err != nil {
@@ -188,51 +194,66 @@ func (s *Subnet) Delete() error {
 // Validate ensures the values are reasonable.
 func (s *Subnet) Validate() error {
 	if _, err := canonicalizeUUID(s.ID); err != nil {
-		return errors.New("invalid ID")
+		return newValidationError("id", "invalid ID")
 	}
 
 	if s.CIDR == nil {
-		return errors.New("CIDR cannot be nil")
+		return newValidationError("cidr", "CIDR cannot be nil")
 	}
 
 	if s.StartRange == nil {
-		return errors.New("StartRange cannot be nil")
+		return newValidationError("start", "StartRange cannot be nil")
 	}
 	if !s.CIDR.Contains(s.StartRange) {
-		return fmt.Errorf("%s does not contain %s", s.CIDR, s.StartRange)
+		return newValidationError("start", fmt.Sprintf("%s does not contain %s", s.CIDR, s.StartRange))
 	}
 
 	if s.EndRange == nil {
-		return errors.New("EndRange cannot be nil")
+		return newValidationError("end", "EndRange cannot be nil")
 	}
 	if !s.CIDR.Contains(s.EndRange) {
-		return fmt.Errorf("%s does not contain %s", s.CIDR, s.EndRange)
+		return newValidationError("end", fmt.Sprintf("%s does not contain %s", s.CIDR, s.EndRange))
 	}
 
 	if bytes.Compare(s.StartRange, s.EndRange) > 0 {
-		return errors.New("EndRange cannot be less than StartRange")
+		return newValidationError("end", "EndRange cannot be less than StartRange")
 	}
 	return nil
 }
 
-// Save persists the subnet to the datastore.
+// Save persists the subnet to the datastore. A subnet overlapping another of
+// its network is rejected, see CheckSubnetOverlap.
 func (s *Subnet) Save() error {
+	return s.context.SaveAll(s)
+}
 
-	if err := s.Validate(); err != nil {
-		return err
+// CheckSubnetOverlap returns a conflict error listing the other subnets of the
+// network of s whose CIDRs overlap its own. Subnets without a network do not
+// overlap anything. This is not atomic, so subnets saved concurrently may
+// still overlap.
+func (c *Context) CheckSubnetOverlap(s *Subnet) error {
+	if s.NetworkID == "" || s.CIDR == nil {
+		return nil
 	}
 
-	v, err := json.Marshal(s)
-
+	overlaps := []string{}
+	err := c.ForEachSubnet(func(other *Subnet) error {
+		if other.ID == s.ID || other.NetworkID != s.NetworkID || other.CIDR == nil {
+			return nil
+		}
+		if other.CIDR.Contains(s.CIDR.IP) || s.CIDR.Contains(other.CIDR.IP) {
+			overlaps = append(overlaps, fmt.Sprintf("%s (%s)", other.ID, other.CIDR))
+		}
+		return nil
+	})
 	if err != nil {
 		return err
 	}
 
-	index, err := s.context.kv.Update(s.key(), kv.Value{Data: v, Index: s.modifiedIndex})
-	if err != nil {
-		return err
+	if len(overlaps) > 0 {
+		sort.Strings(overlaps)
+		return lerrors.Conflictf("cidr %s overlaps subnets %s", s.CIDR, strings.Join(overlaps, ", "))
 	}
-	s.modifiedIndex = index
 	return nil
 }
 
@@ -8,6 +8,7 @@ import (
 
 	"github.com/mistifyio/lochness"
 	"github.com/mistifyio/lochness/internal/tests/common"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
 	"github.com/pborman/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
@@ -136,6 +137,45 @@ func (s *SubnetSuite) TestSave() {
 	s.Error(NewSubnet.Refresh(), "unsaved subnet refresh should fail")
 }
 
+func (s *SubnetSuite) TestCheckSubnetOverlap() {
+	network := s.NewNetwork()
+	existing := s.NewSubnet()
+	s.Require().NoError(network.AddSubnet(existing))
+
+	tests := []struct {
+		description string
+		networkID   string
+		cidr        string
+		expectedErr bool
+	}{
+		{"no network", "", "192.168.100.0/24", false},
+		{"other network", s.NewNetwork().ID, "192.168.100.0/24", false},
+		{"same cidr", network.ID, "192.168.100.0/24", true},
+		{"contained", network.ID, "192.168.100.128/25", true},
+		{"containing", network.ID, "192.168.0.0/16", true},
+		{"adjacent", network.ID, "192.168.101.0/24", false},
+	}
+
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		subnet := s.Context.NewSubnet()
+		subnet.NetworkID = test.networkID
+		subnet.StartRange, subnet.CIDR, _ = net.ParseCIDR(test.cidr)
+		subnet.EndRange = subnet.StartRange
+
+		err := s.Context.CheckSubnetOverlap(subnet)
+		s.Equal(err, subnet.Save(), msg("save should check for overlaps"))
+		if test.expectedErr {
+			s.True(lerrors.IsConflict(err), msg("should be a conflict"))
+			s.Contains(err.Error(), existing.ID, msg("should list the overlapping subnet"))
+		} else {
+			s.NoError(err, msg("should not overlap"))
+		}
+	}
+
+	s.NoError(existing.Save(), "a subnet should not overlap itself")
+}
+
 func (s *SubnetSuite) TestDelete() {
 	subnet := s.NewSubnet()
 	network := s.NewNetwork()
@@ -0,0 +1,206 @@
+package lochness
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mistifyio/lochness/pkg/kv"
+)
+
+// TelemetryWindowSize is the number of recent telemetry samples kept of a
+// hypervisor
+var TelemetryWindowSize = 60
+
+type (
+	// TelemetrySample is the basic resource telemetry of a hypervisor at a
+	// time, as reported by the hypervisor itself. Memory and disk are in MB,
+	// like Resources.
+	TelemetrySample struct {
+		Time       time.Time `json:"time"`
+		Load1      float64   `json:"load1"`
+		Load5      float64   `json:"load5"`
+		Load15     float64   `json:"load15"`
+		FreeMemory uint64    `json:"free_memory"`
+		FreeDisk   uint64    `json:"free_disk"`
+	}
+
+	// HypervisorTelemetry is the rolling window of the telemetry of a
+	// Hypervisor, oldest first, with its heartbeats. AverageLoad is the mean
+	// one minute load, and MinFreeMemory and MinFreeDisk the least free over
+	// the window, for placing guests on what a hypervisor has had free
+	// throughout rather than at an instant.
+	HypervisorTelemetry struct {
+		Health        *HypervisorHealth `json:"health"`
+		Samples       []TelemetrySample `json:"samples"`
+		Latest        *TelemetrySample  `json:"latest,omitempty"`
+		AverageLoad   float64           `json:"average_load"`
+		MinFreeMemory uint64            `json:"min_free_memory"`
+		MinFreeDisk   uint64            `json:"min_free_disk"`
+	}
+)
+
+// telemetryKey is a helper for generating a key for config store.
+func (h *Hypervisor) telemetryKey() string {
+	return filepath.Join(HypervisorPath, h.ID, "telemetry")
+}
+
+// Validate ensures a TelemetrySample has sensible values
+func (s *TelemetrySample) Validate() error {
+	if s.Load1 < 0 || s.Load5 < 0 || s.Load15 < 0 {
+		return newValidationError("load", "negative load")
+	}
+	return nil
+}
+
+// RecordTelemetry adds a sample to the telemetry window of the Hypervisor,
+// dropping the oldest beyond TelemetryWindowSize. Samples without a time are
+// taken as of now.
+func (h *Hypervisor) RecordTelemetry(sample TelemetrySample) error {
+	if err := sample.Validate(); err != nil {
+		return err
+	}
+	if sample.Time.IsZero() {
+		sample.Time = time.Now()
+	}
+
+	samples, index, err := h.telemetry()
+	if err != nil {
+		return err
+	}
+	samples = append(samples, sample)
+	if extra := len(samples) - TelemetryWindowSize; extra > 0 {
+		samples = append([]TelemetrySample(nil), samples[extra:]...)
+	}
+
+	v, err := json.Marshal(samples)
+	if err != nil {
+		return err
+	}
+	_, err = h.context.kv.Update(h.telemetryKey(), kv.Value{Data: v, Index: index})
+	return err
+}
+
+// Telemetry returns the telemetry window of the Hypervisor, summarized, along
+// with the health of its heartbeats
+func (h *Hypervisor) Telemetry() (*HypervisorTelemetry, error) {
+	samples, _, err := h.telemetry()
+	if err != nil {
+		return nil, err
+	}
+
+	t := &HypervisorTelemetry{
+		Health:  h.Health(),
+		Samples: samples,
+	}
+	if len(samples) == 0 {
+		return t, nil
+	}
+	latest := samples[len(samples)-1]
+	t.Latest = &latest
+	t.MinFreeMemory, t.MinFreeDisk = latest.FreeMemory, latest.FreeDisk
+	for _, s := range samples {
+		t.AverageLoad += s.Load1
+		if s.FreeMemory < t.MinFreeMemory {
+			t.MinFreeMemory = s.FreeMemory
+		}
+		if s.FreeDisk < t.MinFreeDisk {
+			t.MinFreeDisk = s.FreeDisk
+		}
+	}
+	t.AverageLoad /= float64(len(samples))
+	return t, nil
+}
+
+// telemetry fetches the telemetry window along with its modified index
+func (h *Hypervisor) telemetry() ([]TelemetrySample, uint64, error) {
+	value, err := h.context.kv.Get(h.telemetryKey())
+	if err != nil {
+		if h.context.kv.IsKeyNotFound(err) {
+			return []TelemetrySample{}, 0, nil
+		}
+		return nil, 0, err
+	}
+
+	samples := []TelemetrySample{}
+	if err := json.Unmarshal(value.Data, &samples); err != nil {
+		return nil, 0, err
+	}
+	return samples, value.Index, nil
+}
+
+// CollectTelemetry samples the telemetry of the Hypervisor and records it, see
+// LocalTelemetry. Disk is that of its guest disk directory. It should only be
+// run on the hypervisor.
+func (h *Hypervisor) CollectTelemetry() error {
+	if err := h.VerifyOnHV(); err != nil {
+		return err
+	}
+	sample, err := LocalTelemetry(h.guestDiskDir())
+	if err != nil {
+		return err
+	}
+	return h.RecordTelemetry(*sample)
+}
+
+// LocalTelemetry samples the load averages and available memory of the local
+// machine, and the disk available at path. It should only be run on the
+// hypervisor.
+func LocalTelemetry(path string) (*TelemetrySample, error) {
+	sample := &TelemetrySample{Time: time.Now()}
+
+	data, err := ioutil.ReadFile("/proc/loadavg")
+	if err != nil {
+		return nil, err
+	}
+	loads := strings.Fields(string(data))
+	if len(loads) < 3 {
+		return nil, newValidationError("load", "malformed /proc/loadavg")
+	}
+	for i, load := range []*float64{&sample.Load1, &sample.Load5, &sample.Load15} {
+		if *load, err = strconv.ParseFloat(loads[i], 64); err != nil {
+			return nil, err
+		}
+	}
+
+	if sample.FreeMemory, err = availableMemory(); err != nil {
+		return nil, err
+	}
+
+	stat := &syscall.Statfs_t{}
+	if err := syscall.Statfs(path, stat); err != nil {
+		return nil, err
+	}
+	sample.FreeDisk = uint64(stat.Bsize) * stat.Bavail / 1024 / 1024
+
+	return sample, nil
+}
+
+// availableMemory gets the memory available to start new processes, in MB
+func availableMemory() (uint64, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "MemAvailable:" {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		return kb / 1024, nil
+	}
+	return 0, scanner.Err()
+}
@@ -0,0 +1,89 @@
+package lochness_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestTelemetry(t *testing.T) {
+	suite.Run(t, new(TelemetrySuite))
+}
+
+type TelemetrySuite struct {
+	common.Suite
+}
+
+func (s *TelemetrySuite) TestRecordTelemetry() {
+	defer func(size int) { lochness.TelemetryWindowSize = size }(lochness.TelemetryWindowSize)
+	lochness.TelemetryWindowSize = 3
+
+	hypervisor := s.NewHypervisor()
+
+	telemetry, err := hypervisor.Telemetry()
+	s.NoError(err)
+	s.Len(telemetry.Samples, 0)
+	s.Nil(telemetry.Latest)
+
+	err = hypervisor.RecordTelemetry(lochness.TelemetrySample{Load1: -1})
+	s.Error(err)
+	s.True(lerrors.IsValidation(err))
+
+	samples := []lochness.TelemetrySample{
+		{Load1: 4, FreeMemory: 1024, FreeDisk: 2048},
+		{Load1: 1, FreeMemory: 512, FreeDisk: 4096},
+		{Load1: 2, FreeMemory: 2048, FreeDisk: 1024},
+		{Load1: 3, FreeMemory: 1024, FreeDisk: 3072},
+	}
+	for _, sample := range samples {
+		s.Require().NoError(hypervisor.RecordTelemetry(sample))
+	}
+
+	// the window survives a refresh
+	h, err := s.Context.Hypervisor(hypervisor.ID)
+	s.Require().NoError(err)
+	telemetry, err = h.Telemetry()
+	s.Require().NoError(err)
+	s.Len(telemetry.Samples, 3)
+	s.Equal(samples[1].FreeMemory, telemetry.Samples[0].FreeMemory)
+	s.False(telemetry.Samples[0].Time.IsZero())
+	if s.NotNil(telemetry.Latest) {
+		s.Equal(samples[3].Load1, telemetry.Latest.Load1)
+	}
+	s.InDelta(2.0, telemetry.AverageLoad, 0.001)
+	s.Equal(uint64(512), telemetry.MinFreeMemory)
+	s.Equal(uint64(1024), telemetry.MinFreeDisk)
+	s.NotNil(telemetry.Health)
+}
+
+func (s *TelemetrySuite) TestCollectTelemetry() {
+	hypervisor := s.NewHypervisor()
+	_ = hypervisor.SetConfig("guestDiskDir", "/")
+
+	s.Error(hypervisor.CollectTelemetry())
+
+	_, _ = lochness.SetHypervisorID(hypervisor.ID)
+	s.Require().NoError(hypervisor.CollectTelemetry())
+
+	telemetry, err := hypervisor.Telemetry()
+	s.NoError(err)
+	if s.NotNil(telemetry.Latest) {
+		s.NotEqual(uint64(0), telemetry.Latest.FreeMemory)
+		s.NotEqual(uint64(0), telemetry.Latest.FreeDisk)
+		s.WithinDuration(time.Now(), telemetry.Latest.Time, time.Minute)
+	}
+}
+
+func (s *TelemetrySuite) TestLocalTelemetry() {
+	sample, err := lochness.LocalTelemetry("/")
+	s.NoError(err)
+	s.NotEqual(uint64(0), sample.FreeMemory)
+	s.NotEqual(uint64(0), sample.FreeDisk)
+
+	_, err = lochness.LocalTelemetry("/does/not/exist")
+	s.Error(err)
+}
@@ -0,0 +1,106 @@
+package lochness
+
+import (
+	"fmt"
+	"strings"
+)
+
+var (
+	// BridgesConfig is the hypervisor config key of the bridges it has, a
+	// comma separated list such as "br0,br1". Once set, subnets may only be
+	// added to the hypervisor on one of them.
+	BridgesConfig = "bridges"
+
+	// VLANGroupsConfig is the hypervisor config key of the ids of the VLAN
+	// groups its uplinks carry, a comma separated list. The subnets of a
+	// network in a VLAN group may only be added to hypervisors carrying it.
+	VLANGroupsConfig = "vlan-groups"
+)
+
+// splitList splits a comma separated config value, dropping blank items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// ParseBridges parses the bridges of BridgesConfig, each of which must be a
+// valid bridge name
+func ParseBridges(value string) ([]string, error) {
+	bridges := splitList(value)
+	for _, bridge := range bridges {
+		if !bridgeRegexp.MatchString(bridge) {
+			return nil, newValidationError(BridgesConfig, fmt.Sprintf("invalid %s %q: invalid bridge name %q", BridgesConfig, value, bridge))
+		}
+	}
+	return bridges, nil
+}
+
+// ParseVLANGroups parses the VLAN group ids of VLANGroupsConfig, each of
+// which must be a UUID, into their canonical form
+func ParseVLANGroups(value string) ([]string, error) {
+	ids := splitList(value)
+	for i, id := range ids {
+		canonical, err := canonicalizeUUID(id)
+		if err != nil {
+			return nil, newValidationError(VLANGroupsConfig, fmt.Sprintf("invalid %s %q: invalid VLAN group id %q", VLANGroupsConfig, value, id))
+		}
+		ids[i] = canonical
+	}
+	return ids, nil
+}
+
+// hasString returns whether s is one of values
+func hasString(values []string, s string) bool {
+	for _, value := range values {
+		if value == s {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckSubnetTopology checks that the hypervisor can carry a subnet on a
+// bridge: the bridge must be one of its BridgesConfig, if that is set, and the
+// VLAN group of the subnet's network, if it has one, must be one of its
+// VLANGroupsConfig. It fails with a validation error describing what is
+// missing, so that the subnet is not added only for guests on it to fail to
+// boot.
+func (h *Hypervisor) CheckSubnetTopology(s *Subnet, bridge string) error {
+	if value, ok := h.Config[BridgesConfig]; ok {
+		bridges, err := ParseBridges(value)
+		if err != nil {
+			return err
+		}
+		if !hasString(bridges, bridge) {
+			return newValidationError("bridge", fmt.Sprintf("bridge %q is not one of the bridges of hypervisor %s: %s", bridge, h.ID, strings.Join(bridges, ", ")))
+		}
+	}
+
+	if s.NetworkID == "" {
+		return nil
+	}
+	network, err := h.context.Network(s.NetworkID)
+	if err != nil {
+		return err
+	}
+	if network.VLANGroupID == "" {
+		return nil
+	}
+	groupID, err := canonicalizeUUID(network.VLANGroupID)
+	if err != nil {
+		return err
+	}
+	groups, err := ParseVLANGroups(h.Config[VLANGroupsConfig])
+	if err != nil {
+		return err
+	}
+	if !hasString(groups, groupID) {
+		return newValidationError("subnet", fmt.Sprintf("network %s of subnet %s is in VLAN group %s, which hypervisor %s does not carry", network.ID, s.ID, groupID, h.ID))
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package lochness_test
+
+import (
+	"testing"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestTopology(t *testing.T) {
+	suite.Run(t, new(TopologySuite))
+}
+
+type TopologySuite struct {
+	common.Suite
+}
+
+func (s *TopologySuite) TestParseBridges() {
+	bridges, err := lochness.ParseBridges(" br0, br-int.100,,")
+	s.NoError(err)
+	s.Equal([]string{"br0", "br-int.100"}, bridges)
+
+	for _, value := range []string{"br0,br 1", "abcdefghijklmnop"} {
+		_, err := lochness.ParseBridges(value)
+		s.True(lerrors.IsValidation(err), value)
+	}
+}
+
+func (s *TopologySuite) TestParseVLANGroups() {
+	vg := s.NewVLANGroup()
+	groups, err := lochness.ParseVLANGroups("")
+	s.NoError(err)
+	s.Len(groups, 0)
+
+	groups, err = lochness.ParseVLANGroups(" " + vg.ID + ",")
+	s.NoError(err)
+	s.Equal([]string{vg.ID}, groups)
+
+	_, err = lochness.ParseVLANGroups(vg.ID + ",foobar")
+	s.True(lerrors.IsValidation(err))
+}
+
+func (s *TopologySuite) TestCheckSubnetTopology() {
+	vg := s.NewVLANGroup()
+	network := s.NewNetwork()
+	subnet := s.NewSubnet()
+	s.Require().NoError(network.AddSubnet(subnet))
+	hypervisor := s.NewHypervisor()
+
+	s.NoError(hypervisor.CheckSubnetTopology(subnet, "br0"), "unconfigured hypervisors carry untagged networks on any bridge")
+
+	s.Require().NoError(hypervisor.SetConfig(lochness.BridgesConfig, "br0,br1"))
+	s.NoError(hypervisor.CheckSubnetTopology(subnet, "br1"))
+	s.True(lerrors.IsValidation(hypervisor.CheckSubnetTopology(subnet, "br2")), "bridges must be configured ones")
+
+	network.VLANGroupID = vg.ID
+	s.Require().NoError(network.Save())
+	s.True(lerrors.IsValidation(hypervisor.CheckSubnetTopology(subnet, "br0")), "the network's vlan group must be carried")
+	s.True(lerrors.IsValidation(hypervisor.AddSubnet(subnet, "br0")))
+	s.Len(hypervisor.Subnets(), 0, "rejected subnets should not be added")
+
+	s.Require().NoError(hypervisor.SetConfig(lochness.VLANGroupsConfig, vg.ID))
+	s.NoError(hypervisor.CheckSubnetTopology(subnet, "br0"))
+	s.NoError(hypervisor.AddSubnet(subnet, "br0"))
+	s.Len(hypervisor.Subnets(), 1)
+}
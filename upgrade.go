@@ -0,0 +1,402 @@
+package lochness
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/mistifyio/lochness/pkg/kv"
+	"github.com/pborman/uuid"
+)
+
+var (
+	// UpgradePath is the path in the config store
+	UpgradePath = "lochness/upgrades/"
+	// UpgradeLockKey holds the id of the upgrade being rolled out, so only one
+	// is at a time
+	UpgradeLockKey = "lochness/upgrade-lock"
+)
+
+const (
+	// VersionConfig is the hypervisor config key set to the version a
+	// hypervisor is being upgraded to, for nconfigd to run the upgrade on
+	VersionConfig = "version"
+	// VersionFact is the fact, see LocalFacts, that an upgraded hypervisor is
+	// expected to report the version as
+	VersionFact = "os-version"
+)
+
+// Upgrade states
+const (
+	UpgradeRunning = "running"
+	UpgradePaused  = "paused"
+	UpgradeAborted = "aborted"
+	UpgradeFailed  = "failed"
+	UpgradeDone    = "done"
+)
+
+// Upgrade steps of a hypervisor
+const (
+	UpgradeStepPending   = "pending"
+	UpgradeStepDraining  = "draining"
+	UpgradeStepUpgrading = "upgrading"
+	UpgradeStepRestoring = "restoring"
+	UpgradeStepDone      = "done"
+	UpgradeStepFailed    = "failed"
+)
+
+// upgradeStateRetries is how many times a state change is retried when the
+// upgrade was saved concurrently
+const upgradeStateRetries = 5
+
+type (
+	// Upgrade is a rolling upgrade of hypervisors to a version. The
+	// hypervisors are upgraded in order, BatchSize at a time, by cupgraded.
+	Upgrade struct {
+		context       *Context
+		modifiedIndex uint64
+		ID            string               `json:"id"`
+		Version       string               `json:"version"`
+		BatchSize     int                  `json:"batch_size"`
+		State         string               `json:"state"`
+		Error         string               `json:"error,omitempty"`
+		Created       time.Time            `json:"created"`
+		Finished      time.Time            `json:"finished"`
+		Hypervisors   []*UpgradeHypervisor `json:"hypervisors"`
+	}
+
+	// UpgradeHypervisor is the progress of a hypervisor through an Upgrade
+	UpgradeHypervisor struct {
+		ID     string    `json:"id"`
+		Step   string    `json:"step"`
+		Since  time.Time `json:"since"`            // when the step began
+		Guests []string  `json:"guests,omitempty"` // guests shut down, to be started again
+		Jobs   []string  `json:"jobs,omitempty"`   // guest jobs the step waits for
+		Error  string    `json:"error,omitempty"`
+	}
+
+	// Upgrades is an alias to a slice of *Upgrade
+	Upgrades []*Upgrade
+)
+
+// InProgress returns whether the step is one a hypervisor is being upgraded in
+func (uh *UpgradeHypervisor) InProgress() bool {
+	switch uh.Step {
+	case UpgradeStepDraining, UpgradeStepUpgrading, UpgradeStepRestoring:
+		return true
+	}
+	return false
+}
+
+// NewUpgrade creates a blank Upgrade
+func (c *Context) NewUpgrade() *Upgrade {
+	return &Upgrade{
+		context:     c,
+		ID:          uuid.New(),
+		BatchSize:   1,
+		State:       UpgradeRunning,
+		Created:     time.Now(),
+		Hypervisors: []*UpgradeHypervisor{},
+	}
+}
+
+// Upgrade fetches an Upgrade from the config store
+func (c *Context) Upgrade(id string) (*Upgrade, error) {
+	var err error
+	id, err = canonicalizeUUID(id)
+	if err != nil {
+		return nil, err
+	}
+	u := &Upgrade{
+		context: c,
+		ID:      id,
+	}
+
+	if err := u.Refresh(); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// ActiveUpgrade fetches the Upgrade being rolled out, or nil if there is none
+func (c *Context) ActiveUpgrade() (*Upgrade, error) {
+	value, err := c.kv.Get(UpgradeLockKey)
+	if err != nil {
+		if c.kv.IsKeyNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return c.Upgrade(string(value.Data))
+}
+
+// key is a helper to generate the config store key
+func (u *Upgrade) key() string {
+	return filepath.Join(UpgradePath, u.ID, "metadata")
+}
+
+// fromResponse is a helper to unmarshal an Upgrade
+func (u *Upgrade) fromResponse(value kv.Value) error {
+	// start afresh, as unmarshaling would reuse the hypervisors
+	*u = Upgrade{context: u.context, modifiedIndex: value.Index}
+	return json.Unmarshal(value.Data, &u)
+}
+
+// Refresh reloads from the data store
+func (u *Upgrade) Refresh() error {
+	resp, err := u.context.kv.Get(u.key())
+	if err != nil {
+		return err
+	}
+
+	return u.fromResponse(resp)
+}
+
+// AddHypervisor adds a hypervisor to be upgraded after those already added
+func (u *Upgrade) AddHypervisor(id string) {
+	u.Hypervisors = append(u.Hypervisors, &UpgradeHypervisor{
+		ID:   id,
+		Step: UpgradeStepPending,
+	})
+}
+
+// Validate ensures an Upgrade has reasonable data.
+func (u *Upgrade) Validate() error {
+	if _, err := canonicalizeUUID(u.ID); err != nil {
+		return newValidationError("id", "missing or invalid id")
+	}
+	if u.Version == "" {
+		return newValidationError("version", "missing version")
+	}
+	if u.BatchSize < 1 {
+		return newValidationError("batch_size", "batch size must be at least 1")
+	}
+	switch u.State {
+	case UpgradeRunning, UpgradePaused, UpgradeAborted, UpgradeFailed, UpgradeDone:
+	default:
+		return newValidationError("state", "invalid state")
+	}
+	if len(u.Hypervisors) == 0 {
+		return newValidationError("hypervisors", "no hypervisors to upgrade")
+	}
+	seen := make(map[string]bool, len(u.Hypervisors))
+	for _, uh := range u.Hypervisors {
+		if _, err := canonicalizeUUID(uh.ID); err != nil {
+			return newValidationError("hypervisors", "invalid hypervisor")
+		}
+		if seen[uh.ID] {
+			return newValidationError("hypervisors", fmt.Sprintf("hypervisor %s is listed more than once", uh.ID))
+		}
+		seen[uh.ID] = true
+	}
+	return nil
+}
+
+// Save persists the Upgrade to the data store. Concurrent changes fail with a
+// conflict error.
+func (u *Upgrade) Save() error {
+	if err := u.Validate(); err != nil {
+		return err
+	}
+
+	v, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+
+	index, err := u.context.kv.Update(u.key(), kv.Value{Data: v, Index: u.modifiedIndex})
+	if err != nil {
+		return err
+	}
+	u.modifiedIndex = index
+	return nil
+}
+
+// SaveProgress saves the progress of the hypervisors of the Upgrade. If the
+// upgrade was saved concurrently, e.g. paused, that change is kept and the
+// upgrade is refreshed with it.
+func (u *Upgrade) SaveProgress() error {
+	for i := 0; ; i++ {
+		err := u.Save()
+		if err == nil || !lerrors.IsConflict(err) || i == upgradeStateRetries {
+			return err
+		}
+
+		progress := u.Hypervisors
+		if err := u.Refresh(); err != nil {
+			return err
+		}
+		u.Hypervisors = progress
+	}
+}
+
+// Start saves a new Upgrade and makes it the one being rolled out, which fails
+// with a conflict error while another is. All hypervisors are upgraded, in
+// order of id, if none were added.
+func (u *Upgrade) Start() error {
+	if len(u.Hypervisors) == 0 {
+		var ids []string
+		err := u.context.ForEachHypervisor(func(h *Hypervisor) error {
+			ids = append(ids, h.ID)
+			return nil
+		})
+		if err != nil && !u.context.IsKeyNotFound(err) {
+			return err
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			u.AddHypervisor(id)
+		}
+	}
+	u.State = UpgradeRunning
+	if err := u.Validate(); err != nil {
+		return err
+	}
+	for _, uh := range u.Hypervisors {
+		if _, err := u.context.Hypervisor(uh.ID); err != nil {
+			if u.context.IsKeyNotFound(err) {
+				return newValidationError("hypervisors", fmt.Sprintf("hypervisor %s does not exist", uh.ID))
+			}
+			return err
+		}
+	}
+
+	if _, err := u.context.kv.Update(UpgradeLockKey, kv.Value{Data: []byte(u.ID)}); err != nil {
+		if !lerrors.IsConflict(err) {
+			return err
+		}
+		active, err := u.context.kv.Get(UpgradeLockKey)
+		if err != nil {
+			return err
+		}
+		return lerrors.Conflictf("upgrade %s is already in progress", active.Data)
+	}
+
+	if err := u.Save(); err != nil {
+		_ = u.releaseLock()
+		return err
+	}
+	return nil
+}
+
+// releaseLock lets another upgrade be rolled out, if this one was
+func (u *Upgrade) releaseLock() error {
+	value, err := u.context.kv.Get(UpgradeLockKey)
+	if err != nil {
+		if u.context.kv.IsKeyNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if string(value.Data) != u.ID {
+		return nil
+	}
+	return u.context.kv.Remove(UpgradeLockKey, value.Index)
+}
+
+// changeState moves the Upgrade from one of the states given to another, for
+// the reason given if it failed, and saves it, refreshing and trying again if
+// it was saved concurrently. Moving from other states fails with a conflict
+// error.
+func (u *Upgrade) changeState(to, reason string, from ...string) error {
+	for i := 0; ; i++ {
+		allowed := false
+		for _, state := range from {
+			allowed = allowed || u.State == state
+		}
+		if !allowed {
+			return lerrors.Conflictf("upgrade %s is %s", u.ID, u.State)
+		}
+
+		u.State = to
+		if reason != "" {
+			u.Error = reason
+		}
+		if to != UpgradeRunning && to != UpgradePaused {
+			u.Finished = time.Now()
+		}
+		err := u.Save()
+		if err == nil || !lerrors.IsConflict(err) || i == upgradeStateRetries {
+			if err == nil && !u.Finished.IsZero() {
+				return u.releaseLock()
+			}
+			return err
+		}
+		if err := u.Refresh(); err != nil {
+			return err
+		}
+	}
+}
+
+// Pause stops hypervisors from starting the Upgrade. Those already being
+// upgraded are finished.
+func (u *Upgrade) Pause() error {
+	return u.changeState(UpgradePaused, "", UpgradeRunning)
+}
+
+// Resume continues a paused Upgrade
+func (u *Upgrade) Resume() error {
+	return u.changeState(UpgradeRunning, "", UpgradePaused)
+}
+
+// Abort ends the Upgrade at once, leaving hypervisors being upgraded at their
+// step, and lets another be rolled out
+func (u *Upgrade) Abort() error {
+	return u.changeState(UpgradeAborted, "", UpgradeRunning, UpgradePaused)
+}
+
+// Fail ends the Upgrade with an error, and lets another be rolled out
+func (u *Upgrade) Fail(err error) error {
+	return u.changeState(UpgradeFailed, err.Error(), UpgradeRunning, UpgradePaused)
+}
+
+// Complete ends the Upgrade once every hypervisor is upgraded, and lets
+// another be rolled out
+func (u *Upgrade) Complete() error {
+	for _, uh := range u.Hypervisors {
+		if uh.Step != UpgradeStepDone {
+			return fmt.Errorf("hypervisor %s is %s", uh.ID, uh.Step)
+		}
+	}
+	return u.changeState(UpgradeDone, "", UpgradeRunning, UpgradePaused)
+}
+
+// Destroy removes a finished Upgrade
+func (u *Upgrade) Destroy() error {
+	if u.modifiedIndex == 0 {
+		// it has not been saved?
+		return lerrors.NotFound(errors.New("not persisted"))
+	}
+	if u.Finished.IsZero() {
+		return lerrors.Conflictf("upgrade %s is %s", u.ID, u.State)
+	}
+
+	if err := u.context.kv.Remove(u.key(), u.modifiedIndex); err != nil {
+		return err
+	}
+	return u.context.kv.Delete(filepath.Join(UpgradePath, u.ID), true)
+}
+
+// ForEachUpgrade will run f on each Upgrade. It will stop iteration if f
+// returns an error.
+func (c *Context) ForEachUpgrade(f func(*Upgrade) error) error {
+	keys, err := c.kv.Keys(UpgradePath)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		u, err := c.Upgrade(filepath.Base(k))
+		if err != nil {
+			return err
+		}
+
+		if err := f(u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package lochness_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestUpgrade(t *testing.T) {
+	suite.Run(t, new(UpgradeSuite))
+}
+
+type UpgradeSuite struct {
+	common.Suite
+}
+
+// newUpgrade creates and starts an upgrade of the hypervisors given
+func (s *UpgradeSuite) newUpgrade(hypervisors ...*lochness.Hypervisor) *lochness.Upgrade {
+	upgrade := s.Context.NewUpgrade()
+	upgrade.Version = "0.5.0"
+	for _, h := range hypervisors {
+		upgrade.AddHypervisor(h.ID)
+	}
+	s.Require().NoError(upgrade.Start())
+	return upgrade
+}
+
+func (s *UpgradeSuite) TestValidate() {
+	hypervisor := s.NewHypervisor()
+	tests := []struct {
+		description string
+		modify      func(*lochness.Upgrade)
+		valid       bool
+	}{
+		{"valid", func(u *lochness.Upgrade) {}, true},
+		{"no version", func(u *lochness.Upgrade) { u.Version = "" }, false},
+		{"no batch size", func(u *lochness.Upgrade) { u.BatchSize = 0 }, false},
+		{"invalid state", func(u *lochness.Upgrade) { u.State = "foo" }, false},
+		{"no hypervisors", func(u *lochness.Upgrade) { u.Hypervisors = nil }, false},
+		{"invalid hypervisor", func(u *lochness.Upgrade) { u.AddHypervisor("foo") }, false},
+		{"duplicate hypervisor", func(u *lochness.Upgrade) { u.AddHypervisor(hypervisor.ID) }, false},
+	}
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		upgrade := s.Context.NewUpgrade()
+		upgrade.Version = "0.5.0"
+		upgrade.AddHypervisor(hypervisor.ID)
+		test.modify(upgrade)
+		err := upgrade.Validate()
+		if test.valid {
+			s.NoError(err, msg("should be valid"))
+		} else {
+			s.True(lerrors.IsValidation(err), msg("should be a validation error"))
+		}
+	}
+}
+
+func (s *UpgradeSuite) TestStart() {
+	hypervisors := []*lochness.Hypervisor{s.NewHypervisor(), s.NewHypervisor()}
+
+	upgrade := s.Context.NewUpgrade()
+	upgrade.Version = "0.5.0"
+	upgrade.AddHypervisor(uuid.New())
+	s.True(lerrors.IsValidation(upgrade.Start()), "missing hypervisors should not be upgraded")
+
+	active, err := s.Context.ActiveUpgrade()
+	s.NoError(err)
+	s.Nil(active)
+
+	upgrade = s.Context.NewUpgrade()
+	upgrade.Version = "0.5.0"
+	s.Require().NoError(upgrade.Start())
+	s.Require().Len(upgrade.Hypervisors, 2, "all hypervisors should be upgraded by default")
+	for _, uh := range upgrade.Hypervisors {
+		s.Equal(lochness.UpgradeStepPending, uh.Step)
+	}
+	s.True(upgrade.Hypervisors[0].ID < upgrade.Hypervisors[1].ID, "hypervisors should be upgraded in order of id")
+
+	active, err = s.Context.ActiveUpgrade()
+	s.NoError(err)
+	s.Require().NotNil(active)
+	s.Equal(upgrade.ID, active.ID)
+
+	other := s.Context.NewUpgrade()
+	other.Version = "0.5.1"
+	other.AddHypervisor(hypervisors[0].ID)
+	err = other.Start()
+	s.True(lerrors.IsConflict(err), "only one upgrade should be rolled out at a time")
+	s.Contains(err.Error(), upgrade.ID)
+	_, err = s.Context.Upgrade(other.ID)
+	s.True(s.Context.IsKeyNotFound(err), "conflicting upgrade should not be saved")
+
+	s.Require().NoError(upgrade.Abort())
+	s.NoError(other.Start(), "aborting should let another upgrade be rolled out")
+}
+
+func (s *UpgradeSuite) TestStateChanges() {
+	upgrade := s.newUpgrade(s.NewHypervisor())
+
+	s.True(lerrors.IsConflict(upgrade.Resume()), "running upgrades should not be resumed")
+	s.NoError(upgrade.Pause())
+	s.Equal(lochness.UpgradePaused, upgrade.State)
+	s.True(lerrors.IsConflict(upgrade.Pause()))
+
+	// a stale copy is refreshed before its change
+	s.NoError(upgrade.Resume())
+	stale, err := s.Context.Upgrade(upgrade.ID)
+	s.Require().NoError(err)
+	s.NoError(upgrade.Pause())
+	s.True(lerrors.IsConflict(stale.Pause()), "stale copy should find the upgrade paused")
+	s.Equal(lochness.UpgradePaused, stale.State)
+
+	s.NoError(upgrade.Abort())
+	s.Equal(lochness.UpgradeAborted, upgrade.State)
+	s.False(upgrade.Finished.IsZero())
+	s.True(lerrors.IsConflict(upgrade.Resume()), "aborted upgrades should not be resumed")
+
+	active, err := s.Context.ActiveUpgrade()
+	s.NoError(err)
+	s.Nil(active)
+}
+
+func (s *UpgradeSuite) TestFailAndComplete() {
+	upgrade := s.newUpgrade(s.NewHypervisor())
+	s.Error(upgrade.Complete(), "upgrades should not be done before their hypervisors")
+
+	s.NoError(upgrade.Fail(errors.New("foo")))
+	s.Equal(lochness.UpgradeFailed, upgrade.State)
+	s.Equal("foo", upgrade.Error)
+
+	upgrade = s.newUpgrade(s.NewHypervisor())
+	upgrade.Hypervisors[0].Step = lochness.UpgradeStepDone
+	s.NoError(upgrade.Complete())
+	s.Equal(lochness.UpgradeDone, upgrade.State)
+}
+
+func (s *UpgradeSuite) TestSaveProgress() {
+	upgrade := s.newUpgrade(s.NewHypervisor())
+	other, err := s.Context.Upgrade(upgrade.ID)
+	s.Require().NoError(err)
+	s.Require().NoError(other.Pause())
+
+	upgrade.Hypervisors[0].Step = lochness.UpgradeStepDraining
+	s.NoError(upgrade.SaveProgress())
+	s.Equal(lochness.UpgradePaused, upgrade.State, "concurrent state change should be kept")
+
+	saved, err := s.Context.Upgrade(upgrade.ID)
+	s.Require().NoError(err)
+	s.Equal(lochness.UpgradePaused, saved.State)
+	s.Equal(lochness.UpgradeStepDraining, saved.Hypervisors[0].Step)
+}
+
+func (s *UpgradeSuite) TestDestroy() {
+	upgrade := s.newUpgrade(s.NewHypervisor())
+	s.True(lerrors.IsConflict(upgrade.Destroy()), "upgrades in progress should not be removed")
+
+	s.Require().NoError(upgrade.Abort())
+	s.NoError(upgrade.Destroy())
+	_, err := s.Context.Upgrade(upgrade.ID)
+	s.True(s.Context.IsKeyNotFound(err))
+}
+
+func (s *UpgradeSuite) TestForEachUpgrade() {
+	upgrade := s.newUpgrade(s.NewHypervisor())
+	s.Require().NoError(upgrade.Abort())
+	other := s.newUpgrade(s.NewHypervisor())
+
+	seen := map[string]bool{}
+	s.NoError(s.Context.ForEachUpgrade(func(u *lochness.Upgrade) error {
+		seen[u.ID] = true
+		return nil
+	}))
+	s.Equal(map[string]bool{upgrade.ID: true, other.ID: true}, seen)
+}
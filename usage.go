@@ -0,0 +1,243 @@
+package lochness
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/mistifyio/lochness/pkg/kv"
+)
+
+var (
+	// UsagePath is the path in the config store. The usage of guests is kept
+	// apart from them so that it outlives them.
+	UsagePath = "lochness/usage/"
+
+	// TenantMetadataKey is the guest metadata key naming the tenant a guest's
+	// usage is charged to
+	TenantMetadataKey = "tenant"
+)
+
+// UsageStateDestroyed is the state usage is recorded with once a guest is
+// destroyed, ending its reservation
+const UsageStateDestroyed = "destroyed"
+
+// usageDay is the layout of the days of Usage
+const usageDay = "2006-01-02"
+
+type (
+	// UsageEvent is a change of the state, tenant, or reserved resources of a
+	// guest. They hold from its time until the next event of the guest.
+	UsageEvent struct {
+		Time      time.Time `json:"time"`
+		State     string    `json:"state"`
+		Tenant    string    `json:"tenant"`
+		Resources Resources `json:"resources"` // reserved on the hypervisor
+	}
+
+	// guestUsage is the usage events of a guest, oldest first
+	guestUsage struct {
+		GuestID string       `json:"guest"`
+		Events  []UsageEvent `json:"events"`
+	}
+
+	// Usage is the usage of the guests of a tenant on a day, in UTC. Guests
+	// hold their resources whatever their state until they are destroyed, so
+	// the reservations are charged for shut down and suspended guests too,
+	// while RunningHours is only the time they ran. Reservations are in
+	// resource hours, e.g. MemoryHours is MB hours.
+	Usage struct {
+		Tenant       string  `json:"tenant"`
+		Day          string  `json:"day"`
+		Guests       int     `json:"guests"`
+		RunningHours float64 `json:"running_hours"`
+		MemoryHours  float64 `json:"memory_hours"`
+		DiskHours    float64 `json:"disk_hours"`
+		CPUHours     float64 `json:"cpu_hours"`
+	}
+
+	// Usages is an alias to a slice of *Usage
+	Usages []*Usage
+)
+
+// Tenant returns the tenant of the guest, or "" if it has none
+func (g *Guest) Tenant() string {
+	return g.Metadata[TenantMetadataKey]
+}
+
+// usageKey is a helper for generating a key for config store.
+func (g *Guest) usageKey() string {
+	return filepath.Join(UsagePath, g.ID)
+}
+
+// reservedResources returns the resources the guest holds on its hypervisor.
+// Guests being resized hold the larger of both flavors until the resize is
+// done.
+func (g *Guest) reservedResources() (Resources, error) {
+	flavor, err := g.context.Flavor(g.FlavorID)
+	if err != nil {
+		return Resources{}, err
+	}
+	reserved := flavor.Resources
+	if g.ResizeFlavor != "" {
+		resize, err := g.context.Flavor(g.ResizeFlavor)
+		if err != nil {
+			return Resources{}, err
+		}
+		reserved = reserved.max(resize.Resources)
+	}
+	return reserved, nil
+}
+
+// RecordUsage records the current state, tenant, and reserved resources of the
+// Guest as of now, unless none of them changed since they were last recorded.
+// It should be called once they change, e.g. when an action completes.
+func (g *Guest) RecordUsage() error {
+	reserved, err := g.reservedResources()
+	if err != nil {
+		return err
+	}
+	return g.recordUsage(UsageEvent{
+		Time:      time.Now(),
+		State:     g.State(),
+		Tenant:    g.Tenant(),
+		Resources: reserved,
+	})
+}
+
+// recordUsageDestroyed records that the guest was destroyed, ending its usage
+func (g *Guest) recordUsageDestroyed() error {
+	return g.recordUsage(UsageEvent{
+		Time:   time.Now(),
+		State:  UsageStateDestroyed,
+		Tenant: g.Tenant(),
+	})
+}
+
+// recordUsage adds an event to the usage of the guest
+func (g *Guest) recordUsage(event UsageEvent) error {
+	var index uint64
+	usage := guestUsage{GuestID: g.ID}
+	value, err := g.context.kv.Get(g.usageKey())
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(value.Data, &usage); err != nil {
+			return err
+		}
+		index = value.Index
+	case !g.context.kv.IsKeyNotFound(err):
+		return err
+	}
+
+	if n := len(usage.Events); n > 0 {
+		last := usage.Events[n-1]
+		if last.State == event.State && last.Tenant == event.Tenant && last.Resources == event.Resources {
+			return nil
+		}
+	}
+	usage.Events = append(usage.Events, event)
+
+	v, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+	_, err = g.context.kv.Update(g.usageKey(), kv.Value{Data: v, Index: index})
+	return err
+}
+
+// Usage aggregates the usage of the guests between from and to per tenant and
+// day, ordered by day then tenant. With a tenant, only its usage is returned.
+// Guests are charged to the tenant they had at the time.
+func (c *Context) Usage(tenant string, from, to time.Time) (Usages, error) {
+	if !from.Before(to) {
+		return nil, newValidationError("to", "must be after from")
+	}
+
+	keys, err := c.kv.Keys(UsagePath)
+	if err != nil {
+		if c.kv.IsKeyNotFound(err) {
+			return Usages{}, nil
+		}
+		return nil, err
+	}
+
+	usages := make(map[string]*Usage)
+	guests := make(map[string]map[string]bool)
+	now := time.Now()
+	for _, key := range keys {
+		value, err := c.kv.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		var usage guestUsage
+		if err := json.Unmarshal(value.Data, &usage); err != nil {
+			return nil, err
+		}
+
+		for i, event := range usage.Events {
+			if event.State == UsageStateDestroyed || (tenant != "" && event.Tenant != tenant) {
+				continue
+			}
+			start, end := event.Time, now
+			if i+1 < len(usage.Events) {
+				end = usage.Events[i+1].Time
+			}
+			if start.Before(from) {
+				start = from
+			}
+			if end.After(to) {
+				end = to
+			}
+
+			// charge each day its share of the interval
+			for start.Before(end) {
+				day := start.UTC().Truncate(24 * time.Hour)
+				dayEnd := day.Add(24 * time.Hour)
+				if end.Before(dayEnd) {
+					dayEnd = end
+				}
+				hours := dayEnd.Sub(start).Hours()
+
+				id := event.Tenant + "/" + day.Format(usageDay)
+				u, ok := usages[id]
+				if !ok {
+					u = &Usage{Tenant: event.Tenant, Day: day.Format(usageDay)}
+					usages[id] = u
+					guests[id] = make(map[string]bool)
+				}
+				if !guests[id][usage.GuestID] {
+					guests[id][usage.GuestID] = true
+					u.Guests++
+				}
+				if event.State == GuestStateRunning {
+					u.RunningHours += hours
+				}
+				u.MemoryHours += hours * float64(event.Resources.Memory)
+				u.DiskHours += hours * float64(event.Resources.Disk)
+				u.CPUHours += hours * float64(event.Resources.CPU)
+
+				start = dayEnd
+			}
+		}
+	}
+
+	result := make(Usages, 0, len(usages))
+	for _, u := range usages {
+		result = append(result, u)
+	}
+	sort.Sort(usagesByDay(result))
+	return result, nil
+}
+
+// usagesByDay orders usages by day, then tenant
+type usagesByDay Usages
+
+func (u usagesByDay) Len() int      { return len(u) }
+func (u usagesByDay) Swap(i, j int) { u[i], u[j] = u[j], u[i] }
+func (u usagesByDay) Less(i, j int) bool {
+	if u[i].Day != u[j].Day {
+		return u[i].Day < u[j].Day
+	}
+	return u[i].Tenant < u[j].Tenant
+}
@@ -0,0 +1,120 @@
+package lochness_test
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestUsage(t *testing.T) {
+	suite.Run(t, new(UsageSuite))
+}
+
+type UsageSuite struct {
+	common.Suite
+}
+
+// events gets the usage events recorded of a guest
+func (s *UsageSuite) events(guestID string) []lochness.UsageEvent {
+	value, err := s.KV.Get(filepath.Join(lochness.UsagePath, guestID))
+	s.Require().NoError(err)
+	var usage struct {
+		Events []lochness.UsageEvent `json:"events"`
+	}
+	s.Require().NoError(json.Unmarshal(value.Data, &usage))
+	return usage.Events
+}
+
+func (s *UsageSuite) TestRecordUsage() {
+	guest := s.NewGuest()
+	flavor, _ := s.Context.Flavor(guest.FlavorID)
+	guest.Metadata[lochness.TenantMetadataKey] = "acme"
+	guest.Metadata["state"] = lochness.GuestStateRunning
+	s.Require().NoError(guest.Save())
+
+	s.Equal("acme", guest.Tenant())
+	s.NoError(guest.RecordUsage())
+	// unchanged usage is not recorded again
+	s.NoError(guest.RecordUsage())
+
+	guest.Metadata["state"] = lochness.GuestStateShutdown
+	s.Require().NoError(guest.Save())
+	s.NoError(guest.RecordUsage())
+	s.NoError(guest.Destroy())
+
+	events := s.events(guest.ID)
+	if !s.Len(events, 3) {
+		return
+	}
+	s.Equal(lochness.GuestStateRunning, events[0].State)
+	s.Equal("acme", events[0].Tenant)
+	s.Equal(flavor.Resources, events[0].Resources)
+	s.Equal(lochness.GuestStateShutdown, events[1].State)
+	s.Equal(lochness.UsageStateDestroyed, events[2].State)
+	s.Equal(lochness.Resources{}, events[2].Resources)
+
+	usage, err := s.Context.Usage("acme", time.Now().Add(-time.Hour), time.Now())
+	s.NoError(err)
+	if s.Len(usage, 1) {
+		s.Equal("acme", usage[0].Tenant)
+		s.Equal(1, usage[0].Guests)
+	}
+}
+
+func (s *UsageSuite) TestUsage() {
+	day := time.Date(2016, 3, 7, 0, 0, 0, 0, time.UTC)
+	at := func(hours int) time.Time {
+		return day.Add(time.Duration(hours) * time.Hour)
+	}
+	resources := lochness.Resources{Memory: 1024, Disk: 10240, CPU: 2}
+
+	records := map[string][]lochness.UsageEvent{
+		"acme": {
+			{Time: at(12), State: lochness.GuestStateRunning, Tenant: "acme", Resources: resources},
+			{Time: at(30), State: lochness.GuestStateShutdown, Tenant: "acme", Resources: resources},
+			{Time: at(36), State: lochness.UsageStateDestroyed, Tenant: "acme"},
+		},
+		"other": {
+			{Time: at(0), State: lochness.GuestStateRunning, Tenant: "other", Resources: resources},
+		},
+	}
+	for tenant, events := range records {
+		guest := s.NewGuest()
+		usage, _ := json.Marshal(map[string]interface{}{
+			"guest":  guest.ID,
+			"events": events,
+		})
+		s.Require().NoError(s.KV.Set(filepath.Join(lochness.UsagePath, guest.ID), string(usage)), tenant)
+	}
+
+	usage, err := s.Context.Usage("", at(0), at(48))
+	s.NoError(err)
+	expected := lochness.Usages{
+		{Tenant: "acme", Day: "2016-03-07", Guests: 1, RunningHours: 12, MemoryHours: 12 * 1024, DiskHours: 12 * 10240, CPUHours: 24},
+		{Tenant: "other", Day: "2016-03-07", Guests: 1, RunningHours: 24, MemoryHours: 24 * 1024, DiskHours: 24 * 10240, CPUHours: 48},
+		{Tenant: "acme", Day: "2016-03-08", Guests: 1, RunningHours: 6, MemoryHours: 12 * 1024, DiskHours: 12 * 10240, CPUHours: 24},
+		{Tenant: "other", Day: "2016-03-08", Guests: 1, RunningHours: 24, MemoryHours: 24 * 1024, DiskHours: 24 * 10240, CPUHours: 48},
+	}
+	s.Equal(expected, usage)
+
+	usage, err = s.Context.Usage("acme", at(18), at(48))
+	s.NoError(err)
+	if s.Len(usage, 2) {
+		s.Equal(6.0, usage[0].RunningHours)
+		s.Equal(6.0, usage[1].RunningHours)
+		s.Equal(12.0*2, usage[1].CPUHours)
+	}
+
+	usage, err = s.Context.Usage("nobody", at(0), at(48))
+	s.NoError(err)
+	s.Len(usage, 0)
+
+	_, err = s.Context.Usage("", at(48), at(0))
+	s.True(lerrors.IsValidation(err))
+}
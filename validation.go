@@ -0,0 +1,14 @@
+package lochness
+
+import (
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+)
+
+// ValidationError is returned by Validate methods. Fields lists the json names
+// of the fields that failed validation. It is an errors.ErrValidation.
+type ValidationError = lerrors.ValidationError
+
+// newValidationError creates a ValidationError for a single field
+func newValidationError(field, message string) *ValidationError {
+	return lerrors.Validation(field, message)
+}
@@ -7,6 +7,7 @@ import (
 	"strconv"
 	"strings"
 
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
 	"github.com/mistifyio/lochness/pkg/kv"
 )
 
@@ -22,6 +23,7 @@ type (
 		modifiedIndex uint64
 		Tag           int    `json:"tag"`
 		Description   string `json:"description"`
+		SubnetID      string `json:"subnet,omitempty" schema:"readonly"` // subnet it is allocated to from its network's VLAN ranges
 		vlanGroups    []string
 	}
 
@@ -83,7 +85,7 @@ func (v *VLAN) Refresh() error {
 	key := filepath.Join(prefix, "metadata")
 	value, ok := nodes[key]
 	if !ok {
-		return errors.New("metadata key is missing")
+		return lerrors.NotFound(errors.New("metadata key is missing"))
 	}
 
 	if err := json.Unmarshal(value.Data, &v); err != nil {
@@ -114,7 +116,7 @@ func (v *VLAN) Refresh() error {
 func (v *VLAN) Validate() error {
 	// Tag must be positive and fit in 12bit
 	if v.Tag <= 0 || v.Tag > 4095 {
-		return errors.New("invalid tag")
+		return newValidationError("tag", "invalid tag")
 	}
 	return nil
 }
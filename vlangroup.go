@@ -122,7 +122,7 @@ func (vg *VLANGroup) Refresh() error {
 // Validate ensures a VLANGroup has resonable data.
 func (vg *VLANGroup) Validate() error {
 	if _, err := canonicalizeUUID(vg.ID); err != nil {
-		return errors.New("invalid ID")
+		return newValidationError("id", "invalid ID")
 	}
 	return nil
 }
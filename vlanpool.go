@@ -0,0 +1,148 @@
+package lochness
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+)
+
+// MaxVLANTag is the highest tag VLAN ranges may have, 4095 being reserved
+const MaxVLANTag = 4094
+
+// VLANRange is a range of VLAN tags, from Start to End inclusive
+type VLANRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// String returns the range as "<start>-<end>"
+func (r VLANRange) String() string {
+	return fmt.Sprintf("%d-%d", r.Start, r.End)
+}
+
+// validateVLANRanges ensures VLAN ranges are within the valid tags and do not
+// overlap
+func validateVLANRanges(ranges []VLANRange) error {
+	sorted := make([]VLANRange, len(ranges))
+	copy(sorted, ranges)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+	for i, r := range sorted {
+		if r.Start < 1 || r.End > MaxVLANTag || r.Start > r.End {
+			return newValidationError("vlan_ranges", fmt.Sprintf("invalid vlan range %s: must be within 1-%d", r, MaxVLANTag))
+		}
+		if i > 0 && sorted[i-1].End >= r.Start {
+			return newValidationError("vlan_ranges", fmt.Sprintf("vlan range %s overlaps %s", r, sorted[i-1]))
+		}
+	}
+	return nil
+}
+
+// usedVLANTags returns the tags of the VLANs that exist, whether allocated to
+// a subnet or added by hand
+func (c *Context) usedVLANTags() (map[int]bool, error) {
+	keys, err := c.kv.Keys(VLANPath)
+	if err != nil && !c.IsKeyNotFound(err) {
+		return nil, err
+	}
+	used := make(map[int]bool, len(keys))
+	for _, k := range keys {
+		if tag, err := strconv.Atoi(filepath.Base(k)); err == nil {
+			used[tag] = true
+		}
+	}
+	return used, nil
+}
+
+// VLANPool is the allocation of the VLAN ranges of a network
+type VLANPool struct {
+	NetworkID string         `json:"network"`
+	Ranges    []VLANRange    `json:"vlan_ranges"`
+	Total     int            `json:"total"`
+	Available int            `json:"available"`
+	Allocated map[int]string `json:"allocated"` // tag to subnet id
+}
+
+// VLANPool returns the allocation of the network's VLAN ranges. Tags in them
+// of VLANs added by hand are neither allocated nor available.
+func (n *Network) VLANPool() (*VLANPool, error) {
+	used, err := n.context.usedVLANTags()
+	if err != nil {
+		return nil, err
+	}
+
+	pool := &VLANPool{
+		NetworkID: n.ID,
+		Ranges:    n.VLANRanges,
+		Allocated: make(map[int]string),
+	}
+	for _, subnetID := range n.subnets {
+		subnet, err := n.context.Subnet(subnetID)
+		if err != nil {
+			return nil, err
+		}
+		if subnet.VLAN != 0 {
+			pool.Allocated[subnet.VLAN] = subnet.ID
+		}
+	}
+	for _, r := range n.VLANRanges {
+		pool.Total += r.End - r.Start + 1
+		for tag := r.Start; tag <= r.End; tag++ {
+			if !used[tag] {
+				pool.Available++
+			}
+		}
+	}
+	return pool, nil
+}
+
+// allocateVLAN creates a VLAN with a free tag of the network's ranges for a
+// subnet, returning its tag. Tags are free if no VLAN has them, in any
+// network, so concurrent allocations can not hand out the same tag. It fails
+// with a conflict if no tag is free.
+func (n *Network) allocateVLAN(s *Subnet) (int, error) {
+	used, err := n.context.usedVLANTags()
+	if err != nil {
+		return 0, err
+	}
+	for _, r := range n.VLANRanges {
+		for tag := r.Start; tag <= r.End; tag++ {
+			if used[tag] {
+				continue
+			}
+			vlan := n.context.blankVLAN(tag)
+			vlan.Description = fmt.Sprintf("subnet %s of network %s", s.ID, n.ID)
+			vlan.SubnetID = s.ID
+			// saving a new VLAN fails if another was saved with the tag since
+			if err := vlan.Save(); err != nil {
+				if lerrors.IsConflict(err) {
+					continue
+				}
+				return 0, err
+			}
+			return tag, nil
+		}
+	}
+	return 0, lerrors.Conflict(fmt.Errorf("no free vlan tag in the ranges of network %s", n.ID))
+}
+
+// releaseVLAN destroys the VLAN allocated to a subnet, if it has one. VLANs
+// added by hand are left alone.
+func (n *Network) releaseVLAN(s *Subnet) error {
+	if s.VLAN == 0 {
+		return nil
+	}
+	vlan, err := n.context.VLAN(s.VLAN)
+	if err != nil {
+		if n.context.IsKeyNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if vlan.SubnetID != s.ID {
+		return nil
+	}
+	return vlan.Destroy()
+}
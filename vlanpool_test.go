@@ -0,0 +1,109 @@
+package lochness_test
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestVLANPool(t *testing.T) {
+	suite.Run(t, new(VLANPoolSuite))
+}
+
+type VLANPoolSuite struct {
+	common.Suite
+}
+
+// newSubnet creates and saves a new subnet, which does not overlap those of
+// other octets
+func (s *VLANPoolSuite) newSubnet(octet int) *lochness.Subnet {
+	subnet := s.Context.NewSubnet()
+	_, subnet.CIDR, _ = net.ParseCIDR(fmt.Sprintf("10.0.%d.0/24", octet))
+	subnet.StartRange = net.ParseIP(fmt.Sprintf("10.0.%d.2", octet))
+	subnet.EndRange = net.ParseIP(fmt.Sprintf("10.0.%d.10", octet))
+	s.Require().NoError(subnet.Save())
+	return subnet
+}
+
+func (s *VLANPoolSuite) TestValidateRanges() {
+	tests := []struct {
+		description string
+		ranges      []lochness.VLANRange
+		expectedErr bool
+	}{
+		{"no ranges", nil, false},
+		{"ranges", []lochness.VLANRange{{300, 300}, {100, 199}}, false},
+		{"zero tag", []lochness.VLANRange{{0, 10}}, true},
+		{"reserved tag", []lochness.VLANRange{{4000, 4095}}, true},
+		{"reversed", []lochness.VLANRange{{20, 10}}, true},
+		{"overlapping", []lochness.VLANRange{{100, 199}, {150, 250}}, true},
+	}
+	for _, test := range tests {
+		network := s.NewNetwork()
+		network.VLANRanges = test.ranges
+		err := network.Validate()
+		if test.expectedErr {
+			s.True(lerrors.IsValidation(err), test.description)
+		} else {
+			s.NoError(err, test.description)
+		}
+	}
+}
+
+func (s *VLANPoolSuite) TestAllocation() {
+	network := s.NewNetwork()
+	network.VLANRanges = []lochness.VLANRange{{100, 102}}
+	s.Require().NoError(network.Save())
+
+	// tags of vlans added by hand are not free
+	manual := s.Context.NewVLAN()
+	manual.Tag = 100
+	s.Require().NoError(manual.Save())
+
+	subnet := s.newSubnet(1)
+	s.Require().NoError(network.AddSubnet(subnet))
+	s.Equal(101, subnet.VLAN)
+	vlan, err := s.Context.VLAN(101)
+	s.Require().NoError(err)
+	s.Equal(subnet.ID, vlan.SubnetID)
+
+	other := s.newSubnet(2)
+	s.Require().NoError(network.AddSubnet(other))
+	s.Equal(102, other.VLAN)
+	s.True(lerrors.IsConflict(network.AddSubnet(s.newSubnet(3))), "no tag should be free")
+
+	pool, err := network.VLANPool()
+	s.Require().NoError(err)
+	s.Equal(3, pool.Total)
+	s.Equal(0, pool.Available)
+	s.Equal(map[int]string{101: subnet.ID, 102: other.ID}, pool.Allocated)
+
+	s.Require().NoError(network.RemoveSubnet(subnet))
+	s.Zero(subnet.VLAN)
+	_, err = s.Context.VLAN(101)
+	s.True(s.Context.IsKeyNotFound(err), "the tag should be released")
+
+	s.Require().NoError(other.Delete())
+	_, err = s.Context.VLAN(102)
+	s.True(s.Context.IsKeyNotFound(err), "deleted subnets should release their tag")
+	_, err = s.Context.VLAN(100)
+	s.NoError(err, "vlans added by hand should be kept")
+
+	s.Require().NoError(network.Refresh())
+	pool, err = network.VLANPool()
+	s.Require().NoError(err)
+	s.Equal(2, pool.Available)
+	s.Len(pool.Allocated, 0)
+}
+
+func (s *VLANPoolSuite) TestNoRanges() {
+	network := s.NewNetwork()
+	subnet := s.NewSubnet()
+	s.Require().NoError(network.AddSubnet(subnet))
+	s.Zero(subnet.VLAN)
+}
@@ -0,0 +1,189 @@
+package lochness
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"path"
+	"path/filepath"
+
+	lerrors "github.com/mistifyio/lochness/pkg/errors"
+	"github.com/mistifyio/lochness/pkg/kv"
+	"github.com/pborman/uuid"
+)
+
+var (
+	// WebhookPath is the path in the config store
+	WebhookPath = "lochness/webhooks/"
+
+	// WebhookFormats are the formats a Webhook may post events in
+	WebhookFormats = map[string]bool{
+		WebhookFormatJSON:  true,
+		WebhookFormatSlack: true,
+	}
+)
+
+// Webhook formats
+const (
+	// WebhookFormatJSON posts the event itself
+	WebhookFormatJSON = "json"
+	// WebhookFormatSlack posts a Slack message describing the event
+	WebhookFormatSlack = "slack"
+)
+
+type (
+	// Webhook posts events, such as a job failing, to a URL. Events may be
+	// filtered by type, and signed with a secret.
+	Webhook struct {
+		context       *Context
+		modifiedIndex uint64
+		ID            string            `json:"id"`
+		URL           string            `json:"url"`
+		Events        []string          `json:"events"`           // event type patterns, e.g. "guest.*", all events if empty
+		Secret        string            `json:"secret,omitempty"` // key for the HMAC-SHA256 signature of the body
+		Format        string            `json:"format"`
+		Disabled      bool              `json:"disabled"`
+		Metadata      map[string]string `json:"metadata"`
+	}
+
+	// Webhooks is an alias to a slice of *Webhook
+	Webhooks []*Webhook
+)
+
+// NewWebhook creates a blank Webhook
+func (c *Context) NewWebhook() *Webhook {
+	return &Webhook{
+		context:  c,
+		ID:       uuid.New(),
+		Events:   []string{},
+		Format:   WebhookFormatJSON,
+		Metadata: make(map[string]string),
+	}
+}
+
+// Webhook fetches a Webhook from the config store
+func (c *Context) Webhook(id string) (*Webhook, error) {
+	var err error
+	id, err = canonicalizeUUID(id)
+	if err != nil {
+		return nil, err
+	}
+	w := &Webhook{
+		context: c,
+		ID:      id,
+	}
+
+	if err := w.Refresh(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// key is a helper to generate the config store key
+func (w *Webhook) key() string {
+	return filepath.Join(WebhookPath, w.ID, "metadata")
+}
+
+// fromResponse is a helper to unmarshal a Webhook
+func (w *Webhook) fromResponse(value kv.Value) error {
+	w.modifiedIndex = value.Index
+	return json.Unmarshal(value.Data, &w)
+}
+
+// Refresh reloads from the data store
+func (w *Webhook) Refresh() error {
+	resp, err := w.context.kv.Get(w.key())
+	if err != nil {
+		return err
+	}
+
+	return w.fromResponse(resp)
+}
+
+// Validate ensures a Webhook has reasonable data.
+func (w *Webhook) Validate() error {
+	if _, err := canonicalizeUUID(w.ID); err != nil {
+		return newValidationError("id", "missing or invalid id")
+	}
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return newValidationError("url", "missing or invalid http(s) url")
+	}
+	for _, pattern := range w.Events {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return newValidationError("events", "invalid event pattern")
+		}
+	}
+	if !WebhookFormats[w.Format] {
+		return newValidationError("format", "invalid format")
+	}
+
+	return nil
+}
+
+// Matches returns whether events of a type, e.g. "guest.created", are posted
+// to the Webhook. Patterns are matched like file names, so "*" matches any
+// event and "*.failed" any failure.
+func (w *Webhook) Matches(eventType string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, pattern := range w.Events {
+		if ok, _ := path.Match(pattern, eventType); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Save persists the Webhook to the data store.
+func (w *Webhook) Save() error {
+	if err := w.Validate(); err != nil {
+		return err
+	}
+
+	v, err := json.Marshal(w)
+	if err != nil {
+		return err
+	}
+
+	index, err := w.context.kv.Update(w.key(), kv.Value{Data: v, Index: w.modifiedIndex})
+	if err != nil {
+		return err
+	}
+	w.modifiedIndex = index
+	return nil
+}
+
+// Destroy removes a Webhook
+func (w *Webhook) Destroy() error {
+	if w.modifiedIndex == 0 {
+		// it has not been saved?
+		return lerrors.NotFound(errors.New("not persisted"))
+	}
+
+	if err := w.context.kv.Remove(w.key(), w.modifiedIndex); err != nil {
+		return err
+	}
+	return w.context.kv.Delete(filepath.Join(WebhookPath, w.ID), true)
+}
+
+// ForEachWebhook will run f on each Webhook. It will stop iteration if f
+// returns an error.
+func (c *Context) ForEachWebhook(f func(*Webhook) error) error {
+	keys, err := c.kv.Keys(WebhookPath)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		w, err := c.Webhook(filepath.Base(k))
+		if err != nil {
+			return err
+		}
+
+		if err := f(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
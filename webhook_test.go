@@ -0,0 +1,193 @@
+package lochness_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mistifyio/lochness"
+	"github.com/mistifyio/lochness/internal/tests/common"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestWebhook(t *testing.T) {
+	suite.Run(t, new(WebhookSuite))
+}
+
+type WebhookSuite struct {
+	common.Suite
+}
+
+func (s *WebhookSuite) TestNewWebhook() {
+	webhook := s.Context.NewWebhook()
+	s.NotNil(uuid.Parse(webhook.ID))
+	s.Equal(lochness.WebhookFormatJSON, webhook.Format)
+}
+
+func (s *WebhookSuite) TestWebhook() {
+	webhook := s.NewWebhook()
+
+	tests := []struct {
+		description string
+		ID          string
+		expectedErr bool
+	}{
+		{"missing id", "", true},
+		{"invalid ID", "adf", true},
+		{"nonexistant ID", uuid.New(), true},
+		{"real ID", webhook.ID, false},
+	}
+
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		w, err := s.Context.Webhook(test.ID)
+		if test.expectedErr {
+			s.Error(err, msg("lookup should fail"))
+			s.Nil(w, msg("failure shouldn't return a webhook"))
+		} else {
+			s.NoError(err, msg("lookup should succeed"))
+			s.Equal(webhook.URL, w.URL, msg("success should return correct data"))
+		}
+	}
+}
+
+func (s *WebhookSuite) TestRefresh() {
+	webhook := s.NewWebhook()
+	webhookCopy, err := s.Context.Webhook(webhook.ID)
+	s.Require().NoError(err)
+
+	webhook.URL = "https://hooks.example.com/lochness"
+	s.Require().NoError(webhook.Save())
+	s.NoError(webhookCopy.Refresh(), "refresh existing should succeed")
+	s.Equal(webhook.URL, webhookCopy.URL, "refresh should pull new data")
+
+	newWebhook := s.Context.NewWebhook()
+	s.Error(newWebhook.Refresh(), "unsaved webhook refresh should fail")
+}
+
+func (s *WebhookSuite) TestValidate() {
+	id := uuid.New()
+	url := "https://hooks.example.com/lochness"
+	tests := []struct {
+		description string
+		ID          string
+		url         string
+		events      []string
+		format      string
+		expectedErr bool
+	}{
+		{"missing ID", "", url, nil, "json", true},
+		{"non uuid ID", "asdf", url, nil, "json", true},
+		{"missing url", id, "", nil, "json", true},
+		{"relative url", id, "/hook", nil, "json", true},
+		{"non http url", id, "ftp://example.com/hook", nil, "json", true},
+		{"empty pattern", id, url, []string{""}, "json", true},
+		{"invalid pattern", id, url, []string{"guest.["}, "json", true},
+		{"missing format", id, url, nil, "", true},
+		{"invalid format", id, url, nil, "xml", true},
+		{"all events", id, url, nil, "json", false},
+		{"filtered", id, url, []string{"guest.*", "job.failed"}, "slack", false},
+	}
+
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		webhook := &lochness.Webhook{
+			ID:     test.ID,
+			URL:    test.url,
+			Events: test.events,
+			Format: test.format,
+		}
+		err := webhook.Validate()
+		if test.expectedErr {
+			s.Error(err, msg("should be invalid"))
+		} else {
+			s.NoError(err, msg("should be valid"))
+		}
+	}
+}
+
+func (s *WebhookSuite) TestMatches() {
+	tests := []struct {
+		description string
+		events      []string
+		eventType   string
+		expected    bool
+	}{
+		{"no filter", nil, "guest.created", true},
+		{"exact", []string{"job.failed"}, "job.failed", true},
+		{"exact mismatch", []string{"job.failed"}, "job.done", false},
+		{"entity", []string{"guest.*"}, "guest.deleted", true},
+		{"entity mismatch", []string{"guest.*"}, "hypervisor.down", false},
+		{"action", []string{"*.failed"}, "job.failed", true},
+		{"any", []string{"*"}, "hypervisor.up", true},
+		{"several", []string{"job.failed", "hypervisor.down"}, "hypervisor.down", true},
+	}
+
+	for _, test := range tests {
+		webhook := &lochness.Webhook{Events: test.events}
+		s.Equal(test.expected, webhook.Matches(test.eventType), test.description)
+	}
+}
+
+func (s *WebhookSuite) TestSave() {
+	goodWebhook := s.Context.NewWebhook()
+	goodWebhook.URL = "https://hooks.example.com/lochness"
+
+	clobberWebhook := *goodWebhook
+
+	tests := []struct {
+		description string
+		webhook     *lochness.Webhook
+		expectedErr bool
+	}{
+		{"invalid webhook", &lochness.Webhook{}, true},
+		{"valid webhook", goodWebhook, false},
+		{"existing webhook", goodWebhook, false},
+		{"existing webhook clobber", &clobberWebhook, true},
+	}
+
+	for _, test := range tests {
+		msg := s.Messager(test.description)
+		err := test.webhook.Save()
+		if test.expectedErr {
+			s.Error(err, msg("should fail"))
+		} else {
+			s.NoError(err, msg("should succeed"))
+		}
+	}
+}
+
+func (s *WebhookSuite) TestDestroy() {
+	webhook := s.NewWebhook()
+	newWebhook := s.Context.NewWebhook()
+
+	s.Error(newWebhook.Destroy(), "unsaved webhook destroy should fail")
+	s.NoError(webhook.Destroy(), "existing webhook destroy should succeed")
+	_, err := s.Context.Webhook(webhook.ID)
+	s.Error(err, "destroyed webhook should be gone")
+}
+
+func (s *WebhookSuite) TestForEachWebhook() {
+	webhook := s.NewWebhook()
+	webhook2 := s.NewWebhook()
+	expectedFound := map[string]bool{
+		webhook.ID:  true,
+		webhook2.ID: true,
+	}
+
+	resultFound := make(map[string]bool)
+
+	err := s.Context.ForEachWebhook(func(w *lochness.Webhook) error {
+		resultFound[w.ID] = true
+		return nil
+	})
+	s.NoError(err)
+	s.Equal(expectedFound, resultFound)
+
+	returnErr := errors.New("an error")
+	err = s.Context.ForEachWebhook(func(w *lochness.Webhook) error {
+		return returnErr
+	})
+	s.Error(err)
+	s.Equal(returnErr, err)
+}